@@ -1,88 +1,262 @@
+// Command create-table provisions the facts table named by
+// DYNAMODB_TABLE_NAME.
+//
+// Usage:
+//
+//	create-table [command] [flags]
+//
+// Commands:
+//
+//	create   create the table unless it exists (the default)
+//	migrate  upgrade a table of an older schema to the expected one; with
+//	         status or up, report or apply its versioned migrations
+//	policy   print the IAM policy the server needs for the table
+//	verify   report how the table differs from the expected schema
+//
+// The table is tuned by flags whose defaults come from the server's
+// NOTABLY_TABLE_* environment variables. -schema picks the key schema and
+// indexes, as defined by package schema: server, those of the notably
+// server, or store, those of db.DynamoDBStore, which keys facts by UserID
+// and adds the NamespaceIndex GSI. The two cannot share a table.
 package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+)
+
+const (
+	schemaServer = "server"
+	schemaStore  = "store"
 )
 
+// commands maps each subcommand to its entry point, which returns the
+// process exit code
+var commands = map[string]func(args []string) int{
+	"create":  runCreate,
+	"migrate": runMigrate,
+	"policy":  runPolicy,
+	"verify":  runVerify,
+}
+
 func main() {
-	tableName := os.Getenv("DYNAMODB_TABLE_NAME")
-	if tableName == "" {
-		log.Fatal("DYNAMODB_TABLE_NAME environment variable is required")
+	log.SetFlags(0)
+	args := os.Args[1:]
+	// Without a command the table is created, as before there were others
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		os.Exit(runCreate(args))
+	}
+	run, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "create-table: unknown command %q\n", args[0])
+		usage()
+		os.Exit(2)
 	}
+	os.Exit(run(args[1:]))
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: create-table [command] [flags]
+
+commands:
+  create   create the table unless it exists (the default)
+  migrate  upgrade a table of an older schema to the expected one;
+           migrate status and migrate up report and apply its migrations
+  policy   print the IAM policy the server needs for the table
+  verify   report how the table differs from the expected schema`)
+}
+
+// tableFlags are the flags describing the table, shared by all commands
+type tableFlags struct {
+	schema                         *string
+	billing, kmsKey, tags          *string
+	read, write, maxRead, maxWrite *int64
+	target                         *float64
+	pitr                           *bool
+}
+
+// addTableFlags defines the table flags on fs
+func addTableFlags(fs *flag.FlagSet) *tableFlags {
+	return &tableFlags{
+		schema:   fs.String("schema", schemaServer, "key schema and indexes: server or store"),
+		billing:  fs.String("billing", envOr("NOTABLY_TABLE_BILLING_MODE", dynamo.BillingOnDemand), "billing mode: on-demand or provisioned"),
+		read:     fs.Int64("read", envInt("NOTABLY_TABLE_READ_CAPACITY"), "provisioned read capacity units (default 5)"),
+		write:    fs.Int64("write", envInt("NOTABLY_TABLE_WRITE_CAPACITY"), "provisioned write capacity units (default 5)"),
+		maxRead:  fs.Int64("max-read", envInt("NOTABLY_TABLE_MAX_READ_CAPACITY"), "autoscale read capacity up to this many units"),
+		maxWrite: fs.Int64("max-write", envInt("NOTABLY_TABLE_MAX_WRITE_CAPACITY"), "autoscale write capacity up to this many units"),
+		target:   fs.Float64("target", envFloat("NOTABLY_TABLE_TARGET_UTILIZATION"), "autoscaling target utilization in percent (default 70)"),
+		pitr:     fs.Bool("pitr", os.Getenv("NOTABLY_TABLE_PITR") == "true", "enable point in time recovery"),
+		kmsKey:   fs.String("kms-key", os.Getenv("NOTABLY_TABLE_KMS_KEY"), "encrypt with this KMS key instead of an AWS owned key"),
+		tags:     fs.String("tags", os.Getenv("NOTABLY_TABLE_TAGS"), "table tags as key=value,key=value"),
+	}
+}
+
+// options returns the validated table options set by the flags
+func (f *tableFlags) options() (dynamo.TableOptions, error) {
+	if *f.schema != schemaServer && *f.schema != schemaStore {
+		return dynamo.TableOptions{}, fmt.Errorf("-schema must be %q or %q, got %q", schemaServer, schemaStore, *f.schema)
+	}
+	opts := dynamo.TableOptions{
+		BillingMode:         *f.billing,
+		ReadCapacity:        *f.read,
+		WriteCapacity:       *f.write,
+		PointInTimeRecovery: *f.pitr,
+		KMSKeyID:            *f.kmsKey,
+	}
+	if *f.maxRead > 0 || *f.maxWrite > 0 {
+		opts.AutoScaling = &dynamo.AutoScaling{MaxReadCapacity: *f.maxRead, MaxWriteCapacity: *f.maxWrite, TargetUtilization: *f.target}
+	}
+	var err error
+	if opts.Tags, err = dynamo.ParseTags(*f.tags); err != nil {
+		return opts, err
+	}
+	if err := opts.Validate(); err != nil {
+		return opts, fmt.Errorf("invalid table options: %w", err)
+	}
+	return opts, nil
+}
+
+// definition returns the expected definition of the table. Server tables
+// may keep the user layout of tables created before the namespace layout,
+// so an existing table's layout is expected of it.
+func (f *tableFlags) definition(name string, opts dynamo.TableOptions, existing *types.TableDescription) *dynamodb.CreateTableInput {
+	if *f.schema == schemaStore {
+		return db.TableDefinition(name, opts)
+	}
+	layout := dynamo.LayoutNamespace
+	if existing != nil {
+		layout = dynamo.TableLayout(existing)
+	}
+	return dynamo.TableDefinition(name, layout, opts)
+}
 
-	endpoint := os.Getenv("DYNAMODB_ENDPOINT_URL")
+// tableName returns DYNAMODB_TABLE_NAME, exiting when it is unset
+func tableName() string {
+	name := os.Getenv("DYNAMODB_TABLE_NAME")
+	if name == "" {
+		log.Fatal("DYNAMODB_TABLE_NAME environment variable is required")
+	}
+	return name
+}
 
-	// Configure AWS SDK
-	var opts []func(*config.LoadOptions) error
-	if endpoint != "" {
+// loadConfig loads the AWS configuration, sending DynamoDB calls to
+// DYNAMODB_ENDPOINT_URL when it is set
+func loadConfig(ctx context.Context) aws.Config {
+	var loadOpts []func(*config.LoadOptions) error
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT_URL"); endpoint != "" {
 		resolver := aws.EndpointResolverFunc(func(service, region string) (aws.Endpoint, error) {
 			if service == dynamodb.ServiceID {
 				return aws.Endpoint{URL: endpoint, SigningRegion: region}, nil
 			}
 			return aws.Endpoint{}, &aws.EndpointNotFoundError{}
 		})
-		opts = append(opts, config.WithEndpointResolver(resolver))
+		loadOpts = append(loadOpts, config.WithEndpointResolver(resolver))
 	}
-
-	// Load the configuration
-	cfg, err := config.LoadDefaultConfig(context.TODO(), opts...)
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
 	if err != nil {
 		log.Fatalf("unable to load SDK config, %v", err)
 	}
+	return cfg
+}
+
+// describeTable returns the description of an existing table, or nil when
+// it does not exist
+func describeTable(ctx context.Context, api *dynamodb.Client, name string) (*types.TableDescription, error) {
+	out, err := api.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(name)})
+	var notFound *types.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Table, nil
+}
 
-	// Create DynamoDB client
-	client := dynamodb.NewFromConfig(cfg)
-
-	// Check if table exists
-	_, err = client.DescribeTable(context.TODO(), &dynamodb.DescribeTableInput{
-		TableName: aws.String(tableName),
-	})
-
-	if err == nil {
-		fmt.Printf("Table %s already exists\n", tableName)
-		return
-	}
-
-	// Create table
-	_, err = client.CreateTable(context.TODO(), &dynamodb.CreateTableInput{
-		AttributeDefinitions: []types.AttributeDefinition{
-			{
-				AttributeName: aws.String("Namespace"),
-				AttributeType: types.ScalarAttributeTypeS,
-			},
-			{
-				AttributeName: aws.String("Timestamp"),
-				AttributeType: types.ScalarAttributeTypeS,
-			},
-		},
-		KeySchema: []types.KeySchemaElement{
-			{
-				AttributeName: aws.String("Namespace"),
-				KeyType:       types.KeyTypeHash,
-			},
-			{
-				AttributeName: aws.String("Timestamp"),
-				KeyType:       types.KeyTypeRange,
-			},
-		},
-		ProvisionedThroughput: &types.ProvisionedThroughput{
-			ReadCapacityUnits:  aws.Int64(5),
-			WriteCapacityUnits: aws.Int64(5),
-		},
-		TableName: aws.String(tableName),
-	})
+func runCreate(args []string) int {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	tf := addTableFlags(fs)
+	fs.Parse(args)
+	opts, err := tf.options()
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	name := tableName()
 
+	ctx := context.Background()
+	cfg := loadConfig(ctx)
+	existing, err := describeTable(ctx, dynamodb.NewFromConfig(cfg), name)
 	if err != nil {
-		log.Fatalf("Failed to create table: %v", err)
+		log.Printf("Failed to describe table: %v", err)
+		return 1
+	}
+	if existing != nil {
+		fmt.Printf("Table %s already exists\n", name)
+		return 0
 	}
 
-	fmt.Printf("Table %s created successfully\n", tableName)
+	// Create the table the way the server or the store would
+	if *tf.schema == schemaStore {
+		err = db.NewDynamoDBStore(&db.Config{
+			TableName:    name,
+			DynamoClient: dynamodb.NewFromConfig(cfg),
+			Table:        opts,
+			Autoscaler:   dynamo.NewAutoscaler(cfg),
+		}).CreateTable(ctx)
+	} else {
+		err = dynamo.NewClient(cfg, name, "").WithTableOptions(opts).CreateTable(ctx)
+	}
+	if err != nil {
+		log.Printf("Failed to create table: %v", err)
+		return 1
+	}
+
+	fmt.Printf("Table %s created successfully\n", name)
+	return 0
+}
+
+// envOr returns the value of an environment variable, or def when it is unset
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// envInt returns an integer environment variable, or 0 when it is unset or invalid
+func envInt(name string) int64 {
+	v, _ := strconv.ParseInt(os.Getenv(name), 10, 64)
+	return v
+}
+
+// envFloat returns a number environment variable, or 0 when it is unset or invalid
+func envFloat(name string) float64 {
+	v, _ := strconv.ParseFloat(os.Getenv(name), 64)
+	return v
+}
+
+// envList returns the comma separated values of an environment variable
+func envList(name string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/migrate"
+	"github.com/elibdev/notably/schema"
+)
+
+// runMigrate detects the schema of the table and upgrades it to the one
+// named by -schema. Tables in the user or store schema are brought to the
+// store schema in place by applying the pending migrations of pkg/migrate,
+// as migrate up does. Tables in the user or store schema are copied into an
+// -into table in the server's namespace schema, as notably migrate-keys
+// does; servers reading the copy with the source as
+// DYNAMODB_LEGACY_TABLE_NAME see every fact while it runs. With status or
+// up as its first argument it reports or applies the table's migrations
+// whatever its schema.
+func runMigrate(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "status":
+			return runMigrateStatus(args[1:])
+		case "up":
+			return runMigrateUp(args[1:])
+		}
+	}
+
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	tf := addTableFlags(fs)
+	into := fs.String("into", "", "table to copy facts into for -schema server, created if missing")
+	quiet := fs.Bool("q", false, "do not report progress")
+	fs.Parse(args)
+	opts, err := tf.options()
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	name := tableName()
+
+	ctx := context.Background()
+	cfg := loadConfig(ctx)
+	api := dynamodb.NewFromConfig(cfg)
+	existing, err := describeTable(ctx, api, name)
+	if err != nil {
+		log.Printf("Failed to describe table: %v", err)
+		return 1
+	}
+	if existing == nil {
+		log.Printf("Table %s does not exist", name)
+		return 1
+	}
+	current, err := schema.Detect(existing)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	if *tf.schema == schemaStore {
+		if current == schema.Namespace {
+			log.Printf("Table %s is in the server's namespace schema, which cannot become a store table", name)
+			return 1
+		}
+		return migrateUp(ctx, api, name, opts, 0, *quiet)
+	}
+
+	if current == schema.Namespace {
+		fmt.Printf("Table %s is already in the server's namespace schema\n", name)
+		return 0
+	}
+	if *into == "" || *into == name {
+		log.Printf("Table %s is in the %s schema; pass -into to copy it into a new table", name, current)
+		return 2
+	}
+	if err := dynamo.NewClient(cfg, *into, "").WithTableOptions(opts).CreateTable(ctx); err != nil {
+		log.Printf("Failed to create table: %v", err)
+		return 1
+	}
+	progress := func(n int) {
+		if !*quiet {
+			fmt.Fprintf(os.Stderr, "%d facts copied\n", n)
+		}
+	}
+	copied, err := dynamo.MigrateLayout(ctx, cfg, name, *into, progress)
+	if err != nil {
+		log.Printf("Failed to copy table: %v (after %d facts)", err, copied)
+		return 1
+	}
+	fmt.Printf("Copied %d facts from %s to %s; serve %s with DYNAMODB_LEGACY_TABLE_NAME=%s until clients have moved\n", copied, name, *into, *into, name)
+	return 0
+}
+
+// runMigrateStatus prints the migrations applied to the table and those
+// pending. It exits with 1 when migrations are pending and -check is set.
+func runMigrateStatus(args []string) int {
+	fs := flag.NewFlagSet("migrate status", flag.ExitOnError)
+	check := fs.Bool("check", false, "exit with status 1 when migrations are pending")
+	fs.Parse(args)
+
+	ctx := context.Background()
+	m, err := migrate.New(dynamodb.NewFromConfig(loadConfig(ctx)), tableName(), migrate.Migrations(dynamo.TableOptions{}))
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	status, err := m.Status(ctx)
+	if err != nil {
+		log.Printf("Failed to read migration status: %v", err)
+		return 1
+	}
+
+	fmt.Printf("Table %s (%s schema) is at version %d of %d\n", status.Table, status.Schema, status.Version, status.Latest)
+	for _, a := range status.Applied {
+		fmt.Printf("  applied  %3d %-20s %s, %d facts\n", a.Version, a.Name, a.AppliedAt.Format(time.RFC3339), a.Facts)
+	}
+	for _, p := range status.Pending {
+		fmt.Printf("  pending  %3d %s\n", p.Version, p.Name)
+	}
+	if status.Version > status.Latest {
+		fmt.Println("The table was migrated by a newer build")
+	}
+	if *check && len(status.Pending) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runMigrateUp applies the table's pending migrations, up to -to
+func runMigrateUp(args []string) int {
+	fs := flag.NewFlagSet("migrate up", flag.ExitOnError)
+	tf := addTableFlags(fs)
+	to := fs.Int("to", 0, "apply migrations up to this version (default all)")
+	quiet := fs.Bool("q", false, "do not report progress")
+	fs.Parse(args)
+	opts, err := tf.options()
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	ctx := context.Background()
+	return migrateUp(ctx, dynamodb.NewFromConfig(loadConfig(ctx)), tableName(), opts, *to, *quiet)
+}
+
+// migrateUp applies the pending migrations of a table up to version to,
+// all of them when 0, and reports them
+func migrateUp(ctx context.Context, api migrate.API, name string, opts dynamo.TableOptions, to int, quiet bool) int {
+	m, err := migrate.New(api, name, migrate.Migrations(opts))
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	if !quiet {
+		m.Progress = func(mig migrate.Migration, n int) {
+			fmt.Fprintf(os.Stderr, "%d %s: %d facts migrated\n", mig.Version, mig.Name, n)
+		}
+	}
+	applied, err := m.Up(ctx, to)
+	for _, a := range applied {
+		fmt.Printf("Applied %d %s to %s: %d facts\n", a.Version, a.Name, name, a.Facts)
+	}
+	if err != nil {
+		log.Printf("Failed to migrate table: %v", err)
+		return 1
+	}
+	if len(applied) == 0 {
+		fmt.Printf("Table %s is up to date\n", name)
+	}
+	return 0
+}
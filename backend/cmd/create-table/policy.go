@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"github.com/elibdev/notably/dynamo"
+)
+
+// policyDocument is an IAM policy
+type policyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []policyStatement `json:"Statement"`
+}
+
+// policyStatement is one statement of an IAM policy
+type policyStatement struct {
+	Sid       string                         `json:"Sid"`
+	Effect    string                         `json:"Effect"`
+	Action    []string                       `json:"Action"`
+	Resource  []string                       `json:"Resource"`
+	Condition map[string]map[string][]string `json:"Condition,omitempty"`
+}
+
+// policyOptions describe the resources a server uses, read from the
+// server's environment
+type policyOptions struct {
+	Partition, Region, Account string
+
+	Table       string
+	LegacyTable string
+	// Isolated gives every user table its own <Table>.<user>.<table> table
+	Isolated bool
+	// ReplicaRegions hold replicas of the tables the server may fail over to
+	ReplicaRegions []string
+	Options        dynamo.TableOptions
+
+	ArchiveBucket    string
+	AttachmentBucket string
+	SecretsKey       string
+	SES              bool
+	Topics           []string
+}
+
+// policyOptionsFromEnv reads the resources a server uses from the
+// environment it would read them from
+func policyOptionsFromEnv() policyOptions {
+	o := policyOptions{
+		LegacyTable:      os.Getenv("DYNAMODB_LEGACY_TABLE_NAME"),
+		Isolated:         os.Getenv("NOTABLY_STORAGE_MODE") == "isolated",
+		ReplicaRegions:   envList("NOTABLY_REPLICA_REGIONS"),
+		ArchiveBucket:    os.Getenv("NOTABLY_ARCHIVE_BUCKET"),
+		AttachmentBucket: os.Getenv("NOTABLY_ATTACHMENT_BUCKET"),
+		SecretsKey:       os.Getenv("NOTABLY_KMS_KEY_ID"),
+		SES:              os.Getenv("NOTABLY_MAIL_SES") == "true",
+	}
+	if os.Getenv("NOTABLY_PUBLISH_SNS") == "true" {
+		o.Topics = envList("NOTABLY_PUBLISH_TOPICS")
+		if topic := os.Getenv("NOTABLY_PUBLISH_TOPIC"); topic != "" {
+			o.Topics = append([]string{topic}, o.Topics...)
+		}
+	}
+	return o
+}
+
+// serverPolicy returns the least privilege policy of a server using the
+// resources of o: the DynamoDB calls it makes on its tables and their
+// indexes, and on each optional resource it is configured with, the calls
+// that resource needs
+func serverPolicy(o policyOptions) policyDocument {
+	regions := append([]string{o.Region}, o.ReplicaRegions...)
+	tableARNs := func(name string) []string {
+		arns := make([]string, 0, len(regions))
+		for _, region := range regions {
+			arns = append(arns, fmt.Sprintf("arn:%s:dynamodb:%s:%s:table/%s", o.Partition, region, o.Account, name))
+		}
+		return arns
+	}
+	indexARNs := func(name string) []string { return tableARNs(name + "/index/*") }
+
+	tableActions := []string{"dynamodb:BatchWriteItem", "dynamodb:CreateTable", "dynamodb:DeleteItem", "dynamodb:DescribeTable", "dynamodb:PutItem", "dynamodb:Query"}
+	if len(o.Options.Tags) > 0 {
+		tableActions = append(tableActions, "dynamodb:TagResource")
+	}
+	if o.Options.PointInTimeRecovery {
+		tableActions = append(tableActions, "dynamodb:UpdateContinuousBackups")
+	}
+
+	doc := policyDocument{Version: "2012-10-17"}
+	add := func(sid string, actions, resources []string, condition map[string]map[string][]string) {
+		doc.Statement = append(doc.Statement, policyStatement{Sid: sid, Effect: "Allow", Action: actions, Resource: resources, Condition: condition})
+	}
+	add("FactsTable", tableActions, tableARNs(o.Table), nil)
+	add("FactsIndexes", []string{"dynamodb:Query"}, indexARNs(o.Table), nil)
+	if o.Isolated {
+		// The wildcard also matches the indexes of the user tables
+		add("UserTables", append([]string{"dynamodb:DeleteTable"}, tableActions...), tableARNs(o.Table+".*"), nil)
+	}
+	if o.LegacyTable != "" {
+		// Legacy facts are read alongside the table's and purged from both
+		add("LegacyTable", []string{"dynamodb:DeleteItem", "dynamodb:DescribeTable", "dynamodb:Query"},
+			append(tableARNs(o.LegacyTable), indexARNs(o.LegacyTable)...), nil)
+	}
+	if o.Options.KMSKeyID != "" {
+		services := make([]string, 0, len(regions))
+		for _, region := range regions {
+			services = append(services, "dynamodb."+region+".amazonaws.com")
+		}
+		resource, condition := o.kmsKey(o.Options.KMSKeyID)
+		condition["StringEquals"] = map[string][]string{"kms:ViaService": services}
+		add("TableKey", []string{"kms:CreateGrant", "kms:Decrypt", "kms:DescribeKey"}, []string{resource}, condition)
+	}
+	if o.Options.AutoScaling != nil {
+		add("TableAutoScaling", []string{"application-autoscaling:PutScalingPolicy", "application-autoscaling:RegisterScalableTarget"}, []string{"*"}, nil)
+		add("TableAutoScalingRole", []string{"iam:CreateServiceLinkedRole"},
+			[]string{fmt.Sprintf("arn:%s:iam::%s:role/aws-service-role/dynamodb.application-autoscaling.amazonaws.com/AWSServiceRoleForApplicationAutoScaling_DynamoDBTable", o.Partition, o.Account)},
+			map[string]map[string][]string{"StringEquals": {"iam:AWSServiceName": {"dynamodb.application-autoscaling.amazonaws.com"}}})
+	}
+	objectActions := []string{"s3:DeleteObject", "s3:GetObject", "s3:PutObject"}
+	if o.ArchiveBucket != "" {
+		add("Archive", objectActions, []string{fmt.Sprintf("arn:%s:s3:::%s/*", o.Partition, o.ArchiveBucket)}, nil)
+	}
+	if o.AttachmentBucket != "" {
+		add("Attachments", objectActions, []string{fmt.Sprintf("arn:%s:s3:::%s/*", o.Partition, o.AttachmentBucket)}, nil)
+	}
+	if o.SecretsKey != "" {
+		resource, condition := o.kmsKey(o.SecretsKey)
+		if len(condition) == 0 {
+			condition = nil
+		}
+		add("Secrets", []string{"kms:Decrypt", "kms:Encrypt"}, []string{resource}, condition)
+	}
+	if o.SES {
+		add("Mail", []string{"ses:SendEmail"}, []string{"*"}, nil)
+	}
+	if len(o.Topics) > 0 {
+		add("Publish", []string{"sns:Publish"}, o.Topics, nil)
+	}
+	return doc
+}
+
+// kmsKey returns the resource granting use of a KMS key given as a key
+// ARN, ID, alias or alias ARN. Keys cannot be granted by their aliases, so
+// aliases grant every key on the condition that it has the alias.
+func (o policyOptions) kmsKey(id string) (string, map[string]map[string][]string) {
+	condition := make(map[string]map[string][]string)
+	if i := strings.Index(id, "alias/"); i >= 0 && (i == 0 || strings.HasPrefix(id, "arn:")) {
+		condition["ForAnyValue:StringEquals"] = map[string][]string{"kms:ResourceAliases": {id[i:]}}
+		return fmt.Sprintf("arn:%s:kms:%s:%s:key/*", o.Partition, o.Region, o.Account), condition
+	}
+	if strings.HasPrefix(id, "arn:") {
+		return id, condition
+	}
+	return fmt.Sprintf("arn:%s:kms:%s:%s:key/%s", o.Partition, o.Region, o.Account, id), condition
+}
+
+// runPolicy prints the IAM policy the server needs for the table and the
+// other resources its environment configures. The table's region and
+// account are read from its ARN unless given by flags.
+func runPolicy(args []string) int {
+	fs := flag.NewFlagSet("policy", flag.ExitOnError)
+	tf := addTableFlags(fs)
+	region := fs.String("region", "", "region of the table (default: that of the table's ARN)")
+	account := fs.String("account", "", "AWS account ID of the table (default: that of the table's ARN)")
+	partition := fs.String("partition", "aws", "AWS partition of the table")
+	fs.Parse(args)
+	opts, err := tf.options()
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	o := policyOptionsFromEnv()
+	o.Table, o.Options = tableName(), opts
+	o.Partition, o.Region, o.Account = *partition, *region, *account
+	if o.Region == "" || o.Account == "" {
+		ctx := context.Background()
+		existing, err := describeTable(ctx, dynamodb.NewFromConfig(loadConfig(ctx)), o.Table)
+		if err != nil {
+			log.Printf("Failed to describe table: %v", err)
+			return 1
+		}
+		if existing == nil {
+			log.Printf("Table %s does not exist; pass -region and -account", o.Table)
+			return 1
+		}
+		// arn:partition:dynamodb:region:account:table/name
+		parts := strings.SplitN(aws.ToString(existing.TableArn), ":", 6)
+		if len(parts) < 6 {
+			log.Printf("Unexpected table ARN %q", aws.ToString(existing.TableArn))
+			return 1
+		}
+		o.Partition = parts[1]
+		if o.Region == "" {
+			o.Region = parts[3]
+		}
+		if o.Account == "" {
+			o.Account = parts[4]
+		}
+	}
+
+	out, _ := json.MarshalIndent(serverPolicy(o), "", "  ")
+	fmt.Println(string(out))
+	return 0
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerPolicy(t *testing.T) {
+	doc := serverPolicy(policyOptions{
+		Partition: "aws", Region: "us-east-1", Account: "123456789012",
+		Table: "Facts",
+	})
+	require.Len(t, doc.Statement, 2)
+	assert.Equal(t, []string{"arn:aws:dynamodb:us-east-1:123456789012:table/Facts"}, doc.Statement[0].Resource)
+	assert.NotContains(t, doc.Statement[0].Action, "dynamodb:DeleteTable")
+	assert.NotContains(t, doc.Statement[0].Action, "dynamodb:Scan")
+	assert.Equal(t, []string{"dynamodb:Query"}, doc.Statement[1].Action)
+	assert.Equal(t, []string{"arn:aws:dynamodb:us-east-1:123456789012:table/Facts/index/*"}, doc.Statement[1].Resource)
+
+	doc = serverPolicy(policyOptions{
+		Partition: "aws", Region: "us-east-1", Account: "123456789012",
+		Table:          "Facts",
+		LegacyTable:    "OldFacts",
+		Isolated:       true,
+		ReplicaRegions: []string{"eu-west-1"},
+		Options:        dynamo.TableOptions{KMSKeyID: "alias/notably", PointInTimeRecovery: true},
+		SecretsKey:     "1234abcd-12ab-34cd-56ef-1234567890ab",
+		Topics:         []string{"arn:aws:sns:us-east-1:123456789012:rows"},
+	})
+	sids := make(map[string]policyStatement)
+	for _, s := range doc.Statement {
+		sids[s.Sid] = s
+	}
+	assert.Len(t, sids["FactsTable"].Resource, 2, "replicas are granted too")
+	assert.Contains(t, sids["FactsTable"].Action, "dynamodb:UpdateContinuousBackups")
+	assert.Contains(t, sids["UserTables"].Action, "dynamodb:DeleteTable")
+	assert.Equal(t, "arn:aws:dynamodb:us-east-1:123456789012:table/Facts.*", sids["UserTables"].Resource[0])
+	assert.Len(t, sids["LegacyTable"].Resource, 4)
+
+	key := sids["TableKey"]
+	assert.Equal(t, []string{"arn:aws:kms:us-east-1:123456789012:key/*"}, key.Resource)
+	assert.Equal(t, []string{"alias/notably"}, key.Condition["ForAnyValue:StringEquals"]["kms:ResourceAliases"])
+	assert.Equal(t, []string{"dynamodb.us-east-1.amazonaws.com", "dynamodb.eu-west-1.amazonaws.com"}, key.Condition["StringEquals"]["kms:ViaService"])
+
+	assert.Equal(t, []string{"arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"}, sids["Secrets"].Resource)
+	assert.Nil(t, sids["Secrets"].Condition)
+	assert.Equal(t, []string{"sns:Publish"}, sids["Publish"].Action)
+	assert.NotContains(t, sids, "Mail")
+	assert.NotContains(t, sids, "TableAutoScaling")
+}
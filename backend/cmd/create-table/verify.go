@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/elibdev/notably/dynamo"
+)
+
+// indexWait bounds how long -fix waits for each index it adds to backfill
+const indexWait = time.Hour
+
+// runVerify prints how the table differs from the expected schema and
+// exits 1 if it does. With -fix it first adds the indexes the table lacks,
+// one at a time as DynamoDB requires; other drift needs a new table.
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	tf := addTableFlags(fs)
+	fix := fs.Bool("fix", false, "add missing indexes, waiting for each to become active")
+	fs.Parse(args)
+	opts, err := tf.options()
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	name := tableName()
+
+	ctx := context.Background()
+	api := dynamodb.NewFromConfig(loadConfig(ctx))
+	got, err := describeTable(ctx, api, name)
+	if err != nil {
+		log.Printf("Failed to describe table: %v", err)
+		return 1
+	}
+	if got == nil {
+		fmt.Printf("Table %s does not exist\n", name)
+		return 1
+	}
+	want := tf.definition(name, opts, got)
+
+	if *fix {
+		for _, gsi := range dynamo.MissingIndexes(want, got) {
+			index := aws.ToString(gsi.IndexName)
+			fmt.Printf("Adding index %s to %s\n", index, name)
+			if _, err := api.UpdateTable(ctx, dynamo.AddIndexInput(want, gsi)); err != nil {
+				log.Printf("Failed to add index %s: %v", index, err)
+				return 1
+			}
+			if got, err = waitForIndex(ctx, api, name, index); err != nil {
+				log.Printf("Index %s did not become active: %v", index, err)
+				return 1
+			}
+		}
+	}
+
+	drift := dynamo.SchemaDrift(want, got)
+	if len(drift) == 0 {
+		fmt.Printf("Table %s matches the %s schema\n", name, *tf.schema)
+		return 0
+	}
+	fmt.Printf("Table %s differs from the %s schema:\n", name, *tf.schema)
+	for _, d := range drift {
+		fmt.Printf("  %s\n", d)
+	}
+	return 1
+}
+
+// waitForIndex polls the table until the index is active and backfilled,
+// returning the table's description then
+func waitForIndex(ctx context.Context, api *dynamodb.Client, table, index string) (*types.TableDescription, error) {
+	ctx, cancel := context.WithTimeout(ctx, indexWait)
+	defer cancel()
+	for {
+		desc, err := describeTable(ctx, api, table)
+		if err != nil {
+			return nil, err
+		}
+		if desc == nil {
+			return nil, fmt.Errorf("table %s was deleted", table)
+		}
+		for _, gsi := range desc.GlobalSecondaryIndexes {
+			if aws.ToString(gsi.IndexName) == index && gsi.IndexStatus == types.IndexStatusActive && !aws.ToBool(gsi.Backfilling) {
+				return desc, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -77,6 +78,7 @@ func setupStore(ctx context.Context, tableName, userID string) (db.Store, error)
 			TableName:    tableName,
 			UserID:       userID,
 			DynamoClient: dynamodb.NewFromConfig(cfg),
+			CursorSecret: db.CursorSecretFromEnv(),
 		}), nil
 	}
 
@@ -102,7 +104,7 @@ func exampleUserProfileWorkflow(ctx context.Context, store db.Store) {
 			Namespace: "user-profile",
 			FieldName: "displayName",
 			DataType:  db.DataTypeString,
-			Value:     "John Doe",
+			Value:     db.StringValue("John Doe"),
 			UserID:    "example-user",
 		},
 		{
@@ -111,7 +113,7 @@ func exampleUserProfileWorkflow(ctx context.Context, store db.Store) {
 			Namespace: "user-profile",
 			FieldName: "email",
 			DataType:  db.DataTypeString,
-			Value:     "johndoe@example.com",
+			Value:     db.StringValue("johndoe@example.com"),
 			UserID:    "example-user",
 		},
 		{
@@ -120,7 +122,7 @@ func exampleUserProfileWorkflow(ctx context.Context, store db.Store) {
 			Namespace: "user-profile",
 			FieldName: "age",
 			DataType:  db.DataTypeNumber,
-			Value:     "30",
+			Value:     json.RawMessage(`30`),
 			UserID:    "example-user",
 		},
 		{
@@ -129,7 +131,7 @@ func exampleUserProfileWorkflow(ctx context.Context, store db.Store) {
 			Namespace: "user-settings",
 			FieldName: "theme",
 			DataType:  db.DataTypeString,
-			Value:     "dark",
+			Value:     db.StringValue("dark"),
 			UserID:    "example-user",
 		},
 	}
@@ -158,7 +160,7 @@ func exampleUserProfileWorkflow(ctx context.Context, store db.Store) {
 
 	fmt.Printf("Found %d profile facts:\n", len(profileResult.Facts))
 	for i, fact := range profileResult.Facts {
-		fmt.Printf("  %d. %s = %s (%s)\n", i+1, fact.FieldName, fact.Value, fact.DataType)
+		fmt.Printf("  %d. %s = %s (%s)\n", i+1, fact.FieldName, fact.Text(), fact.DataType)
 	}
 
 	// 3. Update email
@@ -170,7 +172,7 @@ func exampleUserProfileWorkflow(ctx context.Context, store db.Store) {
 		Namespace: "user-profile",
 		FieldName: "email",
 		DataType:  db.DataTypeString,
-		Value:     "john.doe.updated@example.com",
+		Value:     db.StringValue("john.doe.updated@example.com"),
 		UserID:    "example-user",
 	}
 
@@ -185,7 +187,7 @@ func exampleUserProfileWorkflow(ctx context.Context, store db.Store) {
 		log.Fatalf("Failed to get email fact: %v", err)
 	}
 
-	fmt.Printf("Latest email: %s\n", emailFact.Value)
+	fmt.Printf("Latest email: %s\n", emailFact.Text())
 
 	// 5. Query email history
 	fmt.Println("\nQuerying email history...")
@@ -203,7 +205,7 @@ func exampleUserProfileWorkflow(ctx context.Context, store db.Store) {
 
 	fmt.Printf("Email change history (newest first):\n")
 	for i, fact := range emailHistory.Facts {
-		fmt.Printf("  %d. %s at %s\n", i+1, fact.Value, fact.Timestamp.Format(time.RFC3339))
+		fmt.Printf("  %d. %s at %s\n", i+1, fact.Text(), fact.Timestamp.Format(time.RFC3339))
 	}
 
 	// 6. Take a snapshot at current time
@@ -218,7 +220,7 @@ func exampleUserProfileWorkflow(ctx context.Context, store db.Store) {
 
 	fmt.Printf("Profile snapshot at %s:\n", snapshotTime.Format(time.RFC3339))
 	for _, fact := range snapshot {
-		fmt.Printf("  %s = %s\n", fact.FieldName, fact.Value)
+		fmt.Printf("  %s = %s\n", fact.FieldName, fact.Text())
 	}
 
 	// 7. Delete a fact
@@ -238,7 +240,11 @@ func exampleUserProfileWorkflow(ctx context.Context, store db.Store) {
 
 	fmt.Printf("Profile snapshot after deletion at %s:\n", deletionTime.Format(time.RFC3339))
 	for _, fact := range snapshotAfterDelete {
-		fmt.Printf("  %s = %s\n", fact.FieldName, fact.Value)
+		if fact.IsDeleted {
+			fmt.Printf("  %s (deleted)\n", fact.FieldName)
+			continue
+		}
+		fmt.Printf("  %s = %s\n", fact.FieldName, fact.Text())
 	}
 
 	fmt.Println("User profile workflow completed successfully!")
@@ -261,7 +267,7 @@ func exampleInventoryWorkflow(ctx context.Context, store db.Store) {
 			Namespace: "inventory",
 			FieldName: "product-1001",
 			DataType:  db.DataTypeJSON,
-			Value:     `{"name":"Widget A","count":100,"price":9.99}`,
+			Value:     json.RawMessage(`{"name":"Widget A","count":100,"price":9.99}`),
 			UserID:    "example-user",
 		},
 		{
@@ -270,7 +276,7 @@ func exampleInventoryWorkflow(ctx context.Context, store db.Store) {
 			Namespace: "inventory",
 			FieldName: "product-1002",
 			DataType:  db.DataTypeJSON,
-			Value:     `{"name":"Widget B","count":50,"price":19.99}`,
+			Value:     json.RawMessage(`{"name":"Widget B","count":50,"price":19.99}`),
 			UserID:    "example-user",
 		},
 		{
@@ -279,7 +285,7 @@ func exampleInventoryWorkflow(ctx context.Context, store db.Store) {
 			Namespace: "inventory",
 			FieldName: "product-1003",
 			DataType:  db.DataTypeJSON,
-			Value:     `{"name":"Widget C","count":25,"price":29.99}`,
+			Value:     json.RawMessage(`{"name":"Widget C","count":25,"price":29.99}`),
 			UserID:    "example-user",
 		},
 	}
@@ -301,7 +307,7 @@ func exampleInventoryWorkflow(ctx context.Context, store db.Store) {
 		Namespace: "inventory",
 		FieldName: "product-1001",
 		DataType:  db.DataTypeJSON,
-		Value:     `{"name":"Widget A","count":95,"price":9.99}`, // 5 sold
+		Value:     json.RawMessage(`{"name":"Widget A","count":95,"price":9.99}`), // 5 sold
 		UserID:    "example-user",
 	}
 
@@ -317,7 +323,7 @@ func exampleInventoryWorkflow(ctx context.Context, store db.Store) {
 		Namespace: "inventory",
 		FieldName: "product-1001",
 		DataType:  db.DataTypeJSON,
-		Value:     `{"name":"Widget A","count":80,"price":9.99}`, // 15 more sold
+		Value:     json.RawMessage(`{"name":"Widget A","count":80,"price":9.99}`), // 15 more sold
 		UserID:    "example-user",
 	}
 
@@ -333,7 +339,7 @@ func exampleInventoryWorkflow(ctx context.Context, store db.Store) {
 		Namespace: "inventory",
 		FieldName: "product-1001",
 		DataType:  db.DataTypeJSON,
-		Value:     `{"name":"Widget A","count":80,"price":7.99}`, // Price reduced
+		Value:     json.RawMessage(`{"name":"Widget A","count":80,"price":7.99}`), // Price reduced
 		UserID:    "example-user",
 	}
 
@@ -357,7 +363,7 @@ func exampleInventoryWorkflow(ctx context.Context, store db.Store) {
 
 	fmt.Printf("Product-1001 history (oldest first):\n")
 	for i, fact := range productHistory.Facts {
-		fmt.Printf("  %d. %s at %s\n", i+1, fact.Value, fact.Timestamp.Format(time.RFC3339))
+		fmt.Printf("  %d. %s at %s\n", i+1, fact.Text(), fact.Timestamp.Format(time.RFC3339))
 	}
 
 	// 4. Get inventory snapshots at different times
@@ -396,6 +402,6 @@ func exampleInventoryWorkflow(ctx context.Context, store db.Store) {
 // printInventorySnapshot formats and prints inventory data
 func printInventorySnapshot(snapshot map[string]db.Fact) {
 	for _, fact := range snapshot {
-		fmt.Printf("  %s: %s\n", fact.FieldName, fact.Value)
+		fmt.Printf("  %s: %s\n", fact.FieldName, fact.Text())
 	}
 }
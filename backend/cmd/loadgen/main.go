@@ -0,0 +1,136 @@
+// Command loadgen drives a configurable workload against a notably server,
+// or directly against a db.Store, and reports the throughput and latency
+// percentiles of each kind of operation. It is for checking how changes to
+// the storage and API layers perform before and after.
+//
+// Usage:
+//
+//	loadgen [flags]
+//
+// The workload writes rows of a number of tables, and reads them back with a
+// mix of single-row reads and whole-table lists:
+//
+//	loadgen -url http://localhost:8080 -api-key $KEY -writes 200 -reads 4 -duration 1m
+//	loadgen -target memory -writes 0 -tables 1 -rows 10000 -list 0.01
+//	loadgen -target dynamodb -table notably-loadgen -row-size 4096
+//
+// Targets:
+//
+//	http      the API of a running server at -url, as the user of -api-key
+//	memory    a db.MemoryStore in this process
+//	dynamodb  a db.DynamoDBStore on -table, from the AWS environment; it
+//	          uses DYNAMODB_ENDPOINT_URL when set
+//
+// The rows of every table are written once before the run unless -preload
+// is false. The report is written to stdout, as a table or with -json as
+// JSON. loadgen exits 1 when any operation failed and 2 when the run could
+// not start.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/elibdev/notably/db"
+)
+
+// stdout is replaced by tests
+var stdout io.Writer = os.Stdout
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	targetName := fs.String("target", "http", "what to load: http, memory or dynamodb")
+	url := fs.String("url", envOr("NOTABLY_URL", "http://localhost:8080"), "server URL for -target http ($NOTABLY_URL)")
+	apiKey := fs.String("api-key", os.Getenv("NOTABLY_API_KEY"), "API key for -target http ($NOTABLY_API_KEY)")
+	tableName := fs.String("table", "notably-loadgen", "DynamoDB table for -target dynamodb, created when missing")
+	userID := fs.String("user", "loadgen", "user whose facts -target memory and dynamodb write")
+
+	var w workload
+	fs.DurationVar(&w.Duration, "duration", 30*time.Second, "how long to run, after the preload")
+	fs.Float64Var(&w.Writes, "writes", 100, "rows written per second; 0 runs as fast as the workers can")
+	fs.Float64Var(&w.Reads, "reads", 1, "reads per write")
+	fs.Float64Var(&w.List, "list", 0, "fraction of reads that list a whole table instead of reading one row")
+	fs.IntVar(&w.Concurrency, "concurrency", 8, "operations in flight at once")
+	fs.IntVar(&w.Tables, "tables", 4, "number of tables")
+	fs.IntVar(&w.Rows, "rows", 1000, "rows per table; writes update them at random")
+	fs.IntVar(&w.RowSize, "row-size", 256, "bytes of text in each row")
+	fs.StringVar(&w.TablePrefix, "table-prefix", "loadgen", "prefix of the table names")
+	fs.BoolVar(&w.Preload, "preload", true, "write every row once before the run")
+	fs.Int64Var(&w.Seed, "seed", 1, "seed of the random choice of operations and rows")
+	asJSON := fs.Bool("json", false, "write the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := w.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "loadgen: %v\n", err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var t target
+	switch *targetName {
+	case "http":
+		if *apiKey == "" {
+			fmt.Fprintln(os.Stderr, "loadgen: -api-key is required for -target http")
+			return 2
+		}
+		t = newHTTPTarget(*url, *apiKey, w.Concurrency)
+	case "memory":
+		t = newStoreTarget(db.NewMemoryStore(), *userID)
+	case "dynamodb":
+		store, err := db.NewDynamoDBStoreFromEnv(ctx, *tableName, *userID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "loadgen: %v\n", err)
+			return 2
+		}
+		t = newStoreTarget(store, *userID)
+	default:
+		fmt.Fprintf(os.Stderr, "loadgen: unknown target %q: use http, memory or dynamodb\n", *targetName)
+		return 2
+	}
+
+	rep, err := w.run(ctx, t)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loadgen: %v\n", err)
+		return 2
+	}
+	rep.Target = *targetName
+	if *targetName == "http" {
+		rep.Target = *url
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			fmt.Fprintf(os.Stderr, "loadgen: %v\n", err)
+			return 2
+		}
+	} else {
+		rep.print(stdout)
+	}
+	if rep.Total.Errors > 0 {
+		return 1
+	}
+	return 0
+}
+
+// envOr returns the value of an environment variable, or def when it is unset
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
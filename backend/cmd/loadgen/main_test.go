@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/testutil/servertest"
+)
+
+// runJSON runs loadgen with -json and decodes its report
+func runJSON(t *testing.T, args ...string) (int, report) {
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+	code := run(append(args, "-json"))
+	var rep report
+	if out.Len() > 0 {
+		require.NoError(t, json.Unmarshal(out.Bytes(), &rep))
+	}
+	return code, rep
+}
+
+func TestRunAgainstMemoryStore(t *testing.T) {
+	code, rep := runJSON(t, "-target", "memory", "-duration", "200ms", "-writes", "0",
+		"-tables", "2", "-rows", "20", "-reads", "3", "-list", "0.2")
+	require.Equal(t, 0, code)
+
+	assert.Equal(t, "memory", rep.Target)
+	kinds := map[string]opStats{}
+	for _, s := range rep.Operations {
+		kinds[s.Kind] = s
+	}
+	for _, kind := range []string{opWrite, opGet, opList} {
+		assert.Positive(t, kinds[kind].Count, kind)
+	}
+	assert.Equal(t, kinds[opWrite].Count+kinds[opGet].Count+kinds[opList].Count, rep.Total.Count)
+	assert.Zero(t, rep.Total.Errors)
+	assert.LessOrEqual(t, rep.Total.P50, rep.Total.P99)
+	assert.LessOrEqual(t, rep.Total.P99, rep.Total.Max)
+}
+
+func TestRunAgainstServer(t *testing.T) {
+	srv := servertest.NewTestServer(t)
+	user := srv.RegisterUser("loadgen")
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	code, rep := runJSON(t, "-url", ts.URL, "-api-key", user.APIKey, "-duration", "300ms",
+		"-writes", "50", "-tables", "2", "-rows", "5", "-concurrency", "2")
+	require.Equal(t, 0, code, "errors: %v", rep.ErrorSamples)
+	assert.Equal(t, ts.URL, rep.Target)
+	assert.Positive(t, rep.Total.Count)
+	// 100 operations a second for 300ms, and fewer when the workers lag
+	assert.LessOrEqual(t, rep.Total.Count, 35)
+
+	// The preload created every row of both tables
+	user.Get("/v1/tables/loadgen_1/rows/row-000004").AssertStatus(http.StatusOK)
+}
+
+func TestRunReportsFailures(t *testing.T) {
+	srv := servertest.NewTestServer(t)
+	user := srv.RegisterUser("loadgen")
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	// Without a preload the first write of each row creates it, and reads of
+	// rows not yet written fail
+	code, rep := runJSON(t, "-url", ts.URL, "-api-key", user.APIKey, "-duration", "200ms",
+		"-writes", "0", "-reads", "4", "-tables", "1", "-rows", "1000", "-preload=false")
+	assert.Equal(t, 1, code)
+	assert.Positive(t, rep.Total.Errors)
+	assert.Contains(t, rep.ErrorSamples[0], "get: status 404")
+}
+
+func TestRunRejectsInvalidWorkloads(t *testing.T) {
+	for _, args := range [][]string{
+		{"-target", "memory", "-duration", "0s"},
+		{"-target", "memory", "-list", "2"},
+		{"-target", "memory", "-rows", "0"},
+		{"-target", "nowhere"},
+		{"-target", "http", "-api-key", ""},
+	} {
+		code, _ := runJSON(t, args...)
+		assert.Equal(t, 2, code, "%v", args)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 50*time.Millisecond, percentile(latencies, 50))
+	assert.Equal(t, 99*time.Millisecond, percentile(latencies, 99))
+	assert.Equal(t, 100*time.Millisecond, percentile(latencies, 99.9))
+	assert.Equal(t, time.Millisecond, percentile(latencies, 0))
+	assert.Equal(t, 7*time.Millisecond, percentile([]time.Duration{7 * time.Millisecond}, 90))
+	assert.Zero(t, percentile(nil, 50))
+}
+
+func TestGeneratorMix(t *testing.T) {
+	w := workload{Reads: 3, List: 0.5, Tables: 3, Rows: 10, RowSize: 16, TablePrefix: "t", Seed: 7}
+	g := newGenerator(w)
+	counts := map[string]int{}
+	for i := 0; i < 8000; i++ {
+		o := g.next()
+		counts[o.kind]++
+		assert.Contains(t, w.tableNames(), o.table)
+		if o.kind == opWrite {
+			assert.Len(t, o.values["body"], 16)
+		}
+	}
+	// One write in four, and the reads split between gets and lists
+	assert.InDelta(t, 2000, counts[opWrite], 200)
+	assert.InDelta(t, 3000, counts[opGet], 200)
+	assert.InDelta(t, 3000, counts[opList], 200)
+}
+
+func TestStoreTargetWritesRowsAsFacts(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryStore()
+	target := newStoreTarget(store, "u1")
+	require.NoError(t, target.setup(ctx, []string{"notes"}))
+	require.NoError(t, target.write(ctx, "notes", "r1", map[string]interface{}{"title": "hi"}))
+
+	snap, err := store.GetSnapshotAtTime(ctx, "u1/notes", time.Now().UTC())
+	require.NoError(t, err)
+	fact, ok := snap["u1/notes#r1"]
+	require.True(t, ok, "snapshot %v", snap)
+	assert.Equal(t, db.DataTypeJSON, fact.DataType)
+	assert.JSONEq(t, `{"title": "hi"}`, string(fact.Value))
+	assert.NoError(t, target.get(ctx, "notes", "r1"))
+	assert.NoError(t, target.list(ctx, "notes"))
+}
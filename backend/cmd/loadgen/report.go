@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// maxErrorSamples bounds the distinct error messages kept for the report
+const maxErrorSamples = 5
+
+// recorder collects the latency of every operation of a run
+type recorder struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+	samples   []string
+}
+
+func newRecorder() *recorder {
+	return &recorder{latencies: map[string][]time.Duration{}, errors: map[string]int{}}
+}
+
+func (r *recorder) record(kind string, d time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies[kind] = append(r.latencies[kind], d)
+	if err == nil {
+		return
+	}
+	r.errors[kind]++
+	msg := fmt.Sprintf("%s: %v", kind, err)
+	for _, s := range r.samples {
+		if s == msg {
+			return
+		}
+	}
+	if len(r.samples) < maxErrorSamples {
+		r.samples = append(r.samples, msg)
+	}
+}
+
+// report is the outcome of a run. Durations are nanoseconds in JSON.
+type report struct {
+	Target      string        `json:"target"`
+	Elapsed     time.Duration `json:"elapsed"`
+	Concurrency int           `json:"concurrency"`
+	Tables      int           `json:"tables"`
+	Rows        int           `json:"rows"`
+	RowSize     int           `json:"rowSize"`
+	// TargetRate is the operations per second asked for, 0 for unlimited
+	TargetRate float64 `json:"targetRate"`
+	// Operations has the statistics of each kind of operation that ran
+	Operations []opStats `json:"operations"`
+	Total      opStats   `json:"total"`
+	// ErrorSamples are some of the errors, once each
+	ErrorSamples []string `json:"errorSamples,omitempty"`
+}
+
+// opStats are the statistics of one kind of operation. Latencies include
+// failed operations.
+type opStats struct {
+	Kind       string        `json:"kind"`
+	Count      int           `json:"count"`
+	Errors     int           `json:"errors"`
+	Throughput float64       `json:"throughput"`
+	Mean       time.Duration `json:"mean"`
+	P50        time.Duration `json:"p50"`
+	P90        time.Duration `json:"p90"`
+	P99        time.Duration `json:"p99"`
+	P999       time.Duration `json:"p999"`
+	Max        time.Duration `json:"max"`
+}
+
+func (r *recorder) report(w workload, elapsed time.Duration) *report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep := &report{
+		Elapsed:      elapsed,
+		Concurrency:  w.Concurrency,
+		Tables:       w.Tables,
+		Rows:         w.Rows,
+		RowSize:      w.RowSize,
+		TargetRate:   w.Writes * (1 + w.Reads),
+		ErrorSamples: r.samples,
+	}
+	var all []time.Duration
+	total := 0
+	for _, kind := range []string{opWrite, opGet, opList} {
+		latencies := r.latencies[kind]
+		if len(latencies) == 0 {
+			continue
+		}
+		rep.Operations = append(rep.Operations, stats(kind, latencies, r.errors[kind], elapsed))
+		all = append(all, latencies...)
+		total += r.errors[kind]
+	}
+	rep.Total = stats("total", all, total, elapsed)
+	return rep
+}
+
+// stats sorts latencies and summarizes them
+func stats(kind string, latencies []time.Duration, errors int, elapsed time.Duration) opStats {
+	s := opStats{Kind: kind, Count: len(latencies), Errors: errors}
+	if len(latencies) == 0 {
+		return s
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var sum time.Duration
+	for _, d := range latencies {
+		sum += d
+	}
+	s.Throughput = float64(len(latencies)) / elapsed.Seconds()
+	s.Mean = sum / time.Duration(len(latencies))
+	s.P50 = percentile(latencies, 50)
+	s.P90 = percentile(latencies, 90)
+	s.P99 = percentile(latencies, 99)
+	s.P999 = percentile(latencies, 99.9)
+	s.Max = latencies[len(latencies)-1]
+	return s
+}
+
+// percentile returns the nearest-rank percentile p of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[min(rank, len(sorted)-1)]
+}
+
+func (rep *report) print(w io.Writer) {
+	rate := "unlimited"
+	if rep.TargetRate > 0 {
+		rate = fmt.Sprintf("%.0f ops/s", rep.TargetRate)
+	}
+	fmt.Fprintf(w, "%s for %s: %d workers, %d tables of %d rows of %d bytes, target rate %s\n\n",
+		rep.Target, rep.Elapsed.Round(time.Millisecond), rep.Concurrency, rep.Tables, rep.Rows, rep.RowSize, rate)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\tcount\terrors\tops/s\tmean\tp50\tp90\tp99\tp99.9\tmax\t")
+	for _, s := range append(rep.Operations, rep.Total) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t%s\t%s\t\n", s.Kind, s.Count, s.Errors, s.Throughput,
+			round(s.Mean), round(s.P50), round(s.P90), round(s.P99), round(s.P999), round(s.Max))
+	}
+	tw.Flush()
+	if len(rep.ErrorSamples) > 0 {
+		fmt.Fprintln(w, "\nerrors:")
+		for _, msg := range rep.ErrorSamples {
+			fmt.Fprintf(w, "  %s\n", msg)
+		}
+	}
+}
+
+// round shortens a latency to microseconds, or milliseconds from a second
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(time.Microsecond)
+	default:
+		return d
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/pkg/ulid"
+)
+
+// A target runs the operations of a workload. Its methods are called from
+// many goroutines at once.
+type target interface {
+	// setup prepares the tables before any rows are written
+	setup(ctx context.Context, tables []string) error
+	// write stores a new version of a row
+	write(ctx context.Context, table, row string, values map[string]interface{}) error
+	// get reads the current version of a row
+	get(ctx context.Context, table, row string) error
+	// list reads the current versions of every row of a table
+	list(ctx context.Context, table string) error
+}
+
+// rowColumns are the columns of the tables the http target creates
+var rowColumns = []db.ColumnDefinition{
+	{Name: "title", DataType: "string"},
+	{Name: "n", DataType: "number"},
+	{Name: "body", DataType: "string"},
+}
+
+// httpTarget runs the workload through the API of a server
+type httpTarget struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func newHTTPTarget(baseURL, apiKey string, concurrency int) *httpTarget {
+	// Every worker keeps its connection instead of opening a new one per
+	// request once the default two idle connections are taken
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = concurrency
+	return &httpTarget{
+		url:    strings.TrimSuffix(baseURL, "/"),
+		apiKey: apiKey,
+		client: &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}
+}
+
+func (t *httpTarget) setup(ctx context.Context, tables []string) error {
+	for _, table := range tables {
+		body := map[string]interface{}{"name": table, "columns": rowColumns}
+		if err := t.do(ctx, http.MethodPost, "/v1/tables", body, http.StatusCreated); err != nil {
+			return fmt.Errorf("creating table %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// write creates the row when it does not exist yet and updates it otherwise
+func (t *httpTarget) write(ctx context.Context, table, row string, values map[string]interface{}) error {
+	path := "/v1/tables/" + url.PathEscape(table) + "/rows/" + url.PathEscape(row)
+	err := t.do(ctx, http.MethodPut, path, map[string]interface{}{"values": values}, http.StatusOK)
+	var status *statusError
+	if errors.As(err, &status) && status.code == http.StatusNotFound {
+		body := map[string]interface{}{"id": row, "values": values}
+		err = t.do(ctx, http.MethodPost, "/v1/tables/"+url.PathEscape(table)+"/rows", body, http.StatusCreated)
+	}
+	return err
+}
+
+func (t *httpTarget) get(ctx context.Context, table, row string) error {
+	return t.do(ctx, http.MethodGet, "/v1/tables/"+url.PathEscape(table)+"/rows/"+url.PathEscape(row), nil, http.StatusOK)
+}
+
+func (t *httpTarget) list(ctx context.Context, table string) error {
+	return t.do(ctx, http.MethodGet, "/v1/tables/"+url.PathEscape(table)+"/rows", nil, http.StatusOK)
+}
+
+// statusError is a response with another status than the expected one
+type statusError struct {
+	code int
+	body string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.code, e.body)
+}
+
+// do sends a request and reads the whole response, so reads are timed until
+// their last byte
+func (t *httpTarget) do(ctx context.Context, method, path string, body interface{}, want int) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, t.url+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != want {
+		return &statusError{code: resp.StatusCode, body: strings.TrimSpace(string(data))}
+	}
+	return nil
+}
+
+// storeTarget runs the workload against a store, writing rows as the server
+// does: a row is a JSON fact in its table's namespace, named by its ID
+type storeTarget struct {
+	store  db.Store
+	userID string
+}
+
+func newStoreTarget(store db.Store, userID string) *storeTarget {
+	return &storeTarget{store: store, userID: userID}
+}
+
+func (t *storeTarget) namespace(table string) string {
+	return t.userID + "/" + table
+}
+
+func (t *storeTarget) setup(ctx context.Context, tables []string) error {
+	return t.store.CreateTable(ctx)
+}
+
+func (t *storeTarget) write(ctx context.Context, table, row string, values map[string]interface{}) error {
+	value, err := db.EncodeValue(values)
+	if err != nil {
+		return err
+	}
+	return t.store.PutFact(ctx, &db.Fact{
+		ID:        ulid.Make().String(),
+		Timestamp: time.Now().UTC(),
+		Namespace: t.namespace(table),
+		FieldName: row,
+		DataType:  db.DataTypeJSON,
+		Value:     value,
+		UserID:    t.userID,
+	})
+}
+
+func (t *storeTarget) get(ctx context.Context, table, row string) error {
+	limit := int32(1)
+	_, err := t.store.QueryByField(ctx, t.namespace(table), row, db.QueryOptions{Limit: &limit})
+	return err
+}
+
+func (t *storeTarget) list(ctx context.Context, table string) error {
+	_, err := t.store.GetSnapshotAtTime(ctx, t.namespace(table), time.Now().UTC())
+	return err
+}
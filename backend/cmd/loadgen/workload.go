@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// workload describes the operations of a run and how fast to send them
+type workload struct {
+	Duration time.Duration
+	// Writes is the rate of writes per second; 0 sends every operation as
+	// soon as a worker is free
+	Writes float64
+	// Reads is the number of reads per write
+	Reads float64
+	// List is the fraction of reads that list a table
+	List        float64
+	Concurrency int
+	Tables      int
+	Rows        int
+	// RowSize is the length of the text in the body column of each row
+	RowSize     int
+	TablePrefix string
+	Preload     bool
+	Seed        int64
+}
+
+func (w workload) validate() error {
+	switch {
+	case w.Duration <= 0:
+		return errors.New("-duration must be positive")
+	case w.Writes < 0 || w.Reads < 0:
+		return errors.New("-writes and -reads cannot be negative")
+	case w.List < 0 || w.List > 1:
+		return errors.New("-list must be between 0 and 1")
+	case w.Concurrency < 1 || w.Tables < 1 || w.Rows < 1:
+		return errors.New("-concurrency, -tables and -rows must be at least 1")
+	case w.RowSize < 0:
+		return errors.New("-row-size cannot be negative")
+	}
+	return nil
+}
+
+// The kinds of operations, as they are named in the report
+const (
+	opWrite = "write"
+	opGet   = "get"
+	opList  = "list"
+)
+
+// op is one operation of the workload, chosen before it is sent
+type op struct {
+	kind   string
+	table  string
+	row    string
+	values map[string]interface{}
+}
+
+func (w workload) tableNames() []string {
+	names := make([]string, w.Tables)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s_%d", w.TablePrefix, i)
+	}
+	return names
+}
+
+func rowID(i int) string {
+	return fmt.Sprintf("row-%06d", i)
+}
+
+// generator chooses the operations of a run. It is used from one goroutine.
+type generator struct {
+	w      workload
+	rng    *rand.Rand
+	tables []string
+	body   []byte
+	n      int
+}
+
+func newGenerator(w workload) *generator {
+	g := &generator{w: w, rng: rand.New(rand.NewSource(w.Seed)), tables: w.tableNames()}
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	g.body = make([]byte, w.RowSize)
+	for i := range g.body {
+		g.body[i] = letters[g.rng.Intn(len(letters))]
+	}
+	return g
+}
+
+// values returns the values of a new version of a row
+func (g *generator) values() map[string]interface{} {
+	g.n++
+	return map[string]interface{}{
+		"title": fmt.Sprintf("version %d", g.n),
+		"n":     g.n,
+		"body":  string(g.body),
+	}
+}
+
+func (g *generator) next() op {
+	o := op{table: g.tables[g.rng.Intn(len(g.tables))], row: rowID(g.rng.Intn(g.w.Rows))}
+	switch {
+	case g.rng.Float64()*(1+g.w.Reads) < 1:
+		o.kind, o.values = opWrite, g.values()
+	case g.rng.Float64() < g.w.List:
+		o.kind, o.row = opList, ""
+	default:
+		o.kind = opGet
+	}
+	return o
+}
+
+// run sets up the target, preloads its rows and runs the workload for its
+// duration or until ctx is done
+func (w workload) run(ctx context.Context, t target) (*report, error) {
+	tables := w.tableNames()
+	if err := t.setup(ctx, tables); err != nil {
+		return nil, err
+	}
+	g := newGenerator(w)
+	if w.Preload {
+		preload := make(chan op)
+		go func() {
+			defer close(preload)
+			for _, table := range tables {
+				for i := 0; i < w.Rows; i++ {
+					preload <- op{kind: opWrite, table: table, row: rowID(i), values: g.values()}
+				}
+			}
+		}()
+		var failed error
+		var mu sync.Mutex
+		w.work(preload, func(o op) {
+			if err := t.write(ctx, o.table, o.row, o.values); err != nil {
+				mu.Lock()
+				if failed == nil {
+					failed = fmt.Errorf("preloading %s/%s: %w", o.table, o.row, err)
+				}
+				mu.Unlock()
+			}
+		})
+		if failed != nil {
+			return nil, failed
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, w.Duration)
+	defer cancel()
+	ops := make(chan op)
+	go w.dispatch(ctx, g, ops)
+
+	rec := newRecorder()
+	start := time.Now()
+	w.work(ops, func(o op) {
+		began := time.Now()
+		var err error
+		switch o.kind {
+		case opWrite:
+			err = t.write(ctx, o.table, o.row, o.values)
+		case opGet:
+			err = t.get(ctx, o.table, o.row)
+		case opList:
+			err = t.list(ctx, o.table)
+		}
+		// Operations cut off by the end of the run are not counted
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+		rec.record(o.kind, time.Since(began), err)
+	})
+	return rec.report(w, time.Since(start)), nil
+}
+
+// dispatch sends operations at the workload's rate until ctx is done. When
+// the workers fall behind it waits for them without catching up later, so
+// the achieved rate in the report shows the shortfall.
+func (w workload) dispatch(ctx context.Context, g *generator, ops chan<- op) {
+	defer close(ops)
+	var interval time.Duration
+	if w.Writes > 0 {
+		interval = time.Duration(float64(time.Second) / (w.Writes * (1 + w.Reads)))
+	}
+	next := time.Now()
+	for {
+		if interval > 0 {
+			if now := time.Now(); next.Before(now) {
+				next = now
+			}
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+			next = next.Add(interval)
+		}
+		select {
+		case ops <- g.next():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// work runs fn on the operations from ops with the workload's concurrency,
+// until ops is closed
+func (w workload) work(ops <-chan op, fn func(op)) {
+	var wg sync.WaitGroup
+	for i := 0; i < w.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := range ops {
+				fn(o)
+			}
+		}()
+	}
+	wg.Wait()
+}
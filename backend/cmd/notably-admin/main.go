@@ -0,0 +1,281 @@
+// Command notably-admin provisions the accounts of a notably server directly
+// in its user store, without going through the API, for setting up new
+// deployments from provisioning scripts.
+//
+// Usage:
+//
+//	notably-admin <command> [flags]
+//
+// Commands:
+//
+//	bootstrap  create the first admin user and API key
+//	rotate     replace the API keys, and optionally the password, of a user
+//
+// Both read the server's configuration (-config, default $NOTABLY_CONFIG,
+// and the NOTABLY_* environment variables), which must keep users in a
+// file (users.file or NOTABLY_USERS_FILE); the API key secret must match
+// the server's for the keys to work. Results are written to stdout as JSON,
+// and raw keys and passwords are shown only there, once.
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/server"
+)
+
+// commands maps each subcommand to its entry point, which returns the
+// process exit code
+var commands = map[string]func(args []string) int{
+	"bootstrap": runBootstrap,
+	"rotate":    runRotate,
+}
+
+// stdin and stdout are replaced by tests
+var (
+	stdin  io.Reader = os.Stdin
+	stdout io.Writer = os.Stdout
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "notably-admin: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(run(os.Args[2:]))
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: notably-admin <command> [flags]
+
+commands:
+  bootstrap  create the first admin user and API key
+  rotate     replace the API keys, and optionally the password, of a user`)
+}
+
+// credentials is the output of both commands
+type credentials struct {
+	UserID   string `json:"userId"`
+	Username string `json:"username"`
+	// Password is set when it was generated or replaced
+	Password  string    `json:"password,omitempty"`
+	APIKeyID  string    `json:"apiKeyId"`
+	APIKey    string    `json:"apiKey"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// RetiredKeyIDs lists the keys rotate revoked or put in a grace period
+	RetiredKeyIDs []string `json:"retiredKeyIds,omitempty"`
+}
+
+// runBootstrap creates the first user of an empty store with an admin key
+func runBootstrap(args []string) int {
+	fs := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("NOTABLY_CONFIG"), "server config file ($NOTABLY_CONFIG)")
+	username := fs.String("username", "admin", "name of the admin user")
+	email := fs.String("email", "", "email address of the admin user (default <username>@localhost)")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from stdin instead of $NOTABLY_ADMIN_PASSWORD; without either one is generated")
+	keyName := fs.String("key-name", "bootstrap", "name of the API key")
+	expires := fs.Duration("expires", 0, "lifetime of the API key (default the server's key expiration)")
+	ifMissing := fs.Bool("if-missing", false, "succeed without changes when the store already has users")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *email == "" {
+		*email = *username + "@localhost"
+	}
+
+	ctx := context.Background()
+	authenticator, store, err := openStore(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notably-admin bootstrap: %v\n", err)
+		return 2
+	}
+	users, err := authenticator.GetAllUsers(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notably-admin bootstrap: %v\n", err)
+		return 1
+	}
+	if len(users) > 0 {
+		if *ifMissing {
+			fmt.Fprintln(os.Stderr, "notably-admin bootstrap: the store already has users; nothing to do")
+			return 0
+		}
+		fmt.Fprintln(os.Stderr, "notably-admin bootstrap: the store already has users; use rotate to replace their credentials")
+		return 1
+	}
+	password, generated, err := readPassword(*passwordStdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notably-admin bootstrap: %v\n", err)
+		return 2
+	}
+
+	user, err := authenticator.RegisterUser(ctx, *username, *email, password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notably-admin bootstrap: %v\n", err)
+		return 1
+	}
+	// Nobody can follow a verification email sent to an operator's account
+	user.EmailVerified = true
+	if err := store.UpdateUser(ctx, user); err != nil {
+		fmt.Fprintf(os.Stderr, "notably-admin bootstrap: %v\n", err)
+		return 1
+	}
+	key, raw, err := authenticator.GenerateScopedAPIKey(ctx, user.ID, *keyName, *expires, []string{auth.ScopeAdmin})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notably-admin bootstrap: %v\n", err)
+		return 1
+	}
+
+	out := credentials{UserID: user.ID, Username: user.Username, APIKeyID: key.ID, APIKey: raw, ExpiresAt: key.ExpiresAt}
+	if generated {
+		out.Password = password
+	}
+	return printCredentials(out)
+}
+
+// runRotate issues a new admin key for a user and retires the others
+func runRotate(args []string) int {
+	fs := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("NOTABLY_CONFIG"), "server config file ($NOTABLY_CONFIG)")
+	username := fs.String("username", "admin", "name of the user")
+	keyName := fs.String("key-name", "bootstrap", "name of the new API key")
+	expires := fs.Duration("expires", 0, "lifetime of the new API key (default the server's key expiration)")
+	grace := fs.Duration("grace", 0, "how long the user's other keys keep working; 0 revokes them at once")
+	newPassword := fs.Bool("password", false, "also replace the password and unlock the account")
+	passwordStdin := fs.Bool("password-stdin", false, "with -password, read it from stdin instead of $NOTABLY_ADMIN_PASSWORD; without either one is generated")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *grace < 0 {
+		fmt.Fprintln(os.Stderr, "notably-admin rotate: -grace cannot be negative")
+		return 2
+	}
+
+	ctx := context.Background()
+	authenticator, store, err := openStore(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notably-admin rotate: %v\n", err)
+		return 2
+	}
+	user, err := store.GetUserByUsername(ctx, *username)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notably-admin rotate: user %q: %v\n", *username, err)
+		return 1
+	}
+
+	out := credentials{UserID: user.ID, Username: user.Username}
+	if *newPassword {
+		password, generated, err := readPassword(*passwordStdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "notably-admin rotate: %v\n", err)
+			return 2
+		}
+		if _, err := authenticator.SetPassword(ctx, user.ID, password); err != nil {
+			fmt.Fprintf(os.Stderr, "notably-admin rotate: %v\n", err)
+			return 1
+		}
+		if err := authenticator.Unlock(ctx, user.ID); err != nil {
+			fmt.Fprintf(os.Stderr, "notably-admin rotate: %v\n", err)
+			return 1
+		}
+		if generated {
+			out.Password = password
+		}
+	}
+
+	old, err := store.ListAPIKeys(ctx, user.ID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notably-admin rotate: %v\n", err)
+		return 1
+	}
+	key, raw, err := authenticator.GenerateScopedAPIKey(ctx, user.ID, *keyName, *expires, []string{auth.ScopeAdmin})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notably-admin rotate: %v\n", err)
+		return 1
+	}
+	out.APIKeyID, out.APIKey, out.ExpiresAt = key.ID, raw, key.ExpiresAt
+
+	now := time.Now().UTC()
+	for _, k := range old {
+		if state := k.State(now); state == auth.KeyRevoked || state == auth.KeyExpired {
+			continue
+		}
+		if *grace == 0 {
+			k.Revoked = true
+		} else {
+			k.RotatedTo, k.RotatedAt = key.ID, now
+			if until := now.Add(*grace); until.Before(k.ExpiresAt) {
+				k.ExpiresAt = until
+			}
+		}
+		if err := store.UpdateAPIKey(ctx, k); err != nil {
+			fmt.Fprintf(os.Stderr, "notably-admin rotate: retiring key %s: %v\n", k.ID, err)
+			return 1
+		}
+		out.RetiredKeyIDs = append(out.RetiredKeyIDs, k.ID)
+	}
+	return printCredentials(out)
+}
+
+// openStore opens the user store of the server configuration at path
+func openStore(path string) (*auth.Authenticator, auth.UserStore, error) {
+	config, err := server.LoadConfig(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if config.UsersFile == "" {
+		return nil, nil, errors.New("users.file (NOTABLY_USERS_FILE) is not set; the server keeps users in memory, where they cannot be provisioned")
+	}
+	return server.NewAuthenticator(config)
+}
+
+// readPassword returns the first line of stdin, $NOTABLY_ADMIN_PASSWORD or
+// a generated password, and whether it was generated
+func readPassword(fromStdin bool) (string, bool, error) {
+	if fromStdin {
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", false, fmt.Errorf("reading password: %w", err)
+		}
+		if line = strings.TrimRight(line, "\r\n"); line == "" {
+			return "", false, errors.New("no password on stdin")
+		}
+		return line, false, nil
+	}
+	if password := os.Getenv("NOTABLY_ADMIN_PASSWORD"); password != "" {
+		return password, false, nil
+	}
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", false, fmt.Errorf("generating password: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), true, nil
+}
+
+// printCredentials writes the result of a command to stdout
+func printCredentials(out credentials) int {
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		fmt.Fprintf(os.Stderr, "notably-admin: %v\n", err)
+		return 1
+	}
+	return 0
+}
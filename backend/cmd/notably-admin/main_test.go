@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/elibdev/notably/pkg/server"
+)
+
+func TestBootstrapAndRotate(t *testing.T) {
+	dir := t.TempDir()
+	usersFile := filepath.Join(dir, "users.json")
+	configPath := filepath.Join(dir, "notably.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(`
+store:
+  driver: memory
+users:
+  file: %s
+apiKeys:
+  secret: test-secret
+login:
+  bcryptCost: 4
+`, usersFile)), 0o600))
+	t.Setenv("NOTABLY_ADMIN_PASSWORD", "")
+
+	run := func(input string, command func([]string) int, args ...string) (int, credentials) {
+		var out bytes.Buffer
+		stdin, stdout = strings.NewReader(input), &out
+		defer func() { stdin, stdout = os.Stdin, os.Stdout }()
+		code := command(append([]string{"-config", configPath}, args...))
+		var creds credentials
+		if out.Len() > 0 {
+			require.NoError(t, json.Unmarshal(out.Bytes(), &creds))
+		}
+		return code, creds
+	}
+
+	code, admin := run("", runBootstrap)
+	require.Equal(t, 0, code)
+	assert.Equal(t, "admin", admin.Username)
+	assert.NotEmpty(t, admin.Password, "a password is generated when none is given")
+	assert.NotEmpty(t, admin.APIKey)
+
+	// Bootstrapping only happens once
+	code, _ = run("", runBootstrap)
+	assert.Equal(t, 1, code)
+	code, creds := run("", runBootstrap, "-if-missing")
+	assert.Equal(t, 0, code)
+	assert.Empty(t, creds.APIKey)
+
+	// The server sees the accounts of the file
+	config, err := server.LoadConfig(configPath)
+	require.NoError(t, err)
+	config.Logger = logging.Discard()
+	srv, err := server.NewServer(config)
+	require.NoError(t, err)
+	do := func(method, path, apiKey, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/v1/auth/keys", admin.APIKey, ""))
+	login := fmt.Sprintf(`{"username": "admin", "password": %q}`, admin.Password)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/v1/auth/login", "", login))
+
+	// Rotating while the server runs replaces its keys and password
+	code, rotated := run("new password\n", runRotate, "-password", "-password-stdin")
+	require.Equal(t, 0, code)
+	assert.Empty(t, rotated.Password, "passwords read from stdin are not printed")
+	assert.Len(t, rotated.RetiredKeyIDs, 2, "the bootstrap key and the login's key")
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/v1/auth/keys", admin.APIKey, ""))
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/v1/auth/keys", rotated.APIKey, ""))
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/v1/auth/login", "", login))
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/v1/auth/login", "", `{"username": "admin", "password": "new password"}`))
+
+	// With a grace period the old key keeps working
+	code, next := run("", runRotate, "-grace", "1h")
+	require.Equal(t, 0, code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/v1/auth/keys", rotated.APIKey, ""))
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/v1/auth/keys", next.APIKey, ""))
+
+	// Accounts survive restarts of the server
+	srv, err = server.NewServer(config)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/v1/auth/keys", next.APIKey, ""))
+
+	code, _ = run("", runRotate, "-username", "nobody")
+	assert.Equal(t, 1, code)
+	info, err := os.Stat(usersFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm(), "the file holds password hashes")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/elibdev/notably/pkg/expect"
+)
+
+// runAssert checks a deployment against an expectations file. It exits 0
+// when every expectation holds, 1 when any fails and 2 when the check could
+// not run.
+func runAssert(args []string) int {
+	fs := flag.NewFlagSet("assert", flag.ContinueOnError)
+	file := fs.String("file", "", "expectations file (YAML)")
+	url := fs.String("url", envOr("NOTABLY_URL", "http://localhost:8080"), "server URL ($NOTABLY_URL)")
+	apiKey := fs.String("api-key", os.Getenv("NOTABLY_API_KEY"), "API key ($NOTABLY_API_KEY)")
+	timeout := fs.Duration("timeout", 30*time.Second, "overall time limit")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *file == "" || *apiKey == "" {
+		fmt.Fprintln(os.Stderr, "notably assert: --file and --api-key are required")
+		return 2
+	}
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notably assert: %v\n", err)
+		return 2
+	}
+	spec, err := expect.Parse(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notably assert: %s: %v\n", *file, err)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	failures, err := expect.Check(ctx, spec, expect.NewClient(*url, *apiKey, nil))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notably assert: %v\n", err)
+		return 2
+	}
+
+	for _, f := range failures {
+		fmt.Printf("FAIL %s\n", f)
+	}
+	if len(failures) > 0 {
+		fmt.Printf("%d expectations in %s failed\n", len(failures), *file)
+		return 1
+	}
+	fmt.Printf("ok: %d tables match %s\n", len(spec.Tables), *file)
+	return 0
+}
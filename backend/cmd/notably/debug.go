@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/elibdev/notably/pkg/replay"
+	"github.com/elibdev/notably/pkg/server"
+)
+
+// runDebug dispatches the debugging subcommands
+func runDebug(args []string) int {
+	if len(args) == 0 || args[0] != "replay" {
+		fmt.Fprintln(os.Stderr, "usage: notably debug replay [flags] bundle.json")
+		return 2
+	}
+	return runReplay(args[1:])
+}
+
+// runReplay re-executes a recorded request against a local in-memory store
+// and reports how the new response differs from the recorded one
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("debug replay", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: notably debug replay [flags] bundle.json")
+		fs.PrintDefaults()
+	}
+	ignore := fs.String("ignore", "timestamp,createdAt,updatedAt,expiresAt,lastUsed", "comma-separated JSON keys left out of the comparison")
+	show := fs.Bool("show", false, "print the replayed response body")
+	verbose := fs.Bool("v", false, "log the replayed request")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	bundle, err := replay.ReadFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notably debug replay: %v\n", err)
+		return 2
+	}
+
+	// The environment supplies the master key, so sealed values recorded
+	// from secrets tables and encrypted columns can be opened again
+	config := server.DefaultConfig()
+	config.Logger = logging.Discard()
+	if *verbose {
+		config.Logger = logging.New(os.Stderr, config.LogFormat, "debug")
+	}
+	resp, err := server.Replay(context.Background(), bundle, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notably debug replay: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("%s %s (recorded %s, %d store calls)\n", bundle.Request.Method, bundle.Request.URL, bundle.RecordedAt.Format("2006-01-02 15:04:05Z07:00"), len(bundle.Calls))
+	if *show {
+		fmt.Printf("%d %s\n", resp.Status, replay.Payload(resp.Body, resp.Text))
+	}
+	var keys []string
+	for _, k := range strings.Split(*ignore, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	diffs := replay.Diff(bundle.Response, *resp, keys)
+	if len(diffs) == 0 {
+		fmt.Printf("replayed response matches (%d)\n", resp.Status)
+		return 0
+	}
+	for _, d := range diffs {
+		fmt.Println("DIFF " + d)
+	}
+	return 1
+}
@@ -0,0 +1,59 @@
+// Command notably is the command-line client for notably servers.
+//
+// Usage:
+//
+//	notably <command> [flags]
+//
+// Commands:
+//
+//	assert        check live table state against an expectations file
+//	debug         replay recorded requests to reproduce server bugs
+//	migrate-keys  copy facts into the user#namespace partitioned key layout
+//	mock-serve    serve the API from memory with generated rows
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// commands maps each subcommand to its entry point, which returns the
+// process exit code
+var commands = map[string]func(args []string) int{
+	"assert":       runAssert,
+	"debug":        runDebug,
+	"migrate-keys": runMigrateKeys,
+	"mock-serve":   runMockServe,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "notably: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(run(os.Args[2:]))
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: notably <command> [flags]
+
+commands:
+  assert        check live table state against an expectations file
+  debug         replay recorded requests to reproduce server bugs
+  migrate-keys  copy facts into the user#namespace partitioned key layout
+  mock-serve    serve the API from memory with generated rows`)
+}
+
+// envOr returns the value of an environment variable, or def when it is unset
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
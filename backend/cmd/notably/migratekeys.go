@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+
+	"github.com/elibdev/notably/dynamo"
+)
+
+// runMigrateKeys copies a facts table in the user-partitioned key layout
+// into one partitioned by user and namespace. Servers configured with the
+// old table as DYNAMODB_LEGACY_TABLE_NAME keep serving every fact while it
+// runs.
+func runMigrateKeys(args []string) int {
+	fs := flag.NewFlagSet("migrate-keys", flag.ContinueOnError)
+	source := fs.String("source", os.Getenv("DYNAMODB_LEGACY_TABLE_NAME"), "table in the old layout ($DYNAMODB_LEGACY_TABLE_NAME)")
+	target := fs.String("target", os.Getenv("DYNAMODB_TABLE_NAME"), "table in the new layout, created if missing ($DYNAMODB_TABLE_NAME)")
+	endpoint := fs.String("endpoint", os.Getenv("DYNAMODB_ENDPOINT_URL"), "DynamoDB endpoint ($DYNAMODB_ENDPOINT_URL)")
+	quiet := fs.Bool("q", false, "do not report progress")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *source == "" || *target == "" || *source == *target {
+		fmt.Fprintln(os.Stderr, "notably migrate-keys: distinct --source and --target tables are required")
+		return 2
+	}
+
+	ctx := context.Background()
+	var opts []func(*config.LoadOptions) error
+	if *endpoint != "" {
+		resolver := aws.EndpointResolverFunc(func(service, region string) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: *endpoint, SigningRegion: region}, nil
+		})
+		opts = append(opts, config.WithEndpointResolver(resolver))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notably migrate-keys: loading AWS config: %v\n", err)
+		return 2
+	}
+
+	progress := func(copied int) {
+		if !*quiet && copied%1000 == 0 {
+			fmt.Fprintf(os.Stderr, "%d facts copied\n", copied)
+		}
+	}
+	copied, err := dynamo.MigrateLayout(ctx, cfg, *source, *target, progress)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notably migrate-keys: %v (after %d facts)\n", err, copied)
+		return 1
+	}
+	fmt.Printf("copied %d facts from %s to %s\n", copied, *source, *target)
+	return 0
+}
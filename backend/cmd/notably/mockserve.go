@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/elibdev/notably/pkg/fake"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/elibdev/notably/pkg/server"
+)
+
+// runMockServe serves the full API from memory, with tables created from
+// schema files and filled with generated rows. Nothing is persisted.
+func runMockServe(args []string) int {
+	fs := flag.NewFlagSet("mock-serve", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: notably mock-serve [flags] schema.yaml...")
+		fs.PrintDefaults()
+	}
+	addr := fs.String("addr", ":8080", "HTTP listen address")
+	rows := fs.Int("rows", 20, "rows to generate for tables that do not set rows")
+	seed := fs.Int64("seed", 1, "seed for generated data; the same seed gives the same rows")
+	username := fs.String("username", "demo", "username of the account holding the tables")
+	password := fs.String("password", "demo", "password of the account")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "notably mock-serve: at least one schema file is required")
+		return 2
+	}
+
+	var tables []fake.Table
+	for _, file := range fs.Args() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "notably mock-serve: %v\n", err)
+			return 2
+		}
+		schema, err := fake.Parse(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "notably mock-serve: %s: %v\n", file, err)
+			return 2
+		}
+		tables = append(tables, schema.Tables...)
+	}
+
+	config := server.DefaultConfig()
+	config.Addr = *addr
+	config.InMemory = true
+	config.TableName = "notably-mock"
+	config.Logger = logging.New(os.Stderr, config.LogFormat, config.LogLevel)
+	srv, err := server.NewServer(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notably mock-serve: %v\n", err)
+		return 1
+	}
+
+	apiKey, err := seedMockData(srv.Handler(), tables, *username, *password, *rows, fake.NewGenerator(*seed, time.Now()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "notably mock-serve: seeding data: %v\n", err)
+		return 1
+	}
+	fmt.Printf("mock server listening on %s\n", *addr)
+	fmt.Printf("  account: %s / %s\n", *username, *password)
+	fmt.Printf("  api key: %s\n", apiKey)
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Run() }()
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-errc:
+		fmt.Fprintf(os.Stderr, "notably mock-serve: %v\n", err)
+		return 1
+	case <-stop:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Stop(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "notably mock-serve: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// seedMockData registers the account and creates the tables and their rows
+// through the API itself, so the data is validated and indexed exactly as
+// client writes are. It returns the account's API key.
+func seedMockData(h http.Handler, tables []fake.Table, username, password string, defaultRows int, gen *fake.Generator) (string, error) {
+	var account struct {
+		APIKey string `json:"apiKey"`
+	}
+	register := map[string]string{"username": username, "email": username + "@example.com", "password": password}
+	if err := call(h, "", http.MethodPost, "/v1/auth/register", register, &account); err != nil {
+		return "", err
+	}
+
+	// Reference columns pick from the rows of tables seeded before them
+	rowIDs := make(map[string][]interface{}, len(tables))
+	for _, t := range tables {
+		columns := make([]fake.Column, len(t.Columns))
+		for i, c := range t.Columns {
+			if c.DataType == "reference" && len(c.Values) == 0 {
+				if len(rowIDs[c.References]) == 0 {
+					return "", fmt.Errorf("column %s of table %s references %s, which must be listed earlier and have rows", c.Name, t.Name, c.References)
+				}
+				c.Values = rowIDs[c.References]
+			}
+			columns[i] = c
+		}
+		if err := call(h, account.APIKey, http.MethodPost, "/v1/tables", t, nil); err != nil {
+			return "", err
+		}
+		n := t.Rows
+		if n == 0 {
+			n = defaultRows
+		}
+		for i := 1; i <= n; i++ {
+			id := fmt.Sprintf("%s-%d", t.Name, i)
+			row := map[string]interface{}{
+				"id":     id,
+				"values": gen.Row(columns),
+			}
+			if err := call(h, account.APIKey, http.MethodPost, "/v1/tables/"+t.Name+"/rows", row, nil); err != nil {
+				return "", err
+			}
+			rowIDs[t.Name] = append(rowIDs[t.Name], id)
+		}
+	}
+	return account.APIKey, nil
+}
+
+// call sends one request to h and decodes the response into out, if given
+func call(h http.Handler, apiKey, method, path string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code >= 300 {
+		return fmt.Errorf("%s %s: %d %s", method, path, rec.Code, bytes.TrimSpace(rec.Body.Bytes()))
+	}
+	if out != nil {
+		return json.Unmarshal(rec.Body.Bytes(), out)
+	}
+	return nil
+}
@@ -3,38 +3,68 @@ package main
 import (
 	"context"
 	"flag"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/elibdev/notably/pkg/logging"
 	"github.com/elibdev/notably/pkg/server"
 )
 
 func main() {
 	// Parse command-line flags
-	var addr string
-	flag.StringVar(&addr, "addr", ":8080", "HTTP listen address")
+	var addr, configPath, tlsCert, tlsKey, autocertDomains, seedPath string
+	flag.StringVar(&addr, "addr", "", "HTTP listen address (default :8080)")
+	flag.StringVar(&configPath, "config", os.Getenv("NOTABLY_CONFIG"), "path to a YAML config file")
+	flag.StringVar(&tlsCert, "tls-cert", "", "TLS certificate file; serves HTTPS with HTTP/2")
+	flag.StringVar(&tlsKey, "tls-key", "", "TLS private key file")
+	flag.StringVar(&autocertDomains, "autocert-domains", "", "comma-separated domains to obtain Let's Encrypt certificates for")
+	flag.StringVar(&seedPath, "seed", "", "YAML file of sample accounts and tables to load on startup, for development")
 	flag.Parse()
 
-	// Initialize server configuration
-	config := server.DefaultConfig()
+	// Load the configuration file, with environment variables taking precedence
+	config, err := server.LoadConfig(configPath)
+	if err != nil {
+		slog.Error("failed to load config", "error", err)
+		os.Exit(1)
+	}
 
-	// Override address from flag
+	// Override address and TLS settings from flags
 	if addr != "" {
 		config.Addr = addr
 	}
-
-	// Validate required environment variables
-	if config.TableName == "" {
-		log.Fatal("DYNAMODB_TABLE_NAME environment variable is required")
+	if tlsCert != "" || tlsKey != "" {
+		config.TLS.CertFile, config.TLS.KeyFile = tlsCert, tlsKey
+	}
+	if autocertDomains != "" {
+		config.TLS.AutocertDomains = strings.Split(autocertDomains, ",")
+	}
+	if err := config.Validate(); err != nil {
+		slog.Error("invalid flags", "error", err)
+		os.Exit(1)
 	}
 
+	// Set up structured logging for the server and anything using the default logger
+	logger := logging.New(os.Stderr, config.LogFormat, config.LogLevel)
+	slog.SetDefault(logger)
+	config.Logger = logger
+
 	// Create server instance
 	srv, err := server.NewServer(config)
 	if err != nil {
-		log.Fatalf("Failed to create server: %v", err)
+		logger.Error("failed to create server", "error", err)
+		os.Exit(1)
+	}
+
+	// Load development data before serving, so it is all there on the first request
+	if seedPath != "" {
+		if err := srv.LoadSeed(context.Background(), seedPath); err != nil {
+			logger.Error("failed to load seed data", "error", err)
+			os.Exit(1)
+		}
 	}
 
 	// Set up signal handling for graceful shutdown
@@ -43,15 +73,15 @@ func main() {
 
 	// Start server in a goroutine
 	go func() {
-		log.Printf("Starting server on %s", config.Addr)
 		if err := srv.Run(); err != nil {
-			log.Fatalf("Server error: %v", err)
+			logger.Error("server error", "error", err)
+			os.Exit(1)
 		}
 	}()
 
 	// Wait for termination signal
 	<-stopChan
-	log.Println("Shutting down server...")
+	logger.Info("shutting down server")
 
 	// Create shutdown context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -59,8 +89,9 @@ func main() {
 
 	// Graceful shutdown
 	if err := srv.Stop(ctx); err != nil {
-		log.Fatalf("Server shutdown failed: %v", err)
+		logger.Error("server shutdown failed", "error", err)
+		os.Exit(1)
 	}
 
-	log.Println("Server gracefully stopped")
+	logger.Info("server gracefully stopped")
 }
@@ -0,0 +1,36 @@
+package db
+
+import "context"
+
+// Actor is who writes facts: the authenticated user and the API key they
+// used. On shared and admin-edited tables the actor need not be the user
+// owning the facts.
+type Actor struct {
+	UserID   string
+	APIKeyID string
+}
+
+type actorKey struct{}
+
+// WithActor returns a context whose writes through a StoreAdapter are
+// recorded as made by actor
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor WithActor set on ctx, if any
+func ActorFromContext(ctx context.Context) (Actor, bool) {
+	actor, ok := ctx.Value(actorKey{}).(Actor)
+	return actor, ok
+}
+
+// stampActor records the context's actor on a fact that has none yet.
+// Facts copied from elsewhere, such as restored versions, keep theirs.
+func stampActor(ctx context.Context, fact *Fact) {
+	actor, ok := ActorFromContext(ctx)
+	if !ok || fact.ActorUserID != "" {
+		return
+	}
+	fact.ActorUserID = actor.UserID
+	fact.APIKeyID = actor.APIKeyID
+}
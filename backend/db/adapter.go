@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"time"
 
 	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/tracing"
 )
 
 // StoreAdapter adapts our new Store interface to work with the existing API
@@ -27,14 +29,26 @@ func (a *StoreAdapter) CreateTable(ctx context.Context) error {
 	return a.store.CreateTable(ctx)
 }
 
-// PutFact adapts between the dynamo.Fact type and our db.Fact type
+// DeleteTable deletes the underlying table
+func (a *StoreAdapter) DeleteTable(ctx context.Context) error {
+	return a.store.DeleteTable(ctx)
+}
+
+// PutFact adapts between the dynamo.Fact type and our db.Fact type. Facts
+// without an actor are recorded as written by the context's Actor.
 func (a *StoreAdapter) PutFact(ctx context.Context, fact dynamo.Fact) error {
-	dbFact := convertFromLegacyFact(fact)
+	dbFact, err := storeFact(fact)
+	if err != nil {
+		return &StoreError{Operation: "PutFact", Kind: ErrValidation, Err: err}
+	}
+	stampActor(ctx, &dbFact)
 	return a.store.PutFact(ctx, &dbFact)
 }
 
 // QueryByField performs a field query using our new Store interface
-func (a *StoreAdapter) QueryByField(ctx context.Context, namespace, fieldName string, start, end time.Time) ([]dynamo.Fact, error) {
+func (a *StoreAdapter) QueryByField(ctx context.Context, namespace, fieldName string, start, end time.Time) (facts []dynamo.Fact, err error) {
+	ctx, span := tracing.Start(ctx, "adapter.QueryByField", "namespace", namespace, "field", fieldName)
+	defer func() { endAdapterSpan(span, len(facts), err) }()
 	opts := QueryOptions{
 		StartTime:     &start,
 		EndTime:       &end,
@@ -46,11 +60,13 @@ func (a *StoreAdapter) QueryByField(ctx context.Context, namespace, fieldName st
 		return nil, err
 	}
 
-	return convertToLegacyFacts(result.Facts), nil
+	return clientFacts(result.Facts)
 }
 
 // QueryByTimeRange performs a time range query using our new Store interface
-func (a *StoreAdapter) QueryByTimeRange(ctx context.Context, start, end time.Time) ([]dynamo.Fact, error) {
+func (a *StoreAdapter) QueryByTimeRange(ctx context.Context, start, end time.Time) (facts []dynamo.Fact, err error) {
+	ctx, span := tracing.Start(ctx, "adapter.QueryByTimeRange")
+	defer func() { endAdapterSpan(span, len(facts), err) }()
 	opts := QueryOptions{
 		StartTime:     &start,
 		EndTime:       &end,
@@ -62,7 +78,60 @@ func (a *StoreAdapter) QueryByTimeRange(ctx context.Context, start, end time.Tim
 		return nil, err
 	}
 
-	return convertToLegacyFacts(result.Facts), nil
+	return clientFacts(result.Facts)
+}
+
+// QueryByNamespace returns the facts of one namespace in a time range, in
+// ascending order. On DynamoDB it reads only the namespace's partition.
+func (a *StoreAdapter) QueryByNamespace(ctx context.Context, namespace string, start, end time.Time) (facts []dynamo.Fact, err error) {
+	ctx, span := tracing.Start(ctx, "adapter.QueryByNamespace", "namespace", namespace)
+	defer func() { endAdapterSpan(span, len(facts), err) }()
+	opts := QueryOptions{
+		StartTime:     &start,
+		EndTime:       &end,
+		SortAscending: true,
+	}
+
+	result, err := a.store.QueryByNamespace(ctx, namespace, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return clientFacts(result.Facts)
+}
+
+// QueryByActor returns the facts an actor wrote in a time range, in
+// ascending order
+func (a *StoreAdapter) QueryByActor(ctx context.Context, actorUserID string, start, end time.Time) (facts []dynamo.Fact, err error) {
+	ctx, span := tracing.Start(ctx, "adapter.QueryByActor", "actor", actorUserID)
+	defer func() { endAdapterSpan(span, len(facts), err) }()
+	opts := QueryOptions{
+		StartTime:     &start,
+		EndTime:       &end,
+		SortAscending: true,
+	}
+
+	result, err := a.store.QueryByActor(ctx, actorUserID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return clientFacts(result.Facts)
+}
+
+// PutFactsTransactional stores all the facts or none of them, stamped with
+// the context's Actor like PutFact
+func (a *StoreAdapter) PutFactsTransactional(ctx context.Context, facts []dynamo.Fact) error {
+	dbFacts := make([]*Fact, len(facts))
+	for i, fact := range facts {
+		dbFact, err := storeFact(fact)
+		if err != nil {
+			return &StoreError{Operation: "PutFactsTransactional", Kind: ErrValidation, Err: err}
+		}
+		stampActor(ctx, &dbFact)
+		dbFacts[i] = &dbFact
+	}
+	return a.store.PutFactsTransactional(ctx, dbFacts)
 }
 
 // GetFactByID retrieves a single fact by ID (not in the original interface but useful)
@@ -72,7 +141,10 @@ func (a *StoreAdapter) GetFactByID(ctx context.Context, id string) (*dynamo.Fact
 		return nil, err
 	}
 
-	legacyFact := convertToLegacyFact(*fact)
+	legacyFact, err := clientFact(*fact)
+	if err != nil {
+		return nil, err
+	}
 	return &legacyFact, nil
 }
 
@@ -81,8 +153,20 @@ func (a *StoreAdapter) DeleteFactByID(ctx context.Context, id string) error {
 	return a.store.DeleteFact(ctx, id)
 }
 
-// GetSnapshot retrieves a snapshot of all facts at a given time
-func (a *StoreAdapter) GetSnapshot(ctx context.Context, at time.Time) (map[string]map[string]dynamo.Fact, error) {
+// PurgeFact permanently removes a single fact version
+func (a *StoreAdapter) PurgeFact(ctx context.Context, fact dynamo.Fact) error {
+	dbFact, err := storeFact(fact)
+	if err != nil {
+		return &StoreError{Operation: "PurgeFact", Kind: ErrValidation, Err: err}
+	}
+	return a.store.PurgeFact(ctx, &dbFact)
+}
+
+// GetSnapshot retrieves a snapshot of all facts at a given time. Deleted
+// fields keep their tombstones, which report IsTombstone.
+func (a *StoreAdapter) GetSnapshot(ctx context.Context, at time.Time) (snapshot map[string]map[string]dynamo.Fact, err error) {
+	ctx, span := tracing.Start(ctx, "adapter.GetSnapshot")
+	defer func() { endAdapterSpan(span, len(snapshot), err) }()
 	// First get a snapshot with our new interface
 	allNamespaces, err := a.store.GetSnapshotAtTime(ctx, "", at)
 	if err != nil {
@@ -98,100 +182,147 @@ func (a *StoreAdapter) GetSnapshot(ctx context.Context, at time.Time) (map[strin
 			result[ns] = make(map[string]dynamo.Fact)
 		}
 
-		result[ns][fact.FieldName] = convertToLegacyFact(fact)
+		legacyFact, err := clientFact(fact)
+		if err != nil {
+			return nil, err
+		}
+		result[ns][fact.FieldName] = legacyFact
 	}
 
 	return result, nil
 }
 
-// convertFromLegacyFact converts a dynamo.Fact to a db.Fact
-func convertFromLegacyFact(legacy dynamo.Fact) Fact {
-	// Convert value to string safely
-	var valueStr string
-	if legacy.Value != nil {
-		// For JSON data types, marshal to proper JSON
-		if legacy.DataType == "json" {
-			jsonBytes, err := json.Marshal(legacy.Value)
-			if err != nil {
-				// Fall back to string representation if marshaling fails
-				valueStr = fmt.Sprintf("%v", legacy.Value)
-			} else {
-				valueStr = string(jsonBytes)
-			}
-		} else {
-			valueStr = fmt.Sprintf("%v", legacy.Value)
+// TableNamespace returns the namespace holding the rows of a user's table.
+// The table name is escaped so that it stays one segment of the namespace.
+func TableNamespace(userID, table string) string {
+	return userID + "/" + url.PathEscape(table)
+}
+
+// GetTableSnapshot retrieves a snapshot of the rows of one of a user's
+// tables at a given time, keyed by field name. Only the table's namespace
+// is read, not every namespace of the user as GetSnapshot does. Deleted
+// rows keep their tombstones, so that they shadow the rows a fork inherits.
+func (a *StoreAdapter) GetTableSnapshot(ctx context.Context, userID, table string, at time.Time) (snapshot map[string]dynamo.Fact, err error) {
+	ctx, span := tracing.Start(ctx, "adapter.GetTableSnapshot", "namespace", TableNamespace(userID, table))
+	defer func() { endAdapterSpan(span, len(snapshot), err) }()
+	facts, err := a.store.GetSnapshotAtTime(ctx, TableNamespace(userID, table), at)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]dynamo.Fact, len(facts))
+	for _, fact := range facts {
+		legacyFact, err := clientFact(fact)
+		if err != nil {
+			return nil, err
 		}
+		result[fact.FieldName] = legacyFact
+	}
+	return result, nil
+}
+
+// endAdapterSpan ends the span of a StoreAdapter read with the number of
+// facts or namespaces it returned
+func endAdapterSpan(span *tracing.Span, n int, err error) {
+	span.SetAttributes("results", n)
+	span.End(err)
+}
+
+// storeFact returns the Fact of a client fact, encoding its value as JSON.
+// JSON facts whose value is JSON text were written before values were
+// typed, and their text is taken as the encoding.
+func storeFact(f dynamo.Fact) (Fact, error) {
+	value, err := EncodeValue(f.Value)
+	if text, ok := f.Value.(string); ok && DataType(f.DataType) == DataTypeJSON && json.Valid([]byte(text)) {
+		value, err = json.RawMessage(text), nil
+	}
+	if err != nil {
+		return Fact{}, err
 	}
 
-	// Convert columns
 	var columns []ColumnDefinition
-	if len(legacy.Columns) > 0 {
-		columns = make([]ColumnDefinition, len(legacy.Columns))
-		for i, col := range legacy.Columns {
-			columns[i] = ColumnDefinition{
-				Name:     col.Name,
-				DataType: col.DataType,
-			}
+	if len(f.Columns) > 0 {
+		columns = make([]ColumnDefinition, len(f.Columns))
+		for i, col := range f.Columns {
+			columns[i] = ColumnDefinition(col)
 		}
 	}
 
-	return Fact{
-		ID:        legacy.ID,
-		Timestamp: legacy.Timestamp,
-		Namespace: legacy.Namespace,
-		FieldName: legacy.FieldName,
-		DataType:  DataType(legacy.DataType),
-		Value:     valueStr,
-		Columns:   columns,
-		IsDeleted: legacy.DataType == "deleted",
-	}
+	fact := Fact{
+		ID:          f.ID,
+		Timestamp:   f.Timestamp,
+		Namespace:   f.Namespace,
+		FieldName:   f.FieldName,
+		DataType:    DataType(f.DataType),
+		Value:       value,
+		Columns:     columns,
+		Region:      f.Region,
+		ActorUserID: f.ActorUserID,
+		APIKeyID:    f.APIKeyID,
+		ValidTime:   f.ValidTime,
+		ClientDated: f.ClientDated,
+		CreatedAt:   f.CreatedAt,
+	}
+	normalizeTombstone(&fact)
+	return fact, nil
 }
 
-// convertToLegacyFact converts a db.Fact to a dynamo.Fact
-func convertToLegacyFact(fact Fact) dynamo.Fact {
-	// Convert columns
+// clientFact returns the client fact of a Fact, decoding its value
+func clientFact(fact Fact) (dynamo.Fact, error) {
+	value, err := fact.DecodeValue()
+	if err != nil {
+		return dynamo.Fact{}, err
+	}
+
 	var columns []dynamo.ColumnDefinition
 	if len(fact.Columns) > 0 {
 		columns = make([]dynamo.ColumnDefinition, len(fact.Columns))
 		for i, col := range fact.Columns {
-			columns[i] = dynamo.ColumnDefinition{
-				Name:     col.Name,
-				DataType: col.DataType,
-			}
+			columns[i] = dynamo.ColumnDefinition(col)
 		}
 	}
 
-	// Convert value back to proper type for JSON data
-	var value interface{}
-	if string(fact.DataType) == "json" && fact.Value != "" {
-		// Parse JSON string back to interface{}
-		err := json.Unmarshal([]byte(fact.Value), &value)
-		if err != nil {
-			// Fall back to string value if parsing fails
-			value = fact.Value
-		}
-	} else {
-		value = fact.Value
-	}
-
 	return dynamo.Fact{
-		ID:        fact.ID,
-		Timestamp: fact.Timestamp,
-		Namespace: fact.Namespace,
-		FieldName: fact.FieldName,
-		DataType:  string(fact.DataType),
-		Value:     value,
-		Columns:   columns,
-	}
+		ID:          fact.ID,
+		Timestamp:   fact.Timestamp,
+		Namespace:   fact.Namespace,
+		FieldName:   fact.FieldName,
+		DataType:    string(fact.DataType),
+		Value:       value,
+		Columns:     columns,
+		Region:      fact.Region,
+		ActorUserID: fact.ActorUserID,
+		APIKeyID:    fact.APIKeyID,
+		ValidTime:   fact.ValidTime,
+		ClientDated: fact.ClientDated,
+		CreatedAt:   fact.CreatedAt,
+	}, nil
 }
 
-// convertToLegacyFacts converts a slice of db.Fact to a slice of dynamo.Fact
-func convertToLegacyFacts(facts []Fact) []dynamo.Fact {
+// clientFacts returns the client facts of facts
+func clientFacts(facts []Fact) ([]dynamo.Fact, error) {
 	result := make([]dynamo.Fact, len(facts))
 	for i, fact := range facts {
-		result[i] = convertToLegacyFact(fact)
+		f, err := clientFact(fact)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = f
 	}
-	return result
+	return result, nil
+}
+
+// storeFacts returns the Facts of client facts
+func storeFacts(facts []dynamo.Fact) ([]Fact, error) {
+	result := make([]Fact, len(facts))
+	for i, f := range facts {
+		fact, err := storeFact(f)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = fact
+	}
+	return result, nil
 }
 
 // CreateStoreFromClient creates a new Store implementation wrapping the existing dynamo.Client
@@ -204,76 +335,92 @@ func CreateStoreFromClient(client *dynamo.Client) Store {
 	}
 }
 
+// CreateReplicatedStoreFromClient is CreateStoreFromClient for a Global
+// Table. Snapshots report to observer the conflicts between versions of a
+// field written in different regions less than window apart.
+func CreateReplicatedStoreFromClient(client *dynamo.Client, observer ConflictObserver, window time.Duration) Store {
+	return &LegacyClientAdapter{
+		client:         client,
+		conflicts:      observer,
+		conflictWindow: window,
+	}
+}
+
 // LegacyClientAdapter adapts the existing dynamo.Client to our new Store interface
 type LegacyClientAdapter struct {
 	client *dynamo.Client
+
+	// conflicts, if set, is told about the cross-region conflicts
+	// snapshots resolve
+	conflicts      ConflictObserver
+	conflictWindow time.Duration
 }
 
 // Implement the Store interface methods using the legacy client
 func (a *LegacyClientAdapter) CreateTable(ctx context.Context) error {
-	return a.client.CreateTable(ctx)
+	if err := a.client.CreateTable(ctx); err != nil {
+		return &StoreError{
+			Operation: "CreateTable",
+			Err:       err,
+		}
+	}
+	return nil
 }
 
 func (a *LegacyClientAdapter) DeleteTable(ctx context.Context) error {
-	// Not supported in the original client
-	return &StoreError{
-		Operation: "DeleteTable",
-		Err:       ErrNotImplemented,
+	if err := a.client.DeleteTable(ctx); err != nil {
+		return &StoreError{
+			Operation: "DeleteTable",
+			Err:       err,
+		}
 	}
+	return nil
 }
 
 func (a *LegacyClientAdapter) PutFact(ctx context.Context, fact *Fact) error {
 	if fact == nil {
 		return &StoreError{
 			Operation: "PutFact",
+			Kind:      ErrValidation,
 			Err:       fmt.Errorf("fact cannot be nil"),
 		}
 	}
 
-	// Convert to legacy fact type
-	legacyFact := convertToLegacyFact(*fact)
-
-	return a.client.PutFact(ctx, legacyFact)
+	legacyFact, err := clientFact(*fact)
+	if err != nil {
+		return &StoreError{Operation: "PutFact", Kind: ErrValidation, Err: err}
+	}
+	if err := a.client.PutFact(ctx, legacyFact); err != nil {
+		return &StoreError{
+			Operation: "PutFact",
+			Err:       err,
+		}
+	}
+	return nil
 }
 
+// GetFact returns the newest version of the fact with the given ID, which the
+// client finds with a filtered query of the user's facts, newest first
 func (a *LegacyClientAdapter) GetFact(ctx context.Context, id string) (*Fact, error) {
-	// Legacy client doesn't have a direct GetFact method
-	// We'll need to query for it and find the latest version
-
-	// Use a large time range to find the fact
-	endTime := time.Now().UTC()
-	startTime := time.Unix(0, 0) // Beginning of time
-
-	// Query all facts
-	facts, err := a.client.QueryByTimeRange(ctx, startTime, endTime)
+	fact, err := a.client.GetFact(ctx, id)
 	if err != nil {
 		return nil, &StoreError{
 			Operation: "GetFact",
 			Err:       err,
 		}
 	}
-
-	// Find the most recent fact with the given ID
-	var latestFact *dynamo.Fact
-	for i, f := range facts {
-		if f.ID == id {
-			if latestFact == nil || f.Timestamp.After(latestFact.Timestamp) {
-				factCopy := facts[i]
-				latestFact = &factCopy
-			}
-		}
-	}
-
-	if latestFact == nil {
+	if fact == nil {
 		return nil, &StoreError{
 			Operation: "GetFact",
+			Kind:      ErrNotFound,
 			Err:       fmt.Errorf("fact not found"),
 		}
 	}
 
-	// Convert to our Fact type
-	result := convertFromLegacyFact(*latestFact)
-
+	result, err := storeFact(*fact)
+	if err != nil {
+		return nil, &StoreError{Operation: "GetFact", Err: err}
+	}
 	return &result, nil
 }
 
@@ -287,17 +434,65 @@ func (a *LegacyClientAdapter) DeleteFact(ctx context.Context, id string) error {
 		}
 	}
 
-	// Create a deletion marker in DynamoDB
-	legacyFact := dynamo.Fact{
-		ID:        id,
-		Timestamp: time.Now().UTC(),
-		Namespace: fact.Namespace,
-		FieldName: fact.FieldName,
-		DataType:  "deleted",
-		Value:     nil,
+	legacyFact, err := clientFact(fact.Tombstone(id, time.Now().UTC()))
+	if err != nil {
+		return &StoreError{
+			Operation: "DeleteFact",
+			Err:       err,
+		}
+	}
+
+	if err := a.client.PutFact(ctx, legacyFact); err != nil {
+		return &StoreError{
+			Operation: "DeleteFact",
+			Err:       err,
+		}
+	}
+	return nil
+}
+
+func (a *LegacyClientAdapter) PutFactsTransactional(ctx context.Context, facts []*Fact) error {
+	if err := validateTransaction(facts); err != nil {
+		return err
+	}
+	legacyFacts := make([]dynamo.Fact, len(facts))
+	for i, fact := range facts {
+		legacyFact, err := clientFact(*fact)
+		if err != nil {
+			return &StoreError{Operation: "PutFactsTransactional", Kind: ErrValidation, Err: err}
+		}
+		legacyFacts[i] = legacyFact
+	}
+
+	if err := a.client.PutFactsTransactional(ctx, legacyFacts); err != nil {
+		return &StoreError{
+			Operation: "PutFactsTransactional",
+			Err:       transactionError(err),
+		}
+	}
+	return nil
+}
+
+func (a *LegacyClientAdapter) PurgeFact(ctx context.Context, fact *Fact) error {
+	if fact == nil {
+		return &StoreError{
+			Operation: "PurgeFact",
+			Kind:      ErrValidation,
+			Err:       fmt.Errorf("fact cannot be nil"),
+		}
 	}
 
-	return a.client.PutFact(ctx, legacyFact)
+	legacyFact, err := clientFact(*fact)
+	if err != nil {
+		return &StoreError{Operation: "PurgeFact", Kind: ErrValidation, Err: err}
+	}
+	if err := a.client.PurgeFact(ctx, legacyFact); err != nil {
+		return &StoreError{
+			Operation: "PurgeFact",
+			Err:       err,
+		}
+	}
+	return nil
 }
 
 func (a *LegacyClientAdapter) QueryByField(ctx context.Context, namespace, fieldName string, opts QueryOptions) (*QueryResult, error) {
@@ -321,10 +516,9 @@ func (a *LegacyClientAdapter) QueryByField(ctx context.Context, namespace, field
 		}
 	}
 
-	// Convert to our Fact type
-	result := make([]Fact, len(facts))
-	for i, f := range facts {
-		result[i] = convertFromLegacyFact(f)
+	result, err := storeFacts(facts)
+	if err != nil {
+		return nil, &StoreError{Operation: "QueryByField", Err: err}
 	}
 
 	// Sort if needed
@@ -364,10 +558,9 @@ func (a *LegacyClientAdapter) QueryByTimeRange(ctx context.Context, opts QueryOp
 		}
 	}
 
-	// Convert to our Fact type
-	result := make([]Fact, len(facts))
-	for i, f := range facts {
-		result[i] = convertFromLegacyFact(f)
+	result, err := storeFacts(facts)
+	if err != nil {
+		return nil, &StoreError{Operation: "QueryByTimeRange", Err: err}
 	}
 
 	// Sort if needed
@@ -386,12 +579,54 @@ func (a *LegacyClientAdapter) QueryByTimeRange(ctx context.Context, opts QueryOp
 	}, nil
 }
 
+func (a *LegacyClientAdapter) QueryByActor(ctx context.Context, actorUserID string, opts QueryOptions) (*QueryResult, error) {
+	startTime := time.Unix(0, 0)
+	if opts.StartTime != nil {
+		startTime = *opts.StartTime
+	}
+
+	endTime := time.Now().UTC()
+	if opts.EndTime != nil {
+		endTime = *opts.EndTime
+	}
+
+	// The client reads the actor's partition of the ActorIndex
+	facts, err := a.client.QueryByActor(ctx, actorUserID, startTime, endTime)
+	if err != nil {
+		return nil, &StoreError{
+			Operation: "QueryByActor",
+			Err:       err,
+		}
+	}
+
+	result, err := storeFacts(facts)
+	if err != nil {
+		return nil, &StoreError{Operation: "QueryByActor", Err: err}
+	}
+
+	if !opts.SortAscending {
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
+		}
+	}
+
+	return &QueryResult{Facts: result}, nil
+}
+
 func (a *LegacyClientAdapter) QueryByNamespace(ctx context.Context, namespace string, opts QueryOptions) (*QueryResult, error) {
-	// Legacy client doesn't have this method directly
-	// We'll need to get all facts and filter by namespace
+	// Set default start/end times if not provided
+	startTime := time.Unix(0, 0)
+	if opts.StartTime != nil {
+		startTime = *opts.StartTime
+	}
+
+	endTime := time.Now().UTC()
+	if opts.EndTime != nil {
+		endTime = *opts.EndTime
+	}
 
-	// First get all facts in the time range
-	result, err := a.QueryByTimeRange(ctx, opts)
+	// The client reads the namespace's own partition
+	facts, err := a.client.QueryByNamespace(ctx, namespace, startTime, endTime)
 	if err != nil {
 		return nil, &StoreError{
 			Operation: "QueryByNamespace",
@@ -399,17 +634,20 @@ func (a *LegacyClientAdapter) QueryByNamespace(ctx context.Context, namespace st
 		}
 	}
 
-	// Filter by namespace
-	filteredFacts := make([]Fact, 0)
-	for _, fact := range result.Facts {
-		if fact.Namespace == namespace {
-			filteredFacts = append(filteredFacts, fact)
+	result, err := storeFacts(facts)
+	if err != nil {
+		return nil, &StoreError{Operation: "QueryByNamespace", Err: err}
+	}
+
+	if !opts.SortAscending {
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
 		}
 	}
 
 	return &QueryResult{
-		Facts:     filteredFacts,
-		NextToken: nil,
+		Facts:     result,
+		NextToken: nil, // Legacy client doesn't support pagination
 	}, nil
 }
 
@@ -447,14 +685,18 @@ func (a *LegacyClientAdapter) GetSnapshotAtTime(ctx context.Context, namespace s
 	for _, fact := range result.Facts {
 		key := fmt.Sprintf("%s#%s", fact.Namespace, fact.FieldName)
 
-		// If we haven't seen this field yet or this is a newer version
-		if existing, exists := snapshot[key]; !exists || fact.Timestamp.After(existing.Timestamp) {
-			if !fact.IsDeleted {
-				snapshot[key] = fact
-			} else if exists {
-				// If it's a deletion marker and we had this field, remove it
-				delete(snapshot, key)
+		existing, exists := snapshot[key]
+		if exists && a.conflicts != nil && conflicting(fact, existing, a.conflictWindow) {
+			conflict := Conflict{Winner: existing, Loser: fact}
+			if fact.Supersedes(existing) {
+				conflict = Conflict{Winner: fact, Loser: existing}
 			}
+			a.conflicts.ObserveConflict(ctx, conflict)
+		}
+
+		// If we haven't seen this field yet or this is a newer version
+		if !exists || fact.Supersedes(existing) {
+			snapshot[key] = fact
 		}
 	}
 
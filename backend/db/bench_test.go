@@ -0,0 +1,232 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// benchSizes are the numbers of facts the snapshot benchmarks read
+var benchSizes = []int{10_000, 100_000}
+
+// benchVersions is how many versions each row of benchFacts has
+const benchVersions = 10
+
+const benchNamespace = "u1/notes"
+
+// benchFacts returns n facts of rows of one namespace, benchVersions
+// versions of each, oldest first, with values like the server's rows
+func benchFacts(n int) []Fact {
+	rows := n / benchVersions
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	facts := make([]Fact, n)
+	for i := range facts {
+		row, version := i%rows, i/rows
+		value, err := EncodeValue(map[string]interface{}{
+			"title": fmt.Sprintf("Row %d, version %d", row, version),
+			"count": float64(i),
+			"done":  version%2 == 0,
+			"tags":  []interface{}{"a", "b"},
+			"notes": "Lorem ipsum dolor sit amet, consectetur adipiscing elit",
+		})
+		if err != nil {
+			panic(err)
+		}
+		facts[i] = Fact{
+			ID:        fmt.Sprintf("f%07d", i),
+			Timestamp: base.Add(time.Duration(i) * time.Millisecond),
+			Namespace: benchNamespace,
+			FieldName: "row-" + strconv.Itoa(row),
+			DataType:  DataTypeJSON,
+			Value:     value,
+			UserID:    "u1",
+		}
+	}
+	return facts
+}
+
+func benchName(n int) string {
+	return fmt.Sprintf("%dk", n/1000)
+}
+
+// pagedAPI is a DynamoDB fake serving every query from one list of items,
+// newest first, in pages of pageSize
+type pagedAPI struct {
+	dynamoDBAPI
+	items    []map[string]types.AttributeValue
+	index    map[string]int
+	pageSize int
+}
+
+func newPagedAPI(store *DynamoDBStore, facts []Fact, pageSize int) *pagedAPI {
+	api := &pagedAPI{index: map[string]int{}, pageSize: pageSize}
+	for i := len(facts) - 1; i >= 0; i-- {
+		item, err := store.factItem(&facts[i])
+		if err != nil {
+			panic(err)
+		}
+		api.index[item[skName].(*types.AttributeValueMemberS).Value] = len(api.items)
+		api.items = append(api.items, item)
+	}
+	return api
+}
+
+func (f *pagedAPI) Query(ctx context.Context, in *dynamodb.QueryInput, _ ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	start := 0
+	if in.ExclusiveStartKey != nil {
+		start = f.index[in.ExclusiveStartKey[skName].(*types.AttributeValueMemberS).Value] + 1
+	}
+	end := min(start+f.pageSize, len(f.items))
+	out := &dynamodb.QueryOutput{Items: f.items[start:end], Count: int32(end - start)}
+	if end < len(f.items) {
+		last := f.items[end-1]
+		out.LastEvaluatedKey = map[string]types.AttributeValue{skName: last[skName]}
+	}
+	return out, nil
+}
+
+func BenchmarkGetSnapshotAtTime(b *testing.B) {
+	ctx := context.Background()
+	for _, n := range benchSizes {
+		facts := benchFacts(n)
+		at := facts[len(facts)-1].Timestamp
+
+		b.Run("MemoryStore/"+benchName(n), func(b *testing.B) {
+			store := NewMemoryStore()
+			for i := range facts {
+				if err := store.PutFact(ctx, &facts[i]); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				snap, err := store.GetSnapshotAtTime(ctx, benchNamespace, at)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(snap) != n/benchVersions {
+					b.Fatalf("snapshot has %d rows, want %d", len(snap), n/benchVersions)
+				}
+			}
+		})
+
+		// DynamoDB returns up to 1MB per page, about a thousand of these
+		b.Run("DynamoDBStore/"+benchName(n), func(b *testing.B) {
+			store := testDynamoDBStore(nil)
+			store.db = newPagedAPI(store, facts, 1000)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				snap, err := store.GetSnapshotAtTime(ctx, benchNamespace, at)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(snap) != n/benchVersions {
+					b.Fatalf("snapshot has %d rows, want %d", len(snap), n/benchVersions)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkLegacyClientAdapterConversions measures converting facts between
+// the Store's and dynamo.Client's types, which LegacyClientAdapter does to
+// every fact it writes or reads and StoreAdapter to every fact it returns
+func BenchmarkLegacyClientAdapterConversions(b *testing.B) {
+	facts := benchFacts(10_000)
+	clients, err := clientFacts(facts)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("toClient/10k", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := clientFacts(facts); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("toStore/10k", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := storeFacts(clients); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkStoreAdapterGetSnapshot measures the snapshot of every namespace
+// of a user, over the memory store
+func BenchmarkStoreAdapterGetSnapshot(b *testing.B) {
+	ctx := context.Background()
+	for _, n := range benchSizes {
+		b.Run(benchName(n), func(b *testing.B) {
+			store := NewMemoryStore()
+			facts := benchFacts(n)
+			for i := range facts {
+				if err := store.PutFact(ctx, &facts[i]); err != nil {
+					b.Fatal(err)
+				}
+			}
+			adapter := NewStoreAdapter(store)
+			at := facts[len(facts)-1].Timestamp
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				snap, err := adapter.GetSnapshot(ctx, at)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(snap[benchNamespace]) != n/benchVersions {
+					b.Fatalf("snapshot has %d rows, want %d", len(snap[benchNamespace]), n/benchVersions)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkStoreAdapterGetTableSnapshot measures the snapshot of one table
+// the server takes to read its rows, over the memory store, with another
+// table of the same size beside it
+func BenchmarkStoreAdapterGetTableSnapshot(b *testing.B) {
+	ctx := context.Background()
+	for _, n := range benchSizes {
+		b.Run(benchName(n), func(b *testing.B) {
+			store := NewMemoryStore()
+			facts := benchFacts(n)
+			for i := range facts {
+				if err := store.PutFact(ctx, &facts[i]); err != nil {
+					b.Fatal(err)
+				}
+				other := facts[i]
+				other.ID = "o" + other.ID
+				other.Namespace = "u1/other"
+				if err := store.PutFact(ctx, &other); err != nil {
+					b.Fatal(err)
+				}
+			}
+			adapter := NewStoreAdapter(store)
+			at := facts[len(facts)-1].Timestamp
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				snap, err := adapter.GetTableSnapshot(ctx, "u1", "notes", at)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(snap) != n/benchVersions {
+					b.Fatalf("snapshot has %d rows, want %d", len(snap), n/benchVersions)
+				}
+			}
+		})
+	}
+}
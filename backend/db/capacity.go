@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"github.com/elibdev/notably/dynamo"
+)
+
+// meteredAPI asks DynamoDB for the capacity consumed by calls whose context
+// carries a dynamo.CapacityMeter and adds it to the meter
+type meteredAPI struct {
+	dynamoDBAPI
+}
+
+func (m meteredAPI) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if mode := dynamo.ConsumedCapacityMode(ctx); mode != "" {
+		params.ReturnConsumedCapacity = mode
+	}
+	out, err := m.dynamoDBAPI.PutItem(ctx, params, optFns...)
+	if err == nil && out.ConsumedCapacity != nil {
+		dynamo.RecordConsumedCapacity(ctx, *out.ConsumedCapacity)
+	}
+	return out, err
+}
+
+func (m meteredAPI) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	if mode := dynamo.ConsumedCapacityMode(ctx); mode != "" {
+		params.ReturnConsumedCapacity = mode
+	}
+	out, err := m.dynamoDBAPI.Query(ctx, params, optFns...)
+	if err == nil && out.ConsumedCapacity != nil {
+		dynamo.RecordConsumedCapacity(ctx, *out.ConsumedCapacity)
+	}
+	return out, err
+}
+
+func (m meteredAPI) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	if mode := dynamo.ConsumedCapacityMode(ctx); mode != "" {
+		params.ReturnConsumedCapacity = mode
+	}
+	out, err := m.dynamoDBAPI.DeleteItem(ctx, params, optFns...)
+	if err == nil && out.ConsumedCapacity != nil {
+		dynamo.RecordConsumedCapacity(ctx, *out.ConsumedCapacity)
+	}
+	return out, err
+}
+
+func (m meteredAPI) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	if mode := dynamo.ConsumedCapacityMode(ctx); mode != "" {
+		params.ReturnConsumedCapacity = mode
+	}
+	out, err := m.dynamoDBAPI.TransactWriteItems(ctx, params, optFns...)
+	if err == nil {
+		dynamo.RecordConsumedCapacity(ctx, out.ConsumedCapacity...)
+	}
+	return out, err
+}
@@ -0,0 +1,215 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+)
+
+// capacityWindow is how long the consumption a learning CapacityLimiter
+// bases its rate on is gathered over
+const capacityWindow = 10 * time.Second
+
+// capacityRecovery is how long a throttled limiter takes to climb from half
+// its target rate back to the target when DynamoDB stops throttling
+const capacityRecovery = 5 * time.Second
+
+// CapacityLimiterOptions configures a CapacityLimiter
+type CapacityLimiterOptions struct {
+	// WriteCapacity is the write capacity units per second writes are paced
+	// to, usually the table's provisioned capacity. Zero leaves writes
+	// unpaced until DynamoDB throttles them, then paces them to the
+	// capacity they were consuming.
+	WriteCapacity float64
+
+	// Burst is how many units may be spent at once after an idle spell;
+	// one second of the current rate when zero
+	Burst float64
+}
+
+// CapacityLimiter paces the writes of every Store it wraps to a table's
+// write capacity. It is a token bucket refilled at the paced rate and
+// drained by the capacity each write consumed, as DynamoDB reports it, or
+// by an estimate of it for backends that report none. A write made while the
+// bucket is empty fails with a *CapacityError instead of reaching DynamoDB.
+//
+// The rate adapts: it is halved each time DynamoDB throttles a write and
+// climbs back over capacityRecovery while writes succeed. Share one limiter
+// between the stores of a table, since its capacity is shared too.
+type CapacityLimiter struct {
+	mu sync.Mutex
+
+	// target is the rate the limiter recovers to and rate the current one,
+	// in units per second; a zero rate leaves writes unpaced
+	target float64
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	// learned is set while the target came from observed consumption
+	// rather than configuration
+	learned bool
+
+	// windowStart and windowUnits measure the recent consumption a
+	// learning limiter falls back to when first throttled
+	windowStart time.Time
+	windowUnits float64
+
+	now func() time.Time
+}
+
+// NewCapacityLimiter returns a limiter pacing writes to opts.WriteCapacity
+func NewCapacityLimiter(opts CapacityLimiterOptions) *CapacityLimiter {
+	l := &CapacityLimiter{
+		target: opts.WriteCapacity,
+		rate:   opts.WriteCapacity,
+		burst:  opts.Burst,
+		now:    time.Now,
+	}
+	l.last = l.now()
+	l.windowStart = l.last
+	l.tokens = l.capacity()
+	return l
+}
+
+// Wrap returns inner with its writes paced by the limiter
+func (l *CapacityLimiter) Wrap(inner Store) Store {
+	return &limitedStore{Store: inner, limiter: l}
+}
+
+// capacity returns how many tokens the bucket holds when full
+func (l *CapacityLimiter) capacity() float64 {
+	if l.burst > 0 {
+		return l.burst
+	}
+	return l.rate
+}
+
+// refill adds the tokens earned since the last call and lets the rate
+// recover toward its target. The caller holds mu.
+func (l *CapacityLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	l.last = now
+	if l.rate == 0 {
+		return
+	}
+	if l.rate < l.target {
+		l.rate = math.Min(l.target, l.rate+l.target/2*elapsed/capacityRecovery.Seconds())
+	} else if l.learned {
+		// A learned rate is only a guess; once writes go through at the
+		// consumption that was throttled, stop pacing them until the next
+		// throttle teaches a new one
+		l.rate, l.target, l.learned = 0, 0, false
+		return
+	}
+	l.tokens = math.Min(l.capacity(), l.tokens+l.rate*elapsed)
+}
+
+// admit takes cost tokens for a write, or says how long to wait for them.
+// A write costing more than a full bucket waits for a full bucket.
+func (l *CapacityLimiter) admit(cost float64) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(l.now())
+	if l.rate == 0 {
+		return 0, true
+	}
+	need := math.Min(cost, l.capacity())
+	if l.tokens < need {
+		wait := (need - l.tokens) / l.rate
+		return time.Duration(math.Ceil(wait * float64(time.Second))), false
+	}
+	l.tokens -= cost
+	return 0, true
+}
+
+// settle records the outcome of an admitted write: the units it consumed
+// beyond the estimate it was admitted with, and whether it was throttled
+func (l *CapacityLimiter) settle(estimate, units float64, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.windowStart) > capacityWindow {
+		l.windowStart, l.windowUnits = now, 0
+	}
+	if units == 0 && err == nil {
+		units = estimate
+	}
+	l.windowUnits += units
+	if l.rate > 0 {
+		l.tokens -= units - estimate
+	}
+	if !errors.Is(err, ErrThrottled) {
+		return
+	}
+
+	if l.rate == 0 {
+		// Pace to the consumption that just hit the table's limit
+		elapsed := math.Max(now.Sub(l.windowStart).Seconds(), 1)
+		l.target = math.Max(l.windowUnits/elapsed, 1)
+		l.rate = l.target
+		l.learned = true
+		l.last = now
+	}
+	l.rate = math.Max(l.rate/2, 1)
+	l.tokens = math.Min(l.tokens, 0)
+}
+
+// run paces one write of cost estimated units
+func (l *CapacityLimiter) run(ctx context.Context, operation string, cost float64, write func(ctx context.Context) error) error {
+	if wait, ok := l.admit(cost); !ok {
+		return &StoreError{
+			Operation: operation,
+			Kind:      ErrOverCapacity,
+			Err:       &CapacityError{RetryAfter: wait},
+		}
+	}
+	ctx, meter := dynamo.WithCapacityMeter(ctx)
+	err := write(ctx)
+	l.settle(cost, meter.Units(), err)
+	return err
+}
+
+// limitedStore is a Store whose writes are paced by a CapacityLimiter.
+// Reads pass straight through.
+type limitedStore struct {
+	Store
+	limiter *CapacityLimiter
+}
+
+// PutFact implements Store.PutFact
+func (s *limitedStore) PutFact(ctx context.Context, fact *Fact) error {
+	return s.limiter.run(ctx, "PutFact", 1, func(ctx context.Context) error {
+		return s.Store.PutFact(ctx, fact)
+	})
+}
+
+// PutFactsTransactional implements Store.PutFactsTransactional. A
+// transactional write costs two units per item.
+func (s *limitedStore) PutFactsTransactional(ctx context.Context, facts []*Fact) error {
+	return s.limiter.run(ctx, "PutFactsTransactional", float64(2*len(facts)), func(ctx context.Context) error {
+		return s.Store.PutFactsTransactional(ctx, facts)
+	})
+}
+
+// DeleteFact implements Store.DeleteFact
+func (s *limitedStore) DeleteFact(ctx context.Context, id string) error {
+	return s.limiter.run(ctx, "DeleteFact", 1, func(ctx context.Context) error {
+		return s.Store.DeleteFact(ctx, id)
+	})
+}
+
+// PurgeFact implements Store.PurgeFact
+func (s *limitedStore) PurgeFact(ctx context.Context, fact *Fact) error {
+	return s.limiter.run(ctx, "PurgeFact", 1, func(ctx context.Context) error {
+		return s.Store.PurgeFact(ctx, fact)
+	})
+}
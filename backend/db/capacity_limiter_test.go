@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// throttlingStore reports units of consumed capacity for each write and
+// fails writes as throttled while throttle is set
+type throttlingStore struct {
+	Store
+	units    float64
+	throttle bool
+	writes   int
+}
+
+func (s *throttlingStore) PutFact(ctx context.Context, fact *Fact) error {
+	s.writes++
+	if s.throttle {
+		return &StoreError{Operation: "PutFact", Kind: ErrThrottled, Err: errors.New("slow down")}
+	}
+	dynamo.RecordConsumedCapacity(ctx, types.ConsumedCapacity{CapacityUnits: aws.Float64(s.units)})
+	return nil
+}
+
+func testLimiter(opts CapacityLimiterOptions) (*CapacityLimiter, *time.Time) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewCapacityLimiter(opts)
+	l.now = func() time.Time { return clock }
+	l.last, l.windowStart = clock, clock
+	return l, &clock
+}
+
+func retryAfter(t *testing.T, err error) time.Duration {
+	t.Helper()
+	require.True(t, errors.Is(err, ErrOverCapacity), err)
+	var capErr *CapacityError
+	require.True(t, errors.As(err, &capErr))
+	return capErr.RetryAfter
+}
+
+func TestCapacityLimiterPacesToConsumedCapacity(t *testing.T) {
+	ctx := context.Background()
+	limiter, clock := testLimiter(CapacityLimiterOptions{WriteCapacity: 10})
+	backend := &throttlingStore{Store: NewMemoryStore(), units: 2}
+	store := limiter.Wrap(backend)
+	fact := &Fact{ID: "f1", Namespace: "orders", FieldName: "r1"}
+
+	// A full bucket holds one second of capacity: five writes of two units
+	for i := 0; i < 5; i++ {
+		require.NoError(t, store.PutFact(ctx, fact))
+	}
+	err := store.PutFact(ctx, fact)
+	assert.Equal(t, 100*time.Millisecond, retryAfter(t, err), "one unit at 10 per second")
+	assert.Equal(t, 5, backend.writes, "refused writes do not reach the backend")
+
+	*clock = clock.Add(200 * time.Millisecond)
+	assert.NoError(t, store.PutFact(ctx, fact))
+
+	// Reads are never paced
+	_, err = store.QueryByNamespace(ctx, "orders", QueryOptions{})
+	assert.NoError(t, err)
+}
+
+func TestCapacityLimiterBacksOffWhenThrottled(t *testing.T) {
+	ctx := context.Background()
+	limiter, clock := testLimiter(CapacityLimiterOptions{})
+	backend := &throttlingStore{Store: NewMemoryStore(), units: 1}
+	store := limiter.Wrap(backend)
+	fact := &Fact{ID: "f1", Namespace: "orders", FieldName: "r1"}
+
+	// Unpaced until DynamoDB throttles: 40 units over two seconds
+	for i := 0; i < 40; i++ {
+		require.NoError(t, store.PutFact(ctx, fact))
+	}
+	*clock = clock.Add(2 * time.Second)
+	backend.throttle = true
+	assert.True(t, errors.Is(store.PutFact(ctx, fact), ErrThrottled))
+	backend.throttle = false
+
+	// The learned 20 units per second is halved, with an empty bucket
+	assert.Equal(t, 10.0, limiter.rate)
+	assert.Equal(t, 100*time.Millisecond, retryAfter(t, store.PutFact(ctx, fact)))
+
+	// Successful writes let the rate recover, then pacing stops
+	*clock = clock.Add(capacityRecovery)
+	require.NoError(t, store.PutFact(ctx, fact))
+	assert.Equal(t, 20.0, limiter.rate)
+	*clock = clock.Add(time.Second)
+	require.NoError(t, store.PutFact(ctx, fact))
+	assert.Zero(t, limiter.rate)
+}
+
+func TestCapacityLimiterChargesTransactionsPerItem(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := testLimiter(CapacityLimiterOptions{WriteCapacity: 4, Burst: 8})
+	store := limiter.Wrap(NewMemoryStore())
+	facts := []*Fact{{ID: "a", Namespace: "orders"}, {ID: "b", Namespace: "orders"}, {ID: "c", Namespace: "orders"}}
+
+	require.NoError(t, store.PutFactsTransactional(ctx, facts))
+	assert.Equal(t, 2.0, limiter.tokens, "two units per item without reported capacity")
+	assert.Equal(t, time.Second, retryAfter(t, store.PutFactsTransactional(ctx, facts)))
+}
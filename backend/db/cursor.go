@@ -0,0 +1,153 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// cursorVersion is the version of the pagination tokens issued by the
+// stores. Tokens of another version are rejected rather than misread.
+const cursorVersion = 1
+
+// cursorPayload is the visible part of a pagination token. It holds the
+// position of the last item read, a "timestamp#id" sort key, and nothing of
+// the table's physical keys, so the key schema can change under a token.
+type cursorPayload struct {
+	Version  int    `json:"v"`
+	Position string `json:"p"`
+}
+
+// CursorSigner issues and checks opaque pagination tokens. A token is its
+// payload and an HMAC over the payload and the query's fingerprint, so it
+// cannot be tampered with or replayed against a different query.
+type CursorSigner struct {
+	key []byte
+	// err is why no secret could be had; a signer with an error fails
+	// every paginated query rather than issue tokens with a weak key
+	err error
+}
+
+// NewCursorSigner returns a signer using secret, or a random secret when it
+// is empty; tokens then only work against the signer that issued them
+func NewCursorSigner(secret []byte) (CursorSigner, error) {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return CursorSigner{}, fmt.Errorf("generate cursor secret: %w", err)
+		}
+	}
+	return CursorSigner{key: secret}, nil
+}
+
+// mustCursorSigner is NewCursorSigner for constructors that cannot return an
+// error; the error is kept in the signer and reported by its queries
+func mustCursorSigner(secret []byte) CursorSigner {
+	c, err := NewCursorSigner(secret)
+	if err != nil {
+		return CursorSigner{err: err}
+	}
+	return c
+}
+
+// CursorSecretFromEnv reads NOTABLY_CURSOR_SECRET, the secret stores in
+// different processes share to accept each other's pagination tokens
+func CursorSecretFromEnv() []byte {
+	return []byte(strings.TrimSpace(os.Getenv("NOTABLY_CURSOR_SECRET")))
+}
+
+// queryFingerprint identifies a query by the options that choose its items.
+// Limit is left out so a client may change the page size between pages.
+func queryFingerprint(operation string, opts QueryOptions, parts ...string) string {
+	bound := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	fields := append([]string{operation, bound(opts.StartTime), bound(opts.EndTime), fmt.Sprint(opts.SortAscending)}, parts...)
+	return strings.Join(fields, "\x00")
+}
+
+func (c CursorSigner) mac(payload, fingerprint string) []byte {
+	h := hmac.New(sha256.New, c.key)
+	h.Write([]byte(payload))
+	h.Write([]byte{0})
+	h.Write([]byte(fingerprint))
+	return h.Sum(nil)
+}
+
+// Encode returns the token resuming the query identified by fingerprint
+// after position
+func (c CursorSigner) Encode(fingerprint, position string) (string, error) {
+	if c.err != nil {
+		return "", c.err
+	}
+	data, err := json.Marshal(cursorPayload{Version: cursorVersion, Position: position})
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(c.mac(payload, fingerprint)), nil
+}
+
+// encode is Encode for the NextToken of a query result
+func (c CursorSigner) encode(fingerprint, position string) (*string, error) {
+	token, err := c.Encode(fingerprint, position)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// Decode checks a token against the query it is used with and returns the
+// position to resume after
+func (c CursorSigner) Decode(fingerprint, token string) (string, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", errors.New("malformed token")
+	}
+	want, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(want, c.mac(payload, fingerprint)) {
+		return "", errors.New("token signature does not match this query")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", errors.New("malformed token")
+	}
+	var p cursorPayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return "", errors.New("malformed token")
+	}
+	if p.Version != cursorVersion {
+		return "", fmt.Errorf("unsupported token version %d", p.Version)
+	}
+	return p.Position, nil
+}
+
+// resumeAfter decodes opts.NextToken for a query, returning "" when there is
+// none. A bad token is reported as a validation error of operation.
+func (c CursorSigner) resumeAfter(operation, fingerprint string, opts QueryOptions) (string, error) {
+	if opts.NextToken == nil {
+		return "", nil
+	}
+	if c.err != nil {
+		return "", &StoreError{Operation: operation, Err: c.err}
+	}
+	position, err := c.Decode(fingerprint, *opts.NextToken)
+	if err != nil {
+		return "", &StoreError{
+			Operation: operation,
+			Kind:      ErrValidation,
+			Err:       fmt.Errorf("invalid next token: %w", err),
+		}
+	}
+	return position, nil
+}
@@ -2,44 +2,71 @@ package db
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/backoff"
+	"github.com/elibdev/notably/schema"
 )
 
 const (
-	defaultGSIName = "FieldIndex"
-	pkName         = "UserID"
-	skName         = "SK"
-	fieldKeyName   = "FieldKey"
-	isDeletedName  = "IsDeleted"
+	defaultGSIName = schema.FieldIndex
+	pkName         = schema.UserID
+	skName         = schema.SortKey
+	fieldKeyName   = schema.FieldKey
+
+	// namespaceGSIName indexes facts by user and namespace, keyed by
+	// namespaceKeyName (userId#namespace) and the sort key
+	namespaceGSIName = schema.NamespaceIndex
+	namespaceKeyName = schema.NamespaceKey
+	isDeletedName    = "IsDeleted"
 )
 
 // DynamoDBStore implements the Store interface for AWS DynamoDB
 type DynamoDBStore struct {
-	db        *dynamodb.Client
+	db        dynamoDBAPI
 	tableName string
 	userID    string
+	logger    *slog.Logger
+	cursors   CursorSigner
+
+	tableOptions dynamo.TableOptions
+	autoscaler   dynamo.Autoscaler
+
+	// namespaceFilter is set by CreateTable when the table predates the
+	// NamespaceIndex, so namespace queries filter the user's partition
+	namespaceFilter bool
 }
 
 // NewDynamoDBStore creates a new store using the provided DynamoDB client
 func NewDynamoDBStore(cfg *Config) *DynamoDBStore {
+	var api dynamoDBAPI = meteredAPI{tracedAPI{cfg.DynamoClient}}
+	if cfg.Observer != nil {
+		api = &observedAPI{api: api, observer: cfg.Observer}
+	}
+	api = withRetry(api, cfg.Retry, cfg.Observer)
 	return &DynamoDBStore{
-		db:        cfg.DynamoClient,
+		db:        api,
 		tableName: cfg.TableName,
 		userID:    cfg.UserID,
+		logger:    loggerOrDefault(cfg.Logger),
+		cursors:   mustCursorSigner(cfg.CursorSecret),
+
+		tableOptions: cfg.Table,
+		autoscaler:   cfg.Autoscaler,
 	}
 }
 
-// NewDynamoDBStoreFromEnv creates a new store with AWS config from environment
+// NewDynamoDBStoreFromEnv creates a new store with AWS config from environment.
+// Pagination tokens are signed with NOTABLY_CURSOR_SECRET when it is set.
 func NewDynamoDBStoreFromEnv(ctx context.Context, tableName, userID string) (*DynamoDBStore, error) {
 	opts := []func(*config.LoadOptions) error{}
 	if ep := getEndpointFromEnv(); ep != "" {
@@ -60,13 +87,28 @@ func NewDynamoDBStoreFromEnv(ctx context.Context, tableName, userID string) (*Dy
 		}
 	}
 
+	cursors, err := NewCursorSigner(CursorSecretFromEnv())
+	if err != nil {
+		return nil, &StoreError{Operation: "NewDynamoDBStoreFromEnv", Err: err}
+	}
+
 	return &DynamoDBStore{
-		db:        dynamodb.NewFromConfig(cfg),
+		db:        withRetry(meteredAPI{tracedAPI{dynamodb.NewFromConfig(cfg)}}, backoff.DefaultPolicy, nil),
 		tableName: tableName,
 		userID:    userID,
+		logger:    slog.Default(),
+		cursors:   cursors,
 	}, nil
 }
 
+// loggerOrDefault returns l, or the process-wide default logger if l is nil
+func loggerOrDefault(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
+}
+
 // getEndpointFromEnv returns the DynamoDB endpoint from environment
 func getEndpointFromEnv() string {
 	return strings.TrimSpace(getEnv("DYNAMODB_ENDPOINT_URL", ""))
@@ -89,34 +131,22 @@ var getEnvFn = func(key string) (string, bool) {
 	return "", false
 }
 
+// TableDefinition returns the CreateTable input of a DynamoDBStore table,
+// with opts applied. Its key schema and indexes are those of schema.Store,
+// which differ from those of the tables dynamo.Client creates.
+func TableDefinition(name string, opts dynamo.TableOptions) *dynamodb.CreateTableInput {
+	input := schema.Definition(name, schema.Store)
+	opts.Apply(input)
+	return input
+}
+
 // CreateTable implements Store.CreateTable
 func (s *DynamoDBStore) CreateTable(ctx context.Context) error {
-	input := &dynamodb.CreateTableInput{
-		TableName: aws.String(s.tableName),
-		AttributeDefinitions: []types.AttributeDefinition{
-			{AttributeName: aws.String(pkName), AttributeType: types.ScalarAttributeTypeS},
-			{AttributeName: aws.String(skName), AttributeType: types.ScalarAttributeTypeS},
-			{AttributeName: aws.String(fieldKeyName), AttributeType: types.ScalarAttributeTypeS},
-		},
-		KeySchema: []types.KeySchemaElement{
-			{AttributeName: aws.String(pkName), KeyType: types.KeyTypeHash},
-			{AttributeName: aws.String(skName), KeyType: types.KeyTypeRange},
-		},
-		BillingMode: types.BillingModePayPerRequest,
-		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
-			{
-				IndexName: aws.String(defaultGSIName),
-				KeySchema: []types.KeySchemaElement{
-					{AttributeName: aws.String(fieldKeyName), KeyType: types.KeyTypeHash},
-					{AttributeName: aws.String(skName), KeyType: types.KeyTypeRange},
-				},
-				Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
-			},
-		},
-	}
+	input := TableDefinition(s.tableName, s.tableOptions)
 
 	_, err := s.db.CreateTable(ctx, input)
-	if err != nil {
+	created := err == nil
+	if !created {
 		var existsErr *types.ResourceInUseException
 		if !errors.As(err, &existsErr) {
 			return &StoreError{
@@ -124,10 +154,41 @@ func (s *DynamoDBStore) CreateTable(ctx context.Context) error {
 				Err:       fmt.Errorf("create table failed: %w", err),
 			}
 		}
+		if err := s.detectNamespaceIndex(ctx); err != nil {
+			return err
+		}
 	}
 
 	waiter := dynamodb.NewTableExistsWaiter(s.db)
-	return waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(s.tableName)}, 5*time.Minute)
+	if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(s.tableName)}, 5*time.Minute); err != nil || !created {
+		return err
+	}
+	if err := s.tableOptions.Configure(ctx, s.db, s.autoscaler, input); err != nil {
+		return &StoreError{Operation: "CreateTable", Err: err}
+	}
+	return nil
+}
+
+// detectNamespaceIndex checks whether an existing table has the
+// NamespaceIndex, falling back to filtered namespace queries if not
+func (s *DynamoDBStore) detectNamespaceIndex(ctx context.Context) error {
+	out, err := s.db.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(s.tableName)})
+	if err != nil {
+		return &StoreError{
+			Operation: "CreateTable",
+			Err:       fmt.Errorf("describe table failed: %w", err),
+		}
+	}
+	s.namespaceFilter = true
+	for _, gsi := range out.Table.GlobalSecondaryIndexes {
+		if aws.ToString(gsi.IndexName) == namespaceGSIName {
+			s.namespaceFilter = false
+		}
+	}
+	if s.namespaceFilter {
+		s.logger.WarnContext(ctx, "table has no namespace index, namespace queries filter the user's facts", "table", s.tableName)
+	}
+	return nil
 }
 
 // DeleteTable implements Store.DeleteTable
@@ -152,61 +213,203 @@ func (s *DynamoDBStore) PutFact(ctx context.Context, fact *Fact) error {
 	if fact == nil {
 		return &StoreError{
 			Operation: "PutFact",
+			Kind:      ErrValidation,
 			Err:       errors.New("fact cannot be nil"),
 		}
 	}
 
-	if fact.ID == "" {
+	if fact.UserID == "" {
+		fact.UserID = s.userID
+	}
+
+	item, err := s.factItem(fact)
+	if err != nil {
 		return &StoreError{
 			Operation: "PutFact",
-			Err:       errors.New("fact ID cannot be empty"),
+			Kind:      ErrValidation,
+			Err:       err,
 		}
 	}
 
-	if fact.UserID == "" {
-		fact.UserID = s.userID
+	_, err = s.db.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	})
+
+	if err != nil {
+		return &StoreError{
+			Operation: "PutFact",
+			Err:       fmt.Errorf("put fact failed: %w", err),
+		}
 	}
 
-	// Create sort key in format timestamp#id
-	sk := fmt.Sprintf("%s#%s", fact.Timestamp.Format(time.RFC3339Nano), fact.ID)
-	// Create field key in format userId#namespace#fieldName
-	fk := fmt.Sprintf("%s#%s#%s", s.userID, fact.Namespace, fact.FieldName)
+	s.logger.DebugContext(ctx, "dynamodb put", "table", s.tableName, "namespace", fact.Namespace, "field", fact.FieldName)
+	return nil
+}
 
-	// Prepare item for DynamoDB
-	item := map[string]types.AttributeValue{
-		pkName:       &types.AttributeValueMemberS{Value: s.userID},
-		skName:       &types.AttributeValueMemberS{Value: sk},
-		"ID":         &types.AttributeValueMemberS{Value: fact.ID},
-		"Namespace":  &types.AttributeValueMemberS{Value: fact.Namespace},
-		"FieldName":  &types.AttributeValueMemberS{Value: fact.FieldName},
-		"DataType":   &types.AttributeValueMemberS{Value: string(fact.DataType)},
-		"Value":      &types.AttributeValueMemberS{Value: fact.Value},
-		fieldKeyName: &types.AttributeValueMemberS{Value: fk},
+// factItem returns the DynamoDB item storing a fact
+func (s *DynamoDBStore) factItem(fact *Fact) (map[string]types.AttributeValue, error) {
+	return factItem(s.userID, fact)
+}
+
+// factItem returns the item storing a user's fact in the store schema. It
+// refuses facts whose ID or size DynamoDB cannot take, and writes deletions
+// as tombstones.
+func factItem(userID string, fact *Fact) (map[string]types.AttributeValue, error) {
+	if err := schema.ValidateID(fact.ID); err != nil {
+		return nil, err
+	}
+	normalized := *fact
+	normalizeTombstone(&normalized)
+	fact = &normalized
+	value, err := valueAttribute(*fact)
+	if err != nil {
+		return nil, err
 	}
+	sk := schema.SortKeyValue(fact.Timestamp, fact.ID)
+	fk := schema.FieldKeyValue(userID, fact.Namespace, fact.FieldName)
 
+	item := map[string]types.AttributeValue{
+		pkName:           &types.AttributeValueMemberS{Value: userID},
+		skName:           &types.AttributeValueMemberS{Value: sk},
+		"ID":             &types.AttributeValueMemberS{Value: fact.ID},
+		"Namespace":      &types.AttributeValueMemberS{Value: fact.Namespace},
+		"FieldName":      &types.AttributeValueMemberS{Value: fact.FieldName},
+		"DataType":       &types.AttributeValueMemberS{Value: string(fact.DataType)},
+		"Value":          value,
+		fieldKeyName:     &types.AttributeValueMemberS{Value: fk},
+		namespaceKeyName: &types.AttributeValueMemberS{Value: schema.NamespaceValue(userID, fact.Namespace)},
+	}
 	if fact.IsDeleted {
 		item[isDeletedName] = &types.AttributeValueMemberBOOL{Value: true}
 	}
+	if fact.ActorUserID != "" {
+		item["ActorUserID"] = &types.AttributeValueMemberS{Value: fact.ActorUserID}
+	}
+	if fact.APIKeyID != "" {
+		item["APIKeyID"] = &types.AttributeValueMemberS{Value: fact.APIKeyID}
+	}
+	if fact.ValidTime != nil {
+		item["ValidTime"] = &types.AttributeValueMemberS{Value: fact.ValidTime.UTC().Format(time.RFC3339Nano)}
+	}
+	if fact.ClientDated {
+		item["ClientDated"] = &types.AttributeValueMemberBOOL{Value: true}
+	}
+	if fact.CreatedAt != nil {
+		item["CreatedAt"] = &types.AttributeValueMemberS{Value: fact.CreatedAt.UTC().Format(time.RFC3339Nano)}
+	}
+	if err := schema.ValidateItemSize(item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
 
-	_, err := s.db.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(s.tableName),
-		Item:      item,
-	})
+// checkFactSize returns an *schema.ItemSizeError for facts too large for a
+// DynamoDB item, so that stores other than DynamoDB refuse them too
+func checkFactSize(fact *Fact) error {
+	if _, err := factItem(fact.UserID, fact); err != nil {
+		var sizeErr *schema.ItemSizeError
+		if errors.As(err, &sizeErr) {
+			return err
+		}
+	}
+	return nil
+}
 
-	if err != nil {
+// checkTransactionSizes applies checkFactSize to the facts of a transaction
+func checkTransactionSizes(facts []*Fact) error {
+	for i, fact := range facts {
+		if err := checkFactSize(fact); err != nil {
+			return &StoreError{
+				Operation: "PutFactsTransactional",
+				Kind:      ErrValidation,
+				Err:       fmt.Errorf("item %d: %w", i, err),
+			}
+		}
+	}
+	return nil
+}
+
+// namespaceKey returns the NamespaceIndex partition of a namespace
+func (s *DynamoDBStore) namespaceKey(namespace string) string {
+	return schema.NamespaceValue(s.userID, namespace)
+}
+
+// PutFactsTransactional implements Store.PutFactsTransactional with a single
+// TransactWriteItems call. Each put is conditional on its item not existing,
+// so a fact never silently replaces another with the same timestamp and ID.
+func (s *DynamoDBStore) PutFactsTransactional(ctx context.Context, facts []*Fact) error {
+	if err := validateTransaction(facts); err != nil {
+		return err
+	}
+	if len(facts) == 0 {
+		return nil
+	}
+
+	items := make([]types.TransactWriteItem, len(facts))
+	for i, fact := range facts {
+		if fact.UserID == "" {
+			fact.UserID = s.userID
+		}
+		item, err := s.factItem(fact)
+		if err != nil {
+			return &StoreError{
+				Operation: "PutFactsTransactional",
+				Kind:      ErrValidation,
+				Err:       err,
+			}
+		}
+		items[i] = types.TransactWriteItem{Put: &types.Put{
+			TableName:           aws.String(s.tableName),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(" + skName + ")"),
+		}}
+	}
+
+	if _, err := s.db.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items}); err != nil {
 		return &StoreError{
-			Operation: "PutFact",
-			Err:       fmt.Errorf("put fact failed: %w", err),
+			Operation: "PutFactsTransactional",
+			Err:       transactionError(err),
 		}
 	}
 
+	s.logger.DebugContext(ctx, "dynamodb transaction", "table", s.tableName, "items", len(items))
+	return nil
+}
+
+// validateTransaction rejects transactions PutFactsTransactional cannot run
+func validateTransaction(facts []*Fact) error {
+	if len(facts) > MaxTransactionItems {
+		return &StoreError{
+			Operation: "PutFactsTransactional",
+			Kind:      ErrValidation,
+			Err:       fmt.Errorf("transaction has %d items, at most %d are allowed", len(facts), MaxTransactionItems),
+		}
+	}
+	for i, fact := range facts {
+		if fact == nil {
+			return &StoreError{
+				Operation: "PutFactsTransactional",
+				Kind:      ErrValidation,
+				Err:       fmt.Errorf("item %d: fact cannot be nil", i),
+			}
+		}
+		if err := schema.ValidateID(fact.ID); err != nil {
+			return &StoreError{
+				Operation: "PutFactsTransactional",
+				Kind:      ErrValidation,
+				Err:       fmt.Errorf("item %d: %w", i, err),
+			}
+		}
+	}
 	return nil
 }
 
 // GetFact implements Store.GetFact
 func (s *DynamoDBStore) GetFact(ctx context.Context, id string) (*Fact, error) {
-	// Query for the latest version of this fact ID
-	result, err := s.db.Query(ctx, &dynamodb.QueryInput{
+	// Query for the latest version of this fact ID. A limit would apply
+	// before the filter, so pages are read newest first until one matches.
+	input := &dynamodb.QueryInput{
 		TableName:              aws.String(s.tableName),
 		KeyConditionExpression: aws.String(fmt.Sprintf("%s = :uid", pkName)),
 		FilterExpression:       aws.String("ID = :id"),
@@ -215,14 +418,21 @@ func (s *DynamoDBStore) GetFact(ctx context.Context, id string) (*Fact, error) {
 			":id":  &types.AttributeValueMemberS{Value: id},
 		},
 		ScanIndexForward: aws.Bool(false), // descending order by sort key
-		Limit:            aws.Int32(1),    // just get the latest
-	})
-
-	if err != nil {
-		return nil, &StoreError{
-			Operation: "GetFact",
-			Err:       fmt.Errorf("get fact failed: %w", err),
+	}
+	var result *dynamodb.QueryOutput
+	for {
+		var err error
+		result, err = s.db.Query(ctx, input)
+		if err != nil {
+			return nil, &StoreError{
+				Operation: "GetFact",
+				Err:       fmt.Errorf("get fact failed: %w", err),
+			}
 		}
+		if result.Count > 0 || len(result.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
 	}
 
 	if result.Count == 0 {
@@ -254,32 +464,45 @@ func (s *DynamoDBStore) DeleteFact(ctx context.Context, id string) error {
 		}
 	}
 
-	// Set deletion marker
-	fact.IsDeleted = true
-	fact.Timestamp = time.Now()
+	tombstone := fact.Tombstone(id, time.Now().UTC())
+	return s.PutFact(ctx, &tombstone)
+}
 
-	// Put the deletion marker
-	return s.PutFact(ctx, fact)
+// PurgeFact implements Store.PurgeFact
+func (s *DynamoDBStore) PurgeFact(ctx context.Context, fact *Fact) error {
+	if fact == nil {
+		return &StoreError{
+			Operation: "PurgeFact",
+			Kind:      ErrValidation,
+			Err:       errors.New("fact cannot be nil"),
+		}
+	}
+
+	sk := schema.SortKeyValue(fact.Timestamp, fact.ID)
+	_, err := s.db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			pkName: &types.AttributeValueMemberS{Value: s.userID},
+			skName: &types.AttributeValueMemberS{Value: sk},
+		},
+	})
+
+	if err != nil {
+		return &StoreError{
+			Operation: "PurgeFact",
+			Err:       fmt.Errorf("delete item failed: %w", err),
+		}
+	}
+
+	return nil
 }
 
 // QueryByField implements Store.QueryByField
 func (s *DynamoDBStore) QueryByField(ctx context.Context, namespace, fieldName string, opts QueryOptions) (*QueryResult, error) {
 	// Create field key
-	fk := fmt.Sprintf("%s#%s#%s", s.userID, namespace, fieldName)
-
-	// We need to ensure we always have both a hash key and sort key condition
-	startTime := time.Unix(0, 0) // Beginning of time
-	if opts.StartTime != nil {
-		startTime = *opts.StartTime
-	}
-
-	endTime := time.Now().UTC() // Current time
-	if opts.EndTime != nil {
-		endTime = *opts.EndTime
-	}
+	fk := schema.FieldKeyValue(s.userID, namespace, fieldName)
 
-	skStart := fmt.Sprintf("%s#", startTime.Format(time.RFC3339Nano))
-	skEnd := fmt.Sprintf("%s#", endTime.Format(time.RFC3339Nano))
+	skStart, skEnd := sortKeyRange(opts)
 
 	// Always include both hash and range key conditions for the GSI
 	queryInput := &dynamodb.QueryInput{
@@ -301,19 +524,22 @@ func (s *DynamoDBStore) QueryByField(ctx context.Context, namespace, fieldName s
 		queryInput.Limit = opts.Limit
 	}
 
-	// Apply pagination token if provided
-	if opts.NextToken != nil {
-		var exclusiveStartKey map[string]types.AttributeValue
-		if err := json.Unmarshal([]byte(*opts.NextToken), &exclusiveStartKey); err != nil {
-			return nil, &StoreError{
-				Operation: "QueryByField",
-				Err:       fmt.Errorf("invalid next token: %w", err),
-			}
+	// Resume after the position of a pagination token
+	fingerprint := queryFingerprint("QueryByField", opts, s.tableName, s.userID, namespace, fieldName)
+	position, err := s.cursors.resumeAfter("QueryByField", fingerprint, opts)
+	if err != nil {
+		return nil, err
+	}
+	if position != "" {
+		queryInput.ExclusiveStartKey = map[string]types.AttributeValue{
+			fieldKeyName: &types.AttributeValueMemberS{Value: fk},
+			pkName:       &types.AttributeValueMemberS{Value: s.userID},
+			skName:       &types.AttributeValueMemberS{Value: position},
 		}
-		queryInput.ExclusiveStartKey = exclusiveStartKey
 	}
 
 	// Execute query
+	s.logger.DebugContext(ctx, "dynamodb query", "operation", "QueryByField", "table", s.tableName)
 	result, err := s.db.Query(ctx, queryInput)
 	if err != nil {
 		return nil, &StoreError{
@@ -332,17 +558,9 @@ func (s *DynamoDBStore) QueryByField(ctx context.Context, namespace, fieldName s
 	}
 
 	// Create pagination token if there's more data
-	var nextToken *string
-	if result.LastEvaluatedKey != nil {
-		tokenBytes, err := json.Marshal(result.LastEvaluatedKey)
-		if err != nil {
-			return nil, &StoreError{
-				Operation: "QueryByField",
-				Err:       fmt.Errorf("marshal next token failed: %w", err),
-			}
-		}
-		token := string(tokenBytes)
-		nextToken = &token
+	nextToken, err := s.nextToken("QueryByField", fingerprint, result.LastEvaluatedKey)
+	if err != nil {
+		return nil, err
 	}
 
 	return &QueryResult{
@@ -353,19 +571,7 @@ func (s *DynamoDBStore) QueryByField(ctx context.Context, namespace, fieldName s
 
 // QueryByTimeRange implements Store.QueryByTimeRange
 func (s *DynamoDBStore) QueryByTimeRange(ctx context.Context, opts QueryOptions) (*QueryResult, error) {
-	// We need to ensure we always have both a hash key and sort key condition
-	startTime := time.Unix(0, 0) // Beginning of time
-	if opts.StartTime != nil {
-		startTime = *opts.StartTime
-	}
-
-	endTime := time.Now().UTC() // Current time
-	if opts.EndTime != nil {
-		endTime = *opts.EndTime
-	}
-
-	skStart := fmt.Sprintf("%s#", startTime.Format(time.RFC3339Nano))
-	skEnd := fmt.Sprintf("%s#", endTime.Format(time.RFC3339Nano))
+	skStart, skEnd := sortKeyRange(opts)
 
 	// Always include both hash and range key conditions
 	queryInput := &dynamodb.QueryInput{
@@ -386,19 +592,21 @@ func (s *DynamoDBStore) QueryByTimeRange(ctx context.Context, opts QueryOptions)
 		queryInput.Limit = opts.Limit
 	}
 
-	// Apply pagination token if provided
-	if opts.NextToken != nil {
-		var exclusiveStartKey map[string]types.AttributeValue
-		if err := json.Unmarshal([]byte(*opts.NextToken), &exclusiveStartKey); err != nil {
-			return nil, &StoreError{
-				Operation: "QueryByTimeRange",
-				Err:       fmt.Errorf("invalid next token: %w", err),
-			}
+	// Resume after the position of a pagination token
+	fingerprint := queryFingerprint("QueryByTimeRange", opts, s.tableName, s.userID)
+	position, err := s.cursors.resumeAfter("QueryByTimeRange", fingerprint, opts)
+	if err != nil {
+		return nil, err
+	}
+	if position != "" {
+		queryInput.ExclusiveStartKey = map[string]types.AttributeValue{
+			pkName: &types.AttributeValueMemberS{Value: s.userID},
+			skName: &types.AttributeValueMemberS{Value: position},
 		}
-		queryInput.ExclusiveStartKey = exclusiveStartKey
 	}
 
 	// Execute query
+	s.logger.DebugContext(ctx, "dynamodb query", "operation", "QueryByTimeRange", "table", s.tableName)
 	result, err := s.db.Query(ctx, queryInput)
 	if err != nil {
 		return nil, &StoreError{
@@ -417,17 +625,9 @@ func (s *DynamoDBStore) QueryByTimeRange(ctx context.Context, opts QueryOptions)
 	}
 
 	// Create pagination token if there's more data
-	var nextToken *string
-	if result.LastEvaluatedKey != nil {
-		tokenBytes, err := json.Marshal(result.LastEvaluatedKey)
-		if err != nil {
-			return nil, &StoreError{
-				Operation: "QueryByTimeRange",
-				Err:       fmt.Errorf("marshal next token failed: %w", err),
-			}
-		}
-		token := string(tokenBytes)
-		nextToken = &token
+	nextToken, err := s.nextToken("QueryByTimeRange", fingerprint, result.LastEvaluatedKey)
+	if err != nil {
+		return nil, err
 	}
 
 	return &QueryResult{
@@ -436,66 +636,87 @@ func (s *DynamoDBStore) QueryByTimeRange(ctx context.Context, opts QueryOptions)
 	}, nil
 }
 
-// QueryByNamespace implements Store.QueryByNamespace
+// QueryByNamespace implements Store.QueryByNamespace. Facts are read from
+// the namespace's own partition of the NamespaceIndex, so the limit and pages
+// count only facts of the namespace. Tables created before the index filter
+// the user's partition instead, reading on until the limit is met.
 func (s *DynamoDBStore) QueryByNamespace(ctx context.Context, namespace string, opts QueryOptions) (*QueryResult, error) {
-	// We need to ensure we always have both a hash key and sort key condition
-	startTime := time.Unix(0, 0) // Beginning of time
-	if opts.StartTime != nil {
-		startTime = *opts.StartTime
-	}
+	skStart, skEnd := sortKeyRange(opts)
 
-	endTime := time.Now().UTC() // Current time
-	if opts.EndTime != nil {
-		endTime = *opts.EndTime
-	}
-
-	skStart := fmt.Sprintf("%s#", startTime.Format(time.RFC3339Nano))
-	skEnd := fmt.Sprintf("%s#", endTime.Format(time.RFC3339Nano))
-
-	// Always include both hash and range key conditions
 	queryInput := &dynamodb.QueryInput{
 		TableName: aws.String(s.tableName),
+		IndexName: aws.String(namespaceGSIName),
 		KeyConditionExpression: aws.String(
-			fmt.Sprintf("%s = :uid AND %s BETWEEN :start AND :end", pkName, skName),
+			fmt.Sprintf("%s = :nk AND %s BETWEEN :start AND :end", namespaceKeyName, skName),
 		),
-		FilterExpression: aws.String("Namespace = :ns"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":uid":   &types.AttributeValueMemberS{Value: s.userID},
-			":ns":    &types.AttributeValueMemberS{Value: namespace},
+			":nk":    &types.AttributeValueMemberS{Value: s.namespaceKey(namespace)},
 			":start": &types.AttributeValueMemberS{Value: skStart},
 			":end":   &types.AttributeValueMemberS{Value: skEnd},
 		},
 		ScanIndexForward: aws.Bool(opts.SortAscending),
 	}
+	if s.namespaceFilter {
+		queryInput.IndexName = nil
+		queryInput.KeyConditionExpression = aws.String(
+			fmt.Sprintf("%s = :uid AND %s BETWEEN :start AND :end", pkName, skName),
+		)
+		queryInput.FilterExpression = aws.String("Namespace = :ns")
+		queryInput.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":uid":   &types.AttributeValueMemberS{Value: s.userID},
+			":ns":    &types.AttributeValueMemberS{Value: namespace},
+			":start": &types.AttributeValueMemberS{Value: skStart},
+			":end":   &types.AttributeValueMemberS{Value: skEnd},
+		}
+	}
 
 	// Apply limit if provided
 	if opts.Limit != nil {
 		queryInput.Limit = opts.Limit
 	}
 
-	// Apply pagination token if provided
-	if opts.NextToken != nil {
-		var exclusiveStartKey map[string]types.AttributeValue
-		if err := json.Unmarshal([]byte(*opts.NextToken), &exclusiveStartKey); err != nil {
+	// Resume after the position of a pagination token
+	fingerprint := queryFingerprint("QueryByNamespace", opts, s.tableName, s.userID, namespace)
+	position, err := s.cursors.resumeAfter("QueryByNamespace", fingerprint, opts)
+	if err != nil {
+		return nil, err
+	}
+	if position != "" {
+		queryInput.ExclusiveStartKey = map[string]types.AttributeValue{
+			pkName: &types.AttributeValueMemberS{Value: s.userID},
+			skName: &types.AttributeValueMemberS{Value: position},
+		}
+		if !s.namespaceFilter {
+			queryInput.ExclusiveStartKey[namespaceKeyName] = &types.AttributeValueMemberS{Value: s.namespaceKey(namespace)}
+		}
+	}
+
+	// Execute query
+	var items []map[string]types.AttributeValue
+	var lastKey map[string]types.AttributeValue
+	for {
+		s.logger.DebugContext(ctx, "dynamodb query", "operation", "QueryByNamespace", "table", s.tableName)
+		result, err := s.db.Query(ctx, queryInput)
+		if err != nil {
 			return nil, &StoreError{
 				Operation: "QueryByNamespace",
-				Err:       fmt.Errorf("invalid next token: %w", err),
+				Err:       fmt.Errorf("query failed: %w", err),
 			}
 		}
-		queryInput.ExclusiveStartKey = exclusiveStartKey
-	}
+		items = append(items, result.Items...)
+		lastKey = result.LastEvaluatedKey
 
-	// Execute query
-	result, err := s.db.Query(ctx, queryInput)
-	if err != nil {
-		return nil, &StoreError{
-			Operation: "QueryByNamespace",
-			Err:       fmt.Errorf("query failed: %w", err),
+		// The limit counts items read before the filter, so a filtered page
+		// can hold fewer facts than asked for while more are left
+		if !s.namespaceFilter || opts.Limit == nil || lastKey == nil || int32(len(items)) >= *opts.Limit {
+			break
 		}
+		queryInput.Limit = aws.Int32(*opts.Limit - int32(len(items)))
+		queryInput.ExclusiveStartKey = lastKey
 	}
 
 	// Process results
-	facts, err := unmarshalFactItems(result.Items)
+	facts, err := unmarshalFactItems(items)
 	if err != nil {
 		return nil, &StoreError{
 			Operation: "QueryByNamespace",
@@ -504,17 +725,85 @@ func (s *DynamoDBStore) QueryByNamespace(ctx context.Context, namespace string,
 	}
 
 	// Create pagination token if there's more data
-	var nextToken *string
-	if result.LastEvaluatedKey != nil {
-		tokenBytes, err := json.Marshal(result.LastEvaluatedKey)
+	nextToken, err := s.nextToken("QueryByNamespace", fingerprint, lastKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QueryResult{
+		Facts:     facts,
+		NextToken: nextToken,
+	}, nil
+}
+
+// QueryByActor implements Store.QueryByActor. The store schema has no index
+// of actors, so the user's partition is filtered, reading on until the limit
+// is met.
+func (s *DynamoDBStore) QueryByActor(ctx context.Context, actorUserID string, opts QueryOptions) (*QueryResult, error) {
+	skStart, skEnd := sortKeyRange(opts)
+
+	queryInput := &dynamodb.QueryInput{
+		TableName: aws.String(s.tableName),
+		KeyConditionExpression: aws.String(
+			fmt.Sprintf("%s = :uid AND %s BETWEEN :start AND :end", pkName, skName),
+		),
+		FilterExpression: aws.String("ActorUserID = :actor"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":uid":   &types.AttributeValueMemberS{Value: s.userID},
+			":actor": &types.AttributeValueMemberS{Value: actorUserID},
+			":start": &types.AttributeValueMemberS{Value: skStart},
+			":end":   &types.AttributeValueMemberS{Value: skEnd},
+		},
+		ScanIndexForward: aws.Bool(opts.SortAscending),
+		Limit:            opts.Limit,
+	}
+
+	// Resume after the position of a pagination token
+	fingerprint := queryFingerprint("QueryByActor", opts, s.tableName, s.userID, actorUserID)
+	position, err := s.cursors.resumeAfter("QueryByActor", fingerprint, opts)
+	if err != nil {
+		return nil, err
+	}
+	if position != "" {
+		queryInput.ExclusiveStartKey = map[string]types.AttributeValue{
+			pkName: &types.AttributeValueMemberS{Value: s.userID},
+			skName: &types.AttributeValueMemberS{Value: position},
+		}
+	}
+
+	var items []map[string]types.AttributeValue
+	var lastKey map[string]types.AttributeValue
+	for {
+		s.logger.DebugContext(ctx, "dynamodb query", "operation", "QueryByActor", "table", s.tableName)
+		result, err := s.db.Query(ctx, queryInput)
 		if err != nil {
 			return nil, &StoreError{
-				Operation: "QueryByNamespace",
-				Err:       fmt.Errorf("marshal next token failed: %w", err),
+				Operation: "QueryByActor",
+				Err:       fmt.Errorf("query failed: %w", err),
 			}
 		}
-		token := string(tokenBytes)
-		nextToken = &token
+		items = append(items, result.Items...)
+		lastKey = result.LastEvaluatedKey
+
+		// As in QueryByNamespace, the limit counts items before the filter
+		if opts.Limit == nil || lastKey == nil || int32(len(items)) >= *opts.Limit {
+			break
+		}
+		queryInput.Limit = aws.Int32(*opts.Limit - int32(len(items)))
+		queryInput.ExclusiveStartKey = lastKey
+	}
+
+	facts, err := unmarshalFactItems(items)
+	if err != nil {
+		return nil, &StoreError{
+			Operation: "QueryByActor",
+			Err:       fmt.Errorf("unmarshal failed: %w", err),
+		}
+	}
+
+	nextToken, err := s.nextToken("QueryByActor", fingerprint, lastKey)
+	if err != nil {
+		return nil, err
 	}
 
 	return &QueryResult{
@@ -523,6 +812,45 @@ func (s *DynamoDBStore) QueryByNamespace(ctx context.Context, namespace string,
 	}, nil
 }
 
+// nextToken returns the pagination token resuming a query after the last
+// item it evaluated, or nil when there is none
+func (s *DynamoDBStore) nextToken(operation, fingerprint string, lastKey map[string]types.AttributeValue) (*string, error) {
+	if lastKey == nil {
+		return nil, nil
+	}
+	sk, ok := lastKey[skName].(*types.AttributeValueMemberS)
+	if !ok {
+		return nil, &StoreError{
+			Operation: operation,
+			Err:       errors.New("last evaluated key has no sort key"),
+		}
+	}
+	token, err := s.cursors.encode(fingerprint, sk.Value)
+	if err != nil {
+		return nil, &StoreError{
+			Operation: operation,
+			Err:       fmt.Errorf("encode next token failed: %w", err),
+		}
+	}
+	return token, nil
+}
+
+// sortKeyRange returns the sort key bounds of a query's time range. Both ends
+// are inclusive: the end bound sorts after every fact ID at the end time.
+func sortKeyRange(opts QueryOptions) (start, end string) {
+	startTime := time.Unix(0, 0) // Beginning of time
+	if opts.StartTime != nil {
+		startTime = *opts.StartTime
+	}
+
+	endTime := time.Now().UTC() // Current time
+	if opts.EndTime != nil {
+		endTime = *opts.EndTime
+	}
+
+	return startTime.Format(time.RFC3339Nano) + "#", endTime.Format(time.RFC3339Nano) + "#\uffff"
+}
+
 // GetSnapshotAtTime implements Store.GetSnapshotAtTime
 func (s *DynamoDBStore) GetSnapshotAtTime(ctx context.Context, namespace string, at time.Time) (map[string]Fact, error) {
 	// Query all facts in the namespace up to the given time
@@ -533,42 +861,40 @@ func (s *DynamoDBStore) GetSnapshotAtTime(ctx context.Context, namespace string,
 		SortAscending: false,  // Get newest first for each field
 	}
 
-	var result *QueryResult
-	var err error
-
-	if namespace == "" {
-		// Query all facts if no namespace specified
-		result, err = s.QueryByTimeRange(ctx, queryOpts)
-	} else {
-		// Query only the specified namespace
-		result, err = s.QueryByNamespace(ctx, namespace, queryOpts)
-	}
-
-	if err != nil {
-		return nil, &StoreError{
-			Operation: "GetSnapshotAtTime",
-			Err:       fmt.Errorf("query failed: %w", err),
+	// Read every page, following the pagination tokens
+	var facts []Fact
+	for {
+		var result *QueryResult
+		var err error
+		if namespace == "" {
+			// Query all facts if no namespace specified
+			result, err = s.QueryByTimeRange(ctx, queryOpts)
+		} else {
+			// Query only the specified namespace
+			result, err = s.QueryByNamespace(ctx, namespace, queryOpts)
+		}
+		if err != nil {
+			return nil, &StoreError{
+				Operation: "GetSnapshotAtTime",
+				Err:       fmt.Errorf("query failed: %w", err),
+			}
 		}
+		facts = append(facts, result.Facts...)
+		if result.NextToken == nil {
+			break
+		}
+		queryOpts.NextToken = result.NextToken
 	}
 
-	// Build snapshot map - most recent fact for each field
+	// Build snapshot map - most recent fact for each field. Facts come
+	// newest first, so the first of each field decides it, and a tombstone
+	// hides the older versions.
 	snapshot := make(map[string]Fact)
-	for _, fact := range result.Facts {
+	for _, fact := range facts {
 		// We identify fields by namespace#fieldName
 		key := fmt.Sprintf("%s#%s", fact.Namespace, fact.FieldName)
-
-		// Check if we already have this field in our snapshot
-		existingFact, exists := snapshot[key]
-
-		// If we don't have it yet, or this version is newer, use this one
-		if !exists || fact.Timestamp.After(existingFact.Timestamp) {
-			// Skip deleted items
-			if !fact.IsDeleted {
-				snapshot[key] = fact
-			} else if exists {
-				// Remove the fact if it was deleted and we had it in the snapshot
-				delete(snapshot, key)
-			}
+		if _, seen := snapshot[key]; !seen {
+			snapshot[key] = fact
 		}
 	}
 
@@ -608,32 +934,11 @@ func unmarshalFactItems(items []map[string]types.AttributeValue) ([]Fact, error)
 		}
 
 		if v, ok := item["Value"]; ok {
-			var value interface{}
-			err := attributevalue.Unmarshal(v, &value)
+			value, err := attributeValue(fact.DataType, v)
 			if err != nil {
-				return nil, fmt.Errorf("unmarshal value failed: %w", err)
-			}
-
-			// Convert interface{} to string based on data type
-			if string(fact.DataType) == "json" {
-				// For JSON data, marshal back to JSON string
-				if value != nil {
-					jsonBytes, err := json.Marshal(value)
-					if err != nil {
-						return nil, fmt.Errorf("marshal JSON value failed: %w", err)
-					}
-					fact.Value = string(jsonBytes)
-				} else {
-					fact.Value = "null"
-				}
-			} else {
-				// For other types, convert to string representation
-				if value != nil {
-					fact.Value = fmt.Sprintf("%v", value)
-				} else {
-					fact.Value = ""
-				}
+				return nil, err
 			}
+			fact.Value = value
 		}
 
 		if v, ok := item[pkName]; ok {
@@ -647,19 +952,44 @@ func unmarshalFactItems(items []map[string]types.AttributeValue) ([]Fact, error)
 				fact.IsDeleted = bv.Value
 			}
 		}
+		normalizeTombstone(&fact)
 
-		// Extract timestamp from SK
-		if v, ok := item[skName]; ok {
-			if sv, ok := v.(*types.AttributeValueMemberS); ok {
-				parts := strings.SplitN(sv.Value, "#", 2)
-				if len(parts) > 0 {
-					ts, err := time.Parse(time.RFC3339Nano, parts[0])
-					if err != nil {
-						return nil, fmt.Errorf("parse timestamp failed: %w", err)
-					}
-					fact.Timestamp = ts
-				}
+		if v, ok := item["ActorUserID"].(*types.AttributeValueMemberS); ok {
+			fact.ActorUserID = v.Value
+		}
+		if v, ok := item["APIKeyID"].(*types.AttributeValueMemberS); ok {
+			fact.APIKeyID = v.Value
+		}
+		if v, ok := item["ValidTime"].(*types.AttributeValueMemberS); ok {
+			valid, err := time.Parse(time.RFC3339Nano, v.Value)
+			if err != nil {
+				return nil, fmt.Errorf("item has an invalid ValidTime: %w", err)
 			}
+			fact.ValidTime = &valid
+		}
+		if v, ok := item["ClientDated"].(*types.AttributeValueMemberBOOL); ok {
+			fact.ClientDated = v.Value
+		}
+		if v, ok := item["CreatedAt"].(*types.AttributeValueMemberS); ok {
+			created, err := time.Parse(time.RFC3339Nano, v.Value)
+			if err != nil {
+				return nil, fmt.Errorf("item has an invalid CreatedAt: %w", err)
+			}
+			fact.CreatedAt = &created
+		}
+
+		// Extract timestamp from SK, and the ID when the item lacks it
+		sk, ok := item[skName].(*types.AttributeValueMemberS)
+		if !ok {
+			return nil, fmt.Errorf("item has no string %s", skName)
+		}
+		ts, id, err := schema.ParseSortKey(sk.Value)
+		if err != nil {
+			return nil, err
+		}
+		fact.Timestamp = ts
+		if fact.ID == "" {
+			fact.ID = id
 		}
 
 		facts = append(facts, fact)
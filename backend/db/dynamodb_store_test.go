@@ -0,0 +1,242 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// namespaceAPI is a DynamoDB fake recording queries and answering them with
+// scripted pages
+type namespaceAPI struct {
+	dynamoDBAPI
+	exists  bool
+	indexes []string
+	pages   []*dynamodb.QueryOutput
+	queries []dynamodb.QueryInput
+	puts    []map[string]types.AttributeValue
+}
+
+func (f *namespaceAPI) CreateTable(ctx context.Context, in *dynamodb.CreateTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	if f.exists {
+		return nil, &types.ResourceInUseException{Message: aws.String("table exists")}
+	}
+	for _, gsi := range in.GlobalSecondaryIndexes {
+		f.indexes = append(f.indexes, aws.ToString(gsi.IndexName))
+	}
+	return &dynamodb.CreateTableOutput{}, nil
+}
+
+func (f *namespaceAPI) DescribeTable(ctx context.Context, in *dynamodb.DescribeTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	desc := &types.TableDescription{TableName: in.TableName, TableStatus: types.TableStatusActive}
+	for _, name := range f.indexes {
+		desc.GlobalSecondaryIndexes = append(desc.GlobalSecondaryIndexes, types.GlobalSecondaryIndexDescription{IndexName: aws.String(name)})
+	}
+	return &dynamodb.DescribeTableOutput{Table: desc}, nil
+}
+
+func (f *namespaceAPI) PutItem(ctx context.Context, in *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.puts = append(f.puts, in.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *namespaceAPI) Query(ctx context.Context, in *dynamodb.QueryInput, _ ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	f.queries = append(f.queries, *in)
+	if len(f.pages) == 0 {
+		return &dynamodb.QueryOutput{}, nil
+	}
+	page := f.pages[0]
+	f.pages = f.pages[1:]
+	return page, nil
+}
+
+func testDynamoDBStore(api dynamoDBAPI) *DynamoDBStore {
+	return &DynamoDBStore{db: api, tableName: "Facts", userID: "u1", logger: slog.New(slog.NewTextHandler(io.Discard, nil)), cursors: mustCursorSigner([]byte("secret"))}
+}
+
+func factItemOf(id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"ID":        &types.AttributeValueMemberS{Value: id},
+		skName:      &types.AttributeValueMemberS{Value: "2024-01-01T00:30:00Z#" + id},
+		"Namespace": &types.AttributeValueMemberS{Value: "orders"},
+		"DataType":  &types.AttributeValueMemberS{Value: "string"},
+		"Value":     &types.AttributeValueMemberS{Value: "v"},
+	}
+}
+
+func TestQueryByNamespaceUsesNamespaceIndex(t *testing.T) {
+	ctx := context.Background()
+	api := &namespaceAPI{}
+	store := testDynamoDBStore(api)
+	require.NoError(t, store.CreateTable(ctx))
+	assert.Contains(t, api.indexes, namespaceGSIName)
+
+	require.NoError(t, store.PutFact(ctx, &Fact{ID: "f1", Timestamp: time.Now(), Namespace: "orders", FieldName: "r1", DataType: DataTypeString, Value: StringValue("v")}))
+	require.Len(t, api.puts, 1)
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "u1#orders"}, api.puts[0][namespaceKeyName])
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	api.pages = []*dynamodb.QueryOutput{{Items: []map[string]types.AttributeValue{factItemOf("f1")}}}
+	res, err := store.QueryByNamespace(ctx, "orders", QueryOptions{StartTime: &start, EndTime: &end, Limit: aws.Int32(5)})
+	require.NoError(t, err)
+	assert.Len(t, res.Facts, 1)
+
+	require.Len(t, api.queries, 1)
+	q := api.queries[0]
+	assert.Equal(t, namespaceGSIName, aws.ToString(q.IndexName))
+	assert.Nil(t, q.FilterExpression, "the namespace is the partition, not a filter")
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "u1#orders"}, q.ExpressionAttributeValues[":nk"])
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "2024-01-01T00:00:00Z#"}, q.ExpressionAttributeValues[":start"])
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "2024-01-01T01:00:00Z#\uffff"}, q.ExpressionAttributeValues[":end"])
+	assert.Equal(t, int32(5), aws.ToInt32(q.Limit))
+}
+
+func TestQueryByNamespaceFiltersTablesWithoutIndex(t *testing.T) {
+	ctx := context.Background()
+	api := &namespaceAPI{exists: true, indexes: []string{defaultGSIName}}
+	store := testDynamoDBStore(api)
+	require.NoError(t, store.CreateTable(ctx))
+	assert.True(t, store.namespaceFilter)
+
+	// The first page holds one match out of the two items it read
+	more := map[string]types.AttributeValue{pkName: &types.AttributeValueMemberS{Value: "u1"}, skName: &types.AttributeValueMemberS{Value: "b"}}
+	api.pages = []*dynamodb.QueryOutput{
+		{Items: []map[string]types.AttributeValue{factItemOf("f1")}, LastEvaluatedKey: more},
+		{Items: []map[string]types.AttributeValue{factItemOf("f2")}},
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	res, err := store.QueryByNamespace(ctx, "orders", QueryOptions{StartTime: &start, Limit: aws.Int32(2)})
+	require.NoError(t, err)
+	require.Len(t, res.Facts, 2, "reads on until the limit is met")
+	assert.Nil(t, res.NextToken)
+
+	require.Len(t, api.queries, 2)
+	for _, q := range api.queries {
+		assert.Nil(t, q.IndexName)
+		assert.Equal(t, "Namespace = :ns", aws.ToString(q.FilterExpression))
+		assert.Equal(t, &types.AttributeValueMemberS{Value: "orders"}, q.ExpressionAttributeValues[":ns"])
+		assert.Equal(t, &types.AttributeValueMemberS{Value: "2024-01-01T00:00:00Z#"}, q.ExpressionAttributeValues[":start"])
+	}
+	assert.Equal(t, int32(1), aws.ToInt32(api.queries[1].Limit))
+	assert.Equal(t, more, api.queries[1].ExclusiveStartKey)
+}
+
+func TestQueryByNamespaceCursors(t *testing.T) {
+	ctx := context.Background()
+	api := &namespaceAPI{}
+	store := testDynamoDBStore(api)
+	require.NoError(t, store.CreateTable(ctx))
+
+	lastKey := map[string]types.AttributeValue{
+		pkName:           &types.AttributeValueMemberS{Value: "u1"},
+		skName:           &types.AttributeValueMemberS{Value: "2024-01-01T00:30:00Z#f1"},
+		namespaceKeyName: &types.AttributeValueMemberS{Value: "u1#orders"},
+	}
+	api.pages = []*dynamodb.QueryOutput{{Items: []map[string]types.AttributeValue{factItemOf("f1")}, LastEvaluatedKey: lastKey}}
+	opts := QueryOptions{Limit: aws.Int32(1), SortAscending: true}
+	res, err := store.QueryByNamespace(ctx, "orders", opts)
+	require.NoError(t, err)
+	require.NotNil(t, res.NextToken)
+	assert.NotContains(t, *res.NextToken, "UserID", "tokens do not expose DynamoDB keys")
+
+	// The next page starts after the token's position, whatever the page size
+	opts.NextToken = res.NextToken
+	opts.Limit = aws.Int32(10)
+	api.pages = []*dynamodb.QueryOutput{{Items: []map[string]types.AttributeValue{factItemOf("f2")}}}
+	res, err = store.QueryByNamespace(ctx, "orders", opts)
+	require.NoError(t, err)
+	assert.Nil(t, res.NextToken)
+	assert.Equal(t, lastKey, api.queries[len(api.queries)-1].ExclusiveStartKey)
+
+	// Tokens are bound to their query and cannot be altered
+	_, err = store.QueryByNamespace(ctx, "invoices", opts)
+	assert.True(t, errors.Is(err, ErrValidation), err)
+	_, err = store.QueryByField(ctx, "orders", "r1", opts)
+	assert.True(t, errors.Is(err, ErrValidation), err)
+	token := *opts.NextToken
+	_, sig, _ := strings.Cut(token, ".")
+	forged, err := store.cursors.encode("", "2099-01-01T00:00:00Z#x")
+	require.NoError(t, err)
+	tampered := strings.SplitN(*forged, ".", 2)[0] + "." + sig
+	opts.NextToken = &tampered
+	_, err = store.QueryByNamespace(ctx, "orders", opts)
+	assert.True(t, errors.Is(err, ErrValidation), err)
+
+	// Other stores only accept tokens signed with the same secret
+	other := &DynamoDBStore{db: api, tableName: "Facts", userID: "u1", logger: store.logger, cursors: mustCursorSigner(nil)}
+	opts.NextToken = &token
+	_, err = other.QueryByNamespace(ctx, "orders", opts)
+	assert.True(t, errors.Is(err, ErrValidation), err)
+	_, err = store.QueryByNamespace(ctx, "orders", opts)
+	assert.NoError(t, err)
+	t.Setenv("NOTABLY_CURSOR_SECRET", " secret\n")
+	other.cursors = mustCursorSigner(CursorSecretFromEnv())
+	_, err = other.QueryByNamespace(ctx, "orders", opts)
+	assert.NoError(t, err, "a shared secret from the environment")
+
+	// A signer without a secret fails paginated queries instead of panicking
+	broken := errors.New("no entropy")
+	other.cursors = CursorSigner{err: broken}
+	_, err = other.QueryByNamespace(ctx, "orders", opts)
+	assert.True(t, errors.Is(err, broken), err)
+	opts.NextToken = nil
+	api.pages = []*dynamodb.QueryOutput{{Items: []map[string]types.AttributeValue{factItemOf("f1")}, LastEvaluatedKey: lastKey}}
+	_, err = other.QueryByNamespace(ctx, "orders", opts)
+	assert.True(t, errors.Is(err, broken), err)
+}
+
+func TestGetFactReadsPastFilteredPages(t *testing.T) {
+	ctx := context.Background()
+	more := map[string]types.AttributeValue{pkName: &types.AttributeValueMemberS{Value: "u1"}, skName: &types.AttributeValueMemberS{Value: "b"}}
+	api := &namespaceAPI{pages: []*dynamodb.QueryOutput{
+		{LastEvaluatedKey: more},
+		{Items: []map[string]types.AttributeValue{factItemOf("f1")}, Count: 1},
+	}}
+	store := testDynamoDBStore(api)
+
+	fact, err := store.GetFact(ctx, "f1")
+	require.NoError(t, err)
+	assert.Equal(t, "f1", fact.ID)
+	require.Len(t, api.queries, 2, "a page the filter emptied is not the end")
+	assert.Nil(t, api.queries[0].Limit, "a limit would apply before the filter")
+	assert.Equal(t, more, api.queries[1].ExclusiveStartKey)
+}
+
+func TestSnapshotDeletionHidesOlderVersions(t *testing.T) {
+	ctx := context.Background()
+	api := &namespaceAPI{}
+	store := testDynamoDBStore(api)
+	require.NoError(t, store.CreateTable(ctx))
+
+	version := func(sk string, deleted bool) map[string]types.AttributeValue {
+		item := factItemOf("f1")
+		item[skName] = &types.AttributeValueMemberS{Value: sk}
+		item["FieldName"] = &types.AttributeValueMemberS{Value: "r1"}
+		item[isDeletedName] = &types.AttributeValueMemberBOOL{Value: deleted}
+		return item
+	}
+	// Newest first, as the store queries
+	api.pages = []*dynamodb.QueryOutput{{Items: []map[string]types.AttributeValue{
+		version("2024-01-01T00:30:00Z#f1", true),
+		version("2024-01-01T00:20:00Z#f1", false),
+	}}}
+	snapshot, err := store.GetSnapshotAtTime(ctx, "orders", time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Len(t, snapshot, 1)
+	tombstone := snapshot["orders#r1"]
+	assert.Equal(t, "2024-01-01T00:30:00Z", tombstone.Timestamp.Format(time.RFC3339), "the deletion is the field's latest version")
+	assert.True(t, tombstone.IsDeleted)
+	assert.Equal(t, DataTypeDeleted, tombstone.DataType, "older deletions are read as tombstones")
+	assert.Empty(t, tombstone.Value)
+}
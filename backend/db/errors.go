@@ -0,0 +1,183 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/elibdev/notably/schema"
+)
+
+// Error kinds. A *StoreError matches one of these with errors.Is, either
+// because its Kind is set or because its cause is a DynamoDB error of that kind.
+var (
+	// ErrNotFound means the requested fact or table does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConditionFailed means a conditional write lost to a concurrent change
+	ErrConditionFailed = errors.New("condition failed")
+	// ErrThrottled means DynamoDB rejected the call for exceeding capacity or
+	// request limits; the call may succeed if retried later
+	ErrThrottled = errors.New("throttled")
+	// ErrValidation means the request was malformed and retrying will not help
+	ErrValidation = errors.New("validation failed")
+	// ErrOverCapacity means a CapacityLimiter held the call back before it
+	// reached the backend; a *CapacityError says when to retry
+	ErrOverCapacity = errors.New("over capacity")
+)
+
+// KindOf returns the error kind of err, or nil when it has none
+func KindOf(err error) error {
+	for _, kind := range []error{ErrNotFound, ErrConditionFailed, ErrThrottled, ErrValidation, ErrOverCapacity} {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	return nil
+}
+
+// CapacityError reports a write refused because the table's write capacity
+// is used up. It is the cause of a StoreError of kind ErrOverCapacity.
+type CapacityError struct {
+	// RetryAfter is how long until the capacity for the call is available
+	RetryAfter time.Duration
+}
+
+func (e *CapacityError) Error() string {
+	return fmt.Sprintf("write capacity exhausted, retry after %s", e.RetryAfter)
+}
+
+// Unwrap exposes both the error kind and the underlying cause to errors.Is and errors.As
+func (e *StoreError) Unwrap() []error {
+	kind := e.Kind
+	if kind == nil {
+		kind = classify(e.Err)
+	}
+	if kind == nil {
+		return []error{e.Err}
+	}
+	return []error{kind, e.Err}
+}
+
+// classify maps DynamoDB errors to an error kind
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var txErr *TransactionError
+	if errors.As(err, &txErr) {
+		return txErr.kind()
+	}
+	var sizeErr *schema.ItemSizeError
+	if errors.As(err, &sizeErr) {
+		return ErrValidation
+	}
+
+	var notFound *types.ResourceNotFoundException
+	var condition *types.ConditionalCheckFailedException
+	var provisioned *types.ProvisionedThroughputExceededException
+	var limit *types.RequestLimitExceeded
+	switch {
+	case errors.As(err, &notFound):
+		return ErrNotFound
+	case errors.As(err, &condition):
+		return ErrConditionFailed
+	case errors.As(err, &provisioned), errors.As(err, &limit):
+		return ErrThrottled
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ResourceNotFoundException":
+			return ErrNotFound
+		case "ConditionalCheckFailedException", "TransactionConflictException":
+			return ErrConditionFailed
+		case "ThrottlingException", "ProvisionedThroughputExceededException", "RequestLimitExceeded":
+			return ErrThrottled
+		case "ValidationException", "SerializationException":
+			return ErrValidation
+		}
+	}
+	return nil
+}
+
+// Cancellation reason codes reported by DynamoDB for transaction items
+const (
+	ReasonConditionFailed = "ConditionalCheckFailed"
+	ReasonConflict        = "TransactionConflict"
+	ReasonThrottled       = "ThrottlingError"
+	ReasonValidation      = "ValidationError"
+)
+
+// CancellationReason explains why one item of a transaction was rejected.
+// Index is the item's position in the facts passed to PutFactsTransactional.
+type CancellationReason struct {
+	Index   int    `json:"index"`
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// TransactionError reports a transaction that was cancelled and wrote
+// nothing. Reasons lists only the items that caused the cancellation.
+type TransactionError struct {
+	Reasons []CancellationReason
+}
+
+func (e *TransactionError) Error() string {
+	if len(e.Reasons) == 0 {
+		return "transaction cancelled"
+	}
+	parts := make([]string, len(e.Reasons))
+	for i, r := range e.Reasons {
+		parts[i] = fmt.Sprintf("item %d: %s", r.Index, r.Code)
+	}
+	return "transaction cancelled: " + strings.Join(parts, ", ")
+}
+
+// kind maps the cancellation reasons to an error kind. A failed condition or
+// a conflict with another transaction outranks throttling, which outranks
+// validation, so callers retry only when retrying can help.
+func (e *TransactionError) kind() error {
+	kind := error(nil)
+	rank := 0
+	for _, r := range e.Reasons {
+		var k error
+		var n int
+		switch r.Code {
+		case ReasonConditionFailed, ReasonConflict:
+			k, n = ErrConditionFailed, 3
+		case ReasonThrottled, "ProvisionedThroughputExceeded", "RequestLimitExceeded":
+			k, n = ErrThrottled, 2
+		case ReasonValidation, "ItemCollectionSizeLimitExceeded":
+			k, n = ErrValidation, 1
+		}
+		if n > rank {
+			kind, rank = k, n
+		}
+	}
+	return kind
+}
+
+// transactionError converts a DynamoDB TransactionCanceledException into a
+// *TransactionError and returns other errors unchanged
+func transactionError(err error) error {
+	var cancelled *types.TransactionCanceledException
+	if !errors.As(err, &cancelled) {
+		return err
+	}
+	txErr := &TransactionError{}
+	for i, r := range cancelled.CancellationReasons {
+		code := aws.ToString(r.Code)
+		if code == "" || code == "None" {
+			continue
+		}
+		txErr.Reasons = append(txErr.Reasons, CancellationReason{Index: i, Code: code, Message: aws.ToString(r.Message)})
+	}
+	return txErr
+}
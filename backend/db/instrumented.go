@@ -0,0 +1,224 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/tracing"
+)
+
+// CallObserver receives a record of every call made through an
+// InstrumentedStore: how long it took, how many facts it read or wrote, the
+// DynamoDB capacity units it consumed (zero for backends that report none)
+// and its error
+type CallObserver interface {
+	ObserveStoreCall(ctx context.Context, operation string, duration time.Duration, items int, capacity float64, err error)
+}
+
+// InstrumentOptions configures an InstrumentedStore
+type InstrumentOptions struct {
+	// Observer, if set, is told about every call
+	Observer CallObserver
+
+	// Logger receives slow call warnings; slog.Default() is used when nil
+	Logger *slog.Logger
+
+	// SlowThreshold is how long a call may take before it is logged with
+	// its parameters; zero disables slow call logging
+	SlowThreshold time.Duration
+}
+
+// InstrumentedStore is a Store that measures the calls it passes to another
+// Store. It wraps any backend; consumed capacity is known when the calls
+// reach DynamoDB through a DynamoDBStore or a dynamo.Client.
+type InstrumentedStore struct {
+	inner Store
+	opts  InstrumentOptions
+}
+
+// NewInstrumentedStore returns a Store measuring the calls made to inner
+func NewInstrumentedStore(inner Store, opts InstrumentOptions) *InstrumentedStore {
+	opts.Logger = loggerOrDefault(opts.Logger)
+	return &InstrumentedStore{inner: inner, opts: opts}
+}
+
+// measure runs one call with a capacity meter and reports it. The call
+// returns how many facts it handled; params are logged with slow calls.
+// Calls on a context that is already done fail with its error without
+// reaching the backend, so loops of calls stop once a request is cancelled
+// or times out, even on backends that ignore contexts. Calls made in a
+// trace get a span holding the same details, so the DynamoDB calls they
+// fan out to show below them.
+func (s *InstrumentedStore) measure(ctx context.Context, operation string, params []interface{}, call func(ctx context.Context) (int, error)) error {
+	ctx, span := tracing.Start(ctx, "store."+operation, params...)
+	ctx, meter := dynamo.WithCapacityMeter(ctx)
+	start := time.Now()
+	items, err := 0, ctx.Err()
+	if err != nil {
+		err = &StoreError{Operation: operation, Err: err}
+	} else {
+		items, err = call(ctx)
+	}
+	duration := time.Since(start)
+	span.SetAttributes("items", items, "capacity", meter.Units())
+	span.End(err)
+
+	if s.opts.Observer != nil {
+		s.opts.Observer.ObserveStoreCall(ctx, operation, duration, items, meter.Units(), err)
+	}
+	if s.opts.SlowThreshold > 0 && duration >= s.opts.SlowThreshold {
+		args := append([]interface{}{"operation", operation, "duration", duration, "items", items, "capacity", meter.Units()}, params...)
+		if err != nil {
+			args = append(args, "error", err)
+		}
+		s.opts.Logger.WarnContext(ctx, "slow store call", args...)
+	}
+	return err
+}
+
+// queryParams returns the log attributes of query options
+func queryParams(opts QueryOptions) []interface{} {
+	var params []interface{}
+	if opts.StartTime != nil {
+		params = append(params, "start", opts.StartTime.Format(time.RFC3339Nano))
+	}
+	if opts.EndTime != nil {
+		params = append(params, "end", opts.EndTime.Format(time.RFC3339Nano))
+	}
+	if opts.Limit != nil {
+		params = append(params, "limit", *opts.Limit)
+	}
+	return append(params, "ascending", opts.SortAscending, "paged", opts.NextToken != nil)
+}
+
+// CreateTable implements Store.CreateTable
+func (s *InstrumentedStore) CreateTable(ctx context.Context) error {
+	return s.measure(ctx, "CreateTable", nil, func(ctx context.Context) (int, error) {
+		return 0, s.inner.CreateTable(ctx)
+	})
+}
+
+// DeleteTable implements Store.DeleteTable
+func (s *InstrumentedStore) DeleteTable(ctx context.Context) error {
+	return s.measure(ctx, "DeleteTable", nil, func(ctx context.Context) (int, error) {
+		return 0, s.inner.DeleteTable(ctx)
+	})
+}
+
+// PutFact implements Store.PutFact
+func (s *InstrumentedStore) PutFact(ctx context.Context, fact *Fact) error {
+	params := []interface{}{}
+	if fact != nil {
+		params = append(params, "namespace", fact.Namespace, "field", fact.FieldName)
+	}
+	return s.measure(ctx, "PutFact", params, func(ctx context.Context) (int, error) {
+		return 1, s.inner.PutFact(ctx, fact)
+	})
+}
+
+// PutFactsTransactional implements Store.PutFactsTransactional
+func (s *InstrumentedStore) PutFactsTransactional(ctx context.Context, facts []*Fact) error {
+	return s.measure(ctx, "PutFactsTransactional", nil, func(ctx context.Context) (int, error) {
+		return len(facts), s.inner.PutFactsTransactional(ctx, facts)
+	})
+}
+
+// GetFact implements Store.GetFact
+func (s *InstrumentedStore) GetFact(ctx context.Context, id string) (*Fact, error) {
+	var fact *Fact
+	err := s.measure(ctx, "GetFact", []interface{}{"id", id}, func(ctx context.Context) (int, error) {
+		var err error
+		fact, err = s.inner.GetFact(ctx, id)
+		if fact == nil {
+			return 0, err
+		}
+		return 1, err
+	})
+	return fact, err
+}
+
+// DeleteFact implements Store.DeleteFact
+func (s *InstrumentedStore) DeleteFact(ctx context.Context, id string) error {
+	return s.measure(ctx, "DeleteFact", []interface{}{"id", id}, func(ctx context.Context) (int, error) {
+		return 1, s.inner.DeleteFact(ctx, id)
+	})
+}
+
+// PurgeFact implements Store.PurgeFact
+func (s *InstrumentedStore) PurgeFact(ctx context.Context, fact *Fact) error {
+	params := []interface{}{}
+	if fact != nil {
+		params = append(params, "namespace", fact.Namespace, "field", fact.FieldName)
+	}
+	return s.measure(ctx, "PurgeFact", params, func(ctx context.Context) (int, error) {
+		return 1, s.inner.PurgeFact(ctx, fact)
+	})
+}
+
+// QueryByField implements Store.QueryByField
+func (s *InstrumentedStore) QueryByField(ctx context.Context, namespace, fieldName string, opts QueryOptions) (*QueryResult, error) {
+	var result *QueryResult
+	params := append([]interface{}{"namespace", namespace, "field", fieldName}, queryParams(opts)...)
+	err := s.measure(ctx, "QueryByField", params, func(ctx context.Context) (int, error) {
+		var err error
+		result, err = s.inner.QueryByField(ctx, namespace, fieldName, opts)
+		return resultSize(result), err
+	})
+	return result, err
+}
+
+// QueryByTimeRange implements Store.QueryByTimeRange
+func (s *InstrumentedStore) QueryByTimeRange(ctx context.Context, opts QueryOptions) (*QueryResult, error) {
+	var result *QueryResult
+	err := s.measure(ctx, "QueryByTimeRange", queryParams(opts), func(ctx context.Context) (int, error) {
+		var err error
+		result, err = s.inner.QueryByTimeRange(ctx, opts)
+		return resultSize(result), err
+	})
+	return result, err
+}
+
+// QueryByNamespace implements Store.QueryByNamespace
+func (s *InstrumentedStore) QueryByNamespace(ctx context.Context, namespace string, opts QueryOptions) (*QueryResult, error) {
+	var result *QueryResult
+	params := append([]interface{}{"namespace", namespace}, queryParams(opts)...)
+	err := s.measure(ctx, "QueryByNamespace", params, func(ctx context.Context) (int, error) {
+		var err error
+		result, err = s.inner.QueryByNamespace(ctx, namespace, opts)
+		return resultSize(result), err
+	})
+	return result, err
+}
+
+// QueryByActor implements Store.QueryByActor
+func (s *InstrumentedStore) QueryByActor(ctx context.Context, actorUserID string, opts QueryOptions) (*QueryResult, error) {
+	var result *QueryResult
+	params := append([]interface{}{"actor", actorUserID}, queryParams(opts)...)
+	err := s.measure(ctx, "QueryByActor", params, func(ctx context.Context) (int, error) {
+		var err error
+		result, err = s.inner.QueryByActor(ctx, actorUserID, opts)
+		return resultSize(result), err
+	})
+	return result, err
+}
+
+// GetSnapshotAtTime implements Store.GetSnapshotAtTime
+func (s *InstrumentedStore) GetSnapshotAtTime(ctx context.Context, namespace string, at time.Time) (map[string]Fact, error) {
+	var snapshot map[string]Fact
+	params := []interface{}{"namespace", namespace, "at", at.Format(time.RFC3339Nano)}
+	err := s.measure(ctx, "GetSnapshotAtTime", params, func(ctx context.Context) (int, error) {
+		var err error
+		snapshot, err = s.inner.GetSnapshotAtTime(ctx, namespace, at)
+		return len(snapshot), err
+	})
+	return snapshot, err
+}
+
+func resultSize(result *QueryResult) int {
+	if result == nil {
+		return 0
+	}
+	return len(result.Facts)
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/pkg/tracing"
+)
+
+type storeCall struct {
+	operation string
+	items     int
+	capacity  float64
+	err       error
+}
+
+type callRecorder struct {
+	calls []storeCall
+}
+
+func (r *callRecorder) ObserveStoreCall(ctx context.Context, operation string, duration time.Duration, items int, capacity float64, err error) {
+	r.calls = append(r.calls, storeCall{operation, items, capacity, err})
+}
+
+func TestInstrumentedStoreRecordsCapacity(t *testing.T) {
+	ctx := context.Background()
+	api := &namespaceAPI{}
+	backend := testDynamoDBStore(meteredAPI{api})
+	rec := &callRecorder{}
+	var logs bytes.Buffer
+	store := NewInstrumentedStore(backend, InstrumentOptions{
+		Observer:      rec,
+		Logger:        slog.New(slog.NewJSONHandler(&logs, nil)),
+		SlowThreshold: time.Nanosecond,
+	})
+	require.NoError(t, store.CreateTable(ctx))
+
+	api.pages = []*dynamodb.QueryOutput{{
+		Items:            []map[string]types.AttributeValue{factItemOf("f1"), factItemOf("f2")},
+		ConsumedCapacity: &types.ConsumedCapacity{CapacityUnits: aws.Float64(1.5)},
+	}}
+	res, err := store.QueryByNamespace(ctx, "orders", QueryOptions{Limit: aws.Int32(10)})
+	require.NoError(t, err)
+	assert.Len(t, res.Facts, 2)
+
+	require.Len(t, api.queries, 1)
+	assert.Equal(t, types.ReturnConsumedCapacityTotal, api.queries[0].ReturnConsumedCapacity)
+	require.Len(t, rec.calls, 2)
+	assert.Equal(t, storeCall{operation: "QueryByNamespace", items: 2, capacity: 1.5}, rec.calls[1])
+
+	assert.Contains(t, logs.String(), `"msg":"slow store call"`)
+	assert.Contains(t, logs.String(), `"namespace":"orders"`)
+	assert.Contains(t, logs.String(), `"limit":10`)
+}
+
+func TestInstrumentedStoreWrapsAnyBackend(t *testing.T) {
+	ctx := context.Background()
+	rec := &callRecorder{}
+	var logs bytes.Buffer
+	store := NewInstrumentedStore(NewMemoryStore(), InstrumentOptions{
+		Observer:      rec,
+		Logger:        slog.New(slog.NewJSONHandler(&logs, nil)),
+		SlowThreshold: time.Hour,
+	})
+
+	now := time.Now().UTC()
+	require.NoError(t, store.PutFact(ctx, &Fact{ID: "f1", Timestamp: now, Namespace: "orders", FieldName: "r1", DataType: DataTypeString, Value: StringValue("v")}))
+	snap, err := store.GetSnapshotAtTime(ctx, "orders", now)
+	require.NoError(t, err)
+	assert.Len(t, snap, 1)
+	_, err = store.GetFact(ctx, "missing")
+	assert.Error(t, err)
+
+	require.Len(t, rec.calls, 3)
+	assert.Equal(t, storeCall{operation: "PutFact", items: 1}, rec.calls[0])
+	assert.Equal(t, storeCall{operation: "GetSnapshotAtTime", items: 1}, rec.calls[1])
+	assert.Equal(t, "GetFact", rec.calls[2].operation)
+	assert.Zero(t, rec.calls[2].items)
+	assert.Error(t, rec.calls[2].err)
+	assert.Empty(t, logs.String(), "fast calls are not logged")
+
+	// Calls without a meter leave ReturnConsumedCapacity unset
+	api := &namespaceAPI{}
+	_, err = testDynamoDBStore(meteredAPI{api}).QueryByNamespace(ctx, "orders", QueryOptions{})
+	require.NoError(t, err)
+	require.Len(t, api.queries, 1)
+	assert.Empty(t, api.queries[0].ReturnConsumedCapacity)
+}
+
+func TestInstrumentedStoreStopsOnDoneContext(t *testing.T) {
+	rec := &callRecorder{}
+	backend := NewMemoryStore()
+	store := NewInstrumentedStore(backend, InstrumentOptions{Observer: rec})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := store.PutFact(ctx, &Fact{ID: "f1", Timestamp: time.Now().UTC(), Namespace: "orders", FieldName: "r1", DataType: DataTypeString, Value: StringValue("v")})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = backend.GetFact(context.Background(), "f1")
+	assert.True(t, IsNotFound(err), "the call must not reach the backend")
+	require.Len(t, rec.calls, 1)
+	assert.ErrorIs(t, rec.calls[0].err, context.Canceled)
+}
+
+func TestInstrumentedStoreTraces(t *testing.T) {
+	api := &namespaceAPI{}
+	adapter := NewStoreAdapter(NewInstrumentedStore(testDynamoDBStore(meteredAPI{tracedAPI{api}}), InstrumentOptions{}))
+	api.pages = []*dynamodb.QueryOutput{
+		{
+			Items:            []map[string]types.AttributeValue{factItemOf("f1")},
+			LastEvaluatedKey: map[string]types.AttributeValue{pkName: &types.AttributeValueMemberS{Value: "u1"}, skName: &types.AttributeValueMemberS{Value: "b"}},
+			ConsumedCapacity: &types.ConsumedCapacity{CapacityUnits: aws.Float64(0.5)},
+		},
+		{
+			Items:            []map[string]types.AttributeValue{factItemOf("f2")},
+			ConsumedCapacity: &types.ConsumedCapacity{CapacityUnits: aws.Float64(1)},
+		},
+	}
+
+	rec := &tracing.Recorder{}
+	tracer := tracing.NewTracer(rec, tracing.Options{SampleRatio: 1})
+	ctx, root := tracer.StartServer(context.Background(), "GET /tables/{table}/rows", tracing.SpanContext{})
+	snapshot, err := adapter.GetTableSnapshot(ctx, "u1", "orders", time.Now())
+	require.NoError(t, err)
+	root.End(nil)
+	require.NoError(t, tracer.Flush(ctx))
+
+	spans := rec.Spans()
+	var names []string
+	for _, s := range spans {
+		names = append(names, s.Name)
+	}
+	require.Equal(t, []string{"dynamodb.Query", "dynamodb.Query", "store.GetSnapshotAtTime", "adapter.GetTableSnapshot", "GET /tables/{table}/rows"}, names, "each page is a DynamoDB call")
+	assert.Equal(t, spans[2].SpanID, spans[0].ParentID)
+	assert.Equal(t, spans[2].SpanID, spans[1].ParentID)
+	assert.Equal(t, spans[3].SpanID, spans[2].ParentID)
+	assert.Equal(t, spans[4].SpanID, spans[3].ParentID)
+	assert.Equal(t, 0.5, spans[0].Attribute("aws.dynamodb.consumed_capacity"))
+	assert.Equal(t, 1.5, spans[2].Attribute("capacity"))
+	assert.Equal(t, "u1/orders", spans[2].Attribute("namespace"))
+	assert.Equal(t, len(snapshot), spans[3].Attribute("results"))
+
+	// Calls outside a trace are not metered for it
+	api.queries = nil
+	_, err = testDynamoDBStore(meteredAPI{tracedAPI{api}}).QueryByNamespace(context.Background(), "orders", QueryOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, api.queries[0].ReturnConsumedCapacity)
+}
@@ -0,0 +1,243 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elibdev/notably/schema"
+)
+
+// MemoryStore is a Store that keeps one user's facts in memory. Unlike
+// MockStore it follows the DynamoDB store's semantics exactly, so a server
+// can run against it; the data is lost when the process exits.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	facts   map[string]Fact // Keyed like the DynamoDB sort key, "timestamp#id"
+	cursors CursorSigner
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{facts: make(map[string]Fact), cursors: mustCursorSigner(nil)}
+}
+
+func memoryKey(fact *Fact) string {
+	return fmt.Sprintf("%s#%s", fact.Timestamp.Format(time.RFC3339Nano), fact.ID)
+}
+
+// CreateTable is a no-op; the store is ready as soon as it is created
+func (s *MemoryStore) CreateTable(ctx context.Context) error {
+	return nil
+}
+
+// DeleteTable removes every fact
+func (s *MemoryStore) DeleteTable(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.facts = make(map[string]Fact)
+	return nil
+}
+
+// PutFact stores a fact version, replacing one with the same timestamp and ID
+func (s *MemoryStore) PutFact(ctx context.Context, fact *Fact) error {
+	if fact == nil {
+		return &StoreError{Operation: "PutFact", Kind: ErrValidation, Err: fmt.Errorf("fact cannot be nil")}
+	}
+	if err := schema.ValidateID(fact.ID); err != nil {
+		return &StoreError{Operation: "PutFact", Kind: ErrValidation, Err: err}
+	}
+	if err := checkFactSize(fact); err != nil {
+		return &StoreError{Operation: "PutFact", Kind: ErrValidation, Err: err}
+	}
+	stored := *fact
+	stored.Columns = append([]ColumnDefinition(nil), fact.Columns...)
+	normalizeTombstone(&stored)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.facts[memoryKey(fact)] = stored
+	return nil
+}
+
+// PutFactsTransactional stores all the facts or, if any of them would
+// replace an existing version, none of them
+func (s *MemoryStore) PutFactsTransactional(ctx context.Context, facts []*Fact) error {
+	if err := validateTransaction(facts); err != nil {
+		return err
+	}
+	if err := checkTransactionSizes(facts); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	txErr := &TransactionError{}
+	seen := make(map[string]bool, len(facts))
+	for i, fact := range facts {
+		key := memoryKey(fact)
+		if _, exists := s.facts[key]; exists || seen[key] {
+			txErr.Reasons = append(txErr.Reasons, CancellationReason{Index: i, Code: ReasonConditionFailed, Message: "The conditional request failed"})
+		}
+		seen[key] = true
+	}
+	if len(txErr.Reasons) > 0 {
+		return &StoreError{Operation: "PutFactsTransactional", Err: txErr}
+	}
+	for _, fact := range facts {
+		stored := *fact
+		stored.Columns = append([]ColumnDefinition(nil), fact.Columns...)
+		normalizeTombstone(&stored)
+		s.facts[memoryKey(fact)] = stored
+	}
+	return nil
+}
+
+// GetFact returns the latest version of the fact with the given ID
+func (s *MemoryStore) GetFact(ctx context.Context, id string) (*Fact, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var latest *Fact
+	for _, f := range s.facts {
+		if f.ID == id && (latest == nil || f.Timestamp.After(latest.Timestamp)) {
+			found := f
+			latest = &found
+		}
+	}
+	if latest == nil {
+		return nil, &StoreError{Operation: "GetFact", Kind: ErrNotFound, Err: fmt.Errorf("fact not found")}
+	}
+	return latest, nil
+}
+
+// DeleteFact writes a deletion marker for the fact with the given ID
+func (s *MemoryStore) DeleteFact(ctx context.Context, id string) error {
+	fact, err := s.GetFact(ctx, id)
+	if err != nil {
+		return &StoreError{Operation: "DeleteFact", Err: err}
+	}
+	tombstone := fact.Tombstone(id, time.Now().UTC())
+	return s.PutFact(ctx, &tombstone)
+}
+
+// PurgeFact permanently removes a single fact version
+func (s *MemoryStore) PurgeFact(ctx context.Context, fact *Fact) error {
+	if fact == nil {
+		return &StoreError{Operation: "PurgeFact", Kind: ErrValidation, Err: fmt.Errorf("fact cannot be nil")}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.facts, memoryKey(fact))
+	return nil
+}
+
+// QueryByField returns the versions of one field in the time range
+func (s *MemoryStore) QueryByField(ctx context.Context, namespace, fieldName string, opts QueryOptions) (*QueryResult, error) {
+	return s.page("QueryByField", queryFingerprint("QueryByField", opts, namespace, fieldName), opts, s.query(opts, func(f Fact) bool {
+		return f.Namespace == namespace && f.FieldName == fieldName
+	}))
+}
+
+// QueryByTimeRange returns every fact in the time range
+func (s *MemoryStore) QueryByTimeRange(ctx context.Context, opts QueryOptions) (*QueryResult, error) {
+	return s.page("QueryByTimeRange", queryFingerprint("QueryByTimeRange", opts), opts, s.query(opts, func(Fact) bool { return true }))
+}
+
+// QueryByNamespace returns the facts of one namespace in the time range
+func (s *MemoryStore) QueryByNamespace(ctx context.Context, namespace string, opts QueryOptions) (*QueryResult, error) {
+	return s.page("QueryByNamespace", queryFingerprint("QueryByNamespace", opts, namespace), opts, s.query(opts, func(f Fact) bool { return f.Namespace == namespace }))
+}
+
+// QueryByActor returns the facts an actor wrote in the time range
+func (s *MemoryStore) QueryByActor(ctx context.Context, actorUserID string, opts QueryOptions) (*QueryResult, error) {
+	return s.page("QueryByActor", queryFingerprint("QueryByActor", opts, actorUserID), opts, s.query(opts, func(f Fact) bool { return f.ActorUserID == actorUserID }))
+}
+
+// GetSnapshotAtTime returns the latest version of each field as of at, keyed
+// by "namespace#fieldName". Fields whose latest version is a deletion have
+// their tombstone. An empty namespace covers all namespaces.
+func (s *MemoryStore) GetSnapshotAtTime(ctx context.Context, namespace string, at time.Time) (map[string]Fact, error) {
+	epoch := time.Unix(0, 0)
+	result := s.query(QueryOptions{StartTime: &epoch, EndTime: &at, SortAscending: true}, func(f Fact) bool {
+		return namespace == "" || f.Namespace == namespace
+	})
+
+	snapshot := make(map[string]Fact)
+	for _, f := range result {
+		key := fmt.Sprintf("%s#%s", f.Namespace, f.FieldName)
+		snapshot[key] = f
+	}
+	return snapshot, nil
+}
+
+// query returns the facts matching keep with timestamps in [StartTime,
+// EndTime], defaulting to the epoch and now like the DynamoDB store, ordered
+// by time and then ID
+func (s *MemoryStore) query(opts QueryOptions, keep func(Fact) bool) []Fact {
+	start := time.Unix(0, 0)
+	if opts.StartTime != nil && !opts.StartTime.IsZero() {
+		start = *opts.StartTime
+	}
+	end := time.Now().UTC()
+	if opts.EndTime != nil && !opts.EndTime.IsZero() {
+		end = *opts.EndTime
+	}
+
+	s.mu.RLock()
+	facts := make([]Fact, 0)
+	for _, f := range s.facts {
+		if !f.Timestamp.Before(start) && !f.Timestamp.After(end) && keep(f) {
+			facts = append(facts, f)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(facts, func(i, j int) bool {
+		a, b := facts[i], facts[j]
+		if !a.Timestamp.Equal(b.Timestamp) {
+			if opts.SortAscending {
+				return a.Timestamp.Before(b.Timestamp)
+			}
+			return a.Timestamp.After(b.Timestamp)
+		}
+		if opts.SortAscending {
+			return a.ID < b.ID
+		}
+		return a.ID > b.ID
+	})
+	return facts
+}
+
+// page returns the page of a query's facts that opts.NextToken and
+// opts.Limit select, with a token for the next page when facts are left
+func (s *MemoryStore) page(operation, fingerprint string, opts QueryOptions, facts []Fact) (*QueryResult, error) {
+	position, err := s.cursors.resumeAfter(operation, fingerprint, opts)
+	if err != nil {
+		return nil, err
+	}
+	if position != "" {
+		t, id, err := schema.ParseSortKey(position)
+		if err != nil {
+			return nil, &StoreError{Operation: operation, Kind: ErrValidation, Err: fmt.Errorf("invalid next token: %w", err)}
+		}
+		facts = facts[sort.Search(len(facts), func(i int) bool {
+			f := facts[i]
+			if opts.SortAscending {
+				return f.Timestamp.After(t) || (f.Timestamp.Equal(t) && f.ID > id)
+			}
+			return f.Timestamp.Before(t) || (f.Timestamp.Equal(t) && f.ID < id)
+		}):]
+	}
+	if opts.Limit == nil || int(*opts.Limit) >= len(facts) {
+		return &QueryResult{Facts: facts}, nil
+	}
+	facts = facts[:*opts.Limit]
+	next, err := s.cursors.encode(fingerprint, memoryKey(&facts[len(facts)-1]))
+	if err != nil {
+		return nil, &StoreError{Operation: operation, Err: fmt.Errorf("encode next token failed: %w", err)}
+	}
+	return &QueryResult{Facts: facts, NextToken: next}, nil
+}
@@ -6,6 +6,8 @@ import (
 	"sort"
 	"sync"
 	"time"
+
+	"github.com/elibdev/notably/schema"
 )
 
 // MockStore implements the Store interface for testing
@@ -116,32 +118,75 @@ func (s *MockStore) PutFact(ctx context.Context, fact *Fact) error {
 	if fact == nil {
 		return &StoreError{
 			Operation: "PutFact",
+			Kind:      ErrValidation,
 			Err:       fmt.Errorf("fact cannot be nil"),
 		}
 	}
 
-	if fact.ID == "" {
+	if err := schema.ValidateID(fact.ID); err != nil {
 		return &StoreError{
 			Operation: "PutFact",
-			Err:       fmt.Errorf("fact ID cannot be empty"),
+			Kind:      ErrValidation,
+			Err:       err,
+		}
+	}
+	if err := checkFactSize(fact); err != nil {
+		return &StoreError{
+			Operation: "PutFact",
+			Kind:      ErrValidation,
+			Err:       err,
 		}
 	}
 
 	if !s.tableCreated {
 		return &StoreError{
 			Operation: "PutFact",
+			Kind:      ErrNotFound,
 			Err:       fmt.Errorf("table not created"),
 		}
 	}
 
 	// Create a deep copy to avoid external modification
 	factCopy := *fact
+	normalizeTombstone(&factCopy)
 	key := fmt.Sprintf("%s#%s#%s", fact.UserID, fact.Timestamp.Format(time.RFC3339Nano), fact.ID)
 	s.facts[key] = factCopy
 
 	return nil
 }
 
+// PutFactsTransactional implements Store.PutFactsTransactional
+func (s *MockStore) PutFactsTransactional(ctx context.Context, facts []*Fact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordCall("PutFactsTransactional")
+
+	if err := s.checkFailure("PutFactsTransactional"); err != nil {
+		return err
+	}
+	if err := validateTransaction(facts); err != nil {
+		return err
+	}
+	if err := checkTransactionSizes(facts); err != nil {
+		return err
+	}
+	if !s.tableCreated {
+		return &StoreError{
+			Operation: "PutFactsTransactional",
+			Kind:      ErrNotFound,
+			Err:       fmt.Errorf("table not created"),
+		}
+	}
+
+	for _, fact := range facts {
+		key := fmt.Sprintf("%s#%s#%s", fact.UserID, fact.Timestamp.Format(time.RFC3339Nano), fact.ID)
+		stored := *fact
+		normalizeTombstone(&stored)
+		s.facts[key] = stored
+	}
+	return nil
+}
+
 // GetFact implements Store.GetFact
 func (s *MockStore) GetFact(ctx context.Context, id string) (*Fact, error) {
 	s.mu.RLock()
@@ -155,6 +200,7 @@ func (s *MockStore) GetFact(ctx context.Context, id string) (*Fact, error) {
 	if !s.tableCreated {
 		return nil, &StoreError{
 			Operation: "GetFact",
+			Kind:      ErrNotFound,
 			Err:       fmt.Errorf("table not created"),
 		}
 	}
@@ -174,6 +220,7 @@ func (s *MockStore) GetFact(ctx context.Context, id string) (*Fact, error) {
 	if latestFact == nil {
 		return nil, &StoreError{
 			Operation: "GetFact",
+			Kind:      ErrNotFound,
 			Err:       fmt.Errorf("fact not found"),
 		}
 	}
@@ -194,6 +241,7 @@ func (s *MockStore) DeleteFact(ctx context.Context, id string) error {
 	if !s.tableCreated {
 		return &StoreError{
 			Operation: "DeleteFact",
+			Kind:      ErrNotFound,
 			Err:       fmt.Errorf("table not created"),
 		}
 	}
@@ -213,14 +261,13 @@ func (s *MockStore) DeleteFact(ctx context.Context, id string) error {
 	if foundFact == nil {
 		return &StoreError{
 			Operation: "DeleteFact",
+			Kind:      ErrNotFound,
 			Err:       fmt.Errorf("fact not found"),
 		}
 	}
 
 	// Create a deletion marker
-	deletedFact := *foundFact
-	deletedFact.IsDeleted = true
-	deletedFact.Timestamp = time.Now()
+	deletedFact := foundFact.Tombstone(id, time.Now().UTC())
 
 	key := fmt.Sprintf("%s#%s#%s", deletedFact.UserID, deletedFact.Timestamp.Format(time.RFC3339Nano), deletedFact.ID)
 	s.facts[key] = deletedFact
@@ -228,6 +275,30 @@ func (s *MockStore) DeleteFact(ctx context.Context, id string) error {
 	return nil
 }
 
+// PurgeFact implements Store.PurgeFact
+func (s *MockStore) PurgeFact(ctx context.Context, fact *Fact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordCall("PurgeFact")
+
+	if err := s.checkFailure("PurgeFact"); err != nil {
+		return err
+	}
+
+	if fact == nil {
+		return &StoreError{
+			Operation: "PurgeFact",
+			Kind:      ErrValidation,
+			Err:       fmt.Errorf("fact cannot be nil"),
+		}
+	}
+
+	key := fmt.Sprintf("%s#%s#%s", fact.UserID, fact.Timestamp.Format(time.RFC3339Nano), fact.ID)
+	delete(s.facts, key)
+
+	return nil
+}
+
 // QueryByField implements Store.QueryByField
 func (s *MockStore) QueryByField(ctx context.Context, namespace, fieldName string, opts QueryOptions) (*QueryResult, error) {
 	s.mu.RLock()
@@ -241,6 +312,7 @@ func (s *MockStore) QueryByField(ctx context.Context, namespace, fieldName strin
 	if !s.tableCreated {
 		return nil, &StoreError{
 			Operation: "QueryByField",
+			Kind:      ErrNotFound,
 			Err:       fmt.Errorf("table not created"),
 		}
 	}
@@ -293,6 +365,7 @@ func (s *MockStore) QueryByTimeRange(ctx context.Context, opts QueryOptions) (*Q
 	if !s.tableCreated {
 		return nil, &StoreError{
 			Operation: "QueryByTimeRange",
+			Kind:      ErrNotFound,
 			Err:       fmt.Errorf("table not created"),
 		}
 	}
@@ -329,6 +402,59 @@ func (s *MockStore) QueryByTimeRange(ctx context.Context, opts QueryOptions) (*Q
 	}, nil
 }
 
+// QueryByActor implements Store.QueryByActor
+func (s *MockStore) QueryByActor(ctx context.Context, actorUserID string, opts QueryOptions) (*QueryResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.recordCall("QueryByActor")
+
+	if err := s.checkFailure("QueryByActor"); err != nil {
+		return nil, err
+	}
+
+	if !s.tableCreated {
+		return nil, &StoreError{
+			Operation: "QueryByActor",
+			Kind:      ErrNotFound,
+			Err:       fmt.Errorf("table not created"),
+		}
+	}
+
+	var results []Fact
+
+	// Filter by actor and time range
+	for _, fact := range s.facts {
+		if fact.ActorUserID != actorUserID {
+			continue
+		}
+		if opts.StartTime != nil && opts.EndTime != nil {
+			if fact.Timestamp.Before(*opts.StartTime) || fact.Timestamp.After(*opts.EndTime) {
+				continue
+			}
+		}
+		results = append(results, fact)
+	}
+
+	// Sort by timestamp
+	sort.Slice(results, func(i, j int) bool {
+		if opts.SortAscending {
+			return results[i].Timestamp.Before(results[j].Timestamp)
+		}
+		return results[i].Timestamp.After(results[j].Timestamp)
+	})
+
+	// Apply limit if provided
+	if opts.Limit != nil && int(*opts.Limit) < len(results) {
+		results = results[:*opts.Limit]
+	}
+
+	// No pagination in mock implementation
+	return &QueryResult{
+		Facts:     results,
+		NextToken: nil,
+	}, nil
+}
+
 // QueryByNamespace implements Store.QueryByNamespace
 func (s *MockStore) QueryByNamespace(ctx context.Context, namespace string, opts QueryOptions) (*QueryResult, error) {
 	s.mu.RLock()
@@ -342,6 +468,7 @@ func (s *MockStore) QueryByNamespace(ctx context.Context, namespace string, opts
 	if !s.tableCreated {
 		return nil, &StoreError{
 			Operation: "QueryByNamespace",
+			Kind:      ErrNotFound,
 			Err:       fmt.Errorf("table not created"),
 		}
 	}
@@ -394,6 +521,7 @@ func (s *MockStore) GetSnapshotAtTime(ctx context.Context, namespace string, at
 	if !s.tableCreated {
 		return nil, &StoreError{
 			Operation: "GetSnapshotAtTime",
+			Kind:      ErrNotFound,
 			Err:       fmt.Errorf("table not created"),
 		}
 	}
@@ -435,7 +563,7 @@ func (s *MockStore) GetSnapshotAtTime(ctx context.Context, namespace string, at
 	snapshot := make(map[string]Fact)
 	for key, facts := range fieldFactMap {
 		// Facts are already sorted newest first
-		if len(facts) > 0 && !facts[0].IsDeleted {
+		if len(facts) > 0 {
 			snapshot[key] = facts[0]
 		}
 	}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// Observer receives the duration and outcome of every DynamoDB call made by
+// a DynamoDBStore, e.g. to export metrics.
+type Observer interface {
+	ObserveOperation(ctx context.Context, operation string, duration time.Duration, err error)
+}
+
+// dynamoDBAPI is the subset of the DynamoDB client used by DynamoDBStore
+type dynamoDBAPI interface {
+	CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error)
+	DeleteTable(ctx context.Context, params *dynamodb.DeleteTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteTableOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	UpdateContinuousBackups(ctx context.Context, params *dynamodb.UpdateContinuousBackupsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateContinuousBackupsOutput, error)
+}
+
+// observedAPI times each call to the wrapped DynamoDB API
+type observedAPI struct {
+	api      dynamoDBAPI
+	observer Observer
+}
+
+func (o *observedAPI) done(ctx context.Context, operation string, start time.Time, err error) {
+	o.observer.ObserveOperation(ctx, operation, time.Since(start), err)
+}
+
+func (o *observedAPI) CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	start := time.Now()
+	out, err := o.api.CreateTable(ctx, params, optFns...)
+	o.done(ctx, "CreateTable", start, err)
+	return out, err
+}
+
+func (o *observedAPI) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	start := time.Now()
+	out, err := o.api.PutItem(ctx, params, optFns...)
+	o.done(ctx, "PutItem", start, err)
+	return out, err
+}
+
+func (o *observedAPI) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	start := time.Now()
+	out, err := o.api.Query(ctx, params, optFns...)
+	o.done(ctx, "Query", start, err)
+	return out, err
+}
+
+func (o *observedAPI) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	start := time.Now()
+	out, err := o.api.DeleteItem(ctx, params, optFns...)
+	o.done(ctx, "DeleteItem", start, err)
+	return out, err
+}
+
+func (o *observedAPI) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	start := time.Now()
+	out, err := o.api.TransactWriteItems(ctx, params, optFns...)
+	o.done(ctx, "TransactWriteItems", start, err)
+	return out, err
+}
+
+func (o *observedAPI) DeleteTable(ctx context.Context, params *dynamodb.DeleteTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteTableOutput, error) {
+	start := time.Now()
+	out, err := o.api.DeleteTable(ctx, params, optFns...)
+	o.done(ctx, "DeleteTable", start, err)
+	return out, err
+}
+
+func (o *observedAPI) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	start := time.Now()
+	out, err := o.api.DescribeTable(ctx, params, optFns...)
+	o.done(ctx, "DescribeTable", start, err)
+	return out, err
+}
+
+func (o *observedAPI) UpdateContinuousBackups(ctx context.Context, params *dynamodb.UpdateContinuousBackupsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateContinuousBackupsOutput, error) {
+	start := time.Now()
+	out, err := o.api.UpdateContinuousBackups(ctx, params, optFns...)
+	o.done(ctx, "UpdateContinuousBackups", start, err)
+	return out, err
+}
+
+// RetryObserver may be implemented by an Observer to be told about every
+// throttled call the store retries
+type RetryObserver interface {
+	ObserveRetry(ctx context.Context, operation string, attempt int, delay time.Duration, err error)
+}
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultConflictWindow is how close in time two versions of a field
+// written in different regions must be to count as a conflict. Global
+// Tables usually replicate within a second, so a region writing later than
+// this has most likely seen the other region's version.
+const DefaultConflictWindow = 2 * time.Second
+
+// Supersedes reports whether f is a later version than other. Versions are
+// ordered by timestamp; versions with the same timestamp, as concurrent
+// writes to a Global Table from different regions can have, are ordered by
+// region and then ID, so every region picks the same winner.
+func (f Fact) Supersedes(other Fact) bool {
+	if !f.Timestamp.Equal(other.Timestamp) {
+		return f.Timestamp.After(other.Timestamp)
+	}
+	if f.Region != other.Region {
+		return f.Region > other.Region
+	}
+	return f.ID > other.ID
+}
+
+// Conflict is a pair of versions of one field written in different regions
+// so close together that neither region saw the other's write first.
+// Winner is the version snapshots show.
+type Conflict struct {
+	Winner Fact
+	Loser  Fact
+}
+
+// ConflictObserver is told about every conflict a snapshot resolves
+type ConflictObserver interface {
+	ObserveConflict(ctx context.Context, conflict Conflict)
+}
+
+// conflicting reports whether two versions of a field are a conflict
+func conflicting(a, b Fact, window time.Duration) bool {
+	if a.Region == "" || b.Region == "" || a.Region == b.Region {
+		return false
+	}
+	d := a.Timestamp.Sub(b.Timestamp)
+	if d < 0 {
+		d = -d
+	}
+	return d < window
+}
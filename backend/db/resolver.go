@@ -0,0 +1,71 @@
+package db
+
+import (
+	"fmt"
+)
+
+// Storage modes select how user tables map onto DynamoDB tables
+const (
+	// StorageModeShared keeps every user table in the base DynamoDB table
+	StorageModeShared = "shared"
+	// StorageModeIsolated gives every user table its own DynamoDB table
+	StorageModeIsolated = "isolated"
+)
+
+// TableResolver decides which DynamoDB table stores the rows of a user table.
+// Table definitions always live in the base table; only row facts are routed.
+type TableResolver interface {
+	// TableFor returns the DynamoDB table name holding rows of the user's table
+	TableFor(userID, table string) string
+
+	// Isolated reports whether user tables get their own DynamoDB tables,
+	// which are created and deleted together with the user table
+	Isolated() bool
+}
+
+// SharedTableResolver stores all rows in a single DynamoDB table
+type SharedTableResolver struct {
+	TableName string
+}
+
+// TableFor implements TableResolver
+func (r SharedTableResolver) TableFor(userID, table string) string {
+	return r.TableName
+}
+
+// Isolated implements TableResolver
+func (r SharedTableResolver) Isolated() bool {
+	return false
+}
+
+// IsolatedTableResolver maps each user table to "<base>.<userID>.<table>"
+type IsolatedTableResolver struct {
+	BaseTable string
+}
+
+// TableFor implements TableResolver
+func (r IsolatedTableResolver) TableFor(userID, table string) string {
+	return fmt.Sprintf("%s.%s.%s", r.BaseTable, userID, table)
+}
+
+// Isolated implements TableResolver
+func (r IsolatedTableResolver) Isolated() bool {
+	return true
+}
+
+// NewTableResolver returns the resolver for a storage mode. An empty mode is
+// treated as shared.
+func NewTableResolver(mode, baseTable string) (TableResolver, error) {
+	switch mode {
+	case "", StorageModeShared:
+		return SharedTableResolver{TableName: baseTable}, nil
+	case StorageModeIsolated:
+		return IsolatedTableResolver{BaseTable: baseTable}, nil
+	default:
+		return nil, &StoreError{
+			Operation: "NewTableResolver",
+			Kind:      ErrValidation,
+			Err:       fmt.Errorf("unknown storage mode %q", mode),
+		}
+	}
+}
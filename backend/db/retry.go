@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/elibdev/notably/pkg/backoff"
+)
+
+// retryingAPI retries throttled data-plane calls to the wrapped DynamoDB API
+// with jittered exponential backoff. Table management calls are not retried.
+type retryingAPI struct {
+	dynamoDBAPI
+	policy   backoff.Policy
+	observer RetryObserver
+}
+
+// withRetry wraps api so throttled calls are retried under p
+func withRetry(api dynamoDBAPI, p backoff.Policy, o Observer) dynamoDBAPI {
+	if p.MaxAttempts == 1 {
+		return api
+	}
+	r := &retryingAPI{dynamoDBAPI: api, policy: p}
+	r.observer, _ = o.(RetryObserver)
+	return r
+}
+
+func isThrottled(err error) bool {
+	return errors.Is(err, ErrThrottled) || classify(err) == ErrThrottled
+}
+
+func (r *retryingAPI) retry(ctx context.Context, operation string, fn func() error) error {
+	var onRetry func(int, time.Duration, error)
+	if r.observer != nil {
+		onRetry = func(attempt int, delay time.Duration, err error) {
+			r.observer.ObserveRetry(ctx, operation, attempt, delay, err)
+		}
+	}
+	return r.policy.Retry(ctx, isThrottled, onRetry, fn)
+}
+
+func (r *retryingAPI) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (out *dynamodb.PutItemOutput, err error) {
+	err = r.retry(ctx, "PutItem", func() error {
+		out, err = r.dynamoDBAPI.PutItem(ctx, params, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingAPI) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (out *dynamodb.QueryOutput, err error) {
+	err = r.retry(ctx, "Query", func() error {
+		out, err = r.dynamoDBAPI.Query(ctx, params, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingAPI) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (out *dynamodb.DeleteItemOutput, err error) {
+	err = r.retry(ctx, "DeleteItem", func() error {
+		out, err = r.dynamoDBAPI.DeleteItem(ctx, params, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingAPI) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (out *dynamodb.TransactWriteItemsOutput, err error) {
+	err = r.retry(ctx, "TransactWriteItems", func() error {
+		out, err = r.dynamoDBAPI.TransactWriteItems(ctx, params, optFns...)
+		return err
+	})
+	return out, err
+}
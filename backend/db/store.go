@@ -2,11 +2,15 @@ package db
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/backoff"
 )
 
 // DataType represents the type of data stored in a fact
@@ -17,42 +21,118 @@ const (
 	DataTypeNumber  DataType = "number"
 	DataTypeBoolean DataType = "boolean"
 	DataTypeJSON    DataType = "json"
+	// DataTypeDeleted marks tombstones; see Fact.Tombstone
+	DataTypeDeleted DataType = dynamo.DataTypeDeleted
 )
 
 // ColumnDefinition represents a column in a table with its type
 type ColumnDefinition struct {
 	Name     string `json:"name"`
 	DataType string `json:"dataType"`
+	// Encrypted columns are stored sealed under the server's master key
+	Encrypted bool `json:"encrypted,omitempty" dynamodbav:",omitempty"`
+	// Required columns must be present in every new row
+	Required bool `json:"required,omitempty" dynamodbav:",omitempty"`
+	// Nullable columns accept null in place of a value of their type
+	Nullable bool `json:"nullable,omitempty" dynamodbav:",omitempty"`
+	// Default fills the column of a new row that leaves it out
+	Default interface{} `json:"default,omitempty" dynamodbav:",omitempty"`
+	// Enum lists the allowed values of an enum column
+	Enum []string `json:"enum,omitempty" dynamodbav:",omitempty"`
+	// References names the table whose row IDs a reference column holds
+	References string `json:"references,omitempty" dynamodbav:",omitempty"`
+	// Precision and Scale bound the total digits and the digits after the
+	// point of a decimal column; zero precision means no bound
+	Precision int `json:"precision,omitempty" dynamodbav:",omitempty"`
+	Scale     int `json:"scale,omitempty" dynamodbav:",omitempty"`
+	// OnDelete is what deleting a referenced row does to rows referencing it
+	// through this column: "restrict" blocks the delete, "cascade" deletes
+	// them too, and empty leaves them in place
+	OnDelete string `json:"onDelete,omitempty" dynamodbav:",omitempty"`
 }
 
 // Fact represents a single piece of data with versioning
 type Fact struct {
-	ID        string             `json:"id"`
-	Timestamp time.Time          `json:"timestamp"`
-	Namespace string             `json:"namespace"`
-	FieldName string             `json:"fieldName"`
-	DataType  DataType           `json:"dataType"`
-	Value     string             `json:"value"`
-	UserID    string             `json:"userId"`
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Namespace string    `json:"namespace"`
+	FieldName string    `json:"fieldName"`
+	DataType  DataType  `json:"dataType"`
+	// Value is the JSON encoding of the fact's value, so numbers, booleans
+	// and documents keep their types; see EncodeValue and StringValue
+	Value json.RawMessage `json:"value"`
+
+	UserID string `json:"userId"`
+	// IsDeleted is set on tombstones, and only on them
 	IsDeleted bool               `json:"isDeleted"`
 	Columns   []ColumnDefinition `json:"columns,omitempty"`
+	// Region is the AWS region the fact was written in, when known
+	Region string `json:"region,omitempty"`
+	// ActorUserID and APIKeyID record who wrote the fact, when known; see
+	// WithActor
+	ActorUserID string `json:"actorUserId,omitempty"`
+	APIKeyID    string `json:"apiKeyId,omitempty"`
+	// ValidTime is when the fact takes effect, when it differs from
+	// Timestamp, the time it was written
+	ValidTime *time.Time `json:"validTime,omitempty"`
+	// ClientDated is set when the writer gave Timestamp rather than taking
+	// it from the server's clock
+	ClientDated bool `json:"clientDated,omitempty"`
+	// CreatedAt is when the row a fact is a version of was created, carried
+	// from version to version; facts written before rows recorded it have none
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+}
+
+// Tombstone returns the fact deleting f's field at a time. Tombstones have
+// DataTypeDeleted, IsDeleted set and no value; stores read the deletions
+// written before they had their own data type as tombstones too.
+func (f Fact) Tombstone(id string, at time.Time) Fact {
+	return Fact{
+		ID:        id,
+		Timestamp: at,
+		Namespace: f.Namespace,
+		FieldName: f.FieldName,
+		DataType:  DataTypeDeleted,
+		UserID:    f.UserID,
+		IsDeleted: true,
+	}
+}
+
+// normalizeTombstone rewrites the older encodings of deletions as
+// tombstones: facts with IsDeleted set but another data type, and JSON facts
+// without a value, which the server wrote to delete rows
+func normalizeTombstone(f *Fact) {
+	null := len(f.Value) == 0 || string(f.Value) == "null"
+	if f.IsDeleted || f.DataType == DataTypeDeleted || (f.DataType == DataTypeJSON && null) {
+		f.DataType = DataTypeDeleted
+		f.IsDeleted = true
+		f.Value = nil
+	}
 }
 
 // QueryOptions provides filtering and pagination options for queries
 type QueryOptions struct {
-	StartTime     *time.Time
-	EndTime       *time.Time
-	Limit         *int32
+	StartTime *time.Time
+	EndTime   *time.Time
+	Limit     *int32
+	// NextToken is the token of the previous page's result. It is only valid
+	// for a query with the same options apart from Limit.
 	NextToken     *string
 	SortAscending bool
 }
 
 // QueryResult contains the results of a query operation
 type QueryResult struct {
-	Facts     []Fact
+	Facts []Fact
+	// NextToken is an opaque, signed token for the next page, nil on the
+	// last one
 	NextToken *string
 }
 
+// MaxTransactionItems is the most facts PutFactsTransactional accepts, the
+// DynamoDB TransactWriteItems limit
+const MaxTransactionItems = 100
+
 // Store defines the interface for DynamoDB operations
 type Store interface {
 	// Schema operations
@@ -63,13 +143,23 @@ type Store interface {
 	PutFact(ctx context.Context, fact *Fact) error
 	GetFact(ctx context.Context, id string) (*Fact, error)
 	DeleteFact(ctx context.Context, id string) error
+	PurgeFact(ctx context.Context, fact *Fact) error
+	// PutFactsTransactional stores up to MaxTransactionItems facts so that
+	// either all or none of them are written. A cancelled transaction is
+	// reported as a *TransactionError.
+	PutFactsTransactional(ctx context.Context, facts []*Fact) error
 
 	// Query operations
 	QueryByField(ctx context.Context, namespace, fieldName string, opts QueryOptions) (*QueryResult, error)
 	QueryByTimeRange(ctx context.Context, opts QueryOptions) (*QueryResult, error)
 	QueryByNamespace(ctx context.Context, namespace string, opts QueryOptions) (*QueryResult, error)
+	// QueryByActor returns the facts of the store's user that an actor
+	// wrote, whatever their namespace
+	QueryByActor(ctx context.Context, actorUserID string, opts QueryOptions) (*QueryResult, error)
 
-	// Snapshot operations
+	// Snapshot operations. A snapshot holds the latest version of each
+	// field, keyed by "namespace#fieldName"; for a field deleted by then
+	// that is its tombstone.
 	GetSnapshotAtTime(ctx context.Context, namespace string, at time.Time) (map[string]Fact, error)
 }
 
@@ -78,12 +168,38 @@ type Config struct {
 	TableName    string
 	UserID       string
 	DynamoClient *dynamodb.Client
+
+	// Logger receives store diagnostics; slog.Default() is used when nil
+	Logger *slog.Logger
+
+	// Observer, if set, is told about every DynamoDB call the store makes
+	Observer Observer
+
+	// Retry controls how throttled reads and writes are retried; zero fields
+	// use backoff.DefaultPolicy and MaxAttempts 1 disables retries
+	Retry backoff.Policy
+
+	// CursorSecret signs the pagination tokens of query results. Stores
+	// sharing a secret accept each other's tokens; when empty a random
+	// secret is used, so tokens only work with the store that issued them.
+	// CursorSecretFromEnv reads it from NOTABLY_CURSOR_SECRET.
+	CursorSecret []byte
+
+	// Table tunes the billing, backups, encryption and tags of the table
+	// CreateTable creates
+	Table dynamo.TableOptions
+
+	// Autoscaler applies Table.AutoScaling, e.g. dynamo.NewAutoscaler
+	Autoscaler dynamo.Autoscaler
 }
 
 // StoreError represents errors that can occur in the Store
 type StoreError struct {
 	Operation string
-	Err       error
+	// Kind is one of ErrNotFound, ErrConditionFailed, ErrThrottled,
+	// ErrValidation or ErrOverCapacity. When nil it is derived from Err.
+	Kind error
+	Err  error
 }
 
 func (e *StoreError) Error() string {
@@ -92,16 +208,5 @@ func (e *StoreError) Error() string {
 
 // IsNotFound returns true if the error indicates a record was not found
 func IsNotFound(err error) bool {
-	if err == nil {
-		return false
-	}
-	var storeErr *StoreError
-	if e, ok := err.(*StoreError); ok {
-		storeErr = e
-	} else {
-		return false
-	}
-
-	_, ok := storeErr.Err.(*types.ResourceNotFoundException)
-	return ok
+	return errors.Is(err, ErrNotFound)
 }
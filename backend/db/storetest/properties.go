@@ -0,0 +1,509 @@
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/db"
+)
+
+// Property defaults
+const (
+	defaultRuns  = 20
+	defaultSteps = 100
+)
+
+// The facts of the property-based tests draw from few IDs, namespaces and
+// fields, so versions often replace and shadow one another. Their
+// timestamps fall on whole seconds of a five minute span an hour ago, so
+// several share a timestamp and all precede the deletion markers, which
+// stores write at the current time.
+var (
+	propertyIDs        = []string{"p1", "p2", "p3", "p4", "p5", "p6"}
+	propertyNamespaces = []string{"prop-a", "prop-b", "prop-a/sub"}
+	propertyFields     = []string{"f1", "f2", "f3"}
+	// The empty actor stands for facts that do not record one
+	propertyActors = []string{"", "alice", "bob"}
+)
+
+const propertySpan = 300 // seconds
+
+// testProperties checks random sequences of puts, transactions, deletes
+// and purges against a model of the store, comparing every read with it
+func testProperties(t *testing.T, newStore func(t *testing.T) db.Store, opts Options) {
+	runs, steps := opts.Runs, opts.Steps
+	if runs <= 0 {
+		runs = defaultRuns
+	}
+	if steps <= 0 {
+		steps = defaultSteps
+	}
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	for i := 0; i < runs; i++ {
+		// Each run has a seed of its own, so a failing one replays alone
+		runSeed := seed + int64(i)
+		t.Run(fmt.Sprintf("seed %d", runSeed), func(t *testing.T) {
+			ctx := context.Background()
+			store := newStore(t)
+			require.NoError(t, store.CreateTable(ctx), "CreateTable should succeed")
+			defer func() {
+				assert.NoError(t, store.DeleteTable(ctx), "DeleteTable should succeed")
+			}()
+
+			r := &propertyRun{
+				t:        t,
+				ctx:      ctx,
+				store:    store,
+				seed:     runSeed,
+				rng:      rand.New(rand.NewSource(runSeed)),
+				base:     time.Now().UTC().Add(-time.Hour).Truncate(time.Second),
+				paginate: !opts.SkipPagination,
+				facts:    make(map[string]db.Fact),
+			}
+			for step := 0; step < steps; step++ {
+				r.step()
+			}
+			r.checkAll()
+		})
+	}
+}
+
+// propertyRun is one random sequence of operations on a store and the
+// model of what the store should hold
+type propertyRun struct {
+	t        *testing.T
+	ctx      context.Context
+	store    db.Store
+	seed     int64
+	rng      *rand.Rand
+	base     time.Time
+	paginate bool
+
+	// facts is the model: every fact version, keyed "timestamp#id" like
+	// the sort keys of the DynamoDB store
+	facts  map[string]db.Fact
+	values int
+	log    []string
+}
+
+func versionKey(f db.Fact) string {
+	return f.Timestamp.UTC().Format(time.RFC3339Nano) + "#" + f.ID
+}
+
+// describe renders a fact for comparisons, with what the suite checks of it
+func describe(f db.Fact) string {
+	value := string(f.Value)
+	if f.IsDeleted {
+		value = "deleted"
+	}
+	return fmt.Sprintf("%s %s/%s %s", versionKey(f), f.Namespace, f.FieldName, value)
+}
+
+func (r *propertyRun) logf(format string, args ...interface{}) {
+	r.log = append(r.log, fmt.Sprintf(format, args...))
+}
+
+// failure describes the state of a failing run and how to replay it
+func (r *propertyRun) failure(what string) string {
+	recent := r.log
+	if len(recent) > 10 {
+		recent = recent[len(recent)-10:]
+	}
+	return fmt.Sprintf("%s after %d operations (replay with storetest.Options{Seed: %d, Runs: 1}); the last were:\n\t%s",
+		what, len(r.log), r.seed, strings.Join(recent, "\n\t"))
+}
+
+// equal fails the run unless the store's answer got is the model's want
+func (r *propertyRun) equal(want, got interface{}, what string) {
+	r.t.Helper()
+	if !assert.ObjectsAreEqual(want, got) {
+		require.Equal(r.t, want, got, r.failure(what))
+	}
+}
+
+// noError fails the run if an operation the model expects to succeed failed
+func (r *propertyRun) noError(err error, what string) {
+	r.t.Helper()
+	if err != nil {
+		require.NoError(r.t, err, r.failure(what))
+	}
+}
+
+func (r *propertyRun) errorIs(err, target error, what string) {
+	r.t.Helper()
+	if !errors.Is(err, target) {
+		require.ErrorIs(r.t, err, target, r.failure(what))
+	}
+}
+
+func (r *propertyRun) pick(from []string) string {
+	return from[r.rng.Intn(len(from))]
+}
+
+func (r *propertyRun) randomTime() time.Time {
+	// A little beyond the span, so ranges sometimes cover all of it
+	return r.base.Add(time.Duration(r.rng.Intn(propertySpan+20)-10) * time.Second)
+}
+
+// versions returns the model's version keys in order, so picking from them
+// depends on the seed alone
+func (r *propertyRun) versions() []string {
+	keys := make([]string, 0, len(r.facts))
+	for k := range r.facts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r *propertyRun) newFact() db.Fact {
+	r.values++
+	f := db.Fact{
+		ID:        r.pick(propertyIDs),
+		Timestamp: r.base.Add(time.Duration(r.rng.Intn(propertySpan)) * time.Second),
+		Namespace: r.pick(propertyNamespaces),
+		FieldName: r.pick(propertyFields),
+		DataType:  db.DataTypeString,
+		Value:     db.StringValue(fmt.Sprintf("v%d", r.values)),
+		UserID:    "test-user",
+	}
+	if actor := r.pick(propertyActors); actor != "" {
+		f.ActorUserID, f.APIKeyID = actor, actor+"-key"
+	}
+	// Some take effect at another time than they are written
+	if r.rng.Intn(4) == 0 {
+		valid := r.base.Add(time.Duration(r.rng.Intn(propertySpan)) * time.Second)
+		f.ValidTime = &valid
+	}
+	// Some writes reuse the timestamp and ID of a version, replacing it
+	if keys := r.versions(); len(keys) > 0 && r.rng.Intn(5) == 0 {
+		old := r.facts[keys[r.rng.Intn(len(keys))]]
+		f.ID, f.Timestamp = old.ID, old.Timestamp
+	}
+	return f
+}
+
+func (r *propertyRun) step() {
+	r.t.Helper()
+	switch n := r.rng.Intn(100); {
+	case n < 35:
+		r.put()
+	case n < 45:
+		r.putTransactional()
+	case n < 55:
+		r.delete()
+	case n < 62:
+		r.purge()
+	default:
+		r.read()
+	}
+}
+
+func (r *propertyRun) put() {
+	r.t.Helper()
+	f := r.newFact()
+	r.logf("PutFact %s", describe(f))
+	r.noError(r.store.PutFact(r.ctx, &f), "PutFact")
+	r.facts[versionKey(f)] = f
+}
+
+// putTransactional writes a few facts at once, which must all be written
+// unless one of them has the key of an existing version or of another
+func (r *propertyRun) putTransactional() {
+	r.t.Helper()
+	facts := make([]*db.Fact, 1+r.rng.Intn(4))
+	seen := make(map[string]bool, len(facts))
+	exists, repeated := false, false
+	names := make([]string, len(facts))
+	for i := range facts {
+		f := r.newFact()
+		if i > 0 && r.rng.Intn(10) == 0 {
+			f.ID, f.Timestamp = facts[0].ID, facts[0].Timestamp
+		}
+		key := versionKey(f)
+		_, found := r.facts[key]
+		exists = exists || found
+		repeated = repeated || seen[key]
+		seen[key] = true
+		facts[i], names[i] = &f, describe(f)
+	}
+	r.logf("PutFactsTransactional %s", strings.Join(names, ", "))
+
+	err := r.store.PutFactsTransactional(r.ctx, facts)
+	switch {
+	case repeated:
+		// DynamoDB refuses a transaction writing an item twice as invalid
+		// rather than as a failed condition, so only the failure is checked
+		if err == nil {
+			require.Error(r.t, err, r.failure("PutFactsTransactional writing a version twice"))
+		}
+	case exists:
+		r.errorIs(err, db.ErrConditionFailed, "PutFactsTransactional replacing a version")
+	default:
+		r.noError(err, "PutFactsTransactional")
+		for _, f := range facts {
+			r.facts[versionKey(*f)] = *f
+		}
+	}
+}
+
+// latest returns the model's latest version of the fact with an ID
+func (r *propertyRun) latest(id string) (db.Fact, bool) {
+	var latest db.Fact
+	found := false
+	for _, f := range r.facts {
+		if f.ID == id && (!found || f.Timestamp.After(latest.Timestamp)) {
+			latest, found = f, true
+		}
+	}
+	return latest, found
+}
+
+// delete deletes a fact, which must leave a deletion marker on the field
+// of its latest version. Stores date markers themselves, so the marker is
+// read back from the store before the model takes it.
+func (r *propertyRun) delete() {
+	r.t.Helper()
+	id := r.pick(propertyIDs)
+	r.logf("DeleteFact %s", id)
+	latest, found := r.latest(id)
+	before := time.Now().UTC()
+	err := r.store.DeleteFact(r.ctx, id)
+	if !found {
+		r.errorIs(err, db.ErrNotFound, "DeleteFact of a missing fact")
+		return
+	}
+	r.noError(err, "DeleteFact")
+
+	after := time.Now().UTC()
+	result, err := r.store.QueryByField(r.ctx, latest.Namespace, latest.FieldName, db.QueryOptions{StartTime: &before, EndTime: &after})
+	r.noError(err, "QueryByField for the deletion marker")
+	if len(result.Facts) != 1 || result.Facts[0].ID != id || !result.Facts[0].IsDeleted || result.Facts[0].DataType != db.DataTypeDeleted || len(result.Facts[0].Value) > 0 {
+		got := make([]string, len(result.Facts))
+		for i, f := range result.Facts {
+			got[i] = describe(f)
+		}
+		require.Fail(r.t, "DeleteFact must write one tombstone with the fact's ID", r.failure(fmt.Sprintf("found %v", got)))
+	}
+	marker := result.Facts[0]
+	r.facts[versionKey(marker)] = marker
+}
+
+func (r *propertyRun) purge() {
+	r.t.Helper()
+	keys := r.versions()
+	if len(keys) == 0 {
+		r.put()
+		return
+	}
+	f := r.facts[keys[r.rng.Intn(len(keys))]]
+	r.logf("PurgeFact %s", describe(f))
+	r.noError(r.store.PurgeFact(r.ctx, &f), "PurgeFact")
+	delete(r.facts, versionKey(f))
+}
+
+// read checks one random read of the store against the model
+func (r *propertyRun) read() {
+	r.t.Helper()
+	switch r.rng.Intn(6) {
+	case 0:
+		r.checkGetFact(r.pick(propertyIDs))
+	case 1:
+		ns, field := r.pick(propertyNamespaces), r.pick(propertyFields)
+		r.checkQuery("QueryByField "+ns+"/"+field, r.queryOptions(), func(opts db.QueryOptions) (*db.QueryResult, error) {
+			return r.store.QueryByField(r.ctx, ns, field, opts)
+		}, func(f db.Fact) bool { return f.Namespace == ns && f.FieldName == field })
+	case 2:
+		ns := r.pick(propertyNamespaces)
+		r.checkQuery("QueryByNamespace "+ns, r.queryOptions(), func(opts db.QueryOptions) (*db.QueryResult, error) {
+			return r.store.QueryByNamespace(r.ctx, ns, opts)
+		}, func(f db.Fact) bool { return f.Namespace == ns })
+	case 3:
+		r.checkQuery("QueryByTimeRange", r.queryOptions(), func(opts db.QueryOptions) (*db.QueryResult, error) {
+			return r.store.QueryByTimeRange(r.ctx, opts)
+		}, func(db.Fact) bool { return true })
+	case 4:
+		actor := r.pick(propertyActors[1:])
+		r.checkQuery("QueryByActor "+actor, r.queryOptions(), func(opts db.QueryOptions) (*db.QueryResult, error) {
+			return r.store.QueryByActor(r.ctx, actor, opts)
+		}, func(f db.Fact) bool { return f.ActorUserID == actor })
+	default:
+		ns := ""
+		if r.rng.Intn(2) == 0 {
+			ns = r.pick(propertyNamespaces)
+		}
+		at := time.Now().UTC()
+		if r.rng.Intn(2) == 0 {
+			at = r.randomTime()
+		}
+		r.checkSnapshot(ns, at)
+	}
+}
+
+// checkAll compares everything the store holds with the model
+func (r *propertyRun) checkAll() {
+	r.t.Helper()
+	for _, id := range propertyIDs {
+		r.checkGetFact(id)
+	}
+	all := func(opts db.QueryOptions) (*db.QueryResult, error) { return r.store.QueryByTimeRange(r.ctx, opts) }
+	r.checkQuery("QueryByTimeRange", db.QueryOptions{SortAscending: true}, all, func(db.Fact) bool { return true })
+	for _, ns := range propertyNamespaces {
+		ns := ns
+		r.checkQuery("QueryByNamespace "+ns, db.QueryOptions{}, func(opts db.QueryOptions) (*db.QueryResult, error) {
+			return r.store.QueryByNamespace(r.ctx, ns, opts)
+		}, func(f db.Fact) bool { return f.Namespace == ns })
+		r.checkSnapshot(ns, time.Now().UTC())
+	}
+	r.checkSnapshot("", time.Now().UTC())
+}
+
+func (r *propertyRun) checkGetFact(id string) {
+	r.t.Helper()
+	r.logf("GetFact %s", id)
+	got, err := r.store.GetFact(r.ctx, id)
+	want, found := r.latest(id)
+	if !found {
+		r.errorIs(err, db.ErrNotFound, "GetFact of a missing fact")
+		return
+	}
+	r.noError(err, "GetFact")
+	r.equal(describe(want), describe(*got), "GetFact "+id+" must return its latest version")
+}
+
+// queryOptions returns a random time range and order, and a page size
+// when the store paginates
+func (r *propertyRun) queryOptions() db.QueryOptions {
+	opts := db.QueryOptions{SortAscending: r.rng.Intn(2) == 0}
+	start, end := r.randomTime(), r.randomTime()
+	if end.Before(start) {
+		start, end = end, start
+	}
+	if r.rng.Intn(2) == 0 {
+		opts.StartTime = &start
+	}
+	if r.rng.Intn(2) == 0 {
+		opts.EndTime = &end
+	}
+	if r.paginate && r.rng.Intn(2) == 0 {
+		opts.Limit = aws.Int32(int32(1 + r.rng.Intn(4)))
+	}
+	return opts
+}
+
+// checkQuery runs a query, following its pages, and compares its facts
+// with the model's facts that keep selects in the time range, ordered by
+// timestamp and then ID
+func (r *propertyRun) checkQuery(name string, opts db.QueryOptions, query func(db.QueryOptions) (*db.QueryResult, error), keep func(db.Fact) bool) {
+	r.t.Helper()
+	r.logf("%s %s", name, describeOptions(opts))
+	start, end := time.Unix(0, 0), time.Now().UTC()
+	if opts.StartTime != nil {
+		start = *opts.StartTime
+	}
+	if opts.EndTime != nil {
+		end = *opts.EndTime
+	}
+	var facts []db.Fact
+	for _, f := range r.facts {
+		if !f.Timestamp.Before(start) && !f.Timestamp.After(end) && keep(f) {
+			facts = append(facts, f)
+		}
+	}
+	sort.Slice(facts, func(i, j int) bool {
+		a, b := facts[i], facts[j]
+		if !opts.SortAscending {
+			a, b = b, a
+		}
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.Before(b.Timestamp)
+		}
+		return a.ID < b.ID
+	})
+	want := make([]string, len(facts))
+	for i, f := range facts {
+		want[i] = describe(f)
+	}
+
+	got := make([]string, 0, len(want))
+	for pages := 0; ; pages++ {
+		if pages > len(want)+1 {
+			require.Fail(r.t, "the query does not run out of pages", r.failure(name))
+		}
+		result, err := query(opts)
+		r.noError(err, name)
+		if opts.Limit != nil && len(result.Facts) > int(*opts.Limit) {
+			require.Fail(r.t, "the page is larger than the limit", r.failure(name))
+		}
+		for _, f := range result.Facts {
+			got = append(got, describe(f))
+		}
+		if result.NextToken == nil {
+			break
+		}
+		opts.NextToken = result.NextToken
+	}
+	r.equal(want, got, name+" must return the facts in its range in order")
+}
+
+func describeOptions(opts db.QueryOptions) string {
+	var parts []string
+	if opts.StartTime != nil {
+		parts = append(parts, "from "+opts.StartTime.Format(time.RFC3339))
+	}
+	if opts.EndTime != nil {
+		parts = append(parts, "to "+opts.EndTime.Format(time.RFC3339))
+	}
+	if opts.SortAscending {
+		parts = append(parts, "ascending")
+	}
+	if opts.Limit != nil {
+		parts = append(parts, fmt.Sprintf("pages of %d", *opts.Limit))
+	}
+	return strings.Join(parts, " ")
+}
+
+// checkSnapshot compares a snapshot with the model's latest version of each
+// field as of at, leaving out deleted fields. Of versions with the same
+// timestamp the one with the greatest ID is the latest.
+func (r *propertyRun) checkSnapshot(namespace string, at time.Time) {
+	r.t.Helper()
+	r.logf("GetSnapshotAtTime %q %s", namespace, at.Format(time.RFC3339))
+	latest := make(map[string]db.Fact)
+	for _, f := range r.facts {
+		if (namespace != "" && f.Namespace != namespace) || f.Timestamp.After(at) {
+			continue
+		}
+		key := f.Namespace + "#" + f.FieldName
+		l, ok := latest[key]
+		if !ok || f.Timestamp.After(l.Timestamp) || (f.Timestamp.Equal(l.Timestamp) && f.ID > l.ID) {
+			latest[key] = f
+		}
+	}
+	want := make(map[string]string)
+	for key, f := range latest {
+		want[key] = describe(f)
+	}
+
+	snapshot, err := r.store.GetSnapshotAtTime(r.ctx, namespace, at)
+	r.noError(err, "GetSnapshotAtTime")
+	got := make(map[string]string, len(snapshot))
+	for key, f := range snapshot {
+		got[key] = describe(f)
+	}
+	r.equal(want, got, "GetSnapshotAtTime must hold the latest version of each field, tombstones included")
+}
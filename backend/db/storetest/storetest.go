@@ -0,0 +1,529 @@
+// Package storetest is the conformance suite of db.Store implementations.
+// Run checks a store against the semantics of db.MemoryStore and
+// db.DynamoDBStore: fixed examples of CRUD, queries and snapshots, then
+// random sequences of writes checked against a model of the store, so a
+// new backend can show it behaves like the existing ones:
+//
+//	func TestPostgresStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) db.Store {
+//			return postgres.NewStore(testDB(t), "test-user")
+//		}, storetest.Options{})
+//	}
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/schema"
+)
+
+// Options tune the suite to the store under test
+type Options struct {
+	// SkipPagination skips the tests of paging with Limit and NextToken,
+	// for stores that return every result at once
+	SkipPagination bool
+	// SkipProperties skips the property-based tests, for stores such as
+	// db.MockStore that only approximate the semantics
+	SkipProperties bool
+	// Seed seeds the property-based tests; zero picks one from the clock.
+	// Failures report the seed, so setting it replays them.
+	Seed int64
+	// Runs is how many random sequences of operations the property-based
+	// tests check, 20 by default, and Steps how many operations each has,
+	// 100 by default
+	Runs, Steps int
+}
+
+// Run runs the suite against the stores newStore returns. Each must be
+// empty and have its table not yet created; Run creates the table and
+// deletes it when done. Every example and every run of the property-based
+// tests gets a new store.
+func Run(t *testing.T, newStore func(t *testing.T) db.Store, opts Options) {
+	// Each example gets a store of its own, so the facts of one do not show
+	// in the snapshots and time ranges of another
+	examples := []struct {
+		name string
+		test func(t *testing.T, ctx context.Context, store db.Store)
+	}{
+		{"CRUD operations", testCRUDOperations},
+		{"Query operations", func(t *testing.T, ctx context.Context, store db.Store) {
+			testQueryOperations(t, ctx, store, opts)
+		}},
+		{"Snapshot operations", testSnapshotOperations},
+	}
+	for _, example := range examples {
+		t.Run(example.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newStore(t)
+
+			// Setup - create the table
+			err := store.CreateTable(ctx)
+			require.NoError(t, err, "CreateTable should succeed")
+
+			example.test(t, ctx, store)
+
+			// Cleanup - delete the table
+			err = store.DeleteTable(ctx)
+			assert.NoError(t, err, "DeleteTable should succeed")
+		})
+	}
+
+	t.Run("Properties", func(t *testing.T) {
+		if opts.SkipProperties {
+			t.Skip("the store only approximates the semantics")
+		}
+		testProperties(t, newStore, opts)
+	})
+}
+
+func testCRUDOperations(t *testing.T, ctx context.Context, store db.Store) {
+	// Create a fact
+	now := time.Now().UTC()
+	testFact := &db.Fact{
+		ID:        "test-fact-1",
+		Timestamp: now,
+		Namespace: "test-namespace",
+		FieldName: "test-field",
+		DataType:  db.DataTypeString,
+		Value:     db.StringValue("test-value"),
+		UserID:    "test-user",
+	}
+
+	// Test PutFact
+	err := store.PutFact(ctx, testFact)
+	require.NoError(t, err, "PutFact should succeed")
+
+	// Test GetFact
+	retrievedFact, err := store.GetFact(ctx, testFact.ID)
+	require.NoError(t, err, "GetFact should succeed")
+	assert.Equal(t, testFact.ID, retrievedFact.ID)
+	assert.Equal(t, testFact.Namespace, retrievedFact.Namespace)
+	assert.Equal(t, testFact.FieldName, retrievedFact.FieldName)
+	assert.Equal(t, testFact.Value, retrievedFact.Value)
+	assert.Equal(t, testFact.DataType, retrievedFact.DataType)
+
+	// Test updating a fact
+	updatedFact := &db.Fact{
+		ID:        testFact.ID,
+		Timestamp: now.Add(time.Second),
+		Namespace: testFact.Namespace,
+		FieldName: testFact.FieldName,
+		DataType:  db.DataTypeString,
+		Value:     db.StringValue("updated-value"),
+		UserID:    testFact.UserID,
+	}
+
+	err = store.PutFact(ctx, updatedFact)
+	require.NoError(t, err, "Updating a fact should succeed")
+
+	retrievedUpdatedFact, err := store.GetFact(ctx, testFact.ID)
+	require.NoError(t, err, "GetFact after update should succeed")
+	assert.Equal(t, updatedFact.Value, retrievedUpdatedFact.Value)
+	assert.NotEqual(t, testFact.Value, retrievedUpdatedFact.Value)
+
+	// Test deleting a fact
+	err = store.DeleteFact(ctx, testFact.ID)
+	require.NoError(t, err, "DeleteFact should succeed")
+
+	// Verify deletion
+	endTime := now.Add(time.Hour)
+	result, err := store.QueryByField(ctx, testFact.Namespace, testFact.FieldName, db.QueryOptions{
+		StartTime:     &now,
+		EndTime:       &endTime,
+		SortAscending: false,
+	})
+	require.NoError(t, err, "Query after delete should succeed")
+
+	// Find the latest fact in results which should be a deletion marker
+	var found bool
+	for _, f := range result.Facts {
+		if f.ID == testFact.ID && f.IsDeleted {
+			found = true
+			break
+		}
+	}
+	assert.True(t, found, "DeleteFact should create a deletion marker")
+
+	// IDs end the sort key, so they cannot contain its '#' separator
+	badFact := &db.Fact{
+		ID:        "bad#id",
+		Timestamp: now,
+		Namespace: testFact.Namespace,
+		FieldName: testFact.FieldName,
+		DataType:  db.DataTypeString,
+		Value:     db.StringValue("bad-value"),
+		UserID:    testFact.UserID,
+	}
+	err = store.PutFact(ctx, badFact)
+	assert.ErrorIs(t, err, db.ErrValidation, "PutFact should reject an ID with '#'")
+	err = store.PutFactsTransactional(ctx, []*db.Fact{badFact})
+	assert.ErrorIs(t, err, db.ErrValidation, "PutFactsTransactional should reject an ID with '#'")
+
+	// Facts must fit in a DynamoDB item, whatever the store
+	bigFact := *badFact
+	bigFact.ID = "big-fact"
+	bigFact.Value = db.StringValue(strings.Repeat("x", schema.MaxItemSize))
+	var sizeErr *schema.ItemSizeError
+	err = store.PutFact(ctx, &bigFact)
+	assert.ErrorIs(t, err, db.ErrValidation, "PutFact should reject a fact over the item size limit")
+	if assert.ErrorAs(t, err, &sizeErr) {
+		assert.Equal(t, schema.MaxItemSize, sizeErr.Limit)
+		assert.Less(t, sizeErr.MaxValueSize, schema.MaxItemSize)
+	}
+	err = store.PutFactsTransactional(ctx, []*db.Fact{&bigFact})
+	assert.ErrorIs(t, err, db.ErrValidation, "PutFactsTransactional should reject a fact over the item size limit")
+	assert.ErrorAs(t, err, &sizeErr)
+	_, err = store.GetFact(ctx, bigFact.ID)
+	assert.ErrorIs(t, err, db.ErrNotFound, "a fact over the limit should not be stored")
+}
+
+func testQueryOperations(t *testing.T, ctx context.Context, store db.Store, options Options) {
+	// Create multiple facts with various attributes for querying
+	baseTime := time.Now().UTC()
+	backdated := baseTime.Add(-time.Hour)
+	facts := []*db.Fact{
+		{
+			ID:        "query-fact-1",
+			Timestamp: baseTime,
+			Namespace: "query-ns",
+			FieldName: "field1",
+			DataType:  db.DataTypeString,
+			Value:     db.StringValue("value1"),
+			UserID:    "test-user",
+		},
+		{
+			ID:        "query-fact-2",
+			Timestamp: baseTime.Add(time.Minute),
+			Namespace: "query-ns",
+			FieldName: "field1",
+			DataType:  db.DataTypeString,
+			Value:     db.StringValue("value2"),
+			UserID:    "test-user",
+			// Written by another user, with one of their API keys
+			ActorUserID: "other-user",
+			APIKeyID:    "other-key",
+		},
+		{
+			ID:        "query-fact-3",
+			Timestamp: baseTime.Add(2 * time.Minute),
+			Namespace: "query-ns",
+			FieldName: "field2",
+			DataType:  db.DataTypeNumber,
+			Value:     json.RawMessage(`42`),
+			UserID:    "test-user",
+			// A correction taking effect before it was written, dated by
+			// the client that made it
+			ValidTime:   &backdated,
+			ClientDated: true,
+			CreatedAt:   &baseTime,
+		},
+		{
+			ID:          "query-fact-4",
+			Timestamp:   baseTime.Add(3 * time.Minute),
+			Namespace:   "other-ns",
+			FieldName:   "field3",
+			DataType:    db.DataTypeBoolean,
+			Value:       json.RawMessage(`true`),
+			UserID:      "test-user",
+			ActorUserID: "other-user",
+		},
+	}
+
+	// Insert facts
+	for _, fact := range facts {
+		err := store.PutFact(ctx, fact)
+		require.NoError(t, err, "PutFact should succeed")
+	}
+
+	// Test QueryByField
+	t.Run("QueryByField", func(t *testing.T) {
+		startTime := baseTime.Add(-time.Minute)
+		endTime := baseTime.Add(5 * time.Minute)
+		result, err := store.QueryByField(ctx, "query-ns", "field1", db.QueryOptions{
+			StartTime:     &startTime,
+			EndTime:       &endTime,
+			SortAscending: true,
+		})
+		require.NoError(t, err, "QueryByField should succeed")
+		assert.Len(t, result.Facts, 2, "Should return 2 facts for field1")
+		assert.Equal(t, "value1", result.Facts[0].Text(), "First fact should be value1")
+		assert.Equal(t, "value2", result.Facts[1].Text(), "Second fact should be value2")
+	})
+
+	// Test QueryByTimeRange
+	t.Run("QueryByTimeRange", func(t *testing.T) {
+		startTime := baseTime.Add(1 * time.Minute)
+		endTime := baseTime.Add(4 * time.Minute)
+		result, err := store.QueryByTimeRange(ctx, db.QueryOptions{
+			StartTime:     &startTime,
+			EndTime:       &endTime,
+			SortAscending: false,
+		})
+		require.NoError(t, err, "QueryByTimeRange should succeed")
+		assert.Len(t, result.Facts, 3, "Should return 3 facts in time range")
+		// Facts should be in descending order by timestamp
+		assert.Equal(t, "other-ns", result.Facts[0].Namespace, "First fact should be from other-ns")
+		assert.Equal(t, "query-ns", result.Facts[1].Namespace, "Second fact should be from query-ns")
+		assert.Equal(t, "field2", result.Facts[1].FieldName, "Second fact should be field2")
+	})
+
+	// Test QueryByNamespace
+	t.Run("QueryByNamespace", func(t *testing.T) {
+		startTime := baseTime.Add(-time.Minute)
+		endTime := baseTime.Add(5 * time.Minute)
+		result, err := store.QueryByNamespace(ctx, "query-ns", db.QueryOptions{
+			StartTime:     &startTime,
+			EndTime:       &endTime,
+			SortAscending: true,
+		})
+		require.NoError(t, err, "QueryByNamespace should succeed")
+		assert.Len(t, result.Facts, 3, "Should return 3 facts for query-ns")
+		assert.Equal(t, "field1", result.Facts[0].FieldName, "First fact should be field1")
+		assert.Equal(t, "field1", result.Facts[1].FieldName, "Second fact should be field1")
+		assert.Equal(t, "field2", result.Facts[2].FieldName, "Third fact should be field2")
+		require.NotNil(t, result.Facts[2].ValidTime, "Third fact should keep its valid time")
+		assert.True(t, backdated.Equal(*result.Facts[2].ValidTime), "Third fact should keep its valid time")
+		assert.Nil(t, result.Facts[0].ValidTime, "Facts without a valid time should have none")
+		assert.True(t, result.Facts[2].ClientDated, "Third fact should stay client-dated")
+		assert.False(t, result.Facts[0].ClientDated)
+		require.NotNil(t, result.Facts[2].CreatedAt, "Third fact should keep its row's creation time")
+		assert.True(t, baseTime.Equal(*result.Facts[2].CreatedAt), "Third fact should keep its row's creation time")
+		assert.Nil(t, result.Facts[0].CreatedAt)
+	})
+
+	// Actor queries span namespaces and keep who wrote each fact
+	t.Run("QueryByActor", func(t *testing.T) {
+		startTime := baseTime.Add(-time.Minute)
+		endTime := baseTime.Add(5 * time.Minute)
+		result, err := store.QueryByActor(ctx, "other-user", db.QueryOptions{
+			StartTime:     &startTime,
+			EndTime:       &endTime,
+			SortAscending: true,
+		})
+		require.NoError(t, err, "QueryByActor should succeed")
+		require.Len(t, result.Facts, 2, "Should return the 2 facts other-user wrote")
+		assert.Equal(t, "query-fact-2", result.Facts[0].ID)
+		assert.Equal(t, "other-user", result.Facts[0].ActorUserID)
+		assert.Equal(t, "other-key", result.Facts[0].APIKeyID)
+		assert.Equal(t, "query-fact-4", result.Facts[1].ID)
+
+		result, err = store.QueryByActor(ctx, "nobody", db.QueryOptions{StartTime: &startTime, EndTime: &endTime})
+		require.NoError(t, err, "QueryByActor should succeed")
+		assert.Empty(t, result.Facts)
+	})
+
+	// A time range and limit apply to the namespace's facts alone, and the
+	// range includes its end
+	t.Run("QueryByNamespace with time range and limit", func(t *testing.T) {
+		startTime := baseTime
+		endTime := baseTime.Add(3 * time.Minute)
+		result, err := store.QueryByNamespace(ctx, "query-ns", db.QueryOptions{
+			StartTime: &startTime,
+			EndTime:   &endTime,
+			Limit:     aws.Int32(2),
+		})
+		require.NoError(t, err, "QueryByNamespace should succeed")
+		require.Len(t, result.Facts, 2, "Should return the 2 newest facts of query-ns")
+		assert.Equal(t, "query-fact-3", result.Facts[0].ID)
+		assert.Equal(t, "query-fact-2", result.Facts[1].ID)
+
+		result, err = store.QueryByNamespace(ctx, "other-ns", db.QueryOptions{
+			StartTime: &startTime,
+			EndTime:   &endTime,
+		})
+		require.NoError(t, err, "QueryByNamespace should succeed")
+		require.Len(t, result.Facts, 1, "Should return only the other-ns fact")
+		assert.Equal(t, "query-fact-4", result.Facts[0].ID)
+	})
+
+	// Pages follow on from their signed tokens, which only fit their query
+	t.Run("QueryByNamespace pages", func(t *testing.T) {
+		if options.SkipPagination {
+			t.Skip("the store does not paginate")
+		}
+		startTime := baseTime.Add(-time.Minute)
+		endTime := baseTime.Add(5 * time.Minute)
+		opts := db.QueryOptions{StartTime: &startTime, EndTime: &endTime, SortAscending: true, Limit: aws.Int32(1)}
+		var ids []string
+		for range 10 {
+			result, err := store.QueryByNamespace(ctx, "query-ns", opts)
+			require.NoError(t, err, "QueryByNamespace should succeed")
+			for _, f := range result.Facts {
+				ids = append(ids, f.ID)
+			}
+			if result.NextToken == nil {
+				break
+			}
+			opts.NextToken = result.NextToken
+
+			_, err = store.QueryByNamespace(ctx, "other-ns", opts)
+			assert.ErrorIs(t, err, db.ErrValidation, "a token does not fit another query")
+		}
+		assert.Equal(t, []string{"query-fact-1", "query-fact-2", "query-fact-3"}, ids)
+
+		bad := "eyJ2IjoxLCJwIjoieCJ9.AAAA"
+		opts.NextToken = &bad
+		_, err := store.QueryByNamespace(ctx, "query-ns", opts)
+		assert.ErrorIs(t, err, db.ErrValidation, "a forged token is rejected")
+	})
+}
+
+func testSnapshotOperations(t *testing.T, ctx context.Context, store db.Store) {
+	// Create multiple facts with various timestamps for snapshot testing
+	baseTime := time.Now().UTC()
+	facts := []*db.Fact{
+		{
+			ID:        "snap-fact-1",
+			Timestamp: baseTime,
+			Namespace: "snap-ns",
+			FieldName: "snap-field1",
+			DataType:  db.DataTypeString,
+			Value:     db.StringValue("initial-value"),
+			UserID:    "test-user",
+		},
+		{
+			ID:        "snap-fact-1", // Same ID, updated version
+			Timestamp: baseTime.Add(time.Minute),
+			Namespace: "snap-ns",
+			FieldName: "snap-field1",
+			DataType:  db.DataTypeString,
+			Value:     db.StringValue("updated-value"),
+			UserID:    "test-user",
+		},
+		{
+			ID:        "snap-fact-2",
+			Timestamp: baseTime.Add(2 * time.Minute),
+			Namespace: "snap-ns",
+			FieldName: "snap-field2",
+			DataType:  db.DataTypeNumber,
+			Value:     json.RawMessage(`100`),
+			UserID:    "test-user",
+		},
+		{
+			ID:        "snap-fact-3",
+			Timestamp: baseTime.Add(3 * time.Minute),
+			Namespace: "other-snap-ns",
+			FieldName: "snap-field3",
+			DataType:  db.DataTypeBoolean,
+			Value:     json.RawMessage(`true`),
+			UserID:    "test-user",
+		},
+		{
+			ID:        "snap-fact-2", // Delete snap-fact-2
+			Timestamp: baseTime.Add(4 * time.Minute),
+			Namespace: "snap-ns",
+			FieldName: "snap-field2",
+			DataType:  db.DataTypeNumber,
+			Value:     json.RawMessage(`100`),
+			UserID:    "test-user",
+			IsDeleted: true,
+		},
+	}
+
+	// Insert facts
+	for _, fact := range facts {
+		err := store.PutFact(ctx, fact)
+		require.NoError(t, err, "PutFact should succeed")
+	}
+
+	// Test GetSnapshotAtTime
+	t.Run("Snapshot at initial time", func(t *testing.T) {
+		// Snapshot right after the first fact
+		snapshotTime := baseTime.Add(30 * time.Second)
+		snapshot, err := store.GetSnapshotAtTime(ctx, "snap-ns", snapshotTime)
+		require.NoError(t, err, "GetSnapshotAtTime should succeed")
+		assert.Len(t, snapshot, 1, "Snapshot should have 1 field")
+		key := "snap-ns#snap-field1"
+		fact, ok := snapshot[key]
+		assert.True(t, ok, "snap-field1 should be in snapshot")
+		assert.Equal(t, "initial-value", fact.Text(), "Value should be initial-value")
+	})
+
+	t.Run("Snapshot after update", func(t *testing.T) {
+		// Snapshot after field1 was updated
+		snapshotTime := baseTime.Add(90 * time.Second)
+		snapshot, err := store.GetSnapshotAtTime(ctx, "snap-ns", snapshotTime)
+		require.NoError(t, err, "GetSnapshotAtTime should succeed")
+		assert.Len(t, snapshot, 1, "Snapshot should have 1 field")
+		key := "snap-ns#snap-field1"
+		fact, ok := snapshot[key]
+		assert.True(t, ok, "snap-field1 should be in snapshot")
+		assert.Equal(t, "updated-value", fact.Text(), "Value should be updated-value")
+	})
+
+	t.Run("Snapshot with multiple fields", func(t *testing.T) {
+		// Snapshot after field2 was added
+		snapshotTime := baseTime.Add(150 * time.Second)
+		snapshot, err := store.GetSnapshotAtTime(ctx, "snap-ns", snapshotTime)
+		require.NoError(t, err, "GetSnapshotAtTime should succeed")
+		assert.Len(t, snapshot, 2, "Snapshot should have 2 fields")
+		key1 := "snap-ns#snap-field1"
+		snapFact1, ok := snapshot[key1]
+		assert.True(t, ok, "snap-field1 should be in snapshot")
+		assert.Equal(t, "updated-value", snapFact1.Text(), "Value should be updated-value")
+
+		key2 := "snap-ns#snap-field2"
+		fact2, ok := snapshot[key2]
+		assert.True(t, ok, "snap-field2 should be in snapshot")
+		assert.Equal(t, "100", fact2.Text(), "Value should be 100")
+	})
+
+	t.Run("Snapshot after deletion", func(t *testing.T) {
+		// Snapshot after field2 was deleted
+		snapshotTime := baseTime.Add(5 * time.Minute)
+		snapshot, err := store.GetSnapshotAtTime(ctx, "snap-ns", snapshotTime)
+		require.NoError(t, err, "GetSnapshotAtTime should succeed")
+		assert.Len(t, snapshot, 2, "Snapshot should have 2 fields")
+		key1 := "snap-ns#snap-field1"
+		_, ok := snapshot[key1]
+		assert.True(t, ok, "snap-field1 should be in snapshot")
+
+		key2 := "snap-ns#snap-field2"
+		tombstone, ok := snapshot[key2]
+		require.True(t, ok, "snap-field2 should have its tombstone in the snapshot")
+		assert.True(t, tombstone.IsDeleted, "snap-field2 should be deleted")
+		assert.Equal(t, db.DataTypeDeleted, tombstone.DataType, "Tombstones should have the deleted data type")
+		assert.Empty(t, tombstone.Value, "Tombstones should have no value")
+	})
+
+	t.Run("Legacy deletions are tombstones", func(t *testing.T) {
+		// JSON facts without a value deleted rows before tombstones
+		legacy := []*db.Fact{
+			{ID: "legacy-1", Timestamp: baseTime.Add(6 * time.Minute), Namespace: "legacy-snap-ns", FieldName: "row", DataType: db.DataTypeJSON, Value: json.RawMessage(`{"a":1}`), UserID: "test-user"},
+			{ID: "legacy-2", Timestamp: baseTime.Add(7 * time.Minute), Namespace: "legacy-snap-ns", FieldName: "row", DataType: db.DataTypeJSON, Value: json.RawMessage(`null`), UserID: "test-user"},
+		}
+		for _, fact := range legacy {
+			require.NoError(t, store.PutFact(ctx, fact), "PutFact should succeed")
+		}
+		snapshot, err := store.GetSnapshotAtTime(ctx, "legacy-snap-ns", baseTime.Add(8*time.Minute))
+		require.NoError(t, err, "GetSnapshotAtTime should succeed")
+		tombstone := snapshot["legacy-snap-ns#row"]
+		assert.Equal(t, "legacy-2", tombstone.ID)
+		assert.True(t, tombstone.IsDeleted, "A JSON fact without a value should be read as a tombstone")
+		assert.Equal(t, db.DataTypeDeleted, tombstone.DataType)
+		assert.Empty(t, tombstone.Value)
+	})
+
+	t.Run("Snapshot across all namespaces", func(t *testing.T) {
+		// Snapshot across all namespaces
+		snapshotTime := baseTime.Add(3*time.Minute + 30*time.Second)
+		snapshot, err := store.GetSnapshotAtTime(ctx, "", snapshotTime)
+		require.NoError(t, err, "GetSnapshotAtTime should succeed")
+		assert.Len(t, snapshot, 3, "Snapshot should have 3 fields across all namespaces")
+
+		// Check for field from other namespace
+		key3 := "other-snap-ns#snap-field3"
+		fact3, ok := snapshot[key3]
+		assert.True(t, ok, "snap-field3 from other-snap-ns should be in snapshot")
+		assert.Equal(t, "true", fact3.Text(), "Value should be true")
+	})
+}
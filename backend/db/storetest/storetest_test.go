@@ -0,0 +1,14 @@
+package storetest_test
+
+import (
+	"testing"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/db/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) db.Store {
+		return db.NewMemoryStore()
+	}, storetest.Options{})
+}
@@ -0,0 +1,185 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/schema"
+)
+
+// lookupAPI answers queries with scripted pages and records puts
+type lookupAPI struct {
+	*dynamodb.Client
+	pages   []*dynamodb.QueryOutput
+	queries int
+	puts    []map[string]types.AttributeValue
+}
+
+func (a *lookupAPI) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	a.queries++
+	if len(a.pages) == 0 {
+		return &dynamodb.QueryOutput{}, nil
+	}
+	page := a.pages[0]
+	a.pages = a.pages[1:]
+	return page, nil
+}
+
+func (a *lookupAPI) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	a.puts = append(a.puts, params.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func sAttr(v string) types.AttributeValue {
+	return &types.AttributeValueMemberS{Value: v}
+}
+
+func TestLegacyClientAdapterGetFactStopsAtMatch(t *testing.T) {
+	ctx := context.Background()
+	more := map[string]types.AttributeValue{"UserID": sAttr("u1"), "SK": sAttr("2024-01-01T00:00:00Z#x")}
+	match := map[string]types.AttributeValue{
+		"SK":        sAttr("2024-01-01T00:30:00Z#f1"),
+		"Namespace": sAttr("u1/t"),
+		"FieldName": sAttr("r1"),
+		"DataType":  sAttr("string"),
+		"Value":     sAttr("v"),
+	}
+	api := &lookupAPI{pages: []*dynamodb.QueryOutput{
+		// A page the filter emptied, then the match, then older history
+		{LastEvaluatedKey: more},
+		{Items: []map[string]types.AttributeValue{match}, Count: 1, LastEvaluatedKey: more},
+		{Items: []map[string]types.AttributeValue{match}, Count: 1},
+	}}
+	store := db.CreateStoreFromClient(dynamo.NewClientWithDB(api, "Facts", "u1"))
+
+	fact, err := store.GetFact(ctx, "f1")
+	require.NoError(t, err)
+	assert.Equal(t, "f1", fact.ID)
+	assert.Equal(t, "r1", fact.FieldName)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC), fact.Timestamp)
+	assert.Equal(t, 2, api.queries, "older history is not read")
+}
+
+func TestLegacyClientAdapterDeleteFact(t *testing.T) {
+	ctx := context.Background()
+	match := map[string]types.AttributeValue{
+		"SK":        sAttr("2024-01-01T00:30:00Z#f1"),
+		"Namespace": sAttr("u1/t"),
+		"FieldName": sAttr("r1"),
+		"DataType":  sAttr("string"),
+		"Value":     sAttr("v"),
+	}
+	api := &lookupAPI{pages: []*dynamodb.QueryOutput{{Items: []map[string]types.AttributeValue{match}, Count: 1}}}
+	store := db.CreateStoreFromClient(dynamo.NewClientWithDB(api, "Facts", "u1"))
+
+	require.NoError(t, store.DeleteFact(ctx, "f1"))
+	assert.Equal(t, 1, api.queries, "one lookup finds the fact")
+	require.Len(t, api.puts, 1)
+	assert.Equal(t, sAttr("deleted"), api.puts[0]["DataType"])
+	assert.Equal(t, sAttr("r1"), api.puts[0]["FieldName"])
+
+	err := store.DeleteFact(ctx, "missing")
+	assert.True(t, errors.Is(err, db.ErrNotFound), "got %v", err)
+}
+
+func TestLegacyClientAdapterRejectsFactsOverItemLimit(t *testing.T) {
+	ctx := context.Background()
+	api := &lookupAPI{}
+	store := db.CreateStoreFromClient(dynamo.NewClientWithDB(api, "Facts", "u1"))
+	value, err := db.EncodeValue(map[string]interface{}{"notes": strings.Repeat("x", schema.MaxItemSize)})
+	require.NoError(t, err)
+	fact := &db.Fact{ID: "f1", Timestamp: time.Now().UTC(), Namespace: "u1/t", FieldName: "r1", DataType: db.DataTypeJSON, Value: value}
+
+	err = store.PutFact(ctx, fact)
+	assert.ErrorIs(t, err, db.ErrValidation)
+	var sizeErr *schema.ItemSizeError
+	require.ErrorAs(t, err, &sizeErr)
+	assert.Greater(t, sizeErr.Size, schema.MaxItemSize)
+	assert.Empty(t, api.puts, "nothing is sent to DynamoDB")
+
+	err = store.PutFactsTransactional(ctx, []*db.Fact{fact})
+	assert.ErrorIs(t, err, db.ErrValidation)
+	assert.ErrorAs(t, err, &sizeErr)
+}
+
+// snapshotStore records the namespaces snapshots are taken of
+type snapshotStore struct {
+	db.Store
+	namespaces []string
+}
+
+func (s *snapshotStore) GetSnapshotAtTime(ctx context.Context, namespace string, at time.Time) (map[string]db.Fact, error) {
+	s.namespaces = append(s.namespaces, namespace)
+	return s.Store.GetSnapshotAtTime(ctx, namespace, at)
+}
+
+func TestStoreAdapterGetTableSnapshot(t *testing.T) {
+	ctx := context.Background()
+	store := &snapshotStore{Store: db.NewMemoryStore()}
+	adapter := db.NewStoreAdapter(store)
+	now := time.Now().UTC()
+
+	for _, fact := range []dynamo.Fact{
+		{ID: "d1", Timestamp: now, Namespace: "u1", FieldName: "my notes", DataType: "table"},
+		{ID: "r1", Timestamp: now, Namespace: "u1/my%20notes", FieldName: "r1", DataType: "json", Value: map[string]interface{}{"title": "old"}},
+		{ID: "r2", Timestamp: now.Add(time.Second), Namespace: "u1/my%20notes", FieldName: "r1", DataType: "json", Value: map[string]interface{}{"title": "new"}},
+		{ID: "o1", Timestamp: now, Namespace: "u1/other", FieldName: "r1", DataType: "json", Value: map[string]interface{}{"title": "other"}},
+		{ID: "o2", Timestamp: now, Namespace: "u2/my%20notes", FieldName: "r2", DataType: "json", Value: map[string]interface{}{"title": "u2"}},
+	} {
+		require.NoError(t, adapter.PutFact(ctx, fact))
+	}
+
+	snap, err := adapter.GetTableSnapshot(ctx, "u1", "my notes", now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, snap, 1, "only the table's rows are returned")
+	assert.Equal(t, "r2", snap["r1"].ID)
+	assert.Equal(t, map[string]interface{}{"title": "new"}, snap["r1"].Value)
+	assert.Equal(t, []string{"u1/my%20notes"}, store.namespaces, "only the table's namespace is read")
+
+	snap, err = adapter.GetTableSnapshot(ctx, "u1", "my notes", now)
+	require.NoError(t, err)
+	assert.Equal(t, "r1", snap["r1"].ID, "snapshots are taken at the given time")
+
+	snap, err = adapter.GetTableSnapshot(ctx, "u1", "missing", now)
+	require.NoError(t, err)
+	assert.Empty(t, snap)
+}
+
+func TestStoreAdapterStampsActor(t *testing.T) {
+	store := db.NewMemoryStore()
+	adapter := db.NewStoreAdapter(store)
+	now := time.Now().UTC()
+	ctx := db.WithActor(context.Background(), db.Actor{UserID: "u2", APIKeyID: "k1"})
+
+	require.NoError(t, adapter.PutFact(ctx, dynamo.Fact{ID: "f1", Timestamp: now, Namespace: "u1/t", FieldName: "r1", DataType: "json", Value: map[string]interface{}{}}))
+	require.NoError(t, adapter.PutFactsTransactional(ctx, []dynamo.Fact{
+		{ID: "f2", Timestamp: now, Namespace: "u1/t", FieldName: "r2", DataType: "json", Value: map[string]interface{}{}},
+		{ID: "f3", Timestamp: now, Namespace: "u1/t", FieldName: "r3", DataType: "json", Value: map[string]interface{}{}, ActorUserID: "u3"},
+	}))
+	require.NoError(t, adapter.PutFact(context.Background(), dynamo.Fact{ID: "f4", Timestamp: now, Namespace: "u1/t", FieldName: "r4", DataType: "json", Value: map[string]interface{}{}}))
+
+	facts, err := adapter.QueryByActor(ctx, "u2", now.Add(-time.Minute), now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, facts, 2)
+	assert.Equal(t, []string{"f1", "f2"}, []string{facts[0].ID, facts[1].ID})
+	assert.Equal(t, "k1", facts[0].APIKeyID)
+
+	facts, err = adapter.QueryByActor(ctx, "u3", now.Add(-time.Minute), now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, facts, 1, "facts with an actor keep it")
+	assert.Empty(t, facts[0].APIKeyID)
+
+	fact, err := store.GetFact(ctx, "f4")
+	require.NoError(t, err)
+	assert.Empty(t, fact.ActorUserID, "writes without an actor record none")
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/db"
+)
+
+func TestStoreErrorKinds(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		kind error
+	}{
+		{"not found", &types.ResourceNotFoundException{}, db.ErrNotFound},
+		{"condition", &types.ConditionalCheckFailedException{}, db.ErrConditionFailed},
+		{"provisioned", &types.ProvisionedThroughputExceededException{}, db.ErrThrottled},
+		{"request limit", &types.RequestLimitExceeded{}, db.ErrThrottled},
+		{"throttling code", &smithy.GenericAPIError{Code: "ThrottlingException"}, db.ErrThrottled},
+		{"validation code", &smithy.GenericAPIError{Code: "ValidationException"}, db.ErrValidation},
+		{"wrapped", fmt.Errorf("query failed: %w", &types.RequestLimitExceeded{}), db.ErrThrottled},
+		{"unclassified", errors.New("boom"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := &db.StoreError{Operation: "PutFact", Err: tt.err}
+			assert.Equal(t, tt.kind, db.KindOf(err))
+			if tt.kind != nil {
+				assert.ErrorIs(t, err, tt.kind)
+			}
+			// The cause stays reachable
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	explicit := &db.StoreError{Operation: "GetFact", Kind: db.ErrNotFound, Err: errors.New("fact not found")}
+	assert.True(t, db.IsNotFound(explicit))
+	assert.True(t, db.IsNotFound(&db.StoreError{Operation: "DeleteFact", Err: explicit}))
+	assert.False(t, db.IsNotFound(errors.New("fact not found")))
+}
+
+func TestMockStoreErrorKinds(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMockStore()
+
+	err := store.PutFact(ctx, &db.Fact{ID: "f1"})
+	assert.ErrorIs(t, err, db.ErrNotFound, "table not created")
+
+	require.NoError(t, store.CreateTable(ctx))
+	assert.ErrorIs(t, store.PutFact(ctx, nil), db.ErrValidation)
+
+	_, err = store.GetFact(ctx, "missing")
+	assert.True(t, db.IsNotFound(err))
+
+	store.SimulateFailure("PutFact", &types.ProvisionedThroughputExceededException{})
+	assert.ErrorIs(t, store.PutFact(ctx, &db.Fact{ID: "f1"}), db.ErrThrottled)
+}
@@ -116,7 +116,7 @@ func testIntegrationBasicCRUD(t *testing.T, ctx context.Context, store db.Store)
 		Namespace: "test-ns",
 		FieldName: "test-field",
 		DataType:  db.DataTypeString,
-		Value:     "initial-value",
+		Value:     db.StringValue("initial-value"),
 		UserID:    "test-user",
 	}
 
@@ -137,7 +137,7 @@ func testIntegrationBasicCRUD(t *testing.T, ctx context.Context, store db.Store)
 		Namespace: "test-ns",
 		FieldName: "test-field",
 		DataType:  db.DataTypeString,
-		Value:     "updated-value",
+		Value:     db.StringValue("updated-value"),
 		UserID:    "test-user",
 	}
 	err = store.PutFact(ctx, updatedFact)
@@ -146,7 +146,7 @@ func testIntegrationBasicCRUD(t *testing.T, ctx context.Context, store db.Store)
 	// Verify update
 	retrievedUpdated, err := store.GetFact(ctx, factID)
 	require.NoError(t, err, "Should get updated fact")
-	assert.Equal(t, "updated-value", retrievedUpdated.Value, "Value should be updated")
+	assert.Equal(t, "updated-value", retrievedUpdated.Text(), "Value should be updated")
 
 	// Delete
 	err = store.DeleteFact(ctx, factID)
@@ -172,7 +172,7 @@ func testIntegrationQuerying(t *testing.T, ctx context.Context, store db.Store)
 			Namespace: "query-ns",
 			FieldName: fmt.Sprintf("field-%d", i%2), // alternate between field-0 and field-1
 			DataType:  db.DataTypeString,
-			Value:     fmt.Sprintf("value-%d", i),
+			Value:     db.StringValue(fmt.Sprintf("value-%d", i)),
 			UserID:    "test-user",
 		}
 		err := store.PutFact(ctx, fact)
@@ -255,7 +255,7 @@ func testIntegrationHistoricalData(t *testing.T, ctx context.Context, store db.S
 		Namespace: "history-ns",
 		FieldName: "versioned-field",
 		DataType:  db.DataTypeString,
-		Value:     "version-1",
+		Value:     db.StringValue("version-1"),
 		UserID:    "test-user",
 	}
 	err := store.PutFact(ctx, initialFact)
@@ -268,7 +268,7 @@ func testIntegrationHistoricalData(t *testing.T, ctx context.Context, store db.S
 		Namespace: "history-ns",
 		FieldName: "versioned-field",
 		DataType:  db.DataTypeString,
-		Value:     "version-2",
+		Value:     db.StringValue("version-2"),
 		UserID:    "test-user",
 	}
 	err = store.PutFact(ctx, update1)
@@ -281,7 +281,7 @@ func testIntegrationHistoricalData(t *testing.T, ctx context.Context, store db.S
 		Namespace: "history-ns",
 		FieldName: "versioned-field",
 		DataType:  db.DataTypeString,
-		Value:     "version-3",
+		Value:     db.StringValue("version-3"),
 		UserID:    "test-user",
 	}
 	err = store.PutFact(ctx, update2)
@@ -294,7 +294,7 @@ func testIntegrationHistoricalData(t *testing.T, ctx context.Context, store db.S
 
 		fact, ok := snapshot["history-ns#versioned-field"]
 		assert.True(t, ok, "Field should be in snapshot")
-		assert.Equal(t, "version-1", fact.Value, "Should have initial version")
+		assert.Equal(t, "version-1", fact.Text(), "Should have initial version")
 	})
 
 	t.Run("Snapshot after first update", func(t *testing.T) {
@@ -303,7 +303,7 @@ func testIntegrationHistoricalData(t *testing.T, ctx context.Context, store db.S
 
 		fact, ok := snapshot["history-ns#versioned-field"]
 		assert.True(t, ok, "Field should be in snapshot")
-		assert.Equal(t, "version-2", fact.Value, "Should have first update")
+		assert.Equal(t, "version-2", fact.Text(), "Should have first update")
 	})
 
 	t.Run("Snapshot at latest state", func(t *testing.T) {
@@ -312,7 +312,7 @@ func testIntegrationHistoricalData(t *testing.T, ctx context.Context, store db.S
 
 		fact, ok := snapshot["history-ns#versioned-field"]
 		assert.True(t, ok, "Field should be in snapshot")
-		assert.Equal(t, "version-3", fact.Value, "Should have latest version")
+		assert.Equal(t, "version-3", fact.Text(), "Should have latest version")
 	})
 
 	// Test historical query
@@ -328,9 +328,9 @@ func testIntegrationHistoricalData(t *testing.T, ctx context.Context, store db.S
 
 		require.NoError(t, err, "Should query versions")
 		assert.Len(t, result.Facts, 3, "Should return all 3 versions")
-		assert.Equal(t, "version-1", result.Facts[0].Value, "First should be version-1")
-		assert.Equal(t, "version-2", result.Facts[1].Value, "Second should be version-2")
-		assert.Equal(t, "version-3", result.Facts[2].Value, "Third should be version-3")
+		assert.Equal(t, "version-1", result.Facts[0].Text(), "First should be version-1")
+		assert.Equal(t, "version-2", result.Facts[1].Text(), "Second should be version-2")
+		assert.Equal(t, "version-3", result.Facts[2].Text(), "Third should be version-3")
 	})
 }
 
@@ -347,7 +347,7 @@ func testIntegrationPagination(t *testing.T, ctx context.Context, store db.Store
 			Namespace: "pagination-ns",
 			FieldName: "paginated-field",
 			DataType:  db.DataTypeNumber,
-			Value:     fmt.Sprintf("%d", i),
+			Value:     db.StringValue(fmt.Sprintf("%d", i)),
 			UserID:    "test-user",
 		}
 		err := store.PutFact(ctx, fact)
@@ -401,13 +401,13 @@ func testIntegrationPagination(t *testing.T, ctx context.Context, store db.Store
 		// Verify we got all 25 facts with no duplicates
 		allValues := make(map[string]bool)
 		for _, fact := range result1.Facts {
-			allValues[fact.Value] = true
+			allValues[fact.Text()] = true
 		}
 		for _, fact := range result2.Facts {
-			allValues[fact.Value] = true
+			allValues[fact.Text()] = true
 		}
 		for _, fact := range result3.Facts {
-			allValues[fact.Value] = true
+			allValues[fact.Text()] = true
 		}
 
 		assert.Len(t, allValues, 25, "Should have 25 unique values")
@@ -430,7 +430,7 @@ func testIntegrationErrorHandling(t *testing.T, ctx context.Context, store db.St
 			Timestamp: time.Now(),
 			Namespace: "test-ns",
 			FieldName: "test-field",
-			Value:     "test-value",
+			Value:     db.StringValue("test-value"),
 		}
 
 		err := store.PutFact(ctx, invalidFact)
@@ -456,7 +456,7 @@ func testIntegrationConcurrentAccess(t *testing.T, ctx context.Context, store db
 		Namespace: "concurrent-ns",
 		FieldName: "concurrent-field",
 		DataType:  db.DataTypeNumber,
-		Value:     "0",
+		Value:     db.StringValue("0"),
 		UserID:    "test-user",
 	}
 
@@ -480,7 +480,7 @@ func testIntegrationConcurrentAccess(t *testing.T, ctx context.Context, store db
 					Namespace: "concurrent-ns",
 					FieldName: "concurrent-field",
 					DataType:  db.DataTypeNumber,
-					Value:     fmt.Sprintf("g%d-u%d", goroutineID, i),
+					Value:     db.StringValue(fmt.Sprintf("g%d-u%d", goroutineID, i)),
 					UserID:    "test-user",
 				}
 
@@ -0,0 +1,14 @@
+package tests
+
+import (
+	"os"
+	"testing"
+
+	"github.com/elibdev/notably/testutil/dynamotest"
+)
+
+// TestMain starts DynamoDB Local for the integration tests when
+// DYNAMODB_LOCAL asks for it
+func TestMain(m *testing.M) {
+	os.Exit(dynamotest.Main(m))
+}
@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/db"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryStore()
+	require.NoError(t, store.CreateTable(ctx))
+
+	base := time.Now().UTC().Add(-time.Hour)
+	for _, f := range []db.Fact{
+		{ID: "a1", Timestamp: base, Namespace: "u/orders", FieldName: "r1", DataType: db.DataTypeJSON, Value: json.RawMessage(`{"n":1}`)},
+		{ID: "a2", Timestamp: base.Add(time.Minute), Namespace: "u/orders", FieldName: "r1", DataType: db.DataTypeJSON, Value: json.RawMessage(`{"n":2}`)},
+		{ID: "b1", Timestamp: base.Add(2 * time.Minute), Namespace: "u/orders", FieldName: "r2", DataType: db.DataTypeJSON, Value: json.RawMessage(`{"n":3}`)},
+		{ID: "c1", Timestamp: base.Add(3 * time.Minute), Namespace: "u", FieldName: "orders", DataType: "table"},
+	} {
+		f := f
+		require.NoError(t, store.PutFact(ctx, &f))
+	}
+
+	t.Run("QueryByField", func(t *testing.T) {
+		res, err := store.QueryByField(ctx, "u/orders", "r1", db.QueryOptions{SortAscending: true})
+		require.NoError(t, err)
+		require.Len(t, res.Facts, 2)
+		assert.Equal(t, "a1", res.Facts[0].ID)
+		assert.Equal(t, "a2", res.Facts[1].ID)
+
+		end := base.Add(30 * time.Second)
+		res, err = store.QueryByField(ctx, "u/orders", "r1", db.QueryOptions{EndTime: &end})
+		require.NoError(t, err)
+		require.Len(t, res.Facts, 1)
+		assert.Equal(t, "a1", res.Facts[0].ID)
+	})
+
+	t.Run("QueryByNamespace", func(t *testing.T) {
+		res, err := store.QueryByNamespace(ctx, "u/orders", db.QueryOptions{})
+		require.NoError(t, err)
+		require.Len(t, res.Facts, 3)
+		assert.Equal(t, "b1", res.Facts[0].ID, "descending by default")
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		snap, err := store.GetSnapshotAtTime(ctx, "", time.Now().UTC())
+		require.NoError(t, err)
+		assert.Len(t, snap, 3)
+		assert.JSONEq(t, `{"n":2}`, string(snap["u/orders#r1"].Value))
+
+		snap, err = store.GetSnapshotAtTime(ctx, "u/orders", base.Add(90*time.Second))
+		require.NoError(t, err)
+		assert.Len(t, snap, 1)
+	})
+
+	t.Run("DeleteAndPurge", func(t *testing.T) {
+		require.NoError(t, store.DeleteFact(ctx, "b1"))
+		snap, err := store.GetSnapshotAtTime(ctx, "u/orders", time.Now().UTC())
+		require.NoError(t, err)
+		assert.True(t, snap["u/orders#r2"].IsDeleted, "the snapshot has the tombstone")
+
+		latest, err := store.GetFact(ctx, "b1")
+		require.NoError(t, err)
+		assert.True(t, latest.IsDeleted)
+
+		require.NoError(t, store.PurgeFact(ctx, &db.Fact{ID: "a1", Timestamp: base}))
+		res, err := store.QueryByField(ctx, "u/orders", "r1", db.QueryOptions{})
+		require.NoError(t, err)
+		assert.Len(t, res.Facts, 1)
+
+		_, err = store.GetFact(ctx, "missing")
+		assert.True(t, db.IsNotFound(err))
+	})
+
+	t.Run("DeleteTable", func(t *testing.T) {
+		require.NoError(t, store.DeleteTable(ctx))
+		res, err := store.QueryByTimeRange(ctx, db.QueryOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, res.Facts)
+	})
+}
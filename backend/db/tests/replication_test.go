@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+)
+
+// globalTableAPI stands in for a Global Table: every item put through any
+// region's client is returned by every query. Other DynamoDB methods are
+// not used.
+type globalTableAPI struct {
+	*dynamodb.Client
+	items []map[string]types.AttributeValue
+}
+
+func (a *globalTableAPI) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	a.items = append(a.items, params.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (a *globalTableAPI) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	return &dynamodb.QueryOutput{Items: a.items}, nil
+}
+
+type conflictRecorder struct {
+	conflicts []db.Conflict
+}
+
+func (r *conflictRecorder) ObserveConflict(ctx context.Context, c db.Conflict) {
+	r.conflicts = append(r.conflicts, c)
+}
+
+func TestReplicatedSnapshotResolvesConflicts(t *testing.T) {
+	ctx := context.Background()
+	api := &globalTableAPI{}
+	observer := &conflictRecorder{}
+	east := db.CreateReplicatedStoreFromClient(dynamo.NewClientWithDB(api, "Facts", "u1").WithRegion("us-east-1"), observer, db.DefaultConflictWindow)
+	west := db.CreateReplicatedStoreFromClient(dynamo.NewClientWithDB(api, "Facts", "u1").WithRegion("us-west-2"), observer, db.DefaultConflictWindow)
+
+	at := time.Now().UTC().Add(-time.Minute).Truncate(time.Millisecond)
+	fact := func(id, field, value string, ts time.Time) *db.Fact {
+		return &db.Fact{ID: id, Timestamp: ts, Namespace: "u1/notes", FieldName: field, DataType: db.DataTypeString, Value: db.StringValue(value)}
+	}
+	// Both regions write the title at the same instant; the body is
+	// rewritten in the west long after the east wrote it
+	require.NoError(t, east.PutFact(ctx, fact("a", "title", "east", at)))
+	require.NoError(t, west.PutFact(ctx, fact("b", "title", "west", at)))
+	require.NoError(t, east.PutFact(ctx, fact("c", "body", "first", at)))
+	require.NoError(t, west.PutFact(ctx, fact("d", "body", "second", at.Add(10*time.Second))))
+
+	for _, store := range []db.Store{east, west} {
+		snap, err := store.GetSnapshotAtTime(ctx, "u1/notes", time.Now().UTC())
+		require.NoError(t, err)
+		assert.Equal(t, "west", snap["u1/notes#title"].Text(), "every region picks the same winner")
+		assert.Equal(t, "us-west-2", snap["u1/notes#title"].Region)
+		assert.Equal(t, "second", snap["u1/notes#body"].Text())
+	}
+
+	require.Len(t, observer.conflicts, 2, "one conflict seen by each snapshot")
+	assert.Equal(t, "b", observer.conflicts[0].Winner.ID)
+	assert.Equal(t, "a", observer.conflicts[0].Loser.ID)
+}
+
+func TestFactSupersedes(t *testing.T) {
+	at := time.Now().UTC()
+	older := db.Fact{ID: "z", Timestamp: at.Add(-time.Millisecond), Region: "us-west-2"}
+	east := db.Fact{ID: "b", Timestamp: at, Region: "us-east-1"}
+	west := db.Fact{ID: "a", Timestamp: at, Region: "us-west-2"}
+
+	assert.True(t, east.Supersedes(older), "timestamps decide first")
+	assert.True(t, west.Supersedes(east), "then regions")
+	assert.False(t, east.Supersedes(west))
+	assert.True(t, db.Fact{ID: "b", Timestamp: at}.Supersedes(db.Fact{ID: "a", Timestamp: at}), "then IDs")
+}
@@ -0,0 +1,26 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/db"
+)
+
+func TestTableResolvers(t *testing.T) {
+	shared, err := db.NewTableResolver("", "Facts")
+	require.NoError(t, err)
+	assert.False(t, shared.Isolated())
+	assert.Equal(t, "Facts", shared.TableFor("u1", "tasks"))
+
+	isolated, err := db.NewTableResolver(db.StorageModeIsolated, "Facts")
+	require.NoError(t, err)
+	assert.True(t, isolated.Isolated())
+	assert.Equal(t, "Facts.u1.tasks", isolated.TableFor("u1", "tasks"))
+	assert.NotEqual(t, isolated.TableFor("u1", "tasks"), isolated.TableFor("u2", "tasks"))
+
+	_, err = db.NewTableResolver("sharded", "Facts")
+	assert.Error(t, err)
+}
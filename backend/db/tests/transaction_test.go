@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+)
+
+// transactAPI records TransactWriteItems calls and fails them with err.
+// Other DynamoDB methods are not used.
+type transactAPI struct {
+	*dynamodb.Client
+	input *dynamodb.TransactWriteItemsInput
+	err   error
+}
+
+func (a *transactAPI) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	a.input = params
+	return &dynamodb.TransactWriteItemsOutput{}, a.err
+}
+
+func txFacts(n int) []*db.Fact {
+	now := time.Now().UTC()
+	facts := make([]*db.Fact, n)
+	for i := range facts {
+		facts[i] = &db.Fact{ID: fmt.Sprintf("f%d", i), Timestamp: now, Namespace: "u1/t", FieldName: "r", DataType: db.DataTypeJSON, Value: json.RawMessage(`{}`)}
+	}
+	return facts
+}
+
+func cancelled(codes ...string) error {
+	reasons := make([]types.CancellationReason, len(codes))
+	for i, c := range codes {
+		reasons[i] = types.CancellationReason{Code: aws.String(c), Message: aws.String(c + " message")}
+	}
+	return &types.TransactionCanceledException{CancellationReasons: reasons}
+}
+
+func TestPutFactsTransactional(t *testing.T) {
+	ctx := context.Background()
+	api := &transactAPI{}
+	store := db.CreateStoreFromClient(dynamo.NewClientWithDB(api, "Facts", "u1"))
+
+	require.NoError(t, store.PutFactsTransactional(ctx, txFacts(3)))
+	require.Len(t, api.input.TransactItems, 3)
+	for _, item := range api.input.TransactItems {
+		require.NotNil(t, item.Put)
+		assert.Equal(t, "attribute_not_exists(SK)", aws.ToString(item.Put.ConditionExpression))
+		assert.Equal(t, "Facts", aws.ToString(item.Put.TableName))
+	}
+
+	api.input = nil
+	err := store.PutFactsTransactional(ctx, txFacts(db.MaxTransactionItems+1))
+	assert.ErrorIs(t, err, db.ErrValidation)
+	assert.Nil(t, api.input, "oversized transactions are not sent")
+}
+
+func TestTransactionCancellationReasons(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		kind    error
+		reasons []db.CancellationReason
+	}{
+		{
+			name: "condition",
+			err:  cancelled("None", db.ReasonConditionFailed),
+			kind: db.ErrConditionFailed,
+			reasons: []db.CancellationReason{
+				{Index: 1, Code: db.ReasonConditionFailed, Message: "ConditionalCheckFailed message"},
+			},
+		},
+		{
+			name: "conflict outranks throttling",
+			err:  cancelled(db.ReasonThrottled, db.ReasonConflict),
+			kind: db.ErrConditionFailed,
+			reasons: []db.CancellationReason{
+				{Index: 0, Code: db.ReasonThrottled, Message: "ThrottlingError message"},
+				{Index: 1, Code: db.ReasonConflict, Message: "TransactionConflict message"},
+			},
+		},
+		{
+			name:    "throttled",
+			err:     cancelled("None", "None", db.ReasonThrottled),
+			kind:    db.ErrThrottled,
+			reasons: []db.CancellationReason{{Index: 2, Code: db.ReasonThrottled, Message: "ThrottlingError message"}},
+		},
+		{
+			name:    "validation",
+			err:     cancelled(db.ReasonValidation, "None"),
+			kind:    db.ErrValidation,
+			reasons: []db.CancellationReason{{Index: 0, Code: db.ReasonValidation, Message: "ValidationError message"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := db.CreateStoreFromClient(dynamo.NewClientWithDB(&transactAPI{err: tt.err}, "Facts", "u1"))
+			err := store.PutFactsTransactional(context.Background(), txFacts(3))
+			assert.ErrorIs(t, err, tt.kind)
+
+			var txErr *db.TransactionError
+			require.True(t, errors.As(err, &txErr))
+			assert.Equal(t, tt.reasons, txErr.Reasons)
+		})
+	}
+
+	// Errors other than cancellations keep their own kind
+	store := db.CreateStoreFromClient(dynamo.NewClientWithDB(&transactAPI{err: &types.RequestLimitExceeded{}}, "Facts", "u1"))
+	err := store.PutFactsTransactional(context.Background(), txFacts(1))
+	assert.ErrorIs(t, err, db.ErrThrottled)
+	var txErr *db.TransactionError
+	assert.False(t, errors.As(err, &txErr))
+}
+
+func TestMemoryStoreTransactions(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryStore()
+	facts := txFacts(2)
+	require.NoError(t, store.PutFactsTransactional(ctx, facts))
+
+	// Replaying one fact cancels the whole transaction
+	again := []*db.Fact{
+		{ID: "new", Timestamp: time.Now().UTC(), Namespace: "u1/t", FieldName: "s", DataType: db.DataTypeJSON, Value: json.RawMessage(`{}`)},
+		facts[1],
+	}
+	err := store.PutFactsTransactional(ctx, again)
+	assert.ErrorIs(t, err, db.ErrConditionFailed)
+	var txErr *db.TransactionError
+	require.True(t, errors.As(err, &txErr))
+	require.Len(t, txErr.Reasons, 1)
+	assert.Equal(t, 1, txErr.Reasons[0].Index)
+
+	_, err = store.GetFact(ctx, "new")
+	assert.True(t, db.IsNotFound(err), "nothing from a cancelled transaction is stored")
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/elibdev/notably/dynamo"
+)
+
+// tracedAPI records a span for each read and write made with a context
+// carrying a trace. Traced calls ask DynamoDB for the capacity they
+// consume, so their spans show it.
+type tracedAPI struct {
+	dynamoDBAPI
+}
+
+func (t tracedAPI) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	ctx, span := dynamo.StartCallSpan(ctx, "PutItem", params.TableName)
+	if span != nil && params.ReturnConsumedCapacity == "" {
+		params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	}
+	out, err := t.dynamoDBAPI.PutItem(ctx, params, optFns...)
+	var consumed []types.ConsumedCapacity
+	if err == nil {
+		consumed = dynamo.CapacityOf(out.ConsumedCapacity)
+	}
+	dynamo.EndCallSpan(span, err, consumed...)
+	return out, err
+}
+
+func (t tracedAPI) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	ctx, span := dynamo.StartCallSpan(ctx, "Query", params.TableName)
+	if span != nil && params.ReturnConsumedCapacity == "" {
+		params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	}
+	out, err := t.dynamoDBAPI.Query(ctx, params, optFns...)
+	var consumed []types.ConsumedCapacity
+	if err == nil {
+		consumed = dynamo.CapacityOf(out.ConsumedCapacity)
+	}
+	dynamo.EndCallSpan(span, err, consumed...)
+	return out, err
+}
+
+func (t tracedAPI) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	ctx, span := dynamo.StartCallSpan(ctx, "DeleteItem", params.TableName)
+	if span != nil && params.ReturnConsumedCapacity == "" {
+		params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	}
+	out, err := t.dynamoDBAPI.DeleteItem(ctx, params, optFns...)
+	var consumed []types.ConsumedCapacity
+	if err == nil {
+		consumed = dynamo.CapacityOf(out.ConsumedCapacity)
+	}
+	dynamo.EndCallSpan(span, err, consumed...)
+	return out, err
+}
+
+func (t tracedAPI) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	ctx, span := dynamo.StartCallSpan(ctx, "TransactWriteItems", nil)
+	if span != nil && params.ReturnConsumedCapacity == "" {
+		params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	}
+	out, err := t.dynamoDBAPI.TransactWriteItems(ctx, params, optFns...)
+	var consumed []types.ConsumedCapacity
+	if err == nil {
+		consumed = out.ConsumedCapacity
+	}
+	dynamo.EndCallSpan(span, err, consumed...)
+	return out, err
+}
@@ -0,0 +1,478 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/schema"
+)
+
+// indexPollInterval is how often UpgradeTable checks on the index it adds
+var indexPollInterval = 10 * time.Second
+
+// UpgradeAPI is the DynamoDB API UpgradeTable uses
+type UpgradeAPI interface {
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	UpdateTable(ctx context.Context, params *dynamodb.UpdateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTableOutput, error)
+}
+
+// UpgradeTable brings a table in the user or store schema up to date with
+// AddNamespaceIndex, BackfillValues and BackfillTombstones. It can be run
+// again after an
+// interruption. progress, if set, is called with the running count of facts
+// updated after each page. pkg/migrate applies the same upgrades as
+// versioned migrations and records them in the table.
+func UpgradeTable(ctx context.Context, api UpgradeAPI, table string, opts dynamo.TableOptions, progress func(updated int)) (int, error) {
+	updated, err := AddNamespaceIndex(ctx, api, table, opts, progress)
+	if err != nil {
+		return updated, err
+	}
+	typed, err := BackfillValues(ctx, api, table, func(n int) {
+		if progress != nil {
+			progress(updated + n)
+		}
+	})
+	updated += typed
+	if err != nil {
+		return updated, err
+	}
+	tombstones, err := BackfillTombstones(ctx, api, table, func(n int) {
+		if progress != nil {
+			progress(updated + n)
+		}
+	})
+	return updated + tombstones, err
+}
+
+// AddNamespaceIndex brings a table in the user schema, as stores created it
+// before the NamespaceIndex, to the store schema: it sets the NamespaceKey
+// of every fact lacking one, then adds the index and waits for it to become
+// active. Stores opened on the table afterwards query namespaces through
+// the index. Tables in the store schema only have missing keys set.
+func AddNamespaceIndex(ctx context.Context, api UpgradeAPI, table string, opts dynamo.TableOptions, progress func(updated int)) (int, error) {
+	current, err := describeSchema(ctx, api, table)
+	if err != nil {
+		return 0, err
+	}
+	if current == schema.Namespace {
+		return 0, fmt.Errorf("upgrade: table %s is in the %s schema of the server", table, current)
+	}
+
+	updated, err := backfillNamespaceKeys(ctx, api, table, progress)
+	if err != nil || current == schema.Store {
+		return updated, err
+	}
+
+	out, err := api.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	if err != nil {
+		return updated, fmt.Errorf("upgrade: describe %s: %w", table, err)
+	}
+	want := TableDefinition(table, opts)
+	for _, gsi := range dynamo.MissingIndexes(want, out.Table) {
+		if _, err := api.UpdateTable(ctx, dynamo.AddIndexInput(want, gsi)); err != nil {
+			return updated, fmt.Errorf("upgrade: add %s: %w", aws.ToString(gsi.IndexName), err)
+		}
+		if err := waitForIndex(ctx, api, table, aws.ToString(gsi.IndexName)); err != nil {
+			return updated, fmt.Errorf("upgrade: add %s: %w", aws.ToString(gsi.IndexName), err)
+		}
+	}
+	return updated, nil
+}
+
+// AddActorIndex adds the ActorIndex to a table in the namespace schema of
+// the server, as the server created them before facts recorded their
+// actor, and waits for it to become active. Facts written before have no
+// ActorKey and stay out of the index. Tables of other schemas have no
+// ActorIndex, and are left alone.
+func AddActorIndex(ctx context.Context, api UpgradeAPI, table string, opts dynamo.TableOptions) error {
+	out, err := api.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	if err != nil {
+		return fmt.Errorf("upgrade: describe %s: %w", table, err)
+	}
+	current, err := schema.Detect(out.Table)
+	if err != nil {
+		return fmt.Errorf("upgrade: %w", err)
+	}
+	if current != schema.Namespace {
+		return nil
+	}
+	want := dynamo.TableDefinition(table, dynamo.LayoutNamespace, opts)
+	for _, gsi := range dynamo.MissingIndexes(want, out.Table) {
+		if aws.ToString(gsi.IndexName) != schema.ActorIndex {
+			continue
+		}
+		if _, err := api.UpdateTable(ctx, dynamo.AddIndexInput(want, gsi)); err != nil {
+			return fmt.Errorf("upgrade: add %s: %w", schema.ActorIndex, err)
+		}
+		if err := waitForIndex(ctx, api, table, schema.ActorIndex); err != nil {
+			return fmt.Errorf("upgrade: add %s: %w", schema.ActorIndex, err)
+		}
+	}
+	return nil
+}
+
+// BackfillValues rewrites the number, boolean and JSON values stored as
+// text before values were typed to their native attributes, in a table of
+// any schema. Server tables copied from the user schema carry such values
+// too.
+func BackfillValues(ctx context.Context, api UpgradeAPI, table string, progress func(updated int)) (int, error) {
+	current, err := describeSchema(ctx, api, table)
+	if err != nil {
+		return 0, err
+	}
+	return backfillValues(ctx, api, table, current.HashKey(), progress)
+}
+
+// BackfillTombstones rewrites the deletions written before tombstones had
+// their own data type, JSON facts without a value and facts marked
+// IsDeleted, as tombstones, in a table of any schema. Stores read them as
+// tombstones either way; the rewrite lets anything reading the table
+// directly tell deletions by their data type alone.
+func BackfillTombstones(ctx context.Context, api UpgradeAPI, table string, progress func(updated int)) (int, error) {
+	current, err := describeSchema(ctx, api, table)
+	if err != nil {
+		return 0, err
+	}
+	return backfillTombstones(ctx, api, table, current.HashKey(), progress)
+}
+
+// RekeyEscapedFields rewrites the FieldKey, NamespaceKey and ActorKey of
+// facts written before key components were escaped, in a table of any
+// schema. Only facts whose user, namespace, field or actor contains '%' or
+// '#' have different keys now; until they are rewritten field, namespace
+// and actor queries miss them. Partition keys are left alone: the server's
+// namespaces are built from table names, which never contain either.
+func RekeyEscapedFields(ctx context.Context, api UpgradeAPI, table string, progress func(updated int)) (int, error) {
+	current, err := describeSchema(ctx, api, table)
+	if err != nil {
+		return 0, err
+	}
+	return rekeyEscapedFields(ctx, api, table, current.HashKey(), progress)
+}
+
+// rekeyEscapedFields scans table for facts with a key component needing an
+// escape and sets each key that differs from its escaped value, unless the
+// fact was purged since the scan
+func rekeyEscapedFields(ctx context.Context, api UpgradeAPI, table, hash string, progress func(int)) (int, error) {
+	updated := 0
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(table),
+		FilterExpression: aws.String("contains(#uid, :pct) OR contains(#uid, :hash) OR contains(#ns, :pct) OR contains(#ns, :hash) " +
+			"OR contains(#fn, :pct) OR contains(#fn, :hash) OR contains(#au, :pct) OR contains(#au, :hash)"),
+		ExpressionAttributeNames: map[string]string{"#uid": pkName, "#ns": "Namespace", "#fn": "FieldName", "#au": "ActorUserID"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pct":  &types.AttributeValueMemberS{Value: "%"},
+			":hash": &types.AttributeValueMemberS{Value: "#"},
+		},
+	}
+	for {
+		out, err := api.Scan(ctx, input)
+		if err != nil {
+			return updated, fmt.Errorf("upgrade: scan %s: %w", table, err)
+		}
+		for _, item := range out.Items {
+			update, ok := escapedKeys(item)
+			if item[hash] == nil || !ok {
+				continue
+			}
+			_, err := api.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName:                 aws.String(table),
+				Key:                       map[string]types.AttributeValue{hash: item[hash], skName: item[skName]},
+				UpdateExpression:          aws.String(update.expression),
+				ConditionExpression:       aws.String("attribute_exists(#sk)"),
+				ExpressionAttributeNames:  update.names,
+				ExpressionAttributeValues: update.values,
+			})
+			var purged *types.ConditionalCheckFailedException
+			if errors.As(err, &purged) {
+				continue
+			}
+			if err != nil {
+				return updated, fmt.Errorf("upgrade: update %s: %w", table, err)
+			}
+			updated++
+		}
+		if progress != nil {
+			progress(updated)
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return updated, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// keyUpdate is an UpdateItem setting the keys of a fact
+type keyUpdate struct {
+	expression string
+	names      map[string]string
+	values     map[string]types.AttributeValue
+}
+
+// escapedKeys returns the update setting the keys of a fact item that
+// differ from their escaped values, reporting false if none do. Keys the
+// item lacks, such as the ActorKey of facts without an actor, stay unset.
+func escapedKeys(item map[string]types.AttributeValue) (keyUpdate, bool) {
+	attr := func(name string) string {
+		v, _ := item[name].(*types.AttributeValueMemberS)
+		if v == nil {
+			return ""
+		}
+		return v.Value
+	}
+	user, namespace := attr(pkName), attr("Namespace")
+	if user == "" {
+		return keyUpdate{}, false
+	}
+	want := map[string]string{
+		fieldKeyName:     schema.FieldKeyValue(user, namespace, attr("FieldName")),
+		namespaceKeyName: schema.NamespaceValue(user, namespace),
+		schema.ActorKey:  schema.ActorValue(user, attr("ActorUserID")),
+	}
+	update := keyUpdate{names: map[string]string{"#sk": skName}, values: map[string]types.AttributeValue{}}
+	var sets []string
+	for i, name := range []string{fieldKeyName, namespaceKeyName, schema.ActorKey} {
+		if _, ok := item[name]; !ok || attr(name) == want[name] {
+			continue
+		}
+		update.names[fmt.Sprintf("#k%d", i)] = name
+		update.values[fmt.Sprintf(":k%d", i)] = &types.AttributeValueMemberS{Value: want[name]}
+		sets = append(sets, fmt.Sprintf("#k%d = :k%d", i, i))
+	}
+	if len(sets) == 0 {
+		return keyUpdate{}, false
+	}
+	update.expression = "SET " + strings.Join(sets, ", ")
+	return update, true
+}
+
+// describeSchema returns the schema of a table
+func describeSchema(ctx context.Context, api UpgradeAPI, table string) (schema.Schema, error) {
+	out, err := api.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	if err != nil {
+		return 0, fmt.Errorf("upgrade: describe %s: %w", table, err)
+	}
+	current, err := schema.Detect(out.Table)
+	if err != nil {
+		return 0, fmt.Errorf("upgrade: %w", err)
+	}
+	return current, nil
+}
+
+// backfillNamespaceKeys scans table for facts without a NamespaceKey and
+// sets it from their UserID and Namespace
+func backfillNamespaceKeys(ctx context.Context, api UpgradeAPI, table string, progress func(int)) (int, error) {
+	updated := 0
+	input := &dynamodb.ScanInput{
+		TableName:                aws.String(table),
+		ProjectionExpression:     aws.String("#uid, #sk, #ns"),
+		FilterExpression:         aws.String("attribute_not_exists(#nk)"),
+		ExpressionAttributeNames: map[string]string{"#uid": pkName, "#sk": skName, "#ns": "Namespace", "#nk": namespaceKeyName},
+	}
+	for {
+		out, err := api.Scan(ctx, input)
+		if err != nil {
+			return updated, fmt.Errorf("upgrade: scan %s: %w", table, err)
+		}
+		for _, item := range out.Items {
+			user, _ := item[pkName].(*types.AttributeValueMemberS)
+			ns, _ := item["Namespace"].(*types.AttributeValueMemberS)
+			if user == nil || ns == nil {
+				continue
+			}
+			_, err := api.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName:                 aws.String(table),
+				Key:                       map[string]types.AttributeValue{pkName: user, skName: item[skName]},
+				UpdateExpression:          aws.String("SET #nk = :nk"),
+				ConditionExpression:       aws.String("attribute_exists(#sk)"),
+				ExpressionAttributeNames:  map[string]string{"#nk": namespaceKeyName, "#sk": skName},
+				ExpressionAttributeValues: map[string]types.AttributeValue{":nk": &types.AttributeValueMemberS{Value: schema.NamespaceValue(user.Value, ns.Value)}},
+			})
+			var purged *types.ConditionalCheckFailedException
+			if errors.As(err, &purged) {
+				continue
+			}
+			if err != nil {
+				return updated, fmt.Errorf("upgrade: update %s: %w", table, err)
+			}
+			updated++
+		}
+		if progress != nil {
+			progress(updated)
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return updated, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// backfillValues scans table for number, boolean and JSON facts whose value
+// is text and stores each as its native attribute. Text that is not valid
+// JSON, which only JSON facts written as strings have, stays as it is.
+func backfillValues(ctx context.Context, api UpgradeAPI, table, hash string, progress func(int)) (int, error) {
+	updated := 0
+	input := &dynamodb.ScanInput{
+		TableName:                aws.String(table),
+		ProjectionExpression:     aws.String("#pk, #sk, #dt, #v"),
+		FilterExpression:         aws.String("attribute_type(#v, :s) AND #dt IN (:number, :boolean, :json)"),
+		ExpressionAttributeNames: map[string]string{"#pk": hash, "#sk": skName, "#dt": "DataType", "#v": "Value"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":s":       &types.AttributeValueMemberS{Value: string(types.ScalarAttributeTypeS)},
+			":number":  &types.AttributeValueMemberS{Value: string(DataTypeNumber)},
+			":boolean": &types.AttributeValueMemberS{Value: string(DataTypeBoolean)},
+			":json":    &types.AttributeValueMemberS{Value: string(DataTypeJSON)},
+		},
+	}
+	for {
+		out, err := api.Scan(ctx, input)
+		if err != nil {
+			return updated, fmt.Errorf("upgrade: scan %s: %w", table, err)
+		}
+		for _, item := range out.Items {
+			dataType, _ := item["DataType"].(*types.AttributeValueMemberS)
+			text, _ := item["Value"].(*types.AttributeValueMemberS)
+			if item[hash] == nil || dataType == nil || text == nil {
+				continue
+			}
+			value := textValue(DataType(dataType.Value), text.Value)
+			if value[0] == '"' {
+				continue
+			}
+			av, err := valueAttribute(Fact{Value: value})
+			if err != nil {
+				return updated, fmt.Errorf("upgrade: %w", err)
+			}
+			_, err = api.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName:                 aws.String(table),
+				Key:                       map[string]types.AttributeValue{hash: item[hash], skName: item[skName]},
+				UpdateExpression:          aws.String("SET #v = :v"),
+				ConditionExpression:       aws.String("#v = :text"),
+				ExpressionAttributeNames:  map[string]string{"#v": "Value"},
+				ExpressionAttributeValues: map[string]types.AttributeValue{":v": av, ":text": text},
+			})
+			var changed *types.ConditionalCheckFailedException
+			if errors.As(err, &changed) {
+				continue
+			}
+			if err != nil {
+				return updated, fmt.Errorf("upgrade: update %s: %w", table, err)
+			}
+			updated++
+		}
+		if progress != nil {
+			progress(updated)
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return updated, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// backfillTombstones scans table for deletions that are not tombstones and
+// rewrites each as one, unless it changed since the scan
+func backfillTombstones(ctx context.Context, api UpgradeAPI, table, hash string, progress func(int)) (int, error) {
+	updated := 0
+	input := &dynamodb.ScanInput{
+		TableName:            aws.String(table),
+		ProjectionExpression: aws.String("#pk, #sk, #dt, #v, #del"),
+		FilterExpression: aws.String("(#dt = :json AND (attribute_not_exists(#v) OR attribute_type(#v, :nulltype) OR #v = :nulltext)) " +
+			"OR (#del = :true AND #dt <> :deleted)"),
+		ExpressionAttributeNames: map[string]string{"#pk": hash, "#sk": skName, "#dt": "DataType", "#v": "Value", "#del": isDeletedName},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":json":     &types.AttributeValueMemberS{Value: string(DataTypeJSON)},
+			":nulltype": &types.AttributeValueMemberS{Value: "NULL"},
+			":nulltext": &types.AttributeValueMemberS{Value: "null"},
+			":true":     &types.AttributeValueMemberBOOL{Value: true},
+			":deleted":  &types.AttributeValueMemberS{Value: string(DataTypeDeleted)},
+		},
+	}
+	for {
+		out, err := api.Scan(ctx, input)
+		if err != nil {
+			return updated, fmt.Errorf("upgrade: scan %s: %w", table, err)
+		}
+		for _, item := range out.Items {
+			dataType, _ := item["DataType"].(*types.AttributeValueMemberS)
+			if item[hash] == nil || dataType == nil || !legacyTombstone(DataType(dataType.Value), item) {
+				continue
+			}
+			_, err = api.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName:                aws.String(table),
+				Key:                      map[string]types.AttributeValue{hash: item[hash], skName: item[skName]},
+				UpdateExpression:         aws.String("SET #dt = :deleted, #v = :null, #del = :true"),
+				ConditionExpression:      aws.String("#dt = :old"),
+				ExpressionAttributeNames: map[string]string{"#dt": "DataType", "#v": "Value", "#del": isDeletedName},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":deleted": &types.AttributeValueMemberS{Value: string(DataTypeDeleted)},
+					":null":    &types.AttributeValueMemberNULL{Value: true},
+					":true":    &types.AttributeValueMemberBOOL{Value: true},
+					":old":     dataType,
+				},
+			})
+			var changed *types.ConditionalCheckFailedException
+			if errors.As(err, &changed) {
+				continue
+			}
+			if err != nil {
+				return updated, fmt.Errorf("upgrade: update %s: %w", table, err)
+			}
+			updated++
+		}
+		if progress != nil {
+			progress(updated)
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return updated, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// legacyTombstone reports whether an item of the given data type is a
+// deletion in one of the encodings normalizeTombstone reads
+func legacyTombstone(dataType DataType, item map[string]types.AttributeValue) bool {
+	if deleted, ok := item[isDeletedName].(*types.AttributeValueMemberBOOL); ok && deleted.Value {
+		return dataType != DataTypeDeleted
+	}
+	if dataType != DataTypeJSON {
+		return false
+	}
+	switch v := item["Value"].(type) {
+	case nil, *types.AttributeValueMemberNULL:
+		return true
+	case *types.AttributeValueMemberS:
+		return v.Value == "null"
+	}
+	return false
+}
+
+// waitForIndex polls table until index is active and backfilled
+func waitForIndex(ctx context.Context, api UpgradeAPI, table, index string) error {
+	for {
+		out, err := api.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+		if err != nil {
+			return err
+		}
+		for _, gsi := range out.Table.GlobalSecondaryIndexes {
+			if aws.ToString(gsi.IndexName) == index && gsi.IndexStatus == types.IndexStatusActive && !aws.ToBool(gsi.Backfilling) {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(indexPollInterval):
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// upgradeAPI is a table in the user schema, or with keys if set, whose
+// added indexes backfill after one more DescribeTable call
+type upgradeAPI struct {
+	keys    []types.KeySchemaElement
+	items   []map[string]types.AttributeValue
+	updates []*dynamodb.UpdateItemInput
+	added   []string
+	polls   int
+}
+
+func (f *upgradeAPI) DescribeTable(ctx context.Context, in *dynamodb.DescribeTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	desc := &types.TableDescription{
+		TableName: in.TableName,
+		KeySchema: f.keys,
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndexDescription{
+			{IndexName: aws.String(schema.FieldIndex), IndexStatus: types.IndexStatusActive},
+		},
+	}
+	if desc.KeySchema == nil {
+		desc.KeySchema = schema.Definition("Facts", schema.User).KeySchema
+	}
+	for _, name := range f.added {
+		status := types.IndexStatusCreating
+		if f.polls++; f.polls > 2 {
+			status = types.IndexStatusActive
+		}
+		desc.GlobalSecondaryIndexes = append(desc.GlobalSecondaryIndexes, types.GlobalSecondaryIndexDescription{IndexName: aws.String(name), IndexStatus: status})
+	}
+	return &dynamodb.DescribeTableOutput{Table: desc}, nil
+}
+
+func (f *upgradeAPI) Scan(ctx context.Context, in *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	return &dynamodb.ScanOutput{Items: f.items}, nil
+}
+
+func (f *upgradeAPI) UpdateItem(ctx context.Context, in *dynamodb.UpdateItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	f.updates = append(f.updates, in)
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (f *upgradeAPI) UpdateTable(ctx context.Context, in *dynamodb.UpdateTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateTableOutput, error) {
+	for _, u := range in.GlobalSecondaryIndexUpdates {
+		f.added = append(f.added, aws.ToString(u.Create.IndexName))
+	}
+	return &dynamodb.UpdateTableOutput{}, nil
+}
+
+func TestUpgradeTable(t *testing.T) {
+	defer func(d time.Duration) { indexPollInterval = d }(indexPollInterval)
+	indexPollInterval = time.Millisecond
+
+	api := &upgradeAPI{items: []map[string]types.AttributeValue{
+		{pkName: &types.AttributeValueMemberS{Value: "u1"}, skName: &types.AttributeValueMemberS{Value: "2024-01-01T00:00:00Z#f1"}, "Namespace": &types.AttributeValueMemberS{Value: "orders"}},
+	}}
+	updated, err := UpgradeTable(context.Background(), api, "Facts", dynamo.TableOptions{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, updated)
+	assert.Equal(t, []string{namespaceGSIName}, api.added)
+	require.Len(t, api.updates, 1)
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "u1#orders"}, api.updates[0].ExpressionAttributeValues[":nk"])
+	assert.Greater(t, api.polls, 2, "the upgrade waits for the index to become active")
+}
+
+func TestUpgradeTableTypesValues(t *testing.T) {
+	item := func(id, dataType, value string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			pkName:     &types.AttributeValueMemberS{Value: "u1"},
+			skName:     &types.AttributeValueMemberS{Value: "2024-01-01T00:00:00Z#" + id},
+			"DataType": &types.AttributeValueMemberS{Value: dataType},
+			"Value":    &types.AttributeValueMemberS{Value: value},
+		}
+	}
+	api := &upgradeAPI{items: []map[string]types.AttributeValue{
+		item("f1", "number", "12345678901234567890"),
+		item("f2", "boolean", "true"),
+		item("f3", "json", `{"n":1}`),
+		item("f4", "json", "not json"),
+	}}
+	api.items[0][namespaceKeyName] = &types.AttributeValueMemberS{Value: "u1#orders"}
+
+	var counts []int
+	updated, err := BackfillValues(context.Background(), api, "Facts", func(n int) { counts = append(counts, n) })
+	require.NoError(t, err)
+	assert.Equal(t, 3, updated)
+	assert.Equal(t, []int{3}, counts)
+	require.Len(t, api.updates, 3)
+	assert.Equal(t, &types.AttributeValueMemberN{Value: "12345678901234567890"}, api.updates[0].ExpressionAttributeValues[":v"])
+	assert.Equal(t, &types.AttributeValueMemberBOOL{Value: true}, api.updates[1].ExpressionAttributeValues[":v"])
+	assert.Equal(t, &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"n": &types.AttributeValueMemberN{Value: "1"}}}, api.updates[2].ExpressionAttributeValues[":v"])
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "true"}, api.updates[1].ExpressionAttributeValues[":text"], "updates are conditional on the value being unchanged")
+}
+
+func TestBackfillTombstones(t *testing.T) {
+	item := func(id, dataType string, value types.AttributeValue) map[string]types.AttributeValue {
+		item := map[string]types.AttributeValue{
+			pkName:     &types.AttributeValueMemberS{Value: "u1"},
+			skName:     &types.AttributeValueMemberS{Value: "2024-01-01T00:00:00Z#" + id},
+			"DataType": &types.AttributeValueMemberS{Value: dataType},
+		}
+		if value != nil {
+			item["Value"] = value
+		}
+		return item
+	}
+	api := &upgradeAPI{items: []map[string]types.AttributeValue{
+		item("f1", "json", &types.AttributeValueMemberNULL{Value: true}),
+		item("f2", "json", nil),
+		item("f3", "json", &types.AttributeValueMemberS{Value: "null"}),
+		item("f4", "number", &types.AttributeValueMemberN{Value: "1"}),
+		item("f5", "json", &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}}),
+		item("f6", "deleted", &types.AttributeValueMemberNULL{Value: true}),
+		item("f7", "table", nil),
+	}}
+	api.items[3][isDeletedName] = &types.AttributeValueMemberBOOL{Value: true}
+	api.items[5][isDeletedName] = &types.AttributeValueMemberBOOL{Value: true}
+
+	updated, err := BackfillTombstones(context.Background(), api, "Facts", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 4, updated)
+	require.Len(t, api.updates, 4)
+	for i, id := range []string{"f1", "f2", "f3", "f4"} {
+		assert.Equal(t, "2024-01-01T00:00:00Z#"+id, api.updates[i].Key[skName].(*types.AttributeValueMemberS).Value)
+		assert.Equal(t, &types.AttributeValueMemberS{Value: "deleted"}, api.updates[i].ExpressionAttributeValues[":deleted"])
+	}
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "number"}, api.updates[3].ExpressionAttributeValues[":old"], "updates are conditional on the data type being unchanged")
+}
+
+func TestRekeyEscapedFields(t *testing.T) {
+	item := func(id, field, actor string, keys map[string]string) map[string]types.AttributeValue {
+		item := map[string]types.AttributeValue{
+			pkName:      &types.AttributeValueMemberS{Value: "u1"},
+			skName:      &types.AttributeValueMemberS{Value: "2024-01-01T00:00:00Z#" + id},
+			"Namespace": &types.AttributeValueMemberS{Value: "orders"},
+			"FieldName": &types.AttributeValueMemberS{Value: field},
+		}
+		if actor != "" {
+			item["ActorUserID"] = &types.AttributeValueMemberS{Value: actor}
+		}
+		for name, key := range keys {
+			item[name] = &types.AttributeValueMemberS{Value: key}
+		}
+		return item
+	}
+	// Keys as they were written before components were escaped
+	api := &upgradeAPI{items: []map[string]types.AttributeValue{
+		item("f1", "a#b", "", map[string]string{fieldKeyName: "u1#orders#a#b", namespaceKeyName: "u1#orders"}),
+		item("f2", "50%", "", map[string]string{fieldKeyName: "u1#orders#50%"}),
+		item("f3", "plain", "", map[string]string{fieldKeyName: "u1#orders#plain"}),
+		item("f4", "plain", "x#y", map[string]string{fieldKeyName: "u1#orders#plain", schema.ActorKey: "u1#x#y"}),
+		item("f5", "a#b", "", map[string]string{fieldKeyName: "u1#orders#a%23b"}),
+		{pkName: &types.AttributeValueMemberS{Value: schema.MarkerPartition}, skName: &types.AttributeValueMemberS{Value: schema.MarkerSortKey}},
+	}}
+
+	var counts []int
+	updated, err := RekeyEscapedFields(context.Background(), api, "Facts", func(n int) { counts = append(counts, n) })
+	require.NoError(t, err)
+	assert.Equal(t, 3, updated)
+	assert.Equal(t, []int{3}, counts)
+	require.Len(t, api.updates, 3)
+	set := func(in *dynamodb.UpdateItemInput) map[string]string {
+		keys := map[string]string{}
+		for name, attr := range in.ExpressionAttributeNames {
+			if name != "#sk" {
+				keys[attr] = in.ExpressionAttributeValues[":"+name[1:]].(*types.AttributeValueMemberS).Value
+			}
+		}
+		return keys
+	}
+	assert.Equal(t, "2024-01-01T00:00:00Z#f1", api.updates[0].Key[skName].(*types.AttributeValueMemberS).Value)
+	assert.Equal(t, map[string]string{fieldKeyName: "u1#orders#a%23b"}, set(api.updates[0]), "keys that need no escape are kept")
+	assert.Equal(t, map[string]string{fieldKeyName: "u1#orders#50%25"}, set(api.updates[1]))
+	assert.Equal(t, map[string]string{schema.ActorKey: "u1#x%23y"}, set(api.updates[2]))
+	assert.Equal(t, "attribute_exists(#sk)", aws.ToString(api.updates[0].ConditionExpression), "purged facts are not recreated")
+}
+
+func TestAddActorIndex(t *testing.T) {
+	defer func(d time.Duration) { indexPollInterval = d }(indexPollInterval)
+	indexPollInterval = time.Millisecond
+	ctx := context.Background()
+
+	api := &upgradeAPI{keys: schema.Definition("Facts", schema.Namespace).KeySchema}
+	require.NoError(t, AddActorIndex(ctx, api, "Facts", dynamo.TableOptions{}))
+	assert.Equal(t, []string{schema.ActorIndex}, api.added, "only the ActorIndex is added")
+	assert.Greater(t, api.polls, 2, "the upgrade waits for the index to become active")
+
+	require.NoError(t, AddActorIndex(ctx, api, "Facts", dynamo.TableOptions{}))
+	assert.Equal(t, []string{schema.ActorIndex}, api.added, "an existing index is not added again")
+
+	api = &upgradeAPI{}
+	require.NoError(t, AddActorIndex(ctx, api, "Facts", dynamo.TableOptions{}))
+	assert.Empty(t, api.added, "store tables have no ActorIndex")
+}
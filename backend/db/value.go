@@ -0,0 +1,137 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// EncodeValue returns the JSON encoding of v for Fact.Value. Encoded
+// values, json.RawMessage, are returned as they are.
+func EncodeValue(v interface{}) (json.RawMessage, error) {
+	if raw, ok := v.(json.RawMessage); ok {
+		return raw, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("encode fact value: %w", err)
+	}
+	return raw, nil
+}
+
+// StringValue returns the Fact.Value of the string s
+func StringValue(s string) json.RawMessage {
+	raw, _ := json.Marshal(s)
+	return raw
+}
+
+// DecodeValue returns the value of the fact: nil, a bool, float64, string,
+// []interface{} or map[string]interface{}, as encoding/json decodes it
+func (f Fact) DecodeValue() (interface{}, error) {
+	if len(f.Value) == 0 {
+		return nil, nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(f.Value, &v); err != nil {
+		return nil, fmt.Errorf("decode value of fact %s: %w", f.ID, err)
+	}
+	return v, nil
+}
+
+// Text returns the value of the fact as text: a string value as it is and
+// any other value as JSON
+func (f Fact) Text() string {
+	var s string
+	if len(f.Value) > 0 && f.Value[0] == '"' && json.Unmarshal(f.Value, &s) == nil {
+		return s
+	}
+	return string(f.Value)
+}
+
+// textValue returns the Fact.Value of text stored before values were typed,
+// when every value was a string: numbers, booleans and JSON documents were
+// stored as their JSON text, anything else as it is
+func textValue(dataType DataType, text string) json.RawMessage {
+	switch dataType {
+	case DataTypeNumber, DataTypeBoolean, DataTypeJSON:
+		if json.Valid([]byte(text)) {
+			return json.RawMessage(text)
+		}
+	}
+	return StringValue(text)
+}
+
+// valueAttribute returns the DynamoDB attribute storing the value of a fact
+// with its native type: numbers as N, booleans as BOOL, objects as M and
+// arrays as L, so filter and key expressions can compare them. Numbers keep
+// their digits, and a fact without a value stores NULL.
+func valueAttribute(f Fact) (types.AttributeValue, error) {
+	if len(f.Value) == 0 {
+		return &types.AttributeValueMemberNULL{Value: true}, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(f.Value))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("decode value of fact %s: %w", f.ID, err)
+	}
+	av, err := attributevalue.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal value of fact %s: %w", f.ID, err)
+	}
+	return av, nil
+}
+
+// attributeValue returns the Fact.Value stored in a DynamoDB attribute by
+// valueAttribute or dynamo.Client. Strings are read with textValue, since
+// values written before they were typed are text whatever their data type.
+func attributeValue(dataType DataType, av types.AttributeValue) (json.RawMessage, error) {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return textValue(dataType, v.Value), nil
+	case *types.AttributeValueMemberNULL:
+		return nil, nil
+	}
+	return EncodeValue(attributeJSON(av))
+}
+
+// attributeJSON returns the value of an attribute as encoding/json encodes
+// it, numbers as json.Number so they keep their digits
+func attributeJSON(av types.AttributeValue) interface{} {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return v.Value
+	case *types.AttributeValueMemberN:
+		return json.Number(v.Value)
+	case *types.AttributeValueMemberBOOL:
+		return v.Value
+	case *types.AttributeValueMemberB:
+		return v.Value
+	case *types.AttributeValueMemberSS:
+		return v.Value
+	case *types.AttributeValueMemberBS:
+		return v.Value
+	case *types.AttributeValueMemberNS:
+		numbers := make([]json.Number, len(v.Value))
+		for i, n := range v.Value {
+			numbers[i] = json.Number(n)
+		}
+		return numbers
+	case *types.AttributeValueMemberL:
+		list := make([]interface{}, len(v.Value))
+		for i, item := range v.Value {
+			list[i] = attributeJSON(item)
+		}
+		return list
+	case *types.AttributeValueMemberM:
+		m := make(map[string]interface{}, len(v.Value))
+		for k, item := range v.Value {
+			m[k] = attributeJSON(item)
+		}
+		return m
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/dynamo"
+)
+
+func TestFactValues(t *testing.T) {
+	raw, err := EncodeValue(map[string]interface{}{"n": 1})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"n":1}`, string(raw))
+
+	raw, err = EncodeValue(json.RawMessage(`[1,2]`))
+	require.NoError(t, err)
+	assert.Equal(t, `[1,2]`, string(raw), "encoded values are kept as they are")
+
+	assert.Equal(t, "Ada", Fact{Value: StringValue("Ada")}.Text())
+	assert.Equal(t, "42", Fact{Value: json.RawMessage(`42`)}.Text())
+	assert.Equal(t, "null", Fact{Value: json.RawMessage(`null`)}.Text())
+
+	v, err := Fact{Value: json.RawMessage(`true`)}.DecodeValue()
+	require.NoError(t, err)
+	assert.Equal(t, true, v)
+	v, err = Fact{}.DecodeValue()
+	require.NoError(t, err)
+	assert.Nil(t, v)
+}
+
+func TestStoreAdapterKeepsValueTypes(t *testing.T) {
+	ctx := context.Background()
+	adapter := NewStoreAdapter(NewMemoryStore())
+	now := time.Now().UTC()
+
+	facts := []dynamo.Fact{
+		{ID: "s", Timestamp: now, Namespace: "u1/t", FieldName: "s", DataType: "string", Value: "42"},
+		{ID: "n", Timestamp: now, Namespace: "u1/t", FieldName: "n", DataType: "number", Value: 42.5},
+		{ID: "b", Timestamp: now, Namespace: "u1/t", FieldName: "b", DataType: "boolean", Value: false},
+		{ID: "j", Timestamp: now, Namespace: "u1/t", FieldName: "j", DataType: "json", Value: map[string]interface{}{"tags": []interface{}{"a"}}},
+	}
+	for _, fact := range facts {
+		require.NoError(t, adapter.PutFact(ctx, fact))
+	}
+
+	for _, want := range facts {
+		got, err := adapter.GetFactByID(ctx, want.ID)
+		require.NoError(t, err)
+		assert.Equal(t, want.Value, got.Value, want.ID)
+	}
+}
+
+func TestUnmarshalFactItemValues(t *testing.T) {
+	item := func(dataType string, value types.AttributeValue) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"ID":       &types.AttributeValueMemberS{Value: "f1"},
+			skName:     &types.AttributeValueMemberS{Value: "2024-01-01T00:00:00Z#f1"},
+			"DataType": &types.AttributeValueMemberS{Value: dataType},
+			"Value":    value,
+		}
+	}
+
+	facts, err := unmarshalFactItems([]map[string]types.AttributeValue{
+		item("string", &types.AttributeValueMemberS{Value: "42"}),
+		item("number", &types.AttributeValueMemberS{Value: "42"}),
+		item("json", &types.AttributeValueMemberS{Value: `{"a":1}`}),
+		item("number", &types.AttributeValueMemberN{Value: "7"}),
+		item("boolean", &types.AttributeValueMemberBOOL{Value: true}),
+		item("string", &types.AttributeValueMemberNULL{Value: true}),
+	})
+	require.NoError(t, err)
+	require.Len(t, facts, 6)
+	assert.Equal(t, `"42"`, string(facts[0].Value))
+	assert.Equal(t, `42`, string(facts[1].Value), "numbers stored as text are read as numbers")
+	assert.JSONEq(t, `{"a":1}`, string(facts[2].Value))
+	assert.Equal(t, `7`, string(facts[3].Value))
+	assert.Equal(t, `true`, string(facts[4].Value))
+	assert.Empty(t, facts[5].Value)
+}
+
+func TestFactItemStoresNativeValues(t *testing.T) {
+	store := testDynamoDBStore(nil)
+	for _, tc := range []struct {
+		dataType DataType
+		value    string
+		want     types.AttributeValue
+	}{
+		{DataTypeString, `"42"`, &types.AttributeValueMemberS{Value: "42"}},
+		{DataTypeNumber, `12345678901234567890.5`, &types.AttributeValueMemberN{Value: "12345678901234567890.5"}},
+		{DataTypeBoolean, `false`, &types.AttributeValueMemberBOOL{Value: false}},
+		{DataTypeJSON, `{"tags":["a"],"n":2}`, &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			"tags": &types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberS{Value: "a"}}},
+			"n":    &types.AttributeValueMemberN{Value: "2"},
+		}}},
+		{DataTypeString, ``, &types.AttributeValueMemberNULL{Value: true}},
+	} {
+		fact := &Fact{ID: "f1", Timestamp: time.Now(), Namespace: "orders", FieldName: "r1", DataType: tc.dataType, Value: json.RawMessage(tc.value)}
+		item, err := store.factItem(fact)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, item["Value"], tc.value)
+
+		facts, err := unmarshalFactItems([]map[string]types.AttributeValue{item})
+		require.NoError(t, err)
+		if tc.value == "" {
+			assert.Empty(t, facts[0].Value)
+		} else {
+			assert.JSONEq(t, tc.value, string(facts[0].Value), "values read back as they were written")
+		}
+	}
+
+	_, err := store.factItem(&Fact{ID: "f1", Value: json.RawMessage(`{`)})
+	assert.Error(t, err)
+}
@@ -0,0 +1,131 @@
+package dynamo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// ScalingTarget is one scalable capacity dimension of a table or index
+type ScalingTarget struct {
+	// ResourceID is "table/<name>" or "table/<name>/index/<index>"
+	ResourceID string
+	// Dimension is e.g. "dynamodb:table:WriteCapacityUnits"
+	Dimension         string
+	Min, Max          int64
+	TargetUtilization float64
+}
+
+// Autoscaler registers the target tracking scaling of table capacity
+type Autoscaler interface {
+	Scale(ctx context.Context, target ScalingTarget) error
+}
+
+// WithAutoscaler sets the autoscaler used for tables created with
+// AutoScaling options and returns the client. NewClient sets one calling
+// Application Auto Scaling with the client's AWS config.
+func (c *Client) WithAutoscaler(a Autoscaler) *Client {
+	c.autoscaler = a
+	return c
+}
+
+// NewAutoscaler returns an Autoscaler calling the Application Auto Scaling
+// API of cfg's region with its credentials
+func NewAutoscaler(cfg aws.Config) Autoscaler {
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &appAutoscaler{
+		cfg:      cfg,
+		http:     client,
+		endpoint: fmt.Sprintf("https://application-autoscaling.%s.amazonaws.com/", cfg.Region),
+	}
+}
+
+// appAutoscaler speaks the JSON protocol of Application Auto Scaling
+type appAutoscaler struct {
+	cfg      aws.Config
+	http     aws.HTTPClient
+	endpoint string
+}
+
+// Scale registers target as a scalable target with a target tracking policy
+func (a *appAutoscaler) Scale(ctx context.Context, target ScalingTarget) error {
+	err := a.call(ctx, "RegisterScalableTarget", map[string]interface{}{
+		"ServiceNamespace":  "dynamodb",
+		"ResourceId":        target.ResourceID,
+		"ScalableDimension": target.Dimension,
+		"MinCapacity":       target.Min,
+		"MaxCapacity":       target.Max,
+	})
+	if err != nil {
+		return err
+	}
+	metric := "DynamoDBReadCapacityUtilization"
+	if strings.HasSuffix(target.Dimension, "WriteCapacityUnits") {
+		metric = "DynamoDBWriteCapacityUtilization"
+	}
+	return a.call(ctx, "PutScalingPolicy", map[string]interface{}{
+		"PolicyName":        strings.ReplaceAll(target.ResourceID, "/", "-") + "-" + metric,
+		"ServiceNamespace":  "dynamodb",
+		"ResourceId":        target.ResourceID,
+		"ScalableDimension": target.Dimension,
+		"PolicyType":        "TargetTrackingScaling",
+		"TargetTrackingScalingPolicyConfiguration": map[string]interface{}{
+			"TargetValue":                   target.TargetUtilization,
+			"PredefinedMetricSpecification": map[string]string{"PredefinedMetricType": metric},
+		},
+	})
+}
+
+// call makes one signed Application Auto Scaling request
+func (a *appAutoscaler) call(ctx context.Context, operation string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AnyScaleFrontendService."+operation)
+
+	creds, err := a.cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: retrieve credentials: %w", operation, err)
+	}
+	sum := sha256.Sum256(payload)
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), "application-autoscaling", a.cfg.Region, time.Now()); err != nil {
+		return fmt.Errorf("%s: sign request: %w", operation, err)
+	}
+
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var apiErr struct {
+		Type    string `json:"__type"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &apiErr) == nil && apiErr.Type != "" {
+		return fmt.Errorf("%s: %s: %s", operation, apiErr.Type, apiErr.Message)
+	}
+	return fmt.Errorf("%s: HTTP %d", operation, resp.StatusCode)
+}
@@ -0,0 +1,141 @@
+package dynamo
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// CapacityMeter adds up the DynamoDB capacity units consumed by the calls
+// made with a context carrying it. Calls made without one do not ask
+// DynamoDB for their consumed capacity. Meters nest: a call is counted by
+// every meter of its context.
+type CapacityMeter struct {
+	mu     sync.Mutex
+	units  float64
+	parent *CapacityMeter
+}
+
+type capacityMeterKey struct{}
+
+// WithCapacityMeter returns a context metering the capacity consumed by the
+// DynamoDB calls made with it, and the meter
+func WithCapacityMeter(ctx context.Context) (context.Context, *CapacityMeter) {
+	parent, _ := ctx.Value(capacityMeterKey{}).(*CapacityMeter)
+	m := &CapacityMeter{parent: parent}
+	return context.WithValue(ctx, capacityMeterKey{}, m), m
+}
+
+// Units returns the capacity units consumed so far
+func (m *CapacityMeter) Units() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.units
+}
+
+// ConsumedCapacityMode returns the ReturnConsumedCapacity setting for a call
+// made with ctx: TOTAL when ctx carries a meter, otherwise unset
+func ConsumedCapacityMode(ctx context.Context) types.ReturnConsumedCapacity {
+	if _, ok := ctx.Value(capacityMeterKey{}).(*CapacityMeter); ok {
+		return types.ReturnConsumedCapacityTotal
+	}
+	return ""
+}
+
+// RecordConsumedCapacity adds the capacity reported by a DynamoDB response
+// to the meter of ctx, if any
+func RecordConsumedCapacity(ctx context.Context, consumed ...types.ConsumedCapacity) {
+	var units float64
+	for _, c := range consumed {
+		units += aws.ToFloat64(c.CapacityUnits)
+	}
+	m, _ := ctx.Value(capacityMeterKey{}).(*CapacityMeter)
+	for ; m != nil; m = m.parent {
+		m.mu.Lock()
+		m.units += units
+		m.mu.Unlock()
+	}
+}
+
+// recordCapacity records the capacity of a response holding at most one
+// ConsumedCapacity
+func recordCapacity(ctx context.Context, c *types.ConsumedCapacity) {
+	if c != nil {
+		RecordConsumedCapacity(ctx, *c)
+	}
+}
+
+// meteredAPI asks DynamoDB for the capacity consumed by calls whose context
+// carries a CapacityMeter and adds it to the meter
+type meteredAPI struct {
+	dynamoDBAPI
+}
+
+func (m meteredAPI) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if mode := ConsumedCapacityMode(ctx); mode != "" {
+		params.ReturnConsumedCapacity = mode
+	}
+	out, err := m.dynamoDBAPI.PutItem(ctx, params, optFns...)
+	if err == nil {
+		recordCapacity(ctx, out.ConsumedCapacity)
+	}
+	return out, err
+}
+
+func (m meteredAPI) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	if mode := ConsumedCapacityMode(ctx); mode != "" {
+		params.ReturnConsumedCapacity = mode
+	}
+	out, err := m.dynamoDBAPI.Query(ctx, params, optFns...)
+	if err == nil {
+		recordCapacity(ctx, out.ConsumedCapacity)
+	}
+	return out, err
+}
+
+func (m meteredAPI) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	if mode := ConsumedCapacityMode(ctx); mode != "" {
+		params.ReturnConsumedCapacity = mode
+	}
+	out, err := m.dynamoDBAPI.DeleteItem(ctx, params, optFns...)
+	if err == nil {
+		recordCapacity(ctx, out.ConsumedCapacity)
+	}
+	return out, err
+}
+
+func (m meteredAPI) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	if mode := ConsumedCapacityMode(ctx); mode != "" {
+		params.ReturnConsumedCapacity = mode
+	}
+	out, err := m.dynamoDBAPI.TransactWriteItems(ctx, params, optFns...)
+	if err == nil {
+		RecordConsumedCapacity(ctx, out.ConsumedCapacity...)
+	}
+	return out, err
+}
+
+func (m meteredAPI) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	if mode := ConsumedCapacityMode(ctx); mode != "" {
+		params.ReturnConsumedCapacity = mode
+	}
+	out, err := m.dynamoDBAPI.Scan(ctx, params, optFns...)
+	if err == nil {
+		recordCapacity(ctx, out.ConsumedCapacity)
+	}
+	return out, err
+}
+
+func (m meteredAPI) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	if mode := ConsumedCapacityMode(ctx); mode != "" {
+		params.ReturnConsumedCapacity = mode
+	}
+	out, err := m.dynamoDBAPI.BatchWriteItem(ctx, params, optFns...)
+	if err == nil {
+		RecordConsumedCapacity(ctx, out.ConsumedCapacity...)
+	}
+	return out, err
+}
@@ -4,27 +4,56 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
-	"strings"
+	"log/slog"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/elibdev/notably/schema"
 )
 
 const (
-	defaultGSIName = "FieldIndex"
-	pkName         = "UserID"
-	skName         = "SK"
-	fieldKeyName   = "FieldKey"
+	defaultGSIName = schema.FieldIndex
+	// userGSIName is the index of the namespace layout keyed by UserID
+	userGSIName = schema.UserIndex
+	// partitionKeyName is the UserID#Namespace partition key of the
+	// namespace layout; pkName is the partition key of the user layout
+	partitionKeyName = schema.PartitionKey
+	pkName           = schema.UserID
+	skName           = schema.SortKey
+	fieldKeyName     = schema.FieldKey
+	actorKeyName     = schema.ActorKey
+	// actorGSIName is the index of the namespace layout keyed by ActorKey
+	actorGSIName = schema.ActorIndex
 )
 
 // ColumnDefinition represents a column in a table with its type
 type ColumnDefinition struct {
 	Name     string `json:"name"`
 	DataType string `json:"dataType"`
+	// Encrypted columns are stored sealed under the server's master key
+	Encrypted bool `json:"encrypted,omitempty" dynamodbav:",omitempty"`
+	// Required columns must be present in every new row
+	Required bool `json:"required,omitempty" dynamodbav:",omitempty"`
+	// Nullable columns accept null in place of a value of their type
+	Nullable bool `json:"nullable,omitempty" dynamodbav:",omitempty"`
+	// Default fills the column of a new row that leaves it out
+	Default interface{} `json:"default,omitempty" dynamodbav:",omitempty"`
+	// Enum lists the allowed values of an enum column
+	Enum []string `json:"enum,omitempty" dynamodbav:",omitempty"`
+	// References names the table whose row IDs a reference column holds
+	References string `json:"references,omitempty" dynamodbav:",omitempty"`
+	// Precision and Scale bound the total digits and the digits after the
+	// point of a decimal column; zero precision means no bound
+	Precision int `json:"precision,omitempty" dynamodbav:",omitempty"`
+	Scale     int `json:"scale,omitempty" dynamodbav:",omitempty"`
+	// OnDelete is what deleting a referenced row does to rows referencing it
+	// through this column: "restrict" blocks the delete, "cascade" deletes
+	// them too, and empty leaves them in place
+	OnDelete string `json:"onDelete,omitempty" dynamodbav:",omitempty"`
 }
 
 // Fact represents a single versioned value for a field.
@@ -37,6 +66,40 @@ type Fact struct {
 	Value     interface{}
 	// For table definitions, this will contain column definitions
 	Columns []ColumnDefinition `json:"columns,omitempty"`
+	// Region is the AWS region the fact was written in, set when the
+	// writing client had one
+	Region string `json:"region,omitempty"`
+	// ActorUserID and APIKeyID record who wrote the fact, which on shared
+	// or admin-edited tables is not always the table's owner
+	ActorUserID string `json:"actorUserId,omitempty"`
+	APIKeyID    string `json:"apiKeyId,omitempty"`
+	// ValidTime is when the fact takes effect, when the writer gave a time
+	// other than Timestamp, the time it was written; see EffectiveTime
+	ValidTime *time.Time `json:"validTime,omitempty"`
+	// ClientDated is set when Timestamp was given by the writing client
+	// rather than taken from the server's clock
+	ClientDated bool `json:"clientDated,omitempty"`
+	// CreatedAt is when the row a fact is a version of was created, carried
+	// from version to version; facts written before rows recorded it have none
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+}
+
+// EffectiveTime returns when f takes effect: its valid time, or the time it
+// was written when it has none
+func (f Fact) EffectiveTime() time.Time {
+	if f.ValidTime != nil {
+		return *f.ValidTime
+	}
+	return f.Timestamp
+}
+
+// DataTypeDeleted is the data type of tombstones, the facts deleting a field
+const DataTypeDeleted = "deleted"
+
+// IsTombstone reports whether f deletes its field. JSON facts without a value
+// are the tombstones the server wrote before DataTypeDeleted.
+func (f Fact) IsTombstone() bool {
+	return f.DataType == DataTypeDeleted || (f.DataType == "json" && f.Value == nil)
 }
 
 // dynamoDBAPI defines the interface for DynamoDB operations needed by Client
@@ -44,7 +107,13 @@ type dynamoDBAPI interface {
 	CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error)
 	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
 	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+	DeleteTable(ctx context.Context, params *dynamodb.DeleteTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteTableOutput, error)
 	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	UpdateContinuousBackups(ctx context.Context, params *dynamodb.UpdateContinuousBackupsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateContinuousBackupsOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
 }
 
 // Client wraps DynamoDB operations for facts storage.
@@ -52,179 +121,475 @@ type Client struct {
 	db        dynamoDBAPI
 	tableName string
 	userID    string
+	logger    *slog.Logger
+	observer  Observer
+	// layout is the key schema of the table
+	layout Layout
+	// actorIndex is set when the table has the ActorIndex
+	actorIndex bool
+	// legacyTable, if set, is a table in the user layout also read from
+	legacyTable string
+	// tableOptions tune the table CreateTable creates
+	tableOptions TableOptions
+	autoscaler   Autoscaler
+	// region, if set, is recorded on the facts the client writes
+	region string
 }
 
 // NewClient creates a new Client for the given AWS config, table name, and user ID.
 func NewClient(cfg aws.Config, tableName, userID string) *Client {
 	return &Client{
-		db:        dynamodb.NewFromConfig(cfg),
-		tableName: tableName,
-		userID:    userID,
+		db:         meteredAPI{tracedAPI{dynamodb.NewFromConfig(cfg)}},
+		tableName:  tableName,
+		userID:     userID,
+		logger:     slog.Default(),
+		autoscaler: NewAutoscaler(cfg),
 	}
 }
 
 // NewClientWithDB creates a new Client with a custom DB implementation (useful for testing).
 func NewClientWithDB(db dynamoDBAPI, tableName, userID string) *Client {
 	return &Client{
-		db:        db,
+		db:        meteredAPI{db},
 		tableName: tableName,
 		userID:    userID,
+		logger:    slog.Default(),
 	}
 }
 
-// CreateTable creates the DynamoDB table and the FieldIndex GSI.
+// WithLogger sets the logger used for client diagnostics and returns the client.
+// Log calls pass the operation's context, so request IDs flow through.
+func (c *Client) WithLogger(logger *slog.Logger) *Client {
+	c.logger = logger
+	return c
+}
+
+// WithTableOptions sets the billing and tuning of the table CreateTable
+// creates and returns the client
+func (c *Client) WithTableOptions(o TableOptions) *Client {
+	c.tableOptions = o
+	return c
+}
+
+// CreateTable creates the DynamoDB table and its GSIs in the namespace
+// layout, tuned by the client's TableOptions. If the table exists, the
+// client takes on its layout instead.
 func (c *Client) CreateTable(ctx context.Context) error {
-	input := &dynamodb.CreateTableInput{
-		TableName: aws.String(c.tableName),
-		AttributeDefinitions: []types.AttributeDefinition{
-			{AttributeName: aws.String(pkName), AttributeType: types.ScalarAttributeTypeS},
-			{AttributeName: aws.String(skName), AttributeType: types.ScalarAttributeTypeS},
-			{AttributeName: aws.String(fieldKeyName), AttributeType: types.ScalarAttributeTypeS},
-		},
-		KeySchema: []types.KeySchemaElement{
-			{AttributeName: aws.String(pkName), KeyType: types.KeyTypeHash},
-			{AttributeName: aws.String(skName), KeyType: types.KeyTypeRange},
-		},
-		BillingMode: types.BillingModePayPerRequest,
-		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
-			{
-				IndexName: aws.String(defaultGSIName),
-				KeySchema: []types.KeySchemaElement{
-					{AttributeName: aws.String(fieldKeyName), KeyType: types.KeyTypeHash},
-					{AttributeName: aws.String(skName), KeyType: types.KeyTypeRange},
-				},
-				Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
-			},
-		},
-	}
+	input := TableDefinition(c.tableName, LayoutNamespace, c.tableOptions)
 	_, err := c.db.CreateTable(ctx, input)
-	if err != nil {
+	created := err == nil
+	if !created {
 		var existsErr *types.ResourceInUseException
 		if !errors.As(err, &existsErr) {
 			return fmt.Errorf("create table: %w", err)
 		}
+		out, err := c.db.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(c.tableName)})
+		if err != nil {
+			return fmt.Errorf("describe table: %w", err)
+		}
+		c.layout = layoutOf(out.Table.KeySchema)
+		c.actorIndex = false
+		for _, gsi := range out.Table.GlobalSecondaryIndexes {
+			if aws.ToString(gsi.IndexName) == actorGSIName {
+				c.actorIndex = true
+			}
+		}
+		if out.Table.TableStatus == types.TableStatusActive {
+			return nil
+		}
+	} else {
+		c.layout = LayoutNamespace
+		c.actorIndex = true
 	}
 	waiter := dynamodb.NewTableExistsWaiter(c.db)
+	if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(c.tableName)}, 5*time.Minute); err != nil {
+		return err
+	}
+	if !created {
+		// Whoever created the table configures it
+		return nil
+	}
+	return c.tableOptions.Configure(ctx, c.db, c.autoscaler, input)
+}
+
+// DeleteTable deletes the DynamoDB table and waits until it is gone.
+// Deleting a table that does not exist is not an error.
+func (c *Client) DeleteTable(ctx context.Context) error {
+	_, err := c.db.DeleteTable(ctx, &dynamodb.DeleteTableInput{TableName: aws.String(c.tableName)})
+	if err != nil {
+		var notFound *types.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return fmt.Errorf("delete table: %w", err)
+	}
+	waiter := dynamodb.NewTableNotExistsWaiter(c.db)
 	return waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(c.tableName)}, 5*time.Minute)
 }
 
 // PutFact writes a Fact to DynamoDB.
 func (c *Client) PutFact(ctx context.Context, fact Fact) error {
-	sk := fmt.Sprintf("%s#%s", fact.Timestamp.Format(time.RFC3339Nano), fact.ID)
-	fk := fmt.Sprintf("%s#%s#%s", c.userID, fact.Namespace, fact.FieldName)
-	item := map[string]types.AttributeValue{
-		pkName:       &types.AttributeValueMemberS{Value: c.userID},
-		skName:       &types.AttributeValueMemberS{Value: sk},
-		"Namespace":  &types.AttributeValueMemberS{Value: fact.Namespace},
-		"FieldName":  &types.AttributeValueMemberS{Value: fact.FieldName},
-		"DataType":   &types.AttributeValueMemberS{Value: fact.DataType},
-		fieldKeyName: &types.AttributeValueMemberS{Value: fk},
+	item, err := c.factItem(ctx, fact)
+	if err != nil {
+		return err
 	}
-	av, err := attributevalue.Marshal(fact.Value)
+
+	_, err = c.db.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(c.tableName),
+		Item:      item,
+	})
 	if err != nil {
+		c.logger.ErrorContext(ctx, "dynamodb put failed", "table", c.tableName, "namespace", fact.Namespace, "field", fact.FieldName, "error", err)
 		return err
 	}
+	c.logger.DebugContext(ctx, "dynamodb put", "table", c.tableName, "namespace", fact.Namespace, "field", fact.FieldName)
+	return nil
+}
+
+// factItem returns the DynamoDB item storing a fact
+func (c *Client) factItem(ctx context.Context, fact Fact) (map[string]types.AttributeValue, error) {
+	if err := schema.ValidateID(fact.ID); err != nil {
+		return nil, err
+	}
+	fk := schema.FieldKeyValue(c.userID, fact.Namespace, fact.FieldName)
+	item := c.itemKey(fact)
+	item[pkName] = &types.AttributeValueMemberS{Value: c.userID}
+	item["Namespace"] = &types.AttributeValueMemberS{Value: fact.Namespace}
+	item["FieldName"] = &types.AttributeValueMemberS{Value: fact.FieldName}
+	item["DataType"] = &types.AttributeValueMemberS{Value: fact.DataType}
+	item[fieldKeyName] = &types.AttributeValueMemberS{Value: fk}
+	if region := c.regionOf(fact); region != "" {
+		item[regionName] = &types.AttributeValueMemberS{Value: region}
+	}
+	if fact.ActorUserID != "" {
+		item["ActorUserID"] = &types.AttributeValueMemberS{Value: fact.ActorUserID}
+		item[actorKeyName] = &types.AttributeValueMemberS{Value: schema.ActorValue(c.userID, fact.ActorUserID)}
+	}
+	if fact.APIKeyID != "" {
+		item["APIKeyID"] = &types.AttributeValueMemberS{Value: fact.APIKeyID}
+	}
+	if fact.ValidTime != nil {
+		item["ValidTime"] = &types.AttributeValueMemberS{Value: fact.ValidTime.UTC().Format(time.RFC3339Nano)}
+	}
+	if fact.ClientDated {
+		item["ClientDated"] = &types.AttributeValueMemberBOOL{Value: true}
+	}
+	if fact.CreatedAt != nil {
+		item["CreatedAt"] = &types.AttributeValueMemberS{Value: fact.CreatedAt.UTC().Format(time.RFC3339Nano)}
+	}
+	av, err := attributevalue.Marshal(fact.Value)
+	if err != nil {
+		return nil, err
+	}
 	item["Value"] = av
 
 	// Store column definitions if present
 	if len(fact.Columns) > 0 {
-		log.Printf("Storing %d columns for fact %s.%s: %+v", len(fact.Columns), fact.Namespace, fact.FieldName, fact.Columns)
+		c.logger.DebugContext(ctx, "storing column definitions", "namespace", fact.Namespace, "field", fact.FieldName, "columns", len(fact.Columns))
 		colAv, err := attributevalue.Marshal(fact.Columns)
 		if err != nil {
-			log.Printf("ERROR: Failed to marshal columns: %v", err)
-			return fmt.Errorf("failed to marshal columns: %w", err)
+			return nil, fmt.Errorf("failed to marshal columns: %w", err)
 		}
 		item["Columns"] = colAv
-		log.Printf("Successfully added column definitions to item")
 	} else if fact.DataType == "table" {
-		log.Printf("WARNING: Table fact %s.%s has no columns defined", fact.Namespace, fact.FieldName)
+		c.logger.WarnContext(ctx, "table fact has no columns defined", "namespace", fact.Namespace, "field", fact.FieldName)
+	}
+	if err := schema.ValidateItemSize(item); err != nil {
+		return nil, err
 	}
+	return item, nil
+}
 
-	_, err = c.db.PutItem(ctx, &dynamodb.PutItemInput{
+// PutFactsTransactional writes facts in a single TransactWriteItems call, so
+// either all of them are stored or none are. Each put is conditional on its
+// item not existing yet. DynamoDB allows at most 100 items per transaction.
+func (c *Client) PutFactsTransactional(ctx context.Context, facts []Fact) error {
+	if len(facts) == 0 {
+		return nil
+	}
+	items := make([]types.TransactWriteItem, len(facts))
+	for i, fact := range facts {
+		item, err := c.factItem(ctx, fact)
+		if err != nil {
+			return err
+		}
+		items[i] = types.TransactWriteItem{Put: &types.Put{
+			TableName:           aws.String(c.tableName),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(" + skName + ")"),
+		}}
+	}
+
+	_, err := c.db.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+		c.logger.ErrorContext(ctx, "dynamodb transaction failed", "table", c.tableName, "items", len(items), "error", err)
+		return err
+	}
+	c.logger.DebugContext(ctx, "dynamodb transaction", "table", c.tableName, "items", len(items))
+	return nil
+}
+
+// PurgeFact permanently removes a single fact version. Unlike a tombstone this
+// destroys history, so it is only used once the fact has been copied elsewhere.
+// During a migration the version is removed from the legacy table too.
+func (c *Client) PurgeFact(ctx context.Context, fact Fact) error {
+	_, err := c.db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
 		TableName: aws.String(c.tableName),
-		Item:      item,
+		Key:       c.itemKey(fact),
 	})
-	return err
+	if err != nil {
+		return fmt.Errorf("purge fact %s: %w", factSK(fact), err)
+	}
+	if legacy := c.legacy(); legacy != nil {
+		return legacy.PurgeFact(ctx, fact)
+	}
+	return nil
 }
 
-// QueryByField returns all facts in a namespace/fieldName for the user in the time range [start, end].
-func (c *Client) QueryByField(ctx context.Context, namespace, fieldName string, start, end time.Time) ([]Fact, error) {
-	// Ensure start and end times are valid
+// queryRange fills in the default bounds of a query's time range and
+// checks them
+func queryRange(start, end time.Time) (time.Time, time.Time, error) {
 	if start.IsZero() {
 		start = time.Unix(0, 0) // Use Unix epoch as default start
 	}
 	if end.IsZero() {
 		end = time.Now().UTC() // Use current time as default end
 	}
-
 	// Avoid potential timestamp formatting issues
 	if start.After(end) {
-		return nil, fmt.Errorf("invalid time range: start time (%v) is after end time (%v)", start, end)
+		return start, end, fmt.Errorf("invalid time range: start time (%v) is after end time (%v)", start, end)
 	}
+	return start, end, nil
+}
 
-	fk := fmt.Sprintf("%s#%s#%s", c.userID, namespace, fieldName)
-	skStart := fmt.Sprintf("%s#", start.Format(time.RFC3339Nano))
-	skEnd := fmt.Sprintf("%s#", end.Format(time.RFC3339Nano))
+// query runs a query to completion, following LastEvaluatedKey across pages
+func (c *Client) query(ctx context.Context, input *dynamodb.QueryInput) ([]Fact, error) {
+	var items []map[string]types.AttributeValue
+	for {
+		out, err := c.db.Query(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, out.Items...)
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+	return unmarshalFacts(items)
+}
 
-	// Build query with required key conditions
-	queryInput := &dynamodb.QueryInput{
-		TableName:              aws.String(c.tableName),
-		IndexName:              aws.String(defaultGSIName),
-		KeyConditionExpression: aws.String(fmt.Sprintf("%s = :fk AND %s BETWEEN :start AND :end", fieldKeyName, skName)),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":fk":    &types.AttributeValueMemberS{Value: fk},
-			":start": &types.AttributeValueMemberS{Value: skStart},
-			":end":   &types.AttributeValueMemberS{Value: skEnd},
-		},
+// dualRead runs read against the client's table and, during a migration,
+// the legacy table, merging the results
+func (c *Client) dualRead(read func(c *Client) ([]Fact, error)) ([]Fact, error) {
+	facts, err := read(c)
+	if err != nil {
+		return nil, err
+	}
+	legacy := c.legacy()
+	if legacy == nil {
+		return facts, nil
+	}
+	old, err := read(legacy)
+	if err != nil {
+		return nil, err
 	}
+	return mergeFacts(facts, old), nil
+}
 
-	// Execute the query
-	out, err := c.db.Query(ctx, queryInput)
+// QueryByField returns all facts in a namespace/fieldName for the user in the time range [start, end].
+func (c *Client) QueryByField(ctx context.Context, namespace, fieldName string, start, end time.Time) ([]Fact, error) {
+	start, end, err := queryRange(start, end)
 	if err != nil {
-		return nil, fmt.Errorf("DynamoDB query failed for field %s.%s in time range [%v, %v]: %w",
-			namespace, fieldName, start, end, err)
+		return nil, err
 	}
+	return c.dualRead(func(c *Client) ([]Fact, error) {
+		fk := schema.FieldKeyValue(c.userID, namespace, fieldName)
+		facts, err := c.query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(c.tableName),
+			IndexName:              aws.String(defaultGSIName),
+			KeyConditionExpression: aws.String(fmt.Sprintf("%s = :fk AND %s BETWEEN :start AND :end", fieldKeyName, skName)),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":fk":    &types.AttributeValueMemberS{Value: fk},
+				":start": &types.AttributeValueMemberS{Value: start.Format(time.RFC3339Nano) + "#"},
+				":end":   &types.AttributeValueMemberS{Value: end.Format(time.RFC3339Nano) + "#"},
+			},
+		})
+		if err != nil {
+			c.logger.ErrorContext(ctx, "dynamodb field query failed", "table", c.tableName, "namespace", namespace, "field", fieldName, "error", err)
+			return nil, fmt.Errorf("DynamoDB query failed for field %s.%s in time range [%v, %v]: %w",
+				namespace, fieldName, start, end, err)
+		}
+		c.logger.DebugContext(ctx, "dynamodb field query", "table", c.tableName, "namespace", namespace, "field", fieldName, "items", len(facts))
+		return facts, nil
+	})
+}
 
-	return unmarshalFacts(out.Items)
+// QueryByNamespace returns all facts in a namespace for the user in the time
+// range [start, end]. In the namespace layout this reads a single partition;
+// the user layout filters the user's partition instead.
+func (c *Client) QueryByNamespace(ctx context.Context, namespace string, start, end time.Time) ([]Fact, error) {
+	start, end, err := queryRange(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return c.dualRead(func(c *Client) ([]Fact, error) {
+		input := &dynamodb.QueryInput{
+			TableName:              aws.String(c.tableName),
+			KeyConditionExpression: aws.String(fmt.Sprintf("%s = :pk AND %s BETWEEN :start AND :end", partitionKeyName, skName)),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk":    &types.AttributeValueMemberS{Value: c.partitionKey(namespace)},
+				":start": &types.AttributeValueMemberS{Value: start.Format(time.RFC3339Nano) + "#"},
+				":end":   &types.AttributeValueMemberS{Value: end.Format(time.RFC3339Nano) + "#"},
+			},
+		}
+		if c.layout == LayoutUser {
+			input.KeyConditionExpression = aws.String(fmt.Sprintf("%s = :pk AND %s BETWEEN :start AND :end", pkName, skName))
+			input.FilterExpression = aws.String("Namespace = :ns")
+			input.ExpressionAttributeValues[":ns"] = &types.AttributeValueMemberS{Value: namespace}
+		}
+		facts, err := c.query(ctx, input)
+		if err != nil {
+			c.logger.ErrorContext(ctx, "dynamodb namespace query failed", "table", c.tableName, "namespace", namespace, "error", err)
+			return nil, fmt.Errorf("DynamoDB query failed for namespace %s in time range [%v, %v]: %w",
+				namespace, start, end, err)
+		}
+		c.logger.DebugContext(ctx, "dynamodb namespace query", "table", c.tableName, "namespace", namespace, "items", len(facts))
+		return facts, nil
+	})
 }
 
 // QueryByTimeRange returns all facts for the user in the time range [start, end].
 func (c *Client) QueryByTimeRange(ctx context.Context, start, end time.Time) ([]Fact, error) {
-	// Ensure start and end times are valid
-	if start.IsZero() {
-		start = time.Unix(0, 0) // Use Unix epoch as default start
-	}
-	if end.IsZero() {
-		end = time.Now().UTC() // Use current time as default end
+	start, end, err := queryRange(start, end)
+	if err != nil {
+		return nil, err
 	}
+	return c.dualRead(func(c *Client) ([]Fact, error) {
+		input := &dynamodb.QueryInput{
+			TableName:              aws.String(c.tableName),
+			KeyConditionExpression: aws.String(fmt.Sprintf("%s = :uid AND %s BETWEEN :start AND :end", pkName, skName)),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":uid":   &types.AttributeValueMemberS{Value: c.userID},
+				":start": &types.AttributeValueMemberS{Value: start.Format(time.RFC3339Nano) + "#"},
+				":end":   &types.AttributeValueMemberS{Value: end.Format(time.RFC3339Nano) + "#"},
+			},
+		}
+		// The namespace layout spreads a user over many partitions, which
+		// the user index gathers again
+		if c.layout == LayoutNamespace {
+			input.IndexName = aws.String(userGSIName)
+		}
+		facts, err := c.query(ctx, input)
+		if err != nil {
+			c.logger.ErrorContext(ctx, "dynamodb time range query failed", "table", c.tableName, "error", err)
+			return nil, fmt.Errorf("DynamoDB query failed for user %s in time range [%v, %v]: %w",
+				c.userID, start, end, err)
+		}
+		c.logger.DebugContext(ctx, "dynamodb time range query", "table", c.tableName, "items", len(facts))
+		return facts, nil
+	})
+}
 
-	// Avoid potential timestamp formatting issues
-	if start.After(end) {
-		return nil, fmt.Errorf("invalid time range: start time (%v) is after end time (%v)", start, end)
+// QueryByActor returns the facts an actor wrote to the user's tables in the
+// time range [start, end]. Tables with the ActorIndex read the actor's own
+// partition of it; others filter all of the user's facts.
+func (c *Client) QueryByActor(ctx context.Context, actorUserID string, start, end time.Time) ([]Fact, error) {
+	if actorUserID == "" {
+		return nil, errors.New("actor user ID cannot be empty")
+	}
+	start, end, err := queryRange(start, end)
+	if err != nil {
+		return nil, err
 	}
+	return c.dualRead(func(c *Client) ([]Fact, error) {
+		input := &dynamodb.QueryInput{
+			TableName:              aws.String(c.tableName),
+			IndexName:              aws.String(actorGSIName),
+			KeyConditionExpression: aws.String(fmt.Sprintf("%s = :ak AND %s BETWEEN :start AND :end", actorKeyName, skName)),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":ak":    &types.AttributeValueMemberS{Value: schema.ActorValue(c.userID, actorUserID)},
+				":start": &types.AttributeValueMemberS{Value: start.Format(time.RFC3339Nano) + "#"},
+				":end":   &types.AttributeValueMemberS{Value: end.Format(time.RFC3339Nano) + "#"},
+			},
+		}
+		if c.layout == LayoutUser || !c.actorIndex {
+			input.IndexName = nil
+			if c.layout == LayoutNamespace {
+				input.IndexName = aws.String(userGSIName)
+			}
+			input.KeyConditionExpression = aws.String(fmt.Sprintf("%s = :uid AND %s BETWEEN :start AND :end", pkName, skName))
+			input.FilterExpression = aws.String("ActorUserID = :actor")
+			delete(input.ExpressionAttributeValues, ":ak")
+			input.ExpressionAttributeValues[":uid"] = &types.AttributeValueMemberS{Value: c.userID}
+			input.ExpressionAttributeValues[":actor"] = &types.AttributeValueMemberS{Value: actorUserID}
+		}
+		facts, err := c.query(ctx, input)
+		if err != nil {
+			c.logger.ErrorContext(ctx, "dynamodb actor query failed", "table", c.tableName, "actor", actorUserID, "error", err)
+			return nil, fmt.Errorf("DynamoDB query failed for actor %s in time range [%v, %v]: %w",
+				actorUserID, start, end, err)
+		}
+		c.logger.DebugContext(ctx, "dynamodb actor query", "table", c.tableName, "actor", actorUserID, "items", len(facts))
+		return facts, nil
+	})
+}
 
-	skStart := fmt.Sprintf("%s#", start.Format(time.RFC3339Nano))
-	skEnd := fmt.Sprintf("%s#", end.Format(time.RFC3339Nano))
+// GetFact returns the newest version of the fact with the given ID, or nil
+// when the user has none. Items hold the ID only at the end of their sort
+// key, so the user's facts are read newest first with a filter on it and
+// the read stops at the first page with a match; DynamoDB still reads the
+// items it filters out, but returns only the matches.
+func (c *Client) GetFact(ctx context.Context, id string) (*Fact, error) {
+	if id == "" {
+		return nil, errors.New("fact ID cannot be empty")
+	}
+	fact, err := c.getFact(ctx, id)
+	if err != nil || fact != nil {
+		return fact, err
+	}
+	if legacy := c.legacy(); legacy != nil {
+		return legacy.getFact(ctx, id)
+	}
+	return nil, nil
+}
 
-	// Build query with required key conditions
-	queryInput := &dynamodb.QueryInput{
+func (c *Client) getFact(ctx context.Context, id string) (*Fact, error) {
+	input := &dynamodb.QueryInput{
 		TableName:              aws.String(c.tableName),
-		KeyConditionExpression: aws.String(fmt.Sprintf("%s = :uid AND %s BETWEEN :start AND :end", pkName, skName)),
+		KeyConditionExpression: aws.String(fmt.Sprintf("%s = :uid", pkName)),
+		// IDs cannot contain '#', so only sort keys of this ID or of IDs
+		// it is a prefix of contain "#id"; those are told apart below
+		FilterExpression: aws.String(fmt.Sprintf("contains(%s, :id)", skName)),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":uid":   &types.AttributeValueMemberS{Value: c.userID},
-			":start": &types.AttributeValueMemberS{Value: skStart},
-			":end":   &types.AttributeValueMemberS{Value: skEnd},
+			":uid": &types.AttributeValueMemberS{Value: c.userID},
+			":id":  &types.AttributeValueMemberS{Value: "#" + id},
 		},
+		ScanIndexForward: aws.Bool(false),
 	}
-
-	// Execute the query
-	out, err := c.db.Query(ctx, queryInput)
-	if err != nil {
-		return nil, fmt.Errorf("DynamoDB query failed for user %s in time range [%v, %v]: %w",
-			c.userID, start, end, err)
+	if c.layout == LayoutNamespace {
+		input.IndexName = aws.String(userGSIName)
+	}
+	for {
+		out, err := c.db.Query(ctx, input)
+		if err != nil {
+			c.logger.ErrorContext(ctx, "dynamodb fact lookup failed", "table", c.tableName, "id", id, "error", err)
+			return nil, fmt.Errorf("DynamoDB query failed for fact %s: %w", id, err)
+		}
+		facts, err := unmarshalFacts(out.Items)
+		if err != nil {
+			return nil, err
+		}
+		for i := range facts {
+			if facts[i].ID == id {
+				return &facts[i], nil
+			}
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return nil, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
 	}
-
-	return unmarshalFacts(out.Items)
 }
 
 func unmarshalFacts(items []map[string]types.AttributeValue) ([]Fact, error) {
@@ -237,36 +602,50 @@ func unmarshalFacts(items []map[string]types.AttributeValue) ([]Fact, error) {
 			DataType  string             `dynamodbav:"DataType"`
 			Value     interface{}        `dynamodbav:"Value"`
 			Columns   []ColumnDefinition `dynamodbav:"Columns,omitempty"`
+			Region    string             `dynamodbav:"Region,omitempty"`
+			Actor     string             `dynamodbav:"ActorUserID,omitempty"`
+			APIKeyID  string             `dynamodbav:"APIKeyID,omitempty"`
+			ValidTime string             `dynamodbav:"ValidTime,omitempty"`
+			Client    bool               `dynamodbav:"ClientDated,omitempty"`
+			CreatedAt string             `dynamodbav:"CreatedAt,omitempty"`
 		}
 		if err := attributevalue.UnmarshalMap(item, &raw); err != nil {
-			log.Printf("ERROR: Failed to unmarshal item: %v", err)
 			return nil, fmt.Errorf("unmarshal dynamodb item: %w", err)
 		}
-
-		// Debug column information
-		if raw.DataType == "table" {
-			log.Printf("Unmarshalled table %s.%s with %d columns", raw.Namespace, raw.FieldName, len(raw.Columns))
-			for i, col := range raw.Columns {
-				log.Printf("  Column %d: %s (%s)", i, col.Name, col.DataType)
-			}
-		}
-		parts := strings.SplitN(raw.SK, "#", 2)
-		ts, err := time.Parse(time.RFC3339Nano, parts[0])
+		ts, id, err := schema.ParseSortKey(raw.SK)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("unmarshal dynamodb item: %w", err)
+		}
+		var valid *time.Time
+		if raw.ValidTime != "" {
+			t, err := time.Parse(time.RFC3339Nano, raw.ValidTime)
+			if err != nil {
+				return nil, fmt.Errorf("unmarshal dynamodb item: valid time: %w", err)
+			}
+			valid = &t
 		}
-		var id string
-		if len(parts) > 1 {
-			id = parts[1]
+		var created *time.Time
+		if raw.CreatedAt != "" {
+			t, err := time.Parse(time.RFC3339Nano, raw.CreatedAt)
+			if err != nil {
+				return nil, fmt.Errorf("unmarshal dynamodb item: created at: %w", err)
+			}
+			created = &t
 		}
 		facts = append(facts, Fact{
-			ID:        id,
-			Timestamp: ts,
-			Namespace: raw.Namespace,
-			FieldName: raw.FieldName,
-			DataType:  raw.DataType,
-			Value:     raw.Value,
-			Columns:   raw.Columns,
+			ID:          id,
+			Timestamp:   ts,
+			Namespace:   raw.Namespace,
+			FieldName:   raw.FieldName,
+			DataType:    raw.DataType,
+			Value:       raw.Value,
+			Columns:     raw.Columns,
+			Region:      raw.Region,
+			ActorUserID: raw.Actor,
+			APIKeyID:    raw.APIKeyID,
+			ValidTime:   valid,
+			ClientDated: raw.Client,
+			CreatedAt:   created,
 		})
 	}
 	return facts, nil
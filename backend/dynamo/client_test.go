@@ -5,8 +5,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/elibdev/notably/testutil/dynamotest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestColumnsStorage(t *testing.T) {
@@ -137,3 +139,34 @@ func TestColumnsStorageWithRealEmulator(t *testing.T) {
 		assert.Equal(t, len(tableFact.Columns), len(fact.Columns), "Column count should match")
 	}
 }
+
+func TestFactIsTombstone(t *testing.T) {
+	assert.True(t, Fact{DataType: DataTypeDeleted}.IsTombstone())
+	assert.True(t, Fact{DataType: "json"}.IsTombstone(), "JSON facts without a value are older tombstones")
+	assert.False(t, Fact{DataType: "json", Value: map[string]interface{}{}}.IsTombstone())
+	assert.False(t, Fact{DataType: "table"}.IsTombstone())
+}
+
+func TestFactValidTime(t *testing.T) {
+	ctx := context.Background()
+	fact := Fact{ID: "f1", Timestamp: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), Namespace: "u1/t", FieldName: "r1", DataType: "json", Value: map[string]interface{}{}}
+	assert.Equal(t, fact.Timestamp, fact.EffectiveTime(), "facts without a valid time take effect when written")
+
+	valid := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	fact.ValidTime = &valid
+	assert.Equal(t, valid, fact.EffectiveTime())
+
+	client := NewClientWithDB(nil, "Facts", "u1")
+	item, err := client.factItem(ctx, fact)
+	require.NoError(t, err)
+	facts, err := unmarshalFacts([]map[string]types.AttributeValue{item})
+	require.NoError(t, err)
+	require.Len(t, facts, 1)
+	require.NotNil(t, facts[0].ValidTime)
+	assert.True(t, valid.Equal(*facts[0].ValidTime))
+
+	fact.ValidTime = nil
+	item, err = client.factItem(ctx, fact)
+	require.NoError(t, err)
+	assert.NotContains(t, item, "ValidTime")
+}
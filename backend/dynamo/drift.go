@@ -0,0 +1,143 @@
+package dynamo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// SchemaDrift returns how an existing table differs from the definition
+// want, one difference per line, or nil when it matches. Key schemas, key
+// attribute types, indexes and their projections, the billing mode and the
+// encryption are compared. Capacity is not, as autoscaling changes it, and
+// neither are tags and point in time recovery, which DescribeTable does not
+// report.
+func SchemaDrift(want *dynamodb.CreateTableInput, got *types.TableDescription) []string {
+	var drift []string
+	if keyString(got.KeySchema) != keyString(want.KeySchema) {
+		drift = append(drift, fmt.Sprintf("key schema is %s, want %s", keyString(got.KeySchema), keyString(want.KeySchema)))
+	}
+
+	gotTypes := make(map[string]types.ScalarAttributeType, len(got.AttributeDefinitions))
+	for _, a := range got.AttributeDefinitions {
+		gotTypes[aws.ToString(a.AttributeName)] = a.AttributeType
+	}
+	for _, a := range want.AttributeDefinitions {
+		name := aws.ToString(a.AttributeName)
+		if t, ok := gotTypes[name]; ok && t != a.AttributeType {
+			drift = append(drift, fmt.Sprintf("attribute %s has type %s, want %s", name, t, a.AttributeType))
+		}
+	}
+
+	gotIndexes := make(map[string]types.GlobalSecondaryIndexDescription, len(got.GlobalSecondaryIndexes))
+	for _, gsi := range got.GlobalSecondaryIndexes {
+		gotIndexes[aws.ToString(gsi.IndexName)] = gsi
+	}
+	for _, gsi := range want.GlobalSecondaryIndexes {
+		name := aws.ToString(gsi.IndexName)
+		have, ok := gotIndexes[name]
+		if !ok {
+			drift = append(drift, fmt.Sprintf("index %s is missing", name))
+			continue
+		}
+		delete(gotIndexes, name)
+		if keyString(have.KeySchema) != keyString(gsi.KeySchema) {
+			drift = append(drift, fmt.Sprintf("index %s key schema is %s, want %s", name, keyString(have.KeySchema), keyString(gsi.KeySchema)))
+		}
+		if projectionType(have.Projection) != projectionType(gsi.Projection) {
+			drift = append(drift, fmt.Sprintf("index %s projects %s, want %s", name, projectionType(have.Projection), projectionType(gsi.Projection)))
+		}
+	}
+	extra := make([]string, 0, len(gotIndexes))
+	for name := range gotIndexes {
+		extra = append(extra, name)
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		drift = append(drift, fmt.Sprintf("index %s is not part of the schema", name))
+	}
+
+	// Tables created before on-demand billing have no billing summary
+	billing := types.BillingModeProvisioned
+	if got.BillingModeSummary != nil {
+		billing = got.BillingModeSummary.BillingMode
+	}
+	if want.BillingMode != "" && billing != want.BillingMode {
+		drift = append(drift, fmt.Sprintf("billing mode is %s, want %s", billing, want.BillingMode))
+	}
+
+	encrypted := got.SSEDescription != nil && got.SSEDescription.SSEType == types.SSETypeKms &&
+		got.SSEDescription.Status != types.SSEStatusDisabled && got.SSEDescription.Status != types.SSEStatusDisabling
+	switch wantKey := want.SSESpecification; {
+	case wantKey == nil && encrypted:
+		drift = append(drift, fmt.Sprintf("table is encrypted with KMS key %s, want an AWS owned key", aws.ToString(got.SSEDescription.KMSMasterKeyArn)))
+	case wantKey != nil && !encrypted:
+		drift = append(drift, fmt.Sprintf("table is encrypted with an AWS owned key, want KMS key %s", aws.ToString(wantKey.KMSMasterKeyId)))
+	case wantKey != nil && strings.HasPrefix(aws.ToString(wantKey.KMSMasterKeyId), "arn:") && aws.ToString(wantKey.KMSMasterKeyId) != aws.ToString(got.SSEDescription.KMSMasterKeyArn):
+		// Key IDs and aliases cannot be compared with the key ARN DynamoDB reports
+		drift = append(drift, fmt.Sprintf("table is encrypted with KMS key %s, want %s", aws.ToString(got.SSEDescription.KMSMasterKeyArn), aws.ToString(wantKey.KMSMasterKeyId)))
+	}
+	return drift
+}
+
+// MissingIndexes returns the indexes of want an existing table lacks
+func MissingIndexes(want *dynamodb.CreateTableInput, got *types.TableDescription) []types.GlobalSecondaryIndex {
+	have := make(map[string]bool, len(got.GlobalSecondaryIndexes))
+	for _, gsi := range got.GlobalSecondaryIndexes {
+		have[aws.ToString(gsi.IndexName)] = true
+	}
+	var missing []types.GlobalSecondaryIndex
+	for _, gsi := range want.GlobalSecondaryIndexes {
+		if !have[aws.ToString(gsi.IndexName)] {
+			missing = append(missing, gsi)
+		}
+	}
+	return missing
+}
+
+// AddIndexInput returns the UpdateTable input adding one index of the
+// definition want to its table. DynamoDB creates one index per update.
+func AddIndexInput(want *dynamodb.CreateTableInput, gsi types.GlobalSecondaryIndex) *dynamodb.UpdateTableInput {
+	keys := make(map[string]bool, len(gsi.KeySchema))
+	for _, k := range gsi.KeySchema {
+		keys[aws.ToString(k.AttributeName)] = true
+	}
+	in := &dynamodb.UpdateTableInput{
+		TableName: want.TableName,
+		GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{{
+			Create: &types.CreateGlobalSecondaryIndexAction{
+				IndexName:             gsi.IndexName,
+				KeySchema:             gsi.KeySchema,
+				Projection:            gsi.Projection,
+				ProvisionedThroughput: gsi.ProvisionedThroughput,
+			},
+		}},
+	}
+	for _, a := range want.AttributeDefinitions {
+		if keys[aws.ToString(a.AttributeName)] {
+			in.AttributeDefinitions = append(in.AttributeDefinitions, a)
+		}
+	}
+	return in
+}
+
+// keyString renders a key schema such as "PK (HASH), SK (RANGE)"
+func keyString(keys []types.KeySchemaElement) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s (%s)", aws.ToString(k.AttributeName), k.KeyType)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// projectionType returns the projection type of an index, ALL by default
+func projectionType(p *types.Projection) types.ProjectionType {
+	if p == nil || p.ProjectionType == "" {
+		return types.ProjectionTypeAll
+	}
+	return p.ProjectionType
+}
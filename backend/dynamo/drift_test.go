@@ -0,0 +1,66 @@
+package dynamo
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// describe returns the description DynamoDB gives a table created from
+// the definition of name in layout
+func describe(name string, layout Layout, opts TableOptions) *types.TableDescription {
+	in := TableDefinition(name, layout, opts)
+	desc := &types.TableDescription{
+		TableName:            in.TableName,
+		KeySchema:            in.KeySchema,
+		AttributeDefinitions: in.AttributeDefinitions,
+		BillingModeSummary:   &types.BillingModeSummary{BillingMode: in.BillingMode},
+	}
+	for _, gsi := range in.GlobalSecondaryIndexes {
+		desc.GlobalSecondaryIndexes = append(desc.GlobalSecondaryIndexes, types.GlobalSecondaryIndexDescription{
+			IndexName:  gsi.IndexName,
+			KeySchema:  gsi.KeySchema,
+			Projection: gsi.Projection,
+		})
+	}
+	return desc
+}
+
+func TestSchemaDrift(t *testing.T) {
+	want := TableDefinition("Facts", LayoutNamespace, TableOptions{})
+	assert.Empty(t, SchemaDrift(want, describe("Facts", LayoutNamespace, TableOptions{})))
+	assert.Equal(t, LayoutUser, TableLayout(describe("Facts", LayoutUser, TableOptions{})))
+
+	got := describe("Facts", LayoutNamespace, TableOptions{BillingMode: BillingProvisioned})
+	got.GlobalSecondaryIndexes = got.GlobalSecondaryIndexes[:1]
+	got.GlobalSecondaryIndexes = append(got.GlobalSecondaryIndexes, types.GlobalSecondaryIndexDescription{
+		IndexName:  aws.String("Extra"),
+		Projection: &types.Projection{ProjectionType: types.ProjectionTypeKeysOnly},
+	})
+	got.SSEDescription = &types.SSEDescription{SSEType: types.SSETypeKms, Status: types.SSEStatusEnabled, KMSMasterKeyArn: aws.String("arn:aws:kms:us-east-1:1:key/k")}
+	assert.Equal(t, []string{
+		"index UserIndex is missing",
+		"index ActorIndex is missing",
+		"index Extra is not part of the schema",
+		"billing mode is PROVISIONED, want PAY_PER_REQUEST",
+		"table is encrypted with KMS key arn:aws:kms:us-east-1:1:key/k, want an AWS owned key",
+	}, SchemaDrift(want, got))
+
+	missing := MissingIndexes(want, got)
+	require.Len(t, missing, 2)
+	update := AddIndexInput(want, missing[0])
+	assert.Equal(t, "UserIndex", aws.ToString(update.GlobalSecondaryIndexUpdates[0].Create.IndexName))
+	require.Len(t, update.AttributeDefinitions, 2)
+	assert.Equal(t, "UserID", aws.ToString(update.AttributeDefinitions[1].AttributeName))
+
+	// Key IDs cannot be compared with ARNs, so only their presence counts
+	keyed := TableDefinition("Facts", LayoutNamespace, TableOptions{KMSKeyID: "alias/notably"})
+	got = describe("Facts", LayoutNamespace, TableOptions{})
+	got.SSEDescription = &types.SSEDescription{SSEType: types.SSETypeKms, Status: types.SSEStatusEnabled, KMSMasterKeyArn: aws.String("arn:aws:kms:us-east-1:1:key/k")}
+	assert.Empty(t, SchemaDrift(keyed, got))
+	assert.Equal(t, []string{"key schema is UserID (HASH), SK (RANGE), want PK (HASH), SK (RANGE)", "index UserIndex is missing", "index ActorIndex is missing"},
+		SchemaDrift(want, describe("Facts", LayoutUser, TableOptions{})))
+}
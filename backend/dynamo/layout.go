@@ -0,0 +1,135 @@
+package dynamo
+
+import (
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/elibdev/notably/schema"
+)
+
+// Layout is the key schema of a facts table
+type Layout int
+
+const (
+	// LayoutNamespace partitions facts by user and namespace, so one busy
+	// table does not throttle the rest of its owner's data. Queries across
+	// a user's namespaces go through the UserIndex GSI. New tables use it.
+	LayoutNamespace Layout = iota
+	// LayoutUser keeps all of a user's facts in one partition keyed by
+	// UserID, as tables created before LayoutNamespace do
+	LayoutUser
+)
+
+func (l Layout) String() string {
+	if l == LayoutUser {
+		return "user"
+	}
+	return "namespace"
+}
+
+// WithLayout sets the key schema the client expects of its table and
+// returns the client. CreateTable sets it from an existing table's schema,
+// so this is only needed for clients that never call CreateTable.
+func (c *Client) WithLayout(l Layout) *Client {
+	c.layout = l
+	return c
+}
+
+// WithLegacyTable makes the client also read facts from a table in the user
+// layout while they are copied into the client's own table by
+// MigrateLayout. Results of both tables are merged; writes only go to the
+// client's table, and purges go to both.
+func (c *Client) WithLegacyTable(name string) *Client {
+	c.legacyTable = name
+	return c
+}
+
+// legacy returns a client for the legacy table sharing this client's API,
+// or nil when dual reads are off
+func (c *Client) legacy() *Client {
+	if c.legacyTable == "" || c.legacyTable == c.tableName {
+		return nil
+	}
+	return &Client{db: c.db, tableName: c.legacyTable, userID: c.userID, logger: c.logger, observer: c.observer, layout: LayoutUser}
+}
+
+// partitionKey returns the partition key value of a namespace's facts
+func (c *Client) partitionKey(namespace string) string {
+	if c.layout == LayoutUser {
+		return c.userID
+	}
+	return schema.NamespaceValue(c.userID, namespace)
+}
+
+// factSK returns the sort key of a fact's item
+func factSK(fact Fact) string {
+	return schema.SortKeyValue(fact.Timestamp, fact.ID)
+}
+
+// itemKey returns the primary key of a fact's item
+func (c *Client) itemKey(fact Fact) map[string]types.AttributeValue {
+	sk := &types.AttributeValueMemberS{Value: factSK(fact)}
+	if c.layout == LayoutUser {
+		return map[string]types.AttributeValue{pkName: &types.AttributeValueMemberS{Value: c.userID}, skName: sk}
+	}
+	return map[string]types.AttributeValue{partitionKeyName: &types.AttributeValueMemberS{Value: c.partitionKey(fact.Namespace)}, skName: sk}
+}
+
+// layoutOf returns the layout of a table from its key schema. Store tables
+// share the keys of the user layout.
+func layoutOf(keys []types.KeySchemaElement) Layout {
+	for _, k := range keys {
+		if k.KeyType == types.KeyTypeHash && aws.ToString(k.AttributeName) == pkName {
+			return LayoutUser
+		}
+	}
+	return LayoutNamespace
+}
+
+// TableDefinition returns the CreateTable input of a facts table in a
+// layout, with opts applied, as CreateTable sends it
+func TableDefinition(name string, layout Layout, opts TableOptions) *dynamodb.CreateTableInput {
+	in := createTableInput(name, layout)
+	opts.Apply(in)
+	return in
+}
+
+// TableLayout returns the layout of an existing table
+func TableLayout(desc *types.TableDescription) Layout {
+	return layoutOf(desc.KeySchema)
+}
+
+// createTableInput returns the definition of a facts table in a layout
+func createTableInput(name string, layout Layout) *dynamodb.CreateTableInput {
+	if layout == LayoutUser {
+		return schema.Definition(name, schema.User)
+	}
+	return schema.Definition(name, schema.Namespace)
+}
+
+// mergeFacts merges the facts read from the legacy table into those read
+// from the client's table, dropping copies already migrated, in time order
+func mergeFacts(facts, legacy []Fact) []Fact {
+	seen := make(map[string]bool, len(facts))
+	key := func(f Fact) string {
+		return f.Namespace + "#" + factSK(f)
+	}
+	for _, f := range facts {
+		seen[key(f)] = true
+	}
+	for _, f := range legacy {
+		if !seen[key(f)] {
+			facts = append(facts, f)
+		}
+	}
+	sort.SliceStable(facts, func(i, j int) bool {
+		if !facts[i].Timestamp.Equal(facts[j].Timestamp) {
+			return facts[i].Timestamp.Before(facts[j].Timestamp)
+		}
+		return facts[i].ID < facts[j].ID
+	})
+	return facts
+}
@@ -0,0 +1,220 @@
+package dynamo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// layoutAPI records the items put and queries made, answering queries with
+// the items held for their table
+type layoutAPI struct {
+	dynamoDBAPI
+	puts    []map[string]types.AttributeValue
+	queries []*dynamodb.QueryInput
+	items   map[string][]map[string]types.AttributeValue
+	keys    []types.KeySchemaElement
+	indexes []string
+}
+
+func (a *layoutAPI) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	a.puts = append(a.puts, params.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (a *layoutAPI) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	a.queries = append(a.queries, params)
+	return &dynamodb.QueryOutput{Items: a.items[aws.ToString(params.TableName)]}, nil
+}
+
+func (a *layoutAPI) CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	return nil, &types.ResourceInUseException{Message: aws.String("exists")}
+}
+
+func (a *layoutAPI) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	desc := &types.TableDescription{KeySchema: a.keys, TableStatus: types.TableStatusActive}
+	for _, name := range a.indexes {
+		desc.GlobalSecondaryIndexes = append(desc.GlobalSecondaryIndexes, types.GlobalSecondaryIndexDescription{IndexName: aws.String(name)})
+	}
+	return &dynamodb.DescribeTableOutput{Table: desc}, nil
+}
+
+func str(av types.AttributeValue) string {
+	if s, ok := av.(*types.AttributeValueMemberS); ok {
+		return s.Value
+	}
+	return ""
+}
+
+func TestNamespaceLayoutKeys(t *testing.T) {
+	ctx := context.Background()
+	api := &layoutAPI{}
+	client := NewClientWithDB(api, "Facts", "u1")
+
+	require.NoError(t, client.PutFact(ctx, testFact()))
+	item := api.puts[0]
+	assert.Equal(t, "u1#u1/t", str(item[partitionKeyName]))
+	assert.Equal(t, "u1", str(item[pkName]))
+
+	_, err := client.QueryByNamespace(ctx, "u1/t", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	q := api.queries[0]
+	assert.Nil(t, q.IndexName)
+	assert.Nil(t, q.FilterExpression)
+	assert.Equal(t, "u1#u1/t", str(q.ExpressionAttributeValues[":pk"]))
+
+	_, err = client.QueryByTimeRange(ctx, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, userGSIName, aws.ToString(api.queries[1].IndexName))
+}
+
+func TestUserLayoutKeys(t *testing.T) {
+	ctx := context.Background()
+	api := &layoutAPI{keys: []types.KeySchemaElement{
+		{AttributeName: aws.String(pkName), KeyType: types.KeyTypeHash},
+		{AttributeName: aws.String(skName), KeyType: types.KeyTypeRange},
+	}}
+	client := NewClientWithDB(api, "Facts", "u1")
+	require.NoError(t, client.CreateTable(ctx))
+	assert.Equal(t, LayoutUser, client.layout)
+
+	require.NoError(t, client.PutFact(ctx, testFact()))
+	assert.NotContains(t, api.puts[0], partitionKeyName)
+	assert.Equal(t, "u1", str(api.puts[0][pkName]))
+
+	_, err := client.QueryByNamespace(ctx, "u1/t", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	q := api.queries[0]
+	assert.Equal(t, "Namespace = :ns", aws.ToString(q.FilterExpression))
+	assert.Equal(t, "u1", str(q.ExpressionAttributeValues[":pk"]))
+
+	_, err = client.QueryByTimeRange(ctx, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.Nil(t, api.queries[1].IndexName)
+}
+
+func TestDualReadMergesLegacyTable(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	migrated := Fact{ID: "a", Timestamp: at, Namespace: "u1/t", FieldName: "r1", DataType: "json", Value: "1"}
+	pending := Fact{ID: "b", Timestamp: at.Add(-time.Minute), Namespace: "u1/t", FieldName: "r2", DataType: "json", Value: "2"}
+
+	newTable := NewClientWithDB(nil, "Facts", "u1")
+	oldTable := NewClientWithDB(nil, "OldFacts", "u1").WithLayout(LayoutUser)
+	item := func(c *Client, f Fact) map[string]types.AttributeValue {
+		it, err := c.factItem(ctx, f)
+		require.NoError(t, err)
+		return it
+	}
+	api := &layoutAPI{items: map[string][]map[string]types.AttributeValue{
+		"Facts":    {item(newTable, migrated)},
+		"OldFacts": {item(oldTable, pending), item(oldTable, migrated)},
+	}}
+	client := NewClientWithDB(api, "Facts", "u1").WithLegacyTable("OldFacts")
+
+	facts, err := client.QueryByNamespace(ctx, "u1/t", time.Time{}, at)
+	require.NoError(t, err)
+	require.Len(t, facts, 2)
+	assert.Equal(t, []string{"b", "a"}, []string{facts[0].ID, facts[1].ID})
+
+	// The legacy table is queried in its own layout
+	require.Len(t, api.queries, 2)
+	assert.Equal(t, "OldFacts", aws.ToString(api.queries[1].TableName))
+	assert.Equal(t, "Namespace = :ns", aws.ToString(api.queries[1].FilterExpression))
+
+	// Writes only go to the new table
+	require.NoError(t, client.PutFact(ctx, testFact()))
+	assert.Contains(t, api.puts[0], partitionKeyName)
+}
+
+func TestGetFactFiltersByID(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newTable := NewClientWithDB(nil, "Facts", "u1")
+	oldTable := NewClientWithDB(nil, "OldFacts", "u1").WithLayout(LayoutUser)
+	item := func(c *Client, f Fact) map[string]types.AttributeValue {
+		it, err := c.factItem(ctx, f)
+		require.NoError(t, err)
+		return it
+	}
+	// The fake ignores the filter; "ab" also contains "#a"
+	api := &layoutAPI{items: map[string][]map[string]types.AttributeValue{
+		"Facts": {
+			item(newTable, Fact{ID: "ab", Timestamp: at.Add(time.Minute), Namespace: "u1/t", FieldName: "r2", DataType: "json"}),
+			item(newTable, Fact{ID: "a", Timestamp: at, Namespace: "u1/t", FieldName: "r1", DataType: "json"}),
+		},
+		"OldFacts": {item(oldTable, Fact{ID: "old", Timestamp: at, Namespace: "u1/t", FieldName: "r3", DataType: "json"})},
+	}}
+	client := NewClientWithDB(api, "Facts", "u1").WithLegacyTable("OldFacts")
+
+	fact, err := client.GetFact(ctx, "a")
+	require.NoError(t, err)
+	require.NotNil(t, fact)
+	assert.Equal(t, "r1", fact.FieldName)
+	require.Len(t, api.queries, 1, "a match in the table is not looked up in the legacy table")
+	q := api.queries[0]
+	assert.Equal(t, userGSIName, aws.ToString(q.IndexName))
+	assert.Equal(t, "contains(SK, :id)", aws.ToString(q.FilterExpression))
+	assert.Equal(t, "#a", str(q.ExpressionAttributeValues[":id"]))
+	assert.False(t, aws.ToBool(q.ScanIndexForward), "newest first")
+	assert.Nil(t, q.Limit, "a limit would apply before the filter")
+
+	fact, err = client.GetFact(ctx, "old")
+	require.NoError(t, err)
+	require.NotNil(t, fact)
+	assert.Equal(t, "r3", fact.FieldName)
+	assert.Nil(t, api.queries[2].IndexName, "the legacy table is read in its own layout")
+
+	fact, err = client.GetFact(ctx, "missing")
+	require.NoError(t, err)
+	assert.Nil(t, fact)
+}
+
+func TestQueryByActor(t *testing.T) {
+	ctx := context.Background()
+	api := &layoutAPI{indexes: []string{defaultGSIName, userGSIName, actorGSIName}}
+	client := NewClientWithDB(api, "Facts", "u1")
+	require.NoError(t, client.CreateTable(ctx))
+
+	fact := testFact()
+	fact.ActorUserID = "u2"
+	fact.APIKeyID = "k1"
+	require.NoError(t, client.PutFact(ctx, fact))
+	item := api.puts[0]
+	assert.Equal(t, "u2", str(item["ActorUserID"]))
+	assert.Equal(t, "k1", str(item["APIKeyID"]))
+	assert.Equal(t, "u1#u2", str(item[actorKeyName]))
+	require.NoError(t, client.PutFact(ctx, testFact()))
+	assert.NotContains(t, api.puts[1], actorKeyName, "facts without an actor stay out of the index")
+
+	api.items = map[string][]map[string]types.AttributeValue{"Facts": {item}}
+	facts, err := client.QueryByActor(ctx, "u2", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, facts, 1)
+	assert.Equal(t, "u2", facts[0].ActorUserID)
+	assert.Equal(t, "k1", facts[0].APIKeyID)
+	q := api.queries[0]
+	assert.Equal(t, actorGSIName, aws.ToString(q.IndexName))
+	assert.Nil(t, q.FilterExpression)
+	assert.Equal(t, "u1#u2", str(q.ExpressionAttributeValues[":ak"]))
+
+	// Tables created before the index filter the user's facts
+	api.indexes = []string{defaultGSIName, userGSIName}
+	require.NoError(t, client.CreateTable(ctx))
+	_, err = client.QueryByActor(ctx, "u2", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	q = api.queries[1]
+	assert.Equal(t, userGSIName, aws.ToString(q.IndexName))
+	assert.Equal(t, "ActorUserID = :actor", aws.ToString(q.FilterExpression))
+	assert.Equal(t, "u2", str(q.ExpressionAttributeValues[":actor"]))
+	assert.NotContains(t, q.ExpressionAttributeValues, ":ak")
+
+	_, err = client.QueryByActor(ctx, "", time.Time{}, time.Time{})
+	assert.Error(t, err)
+}
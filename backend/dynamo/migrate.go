@@ -0,0 +1,111 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/elibdev/notably/pkg/backoff"
+	"github.com/elibdev/notably/schema"
+)
+
+// batchWriteSize is the most puts DynamoDB accepts in one BatchWriteItem call
+const batchWriteSize = 25
+
+// errUnprocessed is retried while DynamoDB leaves items of a batch unwritten
+var errUnprocessed = errors.New("batch items left unprocessed")
+
+// MigrateLayout copies every fact of source, a table in the user layout,
+// into target, creating target in the namespace layout if it does not
+// exist. Copies are idempotent, so an interrupted migration can be run again;
+// servers reading target with WithLegacyTable(source) see every fact
+// throughout. progress, if set, is called with the running count after each
+// batch. It returns the number of facts copied.
+func MigrateLayout(ctx context.Context, cfg aws.Config, source, target string, progress func(copied int)) (int, error) {
+	client := NewClient(cfg, target, "").WithRetry(backoff.DefaultPolicy)
+	if err := client.CreateTable(ctx); err != nil {
+		return 0, err
+	}
+	if client.layout != LayoutNamespace {
+		return 0, fmt.Errorf("migrate: target table %s is in the %s layout", target, client.layout)
+	}
+	return migrateLayout(ctx, client.db, source, target, progress)
+}
+
+// migrateLayout scans source and writes each item to target with its
+// UserID#Namespace partition key added
+func migrateLayout(ctx context.Context, api dynamoDBAPI, source, target string, progress func(int)) (int, error) {
+	desc, err := api.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(source)})
+	if err != nil {
+		return 0, fmt.Errorf("migrate: describe %s: %w", source, err)
+	}
+	if layout := layoutOf(desc.Table.KeySchema); layout != LayoutUser {
+		return 0, fmt.Errorf("migrate: source table %s is in the %s layout", source, layout)
+	}
+
+	copied := 0
+	input := &dynamodb.ScanInput{TableName: aws.String(source)}
+	for {
+		out, err := api.Scan(ctx, input)
+		if err != nil {
+			return copied, fmt.Errorf("migrate: scan %s: %w", source, err)
+		}
+		// The marker's migrations are those of the source; the target
+		// keeps its own
+		items := make([]map[string]types.AttributeValue, 0, len(out.Items))
+		for _, item := range out.Items {
+			if user, ok := item[pkName].(*types.AttributeValueMemberS); !ok || user.Value != schema.MarkerPartition {
+				items = append(items, item)
+			}
+		}
+		for i := 0; i < len(items); i += batchWriteSize {
+			batch := items[i:min(i+batchWriteSize, len(items))]
+			if err := writeBatch(ctx, api, target, batch); err != nil {
+				return copied, fmt.Errorf("migrate: write %s: %w", target, err)
+			}
+			copied += len(batch)
+			if progress != nil {
+				progress(copied)
+			}
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return copied, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// writeBatch puts items of the user layout into a namespace layout table,
+// retrying those DynamoDB leaves unprocessed
+func writeBatch(ctx context.Context, api dynamoDBAPI, table string, items []map[string]types.AttributeValue) error {
+	requests := make([]types.WriteRequest, 0, len(items))
+	for _, item := range items {
+		user, _ := item[pkName].(*types.AttributeValueMemberS)
+		ns, _ := item["Namespace"].(*types.AttributeValueMemberS)
+		if user == nil || ns == nil {
+			return fmt.Errorf("item without %s or Namespace", pkName)
+		}
+		moved := make(map[string]types.AttributeValue, len(item)+1)
+		for k, v := range item {
+			moved[k] = v
+		}
+		moved[partitionKeyName] = &types.AttributeValueMemberS{Value: schema.NamespaceValue(user.Value, ns.Value)}
+		requests = append(requests, types.WriteRequest{PutRequest: &types.PutRequest{Item: moved}})
+	}
+
+	pending := map[string][]types.WriteRequest{table: requests}
+	return backoff.DefaultPolicy.Retry(ctx, func(err error) bool { return errors.Is(err, errUnprocessed) }, nil, func() error {
+		out, err := api.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: pending})
+		if err != nil {
+			return err
+		}
+		if len(out.UnprocessedItems[table]) == 0 {
+			return nil
+		}
+		pending = out.UnprocessedItems
+		return errUnprocessed
+	})
+}
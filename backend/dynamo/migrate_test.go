@@ -0,0 +1,82 @@
+package dynamo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// migrationAPI serves a source table in pages of two items and collects the
+// items written to the target, leaving the first write of a batch
+// unprocessed once
+type migrationAPI struct {
+	dynamoDBAPI
+	source   []map[string]types.AttributeValue
+	written  []map[string]types.AttributeValue
+	scans    int
+	deferred bool
+}
+
+func (a *migrationAPI) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	return &dynamodb.DescribeTableOutput{Table: &types.TableDescription{KeySchema: []types.KeySchemaElement{
+		{AttributeName: aws.String(pkName), KeyType: types.KeyTypeHash},
+		{AttributeName: aws.String(skName), KeyType: types.KeyTypeRange},
+	}}}, nil
+}
+
+func (a *migrationAPI) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	start := a.scans * 2
+	a.scans++
+	end := min(start+2, len(a.source))
+	out := &dynamodb.ScanOutput{Items: a.source[start:end]}
+	if end < len(a.source) {
+		out.LastEvaluatedKey = a.source[end-1]
+	}
+	return out, nil
+}
+
+func (a *migrationAPI) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	requests := params.RequestItems["Facts"]
+	out := &dynamodb.BatchWriteItemOutput{}
+	if !a.deferred {
+		a.deferred = true
+		out.UnprocessedItems = map[string][]types.WriteRequest{"Facts": requests[:1]}
+		requests = requests[1:]
+	}
+	for _, r := range requests {
+		a.written = append(a.written, r.PutRequest.Item)
+	}
+	return out, nil
+}
+
+func TestMigrateLayout(t *testing.T) {
+	ctx := context.Background()
+	old := NewClientWithDB(nil, "OldFacts", "u1").WithLayout(LayoutUser)
+	api := &migrationAPI{}
+	for _, ns := range []string{"u1/a", "u1/a", "u1/b", "u1/c"} {
+		f := testFact()
+		f.Namespace = ns
+		item, err := old.factItem(ctx, f)
+		require.NoError(t, err)
+		api.source = append(api.source, item)
+	}
+
+	var reported []int
+	copied, err := migrateLayout(ctx, api, "OldFacts", "Facts", func(n int) { reported = append(reported, n) })
+	require.NoError(t, err)
+	assert.Equal(t, 4, copied)
+	assert.Equal(t, []int{2, 4}, reported)
+
+	require.Len(t, api.written, 4)
+	var keys []string
+	for _, item := range api.written {
+		keys = append(keys, str(item[partitionKeyName]))
+		assert.Equal(t, "u1", str(item[pkName]))
+	}
+	assert.ElementsMatch(t, []string{"u1#u1/a", "u1#u1/a", "u1#u1/b", "u1#u1/c"}, keys)
+}
@@ -0,0 +1,109 @@
+package dynamo
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// Observer receives the duration and outcome of every DynamoDB call made by
+// a Client, e.g. to export metrics.
+type Observer interface {
+	ObserveOperation(ctx context.Context, operation string, duration time.Duration, err error)
+}
+
+// RetryObserver may be implemented by an Observer to be told about every
+// throttled call the client retries
+type RetryObserver interface {
+	ObserveRetry(ctx context.Context, operation string, attempt int, delay time.Duration, err error)
+}
+
+// WithObserver reports every DynamoDB call made by the client to o and returns the client
+func (c *Client) WithObserver(o Observer) *Client {
+	if o != nil {
+		c.db = &observedAPI{api: c.db, observer: o}
+		c.observer = o
+	}
+	return c
+}
+
+// observedAPI times each call to the wrapped DynamoDB API
+type observedAPI struct {
+	api      dynamoDBAPI
+	observer Observer
+}
+
+func (o *observedAPI) done(ctx context.Context, operation string, start time.Time, err error) {
+	o.observer.ObserveOperation(ctx, operation, time.Since(start), err)
+}
+
+func (o *observedAPI) CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	start := time.Now()
+	out, err := o.api.CreateTable(ctx, params, optFns...)
+	o.done(ctx, "CreateTable", start, err)
+	return out, err
+}
+
+func (o *observedAPI) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	start := time.Now()
+	out, err := o.api.PutItem(ctx, params, optFns...)
+	o.done(ctx, "PutItem", start, err)
+	return out, err
+}
+
+func (o *observedAPI) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	start := time.Now()
+	out, err := o.api.Query(ctx, params, optFns...)
+	o.done(ctx, "Query", start, err)
+	return out, err
+}
+
+func (o *observedAPI) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	start := time.Now()
+	out, err := o.api.DeleteItem(ctx, params, optFns...)
+	o.done(ctx, "DeleteItem", start, err)
+	return out, err
+}
+
+func (o *observedAPI) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	start := time.Now()
+	out, err := o.api.TransactWriteItems(ctx, params, optFns...)
+	o.done(ctx, "TransactWriteItems", start, err)
+	return out, err
+}
+
+func (o *observedAPI) DeleteTable(ctx context.Context, params *dynamodb.DeleteTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteTableOutput, error) {
+	start := time.Now()
+	out, err := o.api.DeleteTable(ctx, params, optFns...)
+	o.done(ctx, "DeleteTable", start, err)
+	return out, err
+}
+
+func (o *observedAPI) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	start := time.Now()
+	out, err := o.api.DescribeTable(ctx, params, optFns...)
+	o.done(ctx, "DescribeTable", start, err)
+	return out, err
+}
+
+func (o *observedAPI) UpdateContinuousBackups(ctx context.Context, params *dynamodb.UpdateContinuousBackupsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateContinuousBackupsOutput, error) {
+	start := time.Now()
+	out, err := o.api.UpdateContinuousBackups(ctx, params, optFns...)
+	o.done(ctx, "UpdateContinuousBackups", start, err)
+	return out, err
+}
+
+func (o *observedAPI) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	start := time.Now()
+	out, err := o.api.Scan(ctx, params, optFns...)
+	o.done(ctx, "Scan", start, err)
+	return out, err
+}
+
+func (o *observedAPI) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	start := time.Now()
+	out, err := o.api.BatchWriteItem(ctx, params, optFns...)
+	o.done(ctx, "BatchWriteItem", start, err)
+	return out, err
+}
@@ -0,0 +1,40 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingPutAPI fails every PutItem; other methods are not used
+type failingPutAPI struct {
+	dynamoDBAPI
+}
+
+func (failingPutAPI) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	return nil, errors.New("put failed")
+}
+
+type recordingObserver struct {
+	ops  []string
+	errs []error
+}
+
+func (r *recordingObserver) ObserveOperation(ctx context.Context, operation string, duration time.Duration, err error) {
+	r.ops = append(r.ops, operation)
+	r.errs = append(r.errs, err)
+}
+
+func TestClientReportsOperationsToObserver(t *testing.T) {
+	obs := &recordingObserver{}
+	client := NewClientWithDB(failingPutAPI{}, "Facts", "u1").WithObserver(obs)
+
+	err := client.PutFact(context.Background(), Fact{ID: "1", Timestamp: time.Now(), Namespace: "u1/t", FieldName: "r", DataType: "json", Value: "v"})
+	assert.Error(t, err)
+	assert.Equal(t, []string{"PutItem"}, obs.ops)
+	assert.Error(t, obs.errs[0])
+}
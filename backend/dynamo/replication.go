@@ -0,0 +1,104 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// regionName is the attribute recording the region a fact was written in
+const regionName = "Region"
+
+// heartbeatPartition holds the replication heartbeats of a Global Table.
+// No user has this ID, so heartbeats never show up among facts.
+const heartbeatPartition = "_replication"
+
+// heartbeatPrefix starts the sort key of each region's heartbeat item
+const heartbeatPrefix = "heartbeat#"
+
+// WithRegion records region on the facts the client writes, so concurrent
+// writes to a Global Table from different regions can be told apart, and
+// returns the client
+func (c *Client) WithRegion(region string) *Client {
+	c.region = region
+	return c
+}
+
+// Region returns the region the client records on the facts it writes
+func (c *Client) Region() string {
+	return c.region
+}
+
+// regionOf returns the region to record on a fact: its own, or the client's
+func (c *Client) regionOf(fact Fact) string {
+	if fact.Region != "" {
+		return fact.Region
+	}
+	return c.region
+}
+
+// heartbeatKey returns the key of a region's heartbeat item
+func (c *Client) heartbeatKey(region string) map[string]types.AttributeValue {
+	sk := &types.AttributeValueMemberS{Value: heartbeatPrefix + region}
+	if c.layout == LayoutUser {
+		return map[string]types.AttributeValue{pkName: &types.AttributeValueMemberS{Value: heartbeatPartition}, skName: sk}
+	}
+	return map[string]types.AttributeValue{partitionKeyName: &types.AttributeValueMemberS{Value: heartbeatPartition}, skName: sk}
+}
+
+// WriteHeartbeat stamps the client's region with the time at. Global Tables
+// replicate the stamp, so every other region can tell how far behind it is
+// by reading it with Heartbeats. Each region overwrites its own item.
+func (c *Client) WriteHeartbeat(ctx context.Context, at time.Time) error {
+	if c.region == "" {
+		return fmt.Errorf("write heartbeat: the client has no region")
+	}
+	item := c.heartbeatKey(c.region)
+	item[regionName] = &types.AttributeValueMemberS{Value: c.region}
+	item["At"] = &types.AttributeValueMemberS{Value: at.UTC().Format(time.RFC3339Nano)}
+	_, err := c.db.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(c.tableName), Item: item})
+	if err != nil {
+		return fmt.Errorf("write heartbeat: %w", err)
+	}
+	return nil
+}
+
+// Heartbeats returns the latest heartbeat of each region as replicated to
+// the client's region
+func (c *Client) Heartbeats(ctx context.Context) (map[string]time.Time, error) {
+	key := partitionKeyName
+	if c.layout == LayoutUser {
+		key = pkName
+	}
+	out, err := c.db.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(c.tableName),
+		KeyConditionExpression: aws.String(fmt.Sprintf("%s = :pk AND begins_with(%s, :hb)", key, skName)),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: heartbeatPartition},
+			":hb": &types.AttributeValueMemberS{Value: heartbeatPrefix},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read heartbeats: %w", err)
+	}
+	beats := make(map[string]time.Time, len(out.Items))
+	for _, item := range out.Items {
+		sk, _ := item[skName].(*types.AttributeValueMemberS)
+		at, _ := item["At"].(*types.AttributeValueMemberS)
+		if sk == nil || at == nil {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339Nano, at.Value)
+		if err != nil {
+			continue
+		}
+		beats[strings.TrimPrefix(sk.Value, heartbeatPrefix)] = ts
+	}
+	return beats, nil
+}
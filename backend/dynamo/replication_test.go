@@ -0,0 +1,49 @@
+package dynamo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegionRecordedOnFacts(t *testing.T) {
+	ctx := context.Background()
+	api := &layoutAPI{}
+	client := NewClientWithDB(api, "Facts", "u1").WithRegion("eu-west-1")
+
+	require.NoError(t, client.PutFact(ctx, testFact()))
+	assert.Equal(t, "eu-west-1", str(api.puts[0][regionName]))
+
+	// Facts replicated from another region keep their own
+	fact := testFact()
+	fact.Region = "us-east-1"
+	require.NoError(t, client.PutFact(ctx, fact))
+	assert.Equal(t, "us-east-1", str(api.puts[1][regionName]))
+}
+
+func TestHeartbeats(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	for _, layout := range []Layout{LayoutNamespace, LayoutUser} {
+		api := &layoutAPI{}
+		client := NewClientWithDB(api, "Facts", "u1").WithLayout(layout).WithRegion("eu-west-1")
+		require.NoError(t, client.WriteHeartbeat(ctx, at))
+		require.Len(t, api.puts, 1)
+		assert.Equal(t, "heartbeat#eu-west-1", str(api.puts[0][skName]))
+
+		api.items = map[string][]map[string]types.AttributeValue{"Facts": api.puts}
+		beats, err := client.Heartbeats(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]time.Time{"eu-west-1": at}, beats)
+		assert.Equal(t, heartbeatPartition, str(api.queries[0].ExpressionAttributeValues[":pk"]))
+		assert.True(t, aws.ToBool(api.queries[0].ConsistentRead))
+	}
+
+	assert.Error(t, NewClientWithDB(&layoutAPI{}, "Facts", "u1").WriteHeartbeat(ctx, at), "heartbeats need a region")
+}
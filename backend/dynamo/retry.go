@@ -0,0 +1,109 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/elibdev/notably/pkg/backoff"
+)
+
+// WithRetry retries throttled reads and writes under p and returns the
+// client. Call it after WithObserver so every attempt is observed; retries
+// are reported to the observer if it implements RetryObserver.
+func (c *Client) WithRetry(p backoff.Policy) *Client {
+	if p.MaxAttempts == 1 {
+		return c
+	}
+	r := &retryingAPI{dynamoDBAPI: c.db, policy: p}
+	r.observer, _ = c.observer.(RetryObserver)
+	c.db = r
+	return c
+}
+
+// retryingAPI retries throttled data-plane calls to the wrapped DynamoDB API
+// with jittered exponential backoff. Table management calls are not retried.
+type retryingAPI struct {
+	dynamoDBAPI
+	policy   backoff.Policy
+	observer RetryObserver
+}
+
+// isThrottled reports whether DynamoDB rejected a call for exceeding
+// capacity or request limits
+func isThrottled(err error) bool {
+	var provisioned *types.ProvisionedThroughputExceededException
+	var limit *types.RequestLimitExceeded
+	if errors.As(err, &provisioned) || errors.As(err, &limit) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ThrottlingException", "ProvisionedThroughputExceededException", "RequestLimitExceeded":
+			return true
+		}
+	}
+	return false
+}
+
+func (r *retryingAPI) retry(ctx context.Context, operation string, fn func() error) error {
+	var onRetry func(int, time.Duration, error)
+	if r.observer != nil {
+		onRetry = func(attempt int, delay time.Duration, err error) {
+			r.observer.ObserveRetry(ctx, operation, attempt, delay, err)
+		}
+	}
+	return r.policy.Retry(ctx, isThrottled, onRetry, fn)
+}
+
+func (r *retryingAPI) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (out *dynamodb.PutItemOutput, err error) {
+	err = r.retry(ctx, "PutItem", func() error {
+		out, err = r.dynamoDBAPI.PutItem(ctx, params, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingAPI) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (out *dynamodb.QueryOutput, err error) {
+	err = r.retry(ctx, "Query", func() error {
+		out, err = r.dynamoDBAPI.Query(ctx, params, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingAPI) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (out *dynamodb.DeleteItemOutput, err error) {
+	err = r.retry(ctx, "DeleteItem", func() error {
+		out, err = r.dynamoDBAPI.DeleteItem(ctx, params, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingAPI) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (out *dynamodb.TransactWriteItemsOutput, err error) {
+	err = r.retry(ctx, "TransactWriteItems", func() error {
+		out, err = r.dynamoDBAPI.TransactWriteItems(ctx, params, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingAPI) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (out *dynamodb.ScanOutput, err error) {
+	err = r.retry(ctx, "Scan", func() error {
+		out, err = r.dynamoDBAPI.Scan(ctx, params, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingAPI) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (out *dynamodb.BatchWriteItemOutput, err error) {
+	err = r.retry(ctx, "BatchWriteItem", func() error {
+		out, err = r.dynamoDBAPI.BatchWriteItem(ctx, params, optFns...)
+		return err
+	})
+	return out, err
+}
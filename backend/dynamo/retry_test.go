@@ -0,0 +1,75 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/elibdev/notably/pkg/backoff"
+	"github.com/stretchr/testify/assert"
+)
+
+// throttlingPutAPI throttles the first failures PutItem calls, then fails
+// with err or succeeds
+type throttlingPutAPI struct {
+	dynamoDBAPI
+	failures int
+	calls    int
+	err      error
+}
+
+func (a *throttlingPutAPI) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	a.calls++
+	if a.calls <= a.failures {
+		return nil, &types.ProvisionedThroughputExceededException{Message: aws.String("slow down")}
+	}
+	return &dynamodb.PutItemOutput{}, a.err
+}
+
+type retryRecorder struct {
+	recordingObserver
+	retries []int
+}
+
+func (r *retryRecorder) ObserveRetry(ctx context.Context, operation string, attempt int, delay time.Duration, err error) {
+	r.retries = append(r.retries, attempt)
+}
+
+var testPolicy = backoff.Policy{MaxAttempts: 3, MaxElapsed: time.Second, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+func testFact() Fact {
+	return Fact{ID: "1", Timestamp: time.Now(), Namespace: "u1/t", FieldName: "r", DataType: "json", Value: "v"}
+}
+
+func TestClientRetriesThrottledWrites(t *testing.T) {
+	api := &throttlingPutAPI{failures: 2}
+	obs := &retryRecorder{}
+	client := NewClientWithDB(api, "Facts", "u1").WithObserver(obs).WithRetry(testPolicy)
+
+	assert.NoError(t, client.PutFact(context.Background(), testFact()))
+	assert.Equal(t, 3, api.calls)
+	assert.Equal(t, []int{1, 2}, obs.retries)
+	assert.Equal(t, []string{"PutItem", "PutItem", "PutItem"}, obs.ops)
+}
+
+func TestClientGivesUpAfterMaxAttempts(t *testing.T) {
+	api := &throttlingPutAPI{failures: 10}
+	client := NewClientWithDB(api, "Facts", "u1").WithRetry(testPolicy)
+
+	err := client.PutFact(context.Background(), testFact())
+	var throttled *types.ProvisionedThroughputExceededException
+	assert.True(t, errors.As(err, &throttled))
+	assert.Equal(t, 3, api.calls)
+}
+
+func TestClientDoesNotRetryOtherErrors(t *testing.T) {
+	api := &throttlingPutAPI{err: errors.New("invalid item")}
+	client := NewClientWithDB(api, "Facts", "u1").WithRetry(testPolicy)
+
+	assert.Error(t, client.PutFact(context.Background(), testFact()))
+	assert.Equal(t, 1, api.calls)
+}
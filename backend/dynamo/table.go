@@ -0,0 +1,221 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Billing modes of a facts table
+const (
+	BillingOnDemand    = "on-demand"
+	BillingProvisioned = "provisioned"
+)
+
+// defaultProvisionedCapacity is the read and write capacity of provisioned
+// tables and their indexes when none is configured
+const defaultProvisionedCapacity = 5
+
+// TableOptions tunes the facts tables a Client creates. The zero value
+// creates on-demand tables encrypted with an AWS owned key, without point
+// in time recovery or tags. Options only apply when a table is created;
+// existing tables are left as they are.
+type TableOptions struct {
+	// BillingMode is BillingOnDemand (the default) or BillingProvisioned
+	BillingMode string
+
+	// ReadCapacity and WriteCapacity are the provisioned units of the
+	// table and each of its indexes, 5 when zero. With AutoScaling they
+	// are the floor capacity is scaled down to.
+	ReadCapacity  int64
+	WriteCapacity int64
+
+	// AutoScaling, if set, lets Application Auto Scaling adjust the
+	// capacity of a provisioned table and its indexes
+	AutoScaling *AutoScaling
+
+	// PointInTimeRecovery turns on continuous backups
+	PointInTimeRecovery bool
+
+	// KMSKeyID, if set, encrypts the table with this KMS key (an ID, ARN
+	// or alias) instead of an AWS owned key
+	KMSKeyID string
+
+	// Tags are set on the table
+	Tags map[string]string
+}
+
+// AutoScaling is the target tracking scaling of provisioned capacity
+type AutoScaling struct {
+	// MaxReadCapacity and MaxWriteCapacity cap the scaled capacity; a
+	// dimension whose maximum is zero is not scaled
+	MaxReadCapacity  int64
+	MaxWriteCapacity int64
+
+	// TargetUtilization is the consumed share of the capacity, in
+	// percent, that scaling aims for; 70 when zero
+	TargetUtilization float64
+}
+
+// Provisioned reports whether tables are created with provisioned capacity
+func (o TableOptions) Provisioned() bool {
+	return o.BillingMode == BillingProvisioned
+}
+
+// Validate reports every invalid option
+func (o TableOptions) Validate() error {
+	var errs []error
+	switch o.BillingMode {
+	case "", BillingOnDemand, BillingProvisioned:
+	default:
+		errs = append(errs, fmt.Errorf("billing mode must be %q or %q, got %q", BillingOnDemand, BillingProvisioned, o.BillingMode))
+	}
+	if o.ReadCapacity < 0 || o.WriteCapacity < 0 {
+		errs = append(errs, errors.New("read and write capacity must not be negative"))
+	}
+	if !o.Provisioned() && (o.ReadCapacity > 0 || o.WriteCapacity > 0 || o.AutoScaling != nil) {
+		errs = append(errs, errors.New("capacity and autoscaling need the provisioned billing mode"))
+	}
+	if a := o.AutoScaling; a != nil {
+		read, write := o.ProvisionedCapacity()
+		if a.MaxReadCapacity <= 0 && a.MaxWriteCapacity <= 0 {
+			errs = append(errs, errors.New("autoscaling needs a maximum read or write capacity"))
+		}
+		if (a.MaxReadCapacity > 0 && a.MaxReadCapacity < read) || (a.MaxWriteCapacity > 0 && a.MaxWriteCapacity < write) {
+			errs = append(errs, fmt.Errorf("autoscaling maximum capacity must be at least the provisioned %d read and %d write units", read, write))
+		}
+		if a.TargetUtilization < 0 || a.TargetUtilization > 90 || (a.TargetUtilization > 0 && a.TargetUtilization < 20) {
+			errs = append(errs, errors.New("autoscaling target utilization must be between 20 and 90 percent"))
+		}
+	}
+	for k := range o.Tags {
+		if k == "" || strings.HasPrefix(k, "aws:") {
+			errs = append(errs, fmt.Errorf("invalid tag key %q", k))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ProvisionedCapacity returns the read and write units of a provisioned table
+func (o TableOptions) ProvisionedCapacity() (read, write int64) {
+	read, write = o.ReadCapacity, o.WriteCapacity
+	if read == 0 {
+		read = defaultProvisionedCapacity
+	}
+	if write == 0 {
+		write = defaultProvisionedCapacity
+	}
+	return read, write
+}
+
+// Apply sets the billing, encryption and tags of a table definition. Its
+// indexes must be defined first, as provisioned indexes need capacity too.
+func (o TableOptions) Apply(in *dynamodb.CreateTableInput) {
+	if o.Provisioned() {
+		read, write := o.ProvisionedCapacity()
+		throughput := &types.ProvisionedThroughput{ReadCapacityUnits: aws.Int64(read), WriteCapacityUnits: aws.Int64(write)}
+		in.BillingMode = types.BillingModeProvisioned
+		in.ProvisionedThroughput = throughput
+		for i := range in.GlobalSecondaryIndexes {
+			in.GlobalSecondaryIndexes[i].ProvisionedThroughput = throughput
+		}
+	} else {
+		in.BillingMode = types.BillingModePayPerRequest
+	}
+	if o.KMSKeyID != "" {
+		in.SSESpecification = &types.SSESpecification{
+			Enabled:        aws.Bool(true),
+			SSEType:        types.SSETypeKms,
+			KMSMasterKeyId: aws.String(o.KMSKeyID),
+		}
+	}
+	keys := make([]string, 0, len(o.Tags))
+	for k := range o.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		in.Tags = append(in.Tags, types.Tag{Key: aws.String(k), Value: aws.String(o.Tags[k])})
+	}
+}
+
+// ContinuousBackupsAPI is the DynamoDB call turning on point in time recovery
+type ContinuousBackupsAPI interface {
+	UpdateContinuousBackups(ctx context.Context, params *dynamodb.UpdateContinuousBackupsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateContinuousBackupsOutput, error)
+}
+
+// Configure applies the options that can only be set once the table
+// defined by in is active: point in time recovery and, through scaler,
+// autoscaling of the table and its indexes. scaler may be nil without
+// AutoScaling.
+func (o TableOptions) Configure(ctx context.Context, api ContinuousBackupsAPI, scaler Autoscaler, in *dynamodb.CreateTableInput) error {
+	table := aws.ToString(in.TableName)
+	if o.PointInTimeRecovery {
+		_, err := api.UpdateContinuousBackups(ctx, &dynamodb.UpdateContinuousBackupsInput{
+			TableName:                        aws.String(table),
+			PointInTimeRecoverySpecification: &types.PointInTimeRecoverySpecification{PointInTimeRecoveryEnabled: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("enable point in time recovery: %w", err)
+		}
+	}
+	if o.AutoScaling == nil || !o.Provisioned() {
+		return nil
+	}
+	if scaler == nil {
+		return errors.New("autoscaling is configured but the client has no autoscaler")
+	}
+	read, write := o.ProvisionedCapacity()
+	target := o.AutoScaling.TargetUtilization
+	if target == 0 {
+		target = 70
+	}
+	resources := []string{"table/" + table}
+	for _, gsi := range in.GlobalSecondaryIndexes {
+		resources = append(resources, "table/"+table+"/index/"+aws.ToString(gsi.IndexName))
+	}
+	for _, resource := range resources {
+		kind := "table"
+		if strings.Contains(resource, "/index/") {
+			kind = "index"
+		}
+		targets := []ScalingTarget{
+			{ResourceID: resource, Dimension: "dynamodb:" + kind + ":ReadCapacityUnits", Min: read, Max: o.AutoScaling.MaxReadCapacity, TargetUtilization: target},
+			{ResourceID: resource, Dimension: "dynamodb:" + kind + ":WriteCapacityUnits", Min: write, Max: o.AutoScaling.MaxWriteCapacity, TargetUtilization: target},
+		}
+		for _, t := range targets {
+			if t.Max == 0 {
+				continue
+			}
+			if err := scaler.Scale(ctx, t); err != nil {
+				return fmt.Errorf("configure autoscaling of %s: %w", resource, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ParseTags parses tags written as "key=value,key=value"
+func ParseTags(s string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("tag %q is not key=value", pair)
+		}
+		tags[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	return tags, nil
+}
@@ -0,0 +1,125 @@
+package dynamo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type backupsAPI struct {
+	updates []*dynamodb.UpdateContinuousBackupsInput
+}
+
+func (b *backupsAPI) UpdateContinuousBackups(ctx context.Context, in *dynamodb.UpdateContinuousBackupsInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateContinuousBackupsOutput, error) {
+	b.updates = append(b.updates, in)
+	return &dynamodb.UpdateContinuousBackupsOutput{}, nil
+}
+
+type scalerRecorder struct {
+	targets []ScalingTarget
+}
+
+func (s *scalerRecorder) Scale(ctx context.Context, t ScalingTarget) error {
+	s.targets = append(s.targets, t)
+	return nil
+}
+
+func TestTableOptionsApply(t *testing.T) {
+	in := createTableInput("Facts", LayoutNamespace)
+	TableOptions{}.Apply(in)
+	assert.Equal(t, types.BillingModePayPerRequest, in.BillingMode)
+	assert.Nil(t, in.ProvisionedThroughput)
+	assert.Nil(t, in.SSESpecification)
+
+	in = createTableInput("Facts", LayoutNamespace)
+	TableOptions{
+		BillingMode:  BillingProvisioned,
+		ReadCapacity: 20,
+		KMSKeyID:     "alias/notably",
+		Tags:         map[string]string{"team": "notes", "env": "prod"},
+	}.Apply(in)
+	assert.Equal(t, types.BillingModeProvisioned, in.BillingMode)
+	want := &types.ProvisionedThroughput{ReadCapacityUnits: aws.Int64(20), WriteCapacityUnits: aws.Int64(5)}
+	assert.Equal(t, want, in.ProvisionedThroughput)
+	require.Len(t, in.GlobalSecondaryIndexes, 3)
+	for _, gsi := range in.GlobalSecondaryIndexes {
+		assert.Equal(t, want, gsi.ProvisionedThroughput, "provisioned indexes need capacity too")
+	}
+	assert.Equal(t, types.SSETypeKms, in.SSESpecification.SSEType)
+	assert.Equal(t, "alias/notably", aws.ToString(in.SSESpecification.KMSMasterKeyId))
+	assert.Equal(t, []types.Tag{
+		{Key: aws.String("env"), Value: aws.String("prod")},
+		{Key: aws.String("team"), Value: aws.String("notes")},
+	}, in.Tags)
+}
+
+func TestTableOptionsConfigure(t *testing.T) {
+	ctx := context.Background()
+	in := createTableInput("Facts", LayoutNamespace)
+	api := &backupsAPI{}
+	scaler := &scalerRecorder{}
+
+	require.NoError(t, TableOptions{}.Configure(ctx, api, nil, in))
+	assert.Empty(t, api.updates)
+
+	opts := TableOptions{
+		BillingMode:         BillingProvisioned,
+		WriteCapacity:       10,
+		AutoScaling:         &AutoScaling{MaxReadCapacity: 50, MaxWriteCapacity: 100},
+		PointInTimeRecovery: true,
+	}
+	require.NoError(t, opts.Configure(ctx, api, scaler, in))
+	require.Len(t, api.updates, 1)
+	assert.True(t, aws.ToBool(api.updates[0].PointInTimeRecoverySpecification.PointInTimeRecoveryEnabled))
+
+	// Read and write capacity of the table and every index
+	require.Len(t, scaler.targets, 8)
+	assert.Equal(t, ScalingTarget{ResourceID: "table/Facts", Dimension: "dynamodb:table:WriteCapacityUnits", Min: 10, Max: 100, TargetUtilization: 70}, scaler.targets[1])
+	assert.Equal(t, ScalingTarget{ResourceID: "table/Facts/index/FieldIndex", Dimension: "dynamodb:index:ReadCapacityUnits", Min: 5, Max: 50, TargetUtilization: 70}, scaler.targets[2])
+
+	assert.Error(t, opts.Configure(ctx, api, nil, in), "autoscaling needs an autoscaler")
+
+	// Dimensions without a maximum are left alone
+	scaler.targets = nil
+	opts.AutoScaling.MaxReadCapacity = 0
+	require.NoError(t, opts.Configure(ctx, api, scaler, in))
+	require.Len(t, scaler.targets, 4)
+	for _, target := range scaler.targets {
+		assert.Contains(t, target.Dimension, "WriteCapacityUnits")
+	}
+}
+
+func TestTableOptionsValidate(t *testing.T) {
+	assert.NoError(t, TableOptions{}.Validate())
+	assert.NoError(t, TableOptions{BillingMode: BillingProvisioned, AutoScaling: &AutoScaling{MaxReadCapacity: 5, MaxWriteCapacity: 5}}.Validate())
+
+	for name, opts := range map[string]TableOptions{
+		"unknown billing mode":    {BillingMode: "reserved"},
+		"capacity on demand":      {WriteCapacity: 10},
+		"negative capacity":       {BillingMode: BillingProvisioned, ReadCapacity: -1},
+		"no maximum":              {BillingMode: BillingProvisioned, AutoScaling: &AutoScaling{}},
+		"maximum below the floor": {BillingMode: BillingProvisioned, WriteCapacity: 10, AutoScaling: &AutoScaling{MaxReadCapacity: 10, MaxWriteCapacity: 5}},
+		"target out of range":     {BillingMode: BillingProvisioned, AutoScaling: &AutoScaling{MaxReadCapacity: 5, MaxWriteCapacity: 5, TargetUtilization: 95}},
+		"reserved tag":            {Tags: map[string]string{"aws:owner": "x"}},
+	} {
+		assert.Error(t, opts.Validate(), name)
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tags, err := ParseTags(" team=notes, env = prod ,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "notes", "env": "prod"}, tags)
+
+	tags, err = ParseTags("")
+	assert.NoError(t, err)
+	assert.Nil(t, tags)
+
+	_, err = ParseTags("team")
+	assert.Error(t, err)
+}
@@ -0,0 +1,138 @@
+package dynamo
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/elibdev/notably/pkg/tracing"
+)
+
+// StartCallSpan begins the span of a DynamoDB call on table, nil for calls
+// spanning tables, in the trace carried by ctx. Without a trace it returns
+// ctx and a nil span.
+func StartCallSpan(ctx context.Context, operation string, table *string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.StartClient(ctx, "dynamodb."+operation, "db.system", "dynamodb", "db.operation", operation)
+	if table != nil {
+		span.SetAttributes("aws.dynamodb.table_names", aws.ToString(table))
+	}
+	return ctx, span
+}
+
+// EndCallSpan ends the span of a DynamoDB call with its error and the
+// capacity DynamoDB reported for it
+func EndCallSpan(span *tracing.Span, err error, consumed ...types.ConsumedCapacity) {
+	if span == nil {
+		return
+	}
+	if len(consumed) > 0 {
+		var units float64
+		for _, c := range consumed {
+			units += aws.ToFloat64(c.CapacityUnits)
+		}
+		span.SetAttributes("aws.dynamodb.consumed_capacity", units)
+	}
+	span.End(err)
+}
+
+// CapacityOf returns the capacity of a response holding at most one
+// ConsumedCapacity, for EndCallSpan
+func CapacityOf(c *types.ConsumedCapacity) []types.ConsumedCapacity {
+	if c == nil {
+		return nil
+	}
+	return []types.ConsumedCapacity{*c}
+}
+
+// tracedAPI records a span for each read and write made with a context
+// carrying a trace. Traced calls ask DynamoDB for the capacity they
+// consume, so their spans show it.
+type tracedAPI struct {
+	dynamoDBAPI
+}
+
+func (t tracedAPI) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	ctx, span := StartCallSpan(ctx, "PutItem", params.TableName)
+	if span != nil && params.ReturnConsumedCapacity == "" {
+		params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	}
+	out, err := t.dynamoDBAPI.PutItem(ctx, params, optFns...)
+	var consumed []types.ConsumedCapacity
+	if err == nil {
+		consumed = CapacityOf(out.ConsumedCapacity)
+	}
+	EndCallSpan(span, err, consumed...)
+	return out, err
+}
+
+func (t tracedAPI) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	ctx, span := StartCallSpan(ctx, "Query", params.TableName)
+	if span != nil && params.ReturnConsumedCapacity == "" {
+		params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	}
+	out, err := t.dynamoDBAPI.Query(ctx, params, optFns...)
+	var consumed []types.ConsumedCapacity
+	if err == nil {
+		consumed = CapacityOf(out.ConsumedCapacity)
+	}
+	EndCallSpan(span, err, consumed...)
+	return out, err
+}
+
+func (t tracedAPI) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	ctx, span := StartCallSpan(ctx, "DeleteItem", params.TableName)
+	if span != nil && params.ReturnConsumedCapacity == "" {
+		params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	}
+	out, err := t.dynamoDBAPI.DeleteItem(ctx, params, optFns...)
+	var consumed []types.ConsumedCapacity
+	if err == nil {
+		consumed = CapacityOf(out.ConsumedCapacity)
+	}
+	EndCallSpan(span, err, consumed...)
+	return out, err
+}
+
+func (t tracedAPI) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	ctx, span := StartCallSpan(ctx, "TransactWriteItems", nil)
+	if span != nil && params.ReturnConsumedCapacity == "" {
+		params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	}
+	out, err := t.dynamoDBAPI.TransactWriteItems(ctx, params, optFns...)
+	var consumed []types.ConsumedCapacity
+	if err == nil {
+		consumed = out.ConsumedCapacity
+	}
+	EndCallSpan(span, err, consumed...)
+	return out, err
+}
+
+func (t tracedAPI) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	ctx, span := StartCallSpan(ctx, "Scan", params.TableName)
+	if span != nil && params.ReturnConsumedCapacity == "" {
+		params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	}
+	out, err := t.dynamoDBAPI.Scan(ctx, params, optFns...)
+	var consumed []types.ConsumedCapacity
+	if err == nil {
+		consumed = CapacityOf(out.ConsumedCapacity)
+	}
+	EndCallSpan(span, err, consumed...)
+	return out, err
+}
+
+func (t tracedAPI) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	ctx, span := StartCallSpan(ctx, "BatchWriteItem", nil)
+	if span != nil && params.ReturnConsumedCapacity == "" {
+		params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	}
+	out, err := t.dynamoDBAPI.BatchWriteItem(ctx, params, optFns...)
+	var consumed []types.ConsumedCapacity
+	if err == nil {
+		consumed = out.ConsumedCapacity
+	}
+	EndCallSpan(span, err, consumed...)
+	return out, err
+}
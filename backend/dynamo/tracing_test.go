@@ -0,0 +1,56 @@
+package dynamo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/pkg/tracing"
+)
+
+// capacityPutAPI accepts every PutItem, reporting the capacity it was asked for
+type capacityPutAPI struct {
+	dynamoDBAPI
+	modes []types.ReturnConsumedCapacity
+}
+
+func (a *capacityPutAPI) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	a.modes = append(a.modes, params.ReturnConsumedCapacity)
+	out := &dynamodb.PutItemOutput{}
+	if params.ReturnConsumedCapacity != "" {
+		out.ConsumedCapacity = &types.ConsumedCapacity{CapacityUnits: aws.Float64(2)}
+	}
+	return out, nil
+}
+
+func TestClientTracesCalls(t *testing.T) {
+	api := &capacityPutAPI{}
+	client := NewClientWithDB(tracedAPI{api}, "Facts", "u1")
+	fact := Fact{ID: "1", Timestamp: time.Now(), Namespace: "u1/t", FieldName: "r", DataType: "json", Value: "v"}
+
+	rec := &tracing.Recorder{}
+	tracer := tracing.NewTracer(rec, tracing.Options{SampleRatio: 1})
+	ctx, root := tracer.StartServer(context.Background(), "POST /tables/{table}/rows", tracing.SpanContext{})
+	require.NoError(t, client.PutFact(ctx, fact))
+	root.End(nil)
+	require.NoError(t, tracer.Flush(ctx))
+
+	spans := rec.Spans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "dynamodb.PutItem", spans[0].Name)
+	assert.Equal(t, tracing.KindClient, spans[0].Kind)
+	assert.Equal(t, spans[1].SpanID, spans[0].ParentID)
+	assert.Equal(t, "Facts", spans[0].Attribute("aws.dynamodb.table_names"))
+	assert.Equal(t, 2.0, spans[0].Attribute("aws.dynamodb.consumed_capacity"))
+
+	// Calls outside a trace leave the request as it was
+	require.NoError(t, client.PutFact(context.Background(), fact))
+	assert.Equal(t, []types.ReturnConsumedCapacity{types.ReturnConsumedCapacityTotal, ""}, api.modes)
+	assert.Len(t, rec.Spans(), 2)
+}
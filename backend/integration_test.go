@@ -28,7 +28,8 @@ func TestTableCreationAndListingIntegration(t *testing.T) {
 	oldEndpoint := os.Getenv("DYNAMODB_ENDPOINT_URL")
 
 	os.Setenv("DYNAMODB_TABLE_NAME", testTableName)
-	os.Setenv("DYNAMODB_ENDPOINT_URL", "http://localhost:8000")
+	endpoint := dynamotest.NewEmulatorConfig().Endpoint
+	os.Setenv("DYNAMODB_ENDPOINT_URL", endpoint)
 
 	defer func() {
 		if oldTableName == "" {
@@ -47,7 +48,7 @@ func TestTableCreationAndListingIntegration(t *testing.T) {
 	config := server.Config{
 		TableName:      testTableName,
 		Addr:           ":0", // Use any available port
-		DynamoEndpoint: "http://localhost:8000",
+		DynamoEndpoint: endpoint,
 	}
 
 	srv, err := server.NewServer(config)
@@ -233,7 +234,8 @@ func TestTableCreationValidation(t *testing.T) {
 	oldEndpoint := os.Getenv("DYNAMODB_ENDPOINT_URL")
 
 	os.Setenv("DYNAMODB_TABLE_NAME", testTableName+"_validation")
-	os.Setenv("DYNAMODB_ENDPOINT_URL", "http://localhost:8000")
+	endpoint := dynamotest.NewEmulatorConfig().Endpoint
+	os.Setenv("DYNAMODB_ENDPOINT_URL", endpoint)
 
 	defer func() {
 		if oldTableName == "" {
@@ -252,7 +254,7 @@ func TestTableCreationValidation(t *testing.T) {
 	config := server.Config{
 		TableName:      testTableName + "_validation",
 		Addr:           ":0",
-		DynamoEndpoint: "http://localhost:8000",
+		DynamoEndpoint: endpoint,
 	}
 
 	srv, err := server.NewServer(config)
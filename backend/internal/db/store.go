@@ -1,100 +1,53 @@
+// Package db is a deprecated alias of github.com/elibdev/notably/db, kept
+// so code written against the earlier copy of the store builds unchanged.
+// Its types and adapters are those of the canonical package.
+//
+// Deprecated: import github.com/elibdev/notably/db instead.
 package db
 
 import (
-	"context"
-	"fmt"
-	"time"
-
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
 )
 
-// DataType represents the type of data stored in a fact
-type DataType string
+// Deprecated: use the identically named types of package db.
+type (
+	DataType            = db.DataType
+	Fact                = db.Fact
+	QueryOptions        = db.QueryOptions
+	QueryResult         = db.QueryResult
+	Store               = db.Store
+	Config              = db.Config
+	StoreError          = db.StoreError
+	StoreAdapter        = db.StoreAdapter
+	LegacyClientAdapter = db.LegacyClientAdapter
+)
 
+// Deprecated: use the identically named constants of package db.
 const (
-	DataTypeString  DataType = "string"
-	DataTypeNumber  DataType = "number"
-	DataTypeBoolean DataType = "boolean"
-	DataTypeJSON    DataType = "json"
+	DataTypeString  = db.DataTypeString
+	DataTypeNumber  = db.DataTypeNumber
+	DataTypeBoolean = db.DataTypeBoolean
+	DataTypeJSON    = db.DataTypeJSON
 )
 
-// Fact represents a single piece of data with versioning
-type Fact struct {
-	ID        string    `json:"id"`
-	Timestamp time.Time `json:"timestamp"`
-	Namespace string    `json:"namespace"`
-	FieldName string    `json:"fieldName"`
-	DataType  DataType  `json:"dataType"`
-	Value     string    `json:"value"`
-	UserID    string    `json:"userId"`
-	IsDeleted bool      `json:"isDeleted"`
-}
-
-// QueryOptions provides filtering and pagination options for queries
-type QueryOptions struct {
-	StartTime     *time.Time
-	EndTime       *time.Time
-	Limit         *int32
-	NextToken     *string
-	SortAscending bool
-}
-
-// QueryResult contains the results of a query operation
-type QueryResult struct {
-	Facts     []Fact
-	NextToken *string
-}
-
-// Store defines the interface for DynamoDB operations
-type Store interface {
-	// Schema operations
-	CreateTable(ctx context.Context) error
-	DeleteTable(ctx context.Context) error
-
-	// Fact operations
-	PutFact(ctx context.Context, fact *Fact) error
-	GetFact(ctx context.Context, id string) (*Fact, error)
-	DeleteFact(ctx context.Context, id string) error
-
-	// Query operations
-	QueryByField(ctx context.Context, namespace, fieldName string, opts QueryOptions) (*QueryResult, error)
-	QueryByTimeRange(ctx context.Context, opts QueryOptions) (*QueryResult, error)
-	QueryByNamespace(ctx context.Context, namespace string, opts QueryOptions) (*QueryResult, error)
-
-	// Snapshot operations
-	GetSnapshotAtTime(ctx context.Context, namespace string, at time.Time) (map[string]Fact, error)
-}
-
-// Config holds the configuration for the DynamoDB store
-type Config struct {
-	TableName    string
-	UserID       string
-	DynamoClient *dynamodb.Client
-}
-
-// StoreError represents errors that can occur in the Store
-type StoreError struct {
-	Operation string
-	Err       error
+// IsNotFound reports whether err means a fact was not found.
+//
+// Deprecated: use db.IsNotFound.
+func IsNotFound(err error) bool {
+	return db.IsNotFound(err)
 }
 
-func (e *StoreError) Error() string {
-	return fmt.Sprintf("store operation %s failed: %v", e.Operation, e.Err)
+// NewStoreAdapter returns db.NewStoreAdapter(store).
+//
+// Deprecated: use db.NewStoreAdapter.
+func NewStoreAdapter(store Store) *StoreAdapter {
+	return db.NewStoreAdapter(store)
 }
 
-// IsNotFound returns true if the error indicates a record was not found
-func IsNotFound(err error) bool {
-	if err == nil {
-		return false
-	}
-	var storeErr *StoreError
-	if e, ok := err.(*StoreError); ok {
-		storeErr = e
-	} else {
-		return false
-	}
-
-	_, ok := storeErr.Err.(*types.ResourceNotFoundException)
-	return ok
+// CreateStoreFromClient returns db.CreateStoreFromClient(client).
+//
+// Deprecated: use db.CreateStoreFromClient.
+func CreateStoreFromClient(client *dynamo.Client) Store {
+	return db.CreateStoreFromClient(client)
 }
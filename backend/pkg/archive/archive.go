@@ -0,0 +1,107 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// StubDataType marks a fact whose row state has been moved into an archive bundle.
+// A stub is the archived row's latest fact, so table snapshots return it in place of
+// the row, which is how the server lists archived rows; readers of row values only
+// take "json" facts and leave archived rows out.
+const StubDataType = "archived"
+
+// Record is the latest-state fact of a single row as captured in a bundle
+type Record struct {
+	FactID    string                 `json:"factId"`
+	RowID     string                 `json:"rowId"`
+	Timestamp time.Time              `json:"timestamp"`
+	Values    map[string]interface{} `json:"values"`
+}
+
+// Bundle is a compressed batch of archived rows belonging to one table namespace
+type Bundle struct {
+	Namespace string    `json:"namespace"`
+	CreatedAt time.Time `json:"createdAt"`
+	Records   []Record  `json:"records"`
+}
+
+// Stub is the small reference left in DynamoDB as the latest version of an archived row
+type Stub struct {
+	Bundle     string    `json:"bundle"`
+	ArchivedAt time.Time `json:"archivedAt"`
+}
+
+// BundleKey returns the blob key for a bundle of the given namespace
+func BundleKey(namespace, id string, at time.Time) string {
+	return fmt.Sprintf("archive/%s/%s_%s.json.gz", namespace, at.UTC().Format("20060102T150405Z"), id)
+}
+
+// Encode serializes and gzip-compresses a bundle
+func Encode(b *Bundle) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(b); err != nil {
+		return nil, fmt.Errorf("encode bundle: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("compress bundle: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decompresses and parses a bundle produced by Encode
+func Decode(data []byte) (*Bundle, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("open bundle: %w", err)
+	}
+	defer zr.Close()
+
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("decompress bundle: %w", err)
+	}
+
+	var b Bundle
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return nil, fmt.Errorf("decode bundle: %w", err)
+	}
+	return &b, nil
+}
+
+// Find returns the record for a row ID
+func (b *Bundle) Find(rowID string) (*Record, bool) {
+	for i := range b.Records {
+		if b.Records[i].RowID == rowID {
+			return &b.Records[i], true
+		}
+	}
+	return nil, false
+}
+
+// EncodeStub renders a stub as the string value stored on the stub fact
+func EncodeStub(s Stub) (string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", fmt.Errorf("encode stub: %w", err)
+	}
+	return string(data), nil
+}
+
+// DecodeStub parses a stub fact value
+func DecodeStub(value interface{}) (Stub, error) {
+	var s Stub
+	str, ok := value.(string)
+	if !ok {
+		return s, fmt.Errorf("stub value has unexpected type %T", value)
+	}
+	if err := json.Unmarshal([]byte(str), &s); err != nil {
+		return s, fmt.Errorf("decode stub: %w", err)
+	}
+	return s, nil
+}
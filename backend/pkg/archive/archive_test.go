@@ -0,0 +1,56 @@
+package archive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	bundle := &Bundle{
+		Namespace: "user-1/tasks",
+		CreatedAt: ts.Add(time.Hour),
+		Records: []Record{
+			{FactID: "f1", RowID: "row-1", Timestamp: ts, Values: map[string]interface{}{"title": "a", "done": true}},
+			{FactID: "f2", RowID: "row-2", Timestamp: ts, Values: map[string]interface{}{"count": float64(3)}},
+		},
+	}
+
+	data, err := Encode(bundle)
+	require.NoError(t, err)
+
+	decoded, err := Decode(data)
+	require.NoError(t, err)
+	assert.Equal(t, bundle.Namespace, decoded.Namespace)
+	require.Len(t, decoded.Records, 2)
+
+	rec, ok := decoded.Find("row-2")
+	require.True(t, ok)
+	assert.Equal(t, "f2", rec.FactID)
+	assert.True(t, ts.Equal(rec.Timestamp))
+	assert.Equal(t, float64(3), rec.Values["count"])
+
+	_, ok = decoded.Find("missing")
+	assert.False(t, ok)
+}
+
+func TestStubRoundTrip(t *testing.T) {
+	stub := Stub{Bundle: "archive/u/t/x.json.gz", ArchivedAt: time.Now().UTC()}
+	value, err := EncodeStub(stub)
+	require.NoError(t, err)
+
+	decoded, err := DecodeStub(value)
+	require.NoError(t, err)
+	assert.Equal(t, stub.Bundle, decoded.Bundle)
+
+	_, err = DecodeStub(map[string]interface{}{})
+	assert.Error(t, err)
+}
+
+func TestDecodeRejectsGarbage(t *testing.T) {
+	_, err := Decode([]byte("not gzip"))
+	assert.Error(t, err)
+}
@@ -8,10 +8,13 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/elibdev/notably/pkg/logging"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -34,6 +37,10 @@ var (
 	ErrAPIKeyExpired         = errors.New("API key expired")
 	ErrAPIKeyRevoked         = errors.New("API key revoked")
 	ErrInsufficientPrivilege = errors.New("insufficient privilege")
+	ErrUnknownScope          = errors.New("unknown scope")
+	ErrConflictingScopes     = errors.New("conflicting scopes")
+	ErrInvalidToken          = errors.New("invalid or expired token")
+	ErrEmailNotVerified      = errors.New("email address not verified")
 )
 
 // User represents a user in the system
@@ -45,6 +52,13 @@ type User struct {
 	CreatedAt    time.Time `json:"createdAt"`
 	UpdatedAt    time.Time `json:"updatedAt"`
 	APIKeys      []*APIKey `json:"-"`
+
+	// StorageMode selects how the user's tables map onto DynamoDB tables
+	// ("shared" or "isolated"); empty means the server default
+	StorageMode string `json:"storageMode,omitempty"`
+
+	// EmailVerified is set once the user follows an email verification link
+	EmailVerified bool `json:"emailVerified"`
 }
 
 // APIKey represents an API key for authentication
@@ -58,6 +72,81 @@ type APIKey struct {
 	ExpiresAt time.Time `json:"expiresAt"`
 	LastUsed  time.Time `json:"lastUsed"`
 	Revoked   bool      `json:"revoked"`
+
+	// Scopes grant access beyond the default table permissions or restrict
+	// the key to less than them
+	Scopes []string `json:"scopes,omitempty"`
+
+	// RotatedTo and RotatedFrom link a rotated key and its replacement
+	RotatedTo   string    `json:"rotatedTo,omitempty"`
+	RotatedFrom string    `json:"rotatedFrom,omitempty"`
+	RotatedAt   time.Time `json:"rotatedAt,omitempty"`
+}
+
+const (
+	// ScopeSecretsRead allows reading decrypted values from secrets tables
+	ScopeSecretsRead = "secrets:read"
+
+	// ScopeAdmin allows managing API keys and reviewing access. Keys
+	// without read or table scopes hold it implicitly.
+	ScopeAdmin = "admin"
+
+	// ScopeRead restricts a key to reading
+	ScopeRead = "read"
+
+	// ScopeTablePrefix restricts a key to the named table, as in
+	// "table:orders"; grant one per table
+	ScopeTablePrefix = "table:"
+)
+
+// KnownScopes lists the scopes that may be granted to API keys, besides
+// table scopes
+var KnownScopes = []string{ScopeSecretsRead, ScopeAdmin, ScopeRead}
+
+// HasScope reports whether the key was granted a scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadOnly reports whether the key may only read
+func (k *APIKey) ReadOnly() bool {
+	return k.HasScope(ScopeRead)
+}
+
+// Tables returns the tables a key is restricted to, or nil when it may
+// reach every table
+func (k *APIKey) Tables() []string {
+	var tables []string
+	for _, s := range k.Scopes {
+		if name, ok := strings.CutPrefix(s, ScopeTablePrefix); ok {
+			tables = append(tables, name)
+		}
+	}
+	return tables
+}
+
+// CanAccessTable reports whether the key's table scopes allow a table
+func (k *APIKey) CanAccessTable(table string) bool {
+	tables := k.Tables()
+	if tables == nil {
+		return true
+	}
+	for _, t := range tables {
+		if t == table {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin reports whether the key may manage API keys and review access
+func (k *APIKey) IsAdmin() bool {
+	return k.HasScope(ScopeAdmin) || (!k.ReadOnly() && k.Tables() == nil)
 }
 
 // UserStore is an interface for user data storage
@@ -76,6 +165,17 @@ type UserStore interface {
 	UpdateAPIKey(ctx context.Context, key *APIKey) error
 	DeleteAPIKey(ctx context.Context, id string) error
 	ListAPIKeys(ctx context.Context, userID string) ([]*APIKey, error)
+
+	// One-time token methods
+	CreateToken(ctx context.Context, token *Token) error
+	// TakeToken removes and returns a token, so it can only be used once
+	TakeToken(ctx context.Context, hash string) (*Token, error)
+
+	// Login attempt methods. GetLoginAttempts returns nil when a key has
+	// no recorded attempts.
+	GetLoginAttempts(ctx context.Context, key string) (*LoginAttempts, error)
+	PutLoginAttempts(ctx context.Context, attempts *LoginAttempts) error
+	DeleteLoginAttempts(ctx context.Context, key string) error
 }
 
 // InMemoryUserStore implements UserStore with in-memory storage
@@ -86,8 +186,22 @@ type InMemoryUserStore struct {
 	emails    map[string]string  // email -> userID
 	apiKeys   map[string]*APIKey // key hash -> APIKey
 	apiKeyIDs map[string]*APIKey // key ID -> APIKey
+	tokens    map[string]*Token  // token hash -> Token
+	attempts  map[string]*LoginAttempts
+
+	// attemptsSwept is when expired login attempts were last dropped
+	attemptsSwept time.Time
 }
 
+// MaxLoginAttempts is the number of accounts, names and client addresses
+// whose failed logins an InMemoryUserStore keeps. Every made-up name adds
+// one, so past the limit the oldest failures are evicted, those of names
+// that match no account first.
+const MaxLoginAttempts = 100000
+
+// loginAttemptsSweep is how often expired login attempts are dropped
+const loginAttemptsSweep = time.Minute
+
 // NewInMemoryUserStore creates a new in-memory user store
 func NewInMemoryUserStore() *InMemoryUserStore {
 	return &InMemoryUserStore{
@@ -96,17 +210,70 @@ func NewInMemoryUserStore() *InMemoryUserStore {
 		emails:    make(map[string]string),
 		apiKeys:   make(map[string]*APIKey),
 		apiKeyIDs: make(map[string]*APIKey),
+		tokens:    make(map[string]*Token),
+		attempts:  make(map[string]*LoginAttempts),
 	}
 }
 
 // Authenticator manages user authentication
 type Authenticator struct {
 	store UserStore
+
+	// onFailure, if set, is called with a short reason for every rejected request
+	onFailure func(reason string)
+
+	// keyExpiration is the lifetime of keys created without one; zero means
+	// DefaultAPIKeyExpiration
+	keyExpiration time.Duration
+
+	// requireVerifiedEmail refuses logins until the email is verified
+	requireVerifiedEmail bool
+
+	// lockout limits failed logins
+	lockout LockoutPolicy
+
+	// keySecret is the HMAC key of API key digests
+	keySecret []byte
+
+	// bcryptCost is the cost of password hashes; zero means bcrypt.DefaultCost
+	bcryptCost int
+
+	// legacyMu serializes the search for bcrypt-hashed API keys, and
+	// noLegacyKeys is set once a search found none left
+	legacyMu     sync.Mutex
+	noLegacyKeys atomic.Bool
 }
 
 // NewAuthenticator creates a new authenticator
 func NewAuthenticator(store UserStore) *Authenticator {
-	return &Authenticator{store: store}
+	return &Authenticator{store: store, lockout: DefaultLockoutPolicy()}
+}
+
+// OnFailure registers a hook called with a short reason ("missing_key",
+// "invalid_format", "expired", "revoked" or "invalid_key") whenever RequireAuth
+// rejects a request
+func (a *Authenticator) OnFailure(fn func(reason string)) {
+	a.onFailure = fn
+}
+
+// SetKeyExpiration sets the lifetime of API keys generated or refreshed
+// without an explicit duration; zero restores DefaultAPIKeyExpiration
+func (a *Authenticator) SetKeyExpiration(d time.Duration) {
+	a.keyExpiration = d
+}
+
+// defaultExpiration returns the lifetime of keys created without one
+func (a *Authenticator) defaultExpiration() time.Duration {
+	if a.keyExpiration > 0 {
+		return a.keyExpiration
+	}
+	return DefaultAPIKeyExpiration
+}
+
+func (a *Authenticator) fail(reason string) {
+	if a.onFailure != nil {
+		a.onFailure(reason)
+	}
 }
 
 // RegisterUser registers a new user
@@ -123,9 +290,9 @@ func (a *Authenticator) RegisterUser(ctx context.Context, username, email, passw
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := a.hashPassword(password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to hash password: %w", err)
+		return nil, err
 	}
 
 	now := time.Now().UTC()
@@ -133,7 +300,7 @@ func (a *Authenticator) RegisterUser(ctx context.Context, username, email, passw
 		ID:           generateID(),
 		Username:     username,
 		Email:        email,
-		PasswordHash: string(hashedPassword),
+		PasswordHash: hashedPassword,
 		CreatedAt:    now,
 		UpdatedAt:    now,
 		APIKeys:      []*APIKey{},
@@ -146,10 +313,14 @@ func (a *Authenticator) RegisterUser(ctx context.Context, username, email, passw
 	return user, nil
 }
 
-// LoginUser authenticates a user by username/email and password
-func (a *Authenticator) LoginUser(ctx context.Context, usernameOrEmail, password string) (*User, error) {
+// LoginUser authenticates a user by username/email and password. Failures
+// count against the account, or the submitted name when no account has it,
+// and, when ip is not empty, the client address; refused attempts return a
+// *LockoutError.
+func (a *Authenticator) LoginUser(ctx context.Context, usernameOrEmail, password, ip string) (*User, error) {
 	var user *User
 	var err error
+	now := time.Now().UTC()
 
 	// Try to find by username
 	user, err = a.store.GetUserByUsername(ctx, usernameOrEmail)
@@ -157,21 +328,61 @@ func (a *Authenticator) LoginUser(ctx context.Context, usernameOrEmail, password
 		// Try to find by email
 		user, err = a.store.GetUserByEmail(ctx, usernameOrEmail)
 		if err != nil {
+			account := nameAttemptsKey(usernameOrEmail)
+			if err := a.checkLockout(ctx, account, ip, now); err != nil {
+				return nil, err
+			}
+			if err := a.recordLoginFailure(ctx, account, ip, now); err != nil {
+				return nil, fmt.Errorf("failed to record login attempt: %w", err)
+			}
 			return nil, ErrInvalidCredentials
 		}
 	}
 
+	if err := a.checkLockout(ctx, userAttemptsKey(user.ID), ip, now); err != nil {
+		return nil, err
+	}
+
 	// Check password
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
 	if err != nil {
+		if err := a.recordLoginFailure(ctx, userAttemptsKey(user.ID), ip, now); err != nil {
+			return nil, fmt.Errorf("failed to record login attempt: %w", err)
+		}
 		return nil, ErrInvalidCredentials
 	}
+	if err := a.store.DeleteLoginAttempts(ctx, userAttemptsKey(user.ID)); err != nil {
+		return nil, fmt.Errorf("failed to clear login attempts: %w", err)
+	}
+	a.rehashPassword(ctx, user, password)
+
+	if a.requireVerifiedEmail && !user.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
 
 	return user, nil
 }
 
 // GenerateAPIKey creates a new API key for a user
 func (a *Authenticator) GenerateAPIKey(ctx context.Context, userID, name string, duration time.Duration) (*APIKey, string, error) {
+	return a.GenerateScopedAPIKey(ctx, userID, name, duration, nil)
+}
+
+// GenerateScopedAPIKey creates a new API key for a user with extra scopes
+func (a *Authenticator) GenerateScopedAPIKey(ctx context.Context, userID, name string, duration time.Duration, scopes []string) (*APIKey, string, error) {
+	restricted := false
+	for _, scope := range scopes {
+		if !isKnownScope(scope) {
+			return nil, "", fmt.Errorf("%w: %s", ErrUnknownScope, scope)
+		}
+		restricted = restricted || scope == ScopeRead || strings.HasPrefix(scope, ScopeTablePrefix)
+	}
+	for _, scope := range scopes {
+		if scope == ScopeAdmin && restricted {
+			return nil, "", fmt.Errorf("%w: admin cannot be combined with read or table scopes", ErrConflictingScopes)
+		}
+	}
+
 	// Verify user exists
 	user, err := a.store.GetUserByID(ctx, userID)
 	if err != nil {
@@ -187,26 +398,21 @@ func (a *Authenticator) GenerateAPIKey(ctx context.Context, userID, name string,
 	// Format the key with prefix and encode
 	rawKey := fmt.Sprintf("%s%s", APIKeyPrefix, hex.EncodeToString(keyBytes))
 
-	// Hash the key for storage
-	hashedKey, err := bcrypt.GenerateFromPassword([]byte(rawKey), bcrypt.DefaultCost)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to hash key: %w", err)
-	}
-
 	now := time.Now().UTC()
 	if duration == 0 {
-		duration = DefaultAPIKeyExpiration
+		duration = a.defaultExpiration()
 	}
 
 	apiKey := &APIKey{
 		ID:        generateID(),
 		UserID:    user.ID,
-		KeyHash:   string(hashedKey),
+		KeyHash:   a.hashAPIKey(rawKey),
 		Name:      name,
 		CreatedAt: now,
 		ExpiresAt: now.Add(duration),
 		LastUsed:  now,
 		Revoked:   false,
+		Scopes:    scopes,
 	}
 
 	if err := a.store.CreateAPIKey(ctx, apiKey); err != nil {
@@ -223,46 +429,32 @@ func (a *Authenticator) VerifyAPIKey(ctx context.Context, apiKeyStr string) (*Us
 		return nil, nil, ErrInvalidAPIKey
 	}
 
-	// Get all API keys and check each one
-	// This is inefficient but needed since we can't query by the raw key directly
-	// In a real system, we'd use a more efficient lookup mechanism
-
-	keys, err := a.GetAllAPIKeys(ctx)
+	key, err := a.findAPIKey(ctx, apiKeyStr)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get API keys: %w", err)
+		return nil, nil, err
 	}
 
 	now := time.Now().UTC()
+	if key.Revoked {
+		return nil, nil, ErrAPIKeyRevoked
+	}
+	if now.After(key.ExpiresAt) {
+		return nil, nil, ErrAPIKeyExpired
+	}
 
-	for _, key := range keys {
-		// Compare API key hash (slow but secure)
-		if err := bcrypt.CompareHashAndPassword([]byte(key.KeyHash), []byte(apiKeyStr)); err == nil {
-			// Key found, check if valid
-			if key.Revoked {
-				return nil, nil, ErrAPIKeyRevoked
-			}
-
-			if now.After(key.ExpiresAt) {
-				return nil, nil, ErrAPIKeyExpired
-			}
-
-			// Update last used time
-			key.LastUsed = now
-			if err := a.store.UpdateAPIKey(ctx, key); err != nil {
-				// Non-fatal error, just log it in a real implementation
-			}
-
-			// Get associated user
-			user, err := a.store.GetUserByID(ctx, key.UserID)
-			if err != nil {
-				return nil, nil, fmt.Errorf("API key valid but user not found: %w", err)
-			}
+	// Update last used time
+	key.LastUsed = now
+	if err := a.store.UpdateAPIKey(ctx, key); err != nil {
+		// Non-fatal error, just log it in a real implementation
+	}
 
-			return user, key, nil
-		}
+	// Get associated user
+	user, err := a.store.GetUserByID(ctx, key.UserID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("API key valid but user not found: %w", err)
 	}
 
-	return nil, nil, ErrInvalidAPIKey
+	return user, key, nil
 }
 
 // GetAllAPIKeys returns all API keys (for internal use)
@@ -287,16 +479,8 @@ func (a *Authenticator) GetAllAPIKeys(ctx context.Context) ([]*APIKey, error) {
 
 // GetAllUsers returns all users (for internal use)
 func (a *Authenticator) GetAllUsers(ctx context.Context) ([]*User, error) {
-	// Simplified implementation for InMemoryUserStore
-	if store, ok := a.store.(*InMemoryUserStore); ok {
-		store.mu.RLock()
-		defer store.mu.RUnlock()
-
-		users := make([]*User, 0, len(store.users))
-		for _, user := range store.users {
-			users = append(users, user)
-		}
-		return users, nil
+	if store, ok := a.store.(interface{ allUsers() []*User }); ok {
+		return store.allUsers(), nil
 	}
 
 	return nil, errors.New("operation not supported by this store implementation")
@@ -337,13 +521,25 @@ func (a *Authenticator) RefreshAPIKey(ctx context.Context, userID, keyID string,
 
 	// Extend expiration
 	if duration == 0 {
-		duration = DefaultAPIKeyExpiration
+		duration = a.defaultExpiration()
 	}
 	key.ExpiresAt = time.Now().UTC().Add(duration)
 
 	return a.store.UpdateAPIKey(ctx, key)
 }
 
+// GetAPIKey returns one of a user's API keys
+func (a *Authenticator) GetAPIKey(ctx context.Context, userID, keyID string) (*APIKey, error) {
+	key, err := a.store.GetAPIKey(ctx, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	if key.UserID != userID {
+		return nil, ErrInsufficientPrivilege
+	}
+	return key, nil
+}
+
 // ListAPIKeys lists all API keys for a user
 func (a *Authenticator) ListAPIKeys(ctx context.Context, userID string) ([]*APIKey, error) {
 	return a.store.ListAPIKeys(ctx, userID)
@@ -355,6 +551,7 @@ func (a *Authenticator) RequireAuth(next http.Handler) http.Handler {
 		// Get API key from Authorization header
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
+			a.fail("missing_key")
 			http.Error(w, "unauthorized: missing API key", http.StatusUnauthorized)
 			return
 		}
@@ -362,6 +559,7 @@ func (a *Authenticator) RequireAuth(next http.Handler) http.Handler {
 		// Expected format: "Bearer API_KEY"
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+			a.fail("invalid_format")
 			http.Error(w, "unauthorized: invalid authorization format", http.StatusUnauthorized)
 			return
 		}
@@ -371,16 +569,20 @@ func (a *Authenticator) RequireAuth(next http.Handler) http.Handler {
 		if err != nil {
 			switch err {
 			case ErrAPIKeyExpired:
+				a.fail("expired")
 				http.Error(w, "unauthorized: API key expired", http.StatusUnauthorized)
 			case ErrAPIKeyRevoked:
+				a.fail("revoked")
 				http.Error(w, "unauthorized: API key revoked", http.StatusUnauthorized)
 			default:
+				a.fail("invalid_key")
 				http.Error(w, "unauthorized: invalid API key", http.StatusUnauthorized)
 			}
 			return
 		}
 
-		// Add user and key to context
+		// Record the user for request logging, then add user and key to context
+		logging.SetUser(r.Context(), user.ID)
 		ctx := context.WithValue(r.Context(), contextKeyUser, user)
 		ctx = context.WithValue(ctx, contextKeyAPIKey, key)
 
@@ -395,6 +597,12 @@ func UserFromContext(ctx context.Context) (*User, bool) {
 	return user, ok
 }
 
+// ContextWithUser returns a context carrying user without an API key, for
+// requests authorized by other means such as signed links
+func ContextWithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, contextKeyUser, user)
+}
+
 // APIKeyFromContext extracts the API key from the context
 func APIKeyFromContext(ctx context.Context) (*APIKey, bool) {
 	key, ok := ctx.Value(contextKeyAPIKey).(*APIKey)
@@ -420,8 +628,32 @@ func generateID() string {
 	return base64.RawURLEncoding.EncodeToString(id)
 }
 
+func isKnownScope(scope string) bool {
+	if name, ok := strings.CutPrefix(scope, ScopeTablePrefix); ok {
+		return name != ""
+	}
+	for _, s := range KnownScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
 // InMemoryUserStore implementation
 
+// allUsers returns every user of the store
+func (s *InMemoryUserStore) allUsers() []*User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]*User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	return users
+}
+
 func (s *InMemoryUserStore) CreateUser(ctx context.Context, user *User) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -572,12 +804,15 @@ func (s *InMemoryUserStore) UpdateAPIKey(ctx context.Context, key *APIKey) error
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.apiKeyIDs[key.ID]; !exists {
+	old, exists := s.apiKeyIDs[key.ID]
+	if !exists {
 		return errors.New("API key not found")
 	}
 
+	// The hash changes when a legacy key is rehashed
+	delete(s.apiKeys, old.KeyHash)
 	s.apiKeyIDs[key.ID] = key
-	// No need to update the hash map since the hash doesn't change
+	s.apiKeys[key.KeyHash] = key
 
 	return nil
 }
@@ -614,3 +849,87 @@ func (s *InMemoryUserStore) ListAPIKeys(ctx context.Context, userID string) ([]*
 
 	return keys, nil
 }
+
+func (s *InMemoryUserStore) CreateToken(ctx context.Context, token *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[token.UserID]; !exists {
+		return ErrUserNotFound
+	}
+	s.tokens[token.Hash] = token
+
+	return nil
+}
+
+func (s *InMemoryUserStore) TakeToken(ctx context.Context, hash string) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, exists := s.tokens[hash]
+	if !exists {
+		return nil, ErrInvalidToken
+	}
+	delete(s.tokens, hash)
+
+	return token, nil
+}
+
+func (s *InMemoryUserStore) GetLoginAttempts(ctx context.Context, key string) (*LoginAttempts, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.attempts[key], nil
+}
+
+func (s *InMemoryUserStore) PutLoginAttempts(ctx context.Context, attempts *LoginAttempts) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attempts[attempts.Key] = attempts
+	s.pruneLoginAttempts(time.Now())
+
+	return nil
+}
+
+// pruneLoginAttempts drops expired login attempts, at most once per sweep
+// interval unless the store is over MaxLoginAttempts, and then evicts the
+// oldest failures down to nine tenths of the limit. The caller holds s.mu.
+func (s *InMemoryUserStore) pruneLoginAttempts(now time.Time) {
+	full := len(s.attempts) > MaxLoginAttempts
+	if !full && now.Sub(s.attemptsSwept) < loginAttemptsSweep {
+		return
+	}
+	s.attemptsSwept = now
+	for key, attempts := range s.attempts {
+		if !attempts.ExpiresAt.IsZero() && now.After(attempts.ExpiresAt) {
+			delete(s.attempts, key)
+		}
+	}
+	if len(s.attempts) <= MaxLoginAttempts {
+		return
+	}
+
+	oldest := make([]*LoginAttempts, 0, len(s.attempts))
+	for _, attempts := range s.attempts {
+		oldest = append(oldest, attempts)
+	}
+	sort.Slice(oldest, func(i, j int) bool {
+		if a, b := strings.HasPrefix(oldest[i].Key, nameAttemptsPrefix), strings.HasPrefix(oldest[j].Key, nameAttemptsPrefix); a != b {
+			return a
+		}
+		return oldest[i].LastFailure.Before(oldest[j].LastFailure)
+	})
+	for _, attempts := range oldest[:len(oldest)-MaxLoginAttempts*9/10] {
+		delete(s.attempts, attempts.Key)
+	}
+}
+
+func (s *InMemoryUserStore) DeleteLoginAttempts(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+
+	return nil
+}
@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	// lastUsedPrecision is how stale the last use of a key may be on disk.
+	// Every authenticated request marks its key used, and writing the file
+	// each time would cost more than the request.
+	lastUsedPrecision = time.Minute
+
+	// lockWait is how long a write waits for another process to finish
+	// its own, and staleLock how old a lock file must be to be taken over
+	// from a process that died holding it
+	lockWait  = 5 * time.Second
+	staleLock = 30 * time.Second
+)
+
+// FileUserStore implements UserStore with a JSON file, so accounts survive
+// restarts of single-server deployments and can be provisioned by other
+// processes, such as notably-admin, while the server runs. Reads pick up
+// the file again whenever another process has replaced it; writes take a
+// lock file next to it and replace the file atomically.
+type FileUserStore struct {
+	path string
+
+	mu    sync.Mutex
+	mem   *InMemoryUserStore
+	stamp fileStamp
+	// saved holds each key as it was last written, to tell updates that
+	// only mark a key used from those that must be written at once
+	saved map[string]APIKey
+}
+
+// fileStamp identifies a version of the file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// userFile is the layout of the file. Unlike the API's JSON of the same
+// types, it keeps password and key hashes.
+type userFile struct {
+	Users         []fileUser     `json:"users"`
+	APIKeys       []fileAPIKey   `json:"apiKeys"`
+	Tokens        []fileToken    `json:"tokens"`
+	LoginAttempts []fileAttempts `json:"loginAttempts"`
+}
+
+type fileUser struct {
+	User
+	PasswordHash string `json:"passwordHash"`
+}
+
+type fileAPIKey struct {
+	APIKey
+	KeyHash string `json:"keyHash"`
+}
+
+type fileToken struct {
+	Token
+	Hash string `json:"hash"`
+}
+
+type fileAttempts struct {
+	LoginAttempts
+	Key string `json:"key"`
+}
+
+// OpenFileUserStore returns a store kept in the file at path, which is
+// created on the first write if it does not exist
+func OpenFileUserStore(path string) (*FileUserStore, error) {
+	s := &FileUserStore{path: path, mem: NewInMemoryUserStore(), saved: make(map[string]APIKey)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload reads the file again if another process has replaced it
+func (s *FileUserStore) reload() error {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	stamp := fileStamp{modTime: info.ModTime(), size: info.Size()}
+	if stamp == s.stamp {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	var file userFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("auth: parse %s: %w", s.path, err)
+	}
+
+	mem := NewInMemoryUserStore()
+	saved := make(map[string]APIKey)
+	for _, u := range file.Users {
+		user := u.User
+		user.PasswordHash = u.PasswordHash
+		mem.users[user.ID] = &user
+		mem.usernames[user.Username] = user.ID
+		mem.emails[user.Email] = user.ID
+	}
+	for _, k := range file.APIKeys {
+		key := k.APIKey
+		key.KeyHash = k.KeyHash
+		mem.apiKeys[key.KeyHash] = &key
+		mem.apiKeyIDs[key.ID] = &key
+		saved[key.ID] = key
+	}
+	for _, t := range file.Tokens {
+		token := t.Token
+		token.Hash = t.Hash
+		mem.tokens[token.Hash] = &token
+	}
+	for _, a := range file.LoginAttempts {
+		attempts := a.LoginAttempts
+		attempts.Key = a.Key
+		mem.attempts[attempts.Key] = &attempts
+	}
+	s.mem, s.saved, s.stamp = mem, saved, stamp
+	return nil
+}
+
+// save replaces the file with the store's contents
+func (s *FileUserStore) save() error {
+	var file userFile
+	saved := make(map[string]APIKey)
+	s.mem.mu.RLock()
+	for _, user := range s.mem.users {
+		file.Users = append(file.Users, fileUser{User: *user, PasswordHash: user.PasswordHash})
+	}
+	for _, key := range s.mem.apiKeyIDs {
+		file.APIKeys = append(file.APIKeys, fileAPIKey{APIKey: *key, KeyHash: key.KeyHash})
+		saved[key.ID] = *key
+	}
+	for _, token := range s.mem.tokens {
+		file.Tokens = append(file.Tokens, fileToken{Token: *token, Hash: token.Hash})
+	}
+	for _, attempts := range s.mem.attempts {
+		file.LoginAttempts = append(file.LoginAttempts, fileAttempts{LoginAttempts: *attempts, Key: attempts.Key})
+	}
+	s.mem.mu.RUnlock()
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("auth: write %s: %w", s.path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("auth: write %s: %w", s.path, err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	s.stamp, s.saved = fileStamp{modTime: info.ModTime(), size: info.Size()}, saved
+	return nil
+}
+
+// lock takes the lock file of the store, waiting for other processes
+// holding it, and returns the function that releases it
+func (s *FileUserStore) lock() (func(), error) {
+	path := s.path + ".lock"
+	deadline := time.Now().Add(lockWait)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("auth: %w", err)
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLock {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("auth: %s is locked by another process", s.path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// read runs a read of the store on its latest contents
+func (s *FileUserStore) read(fn func(mem *InMemoryUserStore) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+	return fn(s.mem)
+}
+
+// write runs a change of the store under its lock and saves it
+func (s *FileUserStore) write(fn func(mem *InMemoryUserStore) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+	if err := fn(s.mem); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+// allUsers returns every user of the store
+func (s *FileUserStore) allUsers() []*User {
+	var users []*User
+	_ = s.read(func(mem *InMemoryUserStore) error {
+		users = mem.allUsers()
+		return nil
+	})
+	return users
+}
+
+func (s *FileUserStore) CreateUser(ctx context.Context, user *User) error {
+	return s.write(func(mem *InMemoryUserStore) error { return mem.CreateUser(ctx, user) })
+}
+
+func (s *FileUserStore) GetUserByID(ctx context.Context, id string) (user *User, err error) {
+	err = s.read(func(mem *InMemoryUserStore) error {
+		user, err = mem.GetUserByID(ctx, id)
+		return err
+	})
+	return user, err
+}
+
+func (s *FileUserStore) GetUserByUsername(ctx context.Context, username string) (user *User, err error) {
+	err = s.read(func(mem *InMemoryUserStore) error {
+		user, err = mem.GetUserByUsername(ctx, username)
+		return err
+	})
+	return user, err
+}
+
+func (s *FileUserStore) GetUserByEmail(ctx context.Context, email string) (user *User, err error) {
+	err = s.read(func(mem *InMemoryUserStore) error {
+		user, err = mem.GetUserByEmail(ctx, email)
+		return err
+	})
+	return user, err
+}
+
+func (s *FileUserStore) UpdateUser(ctx context.Context, user *User) error {
+	return s.write(func(mem *InMemoryUserStore) error { return mem.UpdateUser(ctx, user) })
+}
+
+func (s *FileUserStore) DeleteUser(ctx context.Context, id string) error {
+	return s.write(func(mem *InMemoryUserStore) error { return mem.DeleteUser(ctx, id) })
+}
+
+func (s *FileUserStore) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	return s.write(func(mem *InMemoryUserStore) error { return mem.CreateAPIKey(ctx, key) })
+}
+
+func (s *FileUserStore) GetAPIKey(ctx context.Context, id string) (key *APIKey, err error) {
+	err = s.read(func(mem *InMemoryUserStore) error {
+		key, err = mem.GetAPIKey(ctx, id)
+		return err
+	})
+	return key, err
+}
+
+func (s *FileUserStore) GetAPIKeyByHash(ctx context.Context, keyHash string) (key *APIKey, err error) {
+	err = s.read(func(mem *InMemoryUserStore) error {
+		key, err = mem.GetAPIKeyByHash(ctx, keyHash)
+		return err
+	})
+	return key, err
+}
+
+// UpdateAPIKey implements UserStore. Updates that only mark a key used are
+// kept in memory until the last use on disk is lastUsedPrecision old.
+func (s *FileUserStore) UpdateAPIKey(ctx context.Context, key *APIKey) error {
+	s.mu.Lock()
+	saved, ok := s.saved[key.ID]
+	if ok && key.LastUsed.Sub(saved.LastUsed) < lastUsedPrecision {
+		unchanged := *key
+		unchanged.LastUsed = saved.LastUsed
+		if reflect.DeepEqual(unchanged, saved) {
+			defer s.mu.Unlock()
+			return s.mem.UpdateAPIKey(ctx, key)
+		}
+	}
+	s.mu.Unlock()
+	return s.write(func(mem *InMemoryUserStore) error { return mem.UpdateAPIKey(ctx, key) })
+}
+
+func (s *FileUserStore) DeleteAPIKey(ctx context.Context, id string) error {
+	return s.write(func(mem *InMemoryUserStore) error { return mem.DeleteAPIKey(ctx, id) })
+}
+
+func (s *FileUserStore) ListAPIKeys(ctx context.Context, userID string) (keys []*APIKey, err error) {
+	err = s.read(func(mem *InMemoryUserStore) error {
+		keys, err = mem.ListAPIKeys(ctx, userID)
+		return err
+	})
+	return keys, err
+}
+
+func (s *FileUserStore) CreateToken(ctx context.Context, token *Token) error {
+	return s.write(func(mem *InMemoryUserStore) error { return mem.CreateToken(ctx, token) })
+}
+
+func (s *FileUserStore) TakeToken(ctx context.Context, hash string) (token *Token, err error) {
+	err = s.write(func(mem *InMemoryUserStore) error {
+		token, err = mem.TakeToken(ctx, hash)
+		return err
+	})
+	return token, err
+}
+
+func (s *FileUserStore) GetLoginAttempts(ctx context.Context, key string) (attempts *LoginAttempts, err error) {
+	err = s.read(func(mem *InMemoryUserStore) error {
+		attempts, err = mem.GetLoginAttempts(ctx, key)
+		return err
+	})
+	return attempts, err
+}
+
+func (s *FileUserStore) PutLoginAttempts(ctx context.Context, attempts *LoginAttempts) error {
+	return s.write(func(mem *InMemoryUserStore) error { return mem.PutLoginAttempts(ctx, attempts) })
+}
+
+func (s *FileUserStore) DeleteLoginAttempts(ctx context.Context, key string) error {
+	return s.write(func(mem *InMemoryUserStore) error { return mem.DeleteLoginAttempts(ctx, key) })
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// keyHashPrefix marks API key hashes that are HMAC-SHA256 digests. Keys
+// stored before it have bcrypt hashes, which begin with "$2".
+const keyHashPrefix = "hmac-sha256:"
+
+// SetKeyHashSecret sets the HMAC key API keys are digested with. API keys
+// carry 256 random bits, so a fast keyed digest is as strong as bcrypt and
+// can be looked up directly; the secret keeps a leaked user store from being
+// used to check guesses offline. Changing it invalidates existing keys.
+func (a *Authenticator) SetKeyHashSecret(secret []byte) {
+	a.keySecret = secret
+}
+
+// SetBcryptCost sets the bcrypt cost of password hashes; zero restores
+// bcrypt.DefaultCost. Passwords hashed at another cost are rehashed at the
+// next successful login.
+func (a *Authenticator) SetBcryptCost(cost int) error {
+	if cost != 0 && (cost < bcrypt.MinCost || cost > bcrypt.MaxCost) {
+		return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	a.bcryptCost = cost
+	return nil
+}
+
+// passwordCost returns the bcrypt cost of new password hashes
+func (a *Authenticator) passwordCost() int {
+	if a.bcryptCost > 0 {
+		return a.bcryptCost
+	}
+	return bcrypt.DefaultCost
+}
+
+// hashPassword hashes a password with bcrypt at the configured cost
+func (a *Authenticator) hashPassword(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), a.passwordCost())
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hashed), nil
+}
+
+// rehashPassword stores a new hash of a just-verified password when its
+// hash was made at another cost. Failures leave the old hash in place.
+func (a *Authenticator) rehashPassword(ctx context.Context, user *User, password string) {
+	if cost, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil || cost == a.passwordCost() {
+		return
+	}
+	hashed, err := a.hashPassword(password)
+	if err != nil {
+		return
+	}
+	user.PasswordHash = hashed
+	_ = a.store.UpdateUser(ctx, user)
+}
+
+// SetPassword replaces a user's password without a reset token, for
+// operators provisioning accounts. Unlike ResetPassword it leaves the
+// user's API keys working.
+func (a *Authenticator) SetPassword(ctx context.Context, userID, password string) (*User, error) {
+	user, err := a.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	hashed, err := a.hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	user.PasswordHash = hashed
+	user.UpdatedAt = time.Now().UTC()
+	if err := a.store.UpdateUser(ctx, user); err != nil {
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+	return user, nil
+}
+
+// hashAPIKey returns the stored digest of a raw API key
+func (a *Authenticator) hashAPIKey(raw string) string {
+	return hashAPIKeyWith(a.keySecret, raw)
+}
+
+// hashAPIKeyWith returns the digest of a raw API key under secret
+func hashAPIKeyWith(secret []byte, raw string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(raw))
+	return keyHashPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// isLegacyKeyHash reports whether a stored key hash is a bcrypt hash from
+// before keys were digested with HMAC
+func isLegacyKeyHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2")
+}
+
+// findAPIKey looks a raw key up by its digest. Keys still stored with bcrypt,
+// or digested without a secret before one was set, are rehashed on first
+// use. bcrypt keys can only be found by comparing against each one, so that
+// search runs one at a time, and stops for good once it finds no bcrypt
+// hashes left: new keys are never stored with bcrypt.
+func (a *Authenticator) findAPIKey(ctx context.Context, raw string) (*APIKey, error) {
+	digest := a.hashAPIKey(raw)
+	if key, err := a.store.GetAPIKeyByHash(ctx, digest); err == nil {
+		return key, nil
+	}
+	if len(a.keySecret) > 0 {
+		if key, err := a.store.GetAPIKeyByHash(ctx, hashAPIKeyWith(nil, raw)); err == nil {
+			return a.migrateKeyHash(ctx, key, digest), nil
+		}
+	}
+	if a.noLegacyKeys.Load() {
+		return nil, ErrInvalidAPIKey
+	}
+
+	a.legacyMu.Lock()
+	defer a.legacyMu.Unlock()
+	// A key migrated while this search waited is found by its digest
+	if key, err := a.store.GetAPIKeyByHash(ctx, digest); err == nil {
+		return key, nil
+	}
+	keys, err := a.GetAllAPIKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API keys: %w", err)
+	}
+	legacy := 0
+	for _, key := range keys {
+		if !isLegacyKeyHash(key.KeyHash) {
+			continue
+		}
+		legacy++
+		if bcrypt.CompareHashAndPassword([]byte(key.KeyHash), []byte(raw)) != nil {
+			continue
+		}
+		return a.migrateKeyHash(ctx, key, digest), nil
+	}
+	if legacy == 0 {
+		a.noLegacyKeys.Store(true)
+	}
+	return nil, ErrInvalidAPIKey
+}
+
+// migrateKeyHash stores a legacy key under its current digest
+func (a *Authenticator) migrateKeyHash(ctx context.Context, key *APIKey, digest string) *APIKey {
+	migrated := *key
+	migrated.KeyHash = digest
+	if err := a.store.UpdateAPIKey(ctx, &migrated); err != nil {
+		// The key still works; migration is retried on its next use
+		return key
+	}
+	return &migrated
+}
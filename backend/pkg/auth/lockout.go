@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrAccountLocked  = errors.New("account locked")
+	ErrLoginThrottled = errors.New("too many login attempts")
+)
+
+// LockoutPolicy limits password guessing. Each failed login delays the next
+// attempt on the account exponentially, and too many failures lock the
+// account or the client address for a while. Zero values disable each limit.
+type LockoutPolicy struct {
+	// MaxFailures locks an account after this many consecutive failures
+	MaxFailures int
+
+	// IPMaxFailures blocks a client address after this many failures
+	// across all accounts
+	IPMaxFailures int
+
+	// LockDuration is how long a lock lasts, and how long failures are
+	// remembered
+	LockDuration time.Duration
+
+	// BaseDelay is the wait after the second consecutive failure, doubling
+	// with each further one up to LockDuration. The first failure is free.
+	BaseDelay time.Duration
+}
+
+// DefaultLockoutPolicy returns the lockout policy of new authenticators
+func DefaultLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		MaxFailures:   5,
+		IPMaxFailures: 20,
+		LockDuration:  15 * time.Minute,
+		BaseDelay:     time.Second,
+	}
+}
+
+// delay returns how long to wait after a number of consecutive failures
+func (p LockoutPolicy) delay(failures int) time.Duration {
+	if failures < 2 || p.BaseDelay <= 0 {
+		return 0
+	}
+	d := p.BaseDelay
+	for i := 2; i < failures && (p.LockDuration <= 0 || d < p.LockDuration); i++ {
+		d *= 2
+	}
+	if p.LockDuration > 0 && d > p.LockDuration {
+		d = p.LockDuration
+	}
+	return d
+}
+
+// LoginAttempts tracks recent failed logins of an account or client address
+type LoginAttempts struct {
+	// Key is "user:<id>" or "ip:<address>"
+	Key         string    `json:"-"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"lastFailure,omitempty"`
+	LockedUntil time.Time `json:"lockedUntil,omitempty"`
+
+	// ExpiresAt is when the attempts may be forgotten, once both the lock
+	// and the failures have run out. Zero keeps them until they are evicted.
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// Locked reports whether the attempts hold a lock at a time
+func (a *LoginAttempts) Locked(now time.Time) bool {
+	return now.Before(a.LockedUntil)
+}
+
+// LockoutError is returned by LoginUser when an attempt is refused before
+// the password is checked. It wraps ErrAccountLocked or ErrLoginThrottled.
+type LockoutError struct {
+	Err        error
+	RetryAfter time.Time
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("%v until %s", e.Err, e.RetryAfter.Format(time.RFC3339))
+}
+
+func (e *LockoutError) Unwrap() error { return e.Err }
+
+func userAttemptsKey(userID string) string { return "user:" + userID }
+func ipAttemptsKey(ip string) string       { return "ip:" + ip }
+
+// nameAttemptsKey is the key of failures with a username or email that
+// matches no account. Counting them like an account's keeps a made-up name
+// from answering differently than a locked account, which would tell the
+// two apart.
+func nameAttemptsKey(name string) string {
+	return nameAttemptsPrefix + strings.ToLower(strings.TrimSpace(name))
+}
+
+const nameAttemptsPrefix = "name:"
+
+// SetLockoutPolicy replaces the lockout policy
+func (a *Authenticator) SetLockoutPolicy(p LockoutPolicy) {
+	a.lockout = p
+}
+
+// loginAttempts returns the remembered attempts of a key, forgetting
+// failures older than the lock duration
+func (a *Authenticator) loginAttempts(ctx context.Context, key string, now time.Time) (*LoginAttempts, error) {
+	attempts, err := a.store.GetLoginAttempts(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if attempts == nil {
+		return &LoginAttempts{Key: key}, nil
+	}
+	if a.lockout.LockDuration > 0 && !attempts.Locked(now) && now.Sub(attempts.LastFailure) > a.lockout.LockDuration {
+		return &LoginAttempts{Key: key}, nil
+	}
+	return attempts, nil
+}
+
+// checkLockout refuses an attempt on a locked or delayed account or client
+// address. account is the attempts key of the account, if any.
+func (a *Authenticator) checkLockout(ctx context.Context, account, ip string, now time.Time) error {
+	if ip != "" {
+		attempts, err := a.loginAttempts(ctx, ipAttemptsKey(ip), now)
+		if err != nil {
+			return err
+		}
+		if attempts.Locked(now) {
+			return &LockoutError{Err: ErrLoginThrottled, RetryAfter: attempts.LockedUntil}
+		}
+	}
+	if account == "" {
+		return nil
+	}
+	attempts, err := a.loginAttempts(ctx, account, now)
+	if err != nil {
+		return err
+	}
+	if attempts.Locked(now) {
+		return &LockoutError{Err: ErrAccountLocked, RetryAfter: attempts.LockedUntil}
+	}
+	if next := attempts.LastFailure.Add(a.lockout.delay(attempts.Failures)); now.Before(next) {
+		return &LockoutError{Err: ErrLoginThrottled, RetryAfter: next}
+	}
+	return nil
+}
+
+// recordFailure counts a failed attempt against a key, locking it once it
+// reaches max failures. The read-modify-write is not atomic, so concurrent
+// guesses may be undercounted by a few.
+func (a *Authenticator) recordFailure(ctx context.Context, key string, max int, now time.Time) error {
+	attempts, err := a.loginAttempts(ctx, key, now)
+	if err != nil {
+		return err
+	}
+	attempts.Failures++
+	attempts.LastFailure = now
+	if max > 0 && attempts.Failures >= max && a.lockout.LockDuration > 0 {
+		attempts.LockedUntil = now.Add(a.lockout.LockDuration)
+		attempts.Failures = 0
+	}
+	if a.lockout.LockDuration > 0 {
+		attempts.ExpiresAt = now.Add(a.lockout.LockDuration)
+	}
+	return a.store.PutLoginAttempts(ctx, attempts)
+}
+
+// recordLoginFailure counts a failed login against the account's attempts
+// key, if any, and the client address
+func (a *Authenticator) recordLoginFailure(ctx context.Context, account, ip string, now time.Time) error {
+	if ip != "" {
+		if err := a.recordFailure(ctx, ipAttemptsKey(ip), a.lockout.IPMaxFailures, now); err != nil {
+			return err
+		}
+	}
+	if account != "" {
+		return a.recordFailure(ctx, account, a.lockout.MaxFailures, now)
+	}
+	return nil
+}
+
+// LockoutStatus returns the failed login attempts of an account
+func (a *Authenticator) LockoutStatus(ctx context.Context, userID string) (*LoginAttempts, error) {
+	return a.loginAttempts(ctx, userAttemptsKey(userID), time.Now().UTC())
+}
+
+// Unlock clears the failed login attempts and any lock of an account
+func (a *Authenticator) Unlock(ctx context.Context, userID string) error {
+	return a.store.DeleteLoginAttempts(ctx, userAttemptsKey(userID))
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultRotationGrace is how long a rotated key keeps working by default
+const DefaultRotationGrace = 24 * time.Hour
+
+var ErrKeyAlreadyRotated = errors.New("API key already rotated")
+
+// Key states reported by APIKey.State
+const (
+	KeyActive   = "active"
+	KeyRotating = "rotating"
+	KeyExpired  = "expired"
+	KeyRevoked  = "revoked"
+)
+
+// State returns whether a key is active, rotated but still in its grace
+// period, expired or revoked at a time
+func (k *APIKey) State(now time.Time) string {
+	switch {
+	case k.Revoked:
+		return KeyRevoked
+	case now.After(k.ExpiresAt):
+		return KeyExpired
+	case k.RotatedTo != "":
+		return KeyRotating
+	default:
+		return KeyActive
+	}
+}
+
+// RotateAPIKey issues a replacement for a key with the same name, scopes and
+// lifetime. The old key keeps working for the grace period, or until it
+// would have expired anyway, and both keys record the rotation.
+func (a *Authenticator) RotateAPIKey(ctx context.Context, userID, keyID string, grace time.Duration) (*APIKey, string, *APIKey, error) {
+	old, err := a.GetAPIKey(ctx, userID, keyID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	now := time.Now().UTC()
+	switch old.State(now) {
+	case KeyRevoked:
+		return nil, "", nil, ErrAPIKeyRevoked
+	case KeyExpired:
+		return nil, "", nil, ErrAPIKeyExpired
+	case KeyRotating:
+		return nil, "", nil, ErrKeyAlreadyRotated
+	}
+
+	key, raw, err := a.GenerateScopedAPIKey(ctx, userID, old.Name, old.ExpiresAt.Sub(old.CreatedAt), old.Scopes)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	key.RotatedFrom = old.ID
+	if err := a.store.UpdateAPIKey(ctx, key); err != nil {
+		return nil, "", nil, fmt.Errorf("failed to save API key: %w", err)
+	}
+
+	old.RotatedTo = key.ID
+	old.RotatedAt = now
+	if until := now.Add(grace); until.Before(old.ExpiresAt) {
+		old.ExpiresAt = until
+	}
+	if err := a.store.UpdateAPIKey(ctx, old); err != nil {
+		return nil, "", nil, fmt.Errorf("failed to update API key: %w", err)
+	}
+	return key, raw, old, nil
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Token purposes
+const (
+	TokenPasswordReset = "password-reset"
+	TokenVerifyEmail   = "verify-email"
+)
+
+const (
+	// TokenPrefix is the prefix for all one-time tokens
+	TokenPrefix = "nbt_"
+
+	// PasswordResetTTL is how long a password reset token is valid
+	PasswordResetTTL = time.Hour
+
+	// VerifyEmailTTL is how long an email verification token is valid
+	VerifyEmailTTL = 48 * time.Hour
+)
+
+// Token is a single-use token sent to a user's email address. Only its
+// hash is stored.
+type Token struct {
+	Hash      string    `json:"-"`
+	UserID    string    `json:"userId"`
+	Purpose   string    `json:"purpose"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// hashToken returns the stored form of a raw token. Tokens carry 256 random
+// bits, so a fast hash is enough.
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// SetRequireVerifiedEmail makes LoginUser refuse users who have not
+// verified their email address
+func (a *Authenticator) SetRequireVerifiedEmail(require bool) {
+	a.requireVerifiedEmail = require
+}
+
+// RequireVerifiedEmail reports whether logins need a verified email address
+func (a *Authenticator) RequireVerifiedEmail() bool {
+	return a.requireVerifiedEmail
+}
+
+// IssueToken creates a single-use token for a user, returning the raw token
+// to send them
+func (a *Authenticator) IssueToken(ctx context.Context, userID, purpose string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	raw := TokenPrefix + hex.EncodeToString(b)
+	token := &Token{
+		Hash:      hashToken(raw),
+		UserID:    userID,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	}
+	if err := a.store.CreateToken(ctx, token); err != nil {
+		return "", fmt.Errorf("failed to store token: %w", err)
+	}
+	return raw, nil
+}
+
+// consumeToken uses up a token and returns its user. Tokens of another
+// purpose are used up too, so a token is never tried twice.
+func (a *Authenticator) consumeToken(ctx context.Context, raw, purpose string) (*User, error) {
+	token, err := a.store.TakeToken(ctx, hashToken(raw))
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if token.Purpose != purpose || !time.Now().Before(token.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+	user, err := a.store.GetUserByID(ctx, token.UserID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	return user, nil
+}
+
+// ResetPassword sets a new password with a password reset token, unlocks the
+// account and revokes every API key of the user, since the old password may
+// have leaked
+func (a *Authenticator) ResetPassword(ctx context.Context, raw, password string) (*User, error) {
+	user, err := a.consumeToken(ctx, raw, TokenPasswordReset)
+	if err != nil {
+		return nil, err
+	}
+	hashed, err := a.hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	user.PasswordHash = hashed
+	// Receiving the reset email proves the address
+	user.EmailVerified = true
+	user.UpdatedAt = time.Now().UTC()
+	if err := a.store.UpdateUser(ctx, user); err != nil {
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+
+	if err := a.Unlock(ctx, user.ID); err != nil {
+		return nil, fmt.Errorf("failed to clear login attempts: %w", err)
+	}
+
+	keys, err := a.store.ListAPIKeys(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	for _, key := range keys {
+		if key.Revoked {
+			continue
+		}
+		key.Revoked = true
+		if err := a.store.UpdateAPIKey(ctx, key); err != nil {
+			return nil, fmt.Errorf("failed to revoke API key: %w", err)
+		}
+	}
+	return user, nil
+}
+
+// VerifyEmail marks a user's email address verified with a verification token
+func (a *Authenticator) VerifyEmail(ctx context.Context, raw string) (*User, error) {
+	user, err := a.consumeToken(ctx, raw, TokenVerifyEmail)
+	if err != nil {
+		return nil, err
+	}
+	user.EmailVerified = true
+	user.UpdatedAt = time.Now().UTC()
+	if err := a.store.UpdateUser(ctx, user); err != nil {
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+	return user, nil
+}
@@ -0,0 +1,95 @@
+// Package backoff retries operations that fail transiently, waiting a
+// jittered, exponentially growing delay between attempts.
+package backoff
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// Policy bounds how an operation is retried
+type Policy struct {
+	// MaxAttempts caps the number of calls, including the first; 1 disables retries
+	MaxAttempts int
+	// MaxElapsed caps the total time spent, including waits; no retry is
+	// started that would wait past it
+	MaxElapsed time.Duration
+	// BaseDelay is the upper bound of the wait before the first retry; it
+	// doubles with each further retry
+	BaseDelay time.Duration
+	// MaxDelay caps the upper bound of any single wait
+	MaxDelay time.Duration
+}
+
+// DefaultPolicy is used for any zero field of a Policy value
+var DefaultPolicy = Policy{
+	MaxAttempts: 5,
+	MaxElapsed:  5 * time.Second,
+	BaseDelay:   25 * time.Millisecond,
+	MaxDelay:    time.Second,
+}
+
+// Retry calls fn until it succeeds, returns an error retryable rejects, or
+// the policy is exhausted, and returns fn's last error. onRetry, if set, is
+// called before each wait with the number of the attempt that failed. If ctx
+// ends while waiting, Retry returns the context's error.
+func (p Policy) Retry(ctx context.Context, retryable func(error) bool, onRetry func(attempt int, delay time.Duration, err error), fn func() error) error {
+	p = p.withDefaults()
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts || !retryable(err) {
+			return err
+		}
+
+		delay := p.Delay(attempt)
+		if time.Since(start)+delay > p.MaxElapsed {
+			return err
+		}
+		if onRetry != nil {
+			onRetry(attempt, delay, err)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Delay returns a random wait before retrying after the given failed
+// attempt, between zero and min(MaxDelay, BaseDelay*2^(attempt-1)) ("full
+// jitter"), so that clients throttled together do not retry together.
+func (p Policy) Delay(attempt int) time.Duration {
+	p = p.withDefaults()
+	ceiling := p.MaxDelay
+	if attempt < 1 {
+		attempt = 1
+	}
+	if attempt < 32 {
+		if d := p.BaseDelay << (attempt - 1); d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+	return rand.N(ceiling + 1)
+}
+
+func (p Policy) withDefaults() Policy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultPolicy.MaxAttempts
+	}
+	if p.MaxElapsed <= 0 {
+		p.MaxElapsed = DefaultPolicy.MaxElapsed
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultPolicy.MaxDelay
+	}
+	return p
+}
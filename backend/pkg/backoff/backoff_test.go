@@ -0,0 +1,92 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errTransient = errors.New("transient")
+
+func retryTransient(err error) bool { return errors.Is(err, errTransient) }
+
+func fastPolicy() Policy {
+	return Policy{MaxAttempts: 4, MaxElapsed: time.Second, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+}
+
+func TestRetrySucceedsAfterTransientFailures(t *testing.T) {
+	calls := 0
+	var retried []int
+	err := fastPolicy().Retry(context.Background(), retryTransient,
+		func(attempt int, delay time.Duration, err error) { retried = append(retried, attempt) },
+		func() error {
+			calls++
+			if calls < 3 {
+				return errTransient
+			}
+			return nil
+		})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, []int{1, 2}, retried)
+}
+
+func TestRetryStopsAtMaxAttempts(t *testing.T) {
+	calls := 0
+	err := fastPolicy().Retry(context.Background(), retryTransient, nil, func() error {
+		calls++
+		return errTransient
+	})
+
+	assert.ErrorIs(t, err, errTransient)
+	assert.Equal(t, 4, calls)
+}
+
+func TestRetryDoesNotRetryPermanentErrors(t *testing.T) {
+	permanent := errors.New("permanent")
+	calls := 0
+	err := fastPolicy().Retry(context.Background(), retryTransient, nil, func() error {
+		calls++
+		return permanent
+	})
+
+	assert.ErrorIs(t, err, permanent)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryStopsAtMaxElapsed(t *testing.T) {
+	p := Policy{MaxAttempts: 100, MaxElapsed: 30 * time.Millisecond, BaseDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	start := time.Now()
+	err := p.Retry(context.Background(), retryTransient, nil, func() error { return errTransient })
+
+	assert.ErrorIs(t, err, errTransient)
+	assert.Less(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestRetryHonoursContextCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := Policy{MaxAttempts: 10, MaxElapsed: time.Minute, BaseDelay: time.Minute, MaxDelay: time.Minute}
+
+	calls := 0
+	err := p.Retry(ctx, retryTransient, func(int, time.Duration, error) { cancel() }, func() error {
+		calls++
+		return errTransient
+	})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, calls)
+}
+
+func TestDelayIsBoundedAndGrows(t *testing.T) {
+	p := Policy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		assert.LessOrEqual(t, p.Delay(1), 10*time.Millisecond)
+		assert.LessOrEqual(t, p.Delay(3), 40*time.Millisecond)
+		assert.LessOrEqual(t, p.Delay(40), 50*time.Millisecond)
+		assert.GreaterOrEqual(t, p.Delay(40), time.Duration(0))
+	}
+}
@@ -0,0 +1,66 @@
+package blob
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewFileStore(t.TempDir())
+
+	require.NoError(t, store.Put(ctx, "archive/u/t/bundle.gz", []byte("payload")))
+
+	data, err := store.Get(ctx, "archive/u/t/bundle.gz")
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(data))
+
+	_, err = store.Get(ctx, "archive/u/t/other.gz")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, store.Delete(ctx, "archive/u/t/bundle.gz"))
+	_, err = store.Get(ctx, "archive/u/t/bundle.gz")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Deleting a missing object is not an error
+	assert.NoError(t, store.Delete(ctx, "archive/u/t/bundle.gz"))
+}
+
+func TestFileStoreKeysStayInsideRoot(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	store := NewFileStore(root)
+
+	require.NoError(t, store.Put(ctx, "../../escape", []byte("x")))
+	p, err := store.path("../../escape")
+	require.NoError(t, err)
+	assert.Contains(t, p, root)
+
+	assert.Error(t, store.Put(ctx, "", []byte("x")))
+}
+
+func TestS3StorePresign(t *testing.T) {
+	ctx := context.Background()
+	cfg := aws.Config{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+		}),
+	}
+	store := NewS3Store(cfg, "files", "uploads")
+
+	put, err := store.PresignPut(ctx, "u1/report.pdf", "application/pdf", 15*time.Minute)
+	require.NoError(t, err)
+	assert.Contains(t, put, "files.s3.us-east-1.amazonaws.com/uploads/u1/report.pdf")
+	assert.Contains(t, put, "X-Amz-Expires=900")
+
+	get, err := store.PresignGet(ctx, "u1/report.pdf", time.Minute)
+	require.NoError(t, err)
+	assert.Contains(t, get, "/uploads/u1/report.pdf")
+	assert.Contains(t, get, "X-Amz-Signature=")
+}
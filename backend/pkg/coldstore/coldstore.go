@@ -0,0 +1,289 @@
+package coldstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/blob"
+)
+
+// Segment describes one compressed bundle of offloaded facts
+type Segment struct {
+	Key   string    `json:"key"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Count int       `json:"count"`
+}
+
+// Index lists the cold segments of a namespace. Every fact older than ColdBefore
+// lives in a segment, except the last version of each row before that point,
+// which stays hot so snapshots at or after ColdBefore never touch cold storage.
+type Index struct {
+	Namespace  string    `json:"namespace"`
+	ColdBefore time.Time `json:"coldBefore"`
+	Segments   []Segment `json:"segments"`
+}
+
+// IsCold reports whether a read reaching back to t needs cold segments
+func (idx *Index) IsCold(t time.Time) bool {
+	return len(idx.Segments) > 0 && t.Before(idx.ColdBefore)
+}
+
+// IndexCacheTTL is how long a tier trusts an index it loaded. Offloads
+// through the tier update its cache at once; those of other processes
+// sharing the blob store are seen once the cached index expires.
+const IndexCacheTTL = time.Minute
+
+// Tier stores offloaded history in a blob store
+type Tier struct {
+	blobs blob.Store
+
+	// mu serializes index updates within this process
+	mu sync.Mutex
+
+	// cacheMu guards indexes, the indexes loaded so far by namespace, so
+	// reads do not fetch the index from the blob store every time
+	cacheMu sync.Mutex
+	indexes map[string]cachedIndex
+	now     func() time.Time
+}
+
+type cachedIndex struct {
+	idx    Index
+	loaded time.Time
+}
+
+// NewTier creates a cold tier on top of a blob store
+func NewTier(blobs blob.Store) *Tier {
+	return &Tier{blobs: blobs, indexes: make(map[string]cachedIndex), now: time.Now}
+}
+
+func indexKey(namespace string) string {
+	return fmt.Sprintf("cold/%s/index.json", namespace)
+}
+
+func segmentKey(namespace string, start, end time.Time) string {
+	return fmt.Sprintf("cold/%s/%s_%s.json.gz", namespace,
+		start.UTC().Format("20060102T150405.000000000Z"), end.UTC().Format("20060102T150405.000000000Z"))
+}
+
+// LoadIndex returns the index for a namespace, or an empty index if nothing
+// was offloaded. Indexes are cached for IndexCacheTTL.
+func (t *Tier) LoadIndex(ctx context.Context, namespace string) (*Index, error) {
+	now := t.now()
+	t.cacheMu.Lock()
+	cached, ok := t.indexes[namespace]
+	t.cacheMu.Unlock()
+	if ok && now.Sub(cached.loaded) < IndexCacheTTL {
+		return cached.copy(), nil
+	}
+
+	idx, err := t.fetchIndex(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	t.cacheIndex(idx, now)
+	return idx, nil
+}
+
+// copy returns a copy of the cached index that callers may change
+func (c cachedIndex) copy() *Index {
+	idx := c.idx
+	idx.Segments = append([]Segment(nil), c.idx.Segments...)
+	return &idx
+}
+
+func (t *Tier) cacheIndex(idx *Index, now time.Time) {
+	t.cacheMu.Lock()
+	defer t.cacheMu.Unlock()
+	c := cachedIndex{idx: *idx, loaded: now}
+	c.idx.Segments = append([]Segment(nil), idx.Segments...)
+	t.indexes[idx.Namespace] = c
+}
+
+// fetchIndex reads the index for a namespace from the blob store
+func (t *Tier) fetchIndex(ctx context.Context, namespace string) (*Index, error) {
+	data, err := t.blobs.Get(ctx, indexKey(namespace))
+	if errors.Is(err, blob.ErrNotFound) {
+		return &Index{Namespace: namespace}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load cold index: %w", err)
+	}
+
+	var idx Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("decode cold index: %w", err)
+	}
+	return &idx, nil
+}
+
+func (t *Tier) saveIndex(ctx context.Context, idx *Index) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("encode cold index: %w", err)
+	}
+	return t.blobs.Put(ctx, indexKey(idx.Namespace), data)
+}
+
+// SelectColdFacts returns the facts that may be offloaded for a cutoff: every
+// fact older than cutoff except the newest such fact for each row.
+func SelectColdFacts(facts []dynamo.Fact, cutoff time.Time) []dynamo.Fact {
+	latest := make(map[string]dynamo.Fact)
+	for _, f := range facts {
+		if !f.Timestamp.Before(cutoff) {
+			continue
+		}
+		if cur, ok := latest[f.FieldName]; !ok || f.Timestamp.After(cur.Timestamp) {
+			latest[f.FieldName] = f
+		}
+	}
+
+	var cold []dynamo.Fact
+	for _, f := range facts {
+		if !f.Timestamp.Before(cutoff) {
+			continue
+		}
+		if keep := latest[f.FieldName]; keep.ID == f.ID && keep.Timestamp.Equal(f.Timestamp) {
+			continue
+		}
+		cold = append(cold, f)
+	}
+	return cold
+}
+
+// Offload writes the given facts to a new segment and advances the index to cutoff.
+// The caller purges the facts from the hot store once Offload returns.
+func (t *Tier) Offload(ctx context.Context, namespace string, cutoff time.Time, facts []dynamo.Fact) (*Segment, error) {
+	if len(facts) == 0 {
+		return nil, nil
+	}
+
+	sorted := append([]dynamo.Fact(nil), facts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Timestamp.Before(sorted[j].Timestamp) })
+
+	data, err := encodeFacts(sorted)
+	if err != nil {
+		return nil, err
+	}
+
+	seg := Segment{
+		Key:   segmentKey(namespace, sorted[0].Timestamp, sorted[len(sorted)-1].Timestamp),
+		Start: sorted[0].Timestamp,
+		End:   sorted[len(sorted)-1].Timestamp,
+		Count: len(sorted),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// The index is read afresh so segments other processes added are kept
+	idx, err := t.fetchIndex(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	// Upload the segment before publishing it in the index
+	if err := t.blobs.Put(ctx, seg.Key, data); err != nil {
+		return nil, fmt.Errorf("store cold segment: %w", err)
+	}
+
+	idx.Segments = append(idx.Segments, seg)
+	if cutoff.After(idx.ColdBefore) {
+		idx.ColdBefore = cutoff
+	}
+	if err := t.saveIndex(ctx, idx); err != nil {
+		return nil, err
+	}
+	t.cacheIndex(idx, t.now())
+
+	return &seg, nil
+}
+
+// FactsInRange loads every cold fact of a namespace within [start, end]
+func (t *Tier) FactsInRange(ctx context.Context, namespace string, start, end time.Time) ([]dynamo.Fact, error) {
+	idx, err := t.LoadIndex(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var facts []dynamo.Fact
+	for _, seg := range idx.Segments {
+		if seg.End.Before(start) || seg.Start.After(end) {
+			continue
+		}
+		data, err := t.blobs.Get(ctx, seg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("load cold segment %s: %w", seg.Key, err)
+		}
+		segFacts, err := decodeFacts(data)
+		if err != nil {
+			return nil, fmt.Errorf("decode cold segment %s: %w", seg.Key, err)
+		}
+		for _, f := range segFacts {
+			if !f.Timestamp.Before(start) && !f.Timestamp.After(end) {
+				facts = append(facts, f)
+			}
+		}
+	}
+	return facts, nil
+}
+
+// Merge combines hot and cold facts, dropping duplicates left behind by an
+// interrupted purge, and returns them sorted by timestamp.
+func Merge(hot, cold []dynamo.Fact) []dynamo.Fact {
+	seen := make(map[string]bool, len(hot))
+	merged := make([]dynamo.Fact, 0, len(hot)+len(cold))
+	for _, list := range [][]dynamo.Fact{hot, cold} {
+		for _, f := range list {
+			k := f.Timestamp.Format(time.RFC3339Nano) + "#" + f.ID
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			merged = append(merged, f)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Timestamp.Before(merged[j].Timestamp) })
+	return merged
+}
+
+func encodeFacts(facts []dynamo.Fact) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(facts); err != nil {
+		return nil, fmt.Errorf("encode cold segment: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("compress cold segment: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeFacts(data []byte) ([]dynamo.Fact, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	var facts []dynamo.Fact
+	if err := json.Unmarshal(raw, &facts); err != nil {
+		return nil, err
+	}
+	return facts, nil
+}
@@ -0,0 +1,131 @@
+package coldstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/blob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fact(id, row string, ts time.Time, value interface{}) dynamo.Fact {
+	return dynamo.Fact{ID: id, Timestamp: ts, Namespace: "u/t", FieldName: row, DataType: "json", Value: value}
+}
+
+func TestSelectColdFactsKeepsLastVersionBeforeCutoff(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cutoff := base.Add(10 * time.Hour)
+	facts := []dynamo.Fact{
+		fact("a1", "a", base, 1),
+		fact("a2", "a", base.Add(time.Hour), 2),
+		fact("a3", "a", base.Add(2*time.Hour), 3),
+		fact("b1", "b", base.Add(time.Hour), 1),
+		fact("a4", "a", base.Add(11*time.Hour), 4),
+	}
+
+	cold := SelectColdFacts(facts, cutoff)
+	ids := make([]string, 0, len(cold))
+	for _, f := range cold {
+		ids = append(ids, f.ID)
+	}
+	assert.ElementsMatch(t, []string{"a1", "a2"}, ids)
+}
+
+func TestOffloadAndReadBack(t *testing.T) {
+	ctx := context.Background()
+	tier := NewTier(blob.NewFileStore(t.TempDir()))
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	idx, err := tier.LoadIndex(ctx, "u/t")
+	require.NoError(t, err)
+	assert.False(t, idx.IsCold(base))
+
+	cold := []dynamo.Fact{
+		fact("a1", "a", base, map[string]interface{}{"n": float64(1)}),
+		fact("a2", "a", base.Add(time.Hour), map[string]interface{}{"n": float64(2)}),
+	}
+	seg, err := tier.Offload(ctx, "u/t", base.Add(5*time.Hour), cold)
+	require.NoError(t, err)
+	require.NotNil(t, seg)
+	assert.Equal(t, 2, seg.Count)
+
+	idx, err = tier.LoadIndex(ctx, "u/t")
+	require.NoError(t, err)
+	assert.True(t, idx.IsCold(base.Add(time.Hour)))
+	assert.False(t, idx.IsCold(base.Add(6*time.Hour)))
+
+	got, err := tier.FactsInRange(ctx, "u/t", base.Add(30*time.Minute), base.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, "a2", got[0].ID)
+	assert.Equal(t, map[string]interface{}{"n": float64(2)}, got[0].Value)
+
+	// Nothing to offload is a no-op
+	seg, err = tier.Offload(ctx, "u/t", base, nil)
+	require.NoError(t, err)
+	assert.Nil(t, seg)
+}
+
+// countingStore counts the reads of each blob
+type countingStore struct {
+	blob.Store
+	gets map[string]int
+}
+
+func (s *countingStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.gets[key]++
+	return s.Store.Get(ctx, key)
+}
+
+func TestIndexCache(t *testing.T) {
+	ctx := context.Background()
+	blobs := &countingStore{Store: blob.NewFileStore(t.TempDir()), gets: map[string]int{}}
+	tier := NewTier(blobs)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tier.now = func() time.Time { return now }
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		idx, err := tier.LoadIndex(ctx, "u/t")
+		require.NoError(t, err)
+		assert.False(t, idx.IsCold(base))
+	}
+	assert.Equal(t, 1, blobs.gets[indexKey("u/t")], "reads share the cached index")
+
+	// An offload through the tier is seen at once
+	_, err := tier.Offload(ctx, "u/t", base.Add(5*time.Hour), []dynamo.Fact{fact("a1", "a", base, 1)})
+	require.NoError(t, err)
+	idx, err := tier.LoadIndex(ctx, "u/t")
+	require.NoError(t, err)
+	assert.True(t, idx.IsCold(base))
+	idx.Segments = nil
+	idx, err = tier.LoadIndex(ctx, "u/t")
+	require.NoError(t, err)
+	assert.Len(t, idx.Segments, 1, "callers get a copy of the cached index")
+
+	// Another process's offload is seen once the cached index expires
+	other := NewTier(blobs)
+	_, err = other.Offload(ctx, "u/t", base.Add(5*time.Hour), []dynamo.Fact{fact("a2", "a", base.Add(time.Hour), 2)})
+	require.NoError(t, err)
+	idx, err = tier.LoadIndex(ctx, "u/t")
+	require.NoError(t, err)
+	assert.Len(t, idx.Segments, 1)
+	now = now.Add(IndexCacheTTL)
+	idx, err = tier.LoadIndex(ctx, "u/t")
+	require.NoError(t, err)
+	assert.Len(t, idx.Segments, 2)
+}
+
+func TestMergeDropsDuplicates(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	hot := []dynamo.Fact{fact("a2", "a", base.Add(time.Hour), 2), fact("a3", "a", base.Add(2*time.Hour), 3)}
+	cold := []dynamo.Fact{fact("a1", "a", base, 1), fact("a2", "a", base.Add(time.Hour), 2)}
+
+	merged := Merge(hot, cold)
+	require.Len(t, merged, 3)
+	assert.Equal(t, "a1", merged[0].ID)
+	assert.Equal(t, "a3", merged[2].ID)
+}
@@ -0,0 +1,199 @@
+// Package crypto implements application-level envelope encryption. Every value
+// is encrypted with a fresh data key, and the data key is stored alongside the
+// ciphertext wrapped by a master key.
+package crypto
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DataKeySize is the size in bytes of data keys and local master keys (AES-256)
+const DataKeySize = 32
+
+// ErrDecrypt is returned when a ciphertext cannot be authenticated
+var ErrDecrypt = errors.New("crypto: decryption failed")
+
+// KeyWrapper wraps and unwraps data keys with a master key
+type KeyWrapper interface {
+	// KeyID identifies the master key used for wrapping
+	KeyID() string
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Envelope is an encrypted value together with its wrapped data key
+type Envelope struct {
+	KeyID      string `json:"kid"`
+	WrappedKey []byte `json:"wk"`
+	Nonce      []byte `json:"n"`
+	Ciphertext []byte `json:"ct"`
+}
+
+// Encode renders the envelope as a string suitable for storing in a fact
+func (e *Envelope) Encode() (string, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("encode envelope: %w", err)
+	}
+	return string(data), nil
+}
+
+// DecodeEnvelope parses an envelope produced by Encode
+func DecodeEnvelope(s string) (*Envelope, error) {
+	var e Envelope
+	if err := json.Unmarshal([]byte(s), &e); err != nil {
+		return nil, fmt.Errorf("decode envelope: %w", err)
+	}
+	return &e, nil
+}
+
+// Sealer encrypts and decrypts values using envelope encryption
+type Sealer struct {
+	wrapper KeyWrapper
+}
+
+// NewSealer creates a sealer that wraps data keys with w
+func NewSealer(w KeyWrapper) *Sealer {
+	return &Sealer{wrapper: w}
+}
+
+// Seal encrypts plaintext under a new data key. The associated data is
+// authenticated but not stored; the same value must be passed to Open, which
+// binds a ciphertext to where it is stored.
+func (s *Sealer) Seal(ctx context.Context, plaintext, associatedData []byte) (*Envelope, error) {
+	dataKey := make([]byte, DataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, fmt.Errorf("generate data key: %w", err)
+	}
+
+	nonce, ciphertext, err := seal(dataKey, plaintext, associatedData)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := s.wrapper.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("wrap data key: %w", err)
+	}
+
+	return &Envelope{
+		KeyID:      s.wrapper.KeyID(),
+		WrappedKey: wrapped,
+		Nonce:      nonce,
+		Ciphertext: ciphertext,
+	}, nil
+}
+
+// Open decrypts an envelope produced by Seal
+func (s *Sealer) Open(ctx context.Context, e *Envelope, associatedData []byte) ([]byte, error) {
+	dataKey, err := s.wrapper.UnwrapKey(ctx, e.KeyID, e.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+	return open(dataKey, e.Nonce, e.Ciphertext, associatedData)
+}
+
+// LocalKeyWrapper wraps data keys with an AES-256 master key held in memory
+type LocalKeyWrapper struct {
+	id  string
+	key []byte
+}
+
+// NewLocalKeyWrapper creates a wrapper for a 32-byte master key
+func NewLocalKeyWrapper(masterKey []byte) (*LocalKeyWrapper, error) {
+	if len(masterKey) != DataKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", DataKeySize, len(masterKey))
+	}
+	sum := sha256.Sum256(masterKey)
+	return &LocalKeyWrapper{
+		id:  "local:" + hex.EncodeToString(sum[:4]),
+		key: append([]byte(nil), masterKey...),
+	}, nil
+}
+
+// ParseMasterKey decodes a base64-encoded 32-byte master key
+func ParseMasterKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+	if len(key) != DataKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", DataKeySize, len(key))
+	}
+	return key, nil
+}
+
+// KeyID implements KeyWrapper
+func (w *LocalKeyWrapper) KeyID() string {
+	return w.id
+}
+
+// WrapKey implements KeyWrapper
+func (w *LocalKeyWrapper) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	nonce, ciphertext, err := seal(w.key, dataKey, []byte(w.id))
+	if err != nil {
+		return nil, err
+	}
+	return append(nonce, ciphertext...), nil
+}
+
+// UnwrapKey implements KeyWrapper
+func (w *LocalKeyWrapper) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != w.id {
+		return nil, fmt.Errorf("data key was wrapped by unknown master key %q", keyID)
+	}
+	aead, err := newGCM(w.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	n := aead.NonceSize()
+	return open(w.key, wrapped[:n], wrapped[n:], []byte(w.id))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(key, plaintext, associatedData []byte) (nonce, ciphertext []byte, err error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce = make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return nonce, aead.Seal(nil, nonce, plaintext, associatedData), nil
+}
+
+func open(key, nonce, ciphertext, associatedData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, associatedData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,68 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSealer(t *testing.T) *Sealer {
+	w, err := NewLocalKeyWrapper(bytes.Repeat([]byte{7}, DataKeySize))
+	require.NoError(t, err)
+	return NewSealer(w)
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s := testSealer(t)
+
+	env, err := s.Seal(ctx, []byte("hunter2"), []byte("u1/keys#github"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(env.Ciphertext), "hunter2")
+
+	encoded, err := env.Encode()
+	require.NoError(t, err)
+	decoded, err := DecodeEnvelope(encoded)
+	require.NoError(t, err)
+
+	plain, err := s.Open(ctx, decoded, []byte("u1/keys#github"))
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", string(plain))
+}
+
+func TestOpenRejectsWrongAssociatedData(t *testing.T) {
+	ctx := context.Background()
+	s := testSealer(t)
+
+	env, err := s.Seal(ctx, []byte("hunter2"), []byte("u1/keys#github"))
+	require.NoError(t, err)
+
+	_, err = s.Open(ctx, env, []byte("u1/keys#gitlab"))
+	assert.ErrorIs(t, err, ErrDecrypt)
+}
+
+func TestOpenRejectsOtherMasterKey(t *testing.T) {
+	ctx := context.Background()
+	env, err := testSealer(t).Seal(ctx, []byte("hunter2"), nil)
+	require.NoError(t, err)
+
+	other, err := NewLocalKeyWrapper(bytes.Repeat([]byte{8}, DataKeySize))
+	require.NoError(t, err)
+	_, err = NewSealer(other).Open(ctx, env, nil)
+	assert.Error(t, err)
+}
+
+func TestParseMasterKey(t *testing.T) {
+	key, err := ParseMasterKey(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, DataKeySize)))
+	require.NoError(t, err)
+	assert.Len(t, key, DataKeySize)
+
+	_, err = ParseMasterKey("c2hvcnQ=")
+	assert.Error(t, err)
+	_, err = ParseMasterKey("not base64!")
+	assert.Error(t, err)
+}
@@ -0,0 +1,59 @@
+package crypto
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// KMSAPI is the subset of the AWS KMS client used by KMSKeyWrapper
+type KMSAPI interface {
+	Encrypt(ctx context.Context, params *kms.EncryptInput, optFns ...func(*kms.Options)) (*kms.EncryptOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// KMSKeyWrapper wraps data keys with an AWS KMS key, so the master key never
+// leaves KMS
+type KMSKeyWrapper struct {
+	client KMSAPI
+	keyID  string
+}
+
+// NewKMSKeyWrapper creates a wrapper for the KMS key with the given ID, ARN or alias
+func NewKMSKeyWrapper(client KMSAPI, keyID string) *KMSKeyWrapper {
+	return &KMSKeyWrapper{client: client, keyID: keyID}
+}
+
+// KeyID implements KeyWrapper
+func (w *KMSKeyWrapper) KeyID() string {
+	return "kms:" + w.keyID
+}
+
+// WrapKey implements KeyWrapper
+func (w *KMSKeyWrapper) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	out, err := w.client.Encrypt(ctx, &kms.EncryptInput{
+		KeyId:     aws.String(w.keyID),
+		Plaintext: dataKey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("kms encrypt: %w", err)
+	}
+	return out.CiphertextBlob, nil
+}
+
+// UnwrapKey implements KeyWrapper
+func (w *KMSKeyWrapper) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != w.KeyID() {
+		return nil, fmt.Errorf("data key was wrapped by unknown master key %q", keyID)
+	}
+	out, err := w.client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:          aws.String(w.keyID),
+		CiphertextBlob: wrapped,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("kms decrypt: %w", err)
+	}
+	return out.Plaintext, nil
+}
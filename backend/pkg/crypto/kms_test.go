@@ -0,0 +1,57 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKMS "encrypts" by reversing the plaintext and checks the key ID
+type fakeKMS struct {
+	keyID string
+}
+
+func reversed(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, c := range b {
+		out[len(b)-1-i] = c
+	}
+	return out
+}
+
+func (f *fakeKMS) Encrypt(ctx context.Context, params *kms.EncryptInput, optFns ...func(*kms.Options)) (*kms.EncryptOutput, error) {
+	if *params.KeyId != f.keyID {
+		return nil, assert.AnError
+	}
+	return &kms.EncryptOutput{CiphertextBlob: reversed(params.Plaintext)}, nil
+}
+
+func (f *fakeKMS) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	if *params.KeyId != f.keyID {
+		return nil, assert.AnError
+	}
+	return &kms.DecryptOutput{Plaintext: reversed(params.CiphertextBlob)}, nil
+}
+
+func TestKMSKeyWrapper(t *testing.T) {
+	ctx := context.Background()
+	w := NewKMSKeyWrapper(&fakeKMS{keyID: "alias/notably"}, "alias/notably")
+	assert.Equal(t, "kms:alias/notably", w.KeyID())
+
+	s := NewSealer(w)
+	env, err := s.Seal(ctx, []byte("hunter2"), []byte("aad"))
+	require.NoError(t, err)
+	assert.Equal(t, "kms:alias/notably", env.KeyID)
+
+	plaintext, err := s.Open(ctx, env, []byte("aad"))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal([]byte("hunter2"), plaintext))
+
+	env.KeyID = "local:deadbeef"
+	_, err = s.Open(ctx, env, []byte("aad"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,214 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/elibdev/notably/db"
+)
+
+// encryptedKey marks a column value replaced by its sealed envelope:
+// {"$encrypted": {"kid": ..., "wk": ..., "n": ..., "ct": ...}}. The envelope
+// carries the ID of the master key and the wrapped data key, so each value
+// can be decrypted on its own.
+const encryptedKey = "$encrypted"
+
+// ColumnPolicy returns the names of the encrypted columns of the rows in a
+// namespace, or none if the namespace has no encrypted columns
+type ColumnPolicy func(ctx context.Context, namespace string) ([]string, error)
+
+// Store is a db.Store that encrypts selected columns of JSON facts before
+// they reach the wrapped store, and decrypts them in everything it returns
+type Store struct {
+	db.Store
+	sealer *Sealer
+	policy ColumnPolicy
+}
+
+var _ db.Store = (*Store)(nil)
+
+// NewStore wraps inner, encrypting the columns chosen by policy with sealer
+func NewStore(inner db.Store, sealer *Sealer, policy ColumnPolicy) *Store {
+	return &Store{Store: inner, sealer: sealer, policy: policy}
+}
+
+// columnAAD binds an encrypted value to its namespace, row and column
+func columnAAD(namespace, row, column string) []byte {
+	return []byte(namespace + "#" + row + "#" + column)
+}
+
+// PutFact encrypts the fact's encrypted columns and stores it
+func (s *Store) PutFact(ctx context.Context, fact *db.Fact) error {
+	sealed, err := s.seal(ctx, fact)
+	if err != nil {
+		return err
+	}
+	return s.Store.PutFact(ctx, sealed)
+}
+
+// PutFactsTransactional encrypts the encrypted columns of every fact and
+// stores them all or none
+func (s *Store) PutFactsTransactional(ctx context.Context, facts []*db.Fact) error {
+	sealed := make([]*db.Fact, len(facts))
+	for i, fact := range facts {
+		var err error
+		if sealed[i], err = s.seal(ctx, fact); err != nil {
+			return err
+		}
+	}
+	return s.Store.PutFactsTransactional(ctx, sealed)
+}
+
+// seal returns the fact with its encrypted columns sealed, or the fact
+// itself when it has none
+func (s *Store) seal(ctx context.Context, fact *db.Fact) (*db.Fact, error) {
+	if fact == nil || fact.DataType != db.DataTypeJSON || len(fact.Value) == 0 {
+		return fact, nil
+	}
+	columns, err := s.policy(ctx, fact.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("looking up encrypted columns: %w", err)
+	}
+	if len(columns) == 0 {
+		return fact, nil
+	}
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(fact.Value, &values); err != nil || values == nil {
+		// Not a row of values, e.g. a deletion marker
+		return fact, nil
+	}
+	for _, col := range columns {
+		raw, ok := values[col]
+		if !ok || string(raw) == "null" {
+			continue
+		}
+		env, err := s.sealer.Seal(ctx, raw, columnAAD(fact.Namespace, fact.FieldName, col))
+		if err != nil {
+			return nil, fmt.Errorf("encrypting column %s: %w", col, err)
+		}
+		sealed, err := json.Marshal(map[string]*Envelope{encryptedKey: env})
+		if err != nil {
+			return nil, err
+		}
+		values[col] = sealed
+	}
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+
+	sealedFact := *fact
+	sealedFact.Value = encoded
+	return &sealedFact, nil
+}
+
+// GetFact returns a fact with its columns decrypted
+func (s *Store) GetFact(ctx context.Context, id string) (*db.Fact, error) {
+	fact, err := s.Store.GetFact(ctx, id)
+	if err != nil || fact == nil {
+		return fact, err
+	}
+	if err := s.decrypt(ctx, fact); err != nil {
+		return nil, err
+	}
+	return fact, nil
+}
+
+// QueryByField returns facts with their columns decrypted
+func (s *Store) QueryByField(ctx context.Context, namespace, fieldName string, opts db.QueryOptions) (*db.QueryResult, error) {
+	result, err := s.Store.QueryByField(ctx, namespace, fieldName, opts)
+	if err != nil {
+		return nil, err
+	}
+	return result, s.decryptFacts(ctx, result.Facts)
+}
+
+// QueryByTimeRange returns facts with their columns decrypted
+func (s *Store) QueryByTimeRange(ctx context.Context, opts db.QueryOptions) (*db.QueryResult, error) {
+	result, err := s.Store.QueryByTimeRange(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return result, s.decryptFacts(ctx, result.Facts)
+}
+
+// QueryByNamespace returns facts with their columns decrypted
+func (s *Store) QueryByNamespace(ctx context.Context, namespace string, opts db.QueryOptions) (*db.QueryResult, error) {
+	result, err := s.Store.QueryByNamespace(ctx, namespace, opts)
+	if err != nil {
+		return nil, err
+	}
+	return result, s.decryptFacts(ctx, result.Facts)
+}
+
+// QueryByActor returns facts with their columns decrypted
+func (s *Store) QueryByActor(ctx context.Context, actorUserID string, opts db.QueryOptions) (*db.QueryResult, error) {
+	result, err := s.Store.QueryByActor(ctx, actorUserID, opts)
+	if err != nil {
+		return nil, err
+	}
+	return result, s.decryptFacts(ctx, result.Facts)
+}
+
+// GetSnapshotAtTime returns a snapshot with its facts' columns decrypted
+func (s *Store) GetSnapshotAtTime(ctx context.Context, namespace string, at time.Time) (map[string]db.Fact, error) {
+	snap, err := s.Store.GetSnapshotAtTime(ctx, namespace, at)
+	if err != nil {
+		return nil, err
+	}
+	for key, fact := range snap {
+		if err := s.decrypt(ctx, &fact); err != nil {
+			return nil, err
+		}
+		snap[key] = fact
+	}
+	return snap, nil
+}
+
+// decryptFacts decrypts facts in place
+func (s *Store) decryptFacts(ctx context.Context, facts []db.Fact) error {
+	for i := range facts {
+		if err := s.decrypt(ctx, &facts[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decrypt replaces the sealed columns of a JSON fact with their plaintext
+func (s *Store) decrypt(ctx context.Context, fact *db.Fact) error {
+	if fact.DataType != db.DataTypeJSON || !bytes.Contains(fact.Value, []byte(encryptedKey)) {
+		return nil
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(fact.Value, &values); err != nil {
+		return nil
+	}
+
+	changed := false
+	for col, raw := range values {
+		var wrapped map[string]*Envelope
+		if json.Unmarshal(raw, &wrapped) != nil || len(wrapped) != 1 || wrapped[encryptedKey] == nil {
+			continue
+		}
+		plaintext, err := s.sealer.Open(ctx, wrapped[encryptedKey], columnAAD(fact.Namespace, fact.FieldName, col))
+		if err != nil {
+			return &db.StoreError{Operation: "Decrypt", Err: fmt.Errorf("column %s of %s/%s: %w", col, fact.Namespace, fact.FieldName, err)}
+		}
+		values[col] = plaintext
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	fact.Value = encoded
+	return nil
+}
@@ -0,0 +1,118 @@
+package crypto
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStore(t *testing.T) (*Store, *db.MockStore) {
+	inner := db.NewMockStore()
+	require.NoError(t, inner.CreateTable(context.Background()))
+	policy := func(ctx context.Context, namespace string) ([]string, error) {
+		if namespace == "u1/people" {
+			return []string{"ssn", "notes"}, nil
+		}
+		return nil, nil
+	}
+	return NewStore(inner, testSealer(t), policy), inner
+}
+
+func TestStoreEncryptsPolicyColumns(t *testing.T) {
+	ctx := context.Background()
+	store, inner := newTestStore(t)
+
+	now := time.Now().UTC()
+	fact := &db.Fact{ID: "f1", Timestamp: now, Namespace: "u1/people", FieldName: "ada", DataType: db.DataTypeJSON,
+		Value: json.RawMessage(`{"name":"Ada","ssn":"123-45-6789","notes":{"likes":["math"]}}`)}
+	require.NoError(t, store.PutFact(ctx, fact))
+	assert.Contains(t, string(fact.Value), "123-45-6789", "the caller's fact is not modified")
+
+	raw, err := inner.GetFact(ctx, "f1")
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw.Value), "123-45-6789")
+	assert.NotContains(t, string(raw.Value), "math")
+	assert.Contains(t, string(raw.Value), `"name":"Ada"`)
+	assert.Contains(t, string(raw.Value), encryptedKey)
+
+	want := map[string]interface{}{"name": "Ada", "ssn": "123-45-6789", "notes": map[string]interface{}{"likes": []interface{}{"math"}}}
+	values := func(f db.Fact) map[string]interface{} {
+		var v map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(f.Value), &v))
+		return v
+	}
+
+	got, err := store.GetFact(ctx, "f1")
+	require.NoError(t, err)
+	assert.Equal(t, want, values(*got))
+
+	result, err := store.QueryByField(ctx, "u1/people", "ada", db.QueryOptions{})
+	require.NoError(t, err)
+	require.Len(t, result.Facts, 1)
+	assert.Equal(t, want, values(result.Facts[0]))
+
+	snap, err := store.GetSnapshotAtTime(ctx, "u1/people", now)
+	require.NoError(t, err)
+	for _, f := range snap {
+		assert.Equal(t, want, values(f))
+	}
+}
+
+func TestStoreLeavesOtherFactsAlone(t *testing.T) {
+	ctx := context.Background()
+	store, inner := newTestStore(t)
+
+	require.NoError(t, store.PutFact(ctx, &db.Fact{ID: "f1", Timestamp: time.Now(), Namespace: "u1/notes", FieldName: "n1", DataType: db.DataTypeJSON, Value: json.RawMessage(`{"ssn":"plain"}`)}))
+	require.NoError(t, store.PutFact(ctx, &db.Fact{ID: "f2", Timestamp: time.Now(), Namespace: "u1/people", FieldName: "gone", DataType: db.DataTypeJSON, Value: json.RawMessage(`null`)}))
+
+	raw, err := inner.GetFact(ctx, "f1")
+	require.NoError(t, err)
+	assert.Equal(t, `{"ssn":"plain"}`, string(raw.Value))
+	raw, err = inner.GetFact(ctx, "f2")
+	require.NoError(t, err)
+	assert.True(t, raw.IsDeleted, "a row without a value is a tombstone")
+}
+
+func TestStoreRejectsMovedCiphertext(t *testing.T) {
+	ctx := context.Background()
+	store, inner := newTestStore(t)
+
+	require.NoError(t, store.PutFact(ctx, &db.Fact{ID: "f1", Timestamp: time.Now(), Namespace: "u1/people", FieldName: "ada", DataType: db.DataTypeJSON, Value: json.RawMessage(`{"ssn":"123"}`)}))
+	raw, err := inner.GetFact(ctx, "f1")
+	require.NoError(t, err)
+
+	// Copying a sealed value to another row must not decrypt
+	moved := *raw
+	moved.ID, moved.FieldName = "f2", "grace"
+	require.NoError(t, inner.PutFact(ctx, &moved))
+
+	_, err = store.GetFact(ctx, "f2")
+	assert.ErrorIs(t, err, ErrDecrypt)
+}
+
+func TestStoreEncryptsTransactionalWrites(t *testing.T) {
+	ctx := context.Background()
+	store, inner := newTestStore(t)
+
+	now := time.Now().UTC()
+	require.NoError(t, store.PutFactsTransactional(ctx, []*db.Fact{
+		{ID: "f1", Timestamp: now, Namespace: "u1/people", FieldName: "ada", DataType: db.DataTypeJSON, Value: json.RawMessage(`{"ssn":"123-45-6789"}`)},
+		{ID: "f2", Timestamp: now, Namespace: "u1/notes", FieldName: "n1", DataType: db.DataTypeJSON, Value: json.RawMessage(`{"ssn":"plain"}`)},
+	}))
+
+	raw, err := inner.GetFact(ctx, "f1")
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw.Value), "123-45-6789")
+	raw, err = inner.GetFact(ctx, "f2")
+	require.NoError(t, err)
+	assert.Equal(t, `{"ssn":"plain"}`, string(raw.Value))
+
+	got, err := store.GetFact(ctx, "f1")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"ssn":"123-45-6789"}`, string(got.Value))
+}
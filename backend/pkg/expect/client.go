@@ -0,0 +1,92 @@
+package expect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client is a Source reading a notably deployment through its HTTP API
+type Client struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+}
+
+// NewClient returns a Client for the server at baseURL, authenticating with
+// apiKey. A nil httpClient uses http.DefaultClient.
+func NewClient(baseURL, apiKey string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{baseURL: strings.TrimRight(baseURL, "/"), apiKey: apiKey, http: httpClient}
+}
+
+// Tables lists the account's tables
+func (c *Client) Tables(ctx context.Context) ([]Table, error) {
+	var resp struct {
+		Tables []struct {
+			Name    string       `json:"name"`
+			Columns []ColumnSpec `json:"columns"`
+		} `json:"tables"`
+	}
+	if err := c.get(ctx, "/v1/tables", &resp); err != nil {
+		return nil, err
+	}
+	tables := make([]Table, 0, len(resp.Tables))
+	for _, t := range resp.Tables {
+		tables = append(tables, Table{Name: t.Name, Columns: t.Columns})
+	}
+	return tables, nil
+}
+
+// Rows returns the current rows of a table
+func (c *Client) Rows(ctx context.Context, table string) (map[string]map[string]interface{}, error) {
+	var resp struct {
+		Rows []struct {
+			ID     string                 `json:"id"`
+			Values map[string]interface{} `json:"values"`
+		} `json:"rows"`
+	}
+	if err := c.get(ctx, "/v1/tables/"+url.PathEscape(table)+"/snapshot", &resp); err != nil {
+		return nil, err
+	}
+	rows := make(map[string]map[string]interface{}, len(resp.Rows))
+	for _, r := range resp.Rows {
+		rows[r.ID] = r.Values
+	}
+	return rows, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("GET %s: %s: %s", path, resp.Status, apiErr.Error)
+		}
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("GET %s: decoding response: %w", path, err)
+	}
+	return nil
+}
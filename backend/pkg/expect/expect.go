@@ -0,0 +1,250 @@
+// Package expect checks the live state of a notably account against a
+// declarative spec, for verifying data pipeline outputs in CI.
+//
+// A spec lists tables and what must hold for each:
+//
+//	tables:
+//	  - name: orders
+//	    columns:
+//	      - name: status
+//	        dataType: string
+//	    minRows: 1
+//	    rows:
+//	      - id: o-1
+//	        values:
+//	          status: shipped
+//	      - id: o-2
+//	        absent: true
+package expect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is the set of expectations checked by Check
+type Spec struct {
+	Tables []TableSpec `yaml:"tables"`
+}
+
+// TableSpec describes the expected state of one table. Unset fields are not
+// checked.
+type TableSpec struct {
+	Name string `yaml:"name"`
+	// Absent expects the table not to exist
+	Absent bool `yaml:"absent"`
+
+	// Columns must each be defined with the given data type, if one is
+	// given. With ExactColumns no other columns may be defined.
+	Columns      []ColumnSpec `yaml:"columns"`
+	ExactColumns bool         `yaml:"exactColumns"`
+
+	RowCount *int `yaml:"rowCount"`
+	MinRows  *int `yaml:"minRows"`
+	MaxRows  *int `yaml:"maxRows"`
+
+	Rows []RowSpec `yaml:"rows"`
+}
+
+// ColumnSpec is an expected column definition
+type ColumnSpec struct {
+	Name     string `yaml:"name"`
+	DataType string `yaml:"dataType"`
+}
+
+// RowSpec is an expected row. Only the listed values are compared; the row
+// may hold others.
+type RowSpec struct {
+	ID     string                 `yaml:"id"`
+	Absent bool                   `yaml:"absent"`
+	Values map[string]interface{} `yaml:"values"`
+}
+
+// Table is a table as reported by a Source
+type Table struct {
+	Name    string
+	Columns []ColumnSpec
+}
+
+// Source reads the live state being checked
+type Source interface {
+	// Tables lists the account's tables
+	Tables(ctx context.Context) ([]Table, error)
+	// Rows returns the current values of a table's rows, keyed by row ID
+	Rows(ctx context.Context, table string) (map[string]map[string]interface{}, error)
+}
+
+// Failure is one expectation that did not hold
+type Failure struct {
+	Table   string
+	Row     string
+	Message string
+}
+
+func (f Failure) String() string {
+	if f.Row != "" {
+		return fmt.Sprintf("%s/%s: %s", f.Table, f.Row, f.Message)
+	}
+	return fmt.Sprintf("%s: %s", f.Table, f.Message)
+}
+
+// Parse decodes a YAML spec, rejecting unknown fields
+func Parse(data []byte) (*Spec, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var spec Spec
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("parsing spec: %w", err)
+	}
+	for i, t := range spec.Tables {
+		if t.Name == "" {
+			return nil, fmt.Errorf("parsing spec: table %d has no name", i+1)
+		}
+		for j, r := range t.Rows {
+			if r.ID == "" {
+				return nil, fmt.Errorf("parsing spec: row %d of table %s has no id", j+1, t.Name)
+			}
+		}
+	}
+	return &spec, nil
+}
+
+// Check compares the state read from src with spec and returns every
+// expectation that does not hold. An error means the state could not be read.
+func Check(ctx context.Context, spec *Spec, src Source) ([]Failure, error) {
+	tables, err := src.Tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]Table, len(tables))
+	for _, t := range tables {
+		byName[t.Name] = t
+	}
+
+	var failures []Failure
+	for _, ts := range spec.Tables {
+		table, exists := byName[ts.Name]
+		if ts.Absent {
+			if exists {
+				failures = append(failures, Failure{Table: ts.Name, Message: "table exists"})
+			}
+			continue
+		}
+		if !exists {
+			failures = append(failures, Failure{Table: ts.Name, Message: "table does not exist"})
+			continue
+		}
+		failures = append(failures, checkColumns(ts, table)...)
+
+		if ts.RowCount == nil && ts.MinRows == nil && ts.MaxRows == nil && len(ts.Rows) == 0 {
+			continue
+		}
+		rows, err := src.Rows(ctx, ts.Name)
+		if err != nil {
+			return nil, err
+		}
+		failures = append(failures, checkRows(ts, rows)...)
+	}
+	return failures, nil
+}
+
+func checkColumns(ts TableSpec, table Table) []Failure {
+	var failures []Failure
+	types := make(map[string]string, len(table.Columns))
+	for _, c := range table.Columns {
+		types[c.Name] = c.DataType
+	}
+	expected := make(map[string]bool, len(ts.Columns))
+	for _, c := range ts.Columns {
+		expected[c.Name] = true
+		typ, ok := types[c.Name]
+		switch {
+		case !ok:
+			failures = append(failures, Failure{Table: ts.Name, Message: fmt.Sprintf("column %s is not defined", c.Name)})
+		case c.DataType != "" && typ != c.DataType:
+			failures = append(failures, Failure{Table: ts.Name, Message: fmt.Sprintf("column %s has type %s, want %s", c.Name, typ, c.DataType)})
+		}
+	}
+	if ts.ExactColumns {
+		for _, c := range table.Columns {
+			if !expected[c.Name] {
+				failures = append(failures, Failure{Table: ts.Name, Message: fmt.Sprintf("unexpected column %s", c.Name)})
+			}
+		}
+	}
+	return failures
+}
+
+func checkRows(ts TableSpec, rows map[string]map[string]interface{}) []Failure {
+	var failures []Failure
+	n := len(rows)
+	if ts.RowCount != nil && n != *ts.RowCount {
+		failures = append(failures, Failure{Table: ts.Name, Message: fmt.Sprintf("has %d rows, want %d", n, *ts.RowCount)})
+	}
+	if ts.MinRows != nil && n < *ts.MinRows {
+		failures = append(failures, Failure{Table: ts.Name, Message: fmt.Sprintf("has %d rows, want at least %d", n, *ts.MinRows)})
+	}
+	if ts.MaxRows != nil && n > *ts.MaxRows {
+		failures = append(failures, Failure{Table: ts.Name, Message: fmt.Sprintf("has %d rows, want at most %d", n, *ts.MaxRows)})
+	}
+
+	for _, rs := range ts.Rows {
+		values, exists := rows[rs.ID]
+		if rs.Absent {
+			if exists {
+				failures = append(failures, Failure{Table: ts.Name, Row: rs.ID, Message: "row exists"})
+			}
+			continue
+		}
+		if !exists {
+			failures = append(failures, Failure{Table: ts.Name, Row: rs.ID, Message: "row does not exist"})
+			continue
+		}
+		names := make([]string, 0, len(rs.Values))
+		for name := range rs.Values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			got, ok := values[name]
+			if !ok {
+				failures = append(failures, Failure{Table: ts.Name, Row: rs.ID, Message: fmt.Sprintf("%s is not set, want %s", name, render(rs.Values[name]))})
+			} else if !equal(got, rs.Values[name]) {
+				failures = append(failures, Failure{Table: ts.Name, Row: rs.ID, Message: fmt.Sprintf("%s is %s, want %s", name, render(got), render(rs.Values[name]))})
+			}
+		}
+	}
+	return failures
+}
+
+// equal compares a live value with an expected one after normalizing both
+// through JSON, so that YAML integers match JSON numbers
+func equal(got, want interface{}) bool {
+	return reflect.DeepEqual(normalize(got), normalize(want))
+}
+
+func normalize(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+func render(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,123 @@
+package expect
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const spec = `
+tables:
+  - name: orders
+    columns:
+      - name: status
+        dataType: string
+      - name: total
+        dataType: number
+    exactColumns: true
+    rowCount: 2
+    rows:
+      - id: o-1
+        values:
+          status: shipped
+          total: 12
+      - id: o-2
+        values:
+          status: shipped
+      - id: o-3
+        absent: true
+  - name: legacy
+    absent: true
+  - name: customers
+    minRows: 1
+`
+
+func fakeServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer nb_test", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/tables":
+			w.Write([]byte(`{"tables": [
+				{"name": "orders", "columns": [{"name": "status", "dataType": "string"}, {"name": "total", "dataType": "string"}, {"name": "note", "dataType": "string"}]},
+				{"name": "legacy"}
+			]}`))
+		case "/v1/tables/orders/snapshot":
+			w.Write([]byte(`{"rows": [
+				{"id": "o-1", "values": {"status": "shipped", "total": 12}},
+				{"id": "o-2", "values": {"status": "pending"}},
+				{"id": "o-3", "values": {}}
+			]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "not found"}`))
+		}
+	}))
+}
+
+func TestCheck(t *testing.T) {
+	srv := fakeServer(t)
+	defer srv.Close()
+
+	s, err := Parse([]byte(spec))
+	require.NoError(t, err)
+	failures, err := Check(context.Background(), s, NewClient(srv.URL+"/", "nb_test", nil))
+	require.NoError(t, err)
+
+	var got []string
+	for _, f := range failures {
+		got = append(got, f.String())
+	}
+	assert.Equal(t, []string{
+		"orders: column total has type string, want number",
+		"orders: unexpected column note",
+		"orders: has 3 rows, want 2",
+		`orders/o-2: status is "pending", want "shipped"`,
+		"orders/o-3: row exists",
+		"legacy: table exists",
+		"customers: table does not exist",
+	}, got)
+}
+
+func TestCheckPasses(t *testing.T) {
+	srv := fakeServer(t)
+	defer srv.Close()
+
+	s, err := Parse([]byte(`
+tables:
+  - name: orders
+    minRows: 1
+    maxRows: 3
+    rows:
+      - id: o-1
+        values: {total: 12.0}
+`))
+	require.NoError(t, err)
+	failures, err := Check(context.Background(), s, NewClient(srv.URL, "nb_test", nil))
+	require.NoError(t, err)
+	assert.Empty(t, failures)
+}
+
+func TestParseRejectsInvalidSpecs(t *testing.T) {
+	for _, doc := range []string{
+		"tables:\n  - name: orders\n    rowcount: 2\n",
+		"tables:\n  - rowCount: 2\n",
+		"tables:\n  - name: orders\n    rows:\n      - values: {a: 1}\n",
+	} {
+		_, err := Parse([]byte(doc))
+		assert.Error(t, err, doc)
+	}
+}
+
+func TestClientReportsAPIErrors(t *testing.T) {
+	srv := fakeServer(t)
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL, "nb_test", nil).Rows(context.Background(), "missing")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not found")
+}
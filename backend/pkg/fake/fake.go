@@ -0,0 +1,284 @@
+// Package fake generates plausible rows for notably tables from their column
+// definitions, for mock servers and demos.
+//
+// Schemas are YAML (or JSON) listing tables as they would be created through
+// the API, plus an optional row count and fixed value choices per column:
+//
+//	tables:
+//	  - name: customers
+//	    rows: 50
+//	    columns:
+//	      - name: email
+//	        dataType: string
+//	      - name: tier
+//	        dataType: string
+//	        values: [free, pro, enterprise]
+//	      - name: signedUp
+//	        dataType: datetime
+//	      - name: plan
+//	        dataType: enum
+//	        enum: [monthly, yearly]
+//
+// Reference columns have no values of their own: the caller fills Values
+// with the IDs of the referenced table's rows before generating.
+package fake
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Schema lists the tables to generate
+type Schema struct {
+	Tables []Table `yaml:"tables"`
+}
+
+// Table is a table definition. Rows is the number of rows to generate; zero
+// means the caller's default.
+type Table struct {
+	Name    string   `yaml:"name" json:"name"`
+	Rows    int      `yaml:"rows" json:"-"`
+	Columns []Column `yaml:"columns" json:"columns"`
+}
+
+// Column is a column definition. When Values is set each generated value is
+// picked from it instead of being made up from the data type. Enum,
+// References, Precision and Scale are the column's type parameters and are
+// sent with its definition.
+type Column struct {
+	Name       string        `yaml:"name" json:"name"`
+	DataType   string        `yaml:"dataType" json:"dataType"`
+	Values     []interface{} `yaml:"values" json:"-"`
+	Enum       []string      `yaml:"enum" json:"enum,omitempty"`
+	References string        `yaml:"references" json:"references,omitempty"`
+	Precision  int           `yaml:"precision" json:"precision,omitempty"`
+	Scale      int           `yaml:"scale" json:"scale,omitempty"`
+}
+
+// Parse decodes a YAML schema, rejecting unknown fields
+func Parse(data []byte) (*Schema, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var schema Schema
+	if err := dec.Decode(&schema); err != nil {
+		return nil, fmt.Errorf("parsing schema: %w", err)
+	}
+	for i, t := range schema.Tables {
+		if t.Name == "" {
+			return nil, fmt.Errorf("parsing schema: table %d has no name", i+1)
+		}
+		if t.Rows < 0 {
+			return nil, fmt.Errorf("parsing schema: table %s has a negative row count", t.Name)
+		}
+		for j, c := range t.Columns {
+			if c.Name == "" {
+				return nil, fmt.Errorf("parsing schema: column %d of table %s has no name", j+1, t.Name)
+			}
+		}
+	}
+	return &schema, nil
+}
+
+// Generator makes up column values. The same seed always yields the same
+// values, so mock data is stable across restarts.
+type Generator struct {
+	rng *rand.Rand
+	now time.Time
+}
+
+// NewGenerator returns a Generator seeded with seed. Dates are generated
+// relative to now.
+func NewGenerator(seed int64, now time.Time) *Generator {
+	return &Generator{rng: rand.New(rand.NewSource(seed)), now: now.UTC()}
+}
+
+// Row generates a value for every column
+func (g *Generator) Row(columns []Column) map[string]interface{} {
+	values := make(map[string]interface{}, len(columns))
+	for _, c := range columns {
+		values[c.Name] = g.Value(c)
+	}
+	return values
+}
+
+// Value generates a value of the column's data type. Strings and numbers
+// are shaped by the column name, so an "email" column holds addresses and a
+// "price" column holds amounts. Unknown data types get strings.
+func (g *Generator) Value(c Column) interface{} {
+	if len(c.Values) > 0 {
+		return g.pick(c.Values)
+	}
+	name := strings.ToLower(c.Name)
+	switch c.DataType {
+	case "number":
+		return g.number(name)
+	case "boolean":
+		return g.rng.Intn(2) == 1
+	case "datetime":
+		return g.timestamp().Format(time.RFC3339)
+	case "date":
+		return g.timestamp().Format("2006-01-02")
+	case "enum":
+		if len(c.Enum) > 0 {
+			return g.pickString(c.Enum)
+		}
+		return g.word()
+	case "decimal":
+		return g.decimal(c.Precision, c.Scale)
+	case "uuid":
+		return g.uuid()
+	case "geopoint":
+		// Rounded so points survive a trip through JSON unchanged
+		return map[string]interface{}{
+			"lat": round(g.rng.Float64()*180-90, 5),
+			"lng": round(g.rng.Float64()*360-180, 5),
+		}
+	case "object", "json":
+		return map[string]interface{}{
+			"label": g.word(),
+			"score": float64(g.rng.Intn(100)),
+		}
+	case "array":
+		items := make([]interface{}, 1+g.rng.Intn(3))
+		for i := range items {
+			items[i] = g.word()
+		}
+		return items
+	default:
+		return g.text(name)
+	}
+}
+
+// decimal makes up a decimal string with scale digits after the point and
+// at most precision digits in all; zero precision means up to three before it
+func (g *Generator) decimal(precision, scale int) string {
+	intDigits := 3
+	if precision > 0 && precision-scale < intDigits {
+		intDigits = precision - scale
+	}
+	out := "0"
+	if intDigits > 0 {
+		out = fmt.Sprint(g.rng.Int63n(int64(math.Pow10(intDigits))))
+	}
+	if scale > 0 {
+		frac := make([]byte, scale)
+		for i := range frac {
+			frac[i] = byte('0' + g.rng.Intn(10))
+		}
+		out += "." + string(frac)
+	}
+	return out
+}
+
+// uuid makes up a version 4 UUID from the generator's random source
+func (g *Generator) uuid() string {
+	b := make([]byte, 16)
+	g.rng.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func (g *Generator) number(name string) float64 {
+	switch {
+	case containsAny(name, "price", "amount", "total", "cost", "balance"):
+		return round(1+g.rng.Float64()*499, 2)
+	case containsAny(name, "count", "quantity"):
+		return float64(g.rng.Intn(100))
+	case name == "age" || strings.HasSuffix(name, "age"):
+		return float64(18 + g.rng.Intn(72))
+	case containsAny(name, "lat"):
+		return round(g.rng.Float64()*180-90, 5)
+	case containsAny(name, "lng", "lon"):
+		return round(g.rng.Float64()*360-180, 5)
+	case containsAny(name, "rating", "score"):
+		return float64(1 + g.rng.Intn(5))
+	default:
+		return float64(g.rng.Intn(1000))
+	}
+}
+
+func (g *Generator) text(name string) string {
+	first, last := g.pickString(firstNames), g.pickString(lastNames)
+	switch {
+	case containsAny(name, "email"):
+		return fmt.Sprintf("%s.%s@example.com", strings.ToLower(first), strings.ToLower(last))
+	case containsAny(name, "url", "website", "link"):
+		return fmt.Sprintf("https://example.com/%s/%d", g.word(), g.rng.Intn(10000))
+	case containsAny(name, "phone"):
+		return fmt.Sprintf("+1-555-%03d-%04d", g.rng.Intn(1000), g.rng.Intn(10000))
+	case containsAny(name, "city"):
+		return g.pickString(cities)
+	case containsAny(name, "country"):
+		return g.pickString(countries)
+	case containsAny(name, "first"):
+		return first
+	case containsAny(name, "last", "surname"):
+		return last
+	case containsAny(name, "name", "author", "owner", "user"):
+		return first + " " + last
+	case containsAny(name, "status", "state"):
+		return g.pickString(statuses)
+	case containsAny(name, "title", "subject", "summary"):
+		return g.sentence(3 + g.rng.Intn(4))
+	case containsAny(name, "description", "body", "note", "comment", "text", "content"):
+		return g.sentence(8 + g.rng.Intn(12))
+	default:
+		return g.word()
+	}
+}
+
+// timestamp returns a time within the 90 days before now, to the second
+func (g *Generator) timestamp() time.Time {
+	return g.now.Add(-time.Duration(g.rng.Int63n(int64(90 * 24 * time.Hour)))).Truncate(time.Second)
+}
+
+func (g *Generator) sentence(words int) string {
+	parts := make([]string, words)
+	for i := range parts {
+		parts[i] = g.word()
+	}
+	s := strings.Join(parts, " ")
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func (g *Generator) word() string {
+	return g.pickString(words)
+}
+
+func (g *Generator) pick(choices []interface{}) interface{} {
+	return choices[g.rng.Intn(len(choices))]
+}
+
+func (g *Generator) pickString(choices []string) string {
+	return choices[g.rng.Intn(len(choices))]
+}
+
+func containsAny(s string, subs ...string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func round(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
+
+var (
+	firstNames = []string{"Ada", "Alan", "Barbara", "Claude", "Dana", "Edsger", "Frances", "Grace", "Hedy", "Ivan", "Joan", "Ken", "Linus", "Margaret", "Niklaus", "Radia"}
+	lastNames  = []string{"Allen", "Backus", "Cerf", "Dijkstra", "Engelbart", "Hamilton", "Hopper", "Kay", "Knuth", "Lamarr", "Liskov", "Lovelace", "Perlman", "Ritchie", "Thompson", "Wirth"}
+	cities     = []string{"Amsterdam", "Austin", "Berlin", "Lagos", "Lisbon", "Melbourne", "Montreal", "Nairobi", "Osaka", "Seoul", "Toronto", "Valparaiso"}
+	countries  = []string{"Australia", "Brazil", "Canada", "Germany", "Japan", "Kenya", "Mexico", "Netherlands", "Nigeria", "Portugal", "South Korea", "United States"}
+	statuses   = []string{"active", "pending", "archived", "draft", "closed"}
+	words      = []string{"alpha", "amber", "anchor", "atlas", "beacon", "birch", "cedar", "comet", "delta", "ember", "falcon", "garnet", "harbor", "indigo", "juniper", "kestrel", "lumen", "maple", "nimbus", "onyx", "orbit", "pixel", "quartz", "raven", "sierra", "tundra", "umber", "vertex", "willow", "zephyr"}
+)
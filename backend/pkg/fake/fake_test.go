@@ -0,0 +1,119 @@
+package fake
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const schema = `
+tables:
+  - name: customers
+    rows: 5
+    columns:
+      - name: email
+        dataType: string
+      - name: tier
+        dataType: string
+        values: [free, pro]
+      - name: balance
+        dataType: number
+      - name: active
+        dataType: boolean
+      - name: signedUp
+        dataType: datetime
+      - name: birthday
+        dataType: date
+      - name: home
+        dataType: geopoint
+      - name: prefs
+        dataType: object
+      - name: tags
+        dataType: array
+`
+
+func TestParse(t *testing.T) {
+	s, err := Parse([]byte(schema))
+	require.NoError(t, err)
+	require.Len(t, s.Tables, 1)
+	assert.Equal(t, 5, s.Tables[0].Rows)
+	assert.Len(t, s.Tables[0].Columns, 9)
+
+	for _, doc := range []string{
+		"tables:\n  - name: t\n    row: 2\n",
+		"tables:\n  - rows: 2\n",
+		"tables:\n  - name: t\n    rows: -1\n",
+		"tables:\n  - name: t\n    columns:\n      - dataType: string\n",
+	} {
+		_, err := Parse([]byte(doc))
+		assert.Error(t, err, doc)
+	}
+}
+
+func TestGeneratorValues(t *testing.T) {
+	s, err := Parse([]byte(schema))
+	require.NoError(t, err)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	g := NewGenerator(1, now)
+
+	for i := 0; i < 20; i++ {
+		row := g.Row(s.Tables[0].Columns)
+		assert.Contains(t, row["email"], "@example.com")
+		assert.Contains(t, []interface{}{"free", "pro"}, row["tier"])
+		assert.IsType(t, float64(0), row["balance"])
+		assert.IsType(t, true, row["active"])
+
+		signedUp, err := time.Parse(time.RFC3339, row["signedUp"].(string))
+		require.NoError(t, err)
+		assert.False(t, signedUp.After(now))
+		assert.True(t, signedUp.After(now.Add(-91*24*time.Hour)))
+		_, err = time.Parse("2006-01-02", row["birthday"].(string))
+		assert.NoError(t, err)
+
+		home := row["home"].(map[string]interface{})
+		assert.InDelta(t, 0, home["lat"], 90)
+		assert.InDelta(t, 0, home["lng"], 180)
+		assert.IsType(t, map[string]interface{}{}, row["prefs"])
+		assert.NotEmpty(t, row["tags"])
+
+		// Rows survive a JSON round trip unchanged
+		data, err := json.Marshal(row)
+		require.NoError(t, err)
+		var decoded map[string]interface{}
+		require.NoError(t, json.Unmarshal(data, &decoded))
+		assert.Equal(t, len(row), len(decoded))
+		assert.Equal(t, row["home"], decoded["home"])
+	}
+}
+
+func TestGeneratorIsDeterministic(t *testing.T) {
+	columns := []Column{{Name: "title", DataType: "string"}, {Name: "price", DataType: "number"}}
+	now := time.Now()
+	a, b := NewGenerator(7, now), NewGenerator(7, now)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, a.Row(columns), b.Row(columns))
+	}
+	title := NewGenerator(7, now).Value(columns[0]).(string)
+	assert.Equal(t, strings.ToUpper(title[:1]), title[:1])
+}
+
+func TestGeneratorTypedValues(t *testing.T) {
+	g := NewGenerator(1, time.Now())
+	columns := []Column{
+		{Name: "plan", DataType: "enum", Enum: []string{"monthly", "yearly"}},
+		{Name: "total", DataType: "decimal", Precision: 5, Scale: 2},
+		{Name: "ratio", DataType: "decimal", Precision: 2, Scale: 2},
+		{Name: "token", DataType: "uuid"},
+	}
+	for i := 0; i < 20; i++ {
+		row := g.Row(columns)
+		assert.Contains(t, []interface{}{"monthly", "yearly"}, row["plan"])
+		assert.Regexp(t, `^[0-9]{1,3}\.[0-9]{2}$`, row["total"])
+		assert.Regexp(t, `^0\.[0-9]{2}$`, row["ratio"])
+		assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, row["token"])
+	}
+}
@@ -0,0 +1,296 @@
+// Package filter evaluates declarative row filters and sort orders against
+// decoded JSON row values.
+//
+// A filter is a tree of conditions on columns, combined with and, or and not:
+//
+//	{"and": [
+//	  {"column": "status", "op": "eq", "value": "open"},
+//	  {"or": [
+//	    {"column": "total", "op": "gte", "value": 100},
+//	    {"column": "tags", "op": "contains", "value": "urgent"}
+//	  ]}
+//	]}
+//
+// A condition's column may also be a JSONPath expression such as
+// "$.address.city", which reads a value nested inside a column. Paths with
+// wildcards read an array of every value they select.
+package filter
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/pkg/jsonpath"
+)
+
+// Ops are the comparison operators a condition may use
+const (
+	OpEq       = "eq"
+	OpNe       = "ne"
+	OpLt       = "lt"
+	OpLte      = "lte"
+	OpGt       = "gt"
+	OpGte      = "gte"
+	OpIn       = "in"       // the value is an array holding the column's value
+	OpContains = "contains" // the column's string or array holds the value
+	OpPrefix   = "prefix"   // the column's string starts with the value
+	OpExists   = "exists"   // the column is present and not null; takes no value
+)
+
+// MaxDepth bounds how deeply filters may nest
+const MaxDepth = 16
+
+// Filter is a condition on one column, or a combination of filters. Exactly
+// one of And, Or, Not and Column is set.
+type Filter struct {
+	And    []Filter    `json:"and,omitempty"`
+	Or     []Filter    `json:"or,omitempty"`
+	Not    *Filter     `json:"not,omitempty"`
+	Column string      `json:"column,omitempty"`
+	Op     string      `json:"op,omitempty"`
+	Value  interface{} `json:"value,omitempty"`
+}
+
+// Validate checks that a filter is well formed
+func (f *Filter) Validate() error {
+	return f.validate("filter", 1)
+}
+
+func (f *Filter) validate(path string, depth int) error {
+	if depth > MaxDepth {
+		return fmt.Errorf("%s: nested more than %d deep", path, MaxDepth)
+	}
+	set := 0
+	for _, ok := range []bool{f.And != nil, f.Or != nil, f.Not != nil, f.Column != ""} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("%s: must have exactly one of and, or, not and column", path)
+	}
+	switch {
+	case f.And != nil || f.Or != nil:
+		name, children := "and", f.And
+		if f.Or != nil {
+			name, children = "or", f.Or
+		}
+		if len(children) == 0 {
+			return fmt.Errorf("%s.%s: must not be empty", path, name)
+		}
+		for i := range children {
+			if err := children[i].validate(fmt.Sprintf("%s.%s[%d]", path, name, i), depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	case f.Not != nil:
+		return f.Not.validate(path+".not", depth+1)
+	}
+
+	if jsonpath.IsPath(f.Column) {
+		if _, err := jsonpath.Parse(f.Column); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	switch f.Op {
+	case OpEq, OpNe, OpLt, OpLte, OpGt, OpGte, OpContains:
+		if f.Value == nil && f.Op != OpEq && f.Op != OpNe {
+			return fmt.Errorf("%s: op %s needs a value", path, f.Op)
+		}
+	case OpIn:
+		if _, ok := f.Value.([]interface{}); !ok {
+			return fmt.Errorf("%s: op in needs an array value", path)
+		}
+	case OpPrefix:
+		if _, ok := f.Value.(string); !ok {
+			return fmt.Errorf("%s: op prefix needs a string value", path)
+		}
+	case OpExists:
+		if f.Value != nil {
+			return fmt.Errorf("%s: op exists takes no value", path)
+		}
+	case "":
+		return fmt.Errorf("%s: op is required", path)
+	default:
+		return fmt.Errorf("%s: unknown op %q", path, f.Op)
+	}
+	return nil
+}
+
+// Columns returns the columns a filter reads, in the order they appear. A
+// JSONPath condition reads the column its path starts with.
+func (f *Filter) Columns() []string {
+	var out []string
+	seen := make(map[string]bool)
+	var walk func(f *Filter)
+	walk = func(f *Filter) {
+		col := f.Column
+		if jsonpath.IsPath(col) {
+			if p, err := jsonpath.Parse(col); err == nil {
+				col = p.Root()
+			}
+		}
+		if col != "" && !seen[col] {
+			seen[col] = true
+			out = append(out, col)
+		}
+		for i := range f.And {
+			walk(&f.And[i])
+		}
+		for i := range f.Or {
+			walk(&f.Or[i])
+		}
+		if f.Not != nil {
+			walk(f.Not)
+		}
+	}
+	walk(f)
+	return out
+}
+
+// Match reports whether a row's values satisfy the filter. A nil filter
+// matches every row. Ordering comparisons only hold between two numbers or
+// two strings; strings compare byte-wise, which orders RFC 3339 timestamps
+// in the same time zone correctly.
+func (f *Filter) Match(values map[string]interface{}) bool {
+	if f == nil {
+		return true
+	}
+	switch {
+	case f.And != nil:
+		for i := range f.And {
+			if !f.And[i].Match(values) {
+				return false
+			}
+		}
+		return true
+	case f.Or != nil:
+		for i := range f.Or {
+			if f.Or[i].Match(values) {
+				return true
+			}
+		}
+		return false
+	case f.Not != nil:
+		return !f.Not.Match(values)
+	}
+
+	v := f.value(values)
+	switch f.Op {
+	case OpEq:
+		return reflect.DeepEqual(v, f.Value)
+	case OpNe:
+		return !reflect.DeepEqual(v, f.Value)
+	case OpLt, OpLte, OpGt, OpGte:
+		c, ok := Compare(v, f.Value)
+		if !ok {
+			return false
+		}
+		switch f.Op {
+		case OpLt:
+			return c < 0
+		case OpLte:
+			return c <= 0
+		case OpGt:
+			return c > 0
+		default:
+			return c >= 0
+		}
+	case OpIn:
+		for _, candidate := range f.Value.([]interface{}) {
+			if reflect.DeepEqual(v, candidate) {
+				return true
+			}
+		}
+		return false
+	case OpContains:
+		switch v := v.(type) {
+		case string:
+			sub, ok := f.Value.(string)
+			return ok && strings.Contains(v, sub)
+		case []interface{}:
+			for _, item := range v {
+				if reflect.DeepEqual(item, f.Value) {
+					return true
+				}
+			}
+		}
+		return false
+	case OpPrefix:
+		s, ok := v.(string)
+		return ok && strings.HasPrefix(s, f.Value.(string))
+	case OpExists:
+		return v != nil
+	}
+	return false
+}
+
+// value returns the value a condition compares: its column's, or what its
+// JSONPath selects from the row's values
+func (f *Filter) value(values map[string]interface{}) interface{} {
+	if !jsonpath.IsPath(f.Column) {
+		return values[f.Column]
+	}
+	p, err := jsonpath.Parse(f.Column)
+	if err != nil {
+		return nil
+	}
+	v, _ := p.Select(values)
+	return v
+}
+
+// Compare orders two values of the same kind: numbers numerically, strings
+// byte-wise, false before true, times chronologically and exact decimals
+// (*big.Rat) numerically. It reports false for other pairs.
+func Compare(a, b interface{}) (int, bool) {
+	switch a := a.(type) {
+	case time.Time:
+		b, ok := b.(time.Time)
+		if !ok {
+			return 0, false
+		}
+		return a.Compare(b), true
+	case *big.Rat:
+		b, ok := b.(*big.Rat)
+		if !ok || a == nil || b == nil {
+			return 0, false
+		}
+		return a.Cmp(b), true
+	case float64:
+		b, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a, b), true
+	case bool:
+		b, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a == b:
+			return 0, true
+		case !a:
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
@@ -0,0 +1,133 @@
+package filter
+
+import (
+	"encoding/json"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parse(t *testing.T, src string) *Filter {
+	t.Helper()
+	var f Filter
+	require.NoError(t, json.Unmarshal([]byte(src), &f))
+	require.NoError(t, f.Validate())
+	return &f
+}
+
+func TestMatch(t *testing.T) {
+	row := map[string]interface{}{
+		"status": "open",
+		"total":  float64(120),
+		"tags":   []interface{}{"urgent", "eu"},
+		"paid":   false,
+		"note":   nil,
+	}
+	for src, want := range map[string]bool{
+		`{"column": "status", "op": "eq", "value": "open"}`:                                                       true,
+		`{"column": "status", "op": "ne", "value": "open"}`:                                                       false,
+		`{"column": "paid", "op": "eq", "value": false}`:                                                          true,
+		`{"column": "total", "op": "gte", "value": 120}`:                                                          true,
+		`{"column": "total", "op": "lt", "value": 100}`:                                                           false,
+		`{"column": "total", "op": "lt", "value": "200"}`:                                                         false,
+		`{"column": "status", "op": "in", "value": ["open", "held"]}`:                                             true,
+		`{"column": "tags", "op": "contains", "value": "eu"}`:                                                     true,
+		`{"column": "status", "op": "contains", "value": "pe"}`:                                                   true,
+		`{"column": "status", "op": "prefix", "value": "cl"}`:                                                     false,
+		`{"column": "note", "op": "exists"}`:                                                                      false,
+		`{"column": "missing", "op": "eq", "value": null}`:                                                        true,
+		`{"not": {"column": "status", "op": "eq", "value": "open"}}`:                                              false,
+		`{"or": [{"column": "paid", "op": "eq", "value": true}, {"column": "total", "op": "gt", "value": 100}]}`:  true,
+		`{"and": [{"column": "paid", "op": "eq", "value": true}, {"column": "total", "op": "gt", "value": 100}]}`: false,
+	} {
+		assert.Equal(t, want, parse(t, src).Match(row), src)
+	}
+	assert.True(t, (*Filter)(nil).Match(row))
+}
+
+func TestMatchPath(t *testing.T) {
+	row := map[string]interface{}{
+		"address": map[string]interface{}{"city": "Berlin", "zip": "10115"},
+		"items":   []interface{}{map[string]interface{}{"sku": "a"}, map[string]interface{}{"sku": "b"}},
+	}
+	for src, want := range map[string]bool{
+		`{"column": "$.address.city", "op": "eq", "value": "Berlin"}`:  true,
+		`{"column": "$.address.zip", "op": "prefix", "value": "10"}`:   true,
+		`{"column": "$.address.street", "op": "exists"}`:               false,
+		`{"column": "$.items[0].sku", "op": "eq", "value": "a"}`:       true,
+		`{"column": "$.items[*].sku", "op": "contains", "value": "b"}`: true,
+		`{"column": "$.items[*].sku", "op": "contains", "value": "c"}`: false,
+	} {
+		assert.Equal(t, want, parse(t, src).Match(row), src)
+	}
+	assert.Equal(t, []string{"address", "items"}, parse(t, `{"or": [{"column": "$.address.city", "op": "exists"}, {"column": "$.items[0]", "op": "exists"}]}`).Columns())
+}
+
+func TestValidate(t *testing.T) {
+	for _, src := range []string{
+		`{}`,
+		`{"column": "a"}`,
+		`{"column": "a", "op": "like", "value": "x"}`,
+		`{"column": "a", "op": "in", "value": "x"}`,
+		`{"column": "a", "op": "exists", "value": 1}`,
+		`{"column": "a", "op": "gt"}`,
+		`{"and": []}`,
+		`{"column": "$.address[", "op": "exists"}`,
+		`{"column": "a", "op": "eq", "value": 1, "not": {"column": "b", "op": "exists"}}`,
+	} {
+		var f Filter
+		require.NoError(t, json.Unmarshal([]byte(src), &f))
+		assert.Error(t, f.Validate(), src)
+	}
+
+	deep := `{"column": "a", "op": "exists"}`
+	for i := 0; i < MaxDepth; i++ {
+		deep = `{"not": ` + deep + `}`
+	}
+	var f Filter
+	require.NoError(t, json.Unmarshal([]byte(deep), &f))
+	assert.ErrorContains(t, f.Validate(), "nested more than")
+
+	assert.Equal(t, []string{"a", "b"}, parse(t, `{"and": [{"column": "a", "op": "exists"}, {"not": {"column": "b", "op": "exists"}}, {"column": "a", "op": "eq", "value": 1}]}`).Columns())
+}
+
+func TestSort(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"id": "a", "n": float64(2), "s": "x"},
+		{"id": "b", "s": "y"},
+		{"id": "c", "n": float64(1), "s": "x"},
+		{"id": "d", "n": float64(2), "s": "w"},
+	}
+	order := Sort{{Column: "n", Desc: true}, {Column: "s"}}
+	require.NoError(t, order.Validate())
+	sort.SliceStable(rows, func(i, j int) bool { return order.Compare(rows[i], rows[j]) < 0 })
+	var ids []string
+	for _, r := range rows {
+		ids = append(ids, r["id"].(string))
+	}
+	assert.Equal(t, []string{"d", "a", "c", "b"}, ids, "rows without the column sort last")
+	assert.Error(t, Sort{{Desc: true}}.Validate())
+}
+
+func TestCompareTypedValues(t *testing.T) {
+	early, late := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 13, 0, 0, 0, time.FixedZone("", 3600))
+	c, ok := Compare(early, late)
+	assert.True(t, ok)
+	assert.Equal(t, 0, c, "same instant in different zones")
+	c, ok = Compare(early, late.Add(time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, -1, c)
+
+	c, ok = Compare(big.NewRat(101, 10), big.NewRat(99, 1))
+	assert.True(t, ok)
+	assert.Equal(t, -1, c)
+
+	_, ok = Compare(early, "2024-01-01T12:00:00Z")
+	assert.False(t, ok)
+	_, ok = Compare(big.NewRat(1, 1), float64(1))
+	assert.False(t, ok)
+}
@@ -0,0 +1,51 @@
+package filter
+
+import "fmt"
+
+// SortKey orders rows by one column
+type SortKey struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc,omitempty"`
+}
+
+// Sort orders rows by each key in turn
+type Sort []SortKey
+
+// Validate checks that every key names a column
+func (s Sort) Validate() error {
+	for i, k := range s {
+		if k.Column == "" {
+			return fmt.Errorf("sort[%d]: column is required", i)
+		}
+	}
+	return nil
+}
+
+// Compare orders two rows by the sort keys, returning a negative number
+// when a comes first. Rows missing a column, or holding a value that cannot
+// be compared with the other row's, come after the rows that have one,
+// whatever the direction.
+func (s Sort) Compare(a, b map[string]interface{}) int {
+	for _, k := range s {
+		av, bv := a[k.Column], b[k.Column]
+		c, ok := Compare(av, bv)
+		if !ok {
+			_, aOK := Compare(av, av)
+			_, bOK := Compare(bv, bv)
+			switch {
+			case aOK && !bOK:
+				return -1
+			case !aOK && bOK:
+				return 1
+			}
+			continue
+		}
+		if k.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
@@ -0,0 +1,174 @@
+// Package geo provides the geohash encoding and spherical geometry behind
+// geopoint columns.
+//
+// A geohash names a rectangular cell of latitude and longitude; each extra
+// character splits the cell 32 ways, so points in the same cell share a hash
+// prefix. Regions are searched by listing the cells that cover them.
+package geo
+
+import (
+	"errors"
+	"math"
+	"strings"
+)
+
+// EarthRadius is the mean radius of the Earth in meters
+const EarthRadius = 6371008.8
+
+const base32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// ErrTooManyCells is returned when covering a region needs more cells than allowed
+var ErrTooManyCells = errors.New("region needs too many cells")
+
+// Point is a location in degrees
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// Valid reports whether p is a latitude and longitude on the globe
+func (p Point) Valid() bool {
+	return p.Lat >= -90 && p.Lat <= 90 && p.Lng >= -180 && p.Lng <= 180
+}
+
+// Box is a latitude/longitude rectangle. A box crossing the antimeridian has
+// MinLng greater than MaxLng.
+type Box struct {
+	MinLat, MinLng, MaxLat, MaxLng float64
+}
+
+// Contains reports whether p lies in the box
+func (b Box) Contains(p Point) bool {
+	if p.Lat < b.MinLat || p.Lat > b.MaxLat {
+		return false
+	}
+	if b.MinLng <= b.MaxLng {
+		return p.Lng >= b.MinLng && p.Lng <= b.MaxLng
+	}
+	return p.Lng >= b.MinLng || p.Lng <= b.MaxLng
+}
+
+// split returns the box as one or two boxes that do not cross the antimeridian
+func (b Box) split() []Box {
+	if b.MinLng <= b.MaxLng {
+		return []Box{b}
+	}
+	return []Box{
+		{MinLat: b.MinLat, MinLng: b.MinLng, MaxLat: b.MaxLat, MaxLng: 180},
+		{MinLat: b.MinLat, MinLng: -180, MaxLat: b.MaxLat, MaxLng: b.MaxLng},
+	}
+}
+
+// Distance returns the great-circle distance between two points in meters
+func Distance(a, b Point) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLat := lat2 - lat1
+	dLng := radians(b.Lng - a.Lng)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// BoxAround returns a box containing every point within radius meters of center
+func BoxAround(center Point, radius float64) Box {
+	dLat := degrees(radius / EarthRadius)
+	box := Box{MinLat: center.Lat - dLat, MaxLat: center.Lat + dLat}
+	if box.MinLat <= -90 || box.MaxLat >= 90 {
+		// The circle reaches a pole, so it spans every longitude
+		box.MinLat = math.Max(box.MinLat, -90)
+		box.MaxLat = math.Min(box.MaxLat, 90)
+		box.MinLng, box.MaxLng = -180, 180
+		return box
+	}
+
+	dLng := degrees(math.Asin(math.Min(1, math.Sin(radius/EarthRadius)/math.Cos(radians(center.Lat)))))
+	if dLng >= 180 {
+		box.MinLng, box.MaxLng = -180, 180
+		return box
+	}
+	box.MinLng = wrapLng(center.Lng - dLng)
+	box.MaxLng = wrapLng(center.Lng + dLng)
+	return box
+}
+
+// Encode returns the geohash of p with the given number of characters
+func Encode(p Point, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lngRange := [2]float64{-180, 180}
+	var b strings.Builder
+	bits, ch := 0, 0
+	even := true
+	for b.Len() < precision {
+		r, v := &latRange, p.Lat
+		if even {
+			r, v = &lngRange, p.Lng
+		}
+		mid := (r[0] + r[1]) / 2
+		ch <<= 1
+		if v >= mid {
+			ch |= 1
+			r[0] = mid
+		} else {
+			r[1] = mid
+		}
+		even = !even
+		if bits++; bits == 5 {
+			b.WriteByte(base32[ch])
+			bits, ch = 0, 0
+		}
+	}
+	return b.String()
+}
+
+// CellSize returns the height and width in degrees of geohash cells of the
+// given precision
+func CellSize(precision int) (lat, lng float64) {
+	n := 5 * precision
+	lngBits := (n + 1) / 2
+	latBits := n / 2
+	return 180 / math.Exp2(float64(latBits)), 360 / math.Exp2(float64(lngBits))
+}
+
+// Cover returns the geohashes of the given precision whose cells intersect
+// box, or ErrTooManyCells if there are more than maxCells of them
+func Cover(box Box, precision, maxCells int) ([]string, error) {
+	height, width := CellSize(precision)
+	seen := make(map[string]bool)
+	var cells []string
+	for _, part := range box.split() {
+		firstRow := math.Floor((part.MinLat + 90) / height)
+		firstCol := math.Floor((part.MinLng + 180) / width)
+		rows := int(math.Floor((part.MaxLat+90)/height)-firstRow) + 1
+		cols := int(math.Floor((part.MaxLng+180)/width)-firstCol) + 1
+		if len(cells)+rows*cols > maxCells {
+			return nil, ErrTooManyCells
+		}
+		for i := 0; i < rows; i++ {
+			// Encode each cell's center, which is never on a cell boundary
+			lat := math.Min((firstRow+float64(i)+0.5)*height-90, 90)
+			for j := 0; j < cols; j++ {
+				lng := math.Min((firstCol+float64(j)+0.5)*width-180, 180)
+				cell := Encode(Point{Lat: lat, Lng: lng}, precision)
+				if !seen[cell] {
+					seen[cell] = true
+					cells = append(cells, cell)
+				}
+			}
+		}
+	}
+	return cells, nil
+}
+
+func radians(d float64) float64 { return d * math.Pi / 180 }
+
+func degrees(r float64) float64 { return r * 180 / math.Pi }
+
+// wrapLng brings a longitude into [-180, 180]
+func wrapLng(lng float64) float64 {
+	for lng > 180 {
+		lng -= 360
+	}
+	for lng < -180 {
+		lng += 360
+	}
+	return lng
+}
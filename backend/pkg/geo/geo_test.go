@@ -0,0 +1,68 @@
+package geo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	// Reference values from the original geohash.org implementation
+	assert.Equal(t, "u4pruydqqvj", Encode(Point{Lat: 57.64911, Lng: 10.40744}, 11))
+	assert.Equal(t, "9q8yy", Encode(Point{Lat: 37.7749, Lng: -122.4194}, 5))
+	assert.Equal(t, "s0000", Encode(Point{}, 5))
+}
+
+func TestCellSize(t *testing.T) {
+	lat, lng := CellSize(1)
+	assert.Equal(t, 45.0, lat)
+	assert.Equal(t, 45.0, lng)
+	lat, lng = CellSize(2)
+	assert.Equal(t, 5.625, lat)
+	assert.Equal(t, 11.25, lng)
+}
+
+func TestDistance(t *testing.T) {
+	london := Point{Lat: 51.5074, Lng: -0.1278}
+	paris := Point{Lat: 48.8566, Lng: 2.3522}
+	assert.InDelta(t, 343_500, Distance(london, paris), 1_000)
+	assert.Zero(t, Distance(london, london))
+}
+
+func TestBoxAround(t *testing.T) {
+	center := Point{Lat: 51.5, Lng: -0.12}
+	box := BoxAround(center, 10_000)
+	for _, p := range []Point{{Lat: 51.58, Lng: -0.12}, {Lat: 51.5, Lng: 0.02}, {Lat: 51.45, Lng: -0.2}} {
+		require.Less(t, Distance(center, p), 10_000.0)
+		assert.True(t, box.Contains(p), p)
+	}
+	assert.False(t, box.Contains(Point{Lat: 51.7, Lng: -0.12}))
+
+	// Boxes crossing the antimeridian wrap around
+	box = BoxAround(Point{Lat: 0, Lng: 179.99}, 5_000)
+	assert.Greater(t, box.MinLng, box.MaxLng)
+	assert.True(t, box.Contains(Point{Lat: 0, Lng: -179.99}))
+
+	box = BoxAround(Point{Lat: 89.99, Lng: 0}, 5_000)
+	assert.Equal(t, Box{MinLat: box.MinLat, MinLng: -180, MaxLat: 90, MaxLng: 180}, box)
+}
+
+func TestCover(t *testing.T) {
+	center := Point{Lat: 37.7749, Lng: -122.4194}
+	box := BoxAround(center, 3_000)
+	cells, err := Cover(box, 5, 32)
+	require.NoError(t, err)
+	assert.Contains(t, cells, Encode(center, 5))
+	for _, corner := range []Point{{Lat: box.MinLat, Lng: box.MinLng}, {Lat: box.MaxLat, Lng: box.MaxLng}} {
+		assert.Contains(t, cells, Encode(corner, 5))
+	}
+
+	cells, err = Cover(BoxAround(Point{Lat: 0, Lng: 180}, 1_000), 4, 32)
+	require.NoError(t, err)
+	assert.Contains(t, cells, Encode(Point{Lat: 0, Lng: 179.999}, 4))
+	assert.Contains(t, cells, Encode(Point{Lat: 0, Lng: -179.999}, 4))
+
+	_, err = Cover(Box{MinLat: -90, MinLng: -180, MaxLat: 90, MaxLng: 180}, 4, 32)
+	assert.ErrorIs(t, err, ErrTooManyCells)
+}
@@ -0,0 +1,123 @@
+// Package ical renders iCalendar (RFC 5545) feeds.
+//
+// Only what calendar subscriptions need is supported: a calendar of VEVENTs
+// with a start, an optional end, a summary and a description. Events either
+// start at an instant, rendered in UTC, or fall on whole days.
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxLineOctets is the longest content line allowed before folding
+const maxLineOctets = 75
+
+// Calendar is a feed of events
+type Calendar struct {
+	// ProdID identifies the product that produced the feed
+	ProdID string
+	// Name is shown by clients as the calendar's title
+	Name   string
+	Events []Event
+}
+
+// Event is one calendar entry
+type Event struct {
+	// UID identifies the event across feed refreshes
+	UID string
+	// Start, and End when set, are the event's bounds. For all-day events
+	// only their dates are used, and End is exclusive.
+	Start  time.Time
+	End    time.Time
+	AllDay bool
+
+	Summary     string
+	Description string
+	// Updated is when the event last changed
+	Updated time.Time
+}
+
+// Write renders cal to w
+func Write(w io.Writer, cal Calendar) error {
+	bw := bufio.NewWriter(w)
+	line := func(name, value string) {
+		writeLine(bw, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", cal.ProdID)
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	if cal.Name != "" {
+		line("X-WR-CALNAME", EscapeText(cal.Name))
+	}
+	for _, ev := range cal.Events {
+		line("BEGIN", "VEVENT")
+		line("UID", EscapeText(ev.UID))
+		line("DTSTAMP", formatTime(ev.Updated))
+		if ev.AllDay {
+			line("DTSTART;VALUE=DATE", formatDate(ev.Start))
+			if !ev.End.IsZero() {
+				line("DTEND;VALUE=DATE", formatDate(ev.End))
+			}
+		} else {
+			line("DTSTART", formatTime(ev.Start))
+			if !ev.End.IsZero() {
+				line("DTEND", formatTime(ev.End))
+			}
+		}
+		line("SUMMARY", EscapeText(ev.Summary))
+		if ev.Description != "" {
+			line("DESCRIPTION", EscapeText(ev.Description))
+		}
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return bw.Flush()
+}
+
+// EscapeText escapes a TEXT property value
+func EscapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+var textEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+	"\r", `\n`,
+)
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+func formatDate(t time.Time) string {
+	return t.Format("20060102")
+}
+
+// writeLine writes a content line, folding it into continuation lines of at
+// most maxLineOctets octets without splitting UTF-8 sequences
+func writeLine(w *bufio.Writer, s string) {
+	limit := maxLineOctets
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		w.WriteString(s[:cut])
+		w.WriteString("\r\n ")
+		s = s[cut:]
+		// Continuation lines start with a space, which counts toward the limit
+		limit = maxLineOctets - 1
+	}
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
@@ -0,0 +1,72 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	updated := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	cal := Calendar{
+		ProdID: "-//Test//EN",
+		Name:   "Deadlines",
+		Events: []Event{
+			{
+				UID:         "report@example",
+				Start:       time.Date(2024, 5, 3, 17, 30, 0, 0, time.FixedZone("EST", -5*3600)),
+				Summary:     "Report, final; v2",
+				Description: "line one\nline two",
+				Updated:     updated,
+			},
+			{UID: "launch@example", Start: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), AllDay: true, Summary: "Launch", Updated: updated},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, cal))
+	assert.Equal(t, strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Test//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:Deadlines",
+		"BEGIN:VEVENT",
+		"UID:report@example",
+		"DTSTAMP:20240501T090000Z",
+		"DTSTART:20240503T223000Z",
+		`SUMMARY:Report\, final\; v2`,
+		`DESCRIPTION:line one\nline two`,
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:launch@example",
+		"DTSTAMP:20240501T090000Z",
+		"DTSTART;VALUE=DATE:20240601",
+		"SUMMARY:Launch",
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n"), buf.String())
+}
+
+func TestWriteFoldsLongLines(t *testing.T) {
+	summary := strings.Repeat("é", 100)
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, Calendar{ProdID: "-//Test//EN", Events: []Event{{UID: "1", Summary: summary}}}))
+
+	var unfolded string
+	for _, line := range strings.Split(buf.String(), "\r\n") {
+		assert.LessOrEqual(t, len(line), maxLineOctets)
+		if strings.HasPrefix(line, " ") {
+			unfolded += line[1:]
+		} else if strings.HasPrefix(line, "SUMMARY:") {
+			unfolded = line
+		}
+	}
+	assert.Equal(t, "SUMMARY:"+summary, unfolded)
+}
@@ -0,0 +1,228 @@
+// Package jsonpath evaluates JSONPath expressions against decoded JSON
+// values, so nested documents can be projected and filtered without being
+// flattened into columns.
+//
+// Expressions start at the root $ and step into it with members and
+// indexes:
+//
+//	$.address.city
+//	$['display name']
+//	$.tags[0]
+//	$.items[-1].price
+//	$.items[*].sku
+//	$.sizes.*
+//
+// Negative indexes count from the end of an array. The wildcards [*] and .*
+// step into every element of an array or every member of an object.
+package jsonpath
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxSteps bounds the steps of one expression
+const MaxSteps = 32
+
+// stepKind is what a step selects
+type stepKind int
+
+const (
+	stepMember stepKind = iota
+	stepIndex
+	stepWildcard
+)
+
+// step is one member, index or wildcard of a path
+type step struct {
+	kind   stepKind
+	member string
+	index  int
+}
+
+// Path is a parsed JSONPath expression
+type Path struct {
+	expr  string
+	steps []step
+}
+
+// IsPath reports whether s is written as a JSONPath expression rather than
+// a plain column name
+func IsPath(s string) bool {
+	return strings.HasPrefix(s, "$")
+}
+
+// Parse parses a JSONPath expression
+func Parse(expr string) (*Path, error) {
+	if !IsPath(expr) {
+		return nil, fmt.Errorf("jsonpath %q: must start with $", expr)
+	}
+	p := &Path{expr: expr}
+	rest := expr[1:]
+	for rest != "" {
+		if len(p.steps) == MaxSteps {
+			return nil, fmt.Errorf("jsonpath %q: more than %d steps", expr, MaxSteps)
+		}
+		var s step
+		var err error
+		switch rest[0] {
+		case '.':
+			s, rest, err = parseDot(rest[1:])
+		case '[':
+			s, rest, err = parseBracket(rest[1:])
+		default:
+			err = fmt.Errorf("unexpected %q", rest[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("jsonpath %q: %w", expr, err)
+		}
+		p.steps = append(p.steps, s)
+	}
+	return p, nil
+}
+
+// parseDot parses the step after a dot, returning the rest of the expression
+func parseDot(rest string) (step, string, error) {
+	if strings.HasPrefix(rest, "*") {
+		return step{kind: stepWildcard}, rest[1:], nil
+	}
+	end := strings.IndexAny(rest, ".[")
+	if end < 0 {
+		end = len(rest)
+	}
+	name := rest[:end]
+	if name == "" {
+		return step{}, "", fmt.Errorf("member name expected after '.'")
+	}
+	if strings.ContainsAny(name, "]'\" ") {
+		return step{}, "", fmt.Errorf("invalid member name %q; quote it as ['...']", name)
+	}
+	return step{kind: stepMember, member: name}, rest[end:], nil
+}
+
+// parseBracket parses the step after an opening bracket, returning the rest
+// of the expression
+func parseBracket(rest string) (step, string, error) {
+	if rest != "" && (rest[0] == '\'' || rest[0] == '"') {
+		quote := rest[0]
+		var name strings.Builder
+		for i := 1; i < len(rest); i++ {
+			switch c := rest[i]; {
+			case c == '\\' && i+1 < len(rest):
+				i++
+				name.WriteByte(rest[i])
+			case c == quote:
+				if i+1 >= len(rest) || rest[i+1] != ']' {
+					return step{}, "", fmt.Errorf("']' expected after quoted member")
+				}
+				return step{kind: stepMember, member: name.String()}, rest[i+2:], nil
+			default:
+				name.WriteByte(c)
+			}
+		}
+		return step{}, "", fmt.Errorf("unterminated quoted member")
+	}
+	end := strings.IndexByte(rest, ']')
+	if end < 0 {
+		return step{}, "", fmt.Errorf("unterminated '['")
+	}
+	inside := strings.TrimSpace(rest[:end])
+	if inside == "*" {
+		return step{kind: stepWildcard}, rest[end+1:], nil
+	}
+	n, err := strconv.Atoi(inside)
+	if err != nil {
+		return step{}, "", fmt.Errorf("invalid index %q", inside)
+	}
+	return step{kind: stepIndex, index: n}, rest[end+1:], nil
+}
+
+// String returns the expression the path was parsed from
+func (p *Path) String() string {
+	return p.expr
+}
+
+// Root returns the member the path first steps into, which names a column
+// when the path is applied to a row's values. It is empty when the path
+// does not start with a member.
+func (p *Path) Root() string {
+	if len(p.steps) == 0 || p.steps[0].kind != stepMember {
+		return ""
+	}
+	return p.steps[0].member
+}
+
+// Definite reports whether the path selects at most one value, because it
+// has no wildcards
+func (p *Path) Definite() bool {
+	for _, s := range p.steps {
+		if s.kind == stepWildcard {
+			return false
+		}
+	}
+	return true
+}
+
+// Select evaluates the path against a value. A definite path returns the
+// value it selects, and false when there is none. Any other path returns
+// every value it selects as an array, in document order with object
+// members sorted by name, and always reports true.
+func (p *Path) Select(value interface{}) (interface{}, bool) {
+	current := []interface{}{value}
+	for _, s := range p.steps {
+		var next []interface{}
+		for _, v := range current {
+			next = s.apply(v, next)
+		}
+		current = next
+	}
+	if !p.Definite() {
+		if current == nil {
+			current = []interface{}{}
+		}
+		return current, true
+	}
+	if len(current) == 0 {
+		return nil, false
+	}
+	return current[0], true
+}
+
+// apply appends the values a step selects from v to out
+func (s step) apply(v interface{}, out []interface{}) []interface{} {
+	switch s.kind {
+	case stepMember:
+		if m, ok := v.(map[string]interface{}); ok {
+			if child, ok := m[s.member]; ok {
+				out = append(out, child)
+			}
+		}
+	case stepIndex:
+		if a, ok := v.([]interface{}); ok {
+			i := s.index
+			if i < 0 {
+				i += len(a)
+			}
+			if i >= 0 && i < len(a) {
+				out = append(out, a[i])
+			}
+		}
+	case stepWildcard:
+		switch v := v.(type) {
+		case []interface{}:
+			out = append(out, v...)
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				out = append(out, v[k])
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,67 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelect(t *testing.T) {
+	var doc interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"name": "Ada",
+		"display name": "Ada L",
+		"address": {"city": "London", "zip": null},
+		"tags": ["math", "engines"],
+		"items": [{"sku": "a", "price": 1}, {"sku": "b", "price": 2}, {"price": 3}],
+		"sizes": {"s": 1, "m": 2}
+	}`), &doc))
+
+	for expr, want := range map[string]interface{}{
+		"$":                  doc,
+		"$.address.city":     "London",
+		"$['display name']":  "Ada L",
+		`$["address"].city`:  "London",
+		"$.address.zip":      nil,
+		"$.tags[0]":          "math",
+		"$.tags[-1]":         "engines",
+		"$.items[1].price":   float64(2),
+		"$.items[*].sku":     []interface{}{"a", "b"},
+		"$.items[*].missing": []interface{}{},
+		"$.sizes.*":          []interface{}{float64(2), float64(1)},
+		"$.tags[*]":          []interface{}{"math", "engines"},
+	} {
+		p, err := Parse(expr)
+		require.NoError(t, err, expr)
+		got, ok := p.Select(doc)
+		assert.True(t, ok, expr)
+		assert.Equal(t, want, got, expr)
+	}
+
+	for _, expr := range []string{"$.missing", "$.tags[5]", "$.name.first", "$.address[0]"} {
+		p, err := Parse(expr)
+		require.NoError(t, err, expr)
+		_, ok := p.Select(doc)
+		assert.False(t, ok, expr)
+	}
+}
+
+func TestParse(t *testing.T) {
+	p, err := Parse("$.address['city']")
+	require.NoError(t, err)
+	assert.Equal(t, "address", p.Root())
+	assert.True(t, p.Definite())
+	assert.Equal(t, "$.address['city']", p.String())
+
+	p, err = Parse("$[*].name")
+	require.NoError(t, err)
+	assert.Equal(t, "", p.Root())
+	assert.False(t, p.Definite())
+
+	for _, expr := range []string{"address.city", "$.", "$..city", "$[", "$[x]", "$['city'", "$['city'x]", "$.a b", "$x"} {
+		_, err := Parse(expr)
+		assert.Error(t, err, expr)
+	}
+}
@@ -0,0 +1,117 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/elibdev/notably/schema"
+)
+
+// Attributes of lease items besides their key
+const (
+	ownerAttr   = "Owner"
+	expiresAttr = "ExpiresAt"
+)
+
+// API is the DynamoDB API leases use
+type API interface {
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+// DynamoStore keeps leases as items of a facts table, in
+// schema.LeasePartition under their names. Each item records its owner and
+// when it expires, in Unix milliseconds; writes are conditional on those,
+// so replicas racing for a lease cannot both take it.
+type DynamoStore struct {
+	api   API
+	table string
+
+	// The table's schema is detected on first use
+	mu       sync.Mutex
+	detected bool
+	schema   schema.Schema
+}
+
+// NewDynamoStore returns a store keeping leases in a facts table of any
+// schema
+func NewDynamoStore(api API, table string) *DynamoStore {
+	return &DynamoStore{api: api, table: table}
+}
+
+// key returns the key of a lease's item
+func (s *DynamoStore) key(ctx context.Context, name string) (map[string]types.AttributeValue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.detected {
+		out, err := s.api.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(s.table)})
+		if err != nil {
+			return nil, fmt.Errorf("lease: describe %s: %w", s.table, err)
+		}
+		if s.schema, err = schema.Detect(out.Table); err != nil {
+			return nil, fmt.Errorf("lease: %w", err)
+		}
+		s.detected = true
+	}
+	return s.schema.LeaseKey(name), nil
+}
+
+// Acquire implements Store
+func (s *DynamoStore) Acquire(ctx context.Context, name, owner string, now, expires time.Time) error {
+	item, err := s.key(ctx, name)
+	if err != nil {
+		return err
+	}
+	item[ownerAttr] = &types.AttributeValueMemberS{Value: owner}
+	item[expiresAttr] = &types.AttributeValueMemberN{Value: strconv.FormatInt(expires.UnixMilli(), 10)}
+	_, err = s.api.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(s.table),
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_not_exists(#sk) OR #exp < :now OR #owner = :owner"),
+		ExpressionAttributeNames: map[string]string{"#sk": schema.SortKey, "#exp": expiresAttr, "#owner": ownerAttr},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":   &types.AttributeValueMemberN{Value: strconv.FormatInt(now.UnixMilli(), 10)},
+			":owner": &types.AttributeValueMemberS{Value: owner},
+		},
+	})
+	var held *types.ConditionalCheckFailedException
+	if errors.As(err, &held) {
+		return ErrHeld
+	}
+	if err != nil {
+		return fmt.Errorf("lease: acquire %s: %w", name, err)
+	}
+	return nil
+}
+
+// Release implements Store
+func (s *DynamoStore) Release(ctx context.Context, name, owner string) error {
+	key, err := s.key(ctx, name)
+	if err != nil {
+		return err
+	}
+	_, err = s.api.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 aws.String(s.table),
+		Key:                       key,
+		ConditionExpression:       aws.String("#owner = :owner"),
+		ExpressionAttributeNames:  map[string]string{"#owner": ownerAttr},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":owner": &types.AttributeValueMemberS{Value: owner}},
+	})
+	var taken *types.ConditionalCheckFailedException
+	if errors.As(err, &taken) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("lease: release %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// Package lease coordinates work among server replicas with leases: named,
+// expiring claims of which one owner at a time holds each.
+//
+// A Locker runs a task only once it has taken the task's lease, and renews
+// the lease while the task runs, so the lease outlives its TTL only as long
+// as its owner keeps sending heartbeats. An owner that stops, crashes or
+// loses contact with the store loses the lease once the TTL passes, and
+// another replica can take it. DynamoStore keeps leases in a facts table
+// with conditional writes; MemoryStore serves single-process servers.
+package lease
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrHeld is returned by Store.Acquire when another owner holds the lease
+var ErrHeld = errors.New("lease: held by another owner")
+
+// ErrLost is returned by Locker.Do when the lease was lost while the task
+// ran, so another owner may have started it too
+var ErrLost = errors.New("lease: lost while held")
+
+// Store keeps leases. Each call must be atomic.
+type Store interface {
+	// Acquire takes the lease named name for owner until expires if it is
+	// free, expired at now or already owner's, and returns ErrHeld
+	// otherwise. Owners renew their leases by acquiring them again.
+	Acquire(ctx context.Context, name, owner string, now, expires time.Time) error
+	// Release frees owner's lease on name. Releasing a lease held by
+	// another owner does nothing.
+	Release(ctx context.Context, name, owner string) error
+}
+
+// NewOwner returns an owner name unique to this process, made of the host
+// name and a random suffix
+func NewOwner() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return host
+	}
+	return host + "-" + hex.EncodeToString(b)
+}
+
+// Locker runs tasks under leases held by one owner
+type Locker struct {
+	store Store
+	owner string
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+// New returns a locker taking leases in store as owner. Leases last ttl
+// past each heartbeat, and heartbeats are sent every third of it.
+func New(store Store, owner string, ttl time.Duration) *Locker {
+	return &Locker{store: store, owner: owner, ttl: ttl, now: time.Now}
+}
+
+// Owner returns the owner the locker takes leases as
+func (l *Locker) Owner() string {
+	return l.owner
+}
+
+// Do runs task while holding the lease named name, and releases the lease
+// when task returns. It reports false without running task when another
+// owner holds the lease. If a heartbeat finds the lease taken, or none
+// succeeds before it expires, task's context is cancelled and Do returns
+// ErrLost.
+func (l *Locker) Do(ctx context.Context, name string, task func(ctx context.Context) error) (bool, error) {
+	now := l.now()
+	err := l.store.Acquire(ctx, name, l.owner, now, now.Add(l.ttl))
+	if errors.Is(err, ErrHeld) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	taskCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// lost is only read once the heartbeat goroutine has stopped
+	lost := false
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		expires := now.Add(l.ttl)
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			now := l.now()
+			err := l.store.Acquire(taskCtx, name, l.owner, now, now.Add(l.ttl))
+			if err == nil {
+				expires = now.Add(l.ttl)
+				continue
+			}
+			if errors.Is(err, ErrHeld) || !now.Before(expires) {
+				lost = true
+				cancel()
+				return
+			}
+		}
+	}()
+
+	err = task(taskCtx)
+	close(done)
+	<-stopped
+
+	if lost {
+		return true, fmt.Errorf("%w: %s", ErrLost, name)
+	}
+	if relErr := l.store.Release(context.WithoutCancel(ctx), name, l.owner); relErr != nil && err == nil {
+		err = fmt.Errorf("lease: release %s: %w", name, relErr)
+	}
+	return true, err
+}
+
+// MemoryStore keeps leases in memory, for servers that run in one process
+type MemoryStore struct {
+	mu     sync.Mutex
+	leases map[string]memoryLease
+}
+
+type memoryLease struct {
+	owner   string
+	expires time.Time
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{leases: make(map[string]memoryLease)}
+}
+
+// Acquire implements Store
+func (s *MemoryStore) Acquire(ctx context.Context, name, owner string, now, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.leases[name]; ok && l.owner != owner && !l.expires.Before(now) {
+		return ErrHeld
+	}
+	s.leases[name] = memoryLease{owner: owner, expires: expires}
+	return nil
+}
+
+// Release implements Store
+func (s *MemoryStore) Release(ctx context.Context, name, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.leases[name]; ok && l.owner == owner {
+		delete(s.leases, name)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/schema"
+)
+
+// leaseAPI is a namespace-schema table holding lease items, applying the
+// store's conditions the way DynamoDB does
+type leaseAPI struct {
+	items map[string]map[string]types.AttributeValue
+	puts  []*dynamodb.PutItemInput
+}
+
+func (f *leaseAPI) DescribeTable(ctx context.Context, in *dynamodb.DescribeTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	def := schema.Definition("Facts", schema.Namespace)
+	return &dynamodb.DescribeTableOutput{Table: &types.TableDescription{TableName: in.TableName, KeySchema: def.KeySchema}}, nil
+}
+
+func (f *leaseAPI) PutItem(ctx context.Context, in *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.puts = append(f.puts, in)
+	name := in.Item[schema.SortKey].(*types.AttributeValueMemberS).Value
+	if cur, ok := f.items[name]; ok {
+		owner := cur[ownerAttr].(*types.AttributeValueMemberS).Value
+		expires, _ := strconv.ParseInt(cur[expiresAttr].(*types.AttributeValueMemberN).Value, 10, 64)
+		now, _ := strconv.ParseInt(in.ExpressionAttributeValues[":now"].(*types.AttributeValueMemberN).Value, 10, 64)
+		if expires >= now && owner != in.ExpressionAttributeValues[":owner"].(*types.AttributeValueMemberS).Value {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+	}
+	f.items[name] = in.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *leaseAPI) DeleteItem(ctx context.Context, in *dynamodb.DeleteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	name := in.Key[schema.SortKey].(*types.AttributeValueMemberS).Value
+	cur, ok := f.items[name]
+	if !ok || cur[ownerAttr].(*types.AttributeValueMemberS).Value != in.ExpressionAttributeValues[":owner"].(*types.AttributeValueMemberS).Value {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	delete(f.items, name)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func TestStores(t *testing.T) {
+	ctx := context.Background()
+	api := &leaseAPI{items: map[string]map[string]types.AttributeValue{}}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "dynamo": NewDynamoStore(api, "Facts")} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, store.Acquire(ctx, "retention", "a", now, now.Add(time.Minute)))
+			assert.ErrorIs(t, store.Acquire(ctx, "retention", "b", now.Add(time.Second), now.Add(time.Minute)), ErrHeld)
+			require.NoError(t, store.Acquire(ctx, "expiry", "b", now, now.Add(time.Minute)), "leases are independent")
+			require.NoError(t, store.Acquire(ctx, "retention", "a", now.Add(30*time.Second), now.Add(2*time.Minute)), "owners renew their leases")
+			assert.ErrorIs(t, store.Acquire(ctx, "retention", "b", now.Add(90*time.Second), now.Add(3*time.Minute)), ErrHeld, "renewals extend the lease")
+
+			// Expired leases can be taken
+			require.NoError(t, store.Acquire(ctx, "retention", "b", now.Add(3*time.Minute), now.Add(4*time.Minute)))
+			require.NoError(t, store.Release(ctx, "retention", "a"), "releasing a lost lease does nothing")
+			assert.ErrorIs(t, store.Acquire(ctx, "retention", "a", now.Add(3*time.Minute), now.Add(4*time.Minute)), ErrHeld)
+			require.NoError(t, store.Release(ctx, "retention", "b"))
+			require.NoError(t, store.Acquire(ctx, "retention", "a", now.Add(3*time.Minute), now.Add(4*time.Minute)))
+		})
+	}
+
+	put := api.puts[0]
+	assert.Equal(t, &types.AttributeValueMemberS{Value: schema.LeasePartition}, put.Item[schema.PartitionKey])
+	assert.Equal(t, &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(time.Minute).UnixMilli(), 10)}, put.Item[expiresAttr])
+	assert.Equal(t, "attribute_not_exists(#sk) OR #exp < :now OR #owner = :owner", *put.ConditionExpression)
+}
+
+func TestLockerDo(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	a, b := New(store, "a", 30*time.Millisecond), New(store, "b", 30*time.Millisecond)
+
+	// The lease outlives its TTL while its holder runs, and another owner
+	// skips the task meanwhile
+	ran, err := a.Do(ctx, "retention", func(ctx context.Context) error {
+		time.Sleep(100 * time.Millisecond)
+		ran, err := b.Do(ctx, "retention", func(ctx context.Context) error { return nil })
+		assert.False(t, ran)
+		assert.NoError(t, err)
+		return ctx.Err()
+	})
+	assert.True(t, ran)
+	require.NoError(t, err)
+
+	// The lease is released once the task returns
+	taskErr := errors.New("boom")
+	ran, err = b.Do(ctx, "retention", func(ctx context.Context) error { return taskErr })
+	assert.True(t, ran)
+	assert.ErrorIs(t, err, taskErr)
+
+	// A lease taken over ends the task
+	ran, err = a.Do(ctx, "retention", func(ctx context.Context) error {
+		store.Release(ctx, "retention", "a")
+		require.NoError(t, store.Acquire(ctx, "retention", "b", time.Now(), time.Now().Add(time.Hour)))
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.True(t, ran)
+	assert.ErrorIs(t, err, ErrLost)
+}
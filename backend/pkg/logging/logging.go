@@ -0,0 +1,130 @@
+// Package logging provides the structured logger shared by the server and
+// storage layers, and carries per-request correlation data through contexts.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+type contextKey string
+
+const requestKey contextKey = "request"
+
+// request holds the correlation data for one HTTP request. The user is filled
+// in after authentication, which happens deeper in the handler chain than the
+// middleware that created the request.
+type request struct {
+	id string
+
+	mu     sync.Mutex
+	userID string
+}
+
+// WithRequestID returns a context carrying the given request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestKey, &request{id: id})
+}
+
+// RequestID returns the request ID carried by ctx, or "" if there is none
+func RequestID(ctx context.Context) string {
+	if req, ok := ctx.Value(requestKey).(*request); ok {
+		return req.id
+	}
+	return ""
+}
+
+// SetUser records the authenticated user for the request carried by ctx
+func SetUser(ctx context.Context, userID string) {
+	if req, ok := ctx.Value(requestKey).(*request); ok {
+		req.mu.Lock()
+		req.userID = userID
+		req.mu.Unlock()
+	}
+}
+
+// User returns the authenticated user recorded for the request carried by ctx
+func User(ctx context.Context) string {
+	if req, ok := ctx.Value(requestKey).(*request); ok {
+		req.mu.Lock()
+		defer req.mu.Unlock()
+		return req.userID
+	}
+	return ""
+}
+
+// NewRequestID generates a random request ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// contextHandler adds the request ID and user from the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+// NewHandler wraps h so records logged with a request context are tagged with
+// request_id and user_id attributes
+func NewHandler(h slog.Handler) slog.Handler {
+	return contextHandler{h}
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if user := User(ctx); user != "" {
+		r.AddAttrs(slog.String("user_id", user))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// New creates a logger writing to w. Format is "json" or "text" (the default);
+// level is one of debug, info, warn or error (default info).
+func New(w io.Writer, format, level string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: ParseLevel(level)}
+
+	var h slog.Handler
+	if strings.EqualFold(format, "json") {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(NewHandler(h))
+}
+
+// ParseLevel converts a level name to a slog.Level, defaulting to info
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// Discard returns a logger that drops every record
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+}
@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerAddsRequestContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "json", "debug")
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	SetUser(ctx, "user-1")
+	logger.DebugContext(ctx, "hello", "k", "v")
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "req-1", entry["request_id"])
+	assert.Equal(t, "user-1", entry["user_id"])
+	assert.Equal(t, "v", entry["k"])
+}
+
+func TestHandlerWithoutRequestContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "json", "info")
+
+	logger.InfoContext(context.Background(), "hello")
+	logger.Debug("filtered")
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.NotContains(t, entry, "request_id")
+	assert.NotContains(t, entry, "user_id")
+	assert.Equal(t, "", RequestID(context.Background()))
+}
@@ -0,0 +1,250 @@
+// Package metrics exposes Prometheus metrics for the server and storage layers.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/elibdev/notably/db"
+)
+
+const namespace = "notably"
+
+// Metrics holds the collectors for one server instance on a private registry
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	storeDuration   *prometheus.HistogramVec
+	storeErrors     *prometheus.CounterVec
+	throttles       *prometheus.CounterVec
+	storeRetries    *prometheus.CounterVec
+	storeItems      *prometheus.HistogramVec
+	storeCapacity   *prometheus.CounterVec
+	authFailures    *prometheus.CounterVec
+	rateLimited     *prometheus.CounterVec
+	pluginDropped   *prometheus.CounterVec
+	webhooks        *prometheus.CounterVec
+	published       *prometheus.CounterVec
+	conflicts       *prometheus.CounterVec
+	replicationLag  *prometheus.GaugeVec
+}
+
+// New creates and registers the server's collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route pattern and status code.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by method and route pattern.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		storeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "store_operation_duration_seconds",
+			Help:      "Storage operation latency by layer and operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"layer", "operation"}),
+		storeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "store_operation_errors_total",
+			Help:      "Failed storage operations by layer and operation.",
+		}, []string{"layer", "operation"}),
+		throttles: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "dynamodb_throttles_total",
+			Help:      "DynamoDB operations rejected for exceeding capacity or request limits.",
+		}, []string{"layer", "operation"}),
+		storeRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "store_retries_total",
+			Help:      "Throttled storage operations retried after a backoff, by layer and operation.",
+		}, []string{"layer", "operation"}),
+		storeItems: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "store_operation_items",
+			Help:      "Facts read or written per storage operation, by layer and operation.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		}, []string{"layer", "operation"}),
+		storeCapacity: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "dynamodb_consumed_capacity_units_total",
+			Help:      "DynamoDB capacity units consumed by storage operations, by layer and operation.",
+		}, []string{"layer", "operation"}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "auth_failures_total",
+			Help:      "Rejected authentication attempts by reason.",
+		}, []string{"reason"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limited_requests_total",
+			Help:      "Requests rejected with 429 by budget (read or write).",
+		}, []string{"budget"}),
+		pluginDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "plugin_events_dropped_total",
+			Help:      "Row events dropped because a plugin's event queue was full.",
+		}, []string{"plugin"}),
+		webhooks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "webhook_deliveries_total",
+			Help:      "Webhook deliveries by outcome (delivered, failed or dropped).",
+		}, []string{"outcome"}),
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "published_events_total",
+			Help:      "Row change events sent to the message broker by outcome (published or dead_lettered).",
+		}, []string{"outcome"}),
+		conflicts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "replication_conflicts_total",
+			Help:      "Versions of a field written concurrently in two regions, by winning and losing region.",
+		}, []string{"winner", "loser"}),
+		replicationLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "replication_lag_seconds",
+			Help:      "How far behind each replica region's writes are as seen from this region.",
+		}, []string{"region"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests, m.requestDuration,
+		m.storeDuration, m.storeErrors, m.throttles, m.storeRetries, m.storeItems, m.storeCapacity,
+		m.authFailures, m.rateLimited, m.pluginDropped, m.webhooks, m.published,
+		m.conflicts, m.replicationLag,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Registry returns the registry holding the collectors, for registering extra ones
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// ObserveRequest records a completed HTTP request. Route is the mux pattern
+// that matched, so label cardinality stays bounded.
+func (m *Metrics) ObserveRequest(method, route string, status int, duration time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	m.requests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	m.requestDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// ObserveAuthFailure records a rejected authentication attempt
+func (m *Metrics) ObserveAuthFailure(reason string) {
+	m.authFailures.WithLabelValues(reason).Inc()
+}
+
+// ObserveRateLimited records a request rejected by the rate limiter
+func (m *Metrics) ObserveRateLimited(budget string) {
+	m.rateLimited.WithLabelValues(budget).Inc()
+}
+
+// ObservePluginEventDropped records a row event a plugin did not receive
+func (m *Metrics) ObservePluginEventDropped(plugin string) {
+	m.pluginDropped.WithLabelValues(plugin).Inc()
+}
+
+// ObserveWebhookDelivery records the outcome of a webhook delivery
+func (m *Metrics) ObserveWebhookDelivery(outcome string) {
+	m.webhooks.WithLabelValues(outcome).Inc()
+}
+
+// ObservePublish records the outcome of publishing a row change event
+func (m *Metrics) ObservePublish(outcome string) {
+	m.published.WithLabelValues(outcome).Inc()
+}
+
+// ObserveConflict records a version of a field that lost to one written
+// concurrently in another region. It satisfies db.ConflictObserver.
+func (m *Metrics) ObserveConflict(ctx context.Context, conflict db.Conflict) {
+	m.conflicts.WithLabelValues(conflict.Winner.Region, conflict.Loser.Region).Inc()
+}
+
+// ObserveReplicationLag records how far behind a replica region is
+func (m *Metrics) ObserveReplicationLag(region string, lag time.Duration) {
+	m.replicationLag.WithLabelValues(region).Set(lag.Seconds())
+}
+
+// StoreObserver returns an observer for a storage layer, such as "dynamo" for
+// dynamo.Client, "db" for db.DynamoDBStore or "store" for a
+// db.InstrumentedStore. It satisfies the Observer interfaces of both
+// packages and db.CallObserver.
+func (m *Metrics) StoreObserver(layer string) *StoreObserver {
+	return &StoreObserver{metrics: m, layer: layer}
+}
+
+// StoreObserver records storage operation latencies, errors, throttling and retries
+type StoreObserver struct {
+	metrics *Metrics
+	layer   string
+}
+
+// ObserveOperation records one storage operation
+func (o *StoreObserver) ObserveOperation(ctx context.Context, operation string, duration time.Duration, err error) {
+	o.metrics.storeDuration.WithLabelValues(o.layer, operation).Observe(duration.Seconds())
+	if err == nil {
+		return
+	}
+	o.metrics.storeErrors.WithLabelValues(o.layer, operation).Inc()
+	if IsThrottle(err) {
+		o.metrics.throttles.WithLabelValues(o.layer, operation).Inc()
+	}
+}
+
+// ObserveStoreCall records one call through a db.InstrumentedStore, with
+// the facts it handled and the capacity it consumed
+func (o *StoreObserver) ObserveStoreCall(ctx context.Context, operation string, duration time.Duration, items int, capacity float64, err error) {
+	o.ObserveOperation(ctx, operation, duration, err)
+	o.metrics.storeItems.WithLabelValues(o.layer, operation).Observe(float64(items))
+	if capacity > 0 {
+		o.metrics.storeCapacity.WithLabelValues(o.layer, operation).Add(capacity)
+	}
+}
+
+// ObserveRetry records a throttled storage operation about to be retried
+func (o *StoreObserver) ObserveRetry(ctx context.Context, operation string, attempt int, delay time.Duration, err error) {
+	o.metrics.storeRetries.WithLabelValues(o.layer, operation).Inc()
+}
+
+// IsThrottle reports whether err is a DynamoDB capacity or rate limit rejection
+func IsThrottle(err error) bool {
+	var provisioned *types.ProvisionedThroughputExceededException
+	var limit *types.RequestLimitExceeded
+	if errors.As(err, &provisioned) || errors.As(err, &limit) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ThrottlingException", "ProvisionedThroughputExceededException", "RequestLimitExceeded":
+			return true
+		}
+	}
+	return false
+}
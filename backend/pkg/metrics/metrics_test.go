@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elibdev/notably/db"
+)
+
+func TestObserveRequest(t *testing.T) {
+	m := New()
+	m.ObserveRequest("GET", "GET /tables", 200, 10*time.Millisecond)
+	m.ObserveRequest("GET", "GET /tables", 200, 20*time.Millisecond)
+	m.ObserveRequest("GET", "", 404, time.Millisecond)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.requests.WithLabelValues("GET", "GET /tables", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("GET", "unmatched", "404")))
+}
+
+func TestStoreObserverCountsThrottles(t *testing.T) {
+	m := New()
+	o := m.StoreObserver("dynamo")
+	ctx := context.Background()
+
+	o.ObserveOperation(ctx, "Query", time.Millisecond, nil)
+	o.ObserveOperation(ctx, "Query", time.Millisecond, errors.New("boom"))
+	throttled := fmt.Errorf("wrapped: %w", &types.ProvisionedThroughputExceededException{Message: aws.String("slow down")})
+	o.ObserveOperation(ctx, "Query", time.Millisecond, throttled)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.storeErrors.WithLabelValues("dynamo", "Query")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.throttles.WithLabelValues("dynamo", "Query")))
+}
+
+func TestIsThrottle(t *testing.T) {
+	assert.True(t, IsThrottle(&types.RequestLimitExceeded{}))
+	assert.False(t, IsThrottle(&types.ResourceNotFoundException{}))
+	assert.False(t, IsThrottle(errors.New("other")))
+}
+
+func TestStoreObserverCountsRetries(t *testing.T) {
+	m := New()
+	o := m.StoreObserver("db")
+
+	o.ObserveRetry(context.Background(), "PutItem", 1, time.Millisecond, &types.RequestLimitExceeded{})
+	o.ObserveRetry(context.Background(), "PutItem", 2, time.Millisecond, &types.RequestLimitExceeded{})
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.storeRetries.WithLabelValues("db", "PutItem")))
+}
+
+func TestStoreObserverRecordsStoreCalls(t *testing.T) {
+	m := New()
+	o := m.StoreObserver("store")
+
+	o.ObserveStoreCall(context.Background(), "QueryByNamespace", time.Millisecond, 40, 2.5, nil)
+	o.ObserveStoreCall(context.Background(), "QueryByNamespace", time.Millisecond, 0, 0.5, errors.New("boom"))
+
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.storeCapacity.WithLabelValues("store", "QueryByNamespace")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.storeErrors.WithLabelValues("store", "QueryByNamespace")))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.storeItems))
+}
+
+func TestObserveConflict(t *testing.T) {
+	m := New()
+	m.ObserveConflict(context.Background(), db.Conflict{Winner: db.Fact{Region: "us-west-2"}, Loser: db.Fact{Region: "us-east-1"}})
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.conflicts.WithLabelValues("us-west-2", "us-east-1")))
+}
@@ -0,0 +1,240 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/elibdev/notably/db"
+)
+
+// Phase is the step of an online migration from one store to another
+type Phase int
+
+const (
+	// PhaseDualWrite writes facts to both stores and reads them from the
+	// old one, while the facts written before are copied to the new one
+	PhaseDualWrite Phase = iota
+	// PhaseDualRead writes facts to both stores and reads them from the new
+	// one, merging in those of the old one it lacks, until the migration is
+	// verified and the old store is retired
+	PhaseDualRead
+)
+
+func (p Phase) String() string {
+	if p == PhaseDualRead {
+		return "dual-read"
+	}
+	return "dual-write"
+}
+
+// ParsePhase returns the phase named s, "dual-write" or "dual-read"
+func ParsePhase(s string) (Phase, error) {
+	switch s {
+	case "", "dual-write":
+		return PhaseDualWrite, nil
+	case "dual-read":
+		return PhaseDualRead, nil
+	}
+	return 0, fmt.Errorf("migrate: unknown phase %q, want dual-write or dual-read", s)
+}
+
+// DualStore is a db.Store migrating from one store to another without
+// downtime. Writes go to the store reads come from first, then to the
+// other; failures of the second write are logged rather than returned, as
+// the fact is stored and the copy that runs alongside, or a later one,
+// brings the stores back in line.
+type DualStore struct {
+	from, to db.Store
+	phase    Phase
+	logger   *slog.Logger
+}
+
+var _ db.Store = (*DualStore)(nil)
+
+// NewDualStore returns a store migrating from one store to another in a
+// phase, logging failed writes to logger, or slog.Default() when nil
+func NewDualStore(from, to db.Store, phase Phase, logger *slog.Logger) *DualStore {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &DualStore{from: from, to: to, phase: phase, logger: logger}
+}
+
+// primary returns the store reads come from and the other one
+func (d *DualStore) primary() (db.Store, db.Store) {
+	if d.phase == PhaseDualRead {
+		return d.to, d.from
+	}
+	return d.from, d.to
+}
+
+// secondaryFailed logs a failed write to the store reads do not come from
+func (d *DualStore) secondaryFailed(ctx context.Context, op string, err error) {
+	d.logger.WarnContext(ctx, "dual write to the secondary store failed", "operation", op, "phase", d.phase.String(), "error", err)
+}
+
+// CreateTable creates the tables of both stores
+func (d *DualStore) CreateTable(ctx context.Context) error {
+	if err := d.from.CreateTable(ctx); err != nil {
+		return err
+	}
+	return d.to.CreateTable(ctx)
+}
+
+// DeleteTable deletes the tables of both stores
+func (d *DualStore) DeleteTable(ctx context.Context) error {
+	if err := d.from.DeleteTable(ctx); err != nil {
+		return err
+	}
+	return d.to.DeleteTable(ctx)
+}
+
+// PutFact stores the fact in both stores
+func (d *DualStore) PutFact(ctx context.Context, fact *db.Fact) error {
+	primary, secondary := d.primary()
+	if err := primary.PutFact(ctx, fact); err != nil {
+		return err
+	}
+	if err := secondary.PutFact(ctx, fact); err != nil {
+		d.secondaryFailed(ctx, "PutFact", err)
+	}
+	return nil
+}
+
+// PutFactsTransactional stores the facts all or none in the primary store,
+// then in the other one
+func (d *DualStore) PutFactsTransactional(ctx context.Context, facts []*db.Fact) error {
+	primary, secondary := d.primary()
+	if err := primary.PutFactsTransactional(ctx, facts); err != nil {
+		return err
+	}
+	if err := secondary.PutFactsTransactional(ctx, facts); err != nil {
+		d.secondaryFailed(ctx, "PutFactsTransactional", err)
+	}
+	return nil
+}
+
+// GetFact returns the latest version of a fact. During PhaseDualRead facts
+// not copied yet are read from the old store.
+func (d *DualStore) GetFact(ctx context.Context, id string) (*db.Fact, error) {
+	primary, _ := d.primary()
+	fact, err := primary.GetFact(ctx, id)
+	if d.phase == PhaseDualRead && db.IsNotFound(err) {
+		return d.from.GetFact(ctx, id)
+	}
+	return fact, err
+}
+
+// DeleteFact stores the same deletion marker in both stores
+func (d *DualStore) DeleteFact(ctx context.Context, id string) error {
+	fact, err := d.GetFact(ctx, id)
+	if err != nil {
+		return &db.StoreError{Operation: "DeleteFact", Err: err}
+	}
+	tombstone := fact.Tombstone(id, time.Now().UTC())
+	return d.PutFact(ctx, &tombstone)
+}
+
+// PurgeFact removes a fact version from both stores
+func (d *DualStore) PurgeFact(ctx context.Context, fact *db.Fact) error {
+	primary, secondary := d.primary()
+	if err := primary.PurgeFact(ctx, fact); err != nil {
+		return err
+	}
+	if err := secondary.PurgeFact(ctx, fact); err != nil && !db.IsNotFound(err) {
+		d.secondaryFailed(ctx, "PurgeFact", err)
+	}
+	return nil
+}
+
+// QueryByField returns the versions of a field
+func (d *DualStore) QueryByField(ctx context.Context, namespace, fieldName string, opts db.QueryOptions) (*db.QueryResult, error) {
+	return d.query(opts, func(s db.Store) (*db.QueryResult, error) {
+		return s.QueryByField(ctx, namespace, fieldName, opts)
+	})
+}
+
+// QueryByTimeRange returns the facts in a time range
+func (d *DualStore) QueryByTimeRange(ctx context.Context, opts db.QueryOptions) (*db.QueryResult, error) {
+	return d.query(opts, func(s db.Store) (*db.QueryResult, error) {
+		return s.QueryByTimeRange(ctx, opts)
+	})
+}
+
+// QueryByNamespace returns the facts of a namespace
+func (d *DualStore) QueryByNamespace(ctx context.Context, namespace string, opts db.QueryOptions) (*db.QueryResult, error) {
+	return d.query(opts, func(s db.Store) (*db.QueryResult, error) {
+		return s.QueryByNamespace(ctx, namespace, opts)
+	})
+}
+
+// QueryByActor returns the facts an actor wrote
+func (d *DualStore) QueryByActor(ctx context.Context, actorUserID string, opts db.QueryOptions) (*db.QueryResult, error) {
+	return d.query(opts, func(s db.Store) (*db.QueryResult, error) {
+		return s.QueryByActor(ctx, actorUserID, opts)
+	})
+}
+
+// query runs a query on the primary store. During PhaseDualRead, queries
+// without a Limit also run on the old store and get the facts only it has;
+// paged queries read the new store alone, as their tokens belong to it.
+func (d *DualStore) query(opts db.QueryOptions, run func(db.Store) (*db.QueryResult, error)) (*db.QueryResult, error) {
+	primary, _ := d.primary()
+	result, err := run(primary)
+	if err != nil || d.phase != PhaseDualRead || opts.Limit != nil || opts.NextToken != nil {
+		return result, err
+	}
+	old, err := run(d.from)
+	if err != nil {
+		return nil, err
+	}
+	result.Facts = mergeFacts(result.Facts, old.Facts, opts.SortAscending)
+	return result, nil
+}
+
+// GetSnapshotAtTime returns the latest version of each field at a time.
+// During PhaseDualRead the snapshots of both stores are merged.
+func (d *DualStore) GetSnapshotAtTime(ctx context.Context, namespace string, at time.Time) (map[string]db.Fact, error) {
+	primary, _ := d.primary()
+	snapshot, err := primary.GetSnapshotAtTime(ctx, namespace, at)
+	if err != nil || d.phase != PhaseDualRead {
+		return snapshot, err
+	}
+	old, err := d.from.GetSnapshotAtTime(ctx, namespace, at)
+	if err != nil {
+		return nil, err
+	}
+	for key, fact := range old {
+		if current, ok := snapshot[key]; !ok || fact.Supersedes(current) {
+			snapshot[key] = fact
+		}
+	}
+	return snapshot, nil
+}
+
+// mergeFacts adds the facts of old missing from facts and orders the result
+// by time
+func mergeFacts(facts, old []db.Fact, ascending bool) []db.Fact {
+	key := func(f db.Fact) string {
+		return f.ID + "#" + f.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	seen := make(map[string]bool, len(facts))
+	for _, f := range facts {
+		seen[key(f)] = true
+	}
+	for _, f := range old {
+		if !seen[key(f)] {
+			facts = append(facts, f)
+		}
+	}
+	sort.SliceStable(facts, func(i, j int) bool {
+		if ascending {
+			return facts[i].Timestamp.Before(facts[j].Timestamp)
+		}
+		return facts[i].Timestamp.After(facts[j].Timestamp)
+	})
+	return facts
+}
@@ -0,0 +1,68 @@
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/db"
+)
+
+func dualFact(id, field string, ts time.Time) *db.Fact {
+	return &db.Fact{ID: id, Timestamp: ts, Namespace: "u1/notes", FieldName: field, DataType: db.DataTypeJSON, Value: json.RawMessage(`{}`)}
+}
+
+func TestDualStorePhases(t *testing.T) {
+	ctx := context.Background()
+	from, to := db.NewMemoryStore(), db.NewMemoryStore()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Facts written before the migration are only in the old store
+	require.NoError(t, from.PutFact(ctx, dualFact("old", "r1", base)))
+
+	dual := NewDualStore(from, to, PhaseDualWrite, nil)
+	require.NoError(t, dual.PutFact(ctx, dualFact("new", "r2", base.Add(time.Minute))))
+	for _, s := range []db.Store{from, to} {
+		_, err := s.GetFact(ctx, "new")
+		require.NoError(t, err, "writes go to both stores")
+	}
+	result, err := dual.QueryByNamespace(ctx, "u1/notes", db.QueryOptions{SortAscending: true})
+	require.NoError(t, err)
+	assert.Len(t, result.Facts, 2, "reads come from the old store")
+
+	dual = NewDualStore(from, to, PhaseDualRead, nil)
+	fact, err := dual.GetFact(ctx, "old")
+	require.NoError(t, err, "facts not copied yet are read from the old store")
+	assert.Equal(t, "r1", fact.FieldName)
+
+	result, err = dual.QueryByNamespace(ctx, "u1/notes", db.QueryOptions{SortAscending: true})
+	require.NoError(t, err)
+	require.Len(t, result.Facts, 2, "both stores are merged without duplicates")
+	assert.Equal(t, "old", result.Facts[0].ID)
+	assert.Equal(t, "new", result.Facts[1].ID)
+
+	snapshot, err := dual.GetSnapshotAtTime(ctx, "u1/notes", base.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, snapshot, 2)
+
+	require.NoError(t, dual.DeleteFact(ctx, "old"))
+	for _, s := range []db.Store{from, to} {
+		deleted, err := s.GetFact(ctx, "old")
+		require.NoError(t, err)
+		assert.True(t, deleted.IsDeleted, "both stores get the deletion marker")
+	}
+}
+
+func TestParsePhase(t *testing.T) {
+	for _, p := range []Phase{PhaseDualWrite, PhaseDualRead} {
+		got, err := ParsePhase(p.String())
+		require.NoError(t, err)
+		assert.Equal(t, p, got)
+	}
+	_, err := ParsePhase("cutover")
+	assert.Error(t, err)
+}
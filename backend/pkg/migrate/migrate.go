@@ -0,0 +1,235 @@
+// Package migrate upgrades facts tables through versioned migrations.
+//
+// Each table records the migrations applied to it in a marker item (see
+// schema.MarkerKey): its current version and, for every migration, when it
+// ran and how many facts it changed. Migrator.Status compares the marker
+// with the migrations a build knows, and Migrator.Up applies those pending
+// in version order, recording each as soon as it completes, so an
+// interrupted run resumes where it stopped. Migrations edit the table in
+// place while servers keep using it and must tolerate running again.
+//
+// Migrations that move facts into a new table instead are run online with
+// DualStore: servers write to both tables while the facts are copied, then
+// read from the new one while the old one remains as a fallback.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/schema"
+)
+
+// ErrConcurrent is returned by Up when another migrator recorded a
+// migration on the table while this one ran
+var ErrConcurrent = errors.New("migrate: table migrated concurrently")
+
+// API is the DynamoDB API migrations use
+type API interface {
+	db.UpgradeAPI
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+}
+
+// Target is the table a migration applies to
+type Target struct {
+	API    API
+	Table  string
+	Schema schema.Schema
+	// Progress, if set, is called with the running count of facts the
+	// migration changed
+	Progress func(updated int)
+}
+
+// Migration is one versioned upgrade of a facts table
+type Migration struct {
+	Version int
+	Name    string
+	// Up applies the migration and returns the number of facts it changed
+	Up func(ctx context.Context, t Target) (int, error)
+}
+
+// Applied is a migration recorded in a table's marker
+type Applied struct {
+	Version   int       `dynamodbav:"Version"`
+	Name      string    `dynamodbav:"Name"`
+	AppliedAt time.Time `dynamodbav:"AppliedAt"`
+	Facts     int       `dynamodbav:"Facts"`
+}
+
+// Status is the migration state of a table
+type Status struct {
+	Table   string
+	Schema  schema.Schema
+	Version int
+	Applied []Applied
+	// Pending are the known migrations newer than Version, in order
+	Pending []Migration
+	// Latest is the version of the newest known migration
+	Latest int
+}
+
+// marker is the item recording a table's migrations
+type marker struct {
+	Version   int       `dynamodbav:"Version"`
+	UpdatedAt time.Time `dynamodbav:"UpdatedAt"`
+	History   []Applied `dynamodbav:"History"`
+}
+
+// Migrator applies migrations to one table
+type Migrator struct {
+	api        API
+	table      string
+	migrations []Migration
+	now        func() time.Time
+
+	// Progress, if set, is called as migrations change facts
+	Progress func(m Migration, updated int)
+}
+
+// New returns a migrator of table applying migrations, which must have
+// distinct positive versions
+func New(api API, table string, migrations []Migration) (*Migrator, error) {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Version <= 0 {
+			return nil, fmt.Errorf("migrate: migration %q has version %d, want a positive one", m.Name, m.Version)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("migrate: migrations %q and %q share version %d", sorted[i-1].Name, m.Name, m.Version)
+		}
+	}
+	return &Migrator{api: api, table: table, migrations: sorted, now: time.Now}, nil
+}
+
+// Status returns the migration state of the table
+func (m *Migrator) Status(ctx context.Context) (*Status, error) {
+	current, err := m.schema(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mark, err := m.marker(ctx, current)
+	if err != nil {
+		return nil, err
+	}
+	status := &Status{Table: m.table, Schema: current, Version: mark.Version, Applied: mark.History}
+	for _, mig := range m.migrations {
+		if mig.Version > mark.Version {
+			status.Pending = append(status.Pending, mig)
+		}
+		status.Latest = mig.Version
+	}
+	return status, nil
+}
+
+// Up applies the pending migrations up to version to, or all of them when
+// to is 0, and returns those it applied. A table at a version newer than
+// the migrator knows is left alone.
+func (m *Migrator) Up(ctx context.Context, to int) ([]Applied, error) {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if status.Version > status.Latest {
+		return nil, fmt.Errorf("migrate: table %s is at version %d, newer than the latest known %d", m.table, status.Version, status.Latest)
+	}
+
+	var applied []Applied
+	version := status.Version
+	for _, mig := range status.Pending {
+		if to > 0 && mig.Version > to {
+			break
+		}
+		target := Target{API: m.api, Table: m.table, Schema: status.Schema}
+		if m.Progress != nil {
+			mig := mig
+			target.Progress = func(n int) { m.Progress(mig, n) }
+		}
+		n, err := mig.Up(ctx, target)
+		if err != nil {
+			return applied, fmt.Errorf("migrate: %d %s: %w", mig.Version, mig.Name, err)
+		}
+		record := Applied{Version: mig.Version, Name: mig.Name, AppliedAt: m.now().UTC(), Facts: n}
+		if err := m.record(ctx, status.Schema, version, record); err != nil {
+			return applied, err
+		}
+		applied = append(applied, record)
+		version = mig.Version
+	}
+	return applied, nil
+}
+
+// schema returns the schema of the table
+func (m *Migrator) schema(ctx context.Context) (schema.Schema, error) {
+	out, err := m.api.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(m.table)})
+	if err != nil {
+		return 0, fmt.Errorf("migrate: describe %s: %w", m.table, err)
+	}
+	current, err := schema.Detect(out.Table)
+	if err != nil {
+		return 0, fmt.Errorf("migrate: %w", err)
+	}
+	return current, nil
+}
+
+// marker returns the marker of the table, at version 0 when it has none
+func (m *Migrator) marker(ctx context.Context, s schema.Schema) (marker, error) {
+	var mark marker
+	out, err := m.api.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(m.table),
+		Key:            s.MarkerKey(),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return mark, fmt.Errorf("migrate: read marker of %s: %w", m.table, err)
+	}
+	if err := attributevalue.UnmarshalMap(out.Item, &mark); err != nil {
+		return mark, fmt.Errorf("migrate: read marker of %s: %w", m.table, err)
+	}
+	return mark, nil
+}
+
+// record moves the marker from version prev to the version of an applied
+// migration, failing with ErrConcurrent when it is no longer at prev
+func (m *Migrator) record(ctx context.Context, s schema.Schema, prev int, applied Applied) error {
+	entry, err := attributevalue.Marshal(applied)
+	if err != nil {
+		return fmt.Errorf("migrate: record %d: %w", applied.Version, err)
+	}
+	condition := "#v = :prev"
+	if prev == 0 {
+		condition = "attribute_not_exists(#v) OR #v = :prev"
+	}
+	_, err = m.api.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                aws.String(m.table),
+		Key:                      s.MarkerKey(),
+		UpdateExpression:         aws.String("SET #v = :v, #at = :at, #h = list_append(if_not_exists(#h, :empty), :entry)"),
+		ConditionExpression:      aws.String(condition),
+		ExpressionAttributeNames: map[string]string{"#v": "Version", "#at": "UpdatedAt", "#h": "History"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":v":     &types.AttributeValueMemberN{Value: strconv.Itoa(applied.Version)},
+			":prev":  &types.AttributeValueMemberN{Value: strconv.Itoa(prev)},
+			":at":    &types.AttributeValueMemberS{Value: applied.AppliedAt.Format(time.RFC3339Nano)},
+			":empty": &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
+			":entry": &types.AttributeValueMemberL{Value: []types.AttributeValue{entry}},
+		},
+	})
+	var moved *types.ConditionalCheckFailedException
+	if errors.As(err, &moved) {
+		return fmt.Errorf("%w: %s is no longer at version %d", ErrConcurrent, m.table, prev)
+	}
+	if err != nil {
+		return fmt.Errorf("migrate: record %d: %w", applied.Version, err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/schema"
+)
+
+// markerAPI is a store table holding only its marker, recorded the way
+// DynamoDB applies the migrator's updates
+type markerAPI struct {
+	version int
+	history []types.AttributeValue
+}
+
+func (f *markerAPI) DescribeTable(ctx context.Context, in *dynamodb.DescribeTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	def := schema.Definition("Facts", schema.Store)
+	desc := &types.TableDescription{TableName: in.TableName, KeySchema: def.KeySchema}
+	for _, gsi := range def.GlobalSecondaryIndexes {
+		desc.GlobalSecondaryIndexes = append(desc.GlobalSecondaryIndexes, types.GlobalSecondaryIndexDescription{IndexName: gsi.IndexName, IndexStatus: types.IndexStatusActive})
+	}
+	return &dynamodb.DescribeTableOutput{Table: desc}, nil
+}
+
+func (f *markerAPI) GetItem(ctx context.Context, in *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	if f.version == 0 {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	item := schema.Store.MarkerKey()
+	item["Version"] = &types.AttributeValueMemberN{Value: strconv.Itoa(f.version)}
+	item["History"] = &types.AttributeValueMemberL{Value: f.history}
+	return &dynamodb.GetItemOutput{Item: item}, nil
+}
+
+func (f *markerAPI) Scan(ctx context.Context, in *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	return &dynamodb.ScanOutput{}, nil
+}
+
+func (f *markerAPI) UpdateItem(ctx context.Context, in *dynamodb.UpdateItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	prev, _ := strconv.Atoi(in.ExpressionAttributeValues[":prev"].(*types.AttributeValueMemberN).Value)
+	if prev != f.version {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	f.version, _ = strconv.Atoi(in.ExpressionAttributeValues[":v"].(*types.AttributeValueMemberN).Value)
+	f.history = append(f.history, in.ExpressionAttributeValues[":entry"].(*types.AttributeValueMemberL).Value...)
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (f *markerAPI) UpdateTable(ctx context.Context, in *dynamodb.UpdateTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateTableOutput, error) {
+	return &dynamodb.UpdateTableOutput{}, nil
+}
+
+// counting returns a migration that counts its runs
+func counting(version int, runs *[]int) Migration {
+	return Migration{Version: version, Name: "m" + strconv.Itoa(version), Up: func(ctx context.Context, t Target) (int, error) {
+		*runs = append(*runs, version)
+		if t.Progress != nil {
+			t.Progress(version * 10)
+		}
+		return version * 10, nil
+	}}
+}
+
+func TestMigratorUp(t *testing.T) {
+	ctx := context.Background()
+	api := &markerAPI{}
+	var runs []int
+	m, err := New(api, "Facts", []Migration{counting(3, &runs), counting(1, &runs), counting(2, &runs)})
+	require.NoError(t, err)
+	m.now = func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) }
+	var progress []string
+	m.Progress = func(mig Migration, n int) { progress = append(progress, mig.Name+":"+strconv.Itoa(n)) }
+
+	status, err := m.Status(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, schema.Store, status.Schema)
+	assert.Equal(t, 0, status.Version)
+	assert.Equal(t, 3, status.Latest)
+	assert.Len(t, status.Pending, 3)
+
+	applied, err := m.Up(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, runs, "migrations run in version order up to the target")
+	require.Len(t, applied, 2)
+	assert.Equal(t, Applied{Version: 2, Name: "m2", AppliedAt: m.now(), Facts: 20}, applied[1])
+	assert.Equal(t, []string{"m1:10", "m2:20"}, progress)
+
+	status, err = m.Status(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, status.Version)
+	assert.Equal(t, applied, status.Applied)
+	require.Len(t, status.Pending, 1)
+	assert.Equal(t, 3, status.Pending[0].Version)
+
+	applied, err = m.Up(ctx, 0)
+	require.NoError(t, err)
+	assert.Len(t, applied, 1)
+	assert.Equal(t, []int{1, 2, 3}, runs)
+
+	applied, err = m.Up(ctx, 0)
+	require.NoError(t, err)
+	assert.Empty(t, applied, "applied migrations do not run again")
+}
+
+func TestMigratorRefusesNewerAndConcurrentTables(t *testing.T) {
+	ctx := context.Background()
+	var runs []int
+
+	api := &markerAPI{version: 5}
+	m, err := New(api, "Facts", []Migration{counting(1, &runs)})
+	require.NoError(t, err)
+	_, err = m.Up(ctx, 0)
+	assert.EqualError(t, err, "migrate: table Facts is at version 5, newer than the latest known 1")
+	assert.Empty(t, runs)
+
+	// Another migrator moves the marker while this one runs
+	api = &markerAPI{}
+	m, err = New(api, "Facts", []Migration{{Version: 1, Name: "racy", Up: func(ctx context.Context, t Target) (int, error) {
+		api.version = 1
+		return 0, nil
+	}}})
+	require.NoError(t, err)
+	_, err = m.Up(ctx, 0)
+	assert.True(t, errors.Is(err, ErrConcurrent), err)
+}
+
+func TestNewRejectsBadVersions(t *testing.T) {
+	var runs []int
+	_, err := New(&markerAPI{}, "Facts", []Migration{counting(1, &runs), counting(1, &runs)})
+	assert.EqualError(t, err, `migrate: migrations "m1" and "m1" share version 1`)
+	_, err = New(&markerAPI{}, "Facts", []Migration{counting(0, &runs)})
+	assert.Error(t, err)
+}
+
+func TestMigrationsHaveIncreasingVersions(t *testing.T) {
+	for i, m := range Migrations(dynamo.TableOptions{}) {
+		assert.Equal(t, i+1, m.Version, m.Name)
+	}
+}
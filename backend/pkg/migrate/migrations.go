@@ -0,0 +1,56 @@
+package migrate
+
+import (
+	"context"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/schema"
+)
+
+// Migrations returns the migrations of facts tables, in version order.
+// opts are the table options of indexes they add. New migrations are
+// appended with the next version; released ones never change.
+func Migrations(opts dynamo.TableOptions) []Migration {
+	return []Migration{
+		{
+			Version: 1,
+			Name:    "namespace-index",
+			Up: func(ctx context.Context, t Target) (int, error) {
+				// Server tables are partitioned by namespace already
+				if t.Schema == schema.Namespace {
+					return 0, nil
+				}
+				return db.AddNamespaceIndex(ctx, t.API, t.Table, opts, t.Progress)
+			},
+		},
+		{
+			Version: 2,
+			Name:    "typed-values",
+			Up: func(ctx context.Context, t Target) (int, error) {
+				return db.BackfillValues(ctx, t.API, t.Table, t.Progress)
+			},
+		},
+		{
+			Version: 3,
+			Name:    "tombstones",
+			Up: func(ctx context.Context, t Target) (int, error) {
+				return db.BackfillTombstones(ctx, t.API, t.Table, t.Progress)
+			},
+		},
+		{
+			Version: 4,
+			Name:    "actor-index",
+			Up: func(ctx context.Context, t Target) (int, error) {
+				return 0, db.AddActorIndex(ctx, t.API, t.Table, opts)
+			},
+		},
+		{
+			Version: 5,
+			Name:    "escaped-keys",
+			Up: func(ctx context.Context, t Target) (int, error) {
+				return db.RekeyEscapedFields(ctx, t.API, t.Table, t.Progress)
+			},
+		},
+	}
+}
@@ -0,0 +1,157 @@
+// Package naming checks the names users give tables, columns, views and the
+// other resources addressed by name in API paths.
+//
+// Names are made of ASCII letters, digits, hyphens and underscores, so they
+// are safe in URL paths and in the sort keys and index fields built from
+// them. A Policy adds length limits, reserved prefixes and case rules on top.
+package naming
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Case is how a policy treats upper-case letters
+type Case string
+
+const (
+	// CasePreserve accepts names in any case and keeps them as given; names
+	// differing only in case are different names
+	CasePreserve Case = "preserve"
+	// CaseLower accepts only lower-case names, so no two names can differ
+	// only in case
+	CaseLower Case = "lower"
+)
+
+// ParseCase returns the case rule named s, "preserve" or "lower"
+func ParseCase(s string) (Case, error) {
+	switch c := Case(s); c {
+	case "", CasePreserve:
+		return CasePreserve, nil
+	case CaseLower:
+		return c, nil
+	}
+	return "", fmt.Errorf("naming: unknown case rule %q, want preserve or lower", s)
+}
+
+// Policy is the set of rules names must follow
+type Policy struct {
+	// MinLength and MaxLength bound the length of names in characters
+	MinLength int
+	MaxLength int
+	// ReservedPrefixes lists prefixes names may not start with. Nil uses
+	// the default; an empty slice reserves none.
+	ReservedPrefixes []string
+	// Case is how upper-case letters are treated
+	Case Case
+}
+
+// DefaultPolicy is used for any zero field of a Policy value. The "_"
+// prefix is reserved for system columns such as _createdAt.
+var DefaultPolicy = Policy{
+	MinLength:        1,
+	MaxLength:        64,
+	ReservedPrefixes: []string{"_"},
+	Case:             CasePreserve,
+}
+
+// withDefaults fills the zero fields of p from DefaultPolicy
+func (p Policy) withDefaults() Policy {
+	if p.MinLength == 0 {
+		p.MinLength = DefaultPolicy.MinLength
+	}
+	if p.MaxLength == 0 {
+		p.MaxLength = DefaultPolicy.MaxLength
+	}
+	if p.ReservedPrefixes == nil {
+		p.ReservedPrefixes = DefaultPolicy.ReservedPrefixes
+	}
+	if p.Case == "" {
+		p.Case = DefaultPolicy.Case
+	}
+	return p
+}
+
+// Validate reports a policy that no name could satisfy or that makes
+// invalid names valid
+func (p Policy) Validate() error {
+	p = p.withDefaults()
+	if p.MinLength < 1 || p.MaxLength < p.MinLength {
+		return fmt.Errorf("naming: lengths must satisfy 1 <= min <= max, got min %d and max %d", p.MinLength, p.MaxLength)
+	}
+	for _, prefix := range p.ReservedPrefixes {
+		if prefix == "" || !validChars(prefix) {
+			return fmt.Errorf("naming: reserved prefix %q must be a non-empty run of name characters", prefix)
+		}
+	}
+	if _, err := ParseCase(string(p.Case)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Error is a name a policy rejects
+type Error struct {
+	Name string
+	// Reason completes a sentence about the name, such as "must be at most
+	// 64 characters"
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("name %q %s", e.Name, e.Reason)
+}
+
+// Check returns an *Error if name breaks the policy
+func (p Policy) Check(name string) error {
+	p = p.withDefaults()
+	switch {
+	case name == "":
+		return &Error{Name: name, Reason: "is required"}
+	case !validChars(name):
+		return &Error{Name: name, Reason: "must contain only alphanumeric characters, hyphens, and underscores"}
+	case len(name) < p.MinLength:
+		return &Error{Name: name, Reason: fmt.Sprintf("must be at least %d characters", p.MinLength)}
+	case len(name) > p.MaxLength:
+		return &Error{Name: name, Reason: fmt.Sprintf("must be at most %d characters", p.MaxLength)}
+	case p.Case == CaseLower && strings.ToLower(name) != name:
+		return &Error{Name: name, Reason: "must be lower case"}
+	}
+	for _, prefix := range p.ReservedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return &Error{Name: name, Reason: fmt.Sprintf("must not start with the reserved prefix '%s'", prefix)}
+		}
+	}
+	return nil
+}
+
+// Valid reports whether name follows the policy
+func (p Policy) Valid(name string) bool {
+	return p.Check(name) == nil
+}
+
+// Reason returns why a policy rejects name, or "" if it does not
+func (p Policy) Reason(name string) string {
+	if err, ok := p.Check(name).(*Error); ok {
+		return err.Reason
+	}
+	return ""
+}
+
+// ValidSyntax reports whether name is non-empty and made of name
+// characters, the rule of every policy. It suits names that refer to
+// resources which may predate the current policy.
+func ValidSyntax(name string) bool {
+	return name != "" && validChars(name)
+}
+
+// validChars reports whether s holds only ASCII letters, digits, hyphens
+// and underscores
+func validChars(s string) bool {
+	for _, r := range s {
+		if !(('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
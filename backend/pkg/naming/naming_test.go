@@ -0,0 +1,73 @@
+package naming
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	var p Policy
+	for _, name := range []string{"a", "orders", "Order_Items-2", strings.Repeat("x", 64)} {
+		assert.NoError(t, p.Check(name), name)
+	}
+	for name, reason := range map[string]string{
+		"":                      "is required",
+		"has space":             "must contain only alphanumeric characters, hyphens, and underscores",
+		"slash/y":               "must contain only alphanumeric characters, hyphens, and underscores",
+		"ünicode":               "must contain only alphanumeric characters, hyphens, and underscores",
+		strings.Repeat("x", 65): "must be at most 64 characters",
+		"_createdAt":            "must not start with the reserved prefix '_'",
+	} {
+		err := p.Check(name)
+		var nameErr *Error
+		require.ErrorAs(t, err, &nameErr, name)
+		assert.Equal(t, reason, nameErr.Reason, name)
+		assert.Equal(t, reason, p.Reason(name))
+		assert.False(t, p.Valid(name))
+	}
+	assert.Equal(t, "", p.Reason("ok"))
+	assert.EqualError(t, p.Check("a b"), `name "a b" must contain only alphanumeric characters, hyphens, and underscores`)
+}
+
+func TestConfiguredPolicy(t *testing.T) {
+	p := Policy{MinLength: 3, MaxLength: 8, ReservedPrefixes: []string{"sys-", "tmp"}, Case: CaseLower}
+	require.NoError(t, p.Validate())
+	assert.True(t, p.Valid("abc"))
+	assert.True(t, p.Valid("_under"), "configured prefixes replace the default")
+	assert.Equal(t, "must be at least 3 characters", p.Reason("ab"))
+	assert.Equal(t, "must be at most 8 characters", p.Reason("abcdefghi"))
+	assert.Equal(t, "must be lower case", p.Reason("Orders"))
+	assert.Equal(t, "must not start with the reserved prefix 'sys-'", p.Reason("sys-log"))
+	assert.Equal(t, "must not start with the reserved prefix 'tmp'", p.Reason("tmpfile"))
+
+	none := Policy{ReservedPrefixes: []string{}}
+	assert.True(t, none.Valid("_x"), "an empty list reserves no prefixes")
+}
+
+func TestPolicyValidate(t *testing.T) {
+	assert.NoError(t, Policy{}.Validate())
+	assert.Error(t, Policy{MinLength: -1}.Validate())
+	assert.Error(t, Policy{MinLength: 10, MaxLength: 5}.Validate())
+	assert.Error(t, Policy{ReservedPrefixes: []string{""}}.Validate())
+	assert.Error(t, Policy{ReservedPrefixes: []string{"a/"}}.Validate())
+	assert.Error(t, Policy{Case: "upper"}.Validate())
+}
+
+func TestParseCase(t *testing.T) {
+	for in, want := range map[string]Case{"": CasePreserve, "preserve": CasePreserve, "lower": CaseLower} {
+		got, err := ParseCase(in)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err := ParseCase("Lower")
+	assert.Error(t, err)
+}
+
+func TestValidSyntax(t *testing.T) {
+	assert.True(t, ValidSyntax("_legacy-"+strings.Repeat("x", 100)))
+	assert.False(t, ValidSyntax(""))
+	assert.False(t, ValidSyntax("a.b"))
+}
@@ -0,0 +1,209 @@
+// Package plugin lets server extensions be compiled into the binary without
+// forking pkg/server.
+//
+// A plugin registers itself from an init function, like a database/sql
+// driver, and is enabled by blank-importing its package from main:
+//
+//	import _ "example.com/notably-geo"
+//
+// Each plugin declares the capabilities it needs in its Manifest. The server
+// only uses the capabilities a plugin declares, and rejects plugins built
+// against a different APIVersion or declaring capabilities they do not
+// implement. Plugins run in-process, so isolation is cooperative: routes are
+// mounted under /plugins/<name>/, panics in plugin code are recovered and
+// reported as errors, validators run under a deadline, and event consumers
+// receive events asynchronously through bounded queues so a slow consumer
+// cannot stall writes.
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// APIVersion is the version of the plugin API implemented by this server.
+// It changes whenever an interface in this package changes incompatibly.
+const APIVersion = 1
+
+// Capability names one kind of server extension
+type Capability string
+
+const (
+	// CapRoutes adds HTTP routes; the plugin must implement RouteProvider
+	CapRoutes Capability = "routes"
+	// CapColumnTypes adds column data types; the plugin must implement ColumnTypeProvider
+	CapColumnTypes Capability = "column-types"
+	// CapValidators validates row writes; the plugin must implement Validator
+	CapValidators Capability = "validators"
+	// CapEvents receives row events; the plugin must implement EventConsumer
+	CapEvents Capability = "events"
+)
+
+// Manifest describes a plugin and what it needs from the server
+type Manifest struct {
+	Name         string
+	Version      string
+	APIVersion   int
+	Capabilities []Capability
+}
+
+// Plugin is implemented by every server extension
+type Plugin interface {
+	Manifest() Manifest
+	// Init is called once when the server starts, before any other method
+	Init(ctx context.Context, host Host) error
+}
+
+// Host is what the server offers a plugin during Init
+type Host struct {
+	// Logger is tagged with the plugin name
+	Logger *slog.Logger
+	// Capabilities are the capabilities granted to the plugin
+	Capabilities []Capability
+}
+
+// Route is an HTTP route contributed by a plugin
+type Route struct {
+	Method string
+	// Path is relative to /plugins/<name> and may use ServeMux wildcards
+	Path    string
+	Handler http.Handler
+	// Public routes skip API key authentication
+	Public bool
+}
+
+// RouteProvider contributes HTTP routes
+type RouteProvider interface {
+	Routes() []Route
+}
+
+// ColumnType is a column data type contributed by a plugin
+type ColumnType struct {
+	Name string
+	// Description, if set, tells clients which values the type accepts
+	Description string
+	// Valid reports whether a decoded JSON value is acceptable for the type
+	Valid func(value interface{}) bool
+}
+
+// ColumnTypeProvider contributes column data types
+type ColumnTypeProvider interface {
+	ColumnTypes() []ColumnType
+}
+
+// RowEvent describes a row write
+type RowEvent struct {
+	Type      string // "create", "update", "delete" or "expire"
+	UserID    string
+	Table     string
+	Row       string
+	Timestamp time.Time
+	Values    map[string]interface{} // nil for deletes and, for consumers, rows of secrets tables
+}
+
+// Validator may reject row writes before they are stored
+type Validator interface {
+	ValidateRow(ctx context.Context, ev RowEvent) error
+}
+
+// EventConsumer receives row events after they are stored
+type EventConsumer interface {
+	ConsumeEvent(ctx context.Context, ev RowEvent)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]Plugin)
+)
+
+// namePattern restricts plugin names to what is safe in a URL path segment
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Register makes a plugin available to the server. It panics if the name is
+// invalid or already registered, and is meant to be called from init.
+func Register(p Plugin) {
+	name := p.Manifest().Name
+	if !namePattern.MatchString(name) {
+		panic(fmt.Sprintf("plugin: invalid plugin name %q", name))
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[name]; dup {
+		panic(fmt.Sprintf("plugin: Register called twice for plugin %q", name))
+	}
+	registry[name] = p
+}
+
+// Registered returns the sorted names of the registered plugins
+func Registered() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookup returns a registered plugin by name
+func lookup(name string) (Plugin, bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	p, ok := registry[name]
+	return p, ok
+}
+
+// negotiate checks a manifest against this server and the plugin's
+// implementation, returning the capabilities to grant
+func negotiate(p Plugin) ([]Capability, error) {
+	m := p.Manifest()
+	if m.APIVersion != APIVersion {
+		return nil, fmt.Errorf("plugin %s targets plugin API v%d, server implements v%d", m.Name, m.APIVersion, APIVersion)
+	}
+
+	seen := make(map[Capability]bool)
+	var granted []Capability
+	for _, c := range m.Capabilities {
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+
+		var ok bool
+		switch c {
+		case CapRoutes:
+			_, ok = p.(RouteProvider)
+		case CapColumnTypes:
+			_, ok = p.(ColumnTypeProvider)
+		case CapValidators:
+			_, ok = p.(Validator)
+		case CapEvents:
+			_, ok = p.(EventConsumer)
+		default:
+			return nil, fmt.Errorf("plugin %s requests unknown capability %q", m.Name, c)
+		}
+		if !ok {
+			return nil, fmt.Errorf("plugin %s declares capability %q but does not implement it", m.Name, c)
+		}
+		granted = append(granted, c)
+	}
+	return granted, nil
+}
+
+// has reports whether caps contains c
+func has(caps []Capability, c Capability) bool {
+	for _, x := range caps {
+		if x == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fake implements every capability; its manifest decides which are used
+type fake struct {
+	manifest Manifest
+	inited   bool
+	events   chan RowEvent
+	validate func(RowEvent) error
+}
+
+func (f *fake) Manifest() Manifest { return f.manifest }
+
+func (f *fake) Init(ctx context.Context, host Host) error {
+	f.inited = true
+	return nil
+}
+
+func (f *fake) Routes() []Route {
+	return []Route{
+		{Method: "GET", Path: "/hello", Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello"))
+		})},
+		{Method: "GET", Path: "/panic", Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		})},
+	}
+}
+
+func (f *fake) ColumnTypes() []ColumnType {
+	return []ColumnType{{Name: f.manifest.Name + "-even", Valid: func(v interface{}) bool {
+		n, ok := v.(float64)
+		return ok && int(n)%2 == 0
+	}}}
+}
+
+func (f *fake) ValidateRow(ctx context.Context, ev RowEvent) error {
+	if f.validate != nil {
+		return f.validate(ev)
+	}
+	return nil
+}
+
+func (f *fake) ConsumeEvent(ctx context.Context, ev RowEvent) {
+	f.events <- ev
+}
+
+// onlyInit implements no optional interfaces
+type onlyInit struct{ manifest Manifest }
+
+func (o onlyInit) Manifest() Manifest               { return o.manifest }
+func (o onlyInit) Init(context.Context, Host) error { return nil }
+
+func register(t *testing.T, p Plugin) string {
+	t.Helper()
+	Register(p)
+	name := p.Manifest().Name
+	t.Cleanup(func() {
+		registryMu.Lock()
+		delete(registry, name)
+		registryMu.Unlock()
+	})
+	return name
+}
+
+func TestRegisterRejectsDuplicatesAndBadNames(t *testing.T) {
+	register(t, onlyInit{Manifest{Name: "dup", APIVersion: APIVersion}})
+	assert.Panics(t, func() { Register(onlyInit{Manifest{Name: "dup", APIVersion: APIVersion}}) })
+	assert.Panics(t, func() { Register(onlyInit{Manifest{Name: "Bad/Name", APIVersion: APIVersion}}) })
+	assert.Contains(t, Registered(), "dup")
+}
+
+func TestLoadNegotiation(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := Load(ctx, []string{"missing"}, nil, nil)
+	assert.Error(t, err)
+
+	old := register(t, onlyInit{Manifest{Name: "old", APIVersion: APIVersion + 1}})
+	_, err = Load(ctx, []string{old}, nil, nil)
+	assert.ErrorContains(t, err, "plugin API")
+
+	liar := register(t, onlyInit{Manifest{Name: "liar", APIVersion: APIVersion, Capabilities: []Capability{CapRoutes}}})
+	_, err = Load(ctx, []string{liar}, nil, nil)
+	assert.ErrorContains(t, err, "does not implement")
+
+	odd := register(t, onlyInit{Manifest{Name: "odd", APIVersion: APIVersion, Capabilities: []Capability{"telepathy"}}})
+	_, err = Load(ctx, []string{odd}, nil, nil)
+	assert.ErrorContains(t, err, "unknown capability")
+
+	clash := &fake{manifest: Manifest{Name: "clash", APIVersion: APIVersion, Capabilities: []Capability{CapColumnTypes}}}
+	register(t, clash)
+	_, err = Load(ctx, []string{"clash"}, []string{"clash-even"}, nil)
+	assert.ErrorContains(t, err, "already defined")
+}
+
+func TestCapabilitiesAreGatedByManifest(t *testing.T) {
+	// Implements everything but only declares routes
+	p := &fake{manifest: Manifest{Name: "routes-only", APIVersion: APIVersion, Capabilities: []Capability{CapRoutes}}}
+	register(t, p)
+
+	set, err := Load(context.Background(), []string{"routes-only"}, nil, nil)
+	require.NoError(t, err)
+	defer set.Close()
+
+	assert.True(t, p.inited)
+	assert.Len(t, set.Routes(), 2)
+	_, ok := set.ColumnType("routes-only-even")
+	assert.False(t, ok)
+	p.validate = func(RowEvent) error { return errors.New("never called") }
+	assert.NoError(t, set.Validate(context.Background(), RowEvent{}))
+}
+
+func TestSetDispatch(t *testing.T) {
+	p := &fake{
+		manifest: Manifest{Name: "all", APIVersion: APIVersion, Capabilities: []Capability{CapRoutes, CapColumnTypes, CapValidators, CapEvents}},
+		events:   make(chan RowEvent, 1),
+	}
+	register(t, p)
+
+	set, err := Load(context.Background(), []string{"all"}, nil, nil)
+	require.NoError(t, err)
+	defer set.Close()
+
+	routes := set.Routes()
+	require.Len(t, routes, 2)
+	assert.Equal(t, "GET /plugins/all/hello", routes[0].Pattern)
+
+	rec := httptest.NewRecorder()
+	routes[1].Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/plugins/all/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	ct, ok := set.ColumnType("all-even")
+	require.True(t, ok)
+	assert.True(t, ct.Valid(float64(2)))
+	assert.False(t, ct.Valid(float64(3)))
+	assert.False(t, ct.Valid("two"))
+	types := set.ColumnTypes()
+	require.Len(t, types, 1)
+	assert.Equal(t, "all", types[0].Plugin)
+	assert.Equal(t, "all-even", types[0].Name)
+
+	p.validate = func(ev RowEvent) error {
+		if ev.Row == "bad" {
+			return errors.New("no bad rows")
+		}
+		if ev.Row == "panic" {
+			panic("boom")
+		}
+		return nil
+	}
+	assert.NoError(t, set.Validate(context.Background(), RowEvent{Row: "good"}))
+	var verr *ValidationError
+	require.ErrorAs(t, set.Validate(context.Background(), RowEvent{Row: "bad"}), &verr)
+	assert.Equal(t, "all", verr.Plugin)
+	assert.Error(t, set.Validate(context.Background(), RowEvent{Row: "panic"}))
+
+	set.Publish(RowEvent{Type: "create", Row: "r1"})
+	select {
+	case ev := <-p.events:
+		assert.Equal(t, "r1", ev.Row)
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered")
+	}
+}
+
+func TestNilSet(t *testing.T) {
+	var set *Set
+	assert.Nil(t, set.Routes())
+	assert.NoError(t, set.Validate(context.Background(), RowEvent{}))
+	set.Publish(RowEvent{})
+	set.Close()
+}
@@ -0,0 +1,321 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// validateTimeout bounds a single plugin validator call
+	validateTimeout = 2 * time.Second
+	// eventQueueSize is the number of events buffered per consumer
+	eventQueueSize = 256
+)
+
+// loaded is an initialized plugin and the capabilities granted to it
+type loaded struct {
+	name   string
+	plugin Plugin
+	caps   []Capability
+	logger *slog.Logger
+	events chan RowEvent
+}
+
+// Set is the group of plugins enabled on a server. A nil *Set has no plugins.
+type Set struct {
+	plugins     []*loaded
+	columnTypes map[string]ColumnType
+	typeOwners  map[string]string // plugin defining each column type
+
+	// OnDrop is called when an event is dropped because a consumer's queue is full
+	OnDrop func(plugin string)
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Load negotiates capabilities with and initializes the named plugins, or all
+// registered plugins when names is nil. reserved lists built-in column types
+// that plugins may not redefine.
+func Load(ctx context.Context, names []string, reserved []string, logger *slog.Logger) (*Set, error) {
+	if names == nil {
+		names = Registered()
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	taken := make(map[string]string)
+	for _, t := range reserved {
+		taken[t] = "the server"
+	}
+
+	set := &Set{columnTypes: make(map[string]ColumnType), typeOwners: make(map[string]string)}
+	for _, name := range names {
+		p, ok := lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("plugin %s is not registered", name)
+		}
+		caps, err := negotiate(p)
+		if err != nil {
+			return nil, err
+		}
+
+		l := &loaded{name: name, plugin: p, caps: caps, logger: logger.With("plugin", name)}
+		if err := guard(name, "Init", func() error {
+			return p.Init(ctx, Host{Logger: l.logger, Capabilities: caps})
+		}); err != nil {
+			return nil, err
+		}
+
+		if has(caps, CapColumnTypes) {
+			for _, ct := range p.(ColumnTypeProvider).ColumnTypes() {
+				if owner, dup := taken[ct.Name]; dup {
+					return nil, fmt.Errorf("plugin %s: column type %q is already defined by %s", name, ct.Name, owner)
+				}
+				if ct.Valid == nil {
+					return nil, fmt.Errorf("plugin %s: column type %q has no Valid func", name, ct.Name)
+				}
+				taken[ct.Name] = "plugin " + name
+				set.columnTypes[ct.Name] = guardedColumnType(l, ct)
+				set.typeOwners[ct.Name] = name
+			}
+		}
+		if has(caps, CapEvents) {
+			l.events = make(chan RowEvent, eventQueueSize)
+		}
+		set.plugins = append(set.plugins, l)
+	}
+
+	var consumeCtx context.Context
+	consumeCtx, set.cancel = context.WithCancel(context.Background())
+	for _, l := range set.plugins {
+		if l.events != nil {
+			set.wg.Add(1)
+			go set.consume(consumeCtx, l)
+		}
+	}
+	return set, nil
+}
+
+// Names returns the names of the loaded plugins
+func (s *Set) Names() []string {
+	if s == nil {
+		return nil
+	}
+	names := make([]string, len(s.plugins))
+	for i, l := range s.plugins {
+		names[i] = l.name
+	}
+	return names
+}
+
+// MountedRoute is a plugin route with its full ServeMux pattern
+type MountedRoute struct {
+	Plugin  string
+	Pattern string
+	Handler http.Handler
+	Public  bool
+}
+
+// Routes returns the routes of every plugin granted CapRoutes, mounted under
+// /plugins/<name>. Handlers recover from panics and respond 500.
+func (s *Set) Routes() []MountedRoute {
+	if s == nil {
+		return nil
+	}
+	var routes []MountedRoute
+	for _, l := range s.plugins {
+		if !has(l.caps, CapRoutes) {
+			continue
+		}
+		for _, r := range l.plugin.(RouteProvider).Routes() {
+			pattern := fmt.Sprintf("/plugins/%s%s", l.name, r.Path)
+			if r.Method != "" {
+				pattern = r.Method + " " + pattern
+			}
+			routes = append(routes, MountedRoute{
+				Plugin:  l.name,
+				Pattern: pattern,
+				Handler: recoverHandler(l, r.Handler),
+				Public:  r.Public,
+			})
+		}
+	}
+	return routes
+}
+
+// ColumnType returns the plugin column type with the given name
+func (s *Set) ColumnType(name string) (ColumnType, bool) {
+	if s == nil {
+		return ColumnType{}, false
+	}
+	ct, ok := s.columnTypes[name]
+	return ct, ok
+}
+
+// PluginColumnType is a column type and the plugin defining it
+type PluginColumnType struct {
+	Plugin string
+	ColumnType
+}
+
+// ColumnTypes returns the plugin column types, sorted by name
+func (s *Set) ColumnTypes() []PluginColumnType {
+	if s == nil {
+		return nil
+	}
+	types := make([]PluginColumnType, 0, len(s.columnTypes))
+	for name, ct := range s.columnTypes {
+		types = append(types, PluginColumnType{Plugin: s.typeOwners[name], ColumnType: ct})
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+	return types
+}
+
+// ValidationError is returned when a plugin rejects a row write
+type ValidationError struct {
+	Plugin string
+	Err    error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("plugin %s: %v", e.Plugin, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Validate runs every plugin validator on ev, stopping at the first rejection
+func (s *Set) Validate(ctx context.Context, ev RowEvent) error {
+	if s == nil {
+		return nil
+	}
+	for _, l := range s.plugins {
+		if !has(l.caps, CapValidators) {
+			continue
+		}
+		err := guard(l.name, "ValidateRow", func() error {
+			vctx, cancel := context.WithTimeout(ctx, validateTimeout)
+			defer cancel()
+			return l.plugin.(Validator).ValidateRow(vctx, ev)
+		})
+		if err != nil {
+			return &ValidationError{Plugin: l.name, Err: err}
+		}
+	}
+	return nil
+}
+
+// ConsumesEvents reports whether any loaded plugin consumes row events
+func (s *Set) ConsumesEvents() bool {
+	if s == nil {
+		return false
+	}
+	for _, l := range s.plugins {
+		if l.events != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish queues ev for every event consumer without blocking. Events are
+// dropped for consumers whose queue is full.
+func (s *Set) Publish(ev RowEvent) {
+	if s == nil {
+		return
+	}
+	for _, l := range s.plugins {
+		if l.events == nil {
+			continue
+		}
+		select {
+		case l.events <- ev:
+		default:
+			l.logger.Warn("plugin event queue full, dropping event", "table", ev.Table, "row", ev.Row)
+			if s.OnDrop != nil {
+				s.OnDrop(l.name)
+			}
+		}
+	}
+}
+
+// Close stops event delivery and waits for consumers to finish the event
+// they are handling
+func (s *Set) Close() {
+	if s == nil || s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.wg.Wait()
+}
+
+// consume delivers queued events to one consumer until ctx is cancelled
+func (s *Set) consume(ctx context.Context, l *loaded) {
+	defer s.wg.Done()
+	consumer := l.plugin.(EventConsumer)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-l.events:
+			if err := guard(l.name, "ConsumeEvent", func() error {
+				consumer.ConsumeEvent(ctx, ev)
+				return nil
+			}); err != nil {
+				l.logger.Error("plugin event consumer failed", "error", err)
+			}
+		}
+	}
+}
+
+// guard calls fn, converting a panic into an error
+func guard(plugin, method string, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("plugin %s: %s panicked: %v\n%s", plugin, method, r, debug.Stack())
+		}
+	}()
+	return fn()
+}
+
+// guardedColumnType treats a panicking Valid func as rejecting the value
+func guardedColumnType(l *loaded, ct ColumnType) ColumnType {
+	valid := ct.Valid
+	ct.Valid = func(value interface{}) (ok bool) {
+		err := guard(l.name, "ColumnType "+ct.Name, func() error {
+			ok = valid(value)
+			return nil
+		})
+		if err != nil {
+			l.logger.Error("plugin column type failed", "error", err)
+			return false
+		}
+		return ok
+	}
+	return ct
+}
+
+// recoverHandler responds 500 instead of crashing the connection when a plugin handler panics
+func recoverHandler(l *loaded, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := guard(l.name, "route "+r.Method+" "+r.URL.Path, func() error {
+			h.ServeHTTP(w, r)
+			return nil
+		})
+		if err != nil {
+			l.logger.ErrorContext(r.Context(), "plugin handler failed", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":"Plugin handler failed"}`))
+		}
+	})
+}
@@ -0,0 +1,209 @@
+// Package presence tracks who is looking at shared data: the sessions
+// present in each scope, such as a table, with what they are doing there,
+// and the advisory locks they hold on its rows.
+//
+// Presence and locks are ephemeral. Every entry expires unless its session
+// renews it, so the sessions of a server that stops disappear on their own.
+// MemoryStore serves single-process servers; RedisStore shares the entries
+// and change notifications among the replicas of a deployment.
+package presence
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrLocked is returned by Store.Lock when another session holds the lock
+var ErrLocked = errors.New("presence: locked by another session")
+
+// Member is a session present in a scope
+type Member struct {
+	Session  string `json:"session"`
+	UserID   string `json:"userId"`
+	APIKeyID string `json:"apiKeyId,omitempty"`
+	// Name is how the session's client presents its user
+	Name string `json:"name,omitempty"`
+	// State is what the session is doing, such as viewing or editing, and
+	// Row the row it is doing it on
+	State     string    `json:"state"`
+	Row       string    `json:"row,omitempty"`
+	Since     time.Time `json:"since"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Lock is an advisory lock a session holds on a row. Locks do not stop
+// writes; clients check them before editing.
+type Lock struct {
+	Row       string    `json:"row"`
+	Session   string    `json:"session"`
+	UserID    string    `json:"userId"`
+	Name      string    `json:"name,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Store keeps the members and locks of scopes. Each call must be atomic.
+type Store interface {
+	// Join records m in scope until m.ExpiresAt, replacing the session's
+	// earlier entry. Sessions renew their presence by joining again.
+	Join(ctx context.Context, scope string, m Member) error
+	// Leave removes a session from scope
+	Leave(ctx context.Context, scope, session string) error
+	// Members returns the sessions present in scope at now, by session
+	Members(ctx context.Context, scope string, now time.Time) ([]Member, error)
+
+	// Lock takes the lock on l.Row for l.Session until l.ExpiresAt if it
+	// is free, expired at now or already the session's. When another
+	// session holds it, Lock returns that lock and ErrLocked.
+	Lock(ctx context.Context, scope string, l Lock, now time.Time) (Lock, error)
+	// Unlock frees session's lock on row. Unlocking a lock held by another
+	// session does nothing.
+	Unlock(ctx context.Context, scope, row, session string) error
+	// Locks returns the locks held in scope at now, by row
+	Locks(ctx context.Context, scope string, now time.Time) ([]Lock, error)
+
+	// Notify tells the subscribers of every server that scope changed
+	Notify(ctx context.Context, scope string) error
+	// Subscribe calls changed with each scope notified until ctx is done
+	Subscribe(ctx context.Context, changed func(scope string)) error
+}
+
+// sortMembers orders members by session, as Store.Members returns them
+func sortMembers(members []Member) {
+	sort.Slice(members, func(i, j int) bool { return members[i].Session < members[j].Session })
+}
+
+// sortLocks orders locks by row, as Store.Locks returns them
+func sortLocks(locks []Lock) {
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Row < locks[j].Row })
+}
+
+// MemoryStore keeps presence in memory, for servers that run in one process
+type MemoryStore struct {
+	mu          sync.Mutex
+	members     map[string]map[string]Member
+	locks       map[string]map[string]Lock
+	subscribers map[int]func(string)
+	next        int
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		members:     make(map[string]map[string]Member),
+		locks:       make(map[string]map[string]Lock),
+		subscribers: make(map[int]func(string)),
+	}
+}
+
+// Join implements Store
+func (s *MemoryStore) Join(ctx context.Context, scope string, m Member) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.members[scope] == nil {
+		s.members[scope] = make(map[string]Member)
+	}
+	s.members[scope][m.Session] = m
+	return nil
+}
+
+// Leave implements Store
+func (s *MemoryStore) Leave(ctx context.Context, scope, session string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.members[scope], session)
+	if len(s.members[scope]) == 0 {
+		delete(s.members, scope)
+	}
+	return nil
+}
+
+// Members implements Store
+func (s *MemoryStore) Members(ctx context.Context, scope string, now time.Time) ([]Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members := []Member{}
+	for session, m := range s.members[scope] {
+		if m.ExpiresAt.Before(now) {
+			delete(s.members[scope], session)
+			continue
+		}
+		members = append(members, m)
+	}
+	sortMembers(members)
+	return members, nil
+}
+
+// Lock implements Store
+func (s *MemoryStore) Lock(ctx context.Context, scope string, l Lock, now time.Time) (Lock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, ok := s.locks[scope][l.Row]; ok && cur.Session != l.Session && !cur.ExpiresAt.Before(now) {
+		return cur, ErrLocked
+	}
+	if s.locks[scope] == nil {
+		s.locks[scope] = make(map[string]Lock)
+	}
+	s.locks[scope][l.Row] = l
+	return l, nil
+}
+
+// Unlock implements Store
+func (s *MemoryStore) Unlock(ctx context.Context, scope, row, session string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, ok := s.locks[scope][row]; ok && cur.Session == session {
+		delete(s.locks[scope], row)
+	}
+	if len(s.locks[scope]) == 0 {
+		delete(s.locks, scope)
+	}
+	return nil
+}
+
+// Locks implements Store
+func (s *MemoryStore) Locks(ctx context.Context, scope string, now time.Time) ([]Lock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	locks := []Lock{}
+	for row, l := range s.locks[scope] {
+		if l.ExpiresAt.Before(now) {
+			delete(s.locks[scope], row)
+			continue
+		}
+		locks = append(locks, l)
+	}
+	sortLocks(locks)
+	return locks, nil
+}
+
+// Notify implements Store
+func (s *MemoryStore) Notify(ctx context.Context, scope string) error {
+	s.mu.Lock()
+	subscribers := make([]func(string), 0, len(s.subscribers))
+	for _, fn := range s.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	s.mu.Unlock()
+	for _, fn := range subscribers {
+		fn(scope)
+	}
+	return nil
+}
+
+// Subscribe implements Store
+func (s *MemoryStore) Subscribe(ctx context.Context, changed func(scope string)) error {
+	s.mu.Lock()
+	id := s.next
+	s.next++
+	s.subscribers[id] = changed
+	s.mu.Unlock()
+
+	<-ctx.Done()
+	s.mu.Lock()
+	delete(s.subscribers, id)
+	s.mu.Unlock()
+	return ctx.Err()
+}
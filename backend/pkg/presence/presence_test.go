@@ -0,0 +1,272 @@
+package presence
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis is a Redis server holding hashes in memory, running the store's
+// scripts the way Redis would but without expiring keys
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu          sync.Mutex
+	hashes      map[string]map[string]string
+	subscribers []net.Conn
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f := &fakeRedis{ln: ln, password: password, hashes: map[string]map[string]string{}}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		items := reply.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i] = item.(string)
+		}
+		if args[0] == "AUTH" {
+			if args[1] != f.password {
+				conn.Write([]byte("-WRONGPASS invalid password\r\n"))
+				continue
+			}
+			authed = true
+			conn.Write([]byte("+OK\r\n"))
+			continue
+		}
+		if !authed {
+			conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+			continue
+		}
+		f.mu.Lock()
+		out := f.run(conn, args)
+		f.mu.Unlock()
+		conn.Write([]byte(out))
+	}
+}
+
+func bulk(s string) string { return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n" }
+
+func array(items ...string) string {
+	return "*" + strconv.Itoa(len(items)) + "\r\n" + strings.Join(items, "")
+}
+
+// entryHeader returns the expiry and session an entry is prefixed with
+func entryHeader(entry string) (int64, string) {
+	parts := strings.SplitN(entry, "\n", 3)
+	expires, _ := strconv.ParseInt(parts[0], 10, 64)
+	return expires, parts[1]
+}
+
+func (f *fakeRedis) run(conn net.Conn, args []string) string {
+	hash := func(key string) map[string]string {
+		if f.hashes[key] == nil {
+			f.hashes[key] = map[string]string{}
+		}
+		return f.hashes[key]
+	}
+	switch args[0] {
+	case "HDEL":
+		delete(hash(args[1]), args[2])
+		return ":1\r\n"
+	case "PUBLISH":
+		for _, sub := range f.subscribers {
+			sub.Write([]byte(array(bulk("message"), bulk(args[1]), bulk(args[2]))))
+		}
+		return ":" + strconv.Itoa(len(f.subscribers)) + "\r\n"
+	case "SUBSCRIBE":
+		f.subscribers = append(f.subscribers, conn)
+		return array(bulk("subscribe"), bulk(args[1]), ":1\r\n")
+	case "EVAL":
+		key, argv := args[3], args[4:]
+		switch args[1] {
+		case putScript:
+			hash(key)[argv[0]] = argv[1]
+			return ":1\r\n"
+		case liveScript:
+			now, _ := strconv.ParseInt(argv[0], 10, 64)
+			var live []string
+			for field, entry := range hash(key) {
+				if expires, _ := entryHeader(entry); expires < now {
+					delete(hash(key), field)
+				} else {
+					live = append(live, bulk(entry))
+				}
+			}
+			return array(live...)
+		case lockScript:
+			now, _ := strconv.ParseInt(argv[3], 10, 64)
+			if cur, ok := hash(key)[argv[0]]; ok {
+				if expires, session := entryHeader(cur); session != argv[1] && expires >= now {
+					return array(":0\r\n", bulk(cur))
+				}
+			}
+			hash(key)[argv[0]] = argv[2]
+			return array(":1\r\n", bulk(argv[2]))
+		case unlockScript:
+			if cur, ok := hash(key)[argv[0]]; ok {
+				if _, session := entryHeader(cur); session == argv[1] {
+					delete(hash(key), argv[0])
+					return ":1\r\n"
+				}
+			}
+			return ":0\r\n"
+		}
+		return "-NOSCRIPT unknown script\r\n"
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func TestStores(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis(t, "secret")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "redis": NewRedisStore(redis.ln.Addr().String(), "secret")} {
+		t.Run(name, func(t *testing.T) {
+			scope := "u1/notes"
+			alice := Member{Session: "s1", UserID: "u1", Name: "alice", State: "viewing", Since: now, ExpiresAt: now.Add(time.Minute)}
+			bob := Member{Session: "s2", UserID: "u1", Name: "bob", State: "editing", Row: "r1", Since: now, ExpiresAt: now.Add(2 * time.Minute)}
+			require.NoError(t, store.Join(ctx, scope, bob))
+			require.NoError(t, store.Join(ctx, scope, alice))
+			require.NoError(t, store.Join(ctx, "u1/tasks", alice))
+
+			members, err := store.Members(ctx, scope, now)
+			require.NoError(t, err)
+			assert.Equal(t, []Member{alice, bob}, members)
+			alice.State = "editing"
+			require.NoError(t, store.Join(ctx, scope, alice), "sessions update their presence by joining again")
+			members, err = store.Members(ctx, scope, now.Add(90*time.Second))
+			require.NoError(t, err)
+			assert.Equal(t, []Member{bob}, members, "expired members are gone")
+			require.NoError(t, store.Leave(ctx, scope, "s2"))
+			members, err = store.Members(ctx, scope, now)
+			require.NoError(t, err)
+			assert.Empty(t, members)
+			members, err = store.Members(ctx, "u1/tasks", now)
+			require.NoError(t, err)
+			assert.Len(t, members, 1, "scopes are independent")
+
+			// One session at a time holds a row's lock
+			lock := Lock{Row: "r1", Session: "s1", UserID: "u1", Name: "alice", ExpiresAt: now.Add(time.Minute)}
+			held, err := store.Lock(ctx, scope, lock, now)
+			require.NoError(t, err)
+			assert.Equal(t, lock, held)
+			held, err = store.Lock(ctx, scope, Lock{Row: "r1", Session: "s2", ExpiresAt: now.Add(time.Minute)}, now.Add(time.Second))
+			assert.ErrorIs(t, err, ErrLocked)
+			assert.Equal(t, lock, held, "the holder is returned")
+			_, err = store.Lock(ctx, scope, Lock{Row: "r2", Session: "s2", ExpiresAt: now.Add(time.Minute)}, now)
+			require.NoError(t, err)
+			lock.ExpiresAt = now.Add(2 * time.Minute)
+			_, err = store.Lock(ctx, scope, lock, now.Add(30*time.Second))
+			require.NoError(t, err, "holders renew their locks")
+
+			require.NoError(t, store.Unlock(ctx, scope, "r1", "s2"), "unlocking another session's lock does nothing")
+			locks, err := store.Locks(ctx, scope, now)
+			require.NoError(t, err)
+			require.Len(t, locks, 2)
+			assert.Equal(t, []string{"r1", "r2"}, []string{locks[0].Row, locks[1].Row})
+			locks, err = store.Locks(ctx, scope, now.Add(90*time.Second))
+			require.NoError(t, err)
+			assert.Equal(t, []Lock{lock}, locks, "expired locks are gone")
+
+			// Expired locks can be taken
+			_, err = store.Lock(ctx, scope, Lock{Row: "r1", Session: "s2", ExpiresAt: now.Add(4 * time.Minute)}, now.Add(3*time.Minute))
+			require.NoError(t, err)
+			require.NoError(t, store.Unlock(ctx, scope, "r1", "s2"))
+			locks, err = store.Locks(ctx, scope, now.Add(3*time.Minute))
+			require.NoError(t, err)
+			assert.Empty(t, locks)
+		})
+	}
+}
+
+func TestNotify(t *testing.T) {
+	redis := newFakeRedis(t, "")
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "redis": NewRedisStore(redis.ln.Addr().String(), "")} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			changed := make(chan string, 10)
+			done := make(chan error)
+			go func() { done <- store.Subscribe(ctx, func(scope string) { changed <- scope }) }()
+
+			// Notifications sent before the subscription starts are missed,
+			// so keep notifying until one arrives
+			require.Eventually(t, func() bool {
+				require.NoError(t, store.Notify(context.Background(), "u1/notes"))
+				select {
+				case scope := <-changed:
+					return scope == "u1/notes"
+				case <-time.After(10 * time.Millisecond):
+					return false
+				}
+			}, 2*time.Second, 20*time.Millisecond)
+
+			cancel()
+			select {
+			case err := <-done:
+				assert.ErrorIs(t, err, context.Canceled)
+			case <-time.After(2 * time.Second):
+				t.Fatal("Subscribe did not return once its context was done")
+			}
+		})
+	}
+}
+
+func TestRedisClient(t *testing.T) {
+	ctx := context.Background()
+	redis := newFakeRedis(t, "secret")
+
+	_, err := NewRedisStore(redis.ln.Addr().String(), "wrong").Members(ctx, "u1/notes", time.Now())
+	assert.EqualError(t, err, "redis: WRONGPASS invalid password")
+
+	client := &redisClient{addr: redis.ln.Addr().String(), password: "secret"}
+	_, err = client.do(ctx, "NONSENSE")
+	assert.EqualError(t, err, "redis: ERR unknown command 'NONSENSE'")
+	reply, err := client.do(ctx, "HDEL", "k", "f")
+	require.NoError(t, err, "error replies keep the connection")
+	assert.Equal(t, int64(1), reply)
+
+	// A failed connection is reopened by the next command
+	client.conn.Close()
+	_, err = client.do(ctx, "HDEL", "k", "f")
+	assert.Error(t, err)
+	reply, err = client.do(ctx, "HDEL", "k", "f")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), reply)
+
+	r := bufio.NewReader(strings.NewReader("*3\r\n$-1\r\n-ERR nested\r\n*1\r\n+OK\r\n"))
+	reply, err = readReply(r)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{nil, redisError("ERR nested"), []interface{}{"OK"}}, reply)
+}
@@ -0,0 +1,397 @@
+package presence
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisStore keeps presence in Redis, so the replicas of a deployment share
+// it. Each scope is a hash of members and a hash of locks, each entry
+// prefixed with its expiry and session; scripts check those atomically.
+// Notifications go through a pub/sub channel.
+type RedisStore struct {
+	client *redisClient
+	prefix string
+}
+
+// redisTimeout bounds each command to Redis when its context does not
+const redisTimeout = 5 * time.Second
+
+// NewRedisStore returns a store keeping presence in the Redis server at addr
+// (host:port), authenticating with password unless it is empty
+func NewRedisStore(addr, password string) *RedisStore {
+	return &RedisStore{client: &redisClient{addr: addr, password: password}, prefix: "notably:presence:"}
+}
+
+// putScript sets a hash entry and keeps the hash until its entries expire
+var putScript = `redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[3]) then
+  redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return 1`
+
+// liveScript returns the hash entries not expired at ARGV[1], deleting the
+// others
+var liveScript = `local live = {}
+local entries = redis.call('HGETALL', KEYS[1])
+for i = 1, #entries, 2 do
+  if tonumber(string.match(entries[i+1], '^(%d+)')) < tonumber(ARGV[1]) then
+    redis.call('HDEL', KEYS[1], entries[i])
+  else
+    table.insert(live, entries[i+1])
+  end
+end
+return live`
+
+// lockScript sets a lock entry unless another session holds an unexpired
+// one, which it returns
+var lockScript = `local cur = redis.call('HGET', KEYS[1], ARGV[1])
+if cur then
+  local expires, session = string.match(cur, '^(%d+)\n([^\n]*)\n')
+  if session ~= ARGV[2] and tonumber(expires) >= tonumber(ARGV[4]) then
+    return {0, cur}
+  end
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[5]) then
+  redis.call('PEXPIRE', KEYS[1], ARGV[5])
+end
+return {1, ARGV[3]}`
+
+// unlockScript deletes a lock entry if ARGV[2]'s session holds it
+var unlockScript = `local cur = redis.call('HGET', KEYS[1], ARGV[1])
+if cur and string.match(cur, '^%d+\n([^\n]*)\n') == ARGV[2] then
+  return redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return 0`
+
+func (s *RedisStore) membersKey(scope string) string { return s.prefix + scope + ":members" }
+func (s *RedisStore) locksKey(scope string) string   { return s.prefix + scope + ":locks" }
+func (s *RedisStore) channel() string                { return s.prefix + "changes" }
+
+// encodeEntry prefixes v's JSON with its expiry and session, which the
+// scripts read without decoding it
+func encodeEntry(expires time.Time, session string, v interface{}) (string, error) {
+	if strings.Contains(session, "\n") {
+		return "", fmt.Errorf("presence: session %q contains a newline", session)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(expires.UnixMilli(), 10) + "\n" + session + "\n" + string(b), nil
+}
+
+// decodeEntry decodes the JSON of an entry written by encodeEntry into v
+func decodeEntry(entry string, v interface{}) error {
+	parts := strings.SplitN(entry, "\n", 3)
+	if len(parts) != 3 {
+		return fmt.Errorf("presence: malformed entry %q", entry)
+	}
+	return json.Unmarshal([]byte(parts[2]), v)
+}
+
+// ttlMillis is how long from now an entry expiring at expires lasts, and
+// at least a millisecond
+func ttlMillis(expires time.Time) string {
+	ms := time.Until(expires).Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10)
+}
+
+// Join implements Store
+func (s *RedisStore) Join(ctx context.Context, scope string, m Member) error {
+	entry, err := encodeEntry(m.ExpiresAt, m.Session, m)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.do(ctx, "EVAL", putScript, "1", s.membersKey(scope), m.Session, entry, ttlMillis(m.ExpiresAt))
+	return err
+}
+
+// Leave implements Store
+func (s *RedisStore) Leave(ctx context.Context, scope, session string) error {
+	_, err := s.client.do(ctx, "HDEL", s.membersKey(scope), session)
+	return err
+}
+
+// live returns the entries of a scope's hash not expired at now
+func (s *RedisStore) live(ctx context.Context, key string, now time.Time) ([]string, error) {
+	reply, err := s.client.do(ctx, "EVAL", liveScript, "1", key, strconv.FormatInt(now.UnixMilli(), 10))
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("presence: unexpected reply %v", reply)
+	}
+	entries := make([]string, 0, len(items))
+	for _, item := range items {
+		entry, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("presence: unexpected entry %v", item)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Members implements Store
+func (s *RedisStore) Members(ctx context.Context, scope string, now time.Time) ([]Member, error) {
+	entries, err := s.live(ctx, s.membersKey(scope), now)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]Member, 0, len(entries))
+	for _, entry := range entries {
+		var m Member
+		if err := decodeEntry(entry, &m); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	sortMembers(members)
+	return members, nil
+}
+
+// Lock implements Store
+func (s *RedisStore) Lock(ctx context.Context, scope string, l Lock, now time.Time) (Lock, error) {
+	entry, err := encodeEntry(l.ExpiresAt, l.Session, l)
+	if err != nil {
+		return Lock{}, err
+	}
+	reply, err := s.client.do(ctx, "EVAL", lockScript, "1", s.locksKey(scope),
+		l.Row, l.Session, entry, strconv.FormatInt(now.UnixMilli(), 10), ttlMillis(l.ExpiresAt))
+	if err != nil {
+		return Lock{}, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 2 {
+		return Lock{}, fmt.Errorf("presence: unexpected reply %v", reply)
+	}
+	taken, _ := items[0].(int64)
+	held, _ := items[1].(string)
+	var holder Lock
+	if err := decodeEntry(held, &holder); err != nil {
+		return Lock{}, err
+	}
+	if taken != 1 {
+		return holder, ErrLocked
+	}
+	return holder, nil
+}
+
+// Unlock implements Store
+func (s *RedisStore) Unlock(ctx context.Context, scope, row, session string) error {
+	_, err := s.client.do(ctx, "EVAL", unlockScript, "1", s.locksKey(scope), row, session)
+	return err
+}
+
+// Locks implements Store
+func (s *RedisStore) Locks(ctx context.Context, scope string, now time.Time) ([]Lock, error) {
+	entries, err := s.live(ctx, s.locksKey(scope), now)
+	if err != nil {
+		return nil, err
+	}
+	locks := make([]Lock, 0, len(entries))
+	for _, entry := range entries {
+		var l Lock
+		if err := decodeEntry(entry, &l); err != nil {
+			return nil, err
+		}
+		locks = append(locks, l)
+	}
+	sortLocks(locks)
+	return locks, nil
+}
+
+// Notify implements Store
+func (s *RedisStore) Notify(ctx context.Context, scope string) error {
+	_, err := s.client.do(ctx, "PUBLISH", s.channel(), scope)
+	return err
+}
+
+// Subscribe implements Store. It returns an error when the subscription's
+// connection fails; callers resubscribe, and may have missed notifications.
+func (s *RedisStore) Subscribe(ctx context.Context, changed func(scope string)) error {
+	conn, r, err := s.client.dial(ctx)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	if err := writeCommand(conn, "SUBSCRIBE", s.channel()); err != nil {
+		return err
+	}
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		msg, ok := reply.([]interface{})
+		if !ok || len(msg) != 3 {
+			continue
+		}
+		if kind, _ := msg[0].(string); kind == "message" {
+			if scope, ok := msg[2].(string); ok {
+				changed(scope)
+			}
+		}
+	}
+}
+
+// redisError is an error reply from Redis
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisClient sends commands to Redis one at a time over a connection it
+// opens on first use and reopens after the connection fails
+type redisClient struct {
+	addr     string
+	password string
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dial opens and authenticates a connection
+func (c *redisClient) dial(ctx context.Context) (net.Conn, *bufio.Reader, error) {
+	var d net.Dialer
+	dialCtx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	conn, err := d.DialContext(dialCtx, "tcp", c.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReader(conn)
+	if c.password != "" {
+		conn.SetDeadline(time.Now().Add(redisTimeout))
+		err := writeCommand(conn, "AUTH", c.password)
+		if err == nil {
+			_, err = readReply(r)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		conn.SetDeadline(time.Time{})
+	}
+	return conn, r, nil
+}
+
+// do sends a command and returns its reply. Error replies are returned as
+// redisError.
+func (c *redisClient) do(ctx context.Context, args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		conn, r, err := c.dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.conn, c.r = conn, r
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(redisTimeout)
+	}
+	c.conn.SetDeadline(deadline)
+
+	err := writeCommand(c.conn, args...)
+	var reply interface{}
+	if err == nil {
+		reply, err = readReply(c.r)
+	}
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		c.conn.Close()
+		c.conn, c.r = nil, nil
+	}
+	return reply, err
+}
+
+// writeCommand writes a command as a RESP array of bulk strings
+func writeCommand(w io.Writer, args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// readReply reads a RESP reply: simple and bulk strings as string, null
+// bulk strings and arrays as nil, integers as int64 and arrays as
+// []interface{}. An error reply is returned as a redisError, or as an
+// element of its array.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			item, err := readReply(r)
+			var replyErr redisError
+			if errors.As(err, &replyErr) {
+				item = replyErr
+			} else if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: malformed reply %q", line)
+}
@@ -0,0 +1,109 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// kafkaContentType is the REST Proxy v2 embedded JSON format
+const kafkaContentType = "application/vnd.kafka.json.v2+json"
+
+// kafkaRetriable is the REST Proxy error code of a record that may be
+// accepted if sent again
+const kafkaRetriable = 2
+
+// Kafka publishes to Kafka through the v2 API of a Kafka REST Proxy, as
+// served by Confluent REST Proxy and Redpanda. Messages are produced as
+// records keyed by the message key, so Kafka's default partitioner keeps
+// the records of a key on one partition, in order.
+type Kafka struct {
+	// URL is the base URL of the REST Proxy, such as http://localhost:8082
+	URL string
+	// Client sends the requests; http.DefaultClient when nil
+	Client *http.Client
+}
+
+// kafkaRecord is one record of a produce request. The value is the message
+// body, which must be JSON.
+type kafkaRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Publish produces m as one record to its topic
+func (k *Kafka) Publish(ctx context.Context, m Message) error {
+	if !json.Valid(m.Body) {
+		return &Error{Code: "invalid_body", Message: "Kafka messages must be JSON"}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"records": []kafkaRecord{{Key: m.Key, Value: m.Body}},
+	})
+	if err != nil {
+		return err
+	}
+	endpoint := strings.TrimSuffix(k.URL, "/") + "/topics/" + url.PathEscape(m.Topic)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", kafkaContentType)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json, application/json")
+	req.Header.Set("User-Agent", "notably-publish")
+
+	client := k.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			ErrorCode int    `json:"error_code"`
+			Message   string `json:"message"`
+		}
+		pe := &Error{StatusCode: resp.StatusCode, Temporary: statusTemporary(resp.StatusCode)}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.ErrorCode != 0 {
+			pe.Code = strconv.Itoa(apiErr.ErrorCode)
+			pe.Message = apiErr.Message
+		}
+		return pe
+	}
+
+	// A request the proxy accepted may still fail per record
+	var produced struct {
+		Offsets []struct {
+			ErrorCode *int    `json:"error_code"`
+			Error     *string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.Unmarshal(data, &produced); err != nil {
+		return fmt.Errorf("decoding produce response: %w", err)
+	}
+	for _, o := range produced.Offsets {
+		if o.ErrorCode == nil && o.Error == nil {
+			continue
+		}
+		pe := &Error{StatusCode: resp.StatusCode, Temporary: true}
+		if o.ErrorCode != nil {
+			pe.Code = strconv.Itoa(*o.ErrorCode)
+			pe.Temporary = *o.ErrorCode == kafkaRetriable
+		}
+		if o.Error != nil {
+			pe.Message = *o.Error
+		}
+		return pe
+	}
+	return nil
+}
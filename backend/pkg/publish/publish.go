@@ -0,0 +1,99 @@
+// Package publish pushes messages to message brokers: Kafka, through a
+// Kafka REST Proxy, and Amazon SNS.
+//
+// Publishers make one attempt per call; Deliver retries the failures that
+// may succeed if repeated. Messages carry a key and an ID: brokers keep the
+// order of messages with the same key, and may use the ID, which retries of
+// a message reuse, to drop duplicates.
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/elibdev/notably/pkg/backoff"
+)
+
+// requestTimeout bounds a single publish attempt
+const requestTimeout = 10 * time.Second
+
+// DefaultPolicy retries failed publishes for up to about two minutes
+var DefaultPolicy = backoff.Policy{
+	MaxAttempts: 6,
+	MaxElapsed:  2 * time.Minute,
+	BaseDelay:   time.Second,
+	MaxDelay:    30 * time.Second,
+}
+
+// Message is one message to publish
+type Message struct {
+	// Topic is the Kafka topic name or SNS topic ARN
+	Topic string
+	// Key orders messages: those with the same key are kept in order
+	Key string
+	// ID identifies the message; retries of it reuse the ID
+	ID   string
+	Body []byte
+}
+
+// Publisher sends messages to a broker
+type Publisher interface {
+	// Publish makes one attempt to send m, returning once the broker has
+	// accepted it
+	Publish(ctx context.Context, m Message) error
+}
+
+// Error reports a message the broker refused
+type Error struct {
+	StatusCode int
+	// Code and Message are the broker's error, when it gave one
+	Code    string
+	Message string
+	// Temporary is set when the broker may accept the message later
+	Temporary bool
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Code != "" && e.Message != "":
+		return fmt.Sprintf("broker refused message: %s: %s", e.Code, e.Message)
+	case e.Code != "":
+		return fmt.Sprintf("broker refused message: %s", e.Code)
+	default:
+		return fmt.Sprintf("broker responded with status %d", e.StatusCode)
+	}
+}
+
+// statusTemporary reports whether an HTTP status may clear on its own
+func statusTemporary(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// Retryable reports whether a failed publish may succeed if repeated:
+// network errors and refusals the broker marks temporary
+func Retryable(err error) bool {
+	var pe *Error
+	if errors.As(err, &pe) {
+		return pe.Temporary
+	}
+	return true
+}
+
+// Deliver publishes m, retrying under policy (zero fields use
+// DefaultPolicy), and returns the number of attempts made
+func Deliver(ctx context.Context, p Publisher, policy backoff.Policy, m Message) (int, error) {
+	if policy == (backoff.Policy{}) {
+		policy = DefaultPolicy
+	}
+	attempts := 0
+	err := policy.Retry(ctx, Retryable, nil, func() error {
+		attempts++
+		ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+		return p.Publish(ctx, m)
+	})
+	return attempts, err
+}
@@ -0,0 +1,117 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/elibdev/notably/pkg/backoff"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fastPolicy = backoff.Policy{MaxAttempts: 3, MaxElapsed: time.Second, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+func TestKafkaPublish(t *testing.T) {
+	var calls atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/topics/row-events", r.URL.Path)
+		assert.Equal(t, kafkaContentType, r.Header.Get("Content-Type"))
+		var req struct {
+			Records []struct {
+				Key   string                 `json:"key"`
+				Value map[string]interface{} `json:"value"`
+			} `json:"records"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Len(t, req.Records, 1)
+		assert.Equal(t, "u1/orders/o1", req.Records[0].Key)
+		assert.Equal(t, "c", req.Records[0].Value["op"])
+
+		switch calls.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			io.WriteString(w, `{"offsets":[{"partition":null,"offset":null,"error_code":2,"error":"leader not available"}]}`)
+		default:
+			io.WriteString(w, `{"offsets":[{"partition":3,"offset":42,"error_code":null,"error":null}]}`)
+		}
+	}))
+	defer proxy.Close()
+
+	k := &Kafka{URL: proxy.URL + "/"}
+	m := Message{Topic: "row-events", Key: "u1/orders/o1", ID: "e1", Body: []byte(`{"op":"c"}`)}
+	attempts, err := Deliver(context.Background(), k, fastPolicy, m)
+	require.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestKafkaPublishRefused(t *testing.T) {
+	var calls atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"error_code":40401,"message":"Topic not found."}`)
+	}))
+	defer proxy.Close()
+
+	attempts, err := Deliver(context.Background(), &Kafka{URL: proxy.URL}, fastPolicy, Message{Topic: "missing", Key: "k", Body: []byte(`{}`)})
+	var pe *Error
+	require.ErrorAs(t, err, &pe)
+	assert.Equal(t, "40401", pe.Code)
+	assert.Equal(t, "broker refused message: 40401: Topic not found.", err.Error())
+	assert.Equal(t, 1, attempts, "client errors are not retried")
+	assert.EqualValues(t, 1, calls.Load())
+
+	err = (&Kafka{URL: proxy.URL}).Publish(context.Background(), Message{Topic: "t", Body: []byte("not json")})
+	assert.False(t, Retryable(err))
+}
+
+func TestSNSPublish(t *testing.T) {
+	var forms []url.Values
+	var calls atomic.Int32
+	sns := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/"), r.Header.Get("Authorization"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/eu-west-1/sns/aws4_request")
+		require.NoError(t, r.ParseForm())
+		forms = append(forms, r.PostForm)
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `<ErrorResponse><Error><Type>Sender</Type><Code>Throttling</Code><Message>Rate exceeded</Message></Error></ErrorResponse>`)
+			return
+		}
+		io.WriteString(w, `<PublishResponse><PublishResult><MessageId>m1</MessageId></PublishResult></PublishResponse>`)
+	}))
+	defer sns.Close()
+
+	p := &SNS{Credentials: credentials.NewStaticCredentialsProvider("AKID", "secret", ""), Endpoint: sns.URL}
+	fifo := "arn:aws:sns:eu-west-1:123456789012:rows.fifo"
+	attempts, err := Deliver(context.Background(), p, fastPolicy, Message{Topic: fifo, Key: "u1/orders/o1", ID: "e1", Body: []byte(`{"op":"u"}`)})
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempts, "throttling is retried")
+
+	form := forms[1]
+	assert.Equal(t, "Publish", form.Get("Action"))
+	assert.Equal(t, fifo, form.Get("TopicArn"))
+	assert.Equal(t, `{"op":"u"}`, form.Get("Message"))
+	assert.Equal(t, "u1/orders/o1", form.Get("MessageGroupId"))
+	assert.Equal(t, "e1", form.Get("MessageDeduplicationId"))
+
+	// Standard topics get the key as an attribute
+	_, err = Deliver(context.Background(), p, fastPolicy, Message{Topic: "arn:aws:sns:eu-west-1:123456789012:rows", Key: strings.Repeat("k", 200), ID: "e2", Body: []byte(`{}`)})
+	require.NoError(t, err)
+	form = forms[2]
+	assert.Empty(t, form.Get("MessageGroupId"))
+	assert.Equal(t, strings.Repeat("k", 200), form.Get("MessageAttributes.entry.1.Value.StringValue"))
+
+	assert.Len(t, messageGroupID(strings.Repeat("k", 200)), 64, "long keys are hashed")
+	assert.Equal(t, "", TopicRegion("rows"))
+}
@@ -0,0 +1,151 @@
+package publish
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// maxMessageGroupID is the longest message group ID SNS accepts
+const maxMessageGroupID = 128
+
+// snsTemporaryCodes are the SNS error codes of refusals that may clear
+var snsTemporaryCodes = map[string]bool{
+	"Throttling":          true,
+	"ThrottlingException": true,
+	"KMSThrottling":       true,
+	"InternalError":       true,
+	"InternalFailure":     true,
+	"ServiceUnavailable":  true,
+}
+
+// SNS publishes to Amazon SNS topics, calling the Publish action of the SNS
+// query API. On FIFO topics (whose names end in ".fifo") the message key is
+// the message group ID, so SNS keeps the messages of a key in order, and
+// the message ID is the deduplication ID. On standard topics, which do not
+// order messages, the key is sent as the "key" message attribute.
+type SNS struct {
+	Credentials aws.CredentialsProvider
+	// Region is used for topic ARNs that do not name one
+	Region string
+	// Endpoint, if set, replaces the regional SNS endpoint, for LocalStack
+	// and other emulators
+	Endpoint string
+	// Client sends the requests; http.DefaultClient when nil
+	Client aws.HTTPClient
+}
+
+// NewSNS returns an SNS publisher using cfg's credentials, region and HTTP client
+func NewSNS(cfg aws.Config) *SNS {
+	s := &SNS{Credentials: cfg.Credentials, Region: cfg.Region, Client: cfg.HTTPClient}
+	if cfg.BaseEndpoint != nil {
+		s.Endpoint = *cfg.BaseEndpoint
+	}
+	return s
+}
+
+// TopicRegion returns the region named by an SNS topic ARN, such as
+// arn:aws:sns:us-east-1:123456789012:events, or "" if arn is not a topic ARN
+func TopicRegion(arn string) string {
+	parts := strings.Split(arn, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "sns" || parts[5] == "" {
+		return ""
+	}
+	return parts[3]
+}
+
+// Publish sends m to the SNS topic whose ARN is m.Topic
+func (s *SNS) Publish(ctx context.Context, m Message) error {
+	if s.Credentials == nil {
+		return fmt.Errorf("publish: no AWS credentials")
+	}
+	region := TopicRegion(m.Topic)
+	if region == "" {
+		region = s.Region
+	}
+	if region == "" {
+		return &Error{Code: "InvalidParameter", Message: fmt.Sprintf("no region for topic %q", m.Topic)}
+	}
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://sns.%s.amazonaws.com/", region)
+	}
+
+	form := url.Values{
+		"Action":   {"Publish"},
+		"Version":  {"2010-03-31"},
+		"TopicArn": {m.Topic},
+		"Message":  {string(m.Body)},
+	}
+	if strings.HasSuffix(m.Topic, ".fifo") {
+		form.Set("MessageGroupId", messageGroupID(m.Key))
+		form.Set("MessageDeduplicationId", m.ID)
+	} else {
+		form.Set("MessageAttributes.entry.1.Name", "key")
+		form.Set("MessageAttributes.entry.1.Value.DataType", "String")
+		form.Set("MessageAttributes.entry.1.Value.StringValue", m.Key)
+	}
+	payload := form.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	creds, err := s.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("publish: retrieve credentials: %w", err)
+	}
+	sum := sha256.Sum256([]byte(payload))
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), "sns", region, time.Now()); err != nil {
+		return fmt.Errorf("publish: sign request: %w", err)
+	}
+
+	var client aws.HTTPClient = http.DefaultClient
+	if s.Client != nil {
+		client = s.Client
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	pe := &Error{StatusCode: resp.StatusCode, Temporary: statusTemporary(resp.StatusCode)}
+	var apiErr struct {
+		Error struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		} `xml:"Error"`
+	}
+	if xml.Unmarshal(data, &apiErr) == nil && apiErr.Error.Code != "" {
+		pe.Code, pe.Message = apiErr.Error.Code, apiErr.Error.Message
+		pe.Temporary = pe.Temporary || snsTemporaryCodes[pe.Code]
+	}
+	return pe
+}
+
+// messageGroupID returns key, or its hash when it is too long to be a
+// message group ID
+func messageGroupID(key string) string {
+	if len(key) <= maxMessageGroupID {
+		return key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
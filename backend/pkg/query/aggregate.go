@@ -0,0 +1,288 @@
+// Package query computes aggregates and joins over table rows and rollups
+// over a field's history, so clients can ask for counts, totals, charts and
+// related rows instead of downloading every row or fact.
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/elibdev/notably/pkg/filter"
+)
+
+// Metric functions
+const (
+	FuncCount = "count" // rows, or rows with a non-null value when a column is given
+	FuncSum   = "sum"
+	FuncAvg   = "avg"
+	FuncMin   = "min"
+	FuncMax   = "max"
+)
+
+// Value kinds a metric accumulates
+const (
+	// KindAny takes numbers for sum and avg, and numbers or strings for min
+	// and max, whichever the column's first value is
+	KindAny = ""
+	// KindDecimal takes decimal strings and sums them exactly
+	KindDecimal = "decimal"
+)
+
+// MaxGroups bounds the groups one aggregation may produce
+const MaxGroups = 10000
+
+var metricPattern = regexp.MustCompile(`^([a-z]+)(?:\(([A-Za-z0-9_-]+)\))?$`)
+
+// Metric is one aggregate to compute, such as sum(amount)
+type Metric struct {
+	Func   string
+	Column string
+	// Kind selects how values are read; see KindAny and KindDecimal
+	Kind string
+}
+
+// Name is the metric as written in a query, which keys its result
+func (m Metric) Name() string {
+	if m.Column == "" {
+		return m.Func
+	}
+	return m.Func + "(" + m.Column + ")"
+}
+
+// ParseMetrics parses a comma-separated metric list such as
+// "count,sum(amount),max(createdAt)"
+func ParseMetrics(s string) ([]Metric, error) {
+	var metrics []Metric
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		m := metricPattern.FindStringSubmatch(part)
+		if m == nil {
+			return nil, fmt.Errorf("invalid metric %q", part)
+		}
+		metric := Metric{Func: m[1], Column: m[2]}
+		switch metric.Func {
+		case FuncCount:
+		case FuncSum, FuncAvg, FuncMin, FuncMax:
+			if metric.Column == "" {
+				return nil, fmt.Errorf("metric %s needs a column, as in %s(amount)", metric.Func, metric.Func)
+			}
+		default:
+			return nil, fmt.Errorf("unknown metric function %q", metric.Func)
+		}
+		if !seen[metric.Name()] {
+			seen[metric.Name()] = true
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics, nil
+}
+
+// Group is the aggregates of the rows sharing the group-by values in Key
+type Group struct {
+	Key     map[string]interface{} `json:"key"`
+	Metrics map[string]interface{} `json:"metrics"`
+}
+
+// Aggregate groups rows by the groupBy columns and computes each metric per
+// group. Rows missing a group-by column are grouped under null. Without
+// groupBy there is exactly one group, even for no rows. Groups are ordered
+// by their key values.
+func Aggregate(rows []map[string]interface{}, groupBy []string, metrics []Metric) ([]Group, error) {
+	type group struct {
+		key  []interface{}
+		accs []accumulator
+	}
+	newGroup := func(key []interface{}) *group {
+		g := &group{key: key, accs: make([]accumulator, len(metrics))}
+		for i, m := range metrics {
+			g.accs[i] = newAccumulator(m)
+		}
+		return g
+	}
+
+	groups := make(map[string]*group)
+	var order []*group
+	for _, row := range rows {
+		key := make([]interface{}, len(groupBy))
+		for i, col := range groupBy {
+			key[i] = row[col]
+		}
+		id, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		g, ok := groups[string(id)]
+		if !ok {
+			if len(groups) == MaxGroups {
+				return nil, fmt.Errorf("more than %d groups", MaxGroups)
+			}
+			g = newGroup(key)
+			groups[string(id)] = g
+			order = append(order, g)
+		}
+		for i, m := range metrics {
+			if m.Column == "" {
+				g.accs[i].add(nil)
+			} else if v, ok := row[m.Column]; ok && v != nil {
+				g.accs[i].add(v)
+			}
+		}
+	}
+
+	if len(groupBy) == 0 && len(order) == 0 {
+		order = append(order, newGroup(nil))
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		for k := range groupBy {
+			a, b := order[i].key[k], order[j].key[k]
+			if c, ok := filter.Compare(a, b); ok {
+				if c != 0 {
+					return c < 0
+				}
+				continue
+			}
+			// Nulls and values of different kinds order by their JSON text
+			aj, _ := json.Marshal(a)
+			bj, _ := json.Marshal(b)
+			if c := strings.Compare(string(aj), string(bj)); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	out := make([]Group, len(order))
+	for i, g := range order {
+		out[i] = Group{Key: make(map[string]interface{}, len(groupBy)), Metrics: make(map[string]interface{}, len(metrics))}
+		for k, col := range groupBy {
+			out[i].Key[col] = g.key[k]
+		}
+		for k, m := range metrics {
+			out[i].Metrics[m.Name()] = g.accs[k].result()
+		}
+	}
+	return out, nil
+}
+
+// accumulator folds the non-null values of a column into one metric.
+// Values of a kind the metric cannot use are skipped.
+type accumulator interface {
+	add(v interface{})
+	result() interface{}
+}
+
+func newAccumulator(m Metric) accumulator {
+	switch {
+	case m.Func == FuncCount:
+		return &countAcc{}
+	case m.Kind == KindDecimal:
+		return &decimalAcc{fn: m.Func}
+	case m.Func == FuncSum || m.Func == FuncAvg:
+		return &numberAcc{avg: m.Func == FuncAvg}
+	default:
+		return &extremeAcc{max: m.Func == FuncMax}
+	}
+}
+
+type countAcc struct{ n int }
+
+func (a *countAcc) add(interface{})     { a.n++ }
+func (a *countAcc) result() interface{} { return a.n }
+
+// numberAcc sums JSON numbers, or averages them when avg is set
+type numberAcc struct {
+	avg bool
+	sum float64
+	n   int
+}
+
+func (a *numberAcc) add(v interface{}) {
+	if f, ok := v.(float64); ok {
+		a.sum += f
+		a.n++
+	}
+}
+
+func (a *numberAcc) result() interface{} {
+	if !a.avg {
+		return a.sum
+	}
+	if a.n == 0 {
+		return nil
+	}
+	return a.sum / float64(a.n)
+}
+
+// extremeAcc keeps the smallest or largest value. The first value decides
+// whether numbers or strings are compared.
+type extremeAcc struct {
+	max  bool
+	best interface{}
+}
+
+func (a *extremeAcc) add(v interface{}) {
+	switch v.(type) {
+	case float64, string:
+	default:
+		return
+	}
+	if a.best == nil {
+		a.best = v
+		return
+	}
+	if c, ok := filter.Compare(v, a.best); ok && (c > 0) == a.max && c != 0 {
+		a.best = v
+	}
+}
+
+func (a *extremeAcc) result() interface{} { return a.best }
+
+// decimalAcc aggregates decimal strings exactly. Results are strings with
+// as many digits after the point as the most precise value.
+type decimalAcc struct {
+	fn    string
+	sum   big.Rat
+	best  *big.Rat
+	n     int
+	scale int
+}
+
+func (a *decimalAcc) add(v interface{}) {
+	s, ok := v.(string)
+	if !ok {
+		return
+	}
+	var r big.Rat
+	if _, ok := r.SetString(s); !ok || strings.ContainsAny(s, "eE/") {
+		return
+	}
+	if i := strings.IndexByte(s, '.'); i >= 0 && len(s)-i-1 > a.scale {
+		a.scale = len(s) - i - 1
+	}
+	a.n++
+	a.sum.Add(&a.sum, &r)
+	if a.best == nil || (r.Cmp(a.best) > 0) == (a.fn == FuncMax) && r.Cmp(a.best) != 0 {
+		a.best = &r
+	}
+}
+
+func (a *decimalAcc) result() interface{} {
+	switch {
+	case a.n == 0 && a.fn == FuncSum:
+		return "0"
+	case a.n == 0:
+		return nil
+	case a.fn == FuncSum:
+		return a.sum.FloatString(a.scale)
+	case a.fn == FuncAvg:
+		var avg big.Rat
+		return avg.Quo(&a.sum, big.NewRat(int64(a.n), 1)).FloatString(a.scale)
+	}
+	return a.best.FloatString(a.scale)
+}
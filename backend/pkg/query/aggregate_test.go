@@ -0,0 +1,60 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMetrics(t *testing.T) {
+	metrics, err := ParseMetrics("count, sum(amount),avg(amount),count,max(createdAt)")
+	require.NoError(t, err)
+	assert.Equal(t, []Metric{
+		{Func: FuncCount},
+		{Func: FuncSum, Column: "amount"},
+		{Func: FuncAvg, Column: "amount"},
+		{Func: FuncMax, Column: "createdAt"},
+	}, metrics, "duplicates are dropped")
+
+	for _, s := range []string{"", "sum", "median(x)", "sum(a b)", "count(x"} {
+		_, err := ParseMetrics(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"status": "open", "amount": float64(10), "price": "1.50", "at": "2024-01-02"},
+		{"status": "open", "amount": float64(30), "price": "2.25", "at": "2024-01-01"},
+		{"status": "paid", "amount": "n/a", "price": "0.1"},
+		{"status": "paid", "amount": nil},
+		{"amount": float64(5)},
+	}
+	metrics, err := ParseMetrics("count,count(amount),sum(amount),avg(amount),min(at),max(amount)")
+	require.NoError(t, err)
+	metrics = append(metrics, Metric{Func: FuncSum, Column: "price", Kind: KindDecimal}, Metric{Func: FuncMax, Column: "price", Kind: KindDecimal})
+
+	groups, err := Aggregate(rows, []string{"status"}, metrics)
+	require.NoError(t, err)
+	require.Len(t, groups, 3)
+
+	assert.Equal(t, map[string]interface{}{"status": "open"}, groups[0].Key)
+	assert.Equal(t, map[string]interface{}{
+		"count": 2, "count(amount)": 2, "sum(amount)": float64(40), "avg(amount)": float64(20),
+		"min(at)": "2024-01-01", "max(amount)": float64(30), "sum(price)": "3.75", "max(price)": "2.25",
+	}, groups[0].Metrics)
+
+	assert.Equal(t, map[string]interface{}{"status": "paid"}, groups[1].Key)
+	assert.Equal(t, 2, groups[1].Metrics["count"])
+	assert.Equal(t, 1, groups[1].Metrics["count(amount)"], "nulls are not counted")
+	assert.Equal(t, float64(0), groups[1].Metrics["sum(amount)"], "strings are not summed")
+	assert.Nil(t, groups[1].Metrics["avg(amount)"])
+	assert.Equal(t, "0.1", groups[1].Metrics["sum(price)"])
+
+	assert.Equal(t, map[string]interface{}{"status": nil}, groups[2].Key, "rows without the column group under null")
+
+	total, err := Aggregate(nil, nil, []Metric{{Func: FuncCount}, {Func: FuncSum, Column: "x", Kind: KindDecimal}})
+	require.NoError(t, err)
+	assert.Equal(t, []Group{{Key: map[string]interface{}{}, Metrics: map[string]interface{}{"count": 0, "sum(x)": "0"}}}, total)
+}
@@ -0,0 +1,68 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MaxJoinRows bounds the rows one join may produce
+const MaxJoinRows = 10000
+
+// Pair is a left row joined with a right row, by their indexes in the
+// joined slices. Right is -1 for a left row an outer join found no match for.
+type Pair struct {
+	Left  int
+	Right int
+}
+
+// Join matches the join keys of left rows to those of right rows. Keys are
+// equal when their JSON encodings are, so numbers only match numbers; null
+// keys match nothing. Pairs are ordered by left row, and the matches of one
+// left row by right row. With outer, left rows without a match are kept,
+// paired with no right row.
+func Join(left, right []interface{}, outer bool) ([]Pair, error) {
+	index := make(map[string][]int)
+	for i, key := range right {
+		if k, ok := joinKey(key); ok {
+			index[k] = append(index[k], i)
+		}
+	}
+
+	pairs := []Pair{}
+	add := func(p Pair) error {
+		if len(pairs) == MaxJoinRows {
+			return fmt.Errorf("more than %d joined rows", MaxJoinRows)
+		}
+		pairs = append(pairs, p)
+		return nil
+	}
+	for i, key := range left {
+		var matches []int
+		if k, ok := joinKey(key); ok {
+			matches = index[k]
+		}
+		if len(matches) == 0 && outer {
+			if err := add(Pair{Left: i, Right: -1}); err != nil {
+				return nil, err
+			}
+		}
+		for _, j := range matches {
+			if err := add(Pair{Left: i, Right: j}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return pairs, nil
+}
+
+// joinKey returns the text a join key is matched by
+func joinKey(key interface{}) (string, bool) {
+	if key == nil {
+		return "", false
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
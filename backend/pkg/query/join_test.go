@@ -0,0 +1,34 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoin(t *testing.T) {
+	left := []interface{}{"c1", "c2", nil, float64(1), "c9"}
+	right := []interface{}{"c2", "c1", "c2", "1", nil}
+
+	pairs, err := Join(left, right, false)
+	require.NoError(t, err)
+	assert.Equal(t, []Pair{{0, 1}, {1, 0}, {1, 2}}, pairs, "nulls match nothing and 1 does not match \"1\"")
+
+	pairs, err = Join(left, right, true)
+	require.NoError(t, err)
+	assert.Equal(t, []Pair{{0, 1}, {1, 0}, {1, 2}, {2, -1}, {3, -1}, {4, -1}}, pairs)
+
+	pairs, err = Join(nil, right, true)
+	require.NoError(t, err)
+	assert.Empty(t, pairs)
+}
+
+func TestJoinLimit(t *testing.T) {
+	many := make([]interface{}, 101)
+	for i := range many {
+		many[i] = "k"
+	}
+	_, err := Join(many, many, false)
+	assert.Error(t, err)
+}
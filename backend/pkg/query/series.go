@@ -0,0 +1,61 @@
+package query
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Point is a field's value at a time
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Bucket summarizes the points of one interval
+type Bucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	// Last is the value of the latest point in the interval
+	Last float64 `json:"last"`
+}
+
+// Rollup buckets points by interval, oldest first. Buckets start at
+// multiples of the interval since the zero time, so hourly and daily buckets
+// line up with UTC hours and days. Intervals without points are left out,
+// as are NaN and infinite values.
+func Rollup(points []Point, interval time.Duration) []Bucket {
+	sorted := make([]Point, 0, len(points))
+	for _, p := range points {
+		if !math.IsNaN(p.Value) && !math.IsInf(p.Value, 0) {
+			sorted = append(sorted, p)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	buckets := []Bucket{}
+	var sum float64
+	for _, p := range sorted {
+		start := p.Time.UTC().Truncate(interval)
+		if n := len(buckets); n == 0 || !buckets[n-1].Start.Equal(start) {
+			if n > 0 {
+				buckets[n-1].Avg = sum / float64(buckets[n-1].Count)
+			}
+			buckets = append(buckets, Bucket{Start: start, Min: p.Value, Max: p.Value})
+			sum = 0
+		}
+		b := &buckets[len(buckets)-1]
+		b.Count++
+		b.Min = math.Min(b.Min, p.Value)
+		b.Max = math.Max(b.Max, p.Value)
+		b.Last = p.Value
+		sum += p.Value
+	}
+	if n := len(buckets); n > 0 {
+		buckets[n-1].Avg = sum / float64(buckets[n-1].Count)
+	}
+	return buckets
+}
@@ -0,0 +1,32 @@
+package query
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollup(t *testing.T) {
+	at := func(s string) time.Time {
+		ts, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	points := []Point{
+		{at("2024-01-01T10:45:00Z"), 6},
+		{at("2024-01-01T10:05:00Z"), 2},
+		{at("2024-01-01T10:30:00Z"), 10},
+		{at("2024-01-01T13:00:00Z"), 1},
+		{at("2024-01-01T13:10:00Z"), math.NaN()},
+	}
+	assert.Equal(t, []Bucket{
+		{Start: at("2024-01-01T10:00:00Z"), Count: 3, Min: 2, Max: 10, Avg: 6, Last: 6},
+		{Start: at("2024-01-01T13:00:00Z"), Count: 1, Min: 1, Max: 1, Avg: 1, Last: 1},
+	}, Rollup(points, time.Hour))
+	assert.Len(t, Rollup(points, 24*time.Hour), 1)
+	assert.Empty(t, Rollup(nil, time.Hour))
+}
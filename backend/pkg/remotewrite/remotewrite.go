@@ -0,0 +1,222 @@
+// Package remotewrite decodes Prometheus remote-write requests.
+//
+// A request body is a snappy-compressed (block format) protobuf
+// prometheus.WriteRequest. Only the time series are decoded; metadata and
+// exemplars are skipped. The wire format is read directly with protowire, so
+// the Prometheus module is not a dependency.
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// MetricNameLabel is the label holding a series' metric name
+const MetricNameLabel = "__name__"
+
+// ErrInvalidRequest is returned for bodies that are not a valid remote-write request
+var ErrInvalidRequest = errors.New("invalid remote-write request")
+
+// Series is one time series and its samples
+type Series struct {
+	Labels  map[string]string
+	Samples []Sample
+}
+
+// Sample is one value of a series
+type Sample struct {
+	Timestamp time.Time
+	Value     float64
+}
+
+// Metric returns the series' metric name
+func (s Series) Metric() string {
+	return s.Labels[MetricNameLabel]
+}
+
+// Key returns a canonical rendering of the series' labels, such as
+// `up{instance="a:9100",job="node"}`, that identifies the series
+func (s Series) Key() string {
+	names := make([]string, 0, len(s.Labels))
+	for name := range s.Labels {
+		if name != MetricNameLabel {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(s.Metric())
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", name, s.Labels[name])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// IsStale reports whether v is a Prometheus staleness marker or another
+// value that cannot be represented in JSON
+func IsStale(v float64) bool {
+	return math.IsNaN(v) || math.IsInf(v, 0)
+}
+
+// Decode decompresses and parses a remote-write request body, refusing
+// bodies that decompress to more than maxDecodedBytes
+func Decode(body []byte, maxDecodedBytes int) ([]Series, error) {
+	n, err := snappy.DecodedLen(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	if n > maxDecodedBytes {
+		return nil, fmt.Errorf("%w: decompressed body exceeds %d bytes", ErrInvalidRequest, maxDecodedBytes)
+	}
+	raw, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	var series []Series
+	err = eachField(raw, func(num protowire.Number, typ protowire.Type, b []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		s, err := decodeSeries(b)
+		if err != nil {
+			return err
+		}
+		series = append(series, s)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	return series, nil
+}
+
+// decodeSeries parses a prometheus.TimeSeries
+func decodeSeries(b []byte) (Series, error) {
+	s := Series{Labels: make(map[string]string)}
+	err := eachField(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			name, value, err := decodeLabel(v)
+			if err != nil {
+				return err
+			}
+			s.Labels[name] = value
+		case 2:
+			sample, err := decodeSample(v)
+			if err != nil {
+				return err
+			}
+			s.Samples = append(s.Samples, sample)
+		}
+		return nil
+	})
+	if err != nil {
+		return Series{}, err
+	}
+	if s.Metric() == "" {
+		return Series{}, errors.New("time series has no metric name")
+	}
+	return s, nil
+}
+
+// decodeLabel parses a prometheus.Label
+func decodeLabel(b []byte) (name, value string, err error) {
+	err = eachField(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			name = string(v)
+		case 2:
+			value = string(v)
+		}
+		return nil
+	})
+	return name, value, err
+}
+
+// decodeSample parses a prometheus.Sample
+func decodeSample(b []byte) (Sample, error) {
+	var value float64
+	var ms int64
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return Sample{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return Sample{}, protowire.ParseError(n)
+			}
+			value = math.Float64frombits(v)
+			b = b[n:]
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return Sample{}, protowire.ParseError(n)
+			}
+			ms = int64(v)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return Sample{}, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return Sample{Timestamp: time.UnixMilli(ms).UTC(), Value: value}, nil
+}
+
+// eachField calls fn with every field of a message. For length-delimited
+// fields v is the field's bytes; other fields are skipped with v nil.
+func eachField(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			if err := fn(num, typ, v); err != nil {
+				return err
+			}
+			b = b[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		if err := fn(num, typ, nil); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
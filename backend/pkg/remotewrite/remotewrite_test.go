@@ -0,0 +1,81 @@
+package remotewrite
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// encode builds a snappy-compressed WriteRequest the way Prometheus does
+func encode(series ...Series) []byte {
+	var req []byte
+	for _, s := range series {
+		var ts []byte
+		for name, value := range s.Labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		for _, sample := range s.Samples {
+			var sm []byte
+			sm = protowire.AppendTag(sm, 1, protowire.Fixed64Type)
+			sm = protowire.AppendFixed64(sm, math.Float64bits(sample.Value))
+			sm = protowire.AppendTag(sm, 2, protowire.VarintType)
+			sm = protowire.AppendVarint(sm, uint64(sample.Timestamp.UnixMilli()))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, sm)
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	// Metadata (field 3) is skipped by the decoder
+	req = protowire.AppendTag(req, 3, protowire.BytesType)
+	req = protowire.AppendBytes(req, []byte{0x08, 0x01})
+	return snappy.Encode(nil, req)
+}
+
+func TestDecode(t *testing.T) {
+	at := time.UnixMilli(1714650000123).UTC()
+	body := encode(Series{
+		Labels:  map[string]string{MetricNameLabel: "up", "job": "node", "instance": "a:9100"},
+		Samples: []Sample{{Timestamp: at, Value: 1}, {Timestamp: at.Add(15 * time.Second), Value: 0}},
+	})
+
+	series, err := Decode(body, 1<<20)
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+	assert.Equal(t, "up", series[0].Metric())
+	assert.Equal(t, `up{instance="a:9100",job="node"}`, series[0].Key())
+	assert.Equal(t, []Sample{{Timestamp: at, Value: 1}, {Timestamp: at.Add(15 * time.Second), Value: 0}}, series[0].Samples)
+}
+
+func TestDecodeRejectsInvalidBodies(t *testing.T) {
+	_, err := Decode([]byte("not snappy"), 1<<20)
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+
+	_, err = Decode(snappy.Encode(nil, []byte{0x0a, 0xff}), 1<<20)
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+
+	noName := encode(Series{Labels: map[string]string{"job": "node"}})
+	_, err = Decode(noName, 1<<20)
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+
+	big := encode(Series{Labels: map[string]string{MetricNameLabel: "up"}, Samples: make([]Sample, 100)})
+	_, err = Decode(big, 64)
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+}
+
+func TestIsStale(t *testing.T) {
+	assert.True(t, IsStale(math.NaN()))
+	assert.True(t, IsStale(math.Inf(1)))
+	assert.False(t, IsStale(0))
+}
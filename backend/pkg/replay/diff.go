@@ -0,0 +1,98 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Diff lists how a replayed response differs from the recorded one. Values
+// under the ignored JSON keys, such as server-generated timestamps, are not
+// compared, and neither are values that were redacted when recording.
+func Diff(recorded, replayed Response, ignore []string) []string {
+	var diffs []string
+	if recorded.Status != replayed.Status {
+		diffs = append(diffs, fmt.Sprintf("status: recorded %d, replayed %d", recorded.Status, replayed.Status))
+	}
+	if recorded.Truncated {
+		return diffs
+	}
+
+	if len(recorded.Body) > 0 && len(replayed.Body) > 0 {
+		var want, got interface{}
+		if json.Unmarshal(recorded.Body, &want) == nil && json.Unmarshal(replayed.Body, &got) == nil {
+			skip := make(map[string]bool, len(ignore))
+			for _, k := range ignore {
+				skip[k] = true
+			}
+			return diffValue("body", want, got, skip, diffs)
+		}
+	}
+	if !bytes.Equal(Payload(recorded.Body, recorded.Text), Payload(replayed.Body, replayed.Text)) {
+		diffs = append(diffs, "body: recorded and replayed bodies differ")
+	}
+	return diffs
+}
+
+func diffValue(path string, want, got interface{}, skip map[string]bool, diffs []string) []string {
+	if want == Redacted {
+		return diffs
+	}
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(w)+len(g))
+		for k := range w {
+			keys = append(keys, k)
+		}
+		for k := range g {
+			if _, ok := w[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if skip[k] {
+				continue
+			}
+			child := path + "." + k
+			wv, inWant := w[k]
+			gv, inGot := g[k]
+			switch {
+			case !inWant:
+				diffs = append(diffs, fmt.Sprintf("%s: not recorded, replayed %s", child, show(gv)))
+			case !inGot:
+				diffs = append(diffs, fmt.Sprintf("%s: recorded %s, missing from replay", child, show(wv)))
+			default:
+				diffs = diffValue(child, wv, gv, skip, diffs)
+			}
+		}
+		return diffs
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok {
+			break
+		}
+		if len(w) != len(g) {
+			return append(diffs, fmt.Sprintf("%s: recorded %d items, replayed %d", path, len(w), len(g)))
+		}
+		for i := range w {
+			diffs = diffValue(fmt.Sprintf("%s[%d]", path, i), w[i], g[i], skip, diffs)
+		}
+		return diffs
+	}
+	if !reflect.DeepEqual(want, got) {
+		diffs = append(diffs, fmt.Sprintf("%s: recorded %s, replayed %s", path, show(want), show(got)))
+	}
+	return diffs
+}
+
+func show(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,273 @@
+// Package replay records API requests together with the store calls made
+// while serving them, so that a request can later be re-executed against a
+// local store in exactly the state it saw.
+//
+// A Bundle is written for each recorded request. Credentials and secret
+// values are redacted before a bundle is built, so bundles can be attached
+// to bug reports.
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elibdev/notably/db"
+)
+
+// Version is the bundle format written by this package
+const Version = 1
+
+// Redacted replaces every redacted header and JSON value
+const Redacted = "[REDACTED]"
+
+// redactedHeaders are never recorded in the clear
+var redactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// redactedFields are JSON keys whose values are always redacted, at any depth
+var redactedFields = []string{"password", "apiKey", "token", "secret", "signingSecret", "sealedSecret"}
+
+// Bundle is one recorded request: what the client sent, what it got back and
+// every store call made in between
+type Bundle struct {
+	Version    int       `json:"version"`
+	RequestID  string    `json:"requestId,omitempty"`
+	RecordedAt time.Time `json:"recordedAt"`
+	Table      string    `json:"table"` // the server's base DynamoDB table
+	User       *User     `json:"user,omitempty"`
+	Request    Request   `json:"request"`
+	Response   Response  `json:"response"`
+	Calls      []Call    `json:"calls"`
+}
+
+// User is the account a recorded request was authenticated as
+type User struct {
+	ID          string   `json:"id"`
+	Username    string   `json:"username"`
+	StorageMode string   `json:"storageMode,omitempty"`
+	Scopes      []string `json:"scopes,omitempty"`
+}
+
+// Request is a recorded HTTP request. JSON bodies are kept as JSON so they
+// can be redacted and read; anything else is kept as text.
+type Request struct {
+	Method string          `json:"method"`
+	URL    string          `json:"url"`
+	Header http.Header     `json:"header,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Text   string          `json:"text,omitempty"`
+}
+
+// Response is a recorded HTTP response
+type Response struct {
+	Status    int             `json:"status"`
+	Header    http.Header     `json:"header,omitempty"`
+	Body      json.RawMessage `json:"body,omitempty"`
+	Text      string          `json:"text,omitempty"`
+	Truncated bool            `json:"truncated,omitempty"`
+}
+
+// Call is one store call. Facts holds what a read returned or what a write
+// stored; a failed call keeps its error and error kind instead.
+type Call struct {
+	Table     string    `json:"table"`
+	User      string    `json:"user"`
+	Op        string    `json:"op"`
+	Namespace string    `json:"namespace,omitempty"`
+	FieldName string    `json:"fieldName,omitempty"`
+	ID        string    `json:"id,omitempty"`
+	Facts     []db.Fact `json:"facts,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Kind      string    `json:"kind,omitempty"`
+}
+
+// kinds maps recorded error kinds back to the db error kinds
+var kinds = map[string]error{
+	db.ErrNotFound.Error():        db.ErrNotFound,
+	db.ErrConditionFailed.Error(): db.ErrConditionFailed,
+	db.ErrThrottled.Error():       db.ErrThrottled,
+	db.ErrValidation.Error():      db.ErrValidation,
+}
+
+// Err rebuilds the error a call returned, with its original kind, or nil
+// when the call succeeded
+func (c Call) Err() error {
+	if c.Error == "" {
+		return nil
+	}
+	return &db.StoreError{Operation: c.Op, Kind: kinds[c.Kind], Err: errors.New(c.Error)}
+}
+
+// Recorder collects the store calls of one request. A nil *Recorder ignores
+// everything, so callers need not check whether a request is being recorded.
+type Recorder struct {
+	mu     sync.Mutex
+	user   *User
+	calls  []Call
+	redact map[string]bool
+}
+
+type recorderKey struct{}
+
+// NewRecorder returns a recorder for one request
+func NewRecorder() *Recorder {
+	rec := &Recorder{redact: make(map[string]bool)}
+	rec.Redact(redactedFields...)
+	return rec
+}
+
+// WithRecorder returns a context whose store calls are recorded by rec
+func WithRecorder(ctx context.Context, rec *Recorder) context.Context {
+	return context.WithValue(ctx, recorderKey{}, rec)
+}
+
+// FromContext returns the request's recorder, or nil when it is not recorded
+func FromContext(ctx context.Context) *Recorder {
+	rec, _ := ctx.Value(recorderKey{}).(*Recorder)
+	return rec
+}
+
+// SetUser notes the account the request is authenticated as
+func (r *Recorder) SetUser(u User) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.user = &u
+}
+
+// Redact adds JSON keys whose values are removed from the recorded request
+// and response, such as the encrypted columns of a table the request touched
+func (r *Recorder) Redact(fields ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range fields {
+		r.redact[strings.ToLower(f)] = true
+	}
+}
+
+func (r *Recorder) add(c Call) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, c)
+}
+
+// Bundle builds the redacted bundle of the request
+func (r *Recorder) Bundle(req Request, resp Response) *Bundle {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	req.Header = r.redactHeader(req.Header)
+	req.Body = r.redactJSON(req.Body)
+	resp.Header = r.redactHeader(resp.Header)
+	resp.Body = r.redactJSON(resp.Body)
+	return &Bundle{
+		Version:    Version,
+		RecordedAt: time.Now().UTC(),
+		User:       r.user,
+		Request:    req,
+		Response:   resp,
+		Calls:      append([]Call(nil), r.calls...),
+	}
+}
+
+func (r *Recorder) redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range redactedHeaders {
+		if _, ok := h[name]; ok {
+			h.Set(name, Redacted)
+		}
+	}
+	return h
+}
+
+func (r *Recorder) redactJSON(body json.RawMessage) json.RawMessage {
+	if len(body) == 0 {
+		return body
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return body
+	}
+	out, err := json.Marshal(r.redactValue(v))
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+func (r *Recorder) redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if r.redact[strings.ToLower(k)] {
+				v[k] = Redacted
+			} else {
+				v[k] = r.redactValue(child)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = r.redactValue(child)
+		}
+	}
+	return v
+}
+
+// SetBody stores body in the JSON field when it is valid JSON and as text otherwise
+func SetBody(body []byte, raw *json.RawMessage, text *string) {
+	if len(body) == 0 {
+		return
+	}
+	if json.Valid(body) {
+		*raw = append(json.RawMessage(nil), body...)
+		return
+	}
+	*text = string(body)
+}
+
+// Payload returns the body of a recorded request or response
+func Payload(raw json.RawMessage, text string) []byte {
+	if len(raw) > 0 {
+		return raw
+	}
+	return []byte(text)
+}
+
+// WriteFile writes a bundle as indented JSON
+func WriteFile(path string, b *Bundle) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// ReadFile reads a bundle written by WriteFile
+func ReadFile(path string) (*Bundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("decoding bundle: %w", err)
+	}
+	if b.Version != Version {
+		return nil, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	return &b, nil
+}
@@ -0,0 +1,87 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorderRedacts(t *testing.T) {
+	rec := NewRecorder()
+	ctx := WithRecorder(context.Background(), rec)
+	mem := db.NewMemoryStore()
+	store := NewStore(mem, "Facts", "u1")
+
+	// A sealed column seen by the store is redacted from the bodies
+	sealed := &db.Fact{ID: "f1", Timestamp: time.Now().UTC(), Namespace: "u1/t", FieldName: "r1", DataType: db.DataTypeJSON,
+		Value: json.RawMessage(`{"ssn": {"$encrypted": {"kid": "k"}}, "name": "Ann"}`)}
+	require.NoError(t, store.PutFact(ctx, sealed))
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer nb_secret")
+	header.Set("Content-Type", "application/json")
+	b := rec.Bundle(
+		Request{Method: http.MethodPost, URL: "/tables/t/rows", Header: header, Body: json.RawMessage(`{"values": {"ssn": "123", "name": "Ann"}}`)},
+		Response{Status: http.StatusCreated, Body: json.RawMessage(`{"apiKey": "nb_x", "nested": [{"Password": "pw"}]}`)},
+	)
+	assert.Equal(t, Redacted, b.Request.Header.Get("Authorization"))
+	assert.Equal(t, "application/json", b.Request.Header.Get("Content-Type"))
+	assert.JSONEq(t, `{"values": {"ssn": "[REDACTED]", "name": "Ann"}}`, string(b.Request.Body))
+	assert.JSONEq(t, `{"apiKey": "[REDACTED]", "nested": [{"Password": "[REDACTED]"}]}`, string(b.Response.Body))
+	require.Len(t, b.Calls, 1)
+	assert.Equal(t, Call{Table: "Facts", User: "u1", Op: OpPutFact, Facts: []db.Fact{*sealed}}, b.Calls[0])
+
+	// Calls outside a recorded request are not recorded
+	_, err := store.GetFact(context.Background(), "f1")
+	require.NoError(t, err)
+	assert.Len(t, rec.Bundle(Request{}, Response{}).Calls, 1)
+}
+
+func TestPlayback(t *testing.T) {
+	now := time.Now().UTC()
+	read := db.Fact{ID: "a", Timestamp: now.Add(-time.Hour), Namespace: "u1/t", FieldName: "r1", DataType: db.DataTypeJSON, Value: json.RawMessage(`{}`)}
+	wrote := db.Fact{ID: "b", Timestamp: now, Namespace: "u1/t", FieldName: "r2", DataType: db.DataTypeJSON, Value: json.RawMessage(`{}`)}
+	calls := []Call{
+		{Table: "Facts", User: "u1", Op: OpQueryByNamespace, Namespace: "u1/t", Facts: []db.Fact{read}},
+		{Table: "Facts", User: "u1", Op: OpPutFact, Error: "throttled", Kind: db.ErrThrottled.Error()},
+		{Table: "Facts", User: "u1", Op: OpPutFact, Facts: []db.Fact{wrote}},
+		{Table: "Facts", User: "u1", Op: OpGetSnapshotAtTime, Facts: []db.Fact{read, wrote}},
+		{Table: "Facts", User: "u2", Op: OpGetFact, Facts: []db.Fact{{ID: "c", Timestamp: now}}},
+	}
+	store := NewPlayback(calls).Store("Facts", "u1")
+	ctx := context.Background()
+
+	snap, err := store.GetSnapshotAtTime(ctx, "", now)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]db.Fact{"u1/t#r1": read}, snap, "facts the request wrote are not seeded")
+
+	assert.ErrorIs(t, store.PutFact(ctx, &wrote), db.ErrThrottled)
+	assert.NoError(t, store.PutFact(ctx, &wrote))
+	_, err = store.GetFact(ctx, "c")
+	assert.True(t, db.IsNotFound(err), "other users' facts stay in their own store")
+}
+
+func TestDiff(t *testing.T) {
+	recorded := Response{Status: 200, Body: json.RawMessage(`{"id": "r1", "timestamp": "t1", "values": {"a": 1, "b": "[REDACTED]"}, "rows": [1, 2]}`)}
+
+	same := Response{Status: 200, Body: json.RawMessage(`{"id": "r1", "timestamp": "t2", "values": {"a": 1, "b": "secret"}, "rows": [1, 2]}`)}
+	assert.Empty(t, Diff(recorded, same, []string{"timestamp"}))
+
+	changed := Response{Status: 500, Body: json.RawMessage(`{"id": "r2", "timestamp": "t1", "values": {"c": true}, "rows": [1]}`)}
+	assert.Equal(t, []string{
+		"status: recorded 200, replayed 500",
+		`body.id: recorded "r1", replayed "r2"`,
+		"body.rows: recorded 2 items, replayed 1",
+		"body.values.a: recorded 1, missing from replay",
+		"body.values.c: not recorded, replayed true",
+	}, Diff(recorded, changed, []string{"timestamp", "b"}))
+
+	assert.Equal(t, []string{"body: recorded and replayed bodies differ"},
+		Diff(Response{Status: 200, Text: "ok"}, Response{Status: 200, Text: "no"}, nil))
+}
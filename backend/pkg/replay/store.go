@@ -0,0 +1,325 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/elibdev/notably/db"
+)
+
+// How the server and crypto.Store mark sealed values in facts
+const (
+	secretDataType = "secret"
+	encryptedKey   = "$encrypted"
+)
+
+// Store operations, as recorded in Call.Op
+const (
+	OpPutFact               = "PutFact"
+	OpPutFactsTransactional = "PutFactsTransactional"
+	OpGetFact               = "GetFact"
+	OpDeleteFact            = "DeleteFact"
+	OpPurgeFact             = "PurgeFact"
+	OpQueryByField          = "QueryByField"
+	OpQueryByTimeRange      = "QueryByTimeRange"
+	OpQueryByNamespace      = "QueryByNamespace"
+	OpQueryByActor          = "QueryByActor"
+	OpGetSnapshotAtTime     = "GetSnapshotAtTime"
+)
+
+// Store is a db.Store that records the calls made with a recorded request's
+// context. Calls with any other context pass straight through.
+type Store struct {
+	db.Store
+	table string
+	user  string
+}
+
+var _ db.Store = (*Store)(nil)
+
+// NewStore wraps the store of one user on one DynamoDB table
+func NewStore(inner db.Store, table, user string) *Store {
+	return &Store{Store: inner, table: table, user: user}
+}
+
+func (s *Store) record(ctx context.Context, c Call, facts []db.Fact, err error) {
+	rec := FromContext(ctx)
+	if rec == nil {
+		return
+	}
+	c.Table, c.User = s.table, s.user
+	if err != nil {
+		c.Error = err.Error()
+		if kind := db.KindOf(err); kind != nil {
+			c.Kind = kind.Error()
+		}
+	} else {
+		c.Facts = facts
+	}
+	rec.add(c)
+	rec.redactSealed(facts)
+}
+
+// redactSealed redacts the columns a request saw in sealed form: their
+// plaintext appears in the request or response. Secret rows are sealed whole,
+// so all row values are redacted.
+func (r *Recorder) redactSealed(facts []db.Fact) {
+	for _, f := range facts {
+		switch {
+		case f.DataType == secretDataType:
+			r.Redact("values")
+		case f.DataType == db.DataTypeJSON && bytes.Contains(f.Value, []byte(encryptedKey)):
+			var values map[string]json.RawMessage
+			if json.Unmarshal(f.Value, &values) != nil {
+				continue
+			}
+			for col, raw := range values {
+				var wrapped map[string]json.RawMessage
+				if json.Unmarshal(raw, &wrapped) == nil && wrapped[encryptedKey] != nil {
+					r.Redact(col)
+				}
+			}
+		}
+	}
+}
+
+// PutFact stores and records a fact
+func (s *Store) PutFact(ctx context.Context, fact *db.Fact) error {
+	err := s.Store.PutFact(ctx, fact)
+	var facts []db.Fact
+	if fact != nil {
+		facts = []db.Fact{*fact}
+	}
+	s.record(ctx, Call{Op: OpPutFact}, facts, err)
+	return err
+}
+
+// PutFactsTransactional stores and records a transaction
+func (s *Store) PutFactsTransactional(ctx context.Context, facts []*db.Fact) error {
+	err := s.Store.PutFactsTransactional(ctx, facts)
+	stored := make([]db.Fact, 0, len(facts))
+	for _, f := range facts {
+		if f != nil {
+			stored = append(stored, *f)
+		}
+	}
+	s.record(ctx, Call{Op: OpPutFactsTransactional}, stored, err)
+	return err
+}
+
+// GetFact returns and records a fact
+func (s *Store) GetFact(ctx context.Context, id string) (*db.Fact, error) {
+	fact, err := s.Store.GetFact(ctx, id)
+	var facts []db.Fact
+	if fact != nil {
+		facts = []db.Fact{*fact}
+	}
+	s.record(ctx, Call{Op: OpGetFact, ID: id}, facts, err)
+	return fact, err
+}
+
+// DeleteFact deletes a fact and records the call
+func (s *Store) DeleteFact(ctx context.Context, id string) error {
+	err := s.Store.DeleteFact(ctx, id)
+	s.record(ctx, Call{Op: OpDeleteFact, ID: id}, nil, err)
+	return err
+}
+
+// PurgeFact purges a fact version and records the call
+func (s *Store) PurgeFact(ctx context.Context, fact *db.Fact) error {
+	err := s.Store.PurgeFact(ctx, fact)
+	call := Call{Op: OpPurgeFact}
+	if fact != nil {
+		call.ID = fact.ID
+	}
+	s.record(ctx, call, nil, err)
+	return err
+}
+
+// QueryByField runs and records a field query
+func (s *Store) QueryByField(ctx context.Context, namespace, fieldName string, opts db.QueryOptions) (*db.QueryResult, error) {
+	result, err := s.Store.QueryByField(ctx, namespace, fieldName, opts)
+	s.record(ctx, Call{Op: OpQueryByField, Namespace: namespace, FieldName: fieldName}, resultFacts(result), err)
+	return result, err
+}
+
+// QueryByTimeRange runs and records a time range query
+func (s *Store) QueryByTimeRange(ctx context.Context, opts db.QueryOptions) (*db.QueryResult, error) {
+	result, err := s.Store.QueryByTimeRange(ctx, opts)
+	s.record(ctx, Call{Op: OpQueryByTimeRange}, resultFacts(result), err)
+	return result, err
+}
+
+// QueryByNamespace runs and records a namespace query
+func (s *Store) QueryByNamespace(ctx context.Context, namespace string, opts db.QueryOptions) (*db.QueryResult, error) {
+	result, err := s.Store.QueryByNamespace(ctx, namespace, opts)
+	s.record(ctx, Call{Op: OpQueryByNamespace, Namespace: namespace}, resultFacts(result), err)
+	return result, err
+}
+
+// QueryByActor runs and records an actor query
+func (s *Store) QueryByActor(ctx context.Context, actorUserID string, opts db.QueryOptions) (*db.QueryResult, error) {
+	result, err := s.Store.QueryByActor(ctx, actorUserID, opts)
+	s.record(ctx, Call{Op: OpQueryByActor}, resultFacts(result), err)
+	return result, err
+}
+
+// GetSnapshotAtTime takes and records a snapshot
+func (s *Store) GetSnapshotAtTime(ctx context.Context, namespace string, at time.Time) (map[string]db.Fact, error) {
+	snapshot, err := s.Store.GetSnapshotAtTime(ctx, namespace, at)
+	facts := make([]db.Fact, 0, len(snapshot))
+	for _, f := range snapshot {
+		facts = append(facts, f)
+	}
+	s.record(ctx, Call{Op: OpGetSnapshotAtTime, Namespace: namespace}, facts, err)
+	return snapshot, err
+}
+
+func resultFacts(result *db.QueryResult) []db.Fact {
+	if result == nil {
+		return nil
+	}
+	return result.Facts
+}
+
+// Playback rebuilds the stores a recorded request used
+type Playback struct {
+	calls []Call
+}
+
+// NewPlayback returns the playback of a bundle's store calls
+func NewPlayback(calls []Call) *Playback {
+	return &Playback{calls: calls}
+}
+
+// Store returns an in-memory store holding every fact the request read from
+// the given user's store on the given table, apart from the ones it wrote
+// itself. Calls fail in the same order as when they were recorded, so error
+// paths replay too.
+func (p *Playback) Store(table, user string) db.Store {
+	mem := db.NewMemoryStore()
+	written := make(map[string]bool)
+	for _, c := range p.calls {
+		if c.Table == table && c.User == user && (c.Op == OpPutFact || c.Op == OpPutFactsTransactional) {
+			for _, f := range c.Facts {
+				written[factKey(f)] = true
+			}
+		}
+	}
+
+	faults := make(map[string][]error)
+	for _, c := range p.calls {
+		if c.Table != table || c.User != user {
+			continue
+		}
+		faults[c.Op] = append(faults[c.Op], c.Err())
+		switch c.Op {
+		case OpPutFact, OpPutFactsTransactional, OpDeleteFact, OpPurgeFact:
+			continue
+		}
+		for _, f := range c.Facts {
+			if !written[factKey(f)] {
+				fact := f
+				_ = mem.PutFact(context.Background(), &fact)
+			}
+		}
+	}
+	return &playbackStore{MemoryStore: mem, faults: faults}
+}
+
+func factKey(f db.Fact) string {
+	return f.Timestamp.Format(time.RFC3339Nano) + "#" + f.ID
+}
+
+// playbackStore is a seeded memory store that fails the calls that failed
+// when the request was recorded
+type playbackStore struct {
+	*db.MemoryStore
+	mu     sync.Mutex
+	faults map[string][]error
+}
+
+// fault returns the recorded error of the next call of op, if it had one
+func (s *playbackStore) fault(op string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := s.faults[op]
+	if len(queue) == 0 {
+		return nil
+	}
+	s.faults[op] = queue[1:]
+	return queue[0]
+}
+
+func (s *playbackStore) PutFact(ctx context.Context, fact *db.Fact) error {
+	if err := s.fault(OpPutFact); err != nil {
+		return err
+	}
+	return s.MemoryStore.PutFact(ctx, fact)
+}
+
+func (s *playbackStore) PutFactsTransactional(ctx context.Context, facts []*db.Fact) error {
+	if err := s.fault(OpPutFactsTransactional); err != nil {
+		return err
+	}
+	return s.MemoryStore.PutFactsTransactional(ctx, facts)
+}
+
+func (s *playbackStore) GetFact(ctx context.Context, id string) (*db.Fact, error) {
+	if err := s.fault(OpGetFact); err != nil {
+		return nil, err
+	}
+	return s.MemoryStore.GetFact(ctx, id)
+}
+
+func (s *playbackStore) DeleteFact(ctx context.Context, id string) error {
+	if err := s.fault(OpDeleteFact); err != nil {
+		return err
+	}
+	return s.MemoryStore.DeleteFact(ctx, id)
+}
+
+func (s *playbackStore) PurgeFact(ctx context.Context, fact *db.Fact) error {
+	if err := s.fault(OpPurgeFact); err != nil {
+		return err
+	}
+	return s.MemoryStore.PurgeFact(ctx, fact)
+}
+
+func (s *playbackStore) QueryByField(ctx context.Context, namespace, fieldName string, opts db.QueryOptions) (*db.QueryResult, error) {
+	if err := s.fault(OpQueryByField); err != nil {
+		return nil, err
+	}
+	return s.MemoryStore.QueryByField(ctx, namespace, fieldName, opts)
+}
+
+func (s *playbackStore) QueryByTimeRange(ctx context.Context, opts db.QueryOptions) (*db.QueryResult, error) {
+	if err := s.fault(OpQueryByTimeRange); err != nil {
+		return nil, err
+	}
+	return s.MemoryStore.QueryByTimeRange(ctx, opts)
+}
+
+func (s *playbackStore) QueryByNamespace(ctx context.Context, namespace string, opts db.QueryOptions) (*db.QueryResult, error) {
+	if err := s.fault(OpQueryByNamespace); err != nil {
+		return nil, err
+	}
+	return s.MemoryStore.QueryByNamespace(ctx, namespace, opts)
+}
+
+func (s *playbackStore) QueryByActor(ctx context.Context, actorUserID string, opts db.QueryOptions) (*db.QueryResult, error) {
+	if err := s.fault(OpQueryByActor); err != nil {
+		return nil, err
+	}
+	return s.MemoryStore.QueryByActor(ctx, actorUserID, opts)
+}
+
+func (s *playbackStore) GetSnapshotAtTime(ctx context.Context, namespace string, at time.Time) (map[string]db.Fact, error) {
+	if err := s.fault(OpGetSnapshotAtTime); err != nil {
+		return nil, err
+	}
+	return s.MemoryStore.GetSnapshotAtTime(ctx, namespace, at)
+}
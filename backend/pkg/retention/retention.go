@@ -0,0 +1,66 @@
+// Package retention selects the row versions removed by a table's retention
+// policy.
+package retention
+
+import (
+	"sort"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+)
+
+// Policy limits how much history a table keeps. The zero Policy keeps
+// everything.
+type Policy struct {
+	// MaxAge removes versions older than this; zero keeps them
+	MaxAge time.Duration
+	// MaxVersions keeps at most this many versions of each row; zero keeps
+	// them all and 1 keeps only the latest
+	MaxVersions int
+}
+
+// IsZero reports whether the policy keeps all history
+func (p Policy) IsZero() bool {
+	return p.MaxAge <= 0 && p.MaxVersions <= 0
+}
+
+// Expired returns the versions of a table's rows that the policy removes at
+// now, oldest first, so that purging them in order never exposes an older
+// state. The newest version of each row is always kept, which preserves its
+// current state. The one exception is a deletion whose older versions are all
+// removed and that the policy would otherwise remove itself: with nothing
+// left for it to hide, the row is dropped entirely.
+func Expired(facts []dynamo.Fact, p Policy, now time.Time) []dynamo.Fact {
+	if p.IsZero() {
+		return nil
+	}
+	cutoff := now.Add(-p.MaxAge)
+	expired := func(f dynamo.Fact, version int) bool {
+		return (p.MaxVersions > 0 && version >= p.MaxVersions) || (p.MaxAge > 0 && f.Timestamp.Before(cutoff))
+	}
+
+	rows := make(map[string][]dynamo.Fact)
+	for _, f := range facts {
+		rows[f.FieldName] = append(rows[f.FieldName], f)
+	}
+
+	var out []dynamo.Fact
+	for _, versions := range rows {
+		// Newest first
+		sort.Slice(versions, func(i, j int) bool { return versions[i].Timestamp.After(versions[j].Timestamp) })
+		all := true
+		for i, f := range versions[1:] {
+			if expired(f, i+1) {
+				out = append(out, f)
+			} else {
+				all = false
+			}
+		}
+		latest := versions[0]
+		if latest.IsTombstone() && all && (p.MaxVersions > 0 || expired(latest, 0)) {
+			out = append(out, latest)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Timestamp.Before(out[j].Timestamp) })
+	return out
+}
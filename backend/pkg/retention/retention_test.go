@@ -0,0 +1,63 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+
+func version(id, row string, age time.Duration, value interface{}) dynamo.Fact {
+	return dynamo.Fact{ID: id, FieldName: row, DataType: "json", Timestamp: now.Add(-age), Value: value}
+}
+
+func ids(facts []dynamo.Fact) []string {
+	out := []string{}
+	for _, f := range facts {
+		out = append(out, f.ID)
+	}
+	return out
+}
+
+func TestExpiredMaxAge(t *testing.T) {
+	day := 24 * time.Hour
+	facts := []dynamo.Fact{
+		version("a1", "a", 100*day, map[string]interface{}{"v": 1}),
+		version("a2", "a", 95*day, map[string]interface{}{"v": 2}),
+		version("a3", "a", 10*day, map[string]interface{}{"v": 3}),
+		// Only old versions: the newest is kept as the current state
+		version("b1", "b", 200*day, map[string]interface{}{"v": 1}),
+		version("b2", "b", 150*day, map[string]interface{}{"v": 2}),
+		// Deleted long ago: dropped entirely, tombstone last
+		version("c1", "c", 120*day, map[string]interface{}{"v": 1}),
+		version("c2", "c", 110*day, nil),
+		// Deleted recently: the deletion stays in history
+		version("d1", "d", 121*day, map[string]interface{}{"v": 1}),
+		version("d2", "d", 5*day, nil),
+	}
+
+	got := Expired(facts, Policy{MaxAge: 90 * day}, now)
+	assert.Equal(t, []string{"b1", "d1", "c1", "c2", "a1", "a2"}, ids(got))
+}
+
+func TestExpiredMaxVersions(t *testing.T) {
+	facts := []dynamo.Fact{
+		version("a1", "a", 3*time.Hour, map[string]interface{}{"v": 1}),
+		version("a2", "a", 2*time.Hour, map[string]interface{}{"v": 2}),
+		version("a3", "a", time.Hour, map[string]interface{}{"v": 3}),
+		version("b1", "b", 90*time.Minute, map[string]interface{}{"v": 1}),
+		version("b2", "b", 30*time.Minute, nil),
+	}
+
+	assert.Equal(t, []string{"a1"}, ids(Expired(facts, Policy{MaxVersions: 2}, now)))
+	assert.Equal(t, []string{"a1", "a2", "b1", "b2"}, ids(Expired(facts, Policy{MaxVersions: 1}, now)))
+}
+
+func TestExpiredZeroPolicyKeepsEverything(t *testing.T) {
+	facts := []dynamo.Fact{version("a1", "a", 1000*time.Hour, nil)}
+	assert.True(t, Policy{}.IsZero())
+	assert.Empty(t, Expired(facts, Policy{}, now))
+}
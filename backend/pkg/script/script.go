@@ -0,0 +1,373 @@
+// Package script runs small user-defined Starlark programs in a sandbox.
+//
+// A script defines a transform(event) function that receives a row event and
+// returns the row values to store, or None to keep them unchanged. Scripts
+// cannot load modules or perform I/O, and every run is bounded by an
+// execution step budget, a wall-clock timeout and a memory budget.
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"runtime/metrics"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	starjson "go.starlark.net/lib/json"
+	starmath "go.starlark.net/lib/math"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// EntryPoint is the function every script must define
+const EntryPoint = "transform"
+
+// Limits bounds the resources a single script run may use
+type Limits struct {
+	// MaxSourceBytes caps the size of a script's source
+	MaxSourceBytes int
+	// MaxSteps caps the number of interpreter steps, bounding CPU time
+	MaxSteps uint64
+	// Timeout caps the wall-clock duration of a run
+	Timeout time.Duration
+	// MaxMemoryBytes caps heap growth during a run. Growth is sampled on the
+	// process heap, so it is approximate when many scripts run at once.
+	MaxMemoryBytes uint64
+}
+
+// DefaultLimits are used for any zero field of a Limits value
+var DefaultLimits = Limits{
+	MaxSourceBytes: 64 << 10,
+	MaxSteps:       1_000_000,
+	Timeout:        time.Second,
+	MaxMemoryBytes: 64 << 20,
+}
+
+var (
+	// ErrInvalidScript is returned for scripts that fail to compile
+	ErrInvalidScript = errors.New("invalid script")
+	// ErrLimitExceeded is returned when a run exhausts one of its limits
+	ErrLimitExceeded = errors.New("script limit exceeded")
+)
+
+// Event is the row event passed to a script's transform function
+type Event struct {
+	Type      string                 // "create", "update" or "expire"
+	Table     string                 // table name
+	Row       string                 // row ID
+	Timestamp time.Time              // time of the write, or of the expiry
+	Values    map[string]interface{} // values being written
+	Previous  map[string]interface{} // current values before an update or expiry, nil on create
+}
+
+// Program is a compiled script, safe for concurrent use
+type Program struct {
+	name   string
+	prog   *starlark.Program
+	limits Limits
+}
+
+// predeclared holds the names available to every script
+var predeclared = starlark.StringDict{
+	"json": starjson.Module,
+	"math": starmath.Module,
+}
+
+// fileOptions disallows top-level control flow so module initialization stays trivial
+var fileOptions = &syntax.FileOptions{Set: true, While: true}
+
+// Compile parses a script and checks that it defines transform(event).
+// limits apply to compilation and to every later run.
+func Compile(name, src string, limits Limits) (*Program, error) {
+	limits = limits.withDefaults()
+	if len(src) > limits.MaxSourceBytes {
+		return nil, fmt.Errorf("%w: source exceeds %d bytes", ErrInvalidScript, limits.MaxSourceBytes)
+	}
+
+	_, prog, err := starlark.SourceProgramOptions(fileOptions, name, src, predeclared.Has)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidScript, err)
+	}
+
+	p := &Program{name: name, prog: prog, limits: limits}
+	if _, err := p.globals(context.Background()); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Transform runs the script's transform function on ev. It returns the values
+// to write, or nil when the script returned None.
+func (p *Program) Transform(ctx context.Context, ev Event) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.limits.Timeout)
+	defer cancel()
+
+	r := p.start(ctx)
+	defer r.stop()
+
+	globals, err := p.prog.Init(r.thread, predeclared)
+	if err != nil {
+		return nil, p.runError(r, err)
+	}
+	fn := globals[EntryPoint]
+
+	arg, err := toStarlark(eventValue(ev))
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := starlark.Call(r.thread, fn, starlark.Tuple{arg}, nil)
+	if err != nil {
+		return nil, p.runError(r, err)
+	}
+	if result == starlark.None {
+		return nil, nil
+	}
+
+	out, err := fromStarlark(result)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s() result: %v", p.name, EntryPoint, err)
+	}
+	values, ok := out.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: %s() must return a dict or None, got %s", p.name, EntryPoint, result.Type())
+	}
+	return values, nil
+}
+
+// globals initializes the module and checks that it defines transform
+func (p *Program) globals(ctx context.Context) (starlark.StringDict, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.limits.Timeout)
+	defer cancel()
+
+	r := p.start(ctx)
+	defer r.stop()
+
+	globals, err := p.prog.Init(r.thread, predeclared)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidScript, p.runError(r, err))
+	}
+	fn, ok := globals[EntryPoint].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s must define a %s(event) function", ErrInvalidScript, p.name, EntryPoint)
+	}
+	if f, ok := fn.(*starlark.Function); ok && f.NumParams() != 1 {
+		return nil, fmt.Errorf("%w: %s(event) must take exactly one parameter", ErrInvalidScript, EntryPoint)
+	}
+	return globals, nil
+}
+
+// run is one sandboxed execution of a program
+type run struct {
+	thread *starlark.Thread
+	limit  atomic.Value // string naming the limit that cancelled the run
+	done   chan struct{}
+}
+
+// start returns a sandboxed thread that is cancelled when ctx ends or the
+// memory budget is exhausted. The caller must call stop when finished.
+func (p *Program) start(ctx context.Context) *run {
+	r := &run{
+		thread: &starlark.Thread{
+			Name:  p.name,
+			Print: func(*starlark.Thread, string) {},
+			Load: func(*starlark.Thread, string) (starlark.StringDict, error) {
+				return nil, errors.New("load is not allowed in scripts")
+			},
+		},
+		done: make(chan struct{}),
+	}
+	r.thread.SetMaxExecutionSteps(p.limits.MaxSteps)
+
+	go func() {
+		base := heapBytes()
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.done:
+				return
+			case <-ctx.Done():
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					r.cancel("timeout")
+				} else {
+					r.cancel("cancelled")
+				}
+				return
+			case <-ticker.C:
+				if used := heapBytes(); used > base && used-base > p.limits.MaxMemoryBytes {
+					r.cancel("memory")
+					return
+				}
+			}
+		}
+	}()
+	return r
+}
+
+func (r *run) cancel(limit string) {
+	r.limit.Store(limit)
+	r.thread.Cancel(limit + " limit exceeded")
+}
+
+func (r *run) stop() { close(r.done) }
+
+// runError reports limit violations as ErrLimitExceeded
+func (p *Program) runError(r *run, err error) error {
+	if limit, ok := r.limit.Load().(string); ok {
+		return fmt.Errorf("%w: %s: %s", ErrLimitExceeded, p.name, limit)
+	}
+	if r.thread.ExecutionSteps() >= p.limits.MaxSteps {
+		return fmt.Errorf("%w: %s: exceeded %d steps", ErrLimitExceeded, p.name, p.limits.MaxSteps)
+	}
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		return fmt.Errorf("%s: %s", p.name, evalErr.Backtrace())
+	}
+	return fmt.Errorf("%s: %v", p.name, err)
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxSourceBytes <= 0 {
+		l.MaxSourceBytes = DefaultLimits.MaxSourceBytes
+	}
+	if l.MaxSteps == 0 {
+		l.MaxSteps = DefaultLimits.MaxSteps
+	}
+	if l.Timeout <= 0 {
+		l.Timeout = DefaultLimits.Timeout
+	}
+	if l.MaxMemoryBytes == 0 {
+		l.MaxMemoryBytes = DefaultLimits.MaxMemoryBytes
+	}
+	return l
+}
+
+// heapBytes reads live heap object bytes without stopping the world
+func heapBytes() uint64 {
+	s := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(s)
+	if s[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return s[0].Value.Uint64()
+}
+
+func eventValue(ev Event) map[string]interface{} {
+	m := map[string]interface{}{
+		"type":      ev.Type,
+		"table":     ev.Table,
+		"row":       ev.Row,
+		"timestamp": ev.Timestamp.UTC().Format(time.RFC3339Nano),
+		"values":    ev.Values,
+		"previous":  nil,
+	}
+	if ev.Previous != nil {
+		m["previous"] = ev.Previous
+	}
+	return m
+}
+
+// toStarlark converts decoded JSON values to Starlark values
+func toStarlark(v interface{}) (starlark.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return starlark.MakeInt64(int64(v)), nil
+		}
+		return starlark.Float(v), nil
+	case int:
+		return starlark.MakeInt(v), nil
+	case int64:
+		return starlark.MakeInt64(v), nil
+	case []interface{}:
+		elems := make([]starlark.Value, len(v))
+		for i, e := range v {
+			sv, err := toStarlark(e)
+			if err != nil {
+				return nil, err
+			}
+			elems[i] = sv
+		}
+		return starlark.NewList(elems), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		d := starlark.NewDict(len(v))
+		for _, k := range keys {
+			sv, err := toStarlark(v[k])
+			if err != nil {
+				return nil, err
+			}
+			if err := d.SetKey(starlark.String(k), sv); err != nil {
+				return nil, err
+			}
+		}
+		return d, nil
+	default:
+		return nil, fmt.Errorf("unsupported value of type %T", v)
+	}
+}
+
+// fromStarlark converts a Starlark value back to a JSON-compatible value
+func fromStarlark(v starlark.Value) (interface{}, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return float64(i), nil
+		}
+		return nil, fmt.Errorf("integer %s is out of range", v)
+	case starlark.Float:
+		return float64(v), nil
+	case *starlark.List:
+		return fromIterable(v)
+	case starlark.Tuple:
+		return fromIterable(v)
+	case *starlark.Dict:
+		m := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			k, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("dict keys must be strings, got %s", item[0].Type())
+			}
+			gv, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			m[string(k)] = gv
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unsupported value of type %s", v.Type())
+	}
+}
+
+func fromIterable(v starlark.Indexable) (interface{}, error) {
+	out := make([]interface{}, v.Len())
+	for i := range out {
+		gv, err := fromStarlark(v.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		out[i] = gv
+	}
+	return out, nil
+}
@@ -0,0 +1,105 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransform(t *testing.T) {
+	p, err := Compile("slug", `
+def transform(event):
+    values = dict(event["values"])
+    values["slug"] = values["title"].lower().replace(" ", "-")
+    values["count"] = values["count"] + 1
+    if event["previous"] != None:
+        values["was"] = event["previous"]["title"]
+    return values
+`, Limits{})
+	require.NoError(t, err)
+
+	out, err := p.Transform(context.Background(), Event{
+		Type:     "update",
+		Table:    "posts",
+		Row:      "r1",
+		Values:   map[string]interface{}{"title": "Hello World", "count": float64(1)},
+		Previous: map[string]interface{}{"title": "Hello"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"title": "Hello World",
+		"slug":  "hello-world",
+		"count": float64(2),
+		"was":   "Hello",
+	}, out)
+}
+
+func TestTransformNoneKeepsValues(t *testing.T) {
+	p, err := Compile("noop", "def transform(event):\n    return None\n", Limits{})
+	require.NoError(t, err)
+
+	out, err := p.Transform(context.Background(), Event{Type: "create", Values: map[string]interface{}{"a": "b"}})
+	require.NoError(t, err)
+	assert.Nil(t, out)
+}
+
+func TestCompileErrors(t *testing.T) {
+	for name, src := range map[string]string{
+		"syntax":      "def transform(event)\n",
+		"missing":     "x = 1\n",
+		"arity":       "def transform(a, b):\n    return None\n",
+		"load":        "load('os', 'system')\ndef transform(event):\n    return None\n",
+		"top-level":   "for i in range(3):\n    pass\ndef transform(event):\n    return None\n",
+		"unknown ref": "def transform(event):\n    return open('x')\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Compile(name, src, Limits{})
+			assert.ErrorIs(t, err, ErrInvalidScript)
+		})
+	}
+
+	_, err := Compile("big", "# "+string(make([]byte, 100)), Limits{MaxSourceBytes: 10})
+	assert.ErrorIs(t, err, ErrInvalidScript)
+}
+
+func TestTransformLimits(t *testing.T) {
+	loop := "def transform(event):\n    while True:\n        pass\n"
+
+	p, err := Compile("steps", loop, Limits{MaxSteps: 10_000, Timeout: time.Minute})
+	require.NoError(t, err)
+	_, err = p.Transform(context.Background(), Event{})
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+
+	p, err = Compile("timeout", loop, Limits{MaxSteps: 1 << 62, Timeout: 20 * time.Millisecond})
+	require.NoError(t, err)
+	_, err = p.Transform(context.Background(), Event{})
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+
+	p, err = Compile("memory", `
+def transform(event):
+    chunks = []
+    while True:
+        chunks.append("x" * 1048576)
+`, Limits{MaxSteps: 1 << 62, Timeout: time.Minute, MaxMemoryBytes: 8 << 20})
+	require.NoError(t, err)
+	_, err = p.Transform(context.Background(), Event{})
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+}
+
+func TestTransformRuntimeError(t *testing.T) {
+	p, err := Compile("bad", "def transform(event):\n    return event[\"values\"][\"missing\"]\n", Limits{})
+	require.NoError(t, err)
+
+	_, err = p.Transform(context.Background(), Event{Values: map[string]interface{}{}})
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrLimitExceeded))
+
+	p, err = Compile("list", "def transform(event):\n    return [1]\n", Limits{})
+	require.NoError(t, err)
+	_, err = p.Transform(context.Background(), Event{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,450 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+)
+
+const (
+	// accessReviewDataType marks the stored access review reports
+	accessReviewDataType = "access-review"
+	// accessReviewField is the field, in the user's namespace, holding the
+	// periodic access review reports
+	accessReviewField = "access/reviews"
+
+	// defaultStaleAfter flags keys unused for a quarter as stale
+	defaultStaleAfter = 90 * 24 * time.Hour
+	// maxStoredReviews bounds the reports returned by GET /access/reviews
+	maxStoredReviews = 20
+
+	// Roles a key holds on a table
+	roleReadWrite = "read-write"
+	// roleRead applies to virtual tables, which are read-only, and to
+	// keys with the read scope
+	roleRead = "read"
+	// roleWrite applies to secrets tables for keys without secrets:read:
+	// they may write rows but read them back masked
+	roleWrite = "write"
+)
+
+// Grant is one key's access to one table
+type Grant struct {
+	User      string    `json:"user"`
+	Key       string    `json:"key"`
+	KeyName   string    `json:"keyName"`
+	Table     string    `json:"table"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	LastUsed  time.Time `json:"lastUsed"`
+}
+
+// TableAccess is a table reachable by a key, as listed in an access review
+type TableAccess struct {
+	Table string `json:"table"`
+	Role  string `json:"role"`
+}
+
+// KeyAccess describes one active API key in an access review
+type KeyAccess struct {
+	ID        string        `json:"id"`
+	Name      string        `json:"name"`
+	Scopes    []string      `json:"scopes,omitempty"`
+	CreatedAt time.Time     `json:"createdAt"`
+	ExpiresAt time.Time     `json:"expiresAt"`
+	LastUsed  time.Time     `json:"lastUsed"`
+	Stale     bool          `json:"stale"`
+	Tables    []TableAccess `json:"tables"`
+}
+
+// AccessReview reports who can access what in an account
+type AccessReview struct {
+	User        string      `json:"user"`
+	GeneratedAt time.Time   `json:"generatedAt"`
+	StaleAfter  string      `json:"staleAfter"`
+	Keys        []KeyAccess `json:"keys"`
+	// Stale counts the keys unused for longer than StaleAfter
+	Stale int `json:"stale"`
+}
+
+// keyRole returns the role a key holds on a table, or "" when the table is
+// not visible to the key
+func keyRole(key *auth.APIKey, defs []dynamo.Fact, now time.Time) string {
+	if !tableLive(defs) {
+		return ""
+	}
+	opts := tableOptionsOf(latestTableDef(defs))
+	if opts.ExpiresAt != nil && !now.Before(*opts.ExpiresAt) {
+		return ""
+	}
+	if opts.Session != "" && opts.Session != key.ID {
+		return ""
+	}
+	if !key.CanAccessTable(latestTableDef(defs).FieldName) {
+		return ""
+	}
+	switch {
+	case opts.Source != nil || key.ReadOnly():
+		return roleRead
+	case opts.Type == tableTypeSecrets && !key.HasScope(auth.ScopeSecretsRead):
+		return roleWrite
+	}
+	return roleReadWrite
+}
+
+// activeKeys returns a user's keys that are neither revoked nor expired,
+// sorted by name
+func (s *Server) activeKeys(ctx context.Context, userID string, now time.Time) ([]*auth.APIKey, error) {
+	keys, err := s.authenticator.ListAPIKeys(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	active := make([]*auth.APIKey, 0, len(keys))
+	for _, key := range keys {
+		if !key.Revoked && now.Before(key.ExpiresAt) {
+			active = append(active, key)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		if active[i].Name != active[j].Name {
+			return active[i].Name < active[j].Name
+		}
+		return active[i].ID < active[j].ID
+	})
+	return active, nil
+}
+
+// tableDefs returns the definition facts of every table in a user's
+// account, keyed by table name, and the names in sorted order
+func tableDefs(ctx context.Context, store *db.StoreAdapter, userID string, now time.Time) (map[string][]dynamo.Fact, []string, error) {
+	facts, err := store.QueryByTimeRange(ctx, time.Time{}, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	defs := make(map[string][]dynamo.Fact)
+	for _, f := range facts {
+		if f.Namespace == userID && f.DataType == tableDataType {
+			defs[f.FieldName] = append(defs[f.FieldName], f)
+		}
+	}
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return defs, names, nil
+}
+
+// buildAccessReview reports the tables each active key can reach
+func buildAccessReview(user *auth.User, keys []*auth.APIKey, defs map[string][]dynamo.Fact, names []string, staleAfter time.Duration, now time.Time) AccessReview {
+	review := AccessReview{User: user.Username, GeneratedAt: now, StaleAfter: staleAfter.String(), Keys: []KeyAccess{}}
+	for _, key := range keys {
+		access := KeyAccess{
+			ID:        key.ID,
+			Name:      key.Name,
+			Scopes:    key.Scopes,
+			CreatedAt: key.CreatedAt,
+			ExpiresAt: key.ExpiresAt,
+			LastUsed:  key.LastUsed,
+			Stale:     now.Sub(key.LastUsed) > staleAfter,
+			Tables:    []TableAccess{},
+		}
+		for _, name := range names {
+			if role := keyRole(key, defs[name], now); role != "" {
+				access.Tables = append(access.Tables, TableAccess{Table: name, Role: role})
+			}
+		}
+		if access.Stale {
+			review.Stale++
+		}
+		review.Keys = append(review.Keys, access)
+	}
+	return review
+}
+
+// parseStaleAfter reads the staleAfter duration of an access review request
+func parseStaleAfter(v string) (time.Duration, error) {
+	if v == "" {
+		return defaultStaleAfter, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("'staleAfter' must be a positive duration such as \"2160h\"")
+	}
+	return d, nil
+}
+
+// handleListGrants lists every key's access to every table in the account,
+// optionally filtered by key or table
+func (s *Server) handleListGrants(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	now := time.Now().UTC()
+	keys, err := s.activeKeys(r.Context(), user.ID, now)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list API keys")
+		return
+	}
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	defs, names, err := tableDefs(r.Context(), store, user.ID, now)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get tables")
+		return
+	}
+
+	keyFilter, tableFilter := r.URL.Query().Get("key"), r.URL.Query().Get("table")
+	grants := []Grant{}
+	for _, name := range names {
+		if tableFilter != "" && name != tableFilter {
+			continue
+		}
+		for _, key := range keys {
+			if keyFilter != "" && key.ID != keyFilter {
+				continue
+			}
+			if role := keyRole(key, defs[name], now); role != "" {
+				grants = append(grants, Grant{
+					User:      user.Username,
+					Key:       key.ID,
+					KeyName:   key.Name,
+					Table:     name,
+					Role:      role,
+					ExpiresAt: key.ExpiresAt,
+					LastUsed:  key.LastUsed,
+				})
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"grants": grants})
+}
+
+// handleBulkRevoke revokes several API keys at once: the listed keys, every
+// key unused for a given duration, or every key. The key making the request
+// is only revoked when listed explicitly.
+func (s *Server) handleBulkRevoke(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	var req struct {
+		Keys      []string `json:"keys,omitempty"`
+		UnusedFor string   `json:"unusedFor,omitempty"`
+		All       bool     `json:"all,omitempty"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	var unusedFor time.Duration
+	if req.UnusedFor != "" {
+		d, err := time.ParseDuration(req.UnusedFor)
+		if err != nil || d <= 0 {
+			writeValidationError(w, "Invalid revocation", []FieldError{{Field: "unusedFor", Message: "must be a positive duration such as \"2160h\""}})
+			return
+		}
+		unusedFor = d
+	}
+	if len(req.Keys) == 0 && unusedFor == 0 && !req.All {
+		writeValidationError(w, "Invalid revocation", []FieldError{{Field: "keys", Message: "one of 'keys', 'unusedFor' or 'all' is required"}})
+		return
+	}
+
+	now := time.Now().UTC()
+	keys, err := s.activeKeys(r.Context(), user.ID, now)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list API keys")
+		return
+	}
+
+	listed := make(map[string]bool, len(req.Keys))
+	for _, id := range req.Keys {
+		listed[id] = true
+	}
+	var current string
+	if key, ok := auth.APIKeyFromContext(r.Context()); ok {
+		current = key.ID
+	}
+
+	revoked := []string{}
+	for _, key := range keys {
+		match := listed[key.ID]
+		if key.ID != current {
+			match = match || req.All || (unusedFor > 0 && now.Sub(key.LastUsed) > unusedFor)
+		}
+		if !match {
+			continue
+		}
+		if err := s.authenticator.RevokeAPIKey(r.Context(), user.ID, key.ID); err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to revoke API key '%s'", key.ID))
+			return
+		}
+		revoked = append(revoked, key.ID)
+	}
+	s.logger.InfoContext(r.Context(), "bulk revoked API keys", "user", user.ID, "revoked", len(revoked))
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"revoked": revoked})
+}
+
+// handleAccessReview generates an access review report for the account
+func (s *Server) handleAccessReview(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	staleAfter, err := parseStaleAfter(r.URL.Query().Get("staleAfter"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	review, err := s.accessReview(r.Context(), store, user, staleAfter)
+	if err != nil {
+		writeStoreError(w, err, "Failed to build access review")
+		return
+	}
+	writeJSON(w, http.StatusOK, review)
+}
+
+// accessReview builds the current access review of a user's account
+func (s *Server) accessReview(ctx context.Context, store *db.StoreAdapter, user *auth.User, staleAfter time.Duration) (AccessReview, error) {
+	now := time.Now().UTC()
+	keys, err := s.activeKeys(ctx, user.ID, now)
+	if err != nil {
+		return AccessReview{}, err
+	}
+	defs, names, err := tableDefs(ctx, store, user.ID, now)
+	if err != nil {
+		return AccessReview{}, err
+	}
+	return buildAccessReview(user, keys, defs, names, staleAfter, now), nil
+}
+
+// handleListAccessReviews returns the reports stored by the periodic access
+// review, newest first
+func (s *Server) handleListAccessReviews(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	facts, err := store.QueryByField(r.Context(), user.ID, accessReviewField, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to load access reviews")
+		return
+	}
+	sort.Slice(facts, func(i, j int) bool { return facts[i].Timestamp.After(facts[j].Timestamp) })
+
+	reviews := []AccessReview{}
+	for _, f := range facts {
+		if f.DataType != accessReviewDataType || len(reviews) == maxStoredReviews {
+			continue
+		}
+		var review AccessReview
+		raw, _ := f.Value.(string)
+		if err := json.Unmarshal([]byte(raw), &review); err != nil {
+			s.logger.WarnContext(r.Context(), "invalid access review", "user", user.ID, "error", err)
+			continue
+		}
+		reviews = append(reviews, review)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"reviews": reviews})
+}
+
+// runAccessReviews stores an access review for every account at each
+// interval until ctx is cancelled
+func (s *Server) runAccessReviews(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var n int
+			err := s.leased(ctx, "access-reviews", func(ctx context.Context) (err error) {
+				if n, err = s.storeAccessReviews(ctx); err == nil {
+					s.logger.InfoContext(ctx, "stored access reviews", "stored", n)
+				}
+				return err
+			})
+			if err != nil {
+				s.logger.ErrorContext(ctx, "storing access reviews failed", "stored", n, "error", err)
+			}
+		}
+	}
+}
+
+// storeAccessReviews records the current access review of every account,
+// returning how many were stored
+func (s *Server) storeAccessReviews(ctx context.Context) (int, error) {
+	users, err := s.authenticator.GetAllUsers(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	stored := 0
+	for _, user := range users {
+		store, err := s.getStoreForUser(ctx, user.ID)
+		if err != nil {
+			return stored, err
+		}
+		review, err := s.accessReview(ctx, store, user, defaultStaleAfter)
+		if err != nil {
+			return stored, err
+		}
+		data, err := json.Marshal(review)
+		if err != nil {
+			return stored, err
+		}
+		if err := store.PutFact(ctx, dynamo.Fact{
+			ID:        newID(),
+			Timestamp: review.GeneratedAt,
+			Namespace: user.ID,
+			FieldName: accessReviewField,
+			DataType:  accessReviewDataType,
+			Value:     string(data),
+		}); err != nil {
+			return stored, err
+		}
+		stored++
+	}
+	return stored, nil
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyRole(t *testing.T) {
+	now := time.Now().UTC()
+	later := now.Add(time.Hour)
+	def := func(opts tableOptions) []dynamo.Fact {
+		return []dynamo.Fact{{FieldName: "t", DataType: "table", Timestamp: now, Value: opts.encode()}}
+	}
+	plain := &auth.APIKey{ID: "k1"}
+	scoped := &auth.APIKey{ID: "k2", Scopes: []string{auth.ScopeSecretsRead}}
+
+	assert.Equal(t, roleReadWrite, keyRole(plain, def(tableOptions{}), now))
+	assert.Equal(t, roleWrite, keyRole(plain, def(tableOptions{Type: tableTypeSecrets}), now))
+	assert.Equal(t, roleReadWrite, keyRole(scoped, def(tableOptions{Type: tableTypeSecrets}), now))
+	assert.Equal(t, roleRead, keyRole(plain, def(tableOptions{Type: tableTypeVirtual, Source: &virtualSource{URL: "https://example.com"}}), now))
+
+	// Temporary tables are only reachable by their key, until they expire
+	temp := def(tableOptions{ExpiresAt: &later, Session: "k1"})
+	assert.Equal(t, roleReadWrite, keyRole(plain, temp, now))
+	assert.Empty(t, keyRole(scoped, temp, now))
+	assert.Empty(t, keyRole(plain, temp, later))
+
+	assert.Empty(t, keyRole(plain, []dynamo.Fact{{FieldName: "t", DataType: "table", Value: deletedTableMarker}}, now))
+
+	// Read and table scopes narrow the grant
+	reader := &auth.APIKey{ID: "k3", Scopes: []string{auth.ScopeRead, auth.ScopeTablePrefix + "t"}}
+	assert.Equal(t, roleRead, keyRole(reader, def(tableOptions{}), now))
+	other := &auth.APIKey{ID: "k4", Scopes: []string{auth.ScopeTablePrefix + "u"}}
+	assert.Empty(t, keyRole(other, def(tableOptions{}), now))
+}
+
+func TestBuildAccessReview(t *testing.T) {
+	now := time.Now().UTC()
+	defs := map[string][]dynamo.Fact{
+		"notes": {{FieldName: "notes", DataType: "table", Timestamp: now}},
+		"vault": {{FieldName: "vault", DataType: "table", Timestamp: now, Value: tableOptions{Type: tableTypeSecrets}.encode()}},
+	}
+	keys := []*auth.APIKey{
+		{ID: "k1", Name: "ci", LastUsed: now.Add(-100 * 24 * time.Hour)},
+		{ID: "k2", Name: "laptop", LastUsed: now, Scopes: []string{auth.ScopeSecretsRead}},
+	}
+
+	review := buildAccessReview(&auth.User{Username: "alice"}, keys, defs, []string{"notes", "vault"}, defaultStaleAfter, now)
+	assert.Equal(t, "alice", review.User)
+	assert.Equal(t, 1, review.Stale)
+	require.Len(t, review.Keys, 2)
+	assert.True(t, review.Keys[0].Stale)
+	assert.Equal(t, []TableAccess{{Table: "notes", Role: roleReadWrite}, {Table: "vault", Role: roleWrite}}, review.Keys[0].Tables)
+	assert.False(t, review.Keys[1].Stale)
+	assert.Equal(t, []TableAccess{{Table: "notes", Role: roleReadWrite}, {Table: "vault", Role: roleReadWrite}}, review.Keys[1].Tables)
+}
+
+func TestBulkRevoke(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard()})
+	require.NoError(t, err)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	current, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "admin", 0)
+	require.NoError(t, err)
+	ci, _, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "ci", 0)
+	require.NoError(t, err)
+	laptop, _, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "laptop", 0)
+	require.NoError(t, err)
+	ci.LastUsed = time.Now().UTC().Add(-48 * time.Hour)
+
+	revoke := func(body string) (*httptest.ResponseRecorder, []string) {
+		req := httptest.NewRequest(http.MethodPost, "/access/revoke", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		var resp struct {
+			Revoked []string `json:"revoked"`
+		}
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp.Revoked
+	}
+
+	rec, _ := revoke(`{}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec, _ = revoke(`{"unusedFor": "soon"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec, revoked := revoke(`{"unusedFor": "24h"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{ci.ID}, revoked)
+
+	// "all" spares the key making the request
+	rec, revoked = revoke(`{"all": true}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{laptop.ID}, revoked)
+	assert.False(t, current.Revoked)
+}
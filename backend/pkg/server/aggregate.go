@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/query"
+)
+
+// maxGroupBy bounds the columns an aggregation may group by
+const maxGroupBy = 8
+
+// checkAggregate checks an aggregation against a table's columns and picks
+// how each metric reads its column. Tables without columns take any column.
+func checkAggregate(columns []dynamo.ColumnDefinition, groupBy []string, metrics []query.Metric) fieldErrors {
+	if len(columns) == 0 {
+		return nil
+	}
+	defined := make(map[string]dynamo.ColumnDefinition, len(columns))
+	for _, col := range columns {
+		defined[col.Name] = col
+	}
+	var fields fieldErrors
+	for _, name := range groupBy {
+		if _, ok := defined[name]; !ok {
+			fields = append(fields, FieldError{Field: "groupBy", Message: fmt.Sprintf("unknown column '%s'", name)})
+		}
+	}
+	for i, m := range metrics {
+		if m.Column == "" {
+			continue
+		}
+		col, ok := defined[m.Column]
+		if !ok {
+			fields = append(fields, FieldError{Field: "metrics", Message: fmt.Sprintf("unknown column '%s'", m.Column)})
+			continue
+		}
+		if col.DataType == decimalType {
+			metrics[i].Kind = query.KindDecimal
+			continue
+		}
+		var allowed []string
+		switch m.Func {
+		case query.FuncSum, query.FuncAvg:
+			allowed = []string{"number"}
+		case query.FuncMin, query.FuncMax:
+			allowed = []string{"number", "string", "datetime", "date", enumType, uuidType}
+		default:
+			continue
+		}
+		found := false
+		for _, t := range allowed {
+			found = found || col.DataType == t
+		}
+		if !found {
+			fields = append(fields, FieldError{Field: "metrics", Message: fmt.Sprintf("%s is not supported for column '%s' of type '%s'", m.Func, col.Name, col.DataType)})
+		}
+	}
+	return fields
+}
+
+// handleAggregate computes metrics over a table's rows, grouped by zero or
+// more columns, at the current time or the "at" query parameter
+func (s *Server) handleAggregate(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	table := r.PathValue("table")
+
+	q := r.URL.Query()
+	var fields fieldErrors
+	metricsParam := q.Get("metrics")
+	if metricsParam == "" {
+		metricsParam = query.FuncCount
+	}
+	metrics, err := query.ParseMetrics(metricsParam)
+	if err != nil {
+		fields = append(fields, FieldError{Field: "metrics", Message: err.Error()})
+	}
+	var groupBy []string
+	if v := q.Get("groupBy"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			groupBy = append(groupBy, strings.TrimSpace(name))
+		}
+		if len(groupBy) > maxGroupBy {
+			fields = append(fields, FieldError{Field: "groupBy", Message: fmt.Sprintf("must have at most %d columns", maxGroupBy)})
+		}
+	}
+	var at time.Time
+	if v := q.Get("at"); v != "" {
+		if at, err = time.Parse(time.RFC3339, v); err != nil {
+			fields = append(fields, FieldError{Field: "at", Message: "must be an RFC3339 time"})
+		}
+		at = at.UTC()
+	}
+	if len(fields) > 0 {
+		writeValidationError(w, "Invalid aggregation", fields)
+		return
+	}
+
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+	def := latestTableDef(facts)
+	if tableOptionsOf(def).Type == tableTypeSecrets {
+		writeError(w, http.StatusBadRequest, "Rows of secrets tables are encrypted and cannot be aggregated")
+		return
+	}
+	if fields := checkAggregate(def.Columns, groupBy, metrics); len(fields) > 0 {
+		writeValidationError(w, "Invalid aggregation", fields)
+		return
+	}
+
+	rows, ok := s.tableRowsAt(w, r, store, user, table, facts, at)
+	if !ok {
+		return
+	}
+	values := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		values[i] = row.Values
+	}
+	groups, err := query.Aggregate(values, groupBy, metrics)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Failed to aggregate: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"groups": groups})
+}
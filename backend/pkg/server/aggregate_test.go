@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/pkg/query"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregate(t *testing.T) {
+	_, do := memoryServer(t)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "orders", "columns": [
+		{"name": "status", "dataType": "string"},
+		{"name": "amount", "dataType": "number"},
+		{"name": "price", "dataType": "decimal", "scale": 2},
+		{"name": "tags", "dataType": "array", "nullable": true}
+	]}`).Code)
+	for _, body := range []string{
+		`{"values": {"status": "open", "amount": 10, "price": "1.10"}}`,
+		`{"values": {"status": "open", "amount": 20, "price": "2.20"}}`,
+	} {
+		require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/orders/rows", body).Code)
+	}
+	before := time.Now().UTC()
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/orders/rows", `{"values": {"status": "paid", "amount": 5, "price": "0.50"}}`).Code)
+
+	aggregate := func(params string) []query.Group {
+		t.Helper()
+		rec := do(http.MethodGet, "/tables/orders/aggregate?"+params, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp struct {
+			Groups []query.Group `json:"groups"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp.Groups
+	}
+
+	groups := aggregate("groupBy=status&metrics=count,sum(amount),avg(amount),sum(price)")
+	require.Len(t, groups, 2)
+	assert.Equal(t, map[string]interface{}{"status": "open"}, groups[0].Key)
+	assert.Equal(t, map[string]interface{}{"count": float64(2), "sum(amount)": float64(30), "avg(amount)": float64(15), "sum(price)": "3.30"}, groups[0].Metrics)
+	assert.Equal(t, float64(1), groups[1].Metrics["count"])
+
+	total := aggregate("")
+	assert.Equal(t, []query.Group{{Key: map[string]interface{}{}, Metrics: map[string]interface{}{"count": float64(3)}}}, total)
+
+	past := aggregate("metrics=sum(amount)&at=" + before.Format(time.RFC3339Nano))
+	assert.Equal(t, float64(30), past[0].Metrics["sum(amount)"], "rows written after the time are not counted")
+
+	for _, q := range []string{"metrics=sum(status)", "metrics=median(amount)", "groupBy=missing", "metrics=max(tags)", "at=yesterday"} {
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/tables/orders/aggregate?"+q, "").Code, q)
+	}
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/tables/missing/aggregate", "").Code)
+}
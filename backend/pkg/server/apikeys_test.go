@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAPIKeyDigests(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true, APIKeySecret: "pepper"})
+	require.NoError(t, err)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+
+	key, raw, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key.KeyHash, "hmac-sha256:"), key.KeyHash)
+	_, found, err := srv.authenticator.VerifyAPIKey(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, found.ID)
+
+	// Another secret produces other digests, so the key stops working
+	srv.authenticator.SetKeyHashSecret([]byte("other"))
+	_, _, err = srv.authenticator.VerifyAPIKey(ctx, raw)
+	assert.ErrorIs(t, err, auth.ErrInvalidAPIKey)
+}
+
+func TestLegacyAPIKeyRehashedOnUse(t *testing.T) {
+	srv, do := memoryServer(t)
+	ctx := context.Background()
+	user, err := srv.userStore.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+
+	// A key stored before digests were introduced
+	raw := auth.APIKeyPrefix + strings.Repeat("ab", auth.APIKeyLength)
+	hash, err := bcrypt.GenerateFromPassword([]byte(raw), bcrypt.MinCost)
+	require.NoError(t, err)
+	now := time.Now().UTC()
+	require.NoError(t, srv.userStore.CreateAPIKey(ctx, &auth.APIKey{
+		ID: "legacy", UserID: user.ID, KeyHash: string(hash), Name: "legacy",
+		CreatedAt: now, ExpiresAt: now.Add(time.Hour),
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/tables", nil)
+	req.Header.Set("Authorization", "Bearer "+raw)
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	stored, err := srv.userStore.GetAPIKey(ctx, "legacy")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored.KeyHash, "hmac-sha256:"), stored.KeyHash)
+	byHash, err := srv.userStore.GetAPIKeyByHash(ctx, stored.KeyHash)
+	require.NoError(t, err)
+	assert.Equal(t, "legacy", byHash.ID)
+	_, err = srv.userStore.GetAPIKeyByHash(ctx, string(hash))
+	assert.Error(t, err)
+
+	// The migrated key keeps working, as do other keys
+	_, _, err = srv.authenticator.VerifyAPIKey(ctx, raw)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/v1/tables", "").Code)
+}
+
+func TestLegacyAPIKeySearchStops(t *testing.T) {
+	srv, _ := memoryServer(t)
+	ctx := context.Background()
+	user, err := srv.userStore.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	legacyKey := func(id, raw string) {
+		t.Helper()
+		hash, err := bcrypt.GenerateFromPassword([]byte(raw), bcrypt.MinCost)
+		require.NoError(t, err)
+		now := time.Now().UTC()
+		require.NoError(t, srv.userStore.CreateAPIKey(ctx, &auth.APIKey{
+			ID: id, UserID: user.ID, KeyHash: string(hash), Name: id,
+			CreatedAt: now, ExpiresAt: now.Add(time.Hour),
+		}))
+	}
+	unknown := auth.APIKeyPrefix + strings.Repeat("00", auth.APIKeyLength)
+
+	// Unknown keys are compared with the bcrypt keys while any are left
+	first := auth.APIKeyPrefix + strings.Repeat("ab", auth.APIKeyLength)
+	legacyKey("first", first)
+	_, _, err = srv.authenticator.VerifyAPIKey(ctx, unknown)
+	assert.ErrorIs(t, err, auth.ErrInvalidAPIKey)
+	_, _, err = srv.authenticator.VerifyAPIKey(ctx, first)
+	require.NoError(t, err)
+
+	// Once none are, unknown keys are rejected by their digest alone
+	_, _, err = srv.authenticator.VerifyAPIKey(ctx, unknown)
+	assert.ErrorIs(t, err, auth.ErrInvalidAPIKey)
+	second := auth.APIKeyPrefix + strings.Repeat("cd", auth.APIKeyLength)
+	legacyKey("second", second)
+	_, _, err = srv.authenticator.VerifyAPIKey(ctx, second)
+	assert.ErrorIs(t, err, auth.ErrInvalidAPIKey, "no more bcrypt keys are searched")
+	_, _, err = srv.authenticator.VerifyAPIKey(ctx, first)
+	assert.NoError(t, err)
+}
+
+func TestPasswordRehashedAtNewCost(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true, BcryptCost: bcrypt.MinCost})
+	require.NoError(t, err)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	cost, err := bcrypt.Cost([]byte(user.PasswordHash))
+	require.NoError(t, err)
+	assert.Equal(t, bcrypt.MinCost, cost)
+
+	require.NoError(t, srv.authenticator.SetBcryptCost(bcrypt.MinCost+1))
+	_, err = srv.authenticator.LoginUser(ctx, "alice", "pw", "")
+	require.NoError(t, err)
+	user, err = srv.userStore.GetUserByID(ctx, user.ID)
+	require.NoError(t, err)
+	cost, err = bcrypt.Cost([]byte(user.PasswordHash))
+	require.NoError(t, err)
+	assert.Equal(t, bcrypt.MinCost+1, cost)
+
+	_, err = NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true, BcryptCost: 99})
+	assert.Error(t, err)
+}
+
+func TestAPIKeySecretGenerated(t *testing.T) {
+	usersFile := filepath.Join(t.TempDir(), "users.json")
+	config := Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true, UsersFile: usersFile}
+	srv, err := NewServer(config)
+	require.NoError(t, err)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, raw, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+	require.NoError(t, err)
+
+	info, err := os.Stat(apiKeySecretFile(usersFile))
+	require.NoError(t, err, "the secret is kept beside the users file")
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	secret, err := apiKeySecret(config)
+	require.NoError(t, err)
+	assert.Len(t, secret, 64)
+
+	// Other processes on the users file, such as notably-admin, use it too
+	admin, _, err := NewAuthenticator(config)
+	require.NoError(t, err)
+	_, _, err = admin.VerifyAPIKey(ctx, raw)
+	assert.NoError(t, err)
+
+	// Keys digested without a secret, before one was generated, are
+	// rehashed on use
+	unkeyed := auth.NewAuthenticator(srv.userStore)
+	key, raw, err := unkeyed.GenerateAPIKey(ctx, user.ID, "unkeyed", 0)
+	require.NoError(t, err)
+	_, found, err := srv.authenticator.VerifyAPIKey(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, found.ID)
+	stored, err := srv.userStore.GetAPIKey(ctx, key.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, key.KeyHash, stored.KeyHash)
+	_, _, err = unkeyed.VerifyAPIKey(ctx, raw)
+	assert.ErrorIs(t, err, auth.ErrInvalidAPIKey, "the key is stored under the secret")
+}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/archive"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/blob"
+)
+
+// newArchiveStore builds the blob store used for archived rows, or nil when archival is not configured
+func newArchiveStore(cfg Config) (blob.Store, error) {
+	if cfg.ArchiveBucket != "" {
+		awsCfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("loading AWS config for archive bucket: %w", err)
+		}
+		var optFns []func(*s3.Options)
+		if cfg.S3Endpoint != "" {
+			optFns = append(optFns, func(o *s3.Options) {
+				o.BaseEndpoint = aws.String(cfg.S3Endpoint)
+				o.UsePathStyle = true
+			})
+		}
+		return blob.NewS3Store(awsCfg, cfg.ArchiveBucket, "", optFns...), nil
+	}
+	if cfg.ArchiveDir != "" {
+		return blob.NewFileStore(cfg.ArchiveDir), nil
+	}
+	return nil, nil
+}
+
+// ArchivedRow describes a row whose state lives in an archive bundle
+type ArchivedRow struct {
+	ID         string    `json:"id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Bundle     string    `json:"bundle"`
+	ArchivedAt time.Time `json:"archivedAt"`
+}
+
+// handleArchiveRows moves the latest state of rows not modified since a cutoff into a
+// compressed bundle. Each archived row gets a stub as its new latest version, so its
+// history is untouched and snapshots skip the row.
+func (s *Server) handleArchiveRows(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	if s.archive == nil {
+		writeError(w, http.StatusNotImplemented, "Archival is not configured on this server")
+		return
+	}
+
+	table := r.PathValue("table")
+
+	var req struct {
+		Before    string `json:"before"`
+		OlderThan string `json:"olderThan"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	now := time.Now().UTC()
+	cutoff, err := parseCutoff(req.Before, req.OlderThan, now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Validate table exists
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, now)
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+
+	rowStore, err := s.getRowStore(r.Context(), store, user, table)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize table storage")
+		return
+	}
+
+	snap, err := rowStore.GetTableSnapshot(r.Context(), user.ID, table, now)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get snapshot")
+		return
+	}
+
+	key := tableNamespace(user.ID, table)
+	var candidates []dynamo.Fact
+	for _, fact := range snap {
+		if fact.DataType != "json" || !fact.Timestamp.Before(cutoff) {
+			continue
+		}
+		if _, ok := fact.Value.(map[string]interface{}); !ok {
+			continue
+		}
+		candidates = append(candidates, fact)
+	}
+
+	archived := []string{}
+	if len(candidates) == 0 {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"archived": archived})
+		return
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].FieldName < candidates[j].FieldName })
+
+	bundle := &archive.Bundle{Namespace: key, CreatedAt: now}
+	for _, fact := range candidates {
+		bundle.Records = append(bundle.Records, archive.Record{
+			FactID:    fact.ID,
+			RowID:     fact.FieldName,
+			Timestamp: fact.Timestamp,
+			Values:    fact.Value.(map[string]interface{}),
+		})
+	}
+
+	data, err := archive.Encode(bundle)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode archive: %v", err))
+		return
+	}
+
+	bundleKey := archive.BundleKey(key, newID(), now)
+	if err := s.archive.Put(r.Context(), bundleKey, data); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to store archive: %v", err))
+		return
+	}
+
+	stubValue, err := archive.EncodeStub(archive.Stub{Bundle: bundleKey, ArchivedAt: now})
+	if err != nil {
+		writeStoreError(w, err, "Failed to encode archive stub")
+		return
+	}
+
+	// The bundle is durable before any stub is written, so a partial failure
+	// leaves some rows unarchived rather than losing data.
+	for _, fact := range candidates {
+		stub := dynamo.Fact{
+			ID:        newID(),
+			Timestamp: now,
+			Namespace: key,
+			FieldName: fact.FieldName,
+			DataType:  archive.StubDataType,
+			Value:     stubValue,
+		}
+		if err := rowStore.PutFact(r.Context(), stub); err != nil {
+			s.logger.ErrorContext(r.Context(), "archiving rows failed", "table", table, "archived", len(archived), "total", len(candidates), "error", err)
+			writeStoreError(w, err, fmt.Sprintf("Failed to archive row '%s'", fact.FieldName))
+			return
+		}
+		archived = append(archived, fact.FieldName)
+		reportProgress(r.Context(), len(archived), len(candidates))
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"bundle":   bundleKey,
+		"archived": archived,
+	})
+}
+
+// handleListArchivedRows lists the rows of a table that are currently archived
+func (s *Server) handleListArchivedRows(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Validate table exists
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+
+	rowStore, err := s.getRowStore(r.Context(), store, user, table)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize table storage")
+		return
+	}
+
+	snap, err := rowStore.GetTableSnapshot(r.Context(), user.ID, table, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to get snapshot")
+		return
+	}
+
+	rows := []ArchivedRow{}
+	for id, fact := range snap {
+		if fact.DataType != archive.StubDataType {
+			continue
+		}
+		stub, err := archive.DecodeStub(fact.Value)
+		if err != nil {
+			s.logger.WarnContext(r.Context(), "invalid archive stub", "table", table, "row", id, "error", err)
+			continue
+		}
+		rows = append(rows, ArchivedRow{ID: id, Timestamp: fact.Timestamp, Bundle: stub.Bundle, ArchivedAt: stub.ArchivedAt})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"rows": rows})
+}
+
+// handleRestoreRow writes an archived row's values back as its latest version, after
+// its stub
+func (s *Server) handleRestoreRow(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	if s.archive == nil {
+		writeError(w, http.StatusNotImplemented, "Archival is not configured on this server")
+		return
+	}
+
+	table := r.PathValue("table")
+	rowID := r.PathValue("id")
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	rowStore, err := s.getRowStore(r.Context(), store, user, table)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize table storage")
+		return
+	}
+
+	snap, err := rowStore.GetTableSnapshot(r.Context(), user.ID, table, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to get snapshot")
+		return
+	}
+
+	key := tableNamespace(user.ID, table)
+	fact, ok := snap[rowID]
+	if !ok || fact.DataType != archive.StubDataType {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Archived row '%s' not found in table '%s'", rowID, table))
+		return
+	}
+
+	stub, err := archive.DecodeStub(fact.Value)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Invalid archive stub: %v", err))
+		return
+	}
+
+	data, err := s.archive.Get(r.Context(), stub.Bundle)
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Archive bundle '%s' is missing", stub.Bundle))
+		} else {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to read archive: %v", err))
+		}
+		return
+	}
+
+	bundle, err := archive.Decode(data)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	record, ok := bundle.Find(rowID)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Row '%s' not present in archive bundle", rowID))
+		return
+	}
+
+	restored := dynamo.Fact{
+		ID:        newID(),
+		Timestamp: time.Now().UTC(),
+		Namespace: key,
+		FieldName: rowID,
+		DataType:  "json",
+		Value:     record.Values,
+	}
+
+	if err := rowStore.PutFact(r.Context(), restored); err != nil {
+		writeStoreError(w, err, "Failed to restore row")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, RowData{ID: rowID, Timestamp: restored.Timestamp, Values: record.Values})
+}
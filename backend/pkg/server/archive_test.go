@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/pkg/blob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveRows(t *testing.T) {
+	srv, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes", "columns": [{"name": "v", "dataType": "number"}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/notes/archive", `{"olderThan": "1h"}`)
+	assert.Equal(t, http.StatusNotImplemented, rec.Code, "archival needs a blob store")
+	srv.archive = blob.NewFileStore(t.TempDir())
+
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n1", "values": {"v": 1}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPut, "/tables/notes/rows/n1", `{"values": {"v": 2}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n2", "values": {"v": 3}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	beforeArchive := time.Now().UTC()
+
+	rec = do(http.MethodPost, "/tables/notes/archive", `{"olderThan": "1h"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.JSONEq(t, `{"archived": []}`, rec.Body.String(), "rows changed since the cutoff stay")
+
+	rec = do(http.MethodPost, "/tables/notes/archive", `{"before": "`+time.Now().Add(time.Minute).UTC().Format(time.RFC3339)+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var archived struct {
+		Bundle   string   `json:"bundle"`
+		Archived []string `json:"archived"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &archived))
+	assert.Equal(t, []string{"n1", "n2"}, archived.Archived)
+	assert.NotEmpty(t, archived.Bundle)
+	assert.Empty(t, snapshotValues(t, do, "/tables/notes/snapshot"))
+	asOf := url.QueryEscape(beforeArchive.Format(time.RFC3339Nano))
+	assert.Equal(t, map[string]float64{"n1": 2, "n2": 3}, snapshotValues(t, do, "/tables/notes/snapshot?asOf="+asOf), "archiving keeps the rows' history")
+
+	listArchived := func() []ArchivedRow {
+		t.Helper()
+		rec := do(http.MethodGet, "/tables/notes/archive", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp struct {
+			Rows []ArchivedRow `json:"rows"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp.Rows
+	}
+	rows := listArchived()
+	require.Len(t, rows, 2)
+	for _, row := range rows {
+		assert.Equal(t, archived.Bundle, row.Bundle)
+		assert.False(t, row.ArchivedAt.Before(beforeArchive))
+	}
+
+	rec = do(http.MethodPost, "/tables/notes/rows/n1/restore", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var restored RowData
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &restored))
+	assert.Equal(t, float64(2), restored.Values["v"])
+	assert.False(t, restored.Timestamp.Before(beforeArchive), "the restored row is a new version")
+	assert.Equal(t, map[string]float64{"n1": 2}, snapshotValues(t, do, "/tables/notes/snapshot"))
+	rows = listArchived()
+	require.Len(t, rows, 1)
+	assert.Equal(t, "n2", rows[0].ID)
+
+	rec = do(http.MethodPost, "/tables/notes/rows/n1/restore", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+	rec = do(http.MethodGet, "/tables/missing/archive", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/blob"
+	"github.com/elibdev/notably/pkg/ulid"
+)
+
+// attachmentType is the column type of files stored in S3. The column's
+// value is an attachment; the file itself is uploaded and downloaded
+// directly through pre-signed URLs.
+const attachmentType = "attachment"
+
+// defaultAttachmentURLExpiry is how long pre-signed attachment URLs work
+const defaultAttachmentURLExpiry = 15 * time.Minute
+
+// attachment is the value of an attachment column
+type attachment struct {
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	ContentType string `json:"contentType,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+}
+
+// attachmentURL is a pre-signed download URL of an attachment
+type attachmentURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// newAttachmentStore builds the store of attachment files, or nil when
+// attachments are not configured. An S3 bucket takes precedence over a
+// local directory; only a bucket can sign URLs for direct transfers.
+func newAttachmentStore(cfg Config) (blob.Store, error) {
+	if cfg.AttachmentBucket == "" {
+		if cfg.AttachmentDir != "" {
+			return blob.NewFileStore(cfg.AttachmentDir), nil
+		}
+		return nil, nil
+	}
+	awsCfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config for attachment bucket: %w", err)
+	}
+	var optFns []func(*s3.Options)
+	if cfg.S3Endpoint != "" {
+		optFns = append(optFns, func(o *s3.Options) {
+			o.BaseEndpoint = aws.String(cfg.S3Endpoint)
+			o.UsePathStyle = true
+		})
+	}
+	return blob.NewS3Store(awsCfg, cfg.AttachmentBucket, "", optFns...), nil
+}
+
+// attachmentPrefix starts the keys of every attachment of a table. Objects
+// are only signed and deleted below the prefix of the table being read or
+// purged, so a row cannot point at another account's files.
+func attachmentPrefix(userID, table string) string {
+	return fmt.Sprintf("attachments/%s/%s/", userID, table)
+}
+
+// attachmentOf returns the attachment a column value describes
+func attachmentOf(value interface{}) (attachment, bool) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return attachment{}, false
+	}
+	key, _ := m["key"].(string)
+	name, _ := m["name"].(string)
+	if key == "" || name == "" {
+		return attachment{}, false
+	}
+	a := attachment{Key: key, Name: name}
+	if ct, ok := m["contentType"]; ok {
+		if a.ContentType, ok = ct.(string); !ok {
+			return attachment{}, false
+		}
+	}
+	if size, ok := m["size"]; ok {
+		n, ok := size.(float64)
+		if !ok || n < 0 || n != float64(int64(n)) {
+			return attachment{}, false
+		}
+		a.Size = int64(n)
+	}
+	return a, true
+}
+
+// validAttachmentName reports whether a file name can end an object key
+func validAttachmentName(name string) bool {
+	return name != "" && name != "." && name != ".." && len(name) <= 255 && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// handleCreateAttachment issues a pre-signed URL for uploading a file to an
+// attachment column. The response's value is what the client then writes
+// to the column once the upload has finished.
+func (s *Server) handleCreateAttachment(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	presigner, ok := s.attachments.(blob.Presigner)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "Direct attachment uploads need an S3 attachment bucket")
+		return
+	}
+
+	table := r.PathValue("table")
+
+	var req struct {
+		Column      string `json:"column"`
+		Name        string `json:"name"`
+		ContentType string `json:"contentType"`
+		Size        int64  `json:"size"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var fields []FieldError
+	if req.Column == "" {
+		fields = append(fields, FieldError{Field: "column", Message: "is required"})
+	}
+	if !validAttachmentName(req.Name) {
+		fields = append(fields, FieldError{Field: "name", Message: "must be a file name without slashes"})
+	}
+	if req.Size < 0 {
+		fields = append(fields, FieldError{Field: "size", Message: "must not be negative"})
+	}
+	if len(fields) > 0 {
+		writeValidationError(w, "Invalid attachment", fields)
+		return
+	}
+
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+	found := false
+	for _, col := range latestTableDef(facts).Columns {
+		if col.Name == req.Column {
+			found = col.DataType == attachmentType
+			break
+		}
+	}
+	if !found {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Column '%s' is not an attachment column of table '%s'", req.Column, table))
+		return
+	}
+
+	value := attachment{
+		Key:         attachmentPrefix(user.ID, table) + ulid.Make().String() + "/" + req.Name,
+		Name:        req.Name,
+		ContentType: req.ContentType,
+		Size:        req.Size,
+	}
+	expires := s.attachmentURLExpiry()
+	url, err := presigner.PresignPut(r.Context(), value.Key, value.ContentType, expires)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "signing attachment upload failed", "table", table, "error", err)
+		writeError(w, http.StatusInternalServerError, "Failed to sign attachment upload")
+		return
+	}
+	upload := map[string]interface{}{
+		"url":       url,
+		"method":    http.MethodPut,
+		"expiresAt": time.Now().UTC().Add(expires),
+	}
+	if value.ContentType != "" {
+		upload["headers"] = map[string]string{"Content-Type": value.ContentType}
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"upload": upload, "value": value})
+}
+
+// attachmentURLExpiry returns how long pre-signed attachment URLs work
+func (s *Server) attachmentURLExpiry() time.Duration {
+	if s.config.AttachmentURLExpiry > 0 {
+		return s.config.AttachmentURLExpiry
+	}
+	return defaultAttachmentURLExpiry
+}
+
+// signAttachments sets the download URLs of the attachment columns of rows
+func (s *Server) signAttachments(ctx context.Context, userID, table string, columns []dynamo.ColumnDefinition, rows []RowData) {
+	presigner, ok := s.attachments.(blob.Presigner)
+	if !ok {
+		return
+	}
+	var names []string
+	for _, col := range columns {
+		if col.DataType == attachmentType {
+			names = append(names, col.Name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	prefix := attachmentPrefix(userID, table)
+	expires := s.attachmentURLExpiry()
+	expiresAt := time.Now().UTC().Add(expires)
+	for i := range rows {
+		for _, name := range names {
+			a, ok := attachmentOf(rows[i].Values[name])
+			if !ok || !strings.HasPrefix(a.Key, prefix) {
+				continue
+			}
+			url, err := presigner.PresignGet(ctx, a.Key, expires)
+			if err != nil {
+				s.logger.WarnContext(ctx, "signing attachment download failed", "table", table, "row", rows[i].ID, "error", err)
+				continue
+			}
+			if rows[i].Attachments == nil {
+				rows[i].Attachments = make(map[string]attachmentURL)
+			}
+			rows[i].Attachments[name] = attachmentURL{URL: url, ExpiresAt: expiresAt}
+		}
+	}
+}
+
+// attachmentKeys returns the keys of the table's attachments a fact refers to
+func attachmentKeys(f dynamo.Fact, prefix string) []string {
+	values, ok := f.Value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	var keys []string
+	for _, v := range values {
+		if a, ok := attachmentOf(v); ok && strings.HasPrefix(a.Key, prefix) {
+			keys = append(keys, a.Key)
+		}
+	}
+	return keys
+}
+
+// releaseAttachments deletes the files of a table that purged facts refer
+// to and no kept fact does. Failures are logged, not returned: the facts
+// are already gone, and an orphaned file only costs storage.
+func (s *Server) releaseAttachments(ctx context.Context, userID, table string, purged, kept []dynamo.Fact) {
+	if s.attachments == nil || len(purged) == 0 {
+		return
+	}
+	prefix := attachmentPrefix(userID, table)
+	inUse := make(map[string]bool)
+	for _, f := range kept {
+		for _, key := range attachmentKeys(f, prefix) {
+			inUse[key] = true
+		}
+	}
+	for _, f := range purged {
+		for _, key := range attachmentKeys(f, prefix) {
+			if inUse[key] {
+				continue
+			}
+			inUse[key] = true
+			if err := s.attachments.Delete(ctx, key); err != nil {
+				s.logger.WarnContext(ctx, "deleting attachment failed", "table", table, "key", key, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAttachments signs URLs by naming the key and records deletions
+type fakeAttachments struct {
+	deleted []string
+}
+
+func (f *fakeAttachments) Put(ctx context.Context, key string, data []byte) error { return nil }
+
+func (f *fakeAttachments) Get(ctx context.Context, key string) ([]byte, error) { return nil, nil }
+
+func (f *fakeAttachments) Delete(ctx context.Context, key string) error {
+	f.deleted = append(f.deleted, key)
+	return nil
+}
+
+func (f *fakeAttachments) PresignPut(ctx context.Context, key, contentType string, expires time.Duration) (string, error) {
+	return "https://files.example.com/" + key + "?upload", nil
+}
+
+func (f *fakeAttachments) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return "https://files.example.com/" + key + "?download", nil
+}
+
+func TestValidateAttachmentValue(t *testing.T) {
+	assert.True(t, validateValueType(map[string]interface{}{"key": "attachments/u/t/1/a.pdf", "name": "a.pdf", "size": 10.0}, attachmentType))
+	assert.False(t, validateValueType(map[string]interface{}{"key": "attachments/u/t/1/a.pdf"}, attachmentType), "a name is required")
+	assert.False(t, validateValueType(map[string]interface{}{"key": "k", "name": "a", "size": -1.0}, attachmentType))
+	assert.False(t, validateValueType("attachments/u/t/1/a.pdf", attachmentType))
+
+	assert.True(t, validAttachmentName("report.pdf"))
+	assert.False(t, validAttachmentName("../report.pdf"))
+	assert.False(t, validAttachmentName(".."))
+}
+
+func TestAttachmentLifecycle(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true})
+	require.NoError(t, err)
+	files := &fakeAttachments{}
+	srv.attachments = files
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+	require.NoError(t, err)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	upload := func(name string) string {
+		rec := do(http.MethodPost, "/tables/docs/attachments", `{"column": "file", "name": "`+name+`", "contentType": "application/pdf", "size": 1024}`)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		var resp struct {
+			Upload map[string]interface{} `json:"upload"`
+			Value  json.RawMessage        `json:"value"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, http.MethodPut, resp.Upload["method"])
+		assert.Contains(t, resp.Upload["url"], "attachments/"+user.ID+"/docs/")
+		return string(resp.Value)
+	}
+
+	rec := do(http.MethodPost, "/tables", `{"name": "docs", "columns": [{"name": "title", "dataType": "string"}, {"name": "file", "dataType": "attachment", "nullable": true}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodPost, "/tables/docs/attachments", `{"column": "title", "name": "a.pdf"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "only attachment columns take uploads")
+	rec = do(http.MethodPost, "/tables/docs/attachments", `{"column": "file", "name": "../a.pdf"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	first := upload("v1.pdf")
+	rec = do(http.MethodPost, "/tables/docs/rows", `{"id": "d1", "values": {"title": "spec", "file": `+first+`}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/docs/rows", `{"id": "d2", "values": {"title": "elsewhere", "file": {"key": "attachments/other/docs/1/x.pdf", "name": "x.pdf"}}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodGet, "/tables/docs/rows/d1", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var row RowData
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &row))
+	require.Contains(t, row.Attachments, "file")
+	assert.Contains(t, row.Attachments["file"].URL, "v1.pdf?download")
+	assert.True(t, row.Attachments["file"].ExpiresAt.After(time.Now()))
+
+	rec = do(http.MethodGet, "/tables/docs/rows/d2", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	row = RowData{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &row))
+	assert.Empty(t, row.Attachments, "keys outside the table's prefix are not signed")
+
+	// Replacing the file and compacting away the old version deletes it
+	second := upload("v2.pdf")
+	rec = do(http.MethodPut, "/tables/docs/rows/d1", `{"values": {"title": "spec", "file": `+second+`}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = do(http.MethodPut, "/tables/docs/retention", `{"maxVersions": 1}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/docs/compact", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	require.Len(t, files.deleted, 1)
+	assert.True(t, strings.HasSuffix(files.deleted[0], "/v1.pdf"))
+}
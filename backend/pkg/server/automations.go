@@ -0,0 +1,345 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/script"
+)
+
+const (
+	// automationsDataType marks the fact holding a table's automation set
+	automationsDataType = "automations"
+
+	automationEventCreate = "create"
+	automationEventUpdate = "update"
+)
+
+// Automation is a user-defined script run on a table's row events. Its
+// transform(event) function may rewrite the values before they are stored.
+type Automation struct {
+	Name      string    `json:"name"`
+	Events    []string  `json:"events"`
+	Script    string    `json:"script"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// handles reports whether the automation runs for the given event type
+func (a Automation) handles(event string) bool {
+	for _, e := range a.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// automationsField is the field, in the user's namespace, holding a table's
+// automations. Table names cannot contain '/', so it never clashes with a table.
+func automationsField(table string) string {
+	return settingField(table, "automations")
+}
+
+// loadAutomations returns a table's automations sorted by name. defs are the
+// table's definition facts; automations saved before the table was last
+// (re)created are ignored.
+func (s *Server) loadAutomations(ctx context.Context, store *db.StoreAdapter, userID, table string, defs []dynamo.Fact) ([]Automation, error) {
+	facts, err := store.QueryByField(ctx, userID, automationsField(table), time.Time{}, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *dynamo.Fact
+	for i := range facts {
+		if facts[i].DataType != automationsDataType {
+			continue
+		}
+		if latest == nil || facts[i].Timestamp.After(latest.Timestamp) {
+			latest = &facts[i]
+		}
+	}
+	if latest == nil || latest.Timestamp.Before(latestTableDef(defs).Timestamp) {
+		return nil, nil
+	}
+
+	raw, _ := latest.Value.(string)
+	var automations []Automation
+	if err := json.Unmarshal([]byte(raw), &automations); err != nil {
+		return nil, fmt.Errorf("decoding automations for table %s: %w", table, err)
+	}
+	sort.Slice(automations, func(i, j int) bool { return automations[i].Name < automations[j].Name })
+	return automations, nil
+}
+
+// saveAutomations records the full automation set for a table
+func (s *Server) saveAutomations(ctx context.Context, store *db.StoreAdapter, userID, table string, automations []Automation) error {
+	if automations == nil {
+		automations = []Automation{}
+	}
+	data, err := json.Marshal(automations)
+	if err != nil {
+		return err
+	}
+	return store.PutFact(ctx, dynamo.Fact{
+		ID:        newID(),
+		Timestamp: time.Now().UTC(),
+		Namespace: userID,
+		FieldName: automationsField(table),
+		DataType:  automationsDataType,
+		Value:     string(data),
+	})
+}
+
+// runAutomations passes ev through every matching automation in name order,
+// each seeing the previous one's output, and returns the values to store. On
+// failure it writes the error response and returns false.
+func (s *Server) runAutomations(w http.ResponseWriter, r *http.Request, store *db.StoreAdapter, userID string, defs []dynamo.Fact, ev script.Event) (map[string]interface{}, bool) {
+	values, err := s.applyAutomations(r.Context(), store, userID, defs, ev)
+	var failed *automationError
+	switch {
+	case errors.As(err, &failed):
+		writeError(w, http.StatusUnprocessableEntity, failed.Error())
+		return nil, false
+	case err != nil:
+		writeStoreError(w, err, "Failed to load automations")
+		return nil, false
+	}
+	return values, true
+}
+
+// automationError is an automation script that failed on a row event
+type automationError struct {
+	name string
+	err  error
+}
+
+func (e *automationError) Error() string {
+	return fmt.Sprintf("Automation '%s' failed: %v", e.name, e.err)
+}
+
+// applyAutomations is runAutomations for callers that report errors
+// themselves. A failing script is returned as an *automationError.
+func (s *Server) applyAutomations(ctx context.Context, store *db.StoreAdapter, userID string, defs []dynamo.Fact, ev script.Event) (map[string]interface{}, error) {
+	automations, err := s.loadAutomations(ctx, store, userID, ev.Table, defs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range automations {
+		if !a.handles(ev.Type) {
+			continue
+		}
+		prog, err := script.Compile(a.Name, a.Script, s.config.ScriptLimits)
+		if err == nil {
+			var out map[string]interface{}
+			out, err = prog.Transform(ctx, ev)
+			if out != nil {
+				ev.Values = out
+			}
+		}
+		if err != nil {
+			s.logger.WarnContext(ctx, "automation failed", "table", ev.Table, "automation", a.Name, "error", err)
+			return nil, &automationError{name: a.Name, err: err}
+		}
+	}
+	return ev.Values, nil
+}
+
+// handleListAutomations lists the automations attached to a table
+func (s *Server) handleListAutomations(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Validate table exists
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+
+	automations, err := s.loadAutomations(r.Context(), store, user.ID, table, facts)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load automations")
+		return
+	}
+	if automations == nil {
+		automations = []Automation{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"automations": automations})
+}
+
+// handlePutAutomation creates or replaces a named automation on a table
+func (s *Server) handlePutAutomation(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+	name := r.PathValue("name")
+
+	var req struct {
+		Events []string `json:"events"`
+		Script string   `json:"script"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	var fields fieldErrors
+	if problem := s.nameProblem(name); problem != "" {
+		fields = append(fields, FieldError{Field: "name", Message: problem})
+	}
+	fields.required("script", req.Script)
+	if len(req.Events) == 0 {
+		req.Events = []string{automationEventCreate, automationEventUpdate}
+	}
+	for _, e := range req.Events {
+		if e != automationEventCreate && e != automationEventUpdate && e != rowEventExpire {
+			fields = append(fields, FieldError{Field: "events", Message: fmt.Sprintf("unknown event '%s'", e)})
+		}
+	}
+	if len(fields) > 0 {
+		writeValidationError(w, "Invalid automation", fields)
+		return
+	}
+
+	if _, err := script.Compile(name, req.Script, s.config.ScriptLimits); err != nil {
+		writeValidationError(w, "Invalid automation", []FieldError{{Field: "script", Message: err.Error()}})
+		return
+	}
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Validate table exists
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+
+	automations, err := s.loadAutomations(r.Context(), store, user.ID, table, facts)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load automations")
+		return
+	}
+
+	automation := Automation{Name: name, Events: req.Events, Script: req.Script, UpdatedAt: time.Now().UTC()}
+	status := http.StatusCreated
+	for i, a := range automations {
+		if a.Name == name {
+			automations = append(automations[:i], automations[i+1:]...)
+			status = http.StatusOK
+			break
+		}
+	}
+	automations = append(automations, automation)
+
+	if err := s.saveAutomations(r.Context(), store, user.ID, table, automations); err != nil {
+		writeStoreError(w, err, "Failed to save automation")
+		return
+	}
+
+	writeJSON(w, status, automation)
+}
+
+// handleDeleteAutomation removes a named automation from a table
+func (s *Server) handleDeleteAutomation(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+	name := r.PathValue("name")
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Validate table exists
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+
+	automations, err := s.loadAutomations(r.Context(), store, user.ID, table, facts)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load automations")
+		return
+	}
+
+	found := -1
+	for i, a := range automations {
+		if a.Name == name {
+			found = i
+			break
+		}
+	}
+	if found < 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Automation '%s' not found on table '%s'", name, table))
+		return
+	}
+
+	automations = append(automations[:found], automations[found+1:]...)
+	if err := s.saveAutomations(r.Context(), store, user.ID, table, automations); err != nil {
+		writeStoreError(w, err, "Failed to delete automation")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// previousValues returns a stored row's plain values for an update event.
+// Encrypted and archived rows have none.
+func previousValues(fact dynamo.Fact) map[string]interface{} {
+	if fact.DataType != "json" {
+		return nil
+	}
+	values, _ := fact.Value.(map[string]interface{})
+	return values
+}
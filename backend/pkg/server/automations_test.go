@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutomationHandles(t *testing.T) {
+	a := Automation{Events: []string{automationEventCreate}}
+	assert.True(t, a.handles(automationEventCreate))
+	assert.False(t, a.handles(automationEventUpdate))
+}
+
+func TestPreviousValues(t *testing.T) {
+	values := map[string]interface{}{"a": "b"}
+	assert.Equal(t, values, previousValues(dynamo.Fact{DataType: "json", Value: values}))
+	assert.Nil(t, previousValues(dynamo.Fact{DataType: secretDataType, Value: "sealed"}))
+}
+
+func TestPutAutomationRejectsInvalidScripts(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard()})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "key", 0)
+	require.NoError(t, err)
+
+	for name, body := range map[string]string{
+		"syntax":      `{"script":"def transform(event)"}`,
+		"no function": `{"script":"x = 1"}`,
+		"event":       `{"script":"def transform(event):\n    return None","events":["delete"]}`,
+		"missing":     `{}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/tables/posts/automations/slug", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, req)
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+
+			var resp validationErrorResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.NotEmpty(t, resp.Fields)
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/logging"
+)
+
+// benchRowValues returns the values of row i of the benchmark tables
+func benchRowValues(i int) map[string]interface{} {
+	return map[string]interface{}{
+		"title": fmt.Sprintf("Row %d", i),
+		"count": float64(i),
+		"done":  i%2 == 0,
+		"tags":  []interface{}{"a", "b"},
+		"notes": "Lorem ipsum dolor sit amet, consectetur adipiscing elit",
+	}
+}
+
+// BenchmarkWriteRows measures encoding large row sets as JSON responses
+func BenchmarkWriteRows(b *testing.B) {
+	for _, n := range []int{1_000, 10_000} {
+		b.Run(fmt.Sprintf("%dk", n/1000), func(b *testing.B) {
+			now := time.Now().UTC()
+			rows := make([]RowData, n)
+			for i := range rows {
+				rows[i] = RowData{ID: fmt.Sprintf("row-%d", i), Timestamp: now, Values: benchRowValues(i), CreatedAt: &now, UpdatedAt: &now}
+			}
+			rec := httptest.NewRecorder()
+			writeRows(rec, rows)
+			b.SetBytes(int64(rec.Body.Len()))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				writeRows(httptest.NewRecorder(), rows)
+			}
+		})
+	}
+}
+
+// BenchmarkListRows measures GET /tables/{table}/rows of tables of the
+// in-memory server, with one version of each row
+func BenchmarkListRows(b *testing.B) {
+	for _, n := range []int{1_000, 10_000} {
+		b.Run(fmt.Sprintf("%dk", n/1000), func(b *testing.B) {
+			srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true})
+			if err != nil {
+				b.Fatal(err)
+			}
+			ctx := context.Background()
+			b.Cleanup(func() { srv.Stop(ctx) })
+			user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+			if err != nil {
+				b.Fatal(err)
+			}
+			do := func(method, path, body string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(method, path, strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("Authorization", "Bearer "+apiKey)
+				rec := httptest.NewRecorder()
+				srv.Handler().ServeHTTP(rec, req)
+				return rec
+			}
+			rec := do(http.MethodPost, "/tables", `{"name": "notes", "columns": [{"name": "title", "dataType": "string"}, {"name": "count", "dataType": "number"}]}`)
+			if rec.Code != http.StatusCreated {
+				b.Fatalf("creating table: %d %s", rec.Code, rec.Body)
+			}
+
+			// Writing the rows to the store directly keeps the setup fast
+			store, err := srv.getStoreForUser(ctx, user.ID)
+			if err != nil {
+				b.Fatal(err)
+			}
+			now := time.Now().UTC()
+			for i := 0; i < n; i++ {
+				err := store.PutFact(ctx, dynamo.Fact{
+					ID:        newID(),
+					Timestamp: now,
+					Namespace: tableNamespace(user.ID, "notes"),
+					FieldName: fmt.Sprintf("row-%d", i),
+					DataType:  "json",
+					Value:     benchRowValues(i),
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			var list struct {
+				Rows []json.RawMessage `json:"rows"`
+			}
+			if err := json.Unmarshal(do(http.MethodGet, "/v1/tables/notes/rows", "").Body.Bytes(), &list); err != nil || len(list.Rows) != n {
+				b.Fatalf("listing rows returned %d rows, want %d (%v)", len(list.Rows), n, err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if rec := do(http.MethodGet, "/v1/tables/notes/rows", ""); rec.Code != http.StatusOK {
+					b.Fatalf("listing rows: %d %s", rec.Code, rec.Body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+)
+
+// A fact has two times. Timestamp, the transaction time, is when it was
+// written. Its valid time is when it takes effect, which a client may set
+// apart from the write, for example to backdate a correction; see
+// dynamo.Fact.EffectiveTime.
+
+// snapshotTimes are the times a snapshot is taken at
+type snapshotTimes struct {
+	// asOf is the transaction time: only facts written by then are read
+	asOf time.Time
+	// validAt, when set, is the valid time: each row is the version in
+	// effect then. Without it each row is its latest write.
+	validAt time.Time
+	// past is set when either time was given, so the snapshot needs history
+	past bool
+}
+
+// parseSnapshotTimes reads a snapshot's times from the "asOf" query
+// parameter, or its older name "at", and from "validAt". asOf defaults to
+// now.
+func parseSnapshotTimes(q url.Values, now time.Time) (snapshotTimes, error) {
+	times := snapshotTimes{asOf: now}
+	for _, name := range []string{"asOf", "at"} {
+		if v := q.Get(name); v != "" {
+			at, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return snapshotTimes{}, fmt.Errorf("Invalid '%s' time format: %v (expected RFC3339)", name, err)
+			}
+			times.asOf, times.past = at.UTC(), true
+			break
+		}
+	}
+	if v := q.Get("validAt"); v != "" {
+		at, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return snapshotTimes{}, fmt.Errorf("Invalid 'validAt' time format: %v (expected RFC3339)", err)
+		}
+		times.validAt, times.past = at.UTC(), true
+	}
+	return times, nil
+}
+
+// rowsAt returns the time rows of a snapshot taken at times expire by: the
+// valid time when there is one
+func (t snapshotTimes) rowsAt() time.Time {
+	if !t.validAt.IsZero() {
+		return t.validAt
+	}
+	return t.asOf
+}
+
+// snapshotEntries returns a table's row facts at the snapshot's times
+func (s *Server) snapshotEntries(ctx context.Context, store, rowStore *db.StoreAdapter, user *auth.User, table string, def dynamo.Fact, times snapshotTimes) (map[string]dynamo.Fact, error) {
+	if times.validAt.IsZero() {
+		return s.tableEntries(ctx, store, rowStore, user, table, def, times.asOf)
+	}
+	history, err := s.tableHistory(ctx, store, rowStore, user, table, def, time.Time{}, times.asOf)
+	if err != nil {
+		return nil, err
+	}
+	return validSnapshot(history, times.validAt), nil
+}
+
+// validSnapshot returns the fact of each field in effect at validAt: of the
+// facts taking effect by then, the one taking effect last, and of those the
+// last written. Deleted fields keep their tombstones, as in snapshots.
+func validSnapshot(facts []dynamo.Fact, validAt time.Time) map[string]dynamo.Fact {
+	sorted := make([]dynamo.Fact, len(facts))
+	copy(sorted, facts)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+	entries := make(map[string]dynamo.Fact)
+	for _, f := range sorted {
+		effective := f.EffectiveTime()
+		if effective.After(validAt) {
+			continue
+		}
+		if cur, ok := entries[f.FieldName]; ok && effective.Before(cur.EffectiveTime()) {
+			continue
+		}
+		entries[f.FieldName] = f
+	}
+	return entries
+}
+
+// validTime returns the valid time a client gave a write, in UTC, or nil
+// when it gave none
+func validTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
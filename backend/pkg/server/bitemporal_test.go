@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotValidAt(t *testing.T) {
+	_, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n1", "values": {"v": 1}, "validTime": "2024-01-01T00:00:00Z"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var row RowData
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &row))
+	require.NotNil(t, row.ValidTime)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), *row.ValidTime)
+	rec = do(http.MethodPut, "/tables/notes/rows/n1", `{"values": {"v": 2}, "validTime": "2024-03-01T00:00:00Z"}`)
+	require.Less(t, rec.Code, 300, rec.Body.String())
+	before := time.Now().UTC()
+
+	// A correction of February, written after March's value
+	rec = do(http.MethodPut, "/tables/notes/rows/n1", `{"values": {"v": 10}, "validTime": "2024-02-01T00:00:00Z"}`)
+	require.Less(t, rec.Code, 300, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n2", "values": {"v": 5}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	snapshot := func(params url.Values) map[string]float64 {
+		t.Helper()
+		return snapshotValues(t, do, "/tables/notes/snapshot?"+params.Encode())
+	}
+	assert.Equal(t, map[string]float64{"n1": 10, "n2": 5}, snapshot(url.Values{}), "without validAt rows are their latest writes")
+	assert.Equal(t, map[string]float64{"n1": 10}, snapshot(url.Values{"validAt": {"2024-02-15T00:00:00Z"}}))
+	assert.Equal(t, map[string]float64{"n1": 2}, snapshot(url.Values{"validAt": {"2024-03-15T00:00:00Z"}}), "the correction does not reach past March")
+	assert.Equal(t, map[string]float64{"n1": 1}, snapshot(url.Values{"validAt": {"2024-02-15T00:00:00Z"}, "asOf": {before.Format(time.RFC3339Nano)}}), "facts written after asOf are not known")
+	assert.Empty(t, snapshot(url.Values{"validAt": {"2023-12-01T00:00:00Z"}}))
+	assert.Equal(t, map[string]float64{"n1": 1}, snapshotValues(t, do, "/tables/notes/rows?validAt=2024-01-15T00:00:00Z"))
+
+	rec = do(http.MethodGet, "/tables/notes/rows?validAt=2024-03-15T00:00:00Z", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var list struct {
+		Rows []RowData `json:"rows"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list.Rows, 1)
+	require.NotNil(t, list.Rows[0].ValidTime)
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), *list.Rows[0].ValidTime)
+
+	// Deleting a row ends it from the time it is deleted
+	rec = do(http.MethodDelete, "/tables/notes/rows/n1", "")
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+	assert.Equal(t, map[string]float64{"n1": 2}, snapshot(url.Values{"validAt": {"2024-03-15T00:00:00Z"}}))
+	assert.Equal(t, map[string]float64{"n2": 5}, snapshot(url.Values{"validAt": {time.Now().UTC().Add(time.Minute).Format(time.RFC3339)}}))
+
+	rec = do(http.MethodGet, "/tables/notes/history?start=2000-01-01T00:00:00Z&end="+url.QueryEscape(time.Now().UTC().Add(time.Minute).Format(time.RFC3339)), "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var history struct {
+		Events []RowEvent `json:"events"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &history))
+	require.NotEmpty(t, history.Events)
+	require.NotNil(t, history.Events[0].ValidTime, "history has the valid time of each change")
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), *history.Events[0].ValidTime)
+
+	rec = do(http.MethodGet, "/tables/notes/snapshot?validAt=yesterday", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "validAt")
+}
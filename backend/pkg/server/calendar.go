@@ -0,0 +1,507 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/ical"
+)
+
+const (
+	// calendarDataType marks the fact holding a table's calendar feed
+	calendarDataType = "calendar"
+	// calendarTokenPrefix starts every calendar feed token
+	calendarTokenPrefix = "nbcal_"
+	calendarProdID      = "-//Notably//Calendar Feed//EN"
+)
+
+// calendarPlaceholder matches the {column} placeholders of feed templates
+var calendarPlaceholder = regexp.MustCompile(`\{([A-Za-z0-9_-]+)\}`)
+
+// CalendarFeed renders a table's rows as calendar events dated by Column.
+// Title and Description are templates in which {column} is replaced by the
+// row's value for that column.
+type CalendarFeed struct {
+	Column      string    `json:"column"`
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+
+	// URL and Token are only returned when the feed is saved
+	URL   string `json:"url,omitempty"`
+	Token string `json:"token,omitempty"`
+}
+
+// storedCalendarFeed is a feed as saved, with its token hashed
+type storedCalendarFeed struct {
+	CalendarFeed
+	TokenHash string `json:"tokenHash"`
+}
+
+// calendarField is the field, in the user's namespace, holding a table's
+// calendar feed
+func calendarField(table string) string {
+	return settingField(table, "calendar")
+}
+
+// hashCalendarToken returns the stored form of a feed token
+func hashCalendarToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newCalendarToken returns a random feed token
+func newCalendarToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return calendarTokenPrefix + hex.EncodeToString(b), nil
+}
+
+// loadCalendarFeed returns a table's calendar feed, or nil if it has none.
+// defs are the table's definition facts; feeds saved before the table was
+// last (re)created are ignored.
+func (s *Server) loadCalendarFeed(ctx context.Context, store *db.StoreAdapter, userID, table string, defs []dynamo.Fact) (*storedCalendarFeed, error) {
+	facts, err := store.QueryByField(ctx, userID, calendarField(table), time.Time{}, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *dynamo.Fact
+	for i := range facts {
+		if facts[i].DataType != calendarDataType {
+			continue
+		}
+		if latest == nil || facts[i].Timestamp.After(latest.Timestamp) {
+			latest = &facts[i]
+		}
+	}
+	if latest == nil || latest.Timestamp.Before(latestTableDef(defs).Timestamp) {
+		return nil, nil
+	}
+
+	raw, _ := latest.Value.(string)
+	if raw == "" {
+		// The feed was deleted
+		return nil, nil
+	}
+	var feed storedCalendarFeed
+	if err := json.Unmarshal([]byte(raw), &feed); err != nil {
+		return nil, fmt.Errorf("decoding calendar feed for table %s: %w", table, err)
+	}
+	return &feed, nil
+}
+
+// saveCalendarFeed records a table's calendar feed; nil deletes it
+func (s *Server) saveCalendarFeed(ctx context.Context, store *db.StoreAdapter, userID, table string, feed *storedCalendarFeed) error {
+	value := ""
+	if feed != nil {
+		data, err := json.Marshal(feed)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	}
+	return store.PutFact(ctx, dynamo.Fact{
+		ID:        newID(),
+		Timestamp: time.Now().UTC(),
+		Namespace: userID,
+		FieldName: calendarField(table),
+		DataType:  calendarDataType,
+		Value:     value,
+	})
+}
+
+// validateCalendarFeed checks a feed against the table's columns. Tables
+// without column definitions accept any column names.
+func validateCalendarFeed(feed CalendarFeed, columns []dynamo.ColumnDefinition) fieldErrors {
+	if len(columns) == 0 {
+		return nil
+	}
+
+	var fields fieldErrors
+	types := make(map[string]string, len(columns))
+	for _, col := range columns {
+		types[col.Name] = col.DataType
+	}
+	if typ, ok := types[feed.Column]; !ok {
+		fields = append(fields, FieldError{Field: "column", Message: fmt.Sprintf("unknown column '%s'", feed.Column)})
+	} else if typ != "datetime" && typ != "date" {
+		fields = append(fields, FieldError{Field: "column", Message: fmt.Sprintf("column '%s' is not a date or datetime column", feed.Column)})
+	}
+	for _, tmpl := range []struct{ field, text string }{{"title", feed.Title}, {"description", feed.Description}} {
+		for _, m := range calendarPlaceholder.FindAllStringSubmatch(tmpl.text, -1) {
+			if _, ok := types[m[1]]; !ok {
+				fields = append(fields, FieldError{Field: tmpl.field, Message: fmt.Sprintf("unknown column '%s'", m[1])})
+			}
+		}
+	}
+	return fields
+}
+
+// renderCalendarTemplate replaces the {column} placeholders of tmpl with a
+// row's values; missing values render as nothing
+func renderCalendarTemplate(tmpl string, values map[string]interface{}) string {
+	return calendarPlaceholder.ReplaceAllStringFunc(tmpl, func(m string) string {
+		v, ok := values[m[1:len(m)-1]]
+		if !ok || v == nil {
+			return ""
+		}
+		if str, ok := v.(string); ok {
+			return str
+		}
+		data, _ := json.Marshal(v)
+		return string(data)
+	})
+}
+
+// parseEventStart reads an event start from a row value: RFC 3339 datetimes
+// start at that instant and plain YYYY-MM-DD dates last the whole day
+func parseEventStart(v interface{}) (start time.Time, allDay, ok bool) {
+	str, isString := v.(string)
+	if !isString {
+		return time.Time{}, false, false
+	}
+	if t, err := time.Parse(time.RFC3339, str); err == nil {
+		return t, false, true
+	}
+	if t, err := time.Parse(time.DateOnly, str); err == nil {
+		return t, true, true
+	}
+	return time.Time{}, false, false
+}
+
+// calendarEvents renders rows as events, in start order. Rows without a
+// valid date in the feed's column are left out.
+func calendarEvents(feed CalendarFeed, userID, table string, rows []RowData) []ical.Event {
+	events := make([]ical.Event, 0, len(rows))
+	for _, row := range rows {
+		start, allDay, ok := parseEventStart(row.Values[feed.Column])
+		if !ok {
+			continue
+		}
+		ev := ical.Event{
+			UID:         fmt.Sprintf("%s@%s.%s.notably", row.ID, table, userID),
+			Start:       start,
+			AllDay:      allDay,
+			Summary:     row.ID,
+			Description: renderCalendarTemplate(feed.Description, row.Values),
+			Updated:     row.Timestamp,
+		}
+		if feed.Title != "" {
+			ev.Summary = renderCalendarTemplate(feed.Title, row.Values)
+		}
+		if allDay {
+			ev.End = start.AddDate(0, 0, 1)
+		}
+		events = append(events, ev)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].Start.Equal(events[j].Start) {
+			return events[i].Start.Before(events[j].Start)
+		}
+		return events[i].UID < events[j].UID
+	})
+	return events
+}
+
+// calendarFeedURL returns the subscription URL of a feed, on the host the
+// request was made to
+func calendarFeedURL(r *http.Request, userID, table, token string) string {
+	return externalURL(r, fmt.Sprintf("%s/calendar/%s/%s", apiVersion, userID, table), url.Values{"token": {token}})
+}
+
+// externalURL returns an absolute URL for path and query on the host the
+// request was made to
+func externalURL(r *http.Request, path string, query url.Values) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	u := url.URL{Scheme: scheme, Host: r.Host, Path: path, RawQuery: query.Encode()}
+	return u.String()
+}
+
+// handleGetCalendarFeed returns a table's calendar feed settings. The feed
+// token cannot be read back; saving the feed issues a new one.
+func (s *Server) handleGetCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Validate table exists
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+
+	feed, err := s.loadCalendarFeed(r.Context(), store, user.ID, table, facts)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load calendar feed")
+		return
+	}
+	if feed == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' has no calendar feed", table))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, feed.CalendarFeed)
+}
+
+// handlePutCalendarFeed creates or replaces a table's calendar feed. Every
+// save issues a new token, so the previous feed URL stops working.
+func (s *Server) handlePutCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+
+	var req struct {
+		Column      string `json:"column"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	var fields fieldErrors
+	fields.required("column", req.Column)
+	if len(fields) > 0 {
+		writeValidationError(w, "Invalid calendar feed", fields)
+		return
+	}
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Validate table exists
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+	def := latestTableDef(facts)
+	if tableOptionsOf(def).Type == tableTypeSecrets {
+		writeError(w, http.StatusBadRequest, "Secrets tables cannot have calendar feeds")
+		return
+	}
+
+	feed := CalendarFeed{Column: req.Column, Title: req.Title, Description: req.Description, UpdatedAt: time.Now().UTC()}
+	if fields := validateCalendarFeed(feed, def.Columns); len(fields) > 0 {
+		writeValidationError(w, "Invalid calendar feed", fields)
+		return
+	}
+
+	existing, err := s.loadCalendarFeed(r.Context(), store, user.ID, table, facts)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load calendar feed")
+		return
+	}
+
+	token, err := newCalendarToken()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to generate feed token")
+		return
+	}
+	if err := s.saveCalendarFeed(r.Context(), store, user.ID, table, &storedCalendarFeed{CalendarFeed: feed, TokenHash: hashCalendarToken(token)}); err != nil {
+		writeStoreError(w, err, "Failed to save calendar feed")
+		return
+	}
+
+	status := http.StatusCreated
+	if existing != nil {
+		status = http.StatusOK
+	}
+	feed.Token = token
+	feed.URL = calendarFeedURL(r, user.ID, table, token)
+	writeJSON(w, status, feed)
+}
+
+// handleDeleteCalendarFeed removes a table's calendar feed, invalidating its URL
+func (s *Server) handleDeleteCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Validate table exists
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+
+	feed, err := s.loadCalendarFeed(r.Context(), store, user.ID, table, facts)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load calendar feed")
+		return
+	}
+	if feed == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' has no calendar feed", table))
+		return
+	}
+
+	if err := s.saveCalendarFeed(r.Context(), store, user.ID, table, nil); err != nil {
+		writeStoreError(w, err, "Failed to delete calendar feed")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCalendarFeed serves a table's rows as an iCalendar feed. It takes no
+// API key, since calendar clients cannot send one; the feed token in the
+// query string authorizes the request instead. Every failure to find the
+// account, table, feed or token is reported the same way.
+func (s *Server) handleCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("user")
+	table := r.PathValue("table")
+	token := r.URL.Query().Get("token")
+
+	notFound := func() {
+		writeError(w, http.StatusNotFound, "Calendar feed not found")
+	}
+	if token == "" {
+		notFound()
+		return
+	}
+
+	user, err := s.userStore.GetUserByID(r.Context(), userID)
+	if err != nil {
+		notFound()
+		return
+	}
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Validate table exists. Temporary tables bound to an API key are never
+	// visible here.
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		notFound()
+		return
+	}
+
+	feed, err := s.loadCalendarFeed(r.Context(), store, user.ID, table, facts)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load calendar feed")
+		return
+	}
+	if feed == nil || subtle.ConstantTimeCompare([]byte(feed.TokenHash), []byte(hashCalendarToken(token))) != 1 {
+		notFound()
+		return
+	}
+
+	rows, err := s.plainRows(r.Context(), store, user, table, facts)
+	if err != nil {
+		if proxiedTable(facts) {
+			writeVirtualError(w, err)
+			return
+		}
+		writeStoreError(w, err, "Failed to get rows")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", table+".ics"))
+	cal := ical.Calendar{ProdID: calendarProdID, Name: table, Events: calendarEvents(feed.CalendarFeed, user.ID, table, rows)}
+	if err := ical.Write(w, cal); err != nil {
+		s.logger.WarnContext(r.Context(), "writing calendar feed failed", "table", table, "error", err)
+	}
+}
+
+// plainRows returns the current rows of a table that have plain values,
+// leaving out secrets rows
+func (s *Server) plainRows(ctx context.Context, store *db.StoreAdapter, user *auth.User, table string, facts []dynamo.Fact) ([]RowData, error) {
+	if proxiedTable(facts) {
+		rows, _, err := s.virtualRows(ctx, user.ID, table, latestTableDef(facts), false)
+		return rows, err
+	}
+
+	rowStore, err := s.getRowStore(ctx, store, user, table)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	snap, err := rowStore.GetTableSnapshot(ctx, user.ID, table, now)
+	if err != nil {
+		return nil, err
+	}
+
+	ttlColumn := tableOptionsOf(latestTableDef(facts)).TTLColumn
+	var rows []RowData
+	for id, fact := range snap {
+		if fact.DataType != "json" {
+			continue
+		}
+		if vals, ok := fact.Value.(map[string]interface{}); ok && !rowExpired(ttlColumn, vals, now) {
+			rows = append(rows, RowData{ID: id, Timestamp: fact.Timestamp, Values: vals})
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateCalendarFeed(t *testing.T) {
+	columns := []dynamo.ColumnDefinition{
+		{Name: "title", DataType: "string"},
+		{Name: "due", DataType: "datetime"},
+	}
+
+	assert.Empty(t, validateCalendarFeed(CalendarFeed{Column: "due", Title: "{title}"}, columns))
+	assert.Empty(t, validateCalendarFeed(CalendarFeed{Column: "anything", Title: "{whatever}"}, nil))
+
+	fields := validateCalendarFeed(CalendarFeed{Column: "title", Description: "{owner}"}, columns)
+	require.Len(t, fields, 2)
+	assert.Equal(t, "column", fields[0].Field)
+	assert.Contains(t, fields[0].Message, "not a date or datetime column")
+	assert.Equal(t, FieldError{Field: "description", Message: "unknown column 'owner'"}, fields[1])
+
+	fields = validateCalendarFeed(CalendarFeed{Column: "missing"}, columns)
+	assert.Equal(t, fieldErrors{{Field: "column", Message: "unknown column 'missing'"}}, fields)
+}
+
+func TestCalendarEvents(t *testing.T) {
+	updated := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	rows := []RowData{
+		{ID: "launch", Timestamp: updated, Values: map[string]interface{}{"name": "Launch", "due": "2024-06-01"}},
+		{ID: "report", Timestamp: updated, Values: map[string]interface{}{"name": "Report", "due": "2024-05-03T17:00:00Z", "hours": 3.0}},
+		{ID: "undated", Timestamp: updated, Values: map[string]interface{}{"name": "Someday"}},
+		{ID: "bad", Timestamp: updated, Values: map[string]interface{}{"name": "Bad", "due": "next week"}},
+	}
+	feed := CalendarFeed{Column: "due", Title: "{name} due", Description: "{hours}h of work{missing}"}
+
+	events := calendarEvents(feed, "u1", "deadlines", rows)
+	require.Len(t, events, 2)
+
+	assert.Equal(t, "report@deadlines.u1.notably", events[0].UID)
+	assert.Equal(t, "Report due", events[0].Summary)
+	assert.Equal(t, "3h of work", events[0].Description)
+	assert.False(t, events[0].AllDay)
+	assert.Equal(t, time.Date(2024, 5, 3, 17, 0, 0, 0, time.UTC), events[0].Start)
+
+	assert.Equal(t, "Launch due", events[1].Summary)
+	assert.True(t, events[1].AllDay)
+	assert.Equal(t, time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC), events[1].End)
+
+	// Without a title template events are named after their rows
+	events = calendarEvents(CalendarFeed{Column: "due"}, "u1", "deadlines", rows[:1])
+	assert.Equal(t, "launch", events[0].Summary)
+}
+
+func TestCalendarFeedURL(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPut, "/tables/deadlines/calendar", nil)
+	req.Host = "notably.example.com"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	assert.Equal(t, "https://notably.example.com/v1/calendar/u1/deadlines?token=nbcal_abc", calendarFeedURL(req, "u1", "deadlines", "nbcal_abc"))
+}
+
+func TestCalendarFeedRequiresToken(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard()})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "calendar", 0)
+	require.NoError(t, err)
+
+	for _, path := range []string{"/calendar/" + user.ID + "/deadlines", "/calendar/nobody/deadlines?token=nbcal_x"} {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code, path)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/tables/deadlines/calendar", strings.NewReader(`{"title": "{name}"}`))
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"column"`)
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+)
+
+// historyFormatDebezium selects the change feed export of a table's history
+const historyFormatDebezium = "debezium"
+
+// changeConnector names the server as the connector in change events
+const changeConnector = "notably"
+
+// Debezium change event ops
+const (
+	changeCreate = "c"
+	changeUpdate = "u"
+	changeDelete = "d"
+)
+
+// changeEvent is a row change in the form of a Debezium change event
+// envelope, as Debezium writes it with schemas disabled. Before and After
+// hold the row's values and its ID, under "id".
+type changeEvent struct {
+	Before map[string]interface{} `json:"before"`
+	After  map[string]interface{} `json:"after"`
+	Source changeSource           `json:"source"`
+	Op     string                 `json:"op"`
+	TsMs   int64                  `json:"ts_ms"`
+}
+
+// changeSource is the source block of a change event: where and when the
+// change was written
+type changeSource struct {
+	Version   string `json:"version"`
+	Connector string `json:"connector"`
+	Name      string `json:"name"`
+	TsMs      int64  `json:"ts_ms"`
+	Snapshot  string `json:"snapshot"`
+	DB        string `json:"db"`
+	Table     string `json:"table"`
+	FactID    string `json:"fact_id,omitempty"`
+	Region    string `json:"region,omitempty"`
+	// ActorUserID and APIKeyID are who wrote the fact, when it records it
+	ActorUserID string `json:"actor_user_id,omitempty"`
+	APIKeyID    string `json:"api_key_id,omitempty"`
+}
+
+// changeFeed turns a table's facts into change events. It remembers each
+// row's last values, so updates carry what they replaced.
+type changeFeed struct {
+	userID, table string
+	rows          map[string]map[string]interface{}
+}
+
+// rowImage returns a row's values with its ID, as change events show it
+func rowImage(id string, values map[string]interface{}) map[string]interface{} {
+	image := make(map[string]interface{}, len(values)+1)
+	for k, v := range values {
+		image[k] = v
+	}
+	image[joinRowID] = id
+	return image
+}
+
+// event returns the change a fact makes, reporting false for a write that
+// changes nothing: a delete of a row that does not exist
+func (cf *changeFeed) event(f dynamo.Fact, values map[string]interface{}, live bool, now time.Time) (changeEvent, bool) {
+	before := cf.rows[f.FieldName]
+	ev := changeEvent{
+		Before: before,
+		Source: changeSource{
+			Version:     strings.TrimPrefix(apiVersion, "/"),
+			Connector:   changeConnector,
+			Name:        changeConnector,
+			TsMs:        f.Timestamp.UnixMilli(),
+			Snapshot:    "false",
+			DB:          cf.userID,
+			Table:       cf.table,
+			FactID:      f.ID,
+			Region:      f.Region,
+			ActorUserID: f.ActorUserID,
+			APIKeyID:    f.APIKeyID,
+		},
+		TsMs: now.UnixMilli(),
+	}
+	switch {
+	case !live && before == nil:
+		return changeEvent{}, false
+	case !live:
+		ev.Op = changeDelete
+		delete(cf.rows, f.FieldName)
+	default:
+		ev.Op = changeUpdate
+		if before == nil {
+			ev.Op = changeCreate
+		}
+		ev.After = rowImage(f.FieldName, values)
+		cf.rows[f.FieldName] = ev.After
+	}
+	return ev, true
+}
+
+// changeValues returns the values a row fact holds and whether it leaves
+// the row live. Secret rows are decrypted.
+func (s *Server) changeValues(ctx context.Context, f dynamo.Fact) (map[string]interface{}, bool, error) {
+	if !rowLive(f) {
+		return nil, false, nil
+	}
+	if f.DataType == secretDataType {
+		values, _, err := s.revealSecret(ctx, f)
+		return values, true, err
+	}
+	return f.Value.(map[string]interface{}), true, nil
+}
+
+// writeChangeFeed streams a table's history between start and end as
+// newline-delimited Debezium change events. The rows as they were just
+// before start are read first, so the first change of each row carries its
+// prior values.
+func (s *Server) writeChangeFeed(w http.ResponseWriter, r *http.Request, store, rowStore *db.StoreAdapter, user *auth.User, table string, def dynamo.Fact, start, end time.Time) {
+	ctx := r.Context()
+	cf := &changeFeed{userID: user.ID, table: table, rows: make(map[string]map[string]interface{})}
+	if !start.IsZero() {
+		entries, err := s.tableEntries(ctx, store, rowStore, user, table, def, start.Add(-time.Nanosecond))
+		if err != nil {
+			writeStoreError(w, err, "Failed to read rows before the time range")
+			return
+		}
+		for id, f := range entries {
+			values, live, err := s.changeValues(ctx, f)
+			if err != nil {
+				s.logger.WarnContext(ctx, "reading secret row failed", "table", table, "row", id, "error", err)
+				continue
+			}
+			if live {
+				cf.rows[id] = rowImage(id, values)
+			}
+		}
+	}
+
+	prefix := tableNamespace(user.ID, table)
+	started := false
+	count := 0
+	err := s.streamHistory(ctx, store, rowStore, user, table, def, start, end, func(batch []dynamo.Fact) error {
+		now := time.Now().UTC()
+		for _, f := range batch {
+			if f.Namespace != prefix || f.DataType != "json" && f.DataType != secretDataType && !f.IsTombstone() {
+				continue
+			}
+			values, live, err := s.changeValues(ctx, f)
+			if err != nil {
+				s.logger.WarnContext(ctx, "reading secret row failed", "table", table, "row", f.FieldName, "error", err)
+				continue
+			}
+			ev, ok := cf.event(f, values, live, now)
+			if !ok {
+				continue
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				return err
+			}
+			if !started {
+				started = true
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				w.WriteHeader(http.StatusOK)
+			}
+			if _, err := w.Write(append(data, '\n')); err != nil {
+				return err
+			}
+			if count++; count%streamFlushEvery == 0 {
+				_ = http.NewResponseController(w).Flush()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if !started {
+			writeStoreError(w, err, "Failed to query time range")
+			return
+		}
+		s.logger.ErrorContext(ctx, "streaming change feed failed", "table", table, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+	if !started {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryDebeziumExport(t *testing.T) {
+	_, do := memoryServer(t)
+	start := time.Now().UTC().Add(-time.Second)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "notes"}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/notes/rows", `{"id": "n1", "values": {"text": "draft"}}`).Code)
+	time.Sleep(10 * time.Millisecond)
+	middle := time.Now().UTC()
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, http.StatusOK, do(http.MethodPut, "/tables/notes/rows/n1", `{"values": {"text": "final"}}`).Code)
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/tables/notes/rows/n1", "").Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/notes/rows", `{"id": "n2", "values": {"text": "other"}}`).Code)
+	end := time.Now().UTC().Add(time.Second)
+
+	export := func(from time.Time) []changeEvent {
+		t.Helper()
+		q := url.Values{"start": {from.Format(time.RFC3339Nano)}, "end": {end.Format(time.RFC3339Nano)}, "format": {"debezium"}}
+		rec := do(http.MethodGet, "/tables/notes/history?"+q.Encode(), "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+		var events []changeEvent
+		scanner := bufio.NewScanner(strings.NewReader(rec.Body.String()))
+		for scanner.Scan() {
+			var ev changeEvent
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &ev))
+			events = append(events, ev)
+		}
+		return events
+	}
+
+	events := export(start)
+	require.Len(t, events, 4)
+	var ops []string
+	for _, ev := range events {
+		ops = append(ops, ev.Op)
+		assert.Equal(t, "notably", ev.Source.Connector)
+		assert.Equal(t, "notes", ev.Source.Table)
+		assert.NotEmpty(t, ev.Source.FactID)
+		assert.NotZero(t, ev.TsMs)
+	}
+	assert.Equal(t, []string{"c", "u", "d", "c"}, ops)
+	assert.Nil(t, events[0].Before)
+	assert.Equal(t, map[string]interface{}{"id": "n1", "text": "draft"}, events[0].After)
+	assert.Equal(t, events[0].After, events[1].Before)
+	assert.Equal(t, map[string]interface{}{"id": "n1", "text": "final"}, events[1].After)
+	assert.Equal(t, events[1].After, events[2].Before)
+	assert.Nil(t, events[2].After)
+
+	events = export(middle)
+	require.Len(t, events, 3)
+	assert.Equal(t, "u", events[0].Op, "rows written before the range are known")
+	assert.Equal(t, map[string]interface{}{"id": "n1", "text": "draft"}, events[0].Before)
+
+	q := url.Values{"start": {start.Format(time.RFC3339)}, "end": {end.Format(time.RFC3339)}, "format": {"avro"}}
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/tables/notes/history?"+q.Encode(), "").Code)
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/schema"
+)
+
+// withActor records the authenticated user and API key as the actor of the
+// facts the request writes
+func withActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.UserFromContext(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		actor := db.Actor{UserID: user.ID}
+		if key, ok := auth.APIKeyFromContext(r.Context()); ok {
+			actor.APIKeyID = key.ID
+		}
+		next.ServeHTTP(w, r.WithContext(db.WithActor(r.Context(), actor)))
+	})
+}
+
+// handleListChanges lists the row changes an actor made to the user's
+// tables in a time range, oldest first. The actor defaults to the
+// authenticated user, and apiKey narrows the changes to one of their keys.
+// Facts written before they recorded their actor are not listed.
+func (s *Server) handleListChanges(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	q := r.URL.Query()
+	start, err := time.Parse(time.RFC3339, q.Get("start"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'start' time format: %v (expected RFC3339)", err))
+		return
+	}
+	end, err := time.Parse(time.RFC3339, q.Get("end"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'end' time format: %v (expected RFC3339)", err))
+		return
+	}
+	if start.After(end) {
+		writeError(w, http.StatusBadRequest, "'start' time must be before 'end' time")
+		return
+	}
+	actor := q.Get("actor")
+	if actor == "" {
+		actor = user.ID
+	}
+	apiKey := q.Get("apiKey")
+
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	names, _, err := s.visibleTables(r.Context(), store, user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get tables")
+		return
+	}
+
+	// In shared mode every table's rows are in the base store, which is
+	// read once; isolated tables each have their own
+	events := []RowEvent{}
+	read := make(map[*db.StoreAdapter]bool)
+	for _, table := range names {
+		rowStore, err := s.getRowStore(r.Context(), store, user, table)
+		if err != nil {
+			writeStoreError(w, err, "Failed to initialize table storage")
+			return
+		}
+		if read[rowStore] {
+			continue
+		}
+		read[rowStore] = true
+		facts, err := rowStore.QueryByActor(r.Context(), actor, start, end)
+		if err != nil {
+			writeStoreError(w, err, "Failed to query changes")
+			return
+		}
+		tableEvents, err := s.actorEvents(r, rowStore, user.ID, names, facts, apiKey, end)
+		if err != nil {
+			writeStoreError(w, err, "Failed to query changes")
+			return
+		}
+		events = append(events, tableEvents...)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"events": events})
+}
+
+// actorEvents returns the events of the row facts an actor wrote to the
+// given tables. Each changed row's history up to end is replayed to type its
+// events, as earlier writes by others decide whether a write creates a row.
+func (s *Server) actorEvents(r *http.Request, rowStore *db.StoreAdapter, userID string, tables []string, facts []dynamo.Fact, apiKey string, end time.Time) ([]RowEvent, error) {
+	tableOf := make(map[string]string, len(tables))
+	for _, table := range tables {
+		tableOf[tableNamespace(userID, table)] = table
+	}
+	// The actor's versions, by sort key, and the rows they changed
+	written := make(map[string]bool)
+	changed := make(map[[2]string]bool)
+	var rows [][2]string
+	for _, f := range facts {
+		if _, ok := tableOf[f.Namespace]; !ok || (apiKey != "" && f.APIKeyID != apiKey) {
+			continue
+		}
+		written[schema.SortKeyValue(f.Timestamp, f.ID)] = true
+		row := [2]string{f.Namespace, f.FieldName}
+		if !changed[row] {
+			changed[row] = true
+			rows = append(rows, row)
+		}
+	}
+
+	var events []RowEvent
+	for _, row := range rows {
+		versions, err := rowStore.QueryByField(r.Context(), row[0], row[1], time.Time{}, end)
+		if err != nil {
+			return nil, err
+		}
+		live := rowStates{}
+		for _, f := range versions {
+			ev, ok := s.rowEvent(r.Context(), tableOf[row[0]], f)
+			if !ok {
+				continue
+			}
+			ev.Type = live.eventType(f.FieldName, f.IsTombstone())
+			if !written[schema.SortKeyValue(f.Timestamp, f.ID)] {
+				continue
+			}
+			ev.Table = tableOf[row[0]]
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangesByActor(t *testing.T) {
+	srv, do := memoryServer(t)
+	ctx := context.Background()
+	user, err := srv.userStore.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	key, secret, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "script", 0)
+	require.NoError(t, err)
+	script := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+secret)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	start := time.Now().UTC().Add(-time.Second)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n1", "values": {"v": 1}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = script(http.MethodPost, "/tables/notes/rows", `{"id": "n2", "values": {"v": 2}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = script(http.MethodPut, "/tables/notes/rows/n1", `{"values": {"v": 3}}`)
+	require.Less(t, rec.Code, 300, rec.Body.String())
+
+	end := time.Now().UTC().Add(time.Minute)
+	changes := func(params url.Values) []RowEvent {
+		t.Helper()
+		params.Set("start", start.Format(time.RFC3339))
+		params.Set("end", end.Format(time.RFC3339))
+		rec := do(http.MethodGet, "/changes?"+params.Encode(), "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp struct {
+			Events []RowEvent `json:"events"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp.Events
+	}
+
+	events := changes(url.Values{})
+	require.Len(t, events, 3)
+	var got []string
+	for _, e := range events {
+		got = append(got, e.Table+"/"+e.ID+" "+e.Type)
+		assert.Equal(t, user.ID, e.ActorUserID)
+	}
+	assert.Equal(t, []string{"notes/n1 created", "notes/n2 created", "notes/n1 updated"}, got)
+	assert.Equal(t, key.ID, events[1].APIKeyID)
+	assert.NotEqual(t, key.ID, events[0].APIKeyID, "each write records its own key")
+
+	events = changes(url.Values{"apiKey": {key.ID}})
+	require.Len(t, events, 2, "only the changes made with the key")
+	assert.Equal(t, "n2", events[0].ID)
+	assert.Equal(t, "updated", events[1].Type, "writes by other keys still decide the types")
+
+	assert.Empty(t, changes(url.Values{"actor": {"someone-else"}}))
+
+	// History shows who made each change too
+	q := url.Values{"start": {start.Format(time.RFC3339)}, "end": {end.Format(time.RFC3339)}}
+	rec = do(http.MethodGet, "/tables/notes/history?"+q.Encode(), "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var history struct {
+		Events []RowEvent `json:"events"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &history))
+	require.Len(t, history.Events, 3)
+	assert.Equal(t, user.ID, history.Events[2].ActorUserID)
+	assert.Equal(t, key.ID, history.Events[2].APIKeyID)
+
+	rec = do(http.MethodGet, "/changes?end="+url.QueryEscape(end.Format(time.RFC3339)), "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/elibdev/notably/db"
+)
+
+// Row writes may give the time they were made at, so that edits made
+// offline keep their place in history. Client timestamps must be within the
+// configured skew of the server's clock and after every earlier change of
+// the row, and their facts are flagged as client-dated.
+
+const (
+	// defaultMaxClockAhead is how far past the server's clock a client
+	// timestamp may be
+	defaultMaxClockAhead = time.Minute
+	// defaultMaxClockBehind is how far before the server's clock a client
+	// timestamp may be
+	defaultMaxClockBehind = 30 * 24 * time.Hour
+)
+
+// maxClockAhead returns how far ahead client timestamps may be
+func (s *Server) maxClockAhead() time.Duration {
+	if s.config.MaxClockAhead > 0 {
+		return s.config.MaxClockAhead
+	}
+	return defaultMaxClockAhead
+}
+
+// maxClockBehind returns how far behind client timestamps may be, or a
+// negative duration for no bound
+func (s *Server) maxClockBehind() time.Duration {
+	if s.config.MaxClockBehind != 0 {
+		return s.config.MaxClockBehind
+	}
+	return defaultMaxClockBehind
+}
+
+// writeTime returns the time a row write is made at: now, or the timestamp
+// the client gave, which must be within the allowed skew of now. clientDated
+// reports whether the client's timestamp is used.
+func (s *Server) writeTime(given *time.Time, now time.Time) (at time.Time, clientDated bool, fields []FieldError) {
+	if given == nil {
+		return now, false, nil
+	}
+	at = given.UTC()
+	if ahead := s.maxClockAhead(); at.After(now.Add(ahead)) {
+		return time.Time{}, false, []FieldError{{Field: "timestamp", Message: fmt.Sprintf("must be at most %s ahead of the server's clock", ahead)}}
+	}
+	if behind := s.maxClockBehind(); behind >= 0 && at.Before(now.Add(-behind)) {
+		return time.Time{}, false, []FieldError{{Field: "timestamp", Message: fmt.Sprintf("must be at most %s behind the server's clock", behind)}}
+	}
+	return at, true, nil
+}
+
+// outOfOrderError refuses a client-dated write to a row changed at or after
+// its timestamp, which would otherwise slot in under the later change
+type outOfOrderError struct {
+	row  string
+	last time.Time
+}
+
+func (e *outOfOrderError) Error() string {
+	return fmt.Sprintf("Row '%s' changed at %s, after the write's timestamp; timestamps must increase for each row", e.row, e.last.Format(time.RFC3339Nano))
+}
+
+// checkRowOrder returns an *outOfOrderError when a row has a fact at or after
+// the time of a client-dated write. Facts are at most maxClockAhead past the
+// time they were written, which bounds the search.
+func (s *Server) checkRowOrder(ctx context.Context, rowStore *db.StoreAdapter, namespace, row string, at, now time.Time) error {
+	facts, err := rowStore.QueryByField(ctx, namespace, row, at, now.Add(s.maxClockAhead()))
+	if err != nil {
+		return err
+	}
+	if len(facts) > 0 {
+		return &outOfOrderError{row: row, last: facts[len(facts)-1].Timestamp}
+	}
+	return nil
+}
+
+// clientDatedWrite resolves the time of a write to a row from the
+// client's timestamp. It writes the error response and returns false when
+// the timestamp is refused.
+func (s *Server) clientDatedWrite(w http.ResponseWriter, r *http.Request, rowStore *db.StoreAdapter, namespace, row string, given *time.Time) (at time.Time, clientDated bool, ok bool) {
+	return s.resolveWriteTime(w, given, func(at, now time.Time) error {
+		return s.checkRowOrder(r.Context(), rowStore, namespace, row, at, now)
+	})
+}
+
+// resolveWriteTime checks a client timestamp against the server's clock and,
+// with order, against the history of the rows written
+func (s *Server) resolveWriteTime(w http.ResponseWriter, given *time.Time, order func(at, now time.Time) error) (at time.Time, clientDated bool, ok bool) {
+	now := time.Now().UTC()
+	at, clientDated, fields := s.writeTime(given, now)
+	if len(fields) > 0 {
+		writeValidationError(w, "Invalid timestamp", fields)
+		return time.Time{}, false, false
+	}
+	if clientDated && rejectOutOfOrder(w, order(at, now)) {
+		return time.Time{}, false, false
+	}
+	return at, clientDated, true
+}
+
+// rejectOutOfOrder writes the response for an error of checkRowOrder,
+// HTTP 409 for writes out of order, and reports whether there was one
+func rejectOutOfOrder(w http.ResponseWriter, err error) bool {
+	var order *outOfOrderError
+	switch {
+	case err == nil:
+		return false
+	case errors.As(err, &order):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeStoreError(w, err, "Failed to read row history")
+	}
+	return true
+}
+
+// deleteTime resolves the time of a delete from its "timestamp" query
+// parameter, checking it against every row the delete removes, cascaded
+// ones included
+func (s *Server) deleteTime(w http.ResponseWriter, r *http.Request, planner *deletePlanner, userID string, rows []rowRef) (at time.Time, clientDated bool, ok bool) {
+	var given *time.Time
+	if v := r.URL.Query().Get("timestamp"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeValidationError(w, "Invalid timestamp", []FieldError{{Field: "timestamp", Message: "must be an RFC3339 time"}})
+			return time.Time{}, false, false
+		}
+		given = &t
+	}
+	return s.resolveWriteTime(w, given, func(at, now time.Time) error {
+		for _, target := range rows {
+			rowStore, err := planner.rowStore(target.table)
+			if err != nil {
+				return err
+			}
+			if err := s.checkRowOrder(r.Context(), rowStore, tableNamespace(userID, target.table), target.row, at, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
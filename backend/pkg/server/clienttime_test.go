@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientTimestamps(t *testing.T) {
+	srv, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	stamp := func(at time.Time) string { return at.UTC().Format(time.RFC3339Nano) }
+	now := time.Now().UTC()
+
+	// An edit made offline an hour ago
+	offline := now.Add(-time.Hour)
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n1", "values": {"v": 1}, "timestamp": "`+stamp(offline)+`"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var row RowData
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &row))
+	assert.True(t, offline.Equal(row.Timestamp), "the row is written at the client's time")
+	assert.Equal(t, map[string]float64{"n1": 1}, snapshotValues(t, do, "/tables/notes/snapshot?asOf="+url.QueryEscape(stamp(offline.Add(time.Minute)))))
+
+	rec = do(http.MethodPut, "/tables/notes/rows/n1", `{"values": {"v": 2}, "timestamp": "`+stamp(offline.Add(-time.Minute))+`"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code, "timestamps increase for each row")
+	assert.Contains(t, rec.Body.String(), "n1")
+	rec = do(http.MethodPut, "/tables/notes/rows/n1", `{"values": {"v": 2}, "timestamp": "`+stamp(now.Add(5*time.Minute))+`"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "timestamps may not be far ahead of the server")
+	assert.Contains(t, rec.Body.String(), "ahead")
+	rec = do(http.MethodPut, "/tables/notes/rows/n1", `{"values": {"v": 2}, "timestamp": "`+stamp(now.Add(-60*24*time.Hour))+`"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "timestamps may not be far behind the server")
+
+	rec = do(http.MethodPatch, "/tables/notes/rows/n1", `{"values": {"w": 1}, "timestamp": "`+stamp(offline.Add(time.Minute))+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = do(http.MethodPut, "/tables/notes/rows/n1", `{"values": {"v": 3}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodDelete, "/tables/notes/rows/n1?timestamp="+url.QueryEscape(stamp(offline.Add(2*time.Minute))), "")
+	assert.Equal(t, http.StatusConflict, rec.Code, "the row changed after the delete was made")
+	rec = do(http.MethodDelete, "/tables/notes/rows/n1?timestamp="+url.QueryEscape(stamp(time.Now().UTC())), "")
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodGet, "/tables/notes/history?start=2000-01-01T00:00:00Z&end="+url.QueryEscape(stamp(time.Now().Add(time.Minute))), "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var history struct {
+		Events []RowEvent `json:"events"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &history))
+	var dated []bool
+	for _, e := range history.Events {
+		dated = append(dated, e.ClientDated)
+	}
+	assert.Equal(t, []bool{true, true, false, true}, dated, "history tells client-dated changes apart")
+
+	// Without a bound any past time is accepted
+	srv.config.MaxClockBehind = -1
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n2", "values": {"v": 1}, "timestamp": "2001-01-01T00:00:00Z"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/coldstore"
+)
+
+// handleOffloadHistory moves row history older than a cutoff into cold storage.
+// The last version of each row before the cutoff stays in DynamoDB so current
+// snapshots are unaffected; older versions are bundled into a segment and purged.
+func (s *Server) handleOffloadHistory(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	if s.cold == nil {
+		writeError(w, http.StatusNotImplemented, "Cold storage is not configured on this server")
+		return
+	}
+
+	table := r.PathValue("table")
+
+	var req struct {
+		Before    string `json:"before"`
+		OlderThan string `json:"olderThan"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	cutoff, err := parseCutoff(req.Before, req.OlderThan, time.Now().UTC())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Validate table exists
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+
+	rowStore, err := s.getRowStore(r.Context(), store, user, table)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize table storage")
+		return
+	}
+
+	facts, err = rowStore.QueryByTimeRange(r.Context(), time.Time{}, cutoff)
+	if err != nil {
+		writeStoreError(w, err, "Failed to query history")
+		return
+	}
+
+	key := tableNamespace(user.ID, table)
+	var tableFacts []dynamo.Fact
+	for _, f := range facts {
+		if f.Namespace == key {
+			tableFacts = append(tableFacts, f)
+		}
+	}
+
+	cold := coldstore.SelectColdFacts(tableFacts, cutoff)
+	seg, err := s.cold.Offload(r.Context(), key, cutoff, cold)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to offload history: %v", err))
+		return
+	}
+	if seg == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"offloaded": 0})
+		return
+	}
+
+	// The segment is published, so a failed purge only leaves duplicates that
+	// readers drop when merging hot and cold facts.
+	purged := 0
+	for _, f := range cold {
+		if err := rowStore.PurgeFact(r.Context(), f); err != nil {
+			s.logger.ErrorContext(r.Context(), "purging offloaded facts failed", "table", table, "purged", purged, "total", len(cold), "error", err)
+			writeStoreError(w, err, "Failed to purge offloaded facts")
+			return
+		}
+		purged++
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"offloaded": purged,
+		"segment":   seg,
+	})
+}
+
+// parseCutoff resolves either an absolute RFC3339 time or a duration before now
+func parseCutoff(before, olderThan string, now time.Time) (time.Time, error) {
+	switch {
+	case before != "":
+		t, err := time.Parse(time.RFC3339, before)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Invalid 'before' time format: %v (expected RFC3339)", err)
+		}
+		return t, nil
+	case olderThan != "":
+		d, err := time.ParseDuration(olderThan)
+		if err != nil || d <= 0 {
+			return time.Time{}, fmt.Errorf("'olderThan' must be a positive duration such as \"2160h\"")
+		}
+		return now.Add(-d), nil
+	default:
+		return time.Time{}, fmt.Errorf("Either 'before' or 'olderThan' is required")
+	}
+}
+
+// withColdSnapshot folds cold facts into a table's snapshot entries when the
+// requested time predates the namespace's cold boundary
+func (s *Server) withColdSnapshot(ctx context.Context, namespace string, at time.Time, entries map[string]dynamo.Fact) (map[string]dynamo.Fact, error) {
+	if s.cold == nil {
+		return entries, nil
+	}
+
+	idx, err := s.cold.LoadIndex(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if !idx.IsCold(at) {
+		return entries, nil
+	}
+
+	cold, err := s.cold.FactsInRange(ctx, namespace, time.Time{}, at)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]dynamo.Fact, len(entries))
+	for id, f := range entries {
+		merged[id] = f
+	}
+	for _, f := range cold {
+		if cur, ok := merged[f.FieldName]; !ok || f.Timestamp.After(cur.Timestamp) {
+			merged[f.FieldName] = f
+		}
+	}
+	return merged, nil
+}
+
+// withColdHistory merges cold facts into a history query result when the range reaches cold storage
+func (s *Server) withColdHistory(ctx context.Context, namespace string, start, end time.Time, facts []dynamo.Fact) ([]dynamo.Fact, error) {
+	if s.cold == nil {
+		return facts, nil
+	}
+
+	idx, err := s.cold.LoadIndex(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if !idx.IsCold(start) {
+		return facts, nil
+	}
+
+	cold, err := s.cold.FactsInRange(ctx, namespace, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return coldstore.Merge(facts, cold), nil
+}
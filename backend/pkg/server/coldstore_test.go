@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/pkg/blob"
+	"github.com/elibdev/notably/pkg/coldstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// indexReads counts the reads of cold indexes
+type indexReads struct {
+	blob.Store
+	n int
+}
+
+func (s *indexReads) Get(ctx context.Context, key string) ([]byte, error) {
+	if strings.HasSuffix(key, "/index.json") {
+		s.n++
+	}
+	return s.Store.Get(ctx, key)
+}
+
+func TestOffloadHistory(t *testing.T) {
+	srv, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes", "columns": [{"name": "v", "dataType": "number"}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/notes/offload", `{"olderThan": "1h"}`)
+	assert.Equal(t, http.StatusNotImplemented, rec.Code, "offloading needs a blob store")
+	blobs := &indexReads{Store: blob.NewFileStore(t.TempDir())}
+	srv.cold = coldstore.NewTier(blobs)
+
+	var versions []time.Time
+	for i, body := range []string{`{"id": "n1", "values": {"v": 1}}`, `{"values": {"v": 2}}`, `{"values": {"v": 3}}`} {
+		if i == 0 {
+			rec = do(http.MethodPost, "/tables/notes/rows", body)
+			require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		} else {
+			rec = do(http.MethodPut, "/tables/notes/rows/n1", body)
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		}
+		time.Sleep(time.Millisecond)
+		versions = append(versions, time.Now().UTC())
+	}
+
+	rec = do(http.MethodPost, "/tables/notes/offload", `{"before": "`+time.Now().Add(time.Minute).UTC().Format(time.RFC3339)+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var offload struct {
+		Offloaded int `json:"offloaded"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &offload))
+	assert.Equal(t, 2, offload.Offloaded, "the last version stays hot")
+
+	// Reads before the cutoff merge the offloaded versions back in
+	reads := blobs.n
+	asOf := func(at time.Time) string {
+		return "/tables/notes/snapshot?asOf=" + url.QueryEscape(at.Format(time.RFC3339Nano))
+	}
+	assert.Equal(t, map[string]float64{"n1": 1}, snapshotValues(t, do, asOf(versions[0])))
+	assert.Equal(t, map[string]float64{"n1": 2}, snapshotValues(t, do, asOf(versions[1])))
+	assert.Equal(t, map[string]float64{"n1": 3}, snapshotValues(t, do, "/tables/notes/snapshot"))
+
+	rec = do(http.MethodGet, "/tables/notes/history?start=2000-01-01T00:00:00Z&end="+url.QueryEscape(time.Now().UTC().Add(time.Minute).Format(time.RFC3339)), "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var history struct {
+		Events []RowEvent `json:"events"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &history))
+	var values []float64
+	for _, e := range history.Events {
+		if e.ID == "n1" {
+			values = append(values, e.Values["v"].(float64))
+		}
+	}
+	assert.Equal(t, []float64{1, 2, 3}, values)
+	assert.Equal(t, reads, blobs.n, "reads use the index cached by the offload")
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gzipMinSize is the smallest response worth compressing; shorter bodies are
+// sent as they are unless the handler flushes first
+const gzipMinSize = 1024
+
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
+// compress gzips responses for clients whose Accept-Encoding allows it.
+// Flushes reach the client, so streamed responses still arrive as they are
+// written. Upgraded connections and responses that set their own
+// Content-Encoding pass through untouched.
+func (s *Server) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.finish()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = v
+			}
+		}
+		return q > 0
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the start of a response until it is known to
+// be long enough to compress, then gzips the rest
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+	gz     *gzip.Writer
+	// plain is set once the response is sent uncompressed
+	plain bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.status == 0 {
+		g.status = status
+	}
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	switch {
+	case g.plain:
+		return g.ResponseWriter.Write(b)
+	case g.gz != nil:
+		return g.gz.Write(b)
+	}
+	g.buf.Write(b)
+	if g.buf.Len() >= gzipMinSize {
+		if err := g.begin(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// begin sends the headers and what has been held back, compressed or not.
+// Bodiless statuses and responses already encoded are never compressed.
+func (g *gzipResponseWriter) begin(compress bool) error {
+	h := g.Header()
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	if g.status < 200 || g.status == http.StatusNoContent || g.status == http.StatusNotModified || h.Get("Content-Encoding") != "" {
+		compress = false
+	}
+	if !compress {
+		g.plain = true
+		g.ResponseWriter.WriteHeader(g.status)
+		_, err := g.ResponseWriter.Write(g.buf.Bytes())
+		g.buf.Reset()
+		return err
+	}
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	g.ResponseWriter.WriteHeader(g.status)
+	g.gz = gzipWriters.Get().(*gzip.Writer)
+	g.gz.Reset(g.ResponseWriter)
+	_, err := g.gz.Write(g.buf.Bytes())
+	g.buf.Reset()
+	return err
+}
+
+// Flush sends everything written so far, starting compression if it has not
+// been decided yet
+func (g *gzipResponseWriter) Flush() {
+	if !g.plain && g.gz == nil {
+		if g.status == 0 && g.buf.Len() == 0 {
+			return
+		}
+		if g.begin(true) != nil {
+			return
+		}
+	}
+	if g.gz != nil {
+		_ = g.gz.Flush()
+	}
+	_ = http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+// finish ends the response: short bodies go out uncompressed, and the gzip
+// stream is closed
+func (g *gzipResponseWriter) finish() {
+	switch {
+	case g.gz != nil:
+		_ = g.gz.Close()
+		gzipWriters.Put(g.gz)
+	case !g.plain && g.status != 0:
+		_ = g.begin(false)
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
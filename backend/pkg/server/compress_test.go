@@ -0,0 +1,89 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	assert.True(t, acceptsGzip("gzip"))
+	assert.True(t, acceptsGzip("br, GZIP;q=0.5"))
+	assert.True(t, acceptsGzip("*"))
+	assert.False(t, acceptsGzip(""))
+	assert.False(t, acceptsGzip("br, deflate"))
+	assert.False(t, acceptsGzip("gzip;q=0"))
+}
+
+func TestStreamedRowsMatchWriteJSON(t *testing.T) {
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := make([]RowData, 1200)
+	for i := range rows {
+		rows[i] = RowData{ID: fmt.Sprintf("r%d", i), Timestamp: at, Values: map[string]interface{}{"n": i, "html": "<b>"}}
+	}
+	want := httptest.NewRecorder()
+	writeJSON(want, http.StatusOK, map[string]interface{}{"rows": rows})
+	got := httptest.NewRecorder()
+	writeRows(got, rows)
+	assert.Equal(t, want.Body.String(), got.Body.String())
+	assert.Equal(t, "application/json", got.Header().Get("Content-Type"))
+	assert.True(t, got.Flushed, "long results are flushed as they are written")
+
+	empty := httptest.NewRecorder()
+	writeRows(empty, nil)
+	assert.Equal(t, "{\"rows\":[]}\n", empty.Body.String())
+}
+
+func TestCompressResponses(t *testing.T) {
+	srv := &Server{}
+	rows := make([]RowData, 600)
+	for i := range rows {
+		rows[i] = RowData{ID: fmt.Sprintf("r%d", i), Values: map[string]interface{}{"n": i}}
+	}
+	handler := srv.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/small" {
+			writeError(w, http.StatusNotFound, "nothing here")
+			return
+		}
+		writeRows(w, rows)
+	}))
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept-Encoding", accept)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	plain := httptest.NewRecorder()
+	writeRows(plain, rows)
+
+	rec := get("/rows", "gzip, deflate")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+	assert.Less(t, rec.Body.Len(), plain.Body.Len())
+	zr, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, plain.Body.String(), string(body))
+
+	rec = get("/rows", "")
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, plain.Body.String(), rec.Body.String())
+
+	// Short responses are not worth compressing
+	rec = get("/small", "gzip")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.JSONEq(t, `{"error": "nothing here"}`, rec.Body.String())
+}
@@ -0,0 +1,520 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/migrate"
+	"github.com/elibdev/notably/pkg/naming"
+	"github.com/elibdev/notably/pkg/publish"
+)
+
+// Store drivers
+const (
+	storeDriverDynamo = "dynamodb"
+	storeDriverMemory = "memory"
+)
+
+// defaultCORSOrigins allows the frontend dev server when no origins are configured
+var defaultCORSOrigins = []string{"http://localhost:3000"}
+
+// corsOriginsFromEnv reads NOTABLY_CORS_ORIGINS, a comma-separated origin list
+func corsOriginsFromEnv() []string {
+	var origins []string
+	for _, o := range strings.Split(os.Getenv("NOTABLY_CORS_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+// tableOptionsFromEnv reads the billing and tuning of created tables from
+// the environment
+func tableOptionsFromEnv() dynamo.TableOptions {
+	opts := dynamo.TableOptions{
+		BillingMode:         os.Getenv("NOTABLY_TABLE_BILLING_MODE"),
+		ReadCapacity:        int64(envInt("NOTABLY_TABLE_READ_CAPACITY", 0)),
+		WriteCapacity:       int64(envInt("NOTABLY_TABLE_WRITE_CAPACITY", 0)),
+		PointInTimeRecovery: os.Getenv("NOTABLY_TABLE_PITR") == "true",
+		KMSKeyID:            os.Getenv("NOTABLY_TABLE_KMS_KEY"),
+	}
+	tags := os.Getenv("NOTABLY_TABLE_TAGS")
+	var err error
+	if opts.Tags, err = dynamo.ParseTags(tags); err != nil {
+		badEnv("NOTABLY_TABLE_TAGS", tags, "comma-separated key=value pairs")
+	}
+	scaling := dynamo.AutoScaling{
+		MaxReadCapacity:   int64(envInt("NOTABLY_TABLE_MAX_READ_CAPACITY", 0)),
+		MaxWriteCapacity:  int64(envInt("NOTABLY_TABLE_MAX_WRITE_CAPACITY", 0)),
+		TargetUtilization: envFloat("NOTABLY_TABLE_TARGET_UTILIZATION", 0),
+	}
+	if scaling.MaxReadCapacity > 0 || scaling.MaxWriteCapacity > 0 {
+		opts.AutoScaling = &scaling
+	}
+	return opts
+}
+
+// fileConfig is the layout of a configuration file. Every setting is
+// optional; unset settings keep their defaults.
+type fileConfig struct {
+	Addr string `yaml:"addr"`
+	CORS struct {
+		Origins []string `yaml:"origins"`
+		Debug   *bool    `yaml:"debug"`
+	} `yaml:"cors"`
+	Store struct {
+		Driver      string `yaml:"driver"`
+		Table       string `yaml:"table"`
+		LegacyTable string `yaml:"legacyTable"`
+		Migration   struct {
+			Table string `yaml:"table"`
+			Phase string `yaml:"phase"`
+		} `yaml:"migration"`
+		Endpoint      string        `yaml:"endpoint"`
+		Mode          string        `yaml:"mode"`
+		SlowQuery     time.Duration `yaml:"slowQueryThreshold"`
+		WriteCapacity *int          `yaml:"writeCapacity"`
+		Billing       struct {
+			Mode              string  `yaml:"mode"`
+			ReadCapacity      *int    `yaml:"readCapacity"`
+			WriteCapacity     *int    `yaml:"writeCapacity"`
+			MaxReadCapacity   *int    `yaml:"maxReadCapacity"`
+			MaxWriteCapacity  *int    `yaml:"maxWriteCapacity"`
+			TargetUtilization float64 `yaml:"targetUtilization"`
+		} `yaml:"billing"`
+		PointInTimeRecovery *bool             `yaml:"pointInTimeRecovery"`
+		KMSKey              string            `yaml:"kmsKey"`
+		Tags                map[string]string `yaml:"tags"`
+		Region              string            `yaml:"region"`
+		ReplicaRegions      []string          `yaml:"replicaRegions"`
+		MaxReplicationLag   time.Duration     `yaml:"maxReplicationLag"`
+	} `yaml:"store"`
+	Log struct {
+		Level  string `yaml:"level"`
+		Format string `yaml:"format"`
+	} `yaml:"log"`
+	RateLimit struct {
+		Read           *int `yaml:"read"`
+		Write          *int `yaml:"write"`
+		Burst          *int `yaml:"burst"`
+		UserMultiplier *int `yaml:"userMultiplier"`
+	} `yaml:"rateLimit"`
+	APIKeys struct {
+		Expiration    time.Duration `yaml:"expiration"`
+		Secret        string        `yaml:"secret"`
+		RotationGrace time.Duration `yaml:"rotationGrace"`
+	} `yaml:"apiKeys"`
+	Login struct {
+		MaxFailures   *int          `yaml:"maxFailures"`
+		IPMaxFailures *int          `yaml:"ipMaxFailures"`
+		LockDuration  time.Duration `yaml:"lockDuration"`
+		BaseDelay     time.Duration `yaml:"baseDelay"`
+		BcryptCost    *int          `yaml:"bcryptCost"`
+	} `yaml:"login"`
+	Users struct {
+		File string `yaml:"file"`
+	} `yaml:"users"`
+	Mail struct {
+		From         string `yaml:"from"`
+		SMTPAddr     string `yaml:"smtpAddr"`
+		SMTPUsername string `yaml:"smtpUsername"`
+		SMTPPassword string `yaml:"smtpPassword"`
+		SES          *bool  `yaml:"ses"`
+		ResetURL     string `yaml:"resetURL"`
+		VerifyURL    string `yaml:"verifyURL"`
+		VerifyEmail  *bool  `yaml:"verifyEmail"`
+	} `yaml:"mail"`
+	Publish struct {
+		KafkaURL    string        `yaml:"kafkaURL"`
+		SNS         *bool         `yaml:"sns"`
+		Topic       string        `yaml:"topic"`
+		Topics      []string      `yaml:"topics"`
+		MaxAttempts *int          `yaml:"maxAttempts"`
+		MaxElapsed  time.Duration `yaml:"maxElapsed"`
+	} `yaml:"publish"`
+	Realtime struct {
+		Redis struct {
+			Addr     string `yaml:"addr"`
+			Password string `yaml:"password"`
+		} `yaml:"redis"`
+	} `yaml:"realtime"`
+	Tracing struct {
+		Endpoint    string            `yaml:"endpoint"`
+		Headers     map[string]string `yaml:"headers"`
+		ServiceName string            `yaml:"serviceName"`
+		SampleRatio *float64          `yaml:"sampleRatio"`
+	} `yaml:"tracing"`
+	Timeouts struct {
+		Request time.Duration            `yaml:"request"`
+		Routes  map[string]time.Duration `yaml:"routes"`
+	} `yaml:"timeouts"`
+	Clock struct {
+		MaxAhead  time.Duration `yaml:"maxAhead"`
+		MaxBehind time.Duration `yaml:"maxBehind"`
+	} `yaml:"clock"`
+	Naming struct {
+		MinLength        *int     `yaml:"minLength"`
+		MaxLength        *int     `yaml:"maxLength"`
+		ReservedPrefixes []string `yaml:"reservedPrefixes"`
+		Case             string   `yaml:"case"`
+	} `yaml:"naming"`
+	TLS struct {
+		Cert             string   `yaml:"cert"`
+		Key              string   `yaml:"key"`
+		AutocertDomains  []string `yaml:"autocertDomains"`
+		AutocertCacheDir string   `yaml:"autocertCacheDir"`
+		AutocertEmail    string   `yaml:"autocertEmail"`
+		RedirectAddr     string   `yaml:"redirectAddr"`
+	} `yaml:"tls"`
+	Frontend struct {
+		Embedded *bool  `yaml:"embedded"`
+		Dir      string `yaml:"dir"`
+	} `yaml:"frontend"`
+	UI *bool `yaml:"ui"`
+}
+
+// LoadConfig returns the server configuration from the YAML file at path,
+// if any, with environment variables taking precedence over the file and
+// defaults filling in the rest. The result is validated.
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+	driver := os.Getenv("NOTABLY_STORE_DRIVER")
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("read config: %w", err)
+		}
+		var file fileConfig
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+			return Config{}, fmt.Errorf("parse config %s: %w", path, err)
+		}
+		file.apply(&config)
+		if driver == "" {
+			driver = file.Store.Driver
+		}
+	}
+	switch driver {
+	case "", storeDriverDynamo:
+	case storeDriverMemory:
+		config.InMemory = true
+		if config.TableName == "" {
+			config.TableName = "notably"
+		}
+	default:
+		return Config{}, fmt.Errorf("invalid config: store driver must be %q or %q, got %q", storeDriverDynamo, storeDriverMemory, driver)
+	}
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// apply copies the settings of the file into config, skipping those whose
+// environment variable is set
+func (f *fileConfig) apply(config *Config) {
+	str := func(env, v string, dst *string) {
+		if _, ok := os.LookupEnv(env); !ok && v != "" {
+			*dst = v
+		}
+	}
+	num := func(env string, v *int, dst *int) {
+		if _, ok := os.LookupEnv(env); !ok && v != nil {
+			*dst = *v
+		}
+	}
+	dur := func(env string, v time.Duration, dst *time.Duration) {
+		if _, ok := os.LookupEnv(env); !ok && v != 0 {
+			*dst = v
+		}
+	}
+	flag := func(env string, v *bool, dst *bool) {
+		if _, ok := os.LookupEnv(env); !ok && v != nil {
+			*dst = *v
+		}
+	}
+	if f.Addr != "" {
+		config.Addr = f.Addr
+	}
+	if _, ok := os.LookupEnv("NOTABLY_CORS_ORIGINS"); !ok && f.CORS.Origins != nil {
+		config.CORSOrigins = f.CORS.Origins
+	}
+	flag("NOTABLY_CORS_DEBUG", f.CORS.Debug, &config.CORSDebug)
+	str("DYNAMODB_TABLE_NAME", f.Store.Table, &config.TableName)
+	str("DYNAMODB_LEGACY_TABLE_NAME", f.Store.LegacyTable, &config.LegacyTableName)
+	str("DYNAMODB_MIGRATION_TABLE_NAME", f.Store.Migration.Table, &config.MigrationTableName)
+	str("NOTABLY_MIGRATION_PHASE", f.Store.Migration.Phase, &config.MigrationPhase)
+	str("DYNAMODB_ENDPOINT_URL", f.Store.Endpoint, &config.DynamoEndpoint)
+	str("NOTABLY_STORAGE_MODE", f.Store.Mode, &config.StorageMode)
+	dur("NOTABLY_SLOW_QUERY_THRESHOLD", f.Store.SlowQuery, &config.SlowQueryThreshold)
+	num("NOTABLY_WRITE_CAPACITY", f.Store.WriteCapacity, &config.WriteCapacity)
+	f.applyTable(&config.Table)
+	str("NOTABLY_REGION", f.Store.Region, &config.Region)
+	if _, ok := os.LookupEnv("NOTABLY_REPLICA_REGIONS"); !ok && f.Store.ReplicaRegions != nil {
+		config.ReplicaRegions = f.Store.ReplicaRegions
+	}
+	dur("NOTABLY_MAX_REPLICATION_LAG", f.Store.MaxReplicationLag, &config.MaxReplicationLag)
+	str("NOTABLY_LOG_LEVEL", f.Log.Level, &config.LogLevel)
+	str("NOTABLY_LOG_FORMAT", f.Log.Format, &config.LogFormat)
+	num("NOTABLY_RATE_LIMIT_READ", f.RateLimit.Read, &config.RateLimit.ReadPerMinute)
+	num("NOTABLY_RATE_LIMIT_WRITE", f.RateLimit.Write, &config.RateLimit.WritePerMinute)
+	num("NOTABLY_RATE_LIMIT_BURST", f.RateLimit.Burst, &config.RateLimit.Burst)
+	num("NOTABLY_RATE_LIMIT_USER_MULTIPLIER", f.RateLimit.UserMultiplier, &config.RateLimit.UserMultiplier)
+	dur("NOTABLY_API_KEY_EXPIRATION", f.APIKeys.Expiration, &config.APIKeyExpiration)
+	str("NOTABLY_API_KEY_SECRET", f.APIKeys.Secret, &config.APIKeySecret)
+	dur("NOTABLY_KEY_ROTATION_GRACE", f.APIKeys.RotationGrace, &config.KeyRotationGrace)
+	num("NOTABLY_BCRYPT_COST", f.Login.BcryptCost, &config.BcryptCost)
+	str("NOTABLY_USERS_FILE", f.Users.File, &config.UsersFile)
+	dur("NOTABLY_REQUEST_TIMEOUT", f.Timeouts.Request, &config.RequestTimeout)
+	if _, ok := os.LookupEnv("NOTABLY_ROUTE_TIMEOUTS"); !ok && f.Timeouts.Routes != nil {
+		config.RouteTimeouts = f.Timeouts.Routes
+	}
+	dur("NOTABLY_MAX_CLOCK_AHEAD", f.Clock.MaxAhead, &config.MaxClockAhead)
+	dur("NOTABLY_MAX_CLOCK_BEHIND", f.Clock.MaxBehind, &config.MaxClockBehind)
+	num("NOTABLY_NAME_MIN_LENGTH", f.Naming.MinLength, &config.Naming.MinLength)
+	num("NOTABLY_NAME_MAX_LENGTH", f.Naming.MaxLength, &config.Naming.MaxLength)
+	if _, ok := os.LookupEnv("NOTABLY_NAME_RESERVED_PREFIXES"); !ok && f.Naming.ReservedPrefixes != nil {
+		config.Naming.ReservedPrefixes = f.Naming.ReservedPrefixes
+	}
+	if _, ok := os.LookupEnv("NOTABLY_NAME_CASE"); !ok && f.Naming.Case != "" {
+		config.Naming.Case = naming.Case(f.Naming.Case)
+	}
+	num("NOTABLY_LOGIN_MAX_FAILURES", f.Login.MaxFailures, &config.Lockout.MaxFailures)
+	num("NOTABLY_LOGIN_IP_MAX_FAILURES", f.Login.IPMaxFailures, &config.Lockout.IPMaxFailures)
+	dur("NOTABLY_LOGIN_LOCK_DURATION", f.Login.LockDuration, &config.Lockout.LockDuration)
+	dur("NOTABLY_LOGIN_BASE_DELAY", f.Login.BaseDelay, &config.Lockout.BaseDelay)
+	str("NOTABLY_MAIL_FROM", f.Mail.From, &config.Mail.From)
+	str("NOTABLY_SMTP_ADDR", f.Mail.SMTPAddr, &config.Mail.SMTPAddr)
+	str("NOTABLY_SMTP_USERNAME", f.Mail.SMTPUsername, &config.Mail.SMTPUsername)
+	str("NOTABLY_SMTP_PASSWORD", f.Mail.SMTPPassword, &config.Mail.SMTPPassword)
+	str("NOTABLY_PASSWORD_RESET_URL", f.Mail.ResetURL, &config.Mail.ResetURL)
+	str("NOTABLY_VERIFY_EMAIL_URL", f.Mail.VerifyURL, &config.Mail.VerifyURL)
+	flag("NOTABLY_MAIL_SES", f.Mail.SES, &config.Mail.SES)
+	flag("NOTABLY_VERIFY_EMAIL", f.Mail.VerifyEmail, &config.Mail.VerifyEmail)
+	str("NOTABLY_PUBLISH_KAFKA_URL", f.Publish.KafkaURL, &config.Publish.KafkaURL)
+	flag("NOTABLY_PUBLISH_SNS", f.Publish.SNS, &config.Publish.SNS)
+	str("NOTABLY_PUBLISH_TOPIC", f.Publish.Topic, &config.Publish.Topic)
+	if _, ok := os.LookupEnv("NOTABLY_PUBLISH_TOPICS"); !ok && f.Publish.Topics != nil {
+		config.Publish.Topics = f.Publish.Topics
+	}
+	num("NOTABLY_PUBLISH_MAX_ATTEMPTS", f.Publish.MaxAttempts, &config.Publish.Policy.MaxAttempts)
+	dur("NOTABLY_PUBLISH_MAX_ELAPSED", f.Publish.MaxElapsed, &config.Publish.Policy.MaxElapsed)
+	str("NOTABLY_REDIS_ADDR", f.Realtime.Redis.Addr, &config.Realtime.RedisAddr)
+	str("NOTABLY_REDIS_PASSWORD", f.Realtime.Redis.Password, &config.Realtime.RedisPassword)
+	str("OTEL_EXPORTER_OTLP_ENDPOINT", f.Tracing.Endpoint, &config.Tracing.Endpoint)
+	if _, ok := os.LookupEnv("OTEL_EXPORTER_OTLP_HEADERS"); !ok && f.Tracing.Headers != nil {
+		config.Tracing.Headers = f.Tracing.Headers
+	}
+	str("OTEL_SERVICE_NAME", f.Tracing.ServiceName, &config.Tracing.ServiceName)
+	if _, ok := os.LookupEnv("OTEL_TRACES_SAMPLER_ARG"); !ok && f.Tracing.SampleRatio != nil {
+		config.Tracing.SampleRatio = *f.Tracing.SampleRatio
+	}
+	str("NOTABLY_TLS_CERT", f.TLS.Cert, &config.TLS.CertFile)
+	str("NOTABLY_TLS_KEY", f.TLS.Key, &config.TLS.KeyFile)
+	if _, ok := os.LookupEnv("NOTABLY_AUTOCERT_DOMAINS"); !ok && f.TLS.AutocertDomains != nil {
+		config.TLS.AutocertDomains = f.TLS.AutocertDomains
+	}
+	str("NOTABLY_AUTOCERT_CACHE_DIR", f.TLS.AutocertCacheDir, &config.TLS.AutocertCacheDir)
+	str("NOTABLY_AUTOCERT_EMAIL", f.TLS.AutocertEmail, &config.TLS.AutocertEmail)
+	str("NOTABLY_HTTP_REDIRECT_ADDR", f.TLS.RedirectAddr, &config.TLS.RedirectAddr)
+	flag("NOTABLY_FRONTEND_EMBEDDED", f.Frontend.Embedded, &config.Frontend.Embedded)
+	str("NOTABLY_FRONTEND_DIR", f.Frontend.Dir, &config.Frontend.Dir)
+	flag("NOTABLY_UI", f.UI, &config.UI)
+}
+
+// applyTable copies the table settings of the file into opts, skipping
+// those whose environment variable is set
+func (f *fileConfig) applyTable(opts *dynamo.TableOptions) {
+	unset := func(env string) bool {
+		_, ok := os.LookupEnv(env)
+		return !ok
+	}
+	units := func(env string, v *int, dst *int64) {
+		if unset(env) && v != nil {
+			*dst = int64(*v)
+		}
+	}
+	b := f.Store.Billing
+	if unset("NOTABLY_TABLE_BILLING_MODE") && b.Mode != "" {
+		opts.BillingMode = b.Mode
+	}
+	units("NOTABLY_TABLE_READ_CAPACITY", b.ReadCapacity, &opts.ReadCapacity)
+	units("NOTABLY_TABLE_WRITE_CAPACITY", b.WriteCapacity, &opts.WriteCapacity)
+
+	var scaling dynamo.AutoScaling
+	if opts.AutoScaling != nil {
+		scaling = *opts.AutoScaling
+	}
+	units("NOTABLY_TABLE_MAX_READ_CAPACITY", b.MaxReadCapacity, &scaling.MaxReadCapacity)
+	units("NOTABLY_TABLE_MAX_WRITE_CAPACITY", b.MaxWriteCapacity, &scaling.MaxWriteCapacity)
+	if unset("NOTABLY_TABLE_TARGET_UTILIZATION") && b.TargetUtilization != 0 {
+		scaling.TargetUtilization = b.TargetUtilization
+	}
+	if scaling.MaxReadCapacity > 0 || scaling.MaxWriteCapacity > 0 {
+		opts.AutoScaling = &scaling
+	}
+
+	if unset("NOTABLY_TABLE_PITR") && f.Store.PointInTimeRecovery != nil {
+		opts.PointInTimeRecovery = *f.Store.PointInTimeRecovery
+	}
+	if unset("NOTABLY_TABLE_KMS_KEY") && f.Store.KMSKey != "" {
+		opts.KMSKeyID = f.Store.KMSKey
+	}
+	if unset("NOTABLY_TABLE_TAGS") && f.Store.Tags != nil {
+		opts.Tags = f.Store.Tags
+	}
+}
+
+// Validate reports every invalid setting of the configuration
+func (c Config) Validate() error {
+	var errs []error
+	bad := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	errs = append(errs, c.envErrors...)
+	if c.Addr == "" {
+		bad("addr is required")
+	}
+	if !c.InMemory && c.TableName == "" {
+		bad("store.table (DYNAMODB_TABLE_NAME) is required for the %s driver", storeDriverDynamo)
+	}
+	if c.LegacyTableName != "" && c.LegacyTableName == c.TableName {
+		bad("store.legacyTable (DYNAMODB_LEGACY_TABLE_NAME) must differ from store.table")
+	}
+	if c.MigrationTableName != "" && c.MigrationTableName == c.TableName {
+		bad("store.migration.table (DYNAMODB_MIGRATION_TABLE_NAME) must differ from store.table")
+	}
+	if _, err := migrate.ParsePhase(c.MigrationPhase); err != nil {
+		bad("store.migration.phase (NOTABLY_MIGRATION_PHASE) must be %q or %q, got %q", migrate.PhaseDualWrite, migrate.PhaseDualRead, c.MigrationPhase)
+	}
+	if _, err := db.NewTableResolver(c.StorageMode, c.TableName); err != nil {
+		bad("store.mode must be %q or %q, got %q", db.StorageModeShared, db.StorageModeIsolated, c.StorageMode)
+	}
+	if c.SlowQueryThreshold < 0 {
+		bad("store.slowQueryThreshold must not be negative")
+	}
+	if c.MaxAttachmentBytes < 0 || c.AttachmentQuotaBytes < 0 {
+		bad("attachment size limits must not be negative")
+	}
+	if c.WriteCapacity < 0 {
+		bad("store.writeCapacity must not be negative")
+	}
+	if c.MaxClockAhead < 0 {
+		bad("clock.maxAhead (NOTABLY_MAX_CLOCK_AHEAD) must not be negative")
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		bad("tracing.sampleRatio (OTEL_TRACES_SAMPLER_ARG) must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+	}
+	if err := c.Table.Validate(); err != nil {
+		bad("store.billing: %v", err)
+	}
+	for _, region := range c.ReplicaRegions {
+		if region == c.Region {
+			bad("store.replicaRegions must not include store.region %q", region)
+		}
+	}
+	if len(c.ReplicaRegions) > 0 && c.Region == "" {
+		bad("store.region (NOTABLY_REGION) is required with replica regions")
+	}
+	if len(c.ReplicaRegions) > 0 && c.MaxReplicationLag <= 0 {
+		bad("store.maxReplicationLag must be positive with replica regions")
+	}
+	switch strings.ToLower(c.LogLevel) {
+	case "", "debug", "info", "warn", "warning", "error":
+	default:
+		bad("log.level must be debug, info, warn or error, got %q", c.LogLevel)
+	}
+	switch strings.ToLower(c.LogFormat) {
+	case "", "text", "json":
+	default:
+		bad("log.format must be text or json, got %q", c.LogFormat)
+	}
+	for _, origin := range c.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			bad("cors.origins: %q is not an origin such as https://app.example.com", origin)
+		}
+	}
+	rl := c.RateLimit
+	if rl.ReadPerMinute < 0 || rl.WritePerMinute < 0 || rl.Burst < 0 || rl.UserMultiplier < 0 {
+		bad("rateLimit settings must not be negative")
+	}
+	lo := c.Lockout
+	if lo.MaxFailures < 0 || lo.IPMaxFailures < 0 || lo.LockDuration < 0 || lo.BaseDelay < 0 {
+		bad("login settings must not be negative")
+	}
+	if c.BcryptCost != 0 && (c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost) {
+		bad("login.bcryptCost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	for pattern := range c.RouteTimeouts {
+		if method, path, ok := strings.Cut(pattern, " "); !ok || method == "" || !strings.HasPrefix(path, "/") {
+			bad("timeouts.routes: %q is not a route pattern such as \"GET /tables/{table}/rows\"", pattern)
+		}
+	}
+	if err := c.Naming.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.APIKeyExpiration < 0 {
+		bad("apiKeys.expiration must not be negative")
+	}
+	if c.KeyRotationGrace < 0 {
+		bad("apiKeys.rotationGrace must not be negative")
+	}
+	if c.Mail.VerifyEmail && c.Mailer == nil && c.Mail.SMTPAddr == "" && !c.Mail.SES {
+		bad("mail.verifyEmail needs mail.smtpAddr or mail.ses")
+	}
+	if (c.Mail.SMTPAddr != "" || c.Mail.SES) && c.Mail.From == "" {
+		bad("mail.from is required to send email")
+	}
+	if err := c.TLS.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Frontend.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	pc := c.Publish
+	if pc.KafkaURL != "" && pc.SNS {
+		bad("publish.kafkaURL and publish.sns are exclusive")
+	}
+	if pc.KafkaURL != "" {
+		if u, err := url.Parse(pc.KafkaURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			bad("publish.kafkaURL must be an absolute http or https URL")
+		}
+	}
+	if c.Publisher == nil && pc.KafkaURL == "" && !pc.SNS && (pc.Topic != "" || len(pc.Topics) > 0) {
+		bad("publish topics need publish.kafkaURL or publish.sns")
+	}
+	if pc.SNS {
+		for _, topic := range append([]string{pc.Topic}, pc.Topics...) {
+			if topic != "" && publish.TopicRegion(topic) == "" {
+				bad("publish: %q is not an SNS topic ARN", topic)
+			}
+		}
+	}
+	if pc.Policy.MaxAttempts < 0 || pc.Policy.MaxElapsed < 0 {
+		bad("publish retry settings must not be negative")
+	}
+	if c.MaxBodyBytes < 0 {
+		bad("maxBodyBytes must not be negative")
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/naming"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notably.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	for _, name := range []string{"DYNAMODB_TABLE_NAME", "DYNAMODB_LEGACY_TABLE_NAME", "DYNAMODB_MIGRATION_TABLE_NAME", "NOTABLY_MIGRATION_PHASE", "NOTABLY_STORE_DRIVER", "NOTABLY_CORS_ORIGINS", "NOTABLY_RATE_LIMIT_READ", "NOTABLY_API_KEY_EXPIRATION", "NOTABLY_TABLE_BILLING_MODE", "NOTABLY_TABLE_WRITE_CAPACITY", "NOTABLY_TABLE_MAX_WRITE_CAPACITY", "NOTABLY_TABLE_PITR", "NOTABLY_TABLE_TAGS", "NOTABLY_REGION", "NOTABLY_REPLICA_REGIONS", "NOTABLY_MAX_REPLICATION_LAG", "NOTABLY_NAME_MAX_LENGTH", "NOTABLY_NAME_RESERVED_PREFIXES", "NOTABLY_NAME_CASE", "NOTABLY_REQUEST_TIMEOUT", "NOTABLY_ROUTE_TIMEOUTS", "NOTABLY_FRONTEND_EMBEDDED", "NOTABLY_FRONTEND_DIR", "NOTABLY_UI", "NOTABLY_REDIS_ADDR", "NOTABLY_REDIS_PASSWORD", "OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_HEADERS", "OTEL_SERVICE_NAME", "OTEL_TRACES_SAMPLER_ARG"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	t.Setenv("NOTABLY_LOG_LEVEL", "debug")
+
+	path := writeConfigFile(t, `
+addr: ":9090"
+cors:
+  origins: ["https://app.example.com"]
+store:
+  table: Facts
+  legacyTable: OldFacts
+  migration: {table: NewFacts, phase: dual-read}
+  mode: isolated
+  slowQueryThreshold: 250ms
+  writeCapacity: 50
+  billing:
+    mode: provisioned
+    writeCapacity: 20
+    maxWriteCapacity: 200
+  pointInTimeRecovery: true
+  tags: {team: notes}
+  region: us-east-1
+  replicaRegions: [eu-west-1]
+log:
+  level: warn
+  format: json
+rateLimit:
+  read: 600
+  userMultiplier: 2
+apiKeys:
+  expiration: 720h
+users:
+  file: /var/lib/notably/users.json
+naming:
+  maxLength: 32
+  reservedPrefixes: []
+  case: lower
+timeouts:
+  request: 10s
+  routes: {"POST /tables/{table}/archive": 5m}
+clock:
+  maxBehind: -1s
+realtime:
+  redis: {addr: "redis:6379", password: hunter2}
+tracing:
+  endpoint: http://otel-collector:4318
+  headers: {api-key: secret}
+  serviceName: notably-api
+  sampleRatio: 0.25
+frontend:
+  dir: /srv/notably/web
+ui: true
+`)
+	config, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, ":9090", config.Addr)
+	assert.Equal(t, []string{"https://app.example.com"}, config.CORSOrigins)
+	assert.False(t, config.CORSDebug)
+	assert.Equal(t, "Facts", config.TableName)
+	assert.Equal(t, "OldFacts", config.LegacyTableName)
+	assert.Equal(t, "NewFacts", config.MigrationTableName)
+	assert.Equal(t, "dual-read", config.MigrationPhase)
+	assert.Equal(t, "isolated", config.StorageMode)
+	assert.Equal(t, 250*time.Millisecond, config.SlowQueryThreshold)
+	assert.Equal(t, 50, config.WriteCapacity)
+	assert.Equal(t, dynamo.BillingProvisioned, config.Table.BillingMode)
+	assert.Equal(t, int64(20), config.Table.WriteCapacity)
+	require.NotNil(t, config.Table.AutoScaling)
+	assert.Equal(t, int64(200), config.Table.AutoScaling.MaxWriteCapacity)
+	assert.True(t, config.Table.PointInTimeRecovery)
+	assert.Equal(t, map[string]string{"team": "notes"}, config.Table.Tags)
+	assert.Equal(t, "us-east-1", config.Region)
+	assert.Equal(t, []string{"eu-west-1"}, config.ReplicaRegions)
+	assert.Equal(t, defaultMaxReplicationLag, config.MaxReplicationLag)
+	assert.Equal(t, "debug", config.LogLevel, "the environment wins over the file")
+	assert.Equal(t, "json", config.LogFormat)
+	assert.Equal(t, 600, config.RateLimit.ReadPerMinute)
+	assert.Equal(t, 2, config.RateLimit.UserMultiplier)
+	assert.Equal(t, 720*time.Hour, config.APIKeyExpiration)
+	assert.Equal(t, "/var/lib/notably/users.json", config.UsersFile)
+	assert.Equal(t, naming.Policy{MaxLength: 32, ReservedPrefixes: []string{}, Case: naming.CaseLower}, config.Naming)
+	assert.Equal(t, 10*time.Second, config.RequestTimeout)
+	assert.Equal(t, map[string]time.Duration{"POST /tables/{table}/archive": 5 * time.Minute}, config.RouteTimeouts)
+	assert.Equal(t, -time.Second, config.MaxClockBehind)
+	assert.Equal(t, RealtimeConfig{RedisAddr: "redis:6379", RedisPassword: "hunter2"}, config.Realtime)
+	assert.Equal(t, TracingConfig{Endpoint: "http://otel-collector:4318", Headers: map[string]string{"api-key": "secret"}, ServiceName: "notably-api", SampleRatio: 0.25}, config.Tracing)
+	assert.Equal(t, FrontendConfig{Dir: "/srv/notably/web"}, config.Frontend)
+	assert.True(t, config.UI)
+	assert.False(t, config.InMemory)
+
+	config, err = LoadConfig(writeConfigFile(t, "store:\n  driver: memory\n"))
+	require.NoError(t, err)
+	assert.True(t, config.InMemory)
+	assert.NotEmpty(t, config.TableName)
+
+	_, err = LoadConfig("")
+	assert.ErrorContains(t, err, "store.table", "the dynamodb driver needs a table")
+
+	_, err = LoadConfig(writeConfigFile(t, "stor:\n  table: Facts\n"))
+	assert.ErrorContains(t, err, "field stor not found", "unknown settings are rejected")
+
+	_, err = LoadConfig(writeConfigFile(t, `
+store: {table: Facts, legacyTable: Facts, migration: {phase: cutover}, mode: sharded, billing: {mode: reserved}, replicaRegions: [us-east-1]}
+log: {format: xml}
+cors: {origins: ["app.example.com"]}
+rateLimit: {write: -1}
+publish: {kafkaURL: "localhost:8082", sns: true}
+naming: {minLength: 10, maxLength: 5}
+timeouts: {routes: {"/tables": 1s}}
+clock: {maxAhead: -1m}
+frontend: {embedded: true, dir: dist}
+tracing: {sampleRatio: 2}
+`))
+	require.Error(t, err)
+	for _, msg := range []string{"store.mode", "store.legacyTable", "store.migration.phase", "log.format", "cors.origins", "rateLimit", "store.billing", "store.region", "publish.kafkaURL", "naming: lengths", "timeouts.routes", "clock.maxAhead", "frontend.embedded", "tracing.sampleRatio"} {
+		assert.ErrorContains(t, err, msg)
+	}
+}
+
+func TestLoadConfigEnvErrors(t *testing.T) {
+	t.Setenv("NOTABLY_STORE_DRIVER", "memory")
+	t.Setenv("NOTABLY_REQUEST_TIMEOUT", "30")
+	t.Setenv("NOTABLY_LOGIN_MAX_FAILURES", "abc")
+	t.Setenv("NOTABLY_TABLE_TARGET_UTILIZATION", "high")
+	t.Setenv("NOTABLY_TABLE_TAGS", "team")
+	_, err := LoadConfig("")
+	require.Error(t, err, "malformed overrides do not fall back to defaults")
+	for _, name := range []string{"NOTABLY_REQUEST_TIMEOUT", "NOTABLY_LOGIN_MAX_FAILURES", "NOTABLY_TABLE_TARGET_UTILIZATION", "NOTABLY_TABLE_TAGS"} {
+		assert.ErrorContains(t, err, name)
+	}
+
+	t.Setenv("NOTABLY_REQUEST_TIMEOUT", "30s")
+	t.Setenv("NOTABLY_LOGIN_MAX_FAILURES", "")
+	t.Setenv("NOTABLY_TABLE_TARGET_UTILIZATION", "")
+	t.Setenv("NOTABLY_TABLE_TAGS", "")
+	config, err := LoadConfig("")
+	require.NoError(t, err, "empty variables keep their defaults")
+	assert.Equal(t, 30*time.Second, config.RequestTimeout)
+	assert.NoError(t, Config{Addr: ":8080", InMemory: true, TableName: "notably"}.Validate(), "only configs read from the environment report it")
+}
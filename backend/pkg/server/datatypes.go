@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// DataType describes a column data type and the values it accepts
+type DataType struct {
+	Name string `json:"name"`
+	// Values tells which JSON values the type accepts
+	Values string `json:"values"`
+	// Parameters are the column definition fields the type uses
+	Parameters []string `json:"parameters,omitempty"`
+	// Plugin names the plugin defining the type; empty for built-in types
+	Plugin string `json:"plugin,omitempty"`
+}
+
+// builtinDataTypes is the registry of built-in column data types. Values of
+// each are checked by validateValueType, and by checkTypedValue for types
+// with parameters.
+var builtinDataTypes = []DataType{
+	{Name: "string", Values: "any string"},
+	{Name: "number", Values: "any number"},
+	{Name: "boolean", Values: "true or false"},
+	{Name: "datetime", Values: "a string holding an RFC 3339 time such as \"2024-01-02T15:04:05Z\""},
+	{Name: "date", Values: "a string holding a date such as \"2024-01-02\""},
+	{Name: "object", Values: "any object"},
+	{Name: "json", Values: "any object"},
+	{Name: "array", Values: "any array"},
+	{Name: geopointType, Values: "an object with only a \"lat\" between -90 and 90 and a \"lng\" between -180 and 180"},
+	{Name: enumType, Values: "one of the strings listed in enum", Parameters: []string{"enum"}},
+	{Name: referenceType, Values: "the ID of a current row of the referenced table", Parameters: []string{"references", "onDelete"}},
+	{Name: decimalType, Values: "a string holding a decimal number such as \"12.34\", within the precision and scale", Parameters: []string{"precision", "scale"}},
+	{Name: uuidType, Values: "a string holding a UUID in the 8-4-4-4-12 hex form"},
+	{Name: attachmentType, Values: "an object with a \"key\" and \"name\" of an uploaded attachment"},
+}
+
+// builtinColumnTypes are the names of the built-in column data types, which
+// plugins may not redefine
+var builtinColumnTypes = func() []string {
+	names := make([]string, len(builtinDataTypes))
+	for i, dt := range builtinDataTypes {
+		names[i] = dt.Name
+	}
+	return names
+}()
+
+// dataTypes returns the built-in data types followed by those of plugins
+func (s *Server) dataTypes() []DataType {
+	types := append([]DataType(nil), builtinDataTypes...)
+	for _, ct := range s.plugins.ColumnTypes() {
+		values := ct.Description
+		if values == "" {
+			values = "values accepted by the plugin"
+		}
+		types = append(types, DataType{Name: ct.Name, Values: values, Plugin: ct.Plugin})
+	}
+	return types
+}
+
+// checkDataType reports a column data type outside the registry
+func (s *Server) checkDataType(col string, dataType string) error {
+	for _, name := range builtinColumnTypes {
+		if name == dataType {
+			return nil
+		}
+	}
+	if _, ok := s.plugins.ColumnType(dataType); ok {
+		return nil
+	}
+	return fmt.Errorf("Column '%s' has unknown data type '%s'; GET /datatypes lists the supported types", col, dataType)
+}
+
+// handleListDataTypes lists the column data types tables may use
+func (s *Server) handleListDataTypes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"dataTypes": s.dataTypes()})
+}
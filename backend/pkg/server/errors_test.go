@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteStoreError(t *testing.T) {
+	tests := []struct {
+		kind   error
+		status int
+	}{
+		{db.ErrNotFound, http.StatusNotFound},
+		{db.ErrConditionFailed, http.StatusConflict},
+		{db.ErrThrottled, http.StatusServiceUnavailable},
+		{db.ErrValidation, http.StatusBadRequest},
+		{db.ErrOverCapacity, http.StatusTooManyRequests},
+		{nil, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		writeStoreError(rec, &db.StoreError{Operation: "PutFact", Kind: tt.kind, Err: errors.New("boom")}, "Failed to create row")
+		assert.Equal(t, tt.status, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Failed to create row")
+		if tt.status == http.StatusServiceUnavailable {
+			assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestWriteStoreErrorOverCapacity(t *testing.T) {
+	rec := httptest.NewRecorder()
+	err := &db.StoreError{Operation: "PutFact", Kind: db.ErrOverCapacity, Err: &db.CapacityError{RetryAfter: 1500 * time.Millisecond}}
+	writeStoreError(rec, err, "Failed to create row")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+}
+
+func TestWriteRowOverItemLimit(t *testing.T) {
+	_, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes", "columns": [{"name": "body", "dataType": "string"}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	body := `{"id": "n1", "values": {"body": "` + strings.Repeat("x", schema.MaxItemSize) + `"}}`
+	rec = do(http.MethodPost, "/tables/notes/rows", body)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, rec.Body.String())
+	var resp struct {
+		Error        string `json:"error"`
+		Code         string `json:"code"`
+		Size         int    `json:"size"`
+		Limit        int    `json:"limit"`
+		MaxValueSize int    `json:"maxValueSize"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, valueTooLargeErrorCode, resp.Code)
+	assert.Contains(t, resp.Error, "Failed to create row")
+	assert.Equal(t, schema.MaxItemSize, resp.Limit)
+	assert.Greater(t, resp.Size, resp.Limit)
+	assert.Less(t, resp.MaxValueSize, resp.Limit)
+
+	// A row whose values, with their JSON around them, take no more than
+	// the largest value reported fits
+	fits := `{"id": "n1", "values": {"body": "` + strings.Repeat("x", resp.MaxValueSize-20) + `"}}`
+	rec = do(http.MethodPost, "/tables/notes/rows", fits)
+	assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+}
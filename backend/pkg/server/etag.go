@@ -0,0 +1,66 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+)
+
+// snapshotETag versions a snapshot response without encoding it: the table
+// definition, the newest fact and the number of entries change whenever the
+// rows do, and the query and whether secrets are revealed change how they
+// are written. Tables with a TTL column get none, since their rows expire
+// without a new fact, and neither do tables with attachment columns, whose
+// pre-signed download URLs expire without one.
+func snapshotETag(def dynamo.Fact, entries map[string]dynamo.Fact, query string, revealed bool) string {
+	if tableOptionsOf(def).TTLColumn != "" {
+		return ""
+	}
+	for _, col := range def.Columns {
+		if col.DataType == attachmentType {
+			return ""
+		}
+	}
+	var latest dynamo.Fact
+	for _, fact := range entries {
+		if fact.Timestamp.After(latest.Timestamp) || (fact.Timestamp.Equal(latest.Timestamp) && fact.ID > latest.ID) {
+			latest = fact
+		}
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%d\n%s\n%t", def.ID, latest.Timestamp.Format(time.RFC3339Nano), latest.ID, len(entries), query, revealed)
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// notModified sets the response's ETag and reports whether the request's
+// If-None-Match already names it, answering 304 if so. An empty etag is
+// never matched.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches reports whether an If-None-Match header names etag, comparing
+// weakly as RFC 9110 asks for
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEtagMatches(t *testing.T) {
+	assert.True(t, etagMatches(`"abc"`, `"abc"`))
+	assert.True(t, etagMatches(`"x", W/"abc"`, `"abc"`))
+	assert.True(t, etagMatches(`*`, `"abc"`))
+	assert.False(t, etagMatches(``, `"abc"`))
+	assert.False(t, etagMatches(`"abcd"`, `"abc"`))
+}
+
+func TestSnapshotETag(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true})
+	require.NoError(t, err)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+	require.NoError(t, err)
+	do := func(method, path, body, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set("Content-Type", "application/json")
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/tables", `{"name": "notes"}`, "")
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"values": {"v": 1}}`, "")
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodGet, "/tables/notes/snapshot", "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	// An unchanged table is not sent again
+	rec = do(http.MethodGet, "/tables/notes/snapshot", "", etag)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	assert.Equal(t, etag, rec.Header().Get("ETag"))
+	rec = do(http.MethodGet, "/tables/notes/snapshot", "", "W/"+etag)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+
+	// A different query is a different response
+	rec = do(http.MethodGet, "/tables/notes/snapshot?sort=v", "", etag)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+
+	// So is a changed table
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"values": {"v": 2}}`, "")
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodGet, "/tables/notes/snapshot", "", etag)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+	assert.Contains(t, rec.Body.String(), `"v":2`)
+
+	rec = do(http.MethodGet, "/tables/notes/rows", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = do(http.MethodGet, "/tables/notes/rows", "", rec.Header().Get("ETag"))
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+
+	// Attachment download URLs expire, so their tables are always sent
+	rec = do(http.MethodPost, "/tables", `{"name": "files", "columns": [{"name": "doc", "dataType": "attachment"}]}`, "")
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	for _, path := range []string{"/tables/files/snapshot", "/tables/files/rows"} {
+		rec = do(http.MethodGet, path, "", "*")
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+		assert.Empty(t, rec.Header().Get("ETag"), path)
+	}
+}
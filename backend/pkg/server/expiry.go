@@ -0,0 +1,366 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/naming"
+	"github.com/elibdev/notably/pkg/plugin"
+	"github.com/elibdev/notably/pkg/script"
+)
+
+const (
+	// rowEventExpire is the event type of rows whose TTL column has passed
+	rowEventExpire = "expire"
+
+	// expiryIndexDataType marks the facts of the row expiry index
+	expiryIndexDataType = "expiry"
+	// expiryCursorDataType marks the fact recording how far expiries have
+	// been emitted for a user
+	expiryCursorDataType = "expiry-cursor"
+	// expiryCursorField is the field, in the user's namespace, recording the
+	// time up to which expiries have been emitted
+	expiryCursorField = "expiry/cursor"
+
+	// expirySweepInterval is how often passed expiries are emitted
+	expirySweepInterval = time.Minute
+	// maxExpiryCatchUp bounds how far back a sweep looks for missed expiries,
+	// for instance after the server was down
+	maxExpiryCatchUp = 7 * 24 * time.Hour
+	// expiryBucketLayout names the hour an index entry is filed under
+	expiryBucketLayout = "2006010215"
+)
+
+// expiryIndexEntry is the value of an expiry index fact: a row that was
+// written to expire at ExpiresAt
+type expiryIndexEntry struct {
+	Table     string    `json:"table"`
+	Column    string    `json:"column"`
+	Row       string    `json:"row"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// validateTTLColumn checks the TTL column requested for a new table. Tables
+// without column definitions accept any column name.
+func validateTTLColumn(column, tableType string, columns []dynamo.ColumnDefinition) fieldErrors {
+	if column == "" {
+		return nil
+	}
+	if tableType != "" {
+		return fieldErrors{{Field: "ttlColumn", Message: fmt.Sprintf("is not supported for %s tables", tableType)}}
+	}
+	if !naming.ValidSyntax(column) {
+		return fieldErrors{{Field: "ttlColumn", Message: "must contain only alphanumeric characters, hyphens, and underscores"}}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	for _, col := range columns {
+		if col.Name != column {
+			continue
+		}
+		if col.DataType != "datetime" && col.DataType != "date" {
+			return fieldErrors{{Field: "ttlColumn", Message: fmt.Sprintf("column '%s' is not a date or datetime column", column)}}
+		}
+		if col.Encrypted {
+			return fieldErrors{{Field: "ttlColumn", Message: fmt.Sprintf("column '%s' is encrypted", column)}}
+		}
+		return nil
+	}
+	return fieldErrors{{Field: "ttlColumn", Message: fmt.Sprintf("unknown column '%s'", column)}}
+}
+
+// rowExpiry reads a row's expiry from its TTL column. Plain YYYY-MM-DD dates
+// expire at the start of that day, UTC.
+func rowExpiry(column string, values map[string]interface{}) (time.Time, bool) {
+	if column == "" {
+		return time.Time{}, false
+	}
+	t, _, ok := parseEventStart(values[column])
+	return t.UTC(), ok
+}
+
+// rowExpired reports whether a row of a table with the given TTL column has
+// expired by at. Expired rows are left out of snapshots, listings and reads.
+func rowExpired(column string, values map[string]interface{}, at time.Time) bool {
+	t, ok := rowExpiry(column, values)
+	return ok && !at.Before(t)
+}
+
+// expiryIndexField is the field, in the user's namespace, indexing the rows
+// that expire during the hour containing t
+func expiryIndexField(t time.Time) string {
+	return "expiry/" + t.UTC().Format(expiryBucketLayout)
+}
+
+// indexExpiry records a row's expiry in the date index read by the expiry
+// sweeper. Rows written already expired are not indexed and emit no event.
+// Like the geohash index, entries are never removed: the sweeper checks each
+// one against the row's current values.
+func (s *Server) indexExpiry(ctx context.Context, store *db.StoreAdapter, userID, table string, def dynamo.Fact, rowID string, values map[string]interface{}, at time.Time) error {
+	column := tableOptionsOf(def).TTLColumn
+	expiresAt, ok := rowExpiry(column, values)
+	if !ok || !expiresAt.After(at) {
+		return nil
+	}
+	data, err := json.Marshal(expiryIndexEntry{Table: table, Column: column, Row: rowID, ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+	return store.PutFact(ctx, dynamo.Fact{
+		ID:        newID(),
+		Timestamp: at,
+		Namespace: userID,
+		FieldName: expiryIndexField(expiresAt),
+		DataType:  expiryIndexDataType,
+		Value:     string(data),
+	})
+}
+
+// runExpirySweeper emits expiry events for rows whose TTL has passed until
+// ctx is cancelled
+func (s *Server) runExpirySweeper(ctx context.Context) {
+	ticker := time.NewTicker(expirySweepInterval)
+	defer ticker.Stop()
+	cursors := make(map[string]time.Time)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var n int
+			err := s.leased(ctx, "expiry", func(ctx context.Context) (err error) {
+				n, err = s.emitExpirations(ctx, cursors)
+				return err
+			})
+			if err != nil {
+				s.logger.ErrorContext(ctx, "emitting row expiries failed", "expired", n, "error", err)
+			} else if n > 0 {
+				s.logger.InfoContext(ctx, "emitted row expiries", "expired", n)
+			}
+		}
+	}
+}
+
+// emitExpirations emits an expiry event for every indexed row that expired
+// since the previous sweep, returning how many were emitted. cursors holds
+// each user's progress between sweeps; it is persisted only when events are
+// emitted, so idle sweeps do not write.
+func (s *Server) emitExpirations(ctx context.Context, cursors map[string]time.Time) (int, error) {
+	users, err := s.authenticator.GetAllUsers(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	emitted := 0
+	now := time.Now().UTC()
+	for _, user := range users {
+		store, err := s.getStoreForUser(ctx, user.ID)
+		if err != nil {
+			return emitted, err
+		}
+		from, ok := cursors[user.ID]
+		if !ok {
+			if from, err = loadExpiryCursor(ctx, store, user.ID, now); err != nil {
+				return emitted, err
+			}
+		}
+
+		n, err := s.emitUserExpirations(ctx, store, user, from, now)
+		emitted += n
+		if err != nil {
+			return emitted, err
+		}
+		if n > 0 {
+			if err := store.PutFact(ctx, dynamo.Fact{
+				ID:        newID(),
+				Timestamp: now,
+				Namespace: user.ID,
+				FieldName: expiryCursorField,
+				DataType:  expiryCursorDataType,
+				Value:     now.Format(time.RFC3339Nano),
+			}); err != nil {
+				return emitted, err
+			}
+		}
+		cursors[user.ID] = now
+	}
+	return emitted, nil
+}
+
+// loadExpiryCursor returns the time up to which a user's expiries were last
+// emitted, no earlier than maxExpiryCatchUp before now
+func loadExpiryCursor(ctx context.Context, store *db.StoreAdapter, userID string, now time.Time) (time.Time, error) {
+	cursor := now.Add(-maxExpiryCatchUp)
+	facts, err := store.QueryByField(ctx, userID, expiryCursorField, time.Time{}, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, f := range facts {
+		if f.DataType != expiryCursorDataType {
+			continue
+		}
+		str, _ := f.Value.(string)
+		if t, err := time.Parse(time.RFC3339Nano, str); err == nil && t.After(cursor) {
+			cursor = t
+		}
+	}
+	return cursor, nil
+}
+
+// emitUserExpirations reads the expiry index buckets covering (from, to] and
+// expires every row whose indexed expiry falls in that range and still holds
+func (s *Server) emitUserExpirations(ctx context.Context, store *db.StoreAdapter, user *auth.User, from, to time.Time) (int, error) {
+	seen := make(map[expiryIndexEntry]bool)
+	emitted := 0
+	for hour := from.Truncate(time.Hour); !hour.After(to); hour = hour.Add(time.Hour) {
+		facts, err := store.QueryByField(ctx, user.ID, expiryIndexField(hour), time.Time{}, to)
+		if err != nil {
+			return emitted, err
+		}
+		for _, f := range facts {
+			if f.DataType != expiryIndexDataType {
+				continue
+			}
+			var entry expiryIndexEntry
+			raw, _ := f.Value.(string)
+			if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+				continue
+			}
+			entry.ExpiresAt = entry.ExpiresAt.UTC()
+			if !entry.ExpiresAt.After(from) || entry.ExpiresAt.After(to) || seen[entry] {
+				continue
+			}
+			seen[entry] = true
+
+			ok, err := s.expireRow(ctx, store, user, entry)
+			if err != nil {
+				return emitted, err
+			}
+			if ok {
+				emitted++
+			}
+		}
+	}
+	return emitted, nil
+}
+
+// expireRow emits the expiry of an indexed row if the table still has the
+// same TTL column and the row's current values still expire at the indexed
+// time. Automations handling "expire" run afterwards; values they return are
+// stored as an update, which lets a script renew a row.
+func (s *Server) expireRow(ctx context.Context, store *db.StoreAdapter, user *auth.User, entry expiryIndexEntry) (bool, error) {
+	defs, err := store.QueryByField(ctx, user.ID, entry.Table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	if !tableLive(defs) || tableOptionsOf(latestTableDef(defs)).TTLColumn != entry.Column {
+		return false, nil
+	}
+
+	rowStore, err := s.getRowStore(ctx, store, user, entry.Table)
+	if err != nil {
+		return false, err
+	}
+	namespace := tableNamespace(user.ID, entry.Table)
+	versions, err := rowStore.QueryByField(ctx, namespace, entry.Row, time.Time{}, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	var current *dynamo.Fact
+	for i := range versions {
+		if current == nil || versions[i].Timestamp.After(current.Timestamp) {
+			current = &versions[i]
+		}
+	}
+	if current == nil || current.DataType != "json" {
+		return false, nil
+	}
+	values, ok := current.Value.(map[string]interface{})
+	if !ok {
+		return false, nil
+	}
+	if expiresAt, ok := rowExpiry(entry.Column, values); !ok || !expiresAt.Equal(entry.ExpiresAt) {
+		return false, nil
+	}
+
+	s.publishRowEvent(plugin.RowEvent{Type: rowEventExpire, UserID: user.ID, Table: entry.Table, Row: entry.Row, Timestamp: entry.ExpiresAt, Values: values})
+
+	renewed, err := s.runExpiryAutomations(ctx, store, user.ID, defs, script.Event{
+		Type:      rowEventExpire,
+		Table:     entry.Table,
+		Row:       entry.Row,
+		Timestamp: entry.ExpiresAt,
+		Values:    values,
+		Previous:  values,
+	})
+	if err != nil {
+		s.logger.WarnContext(ctx, "expiry automation failed", "table", entry.Table, "row", entry.Row, "error", err)
+		return true, nil
+	}
+	if renewed == nil || reflect.DeepEqual(renewed, values) {
+		return true, nil
+	}
+
+	now := time.Now().UTC()
+	update := plugin.RowEvent{Type: automationEventUpdate, UserID: user.ID, Table: entry.Table, Row: entry.Row, Timestamp: now, Values: renewed}
+	if err := s.plugins.Validate(ctx, update); err != nil {
+		s.logger.WarnContext(ctx, "renewed row rejected", "table", entry.Table, "row", entry.Row, "error", err)
+		return true, nil
+	}
+	if err := s.indexGeoPoints(ctx, store, user.ID, entry.Table, latestTableDef(defs).Columns, entry.Row, renewed, now); err != nil {
+		return true, err
+	}
+	if err := s.indexExpiry(ctx, store, user.ID, entry.Table, latestTableDef(defs), entry.Row, renewed, now); err != nil {
+		return true, err
+	}
+	if err := rowStore.PutFact(ctx, dynamo.Fact{
+		ID:        newID(),
+		Timestamp: now,
+		Namespace: namespace,
+		FieldName: entry.Row,
+		DataType:  "json",
+		Value:     renewed,
+		CreatedAt: current.CreatedAt,
+	}); err != nil {
+		return true, err
+	}
+	s.publishRowEvent(update)
+	return true, nil
+}
+
+// runExpiryAutomations passes an expiry event through the table's automations
+// that handle it, returning the values the last of them produced, or nil when
+// none returned any
+func (s *Server) runExpiryAutomations(ctx context.Context, store *db.StoreAdapter, userID string, defs []dynamo.Fact, ev script.Event) (map[string]interface{}, error) {
+	automations, err := s.loadAutomations(ctx, store, userID, ev.Table, defs)
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]interface{}
+	for _, a := range automations {
+		if !a.handles(ev.Type) {
+			continue
+		}
+		prog, err := script.Compile(a.Name, a.Script, s.config.ScriptLimits)
+		if err != nil {
+			return nil, fmt.Errorf("automation %s: %w", a.Name, err)
+		}
+		out, err := prog.Transform(ctx, ev)
+		if err != nil {
+			return nil, fmt.Errorf("automation %s: %w", a.Name, err)
+		}
+		if out != nil {
+			ev.Values = out
+			result = out
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRowExpired(t *testing.T) {
+	now := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+
+	assert.True(t, rowExpired("expiresAt", map[string]interface{}{"expiresAt": "2024-05-02T12:00:00Z"}, now))
+	assert.True(t, rowExpired("expiresAt", map[string]interface{}{"expiresAt": "2024-05-02T13:00:00+02:00"}, now))
+	assert.False(t, rowExpired("expiresAt", map[string]interface{}{"expiresAt": "2024-05-02T12:00:01Z"}, now))
+	assert.True(t, rowExpired("expiresAt", map[string]interface{}{"expiresAt": "2024-05-02"}, now))
+	assert.False(t, rowExpired("expiresAt", map[string]interface{}{"expiresAt": "2024-05-03"}, now))
+
+	// Rows without a readable expiry never expire
+	assert.False(t, rowExpired("expiresAt", map[string]interface{}{}, now))
+	assert.False(t, rowExpired("expiresAt", map[string]interface{}{"expiresAt": "soon"}, now))
+	assert.False(t, rowExpired("", map[string]interface{}{"expiresAt": "2024-05-01"}, now))
+}
+
+func TestExpiryIndexField(t *testing.T) {
+	at := time.Date(2024, 5, 2, 9, 59, 0, 0, time.FixedZone("", -2*3600))
+	assert.Equal(t, "expiry/2024050211", expiryIndexField(at))
+}
+
+func TestValidateTTLColumn(t *testing.T) {
+	columns := []dynamo.ColumnDefinition{
+		{Name: "title", DataType: "string"},
+		{Name: "expiresAt", DataType: "datetime"},
+		{Name: "until", DataType: "date"},
+		{Name: "hidden", DataType: "datetime", Encrypted: true},
+	}
+
+	assert.Empty(t, validateTTLColumn("", "", columns))
+	assert.Empty(t, validateTTLColumn("expiresAt", "", columns))
+	assert.Empty(t, validateTTLColumn("until", "", columns))
+	assert.Empty(t, validateTTLColumn("anything", "", nil))
+
+	for _, tc := range []struct{ column, tableType string }{
+		{"title", ""},
+		{"missing", ""},
+		{"hidden", ""},
+		{"expires at", ""},
+		{"expiresAt", tableTypeSecrets},
+		{"expiresAt", tableTypeVirtual},
+	} {
+		fields := validateTTLColumn(tc.column, tc.tableType, columns)
+		require.Len(t, fields, 1, tc.column)
+		assert.Equal(t, "ttlColumn", fields[0].Field)
+	}
+}
+
+func TestCreateTableRejectsInvalidTTLColumn(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard()})
+	require.NoError(t, err)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "ttl", 0)
+	require.NoError(t, err)
+
+	body := `{"name": "sessions", "ttlColumn": "title", "columns": [{"name": "title", "dataType": "string"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/tables", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "ttlColumn")
+}
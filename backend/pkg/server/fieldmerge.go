@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+)
+
+// Tables merge concurrent writes of a row by row or, when created with
+// "merge": "field", by field. A write to a field-merge table changes only
+// the columns that differ from the version it was made on, and each column
+// keeps the value of its latest write. Every column a write changes is
+// recorded as a field fact, so a write dated earlier than a later change of
+// a column leaves that column alone. Writes are conditional on the version
+// they were merged into, so one that raced another is merged again rather
+// than dropping the other's columns.
+
+const (
+	// mergeRow, the default, lets the last write of a row win
+	mergeRow = "row"
+	// mergeFields lets the last write of each column win
+	mergeFields = "field"
+
+	// maxMergeAttempts is how many times an update of a field-merge table
+	// is merged again into versions of the row written while it was merged
+	maxMergeAttempts = 5
+
+	// fieldClockDataType marks the facts recording the columns a write of
+	// a field-merge table changed
+	fieldClockDataType = "fieldclock"
+)
+
+// validateMergePolicy checks the merge policy requested for a new table.
+// Secrets and virtual tables merge by row: the server cannot diff sealed
+// rows and does not store proxied ones.
+func validateMergePolicy(policy, tableType string) fieldErrors {
+	switch policy {
+	case "", mergeRow:
+		return nil
+	case mergeFields:
+		if tableType != "" {
+			return fieldErrors{{Field: "merge", Message: fmt.Sprintf("is not supported for %s tables", tableType)}}
+		}
+		return nil
+	}
+	return fieldErrors{{Field: "merge", Message: "must be row or field"}}
+}
+
+// mergesFields reports whether a table merges writes by field
+func (o tableOptions) mergesFields() bool {
+	return o.Merge == mergeFields
+}
+
+// fieldClockField is the field, in the user's namespace, recording the
+// column writes of a row of a field-merge table
+func fieldClockField(table, row string) string {
+	return settingField(table, "fields", row)
+}
+
+// fieldChanges returns the columns values changes from base, sorted, with
+// the columns it leaves out counting as removed
+func fieldChanges(base, values map[string]interface{}) []string {
+	var changed []string
+	for name, v := range values {
+		if old, ok := base[name]; !ok || !reflect.DeepEqual(old, v) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range base {
+		if _, ok := values[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// mergeFields applies the columns a write made at at changed from base to
+// the row's current values. Columns written after at keep their current
+// values. It returns the merged values and the columns the write changed.
+func (s *Server) mergeFields(ctx context.Context, store *db.StoreAdapter, userID, table, row string, current, base, values map[string]interface{}, at, now time.Time) (map[string]interface{}, []string, error) {
+	later, err := store.QueryByField(ctx, userID, fieldClockField(table, row), at, now.Add(s.maxClockAhead()))
+	if err != nil {
+		return nil, nil, err
+	}
+	newer := make(map[string]bool)
+	for _, f := range later {
+		if name, ok := f.Value.(string); ok && f.DataType == fieldClockDataType && f.Timestamp.After(at) {
+			newer[name] = true
+		}
+	}
+
+	merged := make(map[string]interface{}, len(current)+len(values))
+	for name, v := range current {
+		merged[name] = v
+	}
+	var applied []string
+	for _, name := range fieldChanges(base, values) {
+		if newer[name] {
+			continue
+		}
+		if v, ok := values[name]; ok {
+			merged[name] = v
+		} else {
+			delete(merged, name)
+		}
+		applied = append(applied, name)
+	}
+	return merged, applied, nil
+}
+
+// mergeBase returns the values an update of a field-merge table made at at
+// was made on: those of the row as of base, which defaults to at
+func mergeBase(ctx context.Context, rowStore *db.StoreAdapter, namespace string, current dynamo.Fact, base *time.Time, at time.Time) (map[string]interface{}, error) {
+	baseTime := at
+	if base != nil {
+		baseTime = base.UTC()
+	}
+	if baseTime.Before(current.Timestamp) {
+		return rowValuesAt(ctx, rowStore, namespace, current.FieldName, baseTime)
+	}
+	return previousValues(current), nil
+}
+
+// recordFieldWrites records the columns a write of a row made at at changed
+func (s *Server) recordFieldWrites(ctx context.Context, store *db.StoreAdapter, userID, table, row string, columns []string, at time.Time) error {
+	for _, name := range columns {
+		if err := store.PutFact(ctx, dynamo.Fact{
+			ID:        newID(),
+			Timestamp: at,
+			Namespace: userID,
+			FieldName: fieldClockField(table, row),
+			DataType:  fieldClockDataType,
+			Value:     name,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// latestRowFact returns the latest fact of a row written by now, and false
+// when the row has none
+func (s *Server) latestRowFact(ctx context.Context, rowStore *db.StoreAdapter, namespace, row string, now time.Time) (dynamo.Fact, bool, error) {
+	facts, err := rowStore.QueryByField(ctx, namespace, row, time.Time{}, now.Add(s.maxClockAhead()))
+	if err != nil || len(facts) == 0 {
+		return dynamo.Fact{}, false, err
+	}
+	return facts[len(facts)-1], true, nil
+}
+
+// rowValuesAt returns the values of a row's latest version written by at,
+// or nil when it was not live then
+func rowValuesAt(ctx context.Context, rowStore *db.StoreAdapter, namespace, row string, at time.Time) (map[string]interface{}, error) {
+	facts, err := rowStore.QueryByField(ctx, namespace, row, time.Time{}, at)
+	if err != nil || len(facts) == 0 {
+		return nil, err
+	}
+	return previousValues(facts[len(facts)-1]), nil
+}
+
+// rowVersionValues returns the values of the version of a row with the
+// given fact ID, and false when the row has no such version
+func rowVersionValues(ctx context.Context, rowStore *db.StoreAdapter, namespace, row, version string, now time.Time) (map[string]interface{}, bool, error) {
+	facts, err := rowStore.QueryByField(ctx, namespace, row, time.Time{}, now)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, f := range facts {
+		if f.ID == version {
+			return previousValues(f), true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// checkDeleteOrder is the order check of client-dated writes to field-merge
+// tables: they may be dated before later changes of the row, which they are
+// merged with, but not before its deletion
+func (s *Server) checkDeleteOrder(ctx context.Context, rowStore *db.StoreAdapter, namespace, row string, at, now time.Time) error {
+	facts, err := rowStore.QueryByField(ctx, namespace, row, at, now.Add(s.maxClockAhead()))
+	if err != nil {
+		return err
+	}
+	for _, f := range facts {
+		if f.IsTombstone() {
+			return &outOfOrderError{row: row, last: f.Timestamp}
+		}
+	}
+	return nil
+}
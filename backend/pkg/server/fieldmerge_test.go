@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldMerge(t *testing.T) {
+	_, do := memoryServer(t)
+	stamp := func(at time.Time) string { return at.UTC().Format(time.RFC3339Nano) }
+	rowValues := func(path string) map[string]interface{} {
+		t.Helper()
+		rec := do(http.MethodGet, path, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var row RowData
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &row))
+		return row.Values
+	}
+
+	for _, table := range []string{`{"name": "docs", "merge": "field"}`, `{"name": "plain"}`} {
+		rec := do(http.MethodPost, "/tables", table)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	rec := do(http.MethodGet, "/tables", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"merge":"field"`)
+
+	// Two clients edit different columns of the same version
+	created := map[string]time.Time{}
+	for _, table := range []string{"docs", "plain"} {
+		rec := do(http.MethodPost, "/tables/"+table+"/rows", `{"id": "r1", "values": {"a": 1, "b": 1}}`)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		var row RowData
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &row))
+		created[table] = row.Timestamp
+	}
+	rec = do(http.MethodPut, "/tables/docs/rows/r1", `{"values": {"a": 2, "b": 1}, "baseTimestamp": "`+stamp(created["docs"])+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = do(http.MethodPut, "/tables/docs/rows/r1", `{"values": {"a": 1, "b": 2}, "baseTimestamp": "`+stamp(created["docs"])+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, map[string]interface{}{"a": float64(2), "b": float64(2)}, rowValues("/tables/docs/rows/r1"), "both edits are kept")
+
+	rec = do(http.MethodPut, "/tables/plain/rows/r1", `{"values": {"a": 2, "b": 1}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = do(http.MethodPut, "/tables/plain/rows/r1", `{"values": {"a": 1, "b": 2}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, map[string]interface{}{"a": float64(1), "b": float64(2)}, rowValues("/tables/plain/rows/r1"), "row tables keep the last write")
+	rec = do(http.MethodPut, "/tables/plain/rows/r1", `{"values": {"a": 1}, "baseTimestamp": "`+stamp(created["plain"])+`"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// An edit made offline before the others only changes what they did not
+	offline := created["docs"].Add(time.Nanosecond)
+	rec = do(http.MethodPut, "/tables/docs/rows/r1", `{"values": {"a": 5, "b": 1, "c": 1}, "timestamp": "`+stamp(offline)+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var row RowData
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &row))
+	assert.Equal(t, map[string]interface{}{"a": float64(2), "b": float64(2), "c": float64(1)}, row.Values)
+	assert.True(t, row.Timestamp.After(offline), "the merge is a new version")
+	require.NotNil(t, row.ValidTime)
+	assert.True(t, offline.Equal(*row.ValidTime))
+
+	rec = do(http.MethodPatch, "/tables/docs/rows/r1", `{"values": {"b": 3, "c": null}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, map[string]interface{}{"a": float64(2), "b": float64(3)}, rowValues("/tables/docs/rows/r1"))
+
+	// Pushes made on an older version are merged rather than conflicting
+	rec = do(http.MethodGet, "/sync/changes", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var pulled struct {
+		Changes []SyncChange `json:"changes"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pulled))
+	require.NotEmpty(t, pulled.Changes)
+	first := pulled.Changes[0]
+	require.Equal(t, "docs", first.Table)
+	rec = do(http.MethodPost, "/sync/push", `{"changes": [
+		{"table": "docs", "id": "r1", "baseVersion": "`+first.Version+`", "values": {"a": 1, "b": 1, "d": 1}},
+		{"table": "docs", "id": "r1", "baseVersion": "`+first.Version+`", "deleted": true}
+	]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var pushed struct {
+		Results []SyncPushResult `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pushed))
+	require.Len(t, pushed.Results, 2)
+	assert.Equal(t, syncApplied, pushed.Results[0].Status)
+	assert.True(t, pushed.Results[0].Merged)
+	assert.Equal(t, syncApplied, pushed.Results[1].Status, "follows on from the merged change")
+	rec = do(http.MethodGet, "/tables/docs/rows/r1", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = do(http.MethodPost, "/tables/docs/rows", `{"id": "r2", "values": {"a": 1}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &row))
+	rec = do(http.MethodDelete, "/tables/docs/rows/r2", "")
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/docs/rows", `{"id": "r2", "values": {"a": 2}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPut, "/tables/docs/rows/r2", `{"values": {"a": 3}, "timestamp": "`+stamp(row.Timestamp.Add(time.Nanosecond))+`"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code, "writes are not merged across a deletion")
+
+	rec = do(http.MethodPost, "/tables", `{"name": "bad", "merge": "column"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), "merge")
+}
+
+// racingStore runs race, once, before the first write of a row, as if a
+// concurrent request wrote it first
+type racingStore struct {
+	db.Store
+	row  string
+	race func()
+}
+
+func (s *racingStore) before(facts ...*db.Fact) {
+	for _, f := range facts {
+		if f.FieldName == s.row && s.race != nil {
+			race := s.race
+			s.race = nil
+			race()
+			return
+		}
+	}
+}
+
+func (s *racingStore) PutFact(ctx context.Context, fact *db.Fact) error {
+	s.before(fact)
+	return s.Store.PutFact(ctx, fact)
+}
+
+func (s *racingStore) PutFactsTransactional(ctx context.Context, facts []*db.Fact) error {
+	s.before(facts...)
+	return s.Store.PutFactsTransactional(ctx, facts)
+}
+
+func TestFieldMergeConcurrentUpdates(t *testing.T) {
+	srv, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes", "merge": "field"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n1", "values": {"title": "draft", "body": "empty"}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var racer *racingStore
+	srv.memMu.Lock()
+	for key, store := range srv.memStores {
+		racer = &racingStore{Store: store, row: "n1"}
+		srv.memStores[key] = racer
+	}
+	srv.memMu.Unlock()
+	require.NotNil(t, racer)
+
+	// Another write of the row lands between reading it and writing the
+	// update, which is merged again into it
+	racer.race = func() {
+		rec := do(http.MethodPatch, "/tables/notes/rows/n1", `{"values": {"body": "text"}}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+	rec = do(http.MethodPatch, "/tables/notes/rows/n1", `{"values": {"title": "final"}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var row RowData
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &row))
+	assert.Equal(t, map[string]interface{}{"title": "final", "body": "text"}, row.Values)
+
+	racer.race = func() {
+		rec := do(http.MethodPut, "/tables/notes/rows/n1", `{"values": {"title": "final", "body": "more text"}}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+	rec = do(http.MethodPut, "/tables/notes/rows/n1", `{"values": {"title": "done", "body": "text"}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodGet, "/tables/notes/rows/n1", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &row))
+	assert.Equal(t, map[string]interface{}{"title": "done", "body": "more text"}, row.Values, "both columns are kept")
+
+	// Pushed changes are merged again too
+	rec = do(http.MethodGet, "/sync/changes", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var pulled struct {
+		Changes []SyncChange `json:"changes"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pulled))
+	base := pulled.Changes[len(pulled.Changes)-1].Version
+	racer.race = func() {
+		rec := do(http.MethodPatch, "/tables/notes/rows/n1", `{"values": {"body": "synced"}}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+	rec = do(http.MethodPost, "/sync/push", `{"changes": [{"table": "notes", "id": "n1", "baseVersion": "`+base+`", "values": {"title": "pushed", "body": "more text"}}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var pushed struct {
+		Results []SyncPushResult `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pushed))
+	assert.Equal(t, syncApplied, pushed.Results[0].Status)
+	assert.True(t, pushed.Results[0].Merged)
+	rec = do(http.MethodGet, "/tables/notes/rows/n1", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &row))
+	assert.Equal(t, map[string]interface{}{"title": "pushed", "body": "synced"}, row.Values)
+}
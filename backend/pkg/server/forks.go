@@ -0,0 +1,292 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+)
+
+// maxForkDepth bounds the chain of parents followed when reading a fork.
+// Reusing table names can otherwise make a chain loop.
+const maxForkDepth = 8
+
+// forkOrigin records the parent of a forked table. A fork holds no copies
+// of its parent's rows: reads see the parent as it was at the fork point,
+// overlaid with the fork's own writes.
+type forkOrigin struct {
+	Table string    `json:"table"`
+	At    time.Time `json:"at"`
+
+	// MergedAt is when the fork was last merged into its parent
+	MergedAt *time.Time `json:"mergedAt,omitempty"`
+}
+
+// forkParentDef returns a fork parent's table definition as of the fork
+// point, which still describes the parent after it is deleted
+func (s *Server) forkParentDef(ctx context.Context, store *db.StoreAdapter, user *auth.User, fork *forkOrigin) (dynamo.Fact, bool, error) {
+	defs, err := store.QueryByField(ctx, user.ID, fork.Table, time.Time{}, fork.At)
+	if err != nil || len(defs) == 0 {
+		return dynamo.Fact{}, false, err
+	}
+	return latestTableDef(defs), true, nil
+}
+
+// tableEntries returns a table's snapshot entries at a time, keyed by row
+// ID. Cold facts are folded in, and forks inherit their parent's entries
+// from before the fork point.
+func (s *Server) tableEntries(ctx context.Context, store, rowStore *db.StoreAdapter, user *auth.User, table string, def dynamo.Fact, at time.Time) (map[string]dynamo.Fact, error) {
+	return s.forkEntries(ctx, store, rowStore, user, table, def, at, 0)
+}
+
+func (s *Server) forkEntries(ctx context.Context, store, rowStore *db.StoreAdapter, user *auth.User, table string, def dynamo.Fact, at time.Time, depth int) (map[string]dynamo.Fact, error) {
+	namespace := tableNamespace(user.ID, table)
+	snap, err := rowStore.GetTableSnapshot(ctx, user.ID, table, at)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.withColdSnapshot(ctx, namespace, at, snap)
+	if err != nil {
+		return nil, err
+	}
+
+	fork := tableOptionsOf(def).Fork
+	if fork == nil || depth >= maxForkDepth {
+		return entries, nil
+	}
+	parentDef, ok, err := s.forkParentDef(ctx, store, user, fork)
+	if err != nil || !ok {
+		return entries, err
+	}
+	parentStore, err := s.getRowStore(ctx, store, user, fork.Table)
+	if err != nil {
+		return nil, err
+	}
+	parentAt := at
+	if fork.At.Before(parentAt) {
+		parentAt = fork.At
+	}
+	inherited, err := s.forkEntries(ctx, store, parentStore, user, fork.Table, parentDef, parentAt, depth+1)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]dynamo.Fact, len(inherited)+len(entries))
+	for id, f := range inherited {
+		f.Namespace = namespace
+		merged[id] = f
+	}
+	// The fork's own facts, deletions included, shadow the parent's
+	for id, f := range entries {
+		merged[id] = f
+	}
+	return merged, nil
+}
+
+// tableHistory returns the row facts of a table in a time range, with cold
+// facts folded in. A fork's history starts with its parent's up to the fork
+// point.
+func (s *Server) tableHistory(ctx context.Context, store, rowStore *db.StoreAdapter, user *auth.User, table string, def dynamo.Fact, start, end time.Time) ([]dynamo.Fact, error) {
+	var facts []dynamo.Fact
+	err := s.streamHistory(ctx, store, rowStore, user, table, def, start, end, func(batch []dynamo.Fact) error {
+		facts = append(facts, batch...)
+		return nil
+	})
+	return facts, err
+}
+
+// streamHistory passes the facts tableHistory returns to emit in batches, in
+// time order, as they are read
+func (s *Server) streamHistory(ctx context.Context, store, rowStore *db.StoreAdapter, user *auth.User, table string, def dynamo.Fact, start, end time.Time, emit func([]dynamo.Fact) error) error {
+	return s.forkHistory(ctx, store, rowStore, user, table, def, start, end, 0, emit)
+}
+
+func (s *Server) forkHistory(ctx context.Context, store, rowStore *db.StoreAdapter, user *auth.User, table string, def dynamo.Fact, start, end time.Time, depth int, emit func([]dynamo.Fact) error) error {
+	namespace := tableNamespace(user.ID, table)
+	// A fork's own facts all follow the fork point, so the parent's come first
+	if fork := tableOptionsOf(def).Fork; fork != nil && depth < maxForkDepth && !start.After(fork.At) {
+		parentDef, ok, err := s.forkParentDef(ctx, store, user, fork)
+		if err != nil {
+			return err
+		}
+		if ok {
+			parentStore, err := s.getRowStore(ctx, store, user, fork.Table)
+			if err != nil {
+				return err
+			}
+			parentEnd := end
+			if fork.At.Before(parentEnd) {
+				parentEnd = fork.At
+			}
+			err = s.forkHistory(ctx, store, parentStore, user, fork.Table, parentDef, start, parentEnd, depth+1, func(batch []dynamo.Fact) error {
+				for i := range batch {
+					batch[i].Namespace = namespace
+				}
+				return emit(batch)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Cold facts are merged in whole, so with cold storage the table's
+	// history arrives in one batch
+	if s.cold == nil {
+		return readHistory(ctx, rowStore, namespace, start, end, emit)
+	}
+	var own []dynamo.Fact
+	err := readHistory(ctx, rowStore, namespace, start, end, func(batch []dynamo.Fact) error {
+		own = append(own, batch...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	own, err = s.withColdHistory(ctx, namespace, start, end, own)
+	if err != nil {
+		return err
+	}
+	return emit(own)
+}
+
+// forksOf returns the live tables forked from a table
+func (s *Server) forksOf(ctx context.Context, store *db.StoreAdapter, user *auth.User, table string) ([]string, error) {
+	facts, err := store.QueryByTimeRange(ctx, time.Time{}, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defs := make(map[string][]dynamo.Fact)
+	for _, f := range facts {
+		if f.Namespace == user.ID && f.DataType == tableDataType {
+			defs[f.FieldName] = append(defs[f.FieldName], f)
+		}
+	}
+	var forks []string
+	for name, versions := range defs {
+		if !tableLive(versions) {
+			continue
+		}
+		if fork := tableOptionsOf(latestTableDef(versions)).Fork; fork != nil && fork.Table == table {
+			forks = append(forks, name)
+		}
+	}
+	return forks, nil
+}
+
+// handleForkTable creates a table seeded with another table's rows as they
+// were at a point in time, without copying them
+func (s *Server) handleForkTable(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	table := r.PathValue("table")
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var fields fieldErrors
+	fields.required("name", req.Name)
+	if len(fields) > 0 {
+		writeValidationError(w, "Fork name is required", fields)
+		return
+	}
+	if problem := s.nameProblem(req.Name); problem != "" {
+		writeError(w, http.StatusBadRequest, "Table name "+problem)
+		return
+	}
+	if !keyAllowsTable(r, req.Name) {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("API key is not scoped for table '%s'", req.Name))
+		return
+	}
+
+	now := time.Now().UTC()
+	at := now
+	if v := r.URL.Query().Get("at"); v != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'at' time format: %v (expected RFC3339)", err))
+			return
+		}
+		if at.After(now) {
+			writeError(w, http.StatusBadRequest, "'at' must not be in the future")
+			return
+		}
+		at = at.UTC()
+	}
+
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, now)
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+	def := latestTableDef(facts)
+	opts := tableOptionsOf(def)
+	switch {
+	case opts.Source != nil:
+		writeError(w, http.StatusBadRequest, "Virtual tables cannot be forked")
+		return
+	case opts.Session != "":
+		writeError(w, http.StatusBadRequest, "Temporary tables cannot be forked")
+		return
+	}
+
+	existing, err := store.QueryByField(r.Context(), user.ID, req.Name, time.Time{}, now)
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if tableLive(existing) {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Table '%s' already exists", req.Name))
+		return
+	}
+
+	// In isolated mode this creates the fork's own DynamoDB table
+	if _, err := s.getRowStore(r.Context(), store, user, req.Name); err != nil {
+		writeStoreError(w, err, "Failed to create table storage")
+		return
+	}
+
+	origin := &forkOrigin{Table: table, At: at}
+	fact := dynamo.Fact{
+		ID:        newID(),
+		Timestamp: now,
+		Namespace: user.ID,
+		FieldName: req.Name,
+		DataType:  tableDataType,
+		Value:     tableOptions{Type: opts.Type, TTLColumn: opts.TTLColumn, Fork: origin, Merge: opts.Merge}.encode(),
+		Columns:   def.Columns,
+	}
+	if err := store.PutFact(r.Context(), fact); err != nil {
+		writeStoreError(w, err, "Failed to create table")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, TableInfo{
+		Name:      req.Name,
+		Type:      opts.Type,
+		CreatedAt: fact.Timestamp,
+		Columns:   def.Columns,
+		TTLColumn: opts.TTLColumn,
+		Fork:      origin,
+		Merge:     opts.Merge,
+	})
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForkTable(t *testing.T) {
+	_, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes", "columns": [{"name": "v", "dataType": "number"}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	for _, body := range []string{`{"id": "n1", "values": {"v": 1}}`, `{"id": "n2", "values": {"v": 2}}`} {
+		rec = do(http.MethodPost, "/tables/notes/rows", body)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	time.Sleep(5 * time.Millisecond)
+	checkpoint := url.QueryEscape(time.Now().UTC().Format(time.RFC3339Nano))
+	time.Sleep(5 * time.Millisecond)
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n3", "values": {"v": 3}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodPost, "/v1/tables/notes/fork?at="+checkpoint, `{"name": "draft"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var info TableInfo
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	require.NotNil(t, info.Fork)
+	assert.Equal(t, "notes", info.Fork.Table)
+	assert.Len(t, info.Columns, 1)
+
+	// The fork sees the parent as of the fork point, not later writes
+	assert.Equal(t, map[string]float64{"n1": 1, "n2": 2}, snapshotValues(t, do, "/tables/draft/snapshot"))
+
+	// Writes to either table leave the other alone
+	rec = do(http.MethodPost, "/transactions", `{"ops": [{"op": "update", "table": "draft", "id": "n1", "values": {"v": 10}}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/tables/draft/rows/n2", "").Code)
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n4", "values": {"v": 4}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Equal(t, map[string]float64{"n1": 10}, snapshotValues(t, do, "/tables/draft/snapshot"))
+	assert.Equal(t, map[string]float64{"n1": 1, "n2": 2, "n3": 3, "n4": 4}, snapshotValues(t, do, "/tables/notes/snapshot"))
+
+	// History runs through the parent's up to the fork point
+	rec = do(http.MethodGet, "/tables/draft/history?start=2000-01-01T00:00:00Z&end=2999-01-01T00:00:00Z", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var history struct {
+		Events []RowEvent `json:"events"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &history))
+	var ids []string
+	for _, ev := range history.Events {
+		ids = append(ids, ev.ID)
+	}
+	assert.Equal(t, []string{"n1", "n2", "n1"}, ids[:3])
+
+	rec = do(http.MethodGet, "/tables", "")
+	assert.Contains(t, rec.Body.String(), `"fork":{"table":"notes"`)
+}
+
+func TestForkTableErrors(t *testing.T) {
+	_, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables", `{"name": "other"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/v1/tables/notes/fork", `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/v1/tables/notes/fork", `{"name": "bad name"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/v1/tables/notes/fork?at=2999-01-01T00:00:00Z", `{"name": "draft"}`).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/v1/tables/missing/fork", `{"name": "draft"}`).Code)
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/v1/tables/notes/fork", `{"name": "other"}`).Code)
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/elibdev/notably/pkg/web"
+)
+
+// frontendAPIPrefix is where the frontend calls the API. The Vite dev
+// server proxies it to the versioned paths, and so does the server when it
+// serves the frontend itself.
+const frontendAPIPrefix = "/api/"
+
+// FrontendConfig serves the built web app at / next to the API, so a single
+// binary runs both. The app is taken from the binary, built with
+// -tags embedfrontend, or from a directory; it is not served when neither
+// is set.
+type FrontendConfig struct {
+	Embedded bool
+	Dir      string
+}
+
+// enabled reports whether the frontend is served
+func (c FrontendConfig) enabled() bool {
+	return c.Embedded || c.Dir != ""
+}
+
+// validate checks that at most one source of the frontend is set
+func (c FrontendConfig) validate() error {
+	if c.Embedded && c.Dir != "" {
+		return errors.New("frontend.embedded and frontend.dir cannot both be set")
+	}
+	return nil
+}
+
+// frontendConfigFromEnv reads the frontend settings from the environment
+func frontendConfigFromEnv() FrontendConfig {
+	return FrontendConfig{
+		Embedded: os.Getenv("NOTABLY_FRONTEND_EMBEDDED") == "true",
+		Dir:      os.Getenv("NOTABLY_FRONTEND_DIR"),
+	}
+}
+
+// newFrontend returns the handler of the configured frontend, or nil when
+// it is not served
+func newFrontend(c FrontendConfig) (*web.Handler, error) {
+	if !c.enabled() {
+		return nil, nil
+	}
+	files := web.Embedded()
+	source := "the embedded frontend"
+	if c.Dir != "" {
+		files, source = os.DirFS(c.Dir), c.Dir
+	} else if files == nil {
+		return nil, errors.New("frontend.embedded needs a server built with -tags embedfrontend")
+	}
+	if _, err := fs.Stat(files, web.Index); err != nil {
+		return nil, fmt.Errorf("frontend: %s has no %s: run npm run build in frontend/", source, web.Index)
+	}
+	return web.New(files), nil
+}
+
+// serveFrontend serves the frontend in front of the API routes. Its files
+// and the pages a browser navigates to are served by the frontend, and
+// calls under /api/ are answered by the versioned API; everything else,
+// including unversioned API paths requested by API clients, reaches next.
+func (s *Server) serveFrontend(next http.Handler) http.Handler {
+	if s.frontend == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rest, ok := strings.CutPrefix(r.URL.Path, frontendAPIPrefix); ok {
+			r2 := r.Clone(r.Context())
+			r2.URL.Path = apiVersion + "/" + rest
+			if r.URL.RawPath != "" {
+				r2.URL.RawPath = apiVersion + "/" + strings.TrimPrefix(r.URL.RawPath, frontendAPIPrefix)
+			}
+			next.ServeHTTP(w, r2)
+			return
+		}
+		if (r.Method == http.MethodGet || r.Method == http.MethodHead) && s.frontendPath(r) {
+			s.frontend.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// frontendPath reports whether a GET request is for the frontend: a file
+// of it, the root, or a page a browser navigates to outside the versioned
+// API and the server-rendered UI, such as /tables/notes
+func (s *Server) frontendPath(r *http.Request) bool {
+	p := r.URL.Path
+	switch {
+	case p == apiVersion || strings.HasPrefix(p, apiVersion+"/") || p == "/ui" || strings.HasPrefix(p, "/ui/") || p == "/metrics" || p == "/readyz":
+		return false
+	case p == "/" || s.frontend.Has(p):
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/elibdev/notably/pkg/web"
+)
+
+func TestFrontend(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "assets"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<div id=root></div>"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "assets", "index-1a2b.js"), []byte("app()"), 0o644))
+
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true, Frontend: FrontendConfig{Dir: dir}})
+	require.NoError(t, err)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+	require.NoError(t, err)
+	do := func(method, path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"name": "notes"}`))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	const browser = "text/html,application/xhtml+xml,*/*;q=0.8"
+
+	rec := do(http.MethodGet, "/assets/index-1a2b.js", "*/*")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "app()", rec.Body.String())
+	assert.Contains(t, rec.Header().Get("Cache-Control"), "immutable")
+
+	// Pages of the app load the index page, even where an unversioned API
+	// path has the same name
+	for _, path := range []string{"/", "/login", "/tables", "/tables/notes"} {
+		rec = do(http.MethodGet, path, browser)
+		require.Equal(t, http.StatusOK, rec.Code, path)
+		assert.Equal(t, "<div id=root></div>", rec.Body.String(), path)
+		assert.Equal(t, "no-cache", rec.Header().Get("Cache-Control"), path)
+	}
+
+	// The app calls the API under /api/
+	rec = do(http.MethodPost, "/api/tables", "*/*")
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodGet, "/api/tables", "*/*")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"notes"`)
+
+	// API clients still reach the API on every path
+	for _, path := range []string{"/v1/tables", "/tables"} {
+		rec = do(http.MethodGet, path, "application/json")
+		require.Equal(t, http.StatusOK, rec.Code, path)
+		assert.Contains(t, rec.Body.String(), `"notes"`, path)
+	}
+	rec = do(http.MethodGet, "/v1/tables/missing", browser)
+	assert.NotEqual(t, "<div id=root></div>", rec.Body.String(), "the versioned API is never the app")
+
+	// Servers without a frontend leave / to the API
+	_, plain := memoryServer(t)
+	assert.Equal(t, http.StatusNotFound, plain(http.MethodGet, "/", "").Code)
+
+	_, err = NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true, Frontend: FrontendConfig{Dir: t.TempDir()}})
+	assert.ErrorContains(t, err, "has no index.html")
+	if web.Embedded() == nil {
+		_, err = NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true, Frontend: FrontendConfig{Embedded: true}})
+		assert.ErrorContains(t, err, "-tags embedfrontend")
+	}
+}
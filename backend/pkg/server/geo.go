@@ -0,0 +1,394 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/geo"
+)
+
+const (
+	// geopointType is the column type of {"lat": ..., "lng": ...} locations
+	geopointType = "geopoint"
+	// geoIndexDataType marks the facts of the geohash index
+	geoIndexDataType = "geoindex"
+
+	// maxGeoCells bounds the index cells read by one location query
+	maxGeoCells = 32
+
+	defaultGeoLimit = 100
+	maxGeoLimit     = 1000
+)
+
+// geoIndexPrecisions are the geohash lengths each location is indexed at,
+// finest first: cells of about 1.2km, 39km and 1250km across. Queries read
+// the finest precision that covers their area in at most maxGeoCells cells.
+var geoIndexPrecisions = []int{6, 4, 2}
+
+// GeoRow is a row matched by a location query
+type GeoRow struct {
+	RowData
+	// Distance is the distance in meters from the center of a nearby query
+	Distance *float64 `json:"distance,omitempty"`
+}
+
+// geoIndexEntry is the value of a geohash index fact: a row that had a
+// location in the fact's cell
+type geoIndexEntry struct {
+	Row string  `json:"row"`
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// geoPointOf reads a geopoint column value
+func geoPointOf(value interface{}) (geo.Point, bool) {
+	m, ok := value.(map[string]interface{})
+	if !ok || len(m) != 2 {
+		return geo.Point{}, false
+	}
+	lat, latOK := geoNumber(m["lat"])
+	lng, lngOK := geoNumber(m["lng"])
+	p := geo.Point{Lat: lat, Lng: lng}
+	return p, latOK && lngOK && p.Valid()
+}
+
+func geoNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// geoIndexField is the field, in the user's namespace, indexing the rows of
+// a table whose column falls in a geohash cell
+func geoIndexField(table, column, cell string) string {
+	return settingField(table, "geo", column, cell)
+}
+
+// indexGeoPoints records a row's locations in the geohash index. Entries are
+// never removed: queries check every match against the row's current values,
+// so entries left by moved or deleted rows are skipped. Encrypted columns are
+// not indexed.
+func (s *Server) indexGeoPoints(ctx context.Context, store *db.StoreAdapter, userID, table string, columns []dynamo.ColumnDefinition, rowID string, values map[string]interface{}, at time.Time) error {
+	for _, col := range columns {
+		if col.DataType != geopointType || col.Encrypted {
+			continue
+		}
+		p, ok := geoPointOf(values[col.Name])
+		if !ok {
+			continue
+		}
+		data, err := json.Marshal(geoIndexEntry{Row: rowID, Lat: p.Lat, Lng: p.Lng})
+		if err != nil {
+			return err
+		}
+		for _, precision := range geoIndexPrecisions {
+			if err := store.PutFact(ctx, dynamo.Fact{
+				ID:        newID(),
+				Timestamp: at,
+				Namespace: userID,
+				FieldName: geoIndexField(table, col.Name, geo.Encode(p, precision)),
+				DataType:  geoIndexDataType,
+				Value:     string(data),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// geoQuery is a parsed location query
+type geoQuery struct {
+	region geo.Box
+	// center and radius are set for nearby queries
+	center *geo.Point
+	radius float64
+	column string
+	limit  int
+}
+
+// matches reports whether p satisfies the query
+func (q geoQuery) matches(p geo.Point) bool {
+	if !q.region.Contains(p) {
+		return false
+	}
+	return q.center == nil || geo.Distance(*q.center, p) <= q.radius
+}
+
+// parseFloatParam reads a required numeric query parameter, recording a
+// field error if it is missing or invalid
+func parseFloatParam(r *http.Request, name string, min, max float64, fields *fieldErrors) float64 {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		fields.required(name, raw)
+		return 0
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || v < min || v > max {
+		*fields = append(*fields, FieldError{Field: name, Message: fmt.Sprintf("must be a number from %g to %g", min, max)})
+		return 0
+	}
+	return v
+}
+
+// parseGeoLimit reads the limit query parameter
+func parseGeoLimit(r *http.Request, fields *fieldErrors) int {
+	raw := r.URL.Query().Get("limit")
+	if raw == "" {
+		return defaultGeoLimit
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > maxGeoLimit {
+		*fields = append(*fields, FieldError{Field: "limit", Message: fmt.Sprintf("must be an integer from 1 to %d", maxGeoLimit)})
+		return 0
+	}
+	return n
+}
+
+// handleNearbyRows returns the rows whose geopoint lies within radius meters
+// of a point, nearest first
+func (s *Server) handleNearbyRows(w http.ResponseWriter, r *http.Request) {
+	var fields fieldErrors
+	center := geo.Point{
+		Lat: parseFloatParam(r, "lat", -90, 90, &fields),
+		Lng: parseFloatParam(r, "lng", -180, 180, &fields),
+	}
+	radius := parseFloatParam(r, "radius", 0, math.Pi*geo.EarthRadius, &fields)
+	limit := parseGeoLimit(r, &fields)
+	if len(fields) > 0 {
+		writeValidationError(w, "Invalid location query", fields)
+		return
+	}
+
+	s.serveGeoQuery(w, r, geoQuery{
+		region: geo.BoxAround(center, radius),
+		center: &center,
+		radius: radius,
+		column: r.URL.Query().Get("column"),
+		limit:  limit,
+	})
+}
+
+// handleRowsWithin returns the rows whose geopoint lies in a bounding box.
+// A box with minLng greater than maxLng crosses the antimeridian.
+func (s *Server) handleRowsWithin(w http.ResponseWriter, r *http.Request) {
+	var fields fieldErrors
+	box := geo.Box{
+		MinLat: parseFloatParam(r, "minLat", -90, 90, &fields),
+		MinLng: parseFloatParam(r, "minLng", -180, 180, &fields),
+		MaxLat: parseFloatParam(r, "maxLat", -90, 90, &fields),
+		MaxLng: parseFloatParam(r, "maxLng", -180, 180, &fields),
+	}
+	limit := parseGeoLimit(r, &fields)
+	if len(fields) == 0 && box.MinLat > box.MaxLat {
+		fields = append(fields, FieldError{Field: "minLat", Message: "must not be greater than maxLat"})
+	}
+	if len(fields) > 0 {
+		writeValidationError(w, "Invalid location query", fields)
+		return
+	}
+
+	s.serveGeoQuery(w, r, geoQuery{region: box, column: r.URL.Query().Get("column"), limit: limit})
+}
+
+// serveGeoQuery resolves a location query's table and column and writes the
+// matching rows
+func (s *Server) serveGeoQuery(w http.ResponseWriter, r *http.Request, q geoQuery) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Validate table exists and get column definitions
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+
+	def := latestTableDef(facts)
+	column, ok := geoColumn(def.Columns, q.column)
+	if !ok {
+		if q.column == "" {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Table '%s' has no geopoint column", table))
+		} else {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Column '%s' is not a geopoint column", q.column))
+		}
+		return
+	}
+	if column.Encrypted {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Encrypted column '%s' cannot be searched by location", column.Name))
+		return
+	}
+	q.column = column.Name
+
+	var rows []GeoRow
+	if tableOptionsOf(def).Source != nil {
+		// Virtual tables are not indexed, so their rows are scanned
+		rows, err = s.scanGeoRows(r.Context(), store, user, table, facts, q)
+	} else {
+		rows, err = s.indexedGeoRows(r.Context(), store, user, table, def, q)
+	}
+	if errors.Is(err, geo.ErrTooManyCells) {
+		writeError(w, http.StatusBadRequest, "Search area is too large")
+		return
+	}
+	if err != nil {
+		if proxiedTable(facts) {
+			writeVirtualError(w, err)
+			return
+		}
+		writeStoreError(w, err, "Failed to search rows")
+		return
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Distance != nil && *rows[i].Distance != *rows[j].Distance {
+			return *rows[i].Distance < *rows[j].Distance
+		}
+		return rows[i].ID < rows[j].ID
+	})
+	if len(rows) > q.limit {
+		rows = rows[:q.limit]
+	}
+	if rows == nil {
+		rows = []GeoRow{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"rows": rows})
+}
+
+// geoColumn returns the named geopoint column, or the table's first one
+// when name is empty
+func geoColumn(columns []dynamo.ColumnDefinition, name string) (dynamo.ColumnDefinition, bool) {
+	for _, col := range columns {
+		if col.DataType == geopointType && (name == "" || col.Name == name) {
+			return col, true
+		}
+	}
+	return dynamo.ColumnDefinition{}, false
+}
+
+// geoRow returns a row as a match of q, or false if it does not match
+func geoRow(q geoQuery, row RowData) (GeoRow, bool) {
+	p, ok := geoPointOf(row.Values[q.column])
+	if !ok || !q.matches(p) {
+		return GeoRow{}, false
+	}
+	match := GeoRow{RowData: row}
+	if q.center != nil {
+		d := geo.Distance(*q.center, p)
+		match.Distance = &d
+	}
+	return match, true
+}
+
+// indexedGeoRows finds matching rows through the geohash index
+func (s *Server) indexedGeoRows(ctx context.Context, store *db.StoreAdapter, user *auth.User, table string, def dynamo.Fact, q geoQuery) ([]GeoRow, error) {
+	var cells []string
+	var err error
+	for _, precision := range geoIndexPrecisions {
+		if cells, err = geo.Cover(q.region, precision, maxGeoCells); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	candidates := make(map[string]bool)
+	for _, cell := range cells {
+		entries, err := store.QueryByField(ctx, user.ID, geoIndexField(table, q.column, cell), time.Time{}, now)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range entries {
+			// Entries from before the table was (re)created belong to its old rows
+			if f.DataType != geoIndexDataType || f.Timestamp.Before(def.Timestamp) {
+				continue
+			}
+			raw, _ := f.Value.(string)
+			var entry geoIndexEntry
+			if json.Unmarshal([]byte(raw), &entry) != nil {
+				continue
+			}
+			if q.matches(geo.Point{Lat: entry.Lat, Lng: entry.Lng}) {
+				candidates[entry.Row] = true
+			}
+		}
+	}
+
+	rowStore, err := s.getRowStore(ctx, store, user, table)
+	if err != nil {
+		return nil, err
+	}
+	namespace := tableNamespace(user.ID, table)
+	var rows []GeoRow
+	for id := range candidates {
+		versions, err := rowStore.QueryByField(ctx, namespace, id, time.Time{}, now)
+		if err != nil {
+			return nil, err
+		}
+		var current *dynamo.Fact
+		for i := range versions {
+			if current == nil || versions[i].Timestamp.After(current.Timestamp) {
+				current = &versions[i]
+			}
+		}
+		if current == nil || current.DataType != "json" {
+			continue
+		}
+		values, ok := current.Value.(map[string]interface{})
+		if !ok || rowExpired(tableOptionsOf(def).TTLColumn, values, now) {
+			continue
+		}
+		if match, ok := geoRow(q, RowData{ID: id, Timestamp: current.Timestamp, Values: values}); ok {
+			rows = append(rows, match)
+		}
+	}
+	return rows, nil
+}
+
+// scanGeoRows finds matching rows by reading every row of a virtual table
+func (s *Server) scanGeoRows(ctx context.Context, store *db.StoreAdapter, user *auth.User, table string, facts []dynamo.Fact, q geoQuery) ([]GeoRow, error) {
+	all, err := s.plainRows(ctx, store, user, table, facts)
+	if err != nil {
+		return nil, err
+	}
+	var rows []GeoRow
+	for _, row := range all {
+		if match, ok := geoRow(q, row); ok {
+			rows = append(rows, match)
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/geo"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeopointValues(t *testing.T) {
+	assert.True(t, validateValueType(map[string]interface{}{"lat": 51.5, "lng": -0.12}, geopointType))
+	assert.False(t, validateValueType(map[string]interface{}{"lat": 91.0, "lng": 0.0}, geopointType))
+	assert.False(t, validateValueType(map[string]interface{}{"lat": 51.5}, geopointType))
+	assert.False(t, validateValueType(map[string]interface{}{"lat": "51.5", "lng": "0"}, geopointType))
+	assert.False(t, validateValueType([]interface{}{51.5, -0.12}, geopointType))
+}
+
+func TestGeoQueryMatches(t *testing.T) {
+	london := geo.Point{Lat: 51.5074, Lng: -0.1278}
+	q := geoQuery{region: geo.BoxAround(london, 20_000), center: &london, radius: 20_000, column: "where"}
+
+	near, ok := geoRow(q, RowData{ID: "greenwich", Values: map[string]interface{}{"where": map[string]interface{}{"lat": 51.4826, "lng": 0.0077}}})
+	require.True(t, ok)
+	assert.InDelta(t, 9_800, *near.Distance, 200)
+
+	_, ok = geoRow(q, RowData{ID: "oxford", Values: map[string]interface{}{"where": map[string]interface{}{"lat": 51.752, "lng": -1.2577}}})
+	assert.False(t, ok)
+	_, ok = geoRow(q, RowData{ID: "nowhere", Values: map[string]interface{}{"name": "x"}})
+	assert.False(t, ok)
+
+	// Corners of the bounding box are outside the radius
+	_, ok = geoRow(q, RowData{ID: "corner", Values: map[string]interface{}{"where": map[string]interface{}{"lat": q.region.MaxLat, "lng": q.region.MaxLng}}})
+	assert.False(t, ok)
+
+	box := geoQuery{region: geo.Box{MinLat: 51, MinLng: -2, MaxLat: 52, MaxLng: 0}, column: "where"}
+	within, ok := geoRow(box, RowData{ID: "oxford", Values: map[string]interface{}{"where": map[string]interface{}{"lat": 51.752, "lng": -1.2577}}})
+	require.True(t, ok)
+	assert.Nil(t, within.Distance)
+}
+
+func TestGeoColumn(t *testing.T) {
+	columns := []dynamo.ColumnDefinition{{Name: "name", DataType: "string"}, {Name: "home", DataType: geopointType}, {Name: "work", DataType: geopointType}}
+	col, ok := geoColumn(columns, "")
+	require.True(t, ok)
+	assert.Equal(t, "home", col.Name)
+	col, ok = geoColumn(columns, "work")
+	require.True(t, ok)
+	assert.Equal(t, "work", col.Name)
+	_, ok = geoColumn(columns, "name")
+	assert.False(t, ok)
+}
+
+func TestGeoQueriesValidateParameters(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard()})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "geo", 0)
+	require.NoError(t, err)
+
+	for _, path := range []string{
+		"/tables/places/rows/nearby?lat=51.5&lng=-0.12",
+		"/tables/places/rows/nearby?lat=100&lng=0&radius=10",
+		"/tables/places/rows/nearby?lat=0&lng=0&radius=10&limit=0",
+		"/tables/places/rows/within?minLat=52&minLng=-1&maxLat=51&maxLng=0",
+		"/tables/places/rows/within?minLat=51&maxLat=52",
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, path)
+	}
+}
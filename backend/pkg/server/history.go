@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+)
+
+// A history read of a long time range is split into windows queried in
+// parallel: historySegmentSpan is the shortest window, historySegments the
+// most windows of one read and historyConcurrency how many run at once
+const (
+	historySegmentSpan = time.Hour
+	historySegments    = 16
+	historyConcurrency = 4
+)
+
+// Types of the row events of a table's history
+const (
+	eventCreated = "created"
+	eventUpdated = "updated"
+	eventDeleted = "deleted"
+)
+
+// parseEventTypes parses the types parameter of a history request, a
+// comma-separated list of event types. An empty parameter selects them all
+// and returns nil.
+func parseEventTypes(param string) (map[string]bool, error) {
+	if param == "" {
+		return nil, nil
+	}
+	types := make(map[string]bool)
+	for _, t := range strings.Split(param, ",") {
+		switch t = strings.TrimSpace(t); t {
+		case eventCreated, eventUpdated, eventDeleted:
+			types[t] = true
+		default:
+			return nil, fmt.Errorf("unknown event type '%s' (expected created, updated or deleted)", t)
+		}
+	}
+	return types, nil
+}
+
+// rowStates records which rows are live while history is replayed in time
+// order
+type rowStates map[string]bool
+
+// eventType returns the type of a write of a row, a deletion or not, and
+// records the row's state after it. A write to a row that is not live,
+// because it never was or was deleted, creates it.
+func (live rowStates) eventType(id string, deleted bool) string {
+	was := live[id]
+	live[id] = !deleted
+	switch {
+	case deleted:
+		return eventDeleted
+	case was:
+		return eventUpdated
+	}
+	return eventCreated
+}
+
+// rowEvent returns the event of a row fact with its values, unsealed if the
+// row is a secret, or false for facts that are not rows
+func (s *Server) rowEvent(ctx context.Context, table string, f dynamo.Fact) (RowEvent, bool) {
+	ev := RowEvent{ID: f.FieldName, Timestamp: f.Timestamp, ActorUserID: f.ActorUserID, APIKeyID: f.APIKeyID, ValidTime: f.ValidTime, ClientDated: f.ClientDated}
+	switch {
+	case f.IsTombstone():
+	case f.DataType == "json":
+		vals, ok := f.Value.(map[string]interface{})
+		if !ok {
+			s.logger.WarnContext(ctx, "invalid row data format in history", "table", table, "row", f.FieldName)
+			return RowEvent{}, false
+		}
+		ev.Values = vals
+	case f.DataType == secretDataType:
+		vals, masked, err := s.revealSecret(ctx, f)
+		if err != nil {
+			s.logger.WarnContext(ctx, "reading secret row failed", "table", table, "row", f.FieldName, "error", err)
+			return RowEvent{}, false
+		}
+		ev.Values, ev.Masked = vals, masked
+	default:
+		return RowEvent{}, false
+	}
+	return ev, true
+}
+
+// historyWindows splits [start, end] into consecutive windows, each starting
+// where the previous one ends. An open start is read as one window.
+func historyWindows(start, end time.Time) [][2]time.Time {
+	n := int(end.Sub(start) / historySegmentSpan)
+	if start.IsZero() || n < 2 {
+		return [][2]time.Time{{start, end}}
+	}
+	n = min(n, historySegments)
+	step := end.Sub(start) / time.Duration(n)
+	windows := make([][2]time.Time, n)
+	for i := range windows {
+		windows[i] = [2]time.Time{start.Add(time.Duration(i) * step), start.Add(time.Duration(i+1) * step)}
+	}
+	windows[n-1][1] = end
+	return windows
+}
+
+// historyBatch is the outcome of querying one window
+type historyBatch struct {
+	facts []dynamo.Fact
+	err   error
+}
+
+// readHistory reads the facts of a namespace in [start, end] from the
+// namespace's own partition, querying its windows in parallel. Each window's
+// facts are passed to emit in time order as soon as every earlier window is
+// done; facts on a shared bound are emitted once.
+func readHistory(ctx context.Context, rowStore *db.StoreAdapter, namespace string, start, end time.Time, emit func([]dynamo.Fact) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	windows := historyWindows(start, end)
+	results := make([]chan historyBatch, len(windows))
+	for i := range results {
+		results[i] = make(chan historyBatch, 1)
+	}
+	go func() {
+		sem := make(chan struct{}, historyConcurrency)
+		for i, w := range windows {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				results[i] <- historyBatch{err: ctx.Err()}
+				continue
+			}
+			go func(i int, w [2]time.Time) {
+				defer func() { <-sem }()
+				facts, err := rowStore.QueryByNamespace(ctx, namespace, w[0], w[1])
+				results[i] <- historyBatch{facts: facts, err: err}
+			}(i, w)
+		}
+	}()
+
+	var bound map[string]bool
+	for i, w := range windows {
+		batch := <-results[i]
+		if batch.err != nil {
+			return batch.err
+		}
+		facts := make([]dynamo.Fact, 0, len(batch.facts))
+		next := make(map[string]bool)
+		for _, f := range batch.facts {
+			key := f.Timestamp.Format(time.RFC3339Nano) + "#" + f.ID
+			if bound[key] {
+				continue
+			}
+			if f.Timestamp.Equal(w[1]) {
+				next[key] = true
+			}
+			facts = append(facts, f)
+		}
+		bound = next
+		if err := emit(facts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryWindows(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Len(t, historyWindows(start, start.Add(90*time.Minute)), 1)
+	assert.Len(t, historyWindows(time.Time{}, start), 1, "an open start is one window")
+	assert.Len(t, historyWindows(start, start.Add(1000*time.Hour)), historySegments)
+
+	windows := historyWindows(start, start.Add(10*time.Hour+time.Second))
+	require.Len(t, windows, 10)
+	assert.Equal(t, start, windows[0][0])
+	assert.Equal(t, start.Add(10*time.Hour+time.Second), windows[9][1])
+	for i := 1; i < len(windows); i++ {
+		assert.Equal(t, windows[i-1][1], windows[i][0])
+	}
+}
+
+func TestReadHistory(t *testing.T) {
+	ctx := context.Background()
+	rowStore := db.NewStoreAdapter(db.NewMemoryStore())
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(8 * time.Hour)
+	windows := historyWindows(start, end)
+	require.Len(t, windows, 8)
+
+	// One fact per hour, on the bounds shared by two windows, plus a fact
+	// of another table
+	var want []string
+	for i := 0; i <= 8; i++ {
+		id := newID()
+		want = append(want, id)
+		require.NoError(t, rowStore.PutFact(ctx, dynamo.Fact{ID: id, Timestamp: start.Add(time.Duration(i) * time.Hour), Namespace: "u1/t", FieldName: "r", DataType: "json", Value: map[string]interface{}{"i": i}}))
+	}
+	require.NoError(t, rowStore.PutFact(ctx, dynamo.Fact{ID: newID(), Timestamp: start.Add(time.Minute), Namespace: "u1/other", FieldName: "r", DataType: "json"}))
+
+	var got []string
+	batches := 0
+	err := readHistory(ctx, rowStore, "u1/t", start, end, func(batch []dynamo.Fact) error {
+		batches++
+		for _, f := range batch {
+			got = append(got, f.ID)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, len(windows), batches)
+
+	// An emit error stops the read
+	stop := errors.New("stop")
+	err = readHistory(ctx, rowStore, "u1/t", start, end, func([]dynamo.Fact) error { return stop })
+	assert.ErrorIs(t, err, stop)
+}
+
+func TestHistoryEventTypes(t *testing.T) {
+	_, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n1", "values": {"v": 1}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	time.Sleep(time.Millisecond)
+	start := time.Now().UTC()
+
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodPut, "/tables/notes/rows/n1", `{"values": {"v": 2}}`},
+		{http.MethodPost, "/tables/notes/rows", `{"id": "n2", "values": {}}`},
+		{http.MethodDelete, "/tables/notes/rows/n1", ""},
+		{http.MethodPost, "/tables/notes/rows", `{"id": "n1", "values": {"v": 3}}`},
+	} {
+		rec = do(req.method, req.path, req.body)
+		require.Less(t, rec.Code, 300, rec.Body.String())
+	}
+
+	history := func(types string) []RowEvent {
+		t.Helper()
+		q := url.Values{"start": {start.Format(time.RFC3339Nano)}, "end": {time.Now().UTC().Add(time.Minute).Format(time.RFC3339Nano)}}
+		if types != "" {
+			q.Set("types", types)
+		}
+		rec := do(http.MethodGet, "/tables/notes/history?"+q.Encode(), "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp struct {
+			Events []RowEvent `json:"events"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp.Events
+	}
+
+	events := history("")
+	require.Len(t, events, 4)
+	var got []string
+	for _, e := range events {
+		got = append(got, e.ID+" "+e.Type)
+	}
+	assert.Equal(t, []string{"n1 updated", "n2 created", "n1 deleted", "n1 created"}, got, "the row written before the range is updated, not created")
+	assert.Equal(t, map[string]interface{}{}, events[1].Values, "a row without values is not a deletion")
+	assert.Nil(t, events[2].Values)
+
+	deletes := history("deleted")
+	require.Len(t, deletes, 1)
+	assert.Equal(t, "n1", deletes[0].ID)
+	assert.Len(t, history("created, deleted"), 3)
+
+	rec = do(http.MethodGet, "/tables/notes/history?start="+url.QueryEscape(start.Format(time.RFC3339))+"&end="+url.QueryEscape(time.Now().UTC().Format(time.RFC3339))+"&types=moved", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+)
+
+// deletedTableMarker is the value of a table definition fact that deletes the table
+const deletedTableMarker = "deleted"
+
+// latestTableDef returns the newest of a table's definition facts
+func latestTableDef(facts []dynamo.Fact) dynamo.Fact {
+	latest := facts[0]
+	for _, f := range facts[1:] {
+		if f.Timestamp.After(latest.Timestamp) {
+			latest = f
+		}
+	}
+	return latest
+}
+
+// tableLive reports whether a table's definition facts describe an existing
+// table. Facts of other types, found when the name is not a table's, do not.
+func tableLive(facts []dynamo.Fact) bool {
+	if len(facts) == 0 {
+		return false
+	}
+	def := latestTableDef(facts)
+	return def.DataType == tableDataType && def.Value != deletedTableMarker
+}
+
+// visibleTables returns the names of a user's tables the request may see,
+// in the order they were first defined, with the definition facts of each
+func (s *Server) visibleTables(ctx context.Context, store *db.StoreAdapter, userID string) ([]string, map[string][]dynamo.Fact, error) {
+	facts, err := store.QueryByTimeRange(ctx, time.Time{}, time.Now().UTC())
+	if err != nil {
+		return nil, nil, err
+	}
+	defs := make(map[string][]dynamo.Fact)
+	var names []string
+	for _, fact := range facts {
+		if fact.Namespace == userID && fact.DataType == tableDataType {
+			if _, seen := defs[fact.FieldName]; !seen {
+				names = append(names, fact.FieldName)
+			}
+			defs[fact.FieldName] = append(defs[fact.FieldName], fact)
+		}
+	}
+	visible := names[:0]
+	for _, name := range names {
+		if s.tableVisible(ctx, defs[name]) {
+			visible = append(visible, name)
+		}
+	}
+	return visible, defs, nil
+}
+
+// storageMode returns the effective storage mode of an account
+func (s *Server) storageMode(user *auth.User) string {
+	if user.StorageMode != "" {
+		return user.StorageMode
+	}
+	if s.config.StorageMode != "" {
+		return s.config.StorageMode
+	}
+	return db.StorageModeShared
+}
+
+// resolverFor returns the table resolver for an account
+func (s *Server) resolverFor(user *auth.User) db.TableResolver {
+	resolver, err := db.NewTableResolver(s.storageMode(user), s.config.TableName)
+	if err != nil {
+		// Modes are validated at startup and registration
+		return db.SharedTableResolver{TableName: s.config.TableName}
+	}
+	return resolver
+}
+
+// getRowStore returns the store holding the rows of a user table. In shared
+// mode that is the base store itself; in isolated mode it is the table's own
+// DynamoDB table.
+func (s *Server) getRowStore(ctx context.Context, base *db.StoreAdapter, user *auth.User, table string) (*db.StoreAdapter, error) {
+	resolver := s.resolverFor(user)
+	if !resolver.Isolated() {
+		return base, nil
+	}
+	return s.openStore(ctx, resolver.TableFor(user.ID, table), user.ID)
+}
+
+// handleDeleteTable deletes a table. In isolated mode the table's DynamoDB
+// table is dropped with it; in shared mode the rows stay in history but the
+// table no longer appears or accepts requests.
+func (s *Server) handleDeleteTable(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Validate table exists
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+
+	resolver := s.resolverFor(user)
+	if resolver.Isolated() {
+		// Forks read their inherited rows from this table's storage
+		forks, err := s.forksOf(r.Context(), store, user, table)
+		if err != nil {
+			writeStoreError(w, err, "Failed to look up forks")
+			return
+		}
+		if len(forks) > 0 {
+			sort.Strings(forks)
+			writeError(w, http.StatusConflict, fmt.Sprintf("Table '%s' has forks: %s", table, strings.Join(forks, ", ")))
+			return
+		}
+		rowStore, err := s.getRowStore(r.Context(), store, user, table)
+		if err != nil {
+			writeStoreError(w, err, "Failed to initialize table storage")
+			return
+		}
+		if err := rowStore.DeleteTable(r.Context()); err != nil {
+			writeStoreError(w, err, "Failed to delete table storage")
+			return
+		}
+	}
+
+	fact := dynamo.Fact{
+		ID:        newID(),
+		Timestamp: time.Now().UTC(),
+		Namespace: user.ID,
+		FieldName: table,
+		DataType:  tableDataType,
+		Value:     deletedTableMarker,
+	}
+	if err := store.PutFact(r.Context(), fact); err != nil {
+		writeStoreError(w, err, "Failed to delete table")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
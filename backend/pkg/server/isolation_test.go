@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableLive(t *testing.T) {
+	now := time.Now().UTC()
+	created := dynamo.Fact{ID: "1", Timestamp: now, FieldName: "tasks", DataType: "table", Value: ""}
+	deleted := dynamo.Fact{ID: "2", Timestamp: now.Add(time.Second), FieldName: "tasks", DataType: "table", Value: deletedTableMarker}
+	recreated := dynamo.Fact{ID: "3", Timestamp: now.Add(2 * time.Second), FieldName: "tasks", DataType: "table", Value: ""}
+
+	assert.False(t, tableLive(nil))
+	assert.True(t, tableLive([]dynamo.Fact{created}))
+	assert.False(t, tableLive([]dynamo.Fact{deleted, created}))
+	assert.True(t, tableLive([]dynamo.Fact{created, recreated, deleted}))
+	assert.Equal(t, "3", latestTableDef([]dynamo.Fact{created, recreated, deleted}).ID)
+}
+
+func TestRegisterStorageMode(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard()})
+	require.NoError(t, err)
+
+	register := func(body map[string]string) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/register", bytes.NewReader(data)))
+		return rec
+	}
+
+	rec := register(map[string]string{"username": "a", "email": "a@example.com", "password": "pw", "storageMode": "sharded"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = register(map[string]string{"username": "b", "email": "b@example.com", "password": "pw", "storageMode": "isolated"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var resp map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "isolated", resp["storageMode"])
+
+	rec = register(map[string]string{"username": "c", "email": "c@example.com", "password": "pw"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "shared", resp["storageMode"])
+}
+
+func TestNewServerRejectsUnknownStorageMode(t *testing.T) {
+	_, err := NewServer(Config{TableName: "Facts", StorageMode: "sharded", Logger: logging.Discard()})
+	assert.Error(t, err)
+}
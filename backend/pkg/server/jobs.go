@@ -0,0 +1,366 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/replay"
+)
+
+const (
+	// jobDataType marks the facts holding the state of a job
+	jobDataType = "job"
+
+	// jobQueueSize is the number of jobs that may wait for a worker
+	jobQueueSize = 64
+	// jobWorkers is the number of jobs run at once
+	jobWorkers = 2
+	// jobSaveInterval is how often the progress of a running job is stored
+	jobSaveInterval = time.Second
+)
+
+// Job states. Jobs end succeeded, failed or cancelled.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// Job is an operation run in the background, started by a request with
+// ?async=true
+type Job struct {
+	ID string `json:"id"`
+	// Operation names what the job does, such as "rollback"
+	Operation string       `json:"operation"`
+	Table     string       `json:"table,omitempty"`
+	Status    string       `json:"status"`
+	Progress  *JobProgress `json:"progress,omitempty"`
+	// StatusCode and Result are the status and body the operation would
+	// have answered without ?async=true; Result is only kept on success
+	StatusCode int             `json:"statusCode,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	StartedAt  *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+}
+
+// JobProgress counts the units of work, such as rows, a job has done
+type JobProgress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// finished reports whether a job has ended
+func (j Job) finished() bool {
+	return j.Status == jobSucceeded || j.Status == jobFailed || j.Status == jobCancelled
+}
+
+// jobField returns the field, in the user's namespace, holding the states
+// of a job
+func jobField(id string) string {
+	return "jobs/" + escapeName(id)
+}
+
+// queuedJob is a job waiting for a worker with the request it runs
+type queuedJob struct {
+	job     Job
+	userID  string
+	store   *db.StoreAdapter
+	req     *http.Request
+	handler http.HandlerFunc
+	cancel  context.CancelFunc
+}
+
+// running returns the job as a worker starts it
+func (q *queuedJob) running(s *Server) *runningJob {
+	return &runningJob{s: s, job: q.job, userID: q.userID, store: q.store}
+}
+
+// jobKey is the context key of the running job
+type jobKey struct{}
+
+// runningJob is the job a worker is running, whose progress is stored as
+// the operation reports it
+type runningJob struct {
+	s      *Server
+	job    Job
+	userID string
+	store  *db.StoreAdapter
+	saved  time.Time
+}
+
+// reportProgress records how much of its work the job running under ctx
+// has done. Outside jobs it does nothing.
+func reportProgress(ctx context.Context, done, total int) {
+	run, ok := ctx.Value(jobKey{}).(*runningJob)
+	if !ok {
+		return
+	}
+	run.job.Progress = &JobProgress{Done: done, Total: total}
+	if time.Since(run.saved) >= jobSaveInterval {
+		run.save(ctx)
+	}
+}
+
+// save stores the job's current state, even once its context is done
+func (run *runningJob) save(ctx context.Context) {
+	run.saved = time.Now()
+	if err := run.s.saveJob(context.WithoutCancel(ctx), run.store, run.userID, run.job); err != nil {
+		run.s.logger.ErrorContext(ctx, "storing job state failed", "job", run.job.ID, "error", err)
+	}
+}
+
+// saveJob stores a new state of a job in its user's namespace
+func (s *Server) saveJob(ctx context.Context, store *db.StoreAdapter, userID string, job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return store.PutFact(ctx, dynamo.Fact{
+		ID:        newID(),
+		Timestamp: time.Now().UTC(),
+		Namespace: userID,
+		FieldName: jobField(job.ID),
+		DataType:  jobDataType,
+		Value:     string(data),
+	})
+}
+
+// loadJob returns the latest stored state of a user's job
+func (s *Server) loadJob(ctx context.Context, store *db.StoreAdapter, userID, id string) (Job, bool, error) {
+	facts, err := store.QueryByField(ctx, userID, jobField(id), time.Time{}, time.Now().UTC())
+	if err != nil {
+		return Job{}, false, err
+	}
+	sort.Slice(facts, func(i, j int) bool { return facts[i].Timestamp.After(facts[j].Timestamp) })
+	for _, f := range facts {
+		if f.DataType != jobDataType {
+			continue
+		}
+		var job Job
+		raw, _ := f.Value.(string)
+		if err := json.Unmarshal([]byte(raw), &job); err != nil {
+			return Job{}, false, fmt.Errorf("invalid job state: %w", err)
+		}
+		return job, true, nil
+	}
+	return Job{}, false, nil
+}
+
+// async runs an operation as a background job when it is requested with
+// ?async=true, answering at once with 202 and the queued job. Without it
+// the operation is run as usual.
+func (s *Server) async(operation string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("async") != "true" {
+			h(w, r)
+			return
+		}
+		user, ok := auth.UserFromContext(r.Context())
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "User not found in context")
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxErr.Limit))
+				return
+			}
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Failed to read request body: %v", err))
+			return
+		}
+		store, err := s.getStoreForUser(r.Context(), user.ID)
+		if err != nil {
+			writeStoreError(w, err, "Failed to initialize storage")
+			return
+		}
+
+		// The job keeps the request's user and key but not its deadline,
+		// and ends when it is cancelled or the server stops
+		ctx, cancel := context.WithCancel(replay.WithRecorder(context.WithoutCancel(r.Context()), nil))
+		stop := context.AfterFunc(s.background, cancel)
+		q := &queuedJob{
+			job:     Job{ID: newID(), Operation: operation, Table: r.PathValue("table"), Status: jobQueued, CreatedAt: time.Now().UTC()},
+			userID:  user.ID,
+			store:   store,
+			req:     r.Clone(ctx),
+			handler: h,
+			cancel: func() {
+				stop()
+				cancel()
+			},
+		}
+		q.req.Body = io.NopCloser(bytes.NewReader(body))
+
+		if err := s.saveJob(r.Context(), store, user.ID, q.job); err != nil {
+			q.cancel()
+			writeStoreError(w, err, "Failed to create job")
+			return
+		}
+		s.jobMu.Lock()
+		s.jobCancels[q.job.ID] = q.cancel
+		s.jobMu.Unlock()
+		select {
+		case s.jobQueue <- q:
+		default:
+			s.endJob(q, q.running(s), jobFailed, http.StatusServiceUnavailable, "Job queue is full")
+			w.Header().Set("Retry-After", "60")
+			writeError(w, http.StatusServiceUnavailable, "Too many jobs are queued; try again later")
+			return
+		}
+
+		w.Header().Set("Location", apiVersion+"/jobs/"+q.job.ID)
+		writeJSON(w, http.StatusAccepted, q.job)
+	}
+}
+
+// runJobWorker runs queued jobs until ctx is cancelled, then ends the jobs
+// still queued as cancelled
+func (s *Server) runJobWorker(ctx context.Context) {
+	for {
+		select {
+		case q := <-s.jobQueue:
+			s.runJob(q)
+		case <-ctx.Done():
+			for {
+				select {
+				case q := <-s.jobQueue:
+					s.runJob(q)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// runJob runs one job's operation, storing its states as it starts and ends
+func (s *Server) runJob(q *queuedJob) {
+	ctx := q.req.Context()
+	run := q.running(s)
+	if ctx.Err() != nil || s.background.Err() != nil {
+		s.endJob(q, run, jobCancelled, 0, "Job was cancelled before it started")
+		return
+	}
+	started := time.Now().UTC()
+	run.job.Status, run.job.StartedAt = jobRunning, &started
+	run.save(ctx)
+
+	rec := httptest.NewRecorder()
+	q.handler(rec, q.req.WithContext(context.WithValue(ctx, jobKey{}, run)))
+
+	switch {
+	case ctx.Err() != nil:
+		s.endJob(q, run, jobCancelled, rec.Code, "Job was cancelled")
+	case rec.Code >= http.StatusBadRequest:
+		var body struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(rec.Body.Bytes(), &body)
+		if body.Error == "" {
+			body.Error = http.StatusText(rec.Code)
+		}
+		s.endJob(q, run, jobFailed, rec.Code, body.Error)
+	default:
+		if json.Valid(rec.Body.Bytes()) {
+			run.job.Result = json.RawMessage(rec.Body.Bytes())
+		}
+		s.endJob(q, run, jobSucceeded, rec.Code, "")
+	}
+}
+
+// endJob stores the final state of a job and forgets its cancel function
+func (s *Server) endJob(q *queuedJob, run *runningJob, status string, code int, message string) {
+	ctx := q.req.Context()
+	finished := time.Now().UTC()
+	run.job.Status, run.job.StatusCode, run.job.Error, run.job.FinishedAt = status, code, message, &finished
+	run.save(ctx)
+	s.jobMu.Lock()
+	delete(s.jobCancels, q.job.ID)
+	s.jobMu.Unlock()
+	q.cancel()
+	s.logger.InfoContext(ctx, "job finished", "job", q.job.ID, "operation", q.job.Operation, "status", status)
+}
+
+// handleGetJob reports the state of a job
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	id := r.PathValue("id")
+
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	job, ok, err := s.loadJob(r.Context(), store, user.ID, id)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load job")
+		return
+	}
+	if !ok || job.Table != "" && !keyAllowsTable(r, job.Table) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Job '%s' not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleCancelJob cancels a queued or running job. Its operation stops at
+// its next store call and the job ends cancelled; work already written is
+// kept.
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	id := r.PathValue("id")
+
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	job, ok, err := s.loadJob(r.Context(), store, user.ID, id)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load job")
+		return
+	}
+	if !ok || job.Table != "" && !keyAllowsTable(r, job.Table) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Job '%s' not found", id))
+		return
+	}
+	if job.finished() {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Job '%s' has already %s", id, job.Status))
+		return
+	}
+
+	s.jobMu.Lock()
+	cancel, ok := s.jobCancels[id]
+	s.jobMu.Unlock()
+	if !ok {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Job '%s' is not running on this server", id))
+		return
+	}
+	cancel()
+	writeJSON(w, http.StatusAccepted, job)
+}
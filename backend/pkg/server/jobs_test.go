@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// queueJob starts an operation with ?async=true and returns its job
+func queueJob(t *testing.T, do func(method, path, body string) *httptest.ResponseRecorder, path string) Job {
+	t.Helper()
+	rec := do(http.MethodPost, path, "")
+	require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+	var job Job
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &job))
+	assert.Equal(t, "/v1/jobs/"+job.ID, rec.Header().Get("Location"))
+	assert.Equal(t, jobQueued, job.Status)
+	return job
+}
+
+// awaitJob polls a job until it has finished
+func awaitJob(t *testing.T, do func(method, path, body string) *httptest.ResponseRecorder, id string) Job {
+	t.Helper()
+	var job Job
+	require.Eventually(t, func() bool {
+		rec := do(http.MethodGet, "/v1/jobs/"+id, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &job))
+		return job.finished()
+	}, 5*time.Second, 5*time.Millisecond)
+	return job
+}
+
+func TestAsyncJobs(t *testing.T) {
+	srv, do := memoryServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.runJobWorker(ctx)
+
+	rec := do(http.MethodPost, "/tables", `{"name": "notes"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	time.Sleep(5 * time.Millisecond)
+	checkpoint := url.QueryEscape(time.Now().UTC().Format(time.RFC3339Nano))
+	time.Sleep(5 * time.Millisecond)
+	for _, body := range []string{`{"id": "n1", "values": {"v": 1}}`, `{"id": "n2", "values": {"v": 2}}`} {
+		rec = do(http.MethodPost, "/tables/notes/rows", body)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	queued := queueJob(t, do, "/v1/tables/notes/rollback?async=true&to="+checkpoint)
+	assert.Equal(t, "rollback", queued.Operation)
+	assert.Equal(t, "notes", queued.Table)
+	job := awaitJob(t, do, queued.ID)
+	assert.Equal(t, jobSucceeded, job.Status)
+	assert.Equal(t, http.StatusOK, job.StatusCode)
+	assert.Equal(t, &JobProgress{Done: 2, Total: 2}, job.Progress)
+	require.NotNil(t, job.StartedAt)
+	require.NotNil(t, job.FinishedAt)
+	var result RollbackResult
+	require.NoError(t, json.Unmarshal(job.Result, &result))
+	assert.Equal(t, []RowChange{{ID: "n1", Change: "delete"}, {ID: "n2", Change: "delete"}}, result.Rows)
+	assert.Empty(t, snapshotValues(t, do, "/tables/notes/snapshot"))
+
+	// Operations failing in the background fail their job
+	job = awaitJob(t, do, queueJob(t, do, "/v1/tables/missing/rollback?async=true&to="+checkpoint).ID)
+	assert.Equal(t, jobFailed, job.Status)
+	assert.Equal(t, http.StatusNotFound, job.StatusCode)
+	assert.Equal(t, "Table 'missing' not found", job.Error)
+	assert.Empty(t, job.Result)
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/v1/jobs/nope", "").Code)
+}
+
+func TestCancelJob(t *testing.T) {
+	srv, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	to := url.QueryEscape(time.Now().UTC().Format(time.RFC3339Nano))
+
+	// Without a worker the job stays queued until it is cancelled
+	job := queueJob(t, do, "/v1/tables/notes/rollback?async=true&to="+to)
+	rec = do(http.MethodPost, "/v1/jobs/"+job.ID+"/cancel", "")
+	require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.runJobWorker(ctx)
+	job = awaitJob(t, do, job.ID)
+	assert.Equal(t, jobCancelled, job.Status)
+	assert.Nil(t, job.StartedAt, "the cancelled job never ran")
+
+	rec = do(http.MethodPost, "/v1/jobs/"+job.ID+"/cancel", "")
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), "already cancelled")
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/v1/jobs/nope/cancel", "").Code)
+
+	// Stopping the server cancels its jobs
+	cancel()
+	srv.cancel()
+	job = queueJob(t, do, "/v1/tables/notes/rollback?async=true&to="+to)
+	go srv.runJobWorker(srv.background)
+	assert.Equal(t, jobCancelled, awaitJob(t, do, job.ID).Status)
+}
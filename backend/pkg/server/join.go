@@ -0,0 +1,194 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/query"
+)
+
+// joinRowID is the join column that stands for a row's ID, which reference
+// columns hold
+const joinRowID = "id"
+
+// joinedRow is a row of a join. Right is nil for a left row a left join
+// found no match for.
+type joinedRow struct {
+	Left  RowData  `json:"left"`
+	Right *RowData `json:"right"`
+}
+
+// joinColumn checks that a join column exists in a table's columns. Tables
+// without columns take any column.
+func joinColumn(columns []dynamo.ColumnDefinition, name string) bool {
+	if name == joinRowID || len(columns) == 0 {
+		return true
+	}
+	for _, col := range columns {
+		if col.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// inferJoin picks the join columns of two tables from their reference
+// columns: a column of the left table referencing the right one, or else a
+// column of the right table referencing the left one
+func inferJoin(left, right string, leftCols, rightCols []dynamo.ColumnDefinition) (string, string, bool) {
+	for _, col := range leftCols {
+		if col.DataType == referenceType && col.References == right {
+			return col.Name, joinRowID, true
+		}
+	}
+	for _, col := range rightCols {
+		if col.DataType == referenceType && col.References == left {
+			return joinRowID, col.Name, true
+		}
+	}
+	return "", "", false
+}
+
+// joinKeys returns the value of a join column in each row
+func joinKeys(rows []RowData, column string) []interface{} {
+	keys := make([]interface{}, len(rows))
+	for i, row := range rows {
+		if column == joinRowID {
+			keys[i] = row.ID
+		} else {
+			keys[i] = row.Values[column]
+		}
+	}
+	return keys
+}
+
+// handleJoin joins the snapshots of two tables at one point in time, the
+// current time or the "at" query parameter. "on" names the columns to match
+// as leftCol=rightCol, where "id" stands for the row ID; without it the
+// tables are joined through a reference column between them.
+func (s *Server) handleJoin(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	q := r.URL.Query()
+	left, right := q.Get("left"), q.Get("right")
+	var fields fieldErrors
+	if left == "" {
+		fields = append(fields, FieldError{Field: "left", Message: "is required"})
+	}
+	if right == "" {
+		fields = append(fields, FieldError{Field: "right", Message: "is required"})
+	}
+	var leftCol, rightCol string
+	if v := q.Get("on"); v != "" {
+		var found bool
+		leftCol, rightCol, found = strings.Cut(v, "=")
+		leftCol, rightCol = strings.TrimSpace(leftCol), strings.TrimSpace(rightCol)
+		if !found || leftCol == "" || rightCol == "" {
+			fields = append(fields, FieldError{Field: "on", Message: "must be leftColumn=rightColumn"})
+		}
+	}
+	outer := false
+	switch q.Get("type") {
+	case "", "inner":
+	case "left":
+		outer = true
+	default:
+		fields = append(fields, FieldError{Field: "type", Message: "must be inner or left"})
+	}
+	var asOf time.Time
+	if v := q.Get("at"); v != "" {
+		var err error
+		if asOf, err = time.Parse(time.RFC3339, v); err != nil {
+			fields = append(fields, FieldError{Field: "at", Message: "must be an RFC3339 time"})
+		}
+		asOf = asOf.UTC()
+	}
+	if len(fields) > 0 {
+		writeValidationError(w, "Invalid join", fields)
+		return
+	}
+
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	var defs [2][]dynamo.Fact
+	for i, table := range []string{left, right} {
+		facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+		if err != nil {
+			writeStoreError(w, err, "Failed to look up table")
+			return
+		}
+		if !s.tableVisible(r.Context(), facts) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+			return
+		}
+		if tableOptionsOf(latestTableDef(facts)).Type == tableTypeSecrets {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Rows of secrets table '%s' are encrypted and cannot be joined", table))
+			return
+		}
+		defs[i] = facts
+	}
+	leftCols, rightCols := latestTableDef(defs[0]).Columns, latestTableDef(defs[1]).Columns
+
+	if leftCol == "" {
+		if leftCol, rightCol, ok = inferJoin(left, right, leftCols, rightCols); !ok {
+			writeValidationError(w, "Invalid join", []FieldError{{Field: "on", Message: fmt.Sprintf("is required: no reference column joins '%s' and '%s'", left, right)}})
+			return
+		}
+	}
+	if !joinColumn(leftCols, leftCol) {
+		fields = append(fields, FieldError{Field: "on", Message: fmt.Sprintf("unknown column '%s' of table '%s'", leftCol, left)})
+	}
+	if !joinColumn(rightCols, rightCol) {
+		fields = append(fields, FieldError{Field: "on", Message: fmt.Sprintf("unknown column '%s' of table '%s'", rightCol, right)})
+	}
+	if len(fields) > 0 {
+		writeValidationError(w, "Invalid join", fields)
+		return
+	}
+
+	// Both snapshots are read at the same time. Proxied tables have no
+	// history and can only be read as they are now.
+	at := asOf
+	if at.IsZero() {
+		at = time.Now().UTC()
+	}
+	var rows [2][]RowData
+	for i, table := range []string{left, right} {
+		t := at
+		if asOf.IsZero() && proxiedTable(defs[i]) {
+			t = time.Time{}
+		}
+		if rows[i], ok = s.tableRowsAt(w, r, store, user, table, defs[i], t); !ok {
+			return
+		}
+	}
+	leftRows, rightRows := rows[0], rows[1]
+	pairs, err := query.Join(joinKeys(leftRows, leftCol), joinKeys(rightRows, rightCol), outer)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Failed to join: %v", err))
+		return
+	}
+	joined := make([]joinedRow, len(pairs))
+	for i, p := range pairs {
+		joined[i].Left = leftRows[p.Left]
+		if p.Right >= 0 {
+			joined[i].Right = &rightRows[p.Right]
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"at":   at,
+		"on":   map[string]string{"left": leftCol, "right": rightCol},
+		"rows": joined,
+	})
+}
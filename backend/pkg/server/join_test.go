@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoin(t *testing.T) {
+	_, do := memoryServer(t)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "customers", "columns": [
+		{"name": "name", "dataType": "string"},
+		{"name": "region", "dataType": "string", "nullable": true}
+	]}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "orders", "columns": [
+		{"name": "customer", "dataType": "reference", "references": "customers"},
+		{"name": "amount", "dataType": "number"}
+	]}`).Code)
+	for _, body := range []string{
+		`{"id": "c1", "values": {"name": "Ada", "region": "eu"}}`,
+		`{"id": "c2", "values": {"name": "Bob", "region": "us"}}`,
+		`{"id": "c3", "values": {"name": "Cy", "region": "eu"}}`,
+	} {
+		require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/customers/rows", body).Code)
+	}
+	for _, body := range []string{
+		`{"id": "o1", "values": {"customer": "c1", "amount": 10}}`,
+		`{"id": "o2", "values": {"customer": "c2", "amount": 20}}`,
+	} {
+		require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/orders/rows", body).Code)
+	}
+	before := time.Now().UTC()
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/orders/rows", `{"id": "o3", "values": {"customer": "c1", "amount": 30}}`).Code)
+	require.Equal(t, http.StatusOK, do(http.MethodPut, "/tables/customers/rows/c1", `{"values": {"name": "Ada L", "region": "eu"}}`).Code)
+
+	type joined struct {
+		Left  RowData  `json:"left"`
+		Right *RowData `json:"right"`
+	}
+	join := func(params string) ([]joined, map[string]string) {
+		t.Helper()
+		rec := do(http.MethodGet, "/join?"+params, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp struct {
+			On   map[string]string `json:"on"`
+			Rows []joined          `json:"rows"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp.Rows, resp.On
+	}
+
+	rows, on := join("left=orders&right=customers")
+	assert.Equal(t, map[string]string{"left": "customer", "right": "id"}, on, "joined through the reference column")
+	require.Len(t, rows, 3)
+	for _, row := range rows {
+		require.NotNil(t, row.Right)
+		assert.Equal(t, row.Left.Values["customer"], row.Right.ID)
+	}
+
+	rows, on = join("left=customers&right=orders&type=left")
+	assert.Equal(t, map[string]string{"left": "id", "right": "customer"}, on)
+	require.Len(t, rows, 4)
+	var unmatched []string
+	for _, row := range rows {
+		if row.Right == nil {
+			unmatched = append(unmatched, row.Left.ID)
+		}
+	}
+	assert.Equal(t, []string{"c3"}, unmatched, "a left join keeps customers without orders")
+
+	rows, _ = join("left=orders&right=customers&at=" + before.Format(time.RFC3339Nano))
+	require.Len(t, rows, 2, "both tables are read at the same time")
+	for _, row := range rows {
+		assert.NotEqual(t, "o3", row.Left.ID)
+		if row.Right.ID == "c1" {
+			assert.Equal(t, "Ada", row.Right.Values["name"], "the right table is read as of the time too")
+		}
+	}
+
+	rows, _ = join("left=customers&right=customers&on=region=region")
+	assert.Len(t, rows, 5, "each eu customer matches both eu customers")
+
+	for _, q := range []string{
+		"right=customers",
+		"left=orders&right=customers&on=customer",
+		"left=orders&right=customers&on=missing=id",
+		"left=orders&right=customers&type=full",
+		"left=customers&right=customers",
+		"left=orders&right=customers&at=yesterday",
+	} {
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/join?"+q, "").Code, q)
+	}
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/join?left=orders&right=missing", "").Code)
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"github.com/elibdev/notably/pkg/lease"
+)
+
+// leaseTTL is how long a background task's lease outlives the last
+// heartbeat of the replica running it
+const leaseTTL = 30 * time.Second
+
+// newLocker returns the locker of the server's background leases, kept in
+// the base table so every replica sharing it competes for the same leases
+func (s *Server) newLocker(ctx context.Context) (*lease.Locker, error) {
+	if s.config.InMemory {
+		return lease.New(lease.NewMemoryStore(), lease.NewOwner(), leaseTTL), nil
+	}
+	cfg, err := s.dynamoConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	store := lease.NewDynamoStore(dynamodb.NewFromConfig(cfg), s.config.TableName)
+	return lease.New(store, lease.NewOwner(), leaseTTL), nil
+}
+
+// leased runs a periodic background task under the lease named name, so
+// one replica at a time runs it. Replicas finding the lease held skip the
+// run.
+func (s *Server) leased(ctx context.Context, name string, task func(ctx context.Context) error) error {
+	ran, err := s.leases.Do(ctx, name, task)
+	if !ran && err == nil {
+		s.logger.DebugContext(ctx, "background task skipped, lease held by another replica", "lease", name)
+	}
+	return err
+}
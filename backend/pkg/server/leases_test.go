@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/pkg/lease"
+)
+
+func TestLeasedBackgroundTasks(t *testing.T) {
+	ctx := context.Background()
+	srv, _ := memoryServer(t)
+	store := lease.NewMemoryStore()
+	srv.leases = lease.New(store, "replica-1", time.Minute)
+
+	runs := 0
+	task := func(ctx context.Context) error {
+		runs++
+		return nil
+	}
+	require.NoError(t, srv.leased(ctx, "retention", task))
+	assert.Equal(t, 1, runs)
+
+	// Another replica holding the lease keeps this one from running the task
+	now := time.Now()
+	require.NoError(t, store.Acquire(ctx, "retention", "replica-2", now, now.Add(time.Minute)))
+	require.NoError(t, srv.leased(ctx, "retention", task))
+	assert.Equal(t, 1, runs)
+	require.NoError(t, srv.leased(ctx, "expiry", task))
+	assert.Equal(t, 2, runs)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/elibdev/notably/pkg/auth"
+)
+
+// lockoutPolicyFromEnv reads the login lockout settings from the
+// environment, starting from auth.DefaultLockoutPolicy
+func lockoutPolicyFromEnv() auth.LockoutPolicy {
+	def := auth.DefaultLockoutPolicy()
+	return auth.LockoutPolicy{
+		MaxFailures:   envInt("NOTABLY_LOGIN_MAX_FAILURES", def.MaxFailures),
+		IPMaxFailures: envInt("NOTABLY_LOGIN_IP_MAX_FAILURES", def.IPMaxFailures),
+		LockDuration:  envDuration("NOTABLY_LOGIN_LOCK_DURATION", def.LockDuration),
+		BaseDelay:     envDuration("NOTABLY_LOGIN_BASE_DELAY", def.BaseDelay),
+	}
+}
+
+// clientIP returns the address login failures are counted against. Proxy
+// headers are ignored since clients could rotate them freely.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// writeLoginError answers a failed login. Refused attempts get 423 for a
+// locked account or 429 while throttled, with Retry-After and the time
+// attempts resume.
+func (s *Server) writeLoginError(w http.ResponseWriter, err error) {
+	var lockout *auth.LockoutError
+	switch {
+	case errors.Is(err, auth.ErrEmailNotVerified):
+		writeError(w, http.StatusForbidden, "Email address not verified")
+	case errors.As(err, &lockout):
+		status, reason, message := http.StatusTooManyRequests, "throttled", "Too many login attempts"
+		if errors.Is(err, auth.ErrAccountLocked) {
+			status, reason, message = http.StatusLocked, "locked", "Account is temporarily locked after too many failed logins"
+		}
+		s.metrics.ObserveAuthFailure(reason)
+		wait := time.Until(lockout.RetryAfter)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeJSON(w, status, map[string]interface{}{
+			"error":      message,
+			"retryAfter": lockout.RetryAfter,
+		})
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		s.metrics.ObserveAuthFailure("invalid_credentials")
+		writeError(w, http.StatusUnauthorized, "Invalid credentials")
+	default:
+		writeError(w, http.StatusInternalServerError, "Failed to log in")
+	}
+}
+
+// handleLockoutStatus reports the failed logins and lock of the account
+func (s *Server) handleLockoutStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	attempts, err := s.authenticator.LockoutStatus(r.Context(), user.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to read lockout status")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"locked":      attempts.Locked(time.Now()),
+		"failures":    attempts.Failures,
+		"lastFailure": attempts.LastFailure,
+		"lockedUntil": attempts.LockedUntil,
+	})
+}
+
+// handleUnlock clears the failed logins and lock of the account
+func (s *Server) handleUnlock(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	if err := s.authenticator.Unlock(r.Context(), user.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to unlock account")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
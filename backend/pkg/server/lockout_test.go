@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginLockout(t *testing.T) {
+	srv, err := NewServer(Config{
+		TableName: "Facts", Logger: logging.Discard(), InMemory: true,
+		Lockout: auth.LockoutPolicy{MaxFailures: 3, IPMaxFailures: 10, LockDuration: time.Minute},
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+	require.NoError(t, err)
+
+	loginAs := func(username, password, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/auth/login", strings.NewReader(`{"username": "`+username+`", "password": "`+password+`"}`))
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	login := func(password, ip string) *httptest.ResponseRecorder { return loginAs("alice", password, ip) }
+	admin := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/v1/auth/lockout", nil)
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login("wrong", "192.0.2.1").Code)
+	}
+	rec := admin(http.MethodGet)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"failures":2`)
+	assert.Contains(t, rec.Body.String(), `"locked":false`)
+
+	// The third failure locks the account, even for the right password
+	// from another address
+	assert.Equal(t, http.StatusUnauthorized, login("wrong", "192.0.2.1").Code)
+	rec = login("pw", "192.0.2.2")
+	assert.Equal(t, http.StatusLocked, rec.Code, rec.Body.String())
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	assert.Contains(t, admin(http.MethodGet).Body.String(), `"locked":true`)
+
+	assert.Equal(t, http.StatusNoContent, admin(http.MethodDelete).Code)
+	assert.Equal(t, http.StatusOK, login("pw", "192.0.2.2").Code)
+
+	// Names without an account lock the same way, so a locked account
+	// cannot be told apart from a made-up one
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusUnauthorized, loginAs("mallory", "pw", "192.0.2.3").Code)
+	}
+	rec = loginAs("Mallory", "pw", "192.0.2.4")
+	assert.Equal(t, http.StatusLocked, rec.Code, rec.Body.String())
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, login("pw", "192.0.2.4").Code)
+}
+
+func TestLoginThrottling(t *testing.T) {
+	srv, err := NewServer(Config{
+		TableName: "Facts", Logger: logging.Discard(), InMemory: true,
+		Lockout: auth.LockoutPolicy{IPMaxFailures: 2, LockDuration: time.Minute, BaseDelay: time.Minute},
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+	_, err = srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+
+	// The first failure is free; after the second the account must wait
+	_, err = srv.authenticator.LoginUser(ctx, "alice", "wrong", "")
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	_, err = srv.authenticator.LoginUser(ctx, "alice", "wrong", "")
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	_, err = srv.authenticator.LoginUser(ctx, "alice", "pw", "")
+	var lockout *auth.LockoutError
+	require.ErrorAs(t, err, &lockout)
+	assert.ErrorIs(t, err, auth.ErrLoginThrottled)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), lockout.RetryAfter, 2*time.Second)
+
+	// Guessing across unknown accounts blocks the address
+	for _, name := range []string{"bob", "carol"} {
+		_, err = srv.authenticator.LoginUser(ctx, name, "pw", "192.0.2.9")
+		assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	}
+	_, err = srv.authenticator.LoginUser(ctx, "dave", "pw", "192.0.2.9")
+	assert.ErrorIs(t, err, auth.ErrLoginThrottled)
+}
+
+func TestLoginAttemptsPruned(t *testing.T) {
+	ctx := context.Background()
+	store := auth.NewInMemoryUserStore()
+	now := time.Now().UTC()
+	attempts := func(key string) *auth.LoginAttempts {
+		a, err := store.GetLoginAttempts(ctx, key)
+		require.NoError(t, err)
+		return a
+	}
+
+	require.NoError(t, store.PutLoginAttempts(ctx, &auth.LoginAttempts{Key: "name:mallory", Failures: 1, LastFailure: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Minute)}))
+	assert.Nil(t, attempts("name:mallory"), "expired attempts are dropped")
+
+	// Past the limit, guesses at made-up names are evicted oldest first,
+	// before any account's
+	require.NoError(t, store.PutLoginAttempts(ctx, &auth.LoginAttempts{Key: "user:alice", Failures: 1, LastFailure: now.Add(-time.Hour)}))
+	for i := 0; i < auth.MaxLoginAttempts; i++ {
+		key := "name:" + strconv.Itoa(i)
+		require.NoError(t, store.PutLoginAttempts(ctx, &auth.LoginAttempts{Key: key, Failures: 1, LastFailure: now.Add(time.Duration(i))}))
+	}
+	assert.NotNil(t, attempts("user:alice"))
+	assert.Nil(t, attempts("name:0"))
+	assert.NotNil(t, attempts("name:"+strconv.Itoa(auth.MaxLoginAttempts-1)))
+}
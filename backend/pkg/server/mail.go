@@ -0,0 +1,251 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+
+	"github.com/elibdev/notably/pkg/auth"
+)
+
+// MailConfig sends account emails for password resets and email
+// verification. SMTPAddr takes precedence over SES. Both flows are
+// unavailable when neither is set.
+type MailConfig struct {
+	// From is the sender address
+	From string
+
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+
+	// SES sends through Amazon SES with the default AWS configuration
+	SES bool
+
+	// ResetURL and VerifyURL are the pages that complete each flow, with
+	// "{token}" replaced by the token. Without them emails carry the bare
+	// token for POSTing to the API.
+	ResetURL  string
+	VerifyURL string
+
+	// VerifyEmail makes new accounts verify their email address before
+	// they can log in
+	VerifyEmail bool
+}
+
+// mailConfigFromEnv reads the mail settings from the environment
+func mailConfigFromEnv() MailConfig {
+	return MailConfig{
+		From:         os.Getenv("NOTABLY_MAIL_FROM"),
+		SMTPAddr:     os.Getenv("NOTABLY_SMTP_ADDR"),
+		SMTPUsername: os.Getenv("NOTABLY_SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("NOTABLY_SMTP_PASSWORD"),
+		SES:          os.Getenv("NOTABLY_MAIL_SES") == "true",
+		ResetURL:     os.Getenv("NOTABLY_PASSWORD_RESET_URL"),
+		VerifyURL:    os.Getenv("NOTABLY_VERIFY_EMAIL_URL"),
+		VerifyEmail:  os.Getenv("NOTABLY_VERIFY_EMAIL") == "true",
+	}
+}
+
+// newMailer returns the configured mailer, or nil when mail is off.
+// Config.Mailer takes precedence over the mail settings.
+func newMailer(cfg Config) (auth.Mailer, error) {
+	if cfg.Mailer != nil {
+		return cfg.Mailer, nil
+	}
+	mc := cfg.Mail
+	switch {
+	case mc.SMTPAddr != "":
+		return &auth.SMTPMailer{Addr: mc.SMTPAddr, From: mc.From, Username: mc.SMTPUsername, Password: mc.SMTPPassword}, nil
+	case mc.SES:
+		awsCfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("loading AWS config: %w", err)
+		}
+		return auth.NewSESMailer(sesv2.NewFromConfig(awsCfg), mc.From), nil
+	}
+	return nil, nil
+}
+
+var errMailDisabled = errors.New("email is not configured on this server")
+
+// tokenLink renders the link of an emailed token, or the token itself when
+// no page is configured
+func tokenLink(page, token string) string {
+	if page == "" {
+		return token
+	}
+	return strings.ReplaceAll(page, "{token}", url.QueryEscape(token))
+}
+
+// sendVerification emails a new verification token to a user
+func (s *Server) sendVerification(ctx context.Context, user *auth.User) error {
+	if s.mailer == nil {
+		return errMailDisabled
+	}
+	token, err := s.authenticator.IssueToken(ctx, user.ID, auth.TokenVerifyEmail, auth.VerifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, auth.Message{
+		To:      user.Email,
+		Subject: "Verify your Notably email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address to finish setting up your account:\n\n%s\n\nThis link expires in %s.\n",
+			user.Username, tokenLink(s.config.Mail.VerifyURL, token), auth.VerifyEmailTTL),
+	})
+}
+
+// handleForgotPassword emails a password reset token. It answers the same
+// whether or not the address belongs to an account.
+func (s *Server) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var fields fieldErrors
+	fields.required("email", req.Email)
+	if len(fields) > 0 {
+		writeValidationError(w, "Email is required", fields)
+		return
+	}
+	if s.mailer == nil {
+		writeError(w, http.StatusNotImplemented, "Password resets are not configured on this server")
+		return
+	}
+
+	if user, err := s.userStore.GetUserByEmail(r.Context(), req.Email); err == nil {
+		token, err := s.authenticator.IssueToken(r.Context(), user.ID, auth.TokenPasswordReset, auth.PasswordResetTTL)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to create reset token")
+			return
+		}
+		err = s.mailer.Send(r.Context(), auth.Message{
+			To:      user.Email,
+			Subject: "Reset your Notably password",
+			Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. To choose a new one, use:\n\n%s\n\nThis link expires in %s. If you did not ask for a reset, ignore this email.\n",
+				user.Username, tokenLink(s.config.Mail.ResetURL, token), auth.PasswordResetTTL),
+		})
+		if err != nil {
+			s.logger.ErrorContext(r.Context(), "sending password reset email failed", "error", err)
+			writeError(w, http.StatusBadGateway, "Failed to send reset email")
+			return
+		}
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"status": "If an account uses this address, a reset email is on its way",
+	})
+}
+
+// handleResetPassword sets a new password with a reset token. Every API key
+// of the account is revoked and a new one is returned.
+func (s *Server) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var fields fieldErrors
+	fields.required("token", req.Token)
+	fields.required("password", req.Password)
+	if len(fields) > 0 {
+		writeValidationError(w, "Token and password are required", fields)
+		return
+	}
+
+	user, err := s.authenticator.ResetPassword(r.Context(), req.Token, req.Password)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			writeError(w, http.StatusBadRequest, "Reset token is invalid or has expired")
+		} else {
+			writeError(w, http.StatusInternalServerError, "Failed to reset password")
+		}
+		return
+	}
+	_, rawKey, err := s.authenticator.GenerateAPIKey(r.Context(), user.ID, "reset-"+user.UpdatedAt.Format(time.RFC3339), 0)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to generate API key")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":       user.ID,
+		"username": user.Username,
+		"email":    user.Email,
+		"apiKey":   rawKey,
+	})
+}
+
+// handleVerifyEmail marks an account's email address verified with the
+// token from its verification email
+func (s *Server) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var fields fieldErrors
+	fields.required("token", req.Token)
+	if len(fields) > 0 {
+		writeValidationError(w, "Token is required", fields)
+		return
+	}
+
+	user, err := s.authenticator.VerifyEmail(r.Context(), req.Token)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			writeError(w, http.StatusBadRequest, "Verification token is invalid or has expired")
+		} else {
+			writeError(w, http.StatusInternalServerError, "Failed to verify email")
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":            user.ID,
+		"username":      user.Username,
+		"email":         user.Email,
+		"emailVerified": user.EmailVerified,
+	})
+}
+
+// handleResendVerification emails a new verification token. It answers the
+// same whether or not the address belongs to an unverified account.
+func (s *Server) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var fields fieldErrors
+	fields.required("email", req.Email)
+	if len(fields) > 0 {
+		writeValidationError(w, "Email is required", fields)
+		return
+	}
+	if s.mailer == nil {
+		writeError(w, http.StatusNotImplemented, "Email verification is not configured on this server")
+		return
+	}
+	if user, err := s.userStore.GetUserByEmail(r.Context(), req.Email); err == nil && !user.EmailVerified {
+		if err := s.sendVerification(r.Context(), user); err != nil {
+			s.logger.ErrorContext(r.Context(), "sending verification email failed", "error", err)
+			writeError(w, http.StatusBadGateway, "Failed to send verification email")
+			return
+		}
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"status": "If an unverified account uses this address, a verification email is on its way",
+	})
+}
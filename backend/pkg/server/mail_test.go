@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureMailer records sent messages in place of delivering them
+type captureMailer struct {
+	mu   sync.Mutex
+	sent []auth.Message
+}
+
+func (m *captureMailer) Send(_ context.Context, msg auth.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var tokenPattern = regexp.MustCompile(auth.TokenPrefix + `[0-9a-f]+`)
+
+// lastToken returns the token in the latest message sent to an address
+func (m *captureMailer) lastToken(t *testing.T, to string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].To == to {
+			token := tokenPattern.FindString(m.sent[i].Body)
+			require.NotEmpty(t, token, m.sent[i].Body)
+			return token
+		}
+	}
+	t.Fatalf("no email sent to %s", to)
+	return ""
+}
+
+// post sends an unauthenticated JSON request
+func post(srv *Server, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPasswordReset(t *testing.T) {
+	mailer := &captureMailer{}
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true, Mailer: mailer})
+	require.NoError(t, err)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, oldKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+	require.NoError(t, err)
+
+	// Unknown addresses get the same answer and no email
+	rec := post(srv, "/v1/auth/forgot-password", `{"email": "nobody@example.com"}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+	assert.Empty(t, mailer.sent)
+
+	rec = post(srv, "/v1/auth/forgot-password", `{"email": "alice@example.com"}`)
+	require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+	token := mailer.lastToken(t, "alice@example.com")
+
+	rec = post(srv, "/v1/auth/reset-password", `{"token": "`+token+`", "password": "new-pw"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var resp struct {
+		APIKey string `json:"apiKey"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.NotEmpty(t, resp.APIKey)
+
+	// Tokens are single-use
+	rec = post(srv, "/v1/auth/reset-password", `{"token": "`+token+`", "password": "other"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// The old key is revoked and only the new password logs in
+	_, _, err = srv.authenticator.VerifyAPIKey(ctx, oldKey)
+	assert.Error(t, err)
+	_, _, err = srv.authenticator.VerifyAPIKey(ctx, resp.APIKey)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, post(srv, "/v1/auth/login", `{"username": "alice", "password": "pw"}`).Code)
+	assert.Equal(t, http.StatusOK, post(srv, "/v1/auth/login", `{"username": "alice", "password": "new-pw"}`).Code)
+
+	// Verification tokens cannot reset passwords
+	verify, err := srv.authenticator.IssueToken(ctx, user.ID, auth.TokenVerifyEmail, auth.VerifyEmailTTL)
+	require.NoError(t, err)
+	rec = post(srv, "/v1/auth/reset-password", `{"token": "`+verify+`", "password": "x"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestPasswordResetWithoutMailer(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true})
+	require.NoError(t, err)
+	rec := post(srv, "/v1/auth/forgot-password", `{"email": "alice@example.com"}`)
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+
+	_, err = NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true, Mail: MailConfig{VerifyEmail: true}})
+	assert.Error(t, err)
+}
+
+func TestEmailVerification(t *testing.T) {
+	mailer := &captureMailer{}
+	srv, err := NewServer(Config{
+		TableName: "Facts", Logger: logging.Discard(), InMemory: true,
+		Mailer: mailer, Mail: MailConfig{VerifyEmail: true, VerifyURL: "https://app.example.com/verify?token={token}"},
+	})
+	require.NoError(t, err)
+
+	rec := post(srv, "/v1/auth/register", `{"username": "alice", "email": "alice@example.com", "password": "pw"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	assert.NotContains(t, rec.Body.String(), "apiKey")
+	assert.Contains(t, mailer.sent[0].Body, "https://app.example.com/verify?token="+auth.TokenPrefix)
+
+	rec = post(srv, "/v1/auth/login", `{"username": "alice", "password": "pw"}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+
+	rec = post(srv, "/v1/auth/resend-verification", `{"email": "alice@example.com"}`)
+	require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+	require.Len(t, mailer.sent, 2)
+
+	rec = post(srv, "/v1/auth/verify-email", `{"token": "`+mailer.lastToken(t, "alice@example.com")+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"emailVerified":true`)
+
+	rec = post(srv, "/v1/auth/login", `{"username": "alice", "password": "pw"}`)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// Verified accounts get no more verification emails
+	post(srv, "/v1/auth/resend-verification", `{"email": "alice@example.com"}`)
+	assert.Len(t, mailer.sent, 2)
+}
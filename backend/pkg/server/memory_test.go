@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/elibdev/notably/pkg/ulid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryServer(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true})
+	require.NoError(t, err)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+	require.NoError(t, err)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/tables", `{"name": "notes", "columns": [{"name": "title", "dataType": "string"}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n1", "values": {"title": "hello"}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodGet, "/tables/notes/snapshot", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var snap struct {
+		Rows []struct {
+			ID     string                 `json:"id"`
+			Values map[string]interface{} `json:"values"`
+		} `json:"rows"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &snap))
+	require.Len(t, snap.Rows, 1)
+	assert.Equal(t, "n1", snap.Rows[0].ID)
+	assert.Equal(t, "hello", snap.Rows[0].Values["title"])
+
+	// Another user's store is separate
+	other, err := srv.authenticator.RegisterUser(ctx, "bob", "bob@example.com", "pw")
+	require.NoError(t, err)
+	store, err := srv.getStoreForUser(ctx, other.ID)
+	require.NoError(t, err)
+	assert.False(t, tableExists(ctx, store, other.ID, "notes"))
+}
+
+// memoryServer returns an in-memory server and a function that sends
+// requests to it as a registered user
+func memoryServer(t *testing.T) (*Server, func(method, path, body string) *httptest.ResponseRecorder) {
+	t.Helper()
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true})
+	require.NoError(t, err)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+	require.NoError(t, err)
+
+	return srv, func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+}
+
+func TestCreateRowGeneratesULID(t *testing.T) {
+	_, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var ids []string
+	for range 3 {
+		rec = do(http.MethodPost, "/tables/notes/rows", `{"values": {"v": 1}}`)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		var row RowData
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &row))
+		_, err := ulid.Parse(row.ID)
+		require.NoError(t, err, row.ID)
+		ids = append(ids, row.ID)
+	}
+	assert.True(t, sort.StringsAreSorted(ids), ids)
+	assert.NotEqual(t, ids[0], ids[1])
+}
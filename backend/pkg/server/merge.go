@@ -0,0 +1,241 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/plugin"
+)
+
+// Sides of a merge a conflicting row can be resolved to
+const (
+	mergeKeepFork   = "fork"
+	mergeKeepParent = "parent"
+)
+
+// MergeConflict is a row changed in both a fork and its parent since the
+// last merge. Values are nil for a deleted row, and for secret rows.
+type MergeConflict struct {
+	ID     string                 `json:"id"`
+	Base   map[string]interface{} `json:"base"`
+	Fork   map[string]interface{} `json:"fork"`
+	Parent map[string]interface{} `json:"parent"`
+}
+
+// MergeResult lists the parent rows a merge changed
+type MergeResult struct {
+	Parent    string      `json:"parent"`
+	Timestamp time.Time   `json:"timestamp"`
+	Rows      []RowChange `json:"rows"`
+}
+
+// mergeConflictResponse is the 409 body of a merge with unresolved conflicts
+type mergeConflictResponse struct {
+	Error     string          `json:"error"`
+	Conflicts []MergeConflict `json:"conflicts"`
+}
+
+// rowValues returns the values of a present JSON row, or nil
+func rowValues(fact dynamo.Fact, ok bool) map[string]interface{} {
+	if !ok {
+		return nil
+	}
+	return previousValues(fact)
+}
+
+// planMerge returns the facts that copy a fork's changes since the last
+// merge into its parent, and the rows changed on both sides. A conflicting
+// row is carried over only when resolutions picks the fork's side.
+func planMerge(user *auth.User, parent string, base, latest, forkBase, fork map[string]dynamo.Fact, resolutions map[string]string, now time.Time) ([]dynamo.Fact, []plugin.RowEvent, []MergeConflict) {
+	ids := map[string]bool{}
+	for _, entries := range []map[string]dynamo.Fact{forkBase, fork} {
+		for id := range entries {
+			ids[id] = true
+		}
+	}
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+
+	var facts []dynamo.Fact
+	var events []plugin.RowEvent
+	var conflicts []MergeConflict
+	for _, id := range sorted {
+		was, hadWas := forkBase[id]
+		mine, hasMine := fork[id]
+		if sameRow(was, hadWas, mine, hasMine) {
+			continue
+		}
+		cur, hasCur := latest[id]
+		if sameRow(mine, hasMine, cur, hasCur) {
+			continue
+		}
+		old, hadOld := base[id]
+		if !sameRow(old, hadOld, cur, hasCur) {
+			switch resolutions[id] {
+			case mergeKeepFork:
+			case mergeKeepParent:
+				continue
+			default:
+				conflicts = append(conflicts, MergeConflict{
+					ID:     id,
+					Base:   rowValues(old, hadOld),
+					Fork:   rowValues(mine, hasMine),
+					Parent: rowValues(cur, hasCur),
+				})
+				continue
+			}
+		}
+		fact, event := restoreFact(user.ID, parent, id, mine, rowPresent(mine, hasMine), rowPresent(cur, hasCur), now)
+		facts = append(facts, fact)
+		events = append(events, event)
+	}
+	return facts, events, conflicts
+}
+
+// handleMergeTable copies a fork's changes since it was forked, or last
+// merged, into its parent. Rows changed on both sides are conflicts: unless
+// each is resolved, nothing is written and the conflicts are reported.
+func (s *Server) handleMergeTable(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	table := r.PathValue("table")
+
+	var req struct {
+		// Resolutions maps conflicting row IDs to the side to keep
+		Resolutions map[string]string `json:"resolutions"`
+	}
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+		return
+	}
+	var fields []FieldError
+	for id, side := range req.Resolutions {
+		if side != mergeKeepFork && side != mergeKeepParent {
+			fields = append(fields, FieldError{Field: "resolutions." + id, Message: "must be 'fork' or 'parent'"})
+		}
+	}
+	if len(fields) > 0 {
+		writeValidationError(w, "Invalid resolutions", fields)
+		return
+	}
+
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	now := time.Now().UTC()
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, now)
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+	def := latestTableDef(facts)
+	opts := tableOptionsOf(def)
+	origin := opts.Fork
+	if origin == nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Table '%s' is not a fork", table))
+		return
+	}
+	if !keyAllowsTable(r, origin.Table) {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("API key is not scoped for table '%s'", origin.Table))
+		return
+	}
+	parentDefs, err := store.QueryByField(r.Context(), user.ID, origin.Table, time.Time{}, now)
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), parentDefs) {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Parent table '%s' no longer exists", origin.Table))
+		return
+	}
+	parentDef := latestTableDef(parentDefs)
+
+	forkStore, err := s.getRowStore(r.Context(), store, user, table)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize table storage")
+		return
+	}
+	parentStore, err := s.getRowStore(r.Context(), store, user, origin.Table)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize table storage")
+		return
+	}
+
+	since := origin.At
+	if origin.MergedAt != nil {
+		since = *origin.MergedAt
+	}
+	// Compare both sides at the last merge and now
+	var sides [4]map[string]dynamo.Fact
+	for i, side := range []struct {
+		rowStore *db.StoreAdapter
+		table    string
+		def      dynamo.Fact
+		at       time.Time
+	}{
+		{parentStore, origin.Table, parentDef, since},
+		{parentStore, origin.Table, parentDef, now},
+		{forkStore, table, def, since},
+		{forkStore, table, def, now},
+	} {
+		if sides[i], err = s.tableEntries(r.Context(), store, side.rowStore, user, side.table, side.def, side.at); err != nil {
+			writeStoreError(w, err, "Failed to read table history")
+			return
+		}
+	}
+	writes, events, conflicts := planMerge(user, origin.Table, sides[0], sides[1], sides[2], sides[3], req.Resolutions, now)
+	if len(conflicts) > 0 {
+		writeJSON(w, http.StatusConflict, mergeConflictResponse{
+			Error:     fmt.Sprintf("%d rows changed in both '%s' and '%s'", len(conflicts), table, origin.Table),
+			Conflicts: conflicts,
+		})
+		return
+	}
+
+	if err := s.applyRowFacts(r.Context(), store, parentStore, user, origin.Table, parentDef, writes, events, now); err != nil {
+		if !writeRefusedDelete(w, err) {
+			writeStoreError(w, err, "Failed to merge rows")
+		}
+		return
+	}
+
+	// Later merges only carry over changes made after this one
+	merged := *origin
+	merged.MergedAt = &now
+	opts.Fork = &merged
+	mark := dynamo.Fact{
+		ID:        newID(),
+		Timestamp: now,
+		Namespace: user.ID,
+		FieldName: table,
+		DataType:  tableDataType,
+		Value:     opts.encode(),
+		Columns:   def.Columns,
+	}
+	if err := store.PutFact(r.Context(), mark); err != nil {
+		writeStoreError(w, err, "Failed to record merge")
+		return
+	}
+
+	result := MergeResult{Parent: origin.Table, Timestamp: now, Rows: []RowChange{}}
+	for _, ev := range events {
+		result.Rows = append(result.Rows, RowChange{ID: ev.Row, Change: ev.Type})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeFork(t *testing.T) {
+	_, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	for _, body := range []string{`{"id": "n1", "values": {"v": 1}}`, `{"id": "n2", "values": {"v": 2}}`, `{"id": "n3", "values": {"v": 3}}`} {
+		rec = do(http.MethodPost, "/tables/notes/rows", body)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	rec = do(http.MethodPost, "/tables/notes/fork", `{"name": "draft"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	// The fork changes n1, deletes n2 and adds n4; the parent changes n3
+	rec = do(http.MethodPost, "/transactions", `{"ops": [{"op": "update", "table": "draft", "id": "n1", "values": {"v": 10}}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/tables/draft/rows/n2", "").Code)
+	rec = do(http.MethodPost, "/tables/draft/rows", `{"id": "n4", "values": {"v": 4}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/transactions", `{"ops": [{"op": "update", "table": "notes", "id": "n3", "values": {"v": 30}}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodPost, "/v1/tables/draft/merge", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var result MergeResult
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, "notes", result.Parent)
+	assert.Equal(t, []RowChange{{ID: "n1", Change: "update"}, {ID: "n2", Change: "delete"}, {ID: "n4", Change: "create"}}, result.Rows)
+	assert.Equal(t, map[string]float64{"n1": 10, "n3": 30, "n4": 4}, snapshotValues(t, do, "/tables/notes/snapshot"))
+
+	// Merging again carries over nothing new
+	rec = do(http.MethodPost, "/v1/tables/draft/merge", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"rows":[]`)
+
+	// Later changes to merged rows are not conflicts
+	rec = do(http.MethodPost, "/transactions", `{"ops": [{"op": "update", "table": "draft", "id": "n1", "values": {"v": 100}}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/v1/tables/draft/merge", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, map[string]float64{"n1": 100, "n3": 30, "n4": 4}, snapshotValues(t, do, "/tables/notes/snapshot"))
+}
+
+func TestMergeForkConflicts(t *testing.T) {
+	_, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	for _, body := range []string{`{"id": "n1", "values": {"v": 1}}`, `{"id": "n2", "values": {"v": 2}}`} {
+		rec = do(http.MethodPost, "/tables/notes/rows", body)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	rec = do(http.MethodPost, "/tables/notes/fork", `{"name": "draft"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodPost, "/transactions", `{"ops": [
+		{"op": "update", "table": "draft", "id": "n1", "values": {"v": 10}},
+		{"op": "update", "table": "draft", "id": "n2", "values": {"v": 20}}
+	]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/transactions", `{"ops": [{"op": "update", "table": "notes", "id": "n1", "values": {"v": 11}}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// Nothing is written while a conflict is unresolved
+	rec = do(http.MethodPost, "/v1/tables/draft/merge", "")
+	require.Equal(t, http.StatusConflict, rec.Code, rec.Body.String())
+	var report mergeConflictResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	require.Len(t, report.Conflicts, 1)
+	conflict := report.Conflicts[0]
+	assert.Equal(t, "n1", conflict.ID)
+	assert.Equal(t, float64(1), conflict.Base["v"])
+	assert.Equal(t, float64(10), conflict.Fork["v"])
+	assert.Equal(t, float64(11), conflict.Parent["v"])
+	assert.Equal(t, map[string]float64{"n1": 11, "n2": 2}, snapshotValues(t, do, "/tables/notes/snapshot"))
+
+	rec = do(http.MethodPost, "/v1/tables/draft/merge", `{"resolutions": {"n1": "parent"}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"rows":[{"id":"n2","change":"update"}]`)
+	assert.Equal(t, map[string]float64{"n1": 11, "n2": 20}, snapshotValues(t, do, "/tables/notes/snapshot"))
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/v1/tables/draft/merge", `{"resolutions": {"n1": "mine"}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/v1/tables/notes/merge", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/v1/tables/missing/merge", "").Code)
+}
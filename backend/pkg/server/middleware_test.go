@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogRequestsAssignsRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	srv, err := NewServer(Config{TableName: "unused", Logger: logging.New(&buf, "json", "info")})
+	require.NoError(t, err)
+	buf.Reset() // drop startup logs
+
+	req := httptest.NewRequest(http.MethodGet, "/tables", nil)
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	id := rec.Header().Get(requestIDHeader)
+	require.NotEmpty(t, id)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "request", entry["msg"])
+	assert.Equal(t, id, entry["request_id"])
+	assert.Equal(t, "GET", entry["method"])
+	assert.Equal(t, "/tables", entry["path"])
+	assert.Equal(t, float64(http.StatusUnauthorized), entry["status"])
+}
+
+func TestLogRequestsReusesClientRequestID(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "unused", Logger: logging.Discard()})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/tables", nil)
+	req.Header.Set(requestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, "abc-123", rec.Header().Get(requestIDHeader))
+
+	req = httptest.NewRequest(http.MethodGet, "/tables", nil)
+	req.Header.Set(requestIDHeader, "bad id\n")
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	assert.NotEqual(t, "bad id\n", rec.Header().Get(requestIDHeader))
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"os"
+	"strings"
+
+	"github.com/elibdev/notably/pkg/naming"
+)
+
+// namingPolicyFromEnv reads the rules for table, column and other resource
+// names from the environment. NOTABLY_NAME_RESERVED_PREFIXES is a
+// comma-separated list; set but empty, it reserves no prefixes.
+func namingPolicyFromEnv() naming.Policy {
+	p := naming.Policy{
+		MinLength: envInt("NOTABLY_NAME_MIN_LENGTH", 0),
+		MaxLength: envInt("NOTABLY_NAME_MAX_LENGTH", 0),
+		Case:      naming.Case(os.Getenv("NOTABLY_NAME_CASE")),
+	}
+	if v, ok := os.LookupEnv("NOTABLY_NAME_RESERVED_PREFIXES"); ok {
+		p.ReservedPrefixes = splitList(v)
+	}
+	return p
+}
+
+// splitList splits a comma-separated list, dropping empty entries. The
+// result is never nil.
+func splitList(v string) []string {
+	out := []string{}
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// nameProblem returns why the naming policy rejects the name of a table,
+// column, view or automation being created, or "" if it is accepted
+func (s *Server) nameProblem(name string) string {
+	return s.config.Naming.Reason(name)
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elibdev/notably/pkg/naming"
+)
+
+func TestNamingPolicy(t *testing.T) {
+	srv, do := memoryServer(t)
+
+	for _, tt := range []struct {
+		body string
+		msg  string
+	}{
+		{`{"name": "` + strings.Repeat("t", 65) + `"}`, "Table name must be at most 64 characters"},
+		{`{"name": "_hidden"}`, "Table name must not start with the reserved prefix '_'"},
+		{`{"name": "a b"}`, "Table name must contain only alphanumeric characters, hyphens, and underscores"},
+		{`{"name": "notes", "columns": [{"name": "_note", "dataType": "string"}]}`, "Column name '_note' must not start with the reserved prefix '_'"},
+		{`{"name": "notes", "columns": [{"name": "_createdAt", "dataType": "string"}]}`, "reserved for a system column"},
+	} {
+		rec := do(http.MethodPost, "/tables", tt.body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, tt.body)
+		assert.Contains(t, rec.Body.String(), tt.msg, tt.body)
+	}
+	rec := do(http.MethodPost, "/views", `{"name": "_v", "source": "notes"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "must not start with the reserved prefix '_'")
+
+	srv.config.Naming = naming.Policy{MaxLength: 8, ReservedPrefixes: []string{}, Case: naming.CaseLower}
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "_notes"}`).Code)
+	rec = do(http.MethodPost, "/tables", `{"name": "Notes"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Table name must be lower case")
+	rec = do(http.MethodPost, "/tables", `{"name": "notebooks"}`)
+	assert.Contains(t, rec.Body.String(), "Table name must be at most 8 characters")
+
+	// Existing names that predate the policy can still be referenced
+	srv.config.Naming = naming.Policy{}
+	rec = do(http.MethodPost, "/tables", `{"name": "links", "columns": [{"name": "note", "dataType": "reference", "references": "_notes"}]}`)
+	assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+}
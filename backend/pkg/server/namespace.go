@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/elibdev/notably/db"
+)
+
+// Fact layout. A user's namespace, their ID, holds the definition of each
+// of their tables under the table's name, with DataType tableDataType, and
+// the settings and indexes kept about their tables under fields of the form
+// "<table>/<kind>/..." (see settingField), which no table name can take. The
+// rows of a table live in the table namespace "<user>/<table>" (see
+// tableNamespace). Names are escaped before they are joined, so whatever
+// reaches these helpers, a table can neither take the field of a setting nor
+// the namespace of another table.
+
+// tableDataType marks table definition facts
+const tableDataType = "table"
+
+// escapeName escapes a name for use as one segment of a namespace or
+// field, leaving valid names as they are
+func escapeName(name string) string {
+	return url.PathEscape(name)
+}
+
+// tableNamespace returns the namespace holding the rows of a user's table
+func tableNamespace(userID, table string) string {
+	return db.TableNamespace(userID, table)
+}
+
+// parseTableNamespace returns the user and table of a table namespace
+func parseTableNamespace(namespace string) (userID, table string, ok bool) {
+	userID, escaped, ok := strings.Cut(namespace, "/")
+	if !ok || userID == "" || escaped == "" || strings.Contains(escaped, "/") {
+		return "", "", false
+	}
+	table, err := url.PathUnescape(escaped)
+	if err != nil {
+		return "", "", false
+	}
+	return userID, table, true
+}
+
+// settingField returns the field, in the user's namespace, of a setting or
+// index entry kept about a table, such as settingField("orders", "refs", row)
+func settingField(table string, parts ...string) string {
+	segments := make([]string, 0, len(parts)+1)
+	segments = append(segments, escapeName(table))
+	for _, p := range parts {
+		segments = append(segments, escapeName(p))
+	}
+	return strings.Join(segments, "/")
+}
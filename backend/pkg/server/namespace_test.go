@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespaceEncoding(t *testing.T) {
+	assert.Equal(t, "u1/orders", tableNamespace("u1", "orders"), "valid names are kept as they are")
+	assert.Equal(t, "u1/a%2Fb", tableNamespace("u1", "a/b"))
+	assert.NotEqual(t, tableNamespace("u1", "a/b"), tableNamespace("u1", "a")+"/b")
+	assert.Equal(t, "orders/refs/r1", settingField("orders", "refs", "r1"))
+	assert.Equal(t, "orders%2Frefs/refs/r%2F1", settingField("orders/refs", "refs", "r/1"))
+
+	for _, table := range []string{"orders", "a/b", "100%"} {
+		user, got, ok := parseTableNamespace(tableNamespace("u1", table))
+		require.True(t, ok, table)
+		assert.Equal(t, "u1", user)
+		assert.Equal(t, table, got)
+	}
+	for _, ns := range []string{"u1", "u1/", "/t", "u1/t/x", "u1/%zz"} {
+		_, _, ok := parseTableNamespace(ns)
+		assert.False(t, ok, ns)
+	}
+}
+
+func TestSettingsAreNotTables(t *testing.T) {
+	_, do := memoryServer(t)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "orders"}`).Code)
+	rec := do(http.MethodPut, "/tables/orders/automations/notify", `{"script": "def transform(event):\n    return event"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	// The automation set is stored in the user's namespace under
+	// orders/automations; naming it as a table finds no table
+	for _, path := range []string{"/tables/orders%2Fautomations/rows", "/tables/orders%2Fautomations/rows/r1"} {
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, path, "").Code, path)
+	}
+	rec = do(http.MethodPost, "/tables/orders%2Fautomations/rows", `{"id": "r1", "values": {"x": 1}}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+}
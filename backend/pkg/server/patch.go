@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/elibdev/notably/dynamo"
+)
+
+// handlePatchRow applies a JSON merge patch (RFC 7386) to a row's latest
+// values: fields in the patch replace the row's, null removes a field and
+// nested objects are merged the same way. Fields the patch leaves out keep
+// their current values, so concurrent edits to other fields survive.
+func (s *Server) handlePatchRow(w http.ResponseWriter, r *http.Request) {
+	s.updateRow(w, r, true)
+}
+
+// patchTarget returns the current values of a row to apply a patch to,
+// writing the error when the row is deleted or cannot be read
+func (s *Server) patchTarget(w http.ResponseWriter, r *http.Request, current dynamo.Fact, rowID, table string) (map[string]interface{}, bool) {
+	if !rowLive(current) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Row '%s' not found in table '%s'", rowID, table))
+		return nil, false
+	}
+	if current.DataType != secretDataType {
+		return previousValues(current), true
+	}
+	values, masked, err := s.revealSecret(r.Context(), current)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to read secret: %v", err))
+		return nil, false
+	}
+	if masked {
+		writeError(w, http.StatusForbidden, "Patching a secret row requires an API key with the secrets:read scope")
+		return nil, false
+	}
+	return values, true
+}
+
+// mergePatch returns target with patch merged in following RFC 7386. Neither
+// argument is modified.
+func mergePatch(target, patch map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(target)+len(patch))
+	for k, v := range target {
+		merged[k] = v
+	}
+	for k, v := range patch {
+		switch v := v.(type) {
+		case nil:
+			delete(merged, k)
+		case map[string]interface{}:
+			existing, _ := merged[k].(map[string]interface{})
+			merged[k] = mergePatch(existing, v)
+		default:
+			merged[k] = v
+		}
+	}
+	return merged
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergePatch(t *testing.T) {
+	target := map[string]interface{}{
+		"title": "Goodbye!",
+		"author": map[string]interface{}{
+			"givenName":  "John",
+			"familyName": "Doe",
+		},
+		"tags": []interface{}{"example", "sample"},
+	}
+	patch := map[string]interface{}{
+		"title":       "Hello!",
+		"phoneNumber": "+01-123-456-7890",
+		"author":      map[string]interface{}{"familyName": nil},
+		"tags":        []interface{}{"example"},
+	}
+	assert.Equal(t, map[string]interface{}{
+		"title":       "Hello!",
+		"author":      map[string]interface{}{"givenName": "John"},
+		"tags":        []interface{}{"example"},
+		"phoneNumber": "+01-123-456-7890",
+	}, mergePatch(target, patch))
+	assert.Equal(t, "Doe", target["author"].(map[string]interface{})["familyName"])
+
+	// Objects replace non-objects, and the reverse
+	assert.Equal(t, map[string]interface{}{"a": map[string]interface{}{"b": "c"}},
+		mergePatch(map[string]interface{}{"a": "x"}, map[string]interface{}{"a": map[string]interface{}{"b": "c"}}))
+	assert.Equal(t, map[string]interface{}{"a": "x"},
+		mergePatch(map[string]interface{}{"a": map[string]interface{}{"b": "c"}}, map[string]interface{}{"a": "x"}))
+}
+
+func TestPatchRow(t *testing.T) {
+	_, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n1", "values": {"title": "a", "body": "b", "meta": {"x": 1, "y": 2}}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodPatch, "/v1/tables/notes/rows/n1", `{"values": {"title": "c", "body": null, "meta": {"y": 3}}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var row RowData
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &row))
+	assert.Equal(t, map[string]interface{}{"title": "c", "meta": map[string]interface{}{"x": float64(1), "y": float64(3)}}, row.Values)
+
+	rec = do(http.MethodGet, "/tables/notes/snapshot", "")
+	assert.Contains(t, rec.Body.String(), `"values":{"meta":{"x":1,"y":3},"title":"c"}`)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPatch, "/v1/tables/notes/rows/n1", `{}`).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPatch, "/v1/tables/notes/rows/nope", `{"values": {"a": 1}}`).Code)
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/tables/notes/rows/n1", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPatch, "/v1/tables/notes/rows/n1", `{"values": {"a": 1}}`).Code)
+}
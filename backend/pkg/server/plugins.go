@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// pluginsFromEnv reads NOTABLY_PLUGINS, a comma-separated list of plugins to
+// enable. Unset means every registered plugin.
+func pluginsFromEnv() []string {
+	v, ok := os.LookupEnv("NOTABLY_PLUGINS")
+	if !ok {
+		return nil
+	}
+	names := []string{}
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// mountPluginRoutes registers plugin routes on the mux. Routes are
+// authenticated unless the plugin marks them public.
+func (s *Server) mountPluginRoutes() error {
+	for _, route := range s.plugins.Routes() {
+		h := route.Handler
+		if !route.Public {
+			h = s.requireAuth(h.ServeHTTP)
+		}
+		if err := s.handlePattern(route.Pattern, h); err != nil {
+			return fmt.Errorf("plugin %s: %w", route.Plugin, err)
+		}
+	}
+	return nil
+}
+
+// handlePattern registers h, reporting invalid or conflicting patterns as an
+// error rather than a panic
+func (s *Server) handlePattern(pattern string, h http.Handler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	s.mux.Handle(pattern, h)
+	return nil
+}
+
+// validColumnValue checks a value against a built-in or plugin column type
+func (s *Server) validColumnValue(value interface{}, dataType string) bool {
+	if ct, ok := s.plugins.ColumnType(dataType); ok {
+		return ct.Valid(value)
+	}
+	return validateValueType(value, dataType)
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/elibdev/notably/pkg/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPlugin adds a public and a private route and an "even" column type
+type testPlugin struct{}
+
+func (testPlugin) Manifest() plugin.Manifest {
+	return plugin.Manifest{
+		Name:         "server-test",
+		APIVersion:   plugin.APIVersion,
+		Capabilities: []plugin.Capability{plugin.CapRoutes, plugin.CapColumnTypes},
+	}
+}
+
+func (testPlugin) Init(context.Context, plugin.Host) error { return nil }
+
+func (testPlugin) Routes() []plugin.Route {
+	whoami := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := auth.UserFromContext(r.Context())
+		if !ok {
+			w.Write([]byte("anonymous"))
+			return
+		}
+		w.Write([]byte(user.Username))
+	})
+	return []plugin.Route{
+		{Method: "GET", Path: "/whoami", Handler: whoami},
+		{Method: "GET", Path: "/public", Handler: whoami, Public: true},
+	}
+}
+
+func (testPlugin) ColumnTypes() []plugin.ColumnType {
+	return []plugin.ColumnType{{Name: "even", Valid: func(v interface{}) bool {
+		n, ok := v.(float64)
+		return ok && int(n)%2 == 0
+	}}}
+}
+
+// eventsPlugin collects the row events it consumes
+type eventsPlugin struct {
+	events chan plugin.RowEvent
+}
+
+func (eventsPlugin) Manifest() plugin.Manifest {
+	return plugin.Manifest{Name: "server-events", APIVersion: plugin.APIVersion, Capabilities: []plugin.Capability{plugin.CapEvents}}
+}
+
+func (eventsPlugin) Init(context.Context, plugin.Host) error { return nil }
+
+// ConsumeEvent drops events nobody is waiting for, as every server without
+// a plugin list loads this plugin too
+func (p eventsPlugin) ConsumeEvent(_ context.Context, ev plugin.RowEvent) {
+	select {
+	case p.events <- ev:
+	default:
+	}
+}
+
+var pluginEvents = eventsPlugin{events: make(chan plugin.RowEvent, 8)}
+
+func init() {
+	plugin.Register(testPlugin{})
+	plugin.Register(pluginEvents)
+}
+
+func TestPluginRoutes(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Plugins: []string{"server-test"}, Logger: logging.Discard()})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "key", 0)
+	require.NoError(t, err)
+
+	get := func(path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get("/plugins/server-test/whoami", "").Code)
+	rec := get("/plugins/server-test/whoami", apiKey)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "alice", rec.Body.String())
+	assert.Equal(t, "anonymous", get("/plugins/server-test/public", "").Body.String())
+}
+
+func TestPluginColumnTypes(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Plugins: []string{"server-test"}, Logger: logging.Discard()})
+	require.NoError(t, err)
+
+	assert.True(t, srv.validColumnValue(float64(4), "even"))
+	assert.False(t, srv.validColumnValue(float64(3), "even"))
+	assert.True(t, srv.validColumnValue("text", "string"))
+
+	// Without the plugin the type is unknown and, as before, unchecked
+	srv, err = NewServer(Config{TableName: "Facts", Plugins: []string{}, Logger: logging.Discard()})
+	require.NoError(t, err)
+	assert.True(t, srv.validColumnValue(float64(3), "even"))
+}
+
+func TestPluginEventsOmitSecrets(t *testing.T) {
+	srv, err := NewServer(Config{
+		TableName: "Facts",
+		Logger:    logging.Discard(),
+		InMemory:  true,
+		MasterKey: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{5}, 32)),
+		Plugins:   []string{"server-events"},
+	})
+	require.NoError(t, err)
+	t.Cleanup(srv.plugins.Close)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+	require.NoError(t, err)
+	do := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	next := func() plugin.RowEvent {
+		for {
+			select {
+			case ev := <-pluginEvents.events:
+				if ev.UserID == user.ID {
+					return ev
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no plugin event")
+				return plugin.RowEvent{}
+			}
+		}
+	}
+
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "vault", "type": "secrets"}`))
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "notes"}`))
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/vault/rows", `{"id": "github", "values": {"token": "ghp_secret"}}`))
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/notes/rows", `{"id": "n1", "values": {"text": "hi"}}`))
+
+	ev := next()
+	assert.Equal(t, "github", ev.Row)
+	assert.Nil(t, ev.Values, "plugins never see secrets")
+	ev = next()
+	assert.Equal(t, "n1", ev.Row)
+	assert.Equal(t, map[string]interface{}{"text": "hi"}, ev.Values)
+}
+
+func TestPluginsFromEnv(t *testing.T) {
+	t.Setenv("NOTABLY_PLUGINS", " geo, audit ,")
+	assert.Equal(t, []string{"geo", "audit"}, pluginsFromEnv())
+
+	t.Setenv("NOTABLY_PLUGINS", "")
+	assert.Equal(t, []string{}, pluginsFromEnv())
+}
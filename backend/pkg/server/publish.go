@@ -0,0 +1,487 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/backoff"
+	"github.com/elibdev/notably/pkg/plugin"
+	"github.com/elibdev/notably/pkg/publish"
+)
+
+const (
+	// publishingDataType marks the fact holding a table's publishing setting
+	publishingDataType = "publishing"
+	// publishDeadLetterDataType marks a change event that failed to publish
+	publishDeadLetterDataType = "publish-dead-letter"
+
+	// publishWorkers is the number of publish queues. Each row key is
+	// published by one worker, so the events of a row stay in order.
+	publishWorkers = 4
+	// publishQueueSize is the number of row events buffered per worker
+	publishQueueSize = 256
+)
+
+// errPublishQueueFull is recorded on events dead-lettered without an attempt
+// because their publish queue was full
+var errPublishQueueFull = errors.New("publish queue full")
+
+// PublishConfig sends row change events to a message broker
+type PublishConfig struct {
+	// KafkaURL is the base URL of a Kafka REST Proxy (v2 API) to publish to
+	KafkaURL string
+	// SNS publishes to Amazon SNS with the default AWS configuration
+	SNS bool
+
+	// Topic receives the events of every table that does not choose
+	// another. When empty, only tables that choose a topic publish.
+	Topic string
+	// Topics lists the other topics tables may choose
+	Topics []string
+
+	// Policy controls retries; zero fields use publish.DefaultPolicy
+	Policy backoff.Policy
+}
+
+// publishConfigFromEnv reads the publishing settings from the environment
+func publishConfigFromEnv() PublishConfig {
+	var topics []string
+	for _, t := range strings.Split(os.Getenv("NOTABLY_PUBLISH_TOPICS"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			topics = append(topics, t)
+		}
+	}
+	return PublishConfig{
+		KafkaURL: os.Getenv("NOTABLY_PUBLISH_KAFKA_URL"),
+		SNS:      os.Getenv("NOTABLY_PUBLISH_SNS") == "true",
+		Topic:    os.Getenv("NOTABLY_PUBLISH_TOPIC"),
+		Topics:   topics,
+		Policy: backoff.Policy{
+			MaxAttempts: envInt("NOTABLY_PUBLISH_MAX_ATTEMPTS", 0),
+			MaxElapsed:  envDuration("NOTABLY_PUBLISH_MAX_ELAPSED", 0),
+		},
+	}
+}
+
+// newPublisher returns the configured publisher, or nil when publishing is
+// off. Config.Publisher takes precedence over the broker settings.
+func newPublisher(cfg Config) (publish.Publisher, error) {
+	if cfg.Publisher != nil {
+		return cfg.Publisher, nil
+	}
+	pc := cfg.Publish
+	switch {
+	case pc.KafkaURL != "":
+		return &publish.Kafka{URL: pc.KafkaURL}, nil
+	case pc.SNS:
+		awsCfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("loading AWS config: %w", err)
+		}
+		return publish.NewSNS(awsCfg), nil
+	}
+	return nil, nil
+}
+
+// topicAllowed reports whether tables may publish to topic
+func (pc PublishConfig) topicAllowed(topic string) bool {
+	if topic == pc.Topic {
+		return true
+	}
+	for _, t := range pc.Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// tablePublishing is a table's publishing setting. Tables without one
+// publish to the server's default topic.
+type tablePublishing struct {
+	Disabled bool `json:"disabled,omitempty"`
+	// Topic replaces the default topic
+	Topic string `json:"topic,omitempty"`
+}
+
+// PublishingInfo is a table's effective publishing setting
+type PublishingInfo struct {
+	Enabled bool   `json:"enabled"`
+	Topic   string `json:"topic,omitempty"`
+}
+
+// PublishDeadLetter records a change event that could not be published
+type PublishDeadLetter struct {
+	ID       string          `json:"id"`
+	Topic    string          `json:"topic,omitempty"`
+	Key      string          `json:"key"`
+	Event    json.RawMessage `json:"event"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	FailedAt time.Time       `json:"failedAt"`
+}
+
+// publishingField is the field, in the user's namespace, holding a table's
+// publishing setting
+func publishingField(table string) string {
+	return settingField(table, "publishing")
+}
+
+// publishDeadLetterField is the field, in the user's namespace, holding a
+// table's dead-lettered change events
+func publishDeadLetterField(table string) string {
+	return settingField(table, "publishing", "dead-letters")
+}
+
+// publishKey is the message key of a row's events: the row's fact
+// namespace and ID, unique across accounts and tables
+func publishKey(ev plugin.RowEvent) string {
+	return ev.UserID + "/" + ev.Table + "/" + ev.Row
+}
+
+// publishedChange renders a row event as a Debezium change event. Row
+// events do not carry the values a write replaced, so Before only holds the
+// row ID, and only for deletes and expiries, as Debezium reports changes of
+// tables without a full replica identity.
+func (s *Server) publishedChange(ev plugin.RowEvent, now time.Time) changeEvent {
+	ce := changeEvent{
+		Source: changeSource{
+			Version:   strings.TrimPrefix(apiVersion, "/"),
+			Connector: changeConnector,
+			Name:      changeConnector,
+			TsMs:      ev.Timestamp.UnixMilli(),
+			Snapshot:  "false",
+			DB:        ev.UserID,
+			Table:     ev.Table,
+			Region:    s.config.Region,
+		},
+		TsMs: now.UnixMilli(),
+	}
+	switch ev.Type {
+	case automationEventCreate:
+		ce.Op = changeCreate
+		ce.After = rowImage(ev.Row, ev.Values)
+	case automationEventUpdate:
+		ce.Op = changeUpdate
+		ce.After = rowImage(ev.Row, ev.Values)
+	default:
+		ce.Op = changeDelete
+		ce.Before = rowImage(ev.Row, nil)
+	}
+	return ce
+}
+
+// queuePublish hands a row event to the publish worker of its row,
+// dead-lettering it when the worker's queue is full
+func (s *Server) queuePublish(ev plugin.RowEvent) {
+	h := fnv.New32a()
+	h.Write([]byte(publishKey(ev)))
+	select {
+	case s.publishQueues[h.Sum32()%uint32(len(s.publishQueues))] <- ev:
+	default:
+		s.logger.Warn("publish queue full, dead-lettering event", "table", ev.Table, "row", ev.Row)
+		s.deadLetterEvent(s.background, ev, s.publishMessage(ev, ""), 0, errPublishQueueFull)
+	}
+}
+
+// runPublisher publishes the events of one queue, in order, until ctx is
+// cancelled. A failing event holds back the later events of the queue while
+// it is retried.
+func (s *Server) runPublisher(ctx context.Context, queue chan plugin.RowEvent) {
+	defer s.publishWG.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-queue:
+			s.publishEvent(ctx, ev)
+		}
+	}
+}
+
+// drainPublishQueues dead-letters the events still queued, once the
+// workers have stopped, so none is lost when the server stops
+func (s *Server) drainPublishQueues(ctx context.Context) {
+	for _, queue := range s.publishQueues {
+		for len(queue) > 0 {
+			ev := <-queue
+			s.deadLetterEvent(ctx, ev, s.publishMessage(ev, ""), 0, context.Canceled)
+		}
+	}
+}
+
+// publishMessage returns the message publishing a row event to topic
+func (s *Server) publishMessage(ev plugin.RowEvent, topic string) publish.Message {
+	body, _ := json.Marshal(s.publishedChange(ev, time.Now().UTC()))
+	return publish.Message{Topic: topic, Key: publishKey(ev), ID: newID(), Body: body}
+}
+
+// publishEvent publishes a row event to its table's topic, if the table
+// publishes, and dead-letters it if every attempt fails
+func (s *Server) publishEvent(ctx context.Context, ev plugin.RowEvent) {
+	topic, err := s.eventTopic(ctx, ev)
+	if err == nil && topic == "" {
+		return
+	}
+	msg := s.publishMessage(ev, topic)
+	attempts := 0
+	if err == nil {
+		attempts, err = publish.Deliver(ctx, s.publisher, s.config.Publish.Policy, msg)
+	}
+	if err != nil {
+		s.logger.WarnContext(ctx, "publishing change event failed", "table", ev.Table, "row", ev.Row, "topic", topic, "attempts", attempts, "error", err)
+		// Record the failure even when the server is stopping
+		s.deadLetterEvent(context.WithoutCancel(ctx), ev, msg, attempts, err)
+		return
+	}
+	s.metrics.ObservePublish("published")
+}
+
+// eventTopic returns the topic a row event is published to, or "" when its
+// table does not publish. Secrets tables never publish.
+func (s *Server) eventTopic(ctx context.Context, ev plugin.RowEvent) (string, error) {
+	store, err := s.getStoreForUser(ctx, ev.UserID)
+	if err != nil {
+		return "", err
+	}
+	defs, err := store.QueryByField(ctx, ev.UserID, ev.Table, time.Time{}, time.Now().UTC())
+	if err != nil || !tableLive(defs) {
+		return "", err
+	}
+	if tableOptionsOf(latestTableDef(defs)).Type == tableTypeSecrets {
+		return "", nil
+	}
+	setting, err := s.loadPublishing(ctx, store, ev.UserID, ev.Table, defs)
+	if err != nil {
+		return "", err
+	}
+	info := s.publishingInfo(setting)
+	if !info.Enabled {
+		return "", nil
+	}
+	return info.Topic, nil
+}
+
+// deadLetterEvent records a change event that could not be published
+func (s *Server) deadLetterEvent(ctx context.Context, ev plugin.RowEvent, msg publish.Message, attempts int, cause error) {
+	s.metrics.ObservePublish("dead_lettered")
+	dead := PublishDeadLetter{
+		ID:       newID(),
+		Topic:    msg.Topic,
+		Key:      msg.Key,
+		Event:    msg.Body,
+		Attempts: attempts,
+		Error:    cause.Error(),
+		FailedAt: time.Now().UTC(),
+	}
+	err := func() error {
+		store, err := s.getStoreForUser(ctx, ev.UserID)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(dead)
+		if err != nil {
+			return err
+		}
+		return store.PutFact(ctx, dynamo.Fact{
+			ID:        dead.ID,
+			Timestamp: dead.FailedAt,
+			Namespace: ev.UserID,
+			FieldName: publishDeadLetterField(ev.Table),
+			DataType:  publishDeadLetterDataType,
+			Value:     string(data),
+		})
+	}()
+	if err != nil {
+		s.logger.ErrorContext(ctx, "recording publish dead letter failed", "table", ev.Table, "row", ev.Row, "event", string(msg.Body), "error", err)
+	}
+}
+
+// loadPublishing returns a table's publishing setting. defs are the table's
+// definition facts; settings saved before the table was last (re)created
+// are ignored.
+func (s *Server) loadPublishing(ctx context.Context, store *db.StoreAdapter, userID, table string, defs []dynamo.Fact) (tablePublishing, error) {
+	facts, err := store.QueryByField(ctx, userID, publishingField(table), time.Time{}, time.Now().UTC())
+	if err != nil {
+		return tablePublishing{}, err
+	}
+
+	var latest *dynamo.Fact
+	for i := range facts {
+		if facts[i].DataType != publishingDataType {
+			continue
+		}
+		if latest == nil || facts[i].Timestamp.After(latest.Timestamp) {
+			latest = &facts[i]
+		}
+	}
+	if latest == nil || latest.Timestamp.Before(latestTableDef(defs).Timestamp) {
+		return tablePublishing{}, nil
+	}
+
+	raw, _ := latest.Value.(string)
+	var setting tablePublishing
+	if err := json.Unmarshal([]byte(raw), &setting); err != nil {
+		return tablePublishing{}, fmt.Errorf("decoding publishing setting for table %s: %w", table, err)
+	}
+	return setting, nil
+}
+
+// publishingInfo resolves a table's setting against the server's topics
+func (s *Server) publishingInfo(setting tablePublishing) PublishingInfo {
+	topic := setting.Topic
+	if topic == "" {
+		topic = s.config.Publish.Topic
+	}
+	return PublishingInfo{Enabled: !setting.Disabled && topic != "", Topic: topic}
+}
+
+// lookupPublishingTable resolves the table of a publishing request, writing
+// the error response and returning false if it cannot be used
+func (s *Server) lookupPublishingTable(w http.ResponseWriter, r *http.Request, user *auth.User, table string) (*db.StoreAdapter, []dynamo.Fact, bool) {
+	if s.publisher == nil {
+		writeError(w, http.StatusNotImplemented, "Publishing is not configured on this server")
+		return nil, nil, false
+	}
+	return s.lookupWebhookTable(w, r, user, table)
+}
+
+// handleGetPublishing returns whether and where a table publishes its
+// change events
+func (s *Server) handleGetPublishing(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+	store, facts, ok := s.lookupPublishingTable(w, r, user, table)
+	if !ok {
+		return
+	}
+	if tableOptionsOf(latestTableDef(facts)).Type == tableTypeSecrets {
+		writeJSON(w, http.StatusOK, PublishingInfo{})
+		return
+	}
+
+	setting, err := s.loadPublishing(r.Context(), store, user.ID, table, facts)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load publishing setting")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.publishingInfo(setting))
+}
+
+// handlePutPublishing turns a table's publishing on or off and chooses its
+// topic among those the server allows
+func (s *Server) handlePutPublishing(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+
+	var req struct {
+		Enabled *bool  `json:"enabled"`
+		Topic   string `json:"topic"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	store, facts, ok := s.lookupPublishingTable(w, r, user, table)
+	if !ok {
+		return
+	}
+	if tableOptionsOf(latestTableDef(facts)).Type == tableTypeSecrets {
+		writeError(w, http.StatusBadRequest, "Secrets tables cannot publish change events")
+		return
+	}
+
+	setting := tablePublishing{Disabled: req.Enabled != nil && !*req.Enabled, Topic: req.Topic}
+	var fields fieldErrors
+	if req.Topic != "" && !s.config.Publish.topicAllowed(req.Topic) {
+		fields = append(fields, FieldError{Field: "topic", Message: fmt.Sprintf("topic '%s' is not an allowed topic", req.Topic)})
+	}
+	if !setting.Disabled && s.publishingInfo(setting).Topic == "" {
+		fields = append(fields, FieldError{Field: "topic", Message: "is required: the server has no default topic"})
+	}
+	if len(fields) > 0 {
+		writeValidationError(w, "Invalid publishing setting", fields)
+		return
+	}
+
+	data, err := json.Marshal(setting)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to encode publishing setting")
+		return
+	}
+	if err := store.PutFact(r.Context(), dynamo.Fact{
+		ID:        newID(),
+		Timestamp: time.Now().UTC(),
+		Namespace: user.ID,
+		FieldName: publishingField(table),
+		DataType:  publishingDataType,
+		Value:     string(data),
+	}); err != nil {
+		writeStoreError(w, err, "Failed to save publishing setting")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.publishingInfo(setting))
+}
+
+// handleListPublishDeadLetters returns a table's change events that could
+// not be published, newest first
+func (s *Server) handleListPublishDeadLetters(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+	store, facts, ok := s.lookupPublishingTable(w, r, user, table)
+	if !ok {
+		return
+	}
+
+	entries, err := store.QueryByField(r.Context(), user.ID, publishDeadLetterField(table), latestTableDef(facts).Timestamp, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to load dead letters")
+		return
+	}
+
+	letters := []PublishDeadLetter{}
+	for _, f := range entries {
+		if f.DataType != publishDeadLetterDataType {
+			continue
+		}
+		raw, _ := f.Value.(string)
+		var dl PublishDeadLetter
+		if err := json.Unmarshal([]byte(raw), &dl); err != nil {
+			s.logger.WarnContext(r.Context(), "invalid publish dead letter", "table", table, "id", f.ID, "error", err)
+			continue
+		}
+		letters = append(letters, dl)
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].FailedAt.After(letters[j].FailedAt) })
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"deadLetters": letters})
+}
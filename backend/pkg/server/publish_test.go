@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/pkg/backoff"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/elibdev/notably/pkg/publish"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePublisher records published messages, refusing those of topic fail
+type fakePublisher struct {
+	mu       sync.Mutex
+	fail     string
+	messages []publish.Message
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, m publish.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if m.Topic == p.fail {
+		return &publish.Error{StatusCode: http.StatusNotFound, Code: "NotFound", Message: "no such topic"}
+	}
+	p.messages = append(p.messages, m)
+	return nil
+}
+
+// publishServer returns an in-memory server publishing to pub and a
+// function that sends requests to it as a registered user
+func publishServer(t *testing.T, pub publish.Publisher) (*Server, func(method, path, body string) *httptest.ResponseRecorder) {
+	t.Helper()
+	srv, err := NewServer(Config{
+		TableName: "Facts",
+		Logger:    logging.Discard(),
+		InMemory:  true,
+		MasterKey: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{5}, 32)),
+		Publisher: pub,
+		Publish: PublishConfig{
+			Topic:  "rows",
+			Topics: []string{"audit", "dead"},
+			Policy: backoff.Policy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+		},
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+	require.NoError(t, err)
+
+	return srv, func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+}
+
+// runPublishQueues publishes every queued event, as the workers would
+func runPublishQueues(srv *Server) {
+	for _, queue := range srv.publishQueues {
+		for len(queue) > 0 {
+			srv.publishEvent(context.Background(), <-queue)
+		}
+	}
+}
+
+func TestPublishChangeEvents(t *testing.T) {
+	pub := &fakePublisher{fail: "dead"}
+	srv, do := publishServer(t, pub)
+
+	rec := do(http.MethodPost, "/tables", `{"name": "orders"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodGet, "/tables/orders/publishing", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.JSONEq(t, `{"enabled": true, "topic": "rows"}`, rec.Body.String())
+
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/orders/rows", `{"id": "o1", "values": {"status": "open"}}`).Code)
+	require.Equal(t, http.StatusOK, do(http.MethodPut, "/tables/orders/rows/o1", `{"values": {"status": "paid"}}`).Code)
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/tables/orders/rows/o1", "").Code)
+	runPublishQueues(srv)
+
+	require.Len(t, pub.messages, 3)
+	var ops []string
+	for _, m := range pub.messages {
+		assert.Equal(t, "rows", m.Topic)
+		assert.True(t, strings.HasSuffix(m.Key, "/orders/o1"), m.Key)
+		var ev changeEvent
+		require.NoError(t, json.Unmarshal(m.Body, &ev))
+		assert.Equal(t, "orders", ev.Source.Table)
+		ops = append(ops, ev.Op)
+	}
+	assert.Equal(t, []string{"c", "u", "d"}, ops, "a row's events keep their order")
+	var update, del changeEvent
+	require.NoError(t, json.Unmarshal(pub.messages[1].Body, &update))
+	assert.Equal(t, map[string]interface{}{"id": "o1", "status": "paid"}, update.After)
+	require.NoError(t, json.Unmarshal(pub.messages[2].Body, &del))
+	assert.Equal(t, map[string]interface{}{"id": "o1"}, del.Before)
+	assert.Nil(t, del.After)
+
+	// Tables choose among the allowed topics, or stop publishing
+	rec = do(http.MethodPut, "/tables/orders/publishing", `{"topic": "elsewhere"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+	rec = do(http.MethodPut, "/tables/orders/publishing", `{"topic": "audit"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.JSONEq(t, `{"enabled": true, "topic": "audit"}`, rec.Body.String())
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/orders/rows", `{"id": "o2", "values": {}}`).Code)
+	runPublishQueues(srv)
+	require.Len(t, pub.messages, 4)
+	assert.Equal(t, "audit", pub.messages[3].Topic)
+
+	rec = do(http.MethodPut, "/tables/orders/publishing", `{"enabled": false}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/orders/rows", `{"id": "o3", "values": {}}`).Code)
+	runPublishQueues(srv)
+	assert.Len(t, pub.messages, 4)
+
+	// Events the broker refuses are dead-lettered
+	require.Equal(t, http.StatusOK, do(http.MethodPut, "/tables/orders/publishing", `{"topic": "dead"}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/orders/rows", `{"id": "o4", "values": {}}`).Code)
+	runPublishQueues(srv)
+	// Events still queued when the server stops are dead-lettered too
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/orders/rows", `{"id": "o5", "values": {}}`).Code)
+	require.NoError(t, srv.Stop(context.Background()))
+
+	rec = do(http.MethodGet, "/tables/orders/publishing/dead-letters", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var resp struct {
+		DeadLetters []PublishDeadLetter `json:"deadLetters"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.DeadLetters, 2)
+	stopped, refused := resp.DeadLetters[0], resp.DeadLetters[1]
+	assert.Equal(t, "dead", refused.Topic)
+	assert.Equal(t, 1, refused.Attempts, "refusals that will not clear are not retried")
+	assert.Contains(t, refused.Error, "no such topic")
+	assert.True(t, strings.HasSuffix(stopped.Key, "/orders/o5"), stopped.Key)
+	assert.Zero(t, stopped.Attempts)
+	var ev changeEvent
+	require.NoError(t, json.Unmarshal(stopped.Event, &ev))
+	assert.Equal(t, "c", ev.Op)
+}
+
+func TestPublishSkipsSecretsTables(t *testing.T) {
+	pub := &fakePublisher{}
+	srv, do := publishServer(t, pub)
+	rec := do(http.MethodPost, "/tables", `{"name": "vault", "type": "secrets"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/tables/vault/publishing", `{"topic": "audit"}`).Code)
+	rec = do(http.MethodGet, "/tables/vault/publishing", "")
+	assert.JSONEq(t, `{"enabled": false}`, rec.Body.String())
+
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/vault/rows", `{"id": "github", "values": {"token": "ghp_secret"}}`).Code)
+	runPublishQueues(srv)
+	assert.Empty(t, pub.messages)
+}
+
+func TestPublishingNotConfigured(t *testing.T) {
+	_, do := memoryServer(t)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "orders"}`).Code)
+	assert.Equal(t, http.StatusNotImplemented, do(http.MethodGet, "/tables/orders/publishing", "").Code)
+	assert.Equal(t, http.StatusNotImplemented, do(http.MethodPut, "/tables/orders/publishing", `{"topic": "rows"}`).Code)
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/elibdev/notably/pkg/auth"
+)
+
+// RateLimitConfig sets token-bucket budgets, in requests per minute. Reads
+// (GET and HEAD) and writes are budgeted separately so a bulk importer cannot
+// starve reads. Zero disables the corresponding limit.
+type RateLimitConfig struct {
+	// ReadPerMinute and WritePerMinute apply to each API key
+	ReadPerMinute  int
+	WritePerMinute int
+	// Burst is the bucket size; it defaults to the per-minute rate
+	Burst int
+	// UserMultiplier scales the per-key budgets into account-wide budgets,
+	// so creating more keys does not raise a user's limit indefinitely
+	UserMultiplier int
+}
+
+// rateLimitConfigFromEnv reads the rate limit settings from the environment
+func rateLimitConfigFromEnv() RateLimitConfig {
+	return RateLimitConfig{
+		ReadPerMinute:  envInt("NOTABLY_RATE_LIMIT_READ", 0),
+		WritePerMinute: envInt("NOTABLY_RATE_LIMIT_WRITE", 0),
+		Burst:          envInt("NOTABLY_RATE_LIMIT_BURST", 0),
+		UserMultiplier: envInt("NOTABLY_RATE_LIMIT_USER_MULTIPLIER", 4),
+	}
+}
+
+// bucket is a token bucket refilled continuously
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter tracks token buckets by key
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// idleBucketTTL is how long an untouched bucket is kept; a full bucket is
+// indistinguishable from a missing one, so dropping idle buckets is safe
+const idleBucketTTL = 10 * time.Minute
+
+// budget is a rate for one bucket of the limiter
+type budget struct {
+	key       string
+	perMinute int
+	burst     int
+}
+
+// allow takes a token from the bucket for key. When the bucket is empty it
+// reports how long until a token is available.
+func (l *rateLimiter) allow(key string, perMinute, burst int) (bool, time.Duration) {
+	return l.allowAll(budget{key: key, perMinute: perMinute, burst: burst})
+}
+
+// allowAll takes a token from the bucket of every budget, or from none of
+// them when any is empty, so a request one budget refuses does not use up
+// the others. When refused it reports the longest wait for a token.
+func (l *rateLimiter) allowAll(budgets ...budget) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) > idleBucketTTL {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > idleBucketTTL {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	allowed, wait := true, time.Duration(0)
+	var taken []*bucket
+	for _, bg := range budgets {
+		if bg.perMinute <= 0 {
+			continue
+		}
+		burst := bg.burst
+		if burst <= 0 {
+			burst = bg.perMinute
+		}
+		rate := float64(bg.perMinute) / 60 // tokens per second
+
+		b, ok := l.buckets[bg.key]
+		if !ok {
+			b = &bucket{tokens: float64(burst), last: now}
+			l.buckets[bg.key] = b
+		}
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+		b.last = now
+
+		if b.tokens < 1 {
+			allowed = false
+			wait = max(wait, time.Duration((1-b.tokens)/rate*float64(time.Second)))
+		}
+		taken = append(taken, b)
+	}
+	if !allowed {
+		return false, wait
+	}
+	for _, b := range taken {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// requireAuth wraps a handler with authentication and rate limiting, and
+// records the caller as the actor of the facts it writes
+func (s *Server) requireAuth(h http.HandlerFunc) http.Handler {
+	return s.authenticator.RequireAuth(recordUser(withActor(checkScopes(s.rateLimit(h)))))
+}
+
+// rateLimit enforces the per-key and per-user budgets for authenticated requests
+func (s *Server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := s.config.RateLimit
+		key, ok := auth.APIKeyFromContext(r.Context())
+		if !ok || (cfg.ReadPerMinute <= 0 && cfg.WritePerMinute <= 0) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		kind, perMinute := "read", cfg.ReadPerMinute
+		if !readRequest(r) {
+			kind, perMinute = "write", cfg.WritePerMinute
+		}
+		multiplier := cfg.UserMultiplier
+		if multiplier <= 0 {
+			multiplier = 1
+		}
+		burst := cfg.Burst
+		if burst <= 0 {
+			burst = perMinute
+		}
+
+		allowed, wait := s.limiter.allowAll(
+			budget{key: "key:" + kind + ":" + key.ID, perMinute: perMinute, burst: burst},
+			budget{key: "user:" + kind + ":" + key.UserID, perMinute: perMinute * multiplier, burst: burst * multiplier},
+		)
+		if !allowed {
+			s.metrics.ObserveRateLimited(kind)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, fmt.Sprintf("Rate limit exceeded for %s requests", kind))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
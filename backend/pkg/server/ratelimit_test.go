@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiterRefills(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newRateLimiter()
+	l.now = func() time.Time { return now }
+
+	// 60/min with a burst of 2: two immediate requests, then one per second
+	ok, _ := l.allow("k", 60, 2)
+	assert.True(t, ok)
+	ok, _ = l.allow("k", 60, 2)
+	assert.True(t, ok)
+	ok, wait := l.allow("k", 60, 2)
+	assert.False(t, ok)
+	assert.InDelta(t, time.Second, wait, float64(time.Millisecond))
+
+	now = now.Add(time.Second)
+	ok, _ = l.allow("k", 60, 2)
+	assert.True(t, ok)
+
+	// Other keys have their own buckets, and a zero rate is unlimited
+	ok, _ = l.allow("other", 60, 2)
+	assert.True(t, ok)
+	ok, _ = l.allow("k", 0, 0)
+	assert.True(t, ok)
+}
+
+func TestRateLimiterTakesFromAllOrNone(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newRateLimiter()
+	l.now = func() time.Time { return now }
+
+	// A user budget of 1 refuses the second request of each key
+	user := budget{key: "user", perMinute: 60, burst: 1}
+	ok, _ := l.allowAll(budget{key: "a", perMinute: 60, burst: 2}, user)
+	assert.True(t, ok)
+	ok, wait := l.allowAll(budget{key: "a", perMinute: 60, burst: 2}, user)
+	assert.False(t, ok)
+	assert.InDelta(t, time.Second, wait, float64(time.Millisecond))
+	ok, _ = l.allowAll(budget{key: "b", perMinute: 60, burst: 1}, user)
+	assert.False(t, ok)
+
+	// The refused requests took nothing from the key budgets
+	ok, _ = l.allow("a", 60, 2)
+	assert.True(t, ok)
+	ok, _ = l.allow("a", 60, 2)
+	assert.False(t, ok, "key a spent one token of two")
+	ok, _ = l.allow("b", 60, 1)
+	assert.True(t, ok)
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	srv, err := NewServer(Config{
+		TableName: "Facts",
+		Logger:    logging.Discard(),
+		RateLimit: RateLimitConfig{ReadPerMinute: 1, WritePerMinute: 0, UserMultiplier: 1},
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "bob", "bob@example.com", "pw")
+	require.NoError(t, err)
+	_, key, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "k", 0)
+	require.NoError(t, err)
+
+	h := srv.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	do := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, do(http.MethodGet).Code)
+	rec := do(http.MethodGet)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	// Writes have a separate (here unlimited) budget
+	assert.Equal(t, http.StatusOK, do(http.MethodPost).Code)
+}
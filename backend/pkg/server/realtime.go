@@ -0,0 +1,590 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/presence"
+)
+
+// Collaborative clients keep a WebSocket open at /realtime to see who else
+// is viewing or editing a table, and to take advisory locks on the rows
+// they edit. Presence and locks are ephemeral: sessions renew them while
+// connected, and they expire when a session's server stops renewing. They
+// are kept per table in the user's namespace, in memory or, for deployments
+// of several replicas, in Redis, whose pub/sub tells every replica to
+// rebroadcast a table's presence when it changes.
+
+const (
+	// presenceTTL is how long a session stays present without a renewal;
+	// servers renew their sessions every third of it
+	presenceTTL = 30 * time.Second
+
+	// defaultLockTTL and maxLockTTL bound how long a lock lasts unless its
+	// holder takes it again
+	defaultLockTTL = 30 * time.Second
+	maxLockTTL     = 10 * time.Minute
+
+	// realtimeProtocol is the WebSocket subprotocol of /realtime. Browsers
+	// cannot set an Authorization header on WebSockets, so they offer the
+	// API key as a second subprotocol, bearer.<key>.
+	realtimeProtocol     = "notably"
+	bearerProtocolPrefix = "bearer."
+
+	// realtimeMaxMessage bounds the messages clients send
+	realtimeMaxMessage = 64 << 10
+	// realtimeQueueSize is how many messages may wait for a slow client
+	// before its connection is dropped
+	realtimeQueueSize = 64
+	// realtimeWriteTimeout bounds sending one message to a client
+	realtimeWriteTimeout = 10 * time.Second
+
+	// maxPresenceName bounds the names sessions present themselves with
+	maxPresenceName = 100
+)
+
+// Presence states of a session in a table
+const (
+	presenceViewing = "viewing"
+	presenceEditing = "editing"
+)
+
+// RealtimeConfig selects where presence and locks are kept
+type RealtimeConfig struct {
+	// RedisAddr is the host:port of a Redis server shared by the replicas;
+	// without it presence is kept in memory and seen by one server only
+	RedisAddr     string
+	RedisPassword string
+}
+
+// realtimeConfigFromEnv reads the realtime settings from the environment
+func realtimeConfigFromEnv() RealtimeConfig {
+	return RealtimeConfig{
+		RedisAddr:     os.Getenv("NOTABLY_REDIS_ADDR"),
+		RedisPassword: os.Getenv("NOTABLY_REDIS_PASSWORD"),
+	}
+}
+
+// newPresenceStore returns the configured presence store
+func newPresenceStore(config Config) presence.Store {
+	switch {
+	case config.Presence != nil:
+		return config.Presence
+	case config.Realtime.RedisAddr != "":
+		return presence.NewRedisStore(config.Realtime.RedisAddr, config.Realtime.RedisPassword)
+	}
+	return presence.NewMemoryStore()
+}
+
+// realtimeRequest is a message from a client. Ref, if set, is echoed in
+// the reply.
+type realtimeRequest struct {
+	Type  string `json:"type"`
+	Ref   string `json:"ref,omitempty"`
+	Table string `json:"table"`
+	Row   string `json:"row,omitempty"`
+	State string `json:"state,omitempty"`
+	Name  string `json:"name,omitempty"`
+	TTL   string `json:"ttl,omitempty"`
+}
+
+// realtimeReply answers a client's message, or greets it with its session
+type realtimeReply struct {
+	Type    string         `json:"type"`
+	Ref     string         `json:"ref,omitempty"`
+	Session string         `json:"session,omitempty"`
+	Lock    *presence.Lock `json:"lock,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// presenceMessage tells the sessions in a table who is there
+type presenceMessage struct {
+	Type    string            `json:"type"`
+	Table   string            `json:"table"`
+	Members []presence.Member `json:"members"`
+	Locks   []presence.Lock   `json:"locks"`
+}
+
+// realtimeSession is the state of one WebSocket connection
+type realtimeSession struct {
+	id       string
+	userID   string
+	apiKeyID string
+	out      chan interface{}
+	closed   chan struct{}
+	close    sync.Once
+
+	mu     sync.Mutex
+	joined map[string]presence.Member          // by table
+	locks  map[string]map[string]presence.Lock // by table and row
+}
+
+// send queues msg for the client, dropping the connection when the client
+// has fallen too far behind
+func (c *realtimeSession) send(msg interface{}) {
+	select {
+	case c.out <- msg:
+	case <-c.closed:
+	default:
+		c.shutdown()
+	}
+}
+
+// shutdown ends the connection
+func (c *realtimeSession) shutdown() {
+	c.close.Do(func() { close(c.closed) })
+}
+
+// realtimeHub fans presence changes out to the local sessions of each table
+type realtimeHub struct {
+	store presence.Store
+	start sync.Once
+
+	mu       sync.Mutex
+	sessions map[string]map[*realtimeSession]bool // by scope
+	last     map[string]string                    // digest of the last broadcast, by scope
+}
+
+// scopeSessions returns the local sessions joined to scope
+func (h *realtimeHub) scopeSessions(scope string) []*realtimeSession {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sessions := make([]*realtimeSession, 0, len(h.sessions[scope]))
+	for c := range h.sessions[scope] {
+		sessions = append(sessions, c)
+	}
+	return sessions
+}
+
+func (h *realtimeHub) add(scope string, c *realtimeSession) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sessions[scope] == nil {
+		h.sessions[scope] = make(map[*realtimeSession]bool)
+	}
+	h.sessions[scope][c] = true
+}
+
+func (h *realtimeHub) remove(scope string, c *realtimeSession) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.sessions[scope], c)
+	if len(h.sessions[scope]) == 0 {
+		delete(h.sessions, scope)
+		delete(h.last, scope)
+	}
+}
+
+// presenceDigest sums up what a presence broadcast shows, leaving out the
+// expiry times that every renewal moves
+func presenceDigest(members []presence.Member, locks []presence.Lock) string {
+	var b strings.Builder
+	for _, m := range members {
+		fmt.Fprintf(&b, "m\x00%s\x00%s\x00%s\x00%s\x00", m.Session, m.Name, m.State, m.Row)
+	}
+	for _, l := range locks {
+		fmt.Fprintf(&b, "l\x00%s\x00%s\x00", l.Row, l.Session)
+	}
+	return b.String()
+}
+
+// broadcastPresence sends the presence of scope to its local sessions. Unless
+// force is set it skips broadcasts showing nothing new.
+func (s *Server) broadcastPresence(ctx context.Context, scope string, force bool) {
+	h := s.realtime
+	sessions := h.scopeSessions(scope)
+	if len(sessions) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	members, err := h.store.Members(ctx, scope, now)
+	if err != nil {
+		s.logger.Error("failed to read presence", "scope", scope, "error", err)
+		return
+	}
+	locks, err := h.store.Locks(ctx, scope, now)
+	if err != nil {
+		s.logger.Error("failed to read row locks", "scope", scope, "error", err)
+		return
+	}
+
+	digest := presenceDigest(members, locks)
+	h.mu.Lock()
+	if !force && h.last[scope] == digest {
+		h.mu.Unlock()
+		return
+	}
+	h.last[scope] = digest
+	h.mu.Unlock()
+
+	_, table, _ := parseTableNamespace(scope)
+	msg := presenceMessage{Type: "presence", Table: table, Members: members, Locks: locks}
+	for _, c := range sessions {
+		c.send(msg)
+	}
+}
+
+// runPresenceHub rebroadcasts the presence of tables as other sessions
+// change it, and renews the presence of the local sessions until ctx is
+// done. Renewals also catch sessions and locks that expired.
+func (s *Server) runPresenceHub(ctx context.Context) {
+	go func() {
+		for {
+			err := s.realtime.store.Subscribe(ctx, func(scope string) {
+				s.broadcastPresence(ctx, scope, true)
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.Error("presence subscription failed", "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(presenceTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.renewPresence(ctx)
+		}
+	}
+}
+
+// renewPresence extends the presence of every local session, and
+// rebroadcasts the tables whose presence changed
+func (s *Server) renewPresence(ctx context.Context) {
+	h := s.realtime
+	h.mu.Lock()
+	scopes := make([]string, 0, len(h.sessions))
+	for scope := range h.sessions {
+		scopes = append(scopes, scope)
+	}
+	h.mu.Unlock()
+
+	expires := time.Now().UTC().Add(presenceTTL)
+	for _, scope := range scopes {
+		_, table, _ := parseTableNamespace(scope)
+		for _, c := range h.scopeSessions(scope) {
+			c.mu.Lock()
+			m, ok := c.joined[table]
+			if ok {
+				m.ExpiresAt = expires
+				c.joined[table] = m
+			}
+			c.mu.Unlock()
+			if !ok {
+				continue
+			}
+			if err := h.store.Join(ctx, scope, m); err != nil {
+				s.logger.Error("failed to renew presence", "scope", scope, "error", err)
+			}
+		}
+		s.broadcastPresence(ctx, scope, false)
+	}
+}
+
+// withProtocolAuth lets WebSocket clients that cannot set headers
+// authenticate with a bearer.<key> subprotocol
+func withProtocolAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			for _, p := range strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",") {
+				if key, ok := strings.CutPrefix(strings.TrimSpace(p), bearerProtocolPrefix); ok {
+					r.Header.Set("Authorization", "Bearer "+key)
+					break
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hijacker lets the WebSocket server take over connections through the
+// writers the middleware wraps them in
+type hijacker struct {
+	http.ResponseWriter
+}
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(h.ResponseWriter).Hijack()
+}
+
+// handleRealtime upgrades the request to a WebSocket carrying presence and
+// row locks
+func (s *Server) handleRealtime(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		writeError(w, http.StatusBadRequest, "Expected a WebSocket upgrade")
+		return
+	}
+	s.realtime.start.Do(func() { go s.runPresenceHub(s.background) })
+
+	c := &realtimeSession{
+		id:     newID(),
+		userID: user.ID,
+		out:    make(chan interface{}, realtimeQueueSize),
+		closed: make(chan struct{}),
+		joined: make(map[string]presence.Member),
+		locks:  make(map[string]map[string]presence.Lock),
+	}
+	if key, ok := auth.APIKeyFromContext(r.Context()); ok {
+		c.apiKeyID = key.ID
+	}
+
+	ws := websocket.Server{
+		// Clients authenticate with their API key, so any origin may connect
+		Handshake: func(config *websocket.Config, _ *http.Request) error {
+			var protocols []string
+			for _, p := range config.Protocol {
+				if p == realtimeProtocol {
+					protocols = []string{realtimeProtocol}
+				}
+			}
+			config.Protocol = protocols
+			return nil
+		},
+		Handler: func(conn *websocket.Conn) {
+			conn.MaxPayloadBytes = realtimeMaxMessage
+			conn.SetDeadline(time.Time{})
+			s.serveRealtime(r.Context(), conn, c, user)
+		},
+	}
+	ws.ServeHTTP(hijacker{w}, r)
+}
+
+// serveRealtime runs a session until the client or the server closes it
+func (s *Server) serveRealtime(ctx context.Context, conn *websocket.Conn, c *realtimeSession, user *auth.User) {
+	defer s.endRealtimeSession(c)
+
+	go func() {
+		select {
+		case <-c.closed:
+		case <-s.background.Done():
+		}
+		c.shutdown()
+		conn.Close()
+	}()
+	go func() {
+		for {
+			select {
+			case <-c.closed:
+				return
+			case msg := <-c.out:
+				conn.SetWriteDeadline(time.Now().Add(realtimeWriteTimeout))
+				if err := websocket.JSON.Send(conn, msg); err != nil {
+					c.shutdown()
+					return
+				}
+			}
+		}
+	}()
+
+	c.send(realtimeReply{Type: "hello", Session: c.id})
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(conn, &data); err != nil {
+			c.shutdown()
+			return
+		}
+		var req realtimeRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.send(realtimeReply{Type: "error", Error: "Invalid JSON message"})
+			continue
+		}
+		reply, changed := s.handleRealtimeRequest(ctx, c, user, req)
+		c.send(reply)
+		if changed != "" {
+			s.notifyPresence(ctx, changed)
+		}
+	}
+}
+
+// handleRealtimeRequest carries out a client's message. It returns the reply
+// and, when the message changed a table's presence, the table's scope.
+func (s *Server) handleRealtimeRequest(ctx context.Context, c *realtimeSession, user *auth.User, req realtimeRequest) (realtimeReply, string) {
+	fail := func(format string, args ...interface{}) (realtimeReply, string) {
+		return realtimeReply{Type: "error", Ref: req.Ref, Error: fmt.Sprintf(format, args...)}, ""
+	}
+	if req.Type == "ping" {
+		return realtimeReply{Type: "ok", Ref: req.Ref}, ""
+	}
+	if req.Table == "" {
+		return fail("Missing table")
+	}
+	scope := tableNamespace(user.ID, req.Table)
+	now := time.Now().UTC()
+	h := s.realtime
+
+	switch req.Type {
+	case "join":
+		state := req.State
+		if state == "" {
+			state = presenceViewing
+		}
+		if state != presenceViewing && state != presenceEditing {
+			return fail("State must be %s or %s", presenceViewing, presenceEditing)
+		}
+		if len(req.Name) > maxPresenceName {
+			return fail("Name must be at most %d characters", maxPresenceName)
+		}
+		store, err := s.getStoreForUser(ctx, user.ID)
+		if err != nil {
+			return fail("Failed to initialize storage")
+		}
+		facts, err := store.QueryByField(ctx, user.ID, req.Table, time.Time{}, now)
+		if err != nil {
+			return fail("Failed to look up table")
+		}
+		if !s.tableVisible(ctx, facts) {
+			return fail("Table '%s' not found", req.Table)
+		}
+
+		name := req.Name
+		if name == "" {
+			name = user.Username
+		}
+		c.mu.Lock()
+		m, rejoined := c.joined[req.Table]
+		if !rejoined {
+			m = presence.Member{Session: c.id, UserID: c.userID, APIKeyID: c.apiKeyID, Since: now}
+		}
+		m.Name, m.State, m.Row, m.ExpiresAt = name, state, req.Row, now.Add(presenceTTL)
+		c.joined[req.Table] = m
+		c.mu.Unlock()
+		h.add(scope, c)
+		if err := h.store.Join(ctx, scope, m); err != nil {
+			s.logger.Error("failed to record presence", "scope", scope, "error", err)
+			return fail("Failed to record presence")
+		}
+
+	case "leave":
+		c.mu.Lock()
+		_, joined := c.joined[req.Table]
+		c.mu.Unlock()
+		if !joined {
+			return fail("Not joined to table '%s'", req.Table)
+		}
+		s.leaveRealtimeTable(ctx, c, req.Table)
+
+	case "lock":
+		if req.Row == "" {
+			return fail("Missing row")
+		}
+		ttl := defaultLockTTL
+		if req.TTL != "" {
+			d, err := time.ParseDuration(req.TTL)
+			if err != nil || d <= 0 || d > maxLockTTL {
+				return fail("TTL must be a positive duration of at most %s", maxLockTTL)
+			}
+			ttl = d
+		}
+		c.mu.Lock()
+		m, joined := c.joined[req.Table]
+		c.mu.Unlock()
+		if !joined {
+			return fail("Join table '%s' before locking its rows", req.Table)
+		}
+		l := presence.Lock{Row: req.Row, Session: c.id, UserID: c.userID, Name: m.Name, ExpiresAt: now.Add(ttl)}
+		held, err := h.store.Lock(ctx, scope, l, now)
+		if errors.Is(err, presence.ErrLocked) {
+			return realtimeReply{Type: "locked", Ref: req.Ref, Lock: &held}, ""
+		}
+		if err != nil {
+			s.logger.Error("failed to take row lock", "scope", scope, "error", err)
+			return fail("Failed to take row lock")
+		}
+		c.mu.Lock()
+		if c.locks[req.Table] == nil {
+			c.locks[req.Table] = make(map[string]presence.Lock)
+		}
+		c.locks[req.Table][req.Row] = held
+		c.mu.Unlock()
+		return realtimeReply{Type: "ok", Ref: req.Ref, Lock: &held}, scope
+
+	case "unlock":
+		if req.Row == "" {
+			return fail("Missing row")
+		}
+		if err := h.store.Unlock(ctx, scope, req.Row, c.id); err != nil {
+			s.logger.Error("failed to release row lock", "scope", scope, "error", err)
+			return fail("Failed to release row lock")
+		}
+		c.mu.Lock()
+		delete(c.locks[req.Table], req.Row)
+		c.mu.Unlock()
+
+	default:
+		return fail("Type must be join, leave, lock, unlock or ping")
+	}
+	return realtimeReply{Type: "ok", Ref: req.Ref}, scope
+}
+
+// notifyPresence tells every server that the presence of scope changed
+func (s *Server) notifyPresence(ctx context.Context, scope string) {
+	if err := s.realtime.store.Notify(ctx, scope); err != nil {
+		s.logger.Error("failed to notify presence change", "scope", scope, "error", err)
+	}
+}
+
+// leaveRealtimeTable removes a session from a table and frees its locks
+// there, without notifying
+func (s *Server) leaveRealtimeTable(ctx context.Context, c *realtimeSession, table string) {
+	h := s.realtime
+	scope := tableNamespace(c.userID, table)
+	c.mu.Lock()
+	delete(c.joined, table)
+	locks := c.locks[table]
+	delete(c.locks, table)
+	c.mu.Unlock()
+	h.remove(scope, c)
+
+	if err := h.store.Leave(ctx, scope, c.id); err != nil {
+		s.logger.Error("failed to remove presence", "scope", scope, "error", err)
+	}
+	for row := range locks {
+		if err := h.store.Unlock(ctx, scope, row, c.id); err != nil {
+			s.logger.Error("failed to release row lock", "scope", scope, "error", err)
+		}
+	}
+}
+
+// endRealtimeSession removes a closed session from every table it joined
+func (s *Server) endRealtimeSession(c *realtimeSession) {
+	c.shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), realtimeWriteTimeout)
+	defer cancel()
+
+	c.mu.Lock()
+	tables := make([]string, 0, len(c.joined))
+	for table := range c.joined {
+		tables = append(tables, table)
+	}
+	c.mu.Unlock()
+	for _, table := range tables {
+		s.leaveRealtimeTable(ctx, c, table)
+		s.notifyPresence(ctx, tableNamespace(c.userID, table))
+	}
+}
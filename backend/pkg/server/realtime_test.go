@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+
+	"github.com/elibdev/notably/pkg/presence"
+)
+
+// realtimeMessage holds any message the server sends on /realtime
+type realtimeMessage struct {
+	Type    string            `json:"type"`
+	Ref     string            `json:"ref"`
+	Session string            `json:"session"`
+	Error   string            `json:"error"`
+	Lock    *presence.Lock    `json:"lock"`
+	Table   string            `json:"table"`
+	Members []presence.Member `json:"members"`
+	Locks   []presence.Lock   `json:"locks"`
+}
+
+func TestRealtimePresence(t *testing.T) {
+	srv, do := memoryServer(t)
+	t.Cleanup(srv.cancel)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "bob", "bob@example.com", "pw")
+	require.NoError(t, err)
+	_, laptop, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "laptop", 0)
+	require.NoError(t, err)
+	_, phone, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "phone", 0)
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/tables", strings.NewReader(`{"name": "notes"}`))
+	req.Header.Set("Authorization", "Bearer "+laptop)
+	srv.Handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/v1/realtime"
+	dial := func(configure func(*websocket.Config)) (*websocket.Conn, string) {
+		t.Helper()
+		config, err := websocket.NewConfig(url, ts.URL)
+		require.NoError(t, err)
+		configure(config)
+		ws, err := websocket.DialConfig(config)
+		require.NoError(t, err)
+		t.Cleanup(func() { ws.Close() })
+		hello := recv(t, ws, "hello")
+		require.NotEmpty(t, hello.Session)
+		return ws, hello.Session
+	}
+	send := func(ws *websocket.Conn, msg string) {
+		t.Helper()
+		require.NoError(t, websocket.Message.Send(ws, msg))
+	}
+
+	a, sessionA := dial(func(c *websocket.Config) { c.Header.Set("Authorization", "Bearer "+laptop) })
+	// Browsers offer the key as a subprotocol
+	b, sessionB := dial(func(c *websocket.Config) { c.Protocol = []string{realtimeProtocol, bearerProtocolPrefix + phone} })
+	assert.Equal(t, []string{realtimeProtocol}, b.Config().Protocol, "the key is not echoed")
+
+	send(a, `{"type": "join", "ref": "1", "table": "notes", "state": "editing", "row": "r1", "name": "Bob's laptop"}`)
+	reply := recv(t, a, "ok")
+	assert.Equal(t, "1", reply.Ref)
+	p := recv(t, a, "presence")
+	assert.Equal(t, "notes", p.Table)
+	require.Len(t, p.Members, 1)
+	assert.Equal(t, sessionA, p.Members[0].Session)
+	assert.Equal(t, "editing", p.Members[0].State)
+	assert.Equal(t, "r1", p.Members[0].Row)
+
+	send(b, `{"type": "join", "table": "notes"}`)
+	recv(t, b, "ok")
+	for _, ws := range []*websocket.Conn{a, b} {
+		p = recvPresence(t, ws, 2)
+		names := presenceNames(p)
+		assert.Equal(t, "Bob's laptop", names[sessionA])
+		assert.Equal(t, "bob", names[sessionB], "sessions are named after their user by default")
+	}
+
+	// One session at a time holds a row's lock
+	send(a, `{"type": "lock", "ref": "2", "table": "notes", "row": "r1", "ttl": "1m"}`)
+	reply = recv(t, a, "ok")
+	require.NotNil(t, reply.Lock)
+	assert.Equal(t, sessionA, reply.Lock.Session)
+	assert.Equal(t, "2", reply.Ref)
+	send(b, `{"type": "lock", "table": "notes", "row": "r1"}`)
+	reply = recv(t, b, "locked")
+	require.NotNil(t, reply.Lock, "the holder is returned")
+	assert.Equal(t, sessionA, reply.Lock.Session)
+	assert.Equal(t, "Bob's laptop", reply.Lock.Name)
+	send(b, `{"type": "lock", "table": "other", "row": "r1"}`)
+	assert.Contains(t, recv(t, b, "error").Error, "Join table")
+	send(b, `{"type": "lock", "table": "notes", "row": "r1", "ttl": "1h"}`)
+	assert.Contains(t, recv(t, b, "error").Error, "TTL")
+	send(b, `{"type": "join", "table": "missing"}`)
+	assert.Contains(t, recv(t, b, "error").Error, "not found")
+	send(b, `not json`)
+	assert.Contains(t, recv(t, b, "error").Error, "Invalid JSON")
+
+	// Closing a session ends its presence and frees its locks
+	a.Close()
+	p = recvPresence(t, b, 1)
+	assert.Equal(t, sessionB, p.Members[0].Session)
+	assert.Empty(t, p.Locks)
+	send(b, `{"type": "lock", "table": "notes", "row": "r1"}`)
+	recv(t, b, "ok")
+
+	// Other accounts do not see the table's presence
+	carol, err := srv.authenticator.RegisterUser(ctx, "carol", "carol@example.com", "pw")
+	require.NoError(t, err)
+	_, carolKey, err := srv.authenticator.GenerateAPIKey(ctx, carol.ID, "default", 0)
+	require.NoError(t, err)
+	other, _ := dial(func(c *websocket.Config) { c.Header.Set("Authorization", "Bearer "+carolKey) })
+	send(other, `{"type": "join", "table": "notes"}`)
+	assert.Contains(t, recv(t, other, "error").Error, "not found")
+
+	rec = do(http.MethodGet, "/realtime", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "plain requests are refused")
+	config, err := websocket.NewConfig(url, ts.URL)
+	require.NoError(t, err)
+	_, err = websocket.DialConfig(config)
+	assert.Error(t, err, "sessions need an API key")
+}
+
+// recv returns the next message of the given type, skipping others
+func recv(t *testing.T, ws *websocket.Conn, typ string) realtimeMessage {
+	t.Helper()
+	require.NoError(t, ws.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for {
+		var msg realtimeMessage
+		require.NoError(t, websocket.JSON.Receive(ws, &msg), "waiting for %s", typ)
+		if msg.Type == typ {
+			return msg
+		}
+	}
+}
+
+// recvPresence returns the next presence message with n members
+func recvPresence(t *testing.T, ws *websocket.Conn, n int) realtimeMessage {
+	t.Helper()
+	for {
+		p := recv(t, ws, "presence")
+		if len(p.Members) == n {
+			return p
+		}
+	}
+}
+
+// presenceNames returns the names of a presence message's members, by session
+func presenceNames(p realtimeMessage) map[string]string {
+	names := make(map[string]string)
+	for _, m := range p.Members {
+		names[m.Session] = m.Name
+	}
+	return names
+}
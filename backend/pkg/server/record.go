@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/elibdev/notably/pkg/replay"
+)
+
+// defaultRecordSampleRate is the share of requests recorded when recording is
+// enabled without a sample rate
+const defaultRecordSampleRate = 0.01
+
+// maxRecordedBody caps the response body kept in a bundle
+const maxRecordedBody = 1 << 20
+
+// RecordConfig controls request recording for replay debugging
+type RecordConfig struct {
+	// Dir receives one bundle per recorded request, named after its request
+	// ID. Recording is disabled when it is empty.
+	Dir string
+
+	// SampleRate is the share of requests recorded, from 0 to 1
+	SampleRate float64
+}
+
+// recordConfigFromEnv reads NOTABLY_RECORD_DIR and NOTABLY_RECORD_SAMPLE_RATE
+func recordConfigFromEnv() RecordConfig {
+	return RecordConfig{
+		Dir:        os.Getenv("NOTABLY_RECORD_DIR"),
+		SampleRate: envFloat("NOTABLY_RECORD_SAMPLE_RATE", defaultRecordSampleRate),
+	}
+}
+
+// bodyRecorder keeps a copy of the response while passing it to the client
+type bodyRecorder struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (r *bodyRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *bodyRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if room := maxRecordedBody - r.body.Len(); room < len(b) {
+		r.body.Write(b[:room])
+		r.truncated = true
+	} else {
+		r.body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *bodyRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// record writes a replay bundle for a sample of requests. It must run inside
+// logRequests so the bundle can be named after the request ID.
+func (s *Server) record(next http.Handler) http.Handler {
+	cfg := s.config.Record
+	if cfg.Dir == "" || cfg.SampleRate <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rand.Float64() >= cfg.SampleRate {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Read at most one byte more than the server accepts; the body limit
+		// still applies to the copy handed on
+		body, err := io.ReadAll(io.LimitReader(r.Body, s.maxBodyBytes()+1))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		rec := replay.NewRecorder()
+		out := &bodyRecorder{ResponseWriter: w}
+		next.ServeHTTP(out, r.WithContext(replay.WithRecorder(r.Context(), rec)))
+
+		req := replay.Request{Method: r.Method, URL: r.URL.RequestURI(), Header: r.Header}
+		replay.SetBody(body, &req.Body, &req.Text)
+		resp := replay.Response{Status: out.status, Header: w.Header(), Truncated: out.truncated}
+		if resp.Status == 0 {
+			resp.Status = http.StatusOK
+		}
+		replay.SetBody(out.body.Bytes(), &resp.Body, &resp.Text)
+
+		bundle := rec.Bundle(req, resp)
+		bundle.RequestID = logging.RequestID(r.Context())
+		bundle.Table = s.config.TableName
+		name := bundle.RequestID
+		if name == "" {
+			name = newID()
+		}
+		if err := replay.WriteFile(filepath.Join(cfg.Dir, name+".json"), bundle); err != nil {
+			s.logger.ErrorContext(r.Context(), "writing replay bundle failed", "error", err)
+		}
+	})
+}
+
+// recordUser notes the authenticated account of a recorded request
+func recordUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rec := replay.FromContext(r.Context()); rec != nil {
+			if user, ok := auth.UserFromContext(r.Context()); ok {
+				u := replay.User{ID: user.ID, Username: user.Username, StorageMode: user.StorageMode}
+				if key, ok := auth.APIKeyFromContext(r.Context()); ok {
+					u.Scopes = key.Scopes
+				}
+				rec.SetUser(u)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Replay re-executes a recorded request against an in-memory server holding
+// the facts the request read when it was recorded, and returns the new
+// response. Store calls that failed when recorded fail again with the same
+// kind of error. The recorded account is recreated with a fresh API key
+// carrying the recorded scopes.
+func Replay(ctx context.Context, b *replay.Bundle, config Config) (*replay.Response, error) {
+	config.InMemory = true
+	config.TableName = b.Table
+	config.Record = RecordConfig{}
+	srv, err := NewServer(config)
+	if err != nil {
+		return nil, err
+	}
+	defer srv.Stop(ctx)
+
+	playback := replay.NewPlayback(b.Calls)
+	for _, c := range b.Calls {
+		key := c.Table + "#" + c.User
+		if _, ok := srv.memStores[key]; !ok {
+			srv.memStores[key] = playback.Store(c.Table, c.User)
+		}
+	}
+
+	req := httptest.NewRequest(b.Request.Method, b.Request.URL, bytes.NewReader(replay.Payload(b.Request.Body, b.Request.Text))).WithContext(ctx)
+	for name, values := range b.Request.Header {
+		if len(values) == 1 && values[0] == replay.Redacted {
+			continue
+		}
+		req.Header[name] = values
+	}
+	if u := b.User; u != nil {
+		user := &auth.User{ID: u.ID, Username: u.Username, Email: u.Username + "@replay.invalid", StorageMode: u.StorageMode}
+		if err := srv.userStore.CreateUser(ctx, user); err != nil {
+			return nil, fmt.Errorf("recreating user: %w", err)
+		}
+		_, key, err := srv.authenticator.GenerateScopedAPIKey(ctx, u.ID, "replay", 0, u.Scopes)
+		if err != nil {
+			return nil, fmt.Errorf("creating API key: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	resp := &replay.Response{Status: rec.Code, Header: rec.Header()}
+	replay.SetBody(rec.Body.Bytes(), &resp.Body, &resp.Text)
+	return resp, nil
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/elibdev/notably/pkg/replay"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true}
+	recording := config
+	recording.Record = RecordConfig{Dir: dir, SampleRate: 1}
+	srv, err := NewServer(recording)
+	require.NoError(t, err)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+	require.NoError(t, err)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "notes", "columns": [{"name": "title", "dataType": "string"}]}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/notes/rows", `{"id": "n1", "values": {"title": "hello"}}`).Code)
+	rec := do(http.MethodGet, "/tables/notes/rows", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	bundle, err := replay.ReadFile(filepath.Join(dir, rec.Header().Get(requestIDHeader)+".json"))
+	require.NoError(t, err)
+	assert.Equal(t, "Facts", bundle.Table)
+	require.NotNil(t, bundle.User)
+	assert.Equal(t, user.ID, bundle.User.ID)
+	assert.Equal(t, replay.Redacted, bundle.Request.Header.Get("Authorization"))
+	assert.NotEmpty(t, bundle.Calls)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3, "every request is recorded at a sample rate of 1")
+
+	// The replay sees exactly the rows the request read
+	resp, err := Replay(ctx, bundle, config)
+	require.NoError(t, err)
+	assert.Empty(t, replay.Diff(bundle.Response, *resp, []string{"timestamp"}))
+	assert.Contains(t, string(resp.Body), `"hello"`)
+
+	// A store call that failed when recorded fails again
+	for i, c := range bundle.Calls {
+		if c.Op == replay.OpGetSnapshotAtTime {
+			bundle.Calls[i].Facts = nil
+			bundle.Calls[i].Error = "ProvisionedThroughputExceededException"
+			bundle.Calls[i].Kind = "throttled"
+		}
+	}
+	resp, err = Replay(ctx, bundle, config)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Status)
+}
+
+func TestRecordRedactsSecrets(t *testing.T) {
+	dir := t.TempDir()
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true, Record: RecordConfig{Dir: dir, SampleRate: 1}})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/register", strings.NewReader(`{"username": "bob", "email": "bob@example.com", "password": "hunter2"}`))
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	data, err := os.ReadFile(filepath.Join(dir, rec.Header().Get(requestIDHeader)+".json"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+	assert.NotContains(t, string(data), "nb_", "the new API key is redacted from the response")
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+)
+
+const (
+	// refIndexDataType marks the facts of the reference index
+	refIndexDataType = "refindex"
+
+	// onDeleteRestrict blocks deleting a row that is still referenced
+	onDeleteRestrict = "restrict"
+	// onDeleteCascade deletes the referencing rows with the referenced one
+	onDeleteCascade = "cascade"
+
+	// maxCascadeRows bounds the rows one delete may remove
+	maxCascadeRows = 1000
+)
+
+// refIndexEntry is the value of a reference index fact: a row that held a
+// reference to the fact's row
+type refIndexEntry struct {
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	Row    string `json:"row"`
+}
+
+// refIndexField is the field, in the user's namespace, indexing the rows
+// that reference a row of a table
+func refIndexField(table, row string) string {
+	return settingField(table, "refs", row)
+}
+
+// rowLive reports whether a snapshot fact holds a row rather than the
+// tombstone of a deleted one
+func rowLive(f dynamo.Fact) bool {
+	if f.DataType == secretDataType {
+		return true
+	}
+	_, ok := f.Value.(map[string]interface{})
+	return f.DataType == "json" && ok
+}
+
+// indexReferences records a row's references in the reference index. Like
+// the geohash index, entries are never removed: deletes check every entry
+// against the referencing row's current values and skip stale ones.
+func (s *Server) indexReferences(ctx context.Context, store *db.StoreAdapter, userID, table string, columns []dynamo.ColumnDefinition, rowID string, values map[string]interface{}, at time.Time) error {
+	for _, col := range columns {
+		if col.DataType != referenceType {
+			continue
+		}
+		target, ok := values[col.Name].(string)
+		if !ok {
+			continue
+		}
+		data, err := json.Marshal(refIndexEntry{Table: table, Column: col.Name, Row: rowID})
+		if err != nil {
+			return err
+		}
+		if err := store.PutFact(ctx, dynamo.Fact{
+			ID:        newID(),
+			Timestamp: at,
+			Namespace: userID,
+			FieldName: refIndexField(col.References, target),
+			DataType:  refIndexDataType,
+			Value:     string(data),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rowRef names a row of a table
+type rowRef struct {
+	table, row string
+}
+
+// integrityError is a delete refused to keep references intact
+type integrityError struct {
+	msg string
+}
+
+func (e *integrityError) Error() string { return e.msg }
+
+// scopeError is a delete refused because its references reach a table
+// outside the API key's table scopes
+type scopeError struct {
+	msg string
+}
+
+func (e *scopeError) Error() string { return e.msg }
+
+// writeRefusedDelete answers a delete refused by a deletePlanner, with 409
+// for a restricted reference and 403 for one outside the key's scopes. It
+// reports false for other errors, leaving them to the caller.
+func writeRefusedDelete(w http.ResponseWriter, err error) bool {
+	var refused *integrityError
+	var outOfScope *scopeError
+	switch {
+	case errors.As(err, &refused):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.As(err, &outOfScope):
+		writeError(w, http.StatusForbidden, err.Error())
+	default:
+		return false
+	}
+	return true
+}
+
+// deletePlanner works out which rows deleting a row removes, following
+// cascading references and refusing restricted ones
+type deletePlanner struct {
+	s     *Server
+	ctx   context.Context
+	store *db.StoreAdapter
+	user  *auth.User
+	now   time.Time
+	// skip holds rows written by the same request; they are checked as
+	// writes instead
+	skip map[rowRef]bool
+
+	defs   map[string][]dynamo.ColumnDefinition // columns by table; nil for a missing table
+	stores map[string]*db.StoreAdapter
+}
+
+func (s *Server) newDeletePlanner(ctx context.Context, store *db.StoreAdapter, user *auth.User) *deletePlanner {
+	return &deletePlanner{
+		s: s, ctx: ctx, store: store, user: user, now: time.Now().UTC(),
+		skip:   make(map[rowRef]bool),
+		defs:   make(map[string][]dynamo.ColumnDefinition),
+		stores: make(map[string]*db.StoreAdapter),
+	}
+}
+
+// plan returns the rows deleting a row removes, the row itself first and
+// each cascaded row after the row it references
+func (p *deletePlanner) plan(table, row string) ([]rowRef, error) {
+	rows := []rowRef{{table, row}}
+	seen := map[rowRef]bool{rows[0]: true}
+	for i := 0; i < len(rows); i++ {
+		target := rows[i]
+		facts, err := p.store.QueryByField(p.ctx, p.user.ID, refIndexField(target.table, target.row), time.Time{}, p.now)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range facts {
+			if f.DataType != refIndexDataType {
+				continue
+			}
+			var entry refIndexEntry
+			if s, ok := f.Value.(string); !ok || json.Unmarshal([]byte(s), &entry) != nil {
+				continue
+			}
+			dep := rowRef{entry.Table, entry.Row}
+			if seen[dep] || p.skip[dep] {
+				continue
+			}
+			col, ok, err := p.referencing(entry, target)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			if col.OnDelete == onDeleteRestrict || col.OnDelete == onDeleteCascade {
+				if key, ok := auth.APIKeyFromContext(p.ctx); ok && !key.CanAccessTable(dep.table) {
+					return nil, &scopeError{fmt.Sprintf("Row '%s' of table '%s' is referenced from table '%s', which the API key is not scoped for", target.row, target.table, dep.table)}
+				}
+			}
+			switch col.OnDelete {
+			case onDeleteRestrict:
+				return nil, &integrityError{fmt.Sprintf("Row '%s' of table '%s' is referenced by row '%s' of table '%s' through column '%s'", target.row, target.table, dep.row, dep.table, col.Name)}
+			case onDeleteCascade:
+				if len(rows) == maxCascadeRows {
+					return nil, &integrityError{fmt.Sprintf("Deleting row '%s' of table '%s' would cascade to more than %d rows", row, table, maxCascadeRows)}
+				}
+				seen[dep] = true
+				rows = append(rows, dep)
+			}
+		}
+	}
+	return rows, nil
+}
+
+// referencing returns the column through which an index entry's row
+// currently references target. Entries whose row, column or table has since
+// changed or gone are stale.
+func (p *deletePlanner) referencing(entry refIndexEntry, target rowRef) (dynamo.ColumnDefinition, bool, error) {
+	columns, err := p.columns(entry.Table)
+	if err != nil || columns == nil {
+		return dynamo.ColumnDefinition{}, false, err
+	}
+	var col dynamo.ColumnDefinition
+	for _, c := range columns {
+		if c.Name == entry.Column && c.DataType == referenceType && c.References == target.table {
+			col = c
+		}
+	}
+	if col.Name == "" {
+		return col, false, nil
+	}
+	rowStore, err := p.rowStore(entry.Table)
+	if err != nil {
+		return col, false, err
+	}
+	history, err := rowStore.QueryByField(p.ctx, tableNamespace(p.user.ID, entry.Table), entry.Row, time.Time{}, p.now)
+	if err != nil || len(history) == 0 {
+		return col, false, err
+	}
+	current := latestTableDef(history)
+	if !rowLive(current) {
+		return col, false, nil
+	}
+	values, _ := current.Value.(map[string]interface{})
+	return col, values[entry.Column] == target.row, nil
+}
+
+// columns returns a table's column definitions, or nil when the table does
+// not exist. Tables outside the key's scopes are read too, so their
+// references are not missed.
+func (p *deletePlanner) columns(table string) ([]dynamo.ColumnDefinition, error) {
+	if columns, ok := p.defs[table]; ok {
+		return columns, nil
+	}
+	facts, err := p.store.QueryByField(p.ctx, p.user.ID, table, time.Time{}, p.now)
+	if err != nil {
+		return nil, err
+	}
+	var columns []dynamo.ColumnDefinition
+	if p.s.tableExists(p.ctx, facts) {
+		columns = latestTableDef(facts).Columns
+		if columns == nil {
+			columns = []dynamo.ColumnDefinition{}
+		}
+	}
+	p.defs[table] = columns
+	return columns, nil
+}
+
+func (p *deletePlanner) rowStore(table string) (*db.StoreAdapter, error) {
+	if rs, ok := p.stores[table]; ok {
+		return rs, nil
+	}
+	rs, err := p.s.getRowStore(p.ctx, p.store, p.user, table)
+	if err != nil {
+		return nil, err
+	}
+	p.stores[table] = rs
+	return rs, nil
+}
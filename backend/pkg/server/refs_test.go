@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteReferencedRows(t *testing.T) {
+	_, do := memoryServer(t)
+	create := func(path, body string) {
+		t.Helper()
+		rec := do(http.MethodPost, path, body)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	create("/tables", `{"name": "customers", "columns": [{"name": "name", "dataType": "string"}]}`)
+	create("/tables", `{"name": "orders", "columns": [{"name": "customer", "dataType": "reference", "references": "customers", "onDelete": "cascade"}]}`)
+	create("/tables", `{"name": "items", "columns": [{"name": "order", "dataType": "reference", "references": "orders", "onDelete": "cascade"}]}`)
+	create("/tables", `{"name": "invoices", "columns": [{"name": "customer", "dataType": "reference", "references": "customers", "onDelete": "restrict"}]}`)
+	create("/tables", `{"name": "notes", "columns": [{"name": "customer", "dataType": "reference", "references": "customers"}]}`)
+	for _, id := range []string{"c1", "c2", "c3"} {
+		create("/tables/customers/rows", `{"id": "`+id+`", "values": {"name": "x"}}`)
+	}
+	create("/tables/orders/rows", `{"id": "o1", "values": {"customer": "c1"}}`)
+	create("/tables/items/rows", `{"id": "i1", "values": {"order": "o1"}}`)
+	create("/tables/notes/rows", `{"id": "n1", "values": {"customer": "c1"}}`)
+	create("/tables/invoices/rows", `{"id": "v1", "values": {"customer": "c2"}}`)
+
+	rowIDs := func(table string) []string {
+		rec := do(http.MethodGet, "/tables/"+table+"/rows", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp struct {
+			Rows []RowData `json:"rows"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		ids := []string{}
+		for _, row := range resp.Rows {
+			ids = append(ids, row.ID)
+		}
+		return ids
+	}
+
+	// Deleting c1 cascades through orders to items and leaves notes alone
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/tables/customers/rows/c1", "").Code)
+	assert.Empty(t, rowIDs("orders"))
+	assert.Empty(t, rowIDs("items"))
+	assert.Equal(t, []string{"n1"}, rowIDs("notes"))
+
+	// Invoices block the delete of the customer they reference
+	rec := do(http.MethodDelete, "/tables/customers/rows/c2", "")
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), "is referenced by row 'v1' of table 'invoices' through column 'customer'")
+
+	// A transaction may delete both, but must delete cascaded rows itself
+	rec = do(http.MethodPost, "/transactions", `{"ops": [{"op": "delete", "table": "customers", "id": "c2"}]}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = do(http.MethodPost, "/transactions", `{"ops": [
+		{"op": "delete", "table": "invoices", "id": "v1"},
+		{"op": "delete", "table": "customers", "id": "c2"}
+	]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	create("/tables/orders/rows", `{"id": "o2", "values": {"customer": "c3"}}`)
+	rec = do(http.MethodPost, "/transactions", `{"ops": [{"op": "delete", "table": "customers", "id": "c3"}]}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), "would cascade to row 'o2' of table 'orders'")
+
+	// A reference moved elsewhere no longer blocks or cascades; deleted rows
+	// cannot be referenced
+	create("/tables/customers/rows", `{"id": "c4", "values": {"name": "x"}}`)
+	create("/tables/invoices/rows", `{"id": "v2", "values": {"customer": "c3"}}`)
+	rec = do(http.MethodPost, "/transactions", `{"ops": [{"op": "update", "table": "invoices", "id": "v2", "values": {"customer": "c4"}}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/transactions", `{"ops": [
+		{"op": "update", "table": "orders", "id": "o2", "values": {"customer": "c4"}},
+		{"op": "delete", "table": "customers", "id": "c3"}
+	]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/orders/rows", `{"values": {"customer": "c3"}}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do(http.MethodPost, "/tables", `{"name": "bad", "columns": [{"name": "c", "dataType": "reference", "references": "customers", "onDelete": "nullify"}]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/remotewrite"
+)
+
+// maxRemoteWriteDecodedBytes caps the decompressed size of a remote-write request
+const maxRemoteWriteDecodedBytes = 32 << 20
+
+// seriesRowID returns the row ID holding a series: its metric name, made
+// safe for URL paths, and a hash of its labels
+func seriesRowID(s remotewrite.Series) string {
+	h := fnv.New64a()
+	h.Write([]byte(s.Key()))
+	metric := strings.Map(func(r rune) rune {
+		if ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, s.Metric())
+	return fmt.Sprintf("%s-%016x", metric, h.Sum64())
+}
+
+// seriesValues returns the row values recording one sample of a series
+func seriesValues(s remotewrite.Series, sample remotewrite.Sample) map[string]interface{} {
+	labels := make(map[string]interface{}, len(s.Labels))
+	for name, value := range s.Labels {
+		if name != remotewrite.MetricNameLabel {
+			labels[name] = value
+		}
+	}
+	return map[string]interface{}{
+		"metric": s.Metric(),
+		"labels": labels,
+		"value":  sample.Value,
+	}
+}
+
+// handlePrometheusWrite ingests a Prometheus remote-write request into a
+// table. Each series becomes a row and each sample a fact at the sample's
+// timestamp, so snapshots give the latest values and history gives the samples.
+func (s *Server) handlePrometheusWrite(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+
+	if enc := r.Header.Get("Content-Encoding"); enc != "" && enc != "snappy" {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("Unsupported Content-Encoding '%s' (expected snappy)", enc))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxErr.Limit))
+			return
+		}
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Failed to read request body: %v", err))
+		return
+	}
+
+	series, err := remotewrite.Decode(body, maxRemoteWriteDecodedBytes)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Validate table exists
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+	if rejectVirtualWrite(w, facts, table) {
+		return
+	}
+	if tableOptionsOf(latestTableDef(facts)).Type == tableTypeSecrets {
+		writeError(w, http.StatusBadRequest, "Metrics cannot be ingested into secrets tables")
+		return
+	}
+
+	rowStore, err := s.getRowStore(r.Context(), store, user, table)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize table storage")
+		return
+	}
+
+	// Samples are checked like created rows, so tables with columns must
+	// define metric, labels and value; plugins do not see them
+	validator := s.newRowValidatorFor(r.Context(), store, user, facts)
+	namespace := tableNamespace(user.ID, table)
+	for _, ser := range series {
+		rowID := seriesRowID(ser)
+		for _, sample := range ser.Samples {
+			// Staleness markers are NaN, which JSON cannot represent
+			if remotewrite.IsStale(sample.Value) {
+				continue
+			}
+			values := seriesValues(ser, sample)
+			if err := validator.check(values, true); err != nil {
+				writeRowError(w, err, fmt.Sprintf("Series '%s': ", rowID))
+				return
+			}
+			if err := rowStore.PutFact(r.Context(), dynamo.Fact{
+				ID:        newID(),
+				Timestamp: sample.Timestamp,
+				Namespace: namespace,
+				FieldName: rowID,
+				DataType:  "json",
+				Value:     values,
+			}); err != nil {
+				writeStoreError(w, err, "Failed to store samples")
+				return
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/elibdev/notably/pkg/naming"
+	"github.com/elibdev/notably/pkg/remotewrite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesRowID(t *testing.T) {
+	a := remotewrite.Series{Labels: map[string]string{"__name__": "http:requests_total", "job": "api", "code": "200"}}
+	b := remotewrite.Series{Labels: map[string]string{"__name__": "http:requests_total", "job": "api", "code": "500"}}
+
+	id := seriesRowID(a)
+	assert.True(t, strings.HasPrefix(id, "http_requests_total-"), id)
+	assert.True(t, naming.ValidSyntax(id), id)
+	assert.Equal(t, id, seriesRowID(remotewrite.Series{Labels: map[string]string{"code": "200", "job": "api", "__name__": "http:requests_total"}}))
+	assert.NotEqual(t, id, seriesRowID(b))
+}
+
+func TestSeriesValues(t *testing.T) {
+	s := remotewrite.Series{Labels: map[string]string{"__name__": "up", "job": "node"}}
+	values := seriesValues(s, remotewrite.Sample{Timestamp: time.Now(), Value: 1})
+	assert.Equal(t, map[string]interface{}{
+		"metric": "up",
+		"labels": map[string]interface{}{"job": "node"},
+		"value":  1.0,
+	}, values)
+}
+
+func TestPrometheusWriteRejectsInvalidBodies(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard()})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "prometheus", 0)
+	require.NoError(t, err)
+
+	send := func(encoding, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/tables/metrics/prometheus/write", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, send("gzip", "x"))
+	assert.Equal(t, http.StatusBadRequest, send("snappy", "not a write request"))
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// replicationHeartbeatInterval is how often the server stamps its region's
+// heartbeat and checks the heartbeats replicated from the other regions
+const replicationHeartbeatInterval = 5 * time.Second
+
+// defaultMaxReplicationLag is how far behind a replica may fall by default
+// before the server reports itself unready
+const defaultMaxReplicationLag = 30 * time.Second
+
+// heartbeats reads and writes the replication heartbeats of a Global Table;
+// dynamo.Client implements it
+type heartbeats interface {
+	WriteHeartbeat(ctx context.Context, at time.Time) error
+	Heartbeats(ctx context.Context) (map[string]time.Time, error)
+}
+
+// replicaStatus is what the server last saw of a replica region. Known is
+// false until its heartbeat has replicated, or when reading heartbeats fails.
+type replicaStatus struct {
+	Known bool
+	Lag   time.Duration
+}
+
+// replicaRegionsFromEnv reads the Global Table replica regions from the environment
+func replicaRegionsFromEnv() []string {
+	var regions []string
+	for _, r := range strings.Split(os.Getenv("NOTABLY_REPLICA_REGIONS"), ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			regions = append(regions, r)
+		}
+	}
+	return regions
+}
+
+// replicated reports whether the server runs against a Global Table
+func (s *Server) replicated() bool {
+	return !s.config.InMemory && len(s.config.ReplicaRegions) > 0
+}
+
+// runReplicationHeartbeat keeps the replication status of every replica
+// region current until ctx is cancelled
+func (s *Server) runReplicationHeartbeat(ctx context.Context) {
+	client, err := s.dynamoClient(ctx, s.config.TableName, "")
+	if err != nil {
+		s.logger.ErrorContext(ctx, "replication heartbeats disabled", "error", err)
+		return
+	}
+	ticker := time.NewTicker(replicationHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		s.checkReplication(ctx, client, time.Now().UTC())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkReplication stamps the server's heartbeat at now and records how far
+// behind each replica's heartbeat is. Heartbeats are written once per
+// interval, so a replica is only lagging once its heartbeat is older than
+// that.
+func (s *Server) checkReplication(ctx context.Context, hb heartbeats, now time.Time) {
+	if err := hb.WriteHeartbeat(ctx, now); err != nil {
+		s.logger.WarnContext(ctx, "writing replication heartbeat failed", "error", err)
+	}
+	beats, err := hb.Heartbeats(ctx)
+	if err != nil {
+		s.logger.WarnContext(ctx, "reading replication heartbeats failed", "error", err)
+	}
+
+	s.replicationMu.Lock()
+	defer s.replicationMu.Unlock()
+	for _, region := range s.config.ReplicaRegions {
+		at, ok := beats[region]
+		if !ok {
+			s.replication[region] = replicaStatus{}
+			continue
+		}
+		lag := now.Sub(at) - replicationHeartbeatInterval
+		if lag < 0 {
+			lag = 0
+		}
+		s.replication[region] = replicaStatus{Known: true, Lag: lag}
+		s.metrics.ObserveReplicationLag(region, lag)
+	}
+}
+
+// handleReady reports whether the server can serve traffic: always for a
+// single region table, and for a Global Table only while every replica is
+// within MaxReplicationLag
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	type replica struct {
+		LagSeconds *float64 `json:"lagSeconds,omitempty"`
+		Healthy    bool     `json:"healthy"`
+	}
+	resp := map[string]interface{}{"status": "ok"}
+	if s.config.Region != "" {
+		resp["region"] = s.config.Region
+	}
+	if !s.replicated() {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	ready := true
+	replicas := make(map[string]replica, len(s.config.ReplicaRegions))
+	s.replicationMu.Lock()
+	for _, region := range s.config.ReplicaRegions {
+		status := s.replication[region]
+		rep := replica{Healthy: status.Known && status.Lag <= s.config.MaxReplicationLag}
+		if status.Known {
+			lag := status.Lag.Seconds()
+			rep.LagSeconds = &lag
+		}
+		ready = ready && rep.Healthy
+		replicas[region] = rep
+	}
+	s.replicationMu.Unlock()
+
+	resp["replication"] = replicas
+	if !ready {
+		resp["status"] = "replication lagging"
+		writeJSON(w, http.StatusServiceUnavailable, resp)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
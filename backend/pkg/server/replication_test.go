@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeHeartbeats is a Global Table whose replicas' heartbeats are set by the test
+type fakeHeartbeats struct {
+	beats   map[string]time.Time
+	written []time.Time
+	err     error
+}
+
+func (f *fakeHeartbeats) WriteHeartbeat(ctx context.Context, at time.Time) error {
+	f.written = append(f.written, at)
+	return nil
+}
+
+func (f *fakeHeartbeats) Heartbeats(ctx context.Context) (map[string]time.Time, error) {
+	return f.beats, f.err
+}
+
+func TestReadyzReportsReplicationLag(t *testing.T) {
+	srv, err := NewServer(Config{
+		TableName:         "Facts",
+		Logger:            logging.Discard(),
+		Region:            "us-east-1",
+		ReplicaRegions:    []string{"eu-west-1", "ap-south-1"},
+		MaxReplicationLag: 30 * time.Second,
+	})
+	require.NoError(t, err)
+
+	ready := func() (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return rec.Code, body
+	}
+
+	code, body := ready()
+	assert.Equal(t, http.StatusServiceUnavailable, code, "replicas not heard from yet")
+	assert.Equal(t, "us-east-1", body["region"])
+
+	now := time.Now().UTC()
+	hb := &fakeHeartbeats{beats: map[string]time.Time{
+		"eu-west-1":  now.Add(-3 * time.Second),
+		"ap-south-1": now.Add(-15 * time.Second),
+	}}
+	srv.checkReplication(context.Background(), hb, now)
+	assert.Equal(t, []time.Time{now}, hb.written)
+	code, body = ready()
+	require.Equal(t, http.StatusOK, code, body)
+	replication := body["replication"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"lagSeconds": 0.0, "healthy": true}, replication["eu-west-1"], "lag within the heartbeat interval")
+	assert.Equal(t, map[string]interface{}{"lagSeconds": 10.0, "healthy": true}, replication["ap-south-1"])
+
+	hb.beats["ap-south-1"] = now.Add(-time.Minute)
+	srv.checkReplication(context.Background(), hb, now)
+	code, body = ready()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	replication = body["replication"].(map[string]interface{})
+	assert.Equal(t, false, replication["ap-south-1"].(map[string]interface{})["healthy"])
+
+	hb.err = errors.New("region unavailable")
+	hb.beats = nil
+	srv.checkReplication(context.Background(), hb, now)
+	code, _ = ready()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
+
+func TestReadyzSingleRegion(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true})
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status": "ok"}`, rec.Body.String())
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// defaultMaxBodyBytes caps request bodies when Config.MaxBodyBytes is unset
+const defaultMaxBodyBytes = 1 << 20
+
+// FieldError describes one invalid field in a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validationErrorResponse is the body returned for requests with invalid fields
+type validationErrorResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields"`
+}
+
+// fieldErrors collects validation failures for a request body
+type fieldErrors []FieldError
+
+// required records field as missing when value is empty
+func (e *fieldErrors) required(field, value string) {
+	if value == "" {
+		*e = append(*e, FieldError{Field: field, Message: "is required"})
+	}
+}
+
+// writeValidationError responds 400 with every invalid field listed
+func writeValidationError(w http.ResponseWriter, message string, fields []FieldError) {
+	writeJSON(w, http.StatusBadRequest, validationErrorResponse{
+		Error:  message,
+		Fields: fields,
+	})
+}
+
+// limitBody rejects request bodies larger than the configured maximum
+func (s *Server) limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := s.maxBodyBytes()
+		if r.Method == http.MethodPost && rowAttachmentUpload.MatchString(r.URL.Path) {
+			limit = s.maxAttachmentBytes() + multipartOverhead
+		}
+		if r.ContentLength > limit {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", limit))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxBodyBytes is the configured request body limit
+func (s *Server) maxBodyBytes() int64 {
+	if s.config.MaxBodyBytes <= 0 {
+		return defaultMaxBodyBytes
+	}
+	return s.config.MaxBodyBytes
+}
+
+// decodeJSON strictly decodes a request body into dst: unknown fields and
+// anything after the first JSON value are rejected. On failure it writes the
+// error response and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil {
+		if dec.Decode(&struct{}{}) != io.EOF {
+			err = errTrailingData
+		}
+	}
+	if err == nil {
+		return true
+	}
+
+	var maxErr *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &maxErr):
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", maxErr.Limit))
+	case errors.Is(err, io.EOF):
+		writeError(w, http.StatusBadRequest, "Request body is required")
+	case errors.Is(err, errTrailingData):
+		writeError(w, http.StatusBadRequest, "Invalid JSON: unexpected data after the request body")
+	case errors.As(err, &syntaxErr):
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON at offset %d: %v", syntaxErr.Offset, err))
+	case errors.As(err, &typeErr):
+		writeValidationError(w, "Invalid request", []FieldError{{
+			Field:   typeErr.Field,
+			Message: fmt.Sprintf("must be of type %s", typeErr.Type),
+		}})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for unknown fields
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		writeValidationError(w, "Invalid request", []FieldError{{Field: field, Message: "unknown field"}})
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON: %v", err))
+	}
+	return false
+}
+
+// errTrailingData marks a body with more than one JSON value
+var errTrailingData = errors.New("trailing data after JSON value")
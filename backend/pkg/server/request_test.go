@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	decode := func(body string) (*httptest.ResponseRecorder, bool) {
+		var dst struct {
+			Name  string `json:"name"`
+			Count int    `json:"count"`
+		}
+		rec := httptest.NewRecorder()
+		ok := decodeJSON(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)), &dst)
+		return rec, ok
+	}
+
+	_, ok := decode(`{"name":"a","count":1}` + "\n")
+	assert.True(t, ok)
+
+	tests := []struct {
+		name  string
+		body  string
+		field string
+	}{
+		{"empty", ``, ""},
+		{"syntax", `{"name":`, ""},
+		{"trailing", `{"name":"a"} {"name":"b"}`, ""},
+		{"trailing garbage", `{"name":"a"}x`, ""},
+		{"unknown field", `{"name":"a","nmae":"b"}`, "nmae"},
+		{"wrong type", `{"count":"one"}`, "count"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, ok := decode(tt.body)
+			require.False(t, ok)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+			var resp validationErrorResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.NotEmpty(t, resp.Error)
+			if tt.field != "" {
+				require.Len(t, resp.Fields, 1)
+				assert.Equal(t, tt.field, resp.Fields[0].Field)
+			}
+		})
+	}
+}
+
+func TestBodySizeLimit(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", MaxBodyBytes: 64, Logger: logging.Discard()})
+	require.NoError(t, err)
+
+	body := `{"username":"` + strings.Repeat("a", 100) + `","email":"a@example.com","password":"pw"}`
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/register", strings.NewReader(body)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	// Bodies without a Content-Length are cut off while decoding
+	req := httptest.NewRequest(http.MethodPost, "/auth/register", strings.NewReader(body))
+	req.ContentLength = -1
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
+
+func TestRegisterListsMissingFields(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard()})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/register", strings.NewReader(`{"username":"alice"}`)))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	var resp validationErrorResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, []FieldError{
+		{Field: "email", Message: "is required"},
+		{Field: "password", Message: "is required"},
+	}, resp.Fields)
+}
@@ -0,0 +1,427 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/retention"
+)
+
+const (
+	// retentionDataType marks the fact holding a table's retention policy
+	retentionDataType = "retention"
+	// retentionSweepInterval is how often expired row versions are purged
+	retentionSweepInterval = time.Hour
+	// minRetentionAge is the shortest maxAge a policy may set
+	minRetentionAge = time.Hour
+)
+
+// Retention limits how much row history a table keeps. MaxAge is a duration
+// such as "2160h"; MaxVersions of 1 keeps only the latest version of each row.
+type Retention struct {
+	MaxAge      string    `json:"maxAge,omitempty"`
+	MaxVersions int       `json:"maxVersions,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// policy converts a validated retention setting
+func (r Retention) policy() retention.Policy {
+	maxAge, _ := time.ParseDuration(r.MaxAge)
+	return retention.Policy{MaxAge: maxAge, MaxVersions: r.MaxVersions}
+}
+
+// validateRetention checks a requested retention policy
+func validateRetention(r Retention) fieldErrors {
+	var fields fieldErrors
+	if r.MaxAge == "" && r.MaxVersions == 0 {
+		fields = append(fields, FieldError{Field: "maxAge", Message: "one of 'maxAge' or 'maxVersions' is required"})
+	}
+	if r.MaxAge != "" {
+		if d, err := time.ParseDuration(r.MaxAge); err != nil {
+			fields = append(fields, FieldError{Field: "maxAge", Message: "must be a duration such as \"2160h\""})
+		} else if d < minRetentionAge {
+			fields = append(fields, FieldError{Field: "maxAge", Message: fmt.Sprintf("must be at least %s", minRetentionAge)})
+		}
+	}
+	if r.MaxVersions < 0 {
+		fields = append(fields, FieldError{Field: "maxVersions", Message: "must not be negative"})
+	}
+	return fields
+}
+
+// retentionField is the field, in the user's namespace, holding a table's
+// retention policy
+func retentionField(table string) string {
+	return settingField(table, "retention")
+}
+
+// loadRetention returns a table's retention policy, or nil if it keeps all
+// history. defs are the table's definition facts; policies saved before the
+// table was last (re)created are ignored.
+func (s *Server) loadRetention(ctx context.Context, store *db.StoreAdapter, userID, table string, defs []dynamo.Fact) (*Retention, error) {
+	facts, err := store.QueryByField(ctx, userID, retentionField(table), time.Time{}, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *dynamo.Fact
+	for i := range facts {
+		if facts[i].DataType != retentionDataType {
+			continue
+		}
+		if latest == nil || facts[i].Timestamp.After(latest.Timestamp) {
+			latest = &facts[i]
+		}
+	}
+	if latest == nil || latest.Timestamp.Before(latestTableDef(defs).Timestamp) {
+		return nil, nil
+	}
+
+	raw, _ := latest.Value.(string)
+	if raw == "" {
+		// The policy was removed
+		return nil, nil
+	}
+	var r Retention
+	if err := json.Unmarshal([]byte(raw), &r); err != nil {
+		return nil, fmt.Errorf("decoding retention policy for table %s: %w", table, err)
+	}
+	return &r, nil
+}
+
+// saveRetention records a table's retention policy; nil removes it
+func (s *Server) saveRetention(ctx context.Context, store *db.StoreAdapter, userID, table string, r *Retention) error {
+	value := ""
+	if r != nil {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	}
+	return store.PutFact(ctx, dynamo.Fact{
+		ID:        newID(),
+		Timestamp: time.Now().UTC(),
+		Namespace: userID,
+		FieldName: retentionField(table),
+		DataType:  retentionDataType,
+		Value:     value,
+	})
+}
+
+// handleGetRetention returns a table's retention policy
+func (s *Server) handleGetRetention(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Validate table exists
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+
+	policy, err := s.loadRetention(r.Context(), store, user.ID, table, facts)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load retention policy")
+		return
+	}
+	if policy == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' has no retention policy", table))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, policy)
+}
+
+// handlePutRetention creates or replaces a table's retention policy
+func (s *Server) handlePutRetention(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+
+	var req struct {
+		MaxAge      string `json:"maxAge"`
+		MaxVersions int    `json:"maxVersions"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	policy := Retention{MaxAge: req.MaxAge, MaxVersions: req.MaxVersions, UpdatedAt: time.Now().UTC()}
+	if fields := validateRetention(policy); len(fields) > 0 {
+		writeValidationError(w, "Invalid retention policy", fields)
+		return
+	}
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Validate table exists
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+	if proxiedTable(facts) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Table '%s' is proxied to its upstream and has no history", table))
+		return
+	}
+
+	existing, err := s.loadRetention(r.Context(), store, user.ID, table, facts)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load retention policy")
+		return
+	}
+	if err := s.saveRetention(r.Context(), store, user.ID, table, &policy); err != nil {
+		writeStoreError(w, err, "Failed to save retention policy")
+		return
+	}
+
+	status := http.StatusCreated
+	if existing != nil {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, policy)
+}
+
+// handleDeleteRetention removes a table's retention policy, so it keeps all
+// history from then on
+func (s *Server) handleDeleteRetention(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Validate table exists
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+
+	policy, err := s.loadRetention(r.Context(), store, user.ID, table, facts)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load retention policy")
+		return
+	}
+	if policy == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' has no retention policy", table))
+		return
+	}
+
+	if err := s.saveRetention(r.Context(), store, user.ID, table, nil); err != nil {
+		writeStoreError(w, err, "Failed to delete retention policy")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCompactTable applies a table's retention policy immediately instead
+// of waiting for the background compactor
+func (s *Server) handleCompactTable(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Validate table exists
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+
+	policy, err := s.loadRetention(r.Context(), store, user.ID, table, facts)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load retention policy")
+		return
+	}
+	if policy == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' has no retention policy", table))
+		return
+	}
+
+	purged, err := s.compactTable(r.Context(), store, user, table, policy.policy())
+	if err != nil {
+		writeStoreError(w, err, "Failed to compact table")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"purged": purged})
+}
+
+// compactTable purges the row versions a retention policy has expired,
+// returning how many were removed
+func (s *Server) compactTable(ctx context.Context, store *db.StoreAdapter, user *auth.User, table string, policy retention.Policy) (int, error) {
+	rowStore, err := s.getRowStore(ctx, store, user, table)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	facts, err := rowStore.QueryByTimeRange(ctx, time.Time{}, now)
+	if err != nil {
+		return 0, err
+	}
+	key := tableNamespace(user.ID, table)
+	var tableFacts []dynamo.Fact
+	for _, f := range facts {
+		if f.Namespace == key {
+			tableFacts = append(tableFacts, f)
+		}
+	}
+
+	expired := retention.Expired(tableFacts, policy, now)
+	purged := 0
+	for _, f := range expired {
+		if err := rowStore.PurgeFact(ctx, f); err != nil {
+			s.releaseAttachments(ctx, user.ID, table, expired[:purged], keptFacts(tableFacts, expired[:purged]))
+			return purged, err
+		}
+		purged++
+		reportProgress(ctx, purged, len(expired))
+	}
+	s.releaseAttachments(ctx, user.ID, table, expired, keptFacts(tableFacts, expired))
+	return purged, nil
+}
+
+// keptFacts returns the facts not among purged
+func keptFacts(facts, purged []dynamo.Fact) []dynamo.Fact {
+	gone := make(map[string]bool, len(purged))
+	for _, f := range purged {
+		gone[f.ID] = true
+	}
+	var kept []dynamo.Fact
+	for _, f := range facts {
+		if !gone[f.ID] {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// runRetentionCompactor applies every table's retention policy until ctx is
+// cancelled
+func (s *Server) runRetentionCompactor(ctx context.Context) {
+	ticker := time.NewTicker(retentionSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var n int
+			err := s.leased(ctx, "retention", func(ctx context.Context) (err error) {
+				n, err = s.compactTables(ctx)
+				return err
+			})
+			if err != nil {
+				s.logger.ErrorContext(ctx, "compacting tables failed", "purged", n, "error", err)
+			} else if n > 0 {
+				s.logger.InfoContext(ctx, "compacted tables", "purged", n)
+			}
+		}
+	}
+}
+
+// compactTables applies the retention policy of every table with one,
+// returning how many row versions were purged
+func (s *Server) compactTables(ctx context.Context) (int, error) {
+	users, err := s.authenticator.GetAllUsers(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, user := range users {
+		store, err := s.getStoreForUser(ctx, user.ID)
+		if err != nil {
+			return purged, err
+		}
+		defs, names, err := tableDefs(ctx, store, user.ID, time.Now().UTC())
+		if err != nil {
+			return purged, err
+		}
+		for _, table := range names {
+			if !tableLive(defs[table]) || proxiedTable(defs[table]) {
+				continue
+			}
+			policy, err := s.loadRetention(ctx, store, user.ID, table, defs[table])
+			if err != nil {
+				return purged, err
+			}
+			if policy == nil {
+				continue
+			}
+			n, err := s.compactTable(ctx, store, user, table, policy.policy())
+			purged += n
+			if err != nil {
+				return purged, err
+			}
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/elibdev/notably/pkg/retention"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRetention(t *testing.T) {
+	assert.Empty(t, validateRetention(Retention{MaxAge: "2160h"}))
+	assert.Empty(t, validateRetention(Retention{MaxVersions: 1}))
+	assert.Empty(t, validateRetention(Retention{MaxAge: "720h", MaxVersions: 5}))
+
+	for _, r := range []Retention{
+		{},
+		{MaxAge: "90 days"},
+		{MaxAge: "30m"},
+		{MaxVersions: -1},
+	} {
+		assert.NotEmpty(t, validateRetention(r), r)
+	}
+
+	assert.Equal(t, retention.Policy{MaxAge: 90 * 24 * time.Hour, MaxVersions: 2}, Retention{MaxAge: "2160h", MaxVersions: 2}.policy())
+}
+
+func TestPutRetentionRejectsInvalidPolicy(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard()})
+	require.NoError(t, err)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "retention", 0)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPut, "/tables/events/retention", strings.NewReader(`{"maxAge": "1m"}`))
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "maxAge")
+}
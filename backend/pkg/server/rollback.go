@@ -0,0 +1,306 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/plugin"
+)
+
+// maxRollbackBatch is the number of facts written per transaction; larger
+// rollbacks are written in several
+const maxRollbackBatch = 100
+
+// errRowNotFound is returned by planRollback for a row without history
+var errRowNotFound = errors.New("row not found")
+
+// RowChange is a row put back to its earlier state by a rollback or revert
+type RowChange struct {
+	ID string `json:"id"`
+	// Change is "create" for a row restored after being deleted, "delete"
+	// for a row that did not exist yet, or "update"
+	Change string `json:"change"`
+}
+
+// RollbackResult lists the rows a rollback or revert changed
+type RollbackResult struct {
+	To        time.Time   `json:"to"`
+	Timestamp time.Time   `json:"timestamp"`
+	Rows      []RowChange `json:"rows"`
+}
+
+// rowPresent reports whether a snapshot fact holds a row rather than a
+// deletion
+func rowPresent(fact dynamo.Fact, ok bool) bool {
+	return ok && rowLive(fact)
+}
+
+// sameRow reports whether two snapshot entries hold the same row, treating
+// every kind of absence alike
+func sameRow(a dynamo.Fact, aok bool, b dynamo.Fact, bok bool) bool {
+	aPresent, bPresent := rowPresent(a, aok), rowPresent(b, bok)
+	if !aPresent || !bPresent {
+		return aPresent == bPresent
+	}
+	return a.DataType == b.DataType && reflect.DeepEqual(a.Value, b.Value)
+}
+
+// restoreFact returns the fact that puts a row back to a snapshot entry,
+// and the event announcing it
+func restoreFact(userID, table, id string, to dynamo.Fact, present, existed bool, now time.Time) (dynamo.Fact, plugin.RowEvent) {
+	fact := dynamo.Fact{
+		ID:        newID(),
+		Timestamp: now,
+		Namespace: tableNamespace(userID, table),
+		FieldName: id,
+		DataType:  dynamo.DataTypeDeleted,
+	}
+	event := plugin.RowEvent{UserID: userID, Table: table, Row: id, Timestamp: now}
+	switch {
+	case !present:
+		event.Type = "delete"
+	case !existed:
+		event.Type = "create"
+	default:
+		event.Type = "update"
+	}
+	if present {
+		// Sealed secret rows are copied as they are
+		fact.DataType, fact.Value = to.DataType, to.Value
+		if vals, ok := to.Value.(map[string]interface{}); ok && to.DataType == "json" {
+			event.Values = vals
+		}
+	}
+	return fact, event
+}
+
+// planRollback returns the facts that restore rows of a table to their state
+// at a time, with one event per changed row. Rows that hold the same
+// values as then are left alone. A non-empty row restricts
+// the plan to that row.
+func (s *Server) planRollback(ctx context.Context, store, rowStore *db.StoreAdapter, user *auth.User, table string, def dynamo.Fact, row string, to, now time.Time) ([]dynamo.Fact, []plugin.RowEvent, error) {
+	then, err := s.tableEntries(ctx, store, rowStore, user, table, def, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	latest, err := s.tableEntries(ctx, store, rowStore, user, table, def, now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := map[string]bool{}
+	for id := range then {
+		ids[id] = true
+	}
+	for id := range latest {
+		ids[id] = true
+	}
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		if row == "" || id == row {
+			sorted = append(sorted, id)
+		}
+	}
+	sort.Strings(sorted)
+	if row != "" && !ids[row] {
+		return nil, nil, errRowNotFound
+	}
+
+	var facts []dynamo.Fact
+	var events []plugin.RowEvent
+	for _, id := range sorted {
+		old, hadOld := then[id]
+		cur, hasCur := latest[id]
+		if sameRow(old, hadOld, cur, hasCur) {
+			continue
+		}
+		fact, event := restoreFact(user.ID, table, id, old, rowPresent(old, hadOld), rowPresent(cur, hasCur), now)
+		facts = append(facts, fact)
+		events = append(events, event)
+	}
+	return facts, events, nil
+}
+
+// cascadeRowDeletes checks the rows planned facts delete against the
+// references to them, as deleting each row would, and returns the rows
+// outside the plan the deletes cascade to. Rows the plan writes are left
+// to the plan. A restricted reference fails with an *integrityError.
+func (s *Server) cascadeRowDeletes(ctx context.Context, store, rowStore *db.StoreAdapter, user *auth.User, table string, writes []dynamo.Fact) (*deletePlanner, []rowRef, error) {
+	planner := s.newDeletePlanner(ctx, store, user)
+	planner.stores[table] = rowStore
+	for _, f := range writes {
+		planner.skip[rowRef{table, f.FieldName}] = true
+	}
+	var cascades []rowRef
+	seen := map[rowRef]bool{}
+	for _, f := range writes {
+		if rowLive(f) {
+			continue
+		}
+		rows, err := planner.plan(table, f.FieldName)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, row := range rows[1:] {
+			if !seen[row] {
+				seen[row] = true
+				cascades = append(cascades, row)
+			}
+		}
+	}
+	return planner, cascades, nil
+}
+
+// applyRowFacts indexes and writes facts planned by planRollback or
+// planMerge, publishing each row's event once its batch is written. Rows
+// the facts delete are checked against references first, and their
+// cascades are deleted before the facts are written.
+func (s *Server) applyRowFacts(ctx context.Context, store, rowStore *db.StoreAdapter, user *auth.User, table string, def dynamo.Fact, writes []dynamo.Fact, events []plugin.RowEvent, now time.Time) error {
+	planner, cascades, err := s.cascadeRowDeletes(ctx, store, rowStore, user, table, writes)
+	if err != nil {
+		return err
+	}
+	for i := len(cascades) - 1; i >= 0; i-- {
+		target := cascades[i]
+		targetStore, err := planner.rowStore(target.table)
+		if err != nil {
+			return err
+		}
+		fact := dynamo.Fact{
+			ID:        newID(),
+			Timestamp: now,
+			Namespace: tableNamespace(user.ID, target.table),
+			FieldName: target.row,
+			DataType:  dynamo.DataTypeDeleted,
+		}
+		if err := targetStore.PutFact(ctx, fact); err != nil {
+			return err
+		}
+		s.publishRowEvent(plugin.RowEvent{Type: "delete", UserID: user.ID, Table: target.table, Row: target.row, Timestamp: now})
+	}
+	for _, ev := range events {
+		if ev.Values == nil {
+			continue
+		}
+		if err := s.indexGeoPoints(ctx, store, user.ID, table, def.Columns, ev.Row, ev.Values, now); err != nil {
+			return fmt.Errorf("indexing row location: %w", err)
+		}
+		if err := s.indexExpiry(ctx, store, user.ID, table, def, ev.Row, ev.Values, now); err != nil {
+			return fmt.Errorf("indexing row expiry: %w", err)
+		}
+		if err := s.indexReferences(ctx, store, user.ID, table, def.Columns, ev.Row, ev.Values, now); err != nil {
+			return fmt.Errorf("indexing row references: %w", err)
+		}
+	}
+	for start := 0; start < len(writes); start += maxRollbackBatch {
+		end := min(start+maxRollbackBatch, len(writes))
+		if err := rowStore.PutFactsTransactional(ctx, writes[start:end]); err != nil {
+			return err
+		}
+		for _, ev := range events[start:end] {
+			s.publishRowEvent(ev)
+		}
+		reportProgress(ctx, end, len(writes))
+	}
+	return nil
+}
+
+// parseRollbackTime reads the required "to" query parameter, which may not
+// lie in the future
+func parseRollbackTime(w http.ResponseWriter, r *http.Request, now time.Time) (time.Time, bool) {
+	raw := r.URL.Query().Get("to")
+	if raw == "" {
+		writeValidationError(w, "A time to roll back to is required", []FieldError{{Field: "to", Message: "is required"}})
+		return time.Time{}, false
+	}
+	to, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'to' time format: %v (expected RFC3339)", err))
+		return time.Time{}, false
+	}
+	if to.After(now) {
+		writeError(w, http.StatusBadRequest, "'to' must not be in the future")
+		return time.Time{}, false
+	}
+	return to, true
+}
+
+// handleRollbackTable restores every row of a table to its state at a past
+// time by appending new facts, so no history is lost
+func (s *Server) handleRollbackTable(w http.ResponseWriter, r *http.Request) {
+	s.rollback(w, r, "")
+}
+
+// handleRevertRow restores one row to its state at a past time
+func (s *Server) handleRevertRow(w http.ResponseWriter, r *http.Request) {
+	s.rollback(w, r, r.PathValue("id"))
+}
+
+func (s *Server) rollback(w http.ResponseWriter, r *http.Request, row string) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	table := r.PathValue("table")
+	now := time.Now().UTC()
+	to, ok := parseRollbackTime(w, r, now)
+	if !ok {
+		return
+	}
+
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, now)
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+	if rejectVirtualWrite(w, facts, table) {
+		return
+	}
+	rowStore, err := s.getRowStore(r.Context(), store, user, table)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize table storage")
+		return
+	}
+
+	def := latestTableDef(facts)
+	writes, events, err := s.planRollback(r.Context(), store, rowStore, user, table, def, row, to, now)
+	if errors.Is(err, errRowNotFound) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Row '%s' not found in table '%s'", row, table))
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err, "Failed to read table history")
+		return
+	}
+
+	if err := s.applyRowFacts(r.Context(), store, rowStore, user, table, def, writes, events, now); err != nil {
+		if !writeRefusedDelete(w, err) {
+			writeStoreError(w, err, "Failed to roll back rows")
+		}
+		return
+	}
+
+	result := RollbackResult{To: to, Timestamp: now, Rows: []RowChange{}}
+	for _, ev := range events {
+		result.Rows = append(result.Rows, RowChange{ID: ev.Row, Change: ev.Type})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollbackTable(t *testing.T) {
+	_, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	for _, body := range []string{`{"id": "n1", "values": {"v": 1}}`, `{"id": "n2", "values": {"v": 2}}`} {
+		rec = do(http.MethodPost, "/tables/notes/rows", body)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	time.Sleep(5 * time.Millisecond)
+	checkpoint := url.QueryEscape(time.Now().UTC().Format(time.RFC3339Nano))
+	time.Sleep(5 * time.Millisecond)
+
+	rec = do(http.MethodPost, "/transactions", `{"ops": [{"op": "update", "table": "notes", "id": "n1", "values": {"v": 10}}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/tables/notes/rows/n2", "").Code)
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n3", "values": {"v": 3}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodPost, "/v1/tables/notes/rollback?to="+checkpoint, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var result RollbackResult
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, []RowChange{{ID: "n1", Change: "update"}, {ID: "n2", Change: "create"}, {ID: "n3", Change: "delete"}}, result.Rows)
+
+	assert.Equal(t, map[string]float64{"n1": 1, "n2": 2}, snapshotValues(t, do, "/tables/notes/snapshot"))
+
+	// History is kept, so the rollback itself can be undone
+	assert.Equal(t, map[string]float64{"n1": 10, "n3": 3}, snapshotValues(t, do, "/tables/notes/rows?at="+url.QueryEscape(result.Timestamp.Add(-time.Nanosecond).Format(time.RFC3339Nano))))
+
+	// Rolling back again changes nothing
+	rec = do(http.MethodPost, "/v1/tables/notes/rollback?to="+checkpoint, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"rows":[]`)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/v1/tables/notes/rollback", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/v1/tables/notes/rollback?to=2999-01-01T00:00:00Z", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/v1/tables/missing/rollback?to="+checkpoint, "").Code)
+}
+
+func TestRevertRow(t *testing.T) {
+	_, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	for _, body := range []string{`{"id": "n1", "values": {"v": 1}}`, `{"id": "n2", "values": {"v": 2}}`} {
+		rec = do(http.MethodPost, "/tables/notes/rows", body)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	time.Sleep(5 * time.Millisecond)
+	checkpoint := url.QueryEscape(time.Now().UTC().Format(time.RFC3339Nano))
+	time.Sleep(5 * time.Millisecond)
+	rec = do(http.MethodPost, "/transactions", `{"ops": [
+		{"op": "update", "table": "notes", "id": "n1", "values": {"v": 10}},
+		{"op": "update", "table": "notes", "id": "n2", "values": {"v": 20}}
+	]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodPost, "/v1/tables/notes/rows/n1/revert?to="+checkpoint, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"rows":[{"id":"n1","change":"update"}]`)
+	assert.Equal(t, map[string]float64{"n1": 1, "n2": 20}, snapshotValues(t, do, "/tables/notes/snapshot"))
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/v1/tables/notes/rows/nope/revert?to="+checkpoint, "").Code)
+}
+
+func TestRollbackReferences(t *testing.T) {
+	_, do := memoryServer(t)
+	create := func(path, body string) {
+		t.Helper()
+		rec := do(http.MethodPost, path, body)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	create("/tables", `{"name": "customers"}`)
+	create("/tables", `{"name": "invoices", "columns": [{"name": "customer", "dataType": "reference", "references": "customers", "onDelete": "restrict"}]}`)
+	create("/tables", `{"name": "orders", "columns": [{"name": "customer", "dataType": "reference", "references": "customers", "onDelete": "cascade"}]}`)
+	time.Sleep(5 * time.Millisecond)
+	checkpoint := url.QueryEscape(time.Now().UTC().Format(time.RFC3339Nano))
+	time.Sleep(5 * time.Millisecond)
+	create("/tables/customers/rows", `{"id": "c1", "values": {"v": 1}}`)
+	create("/tables/invoices/rows", `{"id": "v1", "values": {"customer": "c1"}}`)
+
+	// Rolling back to before the customer existed would delete it
+	rec := do(http.MethodPost, "/v1/tables/customers/rollback?to="+checkpoint, "")
+	assert.Equal(t, http.StatusConflict, rec.Code, rec.Body.String())
+	assert.Equal(t, map[string]float64{"c1": 1}, snapshotValues(t, do, "/tables/customers/snapshot"))
+	rec = do(http.MethodPost, "/v1/tables/customers/rows/c1/revert?to="+checkpoint, "")
+	assert.Equal(t, http.StatusConflict, rec.Code, rec.Body.String())
+
+	// Cascading references are deleted with the rolled back row
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/tables/invoices/rows/v1", "").Code)
+	create("/tables/orders/rows", `{"id": "o1", "values": {"customer": "c1"}}`)
+	rec = do(http.MethodPost, "/v1/tables/customers/rollback?to="+checkpoint, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Empty(t, snapshotValues(t, do, "/tables/customers/snapshot"))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/tables/orders/rows/o1", "").Code)
+}
+
+// snapshotValues returns the "v" value of each row listed at a path
+func snapshotValues(t *testing.T, do func(method, path, body string) *httptest.ResponseRecorder, path string) map[string]float64 {
+	t.Helper()
+	rec := do(http.MethodGet, path, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var snap struct {
+		Rows []RowData `json:"rows"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &snap))
+	values := map[string]float64{}
+	for _, row := range snap.Rows {
+		values[row.ID] = row.Values["v"].(float64)
+	}
+	return values
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyRotation(t *testing.T) {
+	srv, do := memoryServer(t)
+	ctx := context.Background()
+
+	rec := do(http.MethodPost, "/v1/auth/keys", `{"name": "ci", "scopes": ["read"]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var old struct {
+		ID     string `json:"id"`
+		APIKey string `json:"apiKey"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &old))
+
+	rec = do(http.MethodPost, "/v1/auth/keys/"+old.ID+"/rotate", `{"gracePeriod": 3600}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var rotated struct {
+		ID                string    `json:"id"`
+		Name              string    `json:"name"`
+		APIKey            string    `json:"apiKey"`
+		Scopes            []string  `json:"scopes"`
+		RotatedFrom       string    `json:"rotatedFrom"`
+		PreviousExpiresAt time.Time `json:"previousExpiresAt"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rotated))
+	assert.Equal(t, "ci", rotated.Name)
+	assert.Equal(t, []string{"read"}, rotated.Scopes)
+	assert.Equal(t, old.ID, rotated.RotatedFrom)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), rotated.PreviousExpiresAt, 2*time.Second)
+
+	// Both keys work during the grace period
+	for _, key := range []string{old.APIKey, rotated.APIKey} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/tables", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	rec = do(http.MethodGet, "/v1/auth/keys", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var list struct {
+		Keys []struct {
+			ID          string `json:"id"`
+			State       string `json:"state"`
+			RotatedTo   string `json:"rotatedTo"`
+			RotatedFrom string `json:"rotatedFrom"`
+		} `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	states := map[string]string{}
+	for _, k := range list.Keys {
+		states[k.ID] = k.State
+		if k.ID == old.ID {
+			assert.Equal(t, rotated.ID, k.RotatedTo)
+		}
+		if k.ID == rotated.ID {
+			assert.Equal(t, old.ID, k.RotatedFrom)
+		}
+	}
+	assert.Equal(t, auth.KeyRotating, states[old.ID])
+	assert.Equal(t, auth.KeyActive, states[rotated.ID])
+
+	// A key is rotated once; the replacement can be rotated in turn
+	rec = do(http.MethodPost, "/v1/auth/keys/"+old.ID+"/rotate", `{}`)
+	assert.Equal(t, http.StatusConflict, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/v1/auth/keys/missing/rotate", `{}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Without a grace period the old key stops working at once
+	rec = do(http.MethodPost, "/v1/auth/keys/"+rotated.ID+"/rotate", `{"gracePeriod": 0}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	_, _, err := srv.authenticator.VerifyAPIKey(ctx, rotated.APIKey)
+	assert.ErrorIs(t, err, auth.ErrAPIKeyExpired)
+}
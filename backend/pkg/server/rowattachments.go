@@ -0,0 +1,480 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/blob"
+)
+
+const (
+	// rowAttachmentsDataType marks the facts listing a row's attachments
+	rowAttachmentsDataType = "rowattachments"
+	// attachmentUsageDataType marks the facts holding an account's
+	// attachment storage usage in bytes
+	attachmentUsageDataType = "attachmentusage"
+	// attachmentUsageField is the field, in the user's namespace, holding
+	// the account's attachment storage usage
+	attachmentUsageField = "attachments/usage"
+)
+
+// defaultMaxAttachmentBytes caps row attachments when Config.MaxAttachmentBytes is unset
+const defaultMaxAttachmentBytes = 10 << 20
+
+// multipartOverhead is the room left in an upload's body limit for the
+// multipart boundaries and headers around the file
+const multipartOverhead = 64 << 10
+
+// rowAttachmentUpload matches the paths of row attachment uploads, whose
+// bodies are limited by MaxAttachmentBytes instead of MaxBodyBytes
+var rowAttachmentUpload = regexp.MustCompile(`^(/v1)?/tables/[^/]+/rows/[^/]+/attachments$`)
+
+// RowAttachment is a file attached to a row
+type RowAttachment struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"createdAt"`
+	// Key is where the file is stored; it is not returned to clients
+	Key string `json:"key,omitempty"`
+	// URL downloads the file through the server
+	URL string `json:"url,omitempty"`
+}
+
+// attachmentTypesFromEnv reads the allowed row attachment media types from
+// the environment
+func attachmentTypesFromEnv() []string {
+	var types []string
+	for _, t := range strings.Split(os.Getenv("NOTABLY_ATTACHMENT_TYPES"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, strings.ToLower(t))
+		}
+	}
+	return types
+}
+
+// rowAttachmentsField is the field, in the user's namespace, listing a
+// row's attachments
+func rowAttachmentsField(table, row string) string {
+	return settingField(table, "attachments", row)
+}
+
+// maxAttachmentBytes is the configured size limit of row attachments
+func (s *Server) maxAttachmentBytes() int64 {
+	if s.config.MaxAttachmentBytes <= 0 {
+		return defaultMaxAttachmentBytes
+	}
+	return s.config.MaxAttachmentBytes
+}
+
+// attachmentTypeAllowed reports whether row attachments may have a media type
+func (s *Server) attachmentTypeAllowed(contentType string) bool {
+	if len(s.config.AttachmentTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range s.config.AttachmentTypes {
+		if allowed == mediaType || allowed == "*/*" {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// loadRowAttachments returns a row's attachments, oldest first. Lists saved
+// before the table was last (re)created are ignored.
+func (s *Server) loadRowAttachments(ctx context.Context, store *db.StoreAdapter, userID, table, row string, def dynamo.Fact) ([]RowAttachment, error) {
+	facts, err := store.QueryByField(ctx, userID, rowAttachmentsField(table, row), time.Time{}, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	var latest *dynamo.Fact
+	for i := range facts {
+		if facts[i].DataType != rowAttachmentsDataType {
+			continue
+		}
+		if latest == nil || facts[i].Timestamp.After(latest.Timestamp) {
+			latest = &facts[i]
+		}
+	}
+	if latest == nil || latest.Timestamp.Before(def.Timestamp) {
+		return nil, nil
+	}
+	raw, _ := latest.Value.(string)
+	var list []RowAttachment
+	if err := json.Unmarshal([]byte(raw), &list); err != nil {
+		return nil, fmt.Errorf("decoding attachments of row %s in table %s: %w", row, table, err)
+	}
+	return list, nil
+}
+
+// saveRowAttachments records a row's attachment list
+func (s *Server) saveRowAttachments(ctx context.Context, store *db.StoreAdapter, userID, table, row string, list []RowAttachment) error {
+	if list == nil {
+		list = []RowAttachment{}
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return store.PutFact(ctx, dynamo.Fact{
+		ID:        newID(),
+		Timestamp: time.Now().UTC(),
+		Namespace: userID,
+		FieldName: rowAttachmentsField(table, row),
+		DataType:  rowAttachmentsDataType,
+		Value:     string(data),
+	})
+}
+
+// attachmentUsage returns the bytes of row attachments an account stores
+func (s *Server) attachmentUsage(ctx context.Context, store *db.StoreAdapter, userID string) (int64, error) {
+	facts, err := store.QueryByField(ctx, userID, attachmentUsageField, time.Time{}, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	var latest *dynamo.Fact
+	for i := range facts {
+		if facts[i].DataType != attachmentUsageDataType {
+			continue
+		}
+		if latest == nil || facts[i].Timestamp.After(latest.Timestamp) {
+			latest = &facts[i]
+		}
+	}
+	if latest == nil {
+		return 0, nil
+	}
+	raw, _ := latest.Value.(string)
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("decoding attachment usage: %w", err)
+	}
+	return n, nil
+}
+
+// addAttachmentUsage adds delta bytes to an account's attachment usage
+func (s *Server) addAttachmentUsage(ctx context.Context, store *db.StoreAdapter, userID string, delta int64) error {
+	used, err := s.attachmentUsage(ctx, store, userID)
+	if err != nil {
+		return err
+	}
+	used += delta
+	if used < 0 {
+		used = 0
+	}
+	return store.PutFact(ctx, dynamo.Fact{
+		ID:        newID(),
+		Timestamp: time.Now().UTC(),
+		Namespace: userID,
+		FieldName: attachmentUsageField,
+		DataType:  attachmentUsageDataType,
+		Value:     strconv.FormatInt(used, 10),
+	})
+}
+
+// attachmentTarget is the row a row attachment request is about
+type attachmentTarget struct {
+	user  *auth.User
+	store *db.StoreAdapter
+	table string
+	row   string
+	def   dynamo.Fact
+}
+
+// resolveAttachmentTarget checks that attachments are configured and that
+// the request's table and row exist. On failure it writes the error
+// response and returns false.
+func (s *Server) resolveAttachmentTarget(w http.ResponseWriter, r *http.Request) (attachmentTarget, bool) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return attachmentTarget{}, false
+	}
+	if s.attachments == nil {
+		writeError(w, http.StatusNotImplemented, "Attachments are not configured on this server")
+		return attachmentTarget{}, false
+	}
+
+	table := r.PathValue("table")
+	rowID := r.PathValue("id")
+
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return attachmentTarget{}, false
+	}
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return attachmentTarget{}, false
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return attachmentTarget{}, false
+	}
+	if proxiedTable(facts) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Table '%s' is a virtual table; its rows cannot have attachments", table))
+		return attachmentTarget{}, false
+	}
+	rowStore, err := s.getRowStore(r.Context(), store, user, table)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize table storage")
+		return attachmentTarget{}, false
+	}
+	def := latestTableDef(facts)
+	entries, err := s.tableEntries(r.Context(), store, rowStore, user, table, def, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to get snapshot")
+		return attachmentTarget{}, false
+	}
+	if f, ok := entries[rowID]; !ok || !rowLive(f) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Row '%s' not found in table '%s'", rowID, table))
+		return attachmentTarget{}, false
+	}
+	return attachmentTarget{user: user, store: store, table: table, row: rowID, def: def}, true
+}
+
+// downloadURL is the path serving a row attachment through the server
+func (t attachmentTarget) downloadURL(id string) string {
+	return fmt.Sprintf("/v1/tables/%s/rows/%s/attachments/%s", t.table, t.row, id)
+}
+
+// present returns attachments as clients see them
+func (t attachmentTarget) present(list []RowAttachment) []RowAttachment {
+	out := make([]RowAttachment, len(list))
+	for i, a := range list {
+		a.Key = ""
+		a.URL = t.downloadURL(a.ID)
+		out[i] = a
+	}
+	return out
+}
+
+// handleUploadRowAttachment stores the file of a multipart "file" field as
+// an attachment of a row
+func (s *Server) handleUploadRowAttachment(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.resolveAttachmentTarget(w, r)
+	if !ok {
+		return
+	}
+
+	limit := s.maxAttachmentBytes()
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Attachments are limited to %d bytes", limit))
+			return
+		}
+		writeValidationError(w, "A multipart file upload is required", []FieldError{{Field: "file", Message: "is required"}})
+		return
+	}
+	defer file.Close()
+	if !validAttachmentName(header.Filename) {
+		writeValidationError(w, "Invalid attachment", []FieldError{{Field: "file", Message: "must have a file name without slashes"}})
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(file, limit+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Failed to read the uploaded file")
+		return
+	}
+	if int64(len(data)) > limit {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Attachments are limited to %d bytes", limit))
+		return
+	}
+
+	// A type recognized from the content wins over the declared one, so a
+	// renamed file cannot pass the type check
+	contentType := http.DetectContentType(data)
+	if declared := header.Header.Get("Content-Type"); declared != "" && (contentType == "application/octet-stream" || strings.HasPrefix(contentType, "text/plain")) {
+		contentType = declared
+	}
+	if !s.attachmentTypeAllowed(contentType) {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("Attachments of type '%s' are not allowed", contentType))
+		return
+	}
+
+	s.attachmentMu.Lock()
+	defer s.attachmentMu.Unlock()
+
+	ctx := r.Context()
+	if quota := s.config.AttachmentQuotaBytes; quota > 0 {
+		used, err := s.attachmentUsage(ctx, t.store, t.user.ID)
+		if err != nil {
+			writeStoreError(w, err, "Failed to read attachment usage")
+			return
+		}
+		if used+int64(len(data)) > quota {
+			writeError(w, http.StatusInsufficientStorage, fmt.Sprintf("Attachment storage quota of %d bytes exceeded (%d bytes used)", quota, used))
+			return
+		}
+	}
+	list, err := s.loadRowAttachments(ctx, t.store, t.user.ID, t.table, t.row, t.def)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load attachments")
+		return
+	}
+
+	id := newID()
+	a := RowAttachment{
+		ID:          id,
+		Name:        header.Filename,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		CreatedAt:   time.Now().UTC(),
+		Key:         attachmentPrefix(t.user.ID, t.table) + "rows/" + t.row + "/" + id + "/" + header.Filename,
+	}
+	if err := s.attachments.Put(ctx, a.Key, data); err != nil {
+		s.logger.ErrorContext(ctx, "storing attachment failed", "table", t.table, "row", t.row, "error", err)
+		writeError(w, http.StatusInternalServerError, "Failed to store attachment")
+		return
+	}
+	if err := s.saveRowAttachments(ctx, t.store, t.user.ID, t.table, t.row, append(list, a)); err != nil {
+		// Without the list entry nothing refers to the file
+		if delErr := s.attachments.Delete(ctx, a.Key); delErr != nil {
+			s.logger.WarnContext(ctx, "deleting unrecorded attachment failed", "key", a.Key, "error", delErr)
+		}
+		writeStoreError(w, err, "Failed to save attachment")
+		return
+	}
+	if err := s.addAttachmentUsage(ctx, t.store, t.user.ID, a.Size); err != nil {
+		s.logger.WarnContext(ctx, "recording attachment usage failed", "error", err)
+	}
+	writeJSON(w, http.StatusCreated, t.present([]RowAttachment{a})[0])
+}
+
+// handleListRowAttachments lists a row's attachments
+func (s *Server) handleListRowAttachments(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.resolveAttachmentTarget(w, r)
+	if !ok {
+		return
+	}
+	list, err := s.loadRowAttachments(r.Context(), t.store, t.user.ID, t.table, t.row, t.def)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load attachments")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"attachments": t.present(list)})
+}
+
+// handleDownloadRowAttachment serves the file of a row attachment
+func (s *Server) handleDownloadRowAttachment(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.resolveAttachmentTarget(w, r)
+	if !ok {
+		return
+	}
+	list, err := s.loadRowAttachments(r.Context(), t.store, t.user.ID, t.table, t.row, t.def)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load attachments")
+		return
+	}
+	id := r.PathValue("attachment")
+	for _, a := range list {
+		if a.ID != id {
+			continue
+		}
+		data, err := s.attachments.Get(r.Context(), a.Key)
+		if errors.Is(err, blob.ErrNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("The file of attachment '%s' is missing", id))
+			return
+		}
+		if err != nil {
+			s.logger.ErrorContext(r.Context(), "reading attachment failed", "table", t.table, "row", t.row, "error", err)
+			writeError(w, http.StatusInternalServerError, "Failed to read attachment")
+			return
+		}
+		w.Header().Set("Content-Type", a.ContentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if _, err := w.Write(data); err != nil {
+			s.logger.WarnContext(r.Context(), "writing attachment failed", "error", err)
+		}
+		return
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("Attachment '%s' not found on row '%s'", id, t.row))
+}
+
+// handleDeleteRowAttachment removes a row attachment and its file
+func (s *Server) handleDeleteRowAttachment(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.resolveAttachmentTarget(w, r)
+	if !ok {
+		return
+	}
+
+	s.attachmentMu.Lock()
+	defer s.attachmentMu.Unlock()
+
+	ctx := r.Context()
+	list, err := s.loadRowAttachments(ctx, t.store, t.user.ID, t.table, t.row, t.def)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load attachments")
+		return
+	}
+	id := r.PathValue("attachment")
+	for i, a := range list {
+		if a.ID != id {
+			continue
+		}
+		rest := append(append([]RowAttachment{}, list[:i]...), list[i+1:]...)
+		if err := s.saveRowAttachments(ctx, t.store, t.user.ID, t.table, t.row, rest); err != nil {
+			writeStoreError(w, err, "Failed to save attachments")
+			return
+		}
+		if err := s.attachments.Delete(ctx, a.Key); err != nil {
+			s.logger.WarnContext(ctx, "deleting attachment file failed", "key", a.Key, "error", err)
+		}
+		if err := s.addAttachmentUsage(ctx, t.store, t.user.ID, -a.Size); err != nil {
+			s.logger.WarnContext(ctx, "recording attachment usage failed", "error", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("Attachment '%s' not found on row '%s'", id, t.row))
+}
+
+// handleAttachmentUsage reports the account's row attachment storage
+func (s *Server) handleAttachmentUsage(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	used, err := s.attachmentUsage(r.Context(), store, user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to read attachment usage")
+		return
+	}
+	resp := map[string]interface{}{"bytes": used, "maxAttachmentBytes": s.maxAttachmentBytes()}
+	if s.config.AttachmentQuotaBytes > 0 {
+		resp["quotaBytes"] = s.config.AttachmentQuotaBytes
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elibdev/notably/pkg/blob"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pngHeader is enough of a PNG file for its type to be sniffed
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestRowAttachments(t *testing.T) {
+	srv, err := NewServer(Config{
+		TableName:            "Facts",
+		Logger:               logging.Discard(),
+		InMemory:             true,
+		MaxAttachmentBytes:   1024,
+		AttachmentTypes:      []string{"image/*", "text/plain"},
+		AttachmentQuotaBytes: 1500,
+	})
+	require.NoError(t, err)
+	dir := t.TempDir()
+	srv.attachments = blob.NewFileStore(dir)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+	require.NoError(t, err)
+
+	do := func(method, path, contentType string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	upload := func(row, name, declared string, data []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		h := make(map[string][]string)
+		h["Content-Disposition"] = []string{`form-data; name="file"; filename="` + name + `"`}
+		h["Content-Type"] = []string{declared}
+		part, err := mw.CreatePart(h)
+		require.NoError(t, err)
+		_, err = part.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, mw.Close())
+		return do(http.MethodPost, "/v1/tables/photos/rows/"+row+"/attachments", mw.FormDataContentType(), &body)
+	}
+
+	rec := do(http.MethodPost, "/tables", "application/json", strings.NewReader(`{"name": "photos", "columns": [{"name": "title", "dataType": "string"}]}`))
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/photos/rows", "application/json", strings.NewReader(`{"id": "p1", "values": {"title": "beach"}}`))
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, upload("missing", "a.png", "image/png", pngHeader).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, upload("p1", "big.png", "image/png", append(pngHeader, make([]byte, 2048)...)).Code)
+	assert.Equal(t, http.StatusUnsupportedMediaType, upload("p1", "page.png", "image/png", []byte("<html><body>hi</body></html>")).Code, "the sniffed type wins")
+
+	rec = upload("p1", "beach.png", "application/octet-stream", append(pngHeader, make([]byte, 900)...))
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var photo RowAttachment
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &photo))
+	assert.Equal(t, "image/png", photo.ContentType)
+	assert.Equal(t, int64(len(pngHeader)+900), photo.Size)
+	assert.Empty(t, photo.Key, "storage keys are not exposed")
+	assert.Equal(t, "/v1/tables/photos/rows/p1/attachments/"+photo.ID, photo.URL)
+
+	rec = upload("p1", "notes.txt", "text/plain", bytes.Repeat([]byte("a"), 700))
+	assert.Equal(t, http.StatusInsufficientStorage, rec.Code, "over the account's quota")
+	rec = upload("p1", "notes.txt", "text/plain", []byte("sunny"))
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodGet, "/tables/photos/rows/p1/attachments", "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var list struct {
+		Attachments []RowAttachment `json:"attachments"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list.Attachments, 2)
+	assert.Equal(t, "beach.png", list.Attachments[0].Name)
+
+	rec = do(http.MethodGet, photo.URL, "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename=beach.png`, rec.Header().Get("Content-Disposition"))
+	assert.True(t, bytes.HasPrefix(rec.Body.Bytes(), pngHeader))
+
+	rec = do(http.MethodGet, "/attachments/usage", "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"bytes": 921, "maxAttachmentBytes": 1024, "quotaBytes": 1500}`, rec.Body.String())
+
+	rec = do(http.MethodDelete, photo.URL, "", nil)
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, photo.URL, "", nil).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, photo.URL, "", nil).Code)
+
+	rec = do(http.MethodGet, "/attachments/usage", "", nil)
+	assert.JSONEq(t, `{"bytes": 5, "maxAttachmentBytes": 1024, "quotaBytes": 1500}`, rec.Body.String())
+}
+
+func TestRowAttachmentsNotConfigured(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true})
+	require.NoError(t, err)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/tables/photos/rows/p1/attachments", nil)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/filter"
+	"github.com/elibdev/notably/pkg/jsonpath"
+)
+
+// rowQuery is the filtering, ordering and projection asked of a row listing
+// with the "filter", "sort", "fields" and "select" query parameters
+type rowQuery struct {
+	filter  *filter.Filter
+	sort    filter.Sort
+	fields  []string
+	selects []*jsonpath.Path
+}
+
+// parseRowQuery reads "filter=<JSON filter>", "sort=col[:asc|:desc],...",
+// "fields=col,..." and "select=$.path,...". On a table with a schema only
+// its columns and the system columns are allowed, and JSONPath expressions
+// must start with one of them.
+func parseRowQuery(q url.Values, columns []dynamo.ColumnDefinition) (rowQuery, fieldErrors) {
+	var rq rowQuery
+	var fields fieldErrors
+	known := func(name string) bool {
+		if len(columns) == 0 || isSystemColumn(name) {
+			return true
+		}
+		for _, col := range columns {
+			if col.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	if raw := q.Get("filter"); raw != "" {
+		var f filter.Filter
+		if err := json.Unmarshal([]byte(raw), &f); err != nil {
+			fields = append(fields, FieldError{Field: "filter", Message: "must be a JSON filter"})
+		} else if err := f.Validate(); err != nil {
+			fields = append(fields, FieldError{Field: "filter", Message: err.Error()})
+		} else {
+			for _, name := range f.Columns() {
+				if !known(name) {
+					fields = append(fields, FieldError{Field: "filter", Message: fmt.Sprintf("unknown column '%s'", name)})
+				}
+			}
+			rq.filter = &f
+		}
+	}
+
+	if raw := q.Get("sort"); raw != "" {
+		for _, part := range strings.Split(raw, ",") {
+			name, dir, _ := strings.Cut(strings.TrimSpace(part), ":")
+			key := filter.SortKey{Column: name}
+			switch dir {
+			case "", "asc":
+			case "desc":
+				key.Desc = true
+			default:
+				fields = append(fields, FieldError{Field: "sort", Message: fmt.Sprintf("direction of '%s' must be 'asc' or 'desc'", name)})
+				continue
+			}
+			switch {
+			case name == "":
+				fields = append(fields, FieldError{Field: "sort", Message: "column is required"})
+			case !known(name):
+				fields = append(fields, FieldError{Field: "sort", Message: fmt.Sprintf("unknown column '%s'", name)})
+			default:
+				rq.sort = append(rq.sort, key)
+			}
+		}
+	}
+
+	if raw := q.Get("fields"); raw != "" {
+		for _, name := range strings.Split(raw, ",") {
+			name = strings.TrimSpace(name)
+			switch {
+			case name == "":
+				fields = append(fields, FieldError{Field: "fields", Message: "column is required"})
+			case !known(name):
+				fields = append(fields, FieldError{Field: "fields", Message: fmt.Sprintf("unknown column '%s'", name)})
+			default:
+				rq.fields = append(rq.fields, name)
+			}
+		}
+	}
+
+	if raw := q.Get("select"); raw != "" {
+		for _, expr := range strings.Split(raw, ",") {
+			p, err := jsonpath.Parse(strings.TrimSpace(expr))
+			switch {
+			case err != nil:
+				fields = append(fields, FieldError{Field: "select", Message: err.Error()})
+			case p.Root() != "" && !known(p.Root()):
+				fields = append(fields, FieldError{Field: "select", Message: fmt.Sprintf("unknown column '%s'", p.Root())})
+			default:
+				rq.selects = append(rq.selects, p)
+			}
+		}
+	}
+	return rq, fields
+}
+
+// sortValue converts a row value to the form its column type sorts by:
+// datetimes by instant, decimals exactly and enums in their declared order.
+// Other values sort as decoded from JSON.
+func sortValue(col dynamo.ColumnDefinition, v interface{}) interface{} {
+	str, ok := v.(string)
+	if !ok {
+		return v
+	}
+	switch col.DataType {
+	case "datetime":
+		if t, err := time.Parse(time.RFC3339, str); err == nil {
+			return t
+		}
+	case decimalType:
+		if r, ok := new(big.Rat).SetString(str); ok {
+			return r
+		}
+	case enumType:
+		for i, e := range col.Enum {
+			if e == str {
+				return float64(i)
+			}
+		}
+	}
+	return v
+}
+
+// apply filters, sorts and projects rows. Rows that sort equal keep their ID
+// order. Selected paths are returned as values keyed by their expression,
+// beside the listed fields. Projecting leaves out the system columns not
+// listed.
+func (rq rowQuery) apply(columns []dynamo.ColumnDefinition, rows []RowData) []RowData {
+	if rq.filter != nil {
+		// Filters also see the system columns
+		matched := rows[:0]
+		for _, row := range rows {
+			if rq.filter.Match(systemValues(row)) {
+				matched = append(matched, row)
+			}
+		}
+		rows = matched
+	}
+
+	if len(rq.sort) > 0 {
+		types := make(map[string]dynamo.ColumnDefinition, len(columns))
+		for _, col := range columns {
+			types[col.Name] = col
+		}
+		keys := make(map[string]map[string]interface{}, len(rows))
+		for _, row := range rows {
+			values := systemValues(row)
+			key := make(map[string]interface{}, len(rq.sort))
+			for _, k := range rq.sort {
+				key[k.Column] = sortValue(types[k.Column], values[k.Column])
+			}
+			keys[row.ID] = key
+		}
+		sort.Slice(rows, func(i, j int) bool {
+			if c := rq.sort.Compare(keys[rows[i].ID], keys[rows[j].ID]); c != 0 {
+				return c < 0
+			}
+			return rows[i].ID < rows[j].ID
+		})
+	}
+
+	if len(rq.fields) > 0 || len(rq.selects) > 0 {
+		keep := make(map[string]bool, len(rq.fields))
+		for _, name := range rq.fields {
+			keep[name] = true
+		}
+		for i := range rows {
+			row := &rows[i]
+			if row.Values != nil {
+				projected := make(map[string]interface{}, len(rq.fields)+len(rq.selects))
+				for _, name := range rq.fields {
+					if v, ok := row.Values[name]; ok {
+						projected[name] = v
+					}
+				}
+				for _, p := range rq.selects {
+					if v, ok := p.Select(row.Values); ok {
+						projected[p.String()] = v
+					}
+				}
+				row.Values = projected
+			}
+			if !keep[sysCreatedAt] {
+				row.CreatedAt = nil
+			}
+			if !keep[sysUpdatedAt] {
+				row.UpdatedAt = nil
+			}
+			if !keep[sysCreatedBy] {
+				row.CreatedBy = ""
+			}
+		}
+	}
+	return rows
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRowQuerySortAndFields(t *testing.T) {
+	_, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "orders", "columns": [
+		{"name": "price", "dataType": "decimal", "precision": 10, "scale": 2},
+		{"name": "due", "dataType": "datetime"},
+		{"name": "status", "dataType": "enum", "enum": ["new", "paid", "shipped"]},
+		{"name": "note", "dataType": "string"}
+	]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	for _, body := range []string{
+		`{"id": "o1", "values": {"price": "10.5", "due": "2024-01-01T12:00:00+02:00", "status": "shipped", "note": "a"}}`,
+		`{"id": "o2", "values": {"price": "9", "due": "2024-01-01T11:00:00Z", "status": "new", "note": "b"}}`,
+		`{"id": "o3", "values": {"price": "100", "due": "2024-01-01T09:30:00Z", "status": "paid", "note": "c"}}`,
+		`{"id": "o4", "values": {"note": "d"}}`,
+	} {
+		rec = do(http.MethodPost, "/tables/orders/rows", body)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	list := func(path string) []RowData {
+		t.Helper()
+		rec := do(http.MethodGet, path, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp struct {
+			Rows []RowData `json:"rows"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp.Rows
+	}
+	ids := func(rows []RowData) []string {
+		var out []string
+		for _, row := range rows {
+			out = append(out, row.ID)
+		}
+		return out
+	}
+
+	// Decimals sort by value, datetimes by instant and enums in declared
+	// order; rows without the column come last either way
+	assert.Equal(t, []string{"o2", "o1", "o3", "o4"}, ids(list("/v1/tables/orders/snapshot?sort=price")))
+	assert.Equal(t, []string{"o3", "o1", "o2", "o4"}, ids(list("/v1/tables/orders/snapshot?sort=price:desc")))
+	assert.Equal(t, []string{"o3", "o1", "o2", "o4"}, ids(list("/v1/tables/orders/rows?sort=due")))
+	assert.Equal(t, []string{"o2", "o3", "o1", "o4"}, ids(list("/v1/tables/orders/rows?sort=status:asc,note")))
+
+	rows := list("/v1/tables/orders/snapshot?fields=note&sort=note:desc")
+	require.Len(t, rows, 4)
+	assert.Equal(t, "o4", rows[0].ID)
+	assert.Equal(t, map[string]interface{}{"note": "d"}, rows[0].Values)
+	assert.Nil(t, rows[0].CreatedAt)
+
+	rows = list("/v1/tables/orders/rows?fields=price,_createdAt")
+	require.Len(t, rows, 4)
+	assert.NotNil(t, rows[0].CreatedAt)
+	assert.Nil(t, rows[0].UpdatedAt)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/v1/tables/orders/snapshot?sort=missing", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/v1/tables/orders/snapshot?sort=price:sideways", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/v1/tables/orders/rows?fields=price,,note", "").Code)
+}
+
+func TestRowQueryJSONPath(t *testing.T) {
+	_, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "people", "columns": [
+		{"name": "name", "dataType": "string"},
+		{"name": "address", "dataType": "object"},
+		{"name": "phones", "dataType": "array", "nullable": true}
+	]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	for _, body := range []string{
+		`{"id": "p1", "values": {"name": "Ada", "address": {"city": "London", "geo": {"lat": 51.5}}, "phones": [{"kind": "home", "n": "1"}, {"kind": "work", "n": "2"}]}}`,
+		`{"id": "p2", "values": {"name": "Max", "address": {"city": "Berlin"}, "phones": [{"kind": "home", "n": "3"}]}}`,
+		`{"id": "p3", "values": {"name": "Eva", "address": {"city": "Berlin"}}}`,
+	} {
+		rec = do(http.MethodPost, "/tables/people/rows", body)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	list := func(params url.Values) []RowData {
+		t.Helper()
+		rec := do(http.MethodGet, "/tables/people/rows?"+params.Encode(), "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp struct {
+			Rows []RowData `json:"rows"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp.Rows
+	}
+
+	rows := list(url.Values{
+		"filter": {`{"column": "$.address.city", "op": "eq", "value": "Berlin"}`},
+		"select": {"$.address.city,$.phones[0].n,$.phones[*].kind"},
+		"fields": {"name"},
+		"sort":   {"name"},
+	})
+	require.Len(t, rows, 2)
+	assert.Equal(t, "p3", rows[0].ID)
+	assert.Equal(t, map[string]interface{}{
+		"name":             "Eva",
+		"$.address.city":   "Berlin",
+		"$.phones[*].kind": []interface{}{},
+	}, rows[0].Values, "definite paths that select nothing are left out")
+	assert.Equal(t, map[string]interface{}{
+		"name":             "Max",
+		"$.address.city":   "Berlin",
+		"$.phones[0].n":    "3",
+		"$.phones[*].kind": []interface{}{"home"},
+	}, rows[1].Values)
+	assert.Nil(t, rows[1].CreatedAt, "projections leave out unlisted system columns")
+
+	rows = list(url.Values{"filter": {`{"column": "$.phones[*].kind", "op": "contains", "value": "work"}`}})
+	require.Len(t, rows, 1)
+	assert.Equal(t, "p1", rows[0].ID)
+
+	rows = list(url.Values{"filter": {`{"column": "$.address.geo.lat", "op": "gt", "value": 50}`}, "select": {"$.address.geo"}})
+	require.Len(t, rows, 1)
+	assert.Equal(t, map[string]interface{}{"$.address.geo": map[string]interface{}{"lat": 51.5}}, rows[0].Values)
+
+	for _, q := range []url.Values{
+		{"select": {"address.city"}},
+		{"select": {"$.missing.city"}},
+		{"select": {"$.address["}},
+		{"filter": {`{"column": "$.missing.x", "op": "exists"}`}},
+		{"filter": {`{"column": "$.address.city", "op": "like", "value": "B"}`}},
+		{"filter": {`not json`}},
+	} {
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/tables/people/rows?"+q.Encode(), "").Code, q.Encode())
+	}
+}
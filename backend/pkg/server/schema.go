@@ -0,0 +1,233 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+)
+
+// maxSchemaViolations caps the violations listed when a schema change is rejected
+const maxSchemaViolations = 100
+
+// SchemaViolation is a value of an existing row that does not satisfy a new
+// schema
+type SchemaViolation struct {
+	Row     string `json:"row"`
+	Column  string `json:"column"`
+	Message string `json:"message"`
+}
+
+// schemaViolationResponse is the body returned when validation finds rows
+// that the new schema would reject
+type schemaViolationResponse struct {
+	Error      string            `json:"error"`
+	Violations []SchemaViolation `json:"violations"`
+	Truncated  bool              `json:"truncated,omitempty"`
+}
+
+// checkColumnDefs validates column definitions for a new or changed table
+func (s *Server) checkColumnDefs(columns []dynamo.ColumnDefinition) error {
+	for _, col := range columns {
+		if col.Name == "" {
+			return fmt.Errorf("Column name is required")
+		}
+		if isSystemColumn(col.Name) {
+			return fmt.Errorf("Column name '%s' is reserved for a system column", col.Name)
+		}
+		if problem := s.nameProblem(col.Name); problem != "" {
+			return fmt.Errorf("Column name '%s' %s", col.Name, problem)
+		}
+		if col.DataType == "" {
+			return fmt.Errorf("Data type is required for column '%s'", col.Name)
+		}
+		if err := s.checkDataType(col.Name, col.DataType); err != nil {
+			return err
+		}
+		if err := checkTypeOptions(col); err != nil {
+			return err
+		}
+		if col.Default != nil {
+			if err := s.checkColumnValue(col, col.Default); err != nil {
+				return fmt.Errorf("Default for column '%s' is invalid: %s", col.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// applyDefaults fills the columns a new row leaves out with their defaults.
+// An explicit null is kept, so nullable columns can still be set to null.
+func applyDefaults(columns []dynamo.ColumnDefinition, values map[string]interface{}) {
+	for _, col := range columns {
+		if col.Default == nil {
+			continue
+		}
+		if _, ok := values[col.Name]; !ok {
+			values[col.Name] = copyValue(col.Default)
+		}
+	}
+}
+
+// copyValue returns a deep copy of a decoded JSON value, so rows never share
+// a default's maps or slices
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			out[k] = copyValue(child)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, child := range v {
+			out[i] = copyValue(child)
+		}
+		return out
+	}
+	return v
+}
+
+// schemaViolations lists every way a row's values break a schema
+func (s *Server) schemaViolations(columns []dynamo.ColumnDefinition, rowID string, values map[string]interface{}) []SchemaViolation {
+	var out []SchemaViolation
+	defined := make(map[string]dynamo.ColumnDefinition, len(columns))
+	for _, col := range columns {
+		defined[col.Name] = col
+		if _, ok := values[col.Name]; col.Required && !ok {
+			out = append(out, SchemaViolation{Row: rowID, Column: col.Name, Message: "is required"})
+		}
+	}
+	for name, value := range values {
+		col, ok := defined[name]
+		if !ok {
+			out = append(out, SchemaViolation{Row: rowID, Column: name, Message: "is not defined in the new schema"})
+			continue
+		}
+		if err := s.checkColumnValue(col, value); err != nil {
+			out = append(out, SchemaViolation{Row: rowID, Column: name, Message: err.Error()})
+		}
+	}
+	return out
+}
+
+// handleUpdateSchema replaces a table's columns. Constraints apply to rows
+// written afterwards; with "validate": true the change is only made if every
+// current row already satisfies the new schema.
+func (s *Server) handleUpdateSchema(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	table := r.PathValue("table")
+
+	var req struct {
+		Columns  []dynamo.ColumnDefinition `json:"columns"`
+		Validate bool                      `json:"validate,omitempty"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := s.checkColumnDefs(req.Columns); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, col := range req.Columns {
+		if col.Encrypted && s.sealer == nil {
+			writeError(w, http.StatusNotImplemented, "Encrypted columns are not configured on this server")
+			return
+		}
+	}
+
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+	if rejectVirtualWrite(w, facts, table) {
+		return
+	}
+	def := latestTableDef(facts)
+	opts := tableOptionsOf(def)
+	if fields := validateTTLColumn(opts.TTLColumn, opts.Type, req.Columns); len(fields) > 0 {
+		writeValidationError(w, "Invalid schema", fields)
+		return
+	}
+
+	if req.Validate {
+		if opts.Type == tableTypeSecrets {
+			writeError(w, http.StatusBadRequest, "Rows of secrets tables are encrypted and cannot be validated")
+			return
+		}
+		rowStore, err := s.getRowStore(r.Context(), store, user, table)
+		if err != nil {
+			writeStoreError(w, err, "Failed to initialize table storage")
+			return
+		}
+		entries, err := s.tableEntries(r.Context(), store, rowStore, user, table, def, time.Now().UTC())
+		if err != nil {
+			writeStoreError(w, err, "Failed to get snapshot")
+			return
+		}
+		resp := schemaViolationResponse{Error: "Existing rows do not satisfy the new schema"}
+		for rowID, fact := range entries {
+			values, _ := fact.Value.(map[string]interface{})
+			resp.Violations = append(resp.Violations, s.schemaViolations(req.Columns, rowID, values)...)
+		}
+		if len(resp.Violations) > 0 {
+			sort.Slice(resp.Violations, func(i, j int) bool {
+				a, b := resp.Violations[i], resp.Violations[j]
+				if a.Row != b.Row {
+					return a.Row < b.Row
+				}
+				return a.Column < b.Column
+			})
+			if len(resp.Violations) > maxSchemaViolations {
+				resp.Violations, resp.Truncated = resp.Violations[:maxSchemaViolations], true
+			}
+			writeJSON(w, http.StatusConflict, resp)
+			return
+		}
+	}
+
+	fact := dynamo.Fact{
+		ID:        newID(),
+		Timestamp: time.Now().UTC(),
+		Namespace: user.ID,
+		FieldName: table,
+		DataType:  tableDataType,
+		Value:     def.Value,
+		Columns:   req.Columns,
+	}
+	if err := store.PutFact(r.Context(), fact); err != nil {
+		writeStoreError(w, err, "Failed to update schema")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, TableInfo{
+		Name:      table,
+		Type:      opts.Type,
+		CreatedAt: fact.Timestamp,
+		Columns:   req.Columns,
+		Temporary: opts.ExpiresAt != nil,
+		ExpiresAt: opts.ExpiresAt,
+		Source:    opts.Source.info(),
+		TTLColumn: opts.TTLColumn,
+		Fork:      opts.Fork,
+		Merge:     opts.Merge,
+	})
+}
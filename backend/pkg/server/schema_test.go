@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestColumnConstraints(t *testing.T) {
+	_, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "tasks", "columns": [
+		{"name": "title", "dataType": "string", "required": true},
+		{"name": "status", "dataType": "string", "default": "open"},
+		{"name": "tags", "dataType": "array", "default": []},
+		{"name": "due", "dataType": "datetime", "nullable": true}
+	]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodPost, "/tables/tasks/rows", `{"id": "t1", "values": {"title": "write docs"}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var row RowData
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &row))
+	assert.Equal(t, map[string]interface{}{"title": "write docs", "status": "open", "tags": []interface{}{}}, row.Values)
+
+	for _, tt := range []struct {
+		body string
+		msg  string
+	}{
+		{`{"values": {"status": "done"}}`, "Column 'title' is required"},
+		{`{"values": {"title": null}}`, "Column 'title' cannot be null"},
+		{`{"values": {"title": "x", "status": 3}}`, "does not match expected type 'string'"},
+	} {
+		rec := do(http.MethodPost, "/tables/tasks/rows", tt.body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, tt.body)
+		assert.Contains(t, rec.Body.String(), tt.msg)
+	}
+
+	// Nullable columns take null, and an explicit null is not replaced by a default
+	rec = do(http.MethodPost, "/tables/tasks/rows", `{"id": "t2", "values": {"title": "x", "due": null}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodPost, "/tables", `{"name": "bad", "columns": [{"name": "n", "dataType": "number", "default": "one"}]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Default for column 'n'")
+}
+
+func TestUpdateSchema(t *testing.T) {
+	_, do := memoryServer(t)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "people", "columns": [{"name": "name", "dataType": "string", "nullable": true}, {"name": "age", "dataType": "number"}]}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/people/rows", `{"id": "p1", "values": {"name": "Ann", "age": 30}}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/people/rows", `{"id": "p2", "values": {"name": null}}`).Code)
+
+	// Validation reports every row the new schema would reject
+	rec := do(http.MethodPut, "/tables/people/schema", `{"validate": true, "columns": [{"name": "name", "dataType": "string", "required": true}]}`)
+	require.Equal(t, http.StatusConflict, rec.Code, rec.Body.String())
+	var resp schemaViolationResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, []SchemaViolation{
+		{Row: "p1", Column: "age", Message: "is not defined in the new schema"},
+		{Row: "p2", Column: "name", Message: "Column 'name' cannot be null"},
+	}, resp.Violations)
+
+	// A schema the rows satisfy is applied
+	rec = do(http.MethodPut, "/tables/people/schema", `{"validate": true, "columns": [
+		{"name": "name", "dataType": "string", "nullable": true},
+		{"name": "age", "dataType": "number"},
+		{"name": "email", "dataType": "string", "required": true}
+	]}`)
+	assert.Equal(t, http.StatusConflict, rec.Code, "existing rows lack the new required column")
+
+	rec = do(http.MethodPut, "/tables/people/schema", `{"columns": [
+		{"name": "name", "dataType": "string", "nullable": true},
+		{"name": "age", "dataType": "number"},
+		{"name": "email", "dataType": "string", "required": true}
+	]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// Without validation the change applies to new rows only
+	rec = do(http.MethodPost, "/tables/people/rows", `{"id": "p3", "values": {"name": "Bo"}}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Column 'email' is required")
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPut, "/tables/missing/schema", `{"columns": []}`).Code)
+}
+
+func TestDataTypeRegistry(t *testing.T) {
+	_, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "typos", "columns": [{"name": "title", "dataType": "stringg"}]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Column 'title' has unknown data type 'stringg'")
+
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "events", "columns": [{"name": "on", "dataType": "date"}]}`).Code)
+	rec = do(http.MethodPut, "/tables/events/schema", `{"columns": [{"name": "on", "dataType": "dat"}]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "unknown data type 'dat'")
+
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/events/rows", `{"values": {"on": "2024-02-29"}}`).Code)
+	rec = do(http.MethodPost, "/tables/events/rows", `{"values": {"on": "2024-02-30"}}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "dates are checked")
+
+	rec = do(http.MethodGet, "/datatypes", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp struct {
+		DataTypes []DataType `json:"dataTypes"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.DataTypes, len(builtinDataTypes)+1)
+	assert.Equal(t, "string", resp.DataTypes[0].Name)
+	assert.Equal(t, DataType{Name: "even", Values: "values accepted by the plugin", Plugin: "server-test"}, resp.DataTypes[len(builtinDataTypes)], "plugin types follow the built-in ones")
+	for _, dt := range resp.DataTypes {
+		assert.NotEmpty(t, dt.Values, dt.Name)
+		if dt.Name == decimalType {
+			assert.Equal(t, []string{"precision", "scale"}, dt.Parameters)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/elibdev/notably/pkg/auth"
+)
+
+// checkScopes enforces the restricting scopes of the request's API key on
+// the matched route: read-only keys may only read, and table-scoped keys
+// may only reach their own tables. Tables named in request bodies are
+// checked by tableVisible instead.
+func checkScopes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := auth.APIKeyFromContext(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if key.ReadOnly() && !readRequest(r) {
+			writeError(w, http.StatusForbidden, "API key is read-only")
+			return
+		}
+		if table := r.PathValue("table"); table != "" && !key.CanAccessTable(table) {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("API key is not scoped for table '%s'", table))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readRequest reports whether a request only reads: a GET or HEAD, or a
+// SQL query, which is sent in a POST body
+func readRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	case http.MethodPost:
+		return r.Pattern == "POST /query" || r.Pattern == "POST "+apiVersion+"/query"
+	}
+	return false
+}
+
+// requireAdmin is requireAuth for routes that manage keys or review
+// access, which restricted keys may not use
+func (s *Server) requireAdmin(h http.HandlerFunc) http.Handler {
+	return s.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if key, ok := auth.APIKeyFromContext(r.Context()); ok && !key.IsAdmin() {
+			writeError(w, http.StatusForbidden, "API key lacks the admin scope")
+			return
+		}
+		h(w, r)
+	})
+}
+
+// keyAllowsTable reports whether the request's API key may reach a table
+func keyAllowsTable(r *http.Request, table string) bool {
+	key, ok := auth.APIKeyFromContext(r.Context())
+	return !ok || key.CanAccessTable(table)
+}
+
+// grantsSecrets reports whether scopes include secrets:read, the one scope
+// that gives a key more than the default permissions
+func grantsSecrets(scopes []string) bool {
+	for _, s := range scopes {
+		if s == auth.ScopeSecretsRead {
+			return true
+		}
+	}
+	return false
+}
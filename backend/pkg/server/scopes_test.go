@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scopedKey creates an API key with scopes and returns a client using it
+func scopedKey(t *testing.T, srv *Server, do func(method, path, body string) *httptest.ResponseRecorder, scopes string) func(method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := do(http.MethodPost, "/auth/keys", `{"name": "scoped", "scopes": `+scopes+`}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var created struct {
+		APIKey string `json:"apiKey"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	return func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+created.APIKey)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+}
+
+func TestKeyScopes(t *testing.T) {
+	srv, do := memoryServer(t)
+	for _, table := range []string{"orders", "payroll"} {
+		rec := do(http.MethodPost, "/tables", `{"name": "`+table+`"}`)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	rec := do(http.MethodPost, "/tables/orders/rows", `{"id": "o1", "values": {"total": 5}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	newKey := func(scopes string) func(method, path, body string) *httptest.ResponseRecorder {
+		return scopedKey(t, srv, do, scopes)
+	}
+
+	reader := newKey(`["read", "table:orders"]`)
+	assert.Equal(t, http.StatusOK, reader(http.MethodGet, "/tables/orders/snapshot", "").Code)
+	assert.Equal(t, http.StatusForbidden, reader(http.MethodPost, "/tables/orders/rows", `{"values": {"total": 1}}`).Code)
+	assert.Equal(t, http.StatusForbidden, reader(http.MethodGet, "/tables/payroll/snapshot", "").Code)
+	assert.Equal(t, http.StatusForbidden, reader(http.MethodGet, "/auth/keys", "").Code, "key management needs an admin key")
+	assert.Equal(t, http.StatusOK, reader(http.MethodPost, "/query", `{"sql": "SELECT total FROM orders"}`).Code, "SQL queries only read")
+	assert.Equal(t, http.StatusNotFound, reader(http.MethodPost, "/query", `{"sql": "SELECT * FROM payroll"}`).Code)
+
+	rec = reader(http.MethodGet, "/tables", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var list struct {
+		Tables []TableInfo `json:"tables"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list.Tables, 1, "tables outside the key's scopes are hidden")
+	assert.Equal(t, "orders", list.Tables[0].Name)
+
+	writer := newKey(`["table:orders", "table:returns"]`)
+	assert.Equal(t, http.StatusCreated, writer(http.MethodPost, "/tables/orders/rows", `{"values": {"total": 1}}`).Code)
+	rec = writer(http.MethodPost, "/transactions", `{"ops": [{"op": "create", "table": "payroll", "values": {"x": 1}}]}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code, "tables named in bodies are hidden too")
+	assert.Equal(t, http.StatusForbidden, writer(http.MethodPost, "/tables", `{"name": "other"}`).Code)
+	assert.Equal(t, http.StatusCreated, writer(http.MethodPost, "/tables", `{"name": "returns"}`).Code)
+
+	admin := newKey(`["admin"]`)
+	assert.Equal(t, http.StatusOK, admin(http.MethodGet, "/auth/keys", "").Code)
+
+	rec = do(http.MethodPost, "/auth/keys", `{"scopes": ["admin", "read"]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/auth/keys", `{"scopes": ["table:"]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodGet, "/auth/keys", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"scopes":["read","table:orders"]`)
+}
+
+func TestKeyScopesDeleteReferences(t *testing.T) {
+	srv, do := memoryServer(t)
+	create := func(path, body string) {
+		t.Helper()
+		rec := do(http.MethodPost, path, body)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	create("/tables", `{"name": "customers"}`)
+	create("/tables", `{"name": "invoices", "columns": [{"name": "customer", "dataType": "reference", "references": "customers", "onDelete": "restrict"}]}`)
+	create("/tables", `{"name": "orders", "columns": [{"name": "customer", "dataType": "reference", "references": "customers", "onDelete": "cascade"}]}`)
+	for _, id := range []string{"c1", "c2"} {
+		create("/tables/customers/rows", `{"id": "`+id+`", "values": {}}`)
+	}
+	create("/tables/invoices/rows", `{"id": "v1", "values": {"customer": "c1"}}`)
+	create("/tables/orders/rows", `{"id": "o1", "values": {"customer": "c2"}}`)
+
+	// References from tables outside the key's scopes are still honored
+	customers := scopedKey(t, srv, do, `["table:customers"]`)
+	for _, id := range []string{"c1", "c2"} {
+		rec := customers(http.MethodDelete, "/tables/customers/rows/"+id, "")
+		assert.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	}
+	rec := customers(http.MethodPost, "/transactions", `{"ops": [{"op": "delete", "table": "customers", "id": "c1"}]}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	assert.Equal(t, http.StatusConflict, do(http.MethodDelete, "/tables/customers/rows/c1", "").Code)
+
+	// A key scoped for the referencing table deletes as a full key would
+	both := scopedKey(t, srv, do, `["table:customers", "table:orders"]`)
+	rec = both(http.MethodDelete, "/tables/customers/rows/c2", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/tables/orders/rows/o1", "").Code, "the delete cascaded")
+}
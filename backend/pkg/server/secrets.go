@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/crypto"
+)
+
+const (
+	// tableTypeSecrets marks a table whose row values are always encrypted
+	tableTypeSecrets = "secrets"
+	// secretDataType is the data type of an encrypted secrets-table row
+	secretDataType = "secret"
+)
+
+// errSecretsDisabled is returned when no master key is configured
+var errSecretsDisabled = errors.New("secrets tables require NOTABLY_MASTER_KEY or NOTABLY_KMS_KEY_ID to be configured")
+
+// tableOptions is the JSON stored as the value of a table definition fact.
+// Standard tables store an empty value.
+type tableOptions struct {
+	Type string `json:"type,omitempty"`
+
+	// Temporary tables expire at ExpiresAt and, when Session is set, are
+	// bound to the API key with that ID
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Session   string     `json:"session,omitempty"`
+
+	// Source is the upstream of a virtual table
+	Source *virtualSource `json:"source,omitempty"`
+
+	// TTLColumn names the column holding each row's expiry time
+	TTLColumn string `json:"ttlColumn,omitempty"`
+
+	// Fork is the table and time a forked table inherits rows from
+	Fork *forkOrigin `json:"fork,omitempty"`
+
+	// Merge is the table's merge policy, field or, when empty, row
+	Merge string `json:"merge,omitempty"`
+}
+
+// tableOptionsOf decodes the options of a table definition fact
+func tableOptionsOf(def dynamo.Fact) tableOptions {
+	var opts tableOptions
+	if str, ok := def.Value.(string); ok && strings.HasPrefix(str, "{") {
+		_ = json.Unmarshal([]byte(str), &opts)
+	}
+	return opts
+}
+
+// encode renders options as a table definition value
+func (o tableOptions) encode() string {
+	if o == (tableOptions{}) {
+		return ""
+	}
+	data, _ := json.Marshal(o)
+	return string(data)
+}
+
+// newSealer builds the envelope sealer from the configured KMS key or master
+// key, or nil when neither is set
+func newSealer(cfg Config) (*crypto.Sealer, error) {
+	if cfg.KMSKeyID != "" {
+		awsCfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("loading AWS config: %w", err)
+		}
+		return crypto.NewSealer(crypto.NewKMSKeyWrapper(kms.NewFromConfig(awsCfg), cfg.KMSKeyID)), nil
+	}
+	if cfg.MasterKey == "" {
+		return nil, nil
+	}
+	key, err := crypto.ParseMasterKey(cfg.MasterKey)
+	if err != nil {
+		return nil, err
+	}
+	wrapper, err := crypto.NewLocalKeyWrapper(key)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.NewSealer(wrapper), nil
+}
+
+// secretAAD binds a secret's ciphertext to its table and row
+func secretAAD(namespace, rowID string) []byte {
+	return []byte(namespace + "#" + rowID)
+}
+
+// sealRowFact encrypts the values of a row fact in place, turning it into a secret fact
+func (s *Server) sealRowFact(ctx context.Context, fact *dynamo.Fact) error {
+	if s.sealer == nil {
+		return errSecretsDisabled
+	}
+	plaintext, err := json.Marshal(fact.Value)
+	if err != nil {
+		return fmt.Errorf("encode secret: %w", err)
+	}
+	env, err := s.sealer.Seal(ctx, plaintext, secretAAD(fact.Namespace, fact.FieldName))
+	if err != nil {
+		return err
+	}
+	encoded, err := env.Encode()
+	if err != nil {
+		return err
+	}
+	fact.DataType = secretDataType
+	fact.Value = encoded
+	return nil
+}
+
+// secretsRevealed reports whether the request's credentials may read secret
+// values; otherwise they are masked
+func secretsRevealed(ctx context.Context) bool {
+	key, ok := auth.APIKeyFromContext(ctx)
+	return ok && key.HasScope(auth.ScopeSecretsRead)
+}
+
+// revealSecret returns the decrypted values of a secret fact. Keys without the
+// secrets:read scope get masked=true and no values.
+func (s *Server) revealSecret(ctx context.Context, fact dynamo.Fact) (values map[string]interface{}, masked bool, err error) {
+	if !secretsRevealed(ctx) {
+		return nil, true, nil
+	}
+	if s.sealer == nil {
+		return nil, false, errSecretsDisabled
+	}
+
+	str, ok := fact.Value.(string)
+	if !ok {
+		return nil, false, fmt.Errorf("secret value has unexpected type %T", fact.Value)
+	}
+	env, err := crypto.DecodeEnvelope(str)
+	if err != nil {
+		return nil, false, err
+	}
+	plaintext, err := s.sealer.Open(ctx, env, secretAAD(fact.Namespace, fact.FieldName))
+	if err != nil {
+		return nil, false, err
+	}
+	if err := json.Unmarshal(plaintext, &values); err != nil {
+		return nil, false, fmt.Errorf("decode secret: %w", err)
+	}
+	return values, false, nil
+}
+
+// encryptedColumns is the crypto.ColumnPolicy of the server's stores. Row
+// namespaces are "<user>/<table>"; the table's latest definition lists the
+// encrypted columns.
+func (s *Server) encryptedColumns(ctx context.Context, namespace string) ([]string, error) {
+	userID, table, ok := parseTableNamespace(namespace)
+	if !ok {
+		return nil, nil
+	}
+	store, err := s.getStoreForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	defs, err := store.QueryByField(ctx, userID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if !tableLive(defs) {
+		return nil, nil
+	}
+	var columns []string
+	for _, col := range latestTableDef(defs).Columns {
+		if col.Encrypted {
+			columns = append(columns, col.Name)
+		}
+	}
+	return columns, nil
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableOptionsRoundTrip(t *testing.T) {
+	assert.Equal(t, "", tableOptions{}.encode())
+	assert.Equal(t, tableOptions{}, tableOptionsOf(dynamo.Fact{Value: ""}))
+
+	def := dynamo.Fact{Value: tableOptions{Type: tableTypeSecrets}.encode()}
+	assert.Equal(t, tableTypeSecrets, tableOptionsOf(def).Type)
+}
+
+func TestSecretRowsRequireScope(t *testing.T) {
+	masterKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 32))
+	srv, err := NewServer(Config{TableName: "Facts", MasterKey: masterKey, Logger: logging.Discard()})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, plainKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "plain", 0)
+	require.NoError(t, err)
+	_, scopedKey, err := srv.authenticator.GenerateScopedAPIKey(ctx, user.ID, "scoped", 0, []string{auth.ScopeSecretsRead})
+	require.NoError(t, err)
+
+	fact := dynamo.Fact{
+		ID:        "f1",
+		Timestamp: time.Now().UTC(),
+		Namespace: user.ID + "/vault",
+		FieldName: "github",
+		DataType:  "json",
+		Value:     map[string]interface{}{"token": "ghp_secret"},
+	}
+	require.NoError(t, srv.sealRowFact(ctx, &fact))
+	assert.Equal(t, secretDataType, fact.DataType)
+	assert.NotContains(t, fact.Value, "ghp_secret")
+
+	reveal := func(apiKey string) (map[string]interface{}, bool) {
+		var vals map[string]interface{}
+		var masked bool
+		h := srv.authenticator.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			vals, masked, err = srv.revealSecret(r.Context(), fact)
+			require.NoError(t, err)
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		return vals, masked
+	}
+
+	vals, masked := reveal(plainKey)
+	assert.True(t, masked)
+	assert.Nil(t, vals)
+
+	vals, masked = reveal(scopedKey)
+	assert.False(t, masked)
+	assert.Equal(t, "ghp_secret", vals["token"])
+}
+
+func TestSecretRowsDisabledWithoutMasterKey(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard()})
+	require.NoError(t, err)
+
+	fact := dynamo.Fact{Namespace: "u/vault", FieldName: "k", DataType: "json", Value: map[string]interface{}{"a": "b"}}
+	assert.ErrorIs(t, srv.sealRowFact(context.Background(), &fact), errSecretsDisabled)
+
+	_, err = NewServer(Config{TableName: "Facts", MasterKey: "short", Logger: logging.Discard()})
+	assert.Error(t, err)
+}
+
+func TestEncryptedColumnsRequireKey(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard()})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "key", 0)
+	require.NoError(t, err)
+
+	body := `{"name":"people","columns":[{"name":"ssn","dataType":"string","encrypted":true}]}`
+	req := httptest.NewRequest(http.MethodPost, "/tables", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
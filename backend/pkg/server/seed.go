@@ -0,0 +1,258 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/fake"
+)
+
+// Seed defaults
+const (
+	defaultSeedHistory  = 30 * 24 * time.Hour
+	defaultSeedRows     = 500
+	defaultSeedVersions = 3
+)
+
+// seedFile is the layout of a seed file: accounts, each with tables whose
+// columns are generated as by notably mock-serve, and how much history
+// their rows have
+type seedFile struct {
+	// History is how far back the tables were created; row versions are
+	// spread between then and now
+	History time.Duration `yaml:"history"`
+	// Seed seeds the generated data, so a file always yields the same rows
+	Seed  int64      `yaml:"seed"`
+	Users []seedUser `yaml:"users"`
+}
+
+type seedUser struct {
+	Username string      `yaml:"username"`
+	Email    string      `yaml:"email"`
+	Password string      `yaml:"password"`
+	Tables   []seedTable `yaml:"tables"`
+}
+
+type seedTable struct {
+	fake.Table `yaml:",inline"`
+	// Versions is the most versions a row has, at least one
+	Versions int `yaml:"versions"`
+	// Deleted is the fraction of rows deleted by now
+	Deleted float64 `yaml:"deleted"`
+}
+
+// LoadSeed fills the store with the sample accounts, tables and row
+// history of the seed file at path, for development against the memory
+// store or DynamoDB Local. Accounts that already exist are left alone, so
+// restarting with the same file does not add rows twice.
+func (s *Server) LoadSeed(ctx context.Context, path string) error {
+	if !s.config.InMemory && s.config.DynamoEndpoint == "" {
+		return errors.New("seed data is for development: use the memory driver or set DYNAMODB_ENDPOINT_URL to a local emulator")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file seedFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil {
+		return fmt.Errorf("parse seed %s: %w", path, err)
+	}
+	if file.History <= 0 {
+		file.History = defaultSeedHistory
+	}
+
+	now := time.Now().UTC()
+	rng := rand.New(rand.NewSource(file.Seed))
+	gen := fake.NewGenerator(file.Seed, now)
+	for _, u := range file.Users {
+		if _, err := s.userStore.GetUserByUsername(ctx, u.Username); err == nil {
+			s.logger.InfoContext(ctx, "seed account exists, skipping it", "username", u.Username)
+			continue
+		}
+		if u.Email == "" {
+			u.Email = u.Username + "@example.com"
+		}
+		if u.Password == "" {
+			u.Password = u.Username
+		}
+		user, err := s.authenticator.RegisterUser(ctx, u.Username, u.Email, u.Password)
+		if err != nil {
+			return fmt.Errorf("seed account %s: %w", u.Username, err)
+		}
+		user.EmailVerified = true
+		if err := s.userStore.UpdateUser(ctx, user); err != nil {
+			return fmt.Errorf("seed account %s: %w", u.Username, err)
+		}
+		_, apiKey, err := s.authenticator.GenerateAPIKey(ctx, user.ID, "seed", 0)
+		if err != nil {
+			return fmt.Errorf("seed account %s: %w", u.Username, err)
+		}
+
+		rows := 0
+		// Reference columns pick from the live rows of tables seeded before them
+		rowIDs := make(map[string][]interface{}, len(u.Tables))
+		for _, t := range u.Tables {
+			if t.Rows == 0 {
+				t.Rows = defaultSeedRows
+			}
+			ids, err := s.seedTable(ctx, user, t, rowIDs, now.Add(-file.History), now, rng, gen)
+			if err != nil {
+				return fmt.Errorf("seed table %s of %s: %w", t.Name, u.Username, err)
+			}
+			rowIDs[t.Name] = ids
+			rows += t.Rows
+		}
+		s.logger.InfoContext(ctx, "seeded account", "username", u.Username, "password", u.Password, "apiKey", apiKey, "tables", len(u.Tables), "rows", rows)
+	}
+	return nil
+}
+
+// seedTable creates a table as of start through the API handler, then
+// writes the history of its rows between start and now. It returns the IDs
+// of the rows that were not deleted.
+func (s *Server) seedTable(ctx context.Context, user *auth.User, t seedTable, rowIDs map[string][]interface{}, start, now time.Time, rng *rand.Rand, gen *fake.Generator) ([]interface{}, error) {
+	if t.Versions <= 0 {
+		t.Versions = defaultSeedVersions
+	}
+	ctx = db.WithActor(ctx, db.Actor{UserID: user.ID})
+	columns := make([]fake.Column, len(t.Columns))
+	for i, c := range t.Columns {
+		if c.DataType == "reference" && len(c.Values) == 0 {
+			if len(rowIDs[c.References]) == 0 {
+				return nil, fmt.Errorf("column %s references %s, which must be listed earlier and have rows", c.Name, c.References)
+			}
+			c.Values = rowIDs[c.References]
+		}
+		columns[i] = c
+	}
+
+	body, err := json.Marshal(t.Table)
+	if err != nil {
+		return nil, err
+	}
+	req := httptest.NewRequest(http.MethodPost, apiVersion+"/tables", bytes.NewReader(body)).WithContext(auth.ContextWithUser(ctx, user))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	s.handleCreateTable(rec, req)
+	if rec.Code >= http.StatusBadRequest {
+		return nil, fmt.Errorf("%d %s", rec.Code, bytes.TrimSpace(rec.Body.Bytes()))
+	}
+
+	// The table is moved back to start, so it exists in every snapshot of
+	// its rows
+	store, err := s.getStoreForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	defs, err := store.QueryByField(ctx, user.ID, t.Name, time.Time{}, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	for i, def := range defs {
+		if def.DataType != tableDataType {
+			continue
+		}
+		if err := store.PurgeFact(ctx, def); err != nil {
+			return nil, err
+		}
+		def.Timestamp = start
+		if err := store.PutFact(ctx, def); err != nil {
+			return nil, err
+		}
+		defs[i] = def
+	}
+	rowStore, err := s.getRowStore(ctx, store, user, t.Name)
+	if err != nil {
+		return nil, err
+	}
+	validator := s.newRowValidatorFor(ctx, store, user, defs)
+	secrets := validator.options().Type == tableTypeSecrets
+
+	var live []interface{}
+	span := int64(now.Sub(start))
+	for i := 1; i <= t.Rows; i++ {
+		id := fmt.Sprintf("%s-%d", t.Name, i)
+		n := 1 + rng.Intn(t.Versions)
+		deleted := rng.Float64() < t.Deleted
+		times := make([]time.Time, n+1)
+		for j := range times {
+			times[j] = start.Add(time.Duration(1 + rng.Int63n(span-1)))
+		}
+		sort.Slice(times, func(a, b int) bool { return times[a].Before(times[b]) })
+
+		var values map[string]interface{}
+		for j := 0; j < n; j++ {
+			if values == nil {
+				values = gen.Row(columns)
+				validator.applyDefaults(values)
+			} else if len(columns) > 0 {
+				next := make(map[string]interface{}, len(values))
+				for k, v := range values {
+					next[k] = v
+				}
+				c := columns[rng.Intn(len(columns))]
+				next[c.Name] = gen.Value(c)
+				values = next
+			}
+			if err := validator.check(values, j == 0); err != nil {
+				return nil, fmt.Errorf("row %s: %w", id, err)
+			}
+			fact := dynamo.Fact{
+				ID:        newID(),
+				Timestamp: times[j],
+				Namespace: tableNamespace(user.ID, t.Name),
+				FieldName: id,
+				DataType:  "json",
+				Value:     values,
+				CreatedAt: &times[0],
+			}
+			if secrets {
+				if err := s.sealRowFact(ctx, &fact); err != nil {
+					return nil, err
+				}
+			}
+			if err := s.indexGeoPoints(ctx, store, user.ID, t.Name, validator.columns, id, values, fact.Timestamp); err != nil {
+				return nil, err
+			}
+			if err := s.indexExpiry(ctx, store, user.ID, t.Name, validator.def, id, values, fact.Timestamp); err != nil {
+				return nil, err
+			}
+			if err := s.indexReferences(ctx, store, user.ID, t.Name, validator.columns, id, values, fact.Timestamp); err != nil {
+				return nil, err
+			}
+			if err := rowStore.PutFact(ctx, fact); err != nil {
+				return nil, err
+			}
+		}
+		if !deleted {
+			live = append(live, id)
+			continue
+		}
+		if err := rowStore.PutFact(ctx, dynamo.Fact{
+			ID:        newID(),
+			Timestamp: times[n],
+			Namespace: tableNamespace(user.ID, t.Name),
+			FieldName: id,
+			DataType:  dynamo.DataTypeDeleted,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return live, nil
+}
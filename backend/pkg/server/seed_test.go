@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/pkg/logging"
+)
+
+func TestLoadSeed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seed.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+history: 240h
+seed: 7
+users:
+  - username: dev
+    tables:
+      - name: customers
+        rows: 40
+        versions: 4
+        deleted: 0.25
+        columns:
+          - name: email
+            dataType: string
+          - name: tier
+            dataType: string
+            values: [free, pro]
+      - name: orders
+        rows: 60
+        columns:
+          - name: customer
+            dataType: reference
+            references: customers
+          - name: total
+            dataType: number
+`), 0o600))
+
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true})
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, srv.LoadSeed(ctx, path))
+	require.NoError(t, srv.LoadSeed(ctx, path), "seeded accounts are skipped")
+
+	user, err := srv.authenticator.LoginUser(ctx, "dev", "dev", "")
+	require.NoError(t, err, "passwords default to the username")
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "test", 0)
+	require.NoError(t, err)
+	get := func(path string, query url.Values, v interface{}) {
+		req := httptest.NewRequest(http.MethodGet, path+"?"+query.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), v))
+	}
+
+	var rows struct {
+		Rows []RowData `json:"rows"`
+	}
+	get("/v1/tables/customers/rows", nil, &rows)
+	live := len(rows.Rows)
+	assert.Less(t, live, 40, "some customers are deleted")
+	assert.Greater(t, live, 20)
+	get("/v1/tables/orders/rows", nil, &rows)
+	assert.Len(t, rows.Rows, 60, "the second seed did not add rows")
+
+	// Versions are spread over the history, so the past shows fewer rows
+	get("/v1/tables/customers/rows", url.Values{"at": {time.Now().Add(-120 * time.Hour).Format(time.RFC3339)}}, &rows)
+	assert.Greater(t, len(rows.Rows), 0)
+	assert.Less(t, len(rows.Rows), 40)
+
+	var history struct {
+		Events []RowEvent `json:"events"`
+	}
+	get("/v1/tables/customers/history", url.Values{
+		"start": {time.Now().Add(-241 * time.Hour).Format(time.RFC3339)},
+		"end":   {time.Now().Format(time.RFC3339)},
+	}, &history)
+	assert.Greater(t, len(history.Events), 60, "rows have several versions")
+	for _, event := range history.Events {
+		require.True(t, event.Timestamp.After(time.Now().Add(-240*time.Hour)))
+	}
+
+	// The sample data of the repository loads
+	srv, err = NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true})
+	require.NoError(t, err)
+	require.NoError(t, srv.LoadSeed(ctx, "../../cmd/server/seed.yaml"))
+
+	// Seeding is refused against real AWS
+	prod := &Server{config: Config{TableName: "Facts"}}
+	assert.ErrorContains(t, prod.LoadSeed(ctx, path), "for development")
+}
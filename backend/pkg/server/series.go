@@ -0,0 +1,144 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/query"
+)
+
+// minSeriesInterval is the shortest bucket a series may use
+const minSeriesInterval = time.Second
+
+// SeriesResponse is the bucketed history of one numeric field of a row
+type SeriesResponse struct {
+	Row      string         `json:"row"`
+	Field    string         `json:"field"`
+	Interval string         `json:"interval"`
+	Buckets  []query.Bucket `json:"buckets"`
+}
+
+// handleRowSeries rolls a numeric field's history up into min, max, avg and
+// last values per interval
+func (s *Server) handleRowSeries(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	table := r.PathValue("table")
+	rowID := r.PathValue("id")
+
+	q := r.URL.Query()
+	var fields fieldErrors
+	field := q.Get("field")
+	if field == "" {
+		fields = append(fields, FieldError{Field: "field", Message: "is required"})
+	}
+	interval, err := time.ParseDuration(q.Get("interval"))
+	if err != nil || interval < minSeriesInterval {
+		fields = append(fields, FieldError{Field: "interval", Message: fmt.Sprintf("must be a duration of at least %s, such as 1h", minSeriesInterval)})
+	}
+	start, end := time.Time{}, time.Now().UTC()
+	for name, t := range map[string]*time.Time{"start": &start, "end": &end} {
+		if v := q.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				fields = append(fields, FieldError{Field: name, Message: "must be an RFC3339 time"})
+				continue
+			}
+			*t = parsed.UTC()
+		}
+	}
+	if len(fields) == 0 && start.After(end) {
+		fields = append(fields, FieldError{Field: "start", Message: "must be before end"})
+	}
+	if len(fields) > 0 {
+		writeValidationError(w, "Invalid series query", fields)
+		return
+	}
+
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+	def := latestTableDef(facts)
+	switch {
+	case tableOptionsOf(def).Type == tableTypeSecrets:
+		writeError(w, http.StatusBadRequest, "Rows of secrets tables are encrypted and have no series")
+		return
+	case proxiedTable(facts):
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Table '%s' is proxied to its upstream and has no history; create it with 'materialize' to query past states", table))
+		return
+	}
+	var dataType string
+	if len(def.Columns) > 0 {
+		for _, col := range def.Columns {
+			if col.Name == field {
+				dataType = col.DataType
+			}
+		}
+		if dataType != "number" && dataType != decimalType {
+			writeValidationError(w, "Invalid series query", []FieldError{{Field: "field", Message: fmt.Sprintf("'%s' is not a number or decimal column", field)}})
+			return
+		}
+	}
+
+	rowStore, err := s.getRowStore(r.Context(), store, user, table)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize table storage")
+		return
+	}
+	namespace := tableNamespace(user.ID, table)
+	history, err := rowStore.QueryByField(r.Context(), namespace, rowID, start, end)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get row history")
+		return
+	}
+	history, err = s.withColdHistory(r.Context(), namespace, start, end, history)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to read cold storage: %v", err))
+		return
+	}
+
+	// Facts whose value is not a number, including deletes, are skipped.
+	// Decimal columns hold their numbers as strings.
+	var points []query.Point
+	for _, f := range history {
+		if f.FieldName != rowID || f.DataType != "json" {
+			continue
+		}
+		values, _ := f.Value.(map[string]interface{})
+		switch v := values[field].(type) {
+		case float64:
+			points = append(points, query.Point{Time: f.Timestamp, Value: v})
+		case string:
+			if dataType != decimalType {
+				continue
+			}
+			if n, err := strconv.ParseFloat(v, 64); err == nil {
+				points = append(points, query.Point{Time: f.Timestamp, Value: n})
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, SeriesResponse{
+		Row:      rowID,
+		Field:    field,
+		Interval: interval.String(),
+		Buckets:  query.Rollup(points, interval),
+	})
+}
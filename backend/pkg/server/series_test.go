@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRowSeries(t *testing.T) {
+	_, do := memoryServer(t)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "counters", "columns": [
+		{"name": "count", "dataType": "number"},
+		{"name": "label", "dataType": "string"}
+	]}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/counters/rows", `{"id": "c1", "values": {"count": 1}}`).Code)
+	for _, n := range []string{"5", "3"} {
+		rec := do(http.MethodPost, "/transactions", `{"ops": [{"op": "update", "table": "counters", "id": "c1", "values": {"count": `+n+`}}]}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	rec := do(http.MethodGet, "/tables/counters/rows/c1/series?field=count&interval=1h", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var resp SeriesResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "1h0m0s", resp.Interval)
+	require.NotEmpty(t, resp.Buckets)
+	last := resp.Buckets[len(resp.Buckets)-1]
+	assert.Equal(t, float64(3), last.Last)
+	total := 0
+	for _, b := range resp.Buckets {
+		total += b.Count
+		assert.LessOrEqual(t, b.Min, b.Max)
+	}
+	assert.Equal(t, 3, total)
+
+	for _, q := range []string{"field=count", "field=count&interval=1ms", "interval=1h", "field=label&interval=1h", "field=count&interval=1h&start=2030-01-01T00:00:00Z&end=2020-01-01T00:00:00Z"} {
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/tables/counters/rows/c1/series?"+q, "").Code, q)
+	}
+
+	rec = do(http.MethodGet, "/tables/counters/rows/nope/series?field=count&interval=1h", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"row": "nope", "field": "count", "interval": "1h0m0s", "buckets": []}`, rec.Body.String())
+}
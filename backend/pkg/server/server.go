@@ -3,16 +3,38 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"math/rand"
+	"log/slog"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/elibdev/notably/db"
 	"github.com/elibdev/notably/dynamo"
 	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/backoff"
+	"github.com/elibdev/notably/pkg/blob"
+	"github.com/elibdev/notably/pkg/coldstore"
+	"github.com/elibdev/notably/pkg/crypto"
+	"github.com/elibdev/notably/pkg/lease"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/elibdev/notably/pkg/metrics"
+	"github.com/elibdev/notably/pkg/migrate"
+	"github.com/elibdev/notably/pkg/naming"
+	"github.com/elibdev/notably/pkg/plugin"
+	"github.com/elibdev/notably/pkg/presence"
+	"github.com/elibdev/notably/pkg/publish"
+	"github.com/elibdev/notably/pkg/replay"
+	"github.com/elibdev/notably/pkg/script"
+	"github.com/elibdev/notably/pkg/tracing"
+	"github.com/elibdev/notably/pkg/ulid"
+	"github.com/elibdev/notably/pkg/web"
+	"github.com/elibdev/notably/pkg/webhook"
+	"github.com/elibdev/notably/schema"
 	"github.com/rs/cors"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -24,30 +46,445 @@ type Config struct {
 	TableName      string
 	Addr           string
 	DynamoEndpoint string
+
+	// LegacyTableName, while facts are moved into TableName with
+	// "notably migrate-keys", names the table in the old user-partitioned
+	// layout that reads also consult
+	LegacyTableName string
+
+	// MigrationTableName, while an online migration copies facts into it,
+	// names the table that writes to TableName also go to. MigrationPhase,
+	// "dual-write" (the default) or "dual-read", says which of the two
+	// tables reads come from; see migrate.DualStore.
+	MigrationTableName string
+	MigrationPhase     string
+
+	// Archive storage: an S3 bucket takes precedence over a local directory.
+	// Archival endpoints are disabled when neither is set.
+	ArchiveBucket string
+	ArchiveDir    string
+	S3Endpoint    string
+
+	// AttachmentBucket is the S3 bucket holding the files of attachment
+	// columns and row attachments. Column uploads and downloads use
+	// pre-signed URLs valid for AttachmentURLExpiry (default 15 minutes).
+	// AttachmentDir keeps row attachments on local disk instead.
+	// Attachments are unavailable when neither is set.
+	AttachmentBucket    string
+	AttachmentURLExpiry time.Duration
+	AttachmentDir       string
+
+	// MaxAttachmentBytes caps each row attachment (default 10 MiB), and
+	// AttachmentTypes lists the media types they may have, such as
+	// "image/*" or "application/pdf"; empty allows any type.
+	// AttachmentQuotaBytes caps the row attachment storage of each
+	// account; zero means no quota.
+	MaxAttachmentBytes   int64
+	AttachmentTypes      []string
+	AttachmentQuotaBytes int64
+
+	// Logging: Logger takes precedence; otherwise one is built from LogFormat
+	// ("text" or "json") and LogLevel, writing to stderr.
+	Logger    *slog.Logger
+	LogFormat string
+	LogLevel  string
+
+	// StorageMode is the default storage mode for new accounts: "shared"
+	// keeps all tables in TableName, "isolated" gives each table its own
+	// DynamoDB table. Accounts may choose a mode at registration.
+	StorageMode string
+
+	// MasterKey is the base64-encoded 32-byte key that wraps the data keys
+	// of secrets tables. Secrets tables are unavailable when it is empty.
+	MasterKey string
+
+	// KMSKeyID, if set, is the AWS KMS key (ID, ARN or alias) that wraps
+	// data keys instead of MasterKey
+	KMSKeyID string
+
+	// Tracing exports request traces to an OpenTelemetry collector when an
+	// endpoint is set; SpanExporter, if set, is used instead
+	Tracing      TracingConfig
+	SpanExporter tracing.Exporter
+
+	// RateLimit sets per-API-key and per-user request budgets
+	RateLimit RateLimitConfig
+
+	// MaxBodyBytes caps the size of request bodies (default 1 MiB)
+	MaxBodyBytes int64
+
+	// ScriptLimits bounds each automation script run; zero fields use
+	// script.DefaultLimits
+	ScriptLimits script.Limits
+
+	// Plugins names the compiled-in plugins to enable; nil enables all
+	// registered plugins
+	Plugins []string
+
+	// StoreRetry controls how DynamoDB calls rejected for throttling are
+	// retried before the error reaches the client; zero fields use
+	// backoff.DefaultPolicy and MaxAttempts 1 disables retries
+	StoreRetry backoff.Policy
+
+	// SlowQueryThreshold is how long a storage call may take before it is
+	// logged with its parameters; zero disables slow call logging
+	SlowQueryThreshold time.Duration
+
+	// WriteCapacity is the write capacity units per second writes to a
+	// table are paced to; writes over it are answered 429. Zero paces
+	// writes to the capacity of provisioned tables without autoscaling,
+	// and other DynamoDB writes only once DynamoDB throttles them.
+	WriteCapacity int
+
+	// Region is the AWS region the server runs in and records on the facts
+	// it writes; empty uses the region of the AWS configuration
+	Region string
+
+	// ReplicaRegions lists the other regions of a DynamoDB Global Table.
+	// With replicas the server detects concurrent writes from different
+	// regions and reports replication lag in /readyz.
+	ReplicaRegions []string
+
+	// MaxReplicationLag is how far behind a replica may fall before
+	// /readyz reports the server unready
+	MaxReplicationLag time.Duration
+
+	// Table sets the billing mode, capacity, backups, encryption and tags
+	// of the DynamoDB tables the server creates
+	Table dynamo.TableOptions
+
+	// VirtualTableHosts lists the upstream hosts virtual tables may read
+	// from, or "*" for any host. Virtual tables are unavailable when empty.
+	VirtualTableHosts []string
+
+	// WebhookHosts lists the hosts webhooks may be delivered to, or "*" for
+	// any host. Webhooks are unavailable when empty.
+	WebhookHosts []string
+
+	// Publish sends row change events to Kafka or SNS; Publisher, if set,
+	// is used instead of the broker settings
+	Publish   PublishConfig
+	Publisher publish.Publisher
+
+	// Realtime keeps WebSocket presence and row locks in Redis when an
+	// address is set; Presence, if set, is used instead
+	Realtime RealtimeConfig
+	Presence presence.Store
+
+	// AccessReviewInterval is how often an access review report is stored
+	// for every account; zero disables periodic reviews
+	AccessReviewInterval time.Duration
+
+	// InMemory keeps all facts in process memory instead of DynamoDB, for
+	// demos and mock servers; everything is lost when the server stops
+	InMemory bool
+
+	// Record writes a replay bundle for a sample of requests, for
+	// reproducing bugs with notably debug replay
+	Record RecordConfig
+
+	// CORSOrigins lists the browser origins allowed to call the API, or "*"
+	// for any origin (default the frontend dev server at
+	// http://localhost:3000); CORSDebug logs every CORS decision
+	CORSOrigins []string
+	CORSDebug   bool
+
+	// APIKeyExpiration is the lifetime of API keys created without one
+	// (default auth.DefaultAPIKeyExpiration)
+	APIKeyExpiration time.Duration
+
+	// KeyRotationGrace is how long a rotated API key keeps working when the
+	// rotation request gives no grace period (default auth.DefaultRotationGrace)
+	KeyRotationGrace time.Duration
+
+	// APIKeySecret is the HMAC key API keys are stored under. Changing it
+	// invalidates every key that is not a legacy bcrypt hash. When empty a
+	// secret is generated and kept beside UsersFile; see apiKeySecret.
+	APIKeySecret string
+
+	// BcryptCost is the cost of password hashes (default bcrypt.DefaultCost)
+	BcryptCost int
+
+	// UsersFile keeps users and API keys in a JSON file, so they survive
+	// restarts; without it they are kept in memory
+	UsersFile string
+
+	// Lockout limits password guessing on login
+	Lockout auth.LockoutPolicy
+
+	// Mail sends password reset and verification emails; Mailer, if set,
+	// is used instead of the mail settings
+	Mail   MailConfig
+	Mailer auth.Mailer
+
+	// ShareLinkSecret signs share links. When empty a random secret is
+	// used and links stop working when the server restarts.
+	ShareLinkSecret string
+
+	// CursorSecret signs sync cursors, as db.Config.CursorSecret signs
+	// pagination tokens. When empty a random secret is used and cursors
+	// stop working when the server restarts.
+	CursorSecret string
+
+	// TLS serves HTTPS instead of plain HTTP when a certificate source is set
+	TLS TLSConfig
+
+	// Frontend serves the web app at / from the binary or a directory
+	Frontend FrontendConfig
+
+	// UI serves a server-rendered interface for browsing tables, their
+	// rows and history at /ui, for deployments without the web app
+	UI bool
+
+	// Naming is the policy for the names of new tables, columns, views and
+	// automations; zero fields use naming.DefaultPolicy
+	Naming naming.Policy
+
+	// RequestTimeout bounds each API request (default 30 seconds), and
+	// RouteTimeouts overrides it for route patterns such as
+	// "POST /tables/{table}/archive". Store calls of a request past its
+	// timeout fail, and the request is answered 504. A negative duration
+	// leaves requests unbounded.
+	RequestTimeout time.Duration
+	RouteTimeouts  map[string]time.Duration
+
+	// MaxClockAhead and MaxClockBehind bound the timestamps clients may
+	// give row writes: at most MaxClockAhead past the server's clock
+	// (default 1 minute) and MaxClockBehind before it (default 30 days). A
+	// negative MaxClockBehind accepts any time in the past.
+	MaxClockAhead  time.Duration
+	MaxClockBehind time.Duration
+
+	// UnversionedSunset, if set, is sent in the Sunset header of responses
+	// to unversioned API paths, announcing when they stop being served
+	UnversionedSunset time.Time
+
+	// envErrors are the environment overrides DefaultConfig could not parse
+	envErrors []error
 }
 
-// DefaultConfig returns a default configuration
+// DefaultConfig returns a default configuration. Malformed environment
+// overrides keep their defaults and are reported by Validate.
 func DefaultConfig() Config {
-	return Config{
-		TableName:      os.Getenv("DYNAMODB_TABLE_NAME"),
-		Addr:           ":8080",
-		DynamoEndpoint: os.Getenv("DYNAMODB_ENDPOINT_URL"),
+	takeEnvErrors()
+	config := Config{
+		TableName:            os.Getenv("DYNAMODB_TABLE_NAME"),
+		LegacyTableName:      os.Getenv("DYNAMODB_LEGACY_TABLE_NAME"),
+		MigrationTableName:   os.Getenv("DYNAMODB_MIGRATION_TABLE_NAME"),
+		MigrationPhase:       os.Getenv("NOTABLY_MIGRATION_PHASE"),
+		Addr:                 ":8080",
+		DynamoEndpoint:       os.Getenv("DYNAMODB_ENDPOINT_URL"),
+		ArchiveBucket:        os.Getenv("NOTABLY_ARCHIVE_BUCKET"),
+		ArchiveDir:           os.Getenv("NOTABLY_ARCHIVE_DIR"),
+		S3Endpoint:           os.Getenv("S3_ENDPOINT_URL"),
+		AttachmentBucket:     os.Getenv("NOTABLY_ATTACHMENT_BUCKET"),
+		AttachmentURLExpiry:  envDuration("NOTABLY_ATTACHMENT_URL_EXPIRY", 0),
+		AttachmentDir:        os.Getenv("NOTABLY_ATTACHMENT_DIR"),
+		MaxAttachmentBytes:   int64(envInt("NOTABLY_MAX_ATTACHMENT_BYTES", defaultMaxAttachmentBytes)),
+		AttachmentTypes:      attachmentTypesFromEnv(),
+		AttachmentQuotaBytes: int64(envInt("NOTABLY_ATTACHMENT_QUOTA_BYTES", 0)),
+		LogFormat:            os.Getenv("NOTABLY_LOG_FORMAT"),
+		LogLevel:             os.Getenv("NOTABLY_LOG_LEVEL"),
+		StorageMode:          os.Getenv("NOTABLY_STORAGE_MODE"),
+		MasterKey:            os.Getenv("NOTABLY_MASTER_KEY"),
+		KMSKeyID:             os.Getenv("NOTABLY_KMS_KEY_ID"),
+		Tracing:              tracingConfigFromEnv(),
+		RateLimit:            rateLimitConfigFromEnv(),
+		MaxBodyBytes:         int64(envInt("NOTABLY_MAX_BODY_BYTES", defaultMaxBodyBytes)),
+		Plugins:              pluginsFromEnv(),
+		VirtualTableHosts:    virtualHostsFromEnv(),
+		WebhookHosts:         webhookHostsFromEnv(),
+		Publish:              publishConfigFromEnv(),
+		Realtime:             realtimeConfigFromEnv(),
+		AccessReviewInterval: envDuration("NOTABLY_ACCESS_REVIEW_INTERVAL", 0),
+		Record:               recordConfigFromEnv(),
+		UnversionedSunset:    sunsetFromEnv(),
+		TLS:                  tlsConfigFromEnv(),
+		Frontend:             frontendConfigFromEnv(),
+		UI:                   os.Getenv("NOTABLY_UI") == "true",
+		ShareLinkSecret:      shareLinkSecretFromEnv(),
+		CursorSecret:         string(db.CursorSecretFromEnv()),
+		Mail:                 mailConfigFromEnv(),
+		Lockout:              lockoutPolicyFromEnv(),
+		Naming:               namingPolicyFromEnv(),
+		RequestTimeout:       envDuration("NOTABLY_REQUEST_TIMEOUT", 0),
+		RouteTimeouts:        routeTimeoutsFromEnv(),
+		MaxClockAhead:        envDuration("NOTABLY_MAX_CLOCK_AHEAD", 0),
+		MaxClockBehind:       envDuration("NOTABLY_MAX_CLOCK_BEHIND", 0),
+		CORSOrigins:          corsOriginsFromEnv(),
+		CORSDebug:            os.Getenv("NOTABLY_CORS_DEBUG") == "true",
+		APIKeyExpiration:     envDuration("NOTABLY_API_KEY_EXPIRATION", 0),
+		APIKeySecret:         os.Getenv("NOTABLY_API_KEY_SECRET"),
+		KeyRotationGrace:     envDuration("NOTABLY_KEY_ROTATION_GRACE", 0),
+		BcryptCost:           envInt("NOTABLY_BCRYPT_COST", 0),
+		UsersFile:            os.Getenv("NOTABLY_USERS_FILE"),
+		StoreRetry: backoff.Policy{
+			MaxAttempts: envInt("NOTABLY_DYNAMO_MAX_ATTEMPTS", 0),
+			MaxElapsed:  envDuration("NOTABLY_DYNAMO_MAX_ELAPSED", 0),
+		},
+		SlowQueryThreshold: envDuration("NOTABLY_SLOW_QUERY_THRESHOLD", time.Second),
+		WriteCapacity:      envInt("NOTABLY_WRITE_CAPACITY", 0),
+		Table:              tableOptionsFromEnv(),
+		Region:             os.Getenv("NOTABLY_REGION"),
+		ReplicaRegions:     replicaRegionsFromEnv(),
+		MaxReplicationLag:  envDuration("NOTABLY_MAX_REPLICATION_LAG", defaultMaxReplicationLag),
+	}
+	config.envErrors = takeEnvErrors()
+	return config
+}
+
+var (
+	envErrorsMu    sync.Mutex
+	pendingEnvErrs []error
+)
+
+// badEnv records an environment variable whose value could not be parsed
+func badEnv(name, value, kind string) {
+	envErrorsMu.Lock()
+	defer envErrorsMu.Unlock()
+	pendingEnvErrs = append(pendingEnvErrs, fmt.Errorf("%s must be %s, got %q", name, kind, value))
+}
+
+// takeEnvErrors returns and clears the errors recorded by badEnv
+func takeEnvErrors() []error {
+	envErrorsMu.Lock()
+	defer envErrorsMu.Unlock()
+	errs := pendingEnvErrs
+	pendingEnvErrs = nil
+	return errs
+}
+
+// envInt reads an integer environment variable, returning def when it is
+// unset or invalid
+func envInt(name string, def int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		badEnv(name, raw, "an integer")
+		return def
+	}
+	return v
+}
+
+// envFloat reads a number environment variable, returning def when it is
+// unset or invalid
+func envFloat(name string, def float64) float64 {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		badEnv(name, raw, "a number")
+		return def
 	}
+	return v
+}
+
+// envDuration reads a duration environment variable such as "5s", returning
+// def when it is unset or invalid
+func envDuration(name string, def time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil {
+		badEnv(name, raw, `a duration such as "30s"`)
+		return def
+	}
+	return v
 }
 
 // Server represents the API server
 type Server struct {
 	config        Config
 	mux           *http.ServeMux
+	routes        map[string]bool // patterns registered with route
 	authenticator *auth.Authenticator
 	userStore     auth.UserStore
+	archive       blob.Store
+	attachments   blob.Store
+	cold          *coldstore.Tier
+	logger        *slog.Logger
+	metrics       *metrics.Metrics
+	sealer        *crypto.Sealer
+	shareKey      []byte
+	syncCursors   db.CursorSigner
+	mailer        auth.Mailer
+	limiter       *rateLimiter
+	plugins       *plugin.Set
+	virtual       *virtualTables
+	frontend      *web.Handler // nil unless the frontend is served
+
+	// webhookQueue buffers row events for the webhook delivery workers
+	webhookQueue  chan plugin.RowEvent
+	webhookSender *webhook.Sender
+
+	// publishQueues buffer row events for the publish workers, one queue
+	// per worker; publisher is nil when publishing is off
+	publisher     publish.Publisher
+	publishQueues []chan plugin.RowEvent
+	publishWG     sync.WaitGroup
+
+	// jobQueue holds the jobs waiting for a job worker; jobCancels cancels
+	// the queued and running jobs of this server by ID
+	jobQueue   chan *queuedJob
+	jobMu      sync.Mutex
+	jobCancels map[string]context.CancelFunc
+
+	// realtime tracks the WebSocket sessions of this server and the
+	// tables they joined
+	realtime *realtimeHub
+
+	// tracer starts the traces of sampled requests; nil when tracing is
+	// disabled
+	tracer *tracing.Tracer
+
+	// leases keeps periodic background tasks to one replica at a time
+	leases *lease.Locker
+
+	// background is cancelled by Stop to end background jobs
+	background context.Context
+	cancel     context.CancelFunc
+
+	// memStores holds the per-user stores of an InMemory server, keyed by
+	// "tableName#userID"
+	memMu     sync.Mutex
+	memStores map[string]db.Store
+
+	// capacity holds the write capacity limiter of each table, shared by
+	// the stores of all its users
+	capacityMu sync.Mutex
+	capacity   map[string]*db.CapacityLimiter
+
+	// replication is the last replication lag seen of each replica region
+	replicationMu sync.Mutex
+	replication   map[string]replicaStatus
+
+	// attachmentMu serializes updates of row attachment lists and of
+	// attachment storage usage
+	attachmentMu sync.Mutex
 }
 
 // NewServer creates a new server with the given configuration
 func NewServer(config Config) (*Server, error) {
-	// Initialize user store
-	userStore := auth.NewInMemoryUserStore()
-	authenticator := auth.NewAuthenticator(userStore)
+	authenticator, userStore, err := NewAuthenticator(config)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = logging.New(os.Stderr, config.LogFormat, config.LogLevel)
+	}
+
+	if _, err := db.NewTableResolver(config.StorageMode, config.TableName); err != nil {
+		return nil, err
+	}
 
 	// Create the server
 	server := &Server{
@@ -55,23 +492,120 @@ func NewServer(config Config) (*Server, error) {
 		mux:           http.NewServeMux(),
 		authenticator: authenticator,
 		userStore:     userStore,
+		logger:        logger,
+		metrics:       metrics.New(),
+		limiter:       newRateLimiter(),
+		virtual:       newVirtualTables(),
+		webhookQueue:  make(chan plugin.RowEvent, webhookQueueSize),
+		webhookSender: &webhook.Sender{},
+		jobQueue:      make(chan *queuedJob, jobQueueSize),
+		jobCancels:    make(map[string]context.CancelFunc),
+		memStores:     make(map[string]db.Store),
+		capacity:      make(map[string]*db.CapacityLimiter),
+		replication:   make(map[string]replicaStatus),
+		routes:        make(map[string]bool),
+		realtime: &realtimeHub{
+			store:    newPresenceStore(config),
+			sessions: make(map[string]map[*realtimeSession]bool),
+			last:     make(map[string]string),
+		},
+	}
+	server.tracer = server.newTracer(config)
+	server.background, server.cancel = context.WithCancel(context.Background())
+	authenticator.OnFailure(server.metrics.ObserveAuthFailure)
+
+	leases, err := server.newLocker(server.background)
+	if err != nil {
+		return nil, err
 	}
+	server.leases = leases
 
-	// Register routes
-	server.registerRoutes()
+	if server.frontend, err = newFrontend(config.Frontend); err != nil {
+		return nil, err
+	}
 
-	return server, nil
-}
+	sealer, err := newSealer(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	server.sealer = sealer
 
-// registerRoutes sets up all the API routes
-// Helper function to check if a name contains only allowed characters
-func isValidName(name string) bool {
-	for _, r := range name {
-		if !(('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') || r == '-' || r == '_') {
-			return false
+	publisher, err := newPublisher(config)
+	if err != nil {
+		return nil, err
+	}
+	server.publisher = publisher
+	if publisher != nil {
+		for i := 0; i < publishWorkers; i++ {
+			server.publishQueues = append(server.publishQueues, make(chan plugin.RowEvent, publishQueueSize))
 		}
 	}
-	return true
+
+	mailer, err := newMailer(config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Mail.VerifyEmail && mailer == nil {
+		return nil, errors.New("email verification needs a mailer: set an SMTP address or SES")
+	}
+	server.mailer = mailer
+	authenticator.SetRequireVerifiedEmail(config.Mail.VerifyEmail)
+
+	switch {
+	case config.APIKeySecret != "":
+	case config.UsersFile != "":
+		logger.Warn("NOTABLY_API_KEY_SECRET is not set; API keys are digested with a generated secret, and stop working if it is lost", "file", apiKeySecretFile(config.UsersFile))
+	default:
+		logger.Warn("NOTABLY_API_KEY_SECRET is not set; API keys are digested with a random secret")
+	}
+
+	shareKey, configured := newShareKey(config)
+	if !configured {
+		logger.Warn("NOTABLY_SHARE_LINK_SECRET is not set; share links will stop working when the server restarts")
+	}
+	server.shareKey = shareKey
+	if server.syncCursors, err = db.NewCursorSigner([]byte(config.CursorSecret)); err != nil {
+		return nil, err
+	}
+	if config.CursorSecret == "" {
+		logger.Warn("NOTABLY_CURSOR_SECRET is not set; sync cursors will stop working when the server restarts")
+	}
+
+	archive, err := newArchiveStore(config)
+	if err != nil {
+		return nil, err
+	}
+	server.archive = archive
+	if archive != nil {
+		server.cold = coldstore.NewTier(archive)
+	}
+	if server.attachments, err = newAttachmentStore(config); err != nil {
+		return nil, err
+	}
+
+	plugins, err := plugin.Load(server.background, config.Plugins, builtinColumnTypes, logger)
+	if err != nil {
+		return nil, fmt.Errorf("loading plugins: %w", err)
+	}
+	plugins.OnDrop = server.metrics.ObservePluginEventDropped
+	server.plugins = plugins
+	if names := plugins.Names(); len(names) > 0 {
+		logger.Info("loaded plugins", "plugins", names)
+	}
+
+	// Register routes
+	server.registerRoutes()
+	server.registerUIRoutes()
+	if err := server.checkRouteTimeouts(); err != nil {
+		plugins.Close()
+		return nil, err
+	}
+	if err := server.mountPluginRoutes(); err != nil {
+		plugins.Close()
+		return nil, err
+	}
+
+	return server, nil
 }
 
 // validateValueType checks if a value matches the expected data type
@@ -100,6 +634,26 @@ func validateValueType(value interface{}, dataType string) bool {
 		}
 		_, err := time.Parse(time.RFC3339, str)
 		return err == nil
+	case "date":
+		str, ok := value.(string)
+		if !ok {
+			return false
+		}
+		_, err := time.Parse(time.DateOnly, str)
+		return err == nil
+	case geopointType:
+		_, ok := geoPointOf(value)
+		return ok
+	case uuidType:
+		str, ok := value.(string)
+		return ok && uuidPattern.MatchString(str)
+	case attachmentType:
+		_, ok := attachmentOf(value)
+		return ok
+	case enumType, referenceType, decimalType:
+		// Checked against the column definition by checkTypedValue
+		_, ok := value.(string)
+		return ok
 	case "object", "json":
 		// For object/json, we expect a map
 		_, ok := value.(map[string]interface{})
@@ -109,122 +663,458 @@ func validateValueType(value interface{}, dataType string) bool {
 		_, ok := value.([]interface{})
 		return ok
 	default:
-		// Unknown type, consider valid
+		// Unknown types are rejected when columns are defined; only tables
+		// defined before that keep them, and their values are not checked
 		return true
 	}
 }
 
 // Helper function to check if a table exists for the given user
 func tableExists(ctx context.Context, store *db.StoreAdapter, userID, table string) bool {
-	snap, err := store.GetSnapshot(ctx, time.Now().UTC())
+	facts, err := store.QueryByField(ctx, userID, table, time.Time{}, time.Now().UTC())
 	if err != nil {
-		log.Printf("Error checking if table exists for user %s, table %s: %v", userID, table, err)
+		slog.ErrorContext(ctx, "checking table existence failed", "user", userID, "table", table, "error", err)
 		return false
 	}
-
-	if entries, ok := snap[userID]; ok {
-		_, exists := entries[table]
-		return exists
-	}
-	return false
-}
-
-func init() {
-	// Seed the random number generator for ID generation
-	rand.Seed(time.Now().UnixNano())
+	return tableLive(facts)
 }
 
 func (s *Server) registerRoutes() {
+	// Prometheus metrics (no auth required, not versioned)
+	s.mux.Handle("GET /metrics", s.metrics.Handler())
+
+	// Readiness, including replication lag (no auth required, not versioned)
+	s.mux.HandleFunc("GET /readyz", s.handleReady)
+
 	// Authentication endpoints (no auth required)
-	s.mux.HandleFunc("POST /auth/register", s.handleRegister)
-	s.mux.HandleFunc("POST /auth/login", s.handleLogin)
+	s.route("POST /auth/register", http.HandlerFunc(s.handleRegister))
+	s.route("POST /auth/login", http.HandlerFunc(s.handleLogin))
+	s.route("POST /auth/forgot-password", http.HandlerFunc(s.handleForgotPassword))
+	s.route("POST /auth/reset-password", http.HandlerFunc(s.handleResetPassword))
+	s.route("POST /auth/verify-email", http.HandlerFunc(s.handleVerifyEmail))
+	s.route("POST /auth/resend-verification", http.HandlerFunc(s.handleResendVerification))
+
+	// API Key management (requires an admin key)
+	auth := s.requireAdmin(s.handleAPIKeysList)
+	s.route("GET /auth/keys", auth)
+
+	auth = s.requireAdmin(s.handleAPIKeyCreate)
+	s.route("POST /auth/keys", auth)
+
+	auth = s.requireAdmin(s.handleAPIKeyRevoke)
+	s.route("DELETE /auth/keys/{id}", auth)
+
+	auth = s.requireAdmin(s.handleAPIKeyRotate)
+	s.route("POST /auth/keys/{id}/rotate", auth)
+
+	auth = s.requireAdmin(s.handleLockoutStatus)
+	s.route("GET /auth/lockout", auth)
+
+	auth = s.requireAdmin(s.handleUnlock)
+	s.route("DELETE /auth/lockout", auth)
+
+	// Access reviews (requires an admin key)
+	auth = s.requireAdmin(s.handleListGrants)
+	s.route("GET /access/grants", auth)
 
-	// API Key management (requires auth)
-	auth := s.authenticator.RequireAuth(http.HandlerFunc(s.handleAPIKeysList))
-	s.mux.Handle("GET /auth/keys", auth)
+	auth = s.requireAdmin(s.handleBulkRevoke)
+	s.route("POST /access/revoke", auth)
 
-	auth = s.authenticator.RequireAuth(http.HandlerFunc(s.handleAPIKeyCreate))
-	s.mux.Handle("POST /auth/keys", auth)
+	auth = s.requireAdmin(s.handleAccessReview)
+	s.route("GET /access/review", auth)
 
-	auth = s.authenticator.RequireAuth(http.HandlerFunc(s.handleAPIKeyRevoke))
-	s.mux.Handle("DELETE /auth/keys/{id}", auth)
+	auth = s.requireAdmin(s.handleListAccessReviews)
+	s.route("GET /access/reviews", auth)
 
 	// Tables API (all require auth)
-	auth = s.authenticator.RequireAuth(http.HandlerFunc(s.handleListTables))
-	s.mux.Handle("GET /tables", auth)
+	auth = s.requireAuth(s.handleListDataTypes)
+	s.route("GET /datatypes", auth)
+
+	auth = s.requireAuth(s.handleListTables)
+	s.route("GET /tables", auth)
+
+	auth = s.requireAuth(s.handleCreateTable)
+	s.route("POST /tables", auth)
+
+	auth = s.requireAuth(s.handleDeleteTable)
+	s.route("DELETE /tables/{table}", auth)
+
+	auth = s.requireAuth(s.handleUpdateSchema)
+	s.route("PUT /tables/{table}/schema", auth)
+
+	auth = s.requireAuth(s.handleAggregate)
+	s.route("GET /tables/{table}/aggregate", auth)
+
+	auth = s.requireAuth(s.handleJoin)
+	s.route("GET /join", auth)
+
+	auth = s.requireAuth(s.handleSQLQuery)
+	s.route("POST /query", auth)
+
+	// Views API
+	auth = s.requireAuth(s.handleListViews)
+	s.route("GET /views", auth)
 
-	auth = s.authenticator.RequireAuth(http.HandlerFunc(s.handleCreateTable))
-	s.mux.Handle("POST /tables", auth)
+	auth = s.requireAuth(s.handleCreateView)
+	s.route("POST /views", auth)
+
+	auth = s.requireAuth(s.handleGetView)
+	s.route("GET /views/{name}", auth)
+
+	auth = s.requireAuth(s.handleDeleteView)
+	s.route("DELETE /views/{name}", auth)
+
+	auth = s.requireAuth(s.handleViewRows)
+	s.route("GET /views/{name}/rows", auth)
+
+	auth = s.requireAuth(s.handleViewSnapshot)
+	s.route("GET /views/{name}/snapshot", auth)
 
 	// Rows API
-	auth = s.authenticator.RequireAuth(http.HandlerFunc(s.handleListRows))
-	s.mux.Handle("GET /tables/{table}/rows", auth)
+	auth = s.requireAuth(s.handleListRows)
+	s.route("GET /tables/{table}/rows", auth)
+
+	auth = s.requireAuth(s.handleGetRow)
+	s.route("GET /tables/{table}/rows/{id}", auth)
+
+	auth = s.requireAuth(s.handleNearbyRows)
+	s.route("GET /tables/{table}/rows/nearby", auth)
+
+	auth = s.requireAuth(s.handleRowsWithin)
+	s.route("GET /tables/{table}/rows/within", auth)
+
+	auth = s.requireAuth(s.handleRowSeries)
+	s.route("GET /tables/{table}/rows/{id}/series", auth)
 
-	auth = s.authenticator.RequireAuth(http.HandlerFunc(s.handleGetRow))
-	s.mux.Handle("GET /tables/{table}/rows/{id}", auth)
+	auth = s.requireAuth(s.handleCreateRow)
+	s.route("POST /tables/{table}/rows", auth)
 
-	auth = s.authenticator.RequireAuth(http.HandlerFunc(s.handleCreateRow))
-	s.mux.Handle("POST /tables/{table}/rows", auth)
+	auth = s.requireAuth(s.handleUpdateRow)
+	s.route("PUT /tables/{table}/rows/{id}", auth)
 
-	auth = s.authenticator.RequireAuth(http.HandlerFunc(s.handleUpdateRow))
-	s.mux.Handle("PUT /tables/{table}/rows/{id}", auth)
+	auth = s.requireAuth(s.handlePatchRow)
+	s.route("PATCH /tables/{table}/rows/{id}", auth)
 
-	auth = s.authenticator.RequireAuth(http.HandlerFunc(s.handleDeleteRow))
-	s.mux.Handle("DELETE /tables/{table}/rows/{id}", auth)
+	auth = s.requireAuth(s.handleDeleteRow)
+	s.route("DELETE /tables/{table}/rows/{id}", auth)
+
+	auth = s.requireAuth(s.handleRevertRow)
+	s.route("POST /tables/{table}/rows/{id}/revert", auth)
+
+	auth = s.requireAuth(s.async("rollback", s.handleRollbackTable))
+	s.route("POST /tables/{table}/rollback", auth)
+
+	auth = s.requireAuth(s.handleForkTable)
+	s.route("POST /tables/{table}/fork", auth)
+
+	auth = s.requireAuth(s.async("merge", s.handleMergeTable))
+	s.route("POST /tables/{table}/merge", auth)
+
+	auth = s.requireAuth(s.handleTransaction)
+	s.route("POST /transactions", auth)
+
+	// Background jobs, started by long-running operations given ?async=true
+	auth = s.requireAuth(s.handleGetJob)
+	s.route("GET /jobs/{id}", auth)
+
+	auth = s.requireAuth(s.handleCancelJob)
+	s.route("POST /jobs/{id}/cancel", auth)
 
 	// Snapshot and history
-	auth = s.authenticator.RequireAuth(http.HandlerFunc(s.handleTableSnapshot))
-	s.mux.Handle("GET /tables/{table}/snapshot", auth)
+	auth = s.requireAuth(s.handleTableSnapshot)
+	s.route("GET /tables/{table}/snapshot", auth)
+
+	auth = s.requireAuth(s.handleTableHistory)
+	s.route("GET /tables/{table}/history", auth)
+
+	auth = s.requireAuth(s.handleListChanges)
+	s.route("GET /changes", auth)
+
+	// Offline sync
+	auth = s.requireAuth(s.handleSyncChanges)
+	s.route("GET /sync/changes", auth)
+
+	auth = s.requireAuth(s.handleSyncPush)
+	s.route("POST /sync/push", auth)
+
+	// Realtime presence and row locks
+	auth = withProtocolAuth(s.requireAuth(s.handleRealtime))
+	s.route("GET /realtime", auth)
+
+	// Attachments
+	auth = s.requireAuth(s.handleCreateAttachment)
+	s.route("POST /tables/{table}/attachments", auth)
+
+	auth = s.requireAuth(s.handleUploadRowAttachment)
+	s.route("POST /tables/{table}/rows/{id}/attachments", auth)
+
+	auth = s.requireAuth(s.handleListRowAttachments)
+	s.route("GET /tables/{table}/rows/{id}/attachments", auth)
+
+	auth = s.requireAuth(s.handleDownloadRowAttachment)
+	s.route("GET /tables/{table}/rows/{id}/attachments/{attachment}", auth)
+
+	auth = s.requireAuth(s.handleDeleteRowAttachment)
+	s.route("DELETE /tables/{table}/rows/{id}/attachments/{attachment}", auth)
+
+	auth = s.requireAuth(s.handleAttachmentUsage)
+	s.route("GET /attachments/usage", auth)
+
+	// Archival
+	auth = s.requireAuth(s.async("archive", s.handleArchiveRows))
+	s.route("POST /tables/{table}/archive", auth)
+
+	auth = s.requireAuth(s.handleListArchivedRows)
+	s.route("GET /tables/{table}/archive", auth)
+
+	auth = s.requireAuth(s.handleRestoreRow)
+	s.route("POST /tables/{table}/rows/{id}/restore", auth)
+
+	auth = s.requireAuth(s.async("offload", s.handleOffloadHistory))
+	s.route("POST /tables/{table}/offload", auth)
+
+	// Automation routes
+	auth = s.requireAuth(s.handleListAutomations)
+	s.route("GET /tables/{table}/automations", auth)
+
+	auth = s.requireAuth(s.handlePutAutomation)
+	s.route("PUT /tables/{table}/automations/{name}", auth)
 
-	auth = s.authenticator.RequireAuth(http.HandlerFunc(s.handleTableHistory))
-	s.mux.Handle("GET /tables/{table}/history", auth)
+	auth = s.requireAuth(s.handleDeleteAutomation)
+	s.route("DELETE /tables/{table}/automations/{name}", auth)
+
+	// Retention policies
+	auth = s.requireAuth(s.handleGetRetention)
+	s.route("GET /tables/{table}/retention", auth)
+
+	auth = s.requireAuth(s.handlePutRetention)
+	s.route("PUT /tables/{table}/retention", auth)
+
+	auth = s.requireAuth(s.handleDeleteRetention)
+	s.route("DELETE /tables/{table}/retention", auth)
+
+	auth = s.requireAuth(s.async("compact", s.handleCompactTable))
+	s.route("POST /tables/{table}/compact", auth)
+
+	// Virtual table routes
+	auth = s.requireAuth(s.handleRefreshVirtualTable)
+	s.route("POST /tables/{table}/refresh", auth)
+
+	// Metrics ingestion routes
+	auth = s.requireAuth(s.handlePrometheusWrite)
+	s.route("POST /tables/{table}/prometheus/write", auth)
+
+	// Calendar feed routes. The feed itself is authorized by its token.
+	auth = s.requireAuth(s.handleGetCalendarFeed)
+	s.route("GET /tables/{table}/calendar", auth)
+
+	auth = s.requireAuth(s.handlePutCalendarFeed)
+	s.route("PUT /tables/{table}/calendar", auth)
+
+	auth = s.requireAuth(s.handleDeleteCalendarFeed)
+	s.route("DELETE /tables/{table}/calendar", auth)
+
+	s.route("GET /calendar/{user}/{table}", http.HandlerFunc(s.handleCalendarFeed))
+
+	// Share links. Shared resources are authorized by the link's signature.
+	auth = s.requireAuth(s.handleCreateShareLink)
+	s.route("POST /tables/{table}/share-links", auth)
+
+	s.route("GET /shared/{user}/{table}/snapshot", s.requireShareLink(shareSnapshot, s.handleTableSnapshot))
+	s.route("GET /shared/{user}/{table}/history", s.requireShareLink(shareHistory, s.handleTableHistory))
+
+	// Webhook routes
+	auth = s.requireAuth(s.handleListWebhooks)
+	s.route("GET /tables/{table}/webhooks", auth)
+
+	auth = s.requireAuth(s.handleCreateWebhook)
+	s.route("POST /tables/{table}/webhooks", auth)
+
+	auth = s.requireAuth(s.handleDeleteWebhook)
+	s.route("DELETE /tables/{table}/webhooks/{id}", auth)
+
+	auth = s.requireAuth(s.handleListDeadLetters)
+	s.route("GET /tables/{table}/webhooks/dead-letters", auth)
+
+	auth = s.requireAuth(s.handleGetPublishing)
+	s.route("GET /tables/{table}/publishing", auth)
+
+	auth = s.requireAuth(s.handlePutPublishing)
+	s.route("PUT /tables/{table}/publishing", auth)
+
+	auth = s.requireAuth(s.handleListPublishDeadLetters)
+	s.route("GET /tables/{table}/publishing/dead-letters", auth)
 }
 
 // Run starts the server
 func (s *Server) Run() error {
-	log.Printf("Starting server on %s", s.config.Addr)
-
-	// Create a CORS middleware
-	c := cors.New(cors.Options{
-		AllowedOrigins: []string{"http://localhost:3000"}, // Add your frontend URL
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders: []string{"Content-Type", "Authorization"},
-		// Enable Debugging for testing, consider disabling in production
-		Debug: true,
-	})
+	s.logger.Info("starting server", "addr", s.config.Addr)
+
+	go s.runTempTableSweeper(s.background)
+	go s.runVirtualTableSync(s.background)
+	go s.runExpirySweeper(s.background)
+	go s.runRetentionCompactor(s.background)
+	if s.config.AccessReviewInterval > 0 {
+		go s.runAccessReviews(s.background, s.config.AccessReviewInterval)
+	}
+	for i := 0; i < webhookWorkers; i++ {
+		go s.runWebhookDelivery(s.background)
+	}
+	for i := 0; i < jobWorkers; i++ {
+		go s.runJobWorker(s.background)
+	}
+	for _, queue := range s.publishQueues {
+		s.publishWG.Add(1)
+		go s.runPublisher(s.background, queue)
+	}
+	if s.replicated() {
+		go s.runReplicationHeartbeat(s.background)
+	}
+
+	if s.tracer != nil {
+		go s.tracer.Run(s.background)
+	}
 
-	// Use the middleware
-	handler := c.Handler(s.mux)
+	handler := s.logRequests(s.record(s.traceRequests(s.instrument(s.compress(s.cors().Handler(s.serveFrontend(s.mux)))))))
 
+	if s.config.TLS.enabled() {
+		return s.serveTLS(handler)
+	}
 	return http.ListenAndServe(s.config.Addr, handler)
 }
 
 // Stop gracefully stops the server
 func (s *Server) Stop(ctx context.Context) error {
 	// Implement graceful shutdown if needed
+	s.cancel()
+	s.plugins.Close()
+
+	// Events not yet published are dead-lettered rather than lost
+	done := make(chan struct{})
+	go func() {
+		s.publishWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.drainPublishQueues(context.WithoutCancel(ctx))
+	if s.tracer != nil {
+		// Spans of the last requests would be lost otherwise
+		if err := s.tracer.Flush(ctx); err != nil {
+			s.logger.WarnContext(ctx, "exporting spans failed", "error", err)
+		}
+	}
 	return nil
 }
 
 // Handler returns the HTTP handler for the server with CORS middleware
 func (s *Server) Handler() http.Handler {
-	// Create a CORS middleware
-	c := cors.New(cors.Options{
-		AllowedOrigins: []string{"http://localhost:3000"}, // Add your frontend URL
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders: []string{"Content-Type", "Authorization"},
-		// Enable Debugging for testing, consider disabling in production
-		Debug: true,
-	})
+	return s.logRequests(s.record(s.traceRequests(s.instrument(s.compress(s.limitBody(s.cors().Handler(s.serveFrontend(s.mux))))))))
+}
 
-	// Use the middleware
-	return c.Handler(s.mux)
+// cors returns the CORS middleware for the configured origins
+func (s *Server) cors() *cors.Cors {
+	origins := s.config.CORSOrigins
+	if len(origins) == 0 {
+		origins = defaultCORSOrigins
+	}
+	return cors.New(cors.Options{
+		AllowedOrigins: origins,
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Content-Type", "Authorization", "If-None-Match"},
+		ExposedHeaders: []string{"ETag"},
+		Debug:          s.config.CORSDebug,
+	})
 }
 
 // Helper methods
 
-// getStoreForUser returns a store adapter for the given user ID
+// getStoreForUser returns a store adapter for the given user ID on the base table
 func (s *Server) getStoreForUser(ctx context.Context, userID string) (*db.StoreAdapter, error) {
-	// Create AWS config
+	return s.openStore(ctx, s.config.TableName, userID)
+}
+
+// openStore returns a store adapter for the given user ID on a DynamoDB table,
+// creating the table if it does not exist yet
+func (s *Server) openStore(ctx context.Context, tableName, userID string) (*db.StoreAdapter, error) {
+	if s.config.InMemory {
+		s.memMu.Lock()
+		defer s.memMu.Unlock()
+		mem, ok := s.memStores[tableName+"#"+userID]
+		if !ok {
+			mem = db.NewMemoryStore()
+			s.memStores[tableName+"#"+userID] = mem
+		}
+		return s.wrapStore(mem, tableName, userID), nil
+	}
+
+	client, err := s.dynamoClient(ctx, tableName, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the table exists (this is idempotent and safe to call every time)
+	if err := client.CreateTable(ctx); err != nil {
+		s.logger.ErrorContext(ctx, "ensuring DynamoDB table exists failed", "error", err)
+		return nil, fmt.Errorf("ensuring table exists: %w", err)
+	}
+
+	store := db.CreateStoreFromClient(client)
+	if s.replicated() {
+		store = db.CreateReplicatedStoreFromClient(client, s.metrics, db.DefaultConflictWindow)
+	}
+	if tableName == s.config.TableName && s.config.MigrationTableName != "" {
+		if store, err = s.migrationStore(ctx, store, userID); err != nil {
+			return nil, err
+		}
+	}
+	return s.wrapStore(store, tableName, userID), nil
+}
+
+// migrationStore returns a store writing a user's facts to both the base
+// table, served by store, and the table an online migration copies them to
+func (s *Server) migrationStore(ctx context.Context, store db.Store, userID string) (db.Store, error) {
+	phase, err := migrate.ParsePhase(s.config.MigrationPhase)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s.dynamoClient(ctx, s.config.MigrationTableName, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.CreateTable(ctx); err != nil {
+		s.logger.ErrorContext(ctx, "ensuring migration table exists failed", "table", s.config.MigrationTableName, "error", err)
+		return nil, fmt.Errorf("ensuring migration table exists: %w", err)
+	}
+	return migrate.NewDualStore(store, db.CreateStoreFromClient(client), phase, s.logger), nil
+}
+
+// dynamoClient returns a client for the given user ID on a DynamoDB table
+// in the server's region
+func (s *Server) dynamoClient(ctx context.Context, tableName, userID string) (*dynamo.Client, error) {
+	cfg, err := s.dynamoConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client := dynamo.NewClient(cfg, tableName, userID).
+		WithLogger(s.logger).
+		WithObserver(s.metrics.StoreObserver("dynamo")).
+		WithRetry(s.config.StoreRetry).
+		WithTableOptions(s.config.Table).
+		WithRegion(s.config.Region)
+	if tableName == s.config.TableName {
+		client.WithLegacyTable(s.config.LegacyTableName)
+	}
+	return client, nil
+}
+
+// dynamoConfig returns the AWS config of DynamoDB clients, reaching the
+// configured endpoint in the server's region
+func (s *Server) dynamoConfig(ctx context.Context) (aws.Config, error) {
 	opts := []func(*config.LoadOptions) error{}
 	if s.config.DynamoEndpoint != "" {
 		resolver := aws.EndpointResolverFunc(func(service, region string) (aws.Endpoint, error) {
@@ -232,26 +1122,59 @@ func (s *Server) getStoreForUser(ctx context.Context, userID string) (*db.StoreA
 		})
 		opts = append(opts, config.WithEndpointResolver(resolver))
 	}
+	if s.config.Region != "" {
+		opts = append(opts, config.WithRegion(s.config.Region))
+	}
 
 	cfg, err := config.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
-		log.Printf("Error loading AWS config: %v", err)
-		return nil, fmt.Errorf("loading AWS config: %w", err)
+		s.logger.ErrorContext(ctx, "loading AWS config failed", "error", err)
+		return aws.Config{}, fmt.Errorf("loading AWS config: %w", err)
 	}
+	return cfg, nil
+}
 
-	// Create client and store
-	client := dynamo.NewClient(cfg, s.config.TableName, userID)
-
-	// Ensure the table exists (this is idempotent and safe to call every time)
-	if err := client.CreateTable(ctx); err != nil {
-		log.Printf("Error ensuring DynamoDB table exists: %v", err)
-		return nil, fmt.Errorf("ensuring table exists: %w", err)
+// wrapStore returns an adapter for the store, measuring its calls and
+// encrypting sensitive columns when a key is configured. Recorded requests see the store below the
+// encryption, so bundles only hold encrypted values in their sealed form.
+func (s *Server) wrapStore(inner db.Store, tableName, userID string) *db.StoreAdapter {
+	inner = db.NewInstrumentedStore(inner, db.InstrumentOptions{
+		Observer:      s.metrics.StoreObserver("store"),
+		Logger:        s.logger,
+		SlowThreshold: s.config.SlowQueryThreshold,
+	})
+	if limiter := s.capacityLimiter(tableName); limiter != nil {
+		inner = limiter.Wrap(inner)
 	}
+	if s.config.Record.Dir != "" {
+		inner = replay.NewStore(inner, tableName, userID)
+	}
+	if s.sealer != nil {
+		inner = crypto.NewStore(inner, s.sealer, s.encryptedColumns)
+	}
+	return db.NewStoreAdapter(inner)
+}
 
-	// Create adapter for the store
-	store := db.NewStoreAdapter(db.CreateStoreFromClient(client))
-
-	return store, nil
+// capacityLimiter returns the write capacity limiter of a table, or nil
+// when writes to it are not paced. DynamoDB tables are always paced once
+// they throttle; in-memory ones only with a configured capacity.
+func (s *Server) capacityLimiter(tableName string) *db.CapacityLimiter {
+	capacity := s.config.WriteCapacity
+	if s.config.InMemory && capacity == 0 {
+		return nil
+	}
+	if t := s.config.Table; capacity == 0 && t.Provisioned() && t.AutoScaling == nil {
+		_, write := t.ProvisionedCapacity()
+		capacity = int(write)
+	}
+	s.capacityMu.Lock()
+	defer s.capacityMu.Unlock()
+	limiter, ok := s.capacity[tableName]
+	if !ok {
+		limiter = db.NewCapacityLimiter(db.CapacityLimiterOptions{WriteCapacity: float64(capacity)})
+		s.capacity[tableName] = limiter
+	}
+	return limiter
 }
 
 // writeJSON writes a JSON response
@@ -259,7 +1182,7 @@ func writeJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(data); err != nil {
-		log.Printf("error encoding JSON response: %v", err)
+		slog.Error("encoding JSON response failed", "error", err)
 	}
 }
 
@@ -268,36 +1191,111 @@ func writeError(w http.ResponseWriter, status int, message string) {
 	writeJSON(w, status, map[string]string{"error": message})
 }
 
-// newID generates a unique ID
-func newID() string {
-	// Create a more robust ID format (similar to ULID)
-	// Format: timestamp + random component
-	now := time.Now().UTC()
-	timestamp := now.Format("20060102150405.000")
-	randomPart := make([]byte, 8)
-	for i := range randomPart {
-		randomPart[i] = byte(rand.Intn(256))
+// storeErrorStatus maps a storage error to an HTTP status by its db error kind
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, db.ErrConditionFailed):
+		return http.StatusConflict
+	case errors.Is(err, db.ErrThrottled):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, db.ErrOverCapacity):
+		return http.StatusTooManyRequests
+	case errors.Is(err, db.ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeStoreError writes a storage failure with a status chosen from its
+// error kind. Throttled requests are told to retry, and calls that ran past
+// the request's deadline are answered as timeouts.
+func writeStoreError(w http.ResponseWriter, err error, message string) {
+	var sizeErr *schema.ItemSizeError
+	if errors.As(err, &sizeErr) {
+		writeValueTooLarge(w, sizeErr, message)
+		return
+	}
+	status := storeErrorStatus(err)
+	if status == http.StatusGatewayTimeout {
+		writeTimeout(w, fmt.Sprintf("%s: %v", message, err))
+		return
+	}
+	setRetryAfter(w, err, status)
+	writeError(w, status, fmt.Sprintf("%s: %v", message, err))
+}
+
+// valueTooLargeErrorCode is the code of responses to writes of facts too
+// large to store
+const valueTooLargeErrorCode = "value_too_large"
+
+// writeValueTooLarge answers a write refused for its size with the limit and
+// the largest value the fact could have had, so clients can split the row
+func writeValueTooLarge(w http.ResponseWriter, err *schema.ItemSizeError, message string) {
+	writeJSON(w, http.StatusRequestEntityTooLarge, map[string]interface{}{
+		"error":        fmt.Sprintf("%s: %v", message, err),
+		"code":         valueTooLargeErrorCode,
+		"size":         err.Size,
+		"limit":        err.Limit,
+		"maxValueSize": err.MaxValueSize,
+	})
+}
+
+// setRetryAfter tells clients of throttled requests when to retry: when the
+// write capacity is available again for writes over it, otherwise in a second
+func setRetryAfter(w http.ResponseWriter, err error, status int) {
+	switch status {
+	case http.StatusServiceUnavailable:
+		w.Header().Set("Retry-After", "1")
+	case http.StatusTooManyRequests:
+		wait := 1
+		var capErr *db.CapacityError
+		if errors.As(err, &capErr) {
+			wait = int(math.Max(1, math.Ceil(capErr.RetryAfter.Seconds())))
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(wait))
 	}
-	return fmt.Sprintf("%s_%x", timestamp, randomPart)
+}
+
+// newID generates a unique ID for a fact or row. IDs are ULIDs, so they
+// sort by creation time and never collide between concurrent requests.
+func newID() string {
+	return ulid.Make().String()
 }
 
 // Auth handlers
 
 func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Username string `json:"username"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Username    string `json:"username"`
+		Email       string `json:"email"`
+		Password    string `json:"password"`
+		StorageMode string `json:"storageMode,omitempty"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request format")
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	// Validate input
-	if req.Username == "" || req.Email == "" || req.Password == "" {
-		writeError(w, http.StatusBadRequest, "Username, email, and password are required")
+	var fields fieldErrors
+	fields.required("username", req.Username)
+	fields.required("email", req.Email)
+	fields.required("password", req.Password)
+	if len(fields) > 0 {
+		writeValidationError(w, "Username, email, and password are required", fields)
+		return
+	}
+
+	if req.StorageMode == "" {
+		req.StorageMode = s.config.StorageMode
+	}
+	if _, err := db.NewTableResolver(req.StorageMode, s.config.TableName); err != nil {
+		writeError(w, http.StatusBadRequest, "storageMode must be \"shared\" or \"isolated\"")
 		return
 	}
 
@@ -312,19 +1310,44 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The storage mode is fixed for the lifetime of the account
+	if req.StorageMode != "" {
+		user.StorageMode = req.StorageMode
+		if err := s.userStore.UpdateUser(r.Context(), user); err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to create user")
+			return
+		}
+	}
+
+	// Accounts that must verify their email get a key once they log in
+	if s.authenticator.RequireVerifiedEmail() {
+		if err := s.sendVerification(r.Context(), user); err != nil {
+			s.logger.ErrorContext(r.Context(), "sending verification email failed", "error", err)
+		}
+		writeJSON(w, http.StatusCreated, map[string]interface{}{
+			"id":            user.ID,
+			"username":      user.Username,
+			"email":         user.Email,
+			"emailVerified": false,
+			"storageMode":   s.storageMode(user),
+		})
+		return
+	}
+
 	// Generate an API key for the new user
 	_, rawKey, err := s.authenticator.GenerateAPIKey(r.Context(), user.ID, "default", 0)
 	if err != nil {
-		log.Printf("Error generating API key: %v", err)
+		s.logger.ErrorContext(r.Context(), "generating API key failed", "error", err)
 		// Continue anyway, user was created
 	}
 
 	// Return user info
 	writeJSON(w, http.StatusCreated, map[string]interface{}{
-		"id":       user.ID,
-		"username": user.Username,
-		"email":    user.Email,
-		"apiKey":   rawKey,
+		"id":          user.ID,
+		"username":    user.Username,
+		"email":       user.Email,
+		"apiKey":      rawKey,
+		"storageMode": s.storageMode(user),
 	})
 }
 
@@ -334,21 +1357,23 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request format")
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	// Validate input
-	if req.Username == "" || req.Password == "" {
-		writeError(w, http.StatusBadRequest, "Username and password are required")
+	var fields fieldErrors
+	fields.required("username", req.Username)
+	fields.required("password", req.Password)
+	if len(fields) > 0 {
+		writeValidationError(w, "Username and password are required", fields)
 		return
 	}
 
 	// Authenticate user
-	user, err := s.authenticator.LoginUser(r.Context(), req.Username, req.Password)
+	user, err := s.authenticator.LoginUser(r.Context(), req.Username, req.Password, clientIP(r))
 	if err != nil {
-		writeError(w, http.StatusUnauthorized, "Invalid credentials")
+		s.writeLoginError(w, err)
 		return
 	}
 
@@ -390,18 +1415,33 @@ func (s *Server) handleAPIKeysList(w http.ResponseWriter, r *http.Request) {
 		ExpiresAt time.Time `json:"expiresAt"`
 		LastUsed  time.Time `json:"lastUsed"`
 		Revoked   bool      `json:"revoked"`
+		Scopes    []string  `json:"scopes,omitempty"`
+		// State is active, rotating, expired or revoked
+		State       string     `json:"state"`
+		RotatedTo   string     `json:"rotatedTo,omitempty"`
+		RotatedFrom string     `json:"rotatedFrom,omitempty"`
+		RotatedAt   *time.Time `json:"rotatedAt,omitempty"`
 	}
 
+	now := time.Now().UTC()
 	response := make([]keyInfo, 0, len(keys))
 	for _, key := range keys {
-		response = append(response, keyInfo{
-			ID:        key.ID,
-			Name:      key.Name,
-			CreatedAt: key.CreatedAt,
-			ExpiresAt: key.ExpiresAt,
-			LastUsed:  key.LastUsed,
-			Revoked:   key.Revoked,
-		})
+		info := keyInfo{
+			ID:          key.ID,
+			Name:        key.Name,
+			CreatedAt:   key.CreatedAt,
+			ExpiresAt:   key.ExpiresAt,
+			LastUsed:    key.LastUsed,
+			Revoked:     key.Revoked,
+			Scopes:      key.Scopes,
+			State:       key.State(now),
+			RotatedTo:   key.RotatedTo,
+			RotatedFrom: key.RotatedFrom,
+		}
+		if !key.RotatedAt.IsZero() {
+			info.RotatedAt = &key.RotatedAt
+		}
+		response = append(response, info)
 	}
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
@@ -419,10 +1459,13 @@ func (s *Server) handleAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Name     string        `json:"name"`
 		Duration time.Duration `json:"duration"` // In seconds
+		Scopes   []string      `json:"scopes,omitempty"`
+		// Password must be supplied to grant secrets:read, so a leaked key
+		// cannot mint a more powerful one
+		Password string `json:"password,omitempty"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request format")
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
@@ -435,10 +1478,21 @@ func (s *Server) handleAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
 		duration = auth.DefaultAPIKeyExpiration
 	}
 
+	if grantsSecrets(req.Scopes) {
+		if _, err := s.authenticator.LoginUser(r.Context(), user.Username, req.Password, clientIP(r)); err != nil {
+			writeError(w, http.StatusForbidden, "Password is required to create a key with the secrets:read scope")
+			return
+		}
+	}
+
 	// Create new API key
-	apiKey, rawKey, err := s.authenticator.GenerateAPIKey(r.Context(), user.ID, req.Name, duration)
+	apiKey, rawKey, err := s.authenticator.GenerateScopedAPIKey(r.Context(), user.ID, req.Name, duration, req.Scopes)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Failed to create API key")
+		if errors.Is(err, auth.ErrUnknownScope) || errors.Is(err, auth.ErrConflictingScopes) {
+			writeError(w, http.StatusBadRequest, err.Error())
+		} else {
+			writeError(w, http.StatusInternalServerError, "Failed to create API key")
+		}
 		return
 	}
 
@@ -448,6 +1502,7 @@ func (s *Server) handleAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
 		"apiKey":    rawKey,
 		"createdAt": apiKey.CreatedAt,
 		"expiresAt": apiKey.ExpiresAt,
+		"scopes":    apiKey.Scopes,
 	})
 }
 
@@ -481,20 +1536,113 @@ func (s *Server) handleAPIKeyRevoke(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleAPIKeyRotate replaces a key with a new one, keeping the old key valid
+// for a grace period so clients can switch over without downtime
+func (s *Server) handleAPIKeyRotate(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	keyID := r.PathValue("id")
+	var req struct {
+		GracePeriod *time.Duration `json:"gracePeriod,omitempty"` // In seconds
+		// Password must be supplied to rotate a key with the secrets:read
+		// scope, as when creating one
+		Password string `json:"password,omitempty"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	grace := s.config.KeyRotationGrace
+	if grace == 0 {
+		grace = auth.DefaultRotationGrace
+	}
+	if req.GracePeriod != nil {
+		if *req.GracePeriod < 0 {
+			writeValidationError(w, "Invalid grace period", fieldErrors{{Field: "gracePeriod", Message: "must not be negative"}})
+			return
+		}
+		grace = *req.GracePeriod * time.Second
+	}
+
+	current, err := s.authenticator.GetAPIKey(r.Context(), user.ID, keyID)
+	if errors.Is(err, auth.ErrInsufficientPrivilege) {
+		writeError(w, http.StatusForbidden, "You do not have permission to rotate this key")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, "API key not found")
+		return
+	}
+	if grantsSecrets(current.Scopes) {
+		if _, err := s.authenticator.LoginUser(r.Context(), user.Username, req.Password, clientIP(r)); err != nil {
+			writeError(w, http.StatusForbidden, "Password is required to rotate a key with the secrets:read scope")
+			return
+		}
+	}
+
+	key, rawKey, old, err := s.authenticator.RotateAPIKey(r.Context(), user.ID, keyID, grace)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInsufficientPrivilege):
+			writeError(w, http.StatusForbidden, "You do not have permission to rotate this key")
+		case errors.Is(err, auth.ErrAPIKeyRevoked), errors.Is(err, auth.ErrAPIKeyExpired), errors.Is(err, auth.ErrKeyAlreadyRotated):
+			writeError(w, http.StatusConflict, fmt.Sprintf("Cannot rotate key: %v", err))
+		default:
+			writeError(w, http.StatusInternalServerError, "Failed to rotate API key")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":          key.ID,
+		"name":        key.Name,
+		"apiKey":      rawKey,
+		"createdAt":   key.CreatedAt,
+		"expiresAt":   key.ExpiresAt,
+		"scopes":      key.Scopes,
+		"rotatedFrom": old.ID,
+		// The old key works until then
+		"previousExpiresAt": old.ExpiresAt,
+	})
+}
+
 // Table and row data types
 
 // TableInfo represents metadata for a user table
 type TableInfo struct {
 	Name      string                    `json:"name"`
+	Type      string                    `json:"type,omitempty"`
 	CreatedAt time.Time                 `json:"createdAt"`
 	Columns   []dynamo.ColumnDefinition `json:"columns,omitempty"`
+	Temporary bool                      `json:"temporary,omitempty"`
+	ExpiresAt *time.Time                `json:"expiresAt,omitempty"`
+	Source    *VirtualSourceInfo        `json:"source,omitempty"`
+	TTLColumn string                    `json:"ttlColumn,omitempty"`
+	Fork      *forkOrigin               `json:"fork,omitempty"`
+	Merge     string                    `json:"merge,omitempty"`
 }
 
 // RowData represents a row snapshot for a table
 type RowData struct {
-	ID        string                 `json:"id"`
-	Timestamp time.Time              `json:"timestamp"`
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	// ValidTime is when the row's version takes effect, when its writer
+	// gave a time other than Timestamp
+	ValidTime *time.Time             `json:"validTime,omitempty"`
 	Values    map[string]interface{} `json:"values"`
+	// Masked is set on secrets-table rows read without the secrets:read scope
+	Masked bool `json:"masked,omitempty"`
+
+	// System columns, derived from the row's history
+	CreatedAt *time.Time `json:"_createdAt,omitempty"`
+	UpdatedAt *time.Time `json:"_updatedAt,omitempty"`
+	CreatedBy string     `json:"_createdBy,omitempty"`
+
+	// Attachments holds download URLs of the row's attachment columns
+	Attachments map[string]attachmentURL `json:"_attachments,omitempty"`
 }
 
 // RowEvent represents a history event for a row
@@ -502,6 +1650,20 @@ type RowEvent struct {
 	ID        string                 `json:"id"`
 	Timestamp time.Time              `json:"timestamp"`
 	Values    map[string]interface{} `json:"values"`
+	Masked    bool                   `json:"masked,omitempty"`
+	// Type is created, updated or deleted; deletions have no values
+	Type string `json:"type"`
+	// Table is set on events of several tables, as GET /changes lists them
+	Table string `json:"table,omitempty"`
+	// ActorUserID and APIKeyID are who made the change, for facts written
+	// since they record it
+	ActorUserID string `json:"actorUserId,omitempty"`
+	APIKeyID    string `json:"apiKeyId,omitempty"`
+	// ValidTime is when the change takes effect, when it was given one
+	ValidTime *time.Time `json:"validTime,omitempty"`
+	// ClientDated is set when Timestamp came from the client that made the
+	// change rather than the server's clock
+	ClientDated bool `json:"clientDated,omitempty"`
 }
 
 // Table handlers
@@ -515,49 +1677,128 @@ func (s *Server) handleCreateTable(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		Name    string                    `json:"name"`
+		Type    string                    `json:"type,omitempty"`
 		Columns []dynamo.ColumnDefinition `json:"columns,omitempty"`
+
+		// Temporary tables are bound to the creating API key and purged after TTL
+		Temporary bool   `json:"temporary,omitempty"`
+		TTL       string `json:"ttl,omitempty"`
+
+		// Source configures the upstream of a virtual table
+		Source *virtualSource `json:"source,omitempty"`
+
+		// TTLColumn names a date or datetime column after which rows expire
+		TTLColumn string `json:"ttlColumn,omitempty"`
+
+		// Merge is row, the default, or field to merge concurrent writes
+		// of a row by column
+		Merge string `json:"merge,omitempty"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON: %v", err))
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	if req.Name == "" {
-		writeError(w, http.StatusBadRequest, "Table name is required")
+		writeValidationError(w, "Table name is required", []FieldError{{Field: "name", Message: "is required"}})
 		return
 	}
 
 	// Validate table name format
-	if !isValidName(req.Name) {
-		writeError(w, http.StatusBadRequest, "Table name must contain only alphanumeric characters, hyphens, and underscores")
+	if problem := s.nameProblem(req.Name); problem != "" {
+		writeError(w, http.StatusBadRequest, "Table name "+problem)
+		return
+	}
+	if !keyAllowsTable(r, req.Name) {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("API key is not scoped for table '%s'", req.Name))
 		return
 	}
 
+	switch req.Type {
+	case "":
+	case tableTypeSecrets:
+		if s.sealer == nil {
+			writeError(w, http.StatusNotImplemented, "Secrets tables are not configured on this server")
+			return
+		}
+	case tableTypeVirtual:
+		src, fields, err := s.newVirtualSource(r.Context(), user.ID, req.Name, req.Source)
+		if errors.Is(err, errVirtualTablesDisabled) {
+			writeError(w, http.StatusNotImplemented, "Virtual tables are not configured on this server")
+			return
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to seal upstream headers: %v", err))
+			return
+		}
+		if len(fields) > 0 {
+			writeValidationError(w, "Invalid virtual table source", fields)
+			return
+		}
+		req.Source = src
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Unknown table type '%s'", req.Type))
+		return
+	}
+	if req.Source != nil && req.Type != tableTypeVirtual {
+		writeValidationError(w, "Invalid table", []FieldError{{Field: "source", Message: "is only allowed for virtual tables"}})
+		return
+	}
+
+	if fields := validateTTLColumn(req.TTLColumn, req.Type, req.Columns); len(fields) > 0 {
+		writeValidationError(w, "Invalid table", fields)
+		return
+	}
+
+	if fields := validateMergePolicy(req.Merge, req.Type); len(fields) > 0 {
+		writeValidationError(w, "Invalid table", fields)
+		return
+	}
+	if req.Merge == mergeRow {
+		req.Merge = ""
+	}
+
+	opts := tableOptions{Type: req.Type, Source: req.Source, TTLColumn: req.TTLColumn, Merge: req.Merge}
+	if req.Temporary {
+		ttl, err := parseTempTableTTL(req.TTL)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		expiresAt := time.Now().UTC().Add(ttl)
+		opts.ExpiresAt = &expiresAt
+		if key, ok := auth.APIKeyFromContext(r.Context()); ok {
+			opts.Session = key.ID
+		}
+	} else if req.TTL != "" {
+		writeError(w, http.StatusBadRequest, "'ttl' is only allowed for temporary tables")
+		return
+	}
+
+	for _, col := range req.Columns {
+		if col.Encrypted && s.sealer == nil {
+			writeError(w, http.StatusNotImplemented, "Encrypted columns are not configured on this server")
+			return
+		}
+	}
+
 	// Get store for user
 	store, err := s.getStoreForUser(r.Context(), user.ID)
 	if err != nil {
-		log.Printf("User %s: Failed to initialize storage: %v", user.ID, err)
-		writeError(w, http.StatusInternalServerError, "Failed to initialize storage: "+err.Error())
+		s.logger.ErrorContext(r.Context(), "initializing storage failed", "error", err)
+		writeStoreError(w, err, "Failed to initialize storage")
 		return
 	}
 
 	// Validate column definitions if provided
-	if len(req.Columns) > 0 {
-		for _, col := range req.Columns {
-			if col.Name == "" {
-				writeError(w, http.StatusBadRequest, "Column name is required")
-				return
-			}
-			if !isValidName(col.Name) {
-				writeError(w, http.StatusBadRequest, fmt.Sprintf("Column name '%s' must contain only alphanumeric characters, hyphens, and underscores", col.Name))
-				return
-			}
-			if col.DataType == "" {
-				writeError(w, http.StatusBadRequest, fmt.Sprintf("Data type is required for column '%s'", col.Name))
-				return
-			}
-		}
+	if err := s.checkColumnDefs(req.Columns); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// In isolated mode this creates the table's own DynamoDB table
+	if _, err := s.getRowStore(r.Context(), store, user, req.Name); err != nil {
+		writeStoreError(w, err, "Failed to create table storage")
+		return
 	}
 
 	fact := dynamo.Fact{
@@ -565,17 +1806,27 @@ func (s *Server) handleCreateTable(w http.ResponseWriter, r *http.Request) {
 		Timestamp: time.Now().UTC(),
 		Namespace: user.ID,
 		FieldName: req.Name,
-		DataType:  "table",
-		Value:     "",
+		DataType:  tableDataType,
+		Value:     opts.encode(),
 		Columns:   req.Columns,
 	}
 
 	if err := store.PutFact(r.Context(), fact); err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create table: %v", err))
+		writeStoreError(w, err, "Failed to create table")
 		return
 	}
 
-	writeJSON(w, http.StatusCreated, TableInfo{Name: req.Name, CreatedAt: fact.Timestamp, Columns: req.Columns})
+	writeJSON(w, http.StatusCreated, TableInfo{
+		Name:      req.Name,
+		Type:      req.Type,
+		CreatedAt: fact.Timestamp,
+		Columns:   req.Columns,
+		Temporary: req.Temporary,
+		ExpiresAt: opts.ExpiresAt,
+		Source:    opts.Source.info(),
+		TTLColumn: opts.TTLColumn,
+		Merge:     opts.Merge,
+	})
 }
 
 func (s *Server) handleListTables(w http.ResponseWriter, r *http.Request) {
@@ -588,27 +1839,32 @@ func (s *Server) handleListTables(w http.ResponseWriter, r *http.Request) {
 	// Get store for user
 	store, err := s.getStoreForUser(r.Context(), user.ID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Failed to initialize storage")
+		writeStoreError(w, err, "Failed to initialize storage")
 		return
 	}
 
-	// Query all facts for the user and filter for table definitions
-	facts, err := store.QueryByTimeRange(r.Context(), time.Time{}, time.Now().UTC())
+	names, defs, err := s.visibleTables(r.Context(), store, user.ID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to get tables: %v", err))
+		writeStoreError(w, err, "Failed to get tables")
 		return
 	}
 
 	tables := []TableInfo{}
-	for _, fact := range facts {
-		// Only include facts that are table definitions
-		if fact.Namespace == user.ID && fact.DataType == "table" {
-			tables = append(tables, TableInfo{
-				Name:      fact.FieldName,
-				CreatedAt: fact.Timestamp,
-				Columns:   fact.Columns,
-			})
-		}
+	for _, name := range names {
+		def := latestTableDef(defs[name])
+		opts := tableOptionsOf(def)
+		tables = append(tables, TableInfo{
+			Name:      def.FieldName,
+			Type:      opts.Type,
+			CreatedAt: def.Timestamp,
+			Columns:   def.Columns,
+			Temporary: opts.ExpiresAt != nil,
+			ExpiresAt: opts.ExpiresAt,
+			Source:    opts.Source.info(),
+			TTLColumn: opts.TTLColumn,
+			Fork:      opts.Fork,
+			Merge:     opts.Merge,
+		})
 	}
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{"tables": tables})
@@ -628,88 +1884,134 @@ func (s *Server) handleCreateRow(w http.ResponseWriter, r *http.Request) {
 	// Get store for user
 	store, err := s.getStoreForUser(r.Context(), user.ID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Failed to initialize storage")
+		writeStoreError(w, err, "Failed to initialize storage")
 		return
 	}
 
 	// Validate table exists and get column definitions
 	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
-	if err != nil || len(facts) == 0 {
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
 		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
 		return
 	}
+	if rejectVirtualWrite(w, facts, table) {
+		return
+	}
 
-	tableDefinition := facts[0]
-	var columns []dynamo.ColumnDefinition
-	if len(tableDefinition.Columns) > 0 {
-		columns = tableDefinition.Columns
+	rowStore, err := s.getRowStore(r.Context(), store, user, table)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize table storage")
+		return
 	}
 
+	validator := s.newRowValidatorFor(r.Context(), store, user, facts)
+
 	var req struct {
 		ID     string                 `json:"id"`
 		Values map[string]interface{} `json:"values"`
+		// ValidTime backdates or postdates the row's values
+		ValidTime *time.Time `json:"validTime"`
+		// Timestamp is when the client made the write, for writes made
+		// offline; see clientDatedWrite
+		Timestamp *time.Time `json:"timestamp"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON: %v", err))
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	// Always auto-generate ID if not provided
 	if req.ID == "" {
 		req.ID = newID()
-		log.Printf("Auto-generated row ID: %s", req.ID)
+		s.logger.DebugContext(r.Context(), "auto-generated row ID", "row", req.ID)
 	}
 
 	if req.Values == nil {
-		writeError(w, http.StatusBadRequest, "Row values are required")
+		writeValidationError(w, "Row values are required", []FieldError{{Field: "values", Message: "is required"}})
 		return
 	}
+	validator.applyDefaults(req.Values)
 
-	// Validate values against column definitions if available
-	if len(columns) > 0 {
-		for colName, value := range req.Values {
-			// Check if column is defined
-			found := false
-			var colDef dynamo.ColumnDefinition
-
-			for _, col := range columns {
-				if col.Name == colName {
-					found = true
-					colDef = col
-					break
-				}
-			}
+	now, clientDated, ok := s.clientDatedWrite(w, r, rowStore, tableNamespace(user.ID, table), req.ID, req.Timestamp)
+	if !ok {
+		return
+	}
+	req.Values, ok = s.runAutomations(w, r, store, user.ID, facts, script.Event{
+		Type:      automationEventCreate,
+		Table:     table,
+		Row:       req.ID,
+		Timestamp: now,
+		Values:    req.Values,
+	})
+	if !ok {
+		return
+	}
 
-			if !found {
-				writeError(w, http.StatusBadRequest, fmt.Sprintf("Column '%s' is not defined in table schema", colName))
-				return
-			}
+	event := plugin.RowEvent{Type: "create", UserID: user.ID, Table: table, Row: req.ID, Timestamp: now, Values: req.Values}
+	if err := validator.validate(r.Context(), event); err != nil {
+		writeRowError(w, err, "")
+		return
+	}
+	createdAt, err := s.creationForCreate(r.Context(), rowStore, validator.def, tableNamespace(user.ID, table), req.ID, now)
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up row")
+		return
+	}
 
-			// Validate type according to column definition
-			valid := validateValueType(value, colDef.DataType)
-			if !valid {
-				writeError(w, http.StatusBadRequest, fmt.Sprintf("Value for column '%s' does not match expected type '%s'", colName, colDef.DataType))
-				return
-			}
+	fact := dynamo.Fact{
+		ID:          newID(),
+		Timestamp:   now,
+		Namespace:   tableNamespace(user.ID, table),
+		FieldName:   req.ID,
+		DataType:    "json",
+		Value:       req.Values,
+		ValidTime:   validTime(req.ValidTime),
+		ClientDated: clientDated,
+		CreatedAt:   createdAt,
+	}
+
+	if validator.options().Type == tableTypeSecrets {
+		if err := s.sealRowFact(r.Context(), &fact); err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encrypt row: %v", err))
+			return
 		}
 	}
 
-	fact := dynamo.Fact{
-		ID:        newID(),
-		Timestamp: time.Now().UTC(),
-		Namespace: fmt.Sprintf("%s/%s", user.ID, table),
-		FieldName: req.ID,
-		DataType:  "json",
-		Value:     req.Values,
+	if err := s.indexGeoPoints(r.Context(), store, user.ID, table, validator.columns, req.ID, req.Values, now); err != nil {
+		writeStoreError(w, err, "Failed to index row location")
+		return
+	}
+	if err := s.indexExpiry(r.Context(), store, user.ID, table, validator.def, req.ID, req.Values, now); err != nil {
+		writeStoreError(w, err, "Failed to index row expiry")
+		return
+	}
+	if err := s.indexReferences(r.Context(), store, user.ID, table, validator.columns, req.ID, req.Values, now); err != nil {
+		writeStoreError(w, err, "Failed to index row references")
+		return
+	}
+	if validator.options().mergesFields() {
+		if err := s.recordFieldWrites(r.Context(), store, user.ID, table, req.ID, fieldChanges(nil, req.Values), now); err != nil {
+			writeStoreError(w, err, "Failed to record row fields")
+			return
+		}
 	}
 
-	if err := store.PutFact(r.Context(), fact); err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create row: %v", err))
+	if err := rowStore.PutFact(r.Context(), fact); err != nil {
+		writeStoreError(w, err, "Failed to create row")
 		return
 	}
+	s.publishRowEvent(event)
 
-	writeJSON(w, http.StatusCreated, RowData{ID: req.ID, Timestamp: fact.Timestamp, Values: req.Values})
+	rows := []RowData{{ID: req.ID, Timestamp: fact.Timestamp, ValidTime: fact.ValidTime, CreatedAt: fact.CreatedAt, Values: req.Values}}
+	if err := s.addSystemColumns(r.Context(), store, rowStore, user, table, validator.def, now, rows); err != nil {
+		writeStoreError(w, err, "Failed to read row history")
+		return
+	}
+	writeJSON(w, http.StatusCreated, rows[0])
 }
 
 func (s *Server) handleTableSnapshot(w http.ResponseWriter, r *http.Request) {
@@ -724,44 +2026,95 @@ func (s *Server) handleTableSnapshot(w http.ResponseWriter, r *http.Request) {
 	// Get store for user
 	store, err := s.getStoreForUser(r.Context(), user.ID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Failed to initialize storage")
+		writeStoreError(w, err, "Failed to initialize storage")
 		return
 	}
 
 	// Validate table exists and get column definitions
 	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
-	if err != nil || len(facts) == 0 {
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
 		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
 		return
 	}
+	columns := latestTableDef(facts).Columns
+	rq, fields := parseRowQuery(r.URL.Query(), columns)
+	if len(fields) > 0 {
+		writeValidationError(w, "Invalid row query", fields)
+		return
+	}
+	times, err := parseSnapshotTimes(r.URL.Query(), time.Now().UTC())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if proxiedTable(facts) {
+		if times.past {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Table '%s' is proxied to its upstream and has no history; create it with 'materialize' to query past states", table))
+			return
+		}
+		rows, _, err := s.virtualRows(r.Context(), user.ID, table, latestTableDef(facts), false)
+		if err != nil {
+			writeVirtualError(w, err)
+			return
+		}
+		writeRows(w, rq.apply(columns, rows))
+		return
+	}
+
+	rowStore, err := s.getRowStore(r.Context(), store, user, table)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize table storage")
+		return
+	}
 
 	// We found the table definition, now get the snapshot
-	snap, err := store.GetSnapshot(r.Context(), time.Now().UTC())
+	def := latestTableDef(facts)
+	entries, err := s.snapshotEntries(r.Context(), store, rowStore, user, table, def, times)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to get rows: %v", err))
+		writeStoreError(w, err, "Failed to get rows")
+		return
+	}
+	if notModified(w, r, snapshotETag(def, entries, r.URL.RawQuery, secretsRevealed(r.Context()))) {
 		return
 	}
 
-	key := fmt.Sprintf("%s/%s", user.ID, table)
+	ttlColumn := tableOptionsOf(def).TTLColumn
 	rows := []RowData{}
-
-	if entries, ok := snap[key]; ok {
-		for id, fact := range entries {
-			if fact.DataType == "json" {
-				vals, ok := fact.Value.(map[string]interface{})
-				if !ok {
-					log.Printf("Warning: invalid data format for row '%s'", id)
-					continue
-				}
-				rows = append(rows, RowData{ID: id, Timestamp: fact.Timestamp, Values: vals})
+	for id, fact := range entries {
+		if fact.DataType == "json" {
+			vals, ok := fact.Value.(map[string]interface{})
+			if !ok {
+				s.logger.WarnContext(r.Context(), "invalid row data format", "table", table, "row", id)
+				continue
 			}
+			if rowExpired(ttlColumn, vals, times.rowsAt()) {
+				continue
+			}
+			rows = append(rows, RowData{ID: id, Timestamp: fact.Timestamp, ValidTime: fact.ValidTime, CreatedAt: fact.CreatedAt, Values: vals})
+		} else if fact.DataType == secretDataType {
+			vals, masked, err := s.revealSecret(r.Context(), fact)
+			if err != nil {
+				s.logger.WarnContext(r.Context(), "reading secret row failed", "table", table, "row", id, "error", err)
+				continue
+			}
+			rows = append(rows, RowData{ID: id, Timestamp: fact.Timestamp, ValidTime: fact.ValidTime, CreatedAt: fact.CreatedAt, Values: vals, Masked: masked})
 		}
 	}
+	if err := s.addSystemColumns(r.Context(), store, rowStore, user, table, def, times.asOf, rows); err != nil {
+		writeStoreError(w, err, "Failed to read row history")
+		return
+	}
 
-	writeJSON(w, http.StatusOK, map[string]interface{}{"rows": rows})
+	rows = rq.apply(columns, rows)
+	s.signAttachments(r.Context(), user.ID, table, columns, rows)
+	writeRows(w, rows)
 }
 
-func (s *Server) handleUpdateRow(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleGetRow(w http.ResponseWriter, r *http.Request) {
 	user, ok := auth.UserFromContext(r.Context())
 	if !ok {
 		writeError(w, http.StatusUnauthorized, "User not found in context")
@@ -774,40 +2127,90 @@ func (s *Server) handleUpdateRow(w http.ResponseWriter, r *http.Request) {
 	// Get store for user
 	store, err := s.getStoreForUser(r.Context(), user.ID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Failed to initialize storage")
+		writeStoreError(w, err, "Failed to initialize storage")
 		return
 	}
 
 	// Validate table exists
 	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
-	if err != nil || len(facts) == 0 {
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
 		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
 		return
 	}
+	if proxiedTable(facts) {
+		rows, _, err := s.virtualRows(r.Context(), user.ID, table, latestTableDef(facts), false)
+		if err != nil {
+			writeVirtualError(w, err)
+			return
+		}
+		for _, row := range rows {
+			if row.ID == rowID {
+				writeJSON(w, http.StatusOK, row)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Row '%s' not found in table '%s'", rowID, table))
+		return
+	}
 
-	snap, err := store.GetSnapshot(r.Context(), time.Now().UTC())
+	rowStore, err := s.getRowStore(r.Context(), store, user, table)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to get snapshot: %v", err))
+		writeStoreError(w, err, "Failed to initialize table storage")
 		return
 	}
 
-	key := fmt.Sprintf("%s/%s", user.ID, table)
-	if entries, ok := snap[key]; ok {
-		if fact, ok := entries[rowID]; ok && fact.DataType == "json" {
-			vals, ok := fact.Value.(map[string]interface{})
-			if !ok {
-				writeError(w, http.StatusInternalServerError, "Invalid row data format")
-				return
-			}
-			writeJSON(w, http.StatusOK, RowData{ID: rowID, Timestamp: fact.Timestamp, Values: vals})
+	now := time.Now().UTC()
+	def := latestTableDef(facts)
+	entries, err := s.tableEntries(r.Context(), store, rowStore, user, table, def, now)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get snapshot")
+		return
+	}
+
+	var row RowData
+	if fact, ok := entries[rowID]; ok && fact.DataType == "json" {
+		vals, ok := fact.Value.(map[string]interface{})
+		if !ok {
+			writeError(w, http.StatusInternalServerError, "Invalid row data format")
+			return
+		}
+		if rowExpired(tableOptionsOf(def).TTLColumn, vals, now) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Row '%s' not found in table '%s'", rowID, table))
 			return
 		}
+		row = RowData{ID: rowID, Timestamp: fact.Timestamp, ValidTime: fact.ValidTime, CreatedAt: fact.CreatedAt, Values: vals}
+	} else if ok && fact.DataType == secretDataType {
+		vals, masked, err := s.revealSecret(r.Context(), fact)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to read secret: %v", err))
+			return
+		}
+		row = RowData{ID: rowID, Timestamp: fact.Timestamp, ValidTime: fact.ValidTime, CreatedAt: fact.CreatedAt, Values: vals, Masked: masked}
+	} else {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Row '%s' not found in table '%s'", rowID, table))
+		return
 	}
 
-	writeError(w, http.StatusNotFound, fmt.Sprintf("Row '%s' not found in table '%s'", rowID, table))
+	rows := []RowData{row}
+	if err := s.addSystemColumns(r.Context(), store, rowStore, user, table, def, now, rows); err != nil {
+		writeStoreError(w, err, "Failed to read row history")
+		return
+	}
+	s.signAttachments(r.Context(), user.ID, table, def.Columns, rows)
+	writeJSON(w, http.StatusOK, rows[0])
 }
 
-func (s *Server) handleGetRow(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleUpdateRow(w http.ResponseWriter, r *http.Request) {
+	s.updateRow(w, r, false)
+}
+
+// updateRow replaces a row's values or, when patch is set, merges the
+// request's values into them
+func (s *Server) updateRow(w http.ResponseWriter, r *http.Request, patch bool) {
 	user, ok := auth.UserFromContext(r.Context())
 	if !ok {
 		writeError(w, http.StatusUnauthorized, "User not found in context")
@@ -820,64 +2223,217 @@ func (s *Server) handleGetRow(w http.ResponseWriter, r *http.Request) {
 	// Get store for user
 	store, err := s.getStoreForUser(r.Context(), user.ID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Failed to initialize storage")
+		writeStoreError(w, err, "Failed to initialize storage")
 		return
 	}
 
 	// Validate table exists
 	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
-	if err != nil || len(facts) == 0 {
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
 		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
 		return
 	}
+	if rejectVirtualWrite(w, facts, table) {
+		return
+	}
 
-	// Validate row exists
-	key := fmt.Sprintf("%s/%s", user.ID, table)
-	snap, err := store.GetSnapshot(r.Context(), time.Now().UTC())
+	rowStore, err := s.getRowStore(r.Context(), store, user, table)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to get snapshot: %v", err))
+		writeStoreError(w, err, "Failed to initialize table storage")
 		return
 	}
 
-	rowExists := false
-	if entries, ok := snap[key]; ok {
-		_, rowExists = entries[rowID]
+	validator := s.newRowValidatorFor(r.Context(), store, user, facts)
+
+	// Validate row exists
+	entries, err := s.tableEntries(r.Context(), store, rowStore, user, table, validator.def, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to get snapshot")
+		return
 	}
 
+	current, rowExists := entries[rowID]
 	if !rowExists {
 		writeError(w, http.StatusNotFound, fmt.Sprintf("Row '%s' not found in table '%s'", rowID, table))
 		return
 	}
 
 	var req struct {
-		Values map[string]interface{} `json:"values"`
+		Values    map[string]interface{} `json:"values"`
+		ValidTime *time.Time             `json:"validTime"`
+		Timestamp *time.Time             `json:"timestamp"`
+		// BaseTimestamp is the timestamp of the version of the row the
+		// update was made on, for tables that merge by field
+		BaseTimestamp *time.Time `json:"baseTimestamp"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON: %v", err))
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	if req.Values == nil {
-		writeError(w, http.StatusBadRequest, "Row values are required")
+		writeValidationError(w, "Row values are required", []FieldError{{Field: "values", Message: "is required"}})
+		return
+	}
+	fieldMerge := validator.options().mergesFields()
+	if req.BaseTimestamp != nil && (patch || !fieldMerge) {
+		writeValidationError(w, "Invalid row update", []FieldError{{Field: "baseTimestamp", Message: "is only allowed when replacing rows of tables that merge by field"}})
 		return
 	}
+	if patch {
+		values, ok := s.patchTarget(w, r, current, rowID, table)
+		if !ok {
+			return
+		}
+		req.Values = mergePatch(values, req.Values)
+		// A patch names the columns it changes, so it is made on the
+		// current version
+		req.BaseTimestamp = &current.Timestamp
+	}
+
+	// Client-dated writes to tables that merge by field may be earlier than
+	// later changes of the row, except its deletion
+	namespace := tableNamespace(user.ID, table)
+	order := func(at, now time.Time) error {
+		if fieldMerge {
+			return s.checkDeleteOrder(r.Context(), rowStore, namespace, rowID, at, now)
+		}
+		return s.checkRowOrder(r.Context(), rowStore, namespace, rowID, at, now)
+	}
+	now, clientDated, ok := s.resolveWriteTime(w, req.Timestamp, order)
+	if !ok {
+		return
+	}
+	at := now
+	requested := req.Values
+	var base map[string]interface{}
+	if fieldMerge {
+		base, err = mergeBase(r.Context(), rowStore, namespace, current, req.BaseTimestamp, at)
+		if err != nil {
+			writeStoreError(w, err, "Failed to read row history")
+			return
+		}
+	}
 
-	fact := dynamo.Fact{
-		ID:        newID(),
-		Timestamp: time.Now().UTC(),
-		Namespace: fmt.Sprintf("%s/%s", user.ID, table),
-		FieldName: rowID,
-		DataType:  "json",
-		Value:     req.Values,
+	// Updates of field-merge tables are written on the version they were
+	// merged into, and merged again into versions written in the meantime
+	var fact dynamo.Fact
+	var event plugin.RowEvent
+	var changed []string
+	for attempt := 1; ; attempt++ {
+		if fieldMerge {
+			req.Values, changed, err = s.mergeFields(r.Context(), store, user.ID, table, rowID, previousValues(current), base, requested, at, time.Now().UTC())
+			if err != nil {
+				writeStoreError(w, err, "Failed to read row fields")
+				return
+			}
+			// A write made before the row's latest version is merged into a
+			// new version after it, which takes effect at the write's time
+			if !at.After(current.Timestamp) {
+				now, clientDated = time.Now().UTC(), false
+				if req.ValidTime == nil {
+					req.ValidTime = &at
+				}
+			}
+		}
+		req.Values, ok = s.runAutomations(w, r, store, user.ID, facts, script.Event{
+			Type:      automationEventUpdate,
+			Table:     table,
+			Row:       rowID,
+			Timestamp: now,
+			Values:    req.Values,
+			Previous:  previousValues(current),
+		})
+		if !ok {
+			return
+		}
+
+		event = plugin.RowEvent{Type: "update", UserID: user.ID, Table: table, Row: rowID, Timestamp: now, Values: req.Values}
+		if err := validator.validate(r.Context(), event); err != nil {
+			writeRowError(w, err, "")
+			return
+		}
+
+		fact = dynamo.Fact{
+			ID:          newID(),
+			Timestamp:   now,
+			Namespace:   tableNamespace(user.ID, table),
+			FieldName:   rowID,
+			DataType:    "json",
+			Value:       req.Values,
+			ValidTime:   validTime(req.ValidTime),
+			ClientDated: clientDated,
+			CreatedAt:   carriedCreation(current, true, now),
+		}
+
+		if validator.options().Type == tableTypeSecrets {
+			if err := s.sealRowFact(r.Context(), &fact); err != nil {
+				writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encrypt row: %v", err))
+				return
+			}
+		}
+
+		if !fieldMerge {
+			if err := rowStore.PutFact(r.Context(), fact); err != nil {
+				writeStoreError(w, err, "Failed to update row")
+				return
+			}
+			break
+		}
+		written, err := putRowVersion(r.Context(), rowStore, validator.def, user.ID, table, rowID, current, fact)
+		if err != nil {
+			writeStoreError(w, err, "Failed to update row")
+			return
+		}
+		if written {
+			break
+		}
+		if attempt == maxMergeAttempts {
+			writeError(w, http.StatusConflict, fmt.Sprintf("Row '%s' is being changed too often to merge the update; try again", rowID))
+			return
+		}
+		latest, found, err := s.latestRowFact(r.Context(), rowStore, namespace, rowID, time.Now().UTC())
+		if err != nil {
+			writeStoreError(w, err, "Failed to get row")
+			return
+		}
+		if !found || !rowLive(latest) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Row '%s' not found in table '%s'", rowID, table))
+			return
+		}
+		current = latest
 	}
 
-	if err := store.PutFact(r.Context(), fact); err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to update row: %v", err))
+	// Indexes are written once the row is, so an update merged again
+	// leaves none behind for the values it first had
+	if err := s.indexGeoPoints(r.Context(), store, user.ID, table, validator.columns, rowID, req.Values, now); err != nil {
+		writeStoreError(w, err, "Failed to index row location")
+		return
+	}
+	if err := s.indexExpiry(r.Context(), store, user.ID, table, validator.def, rowID, req.Values, now); err != nil {
+		writeStoreError(w, err, "Failed to index row expiry")
 		return
 	}
+	if err := s.indexReferences(r.Context(), store, user.ID, table, validator.columns, rowID, req.Values, now); err != nil {
+		writeStoreError(w, err, "Failed to index row references")
+		return
+	}
+	if err := s.recordFieldWrites(r.Context(), store, user.ID, table, rowID, changed, at); err != nil {
+		writeStoreError(w, err, "Failed to record row fields")
+		return
+	}
+	s.publishRowEvent(event)
 
-	writeJSON(w, http.StatusOK, RowData{ID: rowID, Timestamp: fact.Timestamp, Values: req.Values})
+	rows := []RowData{{ID: rowID, Timestamp: fact.Timestamp, ValidTime: fact.ValidTime, CreatedAt: fact.CreatedAt, Values: req.Values}}
+	if err := s.addSystemColumns(r.Context(), store, rowStore, user, table, validator.def, time.Now().UTC(), rows); err != nil {
+		writeStoreError(w, err, "Failed to read row history")
+		return
+	}
+	writeJSON(w, http.StatusOK, rows[0])
 }
 
 func (s *Server) handleDeleteRow(w http.ResponseWriter, r *http.Request) {
@@ -893,31 +2449,70 @@ func (s *Server) handleDeleteRow(w http.ResponseWriter, r *http.Request) {
 	// Get store for user
 	store, err := s.getStoreForUser(r.Context(), user.ID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Failed to initialize storage")
+		writeStoreError(w, err, "Failed to initialize storage")
 		return
 	}
 
 	// Validate table exists and get column definitions
 	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
-	if err != nil || len(facts) == 0 {
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
 		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
 		return
 	}
+	if rejectVirtualWrite(w, facts, table) {
+		return
+	}
 
-	fact := dynamo.Fact{
-		ID:        newID(),
-		Timestamp: time.Now().UTC(),
-		Namespace: fmt.Sprintf("%s/%s", user.ID, table),
-		FieldName: rowID,
-		DataType:  "json",
-		Value:     nil,
+	rowStore, err := s.getRowStore(r.Context(), store, user, table)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize table storage")
+		return
 	}
 
-	if err := store.PutFact(r.Context(), fact); err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to delete row: %v", err))
+	planner := s.newDeletePlanner(r.Context(), store, user)
+	planner.stores[table] = rowStore
+	rows, err := planner.plan(table, rowID)
+	if err != nil {
+		if !writeRefusedDelete(w, err) {
+			writeStoreError(w, err, "Failed to check references")
+		}
+		return
+	}
+
+	// A client-dated delete must follow the changes of every row it deletes
+	now, clientDated, ok := s.deleteTime(w, r, planner, user.ID, rows)
+	if !ok {
 		return
 	}
 
+	// Cascaded rows go first, so a failure part way leaves the row that
+	// was asked for in place and the delete can be retried
+	for i := len(rows) - 1; i >= 0; i-- {
+		target := rows[i]
+		targetStore, err := planner.rowStore(target.table)
+		if err != nil {
+			writeStoreError(w, err, "Failed to initialize table storage")
+			return
+		}
+		fact := dynamo.Fact{
+			ID:          newID(),
+			Timestamp:   now,
+			Namespace:   tableNamespace(user.ID, target.table),
+			FieldName:   target.row,
+			DataType:    dynamo.DataTypeDeleted,
+			ClientDated: clientDated,
+		}
+		if err := targetStore.PutFact(r.Context(), fact); err != nil {
+			writeStoreError(w, err, "Failed to delete row")
+			return
+		}
+		s.publishRowEvent(plugin.RowEvent{Type: "delete", UserID: user.ID, Table: target.table, Row: target.row, Timestamp: now})
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -933,53 +2528,98 @@ func (s *Server) handleListRows(w http.ResponseWriter, r *http.Request) {
 	// Get store for user
 	store, err := s.getStoreForUser(r.Context(), user.ID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Failed to initialize storage")
+		writeStoreError(w, err, "Failed to initialize storage")
 		return
 	}
 
 	// Validate table exists
 	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
-	if err != nil || len(facts) == 0 {
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
 		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
 		return
 	}
+	columns := latestTableDef(facts).Columns
+	rq, fields := parseRowQuery(r.URL.Query(), columns)
+	if len(fields) > 0 {
+		writeValidationError(w, "Invalid row query", fields)
+		return
+	}
 
-	q := r.URL.Query()
-	atParam := q.Get("at")
-	var at time.Time
-	if atParam == "" {
-		at = time.Now().UTC()
-	} else {
-		var err error
-		at, err = time.Parse(time.RFC3339, atParam)
+	rowStore, err := s.getRowStore(r.Context(), store, user, table)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize table storage")
+		return
+	}
+
+	times, err := parseSnapshotTimes(r.URL.Query(), time.Now().UTC())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	at := times.asOf
+
+	if proxiedTable(facts) {
+		if times.past {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Table '%s' is proxied to its upstream and has no history; create it with 'materialize' to query past states", table))
+			return
+		}
+		rows, _, err := s.virtualRows(r.Context(), user.ID, table, latestTableDef(facts), false)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'at' time format: %v (expected RFC3339)", err))
+			writeVirtualError(w, err)
 			return
 		}
+		writeRows(w, rq.apply(columns, rows))
+		return
 	}
 
-	snap, err := store.GetSnapshot(r.Context(), at)
+	entries, err := s.snapshotEntries(r.Context(), store, rowStore, user, table, latestTableDef(facts), times)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to get snapshot: %v", err))
+		writeStoreError(w, err, "Failed to get snapshot")
+		return
+	}
+	if notModified(w, r, snapshotETag(latestTableDef(facts), entries, r.URL.RawQuery, secretsRevealed(r.Context()))) {
+		return
+	}
+
+	rows := s.snapshotRows(r.Context(), table, tableOptionsOf(latestTableDef(facts)).TTLColumn, entries, times.rowsAt())
+	if err := s.addSystemColumns(r.Context(), store, rowStore, user, table, latestTableDef(facts), at, rows); err != nil {
+		writeStoreError(w, err, "Failed to read row history")
 		return
 	}
+	rows = rq.apply(columns, rows)
+	s.signAttachments(r.Context(), user.ID, table, columns, rows)
+	writeRows(w, rows)
+}
 
-	key := fmt.Sprintf("%s/%s", user.ID, table)
+// snapshotRows turns a table's snapshot entries into rows, leaving out
+// deleted rows and rows expired at the snapshot time
+func (s *Server) snapshotRows(ctx context.Context, table, ttlColumn string, entries map[string]dynamo.Fact, at time.Time) []RowData {
 	rows := []RowData{}
-	if entries, ok := snap[key]; ok {
-		for id, fact := range entries {
-			if fact.DataType == "json" {
-				vals, ok := fact.Value.(map[string]interface{})
-				if !ok {
-					log.Printf("Warning: invalid data format for row '%s' in snapshot", id)
-					continue
-				}
-				rows = append(rows, RowData{ID: id, Timestamp: fact.Timestamp, Values: vals})
+	for id, fact := range entries {
+		if fact.DataType == "json" {
+			vals, ok := fact.Value.(map[string]interface{})
+			if !ok {
+				s.logger.WarnContext(ctx, "invalid row data format in snapshot", "table", table, "row", id)
+				continue
 			}
+			if rowExpired(ttlColumn, vals, at) {
+				continue
+			}
+			rows = append(rows, RowData{ID: id, Timestamp: fact.Timestamp, ValidTime: fact.ValidTime, CreatedAt: fact.CreatedAt, Values: vals})
+		} else if fact.DataType == secretDataType {
+			vals, masked, err := s.revealSecret(ctx, fact)
+			if err != nil {
+				s.logger.WarnContext(ctx, "reading secret row failed", "table", table, "row", id, "error", err)
+				continue
+			}
+			rows = append(rows, RowData{ID: id, Timestamp: fact.Timestamp, ValidTime: fact.ValidTime, CreatedAt: fact.CreatedAt, Values: vals, Masked: masked})
 		}
 	}
-
-	writeJSON(w, http.StatusOK, map[string]interface{}{"rows": rows})
+	return rows
 }
 
 // handleTableSnapshot returns a snapshot of a table at a given point in time
@@ -996,17 +2636,27 @@ func (s *Server) handleTableHistory(w http.ResponseWriter, r *http.Request) {
 	// Get store for user
 	store, err := s.getStoreForUser(r.Context(), user.ID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Failed to initialize storage")
+		writeStoreError(w, err, "Failed to initialize storage")
 		return
 	}
 
 	// Validate table exists
 	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
-	if err != nil || len(facts) == 0 {
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
 		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
 		return
 	}
 
+	rowStore, err := s.getRowStore(r.Context(), store, user, table)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize table storage")
+		return
+	}
+
 	q := r.URL.Query()
 	startParam := q.Get("start")
 	if startParam == "" {
@@ -1038,25 +2688,74 @@ func (s *Server) handleTableHistory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	facts, err = store.QueryByTimeRange(r.Context(), start, end)
+	switch q.Get("format") {
+	case "":
+	case historyFormatDebezium:
+		if tableOptionsOf(latestTableDef(facts)).Type == tableTypeSecrets && !secretsRevealed(r.Context()) {
+			writeError(w, http.StatusForbidden, "Exporting changes of a secrets table needs the secrets:read scope")
+			return
+		}
+		s.writeChangeFeed(w, r, store, rowStore, user, table, latestTableDef(facts), start, end)
+		return
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Unknown history format '%s'", q.Get("format")))
+		return
+	}
+	types, err := parseEventTypes(q.Get("types"))
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to query time range: %v", err))
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'types' parameter: %v", err))
 		return
 	}
 
-	events := []RowEvent{}
-	prefix := fmt.Sprintf("%s/%s", user.ID, table)
+	// The rows live just before start tell the first write of each row in
+	// the range apart as an update
+	live := rowStates{}
+	if !start.IsZero() {
+		entries, err := s.tableEntries(r.Context(), store, rowStore, user, table, latestTableDef(facts), start.Add(-time.Nanosecond))
+		if err != nil {
+			writeStoreError(w, err, "Failed to read rows before the time range")
+			return
+		}
+		for id, f := range entries {
+			if rowLive(f) {
+				live[id] = true
+			}
+		}
+	}
 
-	for _, f := range facts {
-		if f.Namespace == prefix && f.DataType == "json" {
-			vals, ok := f.Value.(map[string]interface{})
-			if !ok && f.Value != nil {
-				log.Printf("Warning: invalid data format for row '%s' in history", f.FieldName)
+	// Events are streamed as the windows of the range are read. Once some
+	// have been sent, a failure can only cut the response short.
+	prefix := tableNamespace(user.ID, table)
+	stream := newArrayStream(w, "events")
+	err = s.streamHistory(r.Context(), store, rowStore, user, table, latestTableDef(facts), start, end, func(batch []dynamo.Fact) error {
+		for _, f := range batch {
+			if f.Namespace != prefix {
+				continue
+			}
+			ev, ok := s.rowEvent(r.Context(), table, f)
+			if !ok {
+				continue
+			}
+			ev.Type = live.eventType(f.FieldName, f.IsTombstone())
+			if types != nil && !types[ev.Type] {
 				continue
 			}
-			events = append(events, RowEvent{ID: f.FieldName, Timestamp: f.Timestamp, Values: vals})
+			if err := stream.add(ev); err != nil {
+				return err
+			}
 		}
+		stream.flush()
+		return nil
+	})
+	if err == nil {
+		err = stream.close()
+	}
+	if err != nil {
+		if !stream.started {
+			writeStoreError(w, err, "Failed to query time range")
+			return
+		}
+		s.logger.ErrorContext(r.Context(), "streaming table history failed", "table", table, "error", err)
+		panic(http.ErrAbortHandler)
 	}
-
-	writeJSON(w, http.StatusOK, map[string]interface{}{"events": events})
 }
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/logging"
+)
+
+// Resources a share link can grant
+const (
+	shareSnapshot = "snapshot"
+	shareHistory  = "history"
+)
+
+const (
+	// defaultShareLinkTTL is how long a share link lasts when no lifetime is given
+	defaultShareLinkTTL = time.Hour
+	// maxShareLinkTTL bounds the lifetime of share links
+	maxShareLinkTTL = 7 * 24 * time.Hour
+)
+
+// ShareLink is a signed URL granting unauthenticated read access to one
+// resource of a table until it expires
+type ShareLink struct {
+	URL       string    `json:"url"`
+	Resource  string    `json:"resource"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// newShareKey returns the key share links are signed with. Without a
+// configured secret a random key is used, and links stop working when the
+// server restarts.
+func newShareKey(config Config) ([]byte, bool) {
+	if config.ShareLinkSecret != "" {
+		return []byte(config.ShareLinkSecret), true
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err) // This should never happen
+	}
+	return key, false
+}
+
+// shareLinkSecretFromEnv reads NOTABLY_SHARE_LINK_SECRET
+func shareLinkSecretFromEnv() string {
+	return os.Getenv("NOTABLY_SHARE_LINK_SECRET")
+}
+
+// signShareLink signs a resource of a user's table together with every
+// query parameter of the link, so none can be changed or added
+func (s *Server) signShareLink(userID, table, resource string, query url.Values) string {
+	mac := hmac.New(sha256.New, s.shareKey)
+	fmt.Fprintf(mac, "%s\x00%s\x00%s\x00%s", userID, table, resource, query.Encode())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Server) handleCreateShareLink(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	table := r.PathValue("table")
+
+	var req struct {
+		Resource  string `json:"resource"`
+		ExpiresIn string `json:"expiresIn"`
+		// Start and End bound the facts of a history link
+		Start string `json:"start"`
+		End   string `json:"end"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	var fields fieldErrors
+	query := url.Values{}
+	switch req.Resource {
+	case "", shareSnapshot:
+		req.Resource = shareSnapshot
+	case shareHistory:
+		for _, p := range []struct{ name, value string }{{"start", req.Start}, {"end", req.End}} {
+			if _, err := time.Parse(time.RFC3339, p.value); err != nil {
+				fields = append(fields, FieldError{Field: p.name, Message: "must be an RFC3339 time"})
+			}
+			query.Set(p.name, p.value)
+		}
+	default:
+		fields = append(fields, FieldError{Field: "resource", Message: "must be snapshot or history"})
+	}
+	ttl := defaultShareLinkTTL
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 || d > maxShareLinkTTL {
+			fields = append(fields, FieldError{Field: "expiresIn", Message: fmt.Sprintf("must be a positive duration of at most %s", maxShareLinkTTL)})
+		}
+		ttl = d
+	}
+	if len(fields) > 0 {
+		writeValidationError(w, "Invalid share link", fields)
+		return
+	}
+
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+	opts := tableOptionsOf(latestTableDef(facts))
+	switch {
+	case opts.Type == tableTypeSecrets:
+		writeError(w, http.StatusBadRequest, "Secrets tables cannot be shared")
+		return
+	case opts.Session != "":
+		writeError(w, http.StatusBadRequest, "Temporary tables cannot be shared")
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(ttl).Truncate(time.Second)
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("sig", s.signShareLink(user.ID, table, req.Resource, query))
+	path := fmt.Sprintf("%s/shared/%s/%s/%s", apiVersion, url.PathEscape(user.ID), url.PathEscape(table), req.Resource)
+	writeJSON(w, http.StatusCreated, ShareLink{URL: externalURL(r, path, query), Resource: req.Resource, ExpiresAt: expiresAt})
+}
+
+// requireShareLink authorizes a request for a resource of a table by the
+// signature of its share link, in place of an API key. The handler runs as
+// the user who created the link.
+func (s *Server) requireShareLink(resource string, h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, table := r.PathValue("user"), r.PathValue("table")
+		query := r.URL.Query()
+		sig, err := base64.RawURLEncoding.DecodeString(query.Get("sig"))
+		query.Del("sig")
+		want, _ := base64.RawURLEncoding.DecodeString(s.signShareLink(userID, table, resource, query))
+		if err != nil || !hmac.Equal(sig, want) {
+			writeError(w, http.StatusForbidden, "Invalid share link")
+			return
+		}
+		expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+		if err != nil || !time.Now().Before(time.Unix(expires, 0)) {
+			writeError(w, http.StatusForbidden, "Share link has expired")
+			return
+		}
+
+		user, err := s.userStore.GetUserByID(r.Context(), userID)
+		if err != nil {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+			return
+		}
+		logging.SetUser(r.Context(), user.ID)
+		h(w, r.WithContext(auth.ContextWithUser(r.Context(), user)))
+	})
+}
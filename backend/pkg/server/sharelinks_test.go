@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShareLinks(t *testing.T) {
+	srv, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "prices"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/prices/rows", `{"id": "p1", "values": {"usd": 3}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	share := func(body string) ShareLink {
+		rec := do(http.MethodPost, "/v1/tables/prices/share-links", body)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		var link ShareLink
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &link))
+		return link
+	}
+	// open requests a link without credentials
+	open := func(link string) *httptest.ResponseRecorder {
+		u, err := url.Parse(link)
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, u.RequestURI(), nil))
+		return rec
+	}
+
+	link := share(`{"expiresIn": "10m"}`)
+	assert.Equal(t, shareSnapshot, link.Resource)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), link.ExpiresAt, 2*time.Second)
+	rec = open(link.URL)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"usd":3`)
+
+	// Tampering with the link breaks its signature
+	u, _ := url.Parse(link.URL)
+	q := u.Query()
+	q.Set("expires", "9999999999")
+	u.RawQuery = q.Encode()
+	assert.Equal(t, http.StatusForbidden, open(u.String()).Code)
+	assert.Equal(t, http.StatusForbidden, open(u.Scheme+"://"+u.Host+"/v1/shared/x/prices/snapshot").Code)
+
+	start := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	end := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	history := share(`{"resource": "history", "start": "` + start + `", "end": "` + end + `"}`)
+	rec = open(history.URL)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), "p1")
+
+	// A snapshot link does not open the history
+	u, _ = url.Parse(link.URL)
+	u.Path = u.Path[:len(u.Path)-len(shareSnapshot)] + shareHistory
+	assert.Equal(t, http.StatusForbidden, open(u.String()).Code)
+
+	// Expired links are refused
+	user, err := srv.userStore.GetUserByUsername(context.Background(), "alice")
+	require.NoError(t, err)
+	q = url.Values{"expires": {"1"}}
+	q.Set("sig", srv.signShareLink(user.ID, "prices", shareSnapshot, q))
+	rec = open("/v1/shared/" + user.ID + "/prices/snapshot?" + q.Encode())
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "expired")
+
+	for _, body := range []string{`{"resource": "rows"}`, `{"expiresIn": "720h"}`, `{"resource": "history", "start": "yesterday"}`} {
+		rec := do(http.MethodPost, "/tables/prices/share-links", body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/tables/missing/share-links", `{}`).Code)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/sqlquery"
+)
+
+// handleSQLQuery runs a read-only SQL query over one table's snapshot, at
+// the current time or the query's AS OF TIMESTAMP
+func (s *Server) handleSQLQuery(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	var req struct {
+		SQL string `json:"sql"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.SQL) == "" {
+		writeValidationError(w, "Invalid query", []FieldError{{Field: "sql", Message: "is required"}})
+		return
+	}
+	q, err := sqlquery.Parse(req.SQL)
+	if err != nil {
+		writeValidationError(w, "Invalid query", []FieldError{{Field: "sql", Message: err.Error()}})
+		return
+	}
+	plan, err := sqlquery.NewPlan(q)
+	if err != nil {
+		writeValidationError(w, "Invalid query", []FieldError{{Field: "sql", Message: err.Error()}})
+		return
+	}
+
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	facts, err := store.QueryByField(r.Context(), user.ID, q.Table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", q.Table))
+		return
+	}
+	def := latestTableDef(facts)
+	if tableOptionsOf(def).Type == tableTypeSecrets {
+		writeError(w, http.StatusBadRequest, "Rows of secrets tables are encrypted and cannot be queried")
+		return
+	}
+
+	// Tables with a schema only take queries of their own columns
+	types := make(map[string]dynamo.ColumnDefinition, len(def.Columns))
+	names := make([]string, len(def.Columns))
+	for i, col := range def.Columns {
+		types[col.Name] = col
+		names[i] = col.Name
+	}
+	if len(def.Columns) > 0 {
+		var fields fieldErrors
+		for _, name := range plan.Columns() {
+			if _, ok := types[name]; !ok && name != sqlquery.RowID && !isSystemColumn(name) {
+				fields = append(fields, FieldError{Field: "sql", Message: fmt.Sprintf("unknown column '%s'", name)})
+			}
+		}
+		if len(fields) > 0 {
+			writeValidationError(w, "Invalid query", fields)
+			return
+		}
+	}
+	if plan.Aggregated {
+		if fields := checkAggregate(def.Columns, q.GroupBy, plan.Metrics); len(fields) > 0 {
+			writeValidationError(w, "Invalid query", fields)
+			return
+		}
+	}
+
+	at := q.AsOf
+	rows, ok := s.tableRowsAt(w, r, store, user, q.Table, facts, at)
+	if !ok {
+		return
+	}
+	if at.IsZero() {
+		at = time.Now().UTC()
+	}
+	values := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		// Queries also see the row ID and the system columns
+		vals := make(map[string]interface{}, len(row.Values)+4)
+		for k, v := range systemValues(row) {
+			vals[k] = v
+		}
+		vals[sqlquery.RowID] = row.ID
+		values[i] = vals
+	}
+	res, err := plan.Execute(values, sqlquery.Options{
+		Columns: names,
+		SortValue: func(column string, v interface{}) interface{} {
+			return sortValue(types[column], v)
+		},
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Failed to run query: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"at":      at,
+		"columns": res.Columns,
+		"rows":    res.Rows,
+	})
+}
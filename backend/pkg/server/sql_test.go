@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLQuery(t *testing.T) {
+	_, do := memoryServer(t)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "orders", "columns": [
+		{"name": "status", "dataType": "enum", "enum": ["new", "paid", "shipped"]},
+		{"name": "total", "dataType": "number"},
+		{"name": "price", "dataType": "decimal", "scale": 2, "nullable": true}
+	]}`).Code)
+	for _, body := range []string{
+		`{"id": "o1", "values": {"status": "shipped", "total": 10, "price": "9.50"}}`,
+		`{"id": "o2", "values": {"status": "new", "total": 20, "price": "10.25"}}`,
+	} {
+		require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/orders/rows", body).Code)
+	}
+	before := time.Now().UTC()
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/orders/rows", `{"id": "o3", "values": {"status": "paid", "total": 30, "price": "100.00"}}`).Code)
+
+	type result struct {
+		At      time.Time       `json:"at"`
+		Columns []string        `json:"columns"`
+		Rows    [][]interface{} `json:"rows"`
+	}
+	run := func(sql string) result {
+		t.Helper()
+		body, err := json.Marshal(map[string]string{"sql": sql})
+		require.NoError(t, err)
+		rec := do(http.MethodPost, "/query", string(body))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var res result
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		return res
+	}
+
+	res := run("SELECT id, status FROM orders WHERE total >= 20 ORDER BY status")
+	assert.Equal(t, []string{"id", "status"}, res.Columns)
+	assert.Equal(t, [][]interface{}{{"o2", "new"}, {"o3", "paid"}}, res.Rows, "enums order as declared")
+
+	res = run("SELECT * FROM orders WHERE id = 'o1'")
+	assert.Equal(t, []string{"id", "status", "total", "price"}, res.Columns)
+	assert.Equal(t, [][]interface{}{{"o1", "shipped", float64(10), "9.50"}}, res.Rows)
+
+	at := before.Format(time.RFC3339Nano)
+	res = run("SELECT count(*) AS n, sum(price) FROM orders AS OF TIMESTAMP '" + at + "'")
+	assert.Equal(t, []string{"n", "sum(price)"}, res.Columns)
+	assert.Equal(t, [][]interface{}{{float64(2), "19.75"}}, res.Rows, "decimals are summed exactly, as of the time")
+	assert.True(t, res.At.Equal(before))
+
+	res = run("SELECT id FROM orders WHERE _createdAt IS NOT NULL ORDER BY price DESC LIMIT 1")
+	assert.Equal(t, [][]interface{}{{"o3"}}, res.Rows, "decimals order by value")
+
+	for _, sql := range []string{
+		"",
+		"DELETE FROM orders",
+		"SELECT missing FROM orders",
+		"SELECT id FROM orders WHERE missing = 1",
+		"SELECT sum(status) FROM orders",
+		"SELECT status, count(*) FROM orders",
+	} {
+		body, err := json.Marshal(map[string]string{"sql": sql})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/query", string(body)).Code, sql)
+	}
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/query", `{"sql": "SELECT * FROM missing"}`).Code)
+}
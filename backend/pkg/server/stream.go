@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// streamFlushEvery is how many array elements a streamed response writes
+// between flushes to the client
+const streamFlushEvery = 500
+
+// arrayStream writes a {"<key>": [...]} response one element at a time, so
+// large results are never encoded in memory whole, flushing every
+// streamFlushEvery elements. Nothing is written before the first element or
+// close, so an error until then can still be reported with a status. The
+// output is the same as writeJSON gives for the whole object.
+type arrayStream struct {
+	w       http.ResponseWriter
+	key     string
+	started bool
+	count   int
+}
+
+func newArrayStream(w http.ResponseWriter, key string) *arrayStream {
+	return &arrayStream{w: w, key: key}
+}
+
+func (as *arrayStream) start() error {
+	if as.started {
+		return nil
+	}
+	as.started = true
+	as.w.Header().Set("Content-Type", "application/json")
+	as.w.WriteHeader(http.StatusOK)
+	key, err := json.Marshal(as.key)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(as.w, "{"+string(key)+":[")
+	return err
+}
+
+// add writes one element of the array
+func (as *arrayStream) add(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := as.start(); err != nil {
+		return err
+	}
+	if as.count > 0 {
+		data = append([]byte{','}, data...)
+	}
+	if _, err := as.w.Write(data); err != nil {
+		return err
+	}
+	as.count++
+	if as.count%streamFlushEvery == 0 {
+		as.flush()
+	}
+	return nil
+}
+
+// flush sends what has been written so far. Writers that cannot flush still
+// deliver the whole response at the end.
+func (as *arrayStream) flush() {
+	if as.started {
+		_ = http.NewResponseController(as.w).Flush()
+	}
+}
+
+// close ends the response
+func (as *arrayStream) close() error {
+	if err := as.start(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(as.w, "]}\n")
+	return err
+}
+
+// writeRows streams a {"rows": [...]} response
+func writeRows(w http.ResponseWriter, rows []RowData) {
+	stream := newArrayStream(w, "rows")
+	for _, row := range rows {
+		if err := stream.add(row); err != nil {
+			slog.Error("streaming rows response failed", "error", err)
+			return
+		}
+	}
+	if err := stream.close(); err != nil {
+		slog.Error("streaming rows response failed", "error", err)
+	}
+}
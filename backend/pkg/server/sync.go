@@ -0,0 +1,693 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/plugin"
+	"github.com/elibdev/notably/pkg/script"
+	"github.com/elibdev/notably/pkg/ulid"
+)
+
+// Offline clients keep a copy of the user's rows. They pull the row facts
+// written since their last pull from GET /sync/changes, in the order they
+// reached the server, and push the changes they made offline to POST /sync/push. Every
+// pushed change names the version of the row it was made on, the ID of the
+// row's latest fact; a change made on an older version conflicts and is
+// not written, unless the table merges by field and the change can be
+// merged into the row's current version. A change is written together with
+// a claim on the version it follows, so of two pushes made on the same
+// version at once only one is applied.
+
+const (
+	// defaultSyncLimit and maxSyncLimit bound the changes of one pull
+	defaultSyncLimit = 500
+	maxSyncLimit     = 5000
+	// maxSyncPush is the most changes one push may carry
+	maxSyncPush = 100
+	// syncWriteSlack is how long a write may take between reading the
+	// server's clock and taking its fact's ID
+	syncWriteSlack = time.Minute
+
+	// versionClaimDataType marks the facts claiming the version of a row
+	// that follows another
+	versionClaimDataType = "versionclaim"
+)
+
+// Outcomes of a pushed change
+const (
+	syncApplied  = "applied"
+	syncConflict = "conflict"
+	syncRejected = "rejected"
+)
+
+// SyncChange is a row fact as sync clients exchange it. Version is the ID of
+// the fact; a client pushing a change to the row gives it as the change's
+// base version.
+type SyncChange struct {
+	Table     string                 `json:"table"`
+	ID        string                 `json:"id"`
+	Version   string                 `json:"version"`
+	Deleted   bool                   `json:"deleted,omitempty"`
+	Values    map[string]interface{} `json:"values,omitempty"`
+	Masked    bool                   `json:"masked,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+	ValidTime *time.Time             `json:"validTime,omitempty"`
+	// ActorUserID and APIKeyID are who made the change, for facts written
+	// since they record it
+	ActorUserID string `json:"actorUserId,omitempty"`
+	APIKeyID    string `json:"apiKeyId,omitempty"`
+}
+
+// SyncPushChange is a change a client made offline. BaseVersion is the
+// version of the row the change was made on, empty for a row the client
+// created. Timestamp is when the change was made; it is stored as the
+// fact's valid time.
+type SyncPushChange struct {
+	Table       string                 `json:"table"`
+	ID          string                 `json:"id"`
+	BaseVersion string                 `json:"baseVersion"`
+	Values      map[string]interface{} `json:"values,omitempty"`
+	Deleted     bool                   `json:"deleted,omitempty"`
+	Timestamp   *time.Time             `json:"timestamp,omitempty"`
+}
+
+// SyncPushResult is the outcome of one pushed change: applied with the
+// row's new version, a conflict with the row as the server has it, or
+// rejected with the reason. Merged is set on changes made on an older
+// version that were merged by field.
+type SyncPushResult struct {
+	Status  string      `json:"status"`
+	Version string      `json:"version,omitempty"`
+	Merged  bool        `json:"merged,omitempty"`
+	Current *SyncChange `json:"current,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// syncCursor is the position of the last change a pull returned, by the
+// arrival of its fact
+type syncCursor struct {
+	Timestamp time.Time
+	ID        string
+}
+
+// syncCursorFingerprint binds a user's sync cursors to their pulls
+func syncCursorFingerprint(userID string) string {
+	return "sync\x00" + userID
+}
+
+// encodeSyncCursor returns the cursor as the signed, opaque token clients
+// pass back, in the format of the stores' pagination tokens. Its position
+// is the "timestamp#id" of the fact.
+func (s *Server) encodeSyncCursor(userID string, c syncCursor) (string, error) {
+	if c.Timestamp.IsZero() {
+		return "", nil
+	}
+	return s.syncCursors.Encode(syncCursorFingerprint(userID), c.Timestamp.UTC().Format(time.RFC3339Nano)+"#"+c.ID)
+}
+
+// after reports whether a fact reached the server after the cursor's
+func (c syncCursor) after(f dynamo.Fact) bool {
+	if at := factArrival(f); !at.Equal(c.Timestamp) {
+		return at.After(c.Timestamp)
+	}
+	return f.ID > c.ID
+}
+
+// factArrival returns when a fact reached the server. A fact's timestamp
+// may be a client's, up to the allowed skew in the past, but its ID is a
+// ULID the server made as it wrote the fact. Facts written before IDs were
+// ULIDs have their timestamp.
+func factArrival(f dynamo.Fact) time.Time {
+	if id, err := ulid.Parse(f.ID); err == nil {
+		return id.Time()
+	}
+	return f.Timestamp
+}
+
+// syncWindow returns the timestamps a fact that reached the server after
+// the cursor, and by now, may have
+func (s *Server) syncWindow(since syncCursor, now time.Time) (start, end time.Time) {
+	if behind := s.maxClockBehind(); behind >= 0 && !since.Timestamp.IsZero() {
+		start = since.Timestamp.Add(-behind - syncWriteSlack)
+	}
+	return start, now.Add(s.maxClockAhead())
+}
+
+// parseSyncCursor checks and decodes a user's cursor; an empty one starts
+// from the beginning
+func (s *Server) parseSyncCursor(userID, raw string) (syncCursor, error) {
+	var c syncCursor
+	if raw == "" {
+		return c, nil
+	}
+	position, err := s.syncCursors.Decode(syncCursorFingerprint(userID), raw)
+	if err != nil {
+		return c, err
+	}
+	at, id, ok := strings.Cut(position, "#")
+	if !ok {
+		return c, errors.New("malformed cursor position")
+	}
+	if c.Timestamp, err = time.Parse(time.RFC3339Nano, at); err != nil {
+		return c, err
+	}
+	c.ID = id
+	return c, nil
+}
+
+// handleSyncChanges returns the row changes of the user's tables that
+// reached the server after the since cursor, oldest first, with the cursor
+// to pull from next. Client-dated writes are ordered by when they arrived,
+// not by their timestamp, so a write dated before a pull still comes after
+// it. more is set when the limit cut the page short.
+func (s *Server) handleSyncChanges(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	q := r.URL.Query()
+	since, err := s.parseSyncCursor(user.ID, q.Get("since"))
+	if err != nil {
+		writeValidationError(w, "Invalid sync request", []FieldError{{Field: "since", Message: "is not a cursor returned by the server"}})
+		return
+	}
+	limit := defaultSyncLimit
+	if raw := q.Get("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxSyncLimit {
+			writeValidationError(w, "Invalid sync request", []FieldError{{Field: "limit", Message: fmt.Sprintf("must be an integer from 1 to %d", maxSyncLimit)}})
+			return
+		}
+	}
+
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	names, _, err := s.visibleTables(r.Context(), store, user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get tables")
+		return
+	}
+	tableOf := make(map[string]string, len(names))
+	for _, table := range names {
+		tableOf[tableNamespace(user.ID, table)] = table
+	}
+
+	// In shared mode every table's rows are in the base store, which is
+	// read once; isolated tables each have their own
+	start, end := s.syncWindow(since, time.Now().UTC())
+	var facts []dynamo.Fact
+	read := make(map[*db.StoreAdapter]bool)
+	for _, table := range names {
+		rowStore, err := s.getRowStore(r.Context(), store, user, table)
+		if err != nil {
+			writeStoreError(w, err, "Failed to initialize table storage")
+			return
+		}
+		if read[rowStore] {
+			continue
+		}
+		read[rowStore] = true
+		written, err := rowStore.QueryByTimeRange(r.Context(), start, end)
+		if err != nil {
+			writeStoreError(w, err, "Failed to query changes")
+			return
+		}
+		for _, f := range written {
+			if _, ok := tableOf[f.Namespace]; ok && since.after(f) {
+				facts = append(facts, f)
+			}
+		}
+	}
+	sort.SliceStable(facts, func(i, j int) bool {
+		if a, b := factArrival(facts[i]), factArrival(facts[j]); !a.Equal(b) {
+			return a.Before(b)
+		}
+		return facts[i].ID < facts[j].ID
+	})
+
+	changes := []SyncChange{}
+	next, more := since, false
+	for _, f := range facts {
+		if len(changes) == limit {
+			more = true
+			break
+		}
+		next = syncCursor{Timestamp: factArrival(f), ID: f.ID}
+		if change, ok := s.syncChange(r.Context(), tableOf[f.Namespace], f); ok {
+			changes = append(changes, change)
+		}
+	}
+
+	cursor, err := s.encodeSyncCursor(user.ID, next)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to sign cursor: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"changes": changes,
+		"cursor":  cursor,
+		"more":    more,
+	})
+}
+
+// syncChange returns the change of a row fact, or false for facts that are
+// not rows
+func (s *Server) syncChange(ctx context.Context, table string, f dynamo.Fact) (SyncChange, bool) {
+	ev, ok := s.rowEvent(ctx, table, f)
+	if !ok {
+		return SyncChange{}, false
+	}
+	return SyncChange{
+		Table:       table,
+		ID:          f.FieldName,
+		Version:     f.ID,
+		Deleted:     f.IsTombstone(),
+		Values:      ev.Values,
+		Masked:      ev.Masked,
+		Timestamp:   f.Timestamp,
+		ValidTime:   f.ValidTime,
+		ActorUserID: f.ActorUserID,
+		APIKeyID:    f.APIKeyID,
+	}, true
+}
+
+// syncTable is what a push needs to know about each table it writes; err is
+// set for tables that cannot be written
+type syncTable struct {
+	defs      []dynamo.Fact
+	store     *db.StoreAdapter
+	validator *rowValidator
+	rows      map[string]dynamo.Fact
+	err       string
+}
+
+// handleSyncPush writes the changes a client made offline, in order. A
+// change whose base version is not the row's current version conflicts;
+// changes to a row already changed by the push follow on from the earlier
+// change and conflict when it was not applied. Changes that do not conflict
+// go through the same validation, automations and reference checks as row
+// writes, and are written at the server's time with the client's timestamp
+// as their valid time, so other clients pull them. Each change has its own
+// result; a push applies the changes that succeed.
+func (s *Server) handleSyncPush(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	var req struct {
+		Changes []SyncPushChange `json:"changes"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if fields := s.validateSyncChanges(req.Changes, time.Now().UTC()); len(fields) > 0 {
+		writeValidationError(w, "Invalid sync push", fields)
+		return
+	}
+
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	refs := s.newReferenceChecker(r.Context(), store, user)
+	tables := make(map[string]*syncTable)
+	// pushed records, for each row the push changed, whether its last
+	// change was applied
+	pushed := make(map[rowRef]bool)
+	results := make([]SyncPushResult, len(req.Changes))
+	for i, change := range req.Changes {
+		t, ok := tables[change.Table]
+		if !ok {
+			t, err = s.loadSyncTable(r.Context(), store, user, change.Table, refs)
+			if err != nil {
+				writeStoreError(w, err, "Failed to look up table")
+				return
+			}
+			tables[change.Table] = t
+		}
+		if t.err != "" {
+			results[i] = SyncPushResult{Status: syncRejected, Error: t.err}
+			continue
+		}
+
+		row := rowRef{change.Table, change.ID}
+		current, exists := t.rows[change.ID]
+		applied, changed := pushed[row]
+		conflict := changed && !applied
+		// In tables that merge by field, an update of a live row made on an
+		// older version is merged into the current one
+		base := previousValues(current)
+		if !changed && change.BaseVersion != current.ID {
+			conflict = true
+			if exists && rowLive(current) && !change.Deleted && t.validator.options().mergesFields() {
+				values, found, err := rowVersionValues(r.Context(), t.store, tableNamespace(user.ID, change.Table), change.ID, change.BaseVersion, time.Now().UTC())
+				if err != nil {
+					writeStoreError(w, err, "Failed to read row history")
+					return
+				}
+				base, conflict = values, !found
+			}
+		}
+		if conflict {
+			results[i] = SyncPushResult{Status: syncConflict}
+			if exists {
+				if cur, ok := s.syncChange(r.Context(), change.Table, current); ok {
+					results[i].Current = &cur
+				}
+			}
+			pushed[row] = false
+			continue
+		}
+
+		var result SyncPushResult
+		if change.Deleted {
+			result, err = s.pushSyncDelete(r.Context(), store, user, tables, refs, change)
+		} else {
+			result, err = s.pushSyncWrite(r.Context(), store, user, t, refs, change, base)
+			result.Merged = result.Merged || result.Status == syncApplied && !changed && change.BaseVersion != current.ID
+		}
+		if err != nil {
+			writeStoreError(w, err, fmt.Sprintf("Failed to write change %d", i))
+			return
+		}
+		results[i] = result
+		pushed[row] = result.Status == syncApplied
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+}
+
+// validateSyncChanges checks the shape of each pushed change and that its
+// timestamp is within the allowed skew of the server's clock
+func (s *Server) validateSyncChanges(changes []SyncPushChange, now time.Time) []FieldError {
+	if len(changes) == 0 {
+		return []FieldError{{Field: "changes", Message: "is required"}}
+	}
+	if len(changes) > maxSyncPush {
+		return []FieldError{{Field: "changes", Message: fmt.Sprintf("must have at most %d changes", maxSyncPush)}}
+	}
+
+	var fields []FieldError
+	for i, change := range changes {
+		field := func(name string) string { return fmt.Sprintf("changes[%d].%s", i, name) }
+		if change.Table == "" {
+			fields = append(fields, FieldError{Field: field("table"), Message: "is required"})
+		}
+		if change.ID == "" {
+			fields = append(fields, FieldError{Field: field("id"), Message: "is required"})
+		}
+		if change.Values == nil && !change.Deleted {
+			fields = append(fields, FieldError{Field: field("values"), Message: "is required"})
+		}
+		if change.Values != nil && change.Deleted {
+			fields = append(fields, FieldError{Field: field("values"), Message: "must be empty for a deletion"})
+		}
+		_, _, timeFields := s.writeTime(change.Timestamp, now)
+		for _, f := range timeFields {
+			fields = append(fields, FieldError{Field: field(f.Field), Message: f.Message})
+		}
+	}
+	return fields
+}
+
+// loadSyncTable looks up a table a push writes and its current rows
+func (s *Server) loadSyncTable(ctx context.Context, store *db.StoreAdapter, user *auth.User, table string, refs *referenceChecker) (*syncTable, error) {
+	facts, err := store.QueryByField(ctx, user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if !s.tableVisible(ctx, facts) {
+		return &syncTable{err: fmt.Sprintf("Table '%s' not found", table)}, nil
+	}
+	if tableOptionsOf(latestTableDef(facts)).Source != nil {
+		return &syncTable{err: fmt.Sprintf("Table '%s' is a virtual table and is read-only", table)}, nil
+	}
+	rowStore, err := s.getRowStore(ctx, store, user, table)
+	if err != nil {
+		return nil, err
+	}
+	t := &syncTable{defs: facts, store: rowStore, validator: s.newRowValidator(facts, refs)}
+	t.rows, err = s.tableEntries(ctx, store, rowStore, user, table, t.validator.def, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if t.rows == nil {
+		t.rows = map[string]dynamo.Fact{}
+	}
+	return t, nil
+}
+
+// pushSyncWrite writes the values of a pushed change, creating the row when
+// it is not live. In tables that merge by field, an update changes the
+// columns that differ from base, the values of the version it was made on,
+// and is merged again into versions other writes claimed first.
+func (s *Server) pushSyncWrite(ctx context.Context, store *db.StoreAdapter, user *auth.User, t *syncTable, refs *referenceChecker, change SyncPushChange, base map[string]interface{}) (SyncPushResult, error) {
+	current, exists := t.rows[change.ID]
+	remerge := exists && rowLive(current) && t.validator.options().mergesFields()
+	for attempt := 1; ; attempt++ {
+		result, err := s.writeSyncChange(ctx, store, user, t, refs, change, base)
+		switch {
+		case err != nil:
+			return SyncPushResult{}, err
+		case result.Status == syncApplied:
+			result.Merged = attempt > 1
+			return result, nil
+		case result.Status != syncConflict, !remerge, attempt == maxMergeAttempts, result.Current == nil, result.Current.Deleted:
+			return result, nil
+		}
+	}
+}
+
+// writeSyncChange makes one attempt at writing a pushed change on the row's
+// version in t, for pushSyncWrite
+func (s *Server) writeSyncChange(ctx context.Context, store *db.StoreAdapter, user *auth.User, t *syncTable, refs *referenceChecker, change SyncPushChange, base map[string]interface{}) (SyncPushResult, error) {
+	current, exists := t.rows[change.ID]
+	create := !exists || !rowLive(current)
+	now := time.Now().UTC()
+	// at is when the change was made, which orders the writes of each
+	// column of tables that merge by field
+	at := now
+	if change.Timestamp != nil {
+		at = change.Timestamp.UTC()
+	}
+	var fields []string
+	switch {
+	case !t.validator.options().mergesFields():
+	case create:
+		fields = fieldChanges(nil, change.Values)
+	default:
+		merged, changed, err := s.mergeFields(ctx, store, user.ID, change.Table, change.ID, previousValues(current), base, change.Values, at, now)
+		if err != nil {
+			return SyncPushResult{}, err
+		}
+		change.Values, fields = merged, changed
+	}
+
+	ev := script.Event{Type: automationEventUpdate, Table: change.Table, Row: change.ID, Timestamp: now, Values: change.Values}
+	if create {
+		ev.Type = automationEventCreate
+		t.validator.applyDefaults(change.Values)
+	} else {
+		ev.Previous = previousValues(current)
+	}
+
+	values, err := s.applyAutomations(ctx, store, user.ID, t.defs, ev)
+	var failed *automationError
+	switch {
+	case errors.As(err, &failed):
+		return SyncPushResult{Status: syncRejected, Error: failed.Error()}, nil
+	case err != nil:
+		return SyncPushResult{}, err
+	}
+
+	event := plugin.RowEvent{Type: ev.Type, UserID: user.ID, Table: change.Table, Row: change.ID, Timestamp: now, Values: values}
+	err = t.validator.validate(ctx, event)
+	var violation *rowViolation
+	switch {
+	case errors.As(err, &violation):
+		return SyncPushResult{Status: syncRejected, Error: violation.Error()}, nil
+	case err != nil:
+		return SyncPushResult{}, err
+	}
+
+	fact := dynamo.Fact{
+		ID:        newID(),
+		Timestamp: now,
+		Namespace: tableNamespace(user.ID, change.Table),
+		FieldName: change.ID,
+		DataType:  "json",
+		Value:     values,
+		ValidTime: validTime(change.Timestamp),
+		CreatedAt: carriedCreation(current, exists, now),
+	}
+	if t.validator.options().Type == tableTypeSecrets {
+		if err := s.sealRowFact(ctx, &fact); err != nil {
+			return SyncPushResult{}, fmt.Errorf("encrypting row: %w", err)
+		}
+	}
+
+	// Indexes are written once the row is, so a change that loses its base
+	// version to another push leaves none behind
+	claimed, err := putRowVersion(ctx, t.store, latestTableDef(t.defs), user.ID, change.Table, change.ID, current, fact)
+	if err != nil || !claimed {
+		return s.syncLost(ctx, t, user.ID, change.Table, change.ID, err)
+	}
+	t.rows[change.ID] = fact
+
+	columns := t.validator.columns
+	if err := s.indexGeoPoints(ctx, store, user.ID, change.Table, columns, change.ID, values, now); err != nil {
+		return SyncPushResult{}, err
+	}
+	if err := s.indexExpiry(ctx, store, user.ID, change.Table, t.validator.def, change.ID, values, now); err != nil {
+		return SyncPushResult{}, err
+	}
+	if err := s.indexReferences(ctx, store, user.ID, change.Table, columns, change.ID, values, now); err != nil {
+		return SyncPushResult{}, err
+	}
+	if err := s.recordFieldWrites(ctx, store, user.ID, change.Table, change.ID, fields, at); err != nil {
+		return SyncPushResult{}, err
+	}
+	refs.expect(change.Table, change.ID, true)
+	s.publishRowEvent(event)
+
+	return SyncPushResult{Status: syncApplied, Version: fact.ID}, nil
+}
+
+// pushSyncDelete deletes the row of a pushed change and the rows the
+// deletion cascades to, all at once. A row that is already deleted is left
+// as it is.
+func (s *Server) pushSyncDelete(ctx context.Context, store *db.StoreAdapter, user *auth.User, tables map[string]*syncTable, refs *referenceChecker, change SyncPushChange) (SyncPushResult, error) {
+	t := tables[change.Table]
+	current, exists := t.rows[change.ID]
+	if !exists || !rowLive(current) {
+		return SyncPushResult{Status: syncApplied, Version: current.ID}, nil
+	}
+
+	planner := s.newDeletePlanner(ctx, store, user)
+	planner.stores[change.Table] = t.store
+	rows, err := planner.plan(change.Table, change.ID)
+	var refused *integrityError
+	var outOfScope *scopeError
+	switch {
+	case errors.As(err, &refused):
+		return SyncPushResult{Status: syncRejected, Error: refused.Error()}, nil
+	case errors.As(err, &outOfScope):
+		return SyncPushResult{Status: syncRejected, Error: outOfScope.Error()}, nil
+	case err != nil:
+		return SyncPushResult{}, err
+	}
+	now := time.Now().UTC()
+	facts := make([]dynamo.Fact, len(rows))
+	var own []dynamo.Fact
+	for i, target := range rows {
+		facts[i] = dynamo.Fact{
+			ID:        newID(),
+			Timestamp: now,
+			Namespace: tableNamespace(user.ID, target.table),
+			FieldName: target.row,
+			DataType:  dynamo.DataTypeDeleted,
+			ValidTime: validTime(change.Timestamp),
+		}
+		if target.table == change.Table {
+			own = append(own, facts[i])
+		}
+	}
+	// The table's own tombstones are written in one transaction with the
+	// claim; those of other tables, in other stores maybe, once it succeeds
+	if len(own) >= db.MaxTransactionItems {
+		return SyncPushResult{Status: syncRejected, Error: fmt.Sprintf("Deleting row '%s' cascades to %d rows; delete it with DELETE /tables/%s/rows/%s", change.ID, len(rows)-1, change.Table, change.ID)}, nil
+	}
+	claimed, err := putRowVersion(ctx, t.store, latestTableDef(t.defs), user.ID, change.Table, change.ID, current, own...)
+	if err != nil || !claimed {
+		return s.syncLost(ctx, t, user.ID, change.Table, change.ID, err)
+	}
+
+	for i, target := range rows {
+		if target.table != change.Table {
+			targetStore, err := planner.rowStore(target.table)
+			if err != nil {
+				return SyncPushResult{}, err
+			}
+			if err := targetStore.PutFact(ctx, facts[i]); err != nil {
+				return SyncPushResult{}, err
+			}
+		}
+		if cascaded, ok := tables[target.table]; ok && cascaded.rows != nil {
+			cascaded.rows[target.row] = facts[i]
+		}
+		refs.expect(target.table, target.row, false)
+		s.publishRowEvent(plugin.RowEvent{Type: "delete", UserID: user.ID, Table: target.table, Row: target.row, Timestamp: now})
+	}
+	return SyncPushResult{Status: syncApplied, Version: facts[0].ID}, nil
+}
+
+// versionClaim returns the fact claiming the version of a row that follows
+// current, the row's latest fact, or its first version on the table's
+// definition def when the row has none. A claim's key is fixed by the
+// version it follows, so only one write can store it.
+func versionClaim(userID, table, row string, def, current dynamo.Fact) dynamo.Fact {
+	if current.ID == "" {
+		current = dynamo.Fact{ID: def.ID + "." + escapeName(row), Timestamp: def.Timestamp}
+	}
+	return dynamo.Fact{
+		ID:        current.ID + ".next",
+		Timestamp: current.Timestamp,
+		Namespace: userID,
+		FieldName: settingField(table, "versions", row),
+		DataType:  versionClaimDataType,
+		Value:     current.ID,
+	}
+}
+
+// putRowVersion writes facts, the first of them the row's new version, to
+// the table's row store in one transaction with the claim on the version
+// following current; def is the table's definition. It returns false,
+// having written nothing, when another write claimed that version first.
+func putRowVersion(ctx context.Context, rowStore *db.StoreAdapter, def dynamo.Fact, userID, table, row string, current dynamo.Fact, facts ...dynamo.Fact) (bool, error) {
+	claim := versionClaim(userID, table, row, def, current)
+	err := rowStore.PutFactsTransactional(ctx, append([]dynamo.Fact{claim}, facts...))
+	if errors.Is(err, db.ErrConditionFailed) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// syncLost returns the result of a change whose base version another write
+// claimed first: a conflict with the row as it now is. err, when set, is
+// returned instead.
+func (s *Server) syncLost(ctx context.Context, t *syncTable, userID, table, row string, err error) (SyncPushResult, error) {
+	if err != nil {
+		return SyncPushResult{}, err
+	}
+	latest, found, err := s.latestRowFact(ctx, t.store, tableNamespace(userID, table), row, time.Now().UTC())
+	if err != nil {
+		return SyncPushResult{}, err
+	}
+	result := SyncPushResult{Status: syncConflict}
+	if found {
+		t.rows[row] = latest
+		if cur, ok := s.syncChange(ctx, table, latest); ok {
+			result.Current = &cur
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncChanges(t *testing.T) {
+	_, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables", `{"name": "tasks"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	type page struct {
+		Changes []SyncChange `json:"changes"`
+		Cursor  string       `json:"cursor"`
+		More    bool         `json:"more"`
+	}
+	pull := func(since string, limit string) page {
+		t.Helper()
+		q := url.Values{"since": {since}, "limit": {limit}}
+		rec := do(http.MethodGet, "/sync/changes?"+q.Encode(), "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var p page
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+		return p
+	}
+	rows := func(p page) []string {
+		var ids []string
+		for _, c := range p.Changes {
+			ids = append(ids, c.Table+"/"+c.ID)
+		}
+		return ids
+	}
+
+	first := pull("", "")
+	assert.Empty(t, first.Changes)
+	assert.False(t, first.More)
+
+	for _, write := range []struct{ method, path, body string }{
+		{http.MethodPost, "/tables/notes/rows", `{"id": "n1", "values": {"v": 1}}`},
+		{http.MethodPost, "/tables/tasks/rows", `{"id": "t1", "values": {"v": 1}}`},
+		{http.MethodPut, "/tables/notes/rows/n1", `{"values": {"v": 2}}`},
+		{http.MethodDelete, "/tables/tasks/rows/t1", ""},
+	} {
+		rec := do(write.method, write.path, write.body)
+		require.Less(t, rec.Code, 300, rec.Body.String())
+	}
+
+	all := pull(first.Cursor, "")
+	assert.Equal(t, []string{"notes/n1", "tasks/t1", "notes/n1", "tasks/t1"}, rows(all), "changes of every table, oldest first")
+	assert.Equal(t, float64(2), all.Changes[2].Values["v"])
+	assert.True(t, all.Changes[3].Deleted)
+	assert.NotEqual(t, all.Changes[0].Version, all.Changes[2].Version)
+
+	// Pages resume where the last one stopped
+	p := pull(first.Cursor, "3")
+	assert.Equal(t, rows(all)[:3], rows(p))
+	assert.True(t, p.More)
+	p = pull(p.Cursor, "3")
+	assert.Equal(t, rows(all)[3:], rows(p))
+	assert.False(t, p.More)
+	assert.Empty(t, pull(p.Cursor, "").Changes)
+	assert.Equal(t, p.Cursor, pull(p.Cursor, "").Cursor, "an empty page keeps the cursor")
+
+	// A write dated before the last pull comes after it
+	backdated := time.Now().UTC().Add(-24 * time.Hour).Format(time.RFC3339Nano)
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n3", "values": {"v": 1}, "timestamp": "`+backdated+`"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	late := pull(p.Cursor, "")
+	assert.Equal(t, []string{"notes/n3"}, rows(late))
+	assert.Equal(t, backdated, late.Changes[0].Timestamp.Format(time.RFC3339Nano), "changes keep the client's timestamp")
+	assert.Empty(t, pull(late.Cursor, "").Changes)
+
+	rec = do(http.MethodGet, "/sync/changes?since=nonsense!", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "since")
+	rec = do(http.MethodGet, "/sync/changes?limit=0", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestSyncCursorSigned(t *testing.T) {
+	srv, do := memoryServer(t)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "notes"}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/notes/rows", `{"id": "n1", "values": {"v": 1}}`).Code)
+	rec := do(http.MethodGet, "/sync/changes", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var page struct {
+		Cursor string `json:"cursor"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	require.NotEmpty(t, page.Cursor)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/sync/changes?since="+url.QueryEscape(page.Cursor), "").Code)
+
+	// An unsigned cursor, such as one claiming to be far in the future, is refused
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"t":"2099-01-01T00:00:00Z","id":"x"}`))
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/sync/changes?since="+forged, "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/sync/changes?since="+url.QueryEscape(page.Cursor+"x"), "").Code)
+
+	// Cursors are bound to the user they were issued to
+	ctx := context.Background()
+	bob, err := srv.authenticator.RegisterUser(ctx, "bob", "bob@example.com", "pw")
+	require.NoError(t, err)
+	_, key, err := srv.authenticator.GenerateAPIKey(ctx, bob.ID, "default", 0)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/sync/changes?since="+url.QueryEscape(page.Cursor), nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+}
+
+func TestSyncPush(t *testing.T) {
+	_, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes", "columns": [{"name": "v", "dataType": "number"}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n1", "values": {"v": 1}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	type pushResponse struct {
+		Results []SyncPushResult `json:"results"`
+	}
+	push := func(body string) []SyncPushResult {
+		t.Helper()
+		rec := do(http.MethodPost, "/sync/push", body)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp pushResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp.Results
+	}
+	statuses := func(results []SyncPushResult) []string {
+		var s []string
+		for _, r := range results {
+			s = append(s, r.Status)
+		}
+		return s
+	}
+
+	rec = do(http.MethodGet, "/sync/changes", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var pulled struct {
+		Changes []SyncChange `json:"changes"`
+		Cursor  string       `json:"cursor"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pulled))
+	require.Len(t, pulled.Changes, 1)
+	base := pulled.Changes[0].Version
+
+	offline := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339Nano)
+	results := push(`{"changes": [
+		{"table": "notes", "id": "n1", "baseVersion": "` + base + `", "values": {"v": 2}, "timestamp": "` + offline + `"},
+		{"table": "notes", "id": "n1", "baseVersion": "` + base + `", "values": {"v": 3}},
+		{"table": "notes", "id": "n2", "values": {"v": 1}},
+		{"table": "notes", "id": "n3", "values": {"v": "one"}},
+		{"table": "missing", "id": "m1", "values": {}}
+	]}`)
+	assert.Equal(t, []string{syncApplied, syncApplied, syncApplied, syncRejected, syncRejected}, statuses(results))
+	assert.Contains(t, results[3].Error, "v")
+	assert.Contains(t, results[4].Error, "not found")
+	assert.Equal(t, map[string]float64{"n1": 3, "n2": 1}, snapshotValues(t, do, "/tables/notes/snapshot"))
+
+	// Another device changed n1 since this one pulled it
+	results = push(`{"changes": [
+		{"table": "notes", "id": "n1", "baseVersion": "` + base + `", "values": {"v": 10}},
+		{"table": "notes", "id": "n1", "baseVersion": "` + base + `", "deleted": true},
+		{"table": "notes", "id": "n2", "values": {"v": 10}}
+	]}`)
+	assert.Equal(t, []string{syncConflict, syncConflict, syncConflict}, statuses(results))
+	require.NotNil(t, results[0].Current, "a conflict has the row as the server has it")
+	assert.Equal(t, float64(3), results[0].Current.Values["v"])
+	assert.Equal(t, map[string]float64{"n1": 3, "n2": 1}, snapshotValues(t, do, "/tables/notes/snapshot"))
+
+	results = push(`{"changes": [{"table": "notes", "id": "n1", "baseVersion": "` + results[0].Current.Version + `", "deleted": true}]}`)
+	assert.Equal(t, []string{syncApplied}, statuses(results))
+	assert.Equal(t, map[string]float64{"n2": 1}, snapshotValues(t, do, "/tables/notes/snapshot"))
+
+	// Pushed changes are pulled by other clients, with the time they were
+	// made as their valid time
+	rec = do(http.MethodGet, "/sync/changes?since="+pulled.Cursor, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pulled))
+	require.Len(t, pulled.Changes, 4)
+	require.NotNil(t, pulled.Changes[0].ValidTime)
+	assert.Equal(t, offline, pulled.Changes[0].ValidTime.Format(time.RFC3339Nano))
+	assert.True(t, pulled.Changes[3].Deleted)
+	assert.Equal(t, results[0].Version, pulled.Changes[3].Version)
+
+	rec = do(http.MethodPost, "/sync/push", `{"changes": [{"table": "notes", "id": "n4"}]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "changes[0].values")
+	rec = do(http.MethodPost, "/sync/push", `{"changes": [{"table": "notes", "id": "n4", "values": {}, "timestamp": "`+time.Now().Add(time.Hour).UTC().Format(time.RFC3339)+`"}]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "changes[0].timestamp")
+}
+
+func TestSyncPushConcurrent(t *testing.T) {
+	srv, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes", "columns": [{"name": "v", "dataType": "number"}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n1", "values": {"v": 1}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n2", "values": {"v": 1}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	ctx := context.Background()
+	users, err := srv.authenticator.GetAllUsers(ctx)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	user := users[0]
+	store, err := srv.getStoreForUser(ctx, user.ID)
+	require.NoError(t, err)
+	refs := srv.newReferenceChecker(ctx, store, user)
+
+	// Two devices push on the same version at once: each checks the row
+	// before the other has written it
+	stale, err := srv.loadSyncTable(ctx, store, user, "notes", refs)
+	require.NoError(t, err)
+	base := stale.rows["n1"].ID
+	rec = do(http.MethodPost, "/sync/push", `{"changes": [
+		{"table": "notes", "id": "n1", "baseVersion": "`+base+`", "values": {"v": 2}},
+		{"table": "notes", "id": "n2", "baseVersion": "`+stale.rows["n2"].ID+`", "values": {"v": 2}}
+	]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	result, err := srv.pushSyncWrite(ctx, store, user, stale, refs, SyncPushChange{Table: "notes", ID: "n1", BaseVersion: base, Values: map[string]interface{}{"v": float64(3)}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, syncConflict, result.Status)
+	require.NotNil(t, result.Current, "a conflict has the row as the server has it")
+	assert.Equal(t, float64(2), result.Current.Values["v"])
+	assert.Equal(t, result.Current.Version, stale.rows["n1"].ID, "the row is reloaded")
+
+	result, err = srv.pushSyncDelete(ctx, store, user, map[string]*syncTable{"notes": stale}, refs, SyncPushChange{Table: "notes", ID: "n2", BaseVersion: base, Deleted: true})
+	require.NoError(t, err)
+	assert.Equal(t, syncConflict, result.Status)
+	assert.Equal(t, map[string]float64{"n1": 2, "n2": 2}, snapshotValues(t, do, "/tables/notes/snapshot"), "the losing writes wrote nothing")
+
+	result, err = srv.pushSyncWrite(ctx, store, user, stale, refs, SyncPushChange{Table: "notes", ID: "n1", BaseVersion: stale.rows["n1"].ID, Values: map[string]interface{}{"v": float64(3)}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, syncApplied, result.Status, "pushing again on the current version succeeds")
+	assert.Equal(t, map[string]float64{"n1": 3, "n2": 2}, snapshotValues(t, do, "/tables/notes/snapshot"))
+}
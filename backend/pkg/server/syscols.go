@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+)
+
+// System columns are maintained by the server from each row's history. They
+// are returned beside a row's values and can be filtered and sorted on, but
+// rows may not set them. A row's creation time is recorded on the write that
+// creates it and carried by each later version; only rows written before it
+// was recorded have it derived from their history.
+const (
+	sysCreatedAt = "_createdAt"
+	sysUpdatedAt = "_updatedAt"
+	sysCreatedBy = "_createdBy"
+)
+
+// sysTimeFormat renders system column times at a fixed width, so comparing
+// them as strings orders them in time
+const sysTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// isSystemColumn reports whether a column name is reserved for row metadata
+func isSystemColumn(name string) bool {
+	return name == sysCreatedAt || name == sysUpdatedAt || name == sysCreatedBy
+}
+
+// rowCreation returns when each row of a table was created, as of a time: the
+// first write after the row was last deleted
+func (s *Server) rowCreation(ctx context.Context, store, rowStore *db.StoreAdapter, user *auth.User, table string, def dynamo.Fact, at time.Time) (map[string]time.Time, error) {
+	history, err := s.tableHistory(ctx, store, rowStore, user, table, def, time.Time{}, at)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Timestamp.Before(history[j].Timestamp)
+	})
+	created := make(map[string]time.Time)
+	for _, f := range history {
+		switch {
+		case !rowLive(f):
+			delete(created, f.FieldName)
+		case created[f.FieldName].IsZero():
+			created[f.FieldName] = f.Timestamp
+		}
+	}
+	return created, nil
+}
+
+// carriedCreation returns the creation time a new version of a row carries:
+// now when the write creates the row, else that of current, its latest fact,
+// which is unset for rows written before creation times were recorded
+func carriedCreation(current dynamo.Fact, exists bool, now time.Time) *time.Time {
+	if !exists || !rowLive(current) {
+		return &now
+	}
+	return current.CreatedAt
+}
+
+// creationForCreate returns the creation time a row written by a create
+// carries: that of the row it replaces when one is live, else now. A row
+// missing from the table's own facts may still have versions in a fork's
+// parent or the cold tier, so it is left to be derived from its history.
+func (s *Server) creationForCreate(ctx context.Context, rowStore *db.StoreAdapter, def dynamo.Fact, namespace, row string, now time.Time) (*time.Time, error) {
+	latest, found, err := s.latestRowFact(ctx, rowStore, namespace, row, now)
+	if err != nil {
+		return nil, err
+	}
+	if !found && (tableOptionsOf(def).Fork != nil || s.cold != nil) {
+		return nil, nil
+	}
+	return carriedCreation(latest, found, now), nil
+}
+
+// addSystemColumns fills in the system columns of rows read at a time, whose
+// CreatedAt is that of their fact. The creator is the account owning the
+// table, whose namespace every write goes to.
+func (s *Server) addSystemColumns(ctx context.Context, store, rowStore *db.StoreAdapter, user *auth.User, table string, def dynamo.Fact, at time.Time, rows []RowData) error {
+	var created map[string]time.Time
+	for i := range rows {
+		createdAt := rows[i].CreatedAt
+		if createdAt == nil {
+			if created == nil {
+				var err error
+				if created, err = s.rowCreation(ctx, store, rowStore, user, table, def, at); err != nil {
+					return err
+				}
+			}
+			t := created[rows[i].ID]
+			createdAt = &t
+		}
+		setSystemColumns(&rows[i], *createdAt, user.ID)
+	}
+	return nil
+}
+
+// setSystemColumns sets a row's system columns; a zero createdAt falls back
+// to the row's timestamp
+func setSystemColumns(row *RowData, createdAt time.Time, createdBy string) {
+	if createdAt.IsZero() {
+		createdAt = row.Timestamp
+	}
+	updatedAt := row.Timestamp
+	row.CreatedAt, row.UpdatedAt, row.CreatedBy = &createdAt, &updatedAt, createdBy
+}
+
+// systemValues returns a row's values with its system columns added, for
+// filters and sorts. Times are UTC RFC 3339 strings.
+func systemValues(row RowData) map[string]interface{} {
+	if row.CreatedAt == nil {
+		return row.Values
+	}
+	values := make(map[string]interface{}, len(row.Values)+3)
+	for k, v := range row.Values {
+		values[k] = v
+	}
+	values[sysCreatedAt] = row.CreatedAt.UTC().Format(sysTimeFormat)
+	values[sysUpdatedAt] = row.UpdatedAt.UTC().Format(sysTimeFormat)
+	values[sysCreatedBy] = row.CreatedBy
+	return values
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSystemColumns(t *testing.T) {
+	srv, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "notes"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n1", "values": {"v": 1}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var created RowData
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	require.NotNil(t, created.CreatedAt)
+	assert.Equal(t, created.Timestamp, *created.CreatedAt)
+	time.Sleep(5 * time.Millisecond)
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n2", "values": {"v": 2}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	time.Sleep(5 * time.Millisecond)
+	rec = do(http.MethodPatch, "/tables/notes/rows/n1", `{"values": {"v": 10}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var updated RowData
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &updated))
+	require.NotNil(t, updated.CreatedAt, "update responses have system columns")
+	assert.True(t, created.CreatedAt.Equal(*updated.CreatedAt))
+	assert.True(t, updated.Timestamp.Equal(*updated.UpdatedAt))
+	assert.NotEmpty(t, updated.CreatedBy)
+
+	rec = do(http.MethodGet, "/tables/notes/snapshot", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var snap struct {
+		Rows []RowData `json:"rows"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &snap))
+	byID := map[string]RowData{}
+	for _, row := range snap.Rows {
+		byID[row.ID] = row
+	}
+	user, err := srv.userStore.GetUserByUsername(context.Background(), "alice")
+	require.NoError(t, err)
+	n1 := byID["n1"]
+	require.NotNil(t, n1.CreatedAt)
+	assert.True(t, created.CreatedAt.Equal(*n1.CreatedAt))
+	assert.True(t, n1.UpdatedAt.After(*n1.CreatedAt))
+	assert.Equal(t, user.ID, n1.CreatedBy)
+	assert.NotContains(t, n1.Values, sysCreatedAt)
+
+	// A row deleted and written again starts over
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/tables/notes/rows/n2", "").Code)
+	rec = do(http.MethodPost, "/tables/notes/rows", `{"id": "n2", "values": {"v": 20}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodGet, "/tables/notes/snapshot", "")
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &snap))
+	for _, row := range snap.Rows {
+		if row.ID == "n2" {
+			assert.Equal(t, row.Timestamp, *row.CreatedAt)
+		}
+	}
+
+	// Each version carries its row's creation time, so reads need not look
+	// through the row's history; rows written before it was recorded derive it
+	ctx := context.Background()
+	store, err := srv.getStoreForUser(ctx, user.ID)
+	require.NoError(t, err)
+	versions, err := store.QueryByField(ctx, tableNamespace(user.ID, "notes"), "n1", time.Time{}, time.Now().UTC())
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	for _, f := range versions {
+		require.NotNil(t, f.CreatedAt)
+		assert.True(t, created.CreatedAt.Equal(*f.CreatedAt))
+	}
+	legacyAt := time.Now().UTC().Add(-time.Hour)
+	for i, at := range []time.Time{legacyAt, legacyAt.Add(time.Minute)} {
+		require.NoError(t, store.PutFact(ctx, dynamo.Fact{ID: newID(), Timestamp: at, Namespace: tableNamespace(user.ID, "notes"), FieldName: "legacy", DataType: "json", Value: map[string]interface{}{"v": float64(i)}}))
+	}
+	rec = do(http.MethodGet, "/tables/notes/rows/legacy", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var legacy RowData
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &legacy))
+	require.NotNil(t, legacy.CreatedAt)
+	assert.True(t, legacyAt.Equal(*legacy.CreatedAt))
+	rec = do(http.MethodPut, "/tables/notes/rows/legacy", `{"values": {"v": 2}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &legacy))
+	assert.True(t, legacyAt.Equal(*legacy.CreatedAt))
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/tables/notes/rows/legacy", "").Code)
+
+	// Transaction results have system columns too
+	rec = do(http.MethodPost, "/transactions", `{"ops": [
+		{"op": "update", "table": "notes", "id": "n1", "values": {"v": 11}},
+		{"op": "create", "table": "notes", "id": "n3", "values": {"v": 3}},
+		{"op": "delete", "table": "notes", "id": "n3x"}
+	]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var tx TransactionResult
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tx))
+	require.Len(t, tx.Results, 3)
+	require.NotNil(t, tx.Results[0].CreatedAt)
+	assert.True(t, created.CreatedAt.Equal(*tx.Results[0].CreatedAt))
+	assert.True(t, tx.Timestamp.Equal(*tx.Results[0].UpdatedAt))
+	require.NotNil(t, tx.Results[1].CreatedAt)
+	assert.True(t, tx.Timestamp.Equal(*tx.Results[1].CreatedAt))
+	assert.Equal(t, user.ID, tx.Results[1].CreatedBy)
+	assert.Nil(t, tx.Results[2].CreatedAt)
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/tables/notes/rows/n3", "").Code)
+
+	// Views filter and sort on system columns
+	rec = do(http.MethodPost, "/views", `{"name": "recent", "source": "notes", "sort": [{"column": "_createdAt", "desc": true}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodGet, "/views/recent/rows", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &snap))
+	require.Len(t, snap.Rows, 2)
+	assert.Equal(t, []string{"n2", "n1"}, []string{snap.Rows[0].ID, snap.Rows[1].ID})
+
+	rec = do(http.MethodPost, "/views", `{"name": "mine", "source": "notes", "filter": {"column": "_createdBy", "op": "eq", "value": "nobody"}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = do(http.MethodGet, "/views/mine/rows", "")
+	assert.Contains(t, rec.Body.String(), `"rows":[]`)
+
+	// Rows and schemas cannot claim system columns
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/tables/notes/rows", `{"values": {"_createdAt": "2020-01-01"}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/tables", `{"name": "bad", "columns": [{"name": "_updatedAt", "dataType": "string"}]}`).Code)
+}
@@ -27,7 +27,8 @@ func TestTableHandlers(t *testing.T) {
 	oldEndpoint := os.Getenv("DYNAMODB_ENDPOINT_URL")
 
 	os.Setenv("DYNAMODB_TABLE_NAME", testTableName)
-	os.Setenv("DYNAMODB_ENDPOINT_URL", "http://localhost:8000")
+	endpoint := dynamotest.NewEmulatorConfig().Endpoint
+	os.Setenv("DYNAMODB_ENDPOINT_URL", endpoint)
 
 	defer func() {
 		if oldTableName == "" {
@@ -46,7 +47,7 @@ func TestTableHandlers(t *testing.T) {
 	config := Config{
 		TableName:      testTableName,
 		Addr:           ":0",
-		DynamoEndpoint: "http://localhost:8000",
+		DynamoEndpoint: endpoint,
 	}
 
 	srv, err := NewServer(config)
@@ -304,7 +305,8 @@ func TestTableCreationFlow(t *testing.T) {
 	oldEndpoint := os.Getenv("DYNAMODB_ENDPOINT_URL")
 
 	os.Setenv("DYNAMODB_TABLE_NAME", testTableName)
-	os.Setenv("DYNAMODB_ENDPOINT_URL", "http://localhost:8000")
+	endpoint := dynamotest.NewEmulatorConfig().Endpoint
+	os.Setenv("DYNAMODB_ENDPOINT_URL", endpoint)
 
 	defer func() {
 		if oldTableName == "" {
@@ -322,7 +324,7 @@ func TestTableCreationFlow(t *testing.T) {
 	config := Config{
 		TableName:      testTableName,
 		Addr:           ":0",
-		DynamoEndpoint: "http://localhost:8000",
+		DynamoEndpoint: endpoint,
 	}
 
 	srv, err := NewServer(config)
@@ -450,7 +452,8 @@ func TestRowManagement(t *testing.T) {
 	oldEndpoint := os.Getenv("DYNAMODB_ENDPOINT_URL")
 
 	os.Setenv("DYNAMODB_TABLE_NAME", testTableName)
-	os.Setenv("DYNAMODB_ENDPOINT_URL", "http://localhost:8000")
+	endpoint := dynamotest.NewEmulatorConfig().Endpoint
+	os.Setenv("DYNAMODB_ENDPOINT_URL", endpoint)
 
 	defer func() {
 		if oldTableName == "" {
@@ -468,7 +471,7 @@ func TestRowManagement(t *testing.T) {
 	config := Config{
 		TableName:      testTableName,
 		Addr:           ":0",
-		DynamoEndpoint: "http://localhost:8000",
+		DynamoEndpoint: endpoint,
 	}
 
 	srv, err := NewServer(config)
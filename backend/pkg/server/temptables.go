@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+)
+
+const (
+	// defaultTempTableTTL applies to temporary tables created without a ttl
+	defaultTempTableTTL = time.Hour
+	// maxTempTableTTL caps how long a temporary table may live
+	maxTempTableTTL = 7 * 24 * time.Hour
+	// tempTableSweepInterval is how often expired temporary tables are purged
+	tempTableSweepInterval = time.Minute
+)
+
+// parseTempTableTTL validates the ttl requested for a temporary table
+func parseTempTableTTL(ttl string) (time.Duration, error) {
+	if ttl == "" {
+		return defaultTempTableTTL, nil
+	}
+	d, err := time.ParseDuration(ttl)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("'ttl' must be a positive duration such as \"30m\"")
+	}
+	if d > maxTempTableTTL {
+		return 0, fmt.Errorf("'ttl' may not exceed %s", maxTempTableTTL)
+	}
+	return d, nil
+}
+
+// tableVisible reports whether a table exists for the current request. Expired
+// temporary tables are hidden before they are purged, and temporary tables are
+// only visible to the API key that created them. Tables outside a key's table
+// scopes are hidden too.
+func (s *Server) tableVisible(ctx context.Context, facts []dynamo.Fact) bool {
+	if !s.tableExists(ctx, facts) {
+		return false
+	}
+	key, ok := auth.APIKeyFromContext(ctx)
+	return !ok || key.CanAccessTable(latestTableDef(facts).FieldName)
+}
+
+// tableExists is tableVisible without the key's table scopes, for checks
+// such as references that must see every table of the account
+func (s *Server) tableExists(ctx context.Context, facts []dynamo.Fact) bool {
+	if !tableLive(facts) {
+		return false
+	}
+	opts := tableOptionsOf(latestTableDef(facts))
+	if opts.ExpiresAt != nil && !time.Now().Before(*opts.ExpiresAt) {
+		return false
+	}
+	if opts.Session != "" {
+		key, ok := auth.APIKeyFromContext(ctx)
+		return ok && key.ID == opts.Session
+	}
+	return true
+}
+
+// runTempTableSweeper purges expired temporary tables until ctx is cancelled
+func (s *Server) runTempTableSweeper(ctx context.Context) {
+	ticker := time.NewTicker(tempTableSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var n int
+			err := s.leased(ctx, "temp-tables", func(ctx context.Context) (err error) {
+				n, err = s.purgeExpiredTables(ctx)
+				return err
+			})
+			if err != nil {
+				s.logger.ErrorContext(ctx, "purging temporary tables failed", "purged", n, "error", err)
+			} else if n > 0 {
+				s.logger.InfoContext(ctx, "purged temporary tables", "purged", n)
+			}
+		}
+	}
+}
+
+// purgeExpiredTables removes every temporary table whose TTL has passed or
+// whose API key is no longer valid, returning how many were purged
+func (s *Server) purgeExpiredTables(ctx context.Context) (int, error) {
+	users, err := s.authenticator.GetAllUsers(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	now := time.Now().UTC()
+	for _, user := range users {
+		store, err := s.getStoreForUser(ctx, user.ID)
+		if err != nil {
+			return purged, err
+		}
+		facts, err := store.QueryByTimeRange(ctx, time.Time{}, now)
+		if err != nil {
+			return purged, err
+		}
+
+		defs := make(map[string][]dynamo.Fact)
+		for _, f := range facts {
+			if f.Namespace == user.ID && f.DataType == tableDataType {
+				defs[f.FieldName] = append(defs[f.FieldName], f)
+			}
+		}
+
+		for table, tableDefs := range defs {
+			if !tableLive(tableDefs) || !s.tempTableExpired(ctx, tableOptionsOf(latestTableDef(tableDefs)), now) {
+				continue
+			}
+			if err := s.purgeTable(ctx, store, user, table, tableDefs, facts); err != nil {
+				return purged, fmt.Errorf("purge table %s/%s: %w", user.ID, table, err)
+			}
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// tempTableExpired reports whether a temporary table should be purged
+func (s *Server) tempTableExpired(ctx context.Context, opts tableOptions, now time.Time) bool {
+	if opts.ExpiresAt == nil {
+		return false
+	}
+	if !now.Before(*opts.ExpiresAt) {
+		return true
+	}
+	if opts.Session != "" {
+		key, err := s.userStore.GetAPIKey(ctx, opts.Session)
+		if err != nil || key.Revoked || !now.Before(key.ExpiresAt) {
+			return true
+		}
+	}
+	return false
+}
+
+// purgeTable permanently removes a table's rows and definition. baseFacts are
+// the user's facts in the base table, which hold shared-mode rows.
+func (s *Server) purgeTable(ctx context.Context, store *db.StoreAdapter, user *auth.User, table string, defs, baseFacts []dynamo.Fact) error {
+	if s.resolverFor(user).Isolated() {
+		rowStore, err := s.getRowStore(ctx, store, user, table)
+		if err != nil {
+			return err
+		}
+		rows, err := rowStore.QueryByTimeRange(ctx, time.Time{}, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		if err := rowStore.DeleteTable(ctx); err != nil {
+			return err
+		}
+		s.releaseAttachments(ctx, user.ID, table, rows, nil)
+	} else {
+		key := tableNamespace(user.ID, table)
+		var rows []dynamo.Fact
+		for _, f := range baseFacts {
+			if f.Namespace != key {
+				continue
+			}
+			if err := store.PurgeFact(ctx, f); err != nil {
+				s.releaseAttachments(ctx, user.ID, table, rows, nil)
+				return err
+			}
+			rows = append(rows, f)
+		}
+		s.releaseAttachments(ctx, user.ID, table, rows, nil)
+	}
+
+	for _, f := range baseFacts {
+		if f.Namespace == user.ID && f.FieldName == automationsField(table) {
+			if err := store.PurgeFact(ctx, f); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Remove the definition last so an interrupted purge is retried
+	for _, def := range defs {
+		if err := store.PurgeFact(ctx, def); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTempTableTTL(t *testing.T) {
+	d, err := parseTempTableTTL("")
+	require.NoError(t, err)
+	assert.Equal(t, defaultTempTableTTL, d)
+
+	d, err = parseTempTableTTL("30m")
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Minute, d)
+
+	for _, bad := range []string{"soon", "-1m", "0s", "200h"} {
+		_, err := parseTempTableTTL(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestTempTableVisibility(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard()})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	owner, ownerKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "owner", 0)
+	require.NoError(t, err)
+	_, otherKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "other", 0)
+	require.NoError(t, err)
+
+	def := func(opts tableOptions) []dynamo.Fact {
+		return []dynamo.Fact{{Namespace: user.ID, FieldName: "scratch", DataType: "table", Value: opts.encode()}}
+	}
+	future := time.Now().UTC().Add(time.Hour)
+	past := time.Now().UTC().Add(-time.Minute)
+
+	visible := func(apiKey string, facts []dynamo.Fact) bool {
+		var ok bool
+		h := srv.authenticator.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok = srv.tableVisible(r.Context(), facts)
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		return ok
+	}
+
+	assert.True(t, visible(otherKey, def(tableOptions{})))
+	assert.True(t, visible(ownerKey, def(tableOptions{ExpiresAt: &future, Session: owner.ID})))
+	assert.False(t, visible(otherKey, def(tableOptions{ExpiresAt: &future, Session: owner.ID})))
+	assert.False(t, visible(ownerKey, def(tableOptions{ExpiresAt: &past, Session: owner.ID})))
+}
+
+func TestTempTableExpired(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard()})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	key, _, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "session", 0)
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Minute)
+
+	assert.False(t, srv.tempTableExpired(ctx, tableOptions{}, now))
+	assert.True(t, srv.tempTableExpired(ctx, tableOptions{ExpiresAt: &past, Session: key.ID}, now))
+	assert.False(t, srv.tempTableExpired(ctx, tableOptions{ExpiresAt: &future, Session: key.ID}, now))
+	assert.True(t, srv.tempTableExpired(ctx, tableOptions{ExpiresAt: &future, Session: "missing"}, now))
+
+	require.NoError(t, srv.authenticator.RevokeAPIKey(ctx, user.ID, key.ID))
+	assert.True(t, srv.tempTableExpired(ctx, tableOptions{ExpiresAt: &future, Session: key.ID}, now))
+}
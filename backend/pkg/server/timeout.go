@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultRequestTimeout bounds API requests when no timeout is configured
+const defaultRequestTimeout = 30 * time.Second
+
+// timeoutErrorCode is the code of responses to requests that ran out of time
+const timeoutErrorCode = "request_timeout"
+
+// routeTimeoutsFromEnv reads NOTABLY_ROUTE_TIMEOUTS, a comma-separated list
+// of pattern=duration pairs such as "POST /tables/{table}/archive=5m".
+// Malformed pairs are skipped.
+func routeTimeoutsFromEnv() map[string]time.Duration {
+	v := os.Getenv("NOTABLY_ROUTE_TIMEOUTS")
+	if v == "" {
+		return nil
+	}
+	timeouts := make(map[string]time.Duration)
+	for _, pair := range strings.Split(v, ",") {
+		pattern, d, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		if dur, err := time.ParseDuration(strings.TrimSpace(d)); err == nil {
+			timeouts[strings.TrimSpace(pattern)] = dur
+		}
+	}
+	return timeouts
+}
+
+// streamingRoutes hold connections open for as long as clients keep them,
+// so request timeouts do not apply to them
+var streamingRoutes = map[string]bool{"GET /realtime": true}
+
+// routeTimeout returns how long requests to a route may run, or zero when
+// they are not bounded
+func (s *Server) routeTimeout(pattern string) time.Duration {
+	if streamingRoutes[pattern] {
+		return 0
+	}
+	d, ok := s.config.RouteTimeouts[pattern]
+	if !ok {
+		d = s.config.RequestTimeout
+	}
+	switch {
+	case d < 0:
+		return 0
+	case d == 0:
+		return defaultRequestTimeout
+	}
+	return d
+}
+
+// checkRouteTimeouts reports configured route timeouts naming no route
+func (s *Server) checkRouteTimeouts() error {
+	for pattern := range s.config.RouteTimeouts {
+		if !s.routes[pattern] {
+			return fmt.Errorf("invalid config: timeouts.routes: no route %q", pattern)
+		}
+	}
+	return nil
+}
+
+// timeout ends the context of requests to a route once the route's timeout
+// passes, so store calls and waits give up. A handler failing after the
+// deadline, or returning without a response, is answered 504 with
+// timeoutErrorCode instead.
+func (s *Server) timeout(pattern string, next http.Handler) http.Handler {
+	d := s.routeTimeout(pattern)
+	if d == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		tw := &timeoutWriter{ResponseWriter: w, ctx: ctx}
+		next.ServeHTTP(tw, r.WithContext(ctx))
+		if !tw.wrote && timedOut(ctx) {
+			writeTimeout(w, "Request timed out")
+		}
+	})
+}
+
+// timedOut reports whether a request context ended at its deadline
+func timedOut(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// writeTimeout answers a request that ran out of time
+func writeTimeout(w http.ResponseWriter, message string) {
+	writeJSON(w, http.StatusGatewayTimeout, map[string]string{"error": message, "code": timeoutErrorCode})
+}
+
+// timeoutWriter replaces a server error other than a 504 written after the
+// request's deadline with a timeout response, dropping the handler's body
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	wrote    bool
+	replaced bool
+}
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+	if status >= http.StatusInternalServerError && status != http.StatusGatewayTimeout && timedOut(w.ctx) {
+		w.replaced = true
+		w.Header().Del("Retry-After")
+		writeTimeout(w.ResponseWriter, "Request timed out")
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
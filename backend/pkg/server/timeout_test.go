@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteTimeout(t *testing.T) {
+	s := &Server{config: Config{RouteTimeouts: map[string]time.Duration{
+		"GET /tables":     5 * time.Second,
+		"POST /sql/query": -1,
+	}}}
+	assert.Equal(t, defaultRequestTimeout, s.routeTimeout("GET /tables/{table}"))
+	assert.Equal(t, 5*time.Second, s.routeTimeout("GET /tables"))
+	assert.Zero(t, s.routeTimeout("POST /sql/query"), "negative timeouts disable the bound")
+
+	s.config.RequestTimeout = time.Minute
+	assert.Equal(t, time.Minute, s.routeTimeout("GET /tables/{table}"))
+
+	t.Setenv("NOTABLY_ROUTE_TIMEOUTS", "GET /tables=2s, POST /tables/{table}/rows = 1m,bad,GET /x=soon")
+	assert.Equal(t, map[string]time.Duration{
+		"GET /tables":               2 * time.Second,
+		"POST /tables/{table}/rows": time.Minute,
+	}, routeTimeoutsFromEnv())
+
+	_, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true, RouteTimeouts: map[string]time.Duration{"GET /nowhere": time.Second}})
+	assert.ErrorContains(t, err, `timeouts.routes: no route "GET /nowhere"`)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	// A handler failing because its store calls ran out of time
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true, RouteTimeouts: map[string]time.Duration{"GET /tables": time.Nanosecond}})
+	require.NoError(t, err)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/v1/tables", nil)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"code":"request_timeout"`)
+	assert.Contains(t, rec.Body.String(), "deadline exceeded")
+
+	// A handler giving up without writing a response
+	s := &Server{config: Config{RequestTimeout: time.Millisecond}}
+	h := s.timeout("GET /slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"code":"request_timeout"`)
+
+	// A server error after the deadline is replaced, a success is kept
+	h = s.timeout("GET /slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, "Store unavailable")
+	}))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	assert.Empty(t, rec.Header().Get("Retry-After"))
+	assert.NotContains(t, rec.Body.String(), "Store unavailable")
+
+	h = s.timeout("GET /slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		writeJSON(w, http.StatusOK, map[string]string{"status": "done"})
+	}))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Store errors from a deadline map to 504 wherever they surface
+	assert.Equal(t, http.StatusGatewayTimeout, storeErrorStatus(context.DeadlineExceeded))
+}
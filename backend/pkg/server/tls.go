@@ -0,0 +1,124 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// defaultRedirectAddr is where plain HTTP is redirected to HTTPS from
+const defaultRedirectAddr = ":80"
+
+// TLSConfig serves the API over HTTPS, with either a certificate and key
+// from files or certificates obtained from Let's Encrypt for AutocertDomains.
+// HTTP/2 is negotiated automatically. TLS is off when neither is set.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+
+	// AutocertDomains are the host names to obtain certificates for;
+	// AutocertCacheDir keeps them across restarts (default "autocert") and
+	// AutocertEmail is the optional Let's Encrypt account contact
+	AutocertDomains  []string
+	AutocertCacheDir string
+	AutocertEmail    string
+
+	// RedirectAddr is the plain HTTP listen address that redirects to
+	// HTTPS and answers ACME challenges (default ":80"); "off" disables it
+	RedirectAddr string
+}
+
+// enabled reports whether HTTPS is configured
+func (c TLSConfig) enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || len(c.AutocertDomains) > 0
+}
+
+// validate checks that exactly one certificate source is configured
+func (c TLSConfig) validate() error {
+	switch {
+	case (c.CertFile == "") != (c.KeyFile == ""):
+		return errors.New("tls.cert and tls.key must be set together")
+	case c.CertFile != "" && len(c.AutocertDomains) > 0:
+		return errors.New("tls.cert and tls.autocertDomains cannot both be set")
+	}
+	return nil
+}
+
+// tlsConfigFromEnv reads the TLS settings from the environment
+func tlsConfigFromEnv() TLSConfig {
+	var domains []string
+	for _, d := range strings.Split(os.Getenv("NOTABLY_AUTOCERT_DOMAINS"), ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return TLSConfig{
+		CertFile:         os.Getenv("NOTABLY_TLS_CERT"),
+		KeyFile:          os.Getenv("NOTABLY_TLS_KEY"),
+		AutocertDomains:  domains,
+		AutocertCacheDir: os.Getenv("NOTABLY_AUTOCERT_CACHE_DIR"),
+		AutocertEmail:    os.Getenv("NOTABLY_AUTOCERT_EMAIL"),
+		RedirectAddr:     os.Getenv("NOTABLY_HTTP_REDIRECT_ADDR"),
+	}
+}
+
+// serveTLS serves handler over HTTPS on the configured address, and
+// redirects plain HTTP unless the redirect listener is turned off
+func (s *Server) serveTLS(handler http.Handler) error {
+	cfg := s.config.TLS
+	srv := &http.Server{Addr: s.config.Addr, Handler: handler}
+	redirect := redirectToHTTPS(s.config.Addr)
+
+	if len(cfg.AutocertDomains) > 0 {
+		cacheDir := cfg.AutocertCacheDir
+		if cacheDir == "" {
+			cacheDir = "autocert"
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Cache:      autocert.DirCache(cacheDir),
+			Email:      cfg.AutocertEmail,
+		}
+		srv.TLSConfig = m.TLSConfig()
+		redirect = m.HTTPHandler(redirect)
+	} else {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	redirectAddr := cfg.RedirectAddr
+	if redirectAddr == "" {
+		redirectAddr = defaultRedirectAddr
+	}
+	if redirectAddr != "off" {
+		go func() {
+			s.logger.Info("redirecting plain HTTP to HTTPS", "addr", redirectAddr)
+			if err := http.ListenAndServe(redirectAddr, redirect); err != nil {
+				s.logger.Error("HTTP redirect listener failed", "addr", redirectAddr, "error", err)
+			}
+		}()
+	}
+	// Empty file names make ListenAndServeTLS use TLSConfig's certificates
+	return srv.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+}
+
+// redirectToHTTPS permanently redirects requests to the same URL over
+// HTTPS on the port of httpsAddr. 308 keeps the method and body.
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
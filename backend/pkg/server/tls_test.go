@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedirectToHTTPS(t *testing.T) {
+	for _, tc := range []struct {
+		addr, host, target, want string
+	}{
+		{":443", "api.example.com", "/v1/tables?limit=5", "https://api.example.com/v1/tables?limit=5"},
+		{":443", "api.example.com:80", "/", "https://api.example.com/"},
+		{":8443", "api.example.com", "/v1/tables", "https://api.example.com:8443/v1/tables"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.target, nil)
+		req.Host = tc.host
+		rec := httptest.NewRecorder()
+		redirectToHTTPS(tc.addr).ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusPermanentRedirect, rec.Code)
+		assert.Equal(t, tc.want, rec.Header().Get("Location"))
+	}
+}
+
+func TestTLSConfigValidate(t *testing.T) {
+	assert.False(t, TLSConfig{}.enabled())
+	assert.NoError(t, TLSConfig{}.validate())
+	assert.NoError(t, TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"}.validate())
+	assert.NoError(t, TLSConfig{AutocertDomains: []string{"api.example.com"}}.validate())
+	assert.Error(t, TLSConfig{CertFile: "cert.pem"}.validate())
+	assert.Error(t, TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", AutocertDomains: []string{"api.example.com"}}.validate())
+	assert.True(t, TLSConfig{AutocertDomains: []string{"api.example.com"}}.enabled())
+}
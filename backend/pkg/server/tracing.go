@@ -0,0 +1,117 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/elibdev/notably/pkg/tracing"
+)
+
+// defaultServiceName is the service spans are attributed to when
+// OTEL_SERVICE_NAME is unset
+const defaultServiceName = "notably"
+
+// traceparentHeader carries the W3C trace context of a request
+const traceparentHeader = "traceparent"
+
+// TracingConfig configures the export of request traces to an
+// OpenTelemetry collector
+type TracingConfig struct {
+	// Endpoint is the base URL of an OTLP/HTTP collector, such as
+	// http://localhost:4318. Tracing is disabled when it is empty.
+	Endpoint string
+
+	// Headers are sent with every export, e.g. to authenticate
+	Headers map[string]string
+
+	// ServiceName is the service the spans are attributed to
+	ServiceName string
+
+	// SampleRatio is the share of requests traced, from 0 to 1. Requests
+	// carrying a traceparent header follow its sampled flag instead.
+	SampleRatio float64
+}
+
+// tracingConfigFromEnv reads the standard OpenTelemetry exporter settings:
+// OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_HEADERS, OTEL_SERVICE_NAME
+// and OTEL_TRACES_SAMPLER_ARG
+func tracingConfigFromEnv() TracingConfig {
+	config := TracingConfig{
+		Endpoint:    os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		ServiceName: os.Getenv("OTEL_SERVICE_NAME"),
+		SampleRatio: 1,
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = defaultServiceName
+	}
+	if h := os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"); h != "" {
+		config.Headers = tracing.ParseHeaders(h)
+	}
+	if ratio, err := strconv.ParseFloat(os.Getenv("OTEL_TRACES_SAMPLER_ARG"), 64); err == nil {
+		config.SampleRatio = ratio
+	}
+	return config
+}
+
+// newTracer returns the tracer exporting to config.SpanExporter, or to the
+// configured collector, or nil when tracing is disabled
+func (s *Server) newTracer(config Config) *tracing.Tracer {
+	exporter := config.SpanExporter
+	if exporter == nil {
+		if config.Tracing.Endpoint == "" {
+			return nil
+		}
+		exporter = tracing.NewOTLPExporter(config.Tracing.Endpoint, config.Tracing.Headers, config.Tracing.ServiceName)
+	}
+	return tracing.NewTracer(exporter, tracing.Options{SampleRatio: config.Tracing.SampleRatio, Logger: s.logger})
+}
+
+// traceRequests starts a trace for each sampled request, continuing the
+// caller's trace from a traceparent header. The span is named after the
+// route pattern, which the mux sets on the request it is handed, so the name
+// is only known once the request completes.
+func (s *Server) traceRequests(next http.Handler) http.Handler {
+	if s.tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parent, _ := tracing.ParseTraceparent(r.Header.Get(traceparentHeader))
+		ctx, span := s.tracer.StartServer(r.Context(), r.Method, parent)
+		if span == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		span.SetAttributes("http.request.method", r.Method, "url.path", r.URL.Path, "request.id", logging.RequestID(ctx))
+
+		rec := &statusRecorder{ResponseWriter: w}
+		req := r.WithContext(ctx)
+		next.ServeHTTP(rec, req)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if req.Pattern != "" {
+			span.SetName(req.Pattern)
+			span.SetAttributes("http.route", req.Pattern)
+		}
+		span.SetAttributes("http.response.status_code", status)
+		if user := logging.User(ctx); user != "" {
+			span.SetAttributes("user.id", user)
+		}
+		var err error
+		if status >= http.StatusInternalServerError {
+			err = statusError(status)
+		}
+		span.End(err)
+	})
+}
+
+// statusError is the error of a request answered with a server error status
+type statusError int
+
+func (e statusError) Error() string {
+	return strconv.Itoa(int(e)) + " " + http.StatusText(int(e))
+}
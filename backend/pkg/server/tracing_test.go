@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/elibdev/notably/pkg/tracing"
+)
+
+func TestTraceRequests(t *testing.T) {
+	spans := &tracing.Recorder{}
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true, SpanExporter: spans, Tracing: TracingConfig{SampleRatio: 1}})
+	require.NoError(t, err)
+	t.Cleanup(srv.cancel)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+	require.NoError(t, err)
+	do := func(method, path, body, traceparent string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		if traceparent != "" {
+			req.Header.Set(traceparentHeader, traceparent)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	rec := do(http.MethodPost, "/tables", `{"name": "notes"}`, "")
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	require.NoError(t, srv.tracer.Flush(ctx))
+	before := len(spans.Spans())
+
+	// The caller's trace is continued
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	rec = do(http.MethodGet, "/v1/tables/notes/rows", "", parent)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, srv.tracer.Flush(ctx))
+	traced := spans.Spans()[before:]
+	require.NotEmpty(t, traced)
+	root := traced[len(traced)-1]
+	assert.Equal(t, "GET /v1/tables/{table}/rows", root.Name)
+	assert.Equal(t, tracing.KindServer, root.Kind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", root.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", root.ParentID.String())
+	assert.Equal(t, http.StatusOK, root.Attribute("http.response.status_code"))
+	assert.Equal(t, user.ID, root.Attribute("user.id"))
+	assert.NotEmpty(t, root.Attribute("request.id"))
+
+	ids := map[tracing.SpanID]string{root.SpanID: root.Name}
+	var stores int
+	for _, s := range traced[:len(traced)-1] {
+		assert.Equal(t, root.TraceID, s.TraceID)
+		if strings.HasPrefix(s.Name, "store.") {
+			stores++
+		}
+		ids[s.SpanID] = s.Name
+	}
+	assert.NotZero(t, stores, "store calls are traced")
+	for _, s := range traced[:len(traced)-1] {
+		assert.Contains(t, ids, s.ParentID, "%s has a parent in the trace", s.Name)
+	}
+
+	// Unsampled callers are not traced
+	before = len(spans.Spans())
+	rec = do(http.MethodGet, "/tables/notes/rows", "", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, srv.tracer.Flush(ctx))
+	assert.Len(t, spans.Spans(), before)
+
+	off, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true})
+	require.NoError(t, err)
+	t.Cleanup(off.cancel)
+	assert.Nil(t, off.tracer, "tracing is off without an endpoint")
+}
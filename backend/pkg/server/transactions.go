@@ -0,0 +1,381 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/plugin"
+	"github.com/elibdev/notably/pkg/script"
+)
+
+// Transaction operation types
+const (
+	txOpCreate = "create"
+	txOpUpdate = "update"
+	txOpDelete = "delete"
+)
+
+// TransactionOp is one row write of a transaction. Create may leave ID empty
+// to have one generated; delete takes no values.
+type TransactionOp struct {
+	Op     string                 `json:"op"`
+	Table  string                 `json:"table"`
+	ID     string                 `json:"id,omitempty"`
+	Values map[string]interface{} `json:"values,omitempty"`
+
+	// System columns of the row written, set in the results of creates and
+	// updates
+	CreatedAt *time.Time `json:"_createdAt,omitempty"`
+	UpdatedAt *time.Time `json:"_updatedAt,omitempty"`
+	CreatedBy string     `json:"_createdBy,omitempty"`
+}
+
+// TransactionResult is the response to a committed transaction. Every row
+// written shares its timestamp.
+type TransactionResult struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Results   []TransactionOp `json:"results"`
+}
+
+// transactionErrorResponse is the body returned for a cancelled transaction.
+// Each reason's index is the position of the operation that caused it.
+type transactionErrorResponse struct {
+	Error   string                  `json:"error"`
+	Reasons []db.CancellationReason `json:"reasons"`
+}
+
+// txTable is what a transaction needs to know about each table it writes
+type txTable struct {
+	defs      []dynamo.Fact
+	store     *db.StoreAdapter
+	validator *rowValidator
+	rows      map[string]dynamo.Fact // current rows, loaded only for updates
+}
+
+// handleTransaction applies a set of row writes across the user's tables so
+// that all of them are stored or none are. Every operation is validated,
+// run through automations and checked by plugins before anything is
+// written; the writes then go to the store in a single transaction.
+func (s *Server) handleTransaction(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	var req struct {
+		Ops []TransactionOp `json:"ops"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if fields := validateTransactionOps(req.Ops); len(fields) > 0 {
+		writeValidationError(w, "Invalid transaction", fields)
+		return
+	}
+
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Look up every table once; in isolated storage each table is its own
+	// DynamoDB table and a transaction cannot span them through one store
+	tables := make(map[string]*txTable)
+	for i, op := range req.Ops {
+		if _, seen := tables[op.Table]; seen {
+			continue
+		}
+		if len(tables) == 1 && s.resolverFor(user).Isolated() {
+			writeError(w, http.StatusBadRequest, "Transactions on accounts with isolated storage can only write one table")
+			return
+		}
+		facts, err := store.QueryByField(r.Context(), user.ID, op.Table, time.Time{}, time.Now().UTC())
+		if err != nil {
+			writeStoreError(w, err, "Failed to look up table")
+			return
+		}
+		if !s.tableVisible(r.Context(), facts) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Operation %d: Table '%s' not found", i, op.Table))
+			return
+		}
+		if tableOptionsOf(latestTableDef(facts)).Source != nil {
+			writeError(w, http.StatusConflict, fmt.Sprintf("Operation %d: Table '%s' is a virtual table and is read-only", i, op.Table))
+			return
+		}
+		rowStore, err := s.getRowStore(r.Context(), store, user, op.Table)
+		if err != nil {
+			writeStoreError(w, err, "Failed to initialize table storage")
+			return
+		}
+		tables[op.Table] = &txTable{defs: facts, store: rowStore, validator: s.newRowValidator(facts, nil)}
+	}
+
+	now := time.Now().UTC()
+	facts := make([]dynamo.Fact, len(req.Ops))
+	events := make([]plugin.RowEvent, len(req.Ops))
+	for i := range req.Ops {
+		op := &req.Ops[i]
+		t := tables[op.Table]
+		def := t.validator.def
+		ev := script.Event{Type: op.Op, Table: op.Table, Row: op.ID, Timestamp: now, Values: op.Values}
+
+		switch op.Op {
+		case txOpCreate:
+			if op.ID == "" {
+				op.ID = newID()
+				ev.Row = op.ID
+			}
+		case txOpUpdate:
+			if t.rows == nil {
+				rows, err := s.tableEntries(r.Context(), store, t.store, user, op.Table, def, now)
+				if err != nil {
+					writeStoreError(w, err, "Failed to get snapshot")
+					return
+				}
+				t.rows = rows
+				if t.rows == nil {
+					t.rows = map[string]dynamo.Fact{}
+				}
+			}
+			current, exists := t.rows[op.ID]
+			if !exists {
+				writeError(w, http.StatusNotFound, fmt.Sprintf("Operation %d: Row '%s' not found in table '%s'", i, op.ID, op.Table))
+				return
+			}
+			ev.Previous = previousValues(current)
+		}
+
+		fact := dynamo.Fact{
+			ID:        newID(),
+			Timestamp: now,
+			Namespace: tableNamespace(user.ID, op.Table),
+			FieldName: op.ID,
+			DataType:  "json",
+		}
+		switch op.Op {
+		case txOpCreate:
+			createdAt, err := s.creationForCreate(r.Context(), t.store, def, fact.Namespace, op.ID, now)
+			if err != nil {
+				writeStoreError(w, err, "Failed to look up row")
+				return
+			}
+			fact.CreatedAt = createdAt
+		case txOpUpdate:
+			fact.CreatedAt = carriedCreation(t.rows[op.ID], true, now)
+		}
+		event := plugin.RowEvent{Type: op.Op, UserID: user.ID, Table: op.Table, Row: op.ID, Timestamp: now}
+
+		if op.Op != txOpDelete {
+			if op.Op == txOpCreate {
+				t.validator.applyDefaults(op.Values)
+			}
+			values, err := s.applyAutomations(r.Context(), store, user.ID, t.defs, ev)
+			var failed *automationError
+			switch {
+			case errors.As(err, &failed):
+				writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Operation %d: %v", i, failed))
+				return
+			case err != nil:
+				writeStoreError(w, err, "Failed to load automations")
+				return
+			}
+			op.Values = values
+
+			event.Values = op.Values
+			if err := t.validator.validate(r.Context(), event); err != nil {
+				writeRowError(w, err, fmt.Sprintf("Operation %d: ", i))
+				return
+			}
+
+			fact.Value = op.Values
+			if tableOptionsOf(def).Type == tableTypeSecrets {
+				if err := s.sealRowFact(r.Context(), &fact); err != nil {
+					writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encrypt row: %v", err))
+					return
+				}
+			}
+		} else {
+			fact.DataType = dynamo.DataTypeDeleted
+		}
+		facts[i] = fact
+		events[i] = event
+	}
+
+	// References may name rows the transaction itself creates or deletes.
+	// Rows a delete would cascade to must be deleted by the transaction too.
+	refs := s.newReferenceChecker(r.Context(), store, user)
+	planner := s.newDeletePlanner(r.Context(), store, user)
+	for _, op := range req.Ops {
+		refs.expect(op.Table, op.ID, op.Op != txOpDelete)
+		planner.skip[rowRef{op.Table, op.ID}] = true
+	}
+	for i, op := range req.Ops {
+		if op.Op == txOpDelete {
+			rows, err := planner.plan(op.Table, op.ID)
+			var refused *integrityError
+			var outOfScope *scopeError
+			switch {
+			case errors.As(err, &refused):
+				writeError(w, http.StatusConflict, fmt.Sprintf("Operation %d: %v", i, err))
+				return
+			case errors.As(err, &outOfScope):
+				writeError(w, http.StatusForbidden, fmt.Sprintf("Operation %d: %v", i, err))
+				return
+			case err != nil:
+				writeStoreError(w, err, "Failed to check references")
+				return
+			case len(rows) > 1:
+				writeError(w, http.StatusConflict, fmt.Sprintf("Operation %d: Deleting row '%s' would cascade to row '%s' of table '%s'; delete it in the same transaction", i, op.ID, rows[1].row, rows[1].table))
+				return
+			}
+			continue
+		}
+		err := refs.check(tables[op.Table].validator.columns, op.Values)
+		var refErr *referenceError
+		switch {
+		case errors.As(err, &refErr):
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Operation %d: %v", i, err))
+			return
+		case err != nil:
+			writeStoreError(w, err, "Failed to check references")
+			return
+		}
+	}
+
+	// Every table shares the base store unless the account is isolated, in
+	// which case there is only one table
+	var rowStore *db.StoreAdapter
+	for _, t := range tables {
+		rowStore = t.store
+	}
+	if err := rowStore.PutFactsTransactional(r.Context(), facts); err != nil {
+		writeTransactionError(w, err)
+		return
+	}
+
+	// Indexes tolerate entries for rows that have since changed, so they are
+	// written after the commit rather than taking transaction items
+	for i, op := range req.Ops {
+		if op.Op == txOpDelete {
+			continue
+		}
+		def := tables[op.Table].validator.def
+		if err := s.indexGeoPoints(r.Context(), store, user.ID, op.Table, def.Columns, op.ID, op.Values, now); err != nil {
+			s.logger.ErrorContext(r.Context(), "indexing transaction row location failed", "table", op.Table, "row", op.ID, "operation", i, "error", err)
+		}
+		if err := s.indexExpiry(r.Context(), store, user.ID, op.Table, def, op.ID, op.Values, now); err != nil {
+			s.logger.ErrorContext(r.Context(), "indexing transaction row expiry failed", "table", op.Table, "row", op.ID, "operation", i, "error", err)
+		}
+		if err := s.indexReferences(r.Context(), store, user.ID, op.Table, def.Columns, op.ID, op.Values, now); err != nil {
+			s.logger.ErrorContext(r.Context(), "indexing transaction row references failed", "table", op.Table, "row", op.ID, "operation", i, "error", err)
+		}
+	}
+	for _, ev := range events {
+		s.publishRowEvent(ev)
+	}
+
+	if err := s.addTransactionSystemColumns(r.Context(), store, user, tables, req.Ops, facts, now); err != nil {
+		writeStoreError(w, err, "Failed to read row history")
+		return
+	}
+	writeJSON(w, http.StatusOK, TransactionResult{Timestamp: now, Results: req.Ops})
+}
+
+// addTransactionSystemColumns sets the system columns of the results of a
+// committed transaction's creates and updates, whose facts are facts
+func (s *Server) addTransactionSystemColumns(ctx context.Context, store *db.StoreAdapter, user *auth.User, tables map[string]*txTable, ops []TransactionOp, facts []dynamo.Fact, now time.Time) error {
+	rows := make(map[string][]RowData)
+	index := make(map[string][]int)
+	for i := range ops {
+		op := &ops[i]
+		op.CreatedAt, op.UpdatedAt, op.CreatedBy = nil, nil, ""
+		if op.Op == txOpDelete {
+			continue
+		}
+		rows[op.Table] = append(rows[op.Table], RowData{ID: op.ID, Timestamp: now, CreatedAt: facts[i].CreatedAt})
+		index[op.Table] = append(index[op.Table], i)
+	}
+	for table, tableRows := range rows {
+		t := tables[table]
+		if err := s.addSystemColumns(ctx, store, t.store, user, table, t.validator.def, now, tableRows); err != nil {
+			return err
+		}
+		for j, row := range tableRows {
+			op := &ops[index[table][j]]
+			op.CreatedAt, op.UpdatedAt, op.CreatedBy = row.CreatedAt, row.UpdatedAt, row.CreatedBy
+		}
+	}
+	return nil
+}
+
+// validateTransactionOps checks the shape of each operation. A row may only
+// be written once per transaction.
+func validateTransactionOps(ops []TransactionOp) []FieldError {
+	if len(ops) == 0 {
+		return []FieldError{{Field: "ops", Message: "is required"}}
+	}
+	if len(ops) > db.MaxTransactionItems {
+		return []FieldError{{Field: "ops", Message: fmt.Sprintf("must have at most %d operations", db.MaxTransactionItems)}}
+	}
+
+	var fields []FieldError
+	rows := make(map[string]int, len(ops))
+	for i, op := range ops {
+		field := func(name string) string { return fmt.Sprintf("ops[%d].%s", i, name) }
+		switch op.Op {
+		case txOpCreate, txOpUpdate, txOpDelete:
+		default:
+			fields = append(fields, FieldError{Field: field("op"), Message: "must be create, update or delete"})
+		}
+		if op.Table == "" {
+			fields = append(fields, FieldError{Field: field("table"), Message: "is required"})
+		}
+		if op.ID == "" && op.Op != txOpCreate {
+			fields = append(fields, FieldError{Field: field("id"), Message: "is required"})
+		}
+		if op.Values == nil && (op.Op == txOpCreate || op.Op == txOpUpdate) {
+			fields = append(fields, FieldError{Field: field("values"), Message: "is required"})
+		}
+		if op.Values != nil && op.Op == txOpDelete {
+			fields = append(fields, FieldError{Field: field("values"), Message: "must be empty for delete"})
+		}
+		if op.ID != "" && op.Table != "" {
+			key := op.Table + "/" + op.ID
+			if first, dup := rows[key]; dup {
+				fields = append(fields, FieldError{Field: field("id"), Message: fmt.Sprintf("row is already written by ops[%d]", first)})
+			} else {
+				rows[key] = i
+			}
+		}
+	}
+	return fields
+}
+
+// writeTransactionError reports a failed commit. A cancelled transaction
+// lists why, by operation; its status follows the most significant reason,
+// so conflicts are 409 and throttling is 503.
+func writeTransactionError(w http.ResponseWriter, err error) {
+	var txErr *db.TransactionError
+	if !errors.As(err, &txErr) {
+		writeStoreError(w, err, "Failed to commit transaction")
+		return
+	}
+	status := storeErrorStatus(err)
+	setRetryAfter(w, err, status)
+	reasons := txErr.Reasons
+	if reasons == nil {
+		reasons = []db.CancellationReason{}
+	}
+	writeJSON(w, status, transactionErrorResponse{
+		Error:   "Transaction cancelled; no rows were written",
+		Reasons: reasons,
+	})
+}
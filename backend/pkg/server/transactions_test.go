@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactions(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true})
+	require.NoError(t, err)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+	require.NoError(t, err)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	rows := func(table string) map[string]map[string]interface{} {
+		rec := do(http.MethodGet, "/tables/"+table+"/snapshot", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var snap struct {
+			Rows []RowData `json:"rows"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &snap))
+		out := map[string]map[string]interface{}{}
+		for _, r := range snap.Rows {
+			out[r.ID] = r.Values
+		}
+		return out
+	}
+
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "orders", "columns": [{"name": "qty", "dataType": "number"}]}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "stock", "columns": [{"name": "left", "dataType": "number"}]}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/stock/rows", `{"id": "sku-1", "values": {"left": 10}}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/stock/rows", `{"id": "sku-2", "values": {"left": 0}}`).Code)
+
+	rec := do(http.MethodPost, "/transactions", `{"ops": [
+		{"op": "create", "table": "orders", "id": "o-1", "values": {"qty": 2}},
+		{"op": "update", "table": "stock", "id": "sku-1", "values": {"left": 8}},
+		{"op": "delete", "table": "stock", "id": "sku-2"},
+		{"op": "create", "table": "orders", "values": {"qty": 1}}
+	]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var result TransactionResult
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	require.Len(t, result.Results, 4)
+	assert.NotEmpty(t, result.Results[3].ID, "created rows without an ID get one")
+
+	orders := rows("orders")
+	assert.Len(t, orders, 2)
+	assert.Equal(t, float64(2), orders["o-1"]["qty"])
+	assert.Equal(t, map[string]map[string]interface{}{"sku-1": {"left": float64(8)}}, rows("stock"))
+
+	// A failing operation leaves every table untouched
+	for _, tt := range []struct {
+		body   string
+		status int
+		msg    string
+	}{
+		{`{"ops": [{"op": "create", "table": "orders", "id": "o-2", "values": {"qty": 1}}, {"op": "update", "table": "stock", "id": "sku-9", "values": {"left": 1}}]}`, http.StatusNotFound, "Operation 1: Row 'sku-9' not found"},
+		{`{"ops": [{"op": "create", "table": "orders", "id": "o-2", "values": {"qty": 1}}, {"op": "create", "table": "stock", "id": "sku-3", "values": {"left": "many"}}]}`, http.StatusBadRequest, "Operation 1: Value for column 'left'"},
+		{`{"ops": [{"op": "create", "table": "orders", "id": "o-2", "values": {"qty": 1}}, {"op": "delete", "table": "missing", "id": "x"}]}`, http.StatusNotFound, "Operation 1: Table 'missing' not found"},
+	} {
+		rec := do(http.MethodPost, "/transactions", tt.body)
+		assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), tt.msg)
+	}
+	assert.NotContains(t, rows("orders"), "o-2")
+	assert.NotContains(t, rows("stock"), "sku-3")
+}
+
+func TestValidateTransactionOps(t *testing.T) {
+	assert.Equal(t, []FieldError{{Field: "ops", Message: "is required"}}, validateTransactionOps(nil))
+
+	tooMany := make([]TransactionOp, db.MaxTransactionItems+1)
+	for i := range tooMany {
+		tooMany[i] = TransactionOp{Op: txOpDelete, Table: "t", ID: fmt.Sprint(i)}
+	}
+	assert.Len(t, validateTransactionOps(tooMany), 1)
+	assert.Empty(t, validateTransactionOps(tooMany[:db.MaxTransactionItems]))
+
+	fields := validateTransactionOps([]TransactionOp{
+		{Op: "upsert", Table: "t", ID: "a", Values: map[string]interface{}{}},
+		{Op: txOpUpdate, Table: "t"},
+		{Op: txOpDelete, Table: "t", ID: "b", Values: map[string]interface{}{"x": 1}},
+		{Op: txOpDelete, Table: "t", ID: "b"},
+		{Op: txOpCreate},
+	})
+	assert.Equal(t, []FieldError{
+		{Field: "ops[0].op", Message: "must be create, update or delete"},
+		{Field: "ops[1].id", Message: "is required"},
+		{Field: "ops[1].values", Message: "is required"},
+		{Field: "ops[2].values", Message: "must be empty for delete"},
+		{Field: "ops[3].id", Message: "row is already written by ops[2]"},
+		{Field: "ops[4].table", Message: "is required"},
+		{Field: "ops[4].values", Message: "is required"},
+	}, fields)
+}
+
+func TestWriteTransactionError(t *testing.T) {
+	cancelled := &db.StoreError{Operation: "PutFactsTransactional", Err: &db.TransactionError{
+		Reasons: []db.CancellationReason{{Index: 1, Code: db.ReasonConflict}},
+	}}
+	rec := httptest.NewRecorder()
+	writeTransactionError(rec, cancelled)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.JSONEq(t, `{"error": "Transaction cancelled; no rows were written", "reasons": [{"index": 1, "code": "TransactionConflict"}]}`, rec.Body.String())
+
+	throttled := &db.StoreError{Operation: "PutFactsTransactional", Err: &db.TransactionError{
+		Reasons: []db.CancellationReason{{Index: 0, Code: db.ReasonThrottled}},
+	}}
+	rec = httptest.NewRecorder()
+	writeTransactionError(rec, throttled)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/naming"
+)
+
+// Column types whose values are strings checked against the column definition
+const (
+	// enumType columns hold one of the definition's Enum values
+	enumType = "enum"
+	// referenceType columns hold the ID of a row in the References table
+	referenceType = "reference"
+	// decimalType columns hold numbers as strings, so no precision is lost
+	// to floating point
+	decimalType = "decimal"
+	// uuidType columns hold UUIDs in the canonical 8-4-4-4-12 form
+	uuidType = "uuid"
+)
+
+// maxDecimalPrecision is the largest precision a decimal column may declare
+const maxDecimalPrecision = 38
+
+var (
+	decimalPattern = regexp.MustCompile(`^[+-]?([0-9]+)(?:\.([0-9]+))?$`)
+	uuidPattern    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// checkTypeOptions checks the type parameters of a column definition: each
+// may only be set on the type that uses it
+func checkTypeOptions(col dynamo.ColumnDefinition) error {
+	switch {
+	case col.DataType == enumType && len(col.Enum) == 0:
+		return fmt.Errorf("Column '%s' of type enum needs a list of allowed values", col.Name)
+	case col.DataType != enumType && len(col.Enum) > 0:
+		return fmt.Errorf("Column '%s' lists allowed values but is not an enum", col.Name)
+	case col.DataType == referenceType && col.References == "":
+		return fmt.Errorf("Column '%s' of type reference needs a referenced table", col.Name)
+	case col.DataType == referenceType && !naming.ValidSyntax(col.References):
+		return fmt.Errorf("Column '%s' references an invalid table name", col.Name)
+	case col.DataType != referenceType && col.References != "":
+		return fmt.Errorf("Column '%s' names a referenced table but is not a reference", col.Name)
+	case col.DataType != referenceType && col.OnDelete != "":
+		return fmt.Errorf("Column '%s' sets onDelete but is not a reference", col.Name)
+	case col.OnDelete != "" && col.OnDelete != onDeleteRestrict && col.OnDelete != onDeleteCascade:
+		return fmt.Errorf("Column '%s' has onDelete '%s'; use restrict or cascade", col.Name, col.OnDelete)
+	case col.DataType != decimalType && (col.Precision != 0 || col.Scale != 0):
+		return fmt.Errorf("Column '%s' sets a precision or scale but is not a decimal", col.Name)
+	case col.Precision < 0 || col.Precision > maxDecimalPrecision || col.Scale < 0 || (col.Precision > 0 && col.Scale > col.Precision):
+		return fmt.Errorf("Column '%s' needs a precision of at most %d and a scale between 0 and its precision", col.Name, maxDecimalPrecision)
+	}
+	seen := make(map[string]bool, len(col.Enum))
+	for _, v := range col.Enum {
+		if seen[v] {
+			return fmt.Errorf("Column '%s' lists '%s' more than once", col.Name, v)
+		}
+		seen[v] = true
+	}
+	return nil
+}
+
+// checkTypedValue checks a string value of an enum or decimal column against
+// the column's definition. Other types are fully checked by their shape.
+func checkTypedValue(col dynamo.ColumnDefinition, value string) error {
+	switch col.DataType {
+	case enumType:
+		for _, allowed := range col.Enum {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("Value for column '%s' must be one of: %s", col.Name, strings.Join(col.Enum, ", "))
+	case decimalType:
+		m := decimalPattern.FindStringSubmatch(value)
+		if m == nil {
+			return fmt.Errorf("Value for column '%s' must be a decimal number such as \"12.34\"", col.Name)
+		}
+		intDigits := len(strings.TrimLeft(m[1], "0"))
+		if len(m[2]) > col.Scale {
+			return fmt.Errorf("Value for column '%s' has more than %d digits after the decimal point", col.Name, col.Scale)
+		}
+		if col.Precision > 0 && intDigits > col.Precision-col.Scale {
+			return fmt.Errorf("Value for column '%s' has more than %d digits before the decimal point", col.Name, col.Precision-col.Scale)
+		}
+	}
+	return nil
+}
+
+// referenceError is a reference to a row or table that does not exist
+type referenceError struct {
+	column, table, row string
+	missingTable       bool
+}
+
+func (e *referenceError) Error() string {
+	if e.missingTable {
+		return fmt.Sprintf("Column '%s' references table '%s', which does not exist", e.column, e.table)
+	}
+	return fmt.Sprintf("Column '%s' references row '%s', which does not exist in table '%s'", e.column, e.row, e.table)
+}
+
+// referenceChecker checks the values of reference columns against the
+// referenced tables, loading each table's rows once
+type referenceChecker struct {
+	s     *Server
+	ctx   context.Context
+	store *db.StoreAdapter
+	user  *auth.User
+
+	rows    map[string]map[string]bool // current row IDs by table; nil for a missing table
+	pending map[string]map[string]bool // rows created (true) or deleted (false) by the same write
+}
+
+func (s *Server) newReferenceChecker(ctx context.Context, store *db.StoreAdapter, user *auth.User) *referenceChecker {
+	return &referenceChecker{
+		s: s, ctx: ctx, store: store, user: user,
+		rows:    make(map[string]map[string]bool),
+		pending: make(map[string]map[string]bool),
+	}
+}
+
+// expect records a row that the same write creates or deletes, so
+// references to it are judged as if the write had happened
+func (c *referenceChecker) expect(table, row string, exists bool) {
+	if c.pending[table] == nil {
+		c.pending[table] = make(map[string]bool)
+	}
+	c.pending[table][row] = exists
+}
+
+// check reports the first reference column whose value names a missing row.
+// Store failures are returned as they are.
+func (c *referenceChecker) check(columns []dynamo.ColumnDefinition, values map[string]interface{}) error {
+	for _, col := range columns {
+		if col.DataType != referenceType {
+			continue
+		}
+		row, ok := values[col.Name].(string)
+		if !ok {
+			continue
+		}
+		exists, ok := c.pending[col.References][row]
+		if !ok {
+			ids, err := c.load(col.References)
+			if err != nil {
+				return err
+			}
+			if ids == nil {
+				return &referenceError{column: col.Name, table: col.References, missingTable: true}
+			}
+			exists = ids[row]
+		}
+		if !exists {
+			return &referenceError{column: col.Name, table: col.References, row: row}
+		}
+	}
+	return nil
+}
+
+// load returns the row IDs of a table, or nil when the table does not exist
+func (c *referenceChecker) load(table string) (map[string]bool, error) {
+	if ids, ok := c.rows[table]; ok {
+		return ids, nil
+	}
+	defs, err := c.store.QueryByField(c.ctx, c.user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if !c.s.tableVisible(c.ctx, defs) {
+		c.rows[table] = nil
+		return nil, nil
+	}
+	rowStore, err := c.s.getRowStore(c.ctx, c.store, c.user, table)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := c.s.tableEntries(c.ctx, c.store, rowStore, c.user, table, latestTableDef(defs), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	for id, f := range entries {
+		if rowLive(f) {
+			ids[id] = true
+		}
+	}
+	c.rows[table] = ids
+	return ids, nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRichColumnTypes(t *testing.T) {
+	_, do := memoryServer(t)
+	rec := do(http.MethodPost, "/tables", `{"name": "orders", "columns": [
+		{"name": "status", "dataType": "enum", "enum": ["open", "paid"]},
+		{"name": "total", "dataType": "decimal", "precision": 6, "scale": 2},
+		{"name": "token", "dataType": "uuid"}
+	]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"enum":["open","paid"]`, "type metadata is part of the table info")
+	assert.Contains(t, rec.Body.String(), `"precision":6`)
+
+	rec = do(http.MethodPost, "/tables/orders/rows", `{"id": "o1", "values": {"status": "paid", "total": "1234.50", "token": "0F8FAD5B-D9CB-469F-A165-70867728950E"}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	for _, tt := range []struct {
+		values string
+		msg    string
+	}{
+		{`{"status": "shipped"}`, "must be one of: open, paid"},
+		{`{"status": 1}`, "does not match expected type 'enum'"},
+		{`{"total": 12.5}`, "does not match expected type 'decimal'"},
+		{`{"total": "1e3"}`, "must be a decimal number"},
+		{`{"total": "1.234"}`, "more than 2 digits after the decimal point"},
+		{`{"total": "12345.6"}`, "more than 4 digits before the decimal point"},
+		{`{"token": "not-a-uuid"}`, "does not match expected type 'uuid'"},
+	} {
+		rec := do(http.MethodPost, "/tables/orders/rows", `{"values": `+tt.values+`}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, tt.values)
+		assert.Contains(t, rec.Body.String(), tt.msg, tt.values)
+	}
+
+	for _, columns := range []string{
+		`[{"name": "s", "dataType": "enum"}]`,
+		`[{"name": "s", "dataType": "enum", "enum": ["a", "a"]}]`,
+		`[{"name": "s", "dataType": "string", "enum": ["a"]}]`,
+		`[{"name": "r", "dataType": "reference"}]`,
+		`[{"name": "d", "dataType": "decimal", "precision": 2, "scale": 3}]`,
+		`[{"name": "n", "dataType": "number", "scale": 2}]`,
+		`[{"name": "s", "dataType": "enum", "enum": ["a"], "default": "b"}]`,
+	} {
+		rec := do(http.MethodPost, "/tables", `{"name": "bad", "columns": `+columns+`}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, columns)
+	}
+}
+
+func TestReferenceColumns(t *testing.T) {
+	_, do := memoryServer(t)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "customers", "columns": [{"name": "name", "dataType": "string"}]}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/customers/rows", `{"id": "c1", "values": {"name": "Ann"}}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "orders", "columns": [
+		{"name": "customer", "dataType": "reference", "references": "customers"},
+		{"name": "missing", "dataType": "reference", "references": "nowhere", "nullable": true}
+	]}`).Code)
+
+	rec := do(http.MethodPost, "/tables/orders/rows", `{"id": "o1", "values": {"customer": "c1"}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodPost, "/tables/orders/rows", `{"values": {"customer": "c2"}}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "references row 'c2', which does not exist in table 'customers'")
+
+	rec = do(http.MethodPost, "/tables/orders/rows", `{"values": {"customer": "c1", "missing": "x"}}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "references table 'nowhere', which does not exist")
+
+	// A transaction may reference rows it creates, but not rows it deletes
+	rec = do(http.MethodPost, "/transactions", `{"ops": [
+		{"op": "create", "table": "customers", "id": "c2", "values": {"name": "Bo"}},
+		{"op": "create", "table": "orders", "id": "o2", "values": {"customer": "c2"}}
+	]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = do(http.MethodPost, "/transactions", `{"ops": [
+		{"op": "delete", "table": "customers", "id": "c1"},
+		{"op": "create", "table": "orders", "values": {"customer": "c1"}}
+	]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Operation 1: Column 'customer' references row 'c1'")
+}
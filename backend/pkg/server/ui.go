@@ -0,0 +1,544 @@
+package server
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/pkg/auth"
+)
+
+const (
+	// uiCookie holds the API key of a UI session. It is a login key like
+	// those POST /auth/login returns, revoked when the user signs out.
+	uiCookie = "notably_session"
+	// uiPageSize is the number of rows or changes on a page
+	uiPageSize = 50
+	// uiHistorySpan is the range the history page shows by default
+	uiHistorySpan = 24 * time.Hour
+)
+
+// uiInputTime is the layout of datetime-local inputs, read as UTC
+const uiInputTime = "2006-01-02T15:04:05"
+
+//go:embed ui/*.html
+var uiFiles embed.FS
+
+// uiTemplates holds a template per page, each executed as "layout"
+var uiTemplates = parseUITemplates()
+
+func parseUITemplates() map[string]*template.Template {
+	funcs := template.FuncMap{
+		"timestamp":  func(t time.Time) string { return t.UTC().Format(time.RFC3339Nano) },
+		"value":      uiValue,
+		"tableURL":   func(table string) string { return uiTableURL(table, time.Time{}) },
+		"tableAtURL": uiTableURL,
+		"historyURL": func(table string) string {
+			return "/ui/tables/" + url.PathEscape(table) + "/history"
+		},
+		"rowURL":   func(table, id string) string { return uiRowURL(table, id, time.Time{}) },
+		"rowAtURL": uiRowURL,
+	}
+	base := template.Must(template.New("").Funcs(funcs).ParseFS(uiFiles, "ui/layout.html", "ui/pages.html"))
+	pages := make(map[string]*template.Template)
+	for _, page := range []string{"login", "tables", "table", "history", "row", "error"} {
+		pages[page] = template.Must(template.Must(base.Clone()).ParseFS(uiFiles, "ui/"+page+".html"))
+	}
+	return pages
+}
+
+// uiTableURL returns the page of a table's rows, as of at unless it is zero
+func uiTableURL(table string, at time.Time) string {
+	u := "/ui/tables/" + url.PathEscape(table)
+	if !at.IsZero() {
+		u += "?at=" + url.QueryEscape(at.UTC().Format(time.RFC3339Nano))
+	}
+	return u
+}
+
+// uiRowURL returns the page of a row, as of at unless it is zero
+func uiRowURL(table, id string, at time.Time) string {
+	u := "/ui/tables/" + url.PathEscape(table) + "/rows/" + url.PathEscape(id)
+	if !at.IsZero() {
+		u += "?at=" + url.QueryEscape(at.UTC().Format(time.RFC3339Nano))
+	}
+	return u
+}
+
+// uiValue renders a row value: strings as they are, anything else as JSON
+func uiValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	// The page escapes the JSON as it is written
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// uiPage is what every page shows: the signed-in user, if any
+type uiPage struct {
+	User string
+}
+
+// uiPages is the pagination of a listing
+type uiPages struct {
+	Number, Count, Total int
+	Prev, Next           string
+}
+
+// paginate returns the bounds of the requested page of n items, and links
+// to its neighbours
+func paginate(r *http.Request, n int) (uiPages, int, int) {
+	p := uiPages{Number: 1, Count: max(1, int(math.Ceil(float64(n)/uiPageSize))), Total: n}
+	fmt.Sscan(r.URL.Query().Get("page"), &p.Number)
+	p.Number = min(max(p.Number, 1), p.Count)
+	link := func(page int) string {
+		q := r.URL.Query()
+		q.Set("page", fmt.Sprint(page))
+		return r.URL.Path + "?" + q.Encode()
+	}
+	if p.Number > 1 {
+		p.Prev = link(p.Number - 1)
+	}
+	if p.Number < p.Count {
+		p.Next = link(p.Number + 1)
+	}
+	start := (p.Number - 1) * uiPageSize
+	return p, start, min(start+uiPageSize, n)
+}
+
+// uiError is an API error shown on a page
+type uiError struct {
+	Status  int
+	Message string
+}
+
+func (e *uiError) Error() string {
+	return e.Message
+}
+
+// uiCall runs an API handler for a page and decodes its JSON answer into
+// v, so the UI shows exactly what the API would return. Errors of the
+// handler are returned as *uiError.
+func uiCall(r *http.Request, h http.HandlerFunc, method, body string, query url.Values, pathValues map[string]string, v interface{}) error {
+	req := r.Clone(r.Context())
+	req.Method = method
+	req.URL.RawQuery = query.Encode()
+	req.Body = io.NopCloser(strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Del("If-None-Match")
+	for name, value := range pathValues {
+		req.SetPathValue(name, value)
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	if rec.Code >= http.StatusBadRequest {
+		var answer struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(rec.Body.Bytes(), &answer) != nil || answer.Error == "" {
+			answer.Error = http.StatusText(rec.Code)
+		}
+		return &uiError{Status: rec.Code, Message: answer.Error}
+	}
+	return json.Unmarshal(rec.Body.Bytes(), v)
+}
+
+// parseUITime reads a time given as RFC 3339 or by a datetime-local input,
+// returning the zero time when it is empty
+func parseUITime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, uiInputTime, "2006-01-02T15:04"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, &uiError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid time '%s'", value)}
+}
+
+// inputTime formats a time for a datetime-local input
+func inputTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(uiInputTime)
+}
+
+// render writes a page, or the error page when data is an error
+func (s *Server) render(w http.ResponseWriter, r *http.Request, page string, data interface{}) {
+	err, ok := data.(error)
+	if !ok {
+		s.writePage(w, r, http.StatusOK, page, data)
+		return
+	}
+	status, message := http.StatusInternalServerError, "Failed to load the page"
+	var uiErr *uiError
+	if errors.As(err, &uiErr) {
+		status, message = uiErr.Status, uiErr.Message
+	} else {
+		s.logger.ErrorContext(r.Context(), "loading UI page failed", "path", r.URL.Path, "error", err)
+	}
+	s.writePage(w, r, status, "error", struct {
+		uiPage
+		Status  string
+		Message string
+	}{uiPageOf(r), http.StatusText(status), message})
+}
+
+// writePage executes a page's template
+func (s *Server) writePage(w http.ResponseWriter, r *http.Request, status int, page string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	if err := uiTemplates[page].ExecuteTemplate(w, "layout", data); err != nil {
+		s.logger.ErrorContext(r.Context(), "rendering UI page failed", "page", page, "error", err)
+	}
+}
+
+// uiPageOf returns the common page data of a request
+func uiPageOf(r *http.Request) uiPage {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		return uiPage{}
+	}
+	return uiPage{User: user.Username}
+}
+
+// requireUISession authenticates UI pages by the session cookie, sending
+// visitors without a valid session to the sign-in page. The session's key
+// is then checked like the API's keys, scopes and rate limits included.
+func (s *Server) requireUISession(h http.HandlerFunc) http.Handler {
+	authed := s.requireAuth(h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(uiCookie)
+		if err == nil {
+			_, _, err = s.authenticator.VerifyAPIKey(r.Context(), cookie.Value)
+		}
+		if err != nil {
+			clearUISession(w, r)
+			http.Redirect(w, r, "/ui/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+cookie.Value)
+		authed.ServeHTTP(w, r)
+	})
+}
+
+// setUISession starts a session with an API key
+func setUISession(w http.ResponseWriter, r *http.Request, key string) {
+	http.SetCookie(w, &http.Cookie{Name: uiCookie, Value: key, Path: "/ui", HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteStrictMode})
+}
+
+// clearUISession ends the session of the browser
+func clearUISession(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: uiCookie, Path: "/ui", MaxAge: -1, HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteStrictMode})
+}
+
+// uiNext returns where to go after signing in: a UI page, or the table list
+func uiNext(next string) string {
+	if strings.HasPrefix(next, "/ui/") && !strings.HasPrefix(next, "/ui//") {
+		return next
+	}
+	return "/ui/"
+}
+
+// registerUIRoutes serves the UI under /ui when it is enabled
+func (s *Server) registerUIRoutes() {
+	if !s.config.UI {
+		return
+	}
+	ui := func(pattern string, h http.Handler) {
+		s.mux.Handle(pattern, s.timeout(pattern, h))
+	}
+	ui("GET /ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently))
+	ui("GET /ui/login", http.HandlerFunc(s.handleUILoginPage))
+	ui("POST /ui/login", http.HandlerFunc(s.handleUILogin))
+	ui("POST /ui/logout", http.HandlerFunc(s.handleUILogout))
+	ui("GET /ui/{$}", s.requireUISession(s.handleUITables))
+	ui("GET /ui/tables/{table}", s.requireUISession(s.handleUITable))
+	ui("GET /ui/tables/{table}/history", s.requireUISession(s.handleUIHistory))
+	ui("GET /ui/tables/{table}/rows/{id}", s.requireUISession(s.handleUIRow))
+}
+
+// uiLogin is the data of the sign-in page
+type uiLogin struct {
+	uiPage
+	Next     string
+	Username string
+	Error    string
+}
+
+// handleUILoginPage shows the sign-in form
+func (s *Server) handleUILoginPage(w http.ResponseWriter, r *http.Request) {
+	s.render(w, r, "login", uiLogin{Next: uiNext(r.URL.Query().Get("next"))})
+}
+
+// handleUILogin signs in through POST /auth/login, keeping the key it
+// returns in the session cookie
+func (s *Server) handleUILogin(w http.ResponseWriter, r *http.Request) {
+	page := uiLogin{Next: uiNext(r.FormValue("next")), Username: r.FormValue("username")}
+	body, err := json.Marshal(map[string]string{"username": page.Username, "password": r.FormValue("password")})
+	if err != nil {
+		s.render(w, r, "login", err)
+		return
+	}
+	var login struct {
+		APIKey string `json:"apiKey"`
+	}
+	err = uiCall(r, s.handleLogin, http.MethodPost, string(body), nil, nil, &login)
+	var uiErr *uiError
+	if errors.As(err, &uiErr) && uiErr.Status < http.StatusInternalServerError {
+		page.Error = uiErr.Message
+		s.writePage(w, r, uiErr.Status, "login", page)
+		return
+	}
+	if err != nil {
+		s.render(w, r, "login", err)
+		return
+	}
+	setUISession(w, r, login.APIKey)
+	http.Redirect(w, r, page.Next, http.StatusSeeOther)
+}
+
+// handleUILogout revokes the session's key and ends the session
+func (s *Server) handleUILogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(uiCookie); err == nil {
+		if user, key, err := s.authenticator.VerifyAPIKey(r.Context(), cookie.Value); err == nil {
+			if err := s.authenticator.RevokeAPIKey(r.Context(), user.ID, key.ID); err != nil {
+				s.logger.WarnContext(r.Context(), "revoking UI session key failed", "error", err)
+			}
+		}
+	}
+	clearUISession(w, r)
+	http.Redirect(w, r, "/ui/login", http.StatusSeeOther)
+}
+
+// uiTables loads the tables of the signed-in user through GET /tables
+func (s *Server) uiTables(r *http.Request) ([]TableInfo, error) {
+	var list struct {
+		Tables []TableInfo `json:"tables"`
+	}
+	err := uiCall(r, s.handleListTables, http.MethodGet, "", nil, nil, &list)
+	return list.Tables, err
+}
+
+// uiTable loads the definition of the table a page is about
+func (s *Server) uiTable(r *http.Request) (TableInfo, error) {
+	tables, err := s.uiTables(r)
+	if err != nil {
+		return TableInfo{}, err
+	}
+	name := r.PathValue("table")
+	for _, t := range tables {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return TableInfo{}, &uiError{Status: http.StatusNotFound, Message: fmt.Sprintf("Table '%s' not found", name)}
+}
+
+// uiRows loads the rows of a table as of at through GET /tables/{table}/rows,
+// ordered by ID
+func (s *Server) uiRows(r *http.Request, table string, at time.Time) ([]RowData, error) {
+	q := url.Values{}
+	if !at.IsZero() {
+		q.Set("at", at.Format(time.RFC3339Nano))
+	}
+	var list struct {
+		Rows []RowData `json:"rows"`
+	}
+	if err := uiCall(r, s.handleListRows, http.MethodGet, "", q, map[string]string{"table": table}, &list); err != nil {
+		return nil, err
+	}
+	sort.Slice(list.Rows, func(i, j int) bool { return list.Rows[i].ID < list.Rows[j].ID })
+	return list.Rows, nil
+}
+
+// uiHistory loads the changes of a table in [start, end] through
+// GET /tables/{table}/history, newest first
+func (s *Server) uiHistory(r *http.Request, table string, start, end time.Time) ([]RowEvent, error) {
+	q := url.Values{"start": {start.Format(time.RFC3339Nano)}, "end": {end.Format(time.RFC3339Nano)}}
+	var list struct {
+		Events []RowEvent `json:"events"`
+	}
+	if err := uiCall(r, s.handleTableHistory, http.MethodGet, "", q, map[string]string{"table": table}, &list); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(list.Events, func(i, j int) bool { return list.Events[i].Timestamp.After(list.Events[j].Timestamp) })
+	return list.Events, nil
+}
+
+// uiColumns returns the columns to show: the table's own, or else every
+// value name of the rows
+func uiColumns(table TableInfo, rows []RowData) []string {
+	var names []string
+	for _, col := range table.Columns {
+		names = append(names, col.Name)
+	}
+	if len(names) > 0 {
+		return names
+	}
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		for name := range row.Values {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// handleUITables lists the user's tables
+func (s *Server) handleUITables(w http.ResponseWriter, r *http.Request) {
+	tables, err := s.uiTables(r)
+	if err != nil {
+		s.render(w, r, "tables", err)
+		return
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	s.render(w, r, "tables", struct {
+		uiPage
+		Tables []TableInfo
+	}{uiPageOf(r), tables})
+}
+
+// handleUITable shows a page of a table's rows, now or as of ?at
+func (s *Server) handleUITable(w http.ResponseWriter, r *http.Request) {
+	at, err := parseUITime(r.URL.Query().Get("at"))
+	if err != nil {
+		s.render(w, r, "table", err)
+		return
+	}
+	table, err := s.uiTable(r)
+	if err != nil {
+		s.render(w, r, "table", err)
+		return
+	}
+	rows, err := s.uiRows(r, table.Name, at)
+	if err != nil {
+		s.render(w, r, "table", err)
+		return
+	}
+	pages, start, end := paginate(r, len(rows))
+	s.render(w, r, "table", struct {
+		uiPage
+		Table   TableInfo
+		At      time.Time
+		AtInput string
+		Columns []string
+		Rows    []RowData
+		Page    uiPages
+	}{uiPageOf(r), table, at, inputTime(at), uiColumns(table, rows), rows[start:end], pages})
+}
+
+// handleUIHistory shows a page of a table's changes in a time range, the
+// last day by default
+func (s *Server) handleUIHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	end, err := parseUITime(q.Get("end"))
+	if err != nil {
+		s.render(w, r, "history", err)
+		return
+	}
+	if end.IsZero() {
+		end = time.Now().UTC()
+	}
+	start, err := parseUITime(q.Get("start"))
+	if err != nil {
+		s.render(w, r, "history", err)
+		return
+	}
+	if start.IsZero() {
+		start = end.Add(-uiHistorySpan)
+	}
+	table := r.PathValue("table")
+	events, err := s.uiHistory(r, table, start, end)
+	if err != nil {
+		s.render(w, r, "history", err)
+		return
+	}
+	pages, first, last := paginate(r, len(events))
+	s.render(w, r, "history", struct {
+		uiPage
+		Table                string
+		StartInput, EndInput string
+		Events               []RowEvent
+		Page                 uiPages
+	}{uiPageOf(r), table, inputTime(start), inputTime(end), events[first:last], pages})
+}
+
+// handleUIRow shows a row, now or as of ?at, with all its changes
+func (s *Server) handleUIRow(w http.ResponseWriter, r *http.Request) {
+	at, err := parseUITime(r.URL.Query().Get("at"))
+	if err != nil {
+		s.render(w, r, "row", err)
+		return
+	}
+	table, err := s.uiTable(r)
+	if err != nil {
+		s.render(w, r, "row", err)
+		return
+	}
+	id := r.PathValue("id")
+	rows, err := s.uiRows(r, table.Name, at)
+	if err != nil {
+		s.render(w, r, "row", err)
+		return
+	}
+	var row *RowData
+	for i := range rows {
+		if rows[i].ID == id {
+			row = &rows[i]
+		}
+	}
+	history, err := s.uiHistory(r, table.Name, time.Time{}, time.Now().UTC())
+	if err != nil {
+		s.render(w, r, "row", err)
+		return
+	}
+	events := []RowEvent{}
+	for _, e := range history {
+		if e.ID == id {
+			events = append(events, e)
+		}
+	}
+	var shown []RowData
+	if row != nil {
+		shown = []RowData{*row}
+	}
+	s.render(w, r, "row", struct {
+		uiPage
+		Table   string
+		ID      string
+		At      time.Time
+		AtInput string
+		Row     *RowData
+		Columns []string
+		Events  []RowEvent
+	}{uiPageOf(r), table.Name, id, at, inputTime(at), row, uiColumns(table, shown), events})
+}
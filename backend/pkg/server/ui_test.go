@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elibdev/notably/pkg/logging"
+)
+
+func TestUI(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true, UI: true})
+	require.NoError(t, err)
+	ctx := context.Background()
+	user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+	require.NoError(t, err)
+	_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "default", 0)
+	require.NoError(t, err)
+	api := func(method, path, body string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		require.Less(t, rec.Code, 300, rec.Body.String())
+	}
+	var session *http.Cookie
+	page := func(method, path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "text/html")
+		if session != nil {
+			req.AddCookie(session)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	api(http.MethodPost, "/v1/tables", `{"name": "notes", "columns": [{"name": "title", "dataType": "string"}]}`)
+	api(http.MethodPost, "/v1/tables/notes/rows", `{"id": "n1", "values": {"title": "<b>first</b>"}}`)
+	time.Sleep(5 * time.Millisecond)
+	before := time.Now().UTC()
+	time.Sleep(5 * time.Millisecond)
+	api(http.MethodPut, "/v1/tables/notes/rows/n1", `{"values": {"title": "renamed"}}`)
+	for i := 0; i < uiPageSize; i++ {
+		api(http.MethodPost, "/v1/tables/notes/rows", fmt.Sprintf(`{"id": "p%02d", "values": {"title": "row %d"}}`, i, i))
+	}
+
+	// Pages need a session
+	rec := page(http.MethodGet, "/ui/tables/notes", nil)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/ui/login?next=%2Fui%2Ftables%2Fnotes", rec.Header().Get("Location"))
+
+	rec = page(http.MethodPost, "/ui/login", url.Values{"username": {"alice"}, "password": {"wrong"}})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Invalid credentials")
+
+	rec = page(http.MethodPost, "/ui/login", url.Values{"username": {"alice"}, "password": {"pw"}, "next": {"/ui/tables/notes"}})
+	require.Equal(t, http.StatusSeeOther, rec.Code, rec.Body.String())
+	assert.Equal(t, "/ui/tables/notes", rec.Header().Get("Location"))
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	session = cookies[0]
+	assert.True(t, session.HttpOnly)
+	assert.Equal(t, http.SameSiteStrictMode, session.SameSite)
+
+	rec = page(http.MethodGet, "/ui/", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `href="/ui/tables/notes"`)
+	assert.Contains(t, rec.Body.String(), "alice")
+
+	// Rows are paginated, ordered by ID
+	rec = page(http.MethodGet, "/ui/tables/notes", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	body := rec.Body.String()
+	assert.Contains(t, body, "51 rows")
+	assert.Contains(t, body, "renamed")
+	assert.Contains(t, body, "Page 1 of 2")
+	assert.NotContains(t, body, ">p49<")
+	rec = page(http.MethodGet, "/ui/tables/notes?page=2", nil)
+	assert.Contains(t, rec.Body.String(), ">p49<")
+	assert.NotContains(t, rec.Body.String(), ">n1<")
+
+	// Time travel shows the table as it was, values escaped
+	at := url.QueryEscape(before.Format(time.RFC3339Nano))
+	rec = page(http.MethodGet, "/ui/tables/notes?at="+at, nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	body = rec.Body.String()
+	assert.Contains(t, body, "1 rows as of")
+	assert.Contains(t, body, "&lt;b&gt;first&lt;/b&gt;")
+	assert.NotContains(t, body, "<b>first</b>")
+	rec = page(http.MethodGet, "/ui/tables/notes?at="+url.QueryEscape(before.Format(uiInputTime)), nil)
+	assert.Equal(t, http.StatusOK, rec.Code, "times of datetime-local inputs are accepted")
+
+	// A row shows its value then and every change
+	api(http.MethodDelete, "/v1/tables/notes/rows/p00", "")
+	rec = page(http.MethodGet, "/ui/tables/notes/rows/n1", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	body = rec.Body.String()
+	assert.Contains(t, body, "renamed")
+	assert.Contains(t, body, "&lt;b&gt;first&lt;/b&gt;", "the first version is in the changes")
+	rec = page(http.MethodGet, "/ui/tables/notes/rows/p00", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), "did not exist now")
+	assert.Contains(t, rec.Body.String(), "deleted")
+
+	rec = page(http.MethodGet, "/ui/tables/notes/history", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), "53 changes")
+
+	rec = page(http.MethodGet, "/ui/tables/missing", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Table &#39;missing&#39; not found")
+	assert.Equal(t, http.StatusBadRequest, page(http.MethodGet, "/ui/tables/notes?at=yesterday", nil).Code)
+
+	// Signing out revokes the session's key
+	rec = page(http.MethodPost, "/ui/logout", nil)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, http.StatusSeeOther, page(http.MethodGet, "/ui/", nil).Code)
+
+	// The UI is opt-in
+	_, do := memoryServer(t)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/ui/", "").Code)
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/elibdev/notably/pkg/auth"
+)
+
+// NewAuthenticator returns an authenticator of the configured accounts, with
+// the configured key and password settings. Accounts are kept in
+// config.UsersFile, or in memory, and lost on restart, when it is not set;
+// notably-admin provisions them through the file while the server runs.
+func NewAuthenticator(config Config) (*auth.Authenticator, auth.UserStore, error) {
+	var store auth.UserStore = auth.NewInMemoryUserStore()
+	if config.UsersFile != "" {
+		fileStore, err := auth.OpenFileUserStore(config.UsersFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		store = fileStore
+	}
+	secret, err := apiKeySecret(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	authenticator := auth.NewAuthenticator(store)
+	authenticator.SetKeyExpiration(config.APIKeyExpiration)
+	authenticator.SetKeyHashSecret(secret)
+	if err := authenticator.SetBcryptCost(config.BcryptCost); err != nil {
+		return nil, nil, err
+	}
+	authenticator.SetLockoutPolicy(config.Lockout)
+	return authenticator, store, nil
+}
+
+// apiKeySecret returns the HMAC key of stored API key digests: the
+// configured secret or, without one, a secret generated once and kept beside
+// the users file, so the server and notably-admin digest keys alike.
+// Accounts kept in memory get a random secret, as their keys are lost on
+// restart anyway.
+func apiKeySecret(config Config) ([]byte, error) {
+	if config.APIKeySecret != "" {
+		return []byte(config.APIKeySecret), nil
+	}
+	if config.UsersFile == "" {
+		return randomSecret()
+	}
+	path := apiKeySecretFile(config.UsersFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return createSecretFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading API key secret: %w", err)
+	}
+	return []byte(strings.TrimSpace(string(data))), nil
+}
+
+// apiKeySecretFile is the file the generated API key secret of a users file
+// is kept in
+func apiKeySecretFile(usersFile string) string {
+	return usersFile + ".key-secret"
+}
+
+// createSecretFile generates a secret and stores it at path. The file is
+// written aside and linked into place, so a process starting at the same
+// time reads the whole secret or, if it wins, has its own kept instead.
+func createSecretFile(path string) ([]byte, error) {
+	secret, err := randomSecret()
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("creating API key secret: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(secret, '\n')); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("writing API key secret: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("writing API key secret: %w", err)
+	}
+	if err := os.Link(tmp.Name(), path); errors.Is(err, fs.ErrExist) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading API key secret: %w", err)
+		}
+		return []byte(strings.TrimSpace(string(data))), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("storing API key secret: %w", err)
+	}
+	return secret, nil
+}
+
+// randomSecret returns 32 random bytes, hex-encoded
+func randomSecret() ([]byte, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("generating API key secret: %w", err)
+	}
+	return []byte(hex.EncodeToString(b)), nil
+}
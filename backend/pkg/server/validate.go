@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/plugin"
+)
+
+// rowViolation is a row write rejected by its table's schema, its
+// references or a validation plugin; the request cannot succeed as it is
+type rowViolation struct {
+	err error
+}
+
+func (e *rowViolation) Error() string { return e.err.Error() }
+func (e *rowViolation) Unwrap() error { return e.err }
+
+// rowValidator checks the rows written to one table against its schema,
+// resolved once from the table's definition facts. Every path writing row
+// values goes through it: create, update and patch, transactions and, with
+// check alone, metric ingestion.
+type rowValidator struct {
+	s       *Server
+	def     dynamo.Fact
+	columns []dynamo.ColumnDefinition
+	// refs checks reference columns; nil leaves them to the caller, as
+	// transactions check them once every row of the transaction is known
+	refs *referenceChecker
+}
+
+// newRowValidator returns the validator of a table from its definition facts
+func (s *Server) newRowValidator(defs []dynamo.Fact, refs *referenceChecker) *rowValidator {
+	def := latestTableDef(defs)
+	return &rowValidator{s: s, def: def, columns: def.Columns, refs: refs}
+}
+
+// newRowValidatorFor returns the validator of a table whose reference
+// columns are checked against the user's current rows
+func (s *Server) newRowValidatorFor(ctx context.Context, store *db.StoreAdapter, user *auth.User, defs []dynamo.Fact) *rowValidator {
+	return s.newRowValidator(defs, s.newReferenceChecker(ctx, store, user))
+}
+
+// options returns the table's options
+func (v *rowValidator) options() tableOptions {
+	return tableOptionsOf(v.def)
+}
+
+// applyDefaults fills the columns a new row leaves out with their defaults
+func (v *rowValidator) applyDefaults(values map[string]interface{}) {
+	applyDefaults(v.columns, values)
+}
+
+// validate checks a row event against the table's schema with check, then
+// lets the validation plugins reject it
+func (v *rowValidator) validate(ctx context.Context, event plugin.RowEvent) error {
+	if err := v.check(event.Values, event.Type == "create"); err != nil {
+		return err
+	}
+	if err := v.s.plugins.Validate(ctx, event); err != nil {
+		return &rowViolation{fmt.Errorf("Row rejected by %v", err)}
+	}
+	return nil
+}
+
+// check checks the values of a row: that every value belongs to a column
+// of matching type, that a created row has its required columns and that
+// references name existing rows. Rejections are *rowViolation errors; any
+// other error is the store's.
+func (v *rowValidator) check(values map[string]interface{}, create bool) error {
+	if err := v.s.checkRowValues(v.columns, values); err != nil {
+		return &rowViolation{err}
+	}
+	if create {
+		if err := checkRequired(v.columns, values); err != nil {
+			return &rowViolation{err}
+		}
+	}
+	if v.refs != nil {
+		err := v.refs.check(v.columns, values)
+		var refErr *referenceError
+		if errors.As(err, &refErr) {
+			return &rowViolation{err}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRowError writes the response of a failed validation, prefixing the
+// message of a rejection
+func writeRowError(w http.ResponseWriter, err error, prefix string) {
+	var violation *rowViolation
+	if errors.As(err, &violation) {
+		writeError(w, http.StatusBadRequest, prefix+violation.Error())
+		return
+	}
+	writeStoreError(w, err, "Failed to check references")
+}
+
+// checkRowValues reports the first value that is not a defined column or
+// does not match its column's type. Tables without columns accept any values.
+func (s *Server) checkRowValues(columns []dynamo.ColumnDefinition, values map[string]interface{}) error {
+	for name := range values {
+		if isSystemColumn(name) {
+			return fmt.Errorf("Column '%s' is maintained by the server and cannot be written", name)
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	for colName, value := range values {
+		// Check if column is defined
+		found := false
+		var colDef dynamo.ColumnDefinition
+
+		for _, col := range columns {
+			if col.Name == colName {
+				found = true
+				colDef = col
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("Column '%s' is not defined in table schema", colName)
+		}
+
+		if err := s.checkColumnValue(colDef, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkColumnValue checks one value against its column's type and nullability
+func (s *Server) checkColumnValue(col dynamo.ColumnDefinition, value interface{}) error {
+	if value == nil {
+		if col.Nullable {
+			return nil
+		}
+		return fmt.Errorf("Column '%s' cannot be null", col.Name)
+	}
+	if !s.validColumnValue(value, col.DataType) {
+		return fmt.Errorf("Value for column '%s' does not match expected type '%s'", col.Name, col.DataType)
+	}
+	if str, ok := value.(string); ok {
+		return checkTypedValue(col, str)
+	}
+	return nil
+}
+
+// checkRequired reports the first required column a new row leaves out
+func checkRequired(columns []dynamo.ColumnDefinition, values map[string]interface{}) error {
+	for _, col := range columns {
+		if _, ok := values[col.Name]; col.Required && !ok {
+			return fmt.Errorf("Column '%s' is required", col.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRowValidationOnEveryWritePath(t *testing.T) {
+	_, do := memoryServer(t)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "tasks", "columns": [
+		{"name": "title", "dataType": "string", "required": true},
+		{"name": "points", "dataType": "number"}
+	]}`).Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/tasks/rows", `{"id": "t1", "values": {"title": "a", "points": 1}}`).Code)
+
+	for _, tt := range []struct {
+		method, path, body string
+		msg                string
+	}{
+		{http.MethodPut, "/tables/tasks/rows/t1", `{"values": {"title": "a", "points": "many"}}`, "does not match expected type 'number'"},
+		{http.MethodPut, "/tables/tasks/rows/t1", `{"values": {"title": "a", "owner": "me"}}`, "Column 'owner' is not defined"},
+		{http.MethodPatch, "/tables/tasks/rows/t1", `{"values": {"points": "many"}}`, "does not match expected type 'number'"},
+		{http.MethodPost, "/transactions", `{"ops": [{"op": "update", "table": "tasks", "id": "t1", "values": {"points": true}}]}`, "Operation 0: Value for column 'points'"},
+		{http.MethodPost, "/transactions", `{"ops": [{"op": "create", "table": "tasks", "values": {"points": 3}}]}`, "Operation 0: Column 'title' is required"},
+	} {
+		rec := do(tt.method, tt.path, tt.body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "%s %s %s", tt.method, tt.path, tt.body)
+		assert.Contains(t, rec.Body.String(), tt.msg, tt.body)
+	}
+
+	rec := do(http.MethodPatch, "/tables/tasks/rows/t1", `{"values": {"points": 2}}`)
+	assert.Equal(t, http.StatusOK, rec.Code, "patches are checked once merged into the row: %s", rec.Body.String())
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiVersion is the path prefix of the current API version
+const apiVersion = "/v1"
+
+// unversionedDeprecatedAt is when the unversioned paths were deprecated in
+// favor of apiVersion, as sent in the Deprecation header
+var unversionedDeprecatedAt = time.Date(2026, time.October, 14, 0, 0, 0, 0, time.UTC)
+
+// route registers an API route under apiVersion, and at its unversioned path
+// through deprecated so existing clients keep working. The pattern is a
+// ServeMux pattern with a method, such as "GET /tables/{table}".
+func (s *Server) route(pattern string, h http.Handler) {
+	s.routes[pattern] = true
+	h = s.timeout(pattern, h)
+	method, path, _ := strings.Cut(pattern, " ")
+	s.mux.Handle(method+" "+apiVersion+path, h)
+	s.mux.Handle(pattern, s.deprecated(h))
+}
+
+// deprecated marks responses on unversioned paths with the Deprecation
+// header (RFC 9745), a Link to the versioned path and, once a removal date
+// is configured, the Sunset header (RFC 8594)
+func (s *Server) deprecated(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("Deprecation", "@"+strconv.FormatInt(unversionedDeprecatedAt.Unix(), 10))
+		header.Add("Link", "<"+apiVersion+r.URL.Path+">; rel=\"successor-version\"")
+		if sunset := s.config.UnversionedSunset; !sunset.IsZero() {
+			header.Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// sunsetFromEnv reads NOTABLY_UNVERSIONED_SUNSET, the date (YYYY-MM-DD) or
+// RFC 3339 time after which unversioned paths may be removed
+func sunsetFromEnv() time.Time {
+	v := os.Getenv("NOTABLY_UNVERSIONED_SUNSET")
+	for _, layout := range []string{time.DateOnly, time.RFC3339} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionedRoutes(t *testing.T) {
+	srv, do := memoryServer(t)
+
+	rec := do(http.MethodPost, "/v1/tables", `{"name": "notes", "columns": [{"name": "title", "dataType": "string"}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Empty(t, rec.Header().Get("Deprecation"))
+
+	rec = do(http.MethodGet, "/tables/notes/snapshot", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "@1791936000", rec.Header().Get("Deprecation"))
+	assert.Equal(t, `</v1/tables/notes/snapshot>; rel="successor-version"`, rec.Header().Get("Link"))
+	assert.Empty(t, rec.Header().Get("Sunset"), "no sunset until one is configured")
+
+	srv.config.UnversionedSunset = time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC)
+	rec = do(http.MethodGet, "/tables", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "Thu, 01 Apr 2027 00:00:00 GMT", rec.Header().Get("Sunset"))
+
+	rec = do(http.MethodGet, "/v1/tables", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Empty(t, rec.Header().Get("Sunset"))
+
+	// Metrics stay unversioned
+	rec = do(http.MethodGet, "/v1/metrics", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestVersionedAuthRoutes(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), InMemory: true})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/auth/register", strings.NewReader(`{"username": "alice", "email": "alice@example.com", "password": "correct horse"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+}
@@ -0,0 +1,399 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/filter"
+)
+
+// viewDataType marks the definition facts of views
+const viewDataType = "view"
+
+// View is a saved query over one table: the source rows matching Filter,
+// ordered by Sort, with only the Columns listed
+type View struct {
+	Name string `json:"name"`
+	viewSpec
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// viewSpec is the stored part of a view definition
+type viewSpec struct {
+	Source  string         `json:"source"`
+	Filter  *filter.Filter `json:"filter,omitempty"`
+	Columns []string       `json:"columns,omitempty"`
+	Sort    filter.Sort    `json:"sort,omitempty"`
+}
+
+// viewField is the field, in the user's namespace, holding a view's
+// definition
+func viewField(name string) string {
+	return "views/" + name
+}
+
+// viewOf decodes a view definition fact. Deleted views hold deletedTableMarker.
+func viewOf(f dynamo.Fact) (View, bool) {
+	var spec viewSpec
+	s, ok := f.Value.(string)
+	if !ok || s == deletedTableMarker || json.Unmarshal([]byte(s), &spec) != nil {
+		return View{}, false
+	}
+	return View{viewSpec: spec, CreatedAt: f.Timestamp}, true
+}
+
+// lookupView returns a user's view by name
+func lookupView(ctx context.Context, store *db.StoreAdapter, userID, name string) (View, bool, error) {
+	facts, err := store.QueryByField(ctx, userID, viewField(name), time.Time{}, time.Now().UTC())
+	if err != nil || len(facts) == 0 {
+		return View{}, false, err
+	}
+	v, ok := viewOf(latestTableDef(facts))
+	return v, ok, nil
+}
+
+// checkView validates a view against its source table's definition
+func checkView(v View, source dynamo.Fact) fieldErrors {
+	var fields fieldErrors
+	if opts := tableOptionsOf(source); opts.Type == tableTypeSecrets {
+		fields = append(fields, FieldError{Field: "source", Message: "cannot be a secrets table"})
+	}
+	if v.Filter != nil {
+		if err := v.Filter.Validate(); err != nil {
+			fields = append(fields, FieldError{Field: "filter", Message: err.Error()})
+		}
+	}
+	if err := v.Sort.Validate(); err != nil {
+		fields = append(fields, FieldError{Field: "sort", Message: err.Error()})
+	}
+	if len(fields) > 0 || len(source.Columns) == 0 {
+		return fields
+	}
+
+	// Tables with a schema only take views of their own columns
+	defined := map[string]bool{sysCreatedAt: true, sysUpdatedAt: true, sysCreatedBy: true}
+	for _, col := range source.Columns {
+		defined[col.Name] = true
+	}
+	unknown := func(field string, names []string) {
+		for _, name := range names {
+			if !defined[name] {
+				fields = append(fields, FieldError{Field: field, Message: fmt.Sprintf("unknown column '%s'", name)})
+			}
+		}
+	}
+	unknown("columns", v.Columns)
+	if v.Filter != nil {
+		unknown("filter", v.Filter.Columns())
+	}
+	for _, k := range v.Sort {
+		unknown("sort", []string{k.Column})
+	}
+	return fields
+}
+
+// apply filters, sorts and projects a view's source rows. Rows that sort
+// equal keep their ID order.
+func (v View) apply(rows []RowData) []RowData {
+	out := []RowData{}
+	// Filters and sorts also see the system columns
+	values := map[string]map[string]interface{}{}
+	for _, row := range rows {
+		if vals := systemValues(row); v.Filter.Match(vals) {
+			out = append(out, row)
+			values[row.ID] = vals
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if c := v.Sort.Compare(values[out[i].ID], values[out[j].ID]); c != 0 {
+			return c < 0
+		}
+		return out[i].ID < out[j].ID
+	})
+	if len(v.Columns) > 0 {
+		for i, row := range out {
+			projected := make(map[string]interface{}, len(v.Columns))
+			for _, name := range v.Columns {
+				if value, ok := row.Values[name]; ok {
+					projected[name] = value
+				}
+			}
+			out[i].Values = projected
+		}
+	}
+	return out
+}
+
+func (s *Server) handleCreateView(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	var req View
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var fields fieldErrors
+	if req.Name == "" {
+		fields = append(fields, FieldError{Field: "name", Message: "is required"})
+	} else if problem := s.nameProblem(req.Name); problem != "" {
+		fields = append(fields, FieldError{Field: "name", Message: problem})
+	}
+	if req.Source == "" {
+		fields = append(fields, FieldError{Field: "source", Message: "is required"})
+	}
+	if len(fields) > 0 {
+		writeValidationError(w, "Invalid view", fields)
+		return
+	}
+
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	if _, exists, err := lookupView(r.Context(), store, user.ID, req.Name); err != nil {
+		writeStoreError(w, err, "Failed to look up view")
+		return
+	} else if exists {
+		writeError(w, http.StatusConflict, fmt.Sprintf("View '%s' already exists", req.Name))
+		return
+	}
+	facts, err := store.QueryByField(r.Context(), user.ID, req.Source, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", req.Source))
+		return
+	}
+	if fields := checkView(req, latestTableDef(facts)); len(fields) > 0 {
+		writeValidationError(w, "Invalid view", fields)
+		return
+	}
+
+	data, err := json.Marshal(req.viewSpec)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to encode view: %v", err))
+		return
+	}
+	fact := dynamo.Fact{
+		ID:        newID(),
+		Timestamp: time.Now().UTC(),
+		Namespace: user.ID,
+		FieldName: viewField(req.Name),
+		DataType:  viewDataType,
+		Value:     string(data),
+	}
+	if err := store.PutFact(r.Context(), fact); err != nil {
+		writeStoreError(w, err, "Failed to create view")
+		return
+	}
+	req.CreatedAt = fact.Timestamp
+	writeJSON(w, http.StatusCreated, req)
+}
+
+func (s *Server) handleListViews(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	facts, err := store.QueryByTimeRange(r.Context(), time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to get views")
+		return
+	}
+
+	latest := make(map[string]dynamo.Fact)
+	for _, f := range facts {
+		if f.Namespace != user.ID || f.DataType != viewDataType {
+			continue
+		}
+		if cur, seen := latest[f.FieldName]; !seen || f.Timestamp.After(cur.Timestamp) {
+			latest[f.FieldName] = f
+		}
+	}
+	views := []View{}
+	for field, f := range latest {
+		if v, ok := viewOf(f); ok && keyAllowsTable(r, v.Source) {
+			v.Name = field[len(viewField("")):]
+			views = append(views, v)
+		}
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"views": views})
+}
+
+func (s *Server) handleGetView(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	name := r.PathValue("name")
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	v, ok, err := lookupView(r.Context(), store, user.ID, name)
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up view")
+		return
+	}
+	if !ok || !keyAllowsTable(r, v.Source) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("View '%s' not found", name))
+		return
+	}
+	v.Name = name
+	writeJSON(w, http.StatusOK, v)
+}
+
+func (s *Server) handleDeleteView(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	name := r.PathValue("name")
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	if v, ok, err := lookupView(r.Context(), store, user.ID, name); err != nil {
+		writeStoreError(w, err, "Failed to look up view")
+		return
+	} else if !ok || !keyAllowsTable(r, v.Source) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("View '%s' not found", name))
+		return
+	}
+	fact := dynamo.Fact{
+		ID:        newID(),
+		Timestamp: time.Now().UTC(),
+		Namespace: user.ID,
+		FieldName: viewField(name),
+		DataType:  viewDataType,
+		Value:     deletedTableMarker,
+	}
+	if err := store.PutFact(r.Context(), fact); err != nil {
+		writeStoreError(w, err, "Failed to delete view")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleViewRows returns a view's current rows
+func (s *Server) handleViewRows(w http.ResponseWriter, r *http.Request) {
+	s.serveView(w, r, time.Time{})
+}
+
+// handleViewSnapshot returns a view's rows as of the "at" query parameter,
+// evaluating the current definition against the source's past state
+func (s *Server) handleViewSnapshot(w http.ResponseWriter, r *http.Request) {
+	atParam := r.URL.Query().Get("at")
+	if atParam == "" {
+		writeValidationError(w, "Invalid snapshot time", []FieldError{{Field: "at", Message: "is required"}})
+		return
+	}
+	at, err := time.Parse(time.RFC3339, atParam)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'at' time format: %v (expected RFC3339)", err))
+		return
+	}
+	s.serveView(w, r, at.UTC())
+}
+
+// serveView evaluates a view at a time; zero means now
+func (s *Server) serveView(w http.ResponseWriter, r *http.Request, at time.Time) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+	name := r.PathValue("name")
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+	v, ok, err := lookupView(r.Context(), store, user.ID, name)
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up view")
+		return
+	}
+	if !ok || !keyAllowsTable(r, v.Source) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("View '%s' not found", name))
+		return
+	}
+
+	facts, err := store.QueryByField(r.Context(), user.ID, v.Source, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' of view '%s' not found", v.Source, name))
+		return
+	}
+	rows, ok := s.tableRowsAt(w, r, store, user, v.Source, facts, at)
+	if !ok {
+		return
+	}
+	writeRows(w, v.apply(rows))
+}
+
+// tableRowsAt reads a table's rows at a time, zero meaning now, from the
+// snapshot and cold storage or, for proxied tables, from the upstream. It
+// writes the error response and returns false on failure.
+func (s *Server) tableRowsAt(w http.ResponseWriter, r *http.Request, store *db.StoreAdapter, user *auth.User, table string, facts []dynamo.Fact, at time.Time) ([]RowData, bool) {
+	def := latestTableDef(facts)
+	if proxiedTable(facts) {
+		if !at.IsZero() {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Table '%s' is proxied to its upstream and has no history; create it with 'materialize' to query past states", table))
+			return nil, false
+		}
+		rows, _, err := s.virtualRows(r.Context(), user.ID, table, def, false)
+		if err != nil {
+			writeVirtualError(w, err)
+			return nil, false
+		}
+		return rows, true
+	}
+
+	if at.IsZero() {
+		at = time.Now().UTC()
+	}
+	rowStore, err := s.getRowStore(r.Context(), store, user, table)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize table storage")
+		return nil, false
+	}
+	entries, err := s.tableEntries(r.Context(), store, rowStore, user, table, def, at)
+	if err != nil {
+		writeStoreError(w, err, "Failed to get snapshot")
+		return nil, false
+	}
+	rows := s.snapshotRows(r.Context(), table, tableOptionsOf(def).TTLColumn, entries, at)
+	if err := s.addSystemColumns(r.Context(), store, rowStore, user, table, def, at, rows); err != nil {
+		writeStoreError(w, err, "Failed to read row history")
+		return nil, false
+	}
+	return rows, true
+}
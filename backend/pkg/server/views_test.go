@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestViews(t *testing.T) {
+	_, do := memoryServer(t)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables", `{"name": "orders", "columns": [
+		{"name": "status", "dataType": "string"},
+		{"name": "total", "dataType": "number"},
+		{"name": "note", "dataType": "string"}
+	]}`).Code)
+	for _, body := range []string{
+		`{"id": "o1", "values": {"status": "open", "total": 50, "note": "a"}}`,
+		`{"id": "o2", "values": {"status": "open", "total": 200, "note": "b"}}`,
+		`{"id": "o3", "values": {"status": "paid", "total": 300, "note": "c"}}`,
+	} {
+		require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/orders/rows", body).Code)
+	}
+	before := time.Now().UTC()
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tables/orders/rows", `{"id": "o4", "values": {"status": "open", "total": 120}}`).Code)
+
+	rec := do(http.MethodPost, "/views", `{"name": "open-orders", "source": "orders",
+		"filter": {"column": "status", "op": "eq", "value": "open"},
+		"columns": ["total"], "sort": [{"column": "total", "desc": true}]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rows := func(path string) []RowData {
+		t.Helper()
+		rec := do(http.MethodGet, path, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp struct {
+			Rows []RowData `json:"rows"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp.Rows
+	}
+	current := rows("/views/open-orders/rows")
+	require.Len(t, current, 3)
+	assert.Equal(t, []string{"o2", "o4", "o1"}, []string{current[0].ID, current[1].ID, current[2].ID})
+	assert.Equal(t, map[string]interface{}{"total": float64(200)}, current[0].Values)
+
+	past := rows("/views/open-orders/snapshot?at=" + before.Format(time.RFC3339Nano))
+	assert.Len(t, past, 2, "the row written after the snapshot time is left out")
+
+	rec = do(http.MethodGet, "/views", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var list struct {
+		Views []View `json:"views"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list.Views, 1)
+	assert.Equal(t, "open-orders", list.Views[0].Name)
+	assert.Equal(t, "orders", list.Views[0].Source)
+
+	for _, body := range []string{
+		`{"name": "open-orders", "source": "orders"}`,
+		`{"name": "v", "source": "orders", "columns": ["nope"]}`,
+		`{"name": "v", "source": "orders", "filter": {"column": "status", "op": "like", "value": "x"}}`,
+		`{"name": "bad name", "source": "orders"}`,
+	} {
+		rec := do(http.MethodPost, "/views", body)
+		assert.Contains(t, []int{http.StatusBadRequest, http.StatusConflict}, rec.Code, body)
+	}
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/views", `{"name": "v", "source": "missing"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/views/open-orders/snapshot", "").Code)
+
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/views/open-orders", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/views/open-orders/rows", "").Code)
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/views", `{"name": "open-orders", "source": "orders"}`).Code, "a deleted view's name can be reused")
+}
@@ -0,0 +1,646 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/crypto"
+)
+
+const (
+	// tableTypeVirtual marks tables whose rows come from an external REST API
+	tableTypeVirtual = "virtual"
+
+	// defaultVirtualRefresh applies to virtual tables created without a refresh interval
+	defaultVirtualRefresh = 5 * time.Minute
+	// minVirtualRefresh stops tables from polling their upstream too often
+	minVirtualRefresh = 30 * time.Second
+	// virtualFetchTimeout bounds a single upstream request
+	virtualFetchTimeout = 10 * time.Second
+	// maxVirtualResponseBytes caps the size of an upstream response
+	maxVirtualResponseBytes = 10 << 20
+	// virtualSyncInterval is how often materialized virtual tables are checked for a due refresh
+	virtualSyncInterval = 30 * time.Second
+)
+
+// errVirtualTablesDisabled is returned when no upstream hosts are allowed
+var errVirtualTablesDisabled = errors.New("virtual tables are not configured on this server")
+
+// virtualSource is the upstream of a virtual table, stored in its tableOptions
+type virtualSource struct {
+	URL string `json:"url"`
+	// Headers are sent with every upstream request. When a master key is
+	// configured they are stored sealed in SealedHeaders instead.
+	Headers       map[string]string `json:"headers,omitempty"`
+	SealedHeaders string            `json:"sealedHeaders,omitempty"`
+	HeaderNames   []string          `json:"headerNames,omitempty"`
+	// RowsPath is the dot-separated path to the array of rows in the
+	// response; the response itself is the array when empty
+	RowsPath string `json:"rowsPath,omitempty"`
+	// IDField is the path, within each row, of its ID
+	IDField string `json:"idField"`
+	// Fields maps column names to paths within each row; every top-level
+	// field is used when empty
+	Fields map[string]string `json:"fields,omitempty"`
+	// Refresh is how long fetched rows are reused
+	Refresh string `json:"refresh,omitempty"`
+	// Materialize copies upstream rows into facts on every refresh, so they
+	// keep history; otherwise reads are proxied to the upstream
+	Materialize bool `json:"materialize,omitempty"`
+}
+
+// VirtualSourceInfo describes the upstream of a virtual table. Header values
+// are never returned.
+type VirtualSourceInfo struct {
+	URL         string            `json:"url"`
+	HeaderNames []string          `json:"headerNames,omitempty"`
+	RowsPath    string            `json:"rowsPath,omitempty"`
+	IDField     string            `json:"idField"`
+	Fields      map[string]string `json:"fields,omitempty"`
+	Refresh     string            `json:"refresh"`
+	Materialize bool              `json:"materialize"`
+}
+
+// info returns the client-visible description of a source
+func (src *virtualSource) info() *VirtualSourceInfo {
+	if src == nil {
+		return nil
+	}
+	return &VirtualSourceInfo{
+		URL:         src.URL,
+		HeaderNames: src.HeaderNames,
+		RowsPath:    src.RowsPath,
+		IDField:     src.IDField,
+		Fields:      src.Fields,
+		Refresh:     src.refresh().String(),
+		Materialize: src.Materialize,
+	}
+}
+
+// refresh returns how long fetched rows are reused
+func (src *virtualSource) refresh() time.Duration {
+	d, err := time.ParseDuration(src.Refresh)
+	if err != nil || d <= 0 {
+		return defaultVirtualRefresh
+	}
+	return d
+}
+
+// virtualHostsFromEnv reads the upstream hosts virtual tables may use
+func virtualHostsFromEnv() []string {
+	var hosts []string
+	for _, h := range strings.Split(os.Getenv("NOTABLY_VIRTUAL_TABLE_HOSTS"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// checkVirtualURL validates an upstream URL against the allowed hosts
+func (s *Server) checkVirtualURL(raw string) error {
+	if len(s.config.VirtualTableHosts) == 0 {
+		return errVirtualTablesDisabled
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("'source.url' must be an absolute http or https URL")
+	}
+	if hostAllowed(s.config.VirtualTableHosts, u.Hostname()) {
+		return nil
+	}
+	return fmt.Errorf("host '%s' is not an allowed virtual table upstream", u.Hostname())
+}
+
+// hostAllowed reports whether host is in an allowlist, where "*" allows any host
+func hostAllowed(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if h == "*" || strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// newVirtualSource validates the source of a new virtual table, sealing its
+// headers when a master key is configured
+func (s *Server) newVirtualSource(ctx context.Context, userID, table string, src *virtualSource) (*virtualSource, []FieldError, error) {
+	var fields fieldErrors
+	if src == nil {
+		return nil, fieldErrors{{Field: "source", Message: "is required for virtual tables"}}, nil
+	}
+	fields.required("source.url", src.URL)
+	fields.required("source.idField", src.IDField)
+	if src.URL != "" {
+		if err := s.checkVirtualURL(src.URL); errors.Is(err, errVirtualTablesDisabled) {
+			return nil, nil, err
+		} else if err != nil {
+			fields = append(fields, FieldError{Field: "source.url", Message: err.Error()})
+		}
+	}
+	if src.Refresh != "" {
+		if d, err := time.ParseDuration(src.Refresh); err != nil || d < minVirtualRefresh {
+			fields = append(fields, FieldError{Field: "source.refresh", Message: fmt.Sprintf("must be a duration of at least %s", minVirtualRefresh)})
+		}
+	}
+	for col := range src.Fields {
+		if problem := s.nameProblem(col); problem != "" {
+			fields = append(fields, FieldError{Field: "source.fields", Message: fmt.Sprintf("column name '%s' %s", col, problem)})
+		}
+	}
+	if src.SealedHeaders != "" || len(src.HeaderNames) > 0 {
+		fields = append(fields, FieldError{Field: "source", Message: "headers must be given in 'headers'"})
+	}
+	if len(fields) > 0 {
+		return nil, fields, nil
+	}
+
+	out := *src
+	out.HeaderNames = nil
+	for name := range src.Headers {
+		out.HeaderNames = append(out.HeaderNames, name)
+	}
+	sort.Strings(out.HeaderNames)
+	if len(src.Headers) > 0 && s.sealer != nil {
+		plaintext, err := json.Marshal(src.Headers)
+		if err != nil {
+			return nil, nil, err
+		}
+		env, err := s.sealer.Seal(ctx, plaintext, virtualHeadersAAD(userID, table))
+		if err != nil {
+			return nil, nil, err
+		}
+		if out.SealedHeaders, err = env.Encode(); err != nil {
+			return nil, nil, err
+		}
+		out.Headers = nil
+	}
+	return &out, nil, nil
+}
+
+// virtualHeadersAAD binds sealed upstream headers to their table
+func virtualHeadersAAD(userID, table string) []byte {
+	return []byte(userID + "#" + table + "#source")
+}
+
+// virtualHeaders returns the upstream request headers of a source
+func (s *Server) virtualHeaders(ctx context.Context, userID, table string, src *virtualSource) (map[string]string, error) {
+	if src.SealedHeaders == "" {
+		return src.Headers, nil
+	}
+	if s.sealer == nil {
+		return nil, errSecretsDisabled
+	}
+	env, err := crypto.DecodeEnvelope(src.SealedHeaders)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := s.sealer.Open(ctx, env, virtualHeadersAAD(userID, table))
+	if err != nil {
+		return nil, err
+	}
+	var headers map[string]string
+	if err := json.Unmarshal(plaintext, &headers); err != nil {
+		return nil, fmt.Errorf("decode upstream headers: %w", err)
+	}
+	return headers, nil
+}
+
+// virtualTables caches the rows fetched for proxied virtual tables and
+// tracks when materialized ones were last synced
+type virtualTables struct {
+	client *http.Client
+
+	mu     sync.Mutex
+	cache  map[string]*virtualEntry
+	synced map[string]time.Time
+}
+
+// virtualEntry is the cached upstream rows of one table definition
+type virtualEntry struct {
+	mu        sync.Mutex
+	def       time.Time
+	rows      []RowData
+	fetchedAt time.Time
+}
+
+func newVirtualTables() *virtualTables {
+	return &virtualTables{
+		client: &http.Client{Timeout: virtualFetchTimeout},
+		cache:  make(map[string]*virtualEntry),
+		synced: make(map[string]time.Time),
+	}
+}
+
+// entry returns the cache entry of a table, resetting it when the table was redefined
+func (v *virtualTables) entry(key string, def time.Time) *virtualEntry {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	e, ok := v.cache[key]
+	if !ok || !e.def.Equal(def) {
+		e = &virtualEntry{def: def}
+		v.cache[key] = e
+	}
+	return e
+}
+
+// virtualRows returns the rows of a proxied virtual table, fetching them from
+// the upstream when the cached copy is older than the table's refresh interval
+func (s *Server) virtualRows(ctx context.Context, userID, table string, def dynamo.Fact, force bool) ([]RowData, time.Time, error) {
+	src := tableOptionsOf(def).Source
+	e := s.virtual.entry(tableNamespace(userID, table), def.Timestamp)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !force && e.rows != nil && time.Since(e.fetchedAt) < src.refresh() {
+		return e.rows, e.fetchedAt, nil
+	}
+	rows, err := s.fetchVirtualRows(ctx, userID, table, src)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	e.rows, e.fetchedAt = rows, time.Now().UTC()
+	return e.rows, e.fetchedAt, nil
+}
+
+// fetchVirtualRows requests a source's upstream and maps the response to rows
+func (s *Server) fetchVirtualRows(ctx context.Context, userID, table string, src *virtualSource) ([]RowData, error) {
+	if err := s.checkVirtualURL(src.URL); err != nil {
+		return nil, err
+	}
+	headers, err := s.virtualHeaders(ctx, userID, table, src)
+	if err != nil {
+		return nil, fmt.Errorf("reading upstream headers: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := s.virtual.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting upstream: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("upstream responded %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxVirtualResponseBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading upstream response: %w", err)
+	}
+	if len(body) > maxVirtualResponseBytes {
+		return nil, fmt.Errorf("upstream response exceeds %d bytes", maxVirtualResponseBytes)
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("decoding upstream response: %w", err)
+	}
+	return mapVirtualRows(doc, src, time.Now().UTC())
+}
+
+// mapVirtualRows extracts rows from a decoded upstream response. Items
+// without an ID are skipped; later items win over earlier ones with the same ID.
+func mapVirtualRows(doc interface{}, src *virtualSource, fetchedAt time.Time) ([]RowData, error) {
+	list, ok := lookupPath(doc, src.RowsPath)
+	if !ok {
+		return nil, fmt.Errorf("upstream response has no '%s'", src.RowsPath)
+	}
+	items, ok := list.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("upstream rows must be a JSON array, got %T", list)
+	}
+
+	index := make(map[string]int)
+	rows := []RowData{}
+	for _, item := range items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		rawID, _ := lookupPath(obj, src.IDField)
+		id := virtualRowID(rawID)
+		if id == "" {
+			continue
+		}
+
+		values := make(map[string]interface{})
+		if len(src.Fields) == 0 {
+			for k, v := range obj {
+				values[k] = v
+			}
+		} else {
+			for col, path := range src.Fields {
+				if v, ok := lookupPath(obj, path); ok {
+					values[col] = v
+				}
+			}
+		}
+
+		row := RowData{ID: id, Timestamp: fetchedAt, Values: values}
+		if i, dup := index[id]; dup {
+			rows[i] = row
+			continue
+		}
+		index[id] = len(rows)
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// lookupPath follows a dot-separated path of object keys; an empty path is v itself
+func lookupPath(v interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return v, true
+	}
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// virtualRowID renders an upstream ID value as a row ID
+func virtualRowID(v interface{}) string {
+	switch id := v.(type) {
+	case string:
+		return id
+	case float64:
+		return strconv.FormatFloat(id, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+// rejectVirtualWrite responds 409 and returns true when facts define a virtual table
+func rejectVirtualWrite(w http.ResponseWriter, facts []dynamo.Fact, table string) bool {
+	if tableOptionsOf(latestTableDef(facts)).Source == nil {
+		return false
+	}
+	writeError(w, http.StatusConflict, fmt.Sprintf("Table '%s' is a virtual table and is read-only", table))
+	return true
+}
+
+// proxiedTable reports whether reads of a table are served from its upstream
+func proxiedTable(facts []dynamo.Fact) bool {
+	src := tableOptionsOf(latestTableDef(facts)).Source
+	return src != nil && !src.Materialize
+}
+
+// writeVirtualError reports an upstream failure as 502 Bad Gateway
+func writeVirtualError(w http.ResponseWriter, err error) {
+	writeError(w, http.StatusBadGateway, fmt.Sprintf("Failed to read virtual table upstream: %v", err))
+}
+
+// VirtualRefresh reports the outcome of refreshing a virtual table
+type VirtualRefresh struct {
+	FetchedAt time.Time `json:"fetchedAt"`
+	Rows      int       `json:"rows"`
+	// Created, Updated and Deleted count the row facts written to a
+	// materialized table
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Deleted int `json:"deleted"`
+}
+
+// handleRefreshVirtualTable fetches a virtual table's upstream now, bypassing
+// the cache, and materializes the rows if the table is materialized
+func (s *Server) handleRefreshVirtualTable(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return
+	}
+
+	// Validate table exists
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return
+	}
+
+	def := latestTableDef(facts)
+	src := tableOptionsOf(def).Source
+	if src == nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Table '%s' is not a virtual table", table))
+		return
+	}
+
+	if !src.Materialize {
+		rows, fetchedAt, err := s.virtualRows(r.Context(), user.ID, table, def, true)
+		if err != nil {
+			writeVirtualError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, VirtualRefresh{FetchedAt: fetchedAt, Rows: len(rows)})
+		return
+	}
+
+	result, err := s.materializeVirtualTable(r.Context(), store, user, table, src)
+	var storeErr *db.StoreError
+	if errors.As(err, &storeErr) {
+		writeStoreError(w, err, "Failed to materialize virtual table")
+		return
+	} else if err != nil {
+		writeVirtualError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// materializeVirtualTable fetches a table's upstream and writes a fact for
+// every row that changed, and a deletion for every row that disappeared
+func (s *Server) materializeVirtualTable(ctx context.Context, store *db.StoreAdapter, user *auth.User, table string, src *virtualSource) (VirtualRefresh, error) {
+	key := tableNamespace(user.ID, table)
+	rows, err := s.fetchVirtualRows(ctx, user.ID, table, src)
+	if err != nil {
+		return VirtualRefresh{}, err
+	}
+	now := time.Now().UTC()
+	result := VirtualRefresh{FetchedAt: now, Rows: len(rows)}
+
+	rowStore, err := s.getRowStore(ctx, store, user, table)
+	if err != nil {
+		return result, err
+	}
+	snap, err := rowStore.GetTableSnapshot(ctx, user.ID, table, now)
+	if err != nil {
+		return result, err
+	}
+
+	puts, deletes := diffVirtualRows(snap, rows)
+	for _, row := range puts {
+		if f, exists := snap[row.ID]; exists && !f.IsTombstone() {
+			result.Updated++
+		} else {
+			result.Created++
+		}
+		if err := rowStore.PutFact(ctx, dynamo.Fact{
+			ID:        newID(),
+			Timestamp: now,
+			Namespace: key,
+			FieldName: row.ID,
+			DataType:  "json",
+			Value:     row.Values,
+		}); err != nil {
+			return result, err
+		}
+	}
+	for _, id := range deletes {
+		if err := rowStore.PutFact(ctx, dynamo.Fact{
+			ID:        newID(),
+			Timestamp: now,
+			Namespace: key,
+			FieldName: id,
+			DataType:  dynamo.DataTypeDeleted,
+		}); err != nil {
+			return result, err
+		}
+		result.Deleted++
+	}
+
+	s.virtual.mu.Lock()
+	s.virtual.synced[key] = now
+	s.virtual.mu.Unlock()
+	return result, nil
+}
+
+// diffVirtualRows compares a materialized table's current rows with its
+// upstream rows, returning the rows to write and the row IDs to delete
+func diffVirtualRows(current map[string]dynamo.Fact, rows []RowData) (puts []RowData, deletes []string) {
+	seen := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		seen[row.ID] = true
+		fact, exists := current[row.ID]
+		if exists && fact.DataType == "json" {
+			if stored, ok := fact.Value.(map[string]interface{}); ok && reflect.DeepEqual(stored, row.Values) {
+				continue
+			}
+		}
+		puts = append(puts, row)
+	}
+	for id, fact := range current {
+		if !seen[id] && !fact.IsTombstone() {
+			deletes = append(deletes, id)
+		}
+	}
+	sort.Strings(deletes)
+	return puts, deletes
+}
+
+// runVirtualTableSync materializes due virtual tables until ctx is cancelled
+func (s *Server) runVirtualTableSync(ctx context.Context) {
+	if len(s.config.VirtualTableHosts) == 0 {
+		return
+	}
+	ticker := time.NewTicker(virtualSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.leased(ctx, "virtual-tables", s.syncVirtualTables); err != nil {
+				s.logger.ErrorContext(ctx, "syncing virtual tables failed", "error", err)
+			}
+		}
+	}
+}
+
+// syncVirtualTables materializes every materialized virtual table whose
+// refresh interval has passed since it was last synced. A failing upstream is
+// logged and does not stop other tables from syncing.
+func (s *Server) syncVirtualTables(ctx context.Context) error {
+	users, err := s.authenticator.GetAllUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, user := range users {
+		store, err := s.getStoreForUser(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		facts, err := store.QueryByTimeRange(ctx, time.Time{}, now)
+		if err != nil {
+			return err
+		}
+
+		defs := make(map[string][]dynamo.Fact)
+		for _, f := range facts {
+			if f.Namespace == user.ID && f.DataType == tableDataType {
+				defs[f.FieldName] = append(defs[f.FieldName], f)
+			}
+		}
+
+		for table, tableDefs := range defs {
+			if !tableLive(tableDefs) {
+				continue
+			}
+			src := tableOptionsOf(latestTableDef(tableDefs)).Source
+			if src == nil || !src.Materialize {
+				continue
+			}
+
+			key := tableNamespace(user.ID, table)
+			s.virtual.mu.Lock()
+			last := s.virtual.synced[key]
+			s.virtual.mu.Unlock()
+			if now.Sub(last) < src.refresh() {
+				continue
+			}
+
+			result, err := s.materializeVirtualTable(ctx, store, user, table, src)
+			if err != nil {
+				s.logger.WarnContext(ctx, "materializing virtual table failed", "user", user.ID, "table", table, "error", err)
+				continue
+			}
+			if result.Created+result.Updated+result.Deleted > 0 {
+				s.logger.InfoContext(ctx, "materialized virtual table", "user", user.ID, "table", table,
+					"created", result.Created, "updated", result.Updated, "deleted", result.Deleted)
+			}
+		}
+	}
+	return nil
+}
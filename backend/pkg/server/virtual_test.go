@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeDoc(t *testing.T, raw string) interface{} {
+	t.Helper()
+	var doc interface{}
+	require.NoError(t, json.Unmarshal([]byte(raw), &doc))
+	return doc
+}
+
+func TestMapVirtualRows(t *testing.T) {
+	doc := decodeDoc(t, `{"data": {"items": [
+		{"id": 1, "name": "Ada", "address": {"city": "London"}},
+		{"id": "b", "name": "Grace"},
+		{"name": "no id"},
+		"not an object",
+		{"id": 1, "name": "Ada Lovelace", "address": {"city": "London"}}
+	]}}`)
+	src := &virtualSource{RowsPath: "data.items", IDField: "id", Fields: map[string]string{"who": "name", "city": "address.city"}}
+
+	rows, err := mapVirtualRows(doc, src, time.Now())
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "1", rows[0].ID)
+	assert.Equal(t, map[string]interface{}{"who": "Ada Lovelace", "city": "London"}, rows[0].Values)
+	assert.Equal(t, "b", rows[1].ID)
+	assert.Equal(t, map[string]interface{}{"who": "Grace"}, rows[1].Values)
+
+	// Without a field mapping every top-level field is kept
+	rows, err = mapVirtualRows(decodeDoc(t, `[{"key": "k1", "n": 2}]`), &virtualSource{IDField: "key"}, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"key": "k1", "n": 2.0}, rows[0].Values)
+
+	_, err = mapVirtualRows(doc, &virtualSource{RowsPath: "data.missing", IDField: "id"}, time.Now())
+	assert.Error(t, err)
+	_, err = mapVirtualRows(doc, &virtualSource{RowsPath: "data", IDField: "id"}, time.Now())
+	assert.Error(t, err)
+}
+
+func TestDiffVirtualRows(t *testing.T) {
+	current := map[string]dynamo.Fact{
+		"same":    {DataType: "json", Value: map[string]interface{}{"n": 1.0}},
+		"changed": {DataType: "json", Value: map[string]interface{}{"n": 1.0}},
+		"gone":    {DataType: "json", Value: map[string]interface{}{"n": 1.0}},
+		"deleted": {DataType: "json", Value: nil},
+	}
+	rows := []RowData{
+		{ID: "same", Values: map[string]interface{}{"n": 1.0}},
+		{ID: "changed", Values: map[string]interface{}{"n": 2.0}},
+		{ID: "new", Values: map[string]interface{}{"n": 1.0}},
+	}
+
+	puts, deletes := diffVirtualRows(current, rows)
+	var ids []string
+	for _, p := range puts {
+		ids = append(ids, p.ID)
+	}
+	assert.Equal(t, []string{"changed", "new"}, ids)
+	assert.Equal(t, []string{"gone"}, deletes)
+}
+
+func TestCheckVirtualURL(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard()})
+	require.NoError(t, err)
+	assert.ErrorIs(t, srv.checkVirtualURL("https://api.example.com/items"), errVirtualTablesDisabled)
+
+	srv, err = NewServer(Config{TableName: "Facts", Logger: logging.Discard(), VirtualTableHosts: []string{"api.example.com"}})
+	require.NoError(t, err)
+	assert.NoError(t, srv.checkVirtualURL("https://API.example.com/items?page=1"))
+	assert.Error(t, srv.checkVirtualURL("https://evil.example.com/items"))
+	assert.Error(t, srv.checkVirtualURL("file:///etc/passwd"))
+	assert.Error(t, srv.checkVirtualURL("/relative"))
+}
+
+func TestNewVirtualSourceValidatesAndSealsHeaders(t *testing.T) {
+	masterKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{5}, 32))
+	srv, err := NewServer(Config{TableName: "Facts", MasterKey: masterKey, Logger: logging.Discard(), VirtualTableHosts: []string{"api.example.com"}})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, fields, err := srv.newVirtualSource(ctx, "u1", "people", &virtualSource{URL: "https://other.example.com", Refresh: "1s"})
+	require.NoError(t, err)
+	assert.Len(t, fields, 3) // idField, url, refresh
+
+	src, fields, err := srv.newVirtualSource(ctx, "u1", "people", &virtualSource{
+		URL:     "https://api.example.com/people",
+		IDField: "id",
+		Headers: map[string]string{"Authorization": "Bearer token"},
+	})
+	require.NoError(t, err)
+	require.Empty(t, fields)
+	assert.Nil(t, src.Headers)
+	assert.NotEmpty(t, src.SealedHeaders)
+	assert.Equal(t, []string{"Authorization"}, src.HeaderNames)
+	assert.NotContains(t, tableOptions{Type: tableTypeVirtual, Source: src}.encode(), "Bearer token")
+
+	headers, err := srv.virtualHeaders(ctx, "u1", "people", src)
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer token", headers["Authorization"])
+
+	_, err = srv.virtualHeaders(ctx, "u1", "other-table", src)
+	assert.Error(t, err, "sealed headers are bound to their table")
+}
+
+func TestVirtualRowsAreCachedForRefreshInterval(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		assert.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"results": [{"id": 7, "title": "Launch"}]}`))
+	}))
+	defer upstream.Close()
+
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), VirtualTableHosts: []string{"127.0.0.1"}})
+	require.NoError(t, err)
+
+	src := &virtualSource{
+		URL:      upstream.URL,
+		Headers:  map[string]string{"X-Api-Key": "secret"},
+		RowsPath: "results",
+		IDField:  "id",
+		Refresh:  "1h",
+	}
+	def := dynamo.Fact{Timestamp: time.Now().UTC(), DataType: "table", Value: tableOptions{Type: tableTypeVirtual, Source: src}.encode()}
+	ctx := context.Background()
+
+	rows, _, err := srv.virtualRows(ctx, "u1", "launches", def, false)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "7", rows[0].ID)
+	assert.Equal(t, "Launch", rows[0].Values["title"])
+
+	_, _, err = srv.virtualRows(ctx, "u1", "launches", def, false)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), hits.Load())
+
+	_, _, err = srv.virtualRows(ctx, "u1", "launches", def, true)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), hits.Load())
+
+	// Recreating the table drops the cached rows
+	def.Timestamp = def.Timestamp.Add(time.Second)
+	_, _, err = srv.virtualRows(ctx, "u1", "launches", def, false)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), hits.Load())
+}
+
+func TestVirtualRowsReportUpstreamFailures(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), VirtualTableHosts: []string{"127.0.0.1"}})
+	require.NoError(t, err)
+
+	src := &virtualSource{URL: upstream.URL, IDField: "id"}
+	def := dynamo.Fact{Timestamp: time.Now().UTC(), DataType: "table", Value: tableOptions{Type: tableTypeVirtual, Source: src}.encode()}
+	_, _, err = srv.virtualRows(context.Background(), "u1", "down", def, false)
+	assert.ErrorContains(t, err, "503")
+}
+
+func TestVirtualTablesAreReadOnly(t *testing.T) {
+	facts := []dynamo.Fact{{DataType: "table", Value: tableOptions{Type: tableTypeVirtual, Source: &virtualSource{URL: "https://x", IDField: "id", Materialize: true}}.encode()}}
+	rec := httptest.NewRecorder()
+	assert.True(t, rejectVirtualWrite(rec, facts, "people"))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.False(t, proxiedTable(facts))
+
+	plain := []dynamo.Fact{{DataType: "table", Value: ""}}
+	assert.False(t, rejectVirtualWrite(httptest.NewRecorder(), plain, "notes"))
+	assert.False(t, proxiedTable(plain))
+}
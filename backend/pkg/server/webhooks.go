@@ -0,0 +1,558 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/dynamo"
+	"github.com/elibdev/notably/pkg/auth"
+	"github.com/elibdev/notably/pkg/crypto"
+	"github.com/elibdev/notably/pkg/plugin"
+)
+
+const (
+	// webhooksDataType marks the fact holding a table's webhook set
+	webhooksDataType = "webhooks"
+	// deadLetterDataType marks a webhook delivery that failed for good
+	deadLetterDataType = "webhook-dead-letter"
+
+	// webhookEventDelete is the event type of row deletions; creates,
+	// updates and expiries share the automation event names
+	webhookEventDelete = "delete"
+
+	webhookSecretPrefix = "whsec_"
+
+	// webhookQueueSize is the number of row events buffered for delivery
+	webhookQueueSize = 1024
+	// webhookWorkers is the number of concurrent deliveries
+	webhookWorkers = 4
+)
+
+// errWebhooksDisabled is returned when no webhook hosts are allowed
+var errWebhooksDisabled = errors.New("webhooks are not configured on this server")
+
+// Webhook is a callback URL notified of a table's row events
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Secret signs deliveries. It is only returned when the webhook is created.
+	Secret string `json:"secret,omitempty"`
+}
+
+// handles reports whether the webhook is notified of the given event
+func (h Webhook) handles(event string) bool {
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// storedWebhook is a webhook as saved. Its secret is sealed in SealedSecret
+// when a master key is configured, and kept in SigningSecret otherwise.
+type storedWebhook struct {
+	Webhook
+	SigningSecret string `json:"signingSecret,omitempty"`
+	SealedSecret  string `json:"sealedSecret,omitempty"`
+}
+
+// WebhookPayload is the JSON body POSTed to a webhook
+type WebhookPayload struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	Table     string                 `json:"table"`
+	Row       string                 `json:"row"`
+	Timestamp time.Time              `json:"timestamp"`
+	Values    map[string]interface{} `json:"values,omitempty"`
+}
+
+// DeadLetter records a delivery that failed after every retry
+type DeadLetter struct {
+	ID       string         `json:"id"`
+	Webhook  string         `json:"webhook"`
+	URL      string         `json:"url"`
+	Payload  WebhookPayload `json:"payload"`
+	Attempts int            `json:"attempts"`
+	Error    string         `json:"error"`
+	FailedAt time.Time      `json:"failedAt"`
+}
+
+// webhookField is the field, in the user's namespace, holding a table's webhooks
+func webhookField(table string) string {
+	return settingField(table, "webhooks")
+}
+
+// deadLetterField is the field, in the user's namespace, holding a table's
+// dead-lettered deliveries
+func deadLetterField(table string) string {
+	return settingField(table, "webhooks", "dead-letters")
+}
+
+// webhookSecretAAD binds a sealed webhook secret to its webhook
+func webhookSecretAAD(userID, table, id string) []byte {
+	return []byte(userID + "#" + table + "#webhook#" + id)
+}
+
+// webhookHostsFromEnv reads the hosts webhooks may be delivered to
+func webhookHostsFromEnv() []string {
+	var hosts []string
+	for _, h := range strings.Split(os.Getenv("NOTABLY_WEBHOOK_HOSTS"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// checkWebhookURL validates a callback URL against the allowed hosts
+func (s *Server) checkWebhookURL(raw string) error {
+	if len(s.config.WebhookHosts) == 0 {
+		return errWebhooksDisabled
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("must be an absolute http or https URL")
+	}
+	if !hostAllowed(s.config.WebhookHosts, u.Hostname()) {
+		return fmt.Errorf("host '%s' is not an allowed webhook host", u.Hostname())
+	}
+	return nil
+}
+
+// loadWebhooks returns a table's webhooks sorted by creation. defs are the
+// table's definition facts; webhooks saved before the table was last
+// (re)created are ignored.
+func (s *Server) loadWebhooks(ctx context.Context, store *db.StoreAdapter, userID, table string, defs []dynamo.Fact) ([]storedWebhook, error) {
+	facts, err := store.QueryByField(ctx, userID, webhookField(table), time.Time{}, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *dynamo.Fact
+	for i := range facts {
+		if facts[i].DataType != webhooksDataType {
+			continue
+		}
+		if latest == nil || facts[i].Timestamp.After(latest.Timestamp) {
+			latest = &facts[i]
+		}
+	}
+	if latest == nil || latest.Timestamp.Before(latestTableDef(defs).Timestamp) {
+		return nil, nil
+	}
+
+	raw, _ := latest.Value.(string)
+	var hooks []storedWebhook
+	if err := json.Unmarshal([]byte(raw), &hooks); err != nil {
+		return nil, fmt.Errorf("decoding webhooks for table %s: %w", table, err)
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].CreatedAt.Before(hooks[j].CreatedAt) })
+	return hooks, nil
+}
+
+// saveWebhooks records the full webhook set for a table
+func (s *Server) saveWebhooks(ctx context.Context, store *db.StoreAdapter, userID, table string, hooks []storedWebhook) error {
+	if hooks == nil {
+		hooks = []storedWebhook{}
+	}
+	data, err := json.Marshal(hooks)
+	if err != nil {
+		return err
+	}
+	return store.PutFact(ctx, dynamo.Fact{
+		ID:        newID(),
+		Timestamp: time.Now().UTC(),
+		Namespace: userID,
+		FieldName: webhookField(table),
+		DataType:  webhooksDataType,
+		Value:     string(data),
+	})
+}
+
+// pluginEvent returns ev as event consumers receive it: rows of secrets
+// tables, and of tables that fail to load, are sent without values
+func (s *Server) pluginEvent(ev plugin.RowEvent) plugin.RowEvent {
+	if ev.Values == nil {
+		return ev
+	}
+	ctx := context.Background()
+	store, err := s.getStoreForUser(ctx, ev.UserID)
+	if err == nil {
+		var defs []dynamo.Fact
+		defs, err = store.QueryByField(ctx, ev.UserID, ev.Table, time.Time{}, time.Now().UTC())
+		if err == nil && tableOptionsOf(latestTableDef(defs)).Type != tableTypeSecrets {
+			return ev
+		}
+	}
+	if err != nil {
+		s.logger.Warn("failed to load table for plugin event", "table", ev.Table, "row", ev.Row, "error", err)
+	}
+	ev.Values = nil
+	return ev
+}
+
+// webhookSecret returns the signing secret of a stored webhook
+func (s *Server) webhookSecret(ctx context.Context, userID, table string, hook storedWebhook) ([]byte, error) {
+	if hook.SealedSecret == "" {
+		return []byte(hook.SigningSecret), nil
+	}
+	if s.sealer == nil {
+		return nil, errSecretsDisabled
+	}
+	env, err := crypto.DecodeEnvelope(hook.SealedSecret)
+	if err != nil {
+		return nil, err
+	}
+	return s.sealer.Open(ctx, env, webhookSecretAAD(userID, table, hook.ID))
+}
+
+// publishRowEvent hands a stored row write to plugins and, when webhooks
+// or publishing are enabled, queues it for delivery
+func (s *Server) publishRowEvent(ev plugin.RowEvent) {
+	if s.plugins.ConsumesEvents() {
+		s.plugins.Publish(s.pluginEvent(ev))
+	}
+	if s.publisher != nil {
+		s.queuePublish(ev)
+	}
+	if len(s.config.WebhookHosts) == 0 {
+		return
+	}
+	select {
+	case s.webhookQueue <- ev:
+	default:
+		s.logger.Warn("webhook queue full, dropping event", "table", ev.Table, "row", ev.Row)
+		s.metrics.ObserveWebhookDelivery("dropped")
+	}
+}
+
+// runWebhookDelivery delivers queued row events until ctx is cancelled
+func (s *Server) runWebhookDelivery(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-s.webhookQueue:
+			if err := s.dispatchWebhooks(ctx, ev); err != nil {
+				s.logger.ErrorContext(ctx, "dispatching webhooks failed", "table", ev.Table, "row", ev.Row, "error", err)
+			}
+		}
+	}
+}
+
+// dispatchWebhooks delivers a row event to every matching webhook of its
+// table, dead-lettering the deliveries that fail
+func (s *Server) dispatchWebhooks(ctx context.Context, ev plugin.RowEvent) error {
+	store, err := s.getStoreForUser(ctx, ev.UserID)
+	if err != nil {
+		return err
+	}
+	defs, err := store.QueryByField(ctx, ev.UserID, ev.Table, time.Time{}, time.Now().UTC())
+	if err != nil || !tableLive(defs) {
+		return err
+	}
+	hooks, err := s.loadWebhooks(ctx, store, ev.UserID, ev.Table, defs)
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range hooks {
+		if !hook.handles(ev.Type) {
+			continue
+		}
+		dead := s.deliverWebhook(ctx, hook, ev)
+		if dead == nil {
+			continue
+		}
+		data, err := json.Marshal(dead)
+		if err != nil {
+			return err
+		}
+		if err := store.PutFact(ctx, dynamo.Fact{
+			ID:        dead.ID,
+			Timestamp: dead.FailedAt,
+			Namespace: ev.UserID,
+			FieldName: deadLetterField(ev.Table),
+			DataType:  deadLetterDataType,
+			Value:     string(data),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliverWebhook sends a row event to one webhook, returning the dead letter
+// to record if every attempt failed
+func (s *Server) deliverWebhook(ctx context.Context, hook storedWebhook, ev plugin.RowEvent) *DeadLetter {
+	payload := WebhookPayload{ID: newID(), Type: ev.Type, Table: ev.Table, Row: ev.Row, Timestamp: ev.Timestamp, Values: ev.Values}
+	dead := func(attempts int, err error) *DeadLetter {
+		s.metrics.ObserveWebhookDelivery("failed")
+		s.logger.WarnContext(ctx, "webhook delivery failed", "webhook", hook.ID, "table", ev.Table, "row", ev.Row, "attempts", attempts, "error", err)
+		return &DeadLetter{ID: newID(), Webhook: hook.ID, URL: hook.URL, Payload: payload, Attempts: attempts, Error: err.Error(), FailedAt: time.Now().UTC()}
+	}
+
+	// Hosts may have been removed from the allowlist since the webhook was created
+	if err := s.checkWebhookURL(hook.URL); err != nil {
+		return dead(0, err)
+	}
+	secret, err := s.webhookSecret(ctx, ev.UserID, ev.Table, hook)
+	if err != nil {
+		return dead(0, fmt.Errorf("opening signing secret: %w", err))
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return dead(0, err)
+	}
+
+	attempts, err := s.webhookSender.Send(ctx, hook.URL, secret, ev.Type, payload.ID, body)
+	if err != nil {
+		return dead(attempts, err)
+	}
+	s.metrics.ObserveWebhookDelivery("delivered")
+	return nil
+}
+
+// lookupWebhookTable resolves the table of a webhook request, writing the
+// error response and returning false if it cannot be used
+func (s *Server) lookupWebhookTable(w http.ResponseWriter, r *http.Request, user *auth.User, table string) (*db.StoreAdapter, []dynamo.Fact, bool) {
+	// Get store for user
+	store, err := s.getStoreForUser(r.Context(), user.ID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to initialize storage")
+		return nil, nil, false
+	}
+
+	// Validate table exists
+	facts, err := store.QueryByField(r.Context(), user.ID, table, time.Time{}, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to look up table")
+		return nil, nil, false
+	}
+	if !s.tableVisible(r.Context(), facts) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Table '%s' not found", table))
+		return nil, nil, false
+	}
+	return store, facts, true
+}
+
+// handleListWebhooks returns a table's webhooks, without their secrets
+func (s *Server) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+	store, facts, ok := s.lookupWebhookTable(w, r, user, table)
+	if !ok {
+		return
+	}
+
+	hooks, err := s.loadWebhooks(r.Context(), store, user.ID, table, facts)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load webhooks")
+		return
+	}
+	out := make([]Webhook, 0, len(hooks))
+	for _, h := range hooks {
+		out = append(out, h.Webhook)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"webhooks": out})
+}
+
+// handleCreateWebhook registers a callback URL for a table's row events. A
+// signing secret is generated unless one is given; either way it is returned
+// only in this response.
+func (s *Server) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	var fields fieldErrors
+	fields.required("url", req.URL)
+	if req.URL != "" {
+		if err := s.checkWebhookURL(req.URL); errors.Is(err, errWebhooksDisabled) {
+			writeError(w, http.StatusNotImplemented, "Webhooks are not configured on this server")
+			return
+		} else if err != nil {
+			fields = append(fields, FieldError{Field: "url", Message: err.Error()})
+		}
+	}
+	if len(req.Events) == 0 {
+		req.Events = []string{automationEventCreate, automationEventUpdate, webhookEventDelete, rowEventExpire}
+	}
+	for _, e := range req.Events {
+		if e != automationEventCreate && e != automationEventUpdate && e != webhookEventDelete && e != rowEventExpire {
+			fields = append(fields, FieldError{Field: "events", Message: fmt.Sprintf("unknown event '%s'", e)})
+		}
+	}
+	if req.Secret != "" && len(req.Secret) < 16 {
+		fields = append(fields, FieldError{Field: "secret", Message: "must be at least 16 characters"})
+	}
+	if len(fields) > 0 {
+		writeValidationError(w, "Invalid webhook", fields)
+		return
+	}
+
+	store, facts, ok := s.lookupWebhookTable(w, r, user, table)
+	if !ok {
+		return
+	}
+	if tableOptionsOf(latestTableDef(facts)).Type == tableTypeSecrets {
+		writeError(w, http.StatusBadRequest, "Secrets tables cannot have webhooks")
+		return
+	}
+
+	hooks, err := s.loadWebhooks(r.Context(), store, user.ID, table, facts)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load webhooks")
+		return
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to generate webhook secret")
+			return
+		}
+		secret = webhookSecretPrefix + hex.EncodeToString(b)
+	}
+
+	hook := storedWebhook{Webhook: Webhook{ID: newID(), URL: req.URL, Events: req.Events, CreatedAt: time.Now().UTC()}}
+	if s.sealer != nil {
+		env, err := s.sealer.Seal(r.Context(), []byte(secret), webhookSecretAAD(user.ID, table, hook.ID))
+		if err == nil {
+			hook.SealedSecret, err = env.Encode()
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to seal webhook secret: %v", err))
+			return
+		}
+	} else {
+		hook.SigningSecret = secret
+	}
+
+	if err := s.saveWebhooks(r.Context(), store, user.ID, table, append(hooks, hook)); err != nil {
+		writeStoreError(w, err, "Failed to save webhook")
+		return
+	}
+
+	created := hook.Webhook
+	created.Secret = secret
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// handleDeleteWebhook removes a webhook from a table
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+	id := r.PathValue("id")
+	store, facts, ok := s.lookupWebhookTable(w, r, user, table)
+	if !ok {
+		return
+	}
+
+	hooks, err := s.loadWebhooks(r.Context(), store, user.ID, table, facts)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load webhooks")
+		return
+	}
+
+	found := -1
+	for i, h := range hooks {
+		if h.ID == id {
+			found = i
+			break
+		}
+	}
+	if found < 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Webhook '%s' not found on table '%s'", id, table))
+		return
+	}
+
+	hooks = append(hooks[:found], hooks[found+1:]...)
+	if err := s.saveWebhooks(r.Context(), store, user.ID, table, hooks); err != nil {
+		writeStoreError(w, err, "Failed to delete webhook")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListDeadLetters returns a table's failed deliveries, newest first,
+// optionally only those of one webhook
+func (s *Server) handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "User not found in context")
+		return
+	}
+
+	table := r.PathValue("table")
+	webhookID := r.URL.Query().Get("webhook")
+	store, facts, ok := s.lookupWebhookTable(w, r, user, table)
+	if !ok {
+		return
+	}
+
+	entries, err := store.QueryByField(r.Context(), user.ID, deadLetterField(table), latestTableDef(facts).Timestamp, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err, "Failed to load dead letters")
+		return
+	}
+
+	letters := []DeadLetter{}
+	for _, f := range entries {
+		if f.DataType != deadLetterDataType {
+			continue
+		}
+		raw, _ := f.Value.(string)
+		var dl DeadLetter
+		if err := json.Unmarshal([]byte(raw), &dl); err != nil {
+			s.logger.WarnContext(r.Context(), "invalid dead letter", "table", table, "id", f.ID, "error", err)
+			continue
+		}
+		if webhookID == "" || dl.Webhook == webhookID {
+			letters = append(letters, dl)
+		}
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].FailedAt.After(letters[j].FailedAt) })
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"deadLetters": letters})
+}
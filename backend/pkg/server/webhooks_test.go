@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/pkg/backoff"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/elibdev/notably/pkg/plugin"
+	"github.com/elibdev/notably/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateWebhookValidation(t *testing.T) {
+	send := func(srv *Server, body string) *httptest.ResponseRecorder {
+		ctx := context.Background()
+		user, err := srv.authenticator.RegisterUser(ctx, "alice", "alice@example.com", "pw")
+		require.NoError(t, err)
+		_, apiKey, err := srv.authenticator.GenerateAPIKey(ctx, user.ID, "hooks", 0)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/tables/orders/webhooks", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard()})
+	require.NoError(t, err)
+	rec := send(srv, `{"url": "https://hooks.example.com/orders"}`)
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+
+	for _, body := range []string{
+		`{}`,
+		`{"url": "https://evil.example.com/orders"}`,
+		`{"url": "ftp://hooks.example.com/orders"}`,
+		`{"url": "https://hooks.example.com/orders", "events": ["archive"]}`,
+		`{"url": "https://hooks.example.com/orders", "secret": "short"}`,
+	} {
+		srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), WebhookHosts: []string{"hooks.example.com"}})
+		require.NoError(t, err)
+		rec := send(srv, body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+}
+
+func TestDeliverWebhookSignsPayload(t *testing.T) {
+	var got WebhookPayload
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.NoError(t, webhook.Verify([]byte("whsec_0123456789abcdef"), r.Header.Get(webhook.SignatureHeader), body, time.Minute, time.Now()))
+		assert.Equal(t, "update", r.Header.Get(webhook.EventHeader))
+		require.NoError(t, json.Unmarshal(body, &got))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer receiver.Close()
+
+	masterKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{9}, 32))
+	srv, err := NewServer(Config{TableName: "Facts", MasterKey: masterKey, Logger: logging.Discard(), WebhookHosts: []string{"127.0.0.1"}})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	hook := storedWebhook{Webhook: Webhook{ID: "h1", URL: receiver.URL, Events: []string{"update"}}}
+	env, err := srv.sealer.Seal(ctx, []byte("whsec_0123456789abcdef"), webhookSecretAAD("u1", "orders", "h1"))
+	require.NoError(t, err)
+	hook.SealedSecret, err = env.Encode()
+	require.NoError(t, err)
+
+	at := time.Now().UTC().Truncate(time.Second)
+	ev := plugin.RowEvent{Type: "update", UserID: "u1", Table: "orders", Row: "o-1", Timestamp: at, Values: map[string]interface{}{"status": "shipped"}}
+	assert.Nil(t, srv.deliverWebhook(ctx, hook, ev))
+	assert.Equal(t, "orders", got.Table)
+	assert.Equal(t, "o-1", got.Row)
+	assert.Equal(t, at, got.Timestamp)
+	assert.Equal(t, map[string]interface{}{"status": "shipped"}, got.Values)
+
+	// The sealed secret is bound to its webhook
+	_, err = srv.webhookSecret(ctx, "u1", "other", hook)
+	assert.Error(t, err)
+}
+
+func TestDeliverWebhookDeadLetters(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard(), WebhookHosts: []string{"127.0.0.1"}})
+	require.NoError(t, err)
+	srv.webhookSender = &webhook.Sender{Policy: backoff.Policy{MaxAttempts: 3, MaxElapsed: time.Second, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}}
+
+	hook := storedWebhook{Webhook: Webhook{ID: "h1", URL: receiver.URL}, SigningSecret: "whsec_plain"}
+	ev := plugin.RowEvent{Type: "delete", UserID: "u1", Table: "orders", Row: "o-1", Timestamp: time.Now().UTC()}
+
+	dead := srv.deliverWebhook(context.Background(), hook, ev)
+	require.NotNil(t, dead)
+	assert.Equal(t, "h1", dead.Webhook)
+	assert.Equal(t, 3, dead.Attempts)
+	assert.Contains(t, dead.Error, "500")
+	assert.Equal(t, "delete", dead.Payload.Type)
+
+	// Hosts removed from the allowlist are not contacted
+	srv.config.WebhookHosts = []string{"hooks.example.com"}
+	dead = srv.deliverWebhook(context.Background(), hook, ev)
+	require.NotNil(t, dead)
+	assert.Zero(t, dead.Attempts)
+}
+
+func TestPublishRowEventQueuesOnlyWithWebhooksEnabled(t *testing.T) {
+	srv, err := NewServer(Config{TableName: "Facts", Logger: logging.Discard()})
+	require.NoError(t, err)
+	srv.publishRowEvent(plugin.RowEvent{Type: "create", Table: "orders", Row: "o-1"})
+	assert.Len(t, srv.webhookQueue, 0)
+
+	srv.config.WebhookHosts = []string{"*"}
+	srv.publishRowEvent(plugin.RowEvent{Type: "create", Table: "orders", Row: "o-1"})
+	assert.Len(t, srv.webhookQueue, 1)
+}
@@ -0,0 +1,196 @@
+package sqlquery
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/elibdev/notably/pkg/filter"
+	"github.com/elibdev/notably/pkg/query"
+)
+
+// MaxRows bounds the rows one query may return
+const MaxRows = 10000
+
+// Result is the outcome of a query: its column names, and one array of
+// values per row in the same order
+type Result struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// Options tune how a plan runs
+type Options struct {
+	// Columns are the table's columns, which SELECT * returns in order after
+	// the row ID. Without them it returns every column rows have, by name.
+	Columns []string
+	// SortValue converts a column's value to the value it orders by, such as
+	// a datetime to its instant; nil orders values as they are
+	SortValue func(column string, v interface{}) interface{}
+}
+
+// Execute runs the plan over the rows of its table, each holding its ID
+// under RowID. Rows that order equal keep their ID order.
+func (p *Plan) Execute(rows []map[string]interface{}, opts Options) (*Result, error) {
+	q := p.Query
+	matched := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		if q.Where.Match(row) {
+			matched = append(matched, row)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, _ := matched[i][RowID].(string)
+		b, _ := matched[j][RowID].(string)
+		return a < b
+	})
+
+	var columns []string
+	var out []map[string]interface{}
+	if p.Aggregated {
+		groups, err := query.Aggregate(matched, q.GroupBy, p.Metrics)
+		if err != nil {
+			return nil, err
+		}
+		out = make([]map[string]interface{}, len(groups))
+		for i, g := range groups {
+			values := make(map[string]interface{}, len(g.Key)+len(g.Metrics))
+			for name, v := range g.Key {
+				values[name] = v
+			}
+			for _, m := range p.Metrics {
+				values[m.Name()] = g.Metrics[m.Name()]
+			}
+			out[i] = values
+		}
+		for _, it := range q.Items {
+			columns = append(columns, it.Name())
+		}
+	} else {
+		out = matched
+		if q.Star {
+			columns = starColumns(matched, opts.Columns)
+		} else {
+			for _, it := range q.Items {
+				columns = append(columns, it.Name())
+			}
+		}
+	}
+
+	if len(q.OrderBy) > 0 {
+		keys := p.sortKeys(out, opts)
+		order := make(filter.Sort, len(q.OrderBy))
+		for i, o := range q.OrderBy {
+			order[i] = filter.SortKey{Column: o.Name, Desc: o.Desc}
+		}
+		index := make([]int, len(out))
+		for i := range index {
+			index[i] = i
+		}
+		sort.SliceStable(index, func(i, j int) bool {
+			return order.Compare(keys[index[i]], keys[index[j]]) < 0
+		})
+		sorted := make([]map[string]interface{}, len(out))
+		for i, k := range index {
+			sorted[i] = out[k]
+		}
+		out = sorted
+	}
+	if q.Limit >= 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	if len(out) > MaxRows {
+		return nil, fmt.Errorf("more than %d result rows; add a LIMIT", MaxRows)
+	}
+
+	res := &Result{Columns: columns, Rows: make([][]interface{}, len(out))}
+	for i, values := range out {
+		row := make([]interface{}, len(columns))
+		switch {
+		case p.Aggregated:
+			for k, it := range q.Items {
+				row[k] = values[SelectItem{Func: it.Func, Column: it.Column}.Name()]
+			}
+		case q.Star:
+			for k, name := range columns {
+				row[k] = values[name]
+			}
+		default:
+			for k, it := range q.Items {
+				row[k] = values[it.Column]
+			}
+		}
+		res.Rows[i] = row
+	}
+	return res, nil
+}
+
+// sortKeys returns the values each row orders by, keyed by the ORDER BY
+// names. Aliases order by the values they name; decimal aggregates order by
+// value rather than as strings.
+func (p *Plan) sortKeys(rows []map[string]interface{}, opts Options) []map[string]interface{} {
+	q := p.Query
+	source := make(map[string]string, len(q.OrderBy))
+	for _, o := range q.OrderBy {
+		source[o.Name] = o.Name
+		for _, it := range q.Items {
+			if it.Alias == o.Name {
+				source[o.Name] = SelectItem{Func: it.Func, Column: it.Column}.Name()
+				break
+			}
+		}
+	}
+	decimal := make(map[string]bool)
+	for _, m := range p.Metrics {
+		decimal[m.Name()] = m.Kind == query.KindDecimal
+	}
+	grouped := make(map[string]bool, len(q.GroupBy))
+	for _, name := range q.GroupBy {
+		grouped[name] = true
+	}
+
+	keys := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		key := make(map[string]interface{}, len(source))
+		for name, from := range source {
+			v := row[from]
+			switch s, ok := v.(string); {
+			case decimal[from] && ok:
+				if r, ok := new(big.Rat).SetString(s); ok {
+					v = r
+				}
+			case opts.SortValue != nil && (!p.Aggregated || grouped[from]):
+				v = opts.SortValue(from, v)
+			}
+			key[name] = v
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+// starColumns returns the columns of SELECT *: the row ID, then the table's
+// columns or else every column the rows have, by name
+func starColumns(rows []map[string]interface{}, defined []string) []string {
+	columns := []string{RowID}
+	if len(defined) > 0 {
+		for _, name := range defined {
+			if name != RowID {
+				columns = append(columns, name)
+			}
+		}
+		return columns
+	}
+	seen := map[string]bool{RowID: true}
+	var names []string
+	for _, row := range rows {
+		for name := range row {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return append(columns, names...)
+}
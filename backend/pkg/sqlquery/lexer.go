@@ -0,0 +1,130 @@
+package sqlquery
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tokenKind classifies a token
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokKeyword
+	tokString
+	tokNumber
+	tokSymbol
+)
+
+// keywords are the reserved words of the dialect. Other words are
+// identifiers; reserved words can be used as identifiers by quoting them.
+var keywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "AS": true, "OF": true,
+	"TIMESTAMP": true, "AND": true, "OR": true, "NOT": true, "IN": true,
+	"IS": true, "NULL": true, "LIKE": true, "TRUE": true, "FALSE": true,
+	"GROUP": true, "ORDER": true, "BY": true, "ASC": true, "DESC": true,
+	"LIMIT": true,
+}
+
+// token is a lexed token. Keywords are upper-cased; quoted identifiers and
+// strings hold their unquoted text.
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokString:
+		return fmt.Sprintf("'%s'", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// lex splits a query into tokens, ending with a tokEOF
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '-' && strings.HasPrefix(src[i:], "--"):
+			// A comment runs to the end of the line
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && isIdentPart(src[i]) {
+				i++
+			}
+			word := src[start:i]
+			if keywords[strings.ToUpper(word)] {
+				tokens = append(tokens, token{kind: tokKeyword, text: strings.ToUpper(word), pos: start})
+			} else {
+				tokens = append(tokens, token{kind: tokIdent, text: word, pos: start})
+			}
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.' || src[i] == 'e' || src[i] == 'E' ||
+				(src[i] == '+' || src[i] == '-') && (src[i-1] == 'e' || src[i-1] == 'E')) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[start:i], pos: start})
+		case c == '\'' || c == '"':
+			// Strings are single-quoted and identifiers double-quoted; a
+			// doubled quote stands for itself
+			start := i
+			var text strings.Builder
+			i++
+			for {
+				if i >= len(src) {
+					return nil, fmt.Errorf("unterminated quote at position %d", start+1)
+				}
+				if src[i] == c {
+					if i+1 < len(src) && src[i+1] == c {
+						text.WriteByte(c)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				text.WriteByte(src[i])
+				i++
+			}
+			kind := tokString
+			if c == '"' {
+				kind = tokIdent
+			}
+			tokens = append(tokens, token{kind: kind, text: text.String(), pos: start})
+		default:
+			sym := string(c)
+			if i+1 < len(src) {
+				switch two := src[i : i+2]; two {
+				case "<=", ">=", "<>", "!=":
+					sym = two
+				}
+			}
+			if len(sym) == 1 && !strings.ContainsRune("*,()=<>;-", rune(c)) {
+				return nil, fmt.Errorf("unexpected %q at position %d", sym, i+1)
+			}
+			tokens = append(tokens, token{kind: tokSymbol, text: sym, pos: i})
+			i += len(sym)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
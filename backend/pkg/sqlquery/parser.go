@@ -0,0 +1,513 @@
+// Package sqlquery runs a small, read-only SQL dialect over table
+// snapshots, so analysts can query rows as they are or as they were:
+//
+//	SELECT name, total FROM orders
+//	WHERE status = 'open' AND total >= 100
+//	AS OF TIMESTAMP '2024-01-01T00:00:00Z'
+//	ORDER BY total DESC LIMIT 10
+//
+//	SELECT status, count(*), sum(total) AS revenue FROM orders GROUP BY status
+//
+// A query is parsed into a Query, planned into a Plan, which is checked and
+// tells the caller which table to read at which time, and then executed
+// over that table's rows. The WHERE clause compiles to a filter.Filter and
+// aggregates to query.Metric values, so the dialect means what the rest of
+// the API does.
+package sqlquery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/pkg/filter"
+)
+
+// RowID is the column that holds a row's ID
+const RowID = "id"
+
+// Query is a parsed SELECT statement
+type Query struct {
+	// Star is set by SELECT *, which selects the row ID and every column
+	Star  bool
+	Items []SelectItem
+	Table string
+	// AsOf is the time to read the table at; zero reads it as it is now
+	AsOf    time.Time
+	Where   *filter.Filter
+	GroupBy []string
+	OrderBy []OrderItem
+	// Limit bounds the result rows; negative means no LIMIT was given
+	Limit int
+}
+
+// SelectItem is a column or an aggregate of the select list. Func is empty
+// for a column; Column is empty for count(*).
+type SelectItem struct {
+	Func   string
+	Column string
+	Alias  string
+}
+
+// Name is the name of the item's result column: its alias, its column or
+// the aggregate as written, such as sum(total)
+func (it SelectItem) Name() string {
+	switch {
+	case it.Alias != "":
+		return it.Alias
+	case it.Func == "":
+		return it.Column
+	case it.Column == "":
+		return it.Func
+	}
+	return it.Func + "(" + it.Column + ")"
+}
+
+// OrderItem is a result or table column to order by
+type OrderItem struct {
+	Name string
+	Desc bool
+}
+
+// maxDepth bounds how deeply WHERE conditions may nest
+const maxDepth = filter.MaxDepth
+
+// Parse parses a SELECT statement
+func Parse(src string) (*Query, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	q, err := p.query()
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// parser is a recursive descent parser over a query's tokens
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// peek returns the next token. Reading past the end keeps returning the
+// final tokEOF.
+func (p *parser) peek() token {
+	if p.pos >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos]
+}
+
+// next consumes the next token; p.pos-- puts it back
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// accept consumes the next token if it is the keyword or symbol text
+func (p *parser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == tokKeyword || t.kind == tokSymbol) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("expected %s", text)
+	}
+	return nil
+}
+
+// errorf reports an error at the next token
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	return fmt.Errorf("%s at position %d, found %s", fmt.Sprintf(format, args...), t.pos+1, t)
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.peek()
+	if t.kind != tokIdent {
+		return "", p.errorf("expected a name")
+	}
+	p.pos++
+	return t.text, nil
+}
+
+func (p *parser) query() (*Query, error) {
+	q := &Query{Limit: -1}
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+	if p.accept("*") {
+		q.Star = true
+	} else {
+		for {
+			item, err := p.selectItem()
+			if err != nil {
+				return nil, err
+			}
+			q.Items = append(q.Items, item)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+	table, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	q.Table = table
+
+	// AS OF may come right after the table or after the WHERE clause
+	if err := p.asOf(q); err != nil {
+		return nil, err
+	}
+	if p.accept("WHERE") {
+		if q.Where, err = p.or(1); err != nil {
+			return nil, err
+		}
+		if err := p.asOf(q); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("GROUP") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			name, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			q.GroupBy = append(q.GroupBy, name)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if p.accept("ORDER") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			item, err := p.orderItem()
+			if err != nil {
+				return nil, err
+			}
+			q.OrderBy = append(q.OrderBy, item)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if p.accept("LIMIT") {
+		t := p.next()
+		n, err := strconv.Atoi(t.text)
+		if t.kind != tokNumber || err != nil || n < 0 {
+			p.pos--
+			return nil, p.errorf("expected a row count")
+		}
+		q.Limit = n
+	}
+	p.accept(";")
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("unexpected input")
+	}
+	return q, nil
+}
+
+// asOf parses an optional AS OF TIMESTAMP clause
+func (p *parser) asOf(q *Query) error {
+	if p.peek().text != "AS" || p.peek().kind != tokKeyword {
+		return nil
+	}
+	p.pos++
+	if err := p.expect("OF"); err != nil {
+		return err
+	}
+	if err := p.expect("TIMESTAMP"); err != nil {
+		return err
+	}
+	if !q.AsOf.IsZero() {
+		p.pos--
+		return p.errorf("AS OF given twice")
+	}
+	t := p.peek()
+	if t.kind != tokString {
+		return p.errorf("expected an RFC 3339 time in quotes")
+	}
+	at, err := time.Parse(time.RFC3339, t.text)
+	if err != nil {
+		return p.errorf("expected an RFC 3339 time")
+	}
+	p.pos++
+	q.AsOf = at.UTC()
+	return nil
+}
+
+func (p *parser) selectItem() (SelectItem, error) {
+	name, err := p.ident()
+	if err != nil {
+		return SelectItem{}, err
+	}
+	item := SelectItem{Column: name}
+	if p.accept("(") {
+		item = SelectItem{Func: strings.ToLower(name)}
+		if !p.accept("*") {
+			if item.Column, err = p.ident(); err != nil {
+				return SelectItem{}, err
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return SelectItem{}, err
+		}
+	}
+	if p.accept("AS") {
+		if item.Alias, err = p.ident(); err != nil {
+			return SelectItem{}, err
+		}
+	}
+	return item, nil
+}
+
+func (p *parser) orderItem() (OrderItem, error) {
+	name, err := p.ident()
+	if err != nil {
+		return OrderItem{}, err
+	}
+	// An aggregate is ordered by as written, such as ORDER BY count(*)
+	if p.accept("(") {
+		arg := ""
+		if !p.accept("*") {
+			if arg, err = p.ident(); err != nil {
+				return OrderItem{}, err
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return OrderItem{}, err
+		}
+		name = SelectItem{Func: strings.ToLower(name), Column: arg}.Name()
+	}
+	item := OrderItem{Name: name}
+	if p.accept("DESC") {
+		item.Desc = true
+	} else {
+		p.accept("ASC")
+	}
+	return item, nil
+}
+
+// or parses conditions joined by OR
+func (p *parser) or(depth int) (*filter.Filter, error) {
+	if depth > maxDepth {
+		return nil, p.errorf("conditions nested more than %d deep", maxDepth)
+	}
+	f, err := p.and(depth)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().text != "OR" || p.peek().kind != tokKeyword {
+		return f, nil
+	}
+	either := &filter.Filter{Or: []filter.Filter{*f}}
+	for p.accept("OR") {
+		next, err := p.and(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		either.Or = append(either.Or, *next)
+	}
+	return either, nil
+}
+
+// and parses conditions joined by AND
+func (p *parser) and(depth int) (*filter.Filter, error) {
+	f, err := p.not(depth)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().text != "AND" || p.peek().kind != tokKeyword {
+		return f, nil
+	}
+	all := &filter.Filter{And: []filter.Filter{*f}}
+	for p.accept("AND") {
+		next, err := p.not(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		all.And = append(all.And, *next)
+	}
+	return all, nil
+}
+
+func (p *parser) not(depth int) (*filter.Filter, error) {
+	if p.accept("NOT") {
+		if depth+1 > maxDepth {
+			return nil, p.errorf("conditions nested more than %d deep", maxDepth)
+		}
+		f, err := p.not(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &filter.Filter{Not: f}, nil
+	}
+	return p.condition(depth)
+}
+
+// comparisons maps comparison symbols to filter ops
+var comparisons = map[string]string{
+	"=": filter.OpEq, "<>": filter.OpNe, "!=": filter.OpNe,
+	"<": filter.OpLt, "<=": filter.OpLte, ">": filter.OpGt, ">=": filter.OpGte,
+}
+
+// condition parses a parenthesized condition or a comparison of a column
+// with a literal
+func (p *parser) condition(depth int) (*filter.Filter, error) {
+	if p.accept("(") {
+		f, err := p.or(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+
+	column, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	cond := &filter.Filter{Column: column}
+	t := p.next()
+	if op, ok := comparisons[t.text]; ok && t.kind == tokSymbol {
+		if cond.Value, err = p.literal(); err != nil {
+			return nil, err
+		}
+		cond.Op = op
+		if cond.Value == nil && op != filter.OpEq && op != filter.OpNe {
+			return nil, fmt.Errorf("cannot compare %s with NULL using %s; use IS NULL", column, t.text)
+		}
+		return cond, nil
+	}
+	if t.kind != tokKeyword {
+		p.pos--
+		return nil, p.errorf("expected a comparison")
+	}
+
+	switch t.text {
+	case "IS":
+		negate := p.accept("NOT")
+		if err := p.expect("NULL"); err != nil {
+			return nil, err
+		}
+		cond.Op = filter.OpExists
+		if !negate {
+			return &filter.Filter{Not: cond}, nil
+		}
+		return cond, nil
+	case "NOT":
+		inner, err := p.keywordCondition(column, p.next())
+		if err != nil {
+			return nil, err
+		}
+		return &filter.Filter{Not: inner}, nil
+	}
+	return p.keywordCondition(column, t)
+}
+
+// keywordCondition parses the rest of an IN or LIKE condition
+func (p *parser) keywordCondition(column string, t token) (*filter.Filter, error) {
+	switch {
+	case t.kind == tokKeyword && t.text == "IN":
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		values := []interface{}{}
+		for {
+			v, err := p.literal()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return &filter.Filter{Column: column, Op: filter.OpIn, Value: values}, nil
+	case t.kind == tokKeyword && t.text == "LIKE":
+		pt := p.next()
+		if pt.kind != tokString {
+			p.pos--
+			return nil, p.errorf("expected a LIKE pattern in quotes")
+		}
+		return likeFilter(column, pt.text)
+	}
+	p.pos--
+	return nil, p.errorf("expected a comparison")
+}
+
+// likeFilter compiles a LIKE pattern. Only patterns with % at their ends
+// have a filter equivalent: 'text', 'text%' and '%text%'. _ matches itself.
+func likeFilter(column, pattern string) (*filter.Filter, error) {
+	inner := strings.TrimSuffix(pattern, "%")
+	trailing := inner != pattern
+	inner, leading := strings.CutPrefix(inner, "%")
+	if strings.Contains(inner, "%") || leading && !trailing {
+		return nil, fmt.Errorf("LIKE pattern '%s' is not supported; use 'text', 'text%%' or '%%text%%'", pattern)
+	}
+	switch {
+	case leading:
+		return &filter.Filter{Column: column, Op: filter.OpContains, Value: inner}, nil
+	case trailing:
+		return &filter.Filter{Column: column, Op: filter.OpPrefix, Value: inner}, nil
+	}
+	return &filter.Filter{Column: column, Op: filter.OpEq, Value: inner}, nil
+}
+
+// literal parses a string, number, boolean or NULL
+func (p *parser) literal() (interface{}, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokString:
+		return t.text, nil
+	case t.kind == tokNumber:
+		return p.number(t, "")
+	case t.kind == tokSymbol && t.text == "-":
+		n := p.next()
+		if n.kind == tokNumber {
+			return p.number(n, "-")
+		}
+	case t.kind == tokKeyword && t.text == "TRUE":
+		return true, nil
+	case t.kind == tokKeyword && t.text == "FALSE":
+		return false, nil
+	case t.kind == tokKeyword && t.text == "NULL":
+		return nil, nil
+	}
+	p.pos--
+	return nil, p.errorf("expected a value")
+}
+
+func (p *parser) number(t token, sign string) (interface{}, error) {
+	f, err := strconv.ParseFloat(sign+t.text, 64)
+	if err != nil {
+		p.pos--
+		return nil, p.errorf("invalid number")
+	}
+	return f, nil
+}
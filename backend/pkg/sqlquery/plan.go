@@ -0,0 +1,111 @@
+package sqlquery
+
+import (
+	"fmt"
+
+	"github.com/elibdev/notably/pkg/query"
+)
+
+// Plan is a checked query, ready to run over the rows of its table
+type Plan struct {
+	Query *Query
+	// Metrics are the aggregates of the select list, in order. Callers may
+	// set a metric's Kind to match its column's type before executing.
+	Metrics []query.Metric
+	// Aggregated is set when the query computes aggregates or groups rows
+	Aggregated bool
+}
+
+// NewPlan checks a parsed query. Aggregating queries may only select the
+// group-by columns besides aggregates, and may only order by their result
+// columns.
+func NewPlan(q *Query) (*Plan, error) {
+	p := &Plan{Query: q}
+	if q.Where != nil {
+		if err := q.Where.Validate(); err != nil {
+			return nil, fmt.Errorf("WHERE: %w", err)
+		}
+	}
+	for _, it := range q.Items {
+		if it.Func == "" {
+			continue
+		}
+		p.Aggregated = true
+		switch it.Func {
+		case query.FuncCount:
+		case query.FuncSum, query.FuncAvg, query.FuncMin, query.FuncMax:
+			if it.Column == "" {
+				return nil, fmt.Errorf("%s needs a column, as in %s(total)", it.Func, it.Func)
+			}
+		default:
+			return nil, fmt.Errorf("unknown aggregate function %s", it.Func)
+		}
+		p.Metrics = append(p.Metrics, query.Metric{Func: it.Func, Column: it.Column})
+	}
+	if len(q.GroupBy) > 0 {
+		p.Aggregated = true
+	}
+	if !p.Aggregated {
+		return p, nil
+	}
+
+	if q.Star {
+		return nil, fmt.Errorf("SELECT * cannot be grouped; list the group-by columns")
+	}
+	grouped := make(map[string]bool, len(q.GroupBy))
+	for _, name := range q.GroupBy {
+		grouped[name] = true
+	}
+	results := make(map[string]bool, len(q.Items))
+	for _, it := range q.Items {
+		if it.Func == "" && !grouped[it.Column] {
+			return nil, fmt.Errorf("column %s must appear in GROUP BY or be aggregated", it.Column)
+		}
+		results[it.Name()] = true
+		if it.Func != "" {
+			results[SelectItem{Func: it.Func, Column: it.Column}.Name()] = true
+		}
+	}
+	for _, o := range q.OrderBy {
+		if !results[o.Name] && !grouped[o.Name] {
+			return nil, fmt.Errorf("ORDER BY %s must name a selected column or aggregate", o.Name)
+		}
+	}
+	return p, nil
+}
+
+// Columns returns the table columns the query reads, in the order they
+// appear, so they can be checked against the table's schema. Result names
+// ordered by are left out.
+func (p *Plan) Columns() []string {
+	var out []string
+	seen := map[string]bool{}
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+	results := make(map[string]bool, len(p.Query.Items))
+	for _, it := range p.Query.Items {
+		add(it.Column)
+		if it.Func != "" || it.Alias != "" {
+			results[it.Name()] = true
+			results[SelectItem{Func: it.Func, Column: it.Column}.Name()] = true
+		}
+	}
+	if p.Query.Where != nil {
+		for _, name := range p.Query.Where.Columns() {
+			add(name)
+		}
+	}
+	for _, name := range p.Query.GroupBy {
+		add(name)
+	}
+	for _, o := range p.Query.OrderBy {
+		if !results[o.Name] {
+			add(o.Name)
+		}
+	}
+	return out
+}
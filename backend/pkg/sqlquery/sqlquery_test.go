@@ -0,0 +1,144 @@
+package sqlquery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/pkg/filter"
+	"github.com/elibdev/notably/pkg/query"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	q, err := Parse(`select name, "order total" AS total, count(*) FROM orders
+		WHERE status IN ('open', 'held') AND NOT (total < -1.5 OR note IS NULL) AND name LIKE 'A%'
+		AS OF TIMESTAMP '2024-01-02T03:04:05+01:00' -- an hour earlier in UTC
+		GROUP BY name, "order total" ORDER BY count(*) DESC, name LIMIT 5;`)
+	require.NoError(t, err)
+	assert.Equal(t, "orders", q.Table)
+	assert.Equal(t, []SelectItem{
+		{Column: "name"},
+		{Column: "order total", Alias: "total"},
+		{Func: "count"},
+	}, q.Items)
+	assert.Equal(t, time.Date(2024, 1, 2, 2, 4, 5, 0, time.UTC), q.AsOf)
+	assert.Equal(t, []string{"name", "order total"}, q.GroupBy)
+	assert.Equal(t, []OrderItem{{Name: "count", Desc: true}, {Name: "name"}}, q.OrderBy)
+	assert.Equal(t, 5, q.Limit)
+	assert.Equal(t, &filter.Filter{And: []filter.Filter{
+		{Column: "status", Op: filter.OpIn, Value: []interface{}{"open", "held"}},
+		{Not: &filter.Filter{Or: []filter.Filter{
+			{Column: "total", Op: filter.OpLt, Value: -1.5},
+			{Not: &filter.Filter{Column: "note", Op: filter.OpExists}},
+		}}},
+		{Column: "name", Op: filter.OpPrefix, Value: "A"},
+	}}, q.Where)
+
+	q, err = Parse("SELECT * FROM t AS OF TIMESTAMP '2024-01-01T00:00:00Z' WHERE a = 1")
+	require.NoError(t, err)
+	assert.True(t, q.Star)
+	assert.Equal(t, -1, q.Limit)
+	assert.False(t, q.AsOf.IsZero(), "AS OF may follow the table")
+
+	for _, src := range []string{
+		"",
+		"DELETE FROM t",
+		"SELECT FROM t",
+		"SELECT a FROM",
+		"SELECT a FROM t WHERE",
+		"SELECT a FROM t WHERE a",
+		"SELECT a FROM t WHERE a = b",
+		"SELECT a FROM t WHERE a < NULL",
+		"SELECT a FROM t WHERE a LIKE '%a'",
+		"SELECT a FROM t WHERE a LIKE 'a%b'",
+		"SELECT a FROM t AS OF TIMESTAMP 'yesterday'",
+		"SELECT a FROM t AS OF TIMESTAMP '2024-01-01T00:00:00Z' WHERE a = 1 AS OF TIMESTAMP '2024-01-01T00:00:00Z'",
+		"SELECT a FROM t LIMIT -1",
+		"SELECT a FROM t; SELECT b FROM t",
+		"SELECT 'a FROM t",
+		"SELECT a FROM t WHERE a = 1 | 2",
+	} {
+		_, err := Parse(src)
+		assert.Error(t, err, src)
+	}
+}
+
+func TestNewPlan(t *testing.T) {
+	for _, src := range []string{
+		"SELECT a, count(*) FROM t",
+		"SELECT * FROM t GROUP BY a",
+		"SELECT sum(*) FROM t",
+		"SELECT median(a) FROM t",
+		"SELECT a FROM t GROUP BY a ORDER BY b",
+	} {
+		q, err := Parse(src)
+		require.NoError(t, err, src)
+		_, err = NewPlan(q)
+		assert.Error(t, err, src)
+	}
+
+	q, err := Parse("SELECT a AS x, sum(b) AS total FROM t WHERE c = 1 GROUP BY a ORDER BY total, x")
+	require.NoError(t, err)
+	p, err := NewPlan(q)
+	require.NoError(t, err)
+	assert.True(t, p.Aggregated)
+	assert.Equal(t, []query.Metric{{Func: query.FuncSum, Column: "b"}}, p.Metrics)
+	assert.Equal(t, []string{"a", "b", "c"}, p.Columns())
+}
+
+func TestExecute(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"id": "o3", "status": "open", "total": float64(30), "price": "10.50"},
+		{"id": "o1", "status": "open", "total": float64(10), "price": "9.25"},
+		{"id": "o2", "status": "paid", "total": float64(20), "price": "100"},
+		{"id": "o4", "status": "held"},
+	}
+	run := func(src string, opts Options) *Result {
+		t.Helper()
+		q, err := Parse(src)
+		require.NoError(t, err, src)
+		p, err := NewPlan(q)
+		require.NoError(t, err, src)
+		for i := range p.Metrics {
+			if p.Metrics[i].Column == "price" {
+				p.Metrics[i].Kind = query.KindDecimal
+			}
+		}
+		res, err := p.Execute(rows, opts)
+		require.NoError(t, err, src)
+		return res
+	}
+
+	res := run("SELECT id, total AS t FROM orders WHERE status = 'open' OR total IS NOT NULL ORDER BY t DESC LIMIT 2", Options{})
+	assert.Equal(t, []string{"id", "t"}, res.Columns)
+	assert.Equal(t, [][]interface{}{{"o3", float64(30)}, {"o2", float64(20)}}, res.Rows)
+
+	res = run("SELECT * FROM orders WHERE status <> 'open'", Options{Columns: []string{"status", "total"}})
+	assert.Equal(t, []string{"id", "status", "total"}, res.Columns)
+	assert.Equal(t, [][]interface{}{{"o2", "paid", float64(20)}, {"o4", "held", nil}}, res.Rows)
+
+	res = run("SELECT * FROM orders WHERE id = 'o1'", Options{})
+	assert.Equal(t, []string{"id", "price", "status", "total"}, res.Columns)
+
+	res = run("SELECT status, count(*) AS n, sum(price) FROM orders GROUP BY status ORDER BY sum(price) DESC", Options{})
+	assert.Equal(t, []string{"status", "n", "sum(price)"}, res.Columns)
+	assert.Equal(t, [][]interface{}{
+		{"paid", 1, "100"},
+		{"open", 2, "19.75"},
+		{"held", 1, "0"},
+	}, res.Rows, "decimal sums order by value")
+
+	res = run("SELECT count(*), max(total) FROM orders WHERE total > 100", Options{})
+	assert.Equal(t, [][]interface{}{{0, nil}}, res.Rows, "aggregating no rows gives one row")
+
+	// Values order by what SortValue makes of them
+	reversed := func(column string, v interface{}) interface{} {
+		if f, ok := v.(float64); ok && column == "total" {
+			return -f
+		}
+		return v
+	}
+	res = run("SELECT id FROM orders WHERE total IS NOT NULL ORDER BY total", Options{SortValue: reversed})
+	assert.Equal(t, [][]interface{}{{"o3"}, {"o2"}, {"o1"}}, res.Rows)
+}
@@ -0,0 +1,389 @@
+// Package tracing records traces of the work done to serve a request: a
+// span for the request, and child spans for the store and DynamoDB calls it
+// makes, exported to an OpenTelemetry collector.
+//
+// Spans travel in contexts. Start begins a child of the context's span and
+// does nothing when the context carries none, so code may be instrumented
+// without knowing whether tracing is enabled or the request sampled. A
+// Tracer starts the root spans, continuing the trace of a W3C traceparent
+// header, and exports ended spans in batches. Spans are sent as OTLP/HTTP
+// JSON, so the OpenTelemetry SDK is not a dependency.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	mathrand "math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultQueueSize is how many ended spans wait for export before new
+	// ones are dropped
+	defaultQueueSize = 2048
+	// batchSize is the most spans sent in one export
+	batchSize = 512
+	// exportInterval is how often queued spans are exported
+	exportInterval = 5 * time.Second
+)
+
+// Kind is the OTLP kind of a span
+type Kind int
+
+// Span kinds, numbered as in OTLP
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// String returns the ID in hex
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the ID is set
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// SpanID identifies a span within its trace
+type SpanID [8]byte
+
+// String returns the ID in hex
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the ID is set
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// SpanContext is what a span passes to its children, in this process or,
+// through a traceparent header, in another
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// ParseTraceparent parses a W3C traceparent header such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01". It reports
+// false for headers that are missing or invalid.
+func ParseTraceparent(h string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	var flags [1]byte
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) || !decodeHex(flags[:], parts[3]) {
+		return SpanContext{}, false
+	}
+	if !sc.TraceID.IsValid() || !sc.SpanID.IsValid() {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// decodeHex decodes exactly len(dst) bytes of lowercase hex
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// Traceparent returns the W3C traceparent header of the span context
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// Attribute is a key and value describing a span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// SpanData is the record of an ended span, as exported
+type SpanData struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// ParentID is unset for the root span of a trace in this process
+	ParentID   SpanID
+	Name       string
+	Kind       Kind
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	// Err is the error the span ended with, if any
+	Err string
+}
+
+// Attribute returns the value of the span's attribute key, or nil
+func (d SpanData) Attribute(key string) interface{} {
+	for _, a := range d.Attributes {
+		if a.Key == key {
+			return a.Value
+		}
+	}
+	return nil
+}
+
+// Span is a timed piece of work in a trace. The methods of a nil Span do
+// nothing, so callers need not check whether a span was started.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+type spanKey struct{}
+
+// FromContext returns the span carried by ctx, or nil
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start begins a span of work done in this process, as a child of the span
+// carried by ctx, and returns a context carrying it. Without a span in ctx
+// it returns ctx and a nil span. kv are alternating attribute keys and values.
+func Start(ctx context.Context, name string, kv ...interface{}) (context.Context, *Span) {
+	return start(ctx, name, KindInternal, kv)
+}
+
+// StartClient begins the span of a call to another service, such as
+// DynamoDB, like Start
+func StartClient(ctx context.Context, name string, kv ...interface{}) (context.Context, *Span) {
+	return start(ctx, name, KindClient, kv)
+}
+
+func start(ctx context.Context, name string, kind Kind, kv []interface{}) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := parent.tracer.newSpan(parent.data.TraceID, parent.data.SpanID, name, kind)
+	s.SetAttributes(kv...)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Context returns the span's context, to propagate the trace
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID, Sampled: true}
+}
+
+// SetName renames the span, for names only known once the work is done
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Name = name
+}
+
+// SetAttributes adds alternating keys and values to the span
+func (s *Span) SetAttributes(kv ...interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+		s.data.Attributes = append(s.data.Attributes, Attribute{Key: key, Value: kv[i+1]})
+	}
+}
+
+// End ends the span with the outcome of its work and queues it for export.
+// Only the first call counts.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	if err != nil {
+		s.data.Err = err.Error()
+	}
+	data := s.data
+	s.mu.Unlock()
+	s.tracer.enqueue(data)
+}
+
+// Exporter sends ended spans to a tracing backend
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// Options configures a Tracer
+type Options struct {
+	// SampleRatio is the share of new traces recorded, from 0 to 1.
+	// Traces continued from a traceparent header follow its sampled flag.
+	SampleRatio float64
+
+	// QueueSize is how many ended spans may wait for export; spans ended
+	// while the queue is full are dropped. Zero means 2048.
+	QueueSize int
+
+	// Logger receives export failures; slog.Default() is used when nil
+	Logger *slog.Logger
+}
+
+// Tracer starts traces and exports their spans
+type Tracer struct {
+	exporter Exporter
+	ratio    float64
+	logger   *slog.Logger
+	queue    chan SpanData
+	ready    chan struct{}
+	dropped  atomic.Int64
+}
+
+// NewTracer returns a tracer exporting spans to exporter. Spans are only
+// exported while Run runs, or by Flush.
+func NewTracer(exporter Exporter, opts Options) *Tracer {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return &Tracer{
+		exporter: exporter,
+		ratio:    opts.SampleRatio,
+		logger:   opts.Logger,
+		queue:    make(chan SpanData, opts.QueueSize),
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+// StartServer begins the root span of a request served by this process,
+// continuing the trace of parent when it is valid. Unsampled requests get
+// ctx back and a nil span, so none of their work is traced.
+func (t *Tracer) StartServer(ctx context.Context, name string, parent SpanContext) (context.Context, *Span) {
+	var traceID TraceID
+	var parentID SpanID
+	switch {
+	case parent.TraceID.IsValid():
+		if !parent.Sampled {
+			return ctx, nil
+		}
+		traceID, parentID = parent.TraceID, parent.SpanID
+	case t.ratio > 0 && mathrand.Float64() < t.ratio:
+		rand.Read(traceID[:])
+	default:
+		return ctx, nil
+	}
+	s := t.newSpan(traceID, parentID, name, KindServer)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (t *Tracer) newSpan(traceID TraceID, parentID SpanID, name string, kind Kind) *Span {
+	s := &Span{tracer: t, data: SpanData{TraceID: traceID, ParentID: parentID, Name: name, Kind: kind, Start: time.Now()}}
+	rand.Read(s.data.SpanID[:])
+	return s
+}
+
+// enqueue queues an ended span, waking Run once a batch is ready
+func (t *Tracer) enqueue(data SpanData) {
+	select {
+	case t.queue <- data:
+	default:
+		t.dropped.Add(1)
+		return
+	}
+	if len(t.queue) >= batchSize {
+		select {
+		case t.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run exports queued spans every few seconds, or as soon as a batch is
+// ready, until ctx is done. Export failures are logged; their spans are lost.
+func (t *Tracer) Run(ctx context.Context) {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-t.ready:
+		}
+		if err := t.Flush(ctx); err != nil && ctx.Err() == nil {
+			t.logger.WarnContext(ctx, "exporting spans failed", "error", err)
+		}
+		if n := t.dropped.Swap(0); n > 0 {
+			t.logger.WarnContext(ctx, "dropped spans: export queue full", "spans", n)
+		}
+	}
+}
+
+// Flush exports the spans queued so far
+func (t *Tracer) Flush(ctx context.Context) error {
+	for {
+		batch := make([]SpanData, 0, batchSize)
+	take:
+		for len(batch) < batchSize {
+			select {
+			case data := <-t.queue:
+				batch = append(batch, data)
+			default:
+				break take
+			}
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := t.exporter.Export(ctx, batch); err != nil {
+			return err
+		}
+	}
+}
+
+// Recorder is an Exporter keeping the spans it is sent in memory, for tests
+type Recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// Export implements Exporter
+func (r *Recorder) Export(ctx context.Context, spans []SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+// Spans returns the spans exported so far, in the order they ended
+func (r *Recorder) Spans() []SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]SpanData(nil), r.spans...)
+}
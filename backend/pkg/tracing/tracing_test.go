@@ -0,0 +1,153 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpans(t *testing.T) {
+	ctx := context.Background()
+	rec := &Recorder{}
+	tracer := NewTracer(rec, Options{SampleRatio: 1})
+
+	_, span := Start(ctx, "orphan")
+	assert.Nil(t, span, "spans need a trace")
+	span.SetAttributes("ignored", true)
+	span.End(nil)
+
+	ctx, root := tracer.StartServer(ctx, "GET /tables", SpanContext{})
+	require.NotNil(t, root)
+	assert.Same(t, root, FromContext(ctx))
+	childCtx, child := Start(ctx, "store.QueryByField", "namespace", "u1/notes")
+	_, call := StartClient(childCtx, "dynamodb.Query")
+	call.End(errors.New("throttled"))
+	child.End(nil)
+	child.End(errors.New("ignored"))
+	root.SetName("GET /tables/{table}")
+	root.End(nil)
+	require.NoError(t, tracer.Flush(ctx))
+
+	spans := rec.Spans()
+	require.Len(t, spans, 3)
+	assert.Equal(t, []string{"dynamodb.Query", "store.QueryByField", "GET /tables/{table}"}, []string{spans[0].Name, spans[1].Name, spans[2].Name})
+	for _, s := range spans {
+		assert.Equal(t, root.Context().TraceID, s.TraceID, "spans share the trace")
+	}
+	assert.Equal(t, spans[1].SpanID, spans[0].ParentID)
+	assert.Equal(t, spans[2].SpanID, spans[1].ParentID)
+	assert.False(t, spans[2].ParentID.IsValid())
+	assert.Equal(t, []Kind{KindClient, KindInternal, KindServer}, []Kind{spans[0].Kind, spans[1].Kind, spans[2].Kind})
+	assert.Equal(t, "throttled", spans[0].Err)
+	assert.Empty(t, spans[1].Err, "only the first End counts")
+	assert.Equal(t, "u1/notes", spans[1].Attribute("namespace"))
+	assert.False(t, spans[2].End.Before(spans[2].Start))
+}
+
+func TestSampling(t *testing.T) {
+	ctx := context.Background()
+	parent, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.True(t, ok)
+	assert.True(t, parent.Sampled)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", parent.Traceparent())
+
+	never := NewTracer(&Recorder{}, Options{})
+	_, span := never.StartServer(ctx, "GET /", SpanContext{})
+	assert.Nil(t, span)
+	_, span = never.StartServer(ctx, "GET /", parent)
+	require.NotNil(t, span, "sampled parents are followed")
+	assert.Equal(t, parent.TraceID, span.Context().TraceID)
+	assert.Equal(t, parent.SpanID, span.data.ParentID)
+
+	parent.Sampled = false
+	_, span = NewTracer(&Recorder{}, Options{SampleRatio: 1}).StartServer(ctx, "GET /", parent)
+	assert.Nil(t, span, "unsampled parents are followed")
+
+	for _, h := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		_, ok := ParseTraceparent(h)
+		assert.False(t, ok, h)
+	}
+	_, ok = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+	assert.True(t, ok, "later versions may add fields")
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	rec := &Recorder{}
+	tracer := NewTracer(rec, Options{SampleRatio: 1, QueueSize: 2})
+	for i := 0; i < 3; i++ {
+		_, span := tracer.StartServer(ctx, "GET /", SpanContext{})
+		span.End(nil)
+	}
+	require.NoError(t, tracer.Flush(ctx))
+	assert.Len(t, rec.Spans(), 2, "spans are dropped once the queue is full")
+	assert.Equal(t, int64(1), tracer.dropped.Load())
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body map[string]interface{}
+	var header http.Header
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		header = r.Header
+		data, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(data, &body))
+	}))
+	t.Cleanup(collector.Close)
+
+	headers := ParseHeaders("api-key=se%20cret, x-team = core,malformed")
+	assert.Equal(t, map[string]string{"api-key": "se cret", "x-team": "core"}, headers)
+
+	ctx := context.Background()
+	exporter := NewOTLPExporter(collector.URL+"/", headers, "notably")
+	tracer := NewTracer(exporter, Options{SampleRatio: 1})
+	ctx, root := tracer.StartServer(ctx, "GET /tables", SpanContext{})
+	_, child := StartClient(ctx, "dynamodb.PutItem", "capacity", 1.5, "items", 2, "table", "notably", "ok", true)
+	child.End(errors.New("throttled"))
+	root.End(nil)
+	require.NoError(t, tracer.Flush(ctx))
+
+	assert.Equal(t, "se cret", header.Get("api-key"))
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	resource := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"attributes": []interface{}{
+		map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "notably"}},
+	}}, resource["resource"])
+	spans := resource["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	require.Len(t, spans, 2)
+	span := spans[0].(map[string]interface{})
+	assert.Equal(t, "dynamodb.PutItem", span["name"])
+	assert.Equal(t, float64(KindClient), span["kind"])
+	assert.Equal(t, root.Context().TraceID.String(), span["traceId"])
+	assert.Equal(t, root.Context().SpanID.String(), span["parentSpanId"])
+	assert.Equal(t, map[string]interface{}{"code": float64(2), "message": "throttled"}, span["status"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"key": "capacity", "value": map[string]interface{}{"doubleValue": 1.5}},
+		map[string]interface{}{"key": "items", "value": map[string]interface{}{"intValue": "2"}},
+		map[string]interface{}{"key": "table", "value": map[string]interface{}{"stringValue": "notably"}},
+		map[string]interface{}{"key": "ok", "value": map[string]interface{}{"boolValue": true}},
+	}, span["attributes"])
+	assert.NotContains(t, spans[1].(map[string]interface{}), "parentSpanId")
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+	}))
+	t.Cleanup(failing.Close)
+	err := NewOTLPExporter(failing.URL, nil, "notably").Export(ctx, []SpanData{{Name: "GET /"}})
+	assert.EqualError(t, err, "export spans: collector returned 429 Too Many Requests: quota exceeded")
+}
@@ -0,0 +1,182 @@
+// Package ulid generates ULIDs: 128-bit identifiers made of a millisecond
+// timestamp and 80 random bits, written as 26 Crockford base32 characters.
+// ULIDs sort by creation time, and those made by one Generator within the
+// same millisecond are still strictly increasing.
+package ulid
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// encoding is Crockford's base32 alphabet, which leaves out I, L, O and U
+const encoding = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// maxTime is the largest millisecond timestamp a ULID can hold
+const maxTime = 1<<48 - 1
+
+var (
+	ErrInvalidLength    = errors.New("ulid: must be 26 characters")
+	ErrInvalidCharacter = errors.New("ulid: invalid character")
+	ErrOverflow         = errors.New("ulid: value overflows 128 bits")
+)
+
+// ULID is a parsed identifier
+type ULID [16]byte
+
+// decoding maps characters to their base32 values, or 0xFF when invalid.
+// Lowercase letters and the ambiguous I, L and O are accepted as Crockford
+// allows.
+var decoding = func() [256]byte {
+	var d [256]byte
+	for i := range d {
+		d[i] = 0xFF
+	}
+	for i := 0; i < len(encoding); i++ {
+		c := encoding[i]
+		d[c] = byte(i)
+		if c >= 'A' && c <= 'Z' {
+			d[c+'a'-'A'] = byte(i)
+		}
+	}
+	for _, alias := range []struct {
+		c byte
+		v byte
+	}{{'I', 1}, {'i', 1}, {'L', 1}, {'l', 1}, {'O', 0}, {'o', 0}} {
+		d[alias.c] = alias.v
+	}
+	return d
+}()
+
+// String returns the 26 character form of the ULID
+func (u ULID) String() string {
+	var out [26]byte
+	// 128 bits are written as 130, so the first character holds 3 bits
+	var acc uint64
+	bits := 2
+	n := 0
+	for _, b := range u {
+		acc = acc<<8 | uint64(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[n] = encoding[(acc>>uint(bits))&31]
+			n++
+		}
+	}
+	return string(out[:])
+}
+
+// Time returns the ULID's timestamp
+func (u ULID) Time() time.Time {
+	ms := uint64(u[0])<<40 | uint64(u[1])<<32 | uint64(u[2])<<24 | uint64(u[3])<<16 | uint64(u[4])<<8 | uint64(u[5])
+	return time.UnixMilli(int64(ms)).UTC()
+}
+
+// Parse decodes the 26 character form of a ULID
+func Parse(s string) (ULID, error) {
+	var u ULID
+	if len(s) != 26 {
+		return u, ErrInvalidLength
+	}
+	if decoding[s[0]] > 7 {
+		if decoding[s[0]] == 0xFF {
+			return u, ErrInvalidCharacter
+		}
+		return u, ErrOverflow
+	}
+	var acc uint64
+	bits := -2
+	n := 0
+	for i := 0; i < len(s); i++ {
+		v := decoding[s[i]]
+		if v == 0xFF {
+			return ULID{}, ErrInvalidCharacter
+		}
+		acc = acc<<5 | uint64(v)
+		bits += 5
+		if bits >= 8 {
+			bits -= 8
+			u[n] = byte(acc >> uint(bits))
+			n++
+		}
+	}
+	return u, nil
+}
+
+// Generator makes monotonic ULIDs. It is safe for concurrent use.
+type Generator struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	entropy io.Reader
+	last    ULID
+}
+
+// NewGenerator returns a Generator reading random bits from entropy, or
+// crypto/rand when nil
+func NewGenerator(entropy io.Reader) *Generator {
+	if entropy == nil {
+		entropy = rand.Reader
+	}
+	return &Generator{now: time.Now, entropy: entropy}
+}
+
+// New returns a ULID greater than every one the generator made before.
+// Within a millisecond the random bits of the previous ULID are incremented;
+// should they overflow, the timestamp is moved one millisecond on.
+func (g *Generator) New() (ULID, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(g.now().UnixMilli())
+	if ms > maxTime {
+		return ULID{}, ErrOverflow
+	}
+	var u ULID
+	if last := uint64(g.last.Time().UnixMilli()); g.last != (ULID{}) && ms <= last {
+		u = g.last
+		if !increment(u[6:]) {
+			g.last = u
+			return u, nil
+		}
+		ms = last + 1
+		if ms > maxTime {
+			return ULID{}, ErrOverflow
+		}
+	}
+	for i := 5; i >= 0; i-- {
+		u[i] = byte(ms)
+		ms >>= 8
+	}
+	if _, err := io.ReadFull(g.entropy, u[6:]); err != nil {
+		return ULID{}, err
+	}
+	g.last = u
+	return u, nil
+}
+
+// increment adds one to a big-endian number, reporting whether it overflowed
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+var defaultGenerator = NewGenerator(nil)
+
+// Make returns a new ULID from a shared generator. It panics only if the
+// system's random source fails.
+func Make() ULID {
+	u, err := defaultGenerator.New()
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
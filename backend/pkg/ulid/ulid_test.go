@@ -0,0 +1,98 @@
+package ulid
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStringAndParse(t *testing.T) {
+	// Example from the ULID spec's timestamp encoding
+	u, err := Parse("01ARZ3NDEKTSV4RRFFQ69G5FAV")
+	require.NoError(t, err)
+	assert.Equal(t, "01ARZ3NDEKTSV4RRFFQ69G5FAV", u.String())
+	assert.Equal(t, int64(1469922850259), u.Time().UnixMilli())
+
+	lower, err := Parse("01arz3ndektsv4rrffq69g5fav")
+	require.NoError(t, err)
+	assert.Equal(t, u, lower)
+
+	var max ULID
+	for i := range max {
+		max[i] = 0xFF
+	}
+	assert.Equal(t, "7ZZZZZZZZZZZZZZZZZZZZZZZZZ", max.String())
+	assert.Equal(t, strings.Repeat("0", 26), ULID{}.String())
+
+	_, err = Parse("8ZZZZZZZZZZZZZZZZZZZZZZZZZ")
+	assert.ErrorIs(t, err, ErrOverflow)
+	_, err = Parse("01ARZ3NDEKTSV4RRFFQ69G5FA")
+	assert.ErrorIs(t, err, ErrInvalidLength)
+	_, err = Parse("01ARZ3NDEKTSV4RRFFQ69G5FAU")
+	assert.ErrorIs(t, err, ErrInvalidCharacter)
+}
+
+func TestGeneratorMonotonic(t *testing.T) {
+	at := time.UnixMilli(1700000000000)
+	entropy := append(bytes.Repeat([]byte{0xFF}, 10), make([]byte, 20)...)
+	g := NewGenerator(bytes.NewReader(entropy))
+	g.now = func() time.Time { return at }
+
+	first, err := g.New()
+	require.NoError(t, err)
+	assert.Equal(t, at.UTC(), first.Time())
+
+	// The random bits are all ones, so the next ULID of the same
+	// millisecond overflows into the next millisecond
+	second, err := g.New()
+	require.NoError(t, err)
+	assert.Equal(t, at.Add(time.Millisecond).UTC(), second.Time())
+	assert.Less(t, first.String(), second.String())
+
+	third, err := g.New()
+	require.NoError(t, err)
+	assert.Less(t, second.String(), third.String())
+	assert.Equal(t, second.Time(), third.Time())
+
+	// A clock going backwards does not break the order
+	at = at.Add(-time.Hour)
+	fourth, err := g.New()
+	require.NoError(t, err)
+	assert.Less(t, third.String(), fourth.String())
+}
+
+func TestMakeConcurrent(t *testing.T) {
+	const workers, each = 8, 500
+	ids := make(chan string, workers*each)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range each {
+				ids <- Make().String()
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[string]bool)
+	var all []string
+	for id := range ids {
+		require.False(t, seen[id], "duplicate ULID %s", id)
+		seen[id] = true
+		all = append(all, id)
+	}
+	assert.Len(t, all, workers*each)
+
+	// Later ULIDs sort after earlier ones
+	sort.Strings(all)
+	assert.Less(t, all[len(all)-1], Make().String())
+}
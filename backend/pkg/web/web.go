@@ -0,0 +1,101 @@
+// Package web serves the built frontend, a single-page app, so one binary
+// can run both the API and the web app.
+//
+// The frontend is compiled into the binary only when it is built with
+// -tags embedfrontend, after npm run build:embed in frontend/ has written
+// the app to pkg/web/dist; Embedded returns nil otherwise. Any directory
+// of built files can be served instead with os.DirFS.
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Index is the page served for the app's own routes
+const Index = "index.html"
+
+// Cache-Control values. Vite names the files under assets/ by a hash of
+// their content, so they can be cached for good; other files, above all
+// the index page, are revalidated so a deploy is seen on the next load.
+const (
+	immutable   = "public, max-age=31536000, immutable"
+	revalidated = "no-cache"
+)
+
+// Handler serves the files of a built frontend, and the index page for
+// paths naming no file, where the app routes in the browser
+type Handler struct {
+	files fs.FS
+}
+
+// New returns a handler serving files
+func New(files fs.FS) *Handler {
+	return &Handler{files: files}
+}
+
+// Has reports whether the URL path names a file of the frontend
+func (h *Handler) Has(urlPath string) bool {
+	name, ok := fileName(urlPath)
+	if !ok {
+		return false
+	}
+	info, err := fs.Stat(h.files, name)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// ServeHTTP serves the file the request names. Paths without an extension
+// get the index page; missing files with one, such as an asset of an
+// older deploy, are not found rather than answered with HTML.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	name, ok := fileName(r.URL.Path)
+	if !ok || !h.Has(r.URL.Path) {
+		if ok && path.Ext(name) != "" {
+			http.NotFound(w, r)
+			return
+		}
+		name = Index
+	}
+	data, err := fs.ReadFile(h.files, name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var modTime time.Time
+	if info, err := fs.Stat(h.files, name); err == nil {
+		modTime = info.ModTime()
+	}
+
+	cache := revalidated
+	if strings.HasPrefix(name, "assets/") {
+		cache = immutable
+	}
+	sum := sha256.Sum256(data)
+	w.Header().Set("Cache-Control", cache)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// ServeContent picks the content type from the name and answers
+	// If-None-Match with 304
+	http.ServeContent(w, r, name, modTime, bytes.NewReader(data))
+}
+
+// fileName returns the name in the file system of a URL path, or false for
+// the root, which is the index page
+func fileName(urlPath string) (string, bool) {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" || !fs.ValidPath(name) {
+		return "", false
+	}
+	return name, true
+}
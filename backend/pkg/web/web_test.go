@@ -0,0 +1,62 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	h := New(fstest.MapFS{
+		"index.html":         {Data: []byte("<!doctype html><div id=root></div>")},
+		"favicon.svg":        {Data: []byte("<svg/>")},
+		"assets/app-1a2b.js": {Data: []byte("console.log(1)")},
+	})
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := get("/assets/app-1a2b.js")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "console.log(1)", rec.Body.String())
+	assert.Equal(t, "public, max-age=31536000, immutable", rec.Header().Get("Cache-Control"))
+	assert.Contains(t, rec.Header().Get("Content-Type"), "javascript")
+
+	rec = get("/favicon.svg")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "no-cache", rec.Header().Get("Cache-Control"))
+
+	// The app's routes get the index page
+	for _, path := range []string{"/", "/index.html", "/tables/notes", "/login", "/assets"} {
+		rec = get(path)
+		require.Equal(t, http.StatusOK, rec.Code, path)
+		assert.Contains(t, rec.Body.String(), "<div id=root>", path)
+		assert.Equal(t, "no-cache", rec.Header().Get("Cache-Control"), path)
+		assert.Contains(t, rec.Header().Get("Content-Type"), "text/html", path)
+	}
+
+	// Missing files are not answered with the index page
+	assert.Equal(t, http.StatusNotFound, get("/assets/app-0000.js").Code)
+
+	// The index page is revalidated with its ETag
+	req := httptest.NewRequest(http.MethodGet, "/tables", nil)
+	req.Header.Set("If-None-Match", get("/").Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	assert.True(t, h.Has("/assets/app-1a2b.js"))
+	assert.False(t, h.Has("/"))
+	assert.False(t, h.Has("/assets"))
+	assert.False(t, h.Has("/../index.html/x"))
+}
@@ -0,0 +1,172 @@
+// Package webhook signs and delivers webhook requests.
+//
+// Each request carries a signature header of the form
+//
+//	X-Notably-Signature: t=1714650000,v1=5257a869...
+//
+// where v1 is the hex HMAC-SHA256, keyed with the webhook's secret, of the
+// timestamp, a period and the request body. Receivers recompute it with
+// Verify and should reject stale timestamps to stop replays.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elibdev/notably/pkg/backoff"
+)
+
+const (
+	// SignatureHeader carries the request's timestamp and signature
+	SignatureHeader = "X-Notably-Signature"
+	// EventHeader names the event type delivered
+	EventHeader = "X-Notably-Event"
+	// DeliveryHeader identifies a delivery; retries of it reuse the ID
+	DeliveryHeader = "X-Notably-Delivery"
+
+	// requestTimeout bounds a single delivery attempt
+	requestTimeout = 10 * time.Second
+)
+
+// DefaultPolicy retries failed deliveries for up to about two minutes
+var DefaultPolicy = backoff.Policy{
+	MaxAttempts: 6,
+	MaxElapsed:  2 * time.Minute,
+	BaseDelay:   time.Second,
+	MaxDelay:    30 * time.Second,
+}
+
+// ErrInvalidSignature is returned by Verify for requests that were not signed
+// with the secret, or whose timestamp is outside the tolerance
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// StatusError reports a delivery the receiver answered with a non-2xx status
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("receiver responded with status %d", e.StatusCode)
+}
+
+// Sign returns the signature header value for body sent at t
+func Sign(secret []byte, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + mac(secret, ts, body)
+}
+
+// Verify checks a signature header against body, rejecting timestamps more
+// than tolerance away from now
+func Verify(secret []byte, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return ErrInvalidSignature
+	}
+	if d := now.Sub(time.Unix(unix, 0)); d > tolerance || d < -tolerance {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(mac(secret, ts, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func mac(secret []byte, ts string, body []byte) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(ts))
+	h.Write([]byte("."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Sender POSTs signed JSON payloads, retrying failed deliveries
+type Sender struct {
+	// Client sends the requests; http.DefaultClient when nil
+	Client *http.Client
+	// Policy controls retries; zero fields use DefaultPolicy
+	Policy backoff.Policy
+	// OnRetry, if set, is called before each retry
+	OnRetry func(attempt int, err error)
+}
+
+// Send delivers body to url and returns the number of attempts made. Network
+// errors, 429 and 5xx responses are retried; other responses fail at once.
+func (s *Sender) Send(ctx context.Context, url string, secret []byte, event, deliveryID string, body []byte) (int, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	policy := s.Policy
+	if policy == (backoff.Policy{}) {
+		policy = DefaultPolicy
+	}
+
+	attempts := 0
+	err := policy.Retry(ctx, retryable, func(attempt int, _ time.Duration, err error) {
+		if s.OnRetry != nil {
+			s.OnRetry(attempt, err)
+		}
+	}, func() error {
+		attempts++
+		return send(ctx, client, url, secret, event, deliveryID, body)
+	})
+	return attempts, err
+}
+
+// send makes one delivery attempt
+func send(ctx context.Context, client *http.Client, url string, secret []byte, event, deliveryID string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "notably-webhooks")
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(DeliveryHeader, deliveryID)
+	req.Header.Set(SignatureHeader, Sign(secret, time.Now(), body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// retryable reports whether a failed delivery may succeed if repeated
+func retryable(err error) bool {
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.StatusCode == http.StatusTooManyRequests || status.StatusCode >= 500
+	}
+	return true
+}
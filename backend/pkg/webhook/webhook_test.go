@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elibdev/notably/pkg/backoff"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fastPolicy = backoff.Policy{MaxAttempts: 3, MaxElapsed: time.Second, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+func TestSignAndVerify(t *testing.T) {
+	secret := []byte("whsec_test")
+	body := []byte(`{"type":"create"}`)
+	now := time.Unix(1714650000, 0)
+
+	header := Sign(secret, now, body)
+	assert.Regexp(t, `^t=1714650000,v1=[0-9a-f]{64}$`, header)
+	assert.NoError(t, Verify(secret, header, body, 5*time.Minute, now.Add(time.Minute)))
+
+	assert.ErrorIs(t, Verify([]byte("other"), header, body, 5*time.Minute, now), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify(secret, header, []byte(`{"type":"delete"}`), 5*time.Minute, now), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify(secret, header, body, 5*time.Minute, now.Add(time.Hour)), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify(secret, "v1=abc", body, 5*time.Minute, now), ErrInvalidSignature)
+}
+
+func TestSendSignsAndRetries(t *testing.T) {
+	secret := []byte("whsec_test")
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.NoError(t, Verify(secret, r.Header.Get(SignatureHeader), body, time.Minute, time.Now()))
+		assert.Equal(t, "create", r.Header.Get(EventHeader))
+		assert.Equal(t, "d1", r.Header.Get(DeliveryHeader))
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	var retries []int
+	sender := &Sender{Policy: fastPolicy, OnRetry: func(attempt int, err error) { retries = append(retries, attempt) }}
+	attempts, err := sender.Send(context.Background(), receiver.URL, secret, "create", "d1", []byte(`{}`))
+	require.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, []int{1, 2}, retries)
+}
+
+func TestSendStopsOnClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer receiver.Close()
+
+	attempts, err := (&Sender{Policy: fastPolicy}).Send(context.Background(), receiver.URL, []byte("s"), "delete", "d2", []byte(`{}`))
+	var status *StatusError
+	require.ErrorAs(t, err, &status)
+	assert.Equal(t, http.StatusGone, status.StatusCode)
+	assert.Equal(t, 1, attempts)
+	assert.Equal(t, int32(1), calls.Load())
+}
@@ -0,0 +1,349 @@
+// Package schema defines the DynamoDB tables facts are stored in: their
+// attribute names, key schemas and indexes, and the encoding of their keys.
+// The server's dynamo.Client, db.DynamoDBStore and cmd/create-table all
+// build their tables from these definitions, so a table created by one is
+// recognized by the others.
+//
+// Every fact item carries the UserID, SK (timestamp#id) and FieldKey
+// (userID#namespace#field) attributes. IDs may not contain '#' and the
+// components of the other keys are escaped, so every key splits back into
+// its components. The schemas differ in how items are
+// partitioned and which indexes they add:
+//
+//   - Namespace partitions items by PK (userID#namespace) and adds UserIndex
+//     for queries across a user's namespaces, and ActorIndex over the
+//     ActorKey (userID#actorUserID) of facts that record who wrote them.
+//     The server creates it.
+//   - User partitions items by UserID, as server tables created before the
+//     namespace schema do.
+//   - Store is User with the NamespaceKey attribute (userID#namespace) and
+//     the NamespaceIndex over it, as db.DynamoDBStore creates it.
+//
+// All three have the FieldIndex GSI over FieldKey and SK. Besides facts,
+// a table may hold one marker item, keyed by MarkerPartition and
+// MarkerSortKey, recording the migrations applied to it; see pkg/migrate;
+// and lease items in LeasePartition, keyed by lease name; see pkg/lease.
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Attribute names of fact items
+const (
+	PartitionKey = "PK"
+	UserID       = "UserID"
+	SortKey      = "SK"
+	FieldKey     = "FieldKey"
+	NamespaceKey = "NamespaceKey"
+	ActorKey     = "ActorKey"
+)
+
+// Index names
+const (
+	FieldIndex     = "FieldIndex"
+	UserIndex      = "UserIndex"
+	NamespaceIndex = "NamespaceIndex"
+	ActorIndex     = "ActorIndex"
+)
+
+// Key values of the marker item recording a table's migration version. No
+// fact has them: user IDs are never empty and sort keys start with a time.
+const (
+	MarkerPartition = "#schema"
+	MarkerSortKey   = "version"
+)
+
+// LeasePartition holds the lease items coordinating server replicas. Like
+// the marker's, no fact has this partition key.
+const LeasePartition = "#lease"
+
+// Schema is the key schema and set of indexes of a facts table
+type Schema int
+
+const (
+	Namespace Schema = iota
+	User
+	Store
+)
+
+func (s Schema) String() string {
+	switch s {
+	case User:
+		return "user"
+	case Store:
+		return "store"
+	}
+	return "namespace"
+}
+
+// HashKey returns the partition key attribute of the schema's tables
+func (s Schema) HashKey() string {
+	if s == Namespace {
+		return PartitionKey
+	}
+	return UserID
+}
+
+// MarkerKey returns the primary key of the marker item in a table of the
+// schema
+func (s Schema) MarkerKey() map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		s.HashKey(): &types.AttributeValueMemberS{Value: MarkerPartition},
+		SortKey:     &types.AttributeValueMemberS{Value: MarkerSortKey},
+	}
+}
+
+// LeaseKey returns the primary key of the item holding a lease in a table
+// of the schema
+func (s Schema) LeaseKey(name string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		s.HashKey(): &types.AttributeValueMemberS{Value: LeasePartition},
+		SortKey:     &types.AttributeValueMemberS{Value: name},
+	}
+}
+
+// Detect returns the schema of an existing table. Store tables created
+// before the NamespaceIndex have the keys of the user schema and are
+// reported as such, as are user tables.
+func Detect(desc *types.TableDescription) (Schema, error) {
+	var hash string
+	for _, k := range desc.KeySchema {
+		if k.KeyType == types.KeyTypeHash {
+			hash = aws.ToString(k.AttributeName)
+		}
+	}
+	switch hash {
+	case PartitionKey:
+		return Namespace, nil
+	case UserID:
+		for _, gsi := range desc.GlobalSecondaryIndexes {
+			if aws.ToString(gsi.IndexName) == NamespaceIndex {
+				return Store, nil
+			}
+		}
+		return User, nil
+	}
+	return 0, fmt.Errorf("table %s is keyed by %q, not by %s or %s", aws.ToString(desc.TableName), hash, PartitionKey, UserID)
+}
+
+// Definition returns the CreateTable input of a facts table of a schema,
+// billed on demand
+func Definition(name string, s Schema) *dynamodb.CreateTableInput {
+	in := &dynamodb.CreateTableInput{
+		TableName:   aws.String(name),
+		BillingMode: types.BillingModePayPerRequest,
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			index(FieldIndex, FieldKey),
+		},
+	}
+	attrs := []string{UserID, SortKey, FieldKey}
+	switch s {
+	case Namespace:
+		attrs = []string{PartitionKey, SortKey, FieldKey, UserID, ActorKey}
+		in.GlobalSecondaryIndexes = append(in.GlobalSecondaryIndexes, index(UserIndex, UserID), index(ActorIndex, ActorKey))
+	case Store:
+		attrs = append(attrs, NamespaceKey)
+		in.GlobalSecondaryIndexes = append(in.GlobalSecondaryIndexes, index(NamespaceIndex, NamespaceKey))
+	}
+	for _, a := range attrs {
+		in.AttributeDefinitions = append(in.AttributeDefinitions, types.AttributeDefinition{AttributeName: aws.String(a), AttributeType: types.ScalarAttributeTypeS})
+	}
+	in.KeySchema = keys(s.HashKey())
+	return in
+}
+
+// index returns a GSI keyed by hash and the sort key, projecting every attribute
+func index(name, hash string) types.GlobalSecondaryIndex {
+	return types.GlobalSecondaryIndex{
+		IndexName:  aws.String(name),
+		KeySchema:  keys(hash),
+		Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+	}
+}
+
+// keys returns a key schema of hash and the sort key
+func keys(hash string) []types.KeySchemaElement {
+	return []types.KeySchemaElement{
+		{AttributeName: aws.String(hash), KeyType: types.KeyTypeHash},
+		{AttributeName: aws.String(SortKey), KeyType: types.KeyTypeRange},
+	}
+}
+
+// SortKeyValue returns the SK of a fact: its timestamp, then its ID. The ID
+// must pass ValidateID, so the first '#' always ends the timestamp.
+func SortKeyValue(timestamp time.Time, id string) string {
+	return fmt.Sprintf("%s#%s", timestamp.Format(time.RFC3339Nano), id)
+}
+
+// ParseSortKey returns the timestamp and ID of a fact's SK. Items written
+// before IDs were validated may have '#' in their IDs; everything after the
+// first '#' is the ID.
+func ParseSortKey(sk string) (time.Time, string, error) {
+	ts, id, ok := strings.Cut(sk, "#")
+	if !ok {
+		return time.Time{}, "", fmt.Errorf("sort key %q has no '#' between timestamp and ID", sk)
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("sort key %q has an invalid timestamp: %w", sk, err)
+	}
+	if id == "" {
+		return time.Time{}, "", fmt.Errorf("sort key %q has no ID", sk)
+	}
+	return t, id, nil
+}
+
+// ValidateID reports why a fact ID cannot be written, or nil if it can. IDs
+// are the last component of the SK, so they must not contain its '#'
+// separator, and DynamoDB strings must be UTF-8.
+func ValidateID(id string) error {
+	switch {
+	case id == "":
+		return errors.New("fact ID cannot be empty")
+	case strings.Contains(id, "#"):
+		return fmt.Errorf("fact ID %q cannot contain '#'", id)
+	case !utf8.ValidString(id):
+		return fmt.Errorf("fact ID %q is not valid UTF-8", id)
+	}
+	return nil
+}
+
+// MaxItemSize is the largest item DynamoDB stores, in bytes. It bounds the
+// size of a fact's value, which shares the item with the fact's keys.
+const MaxItemSize = 400 * 1024
+
+// ItemSizeError reports an item over MaxItemSize. Sizes are in bytes as
+// DynamoDB counts them, which for values is close to their length as JSON.
+type ItemSizeError struct {
+	Size  int
+	Limit int
+	// MaxValueSize is the largest value the item could have had, given
+	// the size of its other attributes
+	MaxValueSize int
+}
+
+func (e *ItemSizeError) Error() string {
+	return fmt.Sprintf("item is %d bytes, over the %d byte limit of DynamoDB items; its value can be at most %d bytes", e.Size, e.Limit, e.MaxValueSize)
+}
+
+// ValidateItemSize returns an *ItemSizeError for items DynamoDB would refuse
+// for their size, so that writes fail before the request is sent
+func ValidateItemSize(item map[string]types.AttributeValue) error {
+	size := ItemSize(item)
+	if size <= MaxItemSize {
+		return nil
+	}
+	rest := size
+	if value, ok := item["Value"]; ok {
+		rest -= attributeSize(value)
+	}
+	return &ItemSizeError{Size: size, Limit: MaxItemSize, MaxValueSize: max(MaxItemSize-rest, 0)}
+}
+
+// ItemSize returns the size of an item as DynamoDB counts it against
+// MaxItemSize: the lengths of its attribute names and of their values
+func ItemSize(item map[string]types.AttributeValue) int {
+	size := 0
+	for name, av := range item {
+		size += len(name) + attributeSize(av)
+	}
+	return size
+}
+
+// attributeSize returns the size of an attribute value. Numbers take a byte
+// per two significant digits and one more; lists and maps take three bytes
+// and one per element besides their elements.
+func attributeSize(av types.AttributeValue) int {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return len(v.Value)
+	case *types.AttributeValueMemberN:
+		return numberSize(v.Value)
+	case *types.AttributeValueMemberB:
+		return len(v.Value)
+	case *types.AttributeValueMemberSS:
+		size := 0
+		for _, s := range v.Value {
+			size += len(s)
+		}
+		return size
+	case *types.AttributeValueMemberNS:
+		size := 0
+		for _, n := range v.Value {
+			size += numberSize(n)
+		}
+		return size
+	case *types.AttributeValueMemberBS:
+		size := 0
+		for _, b := range v.Value {
+			size += len(b)
+		}
+		return size
+	case *types.AttributeValueMemberL:
+		size := 3
+		for _, e := range v.Value {
+			size += 1 + attributeSize(e)
+		}
+		return size
+	case *types.AttributeValueMemberM:
+		size := 3
+		for k, e := range v.Value {
+			size += 1 + len(k) + attributeSize(e)
+		}
+		return size
+	default:
+		// BOOL and NULL
+		return 1
+	}
+}
+
+func numberSize(n string) int {
+	digits := strings.TrimLeft(strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, strings.SplitN(strings.ToLower(n), "e", 2)[0]), "0")
+	digits = strings.TrimRight(digits, "0")
+	return (len(digits)+1)/2 + 1
+}
+
+// FieldKeyValue returns the FieldKey of a user's field in a namespace. Its
+// components are escaped, so '#' only separates them.
+func FieldKeyValue(userID, namespace, field string) string {
+	return escapeKeyPart(userID) + "#" + escapeKeyPart(namespace) + "#" + escapeKeyPart(field)
+}
+
+// NamespaceValue returns the PK of a user's namespace in the namespace
+// schema, also its NamespaceKey in the store schema. Like FieldKeyValue it
+// escapes its components.
+func NamespaceValue(userID, namespace string) string {
+	return escapeKeyPart(userID) + "#" + escapeKeyPart(namespace)
+}
+
+// ActorValue returns the ActorKey of the facts an actor wrote to a user's
+// tables. Like FieldKeyValue it escapes its components.
+func ActorValue(userID, actorUserID string) string {
+	return escapeKeyPart(userID) + "#" + escapeKeyPart(actorUserID)
+}
+
+// keyPartEscaper escapes the components of composite keys: '#' separates
+// them and '%' starts an escape. Components with neither, such as user IDs
+// and the namespaces of tables, are unchanged.
+var keyPartEscaper = strings.NewReplacer("%", "%25", "#", "%23")
+
+func escapeKeyPart(s string) string {
+	if !strings.ContainsAny(s, "%#") {
+		return s
+	}
+	return keyPartEscaper.Replace(s)
+}
@@ -0,0 +1,179 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// indexNames returns the names of a definition's indexes
+func indexNames(gsis []types.GlobalSecondaryIndex) []string {
+	names := make([]string, len(gsis))
+	for i, gsi := range gsis {
+		names[i] = aws.ToString(gsi.IndexName)
+	}
+	return names
+}
+
+func TestDefinitionsAndDetect(t *testing.T) {
+	for _, tc := range []struct {
+		schema  Schema
+		hash    string
+		indexes []string
+	}{
+		{Namespace, PartitionKey, []string{FieldIndex, UserIndex, ActorIndex}},
+		{User, UserID, []string{FieldIndex}},
+		{Store, UserID, []string{FieldIndex, NamespaceIndex}},
+	} {
+		in := Definition("Facts", tc.schema)
+		assert.Equal(t, tc.hash, aws.ToString(in.KeySchema[0].AttributeName), tc.schema)
+		assert.Equal(t, SortKey, aws.ToString(in.KeySchema[1].AttributeName), tc.schema)
+		assert.Equal(t, tc.indexes, indexNames(in.GlobalSecondaryIndexes), tc.schema)
+
+		desc := &types.TableDescription{TableName: in.TableName, KeySchema: in.KeySchema}
+		for _, gsi := range in.GlobalSecondaryIndexes {
+			desc.GlobalSecondaryIndexes = append(desc.GlobalSecondaryIndexes, types.GlobalSecondaryIndexDescription{IndexName: gsi.IndexName})
+		}
+		got, err := Detect(desc)
+		require.NoError(t, err)
+		assert.Equal(t, tc.schema, got)
+	}
+
+	// Tables with other keys, such as those of early create-table builds, are not facts tables
+	_, err := Detect(&types.TableDescription{TableName: aws.String("Old"), KeySchema: []types.KeySchemaElement{
+		{AttributeName: aws.String("Namespace"), KeyType: types.KeyTypeHash},
+		{AttributeName: aws.String("Timestamp"), KeyType: types.KeyTypeRange},
+	}})
+	assert.EqualError(t, err, `table Old is keyed by "Namespace", not by PK or UserID`)
+}
+
+func TestMarkerKey(t *testing.T) {
+	assert.Equal(t, map[string]types.AttributeValue{
+		PartitionKey: &types.AttributeValueMemberS{Value: MarkerPartition},
+		SortKey:      &types.AttributeValueMemberS{Value: MarkerSortKey},
+	}, Namespace.MarkerKey())
+	assert.Contains(t, Store.MarkerKey(), UserID)
+	assert.Equal(t, map[string]types.AttributeValue{
+		UserID:  &types.AttributeValueMemberS{Value: LeasePartition},
+		SortKey: &types.AttributeValueMemberS{Value: "retention"},
+	}, User.LeaseKey("retention"))
+}
+
+func TestKeyValues(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 600, time.UTC)
+	assert.Equal(t, "2024-01-02T03:04:05.0000006Z#f1", SortKeyValue(ts, "f1"))
+	assert.Equal(t, "u1#orders#status", FieldKeyValue("u1", "orders", "status"))
+	assert.Equal(t, "u1#orders", NamespaceValue("u1", "orders"))
+
+	// Separators and escapes in components are escaped
+	assert.Equal(t, "u1#u1/orders#a%23b%25", FieldKeyValue("u1", "u1/orders", "a#b%"))
+	assert.NotEqual(t, FieldKeyValue("u1", "a#b", "c"), FieldKeyValue("u1", "a", "b#c"))
+	assert.Equal(t, "u1#a%23b", NamespaceValue("u1", "a#b"))
+
+	at, id, err := ParseSortKey("2024-01-02T03:04:05.0000006Z#f1")
+	require.NoError(t, err)
+	assert.True(t, ts.Equal(at))
+	assert.Equal(t, "f1", id)
+	_, id, err = ParseSortKey("2024-01-02T03:04:05Z#legacy#id")
+	require.NoError(t, err)
+	assert.Equal(t, "legacy#id", id, "IDs written before validation still read")
+	for _, sk := range []string{"", "2024-01-02T03:04:05Z", "2024-01-02T03:04:05Z#", "#f1", "yesterday#f1"} {
+		_, _, err := ParseSortKey(sk)
+		assert.Error(t, err, sk)
+	}
+
+	assert.NoError(t, ValidateID("01HZX3"))
+	assert.ErrorContains(t, ValidateID(""), "empty")
+	assert.ErrorContains(t, ValidateID("a#b"), "'#'")
+	assert.ErrorContains(t, ValidateID("\xff"), "UTF-8")
+}
+
+func TestItemSize(t *testing.T) {
+	item := map[string]types.AttributeValue{
+		"S":    &types.AttributeValueMemberS{Value: "hello"},
+		"N":    &types.AttributeValueMemberN{Value: "-123.4500"},
+		"B":    &types.AttributeValueMemberBOOL{Value: true},
+		"Null": &types.AttributeValueMemberNULL{Value: true},
+		"L": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+			&types.AttributeValueMemberS{Value: "ab"},
+		}},
+		"M": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			"k": &types.AttributeValueMemberS{Value: "abc"},
+		}},
+	}
+	// Names, then values: 5, 1+(5+1)/2 for 12345, 1, 1, 3+1+2, 3+1+1+3
+	assert.Equal(t, 1+5+1+4+1+1+4+1+1+6+1+8, ItemSize(item))
+	assert.NoError(t, ValidateItemSize(item))
+
+	item = map[string]types.AttributeValue{
+		"Key":   &types.AttributeValueMemberS{Value: strings.Repeat("k", 100)},
+		"Value": &types.AttributeValueMemberS{Value: strings.Repeat("v", MaxItemSize)},
+	}
+	err := ValidateItemSize(item)
+	var sizeErr *ItemSizeError
+	require.ErrorAs(t, err, &sizeErr)
+	assert.Equal(t, 3+100+5+MaxItemSize, sizeErr.Size)
+	assert.Equal(t, MaxItemSize, sizeErr.Limit)
+	assert.Equal(t, MaxItemSize-3-100-5, sizeErr.MaxValueSize, "the value can take what the other attributes leave")
+	assert.ErrorContains(t, err, "409600 byte limit")
+}
+
+// splitKey splits a composite key into its unescaped components
+func splitKey(key string) []string {
+	parts := strings.Split(key, "#")
+	for i, p := range parts {
+		parts[i] = strings.NewReplacer("%23", "#", "%25", "%").Replace(p)
+	}
+	return parts
+}
+
+func FuzzSortKey(f *testing.F) {
+	f.Add(int64(0), 0, "f1")
+	f.Add(time.Date(2024, 1, 2, 3, 4, 5, 600, time.UTC).UnixNano(), 0, "01HZX3QK5M")
+	f.Add(int64(1)<<62, -330, "é")
+	f.Fuzz(func(t *testing.T, nanos int64, offsetMinutes int, id string) {
+		if ValidateID(id) != nil {
+			t.Skip()
+		}
+		zone := time.FixedZone("", (offsetMinutes%(24*60))*60)
+		ts := time.Unix(0, nanos).In(zone)
+		at, got, err := ParseSortKey(SortKeyValue(ts, id))
+		require.NoError(t, err)
+		assert.True(t, ts.Equal(at), "%v != %v", ts, at)
+		assert.Equal(t, id, got)
+	})
+}
+
+func FuzzParseSortKey(f *testing.F) {
+	for _, sk := range []string{"2024-01-02T03:04:05.0000006Z#f1", "2024-01-02T03:04:05Z#a#b", "#", "x#y", ""} {
+		f.Add(sk)
+	}
+	f.Fuzz(func(t *testing.T, sk string) {
+		at, id, err := ParseSortKey(sk)
+		if err != nil {
+			return
+		}
+		assert.NotEmpty(t, id)
+		assert.True(t, strings.HasSuffix(sk, "#"+id))
+		gotAt, gotID, err := ParseSortKey(SortKeyValue(at, id))
+		require.NoError(t, err)
+		assert.True(t, at.Equal(gotAt))
+		assert.Equal(t, id, gotID)
+	})
+}
+
+func FuzzFieldKey(f *testing.F) {
+	f.Add("u1", "u1/orders", "r1")
+	f.Add("u1", "a#b", "c")
+	f.Add("u1", "a", "b#c")
+	f.Add("u%1", "%23", "#%")
+	f.Fuzz(func(t *testing.T, userID, namespace, field string) {
+		assert.Equal(t, []string{userID, namespace, field}, splitKey(FieldKeyValue(userID, namespace, field)))
+		assert.Equal(t, []string{userID, namespace}, splitKey(NamespaceValue(userID, namespace)))
+	})
+}
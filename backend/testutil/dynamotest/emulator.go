@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 	"testing"
 	"time"
 
@@ -23,7 +24,7 @@ const (
 
 // EmulatorConfig holds configuration for connecting to a DynamoDB emulator
 type EmulatorConfig struct {
-	// Endpoint is the URL of the DynamoDB emulator (default: http://localhost:8000)
+	// Endpoint is the URL of the DynamoDB emulator (default: $DYNAMODB_ENDPOINT_URL or http://localhost:8000)
 	Endpoint string
 
 	// Region is the AWS region to use (default: us-west-2)
@@ -43,10 +44,15 @@ type EmulatorClient struct {
 	clientFactory NewClientFunc
 }
 
-// NewEmulatorConfig creates a default config for the DynamoDB emulator
+// NewEmulatorConfig creates a default config for the DynamoDB emulator, at
+// DYNAMODB_ENDPOINT_URL when it is set
 func NewEmulatorConfig() *EmulatorConfig {
+	endpoint := os.Getenv("DYNAMODB_ENDPOINT_URL")
+	if endpoint == "" {
+		endpoint = DefaultEmulatorEndpoint
+	}
 	return &EmulatorConfig{
-		Endpoint:        DefaultEmulatorEndpoint,
+		Endpoint:        endpoint,
 		Region:          DefaultTestRegion,
 		TableNamePrefix: "test-",
 	}
@@ -0,0 +1,304 @@
+package dynamotest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	// DefaultEmulatorImage is the docker image StartEmulator runs
+	DefaultEmulatorImage = "amazon/dynamodb-local:latest"
+
+	// DefaultEmulatorURL is where StartEmulator downloads DynamoDB Local
+	// from when it runs it with java
+	DefaultEmulatorURL = "https://d1ni2b6xgvw0s0.cloudfront.net/v2.x/dynamodb_local_latest.tar.gz"
+
+	// startTimeout bounds starting the emulator, including pulling its
+	// image or downloading it
+	startTimeout = 5 * time.Minute
+	// readyTimeout bounds waiting for a started emulator to answer
+	readyTimeout = 30 * time.Second
+)
+
+// Launchers of DynamoDB Local, the values of DYNAMODB_LOCAL
+const (
+	// LaunchDocker runs the DefaultEmulatorImage container
+	LaunchDocker = "docker"
+	// LaunchJava runs DynamoDBLocal.jar from DYNAMODB_LOCAL_DIR,
+	// downloading it there first when it is missing
+	LaunchJava = "java"
+	// LaunchAuto uses docker when it is installed and java otherwise
+	LaunchAuto = "auto"
+)
+
+// Emulator is a DynamoDB Local started for a test run
+type Emulator struct {
+	// Endpoint is the URL it listens on
+	Endpoint string
+
+	stop func() error
+}
+
+// StartEmulator starts DynamoDB Local in memory on a free local port with a
+// launcher, and returns once it answers requests
+func StartEmulator(ctx context.Context, launcher string) (*Emulator, error) {
+	if launcher == LaunchAuto {
+		launcher = LaunchJava
+		if _, err := exec.LookPath("docker"); err == nil {
+			launcher = LaunchDocker
+		}
+	}
+
+	var em *Emulator
+	var err error
+	switch launcher {
+	case LaunchDocker:
+		em, err = startDocker(ctx)
+	case LaunchJava:
+		em, err = startJava(ctx)
+	default:
+		return nil, fmt.Errorf("unknown DynamoDB Local launcher %q: use %s, %s or %s", launcher, LaunchDocker, LaunchJava, LaunchAuto)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config := NewEmulatorConfig()
+	config.Endpoint = em.Endpoint
+	deadline := time.Now().Add(readyTimeout)
+	for !IsEmulatorRunning(config) {
+		if time.Now().After(deadline) || ctx.Err() != nil {
+			em.Stop()
+			return nil, fmt.Errorf("DynamoDB Local did not answer at %s within %s", em.Endpoint, readyTimeout)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return em, nil
+}
+
+// Stop stops the emulator, losing its tables
+func (e *Emulator) Stop() error {
+	return e.stop()
+}
+
+func startDocker(ctx context.Context) (*Emulator, error) {
+	image := os.Getenv("DYNAMODB_LOCAL_IMAGE")
+	if image == "" {
+		image = DefaultEmulatorImage
+	}
+	out, err := exec.CommandContext(ctx, "docker", "run", "--detach", "--rm", "--publish", "127.0.0.1::8000",
+		image, "-jar", "DynamoDBLocal.jar", "-inMemory", "-sharedDb").Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker run %s: %w", image, commandError(err))
+	}
+	id := strings.TrimSpace(string(out))
+	stop := func() error {
+		if err := exec.Command("docker", "rm", "--force", id).Run(); err != nil {
+			return fmt.Errorf("docker rm %s: %w", id, commandError(err))
+		}
+		return nil
+	}
+
+	// The port docker picked, as "127.0.0.1:49153"
+	out, err = exec.CommandContext(ctx, "docker", "port", id, "8000/tcp").Output()
+	if err != nil {
+		stop()
+		return nil, fmt.Errorf("docker port %s: %w", id, commandError(err))
+	}
+	address := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	return &Emulator{Endpoint: "http://" + address, stop: stop}, nil
+}
+
+func startJava(ctx context.Context) (*Emulator, error) {
+	dir := os.Getenv("DYNAMODB_LOCAL_DIR")
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("set DYNAMODB_LOCAL_DIR: %w", err)
+		}
+		dir = filepath.Join(cache, "notably", "dynamodb-local")
+	}
+	jar := filepath.Join(dir, "DynamoDBLocal.jar")
+	if _, err := os.Stat(jar); errors.Is(err, os.ErrNotExist) {
+		if err := downloadEmulator(ctx, DefaultEmulatorURL, dir); err != nil {
+			return nil, fmt.Errorf("download DynamoDB Local to %s: %w", dir, err)
+		}
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("java", "-Djava.library.path="+filepath.Join(dir, "DynamoDBLocal_lib"),
+		"-jar", jar, "-inMemory", "-sharedDb", "-port", fmt.Sprint(port))
+	cmd.Dir = dir
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("java -jar %s: %w", jar, err)
+	}
+	return &Emulator{
+		Endpoint: fmt.Sprintf("http://127.0.0.1:%d", port),
+		stop: func() error {
+			if err := cmd.Process.Kill(); err != nil {
+				return err
+			}
+			cmd.Wait()
+			return nil
+		},
+	}, nil
+}
+
+// downloadEmulator unpacks the DynamoDB Local archive at url into dir
+func downloadEmulator(ctx context.Context, url, dir string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// Unpack next to dir and rename it into place, so an interrupted
+	// download is not mistaken for a complete one
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".dynamodb-local-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(tmp, hdr.Name)
+		if !strings.HasPrefix(path, tmp+string(filepath.Separator)) {
+			return fmt.Errorf("archive entry %q is outside the archive", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode)&0o755|0o644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "DynamoDBLocal.jar")); err != nil {
+		return fmt.Errorf("the archive has no DynamoDBLocal.jar")
+	}
+	os.RemoveAll(dir)
+	return os.Rename(tmp, dir)
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// commandError adds a failed command's standard error to its error
+func commandError(err error) error {
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && len(bytes.TrimSpace(exit.Stderr)) > 0 {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(exit.Stderr))
+	}
+	return err
+}
+
+// Main runs a package's tests, for its TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(dynamotest.Main(m))
+//	}
+//
+// When DYNAMODB_LOCAL names a launcher it starts DynamoDB Local for the run,
+// unless an emulator already answers at the configured endpoint, and stops
+// it afterwards. The tests find the emulator through
+// DYNAMODB_ENDPOINT_URL, and DYNAMODB_INTEGRATION_TEST is set so the
+// integration suites that check it run too. Without DYNAMODB_LOCAL, or in
+// -short mode, tests that need an emulator skip as before.
+func Main(m *testing.M) int {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	launcher := os.Getenv("DYNAMODB_LOCAL")
+	if launcher == "" || testing.Short() {
+		return m.Run()
+	}
+
+	endpoint := NewEmulatorConfig().Endpoint
+	if !IsEmulatorRunning(nil) {
+		ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+		em, err := StartEmulator(ctx, launcher)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dynamotest: starting DynamoDB Local: %v\n", err)
+			return 1
+		}
+		defer func() {
+			if err := em.Stop(); err != nil {
+				fmt.Fprintf(os.Stderr, "dynamotest: stopping DynamoDB Local: %v\n", err)
+			}
+		}()
+		endpoint = em.Endpoint
+	}
+
+	os.Setenv("DYNAMODB_ENDPOINT_URL", endpoint)
+	os.Setenv("DYNAMODB_INTEGRATION_TEST", "true")
+	// The SDK needs a region and credentials, which DynamoDB Local ignores
+	for name, value := range map[string]string{
+		"AWS_REGION":            DefaultTestRegion,
+		"AWS_ACCESS_KEY_ID":     "dummy",
+		"AWS_SECRET_ACCESS_KEY": "dummy",
+	} {
+		if os.Getenv(name) == "" {
+			os.Setenv(name, value)
+		}
+	}
+	return m.Run()
+}
@@ -0,0 +1,63 @@
+package dynamotest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func archiveOf(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, body := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(body)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestDownloadEmulator(t *testing.T) {
+	ctx := context.Background()
+	archive := archiveOf(t, map[string]string{
+		"DynamoDBLocal.jar":                 "jar",
+		"DynamoDBLocal_lib/sqlite4java.jar": "lib",
+		"third_party_licenses/LICENSE.txt":  "license",
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer srv.Close()
+
+	dir := filepath.Join(t.TempDir(), "cache", "dynamodb-local")
+	require.NoError(t, downloadEmulator(ctx, srv.URL, dir))
+	jar, err := os.ReadFile(filepath.Join(dir, "DynamoDBLocal.jar"))
+	require.NoError(t, err)
+	assert.Equal(t, "jar", string(jar))
+	assert.FileExists(t, filepath.Join(dir, "DynamoDBLocal_lib", "sqlite4java.jar"))
+
+	// Archives without the jar or with entries escaping them leave dir alone
+	archive = archiveOf(t, map[string]string{"README.txt": "readme"})
+	assert.ErrorContains(t, downloadEmulator(ctx, srv.URL, dir), "no DynamoDBLocal.jar")
+	archive = archiveOf(t, map[string]string{"../evil": "x"})
+	assert.ErrorContains(t, downloadEmulator(ctx, srv.URL, dir), "outside the archive")
+	assert.FileExists(t, filepath.Join(dir, "DynamoDBLocal.jar"))
+	assert.NoFileExists(t, filepath.Join(filepath.Dir(dir), "evil"))
+}
+
+func TestStartEmulatorUnknownLauncher(t *testing.T) {
+	_, err := StartEmulator(context.Background(), "podman")
+	assert.ErrorContains(t, err, `unknown DynamoDB Local launcher "podman"`)
+}
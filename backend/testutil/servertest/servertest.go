@@ -0,0 +1,237 @@
+// Package servertest runs a notably server in memory for tests of its HTTP
+// API. The server keeps facts in db.MemoryStore and users in
+// auth.InMemoryUserStore, so tests need no DynamoDB emulator and each one
+// gets a server of its own:
+//
+//	func TestNotes(t *testing.T) {
+//		srv := servertest.NewTestServer(t)
+//		alice := srv.RegisterUser("alice")
+//		alice.CreateTable("notes", servertest.Column("title", "string"))
+//		alice.Post("/v1/tables/notes/rows", `{"id": "n1", "values": {"title": "hi"}}`).AssertStatus(http.StatusCreated)
+//
+//		var row struct{ Values map[string]interface{} }
+//		alice.Get("/v1/tables/notes/rows/n1").AssertStatus(http.StatusOK).JSON(&row)
+//	}
+//
+// Requests are served in-process by the server's full handler chain, with
+// its middleware, routing and authentication.
+package servertest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/elibdev/notably/db"
+	"github.com/elibdev/notably/pkg/logging"
+	"github.com/elibdev/notably/pkg/server"
+)
+
+// Password is the password of users registered with RegisterUser
+const Password = "password"
+
+// Server is a notably server serving requests in memory
+type Server struct {
+	// Server is the server under test
+	*server.Server
+	// Config is the configuration it was created with
+	Config server.Config
+
+	t       testing.TB
+	handler http.Handler
+}
+
+// NewTestServer returns a server keeping everything in memory, stopped
+// when the test ends. The configure functions may change its configuration
+// before it is created; it always uses the memory store.
+func NewTestServer(t testing.TB, configure ...func(*server.Config)) *Server {
+	t.Helper()
+	config := server.DefaultConfig()
+	config.TableName = "notably-test"
+	config.Logger = logging.Discard()
+	// The cheapest hashes keep registering users fast
+	config.BcryptCost = bcrypt.MinCost
+	for _, fn := range configure {
+		fn(&config)
+	}
+	config.InMemory = true
+
+	srv, err := server.NewServer(config)
+	if err != nil {
+		t.Fatalf("servertest: creating server: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Stop(ctx); err != nil {
+			t.Errorf("servertest: stopping server: %v", err)
+		}
+	})
+	return &Server{Server: srv, Config: config, t: t, handler: srv.Handler()}
+}
+
+// Do sends a request without credentials. body is sent as is when it is a
+// string or []byte and encoded as JSON otherwise; nil sends no body.
+func (s *Server) Do(method, path string, body interface{}) *Response {
+	s.t.Helper()
+	return s.do(method, path, "", body)
+}
+
+func (s *Server) do(method, path, apiKey string, body interface{}) *Response {
+	s.t.Helper()
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		r = strings.NewReader(b)
+	case []byte:
+		r = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			s.t.Fatalf("servertest: encoding request body: %v", err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if r != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	return &Response{ResponseRecorder: rec, t: s.t, request: method + " " + path}
+}
+
+// User is a registered user, whose requests carry its API key
+type User struct {
+	ID       string
+	Username string
+	Email    string
+	Password string
+	APIKey   string
+
+	s *Server
+}
+
+// RegisterUser registers a user through the API, with the email address
+// <username>@example.com and Password
+func (s *Server) RegisterUser(username string) *User {
+	s.t.Helper()
+	u := &User{Username: username, Email: username + "@example.com", Password: Password, s: s}
+	var account struct {
+		ID     string `json:"id"`
+		APIKey string `json:"apiKey"`
+	}
+	s.Do(http.MethodPost, "/v1/auth/register", map[string]string{
+		"username": u.Username,
+		"email":    u.Email,
+		"password": u.Password,
+	}).AssertStatus(http.StatusCreated).JSON(&account)
+	if account.APIKey == "" {
+		s.t.Fatalf("servertest: registering %s returned no API key; does the server require verified email?", username)
+	}
+	u.ID, u.APIKey = account.ID, account.APIKey
+	return u
+}
+
+// Do sends a request as the user, with a body as for Server.Do
+func (u *User) Do(method, path string, body interface{}) *Response {
+	u.s.t.Helper()
+	return u.s.do(method, path, u.APIKey, body)
+}
+
+// Get sends a GET request as the user
+func (u *User) Get(path string) *Response {
+	u.s.t.Helper()
+	return u.Do(http.MethodGet, path, nil)
+}
+
+// Post sends a POST request as the user
+func (u *User) Post(path string, body interface{}) *Response {
+	u.s.t.Helper()
+	return u.Do(http.MethodPost, path, body)
+}
+
+// Put sends a PUT request as the user
+func (u *User) Put(path string, body interface{}) *Response {
+	u.s.t.Helper()
+	return u.Do(http.MethodPut, path, body)
+}
+
+// Delete sends a DELETE request as the user
+func (u *User) Delete(path string) *Response {
+	u.s.t.Helper()
+	return u.Do(http.MethodDelete, path, nil)
+}
+
+// Column returns the definition of a column of a data type
+func Column(name, dataType string) db.ColumnDefinition {
+	return db.ColumnDefinition{Name: name, DataType: dataType}
+}
+
+// CreateTable creates a table, failing the test unless it is created
+func (u *User) CreateTable(name string, columns ...db.ColumnDefinition) {
+	u.s.t.Helper()
+	if columns == nil {
+		columns = []db.ColumnDefinition{}
+	}
+	u.Post("/v1/tables", map[string]interface{}{"name": name, "columns": columns}).AssertStatus(http.StatusCreated)
+}
+
+// CreateRow creates a row of a table, failing the test unless it is created
+func (u *User) CreateRow(table, id string, values map[string]interface{}) {
+	u.s.t.Helper()
+	u.Post("/v1/tables/"+table+"/rows", map[string]interface{}{"id": id, "values": values}).AssertStatus(http.StatusCreated)
+}
+
+// Response is the response to a request, with assertions that fail the test
+type Response struct {
+	*httptest.ResponseRecorder
+
+	t       testing.TB
+	request string
+}
+
+// AssertStatus fails the test unless the response has the status code
+func (r *Response) AssertStatus(code int) *Response {
+	r.t.Helper()
+	if r.Code != code {
+		r.t.Fatalf("servertest: %s: got status %d, want %d: %s", r.request, r.Code, code, strings.TrimSpace(r.Body.String()))
+	}
+	return r
+}
+
+// AssertError fails the test unless the response is an error of the status
+// code whose message contains message
+func (r *Response) AssertError(code int, message string) *Response {
+	r.t.Helper()
+	r.AssertStatus(code)
+	var body struct {
+		Error string `json:"error"`
+	}
+	r.JSON(&body)
+	if !strings.Contains(body.Error, message) {
+		r.t.Fatalf("servertest: %s: error %q does not contain %q", r.request, body.Error, message)
+	}
+	return r
+}
+
+// JSON decodes the body into v, failing the test if it is not JSON
+func (r *Response) JSON(v interface{}) *Response {
+	r.t.Helper()
+	if err := json.Unmarshal(r.Body.Bytes(), v); err != nil {
+		r.t.Fatalf("servertest: %s: decoding body %.200q: %v", r.request, r.Body.String(), err)
+	}
+	return r
+}
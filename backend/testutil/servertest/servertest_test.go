@@ -0,0 +1,48 @@
+package servertest_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elibdev/notably/pkg/server"
+	"github.com/elibdev/notably/testutil/servertest"
+)
+
+func TestServer(t *testing.T) {
+	srv := servertest.NewTestServer(t)
+	alice := srv.RegisterUser("alice")
+	assert.NotEmpty(t, alice.ID)
+
+	alice.CreateTable("notes", servertest.Column("title", "string"))
+	alice.CreateRow("notes", "n1", map[string]interface{}{"title": "hello"})
+	alice.Put("/v1/tables/notes/rows/n1", map[string]interface{}{"values": map[string]interface{}{"title": "renamed"}}).AssertStatus(http.StatusOK)
+
+	var row struct {
+		ID     string                 `json:"id"`
+		Values map[string]interface{} `json:"values"`
+	}
+	alice.Get("/v1/tables/notes/rows/n1").AssertStatus(http.StatusOK).JSON(&row)
+	assert.Equal(t, "renamed", row.Values["title"])
+
+	alice.Post("/v1/tables/notes/rows", `{"id": "n2", "values": {"title": 1}}`).AssertStatus(http.StatusBadRequest)
+	alice.Get("/v1/tables/missing/rows").AssertError(http.StatusNotFound, "not found")
+	srv.Do(http.MethodGet, "/v1/tables", nil).AssertStatus(http.StatusUnauthorized)
+
+	// Users only see their own tables
+	var tables struct {
+		Tables []struct {
+			Name string `json:"name"`
+		} `json:"tables"`
+	}
+	srv.RegisterUser("bob").Get("/v1/tables").AssertStatus(http.StatusOK).JSON(&tables)
+	assert.Empty(t, tables.Tables)
+	alice.Delete("/v1/tables/notes/rows/n1").AssertStatus(http.StatusNoContent)
+
+	// Servers can be configured
+	limited := servertest.NewTestServer(t, func(c *server.Config) { c.UI = true })
+	assert.True(t, limited.Config.UI)
+	assert.True(t, limited.Config.InMemory)
+	limited.Do(http.MethodGet, "/ui/login", nil).AssertStatus(http.StatusOK)
+}
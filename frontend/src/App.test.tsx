@@ -28,6 +28,13 @@ jest.mock('./api', () => {
           { name: 'newField', dataType: 'string' }
         ]
       }),
+      joinPresence: jest.fn().mockReturnValue({
+        session: jest.fn(),
+        edit: jest.fn(),
+        lock: jest.fn().mockResolvedValue({ locked: false, lock: { row: 'row1', session: 's1', userId: 'u1', expiresAt: new Date().toISOString() } }),
+        unlock: jest.fn(),
+        close: jest.fn(),
+      }),
       listRows: jest.fn().mockResolvedValue({ 
         rows: [
           {
@@ -5,6 +5,8 @@ import {
   type RowData,
   type RowEvent,
   type ColumnDefinition,
+  type PresenceSession,
+  type PresenceUpdate,
 } from "./api";
 import { BrowserRouter, Routes, Route, useParams, useNavigate, Navigate } from "react-router-dom";
 
@@ -639,6 +641,17 @@ function TableView({ table, client, onBack, tableInfo }: TableViewProps) {
     end: new Date(),
   });
   const [historyEvents, setHistoryEvents] = useState<RowEvent[]>([]);
+  const [presence, setPresence] = useState<PresenceUpdate>({ members: [], locks: [] });
+  const [presenceSession, setPresenceSession] = useState<PresenceSession | null>(null);
+
+  // Show who else has the table open, and lock the rows being edited
+  useEffect(() => {
+    const session = client.joinPresence(table, setPresence);
+    setPresenceSession(session);
+    return () => session.close();
+  }, [client, table]);
+
+  const others = presence.members.filter((m) => m.session !== presenceSession?.session());
 
   // Memoize columns to prevent unnecessary re-renders
   const columns = useMemo(() => {
@@ -818,11 +831,19 @@ function TableView({ table, client, onBack, tableInfo }: TableViewProps) {
     }
   };
 
+  const finishEditRow = () => {
+    if (presenceSession && currentEditingRow) {
+      presenceSession.unlock(currentEditingRow);
+      presenceSession.edit();
+    }
+    closeEditRowDrawer();
+  };
+
   const updateRow = async (id: string, values: Record<string, unknown>) => {
     setLoading(true);
     try {
       await client.updateRow(table, id, values);
-      closeEditRowDrawer();
+      finishEditRow();
       loadRows();
       notifications.show({
         title: "Row updated",
@@ -866,15 +887,31 @@ function TableView({ table, client, onBack, tableInfo }: TableViewProps) {
     }
   };
 
-  const startEditRow = (id: string) => {
+  const startEditRow = async (id: string) => {
     const row = rows.find((r) => r.id === id);
     if (row) {
+      if (presenceSession) {
+        try {
+          const { locked, lock } = await presenceSession.lock(id);
+          if (locked) {
+            notifications.show({
+              title: "Row is being edited",
+              message: `${lock.name || "Another session"} is editing row ${id}`,
+              color: "yellow",
+            });
+          }
+        } catch (error: unknown) {
+          console.error("Failed to lock row:", getErrorMessage(error));
+        }
+        presenceSession.edit(id);
+      }
       setNewRowValues(row.values);
       setCurrentEditingRow(id);
       openEditRowDrawer();
     }
   };
 
+
   const renderInputForType = (
     columnName: string,
     dataType: string,
@@ -966,6 +1003,13 @@ function TableView({ table, client, onBack, tableInfo }: TableViewProps) {
           <Title order={3}>{table}</Title>
         </Group>
         <Group>
+          {others.length > 0 && (
+            <Tooltip label={others.map((m) => (m.state === "editing" && m.row ? `${m.name} (editing ${m.row})` : m.name)).join(", ")}>
+              <Badge size="lg" color="grape">
+                {others.length} also here
+              </Badge>
+            </Tooltip>
+          )}
           <Badge size="lg">{rows.length} rows</Badge>
           <Button onClick={openNewRowDrawer} lefticon={<IconPlus size={16} />}>
             Add Row
@@ -1171,12 +1215,22 @@ function TableView({ table, client, onBack, tableInfo }: TableViewProps) {
                           <Text fw={500}>{event.id}</Text>
                           <Badge>{new Date(event.timestamp).toLocaleString()}</Badge>
                           <Text c="dimmed" size="sm">
-                            {event.values ? "Update" : "Delete"}
+                            {event.type === "deleted" ? "Delete" : event.type === "created" ? "Create" : "Update"}
                           </Text>
+                          {event.apiKeyId && (
+                            <Text c="dimmed" size="xs">
+                              key {event.apiKeyId}
+                            </Text>
+                          )}
+                          {event.clientDated && (
+                            <Text c="dimmed" size="xs">
+                              client time
+                            </Text>
+                          )}
                         </Group>
                       </Accordion.Control>
                       <Accordion.Panel>
-                        {event.values ? (
+                        {event.type !== "deleted" ? (
                           <JsonInput
                             value={JSON.stringify(event.values, null, 2)}
                             readOnly
@@ -1280,7 +1334,7 @@ function TableView({ table, client, onBack, tableInfo }: TableViewProps) {
       {/* Edit Row Drawer */}
       <Drawer
         opened={editRowDrawerOpen}
-        onClose={closeEditRowDrawer}
+        onClose={finishEditRow}
         title={`Edit Row: ${currentEditingRow}`}
         position="right"
         size="lg"
@@ -1316,7 +1370,7 @@ function TableView({ table, client, onBack, tableInfo }: TableViewProps) {
           </div>
 
           <Group position="right" mt="xl">
-            <Button variant="subtle" onClick={closeEditRowDrawer}>
+            <Button variant="subtle" onClick={finishEditRow}>
               Cancel
             </Button>
             <Button onClick={() => updateRow(currentEditingRow, newRowValues)} loading={loading}>
@@ -18,6 +18,7 @@ export interface TableInfo {
 export interface RowData {
   id: string;
   timestamp: string;
+  validTime?: string;
   values: Record<string, unknown>;
 }
 
@@ -25,6 +26,45 @@ export interface RowEvent {
   id: string;
   timestamp: string;
   values: Record<string, unknown> | null;
+  type: "created" | "updated" | "deleted";
+  actorUserId?: string;
+  apiKeyId?: string;
+  validTime?: string;
+  clientDated?: boolean;
+}
+
+export interface PresenceMember {
+  session: string;
+  userId: string;
+  apiKeyId?: string;
+  name?: string;
+  state: "viewing" | "editing";
+  row?: string;
+  since: string;
+  expiresAt: string;
+}
+
+export interface RowLock {
+  row: string;
+  session: string;
+  userId: string;
+  name?: string;
+  expiresAt: string;
+}
+
+export interface PresenceUpdate {
+  members: PresenceMember[];
+  locks: RowLock[];
+}
+
+// A table's realtime session: presence updates arrive through the callback
+// given to joinPresence, and locks are advisory
+export interface PresenceSession {
+  session(): string | undefined;
+  edit(row?: string): void;
+  lock(row: string): Promise<{ locked: boolean; lock: RowLock }>;
+  unlock(row: string): void;
+  close(): void;
 }
 
 export interface RegisterResponse {
@@ -184,10 +224,17 @@ export class ApiClient {
     }
   }
 
-  async snapshot(table: string, at?: string): Promise<{ rows: RowData[] }> {
-    let path = `/api/tables/${encodeURIComponent(table)}/snapshot`;
+  async snapshot(table: string, at?: string, validAt?: string): Promise<{ rows: RowData[] }> {
+    const params = new URLSearchParams();
     if (at) {
-      path += `?at=${encodeURIComponent(at)}`;
+      params.set("asOf", at);
+    }
+    if (validAt) {
+      params.set("validAt", validAt);
+    }
+    let path = `/api/tables/${encodeURIComponent(table)}/snapshot`;
+    if (params.toString()) {
+      path += `?${params.toString()}`;
     }
     const res = await fetch(path, { headers: this.headers() });
     return handleResponse(res);
@@ -201,4 +248,61 @@ export class ApiClient {
     );
     return handleResponse(res);
   }
+
+  // Joins a table's presence over the realtime WebSocket. Browsers cannot
+  // set headers on WebSockets, so the key is offered as a subprotocol.
+  joinPresence(table: string, onUpdate: (update: PresenceUpdate) => void): PresenceSession {
+    const scheme = window.location.protocol === "https:" ? "wss:" : "ws:";
+    const ws = new WebSocket(`${scheme}//${window.location.host}/api/realtime`, [
+      "notably",
+      `bearer.${this.apiKey}`,
+    ]);
+    let session: string | undefined;
+    let nextRef = 0;
+    const pending = new Map<string, (reply: { type: string; lock?: RowLock; error?: string }) => void>();
+    const send = (msg: Record<string, unknown>) => {
+      if (ws.readyState === WebSocket.OPEN) {
+        ws.send(JSON.stringify(msg));
+      }
+    };
+    const request = (msg: Record<string, unknown>) =>
+      new Promise<{ type: string; lock?: RowLock; error?: string }>((resolve) => {
+        if (ws.readyState !== WebSocket.OPEN) {
+          resolve({ type: "error", error: "Realtime connection is not open" });
+          return;
+        }
+        const ref = String(++nextRef);
+        pending.set(ref, resolve);
+        send({ ...msg, ref });
+      });
+
+    ws.onopen = () => send({ type: "join", table });
+    ws.onmessage = (event) => {
+      const msg = JSON.parse(event.data);
+      if (msg.type === "hello") {
+        session = msg.session;
+      } else if (msg.type === "presence") {
+        onUpdate({ members: msg.members, locks: msg.locks });
+      } else if (msg.ref && pending.has(msg.ref)) {
+        pending.get(msg.ref)?.(msg);
+        pending.delete(msg.ref);
+      } else if (msg.type === "error") {
+        console.error("Realtime error:", msg.error);
+      }
+    };
+
+    return {
+      session: () => session,
+      edit: (row?: string) => send({ type: "join", table, state: row ? "editing" : "viewing", row }),
+      lock: async (row: string) => {
+        const reply = await request({ type: "lock", table, row });
+        if (reply.type === "error" || !reply.lock) {
+          throw new Error(reply.error || "Failed to lock row");
+        }
+        return { locked: reply.type === "locked", lock: reply.lock };
+      },
+      unlock: (row: string) => send({ type: "unlock", table, row }),
+      close: () => ws.close(),
+    };
+  }
 }
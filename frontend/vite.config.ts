@@ -9,7 +9,8 @@ export default defineConfig({
       "/api": {
         target: "http://localhost:8080",
         changeOrigin: true,
-        rewrite: (path) => path.replace(/^\/api/, ""),
+        ws: true,
+        rewrite: (path) => path.replace(/^\/api/, "/v1"),
       },
     },
   },